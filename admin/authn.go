@@ -0,0 +1,152 @@
+package admin
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Methods of authentication recorded on principals
+const (
+	MethodToken = "token"
+	MethodMTLS  = "mtls"
+)
+
+// Prefixes of the certificate identities matched by MTLSAuthenticator, keeping each kind in its own namespace
+const (
+	IdentityURI = "uri:"
+	IdentityDNS = "dns:"
+	IdentityCN  = "cn:"
+)
+
+// Principal is an authenticated caller of the admin surfaces
+type Principal struct {
+	Name   string
+	Roles  []string
+	Method string
+}
+
+// Authenticator identifies the caller of an admin request
+// ErrNoCredentials means the request carries none of the credentials the authenticator handles, so another
+// authenticator may try. Any other error rejects the request
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// AuthenticatorFunc adapts a function to Authenticator
+type AuthenticatorFunc func(r *http.Request) (*Principal, error)
+
+// Authenticate calls f
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (*Principal, error) {
+	return f(r)
+}
+
+// Chain tries authenticators in order, the first one finding credentials decides
+func Chain(authenticators ...Authenticator) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (*Principal, error) {
+		for _, a := range authenticators {
+			p, err := a.Authenticate(r)
+			if errors.Is(err, ErrNoCredentials) {
+				continue
+			}
+			return p, err
+		}
+		return nil, ErrNoCredentials
+	})
+}
+
+// Token is a bearer token and the principal it authenticates
+type Token struct {
+	Name  string
+	Roles []string
+	// Secret is the token itself, or leave it empty and set SHA256 so the configuration holds no usable secret
+	Secret string
+	SHA256 [sha256.Size]byte
+}
+
+// TokenAuthenticator authenticates requests by the bearer token in their Authorization header
+// Tokens are kept and compared as SHA-256 digests
+type TokenAuthenticator struct {
+	tokens map[[sha256.Size]byte]*Principal
+}
+
+// NewTokenAuthenticator creates an authenticator accepting tokens
+func NewTokenAuthenticator(tokens ...Token) *TokenAuthenticator {
+	a := &TokenAuthenticator{tokens: make(map[[sha256.Size]byte]*Principal, len(tokens))}
+	for _, t := range tokens {
+		digest := t.SHA256
+		if t.Secret != "" {
+			digest = sha256.Sum256([]byte(t.Secret))
+		}
+		a.tokens[digest] = &Principal{Name: t.Name, Roles: t.Roles, Method: MethodToken}
+	}
+	return a
+}
+
+// Authenticate looks up the bearer token of r
+func (a *TokenAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil, ErrNoCredentials
+	}
+	p, ok := a.tokens[sha256.Sum256([]byte(strings.TrimSpace(token)))]
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	return p, nil
+}
+
+// MTLSAuthenticator authenticates requests by their verified client certificate
+// A certificate is identified by its URI SANs, e.g. SPIFFE IDs, then its DNS SANs and finally its subject
+// common name. The first identity with an entry in Identities names the principal and gives its roles
+// Identities are prefixed by their kind, e.g. "uri:spiffe://example.org/ops", "dns:ops.example.org" or
+// "cn:dashboard", so a DNS SAN never matches an identity meant for a common name
+type MTLSAuthenticator struct {
+	Identities map[string][]string
+}
+
+// Authenticate maps the client certificate of r to a principal
+// Only chains verified by the TLS handshake count, see ClientTLSConfig
+func (a *MTLSAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, ErrNoCredentials
+	}
+	for _, identity := range certIdentities(r.TLS.VerifiedChains[0][0]) {
+		if roles, ok := a.Identities[identity]; ok {
+			return &Principal{Name: identity, Roles: roles, Method: MethodMTLS}, nil
+		}
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// certIdentities lists the identities of cert in the order they are matched
+func certIdentities(cert *x509.Certificate) []string {
+	var identities []string
+	for _, uri := range cert.URIs {
+		identities = append(identities, IdentityURI+uri.String())
+	}
+	for _, name := range cert.DNSNames {
+		identities = append(identities, IdentityDNS+name)
+	}
+	if cert.Subject.CommonName != "" {
+		identities = append(identities, IdentityCN+cert.Subject.CommonName)
+	}
+	return identities
+}
+
+// ClientTLSConfig returns a copy of base verifying the client certificates presented against clientCAs
+// Certificates stay optional so token clients can connect too, the Guard rejects requests without credentials
+func ClientTLSConfig(base *tls.Config, clientCAs *x509.CertPool) *tls.Config {
+	var config *tls.Config
+	if base != nil {
+		config = base.Clone()
+	} else {
+		config = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	config.ClientCAs = clientCAs
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	return config
+}
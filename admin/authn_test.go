@@ -0,0 +1,108 @@
+package admin
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenAuthenticator(t *testing.T) {
+	a := NewTokenAuthenticator(
+		Token{Name: "grafana", Roles: []string{RoleViewer}, Secret: "s3cret"},
+		Token{Name: "ops", Roles: []string{RoleOperator}, SHA256: sha256.Sum256([]byte("hashed"))},
+	)
+
+	r := httptest.NewRequest("GET", "/stats", nil)
+	_, err := a.Authenticate(r)
+	assert.ErrorIs(t, err, ErrNoCredentials)
+
+	r.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+	_, err = a.Authenticate(r)
+	assert.ErrorIs(t, err, ErrNoCredentials)
+
+	r.Header.Set("Authorization", "Bearer wrong")
+	_, err = a.Authenticate(r)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	r.Header.Set("Authorization", "Bearer s3cret")
+	p, err := a.Authenticate(r)
+	require.NoError(t, err)
+	assert.Equal(t, &Principal{Name: "grafana", Roles: []string{RoleViewer}, Method: MethodToken}, p)
+
+	r.Header.Set("Authorization", "bearer hashed")
+	p, err = a.Authenticate(r)
+	require.NoError(t, err)
+	assert.Equal(t, "ops", p.Name)
+}
+
+func TestMTLSAuthenticator(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.org/ops")
+	a := &MTLSAuthenticator{Identities: map[string][]string{
+		IdentityURI + spiffe.String(): {RoleOperator},
+		IdentityCN + "dashboard":      {RoleViewer},
+	}}
+
+	r := httptest.NewRequest("GET", "/stats", nil)
+	_, err := a.Authenticate(r)
+	assert.ErrorIs(t, err, ErrNoCredentials, "plain HTTP")
+
+	r.TLS = &tls.ConnectionState{}
+	_, err = a.Authenticate(r)
+	assert.ErrorIs(t, err, ErrNoCredentials, "no verified certificate")
+
+	r.TLS.VerifiedChains = [][]*x509.Certificate{{{URIs: []*url.URL{spiffe}, Subject: pkix.Name{CommonName: "dashboard"}}}}
+	p, err := a.Authenticate(r)
+	require.NoError(t, err)
+	assert.Equal(t, &Principal{Name: "uri:" + spiffe.String(), Roles: []string{RoleOperator}, Method: MethodMTLS}, p)
+
+	r.TLS.VerifiedChains = [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "dashboard"}}}}
+	p, err = a.Authenticate(r)
+	require.NoError(t, err)
+	assert.Equal(t, "cn:dashboard", p.Name)
+
+	r.TLS.VerifiedChains = [][]*x509.Certificate{{{DNSNames: []string{"stranger"}}}}
+	_, err = a.Authenticate(r)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	// A DNS SAN does not match an identity meant for a common name
+	r.TLS.VerifiedChains = [][]*x509.Certificate{{{DNSNames: []string{"dashboard"}}}}
+	_, err = a.Authenticate(r)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestChain(t *testing.T) {
+	a := Chain(&MTLSAuthenticator{}, NewTokenAuthenticator(Token{Name: "t", Secret: "x"}))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	_, err := a.Authenticate(r)
+	assert.ErrorIs(t, err, ErrNoCredentials)
+
+	r.Header.Set("Authorization", "Bearer x")
+	p, err := a.Authenticate(r)
+	require.NoError(t, err)
+	assert.Equal(t, MethodToken, p.Method)
+
+	// A certificate that is not recognized rejects the request instead of falling through to the token
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "x"}}}}}
+	_, err = a.Authenticate(r)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestClientTLSConfig(t *testing.T) {
+	pool := x509.NewCertPool()
+	base := &tls.Config{MinVersion: tls.VersionTLS13}
+	config := ClientTLSConfig(base, pool)
+	assert.Equal(t, tls.VerifyClientCertIfGiven, config.ClientAuth)
+	assert.Same(t, pool, config.ClientCAs)
+	assert.Equal(t, uint16(tls.VersionTLS13), config.MinVersion)
+	assert.Equal(t, tls.NoClientCert, base.ClientAuth, "base is not modified")
+
+	assert.Equal(t, uint16(tls.VersionTLS12), ClientTLSConfig(nil, pool).MinVersion)
+}
@@ -0,0 +1,11 @@
+package admin
+
+import "errors"
+
+var (
+	ErrNoCredentials      = errors.New("no admin credentials presented")
+	ErrInvalidCredentials = errors.New("invalid admin credentials")
+	ErrForbidden          = errors.New("admin permission denied")
+	ErrInvalidRole        = errors.New("invalid admin role")
+	ErrNoAuthenticator    = errors.New("admin guard needs an authenticator")
+)
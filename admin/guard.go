@@ -0,0 +1,127 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// DefaultRealm is announced in the WWW-Authenticate header of rejected requests
+const DefaultRealm = "ax-admin"
+
+// Decision records the outcome of guarding one admin request or call
+type Decision struct {
+	Principal  *Principal // nil when authentication failed
+	Permission Permission
+	Allowed    bool
+	// Method and Path describe HTTP requests, both are empty for Go callers
+	Method string
+	Path   string
+	Err    error
+}
+
+// GuardConfig configures a Guard
+type GuardConfig struct {
+	Authenticator Authenticator
+	// RBAC maps roles to permissions, the built-in roles when nil
+	RBAC *RBAC
+	// Realm is announced to unauthenticated clients, DefaultRealm when empty
+	Realm string
+	// OnDecision receives every decision, e.g. for an audit log
+	OnDecision func(Decision)
+}
+
+// Guard authenticates admin requests and checks the permissions they need
+type Guard struct {
+	config GuardConfig
+}
+
+// NewGuard creates a guard
+func NewGuard(config GuardConfig) (*Guard, error) {
+	if config.Authenticator == nil {
+		return nil, ErrNoAuthenticator
+	}
+	if config.RBAC == nil {
+		rbac, err := NewRBAC()
+		if err != nil {
+			return nil, err
+		}
+		config.RBAC = rbac
+	}
+	if config.Realm == "" {
+		config.Realm = DefaultRealm
+	}
+	return &Guard{config: config}, nil
+}
+
+// RBAC returns the role-based permissions of the guard
+func (g *Guard) RBAC() *RBAC {
+	return g.config.RBAC
+}
+
+// Require serves next only to callers holding perm, others get 401 Unauthorized when they could not be
+// authenticated and 403 Forbidden otherwise. next finds the principal with PrincipalFromContext
+// A nil guard returns next unchanged
+func (g *Guard) Require(perm Permission, next http.Handler) http.Handler {
+	if g == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := g.config.Authenticator.Authenticate(r)
+		if err == nil && p == nil {
+			err = ErrInvalidCredentials
+		}
+		if err == nil && !g.config.RBAC.Allowed(p, perm) {
+			err = ErrForbidden
+		}
+		g.decide(Decision{Principal: p, Permission: perm, Allowed: err == nil, Method: r.Method, Path: r.URL.Path, Err: err})
+
+		switch {
+		case err == nil:
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), p)))
+		case errors.Is(err, ErrForbidden):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+g.config.Realm+`"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		}
+	})
+}
+
+// Authorize checks perm for the principal of ctx, so admin operations called from Go share the checks of the
+// HTTP handlers. It returns ErrNoCredentials when ctx carries no principal and ErrForbidden when it lacks perm
+// A nil guard allows every call, for admin surfaces embedded without access control
+func (g *Guard) Authorize(ctx context.Context, perm Permission) error {
+	if g == nil {
+		return nil
+	}
+	p, ok := PrincipalFromContext(ctx)
+	var err error
+	switch {
+	case !ok:
+		err = ErrNoCredentials
+	case !g.config.RBAC.Allowed(p, perm):
+		err = ErrForbidden
+	}
+	g.decide(Decision{Principal: p, Permission: perm, Allowed: err == nil, Err: err})
+	return err
+}
+
+func (g *Guard) decide(d Decision) {
+	if g.config.OnDecision != nil {
+		g.config.OnDecision(d)
+	}
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying p
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the principal ctx carries
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
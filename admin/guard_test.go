@@ -0,0 +1,76 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGuardRequire(t *testing.T) {
+	var decisions []Decision
+	g, err := NewGuard(GuardConfig{
+		Authenticator: NewTokenAuthenticator(
+			Token{Name: "viewer", Roles: []string{RoleViewer}, Secret: "v"},
+			Token{Name: "operator", Roles: []string{RoleOperator}, Secret: "o"},
+		),
+		OnDecision: func(d Decision) { decisions = append(decisions, d) },
+	})
+	require.NoError(t, err)
+
+	handler := g.Require(PermissionClientsKick, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := PrincipalFromContext(r.Context())
+		require.True(t, ok)
+		_, _ = w.Write([]byte("kicked by " + p.Name))
+	}))
+	serve := func(token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/clients/kick", nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Bearer realm="ax-admin"`, w.Header().Get("WWW-Authenticate"))
+	assert.Equal(t, http.StatusUnauthorized, serve("wrong").Code)
+	assert.Equal(t, http.StatusForbidden, serve("v").Code)
+
+	w = serve("o")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "kicked by operator", w.Body.String())
+
+	require.Len(t, decisions, 4)
+	assert.ErrorIs(t, decisions[0].Err, ErrNoCredentials)
+	assert.ErrorIs(t, decisions[1].Err, ErrInvalidCredentials)
+	assert.Equal(t, "viewer", decisions[2].Principal.Name)
+	assert.ErrorIs(t, decisions[2].Err, ErrForbidden)
+	assert.Equal(t, Decision{
+		Principal:  &Principal{Name: "operator", Roles: []string{RoleOperator}, Method: MethodToken},
+		Permission: PermissionClientsKick,
+		Allowed:    true,
+		Method:     http.MethodPost,
+		Path:       "/clients/kick",
+	}, decisions[3])
+}
+
+func TestGuardAuthorize(t *testing.T) {
+	g, err := NewGuard(GuardConfig{Authenticator: Chain()})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	assert.ErrorIs(t, g.Authorize(ctx, PermissionMetricsRead), ErrNoCredentials)
+
+	ctx = WithPrincipal(ctx, &Principal{Name: "cli", Roles: []string{RoleViewer}})
+	assert.NoError(t, g.Authorize(ctx, PermissionMetricsRead))
+	assert.ErrorIs(t, g.Authorize(ctx, PermissionConfigWrite), ErrForbidden)
+
+	_, err = NewGuard(GuardConfig{})
+	assert.ErrorIs(t, err, ErrNoAuthenticator)
+}
@@ -0,0 +1,55 @@
+package admin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRBACDefaultRoles(t *testing.T) {
+	r, err := NewRBAC()
+	require.NoError(t, err)
+
+	viewer := &Principal{Roles: []string{RoleViewer}}
+	operator := &Principal{Roles: []string{RoleOperator}}
+	admin := &Principal{Roles: []string{"unknown", RoleAdmin}}
+
+	tests := []struct {
+		principal *Principal
+		perm      Permission
+		allowed   bool
+	}{
+		{viewer, PermissionMetricsRead, true},
+		{viewer, PermissionClientsKick, false},
+		{operator, PermissionClientsKick, true},
+		{operator, PermissionConfigWrite, false},
+		{admin, PermissionConfigWrite, true},
+		{admin, "custom:thing", true},
+		{&Principal{Roles: []string{"unknown"}}, PermissionMetricsRead, false},
+		{nil, PermissionMetricsRead, false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.allowed, r.Allowed(tt.principal, tt.perm), "%v %s", tt.principal, tt.perm)
+	}
+
+	both := &Principal{Roles: []string{RoleViewer, RoleOperator}}
+	assert.Equal(t, []Permission{PermissionClientsKick, PermissionMetricsRead}, r.Permissions(both))
+}
+
+func TestRBACCustomRoles(t *testing.T) {
+	r, err := NewRBAC(
+		Role{Name: "auditor", Permissions: []Permission{PermissionMetricsRead}},
+		Role{Name: RoleOperator, Permissions: []Permission{PermissionClientsKick}},
+	)
+	require.NoError(t, err)
+
+	assert.True(t, r.Allowed(&Principal{Roles: []string{"auditor"}}, PermissionMetricsRead))
+	assert.False(t, r.Allowed(&Principal{Roles: []string{RoleOperator}}, PermissionMetricsRead), "replaced built-in role")
+
+	require.NoError(t, r.SetRole(Role{Name: "auditor"}))
+	assert.False(t, r.Allowed(&Principal{Roles: []string{"auditor"}}, PermissionMetricsRead))
+
+	_, err = NewRBAC(Role{})
+	assert.ErrorIs(t, err, ErrInvalidRole)
+}
@@ -0,0 +1,413 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/axmq/ax/pkg/compress"
+	"github.com/axmq/ax/topic"
+)
+
+const (
+	CompressionGzip = compress.Gzip
+	CompressionZstd = compress.Zstd
+	CompressionNone = "none"
+)
+
+// objectFormat is the file extension and content type of objects compressed with a codec
+type objectFormat struct {
+	extension   string
+	contentType string
+}
+
+var _objectFormats = map[string]objectFormat{
+	compress.Gzip:    {extension: ".gz", contentType: "application/gzip"},
+	compress.Zstd:    {extension: ".zst", contentType: "application/zstd"},
+	compress.Snappy:  {extension: ".sz", contentType: "application/x-snappy-framed"},
+	compress.Deflate: {extension: ".deflate", contentType: "application/octet-stream"},
+}
+
+// Object is an archive file to upload
+type Object struct {
+	Key         string
+	Body        []byte
+	ContentType string
+}
+
+// Uploader stores archive files in object storage, uploading a key again must overwrite the object
+type Uploader interface {
+	Upload(ctx context.Context, object *Object) error
+}
+
+// Config configures message archival
+type Config struct {
+	// Filters select the archived topics, every message is archived when empty
+	Filters []string
+	// Prefix is prepended to object keys, give each broker node its own prefix
+	Prefix string
+	// Encoder writes batches, NDJSONEncoder when nil
+	Encoder Encoder
+	// Compression names a codec of the pkg/compress default registry or is CompressionNone, gzip when empty
+	Compression string
+	// MaxBatchMessages and MaxBatchBytes seal a batch once it reaches either size
+	MaxBatchMessages int
+	MaxBatchBytes    int
+	// FlushInterval seals the open batch and retries failed uploads
+	FlushInterval time.Duration
+	// SpoolDir keeps sealed batches on disk until they are uploaded, they are kept in memory when empty
+	SpoolDir string
+	// MaxSpoolBytes bounds the sealed batches waiting for upload. A batch that does not fit stays open until
+	// uploads make room, once it is full Add fails with ErrSpoolFull and the hook rejects archived publishes
+	MaxSpoolBytes int64
+	// UploadTimeout bounds each upload
+	UploadTimeout time.Duration
+}
+
+// DefaultConfig returns the default archive configuration
+func DefaultConfig() Config {
+	return Config{
+		Compression:      CompressionGzip,
+		MaxBatchMessages: 10000,
+		MaxBatchBytes:    8 << 20,
+		FlushInterval:    time.Minute,
+		MaxSpoolBytes:    1 << 30,
+		UploadTimeout:    30 * time.Second,
+	}
+}
+
+// Stats holds the counters of an archiver
+type Stats struct {
+	Archived     uint64
+	Dropped      uint64 // messages of batches that failed to encode
+	Rejected     uint64 // messages refused while the spool was full
+	Batches      uint64
+	Uploaded     uint64
+	Failed       uint64
+	Pending      int
+	PendingBytes int64
+}
+
+// Archiver batches messages into compressed files and uploads them to object storage
+// Archival is at least once: a sealed batch is spooled before upload and only removed once the upload
+// succeeds, and a batch is always uploaded under the same key so a retried upload overwrites its object.
+// Messages of the open batch are lost if the broker crashes, FlushInterval bounds that window
+type Archiver struct {
+	config   Config
+	codec    compress.Codec // nil without compression
+	uploader Uploader
+	spool    *spool
+	now      func() time.Time
+
+	mu         sync.Mutex
+	batch      []Record
+	batchBytes int
+	seq        uint64
+	spoolFull  bool // the last sealed batch did not fit in the spool
+	closed     bool
+
+	uploadMu sync.Mutex // a single upload pass at a time keeps objects in batch order
+
+	full    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+
+	archived atomic.Uint64
+	dropped  atomic.Uint64
+	rejected atomic.Uint64
+	batches  atomic.Uint64
+	uploaded atomic.Uint64
+	failed   atomic.Uint64
+}
+
+// NewArchiver creates an archiver uploading through uploader, it seals and uploads batches in the background
+// until Close. Batches spooled by a previous run are uploaded first
+func NewArchiver(config Config, uploader Uploader) (*Archiver, error) {
+	if uploader == nil {
+		return nil, fmt.Errorf("%w: uploader is nil", ErrInvalidConfig)
+	}
+	for _, filter := range config.Filters {
+		if err := topic.ValidateTopicFilter(filter); err != nil {
+			return nil, fmt.Errorf("%w: filter %q: %v", ErrInvalidConfig, filter, err)
+		}
+	}
+
+	defaults := DefaultConfig()
+	if config.Compression == "" {
+		config.Compression = defaults.Compression
+	}
+	var codec compress.Codec
+	if config.Compression != CompressionNone {
+		var err error
+		if codec, err = compress.Get(config.Compression); err != nil {
+			return nil, fmt.Errorf("%w: compression %q: %v", ErrInvalidConfig, config.Compression, err)
+		}
+	}
+	if config.Encoder == nil {
+		config.Encoder = NDJSONEncoder{}
+	}
+	if config.MaxBatchMessages <= 0 {
+		config.MaxBatchMessages = defaults.MaxBatchMessages
+	}
+	if config.MaxBatchBytes <= 0 {
+		config.MaxBatchBytes = defaults.MaxBatchBytes
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaults.FlushInterval
+	}
+	if config.MaxSpoolBytes <= 0 {
+		config.MaxSpoolBytes = defaults.MaxSpoolBytes
+	}
+	if config.UploadTimeout <= 0 {
+		config.UploadTimeout = defaults.UploadTimeout
+	}
+
+	sp, err := openSpool(config.SpoolDir, config.MaxSpoolBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Archiver{
+		config:   config,
+		codec:    codec,
+		uploader: uploader,
+		spool:    sp,
+		now:      time.Now,
+		full:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go a.run()
+	return a, nil
+}
+
+// Matches reports whether messages on topicName are archived
+func (a *Archiver) Matches(topicName string) bool {
+	if len(a.config.Filters) == 0 {
+		return true
+	}
+	for _, filter := range a.config.Filters {
+		if topic.MatchFilter(filter, topicName) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add appends a record to the open batch, a zero Time is set to the current time
+// It fails with ErrSpoolFull while the spool is full and the open batch has reached its limits
+func (a *Archiver) Add(rec Record) error {
+	if rec.Time.IsZero() {
+		rec.Time = a.now()
+	}
+
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return ErrArchiverClosed
+	}
+	if a.fullLocked() {
+		a.mu.Unlock()
+		a.rejected.Add(1)
+		return ErrSpoolFull
+	}
+	a.batch = append(a.batch, rec)
+	a.batchBytes += rec.size()
+	full := len(a.batch) >= a.config.MaxBatchMessages || a.batchBytes >= a.config.MaxBatchBytes
+	a.mu.Unlock()
+
+	a.archived.Add(1)
+	if full {
+		select {
+		case a.full <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Full reports whether the spool is full and the open batch has reached its limits, so Add refuses records
+func (a *Archiver) Full() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.fullLocked()
+}
+
+func (a *Archiver) fullLocked() bool {
+	return a.spoolFull && (len(a.batch) >= a.config.MaxBatchMessages || a.batchBytes >= a.config.MaxBatchBytes)
+}
+
+// Flush seals the open batch and uploads every spooled batch, it stops at the first failed upload
+// A batch that did not fit in the spool is sealed again once the uploads made room
+func (a *Archiver) Flush(ctx context.Context) error {
+	sealErr := a.seal()
+	if sealErr != nil && !errors.Is(sealErr, ErrSpoolFull) {
+		return sealErr
+	}
+	if err := a.upload(ctx); err != nil {
+		return err
+	}
+	if sealErr == nil {
+		return nil
+	}
+	if err := a.seal(); err != nil {
+		return err
+	}
+	return a.upload(ctx)
+}
+
+// Close stops the background uploads, then seals the open batch and makes a last upload attempt
+// Batches that fail to upload stay in the spool directory for the next run
+func (a *Archiver) Close() error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return nil
+	}
+	a.closed = true
+	a.mu.Unlock()
+
+	close(a.done)
+	<-a.stopped
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.config.UploadTimeout)
+	defer cancel()
+	return a.Flush(ctx)
+}
+
+func (a *Archiver) run() {
+	defer close(a.stopped)
+	ticker := time.NewTicker(a.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-ticker.C:
+		case <-a.full:
+		}
+		_ = a.Flush(context.Background())
+	}
+}
+
+// seal encodes the open batch and spools it
+func (a *Archiver) seal() error {
+	a.mu.Lock()
+	records := a.batch
+	if len(records) == 0 {
+		a.mu.Unlock()
+		return nil
+	}
+	a.batch, a.batchBytes = nil, 0
+	a.seq++
+	name := a.batchName(a.now(), a.seq)
+	a.mu.Unlock()
+
+	var buf bytes.Buffer
+	if err := a.config.Encoder.Encode(&buf, records); err != nil {
+		a.dropped.Add(uint64(len(records)))
+		return err
+	}
+	body := buf.Bytes()
+	if a.codec != nil {
+		compressed, err := a.codec.Encode(nil, body)
+		if err != nil {
+			a.dropped.Add(uint64(len(records)))
+			return err
+		}
+		body = compressed
+	}
+
+	err := a.spool.put(name, body)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.spoolFull = errors.Is(err, ErrSpoolFull)
+	if err != nil {
+		// Keep the records ahead of the ones added meanwhile, they are sealed again by the next flush
+		for _, rec := range records {
+			a.batchBytes += rec.size()
+		}
+		a.batch = append(records, a.batch...)
+		return err
+	}
+	a.batches.Add(1)
+	return nil
+}
+
+// batchName names batches so they sort in the order they were sealed
+func (a *Archiver) batchName(now time.Time, seq uint64) string {
+	ext := a.config.Encoder.Extension()
+	if a.codec != nil {
+		ext += a.format().extension
+	}
+	return fmt.Sprintf("%s-%06d.%s", now.UTC().Format("20060102T150405.000000000Z"), seq, ext)
+}
+
+// objectKey partitions objects by the day their batch was sealed, e.g. prefix/2026/10/16/name
+func (a *Archiver) objectKey(name string) string {
+	return a.config.Prefix + name[0:4] + "/" + name[4:6] + "/" + name[6:8] + "/" + name
+}
+
+func (a *Archiver) contentType() string {
+	if a.codec != nil {
+		return a.format().contentType
+	}
+	return a.config.Encoder.ContentType()
+}
+
+// format returns the object format of the codec, codecs registered by users get their name as extension
+func (a *Archiver) format() objectFormat {
+	if format, ok := _objectFormats[a.config.Compression]; ok {
+		return format
+	}
+	return objectFormat{extension: "." + a.config.Compression, contentType: "application/octet-stream"}
+}
+
+// upload uploads the spooled batches oldest first
+func (a *Archiver) upload(ctx context.Context) error {
+	a.uploadMu.Lock()
+	defer a.uploadMu.Unlock()
+
+	for _, name := range a.spool.names() {
+		body, err := a.spool.read(name)
+		if err != nil {
+			return err
+		}
+
+		object := &Object{Key: a.objectKey(name), Body: body, ContentType: a.contentType()}
+		uploadCtx, cancel := context.WithTimeout(ctx, a.config.UploadTimeout)
+		err = a.uploader.Upload(uploadCtx, object)
+		cancel()
+		if err != nil {
+			a.failed.Add(1)
+			return fmt.Errorf("%w: %s: %w", ErrUploadFailed, object.Key, err)
+		}
+
+		if err := a.spool.remove(name); err != nil {
+			return err
+		}
+		a.uploaded.Add(1)
+	}
+	return nil
+}
+
+// Stats returns the archiver counters and the batches waiting for upload
+func (a *Archiver) Stats() Stats {
+	pending, pendingBytes := a.spool.usage()
+	return Stats{
+		Archived:     a.archived.Load(),
+		Dropped:      a.dropped.Load(),
+		Rejected:     a.rejected.Load(),
+		Batches:      a.batches.Load(),
+		Uploaded:     a.uploaded.Load(),
+		Failed:       a.failed.Load(),
+		Pending:      pending,
+		PendingBytes: pendingBytes,
+	}
+}
@@ -0,0 +1,249 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/hook"
+	"github.com/axmq/ax/pkg/compress"
+)
+
+type memoryUploader struct {
+	mu      sync.Mutex
+	objects []*Object
+	err     error
+}
+
+func (u *memoryUploader) Upload(_ context.Context, object *Object) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.err != nil {
+		return u.err
+	}
+	u.objects = append(u.objects, object)
+	return nil
+}
+
+func (u *memoryUploader) setErr(err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.err = err
+}
+
+func (u *memoryUploader) uploaded() []*Object {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]*Object(nil), u.objects...)
+}
+
+func decodeObject(t *testing.T, object *Object) []Record {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(object.Body))
+	require.NoError(t, err)
+	data, err := io.ReadAll(zr)
+	require.NoError(t, err)
+
+	var records []Record
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var rec Record
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &rec))
+		records = append(records, rec)
+	}
+	return records
+}
+
+func newTestArchiver(t *testing.T, config Config, uploader Uploader) *Archiver {
+	t.Helper()
+	if config.FlushInterval == 0 {
+		config.FlushInterval = time.Hour
+	}
+	a, err := NewArchiver(config, uploader)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = a.Close() })
+	return a
+}
+
+func TestArchiverFlushUploadsBatch(t *testing.T) {
+	uploader := &memoryUploader{}
+	a := newTestArchiver(t, Config{Prefix: "node-1/"}, uploader)
+	a.now = func() time.Time { return time.Date(2026, 10, 16, 15, 4, 5, 0, time.UTC) }
+
+	require.NoError(t, a.Add(Record{ClientID: "c1", Topic: "sensors/1", QoS: 1, Payload: []byte("21.5")}))
+	require.NoError(t, a.Add(Record{ClientID: "c2", Topic: "sensors/2", Payload: []byte("19.0")}))
+	require.NoError(t, a.Flush(context.Background()))
+
+	objects := uploader.uploaded()
+	require.Len(t, objects, 1)
+	assert.Equal(t, "node-1/2026/10/16/20261016T150405.000000000Z-000001.ndjson.gz", objects[0].Key)
+	assert.Equal(t, "application/gzip", objects[0].ContentType)
+
+	records := decodeObject(t, objects[0])
+	require.Len(t, records, 2)
+	assert.Equal(t, "sensors/1", records[0].Topic)
+	assert.Equal(t, []byte("21.5"), records[0].Payload)
+	assert.Equal(t, byte(1), records[0].QoS)
+	assert.Equal(t, "c2", records[1].ClientID)
+
+	require.NoError(t, a.Flush(context.Background()))
+	assert.Len(t, uploader.uploaded(), 1, "an empty batch is not uploaded")
+	assert.Equal(t, Stats{Archived: 2, Batches: 1, Uploaded: 1}, a.Stats())
+}
+
+func TestArchiverSealsFullBatch(t *testing.T) {
+	uploader := &memoryUploader{}
+	a := newTestArchiver(t, Config{MaxBatchMessages: 3, Compression: CompressionNone}, uploader)
+
+	for range 3 {
+		require.NoError(t, a.Add(Record{Topic: "a", Payload: []byte("x")}))
+	}
+	require.Eventually(t, func() bool { return len(uploader.uploaded()) == 1 }, time.Second, time.Millisecond)
+
+	object := uploader.uploaded()[0]
+	assert.Equal(t, "application/x-ndjson", object.ContentType)
+	assert.Equal(t, 3, bytes.Count(object.Body, []byte("\n")))
+}
+
+func TestArchiverRetriesAfterOutage(t *testing.T) {
+	uploader := &memoryUploader{err: errors.New("connection refused")}
+	a := newTestArchiver(t, Config{}, uploader)
+
+	require.NoError(t, a.Add(Record{Topic: "a"}))
+	err := a.Flush(context.Background())
+	assert.ErrorIs(t, err, ErrUploadFailed)
+	require.NoError(t, a.Add(Record{Topic: "b"}))
+	assert.ErrorIs(t, a.Flush(context.Background()), ErrUploadFailed)
+
+	stats := a.Stats()
+	assert.Equal(t, 2, stats.Pending)
+	assert.Equal(t, uint64(2), stats.Failed)
+
+	uploader.setErr(nil)
+	require.NoError(t, a.Flush(context.Background()))
+	objects := uploader.uploaded()
+	require.Len(t, objects, 2)
+	assert.Equal(t, "a", decodeObject(t, objects[0])[0].Topic)
+	assert.Equal(t, "b", decodeObject(t, objects[1])[0].Topic)
+	assert.Zero(t, a.Stats().Pending)
+}
+
+func TestArchiverSpoolSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	down := &memoryUploader{err: errors.New("unavailable")}
+	a, err := NewArchiver(Config{SpoolDir: dir, FlushInterval: time.Hour}, down)
+	require.NoError(t, err)
+	require.NoError(t, a.Add(Record{Topic: "a", Payload: []byte("kept")}))
+	assert.ErrorIs(t, a.Close(), ErrUploadFailed)
+	assert.ErrorIs(t, a.Add(Record{Topic: "a"}), ErrArchiverClosed)
+
+	up := &memoryUploader{}
+	restarted := newTestArchiver(t, Config{SpoolDir: dir}, up)
+	assert.Equal(t, 1, restarted.Stats().Pending)
+	require.NoError(t, restarted.Flush(context.Background()))
+
+	objects := up.uploaded()
+	require.Len(t, objects, 1)
+	assert.Equal(t, []byte("kept"), decodeObject(t, objects[0])[0].Payload)
+	assert.Zero(t, restarted.Stats().Pending)
+}
+
+func TestArchiverAppliesBackPressureWhenSpoolFull(t *testing.T) {
+	uploader := &memoryUploader{err: errors.New("unavailable")}
+	a := newTestArchiver(t, Config{MaxSpoolBytes: 64, MaxBatchMessages: 1, Compression: CompressionNone}, uploader)
+	h := NewHook(a)
+	client := &hook.Client{ID: "dev1"}
+
+	// A batch larger than the spool is still spooled when the spool is empty
+	require.NoError(t, a.Add(Record{Topic: "a", Payload: bytes.Repeat([]byte("x"), 100)}))
+	assert.ErrorIs(t, a.Flush(context.Background()), ErrUploadFailed)
+	assert.False(t, a.Full())
+
+	require.NoError(t, a.Add(Record{Topic: "b"}))
+	assert.ErrorIs(t, a.Flush(context.Background()), ErrUploadFailed)
+	assert.True(t, a.Full())
+	assert.ErrorIs(t, a.Add(Record{Topic: "c"}), ErrSpoolFull)
+	err := h.OnPublish(client, &hook.PublishPacket{Topic: "c"})
+	assert.ErrorIs(t, err, ErrSpoolFull)
+	assert.Equal(t, encoding.ReasonQuotaExceeded, encoding.FromError(err))
+
+	stats := a.Stats()
+	assert.Zero(t, stats.Dropped)
+	assert.Equal(t, uint64(1), stats.Rejected)
+	assert.Equal(t, 1, stats.Pending)
+
+	uploader.setErr(nil)
+	require.NoError(t, a.Flush(context.Background()))
+	assert.False(t, a.Full())
+	require.NoError(t, h.OnPublish(client, &hook.PublishPacket{Topic: "c"}))
+	objects := uploader.uploaded()
+	require.Len(t, objects, 2)
+	assert.Contains(t, string(objects[0].Body), `"topic":"a"`)
+	assert.Contains(t, string(objects[1].Body), `"topic":"b"`)
+	assert.Zero(t, a.Stats().Pending)
+}
+
+func TestArchiverCompressesWithRegistryCodec(t *testing.T) {
+	uploader := &memoryUploader{}
+	a := newTestArchiver(t, Config{Compression: CompressionZstd}, uploader)
+	a.now = func() time.Time { return time.Date(2026, 10, 16, 15, 4, 5, 0, time.UTC) }
+
+	require.NoError(t, a.Add(Record{Topic: "a", Payload: []byte("v1")}))
+	require.NoError(t, a.Flush(context.Background()))
+	objects := uploader.uploaded()
+	require.Len(t, objects, 1)
+	assert.Equal(t, "2026/10/16/20261016T150405.000000000Z-000001.ndjson.zst", objects[0].Key)
+	assert.Equal(t, "application/zstd", objects[0].ContentType)
+
+	codec, err := compress.Get(compress.Zstd)
+	require.NoError(t, err)
+	data, err := codec.Decode(nil, objects[0].Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"topic":"a"`)
+}
+
+func TestNewArchiverInvalidConfig(t *testing.T) {
+	_, err := NewArchiver(Config{}, nil)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	_, err = NewArchiver(Config{Filters: []string{"a/#/b"}}, &memoryUploader{})
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	_, err = NewArchiver(Config{Compression: "zip"}, &memoryUploader{})
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+}
+
+func TestHookArchivesMatchingPublishes(t *testing.T) {
+	uploader := &memoryUploader{}
+	a := newTestArchiver(t, Config{Filters: []string{"telemetry/#"}}, uploader)
+	h := NewHook(a)
+	assert.True(t, h.Provides(hook.OnPublished))
+	assert.True(t, h.Provides(hook.OnPublish))
+	assert.False(t, h.Provides(hook.OnSubscribe))
+
+	payload := []byte("v1")
+	client := &hook.Client{ID: "dev1"}
+	require.NoError(t, h.OnPublished(client, &hook.PublishPacket{Topic: "telemetry/dev1", Payload: payload, Retain: true}))
+	require.NoError(t, h.OnPublished(client, &hook.PublishPacket{Topic: "commands/dev1", Payload: payload}))
+	require.NoError(t, h.OnPublished(client, nil))
+	payload[0] = 'x'
+
+	require.NoError(t, h.Stop())
+	objects := uploader.uploaded()
+	require.Len(t, objects, 1)
+	records := decodeObject(t, objects[0])
+	require.Len(t, records, 1)
+	assert.Equal(t, "dev1", records[0].ClientID)
+	assert.Equal(t, []byte("v1"), records[0].Payload)
+	assert.True(t, records[0].Retain)
+	assert.False(t, records[0].Time.IsZero())
+}
@@ -0,0 +1,56 @@
+package archive
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// Record is an archived message
+type Record struct {
+	Time     time.Time `json:"time"`
+	ClientID string    `json:"client_id"`
+	Topic    string    `json:"topic"`
+	QoS      byte      `json:"qos"`
+	Retain   bool      `json:"retain,omitempty"`
+	Payload  []byte    `json:"payload"`
+}
+
+// size approximates the encoded size of the record, it bounds batches by bytes
+func (r *Record) size() int {
+	return 64 + len(r.ClientID) + len(r.Topic) + len(r.Payload)*4/3
+}
+
+// Encoder writes a batch of records as one archive file, implement it to archive in a columnar format such as Parquet
+type Encoder interface {
+	// Encode writes records to w
+	Encode(w io.Writer, records []Record) error
+	// Extension is the file extension of encoded batches, without the compression suffix
+	Extension() string
+	// ContentType is the media type of uncompressed batches
+	ContentType() string
+}
+
+// NDJSONEncoder writes one JSON object per line, payloads are base64 encoded
+type NDJSONEncoder struct{}
+
+// Encode writes one line per record
+func (NDJSONEncoder) Encode(w io.Writer, records []Record) error {
+	enc := json.NewEncoder(w)
+	for i := range records {
+		if err := enc.Encode(&records[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Extension returns ndjson
+func (NDJSONEncoder) Extension() string {
+	return "ndjson"
+}
+
+// ContentType returns the newline-delimited JSON media type
+func (NDJSONEncoder) ContentType() string {
+	return "application/x-ndjson"
+}
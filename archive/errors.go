@@ -0,0 +1,14 @@
+package archive
+
+import (
+	"errors"
+
+	axerrors "github.com/axmq/ax/pkg/errors"
+)
+
+var (
+	ErrArchiverClosed = errors.New("archiver is closed")
+	ErrInvalidConfig  = errors.New("invalid archive config")
+	ErrSpoolFull      = axerrors.New(axerrors.KindQuota, "archive spool is full")
+	ErrUploadFailed   = errors.New("archive upload failed")
+)
@@ -0,0 +1,64 @@
+package archive
+
+import (
+	"github.com/axmq/ax/hook"
+)
+
+// Hook archives accepted publishes on the archiver's topics
+// While the spool is full and the open batch has reached its limits, publishes on archived topics are
+// rejected with ErrSpoolFull, a quota error, instead of being accepted and lost for the archive
+type Hook struct {
+	*hook.Base
+	archiver *Archiver
+}
+
+// NewHook creates a hook adding accepted publishes to archiver
+func NewHook(archiver *Archiver) *Hook {
+	return &Hook{
+		Base:     hook.NewHookBase("archive"),
+		archiver: archiver,
+	}
+}
+
+// Provides indicates this hook throttles and archives publishes
+func (h *Hook) Provides(event hook.Event) bool {
+	return event == hook.OnPublish || event == hook.OnPublished
+}
+
+// OnPublish rejects a publish on an archived topic while the archiver cannot take more messages
+func (h *Hook) OnPublish(client *hook.Client, packet *hook.PublishPacket) error {
+	if packet == nil || !h.archiver.Matches(packet.Topic) {
+		return nil
+	}
+	if h.archiver.Full() {
+		return ErrSpoolFull
+	}
+	return nil
+}
+
+// OnPublished adds a copy of the message to the open batch when its topic is archived, a message refused
+// because the spool filled up after OnPublish is counted in Stats.Rejected
+func (h *Hook) OnPublished(client *hook.Client, packet *hook.PublishPacket) error {
+	if packet == nil || !h.archiver.Matches(packet.Topic) {
+		return nil
+	}
+	_ = h.archiver.Add(Record{
+		Time:     packet.Created,
+		ClientID: client.GetID(),
+		Topic:    packet.Topic,
+		QoS:      packet.QoS,
+		Retain:   packet.Retain,
+		Payload:  append([]byte(nil), packet.Payload...),
+	})
+	return nil
+}
+
+// Archiver returns the underlying archiver
+func (h *Hook) Archiver() *Archiver {
+	return h.archiver
+}
+
+// Stop closes the archiver, uploading the open batch
+func (h *Hook) Stop() error {
+	return h.archiver.Close()
+}
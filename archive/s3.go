@@ -0,0 +1,185 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	_amzDateFormat  = "20060102T150405Z"
+	_s3Service      = "s3"
+	_signAlgorithm  = "AWS4-HMAC-SHA256"
+	_maxErrorDetail = 512
+)
+
+// S3Config configures uploads to S3 or an S3-compatible object store
+type S3Config struct {
+	// Endpoint is the base URL of the service, e.g. https://s3.eu-west-1.amazonaws.com or http://minio:9000
+	Endpoint string
+	Region   string
+	Bucket   string
+
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is set for temporary credentials
+	SessionToken string
+
+	// PathStyle addresses the bucket in the path instead of the host name, most S3-compatible stores need it
+	PathStyle bool
+	// StorageClass sets the storage class of uploaded objects, e.g. GLACIER_IR, the bucket default when empty
+	StorageClass string
+	HTTPClient   *http.Client
+}
+
+// S3Uploader uploads archive files with signature version 4 signed PUT requests
+type S3Uploader struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+// NewS3Uploader creates an uploader for the configured bucket
+func NewS3Uploader(config S3Config) (*S3Uploader, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("%w: endpoint %q", ErrInvalidConfig, config.Endpoint)
+	}
+	if config.Bucket == "" || config.Region == "" {
+		return nil, fmt.Errorf("%w: bucket and region are required", ErrInvalidConfig)
+	}
+	if config.AccessKeyID == "" || config.SecretAccessKey == "" {
+		return nil, fmt.Errorf("%w: credentials are required", ErrInvalidConfig)
+	}
+
+	client := config.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &S3Uploader{
+		config:   config,
+		endpoint: endpoint,
+		client:   client,
+		now:      time.Now,
+	}, nil
+}
+
+// Upload stores object under its key in the bucket
+func (u *S3Uploader) Upload(ctx context.Context, object *Object) error {
+	host := u.endpoint.Host
+	path := strings.TrimSuffix(u.endpoint.Path, "/") + "/"
+	if u.config.PathStyle {
+		path += uriEncode(u.config.Bucket, true) + "/"
+	} else {
+		host = u.config.Bucket + "." + host
+	}
+	path += uriEncode(object.Key, false)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.endpoint.Scheme+"://"+host+path, bytes.NewReader(object.Body))
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(object.Body)
+	payloadHash := hex.EncodeToString(sum[:])
+	now := u.now().UTC()
+	if object.ContentType != "" {
+		req.Header.Set("Content-Type", object.ContentType)
+	}
+	if u.config.StorageClass != "" {
+		req.Header.Set("X-Amz-Storage-Class", u.config.StorageClass)
+	}
+	if u.config.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", u.config.SessionToken)
+	}
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	req.Header.Set("X-Amz-Date", now.Format(_amzDateFormat))
+	req.Header.Set("Authorization", u.sign(req.Method, path, host, req.Header, payloadHash, now))
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, _maxErrorDetail))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// sign returns the signature version 4 Authorization header of a request without a query string
+// signing the host and every header in header
+func (u *S3Uploader) sign(method, path, host string, header http.Header, payloadHash string, now time.Time) string {
+	values := map[string]string{"host": host}
+	for name, vals := range header {
+		name = strings.ToLower(name)
+		if name == "authorization" {
+			continue
+		}
+		values[name] = strings.TrimSpace(strings.Join(vals, ","))
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonical strings.Builder
+	canonical.WriteString(method + "\n" + path + "\n\n")
+	for _, name := range names {
+		canonical.WriteString(name + ":" + values[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonical.WriteString("\n" + signedHeaders + "\n" + payloadHash)
+
+	date := now.Format("20060102")
+	scope := date + "/" + u.config.Region + "/" + _s3Service + "/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonical.String()))
+	stringToSign := _signAlgorithm + "\n" + now.Format(_amzDateFormat) + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+u.config.SecretAccessKey), date)
+	key = hmacSHA256(key, u.config.Region)
+	key = hmacSHA256(key, _s3Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	return fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		_signAlgorithm, u.config.AccessKeyID, scope, signedHeaders, signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// uriEncode escapes s as signature version 4 requires, slashes are kept unless encodeSlash is set
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,140 @@
+package archive
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const _spoolTempSuffix = ".tmp"
+
+// spool holds sealed batches until they are uploaded, on disk when it has a directory so they survive
+// restarts and outages, and in memory otherwise
+type spool struct {
+	dir   string
+	limit int64
+
+	mu     sync.Mutex
+	sizes  map[string]int64
+	bodies map[string][]byte // batches of an in-memory spool
+	bytes  int64
+}
+
+// openSpool opens the spool in dir, picking up the batches left by a previous run
+func openSpool(dir string, limit int64) (*spool, error) {
+	s := &spool{
+		dir:    dir,
+		limit:  limit,
+		sizes:  make(map[string]int64),
+		bodies: make(map[string][]byte),
+	}
+	if dir == "" {
+		return s, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create archive spool: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive spool: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		// A temporary file is a batch that was being written when the broker stopped
+		if strings.HasSuffix(entry.Name(), _spoolTempSuffix) {
+			_ = os.Remove(filepath.Join(dir, entry.Name()))
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive spool: %w", err)
+		}
+		s.sizes[entry.Name()] = info.Size()
+		s.bytes += info.Size()
+	}
+	return s, nil
+}
+
+// put stores a sealed batch, it fails with ErrSpoolFull when the batch does not fit in the limit
+// A batch larger than the limit is spooled once the spool is empty, so it cannot block archival for good
+func (s *spool) put(name string, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	size := int64(len(body))
+	if s.limit > 0 && s.bytes > 0 && s.bytes+size > s.limit {
+		return ErrSpoolFull
+	}
+
+	if s.dir == "" {
+		s.bodies[name] = body
+	} else {
+		path := filepath.Join(s.dir, name)
+		if err := os.WriteFile(path+_spoolTempSuffix, body, 0o644); err != nil {
+			return fmt.Errorf("failed to write archive spool: %w", err)
+		}
+		if err := os.Rename(path+_spoolTempSuffix, path); err != nil {
+			return fmt.Errorf("failed to write archive spool: %w", err)
+		}
+	}
+	s.sizes[name] = size
+	s.bytes += size
+	return nil
+}
+
+// names returns the spooled batches oldest first
+func (s *spool) names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.sizes))
+	for name := range s.sizes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// read returns the body of a spooled batch
+func (s *spool) read(name string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.dir == "" {
+		return s.bodies[name], nil
+	}
+	body, err := os.ReadFile(filepath.Join(s.dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive spool: %w", err)
+	}
+	return body, nil
+}
+
+// remove drops an uploaded batch
+func (s *spool) remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.dir != "" {
+		if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove archive spool batch: %w", err)
+		}
+	}
+	s.bytes -= s.sizes[name]
+	delete(s.sizes, name)
+	delete(s.bodies, name)
+	return nil
+}
+
+// usage returns the number of spooled batches and their size in bytes
+func (s *spool) usage() (int, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sizes), s.bytes
+}
@@ -0,0 +1,78 @@
+package credentials
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2idHasher hashes passwords with argon2id in the PHC string format
+// $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key> with unpadded base64 salt and key
+type Argon2idHasher struct {
+	// Memory is the memory cost in KiB
+	Memory   uint32
+	Time     uint32
+	Threads  uint8
+	SaltSize int
+	KeySize  uint32
+}
+
+// DefaultArgon2idHasher returns an argon2id hasher with the parameters recommended by RFC 9106 for
+// memory-constrained environments
+func DefaultArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{
+		Memory:   64 * 1024,
+		Time:     3,
+		Threads:  4,
+		SaltSize: 16,
+		KeySize:  32,
+	}
+}
+
+// Hash returns the encoded argon2id hash of password with a random salt
+func (h *Argon2idHasher) Hash(password []byte) (string, error) {
+	salt := make([]byte, h.SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	key := argon2.IDKey(password, salt, h.Time, h.Memory, h.Threads, h.KeySize)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", PrefixArgon2id, argon2.Version, h.Memory, h.Time, h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func verifyArgon2id(password []byte, encoded string) (bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return false, ErrMalformedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return false, ErrMalformedHash
+	}
+	if version != argon2.Version {
+		return false, ErrUnsupportedHash
+	}
+
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil || time == 0 || threads == 0 {
+		return false, ErrMalformedHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, ErrMalformedHash
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(expected) == 0 {
+		return false, ErrMalformedHash
+	}
+
+	key := argon2.IDKey(password, salt, time, memory, threads, uint32(len(expected)))
+	return ConstantTimeEqual(key, expected), nil
+}
@@ -0,0 +1,40 @@
+package credentials
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// BcryptHasher hashes passwords with bcrypt in the $2a$ encoding
+type BcryptHasher struct {
+	Cost int
+}
+
+// DefaultBcryptHasher returns a bcrypt hasher with the default cost
+func DefaultBcryptHasher() *BcryptHasher {
+	return &BcryptHasher{Cost: bcrypt.DefaultCost}
+}
+
+// Hash returns the encoded bcrypt hash of password
+func (h *BcryptHasher) Hash(password []byte) (string, error) {
+	encoded, err := bcrypt.GenerateFromPassword(password, h.Cost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(encoded), nil
+}
+
+// verifyBcrypt checks the $2a$, $2b$ and $2y$ encodings, which only differ in bugs of historic implementations
+func verifyBcrypt(password []byte, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), password)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+		return false, nil
+	default:
+		return false, fmt.Errorf("%w: %v", ErrMalformedHash, err)
+	}
+}
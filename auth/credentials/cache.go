@@ -0,0 +1,132 @@
+package credentials
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"sync"
+	"time"
+)
+
+type CacheConfig struct {
+	TTL         time.Duration
+	NegativeTTL time.Duration
+	MaxEntries  int
+}
+
+func DefaultCacheConfig() CacheConfig {
+	return CacheConfig{
+		TTL:         5 * time.Minute,
+		NegativeTTL: 30 * time.Second,
+		MaxEntries:  10000,
+	}
+}
+
+type cacheEntry struct {
+	sum     [sha256.Size]byte
+	valid   bool
+	expires time.Time
+}
+
+// CachedStore caches verification results of another store so repeated connects skip the password hash
+// Only a SHA-256 digest of the password is kept in memory
+type CachedStore struct {
+	store  CredentialStore
+	config CacheConfig
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	now     func() time.Time
+}
+
+// NewCachedStore wraps store with a verification cache
+func NewCachedStore(store CredentialStore, config CacheConfig) *CachedStore {
+	return &CachedStore{
+		store:   store,
+		config:  config,
+		entries: make(map[string]cacheEntry),
+		now:     time.Now,
+	}
+}
+
+// Lookup is passed through to the wrapped store
+func (c *CachedStore) Lookup(ctx context.Context, username string) (*Credential, error) {
+	return c.store.Lookup(ctx, username)
+}
+
+// Verify returns a cached result for the same username and password, or verifies against the wrapped store
+func (c *CachedStore) Verify(ctx context.Context, username string, password []byte) error {
+	sum := sha256.Sum256(password)
+	now := c.now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[username]
+	c.mu.Unlock()
+
+	if ok && now.Before(entry.expires) && ConstantTimeEqual(entry.sum[:], sum[:]) {
+		if entry.valid {
+			return nil
+		}
+		return ErrInvalidCredentials
+	}
+
+	err := c.store.Verify(ctx, username, password)
+	switch {
+	case err == nil && c.config.TTL > 0:
+		c.put(username, cacheEntry{sum: sum, valid: true, expires: now.Add(c.config.TTL)})
+	case errors.Is(err, ErrInvalidCredentials) && c.config.NegativeTTL > 0:
+		c.put(username, cacheEntry{sum: sum, expires: now.Add(c.config.NegativeTTL)})
+	}
+	return err
+}
+
+func (c *CachedStore) put(username string, entry cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.entries[username]; !exists && c.config.MaxEntries > 0 && len(c.entries) >= c.config.MaxEntries {
+		c.evictLocked()
+		if len(c.entries) >= c.config.MaxEntries {
+			return
+		}
+	}
+	c.entries[username] = entry
+}
+
+// evictLocked removes expired entries, or one arbitrary entry when none have expired
+func (c *CachedStore) evictLocked() {
+	now := c.now()
+	for username, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, username)
+		}
+	}
+	if len(c.entries) < c.config.MaxEntries {
+		return
+	}
+	for username := range c.entries {
+		delete(c.entries, username)
+		return
+	}
+}
+
+// Invalidate drops the cached result for username, for example after a password change
+func (c *CachedStore) Invalidate(username string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, username)
+}
+
+// Purge drops all cached results
+func (c *CachedStore) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]cacheEntry)
+}
+
+// Len returns the number of cached results
+func (c *CachedStore) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
@@ -0,0 +1,121 @@
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type countingStore struct {
+	CredentialStore
+	verifies int
+}
+
+func (s *countingStore) Verify(ctx context.Context, username string, password []byte) error {
+	s.verifies++
+	return s.CredentialStore.Verify(ctx, username, password)
+}
+
+func TestDefaultCacheConfig(t *testing.T) {
+	config := DefaultCacheConfig()
+	assert.Equal(t, 5*time.Minute, config.TTL)
+	assert.Equal(t, 30*time.Second, config.NegativeTTL)
+	assert.Equal(t, 10000, config.MaxEntries)
+}
+
+func TestCachedStoreVerify(t *testing.T) {
+	backend := &countingStore{CredentialStore: newTestStore(t)}
+	cache := NewCachedStore(backend, DefaultCacheConfig())
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	require.NoError(t, cache.Verify(ctx, "alice", []byte("secret")))
+	require.NoError(t, cache.Verify(ctx, "alice", []byte("secret")))
+	assert.Equal(t, 1, backend.verifies)
+
+	// A different password is never answered from the cache
+	assert.ErrorIs(t, cache.Verify(ctx, "alice", []byte("wrong")), ErrInvalidCredentials)
+	assert.Equal(t, 2, backend.verifies)
+
+	assert.ErrorIs(t, cache.Verify(ctx, "alice", []byte("wrong")), ErrInvalidCredentials)
+	assert.Equal(t, 2, backend.verifies)
+
+	now = now.Add(time.Minute)
+	assert.ErrorIs(t, cache.Verify(ctx, "alice", []byte("wrong")), ErrInvalidCredentials)
+	assert.Equal(t, 3, backend.verifies)
+
+	require.NoError(t, cache.Verify(ctx, "alice", []byte("secret")))
+	assert.Equal(t, 4, backend.verifies)
+
+	now = now.Add(6 * time.Minute)
+	require.NoError(t, cache.Verify(ctx, "alice", []byte("secret")))
+	assert.Equal(t, 5, backend.verifies)
+}
+
+func TestCachedStoreInvalidate(t *testing.T) {
+	backend := &countingStore{CredentialStore: newTestStore(t)}
+	cache := NewCachedStore(backend, DefaultCacheConfig())
+	ctx := context.Background()
+
+	require.NoError(t, cache.Verify(ctx, "alice", []byte("secret")))
+	assert.Equal(t, 1, cache.Len())
+
+	cache.Invalidate("alice")
+	assert.Equal(t, 0, cache.Len())
+	require.NoError(t, cache.Verify(ctx, "alice", []byte("secret")))
+	assert.Equal(t, 2, backend.verifies)
+
+	cache.Purge()
+	assert.Equal(t, 0, cache.Len())
+}
+
+func TestCachedStoreMaxEntries(t *testing.T) {
+	backend := newTestStore(t)
+	encoded, err := testHasher().Hash([]byte("secret"))
+	require.NoError(t, err)
+	backend.Set(&Credential{Username: "bob", PasswordHash: encoded})
+
+	cache := NewCachedStore(backend, CacheConfig{TTL: time.Minute, MaxEntries: 1})
+	ctx := context.Background()
+
+	require.NoError(t, cache.Verify(ctx, "alice", []byte("secret")))
+	require.NoError(t, cache.Verify(ctx, "bob", []byte("secret")))
+	assert.Equal(t, 1, cache.Len())
+}
+
+func TestCachedStoreDisabled(t *testing.T) {
+	backend := &countingStore{CredentialStore: newTestStore(t)}
+	cache := NewCachedStore(backend, CacheConfig{})
+	ctx := context.Background()
+
+	require.NoError(t, cache.Verify(ctx, "alice", []byte("secret")))
+	require.NoError(t, cache.Verify(ctx, "alice", []byte("secret")))
+	assert.Equal(t, 2, backend.verifies)
+	assert.Equal(t, 0, cache.Len())
+}
+
+func TestCachedStoreWrappedInvalidCredentials(t *testing.T) {
+	backend := &countingStore{CredentialStore: wrappingStore{newTestStore(t)}}
+	cache := NewCachedStore(backend, DefaultCacheConfig())
+	ctx := context.Background()
+
+	assert.ErrorIs(t, cache.Verify(ctx, "alice", []byte("wrong")), ErrInvalidCredentials)
+	assert.ErrorIs(t, cache.Verify(ctx, "alice", []byte("wrong")), ErrInvalidCredentials)
+	assert.Equal(t, 1, backend.verifies)
+}
+
+type wrappingStore struct {
+	CredentialStore
+}
+
+func (s wrappingStore) Verify(ctx context.Context, username string, password []byte) error {
+	if err := s.CredentialStore.Verify(ctx, username, password); err != nil {
+		return fmt.Errorf("backend: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,308 @@
+package credentials
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	mathrand "math/rand/v2"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/axmq/ax/store"
+)
+
+// Decision is an authentication result of an external identity provider
+type Decision struct {
+	Allowed bool `json:"allowed"`
+	// Data carries details the hook needs again on a cache hit, e.g. the claims of a token
+	Data []byte `json:"data,omitempty"`
+}
+
+// DecisionFetcher asks the identity provider for a decision, an error means no decision could be made
+type DecisionFetcher func(ctx context.Context) (Decision, error)
+
+type DecisionCacheConfig struct {
+	// Key seals cached decisions with AES-GCM and derives the cache keys, at least 16 bytes
+	Key []byte
+	// TTL is how long an allowed decision is served without asking the identity provider
+	TTL time.Duration
+	// NegativeTTL is how long a denied decision is served, zero disables caching denials
+	NegativeTTL time.Duration
+	// Refresh is the fraction of the TTL after which a hit asks the identity provider again
+	Refresh float64
+	// Jitter spreads refreshes of entries stored together by up to this fraction of the refresh time
+	Jitter float64
+	// StaleTTL keeps serving allowed decisions past their TTL while the identity provider is failing
+	StaleTTL time.Duration
+}
+
+func DefaultDecisionCacheConfig() DecisionCacheConfig {
+	return DecisionCacheConfig{
+		TTL:         5 * time.Minute,
+		NegativeTTL: 30 * time.Second,
+		Refresh:     0.8,
+		Jitter:      0.1,
+		StaleTTL:    time.Hour,
+	}
+}
+
+// DecisionCacheStats holds the counters of a decision cache
+type DecisionCacheStats struct {
+	Hits        uint64
+	Misses      uint64
+	Refreshes   uint64
+	StaleServed uint64
+	Errors      uint64
+}
+
+// decisionEntry is the sealed form of a cached decision
+type decisionEntry struct {
+	Decision
+	Refresh time.Time `json:"refresh"`
+	Expires time.Time `json:"expires"`
+}
+
+// DecisionCache caches positive and negative decisions of external authentication hooks in a store shared by
+// every broker node, so clients keep connecting while the identity provider is slow or down
+// Entries are sealed with AES-GCM and keyed by HMACs, neither tokens nor password hashes are stored in the clear
+type DecisionCache struct {
+	store  store.Store[[]byte]
+	config DecisionCacheConfig
+	aead   cipher.AEAD
+	mac    []byte
+	now    func() time.Time
+
+	hits        atomic.Uint64
+	misses      atomic.Uint64
+	refreshes   atomic.Uint64
+	staleServed atomic.Uint64
+	errors      atomic.Uint64
+}
+
+// NewDecisionCache creates a decision cache in backend, nil keeps the decisions in memory
+func NewDecisionCache(backend store.Store[[]byte], config DecisionCacheConfig) (*DecisionCache, error) {
+	if len(config.Key) < 16 {
+		return nil, ErrInvalidCacheKey
+	}
+	if backend == nil {
+		backend = store.NewMemoryStore[[]byte]()
+	}
+
+	// Separate keys for sealing and for naming entries are derived from the configured key
+	block, err := aes.NewCipher(deriveKey(config.Key, "ax decision cache seal"))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DecisionCache{
+		store:  backend,
+		config: config,
+		aead:   aead,
+		mac:    deriveKey(config.Key, "ax decision cache key"),
+		now:    time.Now,
+	}, nil
+}
+
+func deriveKey(key []byte, label string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(label))
+	return h.Sum(nil)
+}
+
+func (c *DecisionCache) digest(value []byte) string {
+	h := hmac.New(sha256.New, c.mac)
+	h.Write(value)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// key names the entry of a secret, e.g. a token or a password, presented for subject in namespace
+func (c *DecisionCache) key(namespace, subject string, secret []byte) string {
+	return c.subjectPrefix(namespace, subject) + c.digest(secret)
+}
+
+func (c *DecisionCache) subjectPrefix(namespace, subject string) string {
+	return namespace + ":" + c.digest([]byte(subject)) + ":"
+}
+
+// Resolve returns the cached decision for secret presented by subject, asking fetch when there is none or
+// it is due for a refresh. An allowed decision is served stale when fetch fails within StaleTTL of its expiry
+func (c *DecisionCache) Resolve(ctx context.Context, namespace, subject string, secret []byte, fetch DecisionFetcher) (Decision, error) {
+	key := c.key(namespace, subject, secret)
+	now := c.now()
+
+	entry, ok := c.load(ctx, key)
+	if ok && now.Before(entry.Refresh) {
+		c.hits.Add(1)
+		return entry.Decision, nil
+	}
+	if ok && now.Before(entry.Expires) {
+		c.refreshes.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+
+	decision, err := fetch(ctx)
+	if err != nil {
+		switch {
+		case ok && now.Before(entry.Expires):
+			return entry.Decision, nil
+		case ok && now.Before(c.deadline(entry)):
+			c.staleServed.Add(1)
+			return entry.Decision, nil
+		}
+		return Decision{}, err
+	}
+
+	c.save(ctx, key, decision, now)
+	return decision, nil
+}
+
+func (c *DecisionCache) load(ctx context.Context, key string) (*decisionEntry, bool) {
+	sealed, err := c.store.Load(ctx, key)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			c.errors.Add(1)
+		}
+		return nil, false
+	}
+
+	entry, err := c.open(key, sealed)
+	if err != nil {
+		// Entries sealed with a previous key are dropped
+		c.errors.Add(1)
+		_ = c.store.Delete(ctx, key)
+		return nil, false
+	}
+	return entry, true
+}
+
+func (c *DecisionCache) save(ctx context.Context, key string, decision Decision, now time.Time) {
+	ttl := c.config.TTL
+	if !decision.Allowed {
+		ttl = c.config.NegativeTTL
+	}
+	if ttl <= 0 {
+		_ = c.store.Delete(ctx, key)
+		return
+	}
+
+	refresh := time.Duration(float64(ttl) * c.config.Refresh)
+	if refresh <= 0 || refresh > ttl {
+		refresh = ttl
+	}
+	if c.config.Jitter > 0 {
+		refresh -= time.Duration(float64(refresh) * c.config.Jitter * mathrand.Float64())
+	}
+
+	entry := &decisionEntry{Decision: decision, Refresh: now.Add(refresh), Expires: now.Add(ttl)}
+	sealed, err := c.seal(key, entry)
+	if err == nil {
+		err = c.store.Save(ctx, key, sealed)
+	}
+	if err != nil {
+		c.errors.Add(1)
+	}
+}
+
+// seal encrypts an entry bound to its key, so a sealed entry cannot be replayed under another key
+func (c *DecisionCache) seal(key string, entry *decisionEntry) ([]byte, error) {
+	plain, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(plain)+c.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, plain, []byte(key)), nil
+}
+
+func (c *DecisionCache) open(key string, sealed []byte) (*decisionEntry, error) {
+	if len(sealed) < c.aead.NonceSize() {
+		return nil, ErrCorruptCacheEntry
+	}
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	plain, err := c.aead.Open(nil, nonce, ciphertext, []byte(key))
+	if err != nil {
+		return nil, ErrCorruptCacheEntry
+	}
+
+	var entry decisionEntry
+	if err := json.Unmarshal(plain, &entry); err != nil {
+		return nil, ErrCorruptCacheEntry
+	}
+	return &entry, nil
+}
+
+// Invalidate drops every cached decision of subject in namespace, e.g. after a password change or a revocation
+func (c *DecisionCache) Invalidate(ctx context.Context, namespace, subject string) error {
+	return c.store.DeletePrefix(ctx, c.subjectPrefix(namespace, subject))
+}
+
+// InvalidateSecret drops the cached decision of one secret presented by subject, e.g. a revoked token
+func (c *DecisionCache) InvalidateSecret(ctx context.Context, namespace, subject string, secret []byte) error {
+	return c.store.Delete(ctx, c.key(namespace, subject, secret))
+}
+
+// Purge drops every cached decision in namespace
+func (c *DecisionCache) Purge(ctx context.Context, namespace string) error {
+	return c.store.DeletePrefix(ctx, namespace+":")
+}
+
+// Sweep removes the entries of namespace that can no longer be served, not even stale, and returns how many were removed
+func (c *DecisionCache) Sweep(ctx context.Context, namespace string) (int, error) {
+	keys, err := c.store.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	now := c.now()
+	removed := 0
+	for _, key := range keys {
+		if !strings.HasPrefix(key, namespace+":") {
+			continue
+		}
+		sealed, err := c.store.Load(ctx, key)
+		if err != nil {
+			continue
+		}
+		entry, err := c.open(key, sealed)
+		if err == nil && now.Before(c.deadline(entry)) {
+			continue
+		}
+		if err := c.store.Delete(ctx, key); err == nil {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// deadline returns when an entry can no longer be served
+func (c *DecisionCache) deadline(entry *decisionEntry) time.Time {
+	if entry.Allowed {
+		return entry.Expires.Add(c.config.StaleTTL)
+	}
+	return entry.Expires
+}
+
+// Stats returns the cache counters
+func (c *DecisionCache) Stats() DecisionCacheStats {
+	return DecisionCacheStats{
+		Hits:        c.hits.Load(),
+		Misses:      c.misses.Load(),
+		Refreshes:   c.refreshes.Load(),
+		StaleServed: c.staleServed.Load(),
+		Errors:      c.errors.Load(),
+	}
+}
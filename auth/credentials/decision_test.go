@@ -0,0 +1,223 @@
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/axmq/ax/store"
+)
+
+var errProviderDown = errors.New("identity provider down")
+
+type testProvider struct {
+	decision Decision
+	err      error
+	calls    int
+}
+
+func (p *testProvider) fetch(context.Context) (Decision, error) {
+	p.calls++
+	return p.decision, p.err
+}
+
+func newTestDecisionCache(t *testing.T, backend store.Store[[]byte]) (*DecisionCache, *time.Time) {
+	t.Helper()
+	config := DefaultDecisionCacheConfig()
+	config.Key = []byte("0123456789abcdef0123456789abcdef")
+	config.Jitter = 0
+
+	cache, err := NewDecisionCache(backend, config)
+	require.NoError(t, err)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	return cache, &now
+}
+
+func TestDefaultDecisionCacheConfig(t *testing.T) {
+	config := DefaultDecisionCacheConfig()
+	assert.Equal(t, 5*time.Minute, config.TTL)
+	assert.Equal(t, 30*time.Second, config.NegativeTTL)
+	assert.Equal(t, 0.8, config.Refresh)
+	assert.Equal(t, time.Hour, config.StaleTTL)
+}
+
+func TestNewDecisionCacheShortKey(t *testing.T) {
+	_, err := NewDecisionCache(nil, DecisionCacheConfig{Key: []byte("short")})
+	assert.ErrorIs(t, err, ErrInvalidCacheKey)
+}
+
+func TestDecisionCacheResolve(t *testing.T) {
+	cache, now := newTestDecisionCache(t, nil)
+	ctx := context.Background()
+	provider := &testProvider{decision: Decision{Allowed: true, Data: []byte("claims")}}
+
+	for range 2 {
+		decision, err := cache.Resolve(ctx, "webhook", "alice", []byte("pw"), provider.fetch)
+		require.NoError(t, err)
+		assert.Equal(t, provider.decision, decision)
+	}
+	assert.Equal(t, 1, provider.calls)
+
+	// Another secret of the same subject is never answered from the cache
+	_, err := cache.Resolve(ctx, "webhook", "alice", []byte("other"), provider.fetch)
+	require.NoError(t, err)
+	assert.Equal(t, 2, provider.calls)
+
+	// Past the refresh point the provider is asked again
+	*now = now.Add(4*time.Minute + 30*time.Second)
+	_, err = cache.Resolve(ctx, "webhook", "alice", []byte("pw"), provider.fetch)
+	require.NoError(t, err)
+	assert.Equal(t, 3, provider.calls)
+
+	assert.Equal(t, DecisionCacheStats{Hits: 1, Misses: 2, Refreshes: 1}, cache.Stats())
+}
+
+func TestDecisionCacheNegative(t *testing.T) {
+	cache, now := newTestDecisionCache(t, nil)
+	ctx := context.Background()
+	provider := &testProvider{}
+
+	for range 2 {
+		decision, err := cache.Resolve(ctx, "webhook", "alice", []byte("wrong"), provider.fetch)
+		require.NoError(t, err)
+		assert.False(t, decision.Allowed)
+	}
+	assert.Equal(t, 1, provider.calls)
+
+	// Denials are not served stale once they expire
+	*now = now.Add(time.Minute)
+	provider.err = errProviderDown
+	_, err := cache.Resolve(ctx, "webhook", "alice", []byte("wrong"), provider.fetch)
+	assert.ErrorIs(t, err, errProviderDown)
+}
+
+func TestDecisionCacheOutage(t *testing.T) {
+	cache, now := newTestDecisionCache(t, nil)
+	ctx := context.Background()
+	provider := &testProvider{decision: Decision{Allowed: true}}
+
+	_, err := cache.Resolve(ctx, "jwt", "", []byte("token"), provider.fetch)
+	require.NoError(t, err)
+	provider.err = errProviderDown
+
+	// A failed refresh keeps the cached decision
+	*now = now.Add(4*time.Minute + 30*time.Second)
+	decision, err := cache.Resolve(ctx, "jwt", "", []byte("token"), provider.fetch)
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+
+	// Past the TTL it is served stale until StaleTTL runs out
+	*now = now.Add(30 * time.Minute)
+	decision, err = cache.Resolve(ctx, "jwt", "", []byte("token"), provider.fetch)
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, uint64(1), cache.Stats().StaleServed)
+
+	*now = now.Add(time.Hour)
+	_, err = cache.Resolve(ctx, "jwt", "", []byte("token"), provider.fetch)
+	assert.ErrorIs(t, err, errProviderDown)
+
+	// Unknown secrets fail while the provider is down
+	_, err = cache.Resolve(ctx, "jwt", "", []byte("new"), provider.fetch)
+	assert.ErrorIs(t, err, errProviderDown)
+}
+
+func TestDecisionCacheEncryptedAtRest(t *testing.T) {
+	backend := store.NewMemoryStore[[]byte]()
+	cache, _ := newTestDecisionCache(t, backend)
+	ctx := context.Background()
+	provider := &testProvider{decision: Decision{Allowed: true, Data: []byte(`{"sub":"alice"}`)}}
+
+	_, err := cache.Resolve(ctx, "webhook", "alice", []byte("hunter2"), provider.fetch)
+	require.NoError(t, err)
+
+	keys, err := backend.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.NotContains(t, keys[0], "alice")
+	assert.NotContains(t, keys[0], "hunter2")
+
+	sealed, err := backend.Load(ctx, keys[0])
+	require.NoError(t, err)
+	for _, plain := range []string{"alice", "hunter2", "allowed"} {
+		assert.False(t, bytes.Contains(sealed, []byte(plain)), plain)
+	}
+
+	// A sealed entry does not open under another key, as after a key rotation
+	other, err := NewDecisionCache(backend, DecisionCacheConfig{Key: []byte("fedcba9876543210fedcba9876543210"), TTL: time.Minute})
+	require.NoError(t, err)
+	_, err = other.open(keys[0], sealed)
+	assert.ErrorIs(t, err, ErrCorruptCacheEntry)
+
+	// Tampered entries are dropped and fetched again
+	sealed[len(sealed)-1] ^= 0xff
+	require.NoError(t, backend.Save(ctx, keys[0], sealed))
+	_, err = cache.Resolve(ctx, "webhook", "alice", []byte("hunter2"), provider.fetch)
+	require.NoError(t, err)
+	assert.Equal(t, 2, provider.calls)
+	assert.Equal(t, uint64(1), cache.Stats().Errors)
+}
+
+func TestDecisionCacheInvalidate(t *testing.T) {
+	backend := store.NewMemoryStore[[]byte]()
+	cache, now := newTestDecisionCache(t, backend)
+	ctx := context.Background()
+	provider := &testProvider{decision: Decision{Allowed: true}}
+
+	for _, subject := range []string{"alice", "bob"} {
+		for _, secret := range []string{"a", "b"} {
+			_, err := cache.Resolve(ctx, "webhook", subject, []byte(secret), provider.fetch)
+			require.NoError(t, err)
+		}
+	}
+	_, err := cache.Resolve(ctx, "jwt", "alice", []byte("a"), provider.fetch)
+	require.NoError(t, err)
+
+	require.NoError(t, cache.InvalidateSecret(ctx, "webhook", "bob", []byte("a")))
+	require.NoError(t, cache.Invalidate(ctx, "webhook", "alice"))
+	count, err := backend.Count(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	require.NoError(t, cache.Purge(ctx, "webhook"))
+	count, err = backend.Count(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	// Sweep removes entries past their stale deadline only
+	removed, err := cache.Sweep(ctx, "jwt")
+	require.NoError(t, err)
+	assert.Zero(t, removed)
+
+	*now = now.Add(2 * time.Hour)
+	removed, err = cache.Sweep(ctx, "jwt")
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+}
+
+func TestDecisionCacheJitter(t *testing.T) {
+	config := DefaultDecisionCacheConfig()
+	config.Key = []byte("0123456789abcdef")
+	config.Jitter = 0.5
+	cache, err := NewDecisionCache(nil, config)
+	require.NoError(t, err)
+
+	now := time.Now()
+	refreshes := make(map[time.Time]struct{})
+	for i := range 20 {
+		key := cache.key("webhook", "alice", []byte{byte(i)})
+		cache.save(context.Background(), key, Decision{Allowed: true}, now)
+		entry, ok := cache.load(context.Background(), key)
+		require.True(t, ok)
+		assert.False(t, entry.Refresh.Before(now.Add(2*time.Minute)))
+		assert.False(t, entry.Refresh.After(now.Add(4*time.Minute)))
+		refreshes[entry.Refresh] = struct{}{}
+	}
+	assert.Greater(t, len(refreshes), 1)
+}
@@ -0,0 +1,15 @@
+package credentials
+
+import (
+	"errors"
+)
+
+var (
+	ErrUserNotFound       = errors.New("user not found")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrUnsupportedHash    = errors.New("unsupported password hash")
+	ErrMalformedHash      = errors.New("malformed password hash")
+	ErrEmptyPrefix        = errors.New("hash prefix cannot be empty")
+	ErrInvalidCacheKey    = errors.New("decision cache key must be at least 16 bytes")
+	ErrCorruptCacheEntry  = errors.New("corrupt decision cache entry")
+)
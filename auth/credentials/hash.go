@@ -0,0 +1,173 @@
+package credentials
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Prefixes of the encoded hash formats recognized by VerifyPassword
+const (
+	PrefixPBKDF2   = "PBKDF2$"
+	PrefixBcrypt2a = "$2a$"
+	PrefixBcrypt2b = "$2b$"
+	PrefixBcrypt2y = "$2y$"
+	PrefixArgon2id = "$argon2id$"
+)
+
+// Verifier checks a password against an encoded hash
+type Verifier interface {
+	Verify(password []byte, encoded string) (bool, error)
+}
+
+// VerifierFunc adapts a function to the Verifier interface
+type VerifierFunc func(password []byte, encoded string) (bool, error)
+
+// Verify calls f(password, encoded)
+func (f VerifierFunc) Verify(password []byte, encoded string) (bool, error) {
+	return f(password, encoded)
+}
+
+// Hasher produces encoded password hashes
+type Hasher interface {
+	Hash(password []byte) (string, error)
+}
+
+var (
+	verifiersMu sync.RWMutex
+	verifiers   = map[string]Verifier{
+		PrefixPBKDF2:   VerifierFunc(verifyPBKDF2),
+		PrefixBcrypt2a: VerifierFunc(verifyBcrypt),
+		PrefixBcrypt2b: VerifierFunc(verifyBcrypt),
+		PrefixBcrypt2y: VerifierFunc(verifyBcrypt),
+		PrefixArgon2id: VerifierFunc(verifyArgon2id),
+	}
+)
+
+// RegisterVerifier registers a verifier for encoded hashes starting with prefix, replacing the built-in
+// PBKDF2, bcrypt and argon2id verifiers when it uses one of their prefixes
+func RegisterVerifier(prefix string, v Verifier) error {
+	if prefix == "" {
+		return ErrEmptyPrefix
+	}
+
+	verifiersMu.Lock()
+	defer verifiersMu.Unlock()
+	verifiers[prefix] = v
+	return nil
+}
+
+// VerifyPassword checks a password against an encoded hash using the verifier registered for its format
+func VerifyPassword(password []byte, encoded string) (bool, error) {
+	verifiersMu.RLock()
+	var verifier Verifier
+	longest := 0
+	for prefix, v := range verifiers {
+		if len(prefix) > longest && strings.HasPrefix(encoded, prefix) {
+			verifier, longest = v, len(prefix)
+		}
+	}
+	verifiersMu.RUnlock()
+
+	if verifier == nil {
+		return false, ErrUnsupportedHash
+	}
+	return verifier.Verify(password, encoded)
+}
+
+// ConstantTimeEqual compares two secrets without leaking their contents through timing
+func ConstantTimeEqual(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
+
+// PBKDF2Hasher hashes passwords with PBKDF2 using the mosquitto-go-auth encoding
+// PBKDF2$<digest>$<iterations>$<salt>$<key> with base64 salt and key
+type PBKDF2Hasher struct {
+	Digest     string
+	Iterations int
+	SaltSize   int
+	KeySize    int
+}
+
+// DefaultPBKDF2Hasher returns a PBKDF2-SHA512 hasher with the mosquitto-go-auth defaults
+func DefaultPBKDF2Hasher() *PBKDF2Hasher {
+	return &PBKDF2Hasher{
+		Digest:     "sha512",
+		Iterations: 100000,
+		SaltSize:   16,
+		KeySize:    64,
+	}
+}
+
+// Hash returns the encoded PBKDF2 hash of password with a random salt
+func (h *PBKDF2Hasher) Hash(password []byte) (string, error) {
+	newHash, err := digestFunc(h.Digest)
+	if err != nil {
+		return "", err
+	}
+
+	salt := make([]byte, h.SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	key, err := pbkdf2.Key(newHash, string(password), salt, h.Iterations, h.KeySize)
+	if err != nil {
+		return "", err
+	}
+
+	return PrefixPBKDF2 + h.Digest + "$" + strconv.Itoa(h.Iterations) + "$" +
+		base64.StdEncoding.EncodeToString(salt) + "$" + base64.StdEncoding.EncodeToString(key), nil
+}
+
+func verifyPBKDF2(password []byte, encoded string) (bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 {
+		return false, ErrMalformedHash
+	}
+
+	newHash, err := digestFunc(parts[1])
+	if err != nil {
+		return false, err
+	}
+
+	iterations, err := strconv.Atoi(parts[2])
+	if err != nil || iterations <= 0 {
+		return false, ErrMalformedHash
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false, ErrMalformedHash
+	}
+
+	expected, err := base64.StdEncoding.DecodeString(parts[4])
+	if err != nil || len(expected) == 0 {
+		return false, ErrMalformedHash
+	}
+
+	key, err := pbkdf2.Key(newHash, string(password), salt, iterations, len(expected))
+	if err != nil {
+		return false, err
+	}
+	return ConstantTimeEqual(key, expected), nil
+}
+
+func digestFunc(name string) (func() hash.Hash, error) {
+	switch name {
+	case "sha256":
+		return sha256.New, nil
+	case "sha512":
+		return sha512.New, nil
+	default:
+		return nil, ErrUnsupportedHash
+	}
+}
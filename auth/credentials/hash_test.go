@@ -0,0 +1,142 @@
+package credentials
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testHasher() *PBKDF2Hasher {
+	return &PBKDF2Hasher{Digest: "sha256", Iterations: 10, SaltSize: 8, KeySize: 32}
+}
+
+func TestPBKDF2HashAndVerify(t *testing.T) {
+	for _, digest := range []string{"sha256", "sha512"} {
+		t.Run(digest, func(t *testing.T) {
+			h := testHasher()
+			h.Digest = digest
+
+			encoded, err := h.Hash([]byte("secret"))
+			require.NoError(t, err)
+			assert.Contains(t, encoded, PrefixPBKDF2+digest+"$10$")
+
+			ok, err := VerifyPassword([]byte("secret"), encoded)
+			require.NoError(t, err)
+			assert.True(t, ok)
+
+			ok, err = VerifyPassword([]byte("wrong"), encoded)
+			require.NoError(t, err)
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestPBKDF2RandomSalt(t *testing.T) {
+	h := testHasher()
+	a, err := h.Hash([]byte("secret"))
+	require.NoError(t, err)
+	b, err := h.Hash([]byte("secret"))
+	require.NoError(t, err)
+	assert.NotEqual(t, a, b)
+}
+
+func TestVerifyPasswordMosquittoGoAuthHash(t *testing.T) {
+	// mosquitto-go-auth encoding of PBKDF2-SHA512, produced independently with Python's hashlib.pbkdf2_hmac
+	encoded := "PBKDF2$sha512$1000$YXhtcS10ZXN0LXNhbHQhIQ==$wf9tVBQCXuvDAIqlN+ma0ai9SdMm4K3F/b8+Aw1v+aSv3+cfv7fNjBG1fwdyev0IT/AD+8Id8pYDJDb+9IdalA=="
+
+	ok, err := VerifyPassword([]byte("password"), encoded)
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestBcryptHashAndVerify(t *testing.T) {
+	encoded, err := (&BcryptHasher{Cost: 4}).Hash([]byte("secret"))
+	require.NoError(t, err)
+	assert.Contains(t, encoded, PrefixBcrypt2a+"04$")
+
+	// $2b$ and $2y$ hashes are produced by other implementations with the same algorithm
+	for _, prefix := range []string{PrefixBcrypt2a, PrefixBcrypt2b, PrefixBcrypt2y} {
+		variant := prefix + encoded[len(PrefixBcrypt2a):]
+		ok, err := VerifyPassword([]byte("secret"), variant)
+		require.NoError(t, err)
+		assert.True(t, ok, prefix)
+
+		ok, err = VerifyPassword([]byte("wrong"), variant)
+		require.NoError(t, err)
+		assert.False(t, ok, prefix)
+	}
+}
+
+func TestVerifyPasswordBcryptVector(t *testing.T) {
+	// Test vector of the OpenBSD bcrypt implementation
+	ok, err := VerifyPassword([]byte("U*U"), "$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW")
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestArgon2idHashAndVerify(t *testing.T) {
+	h := &Argon2idHasher{Memory: 64, Time: 1, Threads: 1, SaltSize: 8, KeySize: 16}
+	encoded, err := h.Hash([]byte("secret"))
+	require.NoError(t, err)
+	assert.Contains(t, encoded, PrefixArgon2id+"v=19$m=64,t=1,p=1$")
+
+	ok, err := VerifyPassword([]byte("secret"), encoded)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = VerifyPassword([]byte("wrong"), encoded)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestVerifyPasswordErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+		err     error
+	}{
+		{"unknown format", "plain", ErrUnsupportedHash},
+		{"truncated bcrypt", "$2a$10$abcdefghijklmnopqrstuv", ErrMalformedHash},
+		{"argon2id missing fields", "$argon2id$v=19$m=65536,t=3,p=4$c2FsdA", ErrMalformedHash},
+		{"argon2id unknown version", "$argon2id$v=16$m=65536,t=3,p=4$c2FsdA$aGFzaA", ErrUnsupportedHash},
+		{"argon2id bad parameters", "$argon2id$v=19$m=65536,t=0,p=4$c2FsdA$aGFzaA", ErrMalformedHash},
+		{"argon2id bad salt", "$argon2id$v=19$m=65536,t=3,p=4$!!$aGFzaA", ErrMalformedHash},
+		{"missing fields", "PBKDF2$sha512$1000", ErrMalformedHash},
+		{"unknown digest", "PBKDF2$md5$1000$c2FsdA==$aGFzaA==", ErrUnsupportedHash},
+		{"bad iterations", "PBKDF2$sha256$zero$c2FsdA==$aGFzaA==", ErrMalformedHash},
+		{"bad salt", "PBKDF2$sha256$10$!!$aGFzaA==", ErrMalformedHash},
+		{"empty key", "PBKDF2$sha256$10$c2FsdA==$", ErrMalformedHash},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := VerifyPassword([]byte("secret"), tt.encoded)
+			assert.ErrorIs(t, err, tt.err)
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestRegisterVerifier(t *testing.T) {
+	const prefix = "$test$"
+	require.NoError(t, RegisterVerifier(prefix, VerifierFunc(func(password []byte, encoded string) (bool, error) {
+		return ConstantTimeEqual(password, []byte(encoded[len(prefix):])), nil
+	})))
+
+	ok, err := VerifyPassword([]byte("secret"), prefix+"secret")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = VerifyPassword([]byte("other"), prefix+"secret")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.ErrorIs(t, RegisterVerifier("", nil), ErrEmptyPrefix)
+}
+
+func TestConstantTimeEqual(t *testing.T) {
+	assert.True(t, ConstantTimeEqual([]byte("abc"), []byte("abc")))
+	assert.False(t, ConstantTimeEqual([]byte("abc"), []byte("abd")))
+	assert.False(t, ConstantTimeEqual([]byte("abc"), []byte("ab")))
+}
@@ -0,0 +1,94 @@
+package credentials
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Credential is a stored user record
+type Credential struct {
+	Username     string
+	PasswordHash string
+	Superuser    bool
+}
+
+// CredentialStore looks up and verifies user credentials
+// Verify returns ErrInvalidCredentials for an unknown user or a wrong password
+type CredentialStore interface {
+	Lookup(ctx context.Context, username string) (*Credential, error)
+	Verify(ctx context.Context, username string, password []byte) error
+}
+
+// dummyHash is verified for unknown users so they take as long to reject as a wrong password
+var dummyHash = sync.OnceValue(func() string {
+	encoded, _ := DefaultPBKDF2Hasher().Hash([]byte("dummy"))
+	return encoded
+})
+
+// VerifyWithLookup implements CredentialStore.Verify on top of a lookup function
+func VerifyWithLookup(ctx context.Context, lookup func(context.Context, string) (*Credential, error), username string, password []byte) error {
+	cred, err := lookup(ctx, username)
+	if errors.Is(err, ErrUserNotFound) {
+		_, _ = VerifyPassword(password, dummyHash())
+		return ErrInvalidCredentials
+	}
+	if err != nil {
+		return err
+	}
+
+	ok, err := VerifyPassword(password, cred.PasswordHash)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// MemoryStore is an in-memory CredentialStore
+type MemoryStore struct {
+	mu    sync.RWMutex
+	users map[string]*Credential
+}
+
+// NewMemoryStore creates an empty in-memory credential store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users: make(map[string]*Credential),
+	}
+}
+
+// Set adds or replaces a credential
+func (s *MemoryStore) Set(cred *Credential) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := *cred
+	s.users[cred.Username] = &c
+}
+
+// Delete removes a credential by username
+func (s *MemoryStore) Delete(username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.users, username)
+}
+
+// Lookup returns a copy of the credential for username
+func (s *MemoryStore) Lookup(ctx context.Context, username string) (*Credential, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cred, ok := s.users[username]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	c := *cred
+	return &c, nil
+}
+
+// Verify checks the password of username
+func (s *MemoryStore) Verify(ctx context.Context, username string, password []byte) error {
+	return VerifyWithLookup(ctx, s.Lookup, username, password)
+}
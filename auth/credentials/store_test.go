@@ -0,0 +1,77 @@
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStore(t *testing.T) *MemoryStore {
+	encoded, err := testHasher().Hash([]byte("secret"))
+	require.NoError(t, err)
+
+	s := NewMemoryStore()
+	s.Set(&Credential{Username: "alice", PasswordHash: encoded, Superuser: true})
+	return s
+}
+
+func TestMemoryStoreLookup(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	cred, err := s.Lookup(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", cred.Username)
+	assert.True(t, cred.Superuser)
+
+	cred.Superuser = false
+	cred, err = s.Lookup(ctx, "alice")
+	require.NoError(t, err)
+	assert.True(t, cred.Superuser)
+
+	_, err = s.Lookup(ctx, "bob")
+	assert.ErrorIs(t, err, ErrUserNotFound)
+
+	s.Delete("alice")
+	_, err = s.Lookup(ctx, "alice")
+	assert.ErrorIs(t, err, ErrUserNotFound)
+}
+
+func TestMemoryStoreVerify(t *testing.T) {
+	s := newTestStore(t)
+	s.Set(&Credential{Username: "legacy", PasswordHash: "plaintext"})
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		err      error
+	}{
+		{"valid", "alice", "secret", nil},
+		{"wrong password", "alice", "wrong", ErrInvalidCredentials},
+		{"unknown user", "bob", "secret", ErrInvalidCredentials},
+		{"unsupported hash", "legacy", "plaintext", ErrUnsupportedHash},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.Verify(ctx, tt.username, []byte(tt.password))
+			if tt.err == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestVerifyWithLookupWrappedNotFound(t *testing.T) {
+	lookup := func(context.Context, string) (*Credential, error) {
+		return nil, fmt.Errorf("users table: %w", ErrUserNotFound)
+	}
+	assert.ErrorIs(t, VerifyWithLookup(context.Background(), lookup, "bob", []byte("secret")), ErrInvalidCredentials)
+}
@@ -0,0 +1,56 @@
+package introspection
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/axmq/ax/auth/credentials"
+	"github.com/axmq/ax/topic"
+)
+
+// ACL lists the topic filters a scope grants, %u and %c are replaced by the username and client ID
+type ACL struct {
+	Read  []string
+	Write []string
+}
+
+// Config configures the RFC 7662 token introspection hook
+type Config struct {
+	Endpoint     string
+	ClientID     string
+	ClientSecret string
+	HTTPClient   *http.Client
+	Timeout      time.Duration
+
+	// CacheTTL bounds how long an active token is cached, the token expiry always takes precedence
+	CacheTTL time.Duration
+	// NegativeCacheTTL caches inactive tokens, zero disables it
+	NegativeCacheTTL time.Duration
+	MaxCacheEntries  int
+	// DecisionCache replaces the in-memory cache with one shared by every broker node, which keeps serving
+	// active tokens for a while when the authorization server is unreachable
+	DecisionCache *credentials.DecisionCache
+	// ClockSkew tolerates clock differences with the authorization server when checking exp and nbf
+	ClockSkew time.Duration
+
+	// MatchUsername requires the token's username or sub claim to equal the CONNECT username
+	MatchUsername bool
+	// Scopes maps token scopes to topic ACLs
+	Scopes map[string]ACL
+	// Normalize canonicalizes topics and scope filters before they are matched, set it to the options of the
+	// router so a topic cannot slip past an ACL by differing only in form
+	Normalize topic.NormalizeOptions
+}
+
+// DefaultConfig returns a configuration for the given introspection endpoint
+func DefaultConfig(endpoint string) *Config {
+	return &Config{
+		Endpoint:         endpoint,
+		Timeout:          5 * time.Second,
+		CacheTTL:         5 * time.Minute,
+		NegativeCacheTTL: 30 * time.Second,
+		MaxCacheEntries:  10000,
+		ClockSkew:        30 * time.Second,
+		Scopes:           make(map[string]ACL),
+	}
+}
@@ -0,0 +1,12 @@
+package introspection
+
+import "errors"
+
+var (
+	ErrNoEndpoint          = errors.New("introspection: endpoint is required")
+	ErrEmptyToken          = errors.New("introspection: token is empty")
+	ErrTokenInactive       = errors.New("introspection: token is not active")
+	ErrTokenExpired        = errors.New("introspection: token is expired")
+	ErrTokenNotYetValid    = errors.New("introspection: token is not yet valid")
+	ErrIntrospectionFailed = errors.New("introspection: request failed")
+)
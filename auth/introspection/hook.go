@@ -0,0 +1,140 @@
+package introspection
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/axmq/ax/hook"
+	"github.com/axmq/ax/topic"
+)
+
+// Hook authenticates clients whose CONNECT password is an OAuth2 access token
+// and authorizes topics from the scopes granted to the token
+type Hook struct {
+	*hook.Base
+	config       *Config
+	introspector *Introspector
+
+	mu       sync.RWMutex
+	sessions map[string]*TokenInfo
+}
+
+// NewHook creates an OAuth2 token introspection hook
+func NewHook(config *Config) (*Hook, error) {
+	introspector, err := NewIntrospector(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Hook{
+		Base:         hook.NewHookBase("oauth2-introspection"),
+		config:       config,
+		introspector: introspector,
+		sessions:     make(map[string]*TokenInfo),
+	}, nil
+}
+
+// Provides indicates this hook provides authentication, authorization and session cleanup
+func (h *Hook) Provides(event hook.Event) bool {
+	switch event {
+	case hook.OnConnectAuthenticate, hook.OnACLCheck, hook.OnDisconnect:
+		return true
+	default:
+		return false
+	}
+}
+
+// Introspector returns the underlying introspector
+func (h *Hook) Introspector() *Introspector {
+	return h.introspector
+}
+
+// OnConnectAuthenticate introspects the CONNECT password and remembers the token for ACL checks
+func (h *Hook) OnConnectAuthenticate(client *hook.Client, packet *hook.ConnectPacket) bool {
+	if packet == nil {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout())
+	defer cancel()
+
+	info, err := h.introspector.Introspect(ctx, string(packet.Password))
+	if err != nil {
+		return false
+	}
+
+	if h.config.MatchUsername && packet.Username != info.Username && packet.Username != info.Sub {
+		return false
+	}
+
+	clientID := packet.ClientID
+	if client != nil && client.ID != "" {
+		clientID = client.ID
+	}
+
+	h.mu.Lock()
+	h.sessions[clientID] = info
+	h.mu.Unlock()
+	return true
+}
+
+// OnACLCheck allows access when a scope of the client's token grants a matching filter
+// Access is denied once the token has expired
+func (h *Hook) OnACLCheck(client *hook.Client, topicName string, access hook.AccessType) bool {
+	if client == nil {
+		return false
+	}
+
+	h.mu.RLock()
+	info, ok := h.sessions[client.ID]
+	h.mu.RUnlock()
+	if !ok || info.Validate(h.introspector.now(), h.config.ClockSkew) != nil {
+		return false
+	}
+
+	replacer := strings.NewReplacer("%u", client.Username, "%c", client.ID)
+	for _, scope := range info.Scopes() {
+		acl, ok := h.config.Scopes[scope]
+		if !ok {
+			continue
+		}
+		if (access == hook.AccessTypeRead || access == hook.AccessTypeReadWrite) && !matchAny(acl.Read, replacer, topicName, h.config.Normalize) {
+			continue
+		}
+		if (access == hook.AccessTypeWrite || access == hook.AccessTypeReadWrite) && !matchAny(acl.Write, replacer, topicName, h.config.Normalize) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// OnDisconnect forgets the token of a disconnected client
+func (h *Hook) OnDisconnect(client *hook.Client, _ *hook.DisconnectInfo) error {
+	if client == nil {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.sessions, client.ID)
+	return nil
+}
+
+func (h *Hook) timeout() time.Duration {
+	if h.config.Timeout > 0 {
+		return h.config.Timeout
+	}
+	return DefaultConfig("").Timeout
+}
+
+func matchAny(filters []string, replacer *strings.Replacer, topicName string, opts topic.NormalizeOptions) bool {
+	for _, filter := range filters {
+		if topic.MatchNormalized(replacer.Replace(filter), topicName, opts) {
+			return true
+		}
+	}
+	return false
+}
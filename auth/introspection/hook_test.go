@@ -0,0 +1,129 @@
+package introspection
+
+import (
+	"testing"
+	"time"
+
+	"github.com/axmq/ax/hook"
+	"github.com/axmq/ax/topic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestHook(t *testing.T, configure func(*Config)) *Hook {
+	server := newTestServer(t, map[string]TokenInfo{
+		"reader":  {Active: true, Scope: "telemetry:read", Username: "alice", Exp: time.Now().Add(time.Hour).Unix()},
+		"writer":  {Active: true, Scope: "telemetry:read devices:write", Sub: "bob", Exp: time.Now().Add(time.Hour).Unix()},
+		"expired": {Active: true, Scope: "telemetry:read", Exp: time.Now().Add(-time.Hour).Unix()},
+	})
+
+	config := testConfig(server.URL)
+	config.Scopes = map[string]ACL{
+		"telemetry:read": {Read: []string{"telemetry/#"}},
+		"devices:write":  {Read: []string{"devices/%c/#"}, Write: []string{"devices/%c/#"}},
+	}
+	if configure != nil {
+		configure(config)
+	}
+
+	h, err := NewHook(config)
+	require.NoError(t, err)
+	return h
+}
+
+func TestHookProvides(t *testing.T) {
+	h := newTestHook(t, nil)
+	assert.Equal(t, "oauth2-introspection", h.ID())
+	assert.True(t, h.Provides(hook.OnConnectAuthenticate))
+	assert.True(t, h.Provides(hook.OnACLCheck))
+	assert.True(t, h.Provides(hook.OnDisconnect))
+	assert.False(t, h.Provides(hook.OnPublish))
+}
+
+func TestHookOnConnectAuthenticate(t *testing.T) {
+	tests := []struct {
+		name          string
+		matchUsername bool
+		packet        *hook.ConnectPacket
+		expected      bool
+	}{
+		{"active token", false, &hook.ConnectPacket{ClientID: "c1", Password: []byte("reader")}, true},
+		{"inactive token", false, &hook.ConnectPacket{ClientID: "c1", Password: []byte("unknown")}, false},
+		{"expired token", false, &hook.ConnectPacket{ClientID: "c1", Password: []byte("expired")}, false},
+		{"empty token", false, &hook.ConnectPacket{ClientID: "c1"}, false},
+		{"nil packet", false, nil, false},
+		{"username matches", true, &hook.ConnectPacket{ClientID: "c1", Username: "alice", Password: []byte("reader")}, true},
+		{"sub matches", true, &hook.ConnectPacket{ClientID: "c1", Username: "bob", Password: []byte("writer")}, true},
+		{"username mismatch", true, &hook.ConnectPacket{ClientID: "c1", Username: "mallory", Password: []byte("reader")}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHook(t, func(c *Config) { c.MatchUsername = tt.matchUsername })
+			assert.Equal(t, tt.expected, h.OnConnectAuthenticate(&hook.Client{}, tt.packet))
+		})
+	}
+}
+
+func TestHookOnACLCheck(t *testing.T) {
+	h := newTestHook(t, nil)
+	reader := &hook.Client{ID: "r1", Username: "alice"}
+	writer := &hook.Client{ID: "w1", Username: "bob"}
+
+	require.True(t, h.OnConnectAuthenticate(reader, &hook.ConnectPacket{ClientID: "r1", Password: []byte("reader")}))
+	require.True(t, h.OnConnectAuthenticate(writer, &hook.ConnectPacket{ClientID: "w1", Password: []byte("writer")}))
+
+	tests := []struct {
+		name     string
+		client   *hook.Client
+		topic    string
+		access   hook.AccessType
+		expected bool
+	}{
+		{"read granted", reader, "telemetry/room1/temp", hook.AccessTypeRead, true},
+		{"write not granted", reader, "telemetry/room1/temp", hook.AccessTypeWrite, false},
+		{"read outside scope", reader, "devices/r1/cmd", hook.AccessTypeRead, false},
+		{"write own device", writer, "devices/w1/state", hook.AccessTypeWrite, true},
+		{"readwrite own device", writer, "devices/w1/state", hook.AccessTypeReadWrite, true},
+		{"write other device", writer, "devices/r1/state", hook.AccessTypeWrite, false},
+		{"readwrite needs both in one scope", writer, "telemetry/x", hook.AccessTypeReadWrite, false},
+		{"unknown client", &hook.Client{ID: "x"}, "telemetry/x", hook.AccessTypeRead, false},
+		{"nil client", nil, "telemetry/x", hook.AccessTypeRead, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, h.OnACLCheck(tt.client, tt.topic, tt.access))
+		})
+	}
+}
+
+func TestHookACLNormalize(t *testing.T) {
+	for _, normalize := range []bool{false, true} {
+		h := newTestHook(t, func(c *Config) {
+			c.Scopes["devices:write"] = ACL{Write: []string{"devices/%c/state"}}
+			c.Normalize = topic.NormalizeOptions{TrimTrailingSlash: normalize}
+		})
+		writer := &hook.Client{ID: "w1", Username: "bob"}
+		require.True(t, h.OnConnectAuthenticate(writer, &hook.ConnectPacket{ClientID: "w1", Password: []byte("writer")}))
+
+		assert.Equal(t, normalize, h.OnACLCheck(writer, "devices/w1/state/", hook.AccessTypeWrite))
+		assert.False(t, h.OnACLCheck(writer, "devices/r1/state/", hook.AccessTypeWrite))
+	}
+}
+
+func TestHookACLAfterExpiryAndDisconnect(t *testing.T) {
+	h := newTestHook(t, nil)
+	client := &hook.Client{ID: "r1"}
+	require.True(t, h.OnConnectAuthenticate(client, &hook.ConnectPacket{ClientID: "r1", Password: []byte("reader")}))
+	require.True(t, h.OnACLCheck(client, "telemetry/x", hook.AccessTypeRead))
+
+	now := time.Now().Add(2 * time.Hour)
+	h.introspector.now = func() time.Time { return now }
+	assert.False(t, h.OnACLCheck(client, "telemetry/x", hook.AccessTypeRead))
+
+	h.introspector.now = time.Now
+	require.True(t, h.OnACLCheck(client, "telemetry/x", hook.AccessTypeRead))
+	require.NoError(t, h.OnDisconnect(client, &hook.DisconnectInfo{}))
+	assert.False(t, h.OnACLCheck(client, "telemetry/x", hook.AccessTypeRead))
+}
@@ -0,0 +1,231 @@
+package introspection
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/axmq/ax/auth/credentials"
+)
+
+// DecisionNamespace is the namespace of introspection responses in a shared decision cache
+const DecisionNamespace = "introspection"
+
+// TokenInfo is the RFC 7662 introspection response
+type TokenInfo struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Nbf       int64  `json:"nbf,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Iss       string `json:"iss,omitempty"`
+}
+
+// Scopes returns the space separated scope claim as a slice
+func (t *TokenInfo) Scopes() []string {
+	return strings.Fields(t.Scope)
+}
+
+// Validate checks the active flag and the exp and nbf claims, allowing for clock skew
+func (t *TokenInfo) Validate(now time.Time, skew time.Duration) error {
+	if !t.Active {
+		return ErrTokenInactive
+	}
+	if t.Exp != 0 && now.After(time.Unix(t.Exp, 0).Add(skew)) {
+		return ErrTokenExpired
+	}
+	if t.Nbf != 0 && now.Add(skew).Before(time.Unix(t.Nbf, 0)) {
+		return ErrTokenNotYetValid
+	}
+	return nil
+}
+
+type cacheEntry struct {
+	info    *TokenInfo
+	expires time.Time
+}
+
+// Introspector validates tokens against an introspection endpoint and caches the responses
+// Tokens are cached by their SHA-256 digest so raw tokens are never kept in memory
+type Introspector struct {
+	config *Config
+	client *http.Client
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]cacheEntry
+	now   func() time.Time
+}
+
+// NewIntrospector creates an introspector for config.Endpoint
+func NewIntrospector(config *Config) (*Introspector, error) {
+	if config == nil || config.Endpoint == "" {
+		return nil, ErrNoEndpoint
+	}
+
+	client := config.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: config.Timeout}
+	}
+
+	return &Introspector{
+		config: config,
+		client: client,
+		cache:  make(map[[sha256.Size]byte]cacheEntry),
+		now:    time.Now,
+	}, nil
+}
+
+// Introspect returns the validated token information for token
+func (i *Introspector) Introspect(ctx context.Context, token string) (*TokenInfo, error) {
+	if token == "" {
+		return nil, ErrEmptyToken
+	}
+
+	now := i.now()
+	if i.config.DecisionCache != nil {
+		info, err := i.resolve(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+		if err := info.Validate(now, i.config.ClockSkew); err != nil {
+			return nil, err
+		}
+		return info, nil
+	}
+
+	key := sha256.Sum256([]byte(token))
+
+	i.mu.Lock()
+	entry, ok := i.cache[key]
+	i.mu.Unlock()
+
+	if !ok || !now.Before(entry.expires) {
+		info, err := i.request(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+		entry = cacheEntry{info: info}
+		i.store(key, entry, now)
+	}
+
+	if err := entry.info.Validate(now, i.config.ClockSkew); err != nil {
+		return nil, err
+	}
+	return entry.info, nil
+}
+
+// resolve looks the token up in the shared decision cache, introspecting it when needed
+func (i *Introspector) resolve(ctx context.Context, token string) (*TokenInfo, error) {
+	decision, err := i.config.DecisionCache.Resolve(ctx, DecisionNamespace, "", []byte(token), func(ctx context.Context) (credentials.Decision, error) {
+		info, err := i.request(ctx, token)
+		if err != nil {
+			return credentials.Decision{}, err
+		}
+		data, err := json.Marshal(info)
+		if err != nil {
+			return credentials.Decision{}, err
+		}
+		return credentials.Decision{Allowed: info.Active, Data: data}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var info TokenInfo
+	if err := json.Unmarshal(decision.Data, &info); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrIntrospectionFailed, err)
+	}
+	return &info, nil
+}
+
+func (i *Introspector) request(ctx context.Context, token string) (*TokenInfo, error) {
+	form := url.Values{
+		"token":           {token},
+		"token_type_hint": {"access_token"},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, i.config.Endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrIntrospectionFailed, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if i.config.ClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(i.config.ClientID), url.QueryEscape(i.config.ClientSecret))
+	}
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrIntrospectionFailed, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("%w: status %d", ErrIntrospectionFailed, resp.StatusCode)
+	}
+
+	var info TokenInfo
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&info); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrIntrospectionFailed, err)
+	}
+	return &info, nil
+}
+
+// store caches an introspection response until the earlier of the cache TTL and the token expiry
+func (i *Introspector) store(key [sha256.Size]byte, entry cacheEntry, now time.Time) {
+	ttl := i.config.CacheTTL
+	if !entry.info.Active {
+		ttl = i.config.NegativeCacheTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	entry.expires = now.Add(ttl)
+	if entry.info.Exp != 0 {
+		if exp := time.Unix(entry.info.Exp, 0).Add(i.config.ClockSkew); exp.Before(entry.expires) {
+			entry.expires = exp
+		}
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if _, exists := i.cache[key]; !exists && i.config.MaxCacheEntries > 0 && len(i.cache) >= i.config.MaxCacheEntries {
+		for k, e := range i.cache {
+			if !now.Before(e.expires) {
+				delete(i.cache, k)
+			}
+		}
+		if len(i.cache) >= i.config.MaxCacheEntries {
+			return
+		}
+	}
+	i.cache[key] = entry
+}
+
+// Purge drops all cached introspection responses
+func (i *Introspector) Purge() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.cache = make(map[[sha256.Size]byte]cacheEntry)
+}
+
+// CacheLen returns the number of cached introspection responses
+func (i *Introspector) CacheLen() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return len(i.cache)
+}
@@ -0,0 +1,223 @@
+package introspection
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/axmq/ax/auth/credentials"
+	"github.com/axmq/ax/store"
+)
+
+type testServer struct {
+	*httptest.Server
+	tokens map[string]TokenInfo
+	calls  atomic.Int64
+}
+
+func newTestServer(t *testing.T, tokens map[string]TokenInfo) *testServer {
+	s := &testServer{tokens: tokens}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.calls.Add(1)
+
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "broker" || pass != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodPost || r.FormValue("token_type_hint") != "access_token" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		info := s.tokens[r.FormValue("token")]
+		_ = json.NewEncoder(w).Encode(info)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func testConfig(endpoint string) *Config {
+	config := DefaultConfig(endpoint)
+	config.ClientID = "broker"
+	config.ClientSecret = "s3cret"
+	return config
+}
+
+func TestDefaultConfig(t *testing.T) {
+	config := DefaultConfig("https://idp.example.com/introspect")
+	assert.Equal(t, "https://idp.example.com/introspect", config.Endpoint)
+	assert.Equal(t, 5*time.Second, config.Timeout)
+	assert.Equal(t, 5*time.Minute, config.CacheTTL)
+	assert.Equal(t, 30*time.Second, config.NegativeCacheTTL)
+	assert.Equal(t, 30*time.Second, config.ClockSkew)
+	assert.NotNil(t, config.Scopes)
+}
+
+func TestNewIntrospectorNoEndpoint(t *testing.T) {
+	_, err := NewIntrospector(nil)
+	assert.ErrorIs(t, err, ErrNoEndpoint)
+	_, err = NewIntrospector(&Config{})
+	assert.ErrorIs(t, err, ErrNoEndpoint)
+}
+
+func TestTokenInfoValidate(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+	skew := 30 * time.Second
+
+	tests := []struct {
+		name string
+		info TokenInfo
+		err  error
+	}{
+		{"active", TokenInfo{Active: true}, nil},
+		{"inactive", TokenInfo{}, ErrTokenInactive},
+		{"expired", TokenInfo{Active: true, Exp: now.Add(-time.Minute).Unix()}, ErrTokenExpired},
+		{"expired within skew", TokenInfo{Active: true, Exp: now.Add(-10 * time.Second).Unix()}, nil},
+		{"not yet valid", TokenInfo{Active: true, Nbf: now.Add(time.Minute).Unix()}, ErrTokenNotYetValid},
+		{"not yet valid within skew", TokenInfo{Active: true, Nbf: now.Add(10 * time.Second).Unix()}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.info.Validate(now, skew)
+			if tt.err == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestIntrospect(t *testing.T) {
+	server := newTestServer(t, map[string]TokenInfo{
+		"good": {Active: true, Scope: "read write", Username: "alice", Exp: time.Now().Add(time.Hour).Unix()},
+	})
+
+	i, err := NewIntrospector(testConfig(server.URL))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	info, err := i.Introspect(ctx, "good")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", info.Username)
+	assert.Equal(t, []string{"read", "write"}, info.Scopes())
+
+	_, err = i.Introspect(ctx, "bad")
+	assert.ErrorIs(t, err, ErrTokenInactive)
+
+	_, err = i.Introspect(ctx, "")
+	assert.ErrorIs(t, err, ErrEmptyToken)
+}
+
+func TestIntrospectCache(t *testing.T) {
+	exp := time.Now().Add(time.Hour)
+	server := newTestServer(t, map[string]TokenInfo{
+		"good":  {Active: true, Exp: exp.Unix()},
+		"short": {Active: true, Exp: time.Now().Add(time.Minute).Unix()},
+	})
+
+	i, err := NewIntrospector(testConfig(server.URL))
+	require.NoError(t, err)
+	now := time.Now()
+	i.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	_, err = i.Introspect(ctx, "good")
+	require.NoError(t, err)
+	_, err = i.Introspect(ctx, "good")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), server.calls.Load())
+
+	_, err = i.Introspect(ctx, "bad")
+	assert.ErrorIs(t, err, ErrTokenInactive)
+	_, err = i.Introspect(ctx, "bad")
+	assert.ErrorIs(t, err, ErrTokenInactive)
+	assert.Equal(t, int64(2), server.calls.Load())
+	assert.Equal(t, 2, i.CacheLen())
+
+	// The cache entry of a token never outlives its expiry plus skew
+	_, err = i.Introspect(ctx, "short")
+	require.NoError(t, err)
+	now = now.Add(2 * time.Minute)
+	_, err = i.Introspect(ctx, "short")
+	assert.ErrorIs(t, err, ErrTokenExpired)
+	assert.Equal(t, int64(4), server.calls.Load())
+
+	i.Purge()
+	assert.Equal(t, 0, i.CacheLen())
+}
+
+func TestIntrospectDecisionCache(t *testing.T) {
+	server := newTestServer(t, map[string]TokenInfo{
+		"good": {Active: true, Username: "alice", Exp: time.Now().Add(time.Hour).Unix()},
+	})
+
+	cacheConfig := credentials.DefaultDecisionCacheConfig()
+	cacheConfig.Key = []byte("0123456789abcdef")
+	backend := store.NewMemoryStore[[]byte]()
+	ctx := context.Background()
+
+	// Two broker nodes share one cache
+	nodes := make([]*Introspector, 2)
+	for n := range nodes {
+		cache, err := credentials.NewDecisionCache(backend, cacheConfig)
+		require.NoError(t, err)
+		config := testConfig(server.URL)
+		config.DecisionCache = cache
+		nodes[n], err = NewIntrospector(config)
+		require.NoError(t, err)
+	}
+
+	info, err := nodes[0].Introspect(ctx, "good")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", info.Username)
+	info, err = nodes[1].Introspect(ctx, "good")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", info.Username)
+	assert.Equal(t, int64(1), server.calls.Load())
+	assert.Equal(t, 0, nodes[0].CacheLen())
+
+	_, err = nodes[1].Introspect(ctx, "bad")
+	assert.ErrorIs(t, err, ErrTokenInactive)
+	_, err = nodes[0].Introspect(ctx, "bad")
+	assert.ErrorIs(t, err, ErrTokenInactive)
+	assert.Equal(t, int64(2), server.calls.Load())
+
+	// Cached tokens keep working while the authorization server is down
+	server.Close()
+	_, err = nodes[1].Introspect(ctx, "good")
+	require.NoError(t, err)
+	_, err = nodes[1].Introspect(ctx, "unknown")
+	assert.ErrorIs(t, err, ErrIntrospectionFailed)
+}
+
+func TestIntrospectServerErrors(t *testing.T) {
+	server := newTestServer(t, nil)
+	config := testConfig(server.URL)
+	config.ClientSecret = "wrong"
+
+	i, err := NewIntrospector(config)
+	require.NoError(t, err)
+	_, err = i.Introspect(context.Background(), "good")
+	assert.ErrorIs(t, err, ErrIntrospectionFailed)
+
+	garbage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("not json"))
+	}))
+	defer garbage.Close()
+
+	i, err = NewIntrospector(DefaultConfig(garbage.URL))
+	require.NoError(t, err)
+	_, err = i.Introspect(context.Background(), "good")
+	assert.ErrorIs(t, err, ErrIntrospectionFailed)
+	assert.Equal(t, 0, i.CacheLen())
+}
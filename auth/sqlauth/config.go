@@ -0,0 +1,58 @@
+package sqlauth
+
+import (
+	"time"
+
+	"github.com/axmq/ax/auth/credentials"
+	"github.com/axmq/ax/topic"
+)
+
+// DefaultACLCacheSize bounds the ACL cache when Config.ACLCacheSize is zero
+const DefaultACLCacheSize = 10000
+
+// Config configures the SQL auth hook
+// Queries follow the mosquitto-go-auth conventions so existing schemas can be reused:
+// UserQuery takes the username and returns the password hash,
+// SuperuserQuery takes the username and returns a count greater than zero for superusers,
+// ACLQuery takes the username and the requested access (1 read, 2 write, 3 readwrite) and returns topic patterns
+// in which %u and %c are replaced by the username and client ID
+type Config struct {
+	DriverName string
+	DSN        string
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	QueryTimeout    time.Duration
+
+	UserQuery      string
+	SuperuserQuery string
+	ACLQuery       string
+
+	// Cache caches password verification results, a zero TTL disables it
+	Cache credentials.CacheConfig
+	// ACLCacheTTL caches superuser flags and ACL patterns per user, zero disables it
+	ACLCacheTTL time.Duration
+	// ACLCacheSize bounds the cached entries, the least recently used are evicted first, defaults to
+	// DefaultACLCacheSize
+	ACLCacheSize int
+	// Normalize canonicalizes topics and ACL patterns before they are matched, set it to the options of the
+	// router so a topic cannot slip past an ACL by differing only in form
+	Normalize topic.NormalizeOptions
+}
+
+// DefaultConfig returns a configuration for the mosquitto-go-auth PostgreSQL schema
+func DefaultConfig() *Config {
+	return &Config{
+		DriverName:      "postgres",
+		MaxOpenConns:    10,
+		MaxIdleConns:    5,
+		ConnMaxLifetime: 30 * time.Minute,
+		QueryTimeout:    5 * time.Second,
+		UserQuery:       "SELECT password_hash FROM test_user WHERE username = $1 LIMIT 1",
+		SuperuserQuery:  "SELECT COUNT(*) FROM test_user WHERE username = $1 AND is_admin = true",
+		ACLQuery:        "SELECT topic FROM test_acl WHERE (username = $1) AND rw >= $2",
+		Cache:           credentials.DefaultCacheConfig(),
+		ACLCacheTTL:     time.Minute,
+	}
+}
@@ -0,0 +1,112 @@
+package sqlauth
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// fakeDriver serves canned results for the queries of a test
+type fakeDriver struct {
+	mu      sync.Mutex
+	queries map[string]func(args []driver.NamedValue) ([][]driver.Value, error)
+	calls   atomic.Int64
+}
+
+var (
+	fakeDrivers   sync.Map
+	fakeDriverSeq atomic.Int64
+)
+
+func init() {
+	sql.Register("sqlauth-fake", fakeConnector{})
+}
+
+func newFakeDB(d *fakeDriver) *sql.DB {
+	name := string(rune('a' + fakeDriverSeq.Add(1)))
+	fakeDrivers.Store(name, d)
+	db, _ := sql.Open("sqlauth-fake", name)
+	return db
+}
+
+func (d *fakeDriver) handle(query string, fn func(args []driver.NamedValue) ([][]driver.Value, error)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.queries == nil {
+		d.queries = make(map[string]func(args []driver.NamedValue) ([][]driver.Value, error))
+	}
+	d.queries[query] = fn
+}
+
+type fakeConnector struct{}
+
+func (fakeConnector) Open(name string) (driver.Conn, error) {
+	d, ok := fakeDrivers.Load(name)
+	if !ok {
+		return nil, errors.New("unknown fake database")
+	}
+	return &fakeConn{d: d.(*fakeDriver)}, nil
+}
+
+type fakeConn struct {
+	d *fakeDriver
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	c.d.mu.Lock()
+	fn, ok := c.d.queries[query]
+	c.d.mu.Unlock()
+	if !ok {
+		return nil, errors.New("unexpected query: " + query)
+	}
+	return &fakeStmt{d: c.d, fn: fn}, nil
+}
+
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+type fakeStmt struct {
+	d  *fakeDriver
+	fn func(args []driver.NamedValue) ([][]driver.Value, error)
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("not supported")
+}
+
+func (s *fakeStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	s.d.calls.Add(1)
+	rows, err := s.fn(args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{rows: rows}, nil
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+	pos  int
+}
+
+func (r *fakeRows) Columns() []string { return []string{"value"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.pos])
+	r.pos++
+	return nil
+}
@@ -0,0 +1,8 @@
+package sqlauth
+
+import "errors"
+
+var (
+	ErrNoUserQuery = errors.New("sqlauth: user query is required")
+	ErrNoDB        = errors.New("sqlauth: database is required")
+)
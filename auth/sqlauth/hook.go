@@ -0,0 +1,358 @@
+package sqlauth
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/axmq/ax/auth/credentials"
+	"github.com/axmq/ax/hook"
+	"github.com/axmq/ax/topic"
+)
+
+// Hook authenticates clients and authorizes topic access against SQL tables
+type Hook struct {
+	*hook.Base
+	config *Config
+	db     *sql.DB
+	ownDB  bool
+
+	userStmt      *sql.Stmt
+	superuserStmt *sql.Stmt
+	aclStmt       *sql.Stmt
+
+	store credentials.CredentialStore
+
+	mu       sync.Mutex
+	aclCache map[aclKey]*list.Element
+	aclLRU   *list.List
+	now      func() time.Time
+}
+
+type aclKey struct {
+	username string
+	access   int
+}
+
+type aclEntry struct {
+	superuser bool
+	patterns  []string
+	expires   time.Time
+}
+
+// aclItem is an element of the ACL cache, the front of aclLRU is the most recently used
+type aclItem struct {
+	key   aclKey
+	entry aclEntry
+}
+
+// NewHook opens a connection pool with config.DriverName and config.DSN and creates the hook
+// The driver must be registered by the caller, e.g. by importing it for side effects
+func NewHook(config *Config) (*Hook, error) {
+	if config == nil {
+		config = DefaultConfig()
+	}
+
+	db, err := sql.Open(config.DriverName, config.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	db.SetMaxOpenConns(config.MaxOpenConns)
+	db.SetMaxIdleConns(config.MaxIdleConns)
+	db.SetConnMaxLifetime(config.ConnMaxLifetime)
+
+	h, err := NewHookWithDB(db, config)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	h.ownDB = true
+	return h, nil
+}
+
+// NewHookWithDB creates the hook on an existing connection pool, which is not closed by Stop
+func NewHookWithDB(db *sql.DB, config *Config) (*Hook, error) {
+	if db == nil {
+		return nil, ErrNoDB
+	}
+	if config == nil {
+		config = DefaultConfig()
+	}
+	if config.UserQuery == "" {
+		return nil, ErrNoUserQuery
+	}
+
+	h := &Hook{
+		Base:     hook.NewHookBase("sql-auth"),
+		config:   config,
+		db:       db,
+		aclCache: make(map[aclKey]*list.Element),
+		aclLRU:   list.New(),
+		now:      time.Now,
+	}
+
+	if err := h.prepare(); err != nil {
+		h.closeStatements()
+		return nil, err
+	}
+
+	h.store = &userStore{h: h}
+	if config.Cache.TTL > 0 || config.Cache.NegativeTTL > 0 {
+		h.store = credentials.NewCachedStore(h.store, config.Cache)
+	}
+	return h, nil
+}
+
+func (h *Hook) prepare() error {
+	ctx, cancel := h.context()
+	defer cancel()
+
+	var err error
+	if h.userStmt, err = h.db.PrepareContext(ctx, h.config.UserQuery); err != nil {
+		return fmt.Errorf("failed to prepare user query: %w", err)
+	}
+	if h.config.SuperuserQuery != "" {
+		if h.superuserStmt, err = h.db.PrepareContext(ctx, h.config.SuperuserQuery); err != nil {
+			return fmt.Errorf("failed to prepare superuser query: %w", err)
+		}
+	}
+	if h.config.ACLQuery != "" {
+		if h.aclStmt, err = h.db.PrepareContext(ctx, h.config.ACLQuery); err != nil {
+			return fmt.Errorf("failed to prepare acl query: %w", err)
+		}
+	}
+	return nil
+}
+
+func (h *Hook) context() (context.Context, context.CancelFunc) {
+	if h.config.QueryTimeout > 0 {
+		return context.WithTimeout(context.Background(), h.config.QueryTimeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// Provides indicates this hook provides authentication and, when an ACL query is configured, authorization
+func (h *Hook) Provides(event hook.Event) bool {
+	switch event {
+	case hook.OnConnectAuthenticate:
+		return true
+	case hook.OnACLCheck:
+		return h.aclStmt != nil
+	default:
+		return false
+	}
+}
+
+// Stop closes the prepared statements and the connection pool if the hook opened it
+func (h *Hook) Stop() error {
+	h.closeStatements()
+	if h.ownDB {
+		return h.db.Close()
+	}
+	return nil
+}
+
+func (h *Hook) closeStatements() {
+	for _, stmt := range []*sql.Stmt{h.userStmt, h.superuserStmt, h.aclStmt} {
+		if stmt != nil {
+			_ = stmt.Close()
+		}
+	}
+}
+
+// Store returns the credential store backed by the user query
+func (h *Hook) Store() credentials.CredentialStore {
+	return h.store
+}
+
+// OnConnectAuthenticate verifies the CONNECT username and password against the user table
+func (h *Hook) OnConnectAuthenticate(client *hook.Client, packet *hook.ConnectPacket) bool {
+	if packet == nil || packet.Username == "" {
+		return false
+	}
+
+	ctx, cancel := h.context()
+	defer cancel()
+	return h.store.Verify(ctx, packet.Username, packet.Password) == nil
+}
+
+// OnACLCheck allows superusers everything and otherwise matches the topic against the user's ACL patterns
+// Database errors deny access
+func (h *Hook) OnACLCheck(client *hook.Client, topicName string, access hook.AccessType) bool {
+	if client == nil || client.Username == "" || h.aclStmt == nil {
+		return false
+	}
+
+	entry, err := h.acl(client.Username, aclAccess(access))
+	if err != nil {
+		return false
+	}
+	if entry.superuser {
+		return true
+	}
+
+	replacer := strings.NewReplacer("%u", client.Username, "%c", client.ID)
+	for _, pattern := range entry.patterns {
+		if topic.MatchNormalized(replacer.Replace(pattern), topicName, h.config.Normalize) {
+			return true
+		}
+	}
+	return false
+}
+
+// InvalidateUser drops cached credentials and ACLs of a user after it changed in the database
+func (h *Hook) InvalidateUser(username string) {
+	if cached, ok := h.store.(*credentials.CachedStore); ok {
+		cached.Invalidate(username)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for key, elem := range h.aclCache {
+		if key.username == username {
+			h.aclLRU.Remove(elem)
+			delete(h.aclCache, key)
+		}
+	}
+}
+
+func (h *Hook) acl(username string, access int) (aclEntry, error) {
+	key := aclKey{username: username, access: access}
+	now := h.now()
+
+	h.mu.Lock()
+	var entry aclEntry
+	elem, ok := h.aclCache[key]
+	if ok {
+		entry = elem.Value.(*aclItem).entry
+		h.aclLRU.MoveToFront(elem)
+	}
+	h.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry, nil
+	}
+
+	ctx, cancel := h.context()
+	defer cancel()
+
+	superuser, err := h.isSuperuser(ctx, username)
+	if err != nil {
+		return aclEntry{}, err
+	}
+	entry = aclEntry{superuser: superuser}
+
+	if !superuser {
+		if entry.patterns, err = h.queryPatterns(ctx, username, access); err != nil {
+			return aclEntry{}, err
+		}
+	}
+
+	if h.config.ACLCacheTTL > 0 {
+		entry.expires = now.Add(h.config.ACLCacheTTL)
+		h.cacheACL(key, entry)
+	}
+	return entry, nil
+}
+
+// cacheACL stores an entry, evicting the least recently used entries beyond ACLCacheSize
+func (h *Hook) cacheACL(key aclKey, entry aclEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if elem, ok := h.aclCache[key]; ok {
+		elem.Value.(*aclItem).entry = entry
+		h.aclLRU.MoveToFront(elem)
+		return
+	}
+	h.aclCache[key] = h.aclLRU.PushFront(&aclItem{key: key, entry: entry})
+
+	size := h.config.ACLCacheSize
+	if size <= 0 {
+		size = DefaultACLCacheSize
+	}
+	for h.aclLRU.Len() > size {
+		oldest := h.aclLRU.Back()
+		h.aclLRU.Remove(oldest)
+		delete(h.aclCache, oldest.Value.(*aclItem).key)
+	}
+}
+
+func (h *Hook) isSuperuser(ctx context.Context, username string) (bool, error) {
+	if h.superuserStmt == nil {
+		return false, nil
+	}
+
+	var count int
+	if err := h.superuserStmt.QueryRowContext(ctx, username).Scan(&count); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (h *Hook) queryPatterns(ctx context.Context, username string, access int) ([]string, error) {
+	rows, err := h.aclStmt.QueryContext(ctx, username, access)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	patterns := make([]string, 0)
+	for rows.Next() {
+		var pattern string
+		if err := rows.Scan(&pattern); err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, rows.Err()
+}
+
+// aclAccess maps an access type to the mosquitto-go-auth rw column value
+func aclAccess(access hook.AccessType) int {
+	switch access {
+	case hook.AccessTypeRead:
+		return 1
+	case hook.AccessTypeWrite:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// userStore implements credentials.CredentialStore on top of the user and superuser queries
+type userStore struct {
+	h *Hook
+}
+
+func (s *userStore) Lookup(ctx context.Context, username string) (*credentials.Credential, error) {
+	var passwordHash string
+	if err := s.h.userStmt.QueryRowContext(ctx, username).Scan(&passwordHash); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, credentials.ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	superuser, err := s.h.isSuperuser(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	return &credentials.Credential{
+		Username:     username,
+		PasswordHash: passwordHash,
+		Superuser:    superuser,
+	}, nil
+}
+
+func (s *userStore) Verify(ctx context.Context, username string, password []byte) error {
+	return credentials.VerifyWithLookup(ctx, s.Lookup, username, password)
+}
@@ -0,0 +1,235 @@
+package sqlauth
+
+import (
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/axmq/ax/auth/credentials"
+	"github.com/axmq/ax/hook"
+	"github.com/axmq/ax/topic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testUser struct {
+	hash  string
+	admin bool
+	acls  map[int][]string
+}
+
+func newTestHook(t *testing.T, config *Config) (*Hook, *fakeDriver) {
+	encoded, err := (&credentials.PBKDF2Hasher{Digest: "sha256", Iterations: 10, SaltSize: 8, KeySize: 32}).Hash([]byte("secret"))
+	require.NoError(t, err)
+
+	users := map[string]testUser{
+		"alice": {hash: encoded, acls: map[int][]string{
+			1: {"sensors/#", "devices/%c/cmd", "users/%u/inbox"},
+			2: {"sensors/alice/+"},
+			3: {"sensors/alice/+"},
+		}},
+		"root": {hash: encoded, admin: true},
+	}
+
+	d := &fakeDriver{}
+	d.handle(config.UserQuery, func(args []driver.NamedValue) ([][]driver.Value, error) {
+		u, ok := users[args[0].Value.(string)]
+		if !ok {
+			return nil, nil
+		}
+		return [][]driver.Value{{u.hash}}, nil
+	})
+	d.handle(config.SuperuserQuery, func(args []driver.NamedValue) ([][]driver.Value, error) {
+		if users[args[0].Value.(string)].admin {
+			return [][]driver.Value{{int64(1)}}, nil
+		}
+		return [][]driver.Value{{int64(0)}}, nil
+	})
+	d.handle(config.ACLQuery, func(args []driver.NamedValue) ([][]driver.Value, error) {
+		u := users[args[0].Value.(string)]
+		rows := make([][]driver.Value, 0)
+		for _, pattern := range u.acls[int(args[1].Value.(int64))] {
+			rows = append(rows, []driver.Value{pattern})
+		}
+		return rows, nil
+	})
+
+	h, err := NewHookWithDB(newFakeDB(d), config)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = h.Stop() })
+	return h, d
+}
+
+func TestDefaultConfig(t *testing.T) {
+	config := DefaultConfig()
+	assert.Equal(t, "postgres", config.DriverName)
+	assert.Equal(t, 10, config.MaxOpenConns)
+	assert.Equal(t, 5*time.Second, config.QueryTimeout)
+	assert.Contains(t, config.UserQuery, "password_hash")
+	assert.Contains(t, config.SuperuserQuery, "is_admin")
+	assert.Contains(t, config.ACLQuery, "rw >= $2")
+	assert.Equal(t, time.Minute, config.ACLCacheTTL)
+}
+
+func TestNewHookWithDBErrors(t *testing.T) {
+	_, err := NewHookWithDB(nil, DefaultConfig())
+	assert.ErrorIs(t, err, ErrNoDB)
+
+	config := DefaultConfig()
+	config.UserQuery = ""
+	_, err = NewHookWithDB(newFakeDB(&fakeDriver{}), config)
+	assert.ErrorIs(t, err, ErrNoUserQuery)
+
+	_, err = NewHookWithDB(newFakeDB(&fakeDriver{}), DefaultConfig())
+	assert.Error(t, err)
+}
+
+func TestHookProvides(t *testing.T) {
+	h, _ := newTestHook(t, DefaultConfig())
+	assert.Equal(t, "sql-auth", h.ID())
+	assert.True(t, h.Provides(hook.OnConnectAuthenticate))
+	assert.True(t, h.Provides(hook.OnACLCheck))
+	assert.False(t, h.Provides(hook.OnPublish))
+
+	config := DefaultConfig()
+	config.ACLQuery = ""
+	h, _ = newTestHook(t, config)
+	assert.False(t, h.Provides(hook.OnACLCheck))
+}
+
+func TestHookOnConnectAuthenticate(t *testing.T) {
+	h, _ := newTestHook(t, DefaultConfig())
+
+	tests := []struct {
+		name     string
+		packet   *hook.ConnectPacket
+		expected bool
+	}{
+		{"valid", &hook.ConnectPacket{Username: "alice", Password: []byte("secret")}, true},
+		{"wrong password", &hook.ConnectPacket{Username: "alice", Password: []byte("wrong")}, false},
+		{"unknown user", &hook.ConnectPacket{Username: "bob", Password: []byte("secret")}, false},
+		{"anonymous", &hook.ConnectPacket{}, false},
+		{"nil packet", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, h.OnConnectAuthenticate(&hook.Client{}, tt.packet))
+		})
+	}
+}
+
+func TestHookAuthenticateCache(t *testing.T) {
+	h, d := newTestHook(t, DefaultConfig())
+	packet := &hook.ConnectPacket{Username: "alice", Password: []byte("secret")}
+
+	require.True(t, h.OnConnectAuthenticate(nil, packet))
+	calls := d.calls.Load()
+	require.True(t, h.OnConnectAuthenticate(nil, packet))
+	assert.Equal(t, calls, d.calls.Load())
+
+	h.InvalidateUser("alice")
+	require.True(t, h.OnConnectAuthenticate(nil, packet))
+	assert.Greater(t, d.calls.Load(), calls)
+}
+
+func TestHookOnACLCheck(t *testing.T) {
+	h, _ := newTestHook(t, DefaultConfig())
+	alice := &hook.Client{ID: "dev1", Username: "alice"}
+	root := &hook.Client{ID: "admin", Username: "root"}
+
+	tests := []struct {
+		name     string
+		client   *hook.Client
+		topic    string
+		access   hook.AccessType
+		expected bool
+	}{
+		{"read wildcard", alice, "sensors/bob/temp", hook.AccessTypeRead, true},
+		{"read client id placeholder", alice, "devices/dev1/cmd", hook.AccessTypeRead, true},
+		{"read other client id", alice, "devices/dev2/cmd", hook.AccessTypeRead, false},
+		{"read username placeholder", alice, "users/alice/inbox", hook.AccessTypeRead, true},
+		{"write own", alice, "sensors/alice/temp", hook.AccessTypeWrite, true},
+		{"write other", alice, "sensors/bob/temp", hook.AccessTypeWrite, false},
+		{"readwrite", alice, "sensors/alice/temp", hook.AccessTypeReadWrite, true},
+		{"superuser", root, "anything/at/all", hook.AccessTypeWrite, true},
+		{"unknown user", &hook.Client{Username: "bob"}, "sensors/bob/temp", hook.AccessTypeRead, false},
+		{"anonymous", &hook.Client{}, "sensors/bob/temp", hook.AccessTypeRead, false},
+		{"nil client", nil, "sensors/bob/temp", hook.AccessTypeRead, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, h.OnACLCheck(tt.client, tt.topic, tt.access))
+		})
+	}
+}
+
+func TestHookACLNormalize(t *testing.T) {
+	alice := &hook.Client{ID: "dev1", Username: "alice"}
+
+	h, _ := newTestHook(t, DefaultConfig())
+	assert.False(t, h.OnACLCheck(alice, "devices/dev1/cmd/", hook.AccessTypeRead))
+
+	config := DefaultConfig()
+	config.Normalize = topic.NormalizeOptions{TrimTrailingSlash: true}
+	h, _ = newTestHook(t, config)
+	assert.True(t, h.OnACLCheck(alice, "devices/dev1/cmd/", hook.AccessTypeRead))
+	assert.False(t, h.OnACLCheck(alice, "devices/dev2/cmd/", hook.AccessTypeRead))
+}
+
+func TestHookACLCache(t *testing.T) {
+	h, d := newTestHook(t, DefaultConfig())
+	now := time.Now()
+	h.now = func() time.Time { return now }
+	alice := &hook.Client{ID: "dev1", Username: "alice"}
+
+	require.True(t, h.OnACLCheck(alice, "sensors/x", hook.AccessTypeRead))
+	calls := d.calls.Load()
+	require.True(t, h.OnACLCheck(alice, "sensors/y", hook.AccessTypeRead))
+	assert.Equal(t, calls, d.calls.Load())
+
+	now = now.Add(2 * time.Minute)
+	require.True(t, h.OnACLCheck(alice, "sensors/y", hook.AccessTypeRead))
+	assert.Greater(t, d.calls.Load(), calls)
+}
+
+func TestHookACLCacheSize(t *testing.T) {
+	config := DefaultConfig()
+	config.ACLCacheSize = 2
+	h, d := newTestHook(t, config)
+	alice := &hook.Client{ID: "dev1", Username: "alice"}
+
+	cached := func(access hook.AccessType) bool {
+		calls := d.calls.Load()
+		require.True(t, h.OnACLCheck(alice, "sensors/alice/x", access))
+		return d.calls.Load() == calls
+	}
+
+	assert.False(t, cached(hook.AccessTypeRead))
+	assert.False(t, cached(hook.AccessTypeWrite))
+	assert.True(t, cached(hook.AccessTypeRead))
+
+	// Write is the least recently used entry
+	assert.False(t, cached(hook.AccessTypeReadWrite))
+	assert.True(t, cached(hook.AccessTypeRead))
+	assert.False(t, cached(hook.AccessTypeWrite))
+	assert.Equal(t, 2, h.aclLRU.Len())
+	assert.Len(t, h.aclCache, 2)
+}
+
+func TestHookACLDatabaseErrorDenies(t *testing.T) {
+	config := DefaultConfig()
+	h, d := newTestHook(t, config)
+	// Statements are already prepared, so re-prepare after swapping the handler
+	h.superuserStmt.Close()
+	d.handle(config.SuperuserQuery, func(args []driver.NamedValue) ([][]driver.Value, error) {
+		return nil, errors.New("connection refused")
+	})
+	var err error
+	h.superuserStmt, err = h.db.Prepare(config.SuperuserQuery)
+	require.NoError(t, err)
+
+	assert.False(t, h.OnACLCheck(&hook.Client{Username: "alice"}, "sensors/x", hook.AccessTypeRead))
+}
@@ -0,0 +1,166 @@
+package bridge
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/hook"
+)
+
+// DefaultIDProperty is the user property bridges set to carry a stable message ID
+const DefaultIDProperty = "bridge-msg-id"
+
+// DedupConfig configures duplicate suppression for bridged traffic
+type DedupConfig struct {
+	// Window is how long a message is remembered
+	Window time.Duration
+	// MaxEntries bounds memory, the oldest entries are evicted first
+	MaxEntries int
+	// IDProperty names the user property holding a bridge-supplied message ID
+	// Messages without it are keyed on a hash of topic and payload
+	IDProperty string
+}
+
+// DefaultDedupConfig returns the default deduplication configuration
+func DefaultDedupConfig() *DedupConfig {
+	return &DedupConfig{
+		Window:     5 * time.Minute,
+		MaxEntries: 100000,
+		IDProperty: DefaultIDProperty,
+	}
+}
+
+// dedupKey identifies a message by bridge ID or content hash
+type dedupKey struct {
+	id   string
+	hash uint64
+}
+
+type dedupEntry struct {
+	key  dedupKey
+	seen time.Time
+}
+
+// Deduplicator remembers recently seen messages within a bounded window
+type Deduplicator struct {
+	config *DedupConfig
+
+	mu      sync.Mutex
+	entries map[dedupKey]time.Time
+	order   []dedupEntry // insertion order, oldest first
+	head    int
+
+	now func() time.Time
+}
+
+// NewDeduplicator creates a deduplicator
+func NewDeduplicator(config *DedupConfig) *Deduplicator {
+	if config == nil {
+		config = DefaultDedupConfig()
+	}
+	return &Deduplicator{
+		config:  config,
+		entries: make(map[dedupKey]time.Time),
+		now:     time.Now,
+	}
+}
+
+// Check records the message and reports whether it was already seen within the window
+func (d *Deduplicator) Check(topic string, payload []byte, properties hook.Properties) bool {
+	return d.seen(d.key(topic, payload, properties))
+}
+
+// Len returns the number of remembered messages
+func (d *Deduplicator) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.entries)
+}
+
+func (d *Deduplicator) seen(key dedupKey) bool {
+	now := d.now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.evict(now)
+
+	if at, ok := d.entries[key]; ok && now.Sub(at) < d.config.Window {
+		return true
+	}
+
+	d.entries[key] = now
+	d.order = append(d.order, dedupEntry{key: key, seen: now})
+
+	if d.config.MaxEntries > 0 {
+		for len(d.entries) > d.config.MaxEntries {
+			d.popOldest()
+		}
+	}
+	return false
+}
+
+// evict drops entries older than the window (must be called with lock held)
+func (d *Deduplicator) evict(now time.Time) {
+	for d.head < len(d.order) && now.Sub(d.order[d.head].seen) >= d.config.Window {
+		d.popOldest()
+	}
+}
+
+// popOldest removes the oldest entry (must be called with lock held)
+func (d *Deduplicator) popOldest() {
+	if d.head >= len(d.order) {
+		return
+	}
+
+	e := d.order[d.head]
+	d.order[d.head] = dedupEntry{}
+	d.head++
+
+	// A key re-seen after expiry has a newer entry further back in the queue
+	if at, ok := d.entries[e.key]; ok && at.Equal(e.seen) {
+		delete(d.entries, e.key)
+	}
+
+	if d.head > len(d.order)/2 {
+		d.order = append(d.order[:0], d.order[d.head:]...)
+		d.head = 0
+	}
+}
+
+func (d *Deduplicator) key(topic string, payload []byte, properties hook.Properties) dedupKey {
+	if id := messageID(properties, d.config.IDProperty); id != "" {
+		return dedupKey{id: id}
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(topic))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write(payload)
+	return dedupKey{hash: h.Sum64()}
+}
+
+// messageID extracts the bridge message ID from publish properties
+func messageID(properties hook.Properties, name string) string {
+	if name == "" || properties == nil {
+		return ""
+	}
+
+	if id, ok := properties[name].(string); ok {
+		return id
+	}
+
+	switch props := properties["UserProperty"].(type) {
+	case []encoding.UTF8Pair:
+		for _, p := range props {
+			if p.Key == name {
+				return p.Value
+			}
+		}
+	case map[string]string:
+		return props[name]
+	}
+	return ""
+}
@@ -0,0 +1,113 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/hook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDeduplicator(config *DedupConfig) (*Deduplicator, *time.Time) {
+	d := NewDeduplicator(config)
+	now := time.Unix(1700000000, 0)
+	d.now = func() time.Time { return now }
+	return d, &now
+}
+
+func TestDeduplicatorKeys(t *testing.T) {
+	tests := []struct {
+		name       string
+		first      hook.Properties
+		second     hook.Properties
+		payload2   string
+		duplicated bool
+	}{
+		{name: "same content", duplicated: true},
+		{name: "different content", payload2: "other", duplicated: false},
+		{
+			name:       "same id different content",
+			first:      hook.Properties{DefaultIDProperty: "m1"},
+			second:     hook.Properties{DefaultIDProperty: "m1"},
+			payload2:   "other",
+			duplicated: true,
+		},
+		{
+			name:       "different id same content",
+			first:      hook.Properties{DefaultIDProperty: "m1"},
+			second:     hook.Properties{DefaultIDProperty: "m2"},
+			duplicated: false,
+		},
+		{
+			name:       "id from user property pairs",
+			first:      hook.Properties{"UserProperty": []encoding.UTF8Pair{{Key: DefaultIDProperty, Value: "m1"}}},
+			second:     hook.Properties{"UserProperty": map[string]string{DefaultIDProperty: "m1"}},
+			payload2:   "other",
+			duplicated: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, _ := newTestDeduplicator(nil)
+			payload2 := "payload"
+			if tt.payload2 != "" {
+				payload2 = tt.payload2
+			}
+
+			assert.False(t, d.Check("a/b", []byte("payload"), tt.first))
+			assert.Equal(t, tt.duplicated, d.Check("a/b", []byte(payload2), tt.second))
+		})
+	}
+}
+
+func TestDeduplicatorWindow(t *testing.T) {
+	d, now := newTestDeduplicator(&DedupConfig{Window: time.Minute, MaxEntries: 10})
+
+	assert.False(t, d.Check("a", []byte("x"), nil))
+	*now = now.Add(30 * time.Second)
+	assert.True(t, d.Check("a", []byte("x"), nil))
+
+	*now = now.Add(31 * time.Second)
+	assert.False(t, d.Check("a", []byte("x"), nil))
+	assert.Equal(t, 1, d.Len())
+
+	*now = now.Add(2 * time.Minute)
+	assert.False(t, d.Check("b", []byte("y"), nil))
+	assert.Equal(t, 1, d.Len())
+}
+
+func TestDeduplicatorMaxEntries(t *testing.T) {
+	d, _ := newTestDeduplicator(&DedupConfig{Window: time.Hour, MaxEntries: 3})
+
+	for _, topic := range []string{"a", "b", "c", "d"} {
+		require.False(t, d.Check(topic, nil, nil))
+	}
+	assert.Equal(t, 3, d.Len())
+
+	// Oldest entry was evicted, newer ones are still remembered
+	assert.False(t, d.Check("a", nil, nil))
+	assert.True(t, d.Check("d", nil, nil))
+}
+
+func TestDedupHook(t *testing.T) {
+	h := NewDedupHook(nil)
+	assert.Equal(t, "bridge-dedup", h.ID())
+	assert.True(t, h.Provides(hook.OnPublish))
+	assert.False(t, h.Provides(hook.OnConnect))
+
+	m := hook.NewManager()
+	require.NoError(t, m.Add(h))
+	require.NoError(t, h.SetFilter(hook.OnPublish, &hook.EventFilter{ClientIDs: []string{"bridge-*"}}))
+
+	packet := &hook.PublishPacket{Topic: "a/b", Payload: []byte("x")}
+	assert.NoError(t, m.OnPublish(&hook.Client{ID: "bridge-east"}, packet))
+	assert.ErrorIs(t, m.OnPublish(&hook.Client{ID: "bridge-west"}, packet), ErrDuplicateMessage)
+
+	// Non-bridge clients are not deduplicated
+	assert.NoError(t, m.OnPublish(&hook.Client{ID: "device-1"}, packet))
+	assert.NoError(t, h.OnPublish(nil, nil))
+	assert.Equal(t, 1, h.Deduplicator().Len())
+}
@@ -0,0 +1,5 @@
+package bridge
+
+import "errors"
+
+var ErrDuplicateMessage = errors.New("duplicate bridged message")
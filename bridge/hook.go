@@ -0,0 +1,41 @@
+package bridge
+
+import (
+	"github.com/axmq/ax/hook"
+)
+
+// DedupHook rejects publishes already delivered by another bridge or federated link
+// Use SetFilter to restrict it to the client IDs of bridge connections
+type DedupHook struct {
+	*hook.Base
+	dedup *Deduplicator
+}
+
+// NewDedupHook creates a duplicate suppression hook
+func NewDedupHook(config *DedupConfig) *DedupHook {
+	return &DedupHook{
+		Base:  hook.NewHookBase("bridge-dedup"),
+		dedup: NewDeduplicator(config),
+	}
+}
+
+// Provides indicates this hook inspects publishes
+func (h *DedupHook) Provides(event hook.Event) bool {
+	return event == hook.OnPublish
+}
+
+// OnPublish returns ErrDuplicateMessage for messages seen within the dedup window
+func (h *DedupHook) OnPublish(_ *hook.Client, packet *hook.PublishPacket) error {
+	if packet == nil {
+		return nil
+	}
+	if h.dedup.Check(packet.Topic, packet.Payload, packet.Properties) {
+		return ErrDuplicateMessage
+	}
+	return nil
+}
+
+// Deduplicator returns the underlying deduplicator
+func (h *DedupHook) Deduplicator() *Deduplicator {
+	return h.dedup
+}
@@ -0,0 +1,35 @@
+package broadcast
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/axmq/ax/admin"
+)
+
+// ReportHandler serves the rollout reports to the admin API behind ManagerConfig.Guard, which requires
+// admin.PermissionMetricsRead. Strip its mount prefix:
+//
+//	GET /       lists the reports of every retained rollout, newest first
+//	GET /{id}   returns the report of one rollout, 404 Not Found for an unknown or pruned rollout
+func (m *Manager) ReportHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, m.Reports())
+	})
+	mux.HandleFunc("GET /{id}", func(w http.ResponseWriter, r *http.Request) {
+		report, err := m.Report(r.PathValue("id"))
+		if errors.Is(err, ErrRolloutNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, report)
+	})
+	return m.config.Guard.Require(admin.PermissionMetricsRead, mux)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
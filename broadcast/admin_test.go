@@ -0,0 +1,96 @@
+package broadcast
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/axmq/ax/admin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManagerReportHandler(t *testing.T) {
+	m := NewManager(ManagerConfig{Sender: newFakeSender("dev-2")})
+	id, err := m.Broadcast(context.Background(), &Command{Topic: "cmd/reboot", Target: Target{ClientIDs: []string{"dev-1", "dev-2"}}})
+	require.NoError(t, err)
+
+	server := httptest.NewServer(http.StripPrefix("/broadcasts", m.ReportHandler()))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/broadcasts/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	var reports []Report
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&reports))
+	require.Len(t, reports, 1)
+	assert.Equal(t, id, reports[0].ID)
+
+	resp, err = http.Get(server.URL + "/broadcasts/" + id)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var report struct {
+		ID      string         `json:"id"`
+		Counts  map[string]int `json:"counts"`
+		Clients []struct {
+			ClientID string `json:"client_id"`
+			Status   string `json:"status"`
+		} `json:"clients"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+	assert.Equal(t, id, report.ID)
+	assert.Equal(t, map[string]int{"pending": 1, "failed": 1}, report.Counts)
+	require.Len(t, report.Clients, 2)
+	assert.Equal(t, "dev-2", report.Clients[1].ClientID)
+	assert.Equal(t, "failed", report.Clients[1].Status)
+
+	resp, err = http.Get(server.URL + "/broadcasts/unknown")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, err = http.Post(server.URL+"/broadcasts/", "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestManagerGuard(t *testing.T) {
+	guard, err := admin.NewGuard(admin.GuardConfig{Authenticator: admin.NewTokenAuthenticator(
+		admin.Token{Name: "grafana", Roles: []string{admin.RoleViewer}, Secret: "view"},
+	)})
+	require.NoError(t, err)
+	m := NewManager(ManagerConfig{Sender: newFakeSender(), Guard: guard})
+	cmd := &Command{Topic: "cmd/reboot", Target: Target{ClientIDs: []string{"dev-1"}}}
+
+	_, err = m.Broadcast(context.Background(), cmd)
+	assert.ErrorIs(t, err, admin.ErrNoCredentials)
+	viewer := admin.WithPrincipal(context.Background(), &admin.Principal{Name: "grafana", Roles: []string{admin.RoleViewer}})
+	_, err = m.Broadcast(viewer, cmd)
+	assert.ErrorIs(t, err, admin.ErrForbidden)
+	assert.Empty(t, m.Reports())
+
+	id, err := m.Broadcast(admin.WithPrincipal(context.Background(), &admin.Principal{Name: "ops", Roles: []string{admin.RoleAdmin}}), cmd)
+	require.NoError(t, err)
+	_, err = m.Wait(context.Background(), id)
+	assert.ErrorIs(t, err, admin.ErrNoCredentials)
+
+	server := httptest.NewServer(m.ReportHandler())
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/" + id)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/"+id, nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer view")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
@@ -0,0 +1,10 @@
+package broadcast
+
+import "errors"
+
+var (
+	ErrEmptyTarget     = errors.New("broadcast target selects no clients")
+	ErrInvalidTopic    = errors.New("invalid broadcast topic")
+	ErrRolloutNotFound = errors.New("broadcast rollout not found")
+	ErrNoResolver      = errors.New("broadcast by topic requires a subscriber resolver")
+)
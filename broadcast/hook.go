@@ -0,0 +1,39 @@
+package broadcast
+
+import (
+	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/hook"
+)
+
+// Hook feeds QoS 1 acknowledgments and drops into the broadcast manager
+type Hook struct {
+	*hook.Base
+	manager *Manager
+}
+
+// NewHook creates a hook that reports acknowledgments to the manager
+func NewHook(manager *Manager) *Hook {
+	return &Hook{
+		Base:    hook.NewHookBase("broadcast"),
+		manager: manager,
+	}
+}
+
+// Provides indicates this hook handles QoS completion and drops
+func (h *Hook) Provides(event hook.Event) bool {
+	return event == hook.OnQosComplete || event == hook.OnQosDropped
+}
+
+// OnQosComplete records the PUBACK of a broadcast command
+func (h *Hook) OnQosComplete(client *hook.Client, packetID uint16, packetType encoding.PacketType) error {
+	if packetType == encoding.PUBACK {
+		h.manager.Ack(client.GetID(), packetID)
+	}
+	return nil
+}
+
+// OnQosDropped records that a broadcast command was dropped before it was acknowledged
+func (h *Hook) OnQosDropped(client *hook.Client, packetID uint16, reason hook.DropReason) error {
+	h.manager.Drop(client.GetID(), packetID, reason.String())
+	return nil
+}
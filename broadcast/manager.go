@@ -0,0 +1,359 @@
+// Package broadcast publishes a command to a group of clients at QoS 1 and aggregates their
+// acknowledgments into a completion report, for fleet-wide rollouts with per-client visibility
+package broadcast
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/axmq/ax/admin"
+	"github.com/axmq/ax/hook"
+	"github.com/axmq/ax/topic"
+)
+
+// Sender delivers a command to one connected client at QoS 1, it is implemented by the broker
+type Sender interface {
+	// Send queues packet for clientID and returns the packet identifier of the outbound PUBLISH
+	Send(ctx context.Context, clientID string, packet *hook.PublishPacket) (uint16, error)
+}
+
+// SubscriberResolver returns the IDs of the clients subscribed to a topic
+type SubscriberResolver func(topicName string) []string
+
+// Target selects the clients a command is sent to, the union of both criteria is used
+type Target struct {
+	ClientIDs []string
+	// Topic selects the clients subscribed to this topic name
+	Topic string
+}
+
+// Command is a message broadcast to a group of clients
+type Command struct {
+	Topic      string
+	Payload    []byte
+	Properties hook.Properties
+	Target     Target
+	// Timeout bounds how long acknowledgments are awaited, zero uses the manager default
+	Timeout time.Duration
+}
+
+// ManagerConfig configures the broadcast manager
+type ManagerConfig struct {
+	Sender   Sender
+	Resolver SubscriberResolver
+	// DefaultTimeout applies to commands without a timeout
+	DefaultTimeout time.Duration
+	// Retention is how long finished reports are kept for the admin API
+	Retention time.Duration
+	// Guard checks the principal of the context passed to Broadcast and Wait and of report requests,
+	// nil allows every caller
+	Guard *admin.Guard
+}
+
+func DefaultManagerConfig() ManagerConfig {
+	return ManagerConfig{
+		DefaultTimeout: time.Minute,
+		Retention:      24 * time.Hour,
+	}
+}
+
+// inflightKey identifies an outbound PUBLISH awaiting its PUBACK
+type inflightKey struct {
+	clientID string
+	packetID uint16
+}
+
+// settlement is an acknowledgment or drop that arrived while its PUBLISH was still being sent
+type settlement struct {
+	status Status
+	reason string
+}
+
+type rollout struct {
+	id        string
+	topic     string
+	created   time.Time
+	deadline  time.Time
+	completed time.Time
+	results   map[string]*ClientResult
+	pending   int
+	done      chan struct{}
+	timer     *time.Timer
+}
+
+// Manager runs broadcasts and tracks their acknowledgments
+type Manager struct {
+	config ManagerConfig
+	now    func() time.Time
+
+	mu       sync.Mutex
+	rollouts map[string]*rollout
+	inflight map[inflightKey]*rollout
+	sending  map[string]int             // client ID -> sends in progress
+	early    map[inflightKey]settlement // settlements that raced ahead of Send returning
+}
+
+// NewManager creates a broadcast manager
+func NewManager(config ManagerConfig) *Manager {
+	defaults := DefaultManagerConfig()
+	if config.DefaultTimeout <= 0 {
+		config.DefaultTimeout = defaults.DefaultTimeout
+	}
+	if config.Retention <= 0 {
+		config.Retention = defaults.Retention
+	}
+	return &Manager{
+		config:   config,
+		now:      time.Now,
+		rollouts: make(map[string]*rollout),
+		inflight: make(map[inflightKey]*rollout),
+		sending:  make(map[string]int),
+		early:    make(map[inflightKey]settlement),
+	}
+}
+
+// Broadcast sends cmd to every targeted client and returns the rollout ID
+// Clients that cannot be reached are reported as failed, the rest are pending until they acknowledge
+// It needs admin.PermissionConfigWrite
+func (m *Manager) Broadcast(ctx context.Context, cmd *Command) (string, error) {
+	if err := m.config.Guard.Authorize(ctx, admin.PermissionConfigWrite); err != nil {
+		return "", err
+	}
+	if err := topic.ValidateTopic(cmd.Topic); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidTopic, err)
+	}
+	clients, err := m.resolve(cmd.Target)
+	if err != nil {
+		return "", err
+	}
+
+	timeout := cmd.Timeout
+	if timeout <= 0 {
+		timeout = m.config.DefaultTimeout
+	}
+
+	now := m.now()
+	r := &rollout{
+		id:       newRolloutID(),
+		topic:    cmd.Topic,
+		created:  now,
+		deadline: now.Add(timeout),
+		results:  make(map[string]*ClientResult, len(clients)),
+		pending:  len(clients),
+		done:     make(chan struct{}),
+	}
+	for _, clientID := range clients {
+		r.results[clientID] = &ClientResult{ClientID: clientID, Status: StatusPending, Updated: now}
+	}
+
+	m.mu.Lock()
+	m.pruneLocked(now)
+	m.rollouts[r.id] = r
+	m.mu.Unlock()
+
+	for _, clientID := range clients {
+		packet := &hook.PublishPacket{
+			Topic:      cmd.Topic,
+			Payload:    cmd.Payload,
+			QoS:        1,
+			Properties: cmd.Properties,
+			Created:    now,
+		}
+		m.mu.Lock()
+		m.sending[clientID]++
+		m.mu.Unlock()
+
+		packetID, err := m.config.Sender.Send(ctx, clientID, packet)
+
+		m.mu.Lock()
+		m.track(r, clientID, packetID, err)
+		m.mu.Unlock()
+	}
+
+	m.mu.Lock()
+	if r.pending > 0 {
+		r.timer = time.AfterFunc(timeout, func() { m.expire(r) })
+	}
+	m.mu.Unlock()
+	return r.id, nil
+}
+
+// track registers the outcome of sending to clientID, applying a settlement that arrived before Send returned
+func (m *Manager) track(r *rollout, clientID string, packetID uint16, err error) {
+	key := inflightKey{clientID: clientID, packetID: packetID}
+	early, raced := m.early[key]
+	delete(m.early, key)
+	if m.sending[clientID]--; m.sending[clientID] == 0 {
+		delete(m.sending, clientID)
+		for k := range m.early {
+			if k.clientID == clientID {
+				delete(m.early, k)
+			}
+		}
+	}
+
+	result := r.results[clientID]
+	switch {
+	case err != nil:
+		m.settleLocked(r, result, StatusFailed, err.Error())
+	case raced:
+		result.PacketID = packetID
+		m.settleLocked(r, result, early.status, early.reason)
+	default:
+		result.PacketID = packetID
+		m.inflight[key] = r
+	}
+}
+
+// resolve returns the deduplicated client IDs selected by target
+func (m *Manager) resolve(target Target) ([]string, error) {
+	seen := make(map[string]bool, len(target.ClientIDs))
+	var clients []string
+	add := func(ids []string) {
+		for _, id := range ids {
+			if id != "" && !seen[id] {
+				seen[id] = true
+				clients = append(clients, id)
+			}
+		}
+	}
+
+	add(target.ClientIDs)
+	if target.Topic != "" {
+		if m.config.Resolver == nil {
+			return nil, ErrNoResolver
+		}
+		add(m.config.Resolver(target.Topic))
+	}
+	if len(clients) == 0 {
+		return nil, ErrEmptyTarget
+	}
+	sort.Strings(clients)
+	return clients, nil
+}
+
+// Ack records the PUBACK of clientID for packetID, unrelated acknowledgments are ignored
+func (m *Manager) Ack(clientID string, packetID uint16) {
+	m.resolveInflight(clientID, packetID, StatusAcked, "")
+}
+
+// Drop records that the broker dropped the in-flight command for clientID
+func (m *Manager) Drop(clientID string, packetID uint16, reason string) {
+	m.resolveInflight(clientID, packetID, StatusDropped, reason)
+}
+
+func (m *Manager) resolveInflight(clientID string, packetID uint16, status Status, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := inflightKey{clientID: clientID, packetID: packetID}
+	r, ok := m.inflight[key]
+	if !ok {
+		if m.sending[clientID] > 0 {
+			m.early[key] = settlement{status: status, reason: reason}
+		}
+		return
+	}
+	delete(m.inflight, key)
+	if result := r.results[clientID]; result.Status == StatusPending {
+		m.settleLocked(r, result, status, reason)
+	}
+}
+
+// settleLocked moves a pending result to its final status and completes the rollout with the last one
+func (m *Manager) settleLocked(r *rollout, result *ClientResult, status Status, reason string) {
+	result.Status = status
+	result.Error = reason
+	result.Updated = m.now()
+	r.pending--
+	if r.pending == 0 {
+		r.completed = result.Updated
+		if r.timer != nil {
+			r.timer.Stop()
+		}
+		close(r.done)
+	}
+}
+
+// expire marks the clients that did not acknowledge before the deadline as timed out
+func (m *Manager) expire(r *rollout) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for clientID, result := range r.results {
+		if result.Status != StatusPending {
+			continue
+		}
+		delete(m.inflight, inflightKey{clientID: clientID, packetID: result.PacketID})
+		m.settleLocked(r, result, StatusTimedOut, "")
+	}
+}
+
+// Report returns the current completion report of a rollout
+func (m *Manager) Report(id string) (*Report, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.rollouts[id]
+	if !ok {
+		return nil, ErrRolloutNotFound
+	}
+	return newReport(r), nil
+}
+
+// Reports returns the reports of every retained rollout, newest first
+func (m *Manager) Reports() []*Report {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pruneLocked(m.now())
+	reports := make([]*Report, 0, len(m.rollouts))
+	for _, r := range m.rollouts {
+		reports = append(reports, newReport(r))
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].Created.After(reports[j].Created)
+	})
+	return reports
+}
+
+// Wait blocks until every client of the rollout acknowledged, failed or timed out, and returns the report
+// It needs admin.PermissionMetricsRead
+func (m *Manager) Wait(ctx context.Context, id string) (*Report, error) {
+	if err := m.config.Guard.Authorize(ctx, admin.PermissionMetricsRead); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	r, ok := m.rollouts[id]
+	m.mu.Unlock()
+	if !ok {
+		return nil, ErrRolloutNotFound
+	}
+
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return m.Report(id)
+}
+
+// pruneLocked forgets rollouts that finished longer than the retention ago
+func (m *Manager) pruneLocked(now time.Time) {
+	for id, r := range m.rollouts {
+		if !r.completed.IsZero() && now.Sub(r.completed) > m.config.Retention {
+			delete(m.rollouts, id)
+		}
+	}
+}
+
+func newRolloutID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,188 @@
+package broadcast
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/hook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSender struct {
+	mu       sync.Mutex
+	nextID   uint16
+	offline  map[string]bool
+	sent     map[string]*hook.PublishPacket
+	packetID map[string]uint16
+	onSend   func(clientID string, packetID uint16)
+}
+
+func newFakeSender(offline ...string) *fakeSender {
+	s := &fakeSender{offline: make(map[string]bool), sent: make(map[string]*hook.PublishPacket), packetID: make(map[string]uint16)}
+	for _, id := range offline {
+		s.offline[id] = true
+	}
+	return s
+}
+
+func (s *fakeSender) Send(_ context.Context, clientID string, packet *hook.PublishPacket) (uint16, error) {
+	s.mu.Lock()
+	if s.offline[clientID] {
+		s.mu.Unlock()
+		return 0, errors.New("client not connected")
+	}
+	s.nextID++
+	id := s.nextID
+	s.sent[clientID] = packet
+	s.packetID[clientID] = id
+	onSend := s.onSend
+	s.mu.Unlock()
+
+	if onSend != nil {
+		onSend(clientID, id)
+	}
+	return id, nil
+}
+
+func (s *fakeSender) id(clientID string) uint16 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.packetID[clientID]
+}
+
+func TestManagerBroadcastAggregatesAcks(t *testing.T) {
+	sender := newFakeSender("dev-3")
+	m := NewManager(ManagerConfig{
+		Sender:   sender,
+		Resolver: func(string) []string { return []string{"dev-2", "dev-3", "dev-4"} },
+	})
+	h := NewHook(m)
+	ctx := context.Background()
+
+	id, err := m.Broadcast(ctx, &Command{
+		Topic:   "cmd/reboot",
+		Payload: []byte(`{"at":"now"}`),
+		Target:  Target{ClientIDs: []string{"dev-1", "dev-2"}, Topic: "fleet/eu"},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, byte(1), sender.sent["dev-1"].QoS)
+	assert.Equal(t, "cmd/reboot", sender.sent["dev-1"].Topic)
+
+	report, err := m.Report(id)
+	require.NoError(t, err)
+	assert.Equal(t, 4, report.Total())
+	assert.Equal(t, 3, report.Counts[StatusPending])
+	assert.Equal(t, 1, report.Counts[StatusFailed])
+	assert.False(t, report.Done())
+
+	require.NoError(t, h.OnQosComplete(&hook.Client{ID: "dev-1"}, sender.id("dev-1"), encoding.PUBACK))
+	require.NoError(t, h.OnQosComplete(&hook.Client{ID: "dev-2"}, 999, encoding.PUBACK), "unrelated acks are ignored")
+	require.NoError(t, h.OnQosComplete(&hook.Client{ID: "dev-2"}, sender.id("dev-2"), encoding.PUBACK))
+	require.NoError(t, h.OnQosDropped(&hook.Client{ID: "dev-4"}, sender.id("dev-4"), hook.DropReasonClientDisconnected))
+
+	report, err = m.Wait(ctx, id)
+	require.NoError(t, err)
+	assert.True(t, report.Done())
+	assert.False(t, report.Completed.IsZero())
+	assert.Equal(t, map[Status]int{StatusAcked: 2, StatusFailed: 1, StatusDropped: 1}, report.Counts)
+
+	statuses := make(map[string]Status)
+	for _, c := range report.Clients {
+		statuses[c.ClientID] = c.Status
+	}
+	assert.Equal(t, map[string]Status{"dev-1": StatusAcked, "dev-2": StatusAcked, "dev-3": StatusFailed, "dev-4": StatusDropped}, statuses)
+
+	data, err := json.Marshal(report)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"acked":2`)
+}
+
+func TestManagerBroadcastTimeout(t *testing.T) {
+	sender := newFakeSender()
+	m := NewManager(ManagerConfig{Sender: sender})
+
+	id, err := m.Broadcast(context.Background(), &Command{
+		Topic:   "cmd/update",
+		Target:  Target{ClientIDs: []string{"a", "b"}},
+		Timeout: 20 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	m.Ack("a", sender.id("a"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	report, err := m.Wait(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, map[Status]int{StatusAcked: 1, StatusTimedOut: 1}, report.Counts)
+
+	// A late PUBACK does not change the report
+	m.Ack("b", sender.id("b"))
+	report, err = m.Report(id)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Counts[StatusTimedOut])
+}
+
+func TestManagerAckBeforeSendReturns(t *testing.T) {
+	sender := newFakeSender()
+	m := NewManager(ManagerConfig{Sender: sender})
+	sender.onSend = func(clientID string, packetID uint16) {
+		m.Ack(clientID, packetID)
+	}
+
+	id, err := m.Broadcast(context.Background(), &Command{Topic: "cmd/x", Target: Target{ClientIDs: []string{"fast"}}})
+	require.NoError(t, err)
+
+	report, err := m.Report(id)
+	require.NoError(t, err)
+	assert.True(t, report.Done())
+	assert.Equal(t, 1, report.Counts[StatusAcked])
+}
+
+func TestManagerBroadcastErrors(t *testing.T) {
+	m := NewManager(ManagerConfig{Sender: newFakeSender()})
+	ctx := context.Background()
+
+	_, err := m.Broadcast(ctx, &Command{Topic: "cmd/#", Target: Target{ClientIDs: []string{"a"}}})
+	assert.ErrorIs(t, err, ErrInvalidTopic)
+	_, err = m.Broadcast(ctx, &Command{Topic: "cmd/x"})
+	assert.ErrorIs(t, err, ErrEmptyTarget)
+	_, err = m.Broadcast(ctx, &Command{Topic: "cmd/x", Target: Target{Topic: "fleet"}})
+	assert.ErrorIs(t, err, ErrNoResolver)
+	_, err = m.Report("missing")
+	assert.ErrorIs(t, err, ErrRolloutNotFound)
+	_, err = m.Wait(ctx, "missing")
+	assert.ErrorIs(t, err, ErrRolloutNotFound)
+}
+
+func TestManagerReportsRetention(t *testing.T) {
+	sender := newFakeSender("gone")
+	m := NewManager(ManagerConfig{Sender: sender, Retention: time.Hour})
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	first, err := m.Broadcast(context.Background(), &Command{Topic: "cmd/a", Target: Target{ClientIDs: []string{"gone"}}})
+	require.NoError(t, err)
+	now = now.Add(time.Minute)
+	second, err := m.Broadcast(context.Background(), &Command{Topic: "cmd/b", Target: Target{ClientIDs: []string{"gone"}}})
+	require.NoError(t, err)
+
+	reports := m.Reports()
+	require.Len(t, reports, 2)
+	assert.Equal(t, second, reports[0].ID)
+	assert.Equal(t, first, reports[1].ID)
+
+	now = now.Add(2 * time.Hour)
+	assert.Empty(t, m.Reports())
+}
+
+func TestStatusString(t *testing.T) {
+	assert.Equal(t, "timed_out", StatusTimedOut.String())
+	assert.Equal(t, "unknown", Status(99).String())
+}
@@ -0,0 +1,108 @@
+package broadcast
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Status is the delivery state of a command for one client
+type Status byte
+
+const (
+	// StatusPending waits for the PUBACK of the client
+	StatusPending Status = iota
+	// StatusAcked means the client acknowledged the command
+	StatusAcked
+	// StatusFailed means the command could not be sent, e.g. because the client is offline
+	StatusFailed
+	// StatusDropped means the broker dropped the in-flight command, e.g. when the client session expired
+	StatusDropped
+	// StatusTimedOut means no acknowledgment arrived before the rollout timeout
+	StatusTimedOut
+)
+
+// String returns the string representation of the status
+func (s Status) String() string {
+	switch s {
+	case StatusPending:
+		return "pending"
+	case StatusAcked:
+		return "acked"
+	case StatusFailed:
+		return "failed"
+	case StatusDropped:
+		return "dropped"
+	case StatusTimedOut:
+		return "timed_out"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText encodes the status by name for admin API responses
+func (s Status) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes a status encoded by MarshalText
+func (s *Status) UnmarshalText(text []byte) error {
+	for status := StatusPending; status <= StatusTimedOut; status++ {
+		if status.String() == string(text) {
+			*s = status
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown broadcast status %q", text)
+}
+
+// ClientResult is the outcome of a command for one client
+type ClientResult struct {
+	ClientID string    `json:"client_id"`
+	Status   Status    `json:"status"`
+	PacketID uint16    `json:"packet_id,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Updated  time.Time `json:"updated"`
+}
+
+// Report is the completion report of a rollout
+type Report struct {
+	ID        string         `json:"id"`
+	Topic     string         `json:"topic"`
+	Created   time.Time      `json:"created"`
+	Deadline  time.Time      `json:"deadline"`
+	Completed time.Time      `json:"completed,omitzero"`
+	Counts    map[Status]int `json:"counts"`
+	Clients   []ClientResult `json:"clients"`
+}
+
+// Done reports whether no client is pending anymore
+func (r *Report) Done() bool {
+	return r.Counts[StatusPending] == 0
+}
+
+// Total returns the number of targeted clients
+func (r *Report) Total() int {
+	return len(r.Clients)
+}
+
+// newReport builds a snapshot of the results, sorted by client ID
+func newReport(r *rollout) *Report {
+	report := &Report{
+		ID:        r.id,
+		Topic:     r.topic,
+		Created:   r.created,
+		Deadline:  r.deadline,
+		Completed: r.completed,
+		Counts:    make(map[Status]int),
+		Clients:   make([]ClientResult, 0, len(r.results)),
+	}
+	for _, result := range r.results {
+		report.Clients = append(report.Clients, *result)
+		report.Counts[result.Status]++
+	}
+	sort.Slice(report.Clients, func(i, j int) bool {
+		return report.Clients[i].ClientID < report.Clients[j].ClientID
+	})
+	return report
+}
@@ -0,0 +1,598 @@
+// Package broker embeds the broker core in an application
+//
+// Clients of the same process connect through Connect, or with the paho client dialing inproc://name, and
+// exchange messages without sockets or packet encoding: a publish runs through the hook pipeline and is handed
+// to the subscribers as Go values on the publishing goroutine. Hooks see the same events as for network clients
+package broker
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/axmq/ax/admin"
+	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/hook"
+	"github.com/axmq/ax/network"
+	"github.com/axmq/ax/session"
+	"github.com/axmq/ax/topic"
+)
+
+const (
+	// Scheme is the URL scheme of in-process brokers, clients dial inproc://name
+	Scheme = "inproc"
+
+	// DefaultLeaseInterval is how often subscriptions whose lease ran out are removed
+	DefaultLeaseInterval = time.Second
+)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]*Broker)
+)
+
+// Lookup returns the broker registered under name
+func Lookup(name string) (*Broker, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	b, ok := registry[name]
+	return b, ok
+}
+
+// Config configures an embedded broker
+type Config struct {
+	// Name registers the broker for clients dialing inproc://Name, an empty name leaves it unregistered
+	Name string
+	// Hooks is the hook pipeline, an empty one when nil
+	Hooks *hook.Manager
+	// Tenants selects the hook pipeline of every client by the tenant in its metadata, e.g. routed by TLS SNI
+	// Hooks then only serves the broker-wide events and defaults to the fallback pipeline of Tenants
+	Tenants *hook.TenantManagers
+	// Diagnostics counts and annotates deliveries differing from the message as published, none when nil
+	Diagnostics *hook.DeliveryDiagnostics
+	// DropUnrouted skips routing publishes that match no subscription and are not retained, sparing the routing
+	// hooks of chatty clients publishing into the void. Such publishes are still authorized and seen by the
+	// OnPublish and OnPublished hooks, QoS 1 and 2 publishers then get ErrNoMatchingSubscribers
+	DropUnrouted bool
+	// Receipts answers publishes carrying the request-receipt user property with the number of subscribers
+	// reached, none when nil
+	Receipts *hook.DeliveryReceipts
+	// Tracer records the delivery map of sampled messages, none when nil. The caller runs its Run loop
+	Tracer *hook.FanoutTracer
+	// TakeoverPolicy decides which client wins when a client ID is already connected, OnSessionTakeover hooks
+	// see the decision and may override it
+	TakeoverPolicy session.TakeoverPolicy
+	// TakeoverRejectReason is the reason code of rejected clients, defaults to ReasonClientIdentifierNotValid
+	TakeoverRejectReason encoding.ReasonCode
+	// Retained stores the retained publishes accepted by the OnRetainMessage hooks, none when nil
+	// The replica keeps the newest revision of every topic and converges with the other nodes of a cluster
+	Retained *hook.RetainedReplica
+	// LeaseInterval is how often subscriptions whose TTL lease ran out are removed, defaults to
+	// DefaultLeaseInterval. Leases are renewed whenever their client publishes or receives a message
+	LeaseInterval time.Duration
+	// Leases announces the removed subscriptions, none when nil. Add it to the hook pipeline as well, its
+	// OnSubscribe assigns the TTLs
+	Leases *hook.SubscriptionLeaseHook
+	// Bans rejects connecting clients matching a ban with network.ErrClientBanned, e.g. the ban list of the
+	// network.Admin serving bulk disconnects. The tenant and listener are read from the connect metadata
+	Bans *network.BanList
+	// AuthThrottle is told the authentication result of every client with a RemoteAddr, so the auth-throttle
+	// stage of its listeners refuses peers failing too often, none when nil
+	AuthThrottle *network.AuthThrottle
+	// AdminGuard checks the permissions of the principal carried by the context of admin operations such as
+	// AttachSubscription, none when nil
+	AdminGuard *admin.Guard
+}
+
+// Stats holds the counters of a broker
+type Stats struct {
+	Clients       int
+	Subscriptions int
+	Published     uint64
+	Delivered     uint64
+	Dropped       uint64
+	// Unrouted counts the publishes dropped by DropUnrouted
+	Unrouted uint64
+}
+
+// Broker routes messages between in-process clients through the hook pipeline
+type Broker struct {
+	name         string
+	hooks        *hook.Manager
+	tenants      *hook.TenantManagers
+	diagnostics  *hook.DeliveryDiagnostics
+	dropUnrouted bool
+	receipts     *hook.DeliveryReceipts
+	tracer       *hook.FanoutTracer
+	takeover     session.TakeoverPolicy
+	rejectReason encoding.ReasonCode
+	bans         *network.BanList
+	throttle     *network.AuthThrottle
+	retained     *hook.RetainedReplica
+	leases       *hook.SubscriptionLeaseHook
+	guard        *admin.Guard
+	stopLeases   context.CancelFunc
+	pipeline     *hook.PublishPipeline
+	router       *topic.Router
+	started      time.Time
+	traffic      session.Stats
+
+	mu      sync.RWMutex
+	clients map[string]*LocalClient
+	closed  bool
+
+	nextID    atomic.Uint64
+	published atomic.Uint64
+	delivered atomic.Uint64
+	dropped   atomic.Uint64
+	unrouted  atomic.Uint64
+}
+
+// New creates a broker and registers it under config.Name
+func New(config Config) (*Broker, error) {
+	hooks := config.Hooks
+	if hooks == nil && config.Tenants != nil {
+		hooks = config.Tenants.ForClient(nil)
+	}
+	if hooks == nil {
+		hooks = hook.NewManager()
+	}
+
+	b := &Broker{
+		name:         config.Name,
+		hooks:        hooks,
+		tenants:      config.Tenants,
+		diagnostics:  config.Diagnostics,
+		dropUnrouted: config.DropUnrouted,
+		receipts:     config.Receipts,
+		tracer:       config.Tracer,
+		takeover:     config.TakeoverPolicy,
+		rejectReason: config.TakeoverRejectReason,
+		bans:         config.Bans,
+		throttle:     config.AuthThrottle,
+		retained:     config.Retained,
+		leases:       config.Leases,
+		guard:        config.AdminGuard,
+		router:       topic.NewRouter(),
+		started:      time.Now(),
+		clients:      make(map[string]*LocalClient),
+	}
+	pipeline, err := hook.NewPublishPipeline(
+		hook.AuthorizeStageFor(b.hooksFor),
+		hook.HooksStageFor(b.hooksFor),
+		hook.RetainStageFor(b.hooksFor),
+		hook.NewPublishStage("retain-replica", hook.PhasePersistRetain, b.retain),
+		hook.NewPublishStage("route", hook.PhaseRoute, b.route),
+	)
+	if err != nil {
+		return nil, err
+	}
+	b.pipeline = pipeline
+
+	if b.name != "" {
+		registryMu.Lock()
+		defer registryMu.Unlock()
+		if _, ok := registry[b.name]; ok {
+			return nil, ErrBrokerExists
+		}
+		registry[b.name] = b
+	}
+
+	interval := config.LeaseInterval
+	if interval <= 0 {
+		interval = DefaultLeaseInterval
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	b.stopLeases = cancel
+	go b.router.RunLeaseExpiry(ctx, interval, b.leaseExpired)
+	return b, nil
+}
+
+// leaseExpired drops a subscription the router removed for its lease running out from its client
+func (b *Broker) leaseExpired(sub *topic.Subscription) {
+	b.mu.RLock()
+	c := b.clients[sub.ClientID]
+	b.mu.RUnlock()
+	if c != nil {
+		c.mu.Lock()
+		delete(c.subscriptions, sub.TopicFilter)
+		c.mu.Unlock()
+		c.session.RemoveSubscription(sub.TopicFilter)
+		c.hooks.OnUnsubscribed(c.client, sub.TopicFilter)
+	}
+	if b.leases != nil {
+		_ = b.leases.NotifyExpired(sub.ClientID, sub.TopicFilter, sub.TTL)
+	}
+}
+
+// Hooks returns the hook pipeline
+func (b *Broker) Hooks() *hook.Manager {
+	return b.hooks
+}
+
+// hooksFor returns the hook pipeline of client, the one of its tenant when tenants are configured
+func (b *Broker) hooksFor(client *hook.Client) *hook.Manager {
+	if b.tenants != nil {
+		return b.tenants.ForClient(client)
+	}
+	return b.hooks
+}
+
+// Pipeline returns the publish pipeline, stages added to it run for every in-process publish
+func (b *Broker) Pipeline() *hook.PublishPipeline {
+	return b.pipeline
+}
+
+// Router returns the subscription router
+func (b *Broker) Router() *topic.Router {
+	return b.router
+}
+
+// ConnectOptions describes an in-process client
+type ConnectOptions struct {
+	// ClientID identifies the client, an empty ID gets one assigned
+	ClientID string
+	Username string
+	Password []byte
+	// Metadata seeds the client metadata seen by the hooks, e.g. hook.TenantMetadataKey set from the
+	// network.MetadataTenant of a connection to select the pipeline of its tenant
+	Metadata map[string]string
+	// RemoteAddr is the address of the network peer the client connected from, nil for in-process clients
+	RemoteAddr net.Addr
+	// OnMessage receives the messages routed to the client on the publishing goroutine, it must not block
+	// and must not modify the payload, which is shared with the other subscribers
+	OnMessage func(*Message)
+	// OnDisconnect is called when the broker ends the connection, by a takeover or by closing
+	OnDisconnect func(rc encoding.ReasonCode)
+}
+
+// Connect authenticates and connects an in-process client, a connected client with the same ID is taken over
+// unless the takeover policy or an OnSessionTakeover hook rejects the new client with a PacketError wrapping
+// session.ErrTakeoverRejected. Banned clients are rejected with network.ErrClientBanned. In-process sessions always start clean and end with the connection
+func (b *Broker) Connect(opts ConnectOptions) (*LocalClient, error) {
+	clientID := opts.ClientID
+	if clientID == "" {
+		clientID = "inproc-" + strconv.FormatUint(b.nextID.Add(1), 10)
+	}
+
+	now := time.Now()
+	client := &hook.Client{
+		ID:              clientID,
+		Username:        opts.Username,
+		CleanStart:      true,
+		ProtocolVersion: byte(encoding.ProtocolVersion50),
+		ConnectedAt:     now,
+		State:           hook.ClientStateConnecting,
+	}
+	for key, value := range opts.Metadata {
+		client.SetMetadata(key, value)
+	}
+	packet := &hook.ConnectPacket{
+		ProtocolName:    "MQTT",
+		ProtocolVersion: byte(encoding.ProtocolVersion50),
+		CleanStart:      true,
+		ClientID:        clientID,
+		Username:        opts.Username,
+		Password:        opts.Password,
+	}
+
+	if b.bans != nil {
+		err := b.bans.Check(network.ClientInfo{
+			ClientID: clientID,
+			Username: opts.Username,
+			Tenant:   opts.Metadata[network.MetadataTenant],
+			Listener: opts.Metadata[network.MetadataListener],
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	hooks := b.hooksFor(client)
+	authenticated := hooks.OnConnectAuthenticate(client, packet)
+	if b.throttle != nil && opts.RemoteAddr != nil {
+		if authenticated {
+			b.throttle.Success(opts.RemoteAddr)
+		} else {
+			b.throttle.Failure(opts.RemoteAddr)
+		}
+	}
+	if !authenticated {
+		return nil, ErrNotAuthorized
+	}
+	if err := hooks.OnConnect(client, packet); err != nil {
+		return nil, err
+	}
+
+	b.mu.RLock()
+	active := b.clients[clientID] != nil
+	b.mu.RUnlock()
+	if active {
+		decision := &hook.TakeoverDecision{
+			ClientID:   clientID,
+			Policy:     b.takeover,
+			Active:     true,
+			Reject:     b.takeover == session.TakeoverPolicyRejectNew,
+			ReasonCode: b.rejectReason,
+		}
+		hooks.OnSessionTakeover(client, decision)
+		if err := decision.RejectError(); err != nil {
+			return nil, err
+		}
+	}
+
+	c := &LocalClient{
+		broker:       b,
+		hooks:        hooks,
+		client:       client,
+		session:      session.New(clientID, true, 0, byte(encoding.ProtocolVersion50)),
+		onMessage:    opts.OnMessage,
+		onDisconnect: opts.OnDisconnect,
+	}
+	c.stats.MarkConnected()
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil, ErrBrokerClosed
+	}
+	old := b.clients[clientID]
+	b.clients[clientID] = c
+	b.mu.Unlock()
+
+	if old != nil {
+		old.end(hook.DisconnectByServer, encoding.ReasonSessionTakenOver)
+	}
+	client.State = hook.ClientStateConnected
+	if err := hooks.OnSessionEstablished(client, packet); err != nil {
+		c.end(hook.DisconnectByServer, encoding.ReasonUnspecifiedError)
+		return nil, err
+	}
+	return c, nil
+}
+
+// unroutedKey marks in PublishContext.Values a publish DropUnrouted kept from routing
+const unroutedKey = "unrouted"
+
+// route hands a message to the in-process subscribers, each client gets one copy at the highest matching QoS
+func (b *Broker) route(pc *hook.PublishContext) error {
+	packet := pc.Packet
+	if b.dropUnrouted && !packet.Retain && !b.router.HasSubscribers(packet.Topic) {
+		b.unrouted.Add(1)
+		pc.Values[unroutedKey] = true
+		return nil
+	}
+	b.published.Add(1)
+	var tally *hook.DeliveryTally
+	if b.receipts != nil && hook.ReceiptRequested(packet) {
+		tally = hook.DeliveryTallyOf(pc)
+	}
+	trace := b.tracer.Start(pc)
+	defer b.tracer.Finish(trace)
+
+	matched := b.router.MatchWithPublisher(packet.Topic, pc.Client.ID)
+	b.tracer.Routed(trace, len(matched))
+	if len(matched) == 0 {
+		return nil
+	}
+
+	// Every matching subscription is offered to the hooks with its filter and properties, so a hook can tell
+	// the overlapping subscriptions of a client apart. Each client then gets one copy at the highest QoS left
+	selection := &hook.Subscribers{Packet: packet}
+	for _, info := range matched {
+		sub := &hook.Subscription{
+			ClientID:               info.ClientID,
+			TopicFilter:            info.TopicFilter,
+			QoS:                    info.QoS,
+			NoLocal:                info.NoLocal,
+			RetainAsPublished:      info.RetainAsPublished,
+			RetainHandling:         info.RetainHandling,
+			SubscriptionIdentifier: info.SubscriptionIdentifier,
+			LastValue:              info.LastValue,
+		}
+		if subscribed := b.subscription(info.ClientID, info.TopicFilter); subscribed != nil {
+			sub.Properties = subscribed.Properties
+			sub.SubscribedAt = subscribed.SubscribedAt
+		}
+		selection.Add(sub)
+	}
+	if err := b.hooksFor(pc.Client).OnSelectSubscribersContext(pc.Context, selection, packet.Topic); err != nil {
+		return err
+	}
+
+	deliveries := make([]*hook.Subscription, 0, len(selection.Subscriptions))
+	index := make(map[string]*hook.Subscription, len(selection.Subscriptions))
+	for _, sub := range selection.Subscriptions {
+		if merged, ok := index[sub.ClientID]; ok {
+			merged.QoS = max(merged.QoS, sub.QoS)
+			merged.RetainAsPublished = merged.RetainAsPublished || sub.RetainAsPublished
+			continue
+		}
+		merged := *sub
+		index[sub.ClientID] = &merged
+		deliveries = append(deliveries, &merged)
+	}
+
+	for _, sub := range deliveries {
+		b.mu.RLock()
+		target := b.clients[sub.ClientID]
+		b.mu.RUnlock()
+		if target == nil {
+			b.tracer.Flushed(b.tracer.Enqueued(trace, sub, min(packet.QoS, sub.QoS), 0), ErrClientClosed)
+			if tally != nil {
+				tally.Failed++
+			}
+			continue
+		}
+
+		out := *packet
+		out.QoS = min(packet.QoS, sub.QoS)
+		out.Retain = packet.Retain && sub.RetainAsPublished
+		out.Duplicate = false
+		delivered := target.hooks.OnPublishDeliver(target.client, &out)
+		if b.diagnostics != nil {
+			delivered = b.diagnostics.Inspect(target.client, packet, delivered)
+		}
+		// In-process delivery is a direct call, so a copy is flushed and its QoS flow complete once it returns
+		rec := b.tracer.Enqueued(trace, sub, delivered.QoS, 0)
+		if target.deliver(delivered) {
+			b.router.Renew(target.client.ID)
+			target.stats.RecordSent(delivered.QoS, len(delivered.Payload))
+			b.traffic.RecordSent(delivered.QoS, len(delivered.Payload))
+			b.tracer.Flushed(rec, nil)
+			b.tracer.Acked(rec)
+			b.delivered.Add(1)
+			if tally != nil {
+				tally.Delivered++
+			}
+		} else {
+			b.dropped.Add(1)
+			target.stats.RecordDrop()
+			b.traffic.RecordDrop()
+			target.hooks.OnPublishDropped(target.client, delivered, hook.DropReasonClientDisconnected)
+			b.tracer.Flushed(rec, ErrClientClosed)
+			if tally != nil {
+				tally.Failed++
+			}
+		}
+	}
+	return nil
+}
+
+// retain saves retained publishes to the replica, an empty payload clears the topic
+func (b *Broker) retain(pc *hook.PublishContext) error {
+	if b.retained == nil || !pc.Packet.Retain {
+		return nil
+	}
+	packet := pc.Packet
+	return b.retained.Save(pc.Context, &hook.RetainedMessage{
+		Topic:      packet.Topic,
+		Payload:    packet.Payload,
+		QoS:        packet.QoS,
+		Properties: packet.Properties,
+		Timestamp:  packet.Created,
+	})
+}
+
+// subscription returns the subscription to filter as a connected client made it, nil when it is unknown
+func (b *Broker) subscription(clientID, filter string) *hook.Subscription {
+	b.mu.RLock()
+	c := b.clients[clientID]
+	b.mu.RUnlock()
+	if c == nil {
+		return nil
+	}
+	return c.subscription(filter)
+}
+
+// Stats returns the counters of the broker
+func (b *Broker) Stats() Stats {
+	b.mu.RLock()
+	clients := len(b.clients)
+	b.mu.RUnlock()
+
+	return Stats{
+		Clients:       clients,
+		Subscriptions: b.router.Count(),
+		Published:     b.published.Load(),
+		Delivered:     b.delivered.Load(),
+		Dropped:       b.dropped.Load(),
+		Unrouted:      b.unrouted.Load(),
+	}
+}
+
+// SysInfo returns the traffic of the broker in the form OnSysInfoTick hooks receive
+func (b *Broker) SysInfo() *hook.SysInfo {
+	b.mu.RLock()
+	clients := len(b.clients)
+	b.mu.RUnlock()
+
+	now := time.Now()
+	traffic := b.traffic.Snapshot()
+	return &hook.SysInfo{
+		Uptime:           int64(now.Sub(b.started).Seconds()),
+		Started:          b.started,
+		Time:             now,
+		ClientsConnected: int64(clients),
+		MessagesReceived: int64(traffic.TotalReceived()),
+		MessagesSent:     int64(traffic.TotalSent()),
+		MessagesDropped:  int64(traffic.Dropped),
+		Subscriptions:    int64(b.router.Count()),
+	}
+}
+
+// PublishSysInfo hands the current SysInfo to the OnSysInfoTick hooks, call it on the $SYS interval
+func (b *Broker) PublishSysInfo() {
+	b.hooks.OnSysInfoTick(b.SysInfo())
+}
+
+// Close disconnects every client and unregisters the broker, the hooks are left to the caller
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrBrokerClosed
+	}
+	b.closed = true
+	b.stopLeases()
+	clients := make([]*LocalClient, 0, len(b.clients))
+	for _, c := range b.clients {
+		clients = append(clients, c)
+	}
+	b.mu.Unlock()
+
+	if b.name != "" {
+		registryMu.Lock()
+		if registry[b.name] == b {
+			delete(registry, b.name)
+		}
+		registryMu.Unlock()
+	}
+
+	for _, c := range clients {
+		c.end(hook.DisconnectByServer, encoding.ReasonServerShuttingDown)
+	}
+	return nil
+}
+
+// publish runs a message of c through the pipeline
+// With DropUnrouted a message without subscribers is not routed, QoS 1 and 2 publishers learn it from
+// ErrNoMatchingSubscribers as a network client would from the No matching subscribers reason code
+// A requested receipt follows once the message is through, unless the publisher was not authorized
+func (b *Broker) publish(ctx context.Context, c *LocalClient, packet *hook.PublishPacket) error {
+	b.router.Renew(c.client.ID)
+	c.stats.RecordReceived(packet.QoS, len(packet.Payload))
+	b.traffic.RecordReceived(packet.QoS, len(packet.Payload))
+
+	pc := hook.NewPublishContext(ctx, c.client, packet)
+	if err := b.pipeline.Process(pc); err != nil {
+		return err
+	}
+	if reason, dropped := pc.Dropped(); dropped {
+		if reason == hook.DropReasonACLDenied {
+			return ErrNotAuthorized
+		}
+		b.sendReceipt(pc, c)
+		return nil
+	}
+	// The message is through, a cancelled context only skips the remaining OnPublished hooks
+	_ = c.hooks.OnPublishedContext(pc.Context, c.client, pc.Packet)
+	b.sendReceipt(pc, c)
+	if unrouted, _ := pc.Values[unroutedKey].(bool); unrouted && pc.Packet.QoS > 0 {
+		return ErrNoMatchingSubscribers
+	}
+	return nil
+}
+
+// sendReceipt publishes the receipt of the message carried by pc on behalf of its publisher c, when one was
+// requested. Receipts never request receipts, so this does not recurse further
+func (b *Broker) sendReceipt(pc *hook.PublishContext, c *LocalClient) {
+	if b.receipts == nil {
+		return
+	}
+	if receipt := b.receipts.Receipt(pc); receipt != nil {
+		b.receipts.Record(b.publish(pc.Context, c, receipt))
+	}
+}
@@ -0,0 +1,869 @@
+package broker
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/axmq/ax/admin"
+	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/hook"
+	"github.com/axmq/ax/network"
+	"github.com/axmq/ax/session"
+	"github.com/axmq/ax/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testHook denies access to private topics, tags publishes and records disconnects
+type testHook struct {
+	*hook.Base
+	mu          sync.Mutex
+	disconnects []hook.DisconnectInfo
+}
+
+func (h *testHook) Provides(event hook.Event) bool {
+	switch event {
+	case hook.OnACLCheck, hook.OnPublish, hook.OnPublishDeliver, hook.OnDisconnect:
+		return true
+	}
+	return false
+}
+
+func (h *testHook) OnACLCheck(_ *hook.Client, topicName string, _ hook.AccessType) bool {
+	return !strings.HasPrefix(topicName, "private/")
+}
+
+func (h *testHook) OnPublish(_ *hook.Client, packet *hook.PublishPacket) error {
+	packet.Properties = hook.Properties{"tagged": true}
+	return nil
+}
+
+func (h *testHook) OnPublishDeliver(client *hook.Client, packet *hook.PublishPacket) *hook.PublishPacket {
+	out := *packet
+	out.Topic = client.ID + "/" + packet.Topic
+	return &out
+}
+
+func (h *testHook) OnDisconnect(_ *hook.Client, info *hook.DisconnectInfo) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.disconnects = append(h.disconnects, *info)
+	return nil
+}
+
+func newTestBroker(t *testing.T, hooks ...hook.Hook) *Broker {
+	manager := hook.NewManager()
+	for _, h := range hooks {
+		require.NoError(t, manager.Add(h))
+	}
+	b, err := New(Config{Hooks: manager})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = b.Close() })
+	return b
+}
+
+// inbox collects the messages of a client
+type inbox struct {
+	mu       sync.Mutex
+	messages []*Message
+}
+
+func (i *inbox) add(m *Message) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.messages = append(i.messages, m)
+}
+
+func (i *inbox) all() []*Message {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return append([]*Message(nil), i.messages...)
+}
+
+func TestBroker_PublishThroughHooks(t *testing.T) {
+	h := &testHook{Base: hook.NewHookBase("test")}
+	b := newTestBroker(t, h)
+	ctx := context.Background()
+
+	var got inbox
+	sub, err := b.Connect(ConnectOptions{ClientID: "sub", OnMessage: got.add})
+	require.NoError(t, err)
+	pub, err := b.Connect(ConnectOptions{})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(pub.ID(), "inproc-"))
+
+	granted, err := sub.Subscribe("sensors/#", 1)
+	require.NoError(t, err)
+	assert.Equal(t, byte(1), granted)
+	_, err = sub.Subscribe("sensors/+/temp", 0)
+	require.NoError(t, err)
+	_, err = sub.Subscribe("private/#", 0)
+	assert.ErrorIs(t, err, ErrNotAuthorized)
+
+	// Overlapping subscriptions deliver once at the highest QoS, the retain flag is cleared
+	require.NoError(t, pub.Publish(ctx, &Message{Topic: "sensors/a/temp", Payload: []byte("21"), QoS: 2, Retain: true}))
+	messages := got.all()
+	require.Len(t, messages, 1)
+	assert.Equal(t, "sub/sensors/a/temp", messages[0].Topic)
+	assert.Equal(t, byte(1), messages[0].QoS)
+	assert.False(t, messages[0].Retain)
+	assert.Equal(t, true, messages[0].Properties["tagged"])
+
+	assert.ErrorIs(t, pub.Publish(ctx, &Message{Topic: "private/x"}), ErrNotAuthorized)
+	assert.ErrorIs(t, pub.Publish(ctx, &Message{Topic: "a", QoS: 3}), ErrInvalidQoS)
+	assert.Error(t, pub.Publish(ctx, &Message{Topic: "a/#"}))
+
+	require.NoError(t, sub.Unsubscribe("sensors/#"))
+	require.NoError(t, sub.Unsubscribe("sensors/+/temp"))
+	require.NoError(t, pub.Publish(ctx, &Message{Topic: "sensors/a/temp"}))
+	assert.Len(t, got.all(), 1)
+
+	stats := b.Stats()
+	assert.Equal(t, 2, stats.Clients)
+	assert.Equal(t, uint64(2), stats.Published)
+	assert.Equal(t, uint64(1), stats.Delivered)
+
+	require.NoError(t, pub.Close())
+	assert.ErrorIs(t, pub.Close(), ErrClientClosed)
+	assert.ErrorIs(t, pub.Publish(ctx, &Message{Topic: "a"}), ErrClientClosed)
+	require.Len(t, h.disconnects, 1)
+	assert.Equal(t, hook.DisconnectByClient, h.disconnects[0].Initiator)
+}
+
+type traceKey struct{}
+
+// publishedHook records the trace ID of the request context it sees in OnPublishedContext
+type publishedHook struct {
+	*hook.Base
+	mu     sync.Mutex
+	traces []any
+}
+
+func (h *publishedHook) Provides(event hook.Event) bool {
+	return event == hook.OnPublished
+}
+
+func (h *publishedHook) OnPublishedContext(ctx context.Context, _ *hook.Client, _ *hook.PublishPacket) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.traces = append(h.traces, ctx.Value(traceKey{}))
+	return nil
+}
+
+func TestBroker_PublishContext(t *testing.T) {
+	h := &publishedHook{Base: hook.NewHookBase("published")}
+	b := newTestBroker(t, h)
+
+	var got inbox
+	sub, err := b.Connect(ConnectOptions{ClientID: "sub", OnMessage: got.add})
+	require.NoError(t, err)
+	_, err = sub.Subscribe("a/#", 0)
+	require.NoError(t, err)
+	pub, err := b.Connect(ConnectOptions{})
+	require.NoError(t, err)
+
+	ctx := context.WithValue(context.Background(), traceKey{}, "trace-1")
+	require.NoError(t, pub.Publish(ctx, &Message{Topic: "a/b"}))
+	assert.Equal(t, []any{"trace-1"}, h.traces)
+
+	// Once the hooks shut down the broker stops routing instead of calling them
+	require.NoError(t, b.Hooks().Shutdown(context.Background()))
+	assert.ErrorIs(t, pub.Publish(ctx, &Message{Topic: "a/b"}), hook.ErrManagerShutdown)
+	assert.Len(t, got.all(), 1)
+	assert.Len(t, h.traces, 1)
+}
+
+// subscribeFilterHook sets a property filter on the subscriptions to alerts/#
+type subscribeFilterHook struct {
+	*hook.Base
+}
+
+func (h *subscribeFilterHook) Provides(event hook.Event) bool {
+	return event == hook.OnSubscribe
+}
+
+func (h *subscribeFilterHook) OnSubscribe(_ *hook.Client, sub *hook.Subscription) error {
+	if sub.TopicFilter == "alerts/#" {
+		sub.Properties = hook.Properties{"UserProperty": map[string]string{hook.DefaultFilterProperty: `level = "high"`}}
+	}
+	return nil
+}
+
+func TestBroker_SelectSubscribers(t *testing.T) {
+	b := newTestBroker(t, &subscribeFilterHook{Base: hook.NewHookBase("filter-setter")}, hook.NewPropertyFilterHook(""))
+	ctx := context.Background()
+
+	var filtered, overlapping inbox
+	sub, err := b.Connect(ConnectOptions{ClientID: "filtered", OnMessage: filtered.add})
+	require.NoError(t, err)
+	_, err = sub.Subscribe("alerts/#", 1)
+	require.NoError(t, err)
+	both, err := b.Connect(ConnectOptions{ClientID: "overlapping", OnMessage: overlapping.add})
+	require.NoError(t, err)
+	_, err = both.Subscribe("alerts/#", 1)
+	require.NoError(t, err)
+	_, err = both.Subscribe("alerts/+", 0)
+	require.NoError(t, err)
+
+	pub, err := b.Connect(ConnectOptions{})
+	require.NoError(t, err)
+	high := hook.Properties{"UserProperty": map[string]string{"level": "high"}}
+	require.NoError(t, pub.Publish(ctx, &Message{Topic: "alerts/fire", QoS: 1, Properties: high}))
+	require.NoError(t, pub.Publish(ctx, &Message{Topic: "alerts/drill", QoS: 1}))
+
+	// The filter applies to its own subscription only, the overlapping one still gets the message at QoS 0
+	require.Len(t, filtered.all(), 1)
+	assert.Equal(t, "alerts/fire", filtered.all()[0].Topic)
+	messages := overlapping.all()
+	require.Len(t, messages, 2)
+	assert.Equal(t, byte(1), messages[0].QoS)
+	assert.Equal(t, "alerts/drill", messages[1].Topic)
+	assert.Equal(t, byte(0), messages[1].QoS)
+
+	require.NoError(t, both.Unsubscribe("alerts/+"))
+	require.NoError(t, pub.Publish(ctx, &Message{Topic: "alerts/drill"}))
+	assert.Len(t, overlapping.all(), 2)
+}
+
+func TestBroker_Tenants(t *testing.T) {
+	// The fallback pipeline denies private topics, the pipeline of tenant a tags and renames deliveries
+	fallback := hook.NewManager()
+	require.NoError(t, fallback.Add(&aclHook{Base: hook.NewHookBase("acl")}))
+	tenantA := hook.NewManager()
+	require.NoError(t, tenantA.Add(&testHook{Base: hook.NewHookBase("test")}))
+	tenants := hook.NewTenantManagers(fallback)
+	tenants.Set("a", tenantA)
+
+	b, err := New(Config{Tenants: tenants})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = b.Close() })
+	assert.Same(t, fallback, b.Hooks())
+	ctx := context.Background()
+
+	var gotA inbox
+	a, err := b.Connect(ConnectOptions{ClientID: "a1", Metadata: map[string]string{hook.TenantMetadataKey: "a"}, OnMessage: gotA.add})
+	require.NoError(t, err)
+	other, err := b.Connect(ConnectOptions{ClientID: "o1"})
+	require.NoError(t, err)
+
+	_, err = a.Subscribe("sensors/#", 0)
+	require.NoError(t, err)
+	_, err = other.Subscribe("private/#", 0)
+	assert.ErrorIs(t, err, ErrNotAuthorized)
+
+	require.NoError(t, a.Publish(ctx, &Message{Topic: "sensors/x"}))
+	require.NoError(t, other.Publish(ctx, &Message{Topic: "sensors/y"}))
+	messages := gotA.all()
+	require.Len(t, messages, 2)
+	assert.Equal(t, "a1/sensors/x", messages[0].Topic)
+	assert.Equal(t, true, messages[0].Properties["tagged"])
+	assert.Equal(t, "a1/sensors/y", messages[1].Topic)
+	assert.Nil(t, messages[1].Properties["tagged"])
+}
+
+func TestBroker_DeliveryDiagnostics(t *testing.T) {
+	diagnostics := hook.NewDeliveryDiagnostics(hook.DeliveryDiagnosticsConfig{Annotate: true})
+	b, err := New(Config{Diagnostics: diagnostics})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = b.Close() })
+
+	var got inbox
+	sub, err := b.Connect(ConnectOptions{OnMessage: got.add})
+	require.NoError(t, err)
+	_, err = sub.Subscribe("a", 0)
+	require.NoError(t, err)
+
+	require.NoError(t, sub.Publish(context.Background(), &Message{Topic: "a", QoS: 1, Retain: true}))
+	messages := got.all()
+	require.Len(t, messages, 1)
+	assert.Equal(t, []string{"qos_downgrade,retain_cleared"}, messages[0].Properties.UserProperty(hook.AnnotationModified))
+	assert.Equal(t, uint64(1), diagnostics.Stats().Reasons["qos_downgrade"])
+}
+
+func TestBroker_DropUnrouted(t *testing.T) {
+	b, err := New(Config{DropUnrouted: true})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = b.Close() })
+	ctx := context.Background()
+
+	var got inbox
+	c, err := b.Connect(ConnectOptions{OnMessage: got.add})
+	require.NoError(t, err)
+	_, err = c.Subscribe("sensors/+/temp", 0)
+	require.NoError(t, err)
+
+	require.NoError(t, c.Publish(ctx, &Message{Topic: "void/a"}))
+	err = c.Publish(ctx, &Message{Topic: "void/a", QoS: 1})
+	assert.ErrorIs(t, err, ErrNoMatchingSubscribers)
+
+	// Retained messages are kept for later subscribers and always run through the pipeline
+	require.NoError(t, c.Publish(ctx, &Message{Topic: "void/a", QoS: 1, Retain: true}))
+	require.NoError(t, c.Publish(ctx, &Message{Topic: "sensors/a/temp", QoS: 1}))
+	assert.Len(t, got.all(), 1)
+
+	stats := b.Stats()
+	assert.Equal(t, uint64(2), stats.Unrouted)
+	assert.Equal(t, uint64(2), stats.Published)
+	assert.Equal(t, uint64(1), stats.Delivered)
+}
+
+// publishRecorder records the topics seen by OnPublish and OnPublished
+type publishRecorder struct {
+	*hook.Base
+	mu        sync.Mutex
+	published []string
+}
+
+func (h *publishRecorder) Provides(event hook.Event) bool {
+	return event == hook.OnPublished
+}
+
+func (h *publishRecorder) OnPublished(_ *hook.Client, packet *hook.PublishPacket) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.published = append(h.published, packet.Topic)
+	return nil
+}
+
+func TestBroker_DropUnroutedAfterAuthorization(t *testing.T) {
+	manager := hook.NewManager()
+	require.NoError(t, manager.Add(&aclHook{Base: hook.NewHookBase("acl")}))
+	recorder := &publishRecorder{Base: hook.NewHookBase("recorder")}
+	require.NoError(t, manager.Add(recorder))
+	b, err := New(Config{Hooks: manager, DropUnrouted: true})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = b.Close() })
+	ctx := context.Background()
+
+	c, err := b.Connect(ConnectOptions{})
+	require.NoError(t, err)
+
+	// An unauthorized publisher cannot tell from the error whether a topic has subscribers
+	err = c.Publish(ctx, &Message{Topic: "private/a", QoS: 1})
+	assert.ErrorIs(t, err, ErrNotAuthorized)
+
+	err = c.Publish(ctx, &Message{Topic: "void/a", QoS: 1})
+	assert.ErrorIs(t, err, ErrNoMatchingSubscribers)
+	recorder.mu.Lock()
+	assert.Equal(t, []string{"void/a"}, recorder.published, "hooks consuming publishes see unrouted ones")
+	recorder.mu.Unlock()
+	assert.Equal(t, uint64(1), b.Stats().Unrouted)
+}
+
+// aclHook denies access to private topics and leaves messages alone
+type aclHook struct {
+	*hook.Base
+}
+
+func (h *aclHook) Provides(event hook.Event) bool {
+	return event == hook.OnACLCheck
+}
+
+func (h *aclHook) OnACLCheck(_ *hook.Client, topicName string, _ hook.AccessType) bool {
+	return !strings.HasPrefix(topicName, "private/")
+}
+
+func TestBroker_Receipts(t *testing.T) {
+	manager := hook.NewManager()
+	require.NoError(t, manager.Add(&aclHook{Base: hook.NewHookBase("acl")}))
+	receipts := hook.NewDeliveryReceipts(hook.DeliveryReceiptsConfig{Topic: "receipts/{clientid}"})
+	b, err := New(Config{Hooks: manager, Receipts: receipts})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = b.Close() })
+	ctx := context.Background()
+
+	for range 2 {
+		sub, err := b.Connect(ConnectOptions{OnMessage: func(*Message) {}})
+		require.NoError(t, err)
+		_, err = sub.Subscribe("orders/#", 1)
+		require.NoError(t, err)
+	}
+	var got inbox
+	publisher, err := b.Connect(ConnectOptions{ClientID: "p1", OnMessage: got.add})
+	require.NoError(t, err)
+	_, err = publisher.Subscribe("replies/p1", 1)
+	require.NoError(t, err)
+	_, err = publisher.Subscribe("receipts/p1", 1)
+	require.NoError(t, err)
+
+	request := []encoding.UTF8Pair{{Key: hook.ReceiptRequestProperty, Value: "true"}}
+	userKey := encoding.PropUserProperty.String()
+	require.NoError(t, publisher.Publish(ctx, &Message{Topic: "orders/1", QoS: 1, Properties: hook.Properties{
+		userKey:                               request,
+		encoding.PropResponseTopic.String():   "replies/p1",
+		encoding.PropCorrelationData.String(): []byte("req-1"),
+	}}))
+	messages := got.all()
+	require.Len(t, messages, 1)
+	receipt := messages[0]
+	assert.Equal(t, "replies/p1", receipt.Topic)
+	assert.Equal(t, byte(1), receipt.QoS)
+	assert.JSONEq(t, `{"topic": "orders/1", "delivered": 2, "failed": 0}`, string(receipt.Payload))
+	assert.Equal(t, []byte("req-1"), receipt.Properties[encoding.PropCorrelationData.String()])
+	assert.Equal(t, []string{"2"}, receipt.Properties.UserProperty(hook.ReceiptDeliveredProperty))
+
+	// Without a Response Topic the receipt goes to the configured topic, without a request nowhere
+	require.NoError(t, publisher.Publish(ctx, &Message{Topic: "orders/2", Properties: hook.Properties{userKey: request}}))
+	require.NoError(t, publisher.Publish(ctx, &Message{Topic: "orders/3"}))
+	messages = got.all()
+	require.Len(t, messages, 2)
+	assert.Equal(t, "receipts/p1", messages[1].Topic)
+
+	// The publisher ACL guards the reply topic
+	require.NoError(t, publisher.Publish(ctx, &Message{Topic: "orders/4", Properties: hook.Properties{
+		userKey:                             request,
+		encoding.PropResponseTopic.String(): "private/p2",
+	}}))
+	assert.Equal(t, hook.DeliveryReceiptsStats{Requested: 3, Sent: 2, Failed: 1}, receipts.Stats())
+}
+
+func TestBroker_ReceiptsUnrouted(t *testing.T) {
+	b, err := New(Config{DropUnrouted: true, Receipts: hook.NewDeliveryReceipts(hook.DeliveryReceiptsConfig{})})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = b.Close() })
+
+	var got inbox
+	c, err := b.Connect(ConnectOptions{OnMessage: got.add})
+	require.NoError(t, err)
+	_, err = c.Subscribe("replies", 0)
+	require.NoError(t, err)
+
+	err = c.Publish(context.Background(), &Message{Topic: "void", QoS: 1, Properties: hook.Properties{
+		encoding.PropUserProperty.String():  []encoding.UTF8Pair{{Key: hook.ReceiptRequestProperty, Value: "1"}},
+		encoding.PropResponseTopic.String(): "replies",
+	}})
+	assert.ErrorIs(t, err, ErrNoMatchingSubscribers)
+	messages := got.all()
+	require.Len(t, messages, 1)
+	assert.Equal(t, []string{"0"}, messages[0].Properties.UserProperty(hook.ReceiptDeliveredProperty))
+}
+
+func TestBroker_FanoutTrace(t *testing.T) {
+	exported := make(chan *hook.FanoutTrace, 4)
+	tracer := hook.NewFanoutTracer(hook.FanoutTraceConfig{
+		Sample: func(pc *hook.PublishContext) bool { return pc.Packet.Topic != "untraced" },
+		Exporters: []hook.TraceExporter{hook.TraceExporterFunc(func(_ context.Context, tr *hook.FanoutTrace) error {
+			exported <- tr
+			return nil
+		})},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tracer.Run(ctx)
+
+	b, err := New(Config{Tracer: tracer})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = b.Close() })
+
+	for _, qos := range []byte{0, 1} {
+		sub, err := b.Connect(ConnectOptions{ClientID: "sub" + strconv.Itoa(int(qos)), OnMessage: func(*Message) {}})
+		require.NoError(t, err)
+		_, err = sub.Subscribe("orders/#", qos)
+		require.NoError(t, err)
+	}
+	publisher, err := b.Connect(ConnectOptions{ClientID: "pub"})
+	require.NoError(t, err)
+	require.NoError(t, publisher.Publish(ctx, &Message{Topic: "untraced", QoS: 1}))
+	require.NoError(t, publisher.Publish(ctx, &Message{Topic: "orders/1", QoS: 1}))
+
+	var tr *hook.FanoutTrace
+	select {
+	case tr = <-exported:
+	case <-time.After(time.Second):
+		t.Fatal("trace not exported")
+	}
+	assert.Equal(t, "orders/1", tr.Topic)
+	assert.Equal(t, "pub", tr.Publisher)
+	assert.Equal(t, 2, tr.Matched)
+	require.Len(t, tr.Deliveries, 2)
+	outcomes := map[string]hook.DeliveryOutcome{}
+	for _, rec := range tr.Deliveries {
+		outcomes[rec.ClientID] = rec.Outcome
+		assert.False(t, rec.Flushed.Before(rec.Enqueued))
+	}
+	assert.Equal(t, map[string]hook.DeliveryOutcome{"sub0": hook.DeliveryFlushed, "sub1": hook.DeliveryAcked}, outcomes)
+	assert.Equal(t, uint64(1), tracer.Stats().Sampled)
+}
+
+func TestBroker_Takeover(t *testing.T) {
+	b := newTestBroker(t)
+	ctx := context.Background()
+
+	var lost []encoding.ReasonCode
+	var got inbox
+	first, err := b.Connect(ConnectOptions{ClientID: "c1", OnMessage: got.add, OnDisconnect: func(rc encoding.ReasonCode) {
+		lost = append(lost, rc)
+	}})
+	require.NoError(t, err)
+	_, err = first.Subscribe("a", 0)
+	require.NoError(t, err)
+
+	second, err := b.Connect(ConnectOptions{ClientID: "c1", OnMessage: got.add})
+	require.NoError(t, err)
+	assert.True(t, first.IsClosed())
+	assert.Equal(t, []encoding.ReasonCode{encoding.ReasonSessionTakenOver}, lost)
+
+	// The session starts clean, the subscription of the old client is gone
+	require.NoError(t, second.Publish(ctx, &Message{Topic: "a"}))
+	assert.Empty(t, got.all())
+	assert.Equal(t, 1, b.Stats().Clients)
+}
+
+func TestBroker_ManagedSubscriptions(t *testing.T) {
+	b := newTestBroker(t)
+	ctx := context.Background()
+
+	var got inbox
+	c, err := b.Connect(ConnectOptions{ClientID: "c1", OnMessage: got.add})
+	require.NoError(t, err)
+	_, err = c.Subscribe("own", 0)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, b.AttachSubscription(ctx, "missing", &hook.Subscription{TopicFilter: "diag/#"}), session.ErrSessionNotActive)
+	assert.ErrorIs(t, b.AttachSubscription(ctx, "c1", &hook.Subscription{TopicFilter: "own"}), session.ErrSubscriptionConflict)
+	require.NoError(t, b.AttachSubscription(ctx, "c1", &hook.Subscription{TopicFilter: "diag/#", QoS: 1}))
+
+	// A client UNSUBSCRIBE leaves the managed subscription in place
+	assert.ErrorIs(t, c.Unsubscribe("diag/#"), session.ErrManagedSubscription)
+	_, err = c.Subscribe("diag/#", 0)
+	assert.ErrorIs(t, err, session.ErrManagedSubscription)
+	require.NoError(t, c.Publish(ctx, &Message{Topic: "diag/cpu"}))
+	require.Len(t, got.all(), 1)
+
+	assert.ErrorIs(t, b.DetachSubscription(ctx, "c1", "own"), session.ErrSubscriptionNotFound)
+	require.NoError(t, b.DetachSubscription(ctx, "c1", "diag/#"))
+	require.NoError(t, c.Publish(ctx, &Message{Topic: "diag/cpu"}))
+	assert.Len(t, got.all(), 1)
+
+	require.NoError(t, c.Unsubscribe("own"))
+	require.NoError(t, c.Publish(ctx, &Message{Topic: "own"}))
+	assert.Len(t, got.all(), 1)
+}
+
+func TestBroker_ManagedSubscriptionsGuard(t *testing.T) {
+	guard, err := admin.NewGuard(admin.GuardConfig{Authenticator: admin.Chain()})
+	require.NoError(t, err)
+	b, err := New(Config{AdminGuard: guard})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = b.Close() })
+	_, err = b.Connect(ConnectOptions{ClientID: "c1"})
+	require.NoError(t, err)
+	sub := &hook.Subscription{TopicFilter: "diag/#"}
+
+	assert.ErrorIs(t, b.AttachSubscription(context.Background(), "c1", sub), admin.ErrNoCredentials)
+	viewer := admin.WithPrincipal(context.Background(), &admin.Principal{Name: "grafana", Roles: []string{admin.RoleViewer}})
+	assert.ErrorIs(t, b.AttachSubscription(viewer, "c1", sub), admin.ErrForbidden)
+	assert.Zero(t, b.Router().Count())
+
+	owner := admin.WithPrincipal(context.Background(), &admin.Principal{Name: "root", Roles: []string{admin.RoleAdmin}})
+	require.NoError(t, b.AttachSubscription(owner, "c1", sub))
+	assert.ErrorIs(t, b.DetachSubscription(viewer, "c1", "diag/#"), admin.ErrForbidden)
+	require.NoError(t, b.DetachSubscription(owner, "c1", "diag/#"))
+}
+
+// takeoverHook lets a client ID take over a connected client only when its username is admin
+type takeoverHook struct {
+	*hook.Base
+	decisions []hook.TakeoverDecision
+}
+
+func (h *takeoverHook) Provides(event hook.Event) bool {
+	return event == hook.OnSessionTakeover
+}
+
+func (h *takeoverHook) OnSessionTakeover(client *hook.Client, decision *hook.TakeoverDecision) error {
+	h.decisions = append(h.decisions, *decision)
+	decision.Reject = client.Username != "admin"
+	return nil
+}
+
+func TestBroker_TakeoverPolicy(t *testing.T) {
+	h := &takeoverHook{Base: hook.NewHookBase("takeover")}
+	manager := hook.NewManager()
+	require.NoError(t, manager.Add(h))
+	b, err := New(Config{Hooks: manager, TakeoverPolicy: session.TakeoverPolicyRejectNew})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = b.Close() })
+
+	first, err := b.Connect(ConnectOptions{ClientID: "c1"})
+	require.NoError(t, err)
+	assert.Empty(t, h.decisions, "no takeover without a connected client")
+
+	_, err = b.Connect(ConnectOptions{ClientID: "c1"})
+	assert.ErrorIs(t, err, session.ErrTakeoverRejected)
+	assert.Equal(t, encoding.ReasonClientIdentifierNotValid, encoding.FromError(err))
+	assert.False(t, first.IsClosed())
+	require.Len(t, h.decisions, 1)
+	assert.Equal(t, hook.TakeoverDecision{ClientID: "c1", Policy: session.TakeoverPolicyRejectNew, Active: true, Reject: true}, h.decisions[0])
+
+	// The hook overrides the policy
+	second, err := b.Connect(ConnectOptions{ClientID: "c1", Username: "admin"})
+	require.NoError(t, err)
+	assert.True(t, first.IsClosed())
+	assert.False(t, second.IsClosed())
+}
+
+func TestBroker_RetainedReplica(t *testing.T) {
+	ctx := context.Background()
+	retained := hook.NewRetainedStore(store.NewMemoryStore[*hook.RetainedMessage]())
+	replica := hook.NewRetainedReplica(retained, nil, "node-a", 0)
+	b, err := New(Config{Retained: replica})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = b.Close() })
+
+	c, err := b.Connect(ConnectOptions{ClientID: "pub"})
+	require.NoError(t, err)
+
+	require.NoError(t, c.Publish(ctx, &Message{Topic: "status", Payload: []byte("up"), Retain: true}))
+	require.NoError(t, c.Publish(ctx, &Message{Topic: "other", Payload: []byte("x")}))
+	msg, err := retained.Load(ctx, "status")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("up"), msg.Payload)
+	assert.Equal(t, "node-a", msg.Node)
+	_, err = retained.Load(ctx, "other")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	require.NoError(t, c.Publish(ctx, &Message{Topic: "status", Retain: true}))
+	digest, err := replica.Digest(ctx)
+	require.NoError(t, err)
+	assert.True(t, digest["status"].Deleted)
+}
+
+// leaseRecorder records the expiry announcements of a SubscriptionLeaseHook
+type leaseRecorder struct {
+	mu     sync.Mutex
+	topics []string
+}
+
+func (r *leaseRecorder) PublishControl(topicName string, _ []byte, _ bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.topics = append(r.topics, topicName)
+	return nil
+}
+
+func (r *leaseRecorder) announced() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.topics...)
+}
+
+func TestBroker_SubscriptionLeases(t *testing.T) {
+	ctx := context.Background()
+	recorder := &leaseRecorder{}
+	leases, err := hook.NewSubscriptionLeaseHook(recorder, hook.TTLPolicy{Filter: "ui/#", TTL: 50 * time.Millisecond})
+	require.NoError(t, err)
+	manager := hook.NewManager()
+	require.NoError(t, manager.Add(leases))
+	b, err := New(Config{Hooks: manager, Leases: leases, LeaseInterval: 5 * time.Millisecond})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = b.Close() })
+
+	sub, err := b.Connect(ConnectOptions{ClientID: "sub"})
+	require.NoError(t, err)
+	_, err = sub.Subscribe("ui/#", 0)
+	require.NoError(t, err)
+	_, err = sub.Subscribe("logs/#", 0)
+	require.NoError(t, err)
+	_, ok := b.Router().LeaseDeadline("sub", "ui/#")
+	require.True(t, ok)
+	pub, err := b.Connect(ConnectOptions{ClientID: "pub"})
+	require.NoError(t, err)
+
+	// Deliveries renew the lease past its TTL
+	for i := 0; i < 10; i++ {
+		require.NoError(t, pub.Publish(ctx, &Message{Topic: "ui/x", Payload: []byte("1")}))
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.NotNil(t, sub.subscription("ui/#"))
+	assert.Empty(t, recorder.announced())
+
+	assert.Eventually(t, func() bool {
+		return sub.subscription("ui/#") == nil
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []string{"$SYS/subscriptions/expired/sub"}, recorder.announced())
+	assert.NotNil(t, sub.subscription("logs/#"), "subscriptions without a TTL never expire")
+	assert.Equal(t, 1, b.Router().Count())
+}
+
+func TestLocalClient_SubscribeWithOptions(t *testing.T) {
+	leases, err := hook.NewSubscriptionLeaseHook(&leaseRecorder{})
+	require.NoError(t, err)
+	manager := hook.NewManager()
+	require.NoError(t, manager.Add(leases))
+	b, err := New(Config{Hooks: manager, Leases: leases, LeaseInterval: time.Hour})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = b.Close() })
+
+	c, err := b.Connect(ConnectOptions{ClientID: "c"})
+	require.NoError(t, err)
+	granted, err := c.SubscribeWithOptions("ui/#", SubscribeOptions{
+		QoS:     1,
+		NoLocal: true,
+		Properties: hook.Properties{
+			encoding.PropUserProperty.String(): []encoding.UTF8Pair{{Key: hook.DefaultLeaseProperty, Value: "10m"}},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, byte(1), granted)
+
+	sub := c.subscription("ui/#")
+	require.NotNil(t, sub)
+	assert.Equal(t, 10*time.Minute, sub.TTL, "the lease hook reads the SUBSCRIBE user property")
+	assert.True(t, sub.NoLocal)
+	_, ok := b.Router().LeaseDeadline("c", "ui/#")
+	assert.True(t, ok)
+
+	_, err = c.SubscribeWithOptions("ui/#", SubscribeOptions{RetainHandling: 3})
+	assert.ErrorIs(t, err, ErrInvalidRetainHandling)
+}
+
+// passwordHook authenticates clients with the password "secret"
+type passwordHook struct {
+	*hook.Base
+}
+
+func (h *passwordHook) Provides(event hook.Event) bool {
+	return event == hook.OnConnectAuthenticate
+}
+
+func (h *passwordHook) OnConnectAuthenticate(_ *hook.Client, packet *hook.ConnectPacket) bool {
+	return string(packet.Password) == "secret"
+}
+
+func TestBroker_AuthThrottle(t *testing.T) {
+	manager := hook.NewManager()
+	require.NoError(t, manager.Add(&passwordHook{Base: hook.NewHookBase("password")}))
+	throttle := network.NewAuthThrottle(&network.AuthThrottleConfig{MaxFailures: 2, Window: time.Minute, BlockDuration: time.Minute})
+	b, err := New(Config{Hooks: manager, AuthThrottle: throttle})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = b.Close() })
+
+	peer := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 40000}
+	_, err = b.Connect(ConnectOptions{ClientID: "c1", Password: []byte("wrong"), RemoteAddr: peer})
+	assert.ErrorIs(t, err, ErrNotAuthorized)
+	_, err = b.Connect(ConnectOptions{ClientID: "c1", Password: []byte("secret"), RemoteAddr: peer})
+	require.NoError(t, err)
+
+	// A success clears the earlier failure, so blocking takes two more
+	_, err = b.Connect(ConnectOptions{ClientID: "c2", Password: []byte("wrong"), RemoteAddr: peer})
+	assert.ErrorIs(t, err, ErrNotAuthorized)
+	assert.False(t, throttle.Blocked(peer))
+	_, err = b.Connect(ConnectOptions{ClientID: "c2", Password: []byte("wrong"), RemoteAddr: peer})
+	assert.ErrorIs(t, err, ErrNotAuthorized)
+	assert.True(t, throttle.Blocked(peer))
+}
+
+func TestBroker_Bans(t *testing.T) {
+	bans := network.NewBanList()
+	require.NoError(t, bans.Ban(network.ClientSelector{ClientID: "bad-*", Tenant: "acme"}, time.Minute))
+	b, err := New(Config{Bans: bans})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = b.Close() })
+
+	_, err = b.Connect(ConnectOptions{ClientID: "bad-1", Metadata: map[string]string{network.MetadataTenant: "acme"}})
+	assert.ErrorIs(t, err, network.ErrClientBanned)
+	assert.Zero(t, b.Stats().Clients)
+
+	_, err = b.Connect(ConnectOptions{ClientID: "bad-1", Metadata: map[string]string{network.MetadataTenant: "other"}})
+	assert.NoError(t, err)
+	_, err = b.Connect(ConnectOptions{ClientID: "good-1", Metadata: map[string]string{network.MetadataTenant: "acme"}})
+	assert.NoError(t, err)
+}
+
+// sysInfoHook records the SysInfo handed to OnSysInfoTick
+type sysInfoHook struct {
+	*hook.Base
+	info *hook.SysInfo
+}
+
+func (h *sysInfoHook) Provides(event hook.Event) bool {
+	return event == hook.OnSysInfoTick
+}
+
+func (h *sysInfoHook) OnSysInfoTick(info *hook.SysInfo) error {
+	h.info = info
+	return nil
+}
+
+func TestBroker_SysInfo(t *testing.T) {
+	h := &sysInfoHook{Base: hook.NewHookBase("sysinfo")}
+	b := newTestBroker(t, h)
+	ctx := context.Background()
+
+	var got inbox
+	sub, err := b.Connect(ConnectOptions{ClientID: "sub", OnMessage: got.add})
+	require.NoError(t, err)
+	_, err = sub.Subscribe("a/#", 1)
+	require.NoError(t, err)
+	pub, err := b.Connect(ConnectOptions{ClientID: "pub"})
+	require.NoError(t, err)
+
+	require.NoError(t, pub.Publish(ctx, &Message{Topic: "a/b", Payload: []byte("123"), QoS: 1}))
+	require.NoError(t, pub.Publish(ctx, &Message{Topic: "c", Payload: []byte("4")}))
+
+	stats := pub.Stats()
+	assert.Equal(t, [3]uint64{1, 1, 0}, stats.MessagesReceived)
+	assert.Equal(t, uint64(4), stats.BytesReceived)
+	assert.Equal(t, uint64(1), sub.Stats().MessagesSent[1])
+	assert.False(t, sub.Stats().ConnectedAt.IsZero())
+
+	b.PublishSysInfo()
+	require.NotNil(t, h.info)
+	assert.Equal(t, int64(2), h.info.ClientsConnected)
+	assert.Equal(t, int64(2), h.info.MessagesReceived)
+	assert.Equal(t, int64(1), h.info.MessagesSent)
+	assert.Equal(t, int64(0), h.info.MessagesDropped)
+	assert.Equal(t, int64(1), h.info.Subscriptions)
+}
+
+func TestBroker_Registry(t *testing.T) {
+	b, err := New(Config{Name: "embedded"})
+	require.NoError(t, err)
+	found, ok := Lookup("embedded")
+	require.True(t, ok)
+	assert.Same(t, b, found)
+
+	_, err = New(Config{Name: "embedded"})
+	assert.ErrorIs(t, err, ErrBrokerExists)
+
+	c, err := b.Connect(ConnectOptions{ClientID: "c1"})
+	require.NoError(t, err)
+	require.NoError(t, b.Close())
+	assert.True(t, c.IsClosed())
+	_, ok = Lookup("embedded")
+	assert.False(t, ok)
+
+	_, err = b.Connect(ConnectOptions{})
+	assert.ErrorIs(t, err, ErrBrokerClosed)
+	assert.ErrorIs(t, b.Close(), ErrBrokerClosed)
+}
+
+func BenchmarkLocalClient_Publish(b *testing.B) {
+	broker, err := New(Config{})
+	require.NoError(b, err)
+	defer broker.Close()
+
+	var delivered int
+	sub, err := broker.Connect(ConnectOptions{OnMessage: func(*Message) { delivered++ }})
+	require.NoError(b, err)
+	_, err = sub.Subscribe("bench/#", 1)
+	require.NoError(b, err)
+	pub, err := broker.Connect(ConnectOptions{})
+	require.NoError(b, err)
+
+	ctx := context.Background()
+	msg := &Message{Topic: "bench/a", Payload: []byte("payload"), QoS: 1}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		_ = pub.Publish(ctx, msg)
+	}
+	b.StopTimer()
+	require.Equal(b, b.N, delivered)
+}
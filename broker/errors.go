@@ -0,0 +1,17 @@
+package broker
+
+import (
+	axerrors "github.com/axmq/ax/pkg/errors"
+)
+
+var (
+	ErrBrokerExists          = axerrors.New(axerrors.KindInternal, "broker name already registered")
+	ErrBrokerClosed          = axerrors.New(axerrors.KindInternal, "broker is closed")
+	ErrClientClosed          = axerrors.New(axerrors.KindProtocol, "client is disconnected")
+	ErrNotAuthorized         = axerrors.New(axerrors.KindAuth, "not authorized")
+	ErrInvalidQoS            = axerrors.New(axerrors.KindProtocol, "invalid qos")
+	ErrInvalidRetainHandling = axerrors.New(axerrors.KindProtocol, "invalid retain handling")
+
+	// ErrNoMatchingSubscribers reports a QoS 1 or 2 publish dropped for lacking subscribers, it is not a failure
+	ErrNoMatchingSubscribers = axerrors.New(axerrors.KindProtocol, "no matching subscribers")
+)
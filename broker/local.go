@@ -0,0 +1,273 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/hook"
+	"github.com/axmq/ax/session"
+	"github.com/axmq/ax/topic"
+)
+
+// Message is an application message exchanged with in-process clients
+type Message struct {
+	Topic      string
+	Payload    []byte
+	QoS        byte
+	Retain     bool
+	Properties hook.Properties
+}
+
+// LocalClient is a client connected to a broker in the same process
+// Delivery is a direct call, so a message is handed over before Publish returns and QoS 1 and 2 flows complete
+// at once without packet identifiers or acknowledgements
+type LocalClient struct {
+	broker       *Broker
+	hooks        *hook.Manager
+	client       *hook.Client
+	session      *session.Session // tracks which subscriptions are managed by the broker
+	onMessage    func(*Message)
+	onDisconnect func(encoding.ReasonCode)
+	stats        session.Stats
+	closed       atomic.Bool
+
+	mu            sync.Mutex
+	subscriptions map[string]*hook.Subscription // by topic filter, as accepted by the hooks
+}
+
+// ID returns the client identifier
+func (c *LocalClient) ID() string {
+	return c.client.ID
+}
+
+// Stats returns the traffic counters of the client
+func (c *LocalClient) Stats() session.StatsSnapshot {
+	return c.stats.Snapshot()
+}
+
+// Publish runs msg through the hook pipeline and hands it to the matching subscribers
+func (c *LocalClient) Publish(ctx context.Context, msg *Message) error {
+	if c.closed.Load() {
+		return ErrClientClosed
+	}
+	if msg.QoS > 2 {
+		return ErrInvalidQoS
+	}
+	if err := topic.ValidateTopic(msg.Topic); err != nil {
+		return err
+	}
+
+	return c.broker.publish(ctx, c, &hook.PublishPacket{
+		Topic:           msg.Topic,
+		Payload:         msg.Payload,
+		QoS:             msg.QoS,
+		Retain:          msg.Retain,
+		Properties:      msg.Properties,
+		ProtocolVersion: byte(encoding.ProtocolVersion50),
+		Created:         time.Now(),
+	})
+}
+
+// SubscribeOptions holds the subscription options and properties of a SUBSCRIBE from an in-process client
+type SubscribeOptions struct {
+	QoS                    byte
+	NoLocal                bool
+	RetainAsPublished      bool
+	RetainHandling         byte
+	SubscriptionIdentifier uint32
+	// Properties are seen by the hooks as the SUBSCRIBE properties, e.g. the user property requesting a
+	// subscription TTL from a hook.SubscriptionLeaseHook
+	Properties hook.Properties
+}
+
+// Subscribe subscribes to filter and returns the QoS granted by the hooks
+func (c *LocalClient) Subscribe(filter string, qos byte) (byte, error) {
+	return c.SubscribeWithOptions(filter, SubscribeOptions{QoS: qos})
+}
+
+// SubscribeWithOptions subscribes to filter with the options and properties of opts and returns the QoS
+// granted by the hooks
+func (c *LocalClient) SubscribeWithOptions(filter string, opts SubscribeOptions) (byte, error) {
+	if c.closed.Load() {
+		return 0, ErrClientClosed
+	}
+	if opts.QoS > 2 {
+		return 0, ErrInvalidQoS
+	}
+	if opts.RetainHandling > 2 {
+		return 0, ErrInvalidRetainHandling
+	}
+	if err := topic.ValidateTopicFilter(filter); err != nil {
+		return 0, err
+	}
+
+	hooks := c.hooks
+	if !hooks.OnACLCheck(c.client, filter, hook.AccessTypeRead) {
+		return 0, ErrNotAuthorized
+	}
+	sub := &hook.Subscription{
+		ClientID:               c.client.ID,
+		TopicFilter:            filter,
+		QoS:                    opts.QoS,
+		NoLocal:                opts.NoLocal,
+		RetainAsPublished:      opts.RetainAsPublished,
+		RetainHandling:         opts.RetainHandling,
+		SubscriptionIdentifier: opts.SubscriptionIdentifier,
+		SubscribedAt:           time.Now(),
+		Properties:             opts.Properties,
+	}
+	if err := hooks.OnSubscribe(c.client, sub); err != nil {
+		return 0, err
+	}
+	if existing, ok := c.session.GetSubscription(sub.TopicFilter); ok && existing.Managed {
+		// the broker owns the options of a managed subscription
+		return 0, session.ErrManagedSubscription
+	}
+	if err := c.add(sub, false); err != nil {
+		return 0, err
+	}
+	hooks.OnSubscribed(c.client, sub)
+	return sub.QoS, nil
+}
+
+// add installs sub in the router and records it in the session of the client
+func (c *LocalClient) add(sub *hook.Subscription, managed bool) error {
+	err := c.broker.router.Subscribe(&topic.Subscription{
+		ClientID:               c.client.ID,
+		TopicFilter:            sub.TopicFilter,
+		QoS:                    sub.QoS,
+		NoLocal:                sub.NoLocal,
+		RetainAsPublished:      sub.RetainAsPublished,
+		RetainHandling:         sub.RetainHandling,
+		SubscriptionIdentifier: sub.SubscriptionIdentifier,
+		LastValue:              sub.LastValue,
+		TTL:                    sub.TTL,
+	})
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	if c.subscriptions == nil {
+		c.subscriptions = make(map[string]*hook.Subscription)
+	}
+	c.subscriptions[sub.TopicFilter] = sub
+	c.mu.Unlock()
+	c.session.AddSubscription(&session.Subscription{
+		TopicFilter:            sub.TopicFilter,
+		QoS:                    sub.QoS,
+		NoLocal:                sub.NoLocal,
+		RetainAsPublished:      sub.RetainAsPublished,
+		RetainHandling:         sub.RetainHandling,
+		SubscriptionIdentifier: sub.SubscriptionIdentifier,
+		SubscribedAt:           sub.SubscribedAt,
+		Managed:                managed,
+		LastValue:              sub.LastValue,
+	})
+	return nil
+}
+
+// remove drops the subscription to filter from the client and the router
+func (c *LocalClient) remove(filter string) bool {
+	c.mu.Lock()
+	delete(c.subscriptions, filter)
+	c.mu.Unlock()
+	c.session.RemoveSubscription(filter)
+	return c.broker.router.Unsubscribe(c.client.ID, filter)
+}
+
+// Unsubscribe removes the subscription to filter, unsubscribing from an unknown filter is not an error
+// Subscriptions attached by the broker are kept and reported as session.ErrManagedSubscription
+func (c *LocalClient) Unsubscribe(filter string) error {
+	if c.closed.Load() {
+		return ErrClientClosed
+	}
+	if sub, ok := c.session.GetSubscription(filter); ok && sub.Managed {
+		return session.ErrManagedSubscription
+	}
+
+	hooks := c.hooks
+	if err := hooks.OnUnsubscribe(c.client, filter); err != nil {
+		return err
+	}
+	if err := c.session.Unsubscribe(filter); errors.Is(err, session.ErrManagedSubscription) {
+		// attached while the hooks ran
+		return err
+	}
+	if c.remove(filter) {
+		hooks.OnUnsubscribed(c.client, filter)
+	}
+	return nil
+}
+
+// Close disconnects the client and ends its session
+func (c *LocalClient) Close() error {
+	if !c.end(hook.DisconnectByClient, encoding.ReasonNormalDisconnection) {
+		return ErrClientClosed
+	}
+	return nil
+}
+
+// IsClosed reports whether the client was disconnected, by Close, a takeover or the broker closing
+func (c *LocalClient) IsClosed() bool {
+	return c.closed.Load()
+}
+
+// subscription returns the subscription of the client to filter, nil when there is none
+func (c *LocalClient) subscription(filter string) *hook.Subscription {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.subscriptions[filter]
+}
+
+// deliver hands a message to the client and reports whether it was still connected
+func (c *LocalClient) deliver(packet *hook.PublishPacket) bool {
+	if c.closed.Load() {
+		return false
+	}
+	if c.onMessage != nil {
+		c.onMessage(&Message{
+			Topic:      packet.Topic,
+			Payload:    packet.Payload,
+			QoS:        packet.QoS,
+			Retain:     packet.Retain,
+			Properties: packet.Properties,
+		})
+	}
+	return true
+}
+
+// end disconnects the client once, dropping its subscriptions unless a newer client took its ID over
+func (c *LocalClient) end(initiator hook.DisconnectInitiator, rc encoding.ReasonCode) bool {
+	if !c.closed.CompareAndSwap(false, true) {
+		return false
+	}
+
+	b := c.broker
+	b.mu.Lock()
+	current := b.clients[c.client.ID] == c
+	if current {
+		delete(b.clients, c.client.ID)
+	}
+	b.mu.Unlock()
+	if current || rc == encoding.ReasonSessionTakenOver {
+		// A takeover runs before the new client subscribes, so the old subscriptions can go
+		b.router.UnsubscribeAll(c.client.ID)
+	}
+
+	c.client.State = hook.ClientStateDisconnected
+	c.client.DisconnectedAt = time.Now()
+	c.hooks.OnDisconnect(c.client, &hook.DisconnectInfo{
+		Initiator:  initiator,
+		ReasonCode: rc,
+		Expire:     true,
+		Duration:   c.client.DisconnectedAt.Sub(c.client.ConnectedAt),
+	})
+	if initiator != hook.DisconnectByClient && c.onDisconnect != nil {
+		c.onDisconnect(rc)
+	}
+	return true
+}
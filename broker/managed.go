@@ -0,0 +1,66 @@
+package broker
+
+import (
+	"context"
+	"time"
+
+	"github.com/axmq/ax/admin"
+	"github.com/axmq/ax/hook"
+	"github.com/axmq/ax/session"
+)
+
+// AttachSubscription subscribes a connected client to sub.TopicFilter on its behalf like
+// session.SubscriptionAdmin does for network clients. The subscription is managed, so the client cannot remove
+// it with Unsubscribe. Attaching a filter the client subscribed to itself returns session.ErrSubscriptionConflict,
+// attaching a managed filter again replaces its options. It needs admin.PermissionConfigWrite
+func (b *Broker) AttachSubscription(ctx context.Context, clientID string, sub *hook.Subscription) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := b.guard.Authorize(ctx, admin.PermissionConfigWrite); err != nil {
+		return err
+	}
+	c, err := b.activeClient(clientID)
+	if err != nil {
+		return err
+	}
+	if existing, ok := c.session.GetSubscription(sub.TopicFilter); ok && !existing.Managed {
+		return session.ErrSubscriptionConflict
+	}
+
+	managed := *sub
+	managed.ClientID = clientID
+	managed.SubscribedAt = time.Now()
+	return c.add(&managed, true)
+}
+
+// DetachSubscription removes a managed subscription of a connected client, subscriptions the client made itself
+// are left alone and reported as session.ErrSubscriptionNotFound. It needs admin.PermissionConfigWrite
+func (b *Broker) DetachSubscription(ctx context.Context, clientID, topicFilter string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := b.guard.Authorize(ctx, admin.PermissionConfigWrite); err != nil {
+		return err
+	}
+	c, err := b.activeClient(clientID)
+	if err != nil {
+		return err
+	}
+	if sub, ok := c.session.GetSubscription(topicFilter); !ok || !sub.Managed {
+		return session.ErrSubscriptionNotFound
+	}
+	c.remove(topicFilter)
+	return nil
+}
+
+// activeClient returns the connected client with the ID, session.ErrSessionNotActive when there is none
+func (b *Broker) activeClient(clientID string) (*LocalClient, error) {
+	b.mu.RLock()
+	c := b.clients[clientID]
+	b.mu.RUnlock()
+	if c == nil || c.IsClosed() {
+		return nil, session.ErrSessionNotActive
+	}
+	return c, nil
+}
@@ -0,0 +1,188 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Dialer opens the transport connection to a broker
+type Dialer interface {
+	DialContext(ctx context.Context, u *url.URL) (net.Conn, error)
+}
+
+// DialContextFunc opens a raw network connection, it has the signature of net.Dialer.DialContext
+type DialContextFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// DialerConfig configures how connections to the broker are made
+type DialerConfig struct {
+	// Timeout bounds the whole dial including proxy, TLS and WebSocket handshakes, zero means no limit
+	Timeout time.Duration
+	// TLSConfig is used for tls:// and wss:// URLs, ServerName defaults to the broker host
+	TLSConfig *tls.Config
+	// Proxy routes the connection through an http:// or https:// (HTTP CONNECT) or a socks5:// or socks5h://
+	// proxy, credentials are taken from the URL user info. socks5:// resolves the broker host locally,
+	// socks5h:// leaves it to the proxy
+	Proxy *url.URL
+	// ProxyTLSConfig is used to reach an https:// proxy, ServerName defaults to the proxy host
+	// It is separate from TLSConfig, the proxy is verified against the system roots when nil
+	ProxyTLSConfig *tls.Config
+	// Resolver resolves the broker host for socks5:// proxies, net.DefaultResolver is used when nil
+	Resolver *net.Resolver
+	// DialContext opens the raw connection to the broker or proxy, net.Dialer is used when nil
+	DialContext DialContextFunc
+	// Header holds extra headers sent with the WebSocket handshake
+	Header http.Header
+	// MaxFrameSize bounds the payload of a received WebSocket frame
+	MaxFrameSize int64
+}
+
+// DefaultDialerConfig returns the default dialer configuration
+func DefaultDialerConfig() *DialerConfig {
+	return &DialerConfig{
+		Timeout:      30 * time.Second,
+		MaxFrameSize: 256 << 20,
+	}
+}
+
+// NewDialer returns the dialer for the scheme of u:
+// tcp:// and mqtt:// dial plain TCP, tls://, ssl:// and mqtts:// dial TLS, ws:// and wss:// dial WebSocket
+func NewDialer(u *url.URL, config *DialerConfig) (Dialer, error) {
+	if config == nil {
+		config = DefaultDialerConfig()
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "tcp", "mqtt":
+		return &TCPDialer{config: config}, nil
+	case "tls", "ssl", "mqtts":
+		return &TLSDialer{config: config}, nil
+	case "ws", "wss":
+		return &WebSocketDialer{config: config}, nil
+	default:
+		return nil, ErrUnsupportedScheme
+	}
+}
+
+// Dial parses rawURL and connects to it with the matching dialer
+func Dial(ctx context.Context, rawURL string, config *DialerConfig) (net.Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer, err := NewDialer(u, config)
+	if err != nil {
+		return nil, err
+	}
+	return dialer.DialContext(ctx, u)
+}
+
+// TCPDialer dials plain TCP connections
+type TCPDialer struct {
+	config *DialerConfig
+}
+
+// NewTCPDialer creates a TCP dialer
+func NewTCPDialer(config *DialerConfig) *TCPDialer {
+	if config == nil {
+		config = DefaultDialerConfig()
+	}
+	return &TCPDialer{config: config}
+}
+
+// DialContext connects to the host of u, port 1883 by default
+func (d *TCPDialer) DialContext(ctx context.Context, u *url.URL) (net.Conn, error) {
+	ctx, cancel := withTimeout(ctx, d.config.Timeout)
+	defer cancel()
+
+	return dialThrough(ctx, d.config, hostPort(u, "1883"))
+}
+
+// TLSDialer dials TLS connections
+type TLSDialer struct {
+	config *DialerConfig
+}
+
+// NewTLSDialer creates a TLS dialer
+func NewTLSDialer(config *DialerConfig) *TLSDialer {
+	if config == nil {
+		config = DefaultDialerConfig()
+	}
+	return &TLSDialer{config: config}
+}
+
+// DialContext connects to the host of u, port 8883 by default, and completes the TLS handshake
+func (d *TLSDialer) DialContext(ctx context.Context, u *url.URL) (net.Conn, error) {
+	ctx, cancel := withTimeout(ctx, d.config.Timeout)
+	defer cancel()
+
+	conn, err := dialThrough(ctx, d.config, hostPort(u, "8883"))
+	if err != nil {
+		return nil, err
+	}
+	return clientTLS(ctx, conn, d.config.TLSConfig, u.Hostname())
+}
+
+// clientTLS runs the TLS handshake over conn, closing it on failure
+func clientTLS(ctx context.Context, conn net.Conn, config *tls.Config, host string) (net.Conn, error) {
+	if config == nil {
+		config = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	if config.ServerName == "" {
+		config = config.Clone()
+		config.ServerName = host
+	}
+
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// dialRaw opens a raw TCP connection with the configured DialContext
+func dialRaw(ctx context.Context, config *DialerConfig, address string) (net.Conn, error) {
+	if config.DialContext != nil {
+		return config.DialContext(ctx, "tcp", address)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", address)
+}
+
+// withTimeout bounds ctx by timeout when it is set
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// watchContext makes blocking I/O on conn fail once ctx is done, the returned func stops watching
+// and clears the deadline
+func watchContext(ctx context.Context, conn net.Conn) func() {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Unix(1, 0))
+	})
+	return func() {
+		stop()
+		_ = conn.SetDeadline(time.Time{})
+	}
+}
+
+// hostPort returns the host and port of u, using defaultPort when u has none
+func hostPort(u *url.URL, defaultPort string) string {
+	port := u.Port()
+	if port == "" {
+		port = defaultPort
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
@@ -0,0 +1,147 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echoListener accepts connections on loopback and echoes what they receive
+func echoListener(t *testing.T) net.Listener {
+	t.Helper()
+	return echoListenerOn(t, "127.0.0.1:0")
+}
+
+// echoListenerOn accepts connections on address and echoes what they receive
+func echoListenerOn(t *testing.T, address string) net.Listener {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", address)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln
+}
+
+func assertEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+
+	_, err := conn.Write([]byte("\x10\x00"))
+	require.NoError(t, err)
+
+	buf := make([]byte, 2)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("\x10\x00"), buf)
+}
+
+func TestNewDialer(t *testing.T) {
+	tests := []struct {
+		url     string
+		want    Dialer
+		wantErr error
+	}{
+		{url: "tcp://localhost", want: &TCPDialer{}},
+		{url: "mqtt://localhost:1883", want: &TCPDialer{}},
+		{url: "tls://localhost", want: &TLSDialer{}},
+		{url: "mqtts://localhost", want: &TLSDialer{}},
+		{url: "ssl://localhost", want: &TLSDialer{}},
+		{url: "ws://localhost/mqtt", want: &WebSocketDialer{}},
+		{url: "wss://localhost/mqtt", want: &WebSocketDialer{}},
+		{url: "quic://localhost", wantErr: ErrUnsupportedScheme},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			require.NoError(t, err)
+
+			d, err := NewDialer(u, nil)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.IsType(t, tt.want, d)
+		})
+	}
+}
+
+func TestHostPort(t *testing.T) {
+	u, _ := url.Parse("tcp://broker.example.com")
+	assert.Equal(t, "broker.example.com:1883", hostPort(u, "1883"))
+
+	u, _ = url.Parse("tcp://[::1]:2883")
+	assert.Equal(t, "[::1]:2883", hostPort(u, "1883"))
+}
+
+func TestTCPDialer(t *testing.T) {
+	ln := echoListener(t)
+
+	conn, err := Dial(context.Background(), "tcp://"+ln.Addr().String(), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	assertEcho(t, conn)
+}
+
+func TestDialer_CustomDialContext(t *testing.T) {
+	var dialed string
+	config := DefaultDialerConfig()
+	config.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed = network + " " + address
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			_, _ = io.Copy(server, server)
+		}()
+		return client, nil
+	}
+
+	conn, err := Dial(context.Background(), "mqtt://broker.internal", config)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	assert.Equal(t, "tcp broker.internal:1883", dialed)
+	assertEcho(t, conn)
+}
+
+func TestTLSDialer_HandshakeTimeout(t *testing.T) {
+	// A server that accepts but never answers the TLS handshake
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(time.Second)
+		}
+	}()
+
+	config := DefaultDialerConfig()
+	config.Timeout = 50 * time.Millisecond
+
+	start := time.Now()
+	_, err = Dial(context.Background(), "tls://"+ln.Addr().String(), config)
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
@@ -0,0 +1,14 @@
+package client
+
+import "errors"
+
+var (
+	ErrUnsupportedScheme      = errors.New("unsupported broker URL scheme")
+	ErrUnsupportedProxy       = errors.New("unsupported proxy scheme")
+	ErrProxyConnect           = errors.New("proxy refused CONNECT")
+	ErrSOCKS5Handshake        = errors.New("socks5 handshake failed")
+	ErrSOCKS5Auth             = errors.New("socks5 authentication failed")
+	ErrWebSocketHandshake     = errors.New("websocket handshake failed")
+	ErrWebSocketFrame         = errors.New("invalid websocket frame")
+	ErrWebSocketFrameTooLarge = errors.New("websocket frame too large")
+)
@@ -0,0 +1,878 @@
+package paho
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/axmq/ax/broker"
+	"github.com/axmq/ax/client"
+	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/topic"
+)
+
+type status byte
+
+const (
+	disconnected status = iota
+	connecting
+	reconnecting
+	connected
+	disconnecting
+)
+
+// pending is a request waiting for its acknowledgement
+type pending struct {
+	publish     *PublishToken
+	subscribe   *SubscribeToken
+	unsubscribe *UnsubscribeToken
+}
+
+func (p *pending) complete(err error) {
+	switch {
+	case p.publish != nil:
+		p.publish.complete(err)
+	case p.subscribe != nil:
+		p.subscribe.complete(err)
+	case p.unsubscribe != nil:
+		p.unsubscribe.complete(err)
+	}
+}
+
+// connection is one network connection to a server, a reconnect opens a new one
+type connection struct {
+	net.Conn
+	keepAlive time.Duration
+	writeMu   sync.Mutex
+	lastSent  atomic.Int64
+	pingSent  atomic.Int64 // time of the outstanding PINGREQ in unix nanoseconds, zero when none
+	outbox    *outbox
+	done      chan struct{}
+	once      sync.Once
+}
+
+func newConnection(conn net.Conn, depth int, observer Observer) *connection {
+	c := &connection{Conn: conn, outbox: newOutbox(depth), done: make(chan struct{})}
+	c.outbox.depth = observer.QueueDepth
+	return c
+}
+
+// write encodes a packet and writes it with a single call
+func (c *connection) write(pk encoding.Packet, timeout time.Duration) error {
+	buf, err := encoding.EncodePooled(pk)
+	if err != nil {
+		return err
+	}
+	defer buf.Release()
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if timeout > 0 {
+		_ = c.SetWriteDeadline(time.Now().Add(timeout))
+		defer c.SetWriteDeadline(time.Time{})
+	}
+	if _, err := c.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	c.lastSent.Store(time.Now().UnixNano())
+	return nil
+}
+
+func (c *connection) close() {
+	c.once.Do(func() {
+		close(c.done)
+		c.outbox.close()
+		_ = c.Conn.Close()
+	})
+}
+
+// mqttClient implements Client
+type mqttClient struct {
+	options  ClientOptions
+	routes   router
+	observer Observer
+
+	mu       sync.Mutex
+	status   status
+	conn     *connection
+	stop     chan struct{} // closed by Disconnect, ends connect retries and reconnects
+	nextID   uint16
+	pending  map[uint16]*pending
+	received map[uint16]struct{} // QoS 2 messages received and not yet released
+}
+
+// NewClient creates a client, call Connect to connect it
+// A client whose first server is inproc://name connects to the embedded broker registered under name
+func NewClient(o *ClientOptions) Client {
+	if o == nil {
+		o = NewClientOptions()
+	}
+	observer := o.Observer
+	if observer == nil {
+		observer = NopObserver{}
+	}
+	if len(o.Servers) > 0 && o.Servers[0].Scheme == broker.Scheme {
+		return &localClient{options: *o, observer: observer}
+	}
+	return &mqttClient{
+		options:  *o,
+		observer: observer,
+		pending:  make(map[uint16]*pending),
+		received: make(map[uint16]struct{}),
+	}
+}
+
+// IsConnected reports whether the client is connected or is reconnecting automatically
+func (c *mqttClient) IsConnected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch c.status {
+	case connected:
+		return true
+	case reconnecting:
+		return c.options.AutoReconnect
+	case connecting:
+		return c.options.ConnectRetry
+	}
+	return false
+}
+
+// IsConnectionOpen reports whether the connection to the server is up
+func (c *mqttClient) IsConnectionOpen() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status == connected
+}
+
+// Connect connects to the first server that accepts the connection, retrying when ConnectRetry is set
+func (c *mqttClient) Connect() Token {
+	t := newConnectToken()
+	if len(c.options.Servers) == 0 && c.options.ServerDiscovery == nil {
+		t.complete(ErrNoServers)
+		return t
+	}
+
+	c.mu.Lock()
+	if c.status != disconnected {
+		c.mu.Unlock()
+		t.complete(nil)
+		return t
+	}
+	c.status = connecting
+	stop := make(chan struct{})
+	c.stop = stop
+	c.mu.Unlock()
+
+	go func() {
+		for {
+			err := c.attempt(t, stop)
+			if err == nil {
+				return
+			}
+			if !c.options.ConnectRetry || errors.Is(err, ErrDisconnected) {
+				c.mu.Lock()
+				if c.stop == stop && c.status == connecting {
+					c.status = disconnected
+				}
+				c.mu.Unlock()
+				t.complete(err)
+				return
+			}
+			select {
+			case <-stop:
+				t.complete(ErrDisconnected)
+				return
+			case <-time.After(c.options.ConnectRetryInterval):
+			}
+		}
+	}()
+	return t
+}
+
+// reconnect reconnects with a backoff doubling from one second up to MaxReconnectInterval
+func (c *mqttClient) reconnect(stop chan struct{}) {
+	delay := min(time.Second, c.options.MaxReconnectInterval)
+	for {
+		c.observer.Reconnecting()
+		if c.options.OnReconnecting != nil {
+			c.options.OnReconnecting(c, &c.options)
+		}
+		err := c.attempt(nil, stop)
+		if err == nil || errors.Is(err, ErrDisconnected) {
+			return
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, c.options.MaxReconnectInterval)
+	}
+}
+
+// attempt opens a connection and starts serving it, t is nil for reconnects
+func (c *mqttClient) attempt(t *ConnectToken, stop chan struct{}) error {
+	select {
+	case <-stop:
+		return ErrDisconnected
+	default:
+	}
+
+	conn, br, connack, err := c.dial()
+	if err != nil {
+		if t != nil && connack != nil {
+			t.returnCode = returnCode(connack.ReasonCode)
+		}
+		return err
+	}
+
+	c.mu.Lock()
+	if c.stop != stop || (c.status != connecting && c.status != reconnecting) {
+		c.mu.Unlock()
+		conn.close()
+		return ErrDisconnected
+	}
+	c.status = connected
+	c.conn = conn
+	if !connack.SessionPresent {
+		clear(c.received)
+	}
+	c.mu.Unlock()
+
+	go c.read(conn, br)
+	go c.send(conn)
+	go c.keepAlive(conn)
+	c.observer.Connected(t == nil)
+	if t != nil {
+		t.sessionPresent = connack.SessionPresent
+		t.complete(nil)
+	}
+	if c.options.OnConnect != nil {
+		go c.options.OnConnect(c)
+	}
+	return nil
+}
+
+// dial tries the servers in order and returns the first accepted connection, or the last refusal
+func (c *mqttClient) dial() (*connection, *bufio.Reader, *encoding.ConnackPacket, error) {
+	servers := c.options.Servers
+	discovery := c.options.ServerDiscovery
+	if discovery != nil {
+		servers = discovery.Servers()
+	}
+	if len(servers) == 0 {
+		return nil, nil, nil, ErrNoServers
+	}
+
+	var (
+		refusal *encoding.ConnackPacket
+		lastErr error
+	)
+	for _, server := range servers {
+		conn, br, connack, err := c.dialServer(server)
+		if discovery != nil {
+			// A refusing server is up, only failures to reach it count against its health
+			if connack != nil {
+				discovery.Report(server, nil)
+			} else {
+				discovery.Report(server, err)
+			}
+		}
+		if err == nil {
+			return conn, br, connack, nil
+		}
+		if connack != nil {
+			refusal = connack
+		}
+		lastErr = err
+	}
+	return nil, nil, refusal, lastErr
+}
+
+func (c *mqttClient) dialServer(server *url.URL) (*connection, *bufio.Reader, *encoding.ConnackPacket, error) {
+	config := client.DefaultDialerConfig()
+	config.Timeout = c.options.ConnectTimeout
+	config.TLSConfig = c.options.TLSConfig
+	config.Header = c.options.HTTPHeaders
+	if c.options.Dialer != nil {
+		config.DialContext = c.options.Dialer.DialContext
+	}
+	dialer, err := client.NewDialer(server, config)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	netConn, err := dialer.DialContext(context.Background(), server)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if c.options.ConnectTimeout > 0 {
+		_ = netConn.SetDeadline(time.Now().Add(c.options.ConnectTimeout))
+	}
+	conn := newConnection(netConn, int(c.options.MessageChannelDepth), c.observer)
+	if err := conn.write(c.connectPacket(server), 0); err != nil {
+		conn.close()
+		return nil, nil, nil, err
+	}
+
+	br := bufio.NewReader(netConn)
+	pk, err := encoding.ParsePacket(br, encoding.ProtocolVersion50)
+	if err != nil {
+		conn.close()
+		return nil, nil, nil, err
+	}
+	connack, ok := pk.(*encoding.ConnackPacket)
+	if !ok {
+		conn.close()
+		return nil, nil, nil, fmt.Errorf("%w: %s instead of CONNACK", ErrUnexpectedPacket, pk.Type())
+	}
+	if connack.ReasonCode.IsError() {
+		conn.close()
+		return nil, nil, connack, fmt.Errorf("%w: reason code %#02x", ErrConnectionRefused, byte(connack.ReasonCode))
+	}
+	_ = netConn.SetDeadline(time.Time{})
+
+	conn.keepAlive = time.Duration(c.options.KeepAlive) * time.Second
+	if prop := connack.Properties.GetProperty(encoding.PropServerKeepAlive); prop != nil {
+		if v, ok := prop.Value.(uint16); ok {
+			conn.keepAlive = time.Duration(v) * time.Second
+		}
+	}
+	return conn, br, connack, nil
+}
+
+func (c *mqttClient) connectPacket(server *url.URL) *encoding.ConnectPacket {
+	username, password := c.options.Username, c.options.Password
+	if c.options.CredentialsProvider != nil {
+		username, password = c.options.CredentialsProvider()
+	}
+	if username == "" && server.User != nil {
+		username = server.User.Username()
+		password, _ = server.User.Password()
+	}
+
+	pk := &encoding.ConnectPacket{
+		FixedHeader:     encoding.FixedHeader{Type: encoding.CONNECT},
+		ProtocolName:    "MQTT",
+		ProtocolVersion: encoding.ProtocolVersion50,
+		CleanStart:      c.options.CleanSession,
+		KeepAlive:       uint16(c.options.KeepAlive),
+		ClientID:        c.options.ClientID,
+		Username:        username,
+		UsernameFlag:    username != "",
+		Password:        []byte(password),
+		PasswordFlag:    password != "",
+	}
+	if !c.options.CleanSession {
+		// A persistent MQTT 3.1.1 session lasts until the next clean connect
+		_ = pk.Properties.AddProperty(encoding.PropSessionExpiryInterval, uint32(math.MaxUint32))
+	}
+	if c.options.WillEnabled {
+		pk.WillFlag = true
+		pk.WillTopic = c.options.WillTopic
+		pk.WillPayload = c.options.WillPayload
+		pk.WillQoS = encoding.QoS(c.options.WillQos)
+		pk.WillRetain = c.options.WillRetained
+	}
+	return pk
+}
+
+// returnCode maps an MQTT 5 CONNACK reason code to the MQTT 3.1.1 return code paho reports,
+// refusals without a 3.1.1 counterpart keep their MQTT 5 code
+func returnCode(rc encoding.ReasonCode) byte {
+	switch rc {
+	case encoding.ReasonSuccess:
+		return 0
+	case encoding.ReasonUnsupportedProtocolVersion:
+		return 1
+	case encoding.ReasonClientIdentifierNotValid:
+		return 2
+	case encoding.ReasonServerUnavailable, encoding.ReasonServerBusy:
+		return 3
+	case encoding.ReasonBadUsernameOrPassword:
+		return 4
+	case encoding.ReasonNotAuthorized, encoding.ReasonBanned:
+		return 5
+	}
+	return byte(rc)
+}
+
+func (c *mqttClient) read(conn *connection, br *bufio.Reader) {
+	for {
+		pk, err := encoding.ParsePacket(br, encoding.ProtocolVersion50)
+		if err == nil {
+			err = c.handle(conn, pk)
+		}
+		if err != nil {
+			c.connectionLost(conn, err)
+			return
+		}
+	}
+}
+
+func (c *mqttClient) handle(conn *connection, pk encoding.Packet) error {
+	switch p := pk.(type) {
+	case *encoding.PublishPacket:
+		c.receive(conn, p)
+	case *encoding.PubackPacket:
+		c.published(p.PacketID, p.ReasonCode)
+	case *encoding.PubrecPacket:
+		if p.ReasonCode.IsError() {
+			c.published(p.PacketID, p.ReasonCode)
+			return nil
+		}
+		return conn.write(&encoding.PubrelPacket{PacketID: p.PacketID}, c.options.WriteTimeout)
+	case *encoding.PubcompPacket:
+		c.published(p.PacketID, p.ReasonCode)
+	case *encoding.PubrelPacket:
+		c.mu.Lock()
+		delete(c.received, p.PacketID)
+		c.mu.Unlock()
+		return conn.write(&encoding.PubcompPacket{PacketID: p.PacketID}, c.options.WriteTimeout)
+	case *encoding.SubackPacket:
+		c.subscribed(p)
+	case *encoding.UnsubackPacket:
+		c.unsubscribed(p)
+	case *encoding.PingrespPacket:
+		conn.pingSent.Store(0)
+	case *encoding.DisconnectPacket:
+		return fmt.Errorf("%w: reason code %#02x", ErrServerDisconnect, byte(p.ReasonCode))
+	default:
+		return fmt.Errorf("%w: %s", ErrUnexpectedPacket, pk.Type())
+	}
+	return nil
+}
+
+// receive passes a message to the handlers of matching routes, or to the default handler when none matches
+func (c *mqttClient) receive(conn *connection, p *encoding.PublishPacket) {
+	m := &message{
+		duplicate: p.FixedHeader.DUP,
+		qos:       byte(p.FixedHeader.QoS),
+		retained:  p.FixedHeader.Retain,
+		topic:     p.TopicName,
+		messageID: p.PacketID,
+		payload:   p.Payload,
+	}
+	c.observer.Received(m.topic, m.qos)
+	id := p.PacketID
+	switch p.FixedHeader.QoS {
+	case encoding.QoS1:
+		m.ack = func() { _ = conn.write(&encoding.PubackPacket{PacketID: id}, c.options.WriteTimeout) }
+	case encoding.QoS2:
+		m.ack = func() { _ = conn.write(&encoding.PubrecPacket{PacketID: id}, c.options.WriteTimeout) }
+		c.mu.Lock()
+		_, seen := c.received[id]
+		c.received[id] = struct{}{}
+		c.mu.Unlock()
+		if seen {
+			// A resent message that was already delivered is only acknowledged again
+			m.Ack()
+			return
+		}
+	}
+
+	handlers := c.routes.match(p.TopicName)
+	if len(handlers) == 0 && c.options.DefaultPublishHandler != nil {
+		handlers = append(handlers, c.options.DefaultPublishHandler)
+	}
+	deliver := func() {
+		for _, h := range handlers {
+			h(c, m)
+		}
+		if !c.options.AutoAckDisabled {
+			m.Ack()
+		}
+	}
+	// Ordered handlers run on the reading goroutine, as in paho they must not block
+	if c.options.Order {
+		deliver()
+	} else {
+		go deliver()
+	}
+}
+
+func (c *mqttClient) keepAlive(conn *connection) {
+	if conn.keepAlive <= 0 {
+		return
+	}
+	pingTimeout := c.options.PingTimeout
+	if pingTimeout <= 0 {
+		pingTimeout = conn.keepAlive
+	}
+
+	ticker := time.NewTicker(conn.keepAlive / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-conn.done:
+			return
+		case now := <-ticker.C:
+			if sent := conn.pingSent.Load(); sent != 0 {
+				if now.Sub(time.Unix(0, sent)) >= pingTimeout {
+					c.connectionLost(conn, ErrPingTimeout)
+					return
+				}
+				continue
+			}
+			if now.Sub(time.Unix(0, conn.lastSent.Load())) >= conn.keepAlive/2 {
+				conn.pingSent.Store(now.UnixNano())
+				if err := conn.write(&encoding.PingreqPacket{}, c.options.WriteTimeout); err != nil {
+					c.connectionLost(conn, err)
+					return
+				}
+			}
+		}
+	}
+}
+
+// connectionLost fails the pending requests of a dropped connection and reconnects when AutoReconnect is set
+func (c *mqttClient) connectionLost(conn *connection, err error) {
+	c.mu.Lock()
+	if c.conn != conn || c.status != connected {
+		// Closed by Disconnect, or already handled
+		c.mu.Unlock()
+		conn.close()
+		return
+	}
+	c.conn = nil
+	if c.options.AutoReconnect {
+		c.status = reconnecting
+	} else {
+		c.status = disconnected
+	}
+	requests := c.pending
+	c.pending = make(map[uint16]*pending)
+	stop := c.stop
+	c.mu.Unlock()
+
+	conn.close()
+	c.observer.ConnectionLost(err)
+	for _, req := range requests {
+		req.complete(fmt.Errorf("%w: %v", ErrConnectionLost, err))
+	}
+	if c.options.OnConnectionLost != nil {
+		go c.options.OnConnectionLost(c, err)
+	}
+	if c.options.AutoReconnect {
+		go c.reconnect(stop)
+	}
+}
+
+// Disconnect waits up to quiesce milliseconds for pending requests, sends DISCONNECT and closes the connection
+func (c *mqttClient) Disconnect(quiesce uint) {
+	c.mu.Lock()
+	if c.status == disconnected || c.status == disconnecting {
+		c.mu.Unlock()
+		return
+	}
+	c.status = disconnecting
+	conn := c.conn
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+	c.mu.Unlock()
+
+	if conn != nil {
+		deadline := time.Now().Add(time.Duration(quiesce) * time.Millisecond)
+		for c.inflight() > 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		_ = conn.write(&encoding.DisconnectPacket{ReasonCode: encoding.ReasonNormalDisconnection}, c.options.WriteTimeout)
+		conn.close()
+		c.observer.Disconnected()
+	}
+
+	c.mu.Lock()
+	requests := c.pending
+	c.pending = make(map[uint16]*pending)
+	c.conn = nil
+	c.status = disconnected
+	c.mu.Unlock()
+	for _, req := range requests {
+		req.complete(ErrDisconnected)
+	}
+}
+
+// inflight returns the number of requests waiting for their acknowledgement or in the outbox
+func (c *mqttClient) inflight() int {
+	c.mu.Lock()
+	n, conn := len(c.pending), c.conn
+	c.mu.Unlock()
+	if conn != nil {
+		n += conn.outbox.len()
+	}
+	return n
+}
+
+// Publish queues a payload of type string, []byte, bytes.Buffer or *bytes.Buffer and returns without waiting
+// for the write, it only blocks while MessageChannelDepth messages are queued. Messages are sent in the order
+// they were published. The token completes once the message is written for QoS 0 and once it is acknowledged
+// otherwise, messages still queued when the connection drops fail with ErrConnectionLost
+func (c *mqttClient) Publish(topicName string, qos byte, retained bool, payload any) Token {
+	t := newPublishToken()
+
+	data, ok := payloadBytes(payload)
+	if !ok {
+		t.complete(ErrInvalidPayload)
+		return t
+	}
+	if qos > 2 {
+		t.complete(ErrInvalidQoS)
+		return t
+	}
+	if err := topic.ValidateTopic(topicName); err != nil {
+		t.complete(err)
+		return t
+	}
+
+	c.mu.Lock()
+	conn := c.conn
+	if c.status != connected {
+		conn = nil
+	}
+	c.mu.Unlock()
+	if conn == nil {
+		t.complete(ErrNotConnected)
+		return t
+	}
+
+	pk := &encoding.PublishPacket{
+		FixedHeader: encoding.FixedHeader{Type: encoding.PUBLISH, QoS: encoding.QoS(qos), Retain: retained},
+		TopicName:   topicName,
+		Payload:     data,
+	}
+	if !conn.outbox.push(&outgoing{packet: pk, token: t, queued: time.Now()}) {
+		t.complete(c.closedErr())
+	}
+	return t
+}
+
+// payloadBytes returns the bytes of a payload of type string, []byte, bytes.Buffer or *bytes.Buffer
+func payloadBytes(payload any) ([]byte, bool) {
+	switch p := payload.(type) {
+	case string:
+		return []byte(p), true
+	case []byte:
+		return p, true
+	case bytes.Buffer:
+		return p.Bytes(), true
+	case *bytes.Buffer:
+		return p.Bytes(), true
+	}
+	return nil, false
+}
+
+// send writes the queued publishes of a connection until it closes, and fails those left unsent
+func (c *mqttClient) send(conn *connection) {
+	for {
+		batch, open := conn.outbox.pop()
+		if !open {
+			err := c.closedErr()
+			for _, m := range batch {
+				m.token.complete(err)
+			}
+			return
+		}
+		for _, m := range batch {
+			c.write(conn, m)
+		}
+	}
+}
+
+// write registers a queued publish for its acknowledgement and writes it
+func (c *mqttClient) write(conn *connection, m *outgoing) {
+	pk, t := m.packet, m.token
+	t.topic, t.qos, t.sent = pk.TopicName, byte(pk.FixedHeader.QoS), time.Now()
+	if _, err := c.request(pk.FixedHeader.QoS > encoding.QoS0, &pending{publish: t}, &pk.PacketID); err != nil {
+		t.complete(err)
+		return
+	}
+	t.messageID = pk.PacketID
+
+	if err := conn.write(pk, c.options.WriteTimeout); err != nil {
+		c.take(pk.PacketID)
+		t.complete(err)
+		return
+	}
+	c.observer.Published(t.topic, t.qos, t.sent.Sub(m.queued))
+	if pk.FixedHeader.QoS == encoding.QoS0 {
+		t.complete(nil)
+	}
+}
+
+// closedErr is the error of messages a closed connection left unsent
+func (c *mqttClient) closedErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.status == disconnecting || c.status == disconnected && c.stop == nil {
+		return ErrDisconnected
+	}
+	return ErrConnectionLost
+}
+
+// Subscribe subscribes to a filter, callback handles its messages when not nil
+func (c *mqttClient) Subscribe(topicFilter string, qos byte, callback MessageHandler) Token {
+	return c.SubscribeMultiple(map[string]byte{topicFilter: qos}, callback)
+}
+
+// SubscribeMultiple subscribes to several filters with one SUBSCRIBE, callback handles the messages of all of them
+func (c *mqttClient) SubscribeMultiple(filters map[string]byte, callback MessageHandler) Token {
+	t := newSubscribeToken()
+
+	names := make([]string, 0, len(filters))
+	for filter := range filters {
+		names = append(names, filter)
+	}
+	slices.Sort(names)
+
+	pk := &encoding.SubscribePacket{FixedHeader: encoding.FixedHeader{Type: encoding.SUBSCRIBE, Flags: 0x02}}
+	for _, filter := range names {
+		qos := filters[filter]
+		if qos > 2 {
+			t.complete(ErrInvalidQoS)
+			return t
+		}
+		if err := topic.ValidateTopicFilter(filter); err != nil {
+			t.complete(err)
+			return t
+		}
+		pk.Subscriptions = append(pk.Subscriptions, encoding.Subscription{TopicFilter: filter, QoS: encoding.QoS(qos)})
+	}
+	t.subs = names
+
+	conn, err := c.request(true, &pending{subscribe: t}, &pk.PacketID)
+	if err != nil {
+		t.complete(err)
+		return t
+	}
+	// Routes are added first so retained messages sent right after the SUBACK find their handler
+	if callback != nil {
+		for _, filter := range names {
+			c.routes.add(filter, callback)
+		}
+	}
+	if err := conn.write(pk, c.options.WriteTimeout); err != nil {
+		c.take(pk.PacketID)
+		t.complete(err)
+	}
+	return t
+}
+
+func (c *mqttClient) subscribed(p *encoding.SubackPacket) {
+	req := c.take(p.PacketID)
+	if req == nil || req.subscribe == nil {
+		return
+	}
+	t := req.subscribe
+	for i, filter := range t.subs {
+		granted := byte(encoding.ReasonUnspecifiedError)
+		if i < len(p.ReasonCodes) && !p.ReasonCodes[i].IsError() {
+			granted = byte(p.ReasonCodes[i])
+		}
+		t.result[filter] = granted
+	}
+	t.complete(nil)
+}
+
+// Unsubscribe unsubscribes from filters and removes their routes
+func (c *mqttClient) Unsubscribe(topics ...string) Token {
+	t := newUnsubscribeToken()
+
+	pk := &encoding.UnsubscribePacket{FixedHeader: encoding.FixedHeader{Type: encoding.UNSUBSCRIBE, Flags: 0x02}, TopicFilters: topics}
+	conn, err := c.request(true, &pending{unsubscribe: t}, &pk.PacketID)
+	if err != nil {
+		t.complete(err)
+		return t
+	}
+	for _, filter := range topics {
+		c.routes.remove(filter)
+	}
+	if err := conn.write(pk, c.options.WriteTimeout); err != nil {
+		c.take(pk.PacketID)
+		t.complete(err)
+	}
+	return t
+}
+
+func (c *mqttClient) unsubscribed(p *encoding.UnsubackPacket) {
+	req := c.take(p.PacketID)
+	if req == nil || req.unsubscribe == nil {
+		return
+	}
+	for _, rc := range p.ReasonCodes {
+		if rc.IsError() {
+			req.unsubscribe.complete(fmt.Errorf("%w: reason code %#02x", ErrUnsubscribeRejected, byte(rc)))
+			return
+		}
+	}
+	req.unsubscribe.complete(nil)
+}
+
+// published completes a QoS 1 or 2 publish on its PUBACK, PUBCOMP or failed PUBREC
+func (c *mqttClient) published(id uint16, rc encoding.ReasonCode) {
+	req := c.take(id)
+	if req == nil || req.publish == nil {
+		return
+	}
+	t := req.publish
+	var err error
+	if rc.IsError() {
+		err = fmt.Errorf("%w: reason code %#02x", ErrPublishRejected, byte(rc))
+	}
+	c.observer.Acknowledged(t.topic, t.qos, time.Since(t.sent), err)
+	t.complete(err)
+}
+
+// request returns the open connection and, when acknowledged, registers req under a new packet identifier
+func (c *mqttClient) request(acknowledged bool, req *pending, id *uint16) (*connection, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.status != connected || c.conn == nil {
+		return nil, ErrNotConnected
+	}
+	if !acknowledged {
+		return c.conn, nil
+	}
+	for range math.MaxUint16 {
+		c.nextID++
+		if c.nextID == 0 {
+			c.nextID = 1
+		}
+		if _, used := c.pending[c.nextID]; !used {
+			c.pending[c.nextID] = req
+			*id = c.nextID
+			return c.conn, nil
+		}
+	}
+	return nil, ErrNoFreePacketID
+}
+
+func (c *mqttClient) take(id uint16) *pending {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	req := c.pending[id]
+	delete(c.pending, id)
+	return req
+}
+
+// AddRoute handles messages matching topicFilter with callback without subscribing
+func (c *mqttClient) AddRoute(topicFilter string, callback MessageHandler) {
+	if callback != nil {
+		c.routes.add(topicFilter, callback)
+	}
+}
+
+// OptionsReader returns the client options
+func (c *mqttClient) OptionsReader() ClientOptionsReader {
+	return ClientOptionsReader{options: &c.options}
+}
@@ -0,0 +1,559 @@
+package paho
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/axmq/ax/discovery"
+	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/topic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testBroker is a minimal MQTT 5 server that routes publishes back to matching subscriptions of the same connection
+type testBroker struct {
+	t        *testing.T
+	listener net.Listener
+	connack  encoding.ReasonCode
+
+	mu       sync.Mutex
+	connects []*encoding.ConnectPacket
+	conns    []net.Conn
+	pings    int
+}
+
+func newTestBroker(t *testing.T) *testBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	b := &testBroker{t: t, listener: l}
+	t.Cleanup(func() {
+		_ = l.Close()
+		b.dropAll()
+	})
+	go b.accept()
+	return b
+}
+
+func (b *testBroker) url() string {
+	return "tcp://" + b.listener.Addr().String()
+}
+
+func (b *testBroker) accept() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		b.conns = append(b.conns, conn)
+		b.mu.Unlock()
+		go b.serve(conn)
+	}
+}
+
+// dropAll closes every client connection without a DISCONNECT
+func (b *testBroker) dropAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, conn := range b.conns {
+		_ = conn.Close()
+	}
+	b.conns = nil
+}
+
+func (b *testBroker) lastConnect() *encoding.ConnectPacket {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.connects) == 0 {
+		return nil
+	}
+	return b.connects[len(b.connects)-1]
+}
+
+func (b *testBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	var (
+		writeMu sync.Mutex
+		filters []string
+	)
+	write := func(pk encoding.Packet) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		_ = pk.Encode(conn)
+	}
+
+	for {
+		pk, err := encoding.ParsePacket(br, encoding.ProtocolVersion50)
+		if err != nil {
+			return
+		}
+		switch p := pk.(type) {
+		case *encoding.ConnectPacket:
+			b.mu.Lock()
+			b.connects = append(b.connects, p)
+			b.mu.Unlock()
+			write(&encoding.ConnackPacket{ReasonCode: b.connack})
+			if b.connack.IsError() {
+				return
+			}
+		case *encoding.SubscribePacket:
+			ack := &encoding.SubackPacket{PacketID: p.PacketID}
+			for _, sub := range p.Subscriptions {
+				if sub.TopicFilter == "denied/#" {
+					ack.ReasonCodes = append(ack.ReasonCodes, encoding.ReasonNotAuthorized)
+					continue
+				}
+				filters = append(filters, sub.TopicFilter)
+				ack.ReasonCodes = append(ack.ReasonCodes, encoding.ReasonCode(sub.QoS))
+			}
+			write(ack)
+		case *encoding.UnsubscribePacket:
+			write(&encoding.UnsubackPacket{PacketID: p.PacketID, ReasonCodes: make([]encoding.ReasonCode, len(p.TopicFilters))})
+		case *encoding.PublishPacket:
+			switch p.FixedHeader.QoS {
+			case encoding.QoS1:
+				write(&encoding.PubackPacket{PacketID: p.PacketID})
+			case encoding.QoS2:
+				write(&encoding.PubrecPacket{PacketID: p.PacketID})
+			}
+			for _, filter := range filters {
+				if topic.MatchFilter(filter, p.TopicName) {
+					out := &encoding.PublishPacket{
+						FixedHeader: encoding.FixedHeader{Type: encoding.PUBLISH, QoS: p.FixedHeader.QoS},
+						TopicName:   p.TopicName,
+						PacketID:    p.PacketID + 100,
+						Payload:     p.Payload,
+					}
+					write(out)
+					break
+				}
+			}
+		case *encoding.PubrelPacket:
+			write(&encoding.PubcompPacket{PacketID: p.PacketID})
+		case *encoding.PingreqPacket:
+			b.mu.Lock()
+			b.pings++
+			b.mu.Unlock()
+			write(&encoding.PingrespPacket{})
+		case *encoding.DisconnectPacket:
+			return
+		}
+	}
+}
+
+func connect(t *testing.T, opts *ClientOptions) Client {
+	c := NewClient(opts)
+	token := c.Connect()
+	require.True(t, token.WaitTimeout(5*time.Second))
+	require.NoError(t, token.Error())
+	t.Cleanup(func() { c.Disconnect(100) })
+	return c
+}
+
+func TestClientPublishSubscribe(t *testing.T) {
+	b := newTestBroker(t)
+	c := connect(t, NewClientOptions().AddBroker(b.url()).SetClientID("sensor-1"))
+	assert.True(t, c.IsConnected())
+	assert.True(t, c.IsConnectionOpen())
+
+	received := make(chan Message, 3)
+	sub := c.SubscribeMultiple(map[string]byte{"sensors/+": 2, "denied/#": 1}, func(_ Client, m Message) {
+		received <- m
+	})
+	require.True(t, sub.WaitTimeout(5*time.Second))
+	require.NoError(t, sub.Error())
+	assert.Equal(t, map[string]byte{"sensors/+": 2, "denied/#": 0x80}, sub.(*SubscribeToken).Result())
+
+	for qos := byte(0); qos <= 2; qos++ {
+		token := c.Publish("sensors/temp", qos, false, []byte{'0' + qos})
+		require.True(t, token.WaitTimeout(5*time.Second))
+		require.NoError(t, token.Error())
+		if qos > 0 {
+			assert.NotZero(t, token.(*PublishToken).MessageID())
+		}
+	}
+
+	for qos := byte(0); qos <= 2; qos++ {
+		select {
+		case m := <-received:
+			assert.Equal(t, "sensors/temp", m.Topic())
+			assert.Equal(t, qos, m.Qos())
+			assert.Equal(t, []byte{'0' + qos}, m.Payload())
+		case <-time.After(5 * time.Second):
+			t.Fatal("message not received")
+		}
+	}
+
+	unsub := c.Unsubscribe("sensors/+")
+	require.True(t, unsub.WaitTimeout(5*time.Second))
+	require.NoError(t, unsub.Error())
+
+	connectPacket := b.lastConnect()
+	require.NotNil(t, connectPacket)
+	assert.Equal(t, "sensor-1", connectPacket.ClientID)
+	assert.Equal(t, encoding.ProtocolVersion50, connectPacket.ProtocolVersion)
+	assert.True(t, connectPacket.CleanStart)
+}
+
+func TestClientPublishAsyncOrdered(t *testing.T) {
+	b := newTestBroker(t)
+	c := connect(t, NewClientOptions().AddBroker(b.url()).SetMessageChannelDepth(8))
+
+	var (
+		mu       sync.Mutex
+		received = make(map[string][]string)
+	)
+	require.NoError(t, waitToken(t, c.Subscribe("seq/+", 1, func(_ Client, m Message) {
+		mu.Lock()
+		defer mu.Unlock()
+		received[m.Topic()] = append(received[m.Topic()], string(m.Payload()))
+	})))
+
+	const n = 100
+	var want []string
+	tokens := make([]Token, 0, 2*n)
+	for i := range n {
+		want = append(want, fmt.Sprint(i))
+		tokens = append(tokens, c.Publish("seq/a", 1, false, fmt.Sprint(i)), c.Publish("seq/b", 0, false, fmt.Sprint(i)))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, token := range tokens {
+		require.NoError(t, token.WaitContext(ctx))
+	}
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received["seq/a"]) == n && len(received["seq/b"]) == n
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, want, received["seq/a"])
+	assert.Equal(t, want, received["seq/b"])
+}
+
+func TestTokenWaitContext(t *testing.T) {
+	token := newPublishToken()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, token.WaitContext(ctx), context.DeadlineExceeded)
+
+	token.complete(ErrPublishRejected)
+	assert.ErrorIs(t, token.WaitContext(context.Background()), ErrPublishRejected)
+}
+
+func TestOutbox(t *testing.T) {
+	o := newOutbox(1)
+	first, second := &outgoing{}, &outgoing{}
+	require.True(t, o.push(first))
+
+	pushed := make(chan bool)
+	go func() { pushed <- o.push(second) }()
+	select {
+	case <-pushed:
+		t.Fatal("push did not wait for room")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	batch, open := o.pop()
+	assert.True(t, open)
+	assert.Equal(t, []*outgoing{first}, batch)
+	assert.True(t, <-pushed)
+	assert.Equal(t, 1, o.len())
+
+	o.close()
+	assert.False(t, o.push(&outgoing{}))
+	batch, open = o.pop()
+	assert.False(t, open)
+	assert.Equal(t, []*outgoing{second}, batch, "unsent messages are handed back once closed")
+}
+
+func TestClientDefaultPublishHandler(t *testing.T) {
+	b := newTestBroker(t)
+	fallback := make(chan string, 1)
+	opts := NewClientOptions().AddBroker(b.url()).SetDefaultPublishHandler(func(_ Client, m Message) {
+		fallback <- m.Topic()
+	})
+	c := connect(t, opts)
+
+	// Subscribing without a callback leaves messages to the default handler
+	require.NoError(t, waitToken(t, c.Subscribe("alerts/#", 1, nil)))
+	require.NoError(t, waitToken(t, c.Publish("alerts/fire", 1, false, "smoke")))
+
+	select {
+	case name := <-fallback:
+		assert.Equal(t, "alerts/fire", name)
+	case <-time.After(5 * time.Second):
+		t.Fatal("default handler not called")
+	}
+}
+
+func TestClientPersistentSession(t *testing.T) {
+	b := newTestBroker(t)
+	connect(t, NewClientOptions().
+		AddBroker(b.url()).
+		SetCleanSession(false).
+		SetUsername("device").
+		SetPassword("secret").
+		SetWill("status/d1", "offline", 1, true))
+
+	p := b.lastConnect()
+	require.NotNil(t, p)
+	assert.False(t, p.CleanStart)
+	prop := p.Properties.GetProperty(encoding.PropSessionExpiryInterval)
+	require.NotNil(t, prop)
+	assert.Equal(t, uint32(0xFFFFFFFF), prop.Value)
+	assert.Equal(t, "device", p.Username)
+	assert.Equal(t, []byte("secret"), p.Password)
+	assert.True(t, p.WillFlag)
+	assert.Equal(t, "status/d1", p.WillTopic)
+	assert.True(t, p.WillRetain)
+}
+
+func TestClientConnectRefused(t *testing.T) {
+	b := newTestBroker(t)
+	b.connack = encoding.ReasonBadUsernameOrPassword
+
+	c := NewClient(NewClientOptions().AddBroker(b.url()))
+	token := c.Connect()
+	require.True(t, token.WaitTimeout(5*time.Second))
+	require.ErrorIs(t, token.Error(), ErrConnectionRefused)
+	assert.Equal(t, byte(4), token.(*ConnectToken).ReturnCode())
+	assert.False(t, c.IsConnected())
+}
+
+func TestClientServerDiscovery(t *testing.T) {
+	b := newTestBroker(t)
+	port := b.listener.Addr().(*net.TCPAddr).Port
+	watcher, err := discovery.NewWatcher(discovery.WatcherConfig{
+		Resolver: discovery.ResolverFunc(func(context.Context) ([]discovery.Endpoint, error) {
+			return []discovery.Endpoint{{Host: "127.0.0.1", Port: 1}, {Host: "127.0.0.1", Port: port, Priority: 1}}, nil
+		}),
+	})
+	require.NoError(t, err)
+
+	// Nothing discovered yet
+	c := NewClient(NewClientOptions().SetServerDiscovery(discovery.NewServerSet(watcher, "tcp")))
+	assert.ErrorIs(t, waitToken(t, c.Connect()), ErrNoServers)
+
+	// The preferred endpoint is down, the client falls back to the next one which ejects it
+	require.NoError(t, watcher.Refresh(context.Background()))
+	require.NoError(t, waitToken(t, c.Connect()))
+	defer c.Disconnect(0)
+	assert.NotNil(t, b.lastConnect())
+	assert.Equal(t, discovery.Endpoint{Host: "127.0.0.1", Port: port, Priority: 1}, watcher.Endpoints()[0])
+	assert.Equal(t, 1, watcher.Stats().Healthy)
+}
+
+func TestClientNotConnected(t *testing.T) {
+	c := NewClient(NewClientOptions().AddBroker("127.0.0.1:1"))
+	assert.ErrorIs(t, waitToken(t, c.Publish("a", 0, false, "x")), ErrNotConnected)
+	assert.ErrorIs(t, waitToken(t, c.Subscribe("a", 0, nil)), ErrNotConnected)
+	assert.ErrorIs(t, waitToken(t, c.Publish("a", 0, false, 42)), ErrInvalidPayload)
+	assert.ErrorIs(t, waitToken(t, c.Publish("a", 3, false, "x")), ErrInvalidQoS)
+	assert.Error(t, waitToken(t, c.Publish("a/#", 0, false, "x")))
+
+	assert.ErrorIs(t, waitToken(t, NewClient(NewClientOptions()).Connect()), ErrNoServers)
+}
+
+func TestClientReconnect(t *testing.T) {
+	b := newTestBroker(t)
+	lost := make(chan error, 1)
+	connected := make(chan struct{}, 2)
+	opts := NewClientOptions().
+		AddBroker(b.url()).
+		SetConnectionLostHandler(func(_ Client, err error) { lost <- err }).
+		SetOnConnectHandler(func(Client) { connected <- struct{}{} })
+	c := connect(t, opts)
+	<-connected
+
+	b.dropAll()
+	select {
+	case err := <-lost:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection lost handler not called")
+	}
+	assert.True(t, c.IsConnected(), "reconnecting counts as connected")
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not reconnect")
+	}
+	require.Eventually(t, c.IsConnectionOpen, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, waitToken(t, c.Publish("a", 1, false, "x")))
+}
+
+func TestClientKeepAlive(t *testing.T) {
+	b := newTestBroker(t)
+	connect(t, NewClientOptions().AddBroker(b.url()).SetKeepAlive(time.Second))
+
+	require.Eventually(t, func() bool {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return b.pings > 0
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestClientDisconnect(t *testing.T) {
+	b := newTestBroker(t)
+	c := connect(t, NewClientOptions().AddBroker(b.url()))
+
+	c.Disconnect(0)
+	assert.False(t, c.IsConnected())
+	assert.ErrorIs(t, waitToken(t, c.Publish("a", 1, false, "x")), ErrNotConnected)
+
+	// Connecting again after a disconnect works
+	require.NoError(t, waitToken(t, c.Connect()))
+	assert.True(t, c.IsConnectionOpen())
+}
+
+func TestClientOptions(t *testing.T) {
+	opts := NewClientOptions().AddBroker("localhost:1883").AddBroker("ws://broker/mqtt").AddBroker("::bad")
+	c := NewClient(opts.SetClientID("id").SetKeepAlive(90 * time.Second))
+	r := c.OptionsReader()
+	require.Len(t, r.Servers(), 2)
+	assert.Equal(t, "tcp", r.Servers()[0].Scheme)
+	assert.Equal(t, "ws", r.Servers()[1].Scheme)
+	assert.Equal(t, "id", r.ClientID())
+	assert.Equal(t, 90*time.Second, r.KeepAlive())
+	assert.True(t, r.CleanSession())
+	assert.True(t, r.AutoReconnect())
+}
+
+func TestRouterMatch(t *testing.T) {
+	var r router
+	called := make(map[string]int)
+	handler := func(name string) MessageHandler {
+		return func(Client, Message) { called[name]++ }
+	}
+	r.add("sensors/#", handler("all"))
+	r.add("$share/group/sensors/+/temp", handler("shared"))
+	r.add("sensors/+/humidity", handler("humidity"))
+
+	for _, h := range r.match("sensors/a/temp") {
+		h(nil, nil)
+	}
+	assert.Equal(t, map[string]int{"all": 1, "shared": 1}, called)
+
+	r.remove("sensors/#")
+	assert.Len(t, r.match("sensors/a/humidity"), 1)
+	assert.Empty(t, r.match("other"))
+}
+
+func waitToken(t *testing.T, token Token) error {
+	t.Helper()
+	require.True(t, token.WaitTimeout(5*time.Second))
+	return token.Error()
+}
+
+// recordingObserver records the events of a client
+type recordingObserver struct {
+	NopObserver
+	mu           sync.Mutex
+	published    []string
+	acknowledged []string
+	received     []string
+	connects     []bool
+	lost         int
+	disconnects  int
+	depths       []int
+}
+
+func (o *recordingObserver) Published(topicName string, _ byte, wait time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.published = append(o.published, topicName)
+}
+
+func (o *recordingObserver) Acknowledged(topicName string, _ byte, latency time.Duration, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err == nil && latency > 0 {
+		o.acknowledged = append(o.acknowledged, topicName)
+	}
+}
+
+func (o *recordingObserver) Received(topicName string, _ byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.received = append(o.received, topicName)
+}
+
+func (o *recordingObserver) Connected(reconnect bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.connects = append(o.connects, reconnect)
+}
+
+func (o *recordingObserver) ConnectionLost(error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.lost++
+}
+
+func (o *recordingObserver) Disconnected() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.disconnects++
+}
+
+func (o *recordingObserver) QueueDepth(depth int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.depths = append(o.depths, depth)
+}
+
+func (o *recordingObserver) snapshot() recordingObserver {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return recordingObserver{
+		published:    slices.Clone(o.published),
+		acknowledged: slices.Clone(o.acknowledged),
+		received:     slices.Clone(o.received),
+		connects:     slices.Clone(o.connects),
+		lost:         o.lost,
+		disconnects:  o.disconnects,
+		depths:       slices.Clone(o.depths),
+	}
+}
+
+func TestClientObserver(t *testing.T) {
+	b := newTestBroker(t)
+	observer := &recordingObserver{}
+	c := NewClient(NewClientOptions().AddBroker(b.url()).SetObserver(observer))
+	require.NoError(t, waitToken(t, c.Connect()))
+
+	received := make(chan struct{}, 1)
+	require.NoError(t, waitToken(t, c.Subscribe("obs/#", 1, func(Client, Message) { received <- struct{}{} })))
+	require.NoError(t, waitToken(t, c.Publish("obs/a", 1, false, "x")))
+	require.NoError(t, waitToken(t, c.Publish("obs/b", 0, false, "y")))
+	<-received
+	<-received
+
+	got := observer.snapshot()
+	assert.Equal(t, []string{"obs/a", "obs/b"}, got.published)
+	assert.Equal(t, []string{"obs/a"}, got.acknowledged, "QoS 0 publishes are not acknowledged")
+	assert.ElementsMatch(t, []string{"obs/a", "obs/b"}, got.received)
+	assert.Equal(t, []bool{false}, got.connects)
+	require.NotEmpty(t, got.depths)
+	assert.Zero(t, got.depths[len(got.depths)-1])
+
+	b.dropAll()
+	require.Eventually(t, func() bool {
+		got := observer.snapshot()
+		return got.lost == 1 && len(got.connects) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []bool{false, true}, observer.snapshot().connects)
+
+	c.Disconnect(100)
+	assert.Equal(t, 1, observer.snapshot().disconnects)
+}
@@ -0,0 +1,19 @@
+package paho
+
+import "errors"
+
+var (
+	ErrNotConnected        = errors.New("not connected")
+	ErrConnectionRefused   = errors.New("connection refused")
+	ErrConnectionLost      = errors.New("connection lost")
+	ErrServerDisconnect    = errors.New("server sent disconnect")
+	ErrPingTimeout         = errors.New("pingresp not received, disconnecting")
+	ErrNoServers           = errors.New("no servers defined to connect to")
+	ErrInvalidPayload      = errors.New("unknown payload type")
+	ErrInvalidQoS          = errors.New("invalid qos")
+	ErrNoFreePacketID      = errors.New("no free packet id")
+	ErrUnexpectedPacket    = errors.New("unexpected packet")
+	ErrPublishRejected     = errors.New("publish rejected by server")
+	ErrUnsubscribeRejected = errors.New("unsubscribe rejected by server")
+	ErrDisconnected        = errors.New("client disconnected")
+)
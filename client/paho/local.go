@@ -0,0 +1,239 @@
+package paho
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/axmq/ax/broker"
+	"github.com/axmq/ax/encoding"
+)
+
+// localClient is a Client connected to an embedded broker of the same process
+// Messages are handed over as Go values without sockets or packet encoding, so publish tokens complete before
+// Publish returns and handlers of matching subscriptions have already run. It does not reconnect automatically
+type localClient struct {
+	options  ClientOptions
+	observer Observer
+	routes   router
+
+	mu   sync.Mutex
+	conn *broker.LocalClient
+}
+
+func (c *localClient) current() *broker.LocalClient {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil || c.conn.IsClosed() {
+		return nil
+	}
+	return c.conn
+}
+
+// IsConnected reports whether the client is connected
+func (c *localClient) IsConnected() bool {
+	return c.current() != nil
+}
+
+// IsConnectionOpen reports whether the client is connected
+func (c *localClient) IsConnectionOpen() bool {
+	return c.current() != nil
+}
+
+// Connect connects to the broker registered under the host of the first server URL
+func (c *localClient) Connect() Token {
+	t := newConnectToken()
+	if c.current() != nil {
+		t.complete(nil)
+		return t
+	}
+
+	server := c.options.Servers[0]
+	b, ok := broker.Lookup(server.Host)
+	if !ok {
+		t.returnCode = returnCode(encoding.ReasonServerUnavailable)
+		t.complete(fmt.Errorf("%w: no embedded broker %q", ErrConnectionRefused, server.Host))
+		return t
+	}
+
+	username, password := c.options.Username, c.options.Password
+	if c.options.CredentialsProvider != nil {
+		username, password = c.options.CredentialsProvider()
+	}
+	conn, err := b.Connect(broker.ConnectOptions{
+		ClientID:     c.options.ClientID,
+		Username:     username,
+		Password:     []byte(password),
+		OnMessage:    c.receive,
+		OnDisconnect: c.lost,
+	})
+	if err != nil {
+		t.returnCode = returnCode(encoding.ReasonNotAuthorized)
+		t.complete(fmt.Errorf("%w: %v", ErrConnectionRefused, err))
+		return t
+	}
+
+	c.mu.Lock()
+	c.conn = conn
+	c.mu.Unlock()
+	c.observer.Connected(false)
+	t.complete(nil)
+	if c.options.OnConnect != nil {
+		go c.options.OnConnect(c)
+	}
+	return t
+}
+
+// lost reports a connection ended by the broker
+func (c *localClient) lost(rc encoding.ReasonCode) {
+	err := fmt.Errorf("%w: reason code %#02x", ErrServerDisconnect, byte(rc))
+	c.observer.ConnectionLost(err)
+	if c.options.OnConnectionLost != nil {
+		go c.options.OnConnectionLost(c, err)
+	}
+}
+
+// Disconnect disconnects at once, in-process operations are never in flight
+func (c *localClient) Disconnect(uint) {
+	c.mu.Lock()
+	conn := c.conn
+	c.conn = nil
+	c.mu.Unlock()
+
+	if conn != nil && conn.Close() == nil {
+		c.observer.Disconnected()
+	}
+}
+
+// Publish hands the message to the broker, the token is complete when Publish returns
+func (c *localClient) Publish(topicName string, qos byte, retained bool, payload any) Token {
+	t := newPublishToken()
+
+	data, ok := payloadBytes(payload)
+	if !ok {
+		t.complete(ErrInvalidPayload)
+		return t
+	}
+	if qos > 2 {
+		t.complete(ErrInvalidQoS)
+		return t
+	}
+	conn := c.current()
+	if conn == nil {
+		t.complete(ErrNotConnected)
+		return t
+	}
+
+	start := time.Now()
+	err := conn.Publish(context.Background(), &broker.Message{Topic: topicName, Payload: data, QoS: qos, Retain: retained})
+	if errors.Is(err, broker.ErrNoMatchingSubscribers) {
+		// A success reason code for network clients as well
+		err = nil
+	}
+	if err != nil {
+		err = fmt.Errorf("%w: %v", ErrPublishRejected, err)
+	}
+	c.observer.Published(topicName, qos, 0)
+	if qos > 0 {
+		c.observer.Acknowledged(topicName, qos, time.Since(start), err)
+	}
+	t.complete(err)
+	return t
+}
+
+// Subscribe subscribes to a filter, callback handles its messages when not nil
+func (c *localClient) Subscribe(topicFilter string, qos byte, callback MessageHandler) Token {
+	return c.SubscribeMultiple(map[string]byte{topicFilter: qos}, callback)
+}
+
+// SubscribeMultiple subscribes to several filters, a filter the broker refused is granted 0x80
+func (c *localClient) SubscribeMultiple(filters map[string]byte, callback MessageHandler) Token {
+	t := newSubscribeToken()
+	conn := c.current()
+	if conn == nil {
+		t.complete(ErrNotConnected)
+		return t
+	}
+
+	names := make([]string, 0, len(filters))
+	for filter := range filters {
+		if filters[filter] > 2 {
+			t.complete(ErrInvalidQoS)
+			return t
+		}
+		names = append(names, filter)
+	}
+	slices.Sort(names)
+	t.subs = names
+
+	for _, filter := range names {
+		// Routes are added first so messages published right after the subscription find their handler
+		if callback != nil {
+			c.routes.add(filter, callback)
+		}
+		granted, err := conn.Subscribe(filter, filters[filter])
+		if err != nil {
+			granted = byte(encoding.ReasonUnspecifiedError)
+		}
+		t.result[filter] = granted
+	}
+	t.complete(nil)
+	return t
+}
+
+// Unsubscribe unsubscribes from filters and removes their routes
+func (c *localClient) Unsubscribe(topics ...string) Token {
+	t := newUnsubscribeToken()
+	conn := c.current()
+	if conn == nil {
+		t.complete(ErrNotConnected)
+		return t
+	}
+
+	for _, filter := range topics {
+		c.routes.remove(filter)
+		if err := conn.Unsubscribe(filter); err != nil {
+			t.complete(fmt.Errorf("%w: %v", ErrUnsubscribeRejected, err))
+			return t
+		}
+	}
+	t.complete(nil)
+	return t
+}
+
+// receive passes a message to the handlers of matching routes, or to the default handler when none matches
+func (c *localClient) receive(msg *broker.Message) {
+	c.observer.Received(msg.Topic, msg.QoS)
+	handlers := c.routes.match(msg.Topic)
+	if len(handlers) == 0 && c.options.DefaultPublishHandler != nil {
+		handlers = append(handlers, c.options.DefaultPublishHandler)
+	}
+
+	m := &message{qos: msg.QoS, retained: msg.Retain, topic: msg.Topic, payload: msg.Payload}
+	deliver := func() {
+		for _, h := range handlers {
+			h(c, m)
+		}
+	}
+	// Ordered handlers run on the publishing goroutine and must not block
+	if c.options.Order {
+		deliver()
+	} else {
+		go deliver()
+	}
+}
+
+// AddRoute handles messages matching topicFilter with callback without subscribing
+func (c *localClient) AddRoute(topicFilter string, callback MessageHandler) {
+	if callback != nil {
+		c.routes.add(topicFilter, callback)
+	}
+}
+
+// OptionsReader returns the client options
+func (c *localClient) OptionsReader() ClientOptionsReader {
+	return ClientOptionsReader{options: &c.options}
+}
@@ -0,0 +1,67 @@
+package paho
+
+import (
+	"testing"
+	"time"
+
+	"github.com/axmq/ax/broker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalClient(t *testing.T) {
+	embedded, err := broker.New(broker.Config{Name: "paho-test"})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = embedded.Close() })
+
+	observer := &recordingObserver{}
+	lost := make(chan error, 1)
+	opts := NewClientOptions().AddBroker("inproc://paho-test").SetClientID("local-1").SetOrderMatters(true).
+		SetObserver(observer).SetConnectionLostHandler(func(_ Client, err error) { lost <- err })
+	c := connect(t, opts)
+	assert.True(t, c.IsConnected())
+
+	var received []Message
+	sub := c.Subscribe("sensors/+", 1, func(_ Client, m Message) { received = append(received, m) })
+	require.NoError(t, waitToken(t, sub))
+	assert.Equal(t, map[string]byte{"sensors/+": 1}, sub.(*SubscribeToken).Result())
+
+	// Ordered handlers have run by the time the publish token completes
+	for qos := byte(0); qos <= 2; qos++ {
+		require.NoError(t, waitToken(t, c.Publish("sensors/temp", qos, false, []byte{'0' + qos})))
+	}
+	require.Len(t, received, 3)
+	for i, m := range received {
+		assert.Equal(t, "sensors/temp", m.Topic())
+		assert.Equal(t, min(byte(i), 1), m.Qos())
+		assert.Equal(t, []byte{'0' + byte(i)}, m.Payload())
+	}
+	assert.Equal(t, uint64(3), embedded.Stats().Delivered)
+
+	require.NoError(t, waitToken(t, c.Unsubscribe("sensors/+")))
+	require.NoError(t, waitToken(t, c.Publish("sensors/temp", 0, false, "x")))
+	assert.Len(t, received, 3)
+
+	require.NoError(t, embedded.Close())
+	select {
+	case err := <-lost:
+		assert.ErrorIs(t, err, ErrServerDisconnect)
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection lost handler not called")
+	}
+	assert.False(t, c.IsConnected())
+	assert.ErrorIs(t, waitToken(t, c.Publish("a", 0, false, "x")), ErrNotConnected)
+
+	observer.mu.Lock()
+	defer observer.mu.Unlock()
+	assert.Equal(t, []bool{false}, observer.connects)
+	assert.Len(t, observer.published, 4)
+	assert.Len(t, observer.received, 3)
+	assert.Equal(t, 1, observer.lost)
+}
+
+func TestLocalClientUnknownBroker(t *testing.T) {
+	c := NewClient(NewClientOptions().AddBroker("inproc://missing"))
+	assert.ErrorIs(t, waitToken(t, c.Connect()), ErrConnectionRefused)
+	assert.False(t, c.IsConnected())
+}
@@ -0,0 +1,170 @@
+// Package metrics exports the instrumentation events of paho clients as Prometheus metrics
+//
+// Every client of a fleet reports the same metric names, so dashboards and alerts work across services
+//
+//	observer, err := metrics.NewPrometheusObserver(metrics.PrometheusConfig{
+//		ConstLabels: prometheus.Labels{"client": "orders"},
+//	})
+//	opts := mqtt.NewClientOptions().SetObserver(observer)
+//
+// Metrics are labelled by QoS but never by topic, which would grow without bound
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/axmq/ax/client/paho"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultNamespace prefixes the metric names unless PrometheusConfig.Namespace is set
+const DefaultNamespace = "mqtt_client"
+
+// DefaultLatencyBuckets spans the latencies of a local broker up to a congested link, in seconds
+var DefaultLatencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// PrometheusConfig configures a PrometheusObserver
+type PrometheusConfig struct {
+	// Registerer registers the metrics, prometheus.DefaultRegisterer when nil
+	Registerer prometheus.Registerer
+	Namespace  string
+	// ConstLabels tell apart several clients registered with the same Registerer, e.g. by client ID
+	ConstLabels prometheus.Labels
+	// Buckets are the upper bounds of the latency histograms, in seconds
+	Buckets []float64
+}
+
+// PrometheusObserver is a paho.Observer recording client events in Prometheus metrics
+type PrometheusObserver struct {
+	publishWait    *prometheus.HistogramVec
+	ackLatency     *prometheus.HistogramVec
+	published      *prometheus.CounterVec
+	acknowledged   *prometheus.CounterVec
+	received       *prometheus.CounterVec
+	connects       *prometheus.CounterVec
+	connectionLost prometheus.Counter
+	reconnects     prometheus.Counter
+	connected      prometheus.Gauge
+	queueDepth     prometheus.Gauge
+}
+
+var _ paho.Observer = (*PrometheusObserver)(nil)
+
+// NewPrometheusObserver creates the metrics and registers them
+func NewPrometheusObserver(config PrometheusConfig) (*PrometheusObserver, error) {
+	if config.Registerer == nil {
+		config.Registerer = prometheus.DefaultRegisterer
+	}
+	if config.Namespace == "" {
+		config.Namespace = DefaultNamespace
+	}
+	if len(config.Buckets) == 0 {
+		config.Buckets = DefaultLatencyBuckets
+	}
+
+	ns, labels := config.Namespace, config.ConstLabels
+	histogram := func(name, help string) *prometheus.HistogramVec {
+		return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns, Name: name, Help: help, ConstLabels: labels, Buckets: config.Buckets,
+		}, []string{"qos"})
+	}
+	o := &PrometheusObserver{
+		publishWait: histogram("publish_wait_seconds",
+			"Time publishes spent queued before they were written."),
+		ackLatency: histogram("ack_latency_seconds",
+			"Time from writing a QoS 1 or 2 publish to its PUBACK or PUBCOMP."),
+		published: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns, Name: "published_total", Help: "Publishes written.", ConstLabels: labels,
+		}, []string{"qos"}),
+		acknowledged: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns, Name: "acknowledged_total", Help: "QoS 1 and 2 publishes acknowledged by the server.",
+			ConstLabels: labels,
+		}, []string{"qos", "result"}),
+		received: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns, Name: "received_total", Help: "Messages received.", ConstLabels: labels,
+		}, []string{"qos"}),
+		connects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns, Name: "connects_total", Help: "Successful connects, by connect or automatic reconnect.",
+			ConstLabels: labels,
+		}, []string{"kind"}),
+		connectionLost: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: ns, Name: "connection_lost_total", Help: "Connections dropped unexpectedly.", ConstLabels: labels,
+		}),
+		reconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: ns, Name: "reconnect_attempts_total", Help: "Automatic reconnect attempts.", ConstLabels: labels,
+		}),
+		connected: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: ns, Name: "connected", Help: "Whether the connection to the server is up.", ConstLabels: labels,
+		}),
+		queueDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: ns, Name: "queue_depth", Help: "Publishes queued for sending.", ConstLabels: labels,
+		}),
+	}
+
+	for _, c := range []prometheus.Collector{
+		o.publishWait, o.ackLatency, o.published, o.acknowledged, o.received,
+		o.connects, o.connectionLost, o.reconnects, o.connected, o.queueDepth,
+	} {
+		if err := config.Registerer.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+func qosLabel(qos byte) string {
+	return strconv.Itoa(int(qos))
+}
+
+// Published records the queue wait of a written publish
+func (o *PrometheusObserver) Published(_ string, qos byte, wait time.Duration) {
+	o.published.WithLabelValues(qosLabel(qos)).Inc()
+	o.publishWait.WithLabelValues(qosLabel(qos)).Observe(wait.Seconds())
+}
+
+// Acknowledged records the acknowledgement latency and result of a publish
+func (o *PrometheusObserver) Acknowledged(_ string, qos byte, latency time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "rejected"
+	}
+	o.acknowledged.WithLabelValues(qosLabel(qos), result).Inc()
+	o.ackLatency.WithLabelValues(qosLabel(qos)).Observe(latency.Seconds())
+}
+
+// Received counts a received message
+func (o *PrometheusObserver) Received(_ string, qos byte) {
+	o.received.WithLabelValues(qosLabel(qos)).Inc()
+}
+
+// Connected counts a connect and marks the client connected
+func (o *PrometheusObserver) Connected(reconnect bool) {
+	kind := "connect"
+	if reconnect {
+		kind = "reconnect"
+	}
+	o.connects.WithLabelValues(kind).Inc()
+	o.connected.Set(1)
+}
+
+// ConnectionLost counts a dropped connection and marks the client disconnected
+func (o *PrometheusObserver) ConnectionLost(error) {
+	o.connectionLost.Inc()
+	o.connected.Set(0)
+}
+
+// Disconnected marks the client disconnected
+func (o *PrometheusObserver) Disconnected() {
+	o.connected.Set(0)
+}
+
+// Reconnecting counts a reconnect attempt
+func (o *PrometheusObserver) Reconnecting() {
+	o.reconnects.Inc()
+}
+
+// QueueDepth records the number of queued publishes
+func (o *PrometheusObserver) QueueDepth(depth int) {
+	o.queueDepth.Set(float64(depth))
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheusObserver(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	o, err := NewPrometheusObserver(PrometheusConfig{
+		Registerer:  reg,
+		ConstLabels: prometheus.Labels{"client": "orders"},
+	})
+	require.NoError(t, err)
+
+	o.Connected(false)
+	o.Published("a", 1, time.Millisecond)
+	o.Published("a", 1, 2*time.Millisecond)
+	o.Acknowledged("a", 1, 5*time.Millisecond, nil)
+	o.Acknowledged("a", 1, 5*time.Millisecond, errors.New("rejected"))
+	o.Received("b", 0)
+	o.QueueDepth(3)
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(o.connects.WithLabelValues("connect")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(o.connected))
+	assert.Equal(t, float64(2), testutil.ToFloat64(o.published.WithLabelValues("1")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(o.acknowledged.WithLabelValues("1", "success")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(o.acknowledged.WithLabelValues("1", "rejected")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(o.received.WithLabelValues("0")))
+	assert.Equal(t, float64(3), testutil.ToFloat64(o.queueDepth))
+	assert.Equal(t, 1, testutil.CollectAndCount(o.ackLatency))
+	assert.Equal(t, 1, testutil.CollectAndCount(o.publishWait))
+
+	o.ConnectionLost(errors.New("eof"))
+	o.Reconnecting()
+	o.Reconnecting()
+	assert.Zero(t, testutil.ToFloat64(o.connected))
+	assert.Equal(t, float64(1), testutil.ToFloat64(o.connectionLost))
+	assert.Equal(t, float64(2), testutil.ToFloat64(o.reconnects))
+
+	o.Connected(true)
+	o.Disconnected()
+	assert.Equal(t, float64(1), testutil.ToFloat64(o.connects.WithLabelValues("reconnect")))
+	assert.Zero(t, testutil.ToFloat64(o.connected))
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	names := make([]string, 0, len(families))
+	for _, f := range families {
+		names = append(names, f.GetName())
+		for _, m := range f.GetMetric() {
+			assert.Equal(t, "orders", m.GetLabel()[0].GetValue())
+		}
+	}
+	assert.Contains(t, names, "mqtt_client_ack_latency_seconds")
+	assert.Contains(t, names, "mqtt_client_publish_wait_seconds")
+
+	// A second observer needs its own labels on the same registry
+	_, err = NewPrometheusObserver(PrometheusConfig{Registerer: reg, ConstLabels: prometheus.Labels{"client": "orders"}})
+	assert.Error(t, err)
+	_, err = NewPrometheusObserver(PrometheusConfig{Registerer: reg, ConstLabels: prometheus.Labels{"client": "billing"}})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,53 @@
+package paho
+
+import "time"
+
+// Observer receives the instrumentation events of a client, e.g. to export metrics
+// Methods are called on the client goroutines, some with locks held, so they must return quickly and must not
+// call back into the client
+type Observer interface {
+	// Published is called once a publish is written, wait is the time it spent queued since the Publish call
+	Published(topic string, qos byte, wait time.Duration)
+	// Acknowledged is called when a QoS 1 or 2 publish is acknowledged, latency is measured from its write
+	// and err is set when the server rejected the message
+	Acknowledged(topic string, qos byte, latency time.Duration, err error)
+	// Received is called for every message received, including resent ones
+	Received(topic string, qos byte)
+	// Connected is called after every successful connect, reconnect is set for automatic reconnects
+	Connected(reconnect bool)
+	// ConnectionLost is called when an established connection drops unexpectedly
+	ConnectionLost(err error)
+	// Disconnected is called when Disconnect closes the connection
+	Disconnected()
+	// Reconnecting is called before every automatic reconnect attempt
+	Reconnecting()
+	// QueueDepth is called whenever the number of publishes queued for sending changes
+	QueueDepth(depth int)
+}
+
+// NopObserver ignores every event, embed it to implement only some of the Observer methods
+type NopObserver struct{}
+
+// Published does nothing
+func (NopObserver) Published(string, byte, time.Duration) {}
+
+// Acknowledged does nothing
+func (NopObserver) Acknowledged(string, byte, time.Duration, error) {}
+
+// Received does nothing
+func (NopObserver) Received(string, byte) {}
+
+// Connected does nothing
+func (NopObserver) Connected(bool) {}
+
+// ConnectionLost does nothing
+func (NopObserver) ConnectionLost(error) {}
+
+// Disconnected does nothing
+func (NopObserver) Disconnected() {}
+
+// Reconnecting does nothing
+func (NopObserver) Reconnecting() {}
+
+// QueueDepth does nothing
+func (NopObserver) QueueDepth(int) {}
@@ -0,0 +1,350 @@
+package paho
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// MessageHandler is called for every message received on a subscription
+type MessageHandler func(Client, Message)
+
+// OnConnectHandler is called after every successful connect, including reconnects
+type OnConnectHandler func(Client)
+
+// ConnectionLostHandler is called when an established connection drops unexpectedly
+type ConnectionLostHandler func(Client, error)
+
+// ReconnectHandler is called before every reconnect attempt
+type ReconnectHandler func(Client, *ClientOptions)
+
+// CredentialsProvider returns the username and password for each connect
+type CredentialsProvider func() (username string, password string)
+
+// ServerDiscovery supplies the servers of every connect and reconnect in place of Servers, e.g. a
+// discovery.ServerSet resolving the brokers from DNS or Kubernetes
+type ServerDiscovery interface {
+	// Servers returns the servers in the order to try them
+	Servers() []*url.URL
+	// Report tells how connecting to server went, a nil error is a success
+	Report(server *url.URL, err error)
+}
+
+// ClientOptions configures a client, the setters and defaults match paho
+type ClientOptions struct {
+	Servers              []*url.URL
+	ServerDiscovery      ServerDiscovery
+	ClientID             string
+	Username             string
+	Password             string
+	CredentialsProvider  CredentialsProvider
+	CleanSession         bool
+	Order                bool
+	WillEnabled          bool
+	WillTopic            string
+	WillPayload          []byte
+	WillQos              byte
+	WillRetained         bool
+	TLSConfig            *tls.Config
+	KeepAlive            int64
+	PingTimeout          time.Duration
+	ConnectTimeout       time.Duration
+	MaxReconnectInterval time.Duration
+	AutoReconnect        bool
+	ConnectRetryInterval time.Duration
+	ConnectRetry         bool
+	WriteTimeout         time.Duration
+	MessageChannelDepth  uint
+	AutoAckDisabled      bool
+	HTTPHeaders          http.Header
+	Dialer               *net.Dialer
+
+	DefaultPublishHandler MessageHandler
+	OnConnect             OnConnectHandler
+	OnConnectionLost      ConnectionLostHandler
+	OnReconnecting        ReconnectHandler
+	Observer              Observer
+}
+
+// NewClientOptions returns options with paho's defaults
+func NewClientOptions() *ClientOptions {
+	return &ClientOptions{
+		CleanSession:         true,
+		Order:                true,
+		KeepAlive:            30,
+		PingTimeout:          10 * time.Second,
+		ConnectTimeout:       30 * time.Second,
+		MaxReconnectInterval: 10 * time.Minute,
+		AutoReconnect:        true,
+		ConnectRetryInterval: 30 * time.Second,
+		MessageChannelDepth:  100,
+		HTTPHeaders:          make(http.Header),
+	}
+}
+
+// AddBroker adds a server URI such as tcp://host:1883, ssl://, ws://, wss:// or inproc://name, a URI without scheme is taken as tcp
+// URIs that cannot be parsed are ignored, as in paho
+func (o *ClientOptions) AddBroker(server string) *ClientOptions {
+	if !strings.Contains(server, "://") {
+		server = "tcp://" + server
+	}
+	if u, err := url.Parse(server); err == nil {
+		o.Servers = append(o.Servers, u)
+	}
+	return o
+}
+
+// SetServerDiscovery takes the servers from d on every connect, the servers added with AddBroker are ignored then
+func (o *ClientOptions) SetServerDiscovery(d ServerDiscovery) *ClientOptions {
+	o.ServerDiscovery = d
+	return o
+}
+
+// SetClientID sets the client identifier, an empty ID lets the server assign one
+func (o *ClientOptions) SetClientID(id string) *ClientOptions {
+	o.ClientID = id
+	return o
+}
+
+// SetUsername sets the username, a username in the server URI is used when empty
+func (o *ClientOptions) SetUsername(u string) *ClientOptions {
+	o.Username = u
+	return o
+}
+
+// SetPassword sets the password
+func (o *ClientOptions) SetPassword(p string) *ClientOptions {
+	o.Password = p
+	return o
+}
+
+// SetCredentialsProvider sets a function asked for the username and password on every connect
+func (o *ClientOptions) SetCredentialsProvider(p CredentialsProvider) *ClientOptions {
+	o.CredentialsProvider = p
+	return o
+}
+
+// SetCleanSession discards the session on connect when true, otherwise the server keeps it indefinitely
+func (o *ClientOptions) SetCleanSession(clean bool) *ClientOptions {
+	o.CleanSession = clean
+	return o
+}
+
+// SetOrderMatters calls message handlers one at a time in arrival order when true, concurrently otherwise
+func (o *ClientOptions) SetOrderMatters(order bool) *ClientOptions {
+	o.Order = order
+	return o
+}
+
+// SetTLSConfig sets the TLS configuration of ssl://, tls:// and wss:// servers
+func (o *ClientOptions) SetTLSConfig(t *tls.Config) *ClientOptions {
+	o.TLSConfig = t
+	return o
+}
+
+// SetKeepAlive sets the keep alive interval, it is rounded down to seconds
+func (o *ClientOptions) SetKeepAlive(k time.Duration) *ClientOptions {
+	o.KeepAlive = int64(k / time.Second)
+	return o
+}
+
+// SetPingTimeout sets how long to wait for a PINGRESP before the connection counts as lost
+func (o *ClientOptions) SetPingTimeout(t time.Duration) *ClientOptions {
+	o.PingTimeout = t
+	return o
+}
+
+// SetConnectTimeout bounds dialing and the CONNECT handshake, zero means no limit
+func (o *ClientOptions) SetConnectTimeout(t time.Duration) *ClientOptions {
+	o.ConnectTimeout = t
+	return o
+}
+
+// SetMaxReconnectInterval caps the backoff between reconnect attempts
+func (o *ClientOptions) SetMaxReconnectInterval(t time.Duration) *ClientOptions {
+	o.MaxReconnectInterval = t
+	return o
+}
+
+// SetAutoReconnect reconnects after a lost connection when true
+func (o *ClientOptions) SetAutoReconnect(a bool) *ClientOptions {
+	o.AutoReconnect = a
+	return o
+}
+
+// SetConnectRetryInterval sets the wait between attempts of the initial connect
+func (o *ClientOptions) SetConnectRetryInterval(t time.Duration) *ClientOptions {
+	o.ConnectRetryInterval = t
+	return o
+}
+
+// SetConnectRetry keeps retrying the initial connect until it succeeds when true,
+// the connect token completes only then
+func (o *ClientOptions) SetConnectRetry(a bool) *ClientOptions {
+	o.ConnectRetry = a
+	return o
+}
+
+// SetWriteTimeout bounds each write to the connection, zero means no limit
+func (o *ClientOptions) SetWriteTimeout(t time.Duration) *ClientOptions {
+	o.WriteTimeout = t
+	return o
+}
+
+// SetMessageChannelDepth sets how many publishes are queued for sending before Publish blocks
+func (o *ClientOptions) SetMessageChannelDepth(s uint) *ClientOptions {
+	o.MessageChannelDepth = s
+	return o
+}
+
+// SetAutoAckDisabled leaves acknowledging received messages to Message.Ack when true
+func (o *ClientOptions) SetAutoAckDisabled(autoAckDisabled bool) *ClientOptions {
+	o.AutoAckDisabled = autoAckDisabled
+	return o
+}
+
+// SetWill sets the last will published when the connection drops
+func (o *ClientOptions) SetWill(topic string, payload string, qos byte, retained bool) *ClientOptions {
+	return o.SetBinaryWill(topic, []byte(payload), qos, retained)
+}
+
+// SetBinaryWill sets the last will with a binary payload
+func (o *ClientOptions) SetBinaryWill(topic string, payload []byte, qos byte, retained bool) *ClientOptions {
+	o.WillEnabled = true
+	o.WillTopic = topic
+	o.WillPayload = payload
+	o.WillQos = qos
+	o.WillRetained = retained
+	return o
+}
+
+// UnsetWill removes the last will
+func (o *ClientOptions) UnsetWill() *ClientOptions {
+	o.WillEnabled = false
+	return o
+}
+
+// SetHTTPHeaders sets the headers sent with the WebSocket handshake
+func (o *ClientOptions) SetHTTPHeaders(h http.Header) *ClientOptions {
+	o.HTTPHeaders = h
+	return o
+}
+
+// SetDialer sets the dialer used to open connections
+func (o *ClientOptions) SetDialer(dialer *net.Dialer) *ClientOptions {
+	o.Dialer = dialer
+	return o
+}
+
+// SetDefaultPublishHandler sets the handler of messages no route matches
+func (o *ClientOptions) SetDefaultPublishHandler(defaultHandler MessageHandler) *ClientOptions {
+	o.DefaultPublishHandler = defaultHandler
+	return o
+}
+
+// SetOnConnectHandler sets the handler called after every successful connect
+func (o *ClientOptions) SetOnConnectHandler(onConn OnConnectHandler) *ClientOptions {
+	o.OnConnect = onConn
+	return o
+}
+
+// SetConnectionLostHandler sets the handler called when the connection drops unexpectedly
+func (o *ClientOptions) SetConnectionLostHandler(onLost ConnectionLostHandler) *ClientOptions {
+	o.OnConnectionLost = onLost
+	return o
+}
+
+// SetReconnectingHandler sets the handler called before every reconnect attempt
+func (o *ClientOptions) SetReconnectingHandler(cb ReconnectHandler) *ClientOptions {
+	o.OnReconnecting = cb
+	return o
+}
+
+// SetObserver sets the observer receiving instrumentation events, such as publish and ack latencies
+func (o *ClientOptions) SetObserver(observer Observer) *ClientOptions {
+	o.Observer = observer
+	return o
+}
+
+// ClientOptionsReader gives read access to the options of a client
+type ClientOptionsReader struct {
+	options *ClientOptions
+}
+
+// Servers returns the server URIs
+func (r *ClientOptionsReader) Servers() []*url.URL {
+	servers := make([]*url.URL, len(r.options.Servers))
+	for i, u := range r.options.Servers {
+		nu := *u
+		servers[i] = &nu
+	}
+	return servers
+}
+
+// ClientID returns the client identifier
+func (r *ClientOptionsReader) ClientID() string {
+	return r.options.ClientID
+}
+
+// Username returns the username
+func (r *ClientOptionsReader) Username() string {
+	return r.options.Username
+}
+
+// Password returns the password
+func (r *ClientOptionsReader) Password() string {
+	return r.options.Password
+}
+
+// CleanSession returns whether the session is discarded on connect
+func (r *ClientOptionsReader) CleanSession() bool {
+	return r.options.CleanSession
+}
+
+// Order returns whether handlers are called in arrival order
+func (r *ClientOptionsReader) Order() bool {
+	return r.options.Order
+}
+
+// KeepAlive returns the keep alive interval
+func (r *ClientOptionsReader) KeepAlive() time.Duration {
+	return time.Duration(r.options.KeepAlive) * time.Second
+}
+
+// PingTimeout returns the PINGRESP timeout
+func (r *ClientOptionsReader) PingTimeout() time.Duration {
+	return r.options.PingTimeout
+}
+
+// ConnectTimeout returns the connect timeout
+func (r *ClientOptionsReader) ConnectTimeout() time.Duration {
+	return r.options.ConnectTimeout
+}
+
+// AutoReconnect returns whether lost connections are reconnected
+func (r *ClientOptionsReader) AutoReconnect() bool {
+	return r.options.AutoReconnect
+}
+
+// ConnectRetry returns whether the initial connect is retried
+func (r *ClientOptionsReader) ConnectRetry() bool {
+	return r.options.ConnectRetry
+}
+
+// WillEnabled returns whether a last will is set
+func (r *ClientOptionsReader) WillEnabled() bool {
+	return r.options.WillEnabled
+}
+
+// WillTopic returns the topic of the last will
+func (r *ClientOptionsReader) WillTopic() string {
+	return r.options.WillTopic
+}
+
+// TLSConfig returns the TLS configuration
+func (r *ClientOptionsReader) TLSConfig() *tls.Config {
+	return r.options.TLSConfig
+}
@@ -0,0 +1,90 @@
+package paho
+
+import (
+	"sync"
+	"time"
+
+	"github.com/axmq/ax/encoding"
+)
+
+// outgoing is a queued publish and the token completed once it is sent or acknowledged
+type outgoing struct {
+	packet *encoding.PublishPacket
+	token  *PublishToken
+	queued time.Time
+}
+
+// outbox queues the publishes of a connection in call order, a single sender writes them so messages
+// on a topic reach the server in the order they were published
+type outbox struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []*outgoing
+	limit  int
+	closed bool
+	// depth is told the queue length whenever it changes, nil when nobody observes it
+	depth func(int)
+}
+
+func newOutbox(limit int) *outbox {
+	if limit <= 0 {
+		limit = 1
+	}
+	o := &outbox{limit: limit}
+	o.cond = sync.NewCond(&o.mu)
+	return o
+}
+
+// push queues m, waiting while the outbox is full, and reports false once the outbox is closed
+func (o *outbox) push(m *outgoing) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for len(o.queue) >= o.limit && !o.closed {
+		o.cond.Wait()
+	}
+	if o.closed {
+		return false
+	}
+	o.queue = append(o.queue, m)
+	o.cond.Broadcast()
+	o.observe()
+	return true
+}
+
+// pop waits for queued messages and takes all of them, open is false once the outbox is closed
+// and the returned messages are the ones left unsent
+func (o *outbox) pop() (batch []*outgoing, open bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for len(o.queue) == 0 && !o.closed {
+		o.cond.Wait()
+	}
+	batch, o.queue = o.queue, nil
+	o.cond.Broadcast()
+	if len(batch) > 0 {
+		o.observe()
+	}
+	return batch, !o.closed
+}
+
+// observe reports the queue length (must be called with lock held)
+func (o *outbox) observe() {
+	if o.depth != nil {
+		o.depth(len(o.queue))
+	}
+}
+
+// close wakes the sender and every waiting publisher
+func (o *outbox) close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.closed = true
+	o.cond.Broadcast()
+}
+
+// len returns the number of queued messages
+func (o *outbox) len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.queue)
+}
@@ -0,0 +1,107 @@
+// Package paho is a client with the API of eclipse/paho.mqtt.golang built on the ax dialers and packet codec
+//
+// Applications switch by replacing the import of github.com/eclipse/paho.mqtt.golang with this package, keeping
+// the mqtt import name, e.g.
+//
+//	import mqtt "github.com/axmq/ax/client/paho"
+//
+// The client speaks MQTT 5 on the wire, a clean session maps to Clean Start and a persistent session to a
+// session that never expires. Publish queues messages and returns at once, they are sent in publish order and
+// their tokens complete on the write for QoS 0 and on the acknowledgement otherwise. Messages published while
+// the connection is down fail with ErrNotConnected instead of being queued, and queued and in-flight operations
+// fail when the connection drops
+//
+// A server URL inproc://name connects to the embedded broker registered under name by broker.New, messages
+// then skip sockets and packet encoding and publish tokens complete once the subscribers got the message
+//
+// With SetServerDiscovery the servers are looked up again for every connect and reconnect, see the discovery
+// package for DNS and Kubernetes based server sets
+package paho
+
+import "sync"
+
+// Client is the paho client interface
+type Client interface {
+	// IsConnected reports whether the client is connected or is reconnecting automatically
+	IsConnected() bool
+	// IsConnectionOpen reports whether the connection to the server is up
+	IsConnectionOpen() bool
+	// Connect connects to the first server that accepts the connection
+	Connect() Token
+	// Disconnect waits up to quiesce milliseconds for in-flight operations and disconnects
+	Disconnect(quiesce uint)
+	// Publish queues a payload of type string, []byte, bytes.Buffer or *bytes.Buffer for sending in publish order
+	Publish(topic string, qos byte, retained bool, payload any) Token
+	// Subscribe subscribes to a filter, callback handles its messages when not nil
+	Subscribe(topic string, qos byte, callback MessageHandler) Token
+	// SubscribeMultiple subscribes to several filters with one request
+	SubscribeMultiple(filters map[string]byte, callback MessageHandler) Token
+	// Unsubscribe unsubscribes from filters and removes their routes
+	Unsubscribe(topics ...string) Token
+	// AddRoute handles messages matching topic with callback without subscribing
+	AddRoute(topic string, callback MessageHandler)
+	// OptionsReader returns the client options
+	OptionsReader() ClientOptionsReader
+}
+
+// Message is a received message
+type Message interface {
+	Duplicate() bool
+	Qos() byte
+	Retained() bool
+	Topic() string
+	MessageID() uint16
+	Payload() []byte
+	// Ack acknowledges the message, it is called after the handlers return unless auto ack is disabled
+	Ack()
+}
+
+type message struct {
+	duplicate bool
+	qos       byte
+	retained  bool
+	topic     string
+	messageID uint16
+	payload   []byte
+	ack       func()
+	once      sync.Once
+}
+
+// Duplicate reports whether the server resent the message
+func (m *message) Duplicate() bool {
+	return m.duplicate
+}
+
+// Qos returns the QoS the message was delivered with
+func (m *message) Qos() byte {
+	return m.qos
+}
+
+// Retained reports whether the message is a retained message
+func (m *message) Retained() bool {
+	return m.retained
+}
+
+// Topic returns the topic name
+func (m *message) Topic() string {
+	return m.topic
+}
+
+// MessageID returns the packet identifier, zero for QoS 0
+func (m *message) MessageID() uint16 {
+	return m.messageID
+}
+
+// Payload returns the message payload
+func (m *message) Payload() []byte {
+	return m.payload
+}
+
+// Ack sends the PUBACK or PUBREC of the message once
+func (m *message) Ack() {
+	m.once.Do(func() {
+		if m.ack != nil {
+			m.ack()
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package paho
+
+import (
+	"sync"
+
+	"github.com/axmq/ax/topic"
+)
+
+type route struct {
+	filter  string
+	handler MessageHandler
+}
+
+// router dispatches received messages to the handlers of matching filters
+type router struct {
+	mu     sync.RWMutex
+	routes []route
+}
+
+// add sets the handler of filter, replacing a previous one
+func (r *router) add(filter string, handler MessageHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.routes {
+		if r.routes[i].filter == filter {
+			r.routes[i].handler = handler
+			return
+		}
+	}
+	r.routes = append(r.routes, route{filter: filter, handler: handler})
+}
+
+func (r *router) remove(filter string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.routes {
+		if r.routes[i].filter == filter {
+			r.routes = append(r.routes[:i], r.routes[i+1:]...)
+			return
+		}
+	}
+}
+
+// match returns the handlers of every filter matching name, shared subscriptions match by their topic filter
+func (r *router) match(name string) []MessageHandler {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var handlers []MessageHandler
+	for _, rt := range r.routes {
+		filter := rt.filter
+		if topic.IsSharedSubscription(filter) {
+			if _, f, err := topic.ValidateSharedSubscription(filter); err == nil {
+				filter = f
+			}
+		}
+		if topic.MatchFilter(filter, name) {
+			handlers = append(handlers, rt.handler)
+		}
+	}
+	return handlers
+}
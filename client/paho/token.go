@@ -0,0 +1,147 @@
+package paho
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Token tracks an asynchronous operation, as in paho
+type Token interface {
+	// Wait blocks until the operation completes, it always returns true
+	Wait() bool
+	// WaitTimeout blocks until the operation completes or d elapses and reports whether it completed
+	WaitTimeout(d time.Duration) bool
+	// Done is closed when the operation completes
+	Done() <-chan struct{}
+	// Error returns the error of a completed operation
+	Error() error
+	// WaitContext blocks until the operation completes or ctx is done and returns the error of either
+	WaitContext(ctx context.Context) error
+}
+
+type baseToken struct {
+	done chan struct{}
+	once sync.Once
+	err  error
+}
+
+func newBaseToken() baseToken {
+	return baseToken{done: make(chan struct{})}
+}
+
+// Wait blocks until the operation completes
+func (t *baseToken) Wait() bool {
+	<-t.done
+	return true
+}
+
+// WaitTimeout blocks until the operation completes or d elapses
+func (t *baseToken) WaitTimeout(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-t.done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// WaitContext blocks until the operation completes or ctx is done
+func (t *baseToken) WaitContext(ctx context.Context) error {
+	select {
+	case <-t.done:
+		return t.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done is closed when the operation completes
+func (t *baseToken) Done() <-chan struct{} {
+	return t.done
+}
+
+// Error returns the error of the operation, nil until it completes
+func (t *baseToken) Error() error {
+	select {
+	case <-t.done:
+		return t.err
+	default:
+		return nil
+	}
+}
+
+func (t *baseToken) complete(err error) {
+	t.once.Do(func() {
+		t.err = err
+		close(t.done)
+	})
+}
+
+// ConnectToken tracks a Connect
+type ConnectToken struct {
+	baseToken
+	returnCode     byte
+	sessionPresent bool
+}
+
+func newConnectToken() *ConnectToken {
+	return &ConnectToken{baseToken: newBaseToken()}
+}
+
+// ReturnCode returns the MQTT 3.1.1 CONNACK return code the server answered with
+func (t *ConnectToken) ReturnCode() byte {
+	return t.returnCode
+}
+
+// SessionPresent reports whether the server resumed a session
+func (t *ConnectToken) SessionPresent() bool {
+	return t.sessionPresent
+}
+
+// PublishToken tracks a Publish
+type PublishToken struct {
+	baseToken
+	messageID uint16
+	// Set when the message is written, read by the Observer on its acknowledgement
+	topic string
+	qos   byte
+	sent  time.Time
+}
+
+func newPublishToken() *PublishToken {
+	return &PublishToken{baseToken: newBaseToken()}
+}
+
+// MessageID returns the packet identifier of a QoS 1 or 2 publish, it is assigned when the message is sent
+// and can be read once the token completes
+func (t *PublishToken) MessageID() uint16 {
+	return t.messageID
+}
+
+// SubscribeToken tracks a Subscribe or SubscribeMultiple
+type SubscribeToken struct {
+	baseToken
+	subs   []string
+	result map[string]byte
+}
+
+func newSubscribeToken() *SubscribeToken {
+	return &SubscribeToken{baseToken: newBaseToken(), result: make(map[string]byte)}
+}
+
+// Result maps each filter to the granted QoS, or 0x80 when the server refused it
+func (t *SubscribeToken) Result() map[string]byte {
+	return t.result
+}
+
+// UnsubscribeToken tracks an Unsubscribe
+type UnsubscribeToken struct {
+	baseToken
+}
+
+func newUnsubscribeToken() *UnsubscribeToken {
+	return &UnsubscribeToken{baseToken: newBaseToken()}
+}
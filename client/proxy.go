@@ -0,0 +1,275 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// SOCKS5 protocol constants (RFC 1928, RFC 1929)
+const (
+	socks5Version        = 0x05
+	socks5AuthNone       = 0x00
+	socks5AuthPassword   = 0x02
+	socks5CmdConnect     = 0x01
+	socks5AddrIPv4       = 0x01
+	socks5AddrDomain     = 0x03
+	socks5AddrIPv6       = 0x04
+	socks5PasswordVer    = 0x01
+	socks5ReplySucceeded = 0x00
+)
+
+// dialThrough connects to address directly or through the configured proxy
+func dialThrough(ctx context.Context, config *DialerConfig, address string) (net.Conn, error) {
+	proxy := config.Proxy
+	if proxy == nil {
+		return dialRaw(ctx, config, address)
+	}
+
+	var defaultPort string
+	switch strings.ToLower(proxy.Scheme) {
+	case "http":
+		defaultPort = "80"
+	case "https":
+		defaultPort = "443"
+	case "socks5", "socks5h":
+		defaultPort = "1080"
+	default:
+		return nil, ErrUnsupportedProxy
+	}
+
+	conn, err := dialRaw(ctx, config, hostPort(proxy, defaultPort))
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.EqualFold(proxy.Scheme, "https") {
+		// The proxy itself is reached over TLS with its own configuration, the broker one is for the tunnel
+		if conn, err = clientTLS(ctx, conn, config.ProxyTLSConfig, proxy.Hostname()); err != nil {
+			return nil, err
+		}
+	}
+
+	stop := watchContext(ctx, conn)
+	switch strings.ToLower(proxy.Scheme) {
+	case "socks5":
+		// The proxy only sees the address the broker host resolves to here
+		var target string
+		if target, err = resolveAddress(ctx, config.Resolver, address); err == nil {
+			err = socks5Connect(conn, proxy.User, target)
+		}
+	case "socks5h":
+		err = socks5Connect(conn, proxy.User, address)
+	default:
+		conn, err = httpConnect(conn, proxy.User, address)
+	}
+	stop()
+
+	if err != nil {
+		_ = conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// resolveAddress replaces the host of address by the first address it resolves to, IP addresses are kept
+func resolveAddress(ctx context.Context, resolver *net.Resolver, address string) (string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", err
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return address, nil
+	}
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	addrs, err := resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return "", err
+	}
+	if len(addrs) == 0 {
+		return "", &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return net.JoinHostPort(addrs[0].Unmap().String(), port), nil
+}
+
+// httpConnect opens a tunnel to address with an HTTP CONNECT request
+func httpConnect(conn net.Conn, user *url.Userinfo, address string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if user != nil && user.Username() != "" {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	if err := req.Write(conn); err != nil {
+		return conn, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return conn, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return conn, fmt.Errorf("%w: %s", ErrProxyConnect, resp.Status)
+	}
+
+	return newBufferedConn(conn, br), nil
+}
+
+// socks5Connect opens a tunnel to address through a SOCKS5 proxy
+func socks5Connect(conn net.Conn, user *url.Userinfo, address string) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return err
+	}
+
+	withPassword := user != nil && user.Username() != ""
+	greeting := []byte{socks5Version, 1, socks5AuthNone}
+	if withPassword {
+		greeting = []byte{socks5Version, 2, socks5AuthNone, socks5AuthPassword}
+	}
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+
+	var reply [2]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[0] != socks5Version {
+		return ErrSOCKS5Handshake
+	}
+
+	switch reply[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if !withPassword {
+			return ErrSOCKS5Handshake
+		}
+		if err := socks5Authenticate(conn, user); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%w: no acceptable authentication method", ErrSOCKS5Handshake)
+	}
+
+	req := []byte{socks5Version, socks5CmdConnect, 0}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if ip.Is4() {
+			req = append(req, socks5AddrIPv4)
+		} else {
+			req = append(req, socks5AddrIPv6)
+		}
+		req = append(req, ip.AsSlice()...)
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("%w: host name too long", ErrSOCKS5Handshake)
+		}
+		req = append(req, socks5AddrDomain, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// Reply: version, status, reserved, bound address type, bound address, bound port
+	var head [4]byte
+	if _, err := io.ReadFull(conn, head[:]); err != nil {
+		return err
+	}
+	if head[0] != socks5Version {
+		return ErrSOCKS5Handshake
+	}
+	if head[1] != socks5ReplySucceeded {
+		return fmt.Errorf("%w: connect failed with status %d", ErrSOCKS5Handshake, head[1])
+	}
+
+	var skip int
+	switch head[3] {
+	case socks5AddrIPv4:
+		skip = net.IPv4len
+	case socks5AddrIPv6:
+		skip = net.IPv6len
+	case socks5AddrDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(conn, n[:]); err != nil {
+			return err
+		}
+		skip = int(n[0])
+	default:
+		return ErrSOCKS5Handshake
+	}
+	_, err = io.CopyN(io.Discard, conn, int64(skip+2))
+	return err
+}
+
+// socks5Authenticate runs the username/password subnegotiation
+func socks5Authenticate(conn net.Conn, user *url.Userinfo) error {
+	username := user.Username()
+	pass, _ := user.Password()
+	if len(username) > 255 || len(pass) > 255 {
+		return ErrSOCKS5Auth
+	}
+
+	req := make([]byte, 0, 3+len(username)+len(pass))
+	req = append(req, socks5PasswordVer, byte(len(username)))
+	req = append(req, username...)
+	req = append(req, byte(len(pass)))
+	req = append(req, pass...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	var reply [2]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return ErrSOCKS5Auth
+	}
+	return nil
+}
+
+// bufferedConn serves bytes read ahead during a handshake before reading from the connection again
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// newBufferedConn returns conn itself when nothing was read ahead
+func newBufferedConn(conn net.Conn, br *bufio.Reader) net.Conn {
+	if br.Buffered() == 0 {
+		return conn
+	}
+	return &bufferedConn{Conn: conn, r: br}
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
@@ -0,0 +1,287 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// proxyListener runs handle for every connection accepted on loopback
+func proxyListener(t *testing.T, handle func(conn net.Conn)) *url.URL {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return &url.URL{Host: ln.Addr().String()}
+}
+
+// tunnel connects conn to target and copies in both directions
+func tunnel(conn net.Conn, r io.Reader, target string) {
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		return
+	}
+	defer upstream.Close()
+
+	go func() { _, _ = io.Copy(upstream, r) }()
+	_, _ = io.Copy(conn, upstream)
+}
+
+func httpConnectProxy(t *testing.T, wantAuth string) *url.URL {
+	return proxyListener(t, func(conn net.Conn) {
+		br := bufio.NewReader(conn)
+		req, err := http.ReadRequest(br)
+		if err != nil || req.Method != http.MethodConnect {
+			return
+		}
+		if req.Header.Get("Proxy-Authorization") != wantAuth {
+			_, _ = io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+			return
+		}
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		tunnel(conn, br, req.Host)
+	})
+}
+
+// socks5Proxy runs a SOCKS5 proxy, the address types of the requests it serves are sent to types when not nil
+func socks5Proxy(t *testing.T, username, password string, types chan<- byte) *url.URL {
+	return proxyListener(t, func(conn net.Conn) {
+		var head [2]byte
+		if _, err := io.ReadFull(conn, head[:]); err != nil {
+			return
+		}
+		methods := make([]byte, head[1])
+		if _, err := io.ReadFull(conn, methods); err != nil {
+			return
+		}
+
+		if username == "" {
+			_, _ = conn.Write([]byte{socks5Version, socks5AuthNone})
+		} else {
+			_, _ = conn.Write([]byte{socks5Version, socks5AuthPassword})
+			var buf [256]byte
+			_, _ = io.ReadFull(conn, buf[:2])
+			user := make([]byte, buf[1])
+			_, _ = io.ReadFull(conn, user)
+			_, _ = io.ReadFull(conn, buf[:1])
+			pass := make([]byte, buf[0])
+			_, _ = io.ReadFull(conn, pass)
+			if string(user) != username || string(pass) != password {
+				_, _ = conn.Write([]byte{socks5PasswordVer, 1})
+				return
+			}
+			_, _ = conn.Write([]byte{socks5PasswordVer, 0})
+		}
+
+		var req [4]byte
+		if _, err := io.ReadFull(conn, req[:]); err != nil {
+			return
+		}
+		if types != nil {
+			types <- req[3]
+		}
+		var host string
+		switch req[3] {
+		case socks5AddrIPv4:
+			ip := make([]byte, net.IPv4len)
+			_, _ = io.ReadFull(conn, ip)
+			host = net.IP(ip).String()
+		case socks5AddrIPv6:
+			ip := make([]byte, net.IPv6len)
+			_, _ = io.ReadFull(conn, ip)
+			host = net.IP(ip).String()
+		case socks5AddrDomain:
+			var n [1]byte
+			_, _ = io.ReadFull(conn, n[:])
+			name := make([]byte, n[0])
+			_, _ = io.ReadFull(conn, name)
+			host = string(name)
+		}
+		var port [2]byte
+		_, _ = io.ReadFull(conn, port[:])
+
+		_, _ = conn.Write([]byte{socks5Version, socks5ReplySucceeded, 0, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+		tunnel(conn, conn, net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))))
+	})
+}
+
+func TestDialer_HTTPConnectProxy(t *testing.T) {
+	ln := echoListener(t)
+
+	tests := []struct {
+		name    string
+		user    *url.Userinfo
+		wantErr error
+	}{
+		{name: "with credentials", user: url.UserPassword("device", "secret")},
+		{name: "wrong credentials", user: url.UserPassword("device", "wrong"), wantErr: ErrProxyConnect},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := httpConnectProxy(t, "Basic ZGV2aWNlOnNlY3JldA==")
+			proxy.Scheme = "http"
+			proxy.User = tt.user
+
+			config := DefaultDialerConfig()
+			config.Proxy = proxy
+
+			conn, err := Dial(context.Background(), "tcp://"+ln.Addr().String(), config)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			defer conn.Close()
+			assertEcho(t, conn)
+		})
+	}
+}
+
+func TestDialer_SOCKS5Proxy(t *testing.T) {
+	ln := echoListener(t)
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	tests := []struct {
+		name     string
+		username string
+		user     *url.Userinfo
+		target   string
+		wantErr  error
+	}{
+		{name: "no auth", target: ln.Addr().String()},
+		{name: "domain target", target: net.JoinHostPort("localhost", port)},
+		{name: "password auth", username: "device", user: url.UserPassword("device", "secret"), target: ln.Addr().String()},
+		{name: "bad password", username: "device", user: url.UserPassword("device", "wrong"), target: ln.Addr().String(), wantErr: ErrSOCKS5Auth},
+		{name: "auth required", username: "device", target: ln.Addr().String(), wantErr: ErrSOCKS5Handshake},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := socks5Proxy(t, tt.username, "secret", nil)
+			proxy.Scheme = "socks5h"
+			proxy.User = tt.user
+
+			config := DefaultDialerConfig()
+			config.Proxy = proxy
+
+			conn, err := Dial(context.Background(), "tcp://"+tt.target, config)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			defer conn.Close()
+			assertEcho(t, conn)
+		})
+	}
+}
+
+func TestDialer_SOCKS5Resolution(t *testing.T) {
+	// Listen on the address localhost resolves to first, the one a socks5:// dial hands to the proxy
+	addrs, err := net.DefaultResolver.LookupNetIP(context.Background(), "ip", "localhost")
+	require.NoError(t, err)
+	require.NotEmpty(t, addrs)
+	ln := echoListenerOn(t, net.JoinHostPort(addrs[0].Unmap().String(), "0"))
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	tests := []struct {
+		scheme   string
+		wantType byte
+	}{
+		{scheme: "socks5", wantType: socks5AddrIPv4},
+		{scheme: "socks5h", wantType: socks5AddrDomain},
+	}
+	if addrs[0].Unmap().Is6() {
+		tests[0].wantType = socks5AddrIPv6
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.scheme, func(t *testing.T) {
+			types := make(chan byte, 1)
+			proxy := socks5Proxy(t, "", "", types)
+			proxy.Scheme = tt.scheme
+
+			config := DefaultDialerConfig()
+			config.Proxy = proxy
+
+			conn, err := Dial(context.Background(), "tcp://"+net.JoinHostPort("localhost", port), config)
+			require.NoError(t, err)
+			defer conn.Close()
+			assert.Equal(t, tt.wantType, <-types)
+			assertEcho(t, conn)
+		})
+	}
+}
+
+func TestDialer_HTTPSProxy(t *testing.T) {
+	ln := echoListener(t)
+
+	proxy := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		tunnel(conn, rw, r.Host)
+	}))
+	t.Cleanup(proxy.Close)
+
+	proxyURL, err := url.Parse(proxy.URL)
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(proxy.Certificate())
+
+	config := DefaultDialerConfig()
+	config.Proxy = proxyURL
+	// The broker roots do not trust the proxy, only the proxy configuration does
+	config.TLSConfig = &tls.Config{RootCAs: x509.NewCertPool(), MinVersion: tls.VersionTLS12}
+
+	_, err = Dial(context.Background(), "tcp://"+ln.Addr().String(), config)
+	assert.Error(t, err, "the proxy is not verified against the system roots")
+
+	config.ProxyTLSConfig = &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+	conn, err := Dial(context.Background(), "tcp://"+ln.Addr().String(), config)
+	require.NoError(t, err)
+	defer conn.Close()
+	assertEcho(t, conn)
+}
+
+func TestDialer_UnsupportedProxy(t *testing.T) {
+	config := DefaultDialerConfig()
+	config.Proxy = &url.URL{Scheme: "ftp", Host: "proxy:21"}
+
+	_, err := Dial(context.Background(), "tcp://broker:1883", config)
+	assert.ErrorIs(t, err, ErrUnsupportedProxy)
+}
@@ -0,0 +1,318 @@
+//go:build !js
+
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// WebSocket protocol constants (RFC 6455)
+const (
+	webSocketGUID     = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	webSocketProtocol = "mqtt"
+
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+
+	finBit  = 0x80
+	maskBit = 0x80
+
+	// maxControlPayload is the largest payload a control frame may carry
+	maxControlPayload = 125
+)
+
+// WebSocketDialer dials MQTT over WebSocket connections, wss:// URLs run over TLS
+type WebSocketDialer struct {
+	config *DialerConfig
+}
+
+// NewWebSocketDialer creates a WebSocket dialer
+func NewWebSocketDialer(config *DialerConfig) *WebSocketDialer {
+	if config == nil {
+		config = DefaultDialerConfig()
+	}
+	return &WebSocketDialer{config: config}
+}
+
+// DialContext connects to the host of u, port 80 or 443 by default, and upgrades to the mqtt subprotocol
+// MQTT packets are sent as binary frames, the returned connection reads them back as a byte stream
+func (d *WebSocketDialer) DialContext(ctx context.Context, u *url.URL) (net.Conn, error) {
+	ctx, cancel := withTimeout(ctx, d.config.Timeout)
+	defer cancel()
+
+	secure := strings.EqualFold(u.Scheme, "wss")
+	defaultPort := "80"
+	if secure {
+		defaultPort = "443"
+	}
+
+	conn, err := dialThrough(ctx, d.config, hostPort(u, defaultPort))
+	if err != nil {
+		return nil, err
+	}
+	if secure {
+		if conn, err = clientTLS(ctx, conn, d.config.TLSConfig, u.Hostname()); err != nil {
+			return nil, err
+		}
+	}
+
+	stop := watchContext(ctx, conn)
+	br, err := webSocketHandshake(conn, u, d.config.Header)
+	stop()
+
+	if err != nil {
+		_ = conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	return &webSocketConn{
+		Conn:         conn,
+		br:           br,
+		maxFrameSize: d.config.MaxFrameSize,
+	}, nil
+}
+
+// webSocketHandshake sends the upgrade request and validates the response
+func webSocketHandshake(conn net.Conn, u *url.URL, header http.Header) (*bufio.Reader, error) {
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    &url.URL{Path: u.Path, RawQuery: u.RawQuery},
+		Host:   u.Host,
+		Header: make(http.Header),
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Protocol", webSocketProtocol)
+
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+	_ = resp.Body.Close()
+
+	switch {
+	case resp.StatusCode != http.StatusSwitchingProtocols:
+		return nil, fmt.Errorf("%w: %s", ErrWebSocketHandshake, resp.Status)
+	case !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket"):
+		return nil, fmt.Errorf("%w: missing upgrade header", ErrWebSocketHandshake)
+	case resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key):
+		return nil, fmt.Errorf("%w: bad accept key", ErrWebSocketHandshake)
+	case resp.Header.Get("Sec-WebSocket-Protocol") != webSocketProtocol:
+		return nil, fmt.Errorf("%w: server did not select the mqtt subprotocol", ErrWebSocketHandshake)
+	}
+
+	return br, nil
+}
+
+// acceptKey returns the Sec-WebSocket-Accept value expected for key
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// webSocketConn carries a byte stream in WebSocket binary frames
+type webSocketConn struct {
+	net.Conn
+	br           *bufio.Reader
+	maxFrameSize int64
+
+	readMu    sync.Mutex
+	remaining int64 // payload bytes left in the current data frame
+	mask      [4]byte
+	masked    bool
+	maskPos   int
+	readErr   error
+
+	writeMu sync.Mutex
+	closed  bool
+}
+
+// Read returns payload bytes of received data frames, answering pings and close frames on the way
+func (c *webSocketConn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	for c.remaining == 0 {
+		if c.readErr != nil {
+			return 0, c.readErr
+		}
+		if err := c.nextFrame(); err != nil {
+			c.readErr = err
+			return 0, err
+		}
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.br.Read(p)
+	if c.masked {
+		for i := 0; i < n; i++ {
+			p[i] ^= c.mask[c.maskPos&3]
+			c.maskPos++
+		}
+	}
+	c.remaining -= int64(n)
+	return n, err
+}
+
+// nextFrame reads frame headers until a data frame with payload starts
+func (c *webSocketConn) nextFrame() error {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return err
+	}
+
+	opcode := head[0] & 0x0F
+	length := int64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+		if length < 0 {
+			return ErrWebSocketFrame
+		}
+	}
+
+	c.masked = head[1]&maskBit != 0
+	c.maskPos = 0
+	if c.masked {
+		if _, err := io.ReadFull(c.br, c.mask[:]); err != nil {
+			return err
+		}
+	}
+
+	switch opcode {
+	case opBinary, opText, opContinuation:
+		if c.maxFrameSize > 0 && length > c.maxFrameSize {
+			return ErrWebSocketFrameTooLarge
+		}
+		c.remaining = length
+		return nil
+	case opPing, opPong, opClose:
+		if length > maxControlPayload || head[0]&finBit == 0 {
+			return ErrWebSocketFrame
+		}
+	default:
+		return ErrWebSocketFrame
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return err
+	}
+	if c.masked {
+		for i := range payload {
+			payload[i] ^= c.mask[i&3]
+		}
+	}
+
+	switch opcode {
+	case opPing:
+		return c.writeFrame(opPong, payload)
+	case opClose:
+		// Echo the status code back and end the stream
+		if len(payload) > 2 {
+			payload = payload[:2]
+		}
+		_ = c.writeFrame(opClose, payload)
+		return io.EOF
+	}
+	return nil
+}
+
+// Write sends p as a single binary frame
+func (c *webSocketConn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(opBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close sends a normal closure frame and closes the connection
+func (c *webSocketConn) Close() error {
+	_ = c.writeFrame(opClose, []byte{0x03, 0xE8})
+	return c.Conn.Close()
+}
+
+// writeFrame writes a masked frame, as required of clients
+func (c *webSocketConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closed {
+		return net.ErrClosed
+	}
+	if opcode == opClose {
+		c.closed = true
+	}
+
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return err
+	}
+
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, finBit|opcode)
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i&3])
+	}
+
+	_, err := c.Conn.Write(frame)
+	return err
+}
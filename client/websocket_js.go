@@ -0,0 +1,263 @@
+//go:build js && wasm
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"syscall/js"
+	"time"
+)
+
+// webSocketProtocol is the subprotocol requested from the broker
+const webSocketProtocol = "mqtt"
+
+// WebSocketDialer dials MQTT over WebSocket connections through the browser WebSocket API
+// The browser owns the transport, so Proxy, DialContext, TLSConfig and Header are not used
+type WebSocketDialer struct {
+	config *DialerConfig
+}
+
+// NewWebSocketDialer creates a WebSocket dialer
+func NewWebSocketDialer(config *DialerConfig) *WebSocketDialer {
+	if config == nil {
+		config = DefaultDialerConfig()
+	}
+	return &WebSocketDialer{config: config}
+}
+
+// DialContext opens a browser WebSocket to u with the mqtt subprotocol and waits for it to open
+func (d *WebSocketDialer) DialContext(ctx context.Context, u *url.URL) (net.Conn, error) {
+	ctx, cancel := withTimeout(ctx, d.config.Timeout)
+	defer cancel()
+
+	ctor := js.Global().Get("WebSocket")
+	if ctor.IsUndefined() {
+		return nil, fmt.Errorf("%w: WebSocket is not available", ErrWebSocketHandshake)
+	}
+
+	var ws js.Value
+	if err := catchJS(func() { ws = ctor.New(u.String(), webSocketProtocol) }); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrWebSocketHandshake, err)
+	}
+	ws.Set("binaryType", "arraybuffer")
+
+	conn := &browserConn{
+		ws:     ws,
+		remote: webSocketAddr(u.String()),
+		notify: make(chan struct{}, 1),
+	}
+	opened := make(chan struct{})
+	failed := make(chan struct{})
+	var once sync.Once
+
+	conn.funcs = []js.Func{
+		js.FuncOf(func(this js.Value, args []js.Value) any {
+			close(opened)
+			return nil
+		}),
+		js.FuncOf(func(this js.Value, args []js.Value) any {
+			// Browsers hide the cause, an error before the socket opened means the handshake failed
+			select {
+			case <-opened:
+				conn.fail(io.ErrUnexpectedEOF)
+			default:
+				conn.fail(fmt.Errorf("%w: connection error", ErrWebSocketHandshake))
+			}
+			once.Do(func() { close(failed) })
+			return nil
+		}),
+		js.FuncOf(func(this js.Value, args []js.Value) any {
+			conn.fail(io.EOF)
+			once.Do(func() { close(failed) })
+			return nil
+		}),
+		js.FuncOf(func(this js.Value, args []js.Value) any {
+			data := js.Global().Get("Uint8Array").New(args[0].Get("data"))
+			buf := make([]byte, data.Length())
+			js.CopyBytesToGo(buf, data)
+			conn.push(buf)
+			return nil
+		}),
+	}
+	ws.Set("onopen", conn.funcs[0])
+	ws.Set("onerror", conn.funcs[1])
+	ws.Set("onclose", conn.funcs[2])
+	ws.Set("onmessage", conn.funcs[3])
+
+	select {
+	case <-opened:
+	case <-failed:
+		_ = conn.Close()
+		return nil, conn.readErr()
+	case <-ctx.Done():
+		_ = conn.Close()
+		return nil, ctx.Err()
+	}
+
+	if protocol := ws.Get("protocol").String(); protocol != webSocketProtocol {
+		_ = conn.Close()
+		return nil, fmt.Errorf("%w: server did not select the mqtt subprotocol", ErrWebSocketHandshake)
+	}
+
+	return conn, nil
+}
+
+// catchJS runs fn and converts a thrown JavaScript exception into an error
+func catchJS(fn func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	fn()
+	return nil
+}
+
+// webSocketAddr is the address of a browser WebSocket, identified by its URL
+type webSocketAddr string
+
+func (a webSocketAddr) Network() string { return "websocket" }
+func (a webSocketAddr) String() string  { return string(a) }
+
+// browserConn adapts a browser WebSocket to net.Conn, received messages are queued
+// by the event callbacks so the JavaScript event loop never blocks
+type browserConn struct {
+	ws     js.Value
+	remote net.Addr
+	funcs  []js.Func
+
+	mu           sync.Mutex
+	pending      [][]byte
+	err          error
+	readDeadline time.Time
+	notify       chan struct{}
+	closeOnce    sync.Once
+}
+
+// push queues a received message
+func (c *browserConn) push(data []byte) {
+	c.mu.Lock()
+	c.pending = append(c.pending, data)
+	c.mu.Unlock()
+	c.wake()
+}
+
+// fail ends the stream with err after the queued messages
+func (c *browserConn) fail(err error) {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.mu.Unlock()
+	c.wake()
+}
+
+func (c *browserConn) wake() {
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (c *browserConn) readErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Read returns bytes of received messages in order
+func (c *browserConn) Read(p []byte) (int, error) {
+	for {
+		c.mu.Lock()
+		if len(c.pending) > 0 {
+			n := copy(p, c.pending[0])
+			if n == len(c.pending[0]) {
+				c.pending[0] = nil
+				c.pending = c.pending[1:]
+			} else {
+				c.pending[0] = c.pending[0][n:]
+			}
+			c.mu.Unlock()
+			return n, nil
+		}
+		if c.err != nil {
+			err := c.err
+			c.mu.Unlock()
+			return 0, err
+		}
+		deadline := c.readDeadline
+		c.mu.Unlock()
+
+		if deadline.IsZero() {
+			<-c.notify
+			continue
+		}
+
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return 0, os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-c.notify:
+			timer.Stop()
+		case <-timer.C:
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+// Write sends p as one binary message, the browser buffers it so Write does not block
+func (c *browserConn) Write(p []byte) (int, error) {
+	if err := c.readErr(); err != nil {
+		return 0, err
+	}
+
+	data := js.Global().Get("Uint8Array").New(len(p))
+	js.CopyBytesToJS(data, p)
+	if err := catchJS(func() { c.ws.Call("send", data) }); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close closes the WebSocket and releases the event callbacks
+func (c *browserConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.fail(net.ErrClosed)
+		_ = catchJS(func() { c.ws.Call("close", 1000) })
+		for _, name := range []string{"onopen", "onerror", "onclose", "onmessage"} {
+			c.ws.Set(name, js.Null())
+		}
+		for _, fn := range c.funcs {
+			fn.Release()
+		}
+	})
+	return nil
+}
+
+func (c *browserConn) LocalAddr() net.Addr  { return webSocketAddr("") }
+func (c *browserConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *browserConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *browserConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+	c.wake()
+	return nil
+}
+
+// SetWriteDeadline is a no-op, browser sends never block
+func (c *browserConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
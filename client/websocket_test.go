@@ -0,0 +1,189 @@
+//go:build !js
+
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readClientFrame reads a masked frame sent by the client
+func readClientFrame(r io.Reader) (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, nil, err
+	}
+	length := int(head[1] & 0x7F)
+	if length == 126 {
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(r, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i&3]
+	}
+	return head[0] & 0x0F, payload, nil
+}
+
+// serverFrame encodes an unmasked frame as a server sends it
+func serverFrame(opcode byte, payload []byte) []byte {
+	return append([]byte{finBit | opcode, byte(len(payload))}, payload...)
+}
+
+// webSocketServer upgrades connections and echoes binary frames, each split in two
+// It pings the client before the first echo and checks the pong
+func webSocketServer(t *testing.T, subprotocol string) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		br := bufio.NewReader(conn)
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		_, _ = io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\n"+
+			"Upgrade: websocket\r\nConnection: Upgrade\r\n"+
+			"Sec-WebSocket-Accept: "+acceptKey(req.Header.Get("Sec-WebSocket-Key"))+"\r\n"+
+			"Sec-WebSocket-Protocol: "+subprotocol+"\r\n\r\n")
+
+		pinged := false
+		for {
+			opcode, payload, err := readClientFrame(br)
+			if err != nil {
+				return
+			}
+			switch opcode {
+			case opBinary:
+				if !pinged {
+					_, _ = conn.Write(serverFrame(opPing, []byte("hi")))
+					if op, pong, err := readClientFrame(br); err != nil || op != opPong || string(pong) != "hi" {
+						return
+					}
+					pinged = true
+				}
+				half := len(payload) / 2
+				_, _ = conn.Write(serverFrame(opBinary, payload[:half]))
+				_, _ = conn.Write(serverFrame(opContinuation, payload[half:]))
+			case opClose:
+				_, _ = conn.Write(serverFrame(opClose, payload))
+				return
+			}
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestWebSocketDialer(t *testing.T) {
+	addr := webSocketServer(t, "mqtt")
+
+	conn, err := Dial(context.Background(), "ws://"+addr+"/mqtt", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	packet := []byte{0x30, 0x07, 0x00, 0x01, 'a', 'h', 'e', 'l', 'o'}
+	_, err = conn.Write(packet)
+	require.NoError(t, err)
+
+	buf := make([]byte, len(packet))
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, packet, buf)
+}
+
+func TestWebSocketDialer_Close(t *testing.T) {
+	addr := webSocketServer(t, "mqtt")
+
+	conn, err := Dial(context.Background(), "ws://"+addr, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, conn.Close())
+	_, err = conn.Write([]byte{0xC0, 0x00})
+	assert.Error(t, err)
+}
+
+func TestWebSocketDialer_Subprotocol(t *testing.T) {
+	addr := webSocketServer(t, "mqttv3.1")
+
+	_, err := Dial(context.Background(), "ws://"+addr+"/mqtt", nil)
+	assert.ErrorIs(t, err, ErrWebSocketHandshake)
+}
+
+func TestWebSocketDialer_Rejected(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = http.ReadRequest(bufio.NewReader(conn))
+		_, _ = io.WriteString(conn, "HTTP/1.1 403 Forbidden\r\nContent-Length: 0\r\n\r\n")
+	}()
+
+	_, err = Dial(context.Background(), "ws://"+ln.Addr().String()+"/mqtt", nil)
+	assert.ErrorIs(t, err, ErrWebSocketHandshake)
+}
+
+func TestWebSocketDialer_ThroughProxy(t *testing.T) {
+	addr := webSocketServer(t, "mqtt")
+	proxy := httpConnectProxy(t, "")
+	proxy.Scheme = "http"
+
+	config := DefaultDialerConfig()
+	config.Proxy = proxy
+
+	conn, err := Dial(context.Background(), "ws://"+addr+"/mqtt", config)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte{0xC0, 0x00})
+	require.NoError(t, err)
+	buf := make([]byte, 2)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xC0, 0x00}, buf)
+}
+
+func TestWebSocketConn_FrameTooLarge(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	conn := &webSocketConn{Conn: client, br: bufio.NewReader(client), maxFrameSize: 4}
+	go func() {
+		_, _ = server.Write([]byte{finBit | opBinary, 126, 0x01, 0x00})
+	}()
+
+	_, err := conn.Read(make([]byte, 16))
+	assert.ErrorIs(t, err, ErrWebSocketFrameTooLarge)
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/hook"
+	"github.com/axmq/ax/network"
+	"github.com/axmq/ax/pkg/logger"
+)
+
+// errNotServing refuses the connections accepted by the listeners, no session layer is attached to them
+var errNotServing = errors.New("axd does not serve mqtt sessions")
+
+// broker runs the listeners and hook pipeline of the daemon
+type broker struct {
+	configPath string
+	log        logger.Logger
+	registry   *hook.Registry
+
+	mu        sync.Mutex
+	config    *Config
+	listeners []*network.NetListener
+	parsers   map[string]*encoding.Parser
+	tls       map[string]*listenerTLS
+	hooks     atomic.Pointer[hook.Manager]
+	stopWatch context.CancelFunc
+}
+
+// listenerTLS holds the TLS state of one listener, swapped on reload without touching open connections
+// Its certificates come either from files or from an ACME CA
+type listenerTLS struct {
+	config atomic.Pointer[tls.Config]
+	certs  *network.CertReloader
+	acme   network.CertificateManager
+}
+
+func newListenerTLS(lc ListenerConfig) (*listenerTLS, error) {
+	if lc.ACME != nil {
+		acme, err := newACMEManager(lc)
+		if err != nil {
+			return nil, err
+		}
+		return &listenerTLS{acme: acme}, nil
+	}
+	certs, err := network.NewCertReloader(lc.CertFile, lc.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &listenerTLS{certs: certs}, nil
+}
+
+// newACMEManager creates the certificate manager of an ACME listener, issued certificates survive
+// in the cache directory when it is recreated on reload
+func newACMEManager(lc ListenerConfig) (network.CertificateManager, error) {
+	config, err := lc.acme()
+	if err != nil {
+		return nil, err
+	}
+	return network.NewACMEManager(config)
+}
+
+func newBroker(configPath string, config *Config, log logger.Logger) (*broker, error) {
+	registry := hook.NewRegistry()
+	if err := hook.RegisterBuiltins(registry); err != nil {
+		return nil, err
+	}
+	return &broker{
+		configPath: configPath,
+		log:        log,
+		registry:   registry,
+		config:     config,
+		parsers:    make(map[string]*encoding.Parser),
+		tls:        make(map[string]*listenerTLS),
+	}, nil
+}
+
+// Hooks returns the current hook pipeline, it is replaced on reload
+func (b *broker) Hooks() *hook.Manager {
+	return b.hooks.Load()
+}
+
+func (b *broker) Start(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	hooks, err := b.assembleHooks(b.config)
+	if err != nil {
+		return err
+	}
+	b.hooks.Store(hooks)
+
+	for _, lc := range b.config.Listeners {
+		listenerConfig := network.DefaultListenerConfig(lc.Address)
+		listenerConfig.ID = lc.ID
+		listenerConfig.Network = lc.Network
+		listenerConfig.Addresses = lc.Addresses
+		limits := b.config.propertyLimits(lc)
+		listenerConfig.PropertyLimits = &limits
+		listenerConfig.SlowConsumer, err = lc.slowConsumer(b.slowConsumer)
+		if err != nil {
+			b.closeListeners()
+			return fmt.Errorf("listener %s: %w", lc.ID, err)
+		}
+
+		if lc.CertFile != "" || lc.ACME != nil {
+			current, err := newListenerTLS(lc)
+			if err != nil {
+				b.closeListeners()
+				return fmt.Errorf("listener %s: %w", lc.ID, err)
+			}
+			tlsConfig, err := buildTLS(lc, current)
+			if err != nil {
+				b.closeListeners()
+				return fmt.Errorf("listener %s: %w", lc.ID, err)
+			}
+			current.config.Store(tlsConfig)
+			b.tls[lc.ID] = current
+
+			// Handshakes pick up the configuration stored by the latest reload
+			listenerConfig.TLSConfig = &tls.Config{
+				GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+					return current.config.Load(), nil
+				},
+			}
+		}
+
+		listener, err := network.NewListener(listenerConfig, nil)
+		if err != nil {
+			b.closeListeners()
+			return fmt.Errorf("listener %s: %w", lc.ID, err)
+		}
+		listener.OnConnection(b.refuse)
+		if err := listener.Start(); err != nil {
+			b.closeListeners()
+			return fmt.Errorf("listener %s: %w", lc.ID, err)
+		}
+		b.listeners = append(b.listeners, listener)
+		b.parsers[lc.ID] = listener.Parser()
+		for _, addr := range listener.Addrs() {
+			b.log.Info("listening", "id", lc.ID, "address", addr.String())
+		}
+	}
+
+	b.watchCertificates()
+	hooks.OnStarted()
+	return nil
+}
+
+// refuse closes an accepted connection instead of leaving the client waiting for a CONNACK that never comes
+func (b *broker) refuse(conn *network.Connection) error {
+	b.log.Debug("connection refused", "connection", conn.ID(), "remote", conn.RemoteAddr().String(), "error", errNotServing)
+	conn.Close()
+	return errNotServing
+}
+
+// slowConsumer reports a client detected as slow by a listener to the current hook pipeline
+func (b *broker) slowConsumer(event network.SlowConsumerEvent) {
+	b.log.Warn("slow consumer", "client", event.ClientID, "connection", event.ConnectionID,
+		"depth", event.QueueDepth, "age", event.OldestMessageAge, "policy", event.Policy.String())
+	if hooks := b.Hooks(); hooks != nil {
+		hooks.OnSlowConsumer(&hook.Client{ID: event.ClientID}, &hook.SlowConsumerInfo{
+			QueueDepth:       event.QueueDepth,
+			OldestMessageAge: event.OldestMessageAge,
+			Since:            event.Since,
+			Policy:           event.Policy.String(),
+		})
+	}
+}
+
+// watchCertificates reloads certificate files when they change, e.g. after an ACME client renewed them
+func (b *broker) watchCertificates() {
+	interval, _ := b.config.certCheckInterval()
+	if interval == 0 || len(b.tls) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b.stopWatch = cancel
+	for id, current := range b.tls {
+		if current.certs == nil {
+			continue
+		}
+		go current.certs.Watch(ctx, interval, func(err error) {
+			if err != nil {
+				b.log.Warn("certificate reload failed", "id", id, "error", err)
+				return
+			}
+			b.log.Info("certificate reloaded", "id", id)
+		})
+	}
+}
+
+func (b *broker) stopWatchingCertificates() {
+	if b.stopWatch != nil {
+		b.stopWatch()
+		b.stopWatch = nil
+	}
+}
+
+// Reload re-reads the configuration file, replacing certificates, packet limits and the hook pipeline
+// Listener addresses are bound once, changing them requires a restart
+func (b *broker) Reload(ctx context.Context) error {
+	config, err := loadConfig(b.configPath)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var errs []error
+	for _, lc := range config.Listeners {
+		current, ok := b.tls[lc.ID]
+		if !ok {
+			continue
+		}
+		switch {
+		case current.acme != nil && lc.ACME != nil:
+			acme, err := newACMEManager(lc)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("listener %s: %w", lc.ID, err))
+				continue
+			}
+			current.acme = acme
+		case current.certs != nil && lc.CertFile != "":
+			current.certs.SetFiles(lc.CertFile, lc.KeyFile)
+			if err := current.certs.Reload(); err != nil {
+				errs = append(errs, fmt.Errorf("listener %s: %w", lc.ID, err))
+				continue
+			}
+		default:
+			// switching between certificate files and ACME requires a restart
+			continue
+		}
+		tlsConfig, err := buildTLS(lc, current)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("listener %s: %w", lc.ID, err))
+			continue
+		}
+		current.config.Store(tlsConfig)
+	}
+
+	hooks, err := b.assembleHooks(config)
+	if err != nil {
+		errs = append(errs, err)
+	} else {
+		old := b.hooks.Swap(hooks)
+		hooks.OnStarted()
+		b.retire(ctx, old)
+	}
+
+	for _, lc := range config.Listeners {
+		if parser, ok := b.parsers[lc.ID]; ok {
+			parser.SetLimits(config.propertyLimits(lc))
+		}
+	}
+	b.config = config
+	b.stopWatchingCertificates()
+	b.watchCertificates()
+	b.log.Info("configuration reloaded")
+	return errors.Join(errs...)
+}
+
+func (b *broker) Stop(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.stopWatchingCertificates()
+	err := b.closeListeners()
+	if hooks := b.hooks.Load(); hooks != nil {
+		hooks.OnStopped(err)
+		b.retire(ctx, hooks)
+	}
+	return err
+}
+
+func (b *broker) assembleHooks(config *Config) (*hook.Manager, error) {
+	hooks := hook.NewManager()
+	if err := b.registry.Assemble(hooks, config.pipeline()); err != nil {
+		return nil, err
+	}
+	return hooks, nil
+}
+
+// retire waits for calls in flight on a replaced pipeline and stops its hooks
+func (b *broker) retire(ctx context.Context, hooks *hook.Manager) {
+	if hooks == nil {
+		return
+	}
+	if err := hooks.Shutdown(ctx); err != nil {
+		b.log.Warn("hook pipeline shutdown", "error", err)
+	}
+	hooks.Clear()
+}
+
+func (b *broker) closeListeners() error {
+	var errs []error
+	for _, listener := range b.listeners {
+		if err := listener.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	b.listeners = nil
+	clear(b.parsers)
+	return errors.Join(errs...)
+}
+
+func buildTLS(lc ListenerConfig, current *listenerTLS) (*tls.Config, error) {
+	tlsConfig := network.DefaultTLSConfig()
+	tlsConfig.CertReloader = current.certs
+	tlsConfig.ACME = current.acme
+	tlsConfig.CAFile = lc.CAFile
+	return tlsConfig.Build()
+}
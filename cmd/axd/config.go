@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/hook"
+	"github.com/axmq/ax/network"
+)
+
+// Config is the JSON configuration file of the broker daemon
+type Config struct {
+	PIDFile     string `json:"pid_file"`
+	StopTimeout string `json:"stop_timeout"`
+	// CertCheckInterval polls certificate files and reloads them on change, empty only reloads on SIGHUP
+	CertCheckInterval string            `json:"cert_check_interval"`
+	Listeners         []ListenerConfig  `json:"listeners"`
+	Hooks             []hook.HookConfig `json:"hooks"`
+	// PropertyLimits bounds the User Properties of every packet, omitted uses the codec defaults
+	// Listeners may override it with their own property_limits
+	PropertyLimits *PropertyLimitsConfig `json:"property_limits"`
+}
+
+// PropertyLimitsConfig sets the User Property limits, zero disables a limit
+type PropertyLimitsConfig struct {
+	MaxUserProperties    int `json:"max_user_properties"`
+	MaxUserPropertyBytes int `json:"max_user_property_bytes"`
+}
+
+// ListenerConfig describes one listener, certificates are re-read on reload
+type ListenerConfig struct {
+	ID      string `json:"id"`
+	Network string `json:"network"`
+	Address string `json:"address"`
+	// Addresses binds further addresses sharing the listener limits, e.g. "[::]:1883" next to "0.0.0.0:1883"
+	Addresses []string `json:"addresses"`
+	CertFile  string   `json:"cert_file"`
+	KeyFile   string   `json:"key_file"`
+	CAFile    string   `json:"ca_file"`
+	// ACME issues the listener certificate from an ACME CA instead of cert_file and key_file
+	ACME *ACMEConfig `json:"acme"`
+	// SlowConsumer reports clients that stop reading to the OnSlowConsumer hooks, omitted disables it
+	SlowConsumer *SlowConsumerConfig `json:"slow_consumer"`
+	// PropertyLimits overrides the global property_limits for the packets of this listener
+	PropertyLimits *PropertyLimitsConfig `json:"property_limits"`
+}
+
+// ACMEConfig obtains and renews certificates through TLS-ALPN-01 challenges on the listener itself
+type ACMEConfig struct {
+	Domains  []string `json:"domains"`
+	CacheDir string   `json:"cache_dir"`
+	Email    string   `json:"email"`
+	// DirectoryURL selects the CA, empty uses Let's Encrypt production
+	DirectoryURL string `json:"directory_url"`
+	// RenewBefore renews certificates this long before they expire, empty uses 30 days
+	RenewBefore string `json:"renew_before"`
+}
+
+// SlowConsumerConfig marks a client slow once its write backlog stays above a threshold for a duration
+type SlowConsumerConfig struct {
+	QueueThreshold   int    `json:"queue_threshold"`
+	LatencyThreshold string `json:"latency_threshold"`
+	Duration         string `json:"duration"`
+	// Policy is one of none, disconnect, drop_qos0, quarantine and last_value, empty means none
+	Policy string `json:"policy"`
+}
+
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	if len(config.Listeners) == 0 {
+		config.Listeners = []ListenerConfig{{ID: "tcp", Address: ":1883"}}
+	}
+	seen := make(map[string]bool, len(config.Listeners))
+	for i, l := range config.Listeners {
+		if l.ID == "" {
+			return nil, fmt.Errorf("listener at index %d has no id", i)
+		}
+		if seen[l.ID] {
+			return nil, fmt.Errorf("duplicate listener id %q", l.ID)
+		}
+		seen[l.ID] = true
+		if _, err := l.slowConsumer(nil); err != nil {
+			return nil, fmt.Errorf("listener %s: %w", l.ID, err)
+		}
+		if _, err := l.acme(); err != nil {
+			return nil, fmt.Errorf("listener %s: %w", l.ID, err)
+		}
+		if err := l.PropertyLimits.validate(); err != nil {
+			return nil, fmt.Errorf("listener %s: %w", l.ID, err)
+		}
+	}
+	if _, err := config.stopTimeout(); err != nil {
+		return nil, err
+	}
+	if _, err := config.certCheckInterval(); err != nil {
+		return nil, err
+	}
+	if err := config.PropertyLimits.validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func (c *Config) stopTimeout() (time.Duration, error) {
+	if c.StopTimeout == "" {
+		return 30 * time.Second, nil
+	}
+	d, err := time.ParseDuration(c.StopTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid stop_timeout: %w", err)
+	}
+	return d, nil
+}
+
+func (c *Config) certCheckInterval() (time.Duration, error) {
+	if c.CertCheckInterval == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(c.CertCheckInterval)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid cert_check_interval %q", c.CertCheckInterval)
+	}
+	return d, nil
+}
+
+// propertyLimits returns the User Property limits of listener l, its own limits override the global ones
+func (c *Config) propertyLimits(l ListenerConfig) encoding.PropertyLimits {
+	limits := l.PropertyLimits
+	if limits == nil {
+		limits = c.PropertyLimits
+	}
+	if limits == nil {
+		return encoding.DefaultPropertyLimits()
+	}
+	return encoding.PropertyLimits{
+		MaxUserProperties:    limits.MaxUserProperties,
+		MaxUserPropertyBytes: limits.MaxUserPropertyBytes,
+	}
+}
+
+func (p *PropertyLimitsConfig) validate() error {
+	if p != nil && (p.MaxUserProperties < 0 || p.MaxUserPropertyBytes < 0) {
+		return fmt.Errorf("invalid property_limits: negative limit")
+	}
+	return nil
+}
+
+// slowConsumer returns the detector configuration of the listener reporting to onSlow, nil when disabled
+func (l ListenerConfig) slowConsumer(onSlow func(network.SlowConsumerEvent)) (*network.SlowConsumerConfig, error) {
+	if l.SlowConsumer == nil {
+		return nil, nil
+	}
+
+	config := network.DefaultSlowConsumerConfig()
+	config.OnSlowConsumer = onSlow
+	if l.SlowConsumer.QueueThreshold < 0 {
+		return nil, fmt.Errorf("invalid slow_consumer queue_threshold %d", l.SlowConsumer.QueueThreshold)
+	}
+	if l.SlowConsumer.QueueThreshold > 0 {
+		config.QueueThreshold = l.SlowConsumer.QueueThreshold
+	}
+	if err := parseDuration("slow_consumer latency_threshold", l.SlowConsumer.LatencyThreshold, &config.LatencyThreshold); err != nil {
+		return nil, err
+	}
+	if err := parseDuration("slow_consumer duration", l.SlowConsumer.Duration, &config.Duration); err != nil {
+		return nil, err
+	}
+
+	if l.SlowConsumer.Policy != "" {
+		policy, ok := slowConsumerPolicy(l.SlowConsumer.Policy)
+		if !ok {
+			return nil, fmt.Errorf("invalid slow_consumer policy %q", l.SlowConsumer.Policy)
+		}
+		config.Policy = policy
+	}
+	return config, nil
+}
+
+// acme returns the ACME configuration of the listener, nil when it does not use ACME
+func (l ListenerConfig) acme() (*network.ACMEConfig, error) {
+	if l.ACME == nil {
+		return nil, nil
+	}
+	if l.CertFile != "" {
+		return nil, fmt.Errorf("acme and cert_file are mutually exclusive")
+	}
+	if len(l.ACME.Domains) == 0 {
+		return nil, fmt.Errorf("acme requires domains")
+	}
+	if l.ACME.CacheDir == "" {
+		return nil, fmt.Errorf("acme requires cache_dir")
+	}
+
+	config := &network.ACMEConfig{
+		Domains:      l.ACME.Domains,
+		CacheDir:     l.ACME.CacheDir,
+		Email:        l.ACME.Email,
+		DirectoryURL: l.ACME.DirectoryURL,
+	}
+	if err := parseDuration("acme renew_before", l.ACME.RenewBefore, &config.RenewBefore); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// parseDuration sets target to a positive duration, an empty value leaves it alone
+func parseDuration(name, value string, target *time.Duration) error {
+	if value == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return fmt.Errorf("invalid %s %q", name, value)
+	}
+	*target = d
+	return nil
+}
+
+func slowConsumerPolicy(name string) (network.SlowConsumerPolicy, bool) {
+	for policy := network.SlowConsumerPolicyNone; policy <= network.SlowConsumerPolicyLastValue; policy++ {
+		if policy.String() == name {
+			return policy, true
+		}
+	}
+	return network.SlowConsumerPolicyNone, false
+}
+
+func (c *Config) pipeline() *hook.PipelineConfig {
+	return &hook.PipelineConfig{Hooks: c.Hooks}
+}
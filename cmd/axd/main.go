@@ -1 +1,70 @@
-package axd
+// Command axd runs the broker as a daemon under systemd, as a Windows service or in the foreground
+//
+// The daemon binds the listeners, terminates TLS and runs the hook pipeline, but does not serve MQTT sessions on
+// them yet: accepted connections are closed without a CONNACK. READY is signaled once every listener is bound
+//
+// SIGHUP, or the PARAMCHANGE service control on Windows, reloads the configuration file and certificates
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/axmq/ax/pkg/daemon"
+	"github.com/axmq/ax/pkg/logger"
+	"github.com/axmq/ax/version"
+)
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, "axd:", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	configPath := flag.String("config", "/etc/axd/axd.json", "path of the configuration file")
+	pidFile := flag.String("pidfile", "", "write the process ID to this file, overrides pid_file")
+	serviceName := flag.String("service", "axd", "Windows service name")
+	showVersion := flag.Bool("version", false, "print the version and exit")
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Println(version.Version)
+		return nil
+	}
+
+	log := logger.NewSlogLogger(slog.LevelInfo, os.Stderr)
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	stopTimeout, err := config.stopTimeout()
+	if err != nil {
+		return err
+	}
+
+	b, err := newBroker(*configPath, config, log)
+	if err != nil {
+		return err
+	}
+
+	daemonConfig := daemon.DefaultConfig()
+	daemonConfig.PIDFile = config.PIDFile
+	if *pidFile != "" {
+		daemonConfig.PIDFile = *pidFile
+	}
+	daemonConfig.StopTimeout = stopTimeout
+	daemonConfig.Logger = log
+
+	err = daemon.RunWindowsService(*serviceName, b, daemonConfig)
+	if errors.Is(err, daemon.ErrNotService) {
+		err = daemon.Run(context.Background(), b, daemonConfig)
+	}
+	return err
+}
@@ -0,0 +1,696 @@
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/axmq/ax/encoding"
+)
+
+// Checks returns the built-in checks in spec order
+func Checks() []Check {
+	return []Check{
+		{"MQTT-2.1.3-1", "Reserved flag bits MUST be set to the value listed, otherwise the packet is malformed", checkReservedFlags},
+		{"MQTT-3.1.0-1", "The first packet sent from the Client to the Server MUST be a CONNECT packet", checkFirstPacketConnect},
+		{"MQTT-3.1.0-2", "The Server MUST process a second CONNECT packet sent from a Client as a Protocol Error and close the Network Connection", checkSecondConnect},
+		{"MQTT-3.1.2-8", "The Will Message MUST be published after the Network Connection is closed unless it was deleted on receipt of a normal DISCONNECT", checkWillPublished},
+		{"MQTT-3.1.2-22", "The Server MUST close the Network Connection of a Client silent for one and a half times the Keep Alive", checkKeepAlive},
+		{"MQTT-3.1.4-3", "The Server MUST send DISCONNECT with Session taken over to an existing Client with the same ClientID and close its Network Connection", checkTakeover},
+		{"MQTT-3.2.0-1", "The Server MUST send a CONNACK with a 0x00 (Success) Reason Code before sending any Packet other than AUTH", checkConnack},
+		{"MQTT-3.2.2-2", "If the Server accepts a connection with Clean Start set to 1, the Server MUST set Session Present to 0", checkCleanStartSessionPresent},
+		{"MQTT-3.2.2-3", "If the Server accepts a connection with Clean Start set to 0 and has Session State for the ClientID, it MUST set Session Present to 1", checkResumeSessionPresent},
+		{"MQTT-3.2.2-16", "If the Client connects using a zero length Client Identifier, the CONNACK MUST contain an Assigned Client Identifier", checkAssignedClientID},
+		{"MQTT-3.3.1-5", "A retained PUBLISH MUST replace any existing retained message for the topic and be stored", checkRetainReplace},
+		{"MQTT-3.3.1-6", "A retained PUBLISH with a zero byte Payload MUST remove the retained message of the topic", checkRetainRemove},
+		{"MQTT-3.3.2-2", "The Topic Name in the PUBLISH packet MUST NOT contain wildcard characters", checkPublishWildcard},
+		{"MQTT-3.3.4-1", "The receiver of a PUBLISH packet MUST respond with the packet determined by the QoS of the PUBLISH", checkPublishResponse},
+		{"MQTT-3.8.3-3", "If No Local is set, Application Messages MUST NOT be forwarded to the connection that published them", checkNoLocal},
+		{"MQTT-3.8.4-1", "The Server MUST respond to a SUBSCRIBE packet with a SUBACK packet", checkSuback},
+		{"MQTT-3.8.4-2", "The SUBACK packet MUST have the same Packet Identifier as the SUBSCRIBE packet", checkSubackPacketID},
+		{"MQTT-3.8.4-3", "A SUBSCRIBE with the Topic Filter of an existing Subscription MUST replace that Subscription", checkSubscriptionReplaced},
+		{"MQTT-3.8.4-6", "The SUBACK MUST contain a Reason Code for each Topic Filter of the SUBSCRIBE", checkSubackReasonCodes},
+		{"MQTT-3.8.4-8", "Messages sent for a Subscription MUST have the minimum of the published QoS and the granted QoS", checkDeliveryQoS},
+		{"MQTT-3.10.4-4", "The Server MUST respond to an UNSUBSCRIBE packet with an UNSUBACK packet", checkUnsuback},
+		{"MQTT-3.10.4-5", "The UNSUBACK packet MUST have the same Packet Identifier as the UNSUBSCRIBE packet", checkUnsubackPacketID},
+		{"MQTT-3.12.4-1", "The Server MUST send a PINGRESP packet in response to a PINGREQ packet", checkPingresp},
+		{"MQTT-3.14.4-3", "On receipt of DISCONNECT with Reason Code 0x00 the Server MUST discard the Will Message without publishing it", checkWillDiscarded},
+		{"MQTT-4.7.2-1", "The Server MUST NOT match Topic Filters starting with a wildcard character with Topic Names beginning with $", checkDollarTopics},
+	}
+}
+
+func violation(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrViolation, fmt.Sprintf(format, args...))
+}
+
+// quiet is how long a check waits to conclude that a message is not delivered
+func quiet(env *Env) time.Duration {
+	return env.Timeout() / 2
+}
+
+func subscribe(c *Conn, packetID uint16, subs ...encoding.Subscription) (*encoding.SubackPacket, error) {
+	if err := c.Send(&encoding.SubscribePacket{PacketID: packetID, Subscriptions: subs}); err != nil {
+		return nil, err
+	}
+	suback, err := Expect[*encoding.SubackPacket](c)
+	if err != nil {
+		return nil, err
+	}
+	for i, code := range suback.ReasonCodes {
+		if code.IsError() {
+			return suback, fmt.Errorf("%w: subscription to %q refused with %s", ErrUnexpectedPacket, subs[i].TopicFilter, code)
+		}
+	}
+	return suback, nil
+}
+
+// publish sends a QoS 0 or 1 message and waits for the PUBACK of QoS 1
+func publish(c *Conn, topicName string, qos encoding.QoS, retain bool, payload []byte) error {
+	pk := &encoding.PublishPacket{
+		FixedHeader: encoding.FixedHeader{Type: encoding.PUBLISH, QoS: qos, Retain: retain},
+		TopicName:   topicName,
+		Payload:     payload,
+	}
+	if qos > encoding.QoS0 {
+		pk.PacketID = 1
+	}
+	if err := c.Send(pk); err != nil {
+		return err
+	}
+	if qos == encoding.QoS0 {
+		return nil
+	}
+	puback, err := Expect[*encoding.PubackPacket](c)
+	if err != nil {
+		return err
+	}
+	if puback.ReasonCode.IsError() {
+		return fmt.Errorf("%w: publish to %q refused with %s", ErrUnexpectedPacket, topicName, puback.ReasonCode)
+	}
+	return nil
+}
+
+// receive waits for a PUBLISH and acknowledges it
+func receive(c *Conn) (*encoding.PublishPacket, error) {
+	pk, err := Expect[*encoding.PublishPacket](c)
+	if err != nil {
+		return nil, err
+	}
+	switch pk.FixedHeader.QoS {
+	case encoding.QoS1:
+		err = c.Send(&encoding.PubackPacket{PacketID: pk.PacketID})
+	case encoding.QoS2:
+		err = c.Send(&encoding.PubrecPacket{PacketID: pk.PacketID})
+	}
+	return pk, err
+}
+
+// clearRetained removes a retained message a check left behind
+func clearRetained(ctx context.Context, env *Env, topicName string) {
+	c, _, err := env.Connect(ctx, env.ClientID("clear"), nil)
+	if err != nil {
+		return
+	}
+	_ = publish(c, topicName, encoding.QoS1, true, nil)
+	_ = c.Disconnect()
+}
+
+// endSession connects with Clean Start and no session expiry so a persistent session a check created is discarded
+func endSession(ctx context.Context, env *Env, clientID string) {
+	c, _, err := env.Connect(ctx, clientID, nil)
+	if err == nil {
+		_ = c.Disconnect()
+	}
+}
+
+func persistent(pk *encoding.ConnectPacket) {
+	_ = pk.Properties.AddProperty(encoding.PropSessionExpiryInterval, uint32(300))
+}
+
+func checkReservedFlags(ctx context.Context, env *Env) error {
+	c, _, err := env.Connect(ctx, env.ClientID("c"), nil)
+	if err != nil {
+		return err
+	}
+	// PINGREQ with the reserved flag bits 0001 instead of 0000
+	if err := c.SendRaw([]byte{0xC1, 0x00}); err != nil {
+		return err
+	}
+	return c.ExpectClosed()
+}
+
+func checkFirstPacketConnect(ctx context.Context, env *Env) error {
+	c, err := env.Dial(ctx)
+	if err != nil {
+		return err
+	}
+	if err := c.Send(&encoding.PingreqPacket{}); err != nil {
+		return err
+	}
+	return c.ExpectClosed()
+}
+
+func checkSecondConnect(ctx context.Context, env *Env) error {
+	clientID := env.ClientID("c")
+	c, _, err := env.Connect(ctx, clientID, nil)
+	if err != nil {
+		return err
+	}
+	err = c.Send(&encoding.ConnectPacket{
+		ProtocolName:    "MQTT",
+		ProtocolVersion: encoding.ProtocolVersion50,
+		CleanStart:      true,
+		ClientID:        clientID,
+	})
+	if err != nil {
+		return err
+	}
+	return c.ExpectClosed()
+}
+
+func willSubscriber(ctx context.Context, env *Env, willTopic string) (*Conn, error) {
+	sub, _, err := env.Connect(ctx, env.ClientID("sub"), nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := subscribe(sub, 1, encoding.Subscription{TopicFilter: willTopic, QoS: encoding.QoS1}); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+func connectWithWill(ctx context.Context, env *Env, willTopic string) (*Conn, error) {
+	c, _, err := env.Connect(ctx, env.ClientID("will"), func(pk *encoding.ConnectPacket) {
+		pk.WillFlag = true
+		pk.WillQoS = encoding.QoS1
+		pk.WillTopic = willTopic
+		pk.WillPayload = []byte("gone")
+	})
+	return c, err
+}
+
+func checkWillPublished(ctx context.Context, env *Env) error {
+	willTopic := env.Topic("will")
+	sub, err := willSubscriber(ctx, env, willTopic)
+	if err != nil {
+		return err
+	}
+	c, err := connectWithWill(ctx, env, willTopic)
+	if err != nil {
+		return err
+	}
+	_ = c.Close()
+
+	pk, err := receive(sub)
+	if errors.Is(err, ErrNoResponse) {
+		return violation("will message not published after the connection closed")
+	}
+	if err != nil {
+		return err
+	}
+	if pk.TopicName != willTopic || !bytes.Equal(pk.Payload, []byte("gone")) {
+		return violation("got %q with payload %q instead of the will message", pk.TopicName, pk.Payload)
+	}
+	return nil
+}
+
+func checkWillDiscarded(ctx context.Context, env *Env) error {
+	willTopic := env.Topic("will")
+	sub, err := willSubscriber(ctx, env, willTopic)
+	if err != nil {
+		return err
+	}
+	c, err := connectWithWill(ctx, env, willTopic)
+	if err != nil {
+		return err
+	}
+	if err := c.Disconnect(); err != nil {
+		return err
+	}
+	if err := sub.ExpectSilence(quiet(env)); err != nil {
+		return violation("will message published after a normal disconnect: %v", err)
+	}
+	return nil
+}
+
+func checkKeepAlive(ctx context.Context, env *Env) error {
+	c, _, err := env.Connect(ctx, env.ClientID("c"), func(pk *encoding.ConnectPacket) {
+		pk.KeepAlive = 1
+	})
+	if err != nil {
+		return err
+	}
+	pk, err := c.ReceiveWithin(1500*time.Millisecond + env.Timeout())
+	if errors.Is(err, ErrNoResponse) {
+		return violation("connection still open after one and a half times the keep alive")
+	}
+	if err != nil {
+		return nil
+	}
+	if _, ok := pk.(*encoding.DisconnectPacket); !ok {
+		return fmt.Errorf("%w: %s", ErrUnexpectedPacket, pk.Type())
+	}
+	return c.ExpectClosed()
+}
+
+func checkTakeover(ctx context.Context, env *Env) error {
+	clientID := env.ClientID("c")
+	first, _, err := env.Connect(ctx, clientID, nil)
+	if err != nil {
+		return err
+	}
+	if _, _, err := env.Connect(ctx, clientID, nil); err != nil {
+		return err
+	}
+
+	disconnect, err := Expect[*encoding.DisconnectPacket](first)
+	if err != nil {
+		return violation("existing connection not sent a DISCONNECT: %v", err)
+	}
+	if disconnect.ReasonCode != encoding.ReasonSessionTakenOver {
+		return violation("DISCONNECT reason code %s, want %s", disconnect.ReasonCode, encoding.ReasonSessionTakenOver)
+	}
+	return first.ExpectClosed()
+}
+
+func checkConnack(ctx context.Context, env *Env) error {
+	_, connack, err := env.Connect(ctx, env.ClientID("c"), nil)
+	if err != nil {
+		return err
+	}
+	if connack.ReasonCode != encoding.ReasonSuccess {
+		return violation("CONNACK reason code %s", connack.ReasonCode)
+	}
+	return nil
+}
+
+func checkCleanStartSessionPresent(ctx context.Context, env *Env) error {
+	clientID := env.ClientID("c")
+	c, _, err := env.Connect(ctx, clientID, persistent)
+	if err != nil {
+		return err
+	}
+	if err := c.Disconnect(); err != nil {
+		return err
+	}
+
+	c, connack, err := env.Connect(ctx, clientID, nil)
+	if err != nil {
+		return err
+	}
+	_ = c.Disconnect()
+	if connack.SessionPresent {
+		return violation("Session Present set on a Clean Start connection")
+	}
+	return nil
+}
+
+func checkResumeSessionPresent(ctx context.Context, env *Env) error {
+	clientID := env.ClientID("c")
+	defer endSession(ctx, env, clientID)
+
+	c, _, err := env.Connect(ctx, clientID, persistent)
+	if err != nil {
+		return err
+	}
+	if err := c.Disconnect(); err != nil {
+		return err
+	}
+
+	c, connack, err := env.Connect(ctx, clientID, func(pk *encoding.ConnectPacket) {
+		pk.CleanStart = false
+		persistent(pk)
+	})
+	if err != nil {
+		return err
+	}
+	_ = c.Disconnect()
+	if !connack.SessionPresent {
+		return violation("Session Present not set when resuming a stored session")
+	}
+	return nil
+}
+
+func checkAssignedClientID(ctx context.Context, env *Env) error {
+	c, connack, err := env.Connect(ctx, "", nil)
+	if connack != nil && connack.ReasonCode == encoding.ReasonClientIdentifierNotValid {
+		return fmt.Errorf("%w: server requires client identifiers", ErrSkipped)
+	}
+	if err != nil {
+		return err
+	}
+	_ = c.Disconnect()
+
+	prop := connack.Properties.GetProperty(encoding.PropAssignedClientIdentifier)
+	if prop == nil {
+		return violation("CONNACK has no Assigned Client Identifier")
+	}
+	if id, _ := prop.Value.(string); id == "" {
+		return violation("Assigned Client Identifier is empty")
+	}
+	return nil
+}
+
+func checkRetainReplace(ctx context.Context, env *Env) error {
+	topicName := env.Topic("retained")
+	defer clearRetained(ctx, env, topicName)
+
+	pub, _, err := env.Connect(ctx, env.ClientID("pub"), nil)
+	if err != nil {
+		return err
+	}
+	if err := publish(pub, topicName, encoding.QoS1, true, []byte("v1")); err != nil {
+		return err
+	}
+	if err := publish(pub, topicName, encoding.QoS1, true, []byte("v2")); err != nil {
+		return err
+	}
+
+	sub, _, err := env.Connect(ctx, env.ClientID("sub"), nil)
+	if err != nil {
+		return err
+	}
+	if _, err := subscribe(sub, 1, encoding.Subscription{TopicFilter: topicName, QoS: encoding.QoS1}); err != nil {
+		return err
+	}
+	pk, err := receive(sub)
+	if errors.Is(err, ErrNoResponse) {
+		return violation("retained message not delivered to a new subscription")
+	}
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(pk.Payload, []byte("v2")) {
+		return violation("retained payload %q, want the latest %q", pk.Payload, "v2")
+	}
+	if err := sub.ExpectSilence(quiet(env)); err != nil {
+		return violation("replaced retained message still delivered: %v", err)
+	}
+	return nil
+}
+
+func checkRetainRemove(ctx context.Context, env *Env) error {
+	topicName := env.Topic("retained")
+	pub, _, err := env.Connect(ctx, env.ClientID("pub"), nil)
+	if err != nil {
+		return err
+	}
+	if err := publish(pub, topicName, encoding.QoS1, true, []byte("v1")); err != nil {
+		return err
+	}
+	if err := publish(pub, topicName, encoding.QoS1, true, nil); err != nil {
+		return err
+	}
+
+	sub, _, err := env.Connect(ctx, env.ClientID("sub"), nil)
+	if err != nil {
+		return err
+	}
+	if _, err := subscribe(sub, 1, encoding.Subscription{TopicFilter: topicName, QoS: encoding.QoS1}); err != nil {
+		return err
+	}
+	if err := sub.ExpectSilence(quiet(env)); err != nil {
+		return violation("removed retained message still delivered: %v", err)
+	}
+	return nil
+}
+
+func checkPublishWildcard(ctx context.Context, env *Env) error {
+	c, _, err := env.Connect(ctx, env.ClientID("c"), nil)
+	if err != nil {
+		return err
+	}
+	err = c.Send(&encoding.PublishPacket{
+		FixedHeader: encoding.FixedHeader{Type: encoding.PUBLISH},
+		TopicName:   env.Topic("+"),
+		Payload:     []byte("x"),
+	})
+	if err != nil {
+		return err
+	}
+	return c.ExpectClosed()
+}
+
+func checkPublishResponse(ctx context.Context, env *Env) error {
+	c, _, err := env.Connect(ctx, env.ClientID("c"), nil)
+	if err != nil {
+		return err
+	}
+	topicName := env.Topic("qos")
+
+	err = c.Send(&encoding.PublishPacket{
+		FixedHeader: encoding.FixedHeader{Type: encoding.PUBLISH, QoS: encoding.QoS1},
+		TopicName:   topicName,
+		PacketID:    11,
+	})
+	if err != nil {
+		return err
+	}
+	puback, err := Expect[*encoding.PubackPacket](c)
+	if err != nil {
+		return violation("QoS 1 PUBLISH not answered with PUBACK: %v", err)
+	}
+	if puback.PacketID != 11 {
+		return violation("PUBACK packet identifier %d, want 11", puback.PacketID)
+	}
+
+	err = c.Send(&encoding.PublishPacket{
+		FixedHeader: encoding.FixedHeader{Type: encoding.PUBLISH, QoS: encoding.QoS2},
+		TopicName:   topicName,
+		PacketID:    12,
+	})
+	if err != nil {
+		return err
+	}
+	pubrec, err := Expect[*encoding.PubrecPacket](c)
+	if err != nil {
+		return violation("QoS 2 PUBLISH not answered with PUBREC: %v", err)
+	}
+	if pubrec.PacketID != 12 {
+		return violation("PUBREC packet identifier %d, want 12", pubrec.PacketID)
+	}
+	if err := c.Send(&encoding.PubrelPacket{PacketID: 12}); err != nil {
+		return err
+	}
+	pubcomp, err := Expect[*encoding.PubcompPacket](c)
+	if err != nil {
+		return violation("PUBREL not answered with PUBCOMP: %v", err)
+	}
+	if pubcomp.PacketID != 12 {
+		return violation("PUBCOMP packet identifier %d, want 12", pubcomp.PacketID)
+	}
+	return nil
+}
+
+func checkNoLocal(ctx context.Context, env *Env) error {
+	topicName := env.Topic("nolocal")
+	c, _, err := env.Connect(ctx, env.ClientID("c"), nil)
+	if err != nil {
+		return err
+	}
+	if _, err := subscribe(c, 1, encoding.Subscription{TopicFilter: topicName, QoS: encoding.QoS0, NoLocal: true}); err != nil {
+		return err
+	}
+	if err := publish(c, topicName, encoding.QoS1, false, []byte("x")); err != nil {
+		return err
+	}
+	if err := c.ExpectSilence(quiet(env)); err != nil {
+		return violation("message forwarded to its publisher: %v", err)
+	}
+	return nil
+}
+
+func checkSuback(ctx context.Context, env *Env) error {
+	c, _, err := env.Connect(ctx, env.ClientID("c"), nil)
+	if err != nil {
+		return err
+	}
+	if err := c.Send(&encoding.SubscribePacket{PacketID: 1, Subscriptions: []encoding.Subscription{{TopicFilter: env.Topic("a")}}}); err != nil {
+		return err
+	}
+	if _, err := Expect[*encoding.SubackPacket](c); err != nil {
+		return violation("%v", err)
+	}
+	return nil
+}
+
+func checkSubackPacketID(ctx context.Context, env *Env) error {
+	c, _, err := env.Connect(ctx, env.ClientID("c"), nil)
+	if err != nil {
+		return err
+	}
+	suback, err := subscribe(c, 4242, encoding.Subscription{TopicFilter: env.Topic("a")})
+	if err != nil {
+		return err
+	}
+	if suback.PacketID != 4242 {
+		return violation("SUBACK packet identifier %d, want 4242", suback.PacketID)
+	}
+	return nil
+}
+
+func checkSubscriptionReplaced(ctx context.Context, env *Env) error {
+	topicName := env.Topic("a")
+	c, _, err := env.Connect(ctx, env.ClientID("c"), nil)
+	if err != nil {
+		return err
+	}
+	if _, err := subscribe(c, 1, encoding.Subscription{TopicFilter: topicName, QoS: encoding.QoS0}); err != nil {
+		return err
+	}
+	if _, err := subscribe(c, 2, encoding.Subscription{TopicFilter: topicName, QoS: encoding.QoS1}); err != nil {
+		return err
+	}
+
+	pub, _, err := env.Connect(ctx, env.ClientID("pub"), nil)
+	if err != nil {
+		return err
+	}
+	if err := publish(pub, topicName, encoding.QoS1, false, []byte("x")); err != nil {
+		return err
+	}
+	pk, err := receive(c)
+	if err != nil {
+		return err
+	}
+	if pk.FixedHeader.QoS != encoding.QoS1 {
+		return violation("delivered with QoS %d of the replaced subscription", pk.FixedHeader.QoS)
+	}
+	if err := c.ExpectSilence(quiet(env)); err != nil {
+		return violation("message delivered once per subscription: %v", err)
+	}
+	return nil
+}
+
+func checkSubackReasonCodes(ctx context.Context, env *Env) error {
+	c, _, err := env.Connect(ctx, env.ClientID("c"), nil)
+	if err != nil {
+		return err
+	}
+	subs := []encoding.Subscription{
+		{TopicFilter: env.Topic("q0"), QoS: encoding.QoS0},
+		{TopicFilter: env.Topic("q1"), QoS: encoding.QoS1},
+		{TopicFilter: env.Topic("q2"), QoS: encoding.QoS2},
+	}
+	if err := c.Send(&encoding.SubscribePacket{PacketID: 1, Subscriptions: subs}); err != nil {
+		return err
+	}
+	suback, err := Expect[*encoding.SubackPacket](c)
+	if err != nil {
+		return err
+	}
+	if len(suback.ReasonCodes) != len(subs) {
+		return violation("%d reason codes for %d topic filters", len(suback.ReasonCodes), len(subs))
+	}
+	for i, code := range suback.ReasonCodes {
+		if !code.IsError() && code > encoding.ReasonCode(subs[i].QoS) {
+			return violation("reason code %s for %q grants more than QoS %d", code, subs[i].TopicFilter, subs[i].QoS)
+		}
+	}
+	return nil
+}
+
+func checkDeliveryQoS(ctx context.Context, env *Env) error {
+	topicName := env.Topic("a")
+	sub, _, err := env.Connect(ctx, env.ClientID("sub"), nil)
+	if err != nil {
+		return err
+	}
+	if _, err := subscribe(sub, 1, encoding.Subscription{TopicFilter: topicName, QoS: encoding.QoS0}); err != nil {
+		return err
+	}
+	pub, _, err := env.Connect(ctx, env.ClientID("pub"), nil)
+	if err != nil {
+		return err
+	}
+	if err := publish(pub, topicName, encoding.QoS1, false, []byte("x")); err != nil {
+		return err
+	}
+
+	pk, err := receive(sub)
+	if err != nil {
+		return err
+	}
+	if pk.FixedHeader.QoS != encoding.QoS0 {
+		return violation("QoS 1 message delivered with QoS %d to a QoS 0 subscription", pk.FixedHeader.QoS)
+	}
+	return nil
+}
+
+func checkUnsuback(ctx context.Context, env *Env) error {
+	c, _, err := env.Connect(ctx, env.ClientID("c"), nil)
+	if err != nil {
+		return err
+	}
+	if err := c.Send(&encoding.UnsubscribePacket{PacketID: 1, TopicFilters: []string{env.Topic("a")}}); err != nil {
+		return err
+	}
+	if _, err := Expect[*encoding.UnsubackPacket](c); err != nil {
+		return violation("%v", err)
+	}
+	return nil
+}
+
+func checkUnsubackPacketID(ctx context.Context, env *Env) error {
+	c, _, err := env.Connect(ctx, env.ClientID("c"), nil)
+	if err != nil {
+		return err
+	}
+	if _, err := subscribe(c, 1, encoding.Subscription{TopicFilter: env.Topic("a")}); err != nil {
+		return err
+	}
+	if err := c.Send(&encoding.UnsubscribePacket{PacketID: 4243, TopicFilters: []string{env.Topic("a")}}); err != nil {
+		return err
+	}
+	unsuback, err := Expect[*encoding.UnsubackPacket](c)
+	if err != nil {
+		return err
+	}
+	if unsuback.PacketID != 4243 {
+		return violation("UNSUBACK packet identifier %d, want 4243", unsuback.PacketID)
+	}
+	return nil
+}
+
+func checkPingresp(ctx context.Context, env *Env) error {
+	c, _, err := env.Connect(ctx, env.ClientID("c"), nil)
+	if err != nil {
+		return err
+	}
+	if err := c.Send(&encoding.PingreqPacket{}); err != nil {
+		return err
+	}
+	if _, err := Expect[*encoding.PingrespPacket](c); err != nil {
+		return violation("%v", err)
+	}
+	return nil
+}
+
+func checkDollarTopics(ctx context.Context, env *Env) error {
+	// A unique $ topic keeps other traffic out of the wildcard subscription
+	suffix := env.ClientID("dollar")
+	c, _, err := env.Connect(ctx, env.ClientID("c"), nil)
+	if err != nil {
+		return err
+	}
+	if _, err := subscribe(c, 1, encoding.Subscription{TopicFilter: "+/" + suffix}); err != nil {
+		return err
+	}
+
+	pub, _, err := env.Connect(ctx, env.ClientID("pub"), nil)
+	if err != nil {
+		return err
+	}
+	// The server may refuse publishes to $ topics, which satisfies the statement as well
+	_ = pub.Send(&encoding.PublishPacket{
+		FixedHeader: encoding.FixedHeader{Type: encoding.PUBLISH},
+		TopicName:   "$conformance/" + suffix,
+		Payload:     []byte("x"),
+	})
+	if err := c.ExpectSilence(quiet(env)); err != nil {
+		return violation("wildcard subscription matched a $ topic: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package conformance
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/axmq/ax/encoding"
+)
+
+// Conn is a raw MQTT 5 connection to the server under test, packets are sent exactly as crafted
+type Conn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+}
+
+func newConn(conn net.Conn, timeout time.Duration) *Conn {
+	return &Conn{conn: conn, r: bufio.NewReader(conn), timeout: timeout}
+}
+
+// Send writes a packet
+func (c *Conn) Send(pk encoding.Packet) error {
+	_ = c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	return pk.Encode(c.conn)
+}
+
+// SendRaw writes bytes as they are, for packets the encoder refuses to produce
+func (c *Conn) SendRaw(data []byte) error {
+	_ = c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	_, err := c.conn.Write(data)
+	return err
+}
+
+// Receive reads the next packet, waiting up to the check timeout
+func (c *Conn) Receive() (encoding.Packet, error) {
+	return c.ReceiveWithin(c.timeout)
+}
+
+// ReceiveWithin reads the next packet, waiting up to timeout
+func (c *Conn) ReceiveWithin(timeout time.Duration) (encoding.Packet, error) {
+	_ = c.conn.SetReadDeadline(time.Now().Add(timeout))
+	pk, err := encoding.ParsePacket(c.r, encoding.ProtocolVersion50)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return nil, ErrNoResponse
+	}
+	return pk, err
+}
+
+// ExpectClosed reads until the server closes the connection, a DISCONNECT sent before closing is accepted
+// It fails with ErrNotClosed when the connection is still open after the check timeout
+func (c *Conn) ExpectClosed() error {
+	deadline := time.Now().Add(c.timeout)
+	for {
+		_ = c.conn.SetReadDeadline(deadline)
+		pk, err := encoding.ParsePacket(c.r, encoding.ProtocolVersion50)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return ErrNotClosed
+		}
+		if err != nil {
+			// EOF, a reset or a partial packet, the server closed the connection
+			return nil
+		}
+		if _, ok := pk.(*encoding.DisconnectPacket); !ok {
+			return fmt.Errorf("%w: %s before closing", ErrUnexpectedPacket, pk.Type())
+		}
+	}
+}
+
+// ExpectSilence fails when a packet arrives within d
+func (c *Conn) ExpectSilence(d time.Duration) error {
+	pk, err := c.ReceiveWithin(d)
+	if errors.Is(err, ErrNoResponse) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: %s", ErrUnexpectedPacket, pk.Type())
+}
+
+// Close closes the network connection without a DISCONNECT
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// Disconnect sends a normal DISCONNECT and closes the connection
+func (c *Conn) Disconnect() error {
+	err := c.Send(&encoding.DisconnectPacket{ReasonCode: encoding.ReasonNormalDisconnection})
+	if closeErr := c.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Expect reads the next packet and fails unless it is of type T
+func Expect[T encoding.Packet](c *Conn) (T, error) {
+	var zero T
+	pk, err := c.Receive()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return zero, fmt.Errorf("%w: connection closed waiting for %T", ErrUnexpectedPacket, zero)
+		}
+		return zero, err
+	}
+	p, ok := pk.(T)
+	if !ok {
+		return zero, fmt.Errorf("%w: got %s, want %T", ErrUnexpectedPacket, pk.Type(), zero)
+	}
+	return p, nil
+}
@@ -0,0 +1,11 @@
+package conformance
+
+import "errors"
+
+var (
+	ErrUnexpectedPacket = errors.New("unexpected packet")
+	ErrNoResponse       = errors.New("no response from server")
+	ErrNotClosed        = errors.New("server kept the connection open")
+	ErrViolation        = errors.New("normative statement violated")
+	ErrSkipped          = errors.New("check not applicable")
+)
@@ -0,0 +1,81 @@
+package conformance
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// Status is the outcome of a check
+type Status string
+
+const (
+	StatusPass Status = "pass"
+	StatusFail Status = "fail"
+	StatusSkip Status = "skip"
+)
+
+// Result is the outcome of the check of one spec clause
+type Result struct {
+	Clause    string        `json:"clause"`
+	Statement string        `json:"statement"`
+	Status    Status        `json:"status"`
+	Error     string        `json:"error,omitempty"`
+	Duration  time.Duration `json:"duration"`
+}
+
+// Report holds the results of a conformance run in check order
+type Report struct {
+	Address  string        `json:"address"`
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration"`
+	Results  []Result      `json:"results"`
+}
+
+// Count returns the number of results with status
+func (r *Report) Count(status Status) int {
+	n := 0
+	for _, result := range r.Results {
+		if result.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Passed reports whether no check failed
+func (r *Report) Passed() bool {
+	return r.Count(StatusFail) == 0
+}
+
+// Failures returns the results of the failed checks
+func (r *Report) Failures() []Result {
+	var failed []Result
+	for _, result := range r.Results {
+		if result.Status == StatusFail {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// WriteText writes one line per clause followed by a summary
+func (r *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, result := range r.Results {
+		line := fmt.Sprintf("%s\t%s\t%s", result.Status, result.Clause, result.Statement)
+		if result.Error != "" {
+			line += "\t" + result.Error
+		}
+		if _, err := fmt.Fprintln(tw, line); err != nil {
+			return err
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%d passed, %d failed, %d skipped in %s\n",
+		r.Count(StatusPass), r.Count(StatusFail), r.Count(StatusSkip), r.Duration.Round(time.Millisecond))
+	return err
+}
@@ -0,0 +1,205 @@
+// Package conformance checks a running MQTT 5 server against the normative statements of the MQTT 5.0
+// specification. Each check crafts packets with the encoding package, sends them over a raw connection and
+// reports pass or fail for its spec clause, e.g.
+//
+//	report := conformance.Run(ctx, conformance.DefaultConfig("127.0.0.1:1883"), nil)
+//	_ = report.WriteText(os.Stdout)
+//
+// Checks use their own client IDs and topics under ClientIDPrefix and TopicPrefix, and clean up the sessions
+// and retained messages they create, so they can run against a broker serving other traffic
+package conformance
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/axmq/ax/encoding"
+)
+
+// Config configures a conformance run
+type Config struct {
+	// Address is the host:port of the server's TCP listener
+	Address string
+	// Dial opens connections to the server, set it to check a TLS or WebSocket listener
+	Dial func(ctx context.Context) (net.Conn, error)
+	// Timeout bounds each wait for a response from the server
+	Timeout time.Duration
+	// ClientIDPrefix and TopicPrefix keep the clients and topics of the run apart from other traffic
+	ClientIDPrefix string
+	TopicPrefix    string
+	// Clauses restricts the run to the checks of these clauses or clause prefixes, e.g. MQTT-3.8
+	Clauses []string
+}
+
+// DefaultConfig returns a configuration checking the server at address
+func DefaultConfig(address string) Config {
+	return Config{
+		Address:        address,
+		Timeout:        2 * time.Second,
+		ClientIDPrefix: "cf-",
+		TopicPrefix:    "conformance/",
+	}
+}
+
+// Check is an executable normative statement, Run returns an error wrapping ErrSkipped when the server
+// declines an optional feature the statement depends on
+type Check struct {
+	Clause    string
+	Statement string
+	Run       func(ctx context.Context, env *Env) error
+}
+
+// Env gives a check connections to the server and client IDs and topics unique to the check
+type Env struct {
+	config Config
+	id     string
+	conns  []*Conn
+}
+
+// Timeout returns how long a check waits for a response
+func (e *Env) Timeout() time.Duration {
+	return e.config.Timeout
+}
+
+// ClientID returns a client ID unique to the check
+func (e *Env) ClientID(name string) string {
+	return e.config.ClientIDPrefix + e.id + "-" + name
+}
+
+// Topic returns a topic name unique to the check
+func (e *Env) Topic(name string) string {
+	return e.config.TopicPrefix + e.id + "/" + name
+}
+
+// Dial opens a network connection to the server, it is closed when the check ends
+func (e *Env) Dial(ctx context.Context) (*Conn, error) {
+	var (
+		conn net.Conn
+		err  error
+	)
+	if e.config.Dial != nil {
+		conn, err = e.config.Dial(ctx)
+	} else {
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, "tcp", e.config.Address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := newConn(conn, e.config.Timeout)
+	e.conns = append(e.conns, c)
+	return c, nil
+}
+
+// Connect dials and sends a CONNECT with Clean Start, which configure may change before it is sent
+// It returns the CONNACK and fails unless the server accepts the connection
+func (e *Env) Connect(ctx context.Context, clientID string, configure func(*encoding.ConnectPacket)) (*Conn, *encoding.ConnackPacket, error) {
+	c, err := e.Dial(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	pk := &encoding.ConnectPacket{
+		ProtocolName:    "MQTT",
+		ProtocolVersion: encoding.ProtocolVersion50,
+		CleanStart:      true,
+		KeepAlive:       60,
+		ClientID:        clientID,
+	}
+	if configure != nil {
+		configure(pk)
+	}
+	if err := c.Send(pk); err != nil {
+		return nil, nil, err
+	}
+	connack, err := Expect[*encoding.ConnackPacket](c)
+	if err != nil {
+		return nil, nil, err
+	}
+	if connack.ReasonCode.IsError() {
+		return c, connack, fmt.Errorf("%w: connection refused with %s", ErrUnexpectedPacket, connack.ReasonCode)
+	}
+	return c, connack, nil
+}
+
+func (e *Env) close() {
+	for _, c := range e.conns {
+		_ = c.Close()
+	}
+	e.conns = nil
+}
+
+// Run runs checks against the server, every built-in check when checks is nil
+func Run(ctx context.Context, config Config, checks []Check) *Report {
+	defaults := DefaultConfig(config.Address)
+	if config.Timeout <= 0 {
+		config.Timeout = defaults.Timeout
+	}
+	if config.ClientIDPrefix == "" {
+		config.ClientIDPrefix = defaults.ClientIDPrefix
+	}
+	if config.TopicPrefix == "" {
+		config.TopicPrefix = defaults.TopicPrefix
+	}
+	if checks == nil {
+		checks = Checks()
+	}
+
+	report := &Report{Address: config.Address, Started: time.Now()}
+	runID := newRunID()
+	for i, check := range checks {
+		if !selected(config.Clauses, check.Clause) {
+			continue
+		}
+		result := Result{Clause: check.Clause, Statement: check.Statement}
+		if err := ctx.Err(); err != nil {
+			result.Status = StatusSkip
+			result.Error = err.Error()
+			report.Results = append(report.Results, result)
+			continue
+		}
+
+		env := &Env{config: config, id: fmt.Sprintf("%s%02d", runID, i)}
+		started := time.Now()
+		err := check.Run(ctx, env)
+		env.close()
+		result.Duration = time.Since(started)
+
+		switch {
+		case err == nil:
+			result.Status = StatusPass
+		case errors.Is(err, ErrSkipped):
+			result.Status = StatusSkip
+			result.Error = err.Error()
+		default:
+			result.Status = StatusFail
+			result.Error = err.Error()
+		}
+		report.Results = append(report.Results, result)
+	}
+	report.Duration = time.Since(report.Started)
+	return report
+}
+
+func selected(clauses []string, clause string) bool {
+	if len(clauses) == 0 {
+		return true
+	}
+	for _, c := range clauses {
+		if clause == c || strings.HasPrefix(clause, c+".") || strings.HasPrefix(clause, c+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+func newRunID() string {
+	var b [3]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,284 @@
+package conformance
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/topic"
+)
+
+// testBroker is a minimal MQTT 5 server following the statements the built-in checks cover
+// Setting skipPingresp makes it violate MQTT-3.12.4-1
+type testBroker struct {
+	listener     net.Listener
+	skipPingresp bool
+
+	mu       sync.Mutex
+	clients  map[string]*testClient
+	sessions map[string]bool
+	retained map[string][]byte
+	assigned int
+}
+
+type testClient struct {
+	id      string
+	conn    net.Conn
+	writeMu sync.Mutex
+	subs    map[string]encoding.Subscription
+	will    *encoding.ConnectPacket
+}
+
+func (c *testClient) write(pk encoding.Packet) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_ = pk.Encode(c.conn)
+}
+
+func newTestBroker(t *testing.T) *testBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	b := &testBroker{
+		listener: l,
+		clients:  make(map[string]*testClient),
+		sessions: make(map[string]bool),
+		retained: make(map[string][]byte),
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *testBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+
+	pk, err := encoding.ParsePacket(br, encoding.ProtocolVersion50)
+	if err != nil {
+		return
+	}
+	connect, ok := pk.(*encoding.ConnectPacket)
+	if !ok {
+		return
+	}
+	client := &testClient{id: connect.ClientID, conn: conn, subs: make(map[string]encoding.Subscription)}
+	connack := &encoding.ConnackPacket{}
+	if connect.WillFlag {
+		client.will = connect
+	}
+
+	b.mu.Lock()
+	if client.id == "" {
+		b.assigned++
+		client.id = fmt.Sprintf("auto-%d", b.assigned)
+		_ = connack.Properties.AddProperty(encoding.PropAssignedClientIdentifier, client.id)
+	}
+	if prev := b.clients[client.id]; prev != nil {
+		prev.write(&encoding.DisconnectPacket{ReasonCode: encoding.ReasonSessionTakenOver})
+		prev.will = nil
+		_ = prev.conn.Close()
+	}
+	connack.SessionPresent = !connect.CleanStart && b.sessions[client.id]
+	if prop := connect.Properties.GetProperty(encoding.PropSessionExpiryInterval); prop != nil {
+		b.sessions[client.id] = true
+	} else {
+		delete(b.sessions, client.id)
+	}
+	b.clients[client.id] = client
+	b.mu.Unlock()
+	client.write(connack)
+
+	defer func() {
+		b.mu.Lock()
+		if b.clients[client.id] == client {
+			delete(b.clients, client.id)
+		}
+		will := client.will
+		b.mu.Unlock()
+		if will != nil {
+			b.route(nil, will.WillTopic, will.WillQoS, false, will.WillPayload)
+		}
+	}()
+
+	for {
+		if connect.KeepAlive > 0 {
+			_ = conn.SetReadDeadline(time.Now().Add(time.Duration(connect.KeepAlive) * 1500 * time.Millisecond))
+		}
+		pk, err := encoding.ParsePacket(br, encoding.ProtocolVersion50)
+		if err != nil {
+			return
+		}
+		switch p := pk.(type) {
+		case *encoding.SubscribePacket:
+			ack := &encoding.SubackPacket{PacketID: p.PacketID}
+			var retained []*encoding.PublishPacket
+			b.mu.Lock()
+			for _, sub := range p.Subscriptions {
+				client.subs[sub.TopicFilter] = sub
+				ack.ReasonCodes = append(ack.ReasonCodes, encoding.ReasonCode(sub.QoS))
+				for name, payload := range b.retained {
+					if topic.MatchFilter(sub.TopicFilter, name) {
+						retained = append(retained, &encoding.PublishPacket{
+							FixedHeader: encoding.FixedHeader{Type: encoding.PUBLISH, Retain: true},
+							TopicName:   name,
+							Payload:     payload,
+						})
+					}
+				}
+			}
+			b.mu.Unlock()
+			client.write(ack)
+			for _, pk := range retained {
+				client.write(pk)
+			}
+		case *encoding.UnsubscribePacket:
+			b.mu.Lock()
+			for _, filter := range p.TopicFilters {
+				delete(client.subs, filter)
+			}
+			b.mu.Unlock()
+			client.write(&encoding.UnsubackPacket{PacketID: p.PacketID, ReasonCodes: make([]encoding.ReasonCode, len(p.TopicFilters))})
+		case *encoding.PublishPacket:
+			if strings.ContainsAny(p.TopicName, "+#") {
+				return
+			}
+			switch p.FixedHeader.QoS {
+			case encoding.QoS1:
+				client.write(&encoding.PubackPacket{PacketID: p.PacketID})
+			case encoding.QoS2:
+				client.write(&encoding.PubrecPacket{PacketID: p.PacketID})
+			}
+			if p.FixedHeader.Retain {
+				b.mu.Lock()
+				if len(p.Payload) == 0 {
+					delete(b.retained, p.TopicName)
+				} else {
+					b.retained[p.TopicName] = p.Payload
+				}
+				b.mu.Unlock()
+			}
+			b.route(client, p.TopicName, p.FixedHeader.QoS, false, p.Payload)
+		case *encoding.PubrelPacket:
+			client.write(&encoding.PubcompPacket{PacketID: p.PacketID})
+		case *encoding.PubackPacket, *encoding.PubrecPacket, *encoding.PubcompPacket:
+		case *encoding.PingreqPacket:
+			if !b.skipPingresp {
+				client.write(&encoding.PingrespPacket{})
+			}
+		case *encoding.DisconnectPacket:
+			if p.ReasonCode == encoding.ReasonNormalDisconnection {
+				b.mu.Lock()
+				client.will = nil
+				b.mu.Unlock()
+			}
+			return
+		default:
+			// A second CONNECT or a packet a client must not send
+			return
+		}
+	}
+}
+
+// route delivers a message to every matching subscription with the lower of the two QoS
+func (b *testBroker) route(from *testClient, name string, qos encoding.QoS, retain bool, payload []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, c := range b.clients {
+		for filter, sub := range c.subs {
+			if !topic.MatchFilter(filter, name) || (sub.NoLocal && c == from) {
+				continue
+			}
+			out := &encoding.PublishPacket{
+				FixedHeader: encoding.FixedHeader{Type: encoding.PUBLISH, QoS: min(qos, sub.QoS), Retain: retain},
+				TopicName:   name,
+				Payload:     payload,
+			}
+			if out.FixedHeader.QoS > encoding.QoS0 {
+				out.PacketID = 1
+			}
+			go c.write(out)
+		}
+	}
+}
+
+func testConfig(b *testBroker) Config {
+	config := DefaultConfig(b.listener.Addr().String())
+	config.Timeout = 300 * time.Millisecond
+	return config
+}
+
+func TestRunAllChecksPass(t *testing.T) {
+	b := newTestBroker(t)
+	report := Run(context.Background(), testConfig(b), nil)
+
+	require.Len(t, report.Results, len(Checks()))
+	for _, result := range report.Results {
+		assert.Equal(t, StatusPass, result.Status, "%s: %s", result.Clause, result.Error)
+	}
+	assert.True(t, report.Passed())
+	assert.Empty(t, b.retained, "checks clear their retained messages")
+}
+
+func TestRunReportsViolation(t *testing.T) {
+	b := newTestBroker(t)
+	b.skipPingresp = true
+	config := testConfig(b)
+	config.Clauses = []string{"MQTT-3.12", "MQTT-3.2.0-1"}
+
+	report := Run(context.Background(), config, nil)
+	require.Len(t, report.Results, 2)
+	assert.Equal(t, StatusPass, report.Results[0].Status)
+	assert.Equal(t, StatusFail, report.Results[1].Status)
+	assert.Equal(t, "MQTT-3.12.4-1", report.Results[1].Clause)
+	assert.Contains(t, report.Results[1].Error, ErrViolation.Error())
+	assert.False(t, report.Passed())
+	assert.Len(t, report.Failures(), 1)
+
+	var buf bytes.Buffer
+	require.NoError(t, report.WriteText(&buf))
+	assert.Contains(t, buf.String(), "fail  MQTT-3.12.4-1")
+	assert.Contains(t, buf.String(), "1 passed, 1 failed, 0 skipped")
+}
+
+func TestRunSkipsAndUnreachableServer(t *testing.T) {
+	checks := []Check{
+		{Clause: "X-1", Run: func(context.Context, *Env) error { return fmt.Errorf("%w: optional", ErrSkipped) }},
+		{Clause: "X-2", Run: func(ctx context.Context, env *Env) error {
+			_, err := env.Dial(ctx)
+			return err
+		}},
+	}
+	config := DefaultConfig("127.0.0.1:1")
+	report := Run(context.Background(), config, checks)
+
+	require.Len(t, report.Results, 2)
+	assert.Equal(t, StatusSkip, report.Results[0].Status)
+	assert.Equal(t, StatusFail, report.Results[1].Status)
+	assert.Equal(t, 1, report.Count(StatusSkip))
+}
+
+func TestSelected(t *testing.T) {
+	assert.True(t, selected(nil, "MQTT-3.1.0-1"))
+	assert.True(t, selected([]string{"MQTT-3.1"}, "MQTT-3.1.0-1"))
+	assert.True(t, selected([]string{"MQTT-3.1.0-1"}, "MQTT-3.1.0-1"))
+	assert.False(t, selected([]string{"MQTT-3.1"}, "MQTT-3.12.4-1"))
+	assert.False(t, selected([]string{"MQTT-3.1.0-1"}, "MQTT-3.1.0-2"))
+}
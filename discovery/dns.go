@@ -0,0 +1,84 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"strings"
+)
+
+// dnsLookup is the part of net.Resolver the DNS resolvers use
+type dnsLookup interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// SRVResolver resolves the endpoints named by the SRV records of _service._proto.name
+type SRVResolver struct {
+	service string
+	proto   string
+	name    string
+	lookup  dnsLookup
+}
+
+// NewSRVResolver resolves _service._proto.name, e.g. ("mqtt", "tcp", "brokers.example.com")
+// Empty service and proto look name up as is, a nil resolver uses net.DefaultResolver
+func NewSRVResolver(service, proto, name string, resolver *net.Resolver) (*SRVResolver, error) {
+	if name == "" || (service == "") != (proto == "") {
+		return nil, ErrInvalidName
+	}
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return &SRVResolver{service: service, proto: proto, name: name, lookup: resolver}, nil
+}
+
+// Resolve looks the SRV records up, a target of "." means the service is decidedly not available
+func (r *SRVResolver) Resolve(ctx context.Context) ([]Endpoint, error) {
+	_, records, err := r.lookup.LookupSRV(ctx, r.service, r.proto, r.name)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := make([]Endpoint, 0, len(records))
+	for _, srv := range records {
+		host := strings.TrimSuffix(srv.Target, ".")
+		if host == "" {
+			continue
+		}
+		endpoints = append(endpoints, Endpoint{Host: host, Port: int(srv.Port), Priority: srv.Priority, Weight: srv.Weight})
+	}
+	return endpoints, nil
+}
+
+// HostResolver resolves the A and AAAA records of a name, every address serving the same port
+// It suits headless Kubernetes services and round robin DNS, which publish one record per peer
+type HostResolver struct {
+	host   string
+	port   int
+	lookup dnsLookup
+}
+
+// NewHostResolver resolves host to endpoints on port, a nil resolver uses net.DefaultResolver
+func NewHostResolver(host string, port int, resolver *net.Resolver) (*HostResolver, error) {
+	if host == "" || port <= 0 || port > 65535 {
+		return nil, ErrInvalidName
+	}
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return &HostResolver{host: host, port: port, lookup: resolver}, nil
+}
+
+// Resolve looks the addresses up
+func (r *HostResolver) Resolve(ctx context.Context) ([]Endpoint, error) {
+	addrs, err := r.lookup.LookupHost(ctx, r.host)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := make([]Endpoint, len(addrs))
+	for i, addr := range addrs {
+		endpoints[i] = Endpoint{Host: addr, Port: r.port}
+	}
+	return endpoints, nil
+}
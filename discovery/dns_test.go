@@ -0,0 +1,72 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeLookup struct {
+	srv   []*net.SRV
+	hosts []string
+	err   error
+	query string
+}
+
+func (f *fakeLookup) LookupSRV(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	f.query = "_" + service + "._" + proto + "." + name
+	return "", f.srv, f.err
+}
+
+func (f *fakeLookup) LookupHost(_ context.Context, host string) ([]string, error) {
+	f.query = host
+	return f.hosts, f.err
+}
+
+func TestSRVResolver(t *testing.T) {
+	r, err := NewSRVResolver("mqtt", "tcp", "brokers.example.com", nil)
+	require.NoError(t, err)
+	lookup := &fakeLookup{srv: []*net.SRV{
+		{Target: "b1.example.com.", Port: 1883, Priority: 10, Weight: 60},
+		{Target: ".", Port: 0},
+		{Target: "b2.example.com.", Port: 8883, Priority: 20},
+	}}
+	r.lookup = lookup
+
+	endpoints, err := r.Resolve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "_mqtt._tcp.brokers.example.com", lookup.query)
+	assert.Equal(t, []Endpoint{
+		{Host: "b1.example.com", Port: 1883, Priority: 10, Weight: 60},
+		{Host: "b2.example.com", Port: 8883, Priority: 20},
+	}, endpoints)
+	assert.Equal(t, "b1.example.com:1883", endpoints[0].Addr())
+
+	lookup.err = errors.New("no such host")
+	_, err = r.Resolve(context.Background())
+	assert.Error(t, err)
+
+	_, err = NewSRVResolver("mqtt", "", "brokers.example.com", nil)
+	assert.ErrorIs(t, err, ErrInvalidName)
+	_, err = NewSRVResolver("", "", "", nil)
+	assert.ErrorIs(t, err, ErrInvalidName)
+}
+
+func TestHostResolver(t *testing.T) {
+	r, err := NewHostResolver("ax-headless", 1883, nil)
+	require.NoError(t, err)
+	r.lookup = &fakeLookup{hosts: []string{"10.0.0.1", "2001:db8::1"}}
+
+	endpoints, err := r.Resolve(context.Background())
+	require.NoError(t, err)
+	require.Len(t, endpoints, 2)
+	assert.Equal(t, "10.0.0.1:1883", endpoints[0].Addr())
+	assert.Equal(t, "[2001:db8::1]:1883", endpoints[1].Addr())
+
+	_, err = NewHostResolver("ax-headless", 0, nil)
+	assert.ErrorIs(t, err, ErrInvalidName)
+}
@@ -0,0 +1,47 @@
+// Package discovery resolves the broker peer set of a cluster or a bridge dynamically
+//
+// A Resolver looks the peers up, from DNS SRV or A/AAAA records or from the endpoints of a Kubernetes
+// service. A Watcher polls it, keeps the last known set through resolution failures, notifies changes and
+// tracks the health callers report, so Endpoints lists the peers worth dialing first. ServerSet feeds the
+// paho client reconnect logic and PeerSet keeps one peer value per endpoint for the cluster layer
+package discovery
+
+import (
+	"context"
+	"net"
+	"strconv"
+)
+
+// Endpoint is a discovered broker peer
+type Endpoint struct {
+	Host string
+	Port int
+	// Priority and Weight come from SRV records: lower priorities are preferred, weights share the load
+	// among endpoints of one priority. Other resolvers leave both zero
+	Priority uint16
+	Weight   uint16
+}
+
+// Addr returns the host:port address of the endpoint
+func (e Endpoint) Addr() string {
+	return net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+}
+
+// Resolver returns the current endpoints of a peer set
+type Resolver interface {
+	Resolve(ctx context.Context) ([]Endpoint, error)
+}
+
+// ResolverFunc adapts a function to Resolver, e.g. for a static list
+type ResolverFunc func(ctx context.Context) ([]Endpoint, error)
+
+// Resolve calls f
+func (f ResolverFunc) Resolve(ctx context.Context) ([]Endpoint, error) {
+	return f(ctx)
+}
+
+// Notifier is implemented by resolvers that learn about changes before the next poll
+// Notify blocks until ctx is done and calls changed whenever the endpoints may have changed
+type Notifier interface {
+	Notify(ctx context.Context, changed func()) error
+}
@@ -0,0 +1,11 @@
+package discovery
+
+import "errors"
+
+var (
+	ErrNoResolver       = errors.New("discovery resolver is required")
+	ErrInvalidName      = errors.New("invalid discovery name")
+	ErrNoEndpoints      = errors.New("no endpoints discovered")
+	ErrKubernetesConfig = errors.New("invalid kubernetes discovery configuration")
+	ErrKubernetesAPI    = errors.New("kubernetes api request failed")
+)
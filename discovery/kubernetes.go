@@ -0,0 +1,226 @@
+package discovery
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Paths of the service account files mounted into every pod
+const (
+	serviceAccountDir       = "/var/run/secrets/kubernetes.io/serviceaccount/"
+	ServiceAccountToken     = serviceAccountDir + "token"
+	ServiceAccountCA        = serviceAccountDir + "ca.crt"
+	ServiceAccountNamespace = serviceAccountDir + "namespace"
+)
+
+// KubernetesConfig selects the service whose endpoints are discovered through the Kubernetes API
+type KubernetesConfig struct {
+	// APIServer is the base URL of the API, e.g. https://kubernetes.default.svc
+	APIServer string
+	Namespace string
+	Service   string
+	// PortName selects the service port, the first port of each endpoint slice when empty
+	PortName string
+	// Token authenticates the requests, TokenFile is re-read for every request when Token is empty
+	// since projected service account tokens rotate
+	Token     string
+	TokenFile string
+	// Client sends the requests, its transport must trust the cluster CA. http.DefaultClient when nil
+	Client *http.Client
+	// Watch streams endpoint changes, so a Watcher refreshes at once instead of at its next poll
+	Watch bool
+}
+
+// InClusterKubernetesConfig discovers service in the namespace of the pod with its service account
+func InClusterKubernetesConfig(service string) (*KubernetesConfig, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("%w: not running in a cluster", ErrKubernetesConfig)
+	}
+	namespace, err := os.ReadFile(ServiceAccountNamespace)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrKubernetesConfig, err)
+	}
+	ca, err := os.ReadFile(ServiceAccountCA)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrKubernetesConfig, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("%w: no certificate in %s", ErrKubernetesConfig, ServiceAccountCA)
+	}
+
+	return &KubernetesConfig{
+		APIServer: "https://" + net.JoinHostPort(host, port),
+		Namespace: strings.TrimSpace(string(namespace)),
+		Service:   service,
+		TokenFile: ServiceAccountToken,
+		Client: &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+		}},
+		Watch: true,
+	}, nil
+}
+
+// KubernetesResolver resolves the ready endpoints of a service from its EndpointSlices
+type KubernetesResolver struct {
+	config   KubernetesConfig
+	client   *http.Client
+	endpoint string
+}
+
+// NewKubernetesResolver creates a resolver for the service of config
+func NewKubernetesResolver(config *KubernetesConfig) (*KubernetesResolver, error) {
+	if config == nil || config.APIServer == "" || config.Namespace == "" || config.Service == "" {
+		return nil, ErrKubernetesConfig
+	}
+	client := config.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	query := url.Values{"labelSelector": {"kubernetes.io/service-name=" + config.Service}}
+	endpoint := strings.TrimSuffix(config.APIServer, "/") + "/apis/discovery.k8s.io/v1/namespaces/" +
+		url.PathEscape(config.Namespace) + "/endpointslices?" + query.Encode()
+	return &KubernetesResolver{config: *config, client: client, endpoint: endpoint}, nil
+}
+
+// endpointSlice holds the fields of a discovery.k8s.io/v1 EndpointSlice the resolver reads
+type endpointSlice struct {
+	Endpoints []struct {
+		Addresses  []string `json:"addresses"`
+		Conditions struct {
+			Ready *bool `json:"ready"`
+		} `json:"conditions"`
+	} `json:"endpoints"`
+	Ports []struct {
+		Name string `json:"name"`
+		Port *int   `json:"port"`
+	} `json:"ports"`
+}
+
+// watchEvent is one line of a watch stream
+type watchEvent struct {
+	Type string `json:"type"`
+}
+
+// Resolve lists the endpoint slices of the service, endpoints not ready are left out
+func (r *KubernetesResolver) Resolve(ctx context.Context) ([]Endpoint, error) {
+	resp, err := r.get(ctx, r.endpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var list struct {
+		Items []endpointSlice `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrKubernetesAPI, err)
+	}
+
+	var endpoints []Endpoint
+	seen := make(map[string]bool)
+	for _, slice := range list.Items {
+		port, ok := r.port(&slice)
+		if !ok {
+			continue
+		}
+		for _, ep := range slice.Endpoints {
+			// An unknown condition counts as ready, as for Kubernetes itself
+			if ep.Conditions.Ready != nil && !*ep.Conditions.Ready {
+				continue
+			}
+			for _, addr := range ep.Addresses {
+				e := Endpoint{Host: addr, Port: port}
+				if !seen[e.Addr()] {
+					seen[e.Addr()] = true
+					endpoints = append(endpoints, e)
+				}
+			}
+		}
+	}
+	return endpoints, nil
+}
+
+// port returns the port of slice named by PortName
+func (r *KubernetesResolver) port(slice *endpointSlice) (int, bool) {
+	for _, p := range slice.Ports {
+		if p.Port != nil && (r.config.PortName == "" || p.Name == r.config.PortName) {
+			return *p.Port, true
+		}
+	}
+	return 0, false
+}
+
+// Notify watches the endpoint slices of the service and calls changed for every event until the stream ends
+// With Watch off it only waits for ctx, leaving changes to the polls
+func (r *KubernetesResolver) Notify(ctx context.Context, changed func()) error {
+	if !r.config.Watch {
+		<-ctx.Done()
+		return nil
+	}
+
+	resp, err := r.get(ctx, r.endpoint+"&watch=true")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event watchEvent
+		if err := decoder.Decode(&event); err != nil {
+			if err == io.EOF || ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("%w: %v", ErrKubernetesAPI, err)
+		}
+		switch event.Type {
+		case "ERROR":
+			return fmt.Errorf("%w: watch error event", ErrKubernetesAPI)
+		case "BOOKMARK":
+		default:
+			changed()
+		}
+	}
+}
+
+func (r *KubernetesResolver) get(ctx context.Context, endpoint string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	token := r.config.Token
+	if token == "" && r.config.TokenFile != "" {
+		data, err := os.ReadFile(r.config.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrKubernetesConfig, err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s", ErrKubernetesAPI, resp.Status)
+	}
+	return resp, nil
+}
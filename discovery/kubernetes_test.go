@@ -0,0 +1,92 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSlices = `{"items": [
+	{"endpoints": [
+		{"addresses": ["10.0.0.1"], "conditions": {"ready": true}},
+		{"addresses": ["10.0.0.2"], "conditions": {"ready": false}},
+		{"addresses": ["10.0.0.3"]}
+	], "ports": [{"name": "metrics", "port": 9090}, {"name": "mqtt", "port": 1883}]},
+	{"endpoints": [{"addresses": ["10.0.0.1"]}], "ports": [{"name": "mqtt", "port": 1883}]},
+	{"endpoints": [{"addresses": ["10.0.0.9"]}], "ports": [{"name": "metrics", "port": 9090}]}
+]}`
+
+func newTestAPI(t *testing.T, events chan string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/apis/discovery.k8s.io/v1/namespaces/mqtt/endpointslices", r.URL.Path)
+		assert.Equal(t, "kubernetes.io/service-name=ax", r.URL.Query().Get("labelSelector"))
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("watch") != "true" {
+			fmt.Fprint(w, testSlices)
+			return
+		}
+		for event := range events {
+			fmt.Fprintf(w, `{"type": %q, "object": {}}`+"\n", event)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestKubernetesResolver(t *testing.T) {
+	server := newTestAPI(t, nil)
+	config := &KubernetesConfig{APIServer: server.URL, Namespace: "mqtt", Service: "ax", PortName: "mqtt", Token: "secret"}
+	r, err := NewKubernetesResolver(config)
+	require.NoError(t, err)
+
+	endpoints, err := r.Resolve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []Endpoint{{Host: "10.0.0.1", Port: 1883}, {Host: "10.0.0.3", Port: 1883}}, endpoints)
+
+	config.Token = ""
+	r, err = NewKubernetesResolver(config)
+	require.NoError(t, err)
+	_, err = r.Resolve(context.Background())
+	assert.ErrorIs(t, err, ErrKubernetesAPI)
+
+	_, err = NewKubernetesResolver(&KubernetesConfig{APIServer: server.URL})
+	assert.ErrorIs(t, err, ErrKubernetesConfig)
+}
+
+func TestKubernetesResolverWatch(t *testing.T) {
+	events := make(chan string, 3)
+	server := newTestAPI(t, events)
+	r, err := NewKubernetesResolver(&KubernetesConfig{
+		APIServer: server.URL, Namespace: "mqtt", Service: "ax", Token: "secret", Watch: true,
+	})
+	require.NoError(t, err)
+
+	changed := make(chan struct{}, 3)
+	done := make(chan error, 1)
+	go func() { done <- r.Notify(context.Background(), func() { changed <- struct{}{} }) }()
+
+	events <- "ADDED"
+	events <- "BOOKMARK"
+	events <- "MODIFIED"
+	close(events)
+	require.NoError(t, <-done)
+	assert.Len(t, changed, 2)
+}
+
+func TestKubernetesResolverNoWatch(t *testing.T) {
+	r, err := NewKubernetesResolver(&KubernetesConfig{APIServer: "http://127.0.0.1:1", Namespace: "mqtt", Service: "ax"})
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.NoError(t, r.Notify(ctx, func() { t.Fatal("changed without watch") }))
+}
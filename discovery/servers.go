@@ -0,0 +1,91 @@
+package discovery
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ServerSet presents the endpoints of a watcher as broker URLs for the paho client, see ClientOptions.ServerDiscovery
+// Connection outcomes reported back feed the health of the endpoints, so reconnects skip failing peers
+type ServerSet struct {
+	watcher *Watcher
+	scheme  string
+}
+
+// NewServerSet serves the endpoints of w as scheme://host:port URLs, scheme is e.g. tcp or ssl
+func NewServerSet(w *Watcher, scheme string) *ServerSet {
+	return &ServerSet{watcher: w, scheme: strings.TrimSuffix(scheme, "://")}
+}
+
+// Servers returns the URLs in the order to try them
+func (s *ServerSet) Servers() []*url.URL {
+	endpoints := s.watcher.Endpoints()
+	servers := make([]*url.URL, len(endpoints))
+	for i, e := range endpoints {
+		servers[i] = &url.URL{Scheme: s.scheme, Host: e.Addr()}
+	}
+	return servers
+}
+
+// Report records the outcome of connecting to server, a nil error is a success
+func (s *ServerSet) Report(server *url.URL, err error) {
+	s.watcher.report(server.Host, err == nil)
+}
+
+// PeerSet keeps one peer value per discovered endpoint, e.g. the anti-entropy client of each cluster node
+// Pass Update as WatcherConfig.OnChange and Peers to the code iterating the cluster members
+type PeerSet[T any] struct {
+	open  func(Endpoint) T
+	close func(T)
+
+	mu    sync.RWMutex
+	peers map[string]T
+}
+
+// NewPeerSet creates a peer set, open builds the peer of a new endpoint and close, if set, releases a removed one
+func NewPeerSet[T any](open func(Endpoint) T, close func(T)) *PeerSet[T] {
+	return &PeerSet[T]{open: open, close: close, peers: make(map[string]T)}
+}
+
+// Update opens the peers of added endpoints and closes those of removed ones
+func (s *PeerSet[T]) Update(added, removed []Endpoint) {
+	s.mu.Lock()
+	var closed []T
+	for _, e := range removed {
+		if peer, ok := s.peers[e.Addr()]; ok {
+			closed = append(closed, peer)
+			delete(s.peers, e.Addr())
+		}
+	}
+	for _, e := range added {
+		if _, ok := s.peers[e.Addr()]; !ok {
+			s.peers[e.Addr()] = s.open(e)
+		}
+	}
+	s.mu.Unlock()
+
+	if s.close != nil {
+		for _, peer := range closed {
+			s.close(peer)
+		}
+	}
+}
+
+// Peers returns the current peers ordered by endpoint address
+func (s *PeerSet[T]) Peers() []T {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	addrs := make([]string, 0, len(s.peers))
+	for addr := range s.peers {
+		addrs = append(addrs, addr)
+	}
+	slices.Sort(addrs)
+	peers := make([]T, len(addrs))
+	for i, addr := range addrs {
+		peers[i] = s.peers[addr]
+	}
+	return peers
+}
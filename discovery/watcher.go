@@ -0,0 +1,305 @@
+package discovery
+
+import (
+	"cmp"
+	"context"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// notifyRetry is the pause before a failed or ended change stream is opened again
+const notifyRetry = time.Second
+
+// WatcherConfig configures a Watcher
+type WatcherConfig struct {
+	Resolver Resolver
+	// Interval is the time between polls, 30s when zero
+	Interval time.Duration
+	// FailureThreshold is the number of consecutive failures ejecting an endpoint, 1 when zero
+	FailureThreshold int
+	// EjectDuration is how long an ejected endpoint is only tried after the healthy ones, 30s when zero
+	EjectDuration time.Duration
+	// OnChange receives the endpoints added and removed by a refresh, the first one adds every endpoint
+	OnChange func(added, removed []Endpoint)
+	// OnError receives resolution and change stream errors of Run, the last endpoints stay in use meanwhile
+	OnError func(err error)
+}
+
+// WatcherStats holds the counters of a Watcher
+type WatcherStats struct {
+	Endpoints int
+	Healthy   int
+	Refreshes uint64
+	Failures  uint64
+}
+
+// endpointState is the health of an endpoint, carried over refreshes while the endpoint stays
+type endpointState struct {
+	endpoint     Endpoint
+	failures     int
+	ejectedUntil time.Time
+}
+
+// Watcher keeps the endpoint set of a resolver current and tracks the health of its endpoints
+type Watcher struct {
+	config WatcherConfig
+
+	mu     sync.RWMutex
+	states map[string]*endpointState
+
+	refresh   chan struct{}
+	refreshes atomic.Uint64
+	failures  atomic.Uint64
+	now       func() time.Time
+}
+
+// NewWatcher creates a watcher, Run or Refresh resolve the first endpoints
+func NewWatcher(config WatcherConfig) (*Watcher, error) {
+	if config.Resolver == nil {
+		return nil, ErrNoResolver
+	}
+	if config.Interval <= 0 {
+		config.Interval = 30 * time.Second
+	}
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = 1
+	}
+	if config.EjectDuration <= 0 {
+		config.EjectDuration = 30 * time.Second
+	}
+	return &Watcher{
+		config:  config,
+		states:  make(map[string]*endpointState),
+		refresh: make(chan struct{}, 1),
+		now:     time.Now,
+	}, nil
+}
+
+// Refresh resolves the endpoints once, on error the previous endpoints are kept
+func (w *Watcher) Refresh(ctx context.Context) error {
+	w.refreshes.Add(1)
+	endpoints, err := w.config.Resolver.Resolve(ctx)
+	if err != nil {
+		w.failures.Add(1)
+		return err
+	}
+
+	w.mu.Lock()
+	states := make(map[string]*endpointState, len(endpoints))
+	var added []Endpoint
+	for _, e := range endpoints {
+		addr := e.Addr()
+		if _, ok := states[addr]; ok {
+			continue
+		}
+		state, ok := w.states[addr]
+		if !ok {
+			state = &endpointState{}
+			added = append(added, e)
+		}
+		state.endpoint = e
+		states[addr] = state
+	}
+	var removed []Endpoint
+	for addr, state := range w.states {
+		if _, ok := states[addr]; !ok {
+			removed = append(removed, state.endpoint)
+		}
+	}
+	w.states = states
+	w.mu.Unlock()
+
+	if w.config.OnChange != nil && (len(added) > 0 || len(removed) > 0) {
+		w.config.OnChange(added, removed)
+	}
+	return nil
+}
+
+// Run refreshes at once and then every interval until ctx is done
+// A resolver that is a Notifier triggers refreshes as it learns about changes, and so does losing every endpoint
+func (w *Watcher) Run(ctx context.Context) {
+	if n, ok := w.config.Resolver.(Notifier); ok {
+		go w.notify(ctx, n)
+	}
+
+	ticker := time.NewTicker(w.config.Interval)
+	defer ticker.Stop()
+	for {
+		if err := w.Refresh(ctx); err != nil && ctx.Err() == nil {
+			w.reportError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-w.refresh:
+		}
+	}
+}
+
+// notify reopens the change stream of n until ctx is done
+func (w *Watcher) notify(ctx context.Context, n Notifier) {
+	for {
+		err := n.Notify(ctx, w.Trigger)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			w.reportError(err)
+		}
+
+		timer := time.NewTimer(notifyRetry)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+func (w *Watcher) reportError(err error) {
+	if w.config.OnError != nil {
+		w.config.OnError(err)
+	}
+}
+
+// Trigger makes Run refresh without waiting for the next poll
+func (w *Watcher) Trigger() {
+	select {
+	case w.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// Endpoints returns the endpoints in the order to try them
+// Healthy endpoints come first by SRV priority, shuffled by weight within a priority so load spreads as
+// RFC 2782 intends, followed by the ejected ones starting with the one whose ejection ends first
+func (w *Watcher) Endpoints() []Endpoint {
+	now := w.now()
+
+	w.mu.RLock()
+	var healthy, ejected []*endpointState
+	for _, state := range w.states {
+		if now.Before(state.ejectedUntil) {
+			ejected = append(ejected, state)
+		} else {
+			healthy = append(healthy, state)
+		}
+	}
+	w.mu.RUnlock()
+
+	endpoints := make([]Endpoint, 0, len(healthy)+len(ejected))
+	slices.SortFunc(healthy, func(a, b *endpointState) int {
+		return cmp.Compare(a.endpoint.Priority, b.endpoint.Priority)
+	})
+	for start := 0; start < len(healthy); {
+		end := start + 1
+		for end < len(healthy) && healthy[end].endpoint.Priority == healthy[start].endpoint.Priority {
+			end++
+		}
+		endpoints = appendWeighted(endpoints, healthy[start:end])
+		start = end
+	}
+
+	slices.SortFunc(ejected, func(a, b *endpointState) int {
+		return a.ejectedUntil.Compare(b.ejectedUntil)
+	})
+	for _, state := range ejected {
+		endpoints = append(endpoints, state.endpoint)
+	}
+	return endpoints
+}
+
+// appendWeighted appends the endpoints of one priority, each next pick is random with a chance proportional
+// to its weight. Endpoints of weight zero follow in random order
+func appendWeighted(dst []Endpoint, group []*endpointState) []Endpoint {
+	remaining := make([]Endpoint, len(group))
+	total := 0
+	for i, state := range group {
+		remaining[i] = state.endpoint
+		total += int(state.endpoint.Weight)
+	}
+
+	for total > 0 {
+		pick := rand.IntN(total)
+		for i, e := range remaining {
+			if pick < int(e.Weight) {
+				dst = append(dst, e)
+				total -= int(e.Weight)
+				remaining = slices.Delete(remaining, i, i+1)
+				break
+			}
+			pick -= int(e.Weight)
+		}
+	}
+
+	rand.Shuffle(len(remaining), func(i, j int) { remaining[i], remaining[j] = remaining[j], remaining[i] })
+	return append(dst, remaining...)
+}
+
+// ReportSuccess marks e healthy after a successful connection
+func (w *Watcher) ReportSuccess(e Endpoint) {
+	w.report(e.Addr(), true)
+}
+
+// ReportFailure counts a failed connection to e, reaching the failure threshold ejects it
+// When no healthy endpoint is left the peer set may have moved, Run then refreshes at once
+func (w *Watcher) ReportFailure(e Endpoint) {
+	w.report(e.Addr(), false)
+}
+
+func (w *Watcher) report(addr string, ok bool) {
+	now := w.now()
+
+	w.mu.Lock()
+	state, found := w.states[addr]
+	if !found {
+		w.mu.Unlock()
+		return
+	}
+	if ok {
+		state.failures = 0
+		state.ejectedUntil = time.Time{}
+		w.mu.Unlock()
+		return
+	}
+
+	state.failures++
+	if state.failures >= w.config.FailureThreshold {
+		state.ejectedUntil = now.Add(w.config.EjectDuration)
+	}
+	exhausted := w.healthyLocked(now) == 0
+	w.mu.Unlock()
+
+	if exhausted {
+		w.Trigger()
+	}
+}
+
+func (w *Watcher) healthyLocked(now time.Time) int {
+	healthy := 0
+	for _, state := range w.states {
+		if !now.Before(state.ejectedUntil) {
+			healthy++
+		}
+	}
+	return healthy
+}
+
+// Stats returns the counters of the watcher
+func (w *Watcher) Stats() WatcherStats {
+	now := w.now()
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return WatcherStats{
+		Endpoints: len(w.states),
+		Healthy:   w.healthyLocked(now),
+		Refreshes: w.refreshes.Load(),
+		Failures:  w.failures.Load(),
+	}
+}
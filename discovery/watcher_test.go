@@ -0,0 +1,190 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// staticResolver returns the endpoints it holds, or err
+type staticResolver struct {
+	mu        sync.Mutex
+	endpoints []Endpoint
+	err       error
+	calls     int
+}
+
+func (r *staticResolver) set(endpoints []Endpoint, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.endpoints, r.err = endpoints, err
+}
+
+func (r *staticResolver) Resolve(context.Context) ([]Endpoint, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls++
+	return append([]Endpoint(nil), r.endpoints...), r.err
+}
+
+func (r *staticResolver) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.calls
+}
+
+func TestWatcherRefresh(t *testing.T) {
+	ctx := context.Background()
+	a, b, c := Endpoint{Host: "a", Port: 1883}, Endpoint{Host: "b", Port: 1883}, Endpoint{Host: "c", Port: 1883}
+	resolver := &staticResolver{endpoints: []Endpoint{a, b, a}}
+
+	type change struct{ added, removed []Endpoint }
+	var changes []change
+	w, err := NewWatcher(WatcherConfig{Resolver: resolver, OnChange: func(added, removed []Endpoint) {
+		changes = append(changes, change{added, removed})
+	}})
+	require.NoError(t, err)
+
+	require.NoError(t, w.Refresh(ctx))
+	assert.ElementsMatch(t, []Endpoint{a, b}, w.Endpoints())
+	require.NoError(t, w.Refresh(ctx))
+	require.Len(t, changes, 1)
+	assert.Equal(t, []Endpoint{a, b}, changes[0].added)
+
+	// A failed resolution keeps the last known endpoints
+	resolver.set(nil, errors.New("dns down"))
+	assert.Error(t, w.Refresh(ctx))
+	assert.Len(t, w.Endpoints(), 2)
+
+	resolver.set([]Endpoint{b, c}, nil)
+	require.NoError(t, w.Refresh(ctx))
+	require.Len(t, changes, 2)
+	assert.Equal(t, change{added: []Endpoint{c}, removed: []Endpoint{a}}, changes[1])
+
+	stats := w.Stats()
+	assert.Equal(t, 2, stats.Endpoints)
+	assert.Equal(t, uint64(4), stats.Refreshes)
+	assert.Equal(t, uint64(1), stats.Failures)
+
+	_, err = NewWatcher(WatcherConfig{})
+	assert.ErrorIs(t, err, ErrNoResolver)
+}
+
+func TestWatcherHealth(t *testing.T) {
+	now := time.Unix(0, 0)
+	primary := Endpoint{Host: "primary", Port: 1883, Priority: 0}
+	backup := Endpoint{Host: "backup", Port: 1883, Priority: 1}
+	resolver := &staticResolver{endpoints: []Endpoint{backup, primary}}
+	w, err := NewWatcher(WatcherConfig{Resolver: resolver, FailureThreshold: 2, EjectDuration: time.Minute})
+	require.NoError(t, err)
+	w.now = func() time.Time { return now }
+	require.NoError(t, w.Refresh(context.Background()))
+
+	assert.Equal(t, []Endpoint{primary, backup}, w.Endpoints())
+
+	w.ReportFailure(primary)
+	assert.Equal(t, []Endpoint{primary, backup}, w.Endpoints())
+	w.ReportFailure(primary)
+	assert.Equal(t, []Endpoint{backup, primary}, w.Endpoints())
+	assert.Equal(t, 1, w.Stats().Healthy)
+
+	// Ejection ends by itself, a success clears the failures
+	now = now.Add(2 * time.Minute)
+	assert.Equal(t, []Endpoint{primary, backup}, w.Endpoints())
+	w.ReportSuccess(primary)
+	w.ReportFailure(primary)
+	assert.Equal(t, []Endpoint{primary, backup}, w.Endpoints())
+
+	// Losing every endpoint asks Run for a refresh
+	w.ReportFailure(backup)
+	w.ReportFailure(backup)
+	w.ReportFailure(primary)
+	assert.Len(t, w.refresh, 1)
+}
+
+func TestWatcherWeights(t *testing.T) {
+	heavy := Endpoint{Host: "heavy", Port: 1, Weight: 1000}
+	light := Endpoint{Host: "light", Port: 1, Weight: 1}
+	idle := Endpoint{Host: "idle", Port: 1}
+	w, err := NewWatcher(WatcherConfig{Resolver: &staticResolver{endpoints: []Endpoint{idle, light, heavy}}})
+	require.NoError(t, err)
+	require.NoError(t, w.Refresh(context.Background()))
+
+	first := 0
+	for range 100 {
+		endpoints := w.Endpoints()
+		require.Len(t, endpoints, 3)
+		assert.Equal(t, idle, endpoints[2])
+		if endpoints[0] == heavy {
+			first++
+		}
+	}
+	assert.Greater(t, first, 80)
+}
+
+func TestWatcherRun(t *testing.T) {
+	resolver := &staticResolver{endpoints: []Endpoint{{Host: "a", Port: 1}}}
+	var errs []error
+	var mu sync.Mutex
+	w, err := NewWatcher(WatcherConfig{Resolver: resolver, Interval: time.Hour, OnError: func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	}})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+	require.Eventually(t, func() bool { return resolver.count() == 1 }, time.Second, time.Millisecond)
+
+	resolver.set(nil, errors.New("dns down"))
+	w.Trigger()
+	require.Eventually(t, func() bool { return resolver.count() == 2 }, time.Second, time.Millisecond)
+	cancel()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(t, errs, 1)
+	assert.Len(t, w.Endpoints(), 1)
+}
+
+func TestServerSet(t *testing.T) {
+	e := Endpoint{Host: "2001:db8::1", Port: 8883}
+	w, err := NewWatcher(WatcherConfig{Resolver: &staticResolver{endpoints: []Endpoint{e}}})
+	require.NoError(t, err)
+	require.NoError(t, w.Refresh(context.Background()))
+
+	set := NewServerSet(w, "ssl://")
+	servers := set.Servers()
+	require.Len(t, servers, 1)
+	assert.Equal(t, "ssl://[2001:db8::1]:8883", servers[0].String())
+
+	set.Report(servers[0], errors.New("refused"))
+	assert.Zero(t, w.Stats().Healthy)
+	set.Report(&url.URL{Host: "unknown:1"}, nil)
+	set.Report(servers[0], nil)
+	assert.Equal(t, 1, w.Stats().Healthy)
+}
+
+func TestPeerSet(t *testing.T) {
+	var closed []string
+	peers := NewPeerSet(func(e Endpoint) string { return e.Host }, func(peer string) { closed = append(closed, peer) })
+	a, b := Endpoint{Host: "a", Port: 1}, Endpoint{Host: "b", Port: 1}
+
+	peers.Update([]Endpoint{b, a}, nil)
+	assert.Equal(t, []string{"a", "b"}, peers.Peers())
+	peers.Update(nil, []Endpoint{a})
+	assert.Equal(t, []string{"b"}, peers.Peers())
+	assert.Equal(t, []string{"a"}, closed)
+}
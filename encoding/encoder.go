@@ -1,8 +1,9 @@
 package encoding
 
 import (
-	"bytes"
 	"io"
+
+	"github.com/axmq/ax/pkg/bufpool"
 )
 
 // Encode encodes an MQTT 5.0 CONNECT packet
@@ -23,10 +24,12 @@ func (p *ConnectPacket) Encode(w io.Writer) error {
 	varHeaderLen += 2
 
 	// Properties
-	propsBytes, err := p.Properties.encodeToBytes()
+	propsBuf, err := p.Properties.encodePooled()
 	if err != nil {
 		return err
 	}
+	defer propsBuf.Release()
+	propsBytes := propsBuf.B
 	varHeaderLen += len(propsBytes)
 
 	// Payload calculations
@@ -37,10 +40,12 @@ func (p *ConnectPacket) Encode(w io.Writer) error {
 
 	// Will properties, topic, and payload
 	if p.WillFlag {
-		willPropsBytes, err := p.WillProperties.encodeToBytes()
+		willPropsBuf, err := p.WillProperties.encodePooled()
 		if err != nil {
 			return err
 		}
+		defer willPropsBuf.Release()
+		willPropsBytes := willPropsBuf.B
 		payloadLen += len(willPropsBytes)
 		payloadLen += 2 + len(p.WillTopic)
 		payloadLen += 2 + len(p.WillPayload)
@@ -123,8 +128,12 @@ func (p *ConnectPacket) Encode(w io.Writer) error {
 
 	// Will properties, topic, and payload
 	if p.WillFlag {
-		willPropsBytes, _ := p.WillProperties.encodeToBytes()
-		if _, err := w.Write(willPropsBytes); err != nil {
+		willPropsBuf, err := p.WillProperties.encodePooled()
+		if err != nil {
+			return err
+		}
+		defer willPropsBuf.Release()
+		if _, err := w.Write(willPropsBuf.B); err != nil {
 			return err
 		}
 
@@ -157,10 +166,12 @@ func (p *ConnectPacket) Encode(w io.Writer) error {
 // Encode encodes an MQTT 5.0 CONNACK packet
 func (p *ConnackPacket) Encode(w io.Writer) error {
 	// Calculate remaining length
-	propsBytes, err := p.Properties.encodeToBytes()
+	propsBuf, err := p.Properties.encodePooled()
 	if err != nil {
 		return err
 	}
+	defer propsBuf.Release()
+	propsBytes := propsBuf.B
 
 	remainingLength := uint32(1 + 1 + len(propsBytes)) // flags + reason code + properties
 
@@ -199,12 +210,14 @@ func (p *ConnackPacket) Encode(w io.Writer) error {
 // Encode encodes an MQTT 5.0 PUBLISH packet
 func (p *PublishPacket) Encode(w io.Writer) error {
 	// Calculate remaining length
-	propsBytes, err := p.Properties.encodeToBytes()
+	propsBuf, err := p.Properties.encodePooled()
 	if err != nil {
 		return err
 	}
+	defer propsBuf.Release()
+	propsBytes := propsBuf.B
 
-	remainingLength := uint32(2 + len(p.TopicName) + len(propsBytes) + len(p.Payload))
+	remainingLength := uint32(2+len(p.TopicName)+len(propsBytes)) + uint32(p.PayloadSize())
 
 	// Add packet ID for QoS 1 and 2
 	if p.FixedHeader.QoS > QoS0 {
@@ -244,12 +257,8 @@ func (p *PublishPacket) Encode(w io.Writer) error {
 		return err
 	}
 
-	// Payload
-	if len(p.Payload) > 0 {
-		_, err = w.Write(p.Payload)
-	}
-
-	return err
+	// Payload, spooled payloads are streamed from their sink
+	return p.writePayload(w)
 }
 
 // Encode encodes an MQTT 5.0 PUBACK packet
@@ -279,10 +288,12 @@ func encodeAckPacket(w io.Writer, packetType PacketType, packetID uint16, reason
 
 // encodeAckPacketWithFlags is a helper to encode acknowledgment packets with custom flags
 func encodeAckPacketWithFlags(w io.Writer, packetType PacketType, flags byte, packetID uint16, reasonCode ReasonCode, props *Properties) error {
-	propsBytes, err := props.encodeToBytes()
+	propsBuf, err := props.encodePooled()
 	if err != nil {
 		return err
 	}
+	defer propsBuf.Release()
+	propsBytes := propsBuf.B
 
 	// Calculate remaining length
 	remainingLength := uint32(2) // Packet ID
@@ -332,10 +343,12 @@ func writeReasonCodes(w io.Writer, reasonCodes []ReasonCode) error {
 
 // encodeAckPacketWithReasonCodes is a helper to encode acknowledgment packets with reason codes (SUBACK, UNSUBACK)
 func encodeAckPacketWithReasonCodes(w io.Writer, packetType PacketType, flags byte, packetID uint16, reasonCodes []ReasonCode, props *Properties) error {
-	propsBytes, err := props.encodeToBytes()
+	propsBuf, err := props.encodePooled()
 	if err != nil {
 		return err
 	}
+	defer propsBuf.Release()
+	propsBytes := propsBuf.B
 
 	remainingLength := uint32(2 + len(propsBytes) + len(reasonCodes))
 
@@ -367,10 +380,12 @@ func encodeAckPacketWithReasonCodes(w io.Writer, packetType PacketType, flags by
 // Encode encodes an MQTT 5.0 SUBSCRIBE packet
 func (p *SubscribePacket) Encode(w io.Writer) error {
 	// Calculate remaining length
-	propsBytes, err := p.Properties.encodeToBytes()
+	propsBuf, err := p.Properties.encodePooled()
 	if err != nil {
 		return err
 	}
+	defer propsBuf.Release()
+	propsBytes := propsBuf.B
 
 	remainingLength := uint32(2 + len(propsBytes)) // Packet ID + properties
 
@@ -432,10 +447,12 @@ func (p *SubackPacket) Encode(w io.Writer) error {
 
 // Encode encodes an MQTT 5.0 UNSUBSCRIBE packet
 func (p *UnsubscribePacket) Encode(w io.Writer) error {
-	propsBytes, err := p.Properties.encodeToBytes()
+	propsBuf, err := p.Properties.encodePooled()
 	if err != nil {
 		return err
 	}
+	defer propsBuf.Release()
+	propsBytes := propsBuf.B
 
 	remainingLength := uint32(2 + len(propsBytes)) // Packet ID + properties
 
@@ -502,10 +519,12 @@ func (p *PingrespPacket) Encode(w io.Writer) error {
 
 // Encode encodes an MQTT 5.0 DISCONNECT packet
 func (p *DisconnectPacket) Encode(w io.Writer) error {
-	propsBytes, err := p.Properties.encodeToBytes()
+	propsBuf, err := p.Properties.encodePooled()
 	if err != nil {
 		return err
 	}
+	defer propsBuf.Release()
+	propsBytes := propsBuf.B
 
 	// Calculate remaining length
 	remainingLength := uint32(0)
@@ -540,10 +559,12 @@ func (p *DisconnectPacket) Encode(w io.Writer) error {
 
 // Encode encodes an MQTT 5.0 AUTH packet
 func (p *AuthPacket) Encode(w io.Writer) error {
-	propsBytes, err := p.Properties.encodeToBytes()
+	propsBuf, err := p.Properties.encodePooled()
 	if err != nil {
 		return err
 	}
+	defer propsBuf.Release()
+	propsBytes := propsBuf.B
 
 	remainingLength := uint32(1 + len(propsBytes)) // Reason code + properties
 
@@ -568,25 +589,28 @@ func (p *AuthPacket) Encode(w io.Writer) error {
 	return err
 }
 
-// encodeToBytes is a helper to encode properties to a byte slice
-func (p *Properties) encodeToBytes() ([]byte, error) {
-	var buf bytes.Buffer
-	if err := p.EncodeProperties(&buf); err != nil {
+// encodePooled encodes properties into a pooled buffer, the caller releases it once written
+func (p *Properties) encodePooled() (*bufpool.Buffer, error) {
+	buf := bufpool.Get(int(p.calculateLength()) + 4)
+	if err := p.EncodeProperties(buf); err != nil {
+		buf.Release()
 		return nil, err
 	}
-	return buf.Bytes(), nil
+	return buf, nil
 }
 
 // EncodeTo encodes a packet to a byte slice with pre-allocated buffer
 // This is a zero-allocation optimization when the buffer size is known
 func (p *PublishPacket) EncodeTo(buf []byte) (int, error) {
 	// Calculate required size
-	propsBytes, err := p.Properties.encodeToBytes()
+	propsBuf, err := p.Properties.encodePooled()
 	if err != nil {
 		return 0, err
 	}
+	defer propsBuf.Release()
+	propsBytes := propsBuf.B
 
-	remainingLength := uint32(2 + len(p.TopicName) + len(propsBytes) + len(p.Payload))
+	remainingLength := uint32(2+len(p.TopicName)+len(propsBytes)) + uint32(p.PayloadSize())
 	if p.FixedHeader.QoS > QoS0 {
 		remainingLength += 2
 	}
@@ -637,6 +661,21 @@ func (p *PublishPacket) EncodeTo(buf []byte) (int, error) {
 	offset += len(propsBytes)
 
 	// Payload
+	if p.Spool != nil {
+		size := int(p.Spool.Size())
+		if len(buf)-offset < size {
+			return 0, ErrBufferTooSmall
+		}
+		rc, err := p.Spool.Open()
+		if err != nil {
+			return 0, err
+		}
+		defer rc.Close()
+		if _, err := io.ReadFull(rc, buf[offset:offset+size]); err != nil {
+			return 0, unexpectedEOF(err)
+		}
+		return offset + size, nil
+	}
 	copy(buf[offset:], p.Payload)
 	offset += len(p.Payload)
 
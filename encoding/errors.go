@@ -1,66 +1,79 @@
 package encoding
 
-import "errors"
+import (
+	"errors"
 
+	axerrors "github.com/axmq/ax/pkg/errors"
+)
+
+// Codec errors are protocol errors, the broker errors at the end carry their own kinds
 var (
 	// ErrVariableByteIntegerTooLarge indicates the value exceeds the maximum encodable value (268,435,455)
-	ErrVariableByteIntegerTooLarge = errors.New("variable byte integer value exceeds maximum (268,435,455)")
+	ErrVariableByteIntegerTooLarge = axerrors.New(axerrors.KindProtocol, "variable byte integer value exceeds maximum (268,435,455)")
 
 	// ErrMalformedVariableByteInteger indicates invalid variable byte integer encoding
-	ErrMalformedVariableByteInteger = errors.New("malformed variable byte integer")
+	ErrMalformedVariableByteInteger = axerrors.New(axerrors.KindProtocol, "malformed variable byte integer")
 
 	// ErrUnexpectedEOF indicates unexpected end of input while reading
-	ErrUnexpectedEOF = errors.New("unexpected end of input")
+	ErrUnexpectedEOF = axerrors.New(axerrors.KindProtocol, "unexpected end of input")
 
 	// ErrBufferTooSmall indicates the buffer is too small for the operation
-	ErrBufferTooSmall = errors.New("buffer too small")
+	ErrBufferTooSmall = axerrors.New(axerrors.KindProtocol, "buffer too small")
 
-	ErrInvalidType         = errors.New("invalid packet type")
-	ErrInvalidFlags        = errors.New("invalid flags for packet type")
-	ErrInvalidQoS          = errors.New("invalid QoS level")
-	ErrInvalidReservedType = errors.New("reserved packet type (0) not allowed")
+	ErrInvalidType         = axerrors.New(axerrors.KindProtocol, "invalid packet type")
+	ErrInvalidFlags        = axerrors.New(axerrors.KindProtocol, "invalid flags for packet type")
+	ErrInvalidQoS          = axerrors.New(axerrors.KindProtocol, "invalid QoS level")
+	ErrInvalidReservedType = axerrors.New(axerrors.KindProtocol, "reserved packet type (0) not allowed")
 
 	// Property-related errors
-	ErrInvalidPropertyID   = errors.New("invalid property ID")
-	ErrInvalidPropertyType = errors.New("invalid property type")
-	ErrDuplicateProperty   = errors.New("duplicate property not allowed")
+	ErrInvalidPropertyID   = axerrors.New(axerrors.KindProtocol, "invalid property ID")
+	ErrInvalidPropertyType = axerrors.New(axerrors.KindProtocol, "invalid property type")
+	ErrDuplicateProperty   = axerrors.New(axerrors.KindProtocol, "duplicate property not allowed")
 
 	// Packet-related errors
-	ErrInvalidProtocolName    = errors.New("invalid protocol name")
-	ErrInvalidProtocolVersion = errors.New("invalid protocol version")
-	ErrInvalidPacketID        = errors.New("invalid packet identifier")
-	ErrMalformedPacket        = errors.New("malformed packet")
+	ErrInvalidProtocolName    = axerrors.New(axerrors.KindProtocol, "invalid protocol name")
+	ErrInvalidProtocolVersion = axerrors.New(axerrors.KindProtocol, "invalid protocol version")
+	ErrInvalidPacketID        = axerrors.New(axerrors.KindProtocol, "invalid packet identifier")
+	ErrMalformedPacket        = axerrors.New(axerrors.KindProtocol, "malformed packet")
 
 	// UTF-8 validation errors
-	ErrInvalidUTF8           = errors.New("invalid UTF-8 encoding")
-	ErrNullCharacter         = errors.New("null character (U+0000) not allowed in UTF-8 string")
-	ErrInvalidCodePoint      = errors.New("invalid Unicode code point")
-	ErrSurrogateCodePoint    = errors.New("UTF-16 surrogate code points (U+D800 to U+DFFF) not allowed")
-	ErrNonCharacterCodePoint = errors.New("non-character code points (U+FFFE, U+FFFF) not allowed")
-	ErrControlCharacter      = errors.New("control characters (U+0001 to U+001F, U+007F to U+009F) should be avoided")
+	ErrInvalidUTF8           = axerrors.New(axerrors.KindProtocol, "invalid UTF-8 encoding")
+	ErrNullCharacter         = axerrors.New(axerrors.KindProtocol, "null character (U+0000) not allowed in UTF-8 string")
+	ErrInvalidCodePoint      = axerrors.New(axerrors.KindProtocol, "invalid Unicode code point")
+	ErrSurrogateCodePoint    = axerrors.New(axerrors.KindProtocol, "UTF-16 surrogate code points (U+D800 to U+DFFF) not allowed")
+	ErrNonCharacterCodePoint = axerrors.New(axerrors.KindProtocol, "non-character code points (U+FFFE, U+FFFF) not allowed")
+	ErrControlCharacter      = axerrors.New(axerrors.KindProtocol, "control characters (U+0001 to U+001F, U+007F to U+009F) should be avoided")
 
 	// Additional malformed packet detection errors
-	ErrInvalidConnectFlags      = errors.New("invalid CONNECT flags: reserved bit must be 0")
-	ErrInvalidWillQoS           = errors.New("invalid Will QoS level")
-	ErrWillFlagMismatch         = errors.New("Will flag inconsistent with Will QoS or Will Retain")
-	ErrMissingPacketID          = errors.New("missing packet identifier for QoS > 0")
-	ErrInvalidPacketIDZero      = errors.New("packet identifier cannot be 0 for QoS > 0")
-	ErrInvalidRemainingLength   = errors.New("remaining length exceeds maximum or packet bounds")
-	ErrInvalidTopicName         = errors.New("invalid topic name")
-	ErrInvalidTopicFilter       = errors.New("invalid topic filter")
-	ErrEmptyTopicFilter         = errors.New("empty topic filter not allowed")
-	ErrInvalidSubscriptionOpts  = errors.New("invalid subscription options")
-	ErrEmptySubscriptionList    = errors.New("SUBSCRIBE packet must contain at least one subscription")
-	ErrEmptyUnsubscribeList     = errors.New("UNSUBSCRIBE packet must contain at least one topic filter")
-	ErrInvalidPropertyLength    = errors.New("invalid property length")
-	ErrPropertyTooLarge         = errors.New("property value exceeds maximum size")
-	ErrInvalidReasonCode        = errors.New("invalid reason code for packet type")
-	ErrPayloadTooLarge          = errors.New("payload exceeds maximum size")
-	ErrInvalidPublishTopicName  = errors.New("PUBLISH topic name cannot contain wildcards")
-	ErrUsernameWithoutFlag      = errors.New("username present but username flag not set")
-	ErrPasswordWithoutFlag      = errors.New("password present but password flag not set")
-	ErrPasswordWithoutUsername  = errors.New("password flag set without username flag")
-	ErrWillPropsWithoutWillFlag = errors.New("will properties present but will flag not set")
+	ErrInvalidConnectFlags      = axerrors.New(axerrors.KindProtocol, "invalid CONNECT flags: reserved bit must be 0")
+	ErrInvalidWillQoS           = axerrors.New(axerrors.KindProtocol, "invalid Will QoS level")
+	ErrWillFlagMismatch         = axerrors.New(axerrors.KindProtocol, "Will flag inconsistent with Will QoS or Will Retain")
+	ErrMissingPacketID          = axerrors.New(axerrors.KindProtocol, "missing packet identifier for QoS > 0")
+	ErrInvalidPacketIDZero      = axerrors.New(axerrors.KindProtocol, "packet identifier cannot be 0 for QoS > 0")
+	ErrInvalidRemainingLength   = axerrors.New(axerrors.KindProtocol, "remaining length exceeds maximum or packet bounds")
+	ErrInvalidTopicName         = axerrors.New(axerrors.KindProtocol, "invalid topic name")
+	ErrInvalidTopicFilter       = axerrors.New(axerrors.KindProtocol, "invalid topic filter")
+	ErrEmptyTopicFilter         = axerrors.New(axerrors.KindProtocol, "empty topic filter not allowed")
+	ErrInvalidSubscriptionOpts  = axerrors.New(axerrors.KindProtocol, "invalid subscription options")
+	ErrEmptySubscriptionList    = axerrors.New(axerrors.KindProtocol, "SUBSCRIBE packet must contain at least one subscription")
+	ErrEmptyUnsubscribeList     = axerrors.New(axerrors.KindProtocol, "UNSUBSCRIBE packet must contain at least one topic filter")
+	ErrInvalidPropertyLength    = axerrors.New(axerrors.KindProtocol, "invalid property length")
+	ErrPropertyTooLarge         = axerrors.New(axerrors.KindProtocol, "property value exceeds maximum size")
+	ErrInvalidReasonCode        = axerrors.New(axerrors.KindProtocol, "invalid reason code for packet type")
+	ErrPayloadTooLarge          = axerrors.New(axerrors.KindProtocol, "payload exceeds maximum size")
+	ErrInvalidPublishTopicName  = axerrors.New(axerrors.KindProtocol, "PUBLISH topic name cannot contain wildcards")
+	ErrSharedPublishTopicName   = axerrors.New(axerrors.KindProtocol, "PUBLISH topic name cannot start with $share/")
+	ErrUsernameWithoutFlag      = axerrors.New(axerrors.KindProtocol, "username present but username flag not set")
+	ErrPasswordWithoutFlag      = axerrors.New(axerrors.KindProtocol, "password present but password flag not set")
+	ErrPasswordWithoutUsername  = axerrors.New(axerrors.KindProtocol, "password flag set without username flag")
+	ErrWillPropsWithoutWillFlag = axerrors.New(axerrors.KindProtocol, "will properties present but will flag not set")
+	ErrTooManyUserProperties    = axerrors.New(axerrors.KindProtocol, "too many user properties")
+	ErrUserPropertiesTooLarge   = axerrors.New(axerrors.KindProtocol, "user properties exceed maximum size")
+	ErrResponseTooLarge         = axerrors.New(axerrors.KindProtocol, "response exceeds the client maximum packet size")
+
+	// Errors raised by the broker rather than the codec, mapped to wire reason codes by FromError
+	ErrNotAuthorized = axerrors.New(axerrors.KindAuth, "not authorized")
+	ErrQuotaExceeded = axerrors.New(axerrors.KindQuota, "quota exceeded")
 )
 
 // PacketError represents a packet parsing error with associated protocol reason code
@@ -131,9 +144,12 @@ func GetReasonCode(err error) ReasonCode {
 		errors.Is(err, ErrEmptyTopicFilter):
 		return ReasonTopicFilterInvalid
 	case errors.Is(err, ErrInvalidTopicName),
-		errors.Is(err, ErrInvalidPublishTopicName):
+		errors.Is(err, ErrInvalidPublishTopicName),
+		errors.Is(err, ErrSharedPublishTopicName):
 		return ReasonTopicNameInvalid
-	case errors.Is(err, ErrPayloadTooLarge):
+	case errors.Is(err, ErrPayloadTooLarge),
+		errors.Is(err, ErrTooManyUserProperties),
+		errors.Is(err, ErrUserPropertiesTooLarge):
 		return ReasonPacketTooLarge
 	default:
 		return ReasonUnspecifiedError
@@ -0,0 +1,136 @@
+package encoding
+
+import (
+	"bufio"
+	"io"
+	"sync/atomic"
+)
+
+// PropertyLimits bounds the User Properties accepted in a single property block, zero disables a limit
+// Parsing stops at the first property over a limit, so a pathological list is never held in memory
+type PropertyLimits struct {
+	// MaxUserProperties is the number of User Property pairs allowed
+	MaxUserProperties int
+	// MaxUserPropertyBytes is the combined length of every User Property key and value
+	MaxUserPropertyBytes int
+}
+
+// DefaultPropertyLimits returns limits well above what legitimate clients send
+func DefaultPropertyLimits() PropertyLimits {
+	return PropertyLimits{
+		MaxUserProperties:    128,
+		MaxUserPropertyBytes: 64 * 1024,
+	}
+}
+
+// defaultPropertyLimits is enforced by the package level parse functions
+var defaultPropertyLimits = DefaultPropertyLimits()
+
+// Parser parses packets against its own property limits, so every listener can enforce different limits
+// The package level parse functions enforce DefaultPropertyLimits
+type Parser struct {
+	limits atomic.Pointer[PropertyLimits]
+}
+
+// NewParser creates a parser enforcing limits
+func NewParser(limits PropertyLimits) *Parser {
+	p := &Parser{}
+	p.SetLimits(limits)
+	return p
+}
+
+// SetLimits changes the limits, packets being parsed keep the limits they started with
+func (p *Parser) SetLimits(limits PropertyLimits) {
+	p.limits.Store(&limits)
+}
+
+// Limits returns the limits currently enforced
+func (p *Parser) Limits() PropertyLimits {
+	return *p.limits.Load()
+}
+
+// Reader wraps r so the parse functions of this package enforce the parser limits on packets read from it
+// A *bufio.Reader keeps its buffered fast path
+func (p *Parser) Reader(r io.Reader) io.Reader {
+	if pr, ok := r.(*parserReader); ok {
+		r = pr.Reader
+	}
+	br, _ := r.(*bufio.Reader)
+	return &parserReader{Reader: r, br: br, limits: p.limits.Load()}
+}
+
+// ParsePacket reads a packet of the given protocol version like the package level ParsePacket
+func (p *Parser) ParsePacket(r io.Reader, version ProtocolVersion) (Packet, error) {
+	return ParsePacket(p.Reader(r), version)
+}
+
+// ParsePacketBody parses the rest of a packet like the package level ParsePacketBody
+func (p *Parser) ParsePacketBody(r io.Reader, fh *FixedHeader, version ProtocolVersion) (Packet, error) {
+	return ParsePacketBody(p.Reader(r), fh, version)
+}
+
+// ParseProperties parses MQTT 5.0 properties from a reader
+func (p *Parser) ParseProperties(r io.Reader) (*Properties, error) {
+	return ParseProperties(p.Reader(r))
+}
+
+// ParsePropertiesFromBytes parses MQTT 5.0 properties from a byte slice
+func (p *Parser) ParsePropertiesFromBytes(data []byte) (*Properties, int, error) {
+	return parsePropertiesFromBytes(data, p.limits.Load())
+}
+
+// parserReader carries the limits of a parser through the parse functions
+type parserReader struct {
+	io.Reader
+	// br is the buffered reader behind Reader, nil when it is not buffered
+	br     *bufio.Reader
+	limits *PropertyLimits
+}
+
+// bufferedReader returns the buffered reader behind r, if any
+func bufferedReader(r io.Reader) (*bufio.Reader, bool) {
+	switch r := r.(type) {
+	case *bufio.Reader:
+		return r, true
+	case *parserReader:
+		return r.br, r.br != nil
+	}
+	return nil, false
+}
+
+// limitsOf returns the limits enforced on property blocks read from r
+func limitsOf(r io.Reader) *PropertyLimits {
+	if pr, ok := r.(*parserReader); ok {
+		return pr.limits
+	}
+	return &defaultPropertyLimits
+}
+
+// userPropertyBudget tracks the User Properties of one property block against the limits
+type userPropertyBudget struct {
+	limits *PropertyLimits
+	count  int
+	bytes  int
+}
+
+func newUserPropertyBudget(limits *PropertyLimits) userPropertyBudget {
+	return userPropertyBudget{limits: limits}
+}
+
+// add accounts for a parsed property and fails once a limit is exceeded
+func (b *userPropertyBudget) add(prop *Property) error {
+	if prop.ID != PropUserProperty {
+		return nil
+	}
+	pair, _ := prop.Value.(UTF8Pair)
+
+	b.count++
+	b.bytes += len(pair.Key) + len(pair.Value)
+	if b.limits.MaxUserProperties > 0 && b.count > b.limits.MaxUserProperties {
+		return ErrTooManyUserProperties
+	}
+	if b.limits.MaxUserPropertyBytes > 0 && b.bytes > b.limits.MaxUserPropertyBytes {
+		return ErrUserPropertiesTooLarge
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package encoding
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encodeUserProperties(t *testing.T, count int, valueSize int) []byte {
+	b := NewPropertyBuilder().WithContentType("text/plain")
+	for i := 0; i < count; i++ {
+		b.WithUserProperty(fmt.Sprintf("k%d", i), strings.Repeat("v", valueSize))
+	}
+	props, err := b.Build()
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, props.EncodeProperties(&buf))
+	return buf.Bytes()
+}
+
+// parseAllWays parses data with the byte slice, plain reader and buffered reader parsers
+func parseAllWays(p *Parser, data []byte) map[string]error {
+	_, _, bytesErr := p.ParsePropertiesFromBytes(data)
+	_, readerErr := p.ParseProperties(struct{ *bytes.Reader }{bytes.NewReader(data)})
+	_, bufioErr := p.ParseProperties(bufio.NewReader(bytes.NewReader(data)))
+	return map[string]error{"bytes": bytesErr, "reader": readerErr, "bufio": bufioErr}
+}
+
+func TestPropertyLimits_Count(t *testing.T) {
+	p := NewParser(PropertyLimits{MaxUserProperties: 3})
+
+	for name, err := range parseAllWays(p, encodeUserProperties(t, 3, 4)) {
+		assert.NoError(t, err, name)
+	}
+	for name, err := range parseAllWays(p, encodeUserProperties(t, 4, 4)) {
+		assert.ErrorIs(t, err, ErrTooManyUserProperties, name)
+	}
+}
+
+func TestPropertyLimits_Bytes(t *testing.T) {
+	p := NewParser(PropertyLimits{MaxUserPropertyBytes: 100})
+
+	// Each pair is a two byte key and a 40 byte value
+	for name, err := range parseAllWays(p, encodeUserProperties(t, 2, 40)) {
+		assert.NoError(t, err, name)
+	}
+	for name, err := range parseAllWays(p, encodeUserProperties(t, 3, 40)) {
+		assert.ErrorIs(t, err, ErrUserPropertiesTooLarge, name)
+	}
+}
+
+func TestPropertyLimits_Disabled(t *testing.T) {
+	p := NewParser(PropertyLimits{})
+
+	for name, err := range parseAllWays(p, encodeUserProperties(t, 500, 200)) {
+		assert.NoError(t, err, name)
+	}
+}
+
+func TestPropertyLimits_Defaults(t *testing.T) {
+	limits := DefaultPropertyLimits()
+
+	data := encodeUserProperties(t, limits.MaxUserProperties+1, 1)
+	_, _, err := ParsePropertiesFromBytes(data)
+	assert.ErrorIs(t, err, ErrTooManyUserProperties)
+	assert.Equal(t, ReasonPacketTooLarge, FromError(err))
+}
+
+func TestParser_ParsePacket(t *testing.T) {
+	props := Properties{}
+	for i := 0; i < 3; i++ {
+		props.Properties = append(props.Properties, Property{ID: PropUserProperty, Value: UTF8Pair{Key: fmt.Sprintf("k%d", i), Value: "v"}})
+	}
+	var buf bytes.Buffer
+	require.NoError(t, (&PublishPacket{TopicName: "a/b", Properties: props, Payload: []byte("x")}).Encode(&buf))
+
+	strict := NewParser(PropertyLimits{MaxUserProperties: 2})
+	lenient := NewParser(PropertyLimits{MaxUserProperties: 3})
+	for name, wrap := range map[string]func([]byte) io.Reader{
+		"reader": func(data []byte) io.Reader { return struct{ *bytes.Reader }{bytes.NewReader(data)} },
+		"bufio":  func(data []byte) io.Reader { return bufio.NewReader(bytes.NewReader(data)) },
+	} {
+		_, err := strict.ParsePacket(wrap(buf.Bytes()), ProtocolVersion50)
+		assert.ErrorIs(t, err, ErrTooManyUserProperties, name)
+
+		pk, err := lenient.ParsePacket(wrap(buf.Bytes()), ProtocolVersion50)
+		require.NoError(t, err, name)
+		assert.Equal(t, []byte("x"), pk.(*PublishPacket).Payload, name)
+	}
+
+	strict.SetLimits(PropertyLimits{})
+	_, err := strict.ParsePacket(bytes.NewReader(buf.Bytes()), ProtocolVersion50)
+	assert.NoError(t, err)
+
+	// The limits of one parser do not leak into the package level functions
+	_, err = ParsePacket(bytes.NewReader(buf.Bytes()), ProtocolVersion50)
+	assert.NoError(t, err)
+}
+
+func TestParser_ReaderKeepsBufferedFastPath(t *testing.T) {
+	br := bufio.NewReader(strings.NewReader("x"))
+	got, ok := bufferedReader(NewParser(DefaultPropertyLimits()).Reader(br))
+	assert.True(t, ok)
+	assert.Same(t, br, got)
+
+	_, ok = bufferedReader(NewParser(DefaultPropertyLimits()).Reader(strings.NewReader("x")))
+	assert.False(t, ok)
+}
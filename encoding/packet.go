@@ -110,16 +110,13 @@ func ParseFixedHeaderWithVersion(r io.Reader, version ProtocolVersion) (*FixedHe
 	header := &FixedHeader{}
 
 	// Read first byte (packet type + flags)
-	var firstByte [1]byte // Stack-allocated, zero heap allocation
-	if _, err := io.ReadFull(r, firstByte[:]); err != nil {
-		if err == io.EOF {
-			return nil, ErrUnexpectedEOF
-		}
+	firstByte, err := readByte(r)
+	if err != nil {
 		return nil, err
 	}
 
 	// Extract packet type (bits 7-4)
-	header.Type = PacketType(firstByte[0] >> 4)
+	header.Type = PacketType(firstByte >> 4)
 
 	// Validate packet type - Reserved (0) is invalid per MQTT spec
 	if header.Type == Reserved {
@@ -140,7 +137,7 @@ func ParseFixedHeaderWithVersion(r io.Reader, version ProtocolVersion) (*FixedHe
 	}
 
 	// Extract flags (bits 3-0)
-	header.Flags = firstByte[0] & 0x0F
+	header.Flags = firstByte & 0x0F
 
 	// Decode PUBLISH-specific flags
 	if header.Type == PUBLISH {
@@ -0,0 +1,469 @@
+package encoding
+
+import (
+	"io"
+
+	"github.com/axmq/ax/pkg/bufpool"
+)
+
+// Packet is implemented by every MQTT control packet, so packets can be handled without knowing their concrete type
+type Packet interface {
+	// Type returns the control packet type
+	Type() PacketType
+
+	// Encode writes the packet including its fixed header
+	Encode(w io.Writer) error
+
+	// Size returns the number of bytes Encode writes, or -1 when the packet cannot be encoded
+	Size() int
+}
+
+var (
+	_ Packet = (*ConnectPacket)(nil)
+	_ Packet = (*ConnackPacket)(nil)
+	_ Packet = (*PublishPacket)(nil)
+	_ Packet = (*PubackPacket)(nil)
+	_ Packet = (*PubrecPacket)(nil)
+	_ Packet = (*PubrelPacket)(nil)
+	_ Packet = (*PubcompPacket)(nil)
+	_ Packet = (*SubscribePacket)(nil)
+	_ Packet = (*SubackPacket)(nil)
+	_ Packet = (*UnsubscribePacket)(nil)
+	_ Packet = (*UnsubackPacket)(nil)
+	_ Packet = (*PingreqPacket)(nil)
+	_ Packet = (*PingrespPacket)(nil)
+	_ Packet = (*DisconnectPacket)(nil)
+	_ Packet = (*AuthPacket)(nil)
+	_ Packet = (*ConnectPacket311)(nil)
+	_ Packet = (*ConnackPacket311)(nil)
+	_ Packet = (*PublishPacket311)(nil)
+	_ Packet = (*PubackPacket311)(nil)
+	_ Packet = (*PubrecPacket311)(nil)
+	_ Packet = (*PubrelPacket311)(nil)
+	_ Packet = (*PubcompPacket311)(nil)
+	_ Packet = (*SubscribePacket311)(nil)
+	_ Packet = (*SubackPacket311)(nil)
+	_ Packet = (*UnsubscribePacket311)(nil)
+	_ Packet = (*UnsubackPacket311)(nil)
+	_ Packet = (*DisconnectPacket311)(nil)
+)
+
+// ParsePacket reads a packet of the given protocol version and returns it as its concrete type
+// MQTT 3.0 and 3.1.1 packets are returned as their 311 types, e.g. *PublishPacket311
+func ParsePacket(r io.Reader, version ProtocolVersion) (Packet, error) {
+	fh, err := ParseFixedHeaderWithVersion(r, version)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePacketBody(r, fh, version)
+}
+
+// ParsePacketBody parses the rest of a packet of the given protocol version whose fixed header was already read
+func ParsePacketBody(r io.Reader, fh *FixedHeader, version ProtocolVersion) (Packet, error) {
+	switch version {
+	case ProtocolVersion50:
+		return parsePacketBody50(r, fh)
+	case ProtocolVersion311, ProtocolVersion30:
+		return parsePacketBody311(r, fh)
+	default:
+		return nil, ErrInvalidProtocolVersion
+	}
+}
+
+func parsePacketBody50(r io.Reader, fh *FixedHeader) (Packet, error) {
+	switch fh.Type {
+	case CONNECT:
+		return ParseConnectPacket(r, fh)
+	case CONNACK:
+		return ParseConnackPacket(r, fh)
+	case PUBLISH:
+		return ParsePublishPacket(r, fh)
+	case PUBACK:
+		return ParsePubackPacket(r, fh)
+	case PUBREC:
+		return ParsePubrecPacket(r, fh)
+	case PUBREL:
+		return ParsePubrelPacket(r, fh)
+	case PUBCOMP:
+		return ParsePubcompPacket(r, fh)
+	case SUBSCRIBE:
+		return ParseSubscribePacket(r, fh)
+	case SUBACK:
+		return ParseSubackPacket(r, fh)
+	case UNSUBSCRIBE:
+		return ParseUnsubscribePacket(r, fh)
+	case UNSUBACK:
+		return ParseUnsubackPacket(r, fh)
+	case PINGREQ:
+		return ParsePingreqPacket(fh)
+	case PINGRESP:
+		return ParsePingrespPacket(fh)
+	case DISCONNECT:
+		return ParseDisconnectPacket(r, fh)
+	case AUTH:
+		return ParseAuthPacket(r, fh)
+	default:
+		return nil, ErrInvalidType
+	}
+}
+
+func parsePacketBody311(r io.Reader, fh *FixedHeader) (Packet, error) {
+	switch fh.Type {
+	case CONNECT:
+		return ParseConnectPacket311(r, fh)
+	case CONNACK:
+		return ParseConnackPacket311(r, fh)
+	case PUBLISH:
+		return ParsePublishPacket311(r, fh)
+	case PUBACK:
+		return ParsePubackPacket311(r, fh)
+	case PUBREC:
+		return ParsePubrecPacket311(r, fh)
+	case PUBREL:
+		return ParsePubrelPacket311(r, fh)
+	case PUBCOMP:
+		return ParsePubcompPacket311(r, fh)
+	case SUBSCRIBE:
+		return ParseSubscribePacket311(r, fh)
+	case SUBACK:
+		return ParseSubackPacket311(r, fh)
+	case UNSUBSCRIBE:
+		return ParseUnsubscribePacket311(r, fh)
+	case UNSUBACK:
+		return ParseUnsubackPacket311(r, fh)
+	case PINGREQ:
+		return ParsePingreqPacket(fh)
+	case PINGRESP:
+		return ParsePingrespPacket(fh)
+	case DISCONNECT:
+		return ParseDisconnectPacket311(fh)
+	default:
+		return nil, ErrInvalidType
+	}
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter struct {
+	n int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += len(p)
+	return len(p), nil
+}
+
+// EncodePooled encodes p into a buffer of the default buffer pool, the caller releases it once written
+// PUBLISH packets are sized up front so large payloads take a fitting class right away, the other packets are
+// small and start from the smallest class
+func EncodePooled(p Packet) (*bufpool.Buffer, error) {
+	hint := 0
+	switch p.(type) {
+	case *PublishPacket, *PublishPacket311:
+		hint = p.Size()
+	}
+	buf := bufpool.Get(hint)
+	if err := p.Encode(buf); err != nil {
+		buf.Release()
+		return nil, err
+	}
+	return buf, nil
+}
+
+// encodedSize measures a packet by encoding it into a countingWriter
+func encodedSize(p Packet) int {
+	var w countingWriter
+	if err := p.Encode(&w); err != nil {
+		return -1
+	}
+	return w.n
+}
+
+// fixedHeaderSize returns the size of a fixed header carrying remainingLength
+func fixedHeaderSize(remainingLength uint32) int {
+	return 1 + SizeVariableByteInteger(remainingLength)
+}
+
+// Type returns CONNECT
+func (p *ConnectPacket) Type() PacketType {
+	return CONNECT
+}
+
+// Size returns the encoded size of the packet
+func (p *ConnectPacket) Size() int {
+	return encodedSize(p)
+}
+
+// Type returns CONNACK
+func (p *ConnackPacket) Type() PacketType {
+	return CONNACK
+}
+
+// Size returns the encoded size of the packet
+func (p *ConnackPacket) Size() int {
+	return encodedSize(p)
+}
+
+// Type returns PUBLISH
+func (p *PublishPacket) Type() PacketType {
+	return PUBLISH
+}
+
+// Size returns the encoded size of the packet
+func (p *PublishPacket) Size() int {
+	propsLen := p.Properties.calculateLength()
+	remaining := uint32(2+len(p.TopicName)+SizeVariableByteInteger(propsLen)) + uint32(p.PayloadSize()) + propsLen
+	if p.FixedHeader.QoS > QoS0 {
+		remaining += 2
+	}
+	if remaining > MaxVariableByteInteger {
+		return -1
+	}
+	return fixedHeaderSize(remaining) + int(remaining)
+}
+
+// Type returns PUBACK
+func (p *PubackPacket) Type() PacketType {
+	return PUBACK
+}
+
+// Size returns the encoded size of the packet
+func (p *PubackPacket) Size() int {
+	return encodedSize(p)
+}
+
+// Type returns PUBREC
+func (p *PubrecPacket) Type() PacketType {
+	return PUBREC
+}
+
+// Size returns the encoded size of the packet
+func (p *PubrecPacket) Size() int {
+	return encodedSize(p)
+}
+
+// Type returns PUBREL
+func (p *PubrelPacket) Type() PacketType {
+	return PUBREL
+}
+
+// Size returns the encoded size of the packet
+func (p *PubrelPacket) Size() int {
+	return encodedSize(p)
+}
+
+// Type returns PUBCOMP
+func (p *PubcompPacket) Type() PacketType {
+	return PUBCOMP
+}
+
+// Size returns the encoded size of the packet
+func (p *PubcompPacket) Size() int {
+	return encodedSize(p)
+}
+
+// Type returns SUBSCRIBE
+func (p *SubscribePacket) Type() PacketType {
+	return SUBSCRIBE
+}
+
+// Size returns the encoded size of the packet
+func (p *SubscribePacket) Size() int {
+	return encodedSize(p)
+}
+
+// Type returns SUBACK
+func (p *SubackPacket) Type() PacketType {
+	return SUBACK
+}
+
+// Size returns the encoded size of the packet
+func (p *SubackPacket) Size() int {
+	return encodedSize(p)
+}
+
+// Type returns UNSUBSCRIBE
+func (p *UnsubscribePacket) Type() PacketType {
+	return UNSUBSCRIBE
+}
+
+// Size returns the encoded size of the packet
+func (p *UnsubscribePacket) Size() int {
+	return encodedSize(p)
+}
+
+// Type returns UNSUBACK
+func (p *UnsubackPacket) Type() PacketType {
+	return UNSUBACK
+}
+
+// Size returns the encoded size of the packet
+func (p *UnsubackPacket) Size() int {
+	return encodedSize(p)
+}
+
+// Type returns PINGREQ
+func (p *PingreqPacket) Type() PacketType {
+	return PINGREQ
+}
+
+// Size returns the encoded size of the packet
+func (p *PingreqPacket) Size() int {
+	return 2
+}
+
+// Type returns PINGRESP
+func (p *PingrespPacket) Type() PacketType {
+	return PINGRESP
+}
+
+// Size returns the encoded size of the packet
+func (p *PingrespPacket) Size() int {
+	return 2
+}
+
+// Type returns DISCONNECT
+func (p *DisconnectPacket) Type() PacketType {
+	return DISCONNECT
+}
+
+// Size returns the encoded size of the packet
+func (p *DisconnectPacket) Size() int {
+	return encodedSize(p)
+}
+
+// Type returns AUTH
+func (p *AuthPacket) Type() PacketType {
+	return AUTH
+}
+
+// Size returns the encoded size of the packet
+func (p *AuthPacket) Size() int {
+	return encodedSize(p)
+}
+
+// Type returns CONNECT
+func (p *ConnectPacket311) Type() PacketType {
+	return CONNECT
+}
+
+// Size returns the encoded size of the packet
+func (p *ConnectPacket311) Size() int {
+	return encodedSize(p)
+}
+
+// Type returns CONNACK
+func (p *ConnackPacket311) Type() PacketType {
+	return CONNACK
+}
+
+// Size returns the encoded size of the packet
+func (p *ConnackPacket311) Size() int {
+	return encodedSize(p)
+}
+
+// Type returns PUBLISH
+func (p *PublishPacket311) Type() PacketType {
+	return PUBLISH
+}
+
+// Size returns the encoded size of the packet
+func (p *PublishPacket311) Size() int {
+	remaining := uint32(2 + len(p.TopicName) + len(p.Payload))
+	if p.FixedHeader.QoS > QoS0 {
+		remaining += 2
+	}
+	if remaining > MaxVariableByteInteger {
+		return -1
+	}
+	return fixedHeaderSize(remaining) + int(remaining)
+}
+
+// Type returns PUBACK
+func (p *PubackPacket311) Type() PacketType {
+	return PUBACK
+}
+
+// Size returns the encoded size of the packet
+func (p *PubackPacket311) Size() int {
+	return encodedSize(p)
+}
+
+// Type returns PUBREC
+func (p *PubrecPacket311) Type() PacketType {
+	return PUBREC
+}
+
+// Size returns the encoded size of the packet
+func (p *PubrecPacket311) Size() int {
+	return encodedSize(p)
+}
+
+// Type returns PUBREL
+func (p *PubrelPacket311) Type() PacketType {
+	return PUBREL
+}
+
+// Size returns the encoded size of the packet
+func (p *PubrelPacket311) Size() int {
+	return encodedSize(p)
+}
+
+// Type returns PUBCOMP
+func (p *PubcompPacket311) Type() PacketType {
+	return PUBCOMP
+}
+
+// Size returns the encoded size of the packet
+func (p *PubcompPacket311) Size() int {
+	return encodedSize(p)
+}
+
+// Type returns SUBSCRIBE
+func (p *SubscribePacket311) Type() PacketType {
+	return SUBSCRIBE
+}
+
+// Size returns the encoded size of the packet
+func (p *SubscribePacket311) Size() int {
+	return encodedSize(p)
+}
+
+// Type returns SUBACK
+func (p *SubackPacket311) Type() PacketType {
+	return SUBACK
+}
+
+// Size returns the encoded size of the packet
+func (p *SubackPacket311) Size() int {
+	return encodedSize(p)
+}
+
+// Type returns UNSUBSCRIBE
+func (p *UnsubscribePacket311) Type() PacketType {
+	return UNSUBSCRIBE
+}
+
+// Size returns the encoded size of the packet
+func (p *UnsubscribePacket311) Size() int {
+	return encodedSize(p)
+}
+
+// Type returns UNSUBACK
+func (p *UnsubackPacket311) Type() PacketType {
+	return UNSUBACK
+}
+
+// Size returns the encoded size of the packet
+func (p *UnsubackPacket311) Size() int {
+	return encodedSize(p)
+}
+
+// Type returns DISCONNECT
+func (p *DisconnectPacket311) Type() PacketType {
+	return DISCONNECT
+}
+
+// Size returns the encoded size of the packet
+func (p *DisconnectPacket311) Size() int {
+	return encodedSize(p)
+}
@@ -0,0 +1,204 @@
+package encoding
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func samplePackets() []Packet {
+	return []Packet{
+		&ConnectPacket{
+			ProtocolName:    "MQTT",
+			ProtocolVersion: ProtocolVersion50,
+			CleanStart:      true,
+			KeepAlive:       60,
+			ClientID:        "client-1",
+			UsernameFlag:    true,
+			Username:        "user",
+			PasswordFlag:    true,
+			Password:        []byte("secret"),
+		},
+		&ConnackPacket{SessionPresent: true, ReasonCode: ReasonSuccess},
+		&PublishPacket{
+			FixedHeader: FixedHeader{QoS: QoS1},
+			TopicName:   "a/b",
+			PacketID:    1,
+			Payload:     []byte("hello"),
+			Properties:  Properties{Properties: []Property{{ID: PropContentType, Value: "text/plain"}}},
+		},
+		&PubackPacket{PacketID: 2, ReasonCode: ReasonNoMatchingSubscribers},
+		&PubrecPacket{PacketID: 3, ReasonCode: ReasonSuccess},
+		&PubrelPacket{PacketID: 4, ReasonCode: ReasonSuccess},
+		&PubcompPacket{PacketID: 5, ReasonCode: ReasonSuccess},
+		&SubscribePacket{PacketID: 6, Subscriptions: []Subscription{{TopicFilter: "a/#", QoS: QoS1}}},
+		&SubackPacket{PacketID: 6, ReasonCodes: []ReasonCode{ReasonGrantedQoS1}},
+		&UnsubscribePacket{PacketID: 7, TopicFilters: []string{"a/#"}},
+		&UnsubackPacket{PacketID: 7, ReasonCodes: []ReasonCode{ReasonSuccess}},
+		&PingreqPacket{},
+		&PingrespPacket{},
+		&DisconnectPacket{ReasonCode: ReasonServerShuttingDown},
+		&AuthPacket{ReasonCode: ReasonContinueAuthentication},
+	}
+}
+
+func TestParsePacket(t *testing.T) {
+	for _, pkt := range samplePackets() {
+		t.Run(pkt.Type().String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, pkt.Encode(&buf))
+			encoded := append([]byte(nil), buf.Bytes()...)
+			assert.Equal(t, len(encoded), pkt.Size())
+
+			parsed, err := ParsePacket(&buf, ProtocolVersion50)
+			require.NoError(t, err)
+			assert.IsType(t, pkt, parsed)
+			assert.Equal(t, pkt.Type(), parsed.Type())
+			assert.Equal(t, 0, buf.Len())
+
+			var reencoded bytes.Buffer
+			require.NoError(t, parsed.Encode(&reencoded))
+			assert.Equal(t, encoded, reencoded.Bytes())
+			assert.Equal(t, len(encoded), parsed.Size())
+		})
+	}
+}
+
+func TestParsePacket_Stream(t *testing.T) {
+	var stream bytes.Buffer
+	packets := samplePackets()
+	for _, pkt := range packets {
+		require.NoError(t, pkt.Encode(&stream))
+	}
+
+	for _, want := range packets {
+		got, err := ParsePacket(&stream, ProtocolVersion50)
+		require.NoError(t, err)
+		assert.Equal(t, want.Type(), got.Type())
+	}
+
+	_, err := ParsePacket(&stream, ProtocolVersion50)
+	assert.ErrorIs(t, err, ErrUnexpectedEOF)
+}
+
+func TestParsePacket_Invalid(t *testing.T) {
+	_, err := ParsePacket(bytes.NewReader([]byte{0x00, 0x00}), ProtocolVersion50)
+	assert.ErrorIs(t, err, ErrInvalidReservedType)
+
+	_, err = ParsePacket(bytes.NewReader([]byte{0xC0, 0x01, 0x00}), ProtocolVersion50)
+	assert.ErrorIs(t, err, ErrMalformedPacket)
+}
+
+func samplePackets311() []Packet {
+	return []Packet{
+		&ConnectPacket311{ProtocolName: "MQTT", ProtocolVersion: ProtocolVersion311, CleanSession: true, ClientID: "c"},
+		&ConnectPacket311{
+			ProtocolName:    "MQIsdp",
+			ProtocolVersion: ProtocolVersion30,
+			KeepAlive:       30,
+			ClientID:        "c",
+			WillFlag:        true,
+			WillQoS:         QoS1,
+			WillTopic:       "will",
+			WillPayload:     []byte("gone"),
+			UsernameFlag:    true,
+			Username:        "user",
+			PasswordFlag:    true,
+			Password:        []byte("secret"),
+		},
+		&ConnackPacket311{SessionPresent: true, ReturnCode: 0},
+		&PublishPacket311{FixedHeader: FixedHeader{QoS: QoS2}, TopicName: "a/b", PacketID: 9, Payload: []byte("x")},
+		&PublishPacket311{TopicName: "a/b", Payload: []byte("hello")},
+		&PubackPacket311{PacketID: 1},
+		&PubrecPacket311{PacketID: 1},
+		&PubrelPacket311{PacketID: 1},
+		&PubcompPacket311{PacketID: 1},
+		&SubscribePacket311{PacketID: 1, Subscriptions: []Subscription311{{TopicFilter: "a", QoS: QoS0}, {TopicFilter: "b/#", QoS: QoS2}}},
+		&SubackPacket311{PacketID: 1, ReturnCodes: []byte{0, 0x80}},
+		&UnsubscribePacket311{PacketID: 1, TopicFilters: []string{"a"}},
+		&UnsubackPacket311{PacketID: 1},
+		&PingreqPacket{},
+		&PingrespPacket{},
+		&DisconnectPacket311{},
+	}
+}
+
+func TestPacket311_Size(t *testing.T) {
+	for _, pkt := range samplePackets311() {
+		t.Run(pkt.Type().String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, pkt.Encode(&buf))
+			assert.Equal(t, buf.Len(), pkt.Size())
+		})
+	}
+}
+
+func TestParsePacket311(t *testing.T) {
+	for _, version := range []ProtocolVersion{ProtocolVersion311, ProtocolVersion30} {
+		for _, pkt := range samplePackets311() {
+			t.Run(pkt.Type().String(), func(t *testing.T) {
+				var buf bytes.Buffer
+				require.NoError(t, pkt.Encode(&buf))
+				encoded := append([]byte(nil), buf.Bytes()...)
+
+				parsed, err := ParsePacket(&buf, version)
+				require.NoError(t, err)
+				assert.IsType(t, pkt, parsed)
+				assert.Equal(t, 0, buf.Len())
+
+				var reencoded bytes.Buffer
+				require.NoError(t, parsed.Encode(&reencoded))
+				assert.Equal(t, encoded, reencoded.Bytes())
+			})
+		}
+	}
+}
+
+func TestParsePacket_Version(t *testing.T) {
+	// A 3.1.1 PUBLISH has no properties, the 5.0 parser would take the first payload byte for their length
+	var buf bytes.Buffer
+	require.NoError(t, (&PublishPacket311{TopicName: "a", Payload: []byte("hello")}).Encode(&buf))
+	parsed, err := ParsePacket(bytes.NewReader(buf.Bytes()), ProtocolVersion311)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello"), parsed.(*PublishPacket311).Payload)
+
+	// AUTH does not exist before 5.0
+	_, err = ParsePacket(bytes.NewReader([]byte{0xF0, 0x00}), ProtocolVersion311)
+	assert.ErrorIs(t, err, ErrInvalidType)
+
+	_, err = ParsePacket(bytes.NewReader([]byte{0xC0, 0x00}), ProtocolVersion(6))
+	assert.ErrorIs(t, err, ErrInvalidProtocolVersion)
+
+	// The CONNECT of a 5.0 client is not a 3.1.1 packet
+	buf.Reset()
+	require.NoError(t, samplePackets()[0].Encode(&buf))
+	_, err = ParsePacket(&buf, ProtocolVersion311)
+	assert.ErrorIs(t, err, ErrInvalidProtocolVersion)
+
+	_, err = ParsePacket(bytes.NewReader([]byte{0x40, 0x03, 0x00, 0x01, 0x00}), ProtocolVersion311)
+	assert.ErrorIs(t, err, ErrMalformedPacket)
+}
+
+func TestEncodePooled(t *testing.T) {
+	large := &PublishPacket{TopicName: "a/b", Payload: bytes.Repeat([]byte("x"), 10000)}
+	for _, pkt := range append(samplePackets(), large) {
+		t.Run(pkt.Type().String(), func(t *testing.T) {
+			var want bytes.Buffer
+			require.NoError(t, pkt.Encode(&want))
+
+			buf, err := EncodePooled(pkt)
+			require.NoError(t, err)
+			defer buf.Release()
+			assert.Equal(t, want.Bytes(), buf.Bytes())
+		})
+	}
+	buf, err := EncodePooled(large)
+	require.NoError(t, err)
+	assert.Equal(t, 64<<10, cap(buf.B), "a large PUBLISH is sized before encoding")
+	buf.Release()
+
+	_, err = EncodePooled(&PublishPacket{FixedHeader: FixedHeader{QoS: 3}, TopicName: "a"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,343 @@
+package encoding
+
+import (
+	"io"
+)
+
+// ParseConnectPacket311 parses an MQTT 3.1.1 CONNECT packet, MQTT 3.0 packets using the MQIsdp protocol name
+// are accepted as well
+func ParseConnectPacket311(r io.Reader, fh *FixedHeader) (*ConnectPacket311, error) {
+	pkt := &ConnectPacket311{FixedHeader: *fh}
+
+	// Read protocol name
+	protocolName, err := readUTF8String(r)
+	if err != nil {
+		return nil, err
+	}
+	pkt.ProtocolName = protocolName
+
+	// Read protocol version
+	version, err := readByte(r)
+	if err != nil {
+		return nil, err
+	}
+	pkt.ProtocolVersion = ProtocolVersion(version)
+
+	// The protocol name must match the version
+	switch {
+	case protocolName == "MQTT" && pkt.ProtocolVersion == ProtocolVersion311:
+	case protocolName == "MQIsdp" && pkt.ProtocolVersion == ProtocolVersion30:
+	case protocolName != "MQTT" && protocolName != "MQIsdp":
+		return nil, ErrInvalidProtocolName
+	default:
+		return nil, ErrInvalidProtocolVersion
+	}
+
+	// Read connect flags
+	flags, err := readByte(r)
+	if err != nil {
+		return nil, err
+	}
+
+	pkt.CleanSession = (flags & 0x02) != 0
+	pkt.WillFlag = (flags & 0x04) != 0
+	pkt.WillQoS = QoS((flags & 0x18) >> 3)
+	pkt.WillRetain = (flags & 0x20) != 0
+	pkt.PasswordFlag = (flags & 0x40) != 0
+	pkt.UsernameFlag = (flags & 0x80) != 0
+
+	// Validate reserved bit (bit 0) must be 0
+	if (flags & 0x01) != 0 {
+		return nil, ErrMalformedPacket
+	}
+
+	// Read keep alive
+	keepAlive, err := readTwoByteInt(r)
+	if err != nil {
+		return nil, err
+	}
+	pkt.KeepAlive = keepAlive
+
+	// Read client ID
+	clientID, err := readUTF8String(r)
+	if err != nil {
+		return nil, err
+	}
+	pkt.ClientID = clientID
+
+	// Read Will topic and payload if Will flag is set
+	if pkt.WillFlag {
+		willTopic, err := readUTF8String(r)
+		if err != nil {
+			return nil, err
+		}
+		pkt.WillTopic = willTopic
+
+		willPayload, err := readBinaryData(r)
+		if err != nil {
+			return nil, err
+		}
+		pkt.WillPayload = willPayload
+	}
+
+	// Read username if flag is set
+	if pkt.UsernameFlag {
+		username, err := readUTF8String(r)
+		if err != nil {
+			return nil, err
+		}
+		pkt.Username = username
+	}
+
+	// Read password if flag is set
+	if pkt.PasswordFlag {
+		password, err := readBinaryData(r)
+		if err != nil {
+			return nil, err
+		}
+		pkt.Password = password
+	}
+
+	return pkt, nil
+}
+
+// ParseConnackPacket311 parses an MQTT 3.1.1 CONNACK packet
+func ParseConnackPacket311(r io.Reader, fh *FixedHeader) (*ConnackPacket311, error) {
+	if fh.RemainingLength != 2 {
+		return nil, ErrMalformedPacket
+	}
+	pkt := &ConnackPacket311{FixedHeader: *fh}
+
+	// Read connect acknowledge flags
+	flags, err := readByte(r)
+	if err != nil {
+		return nil, err
+	}
+	pkt.SessionPresent = (flags & 0x01) != 0
+
+	// Reserved bits (bits 7-1) must be 0
+	if (flags & 0xFE) != 0 {
+		return nil, ErrMalformedPacket
+	}
+
+	// Read return code
+	returnCode, err := readByte(r)
+	if err != nil {
+		return nil, err
+	}
+	pkt.ReturnCode = returnCode
+
+	return pkt, nil
+}
+
+// ParsePublishPacket311 parses an MQTT 3.1.1 PUBLISH packet
+func ParsePublishPacket311(r io.Reader, fh *FixedHeader) (*PublishPacket311, error) {
+	pkt := &PublishPacket311{FixedHeader: *fh}
+
+	// Read topic name
+	topicName, err := readUTF8String(r)
+	if err != nil {
+		return nil, err
+	}
+	pkt.TopicName = topicName
+
+	// Read packet ID for QoS 1 and 2
+	headerSize := 2 + len(topicName)
+	if fh.QoS > QoS0 {
+		packetID, err := readTwoByteInt(r)
+		if err != nil {
+			return nil, err
+		}
+		if packetID == 0 {
+			return nil, ErrInvalidPacketID
+		}
+		pkt.PacketID = packetID
+		headerSize += 2
+	}
+
+	// The payload is the rest of the packet
+	payloadLength := int(fh.RemainingLength) - headerSize
+	if payloadLength < 0 {
+		return nil, ErrMalformedPacket
+	}
+	if payloadLength > 0 {
+		payload := make([]byte, payloadLength)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			if err == io.EOF {
+				return nil, ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		pkt.Payload = payload
+	}
+
+	return pkt, nil
+}
+
+// parsePacketID311 reads the packet ID making up the whole body of PUBACK, PUBREC, PUBREL, PUBCOMP and UNSUBACK
+func parsePacketID311(r io.Reader, fh *FixedHeader) (uint16, error) {
+	if fh.RemainingLength != 2 {
+		return 0, ErrMalformedPacket
+	}
+	return readTwoByteInt(r)
+}
+
+// ParsePubackPacket311 parses an MQTT 3.1.1 PUBACK packet
+func ParsePubackPacket311(r io.Reader, fh *FixedHeader) (*PubackPacket311, error) {
+	packetID, err := parsePacketID311(r, fh)
+	if err != nil {
+		return nil, err
+	}
+	return &PubackPacket311{FixedHeader: *fh, PacketID: packetID}, nil
+}
+
+// ParsePubrecPacket311 parses an MQTT 3.1.1 PUBREC packet
+func ParsePubrecPacket311(r io.Reader, fh *FixedHeader) (*PubrecPacket311, error) {
+	packetID, err := parsePacketID311(r, fh)
+	if err != nil {
+		return nil, err
+	}
+	return &PubrecPacket311{FixedHeader: *fh, PacketID: packetID}, nil
+}
+
+// ParsePubrelPacket311 parses an MQTT 3.1.1 PUBREL packet
+func ParsePubrelPacket311(r io.Reader, fh *FixedHeader) (*PubrelPacket311, error) {
+	packetID, err := parsePacketID311(r, fh)
+	if err != nil {
+		return nil, err
+	}
+	return &PubrelPacket311{FixedHeader: *fh, PacketID: packetID}, nil
+}
+
+// ParsePubcompPacket311 parses an MQTT 3.1.1 PUBCOMP packet
+func ParsePubcompPacket311(r io.Reader, fh *FixedHeader) (*PubcompPacket311, error) {
+	packetID, err := parsePacketID311(r, fh)
+	if err != nil {
+		return nil, err
+	}
+	return &PubcompPacket311{FixedHeader: *fh, PacketID: packetID}, nil
+}
+
+// ParseSubscribePacket311 parses an MQTT 3.1.1 SUBSCRIBE packet
+func ParseSubscribePacket311(r io.Reader, fh *FixedHeader) (*SubscribePacket311, error) {
+	pkt := &SubscribePacket311{FixedHeader: *fh}
+
+	// Read packet ID
+	packetID, err := readTwoByteInt(r)
+	if err != nil {
+		return nil, err
+	}
+	pkt.PacketID = packetID
+
+	pkt.Subscriptions = make([]Subscription311, 0, 2)
+	bytesRead := 2
+
+	for bytesRead < int(fh.RemainingLength) {
+		// Read topic filter
+		topicFilter, err := readUTF8String(r)
+		if err != nil {
+			return nil, err
+		}
+		bytesRead += 2 + len(topicFilter)
+
+		// Read requested QoS
+		options, err := readByte(r)
+		if err != nil {
+			return nil, err
+		}
+		bytesRead++
+
+		// Reserved bits (bits 7-2) must be 0
+		if (options & 0xFC) != 0 {
+			return nil, ErrMalformedPacket
+		}
+
+		pkt.Subscriptions = append(pkt.Subscriptions, Subscription311{
+			TopicFilter: topicFilter,
+			QoS:         QoS(options & 0x03),
+		})
+	}
+
+	// SUBSCRIBE packet must contain at least one subscription
+	if len(pkt.Subscriptions) == 0 {
+		return nil, ErrMalformedPacket
+	}
+
+	return pkt, nil
+}
+
+// ParseSubackPacket311 parses an MQTT 3.1.1 SUBACK packet
+func ParseSubackPacket311(r io.Reader, fh *FixedHeader) (*SubackPacket311, error) {
+	if fh.RemainingLength < 2 {
+		return nil, ErrMalformedPacket
+	}
+	pkt := &SubackPacket311{FixedHeader: *fh}
+
+	// Read packet ID
+	packetID, err := readTwoByteInt(r)
+	if err != nil {
+		return nil, err
+	}
+	pkt.PacketID = packetID
+
+	// Read return codes
+	pkt.ReturnCodes = make([]byte, int(fh.RemainingLength)-2)
+	for i := range pkt.ReturnCodes {
+		rc, err := readByte(r)
+		if err != nil {
+			return nil, err
+		}
+		pkt.ReturnCodes[i] = rc
+	}
+
+	return pkt, nil
+}
+
+// ParseUnsubscribePacket311 parses an MQTT 3.1.1 UNSUBSCRIBE packet
+func ParseUnsubscribePacket311(r io.Reader, fh *FixedHeader) (*UnsubscribePacket311, error) {
+	pkt := &UnsubscribePacket311{FixedHeader: *fh}
+
+	// Read packet ID
+	packetID, err := readTwoByteInt(r)
+	if err != nil {
+		return nil, err
+	}
+	pkt.PacketID = packetID
+
+	// Read topic filters
+	pkt.TopicFilters = make([]string, 0)
+	bytesRead := 2
+
+	for bytesRead < int(fh.RemainingLength) {
+		topicFilter, err := readUTF8String(r)
+		if err != nil {
+			return nil, err
+		}
+		bytesRead += 2 + len(topicFilter)
+		pkt.TopicFilters = append(pkt.TopicFilters, topicFilter)
+	}
+
+	// UNSUBSCRIBE packet must contain at least one topic filter
+	if len(pkt.TopicFilters) == 0 {
+		return nil, ErrMalformedPacket
+	}
+
+	return pkt, nil
+}
+
+// ParseUnsubackPacket311 parses an MQTT 3.1.1 UNSUBACK packet
+func ParseUnsubackPacket311(r io.Reader, fh *FixedHeader) (*UnsubackPacket311, error) {
+	packetID, err := parsePacketID311(r, fh)
+	if err != nil {
+		return nil, err
+	}
+	return &UnsubackPacket311{FixedHeader: *fh, PacketID: packetID}, nil
+}
+
+// ParseDisconnectPacket311 parses an MQTT 3.1.1 DISCONNECT packet
+func ParseDisconnectPacket311(fh *FixedHeader) (*DisconnectPacket311, error) {
+	if fh.RemainingLength != 0 {
+		return nil, ErrMalformedPacket
+	}
+	return &DisconnectPacket311{FixedHeader: *fh}, nil
+}
@@ -94,6 +94,7 @@ type PublishPacket struct {
 	PacketID    uint16 // Only for QoS 1 and 2
 	Properties  Properties
 	Payload     []byte
+	Spool       PayloadSink // Payload spooled outside memory by ParsePublishPacketSpooled, Payload is empty then
 }
 
 // PubackPacket represents an MQTT 5.0 PUBACK packet
@@ -337,6 +338,19 @@ func ParseConnackPacket(r io.Reader, fh *FixedHeader) (*ConnackPacket, error) {
 
 // ParsePublishPacket parses an MQTT 5.0 PUBLISH packet
 func ParsePublishPacket(r io.Reader, fh *FixedHeader) (*PublishPacket, error) {
+	return parsePublishPacket(r, fh, nil)
+}
+
+func parsePublishPacket(r io.Reader, fh *FixedHeader, spool *PayloadSpool) (*PublishPacket, error) {
+	pkt, err := parsePublishHeader(r, fh)
+	if err != nil {
+		return nil, err
+	}
+	return parsePublishRest(r, pkt, spool)
+}
+
+// parsePublishHeader reads the topic name and packet ID, the fields preceding the properties
+func parsePublishHeader(r io.Reader, fh *FixedHeader) (*PublishPacket, error) {
 	pkt := &PublishPacket{FixedHeader: *fh}
 
 	// Read topic name
@@ -357,6 +371,13 @@ func ParsePublishPacket(r io.Reader, fh *FixedHeader) (*PublishPacket, error) {
 		}
 		pkt.PacketID = packetID
 	}
+	return pkt, nil
+}
+
+// parsePublishRest reads the properties and payload following the header read by parsePublishHeader
+func parsePublishRest(r io.Reader, pkt *PublishPacket, spool *PayloadSpool) (*PublishPacket, error) {
+	fh := &pkt.FixedHeader
+	topicName := pkt.TopicName
 
 	// Read properties
 	props, err := ParseProperties(r)
@@ -373,7 +394,13 @@ func ParsePublishPacket(r io.Reader, fh *FixedHeader) (*PublishPacket, error) {
 	headerSize += int(props.Length) + len(EncodeVariableByteIntegerMust(props.Length))
 
 	payloadLength := int(fh.RemainingLength) - headerSize
-	if payloadLength > 0 {
+	if spool != nil && int64(payloadLength) > spool.Threshold {
+		sink, err := spool.spool(r, int64(payloadLength))
+		if err != nil {
+			return nil, err
+		}
+		pkt.Spool = sink
+	} else if payloadLength > 0 {
 		payload := make([]byte, payloadLength)
 		if _, err := io.ReadFull(r, payload); err != nil {
 			if err == io.EOF {
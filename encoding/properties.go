@@ -2,6 +2,8 @@ package encoding
 
 import (
 	"io"
+
+	"github.com/axmq/ax/pkg/bufpool"
 )
 
 // PropertyID represents MQTT 5.0 property identifiers
@@ -101,12 +103,22 @@ var propertySpecs = map[PropertyID]propertySpec{
 
 // ParseProperties parses MQTT 5.0 properties from a reader
 func ParseProperties(r io.Reader) (*Properties, error) {
+	limits := limitsOf(r)
+	if br, ok := bufferedReader(r); ok {
+		return parsePropertiesFrom(br, limits)
+	}
+
 	// Read property length (Variable Byte Integer)
 	propLength, err := DecodeVariableByteInteger(r)
 	if err != nil {
 		return nil, err
 	}
 
+	return parsePropertyList(r, propLength, limits)
+}
+
+// parsePropertyList parses propLength bytes of properties following the property length
+func parsePropertyList(r io.Reader, propLength uint32, limits *PropertyLimits) (*Properties, error) {
 	props := &Properties{
 		Length:     propLength,
 		Properties: make([]Property, 0, 4),
@@ -121,11 +133,15 @@ func ParseProperties(r io.Reader) (*Properties, error) {
 	limitedReader := io.LimitedReader{R: r, N: int64(propLength)}
 
 	// Parse individual properties
+	budget := newUserPropertyBudget(limits)
 	for limitedReader.N > 0 {
 		prop, err := parseProperty(&limitedReader)
 		if err != nil {
 			return nil, err
 		}
+		if err := budget.add(prop); err != nil {
+			return nil, err
+		}
 		props.Properties = append(props.Properties, *prop)
 	}
 
@@ -134,6 +150,10 @@ func ParseProperties(r io.Reader) (*Properties, error) {
 
 // ParsePropertiesFromBytes parses MQTT 5.0 properties from a byte slice
 func ParsePropertiesFromBytes(data []byte) (*Properties, int, error) {
+	return parsePropertiesFromBytes(data, &defaultPropertyLimits)
+}
+
+func parsePropertiesFromBytes(data []byte, limits *PropertyLimits) (*Properties, int, error) {
 	if len(data) == 0 {
 		return nil, 0, ErrUnexpectedEOF
 	}
@@ -164,11 +184,15 @@ func ParsePropertiesFromBytes(data []byte) (*Properties, int, error) {
 
 	// Parse individual properties
 	propEnd := offset + int(propLength)
+	budget := newUserPropertyBudget(limits)
 	for offset < propEnd {
 		prop, bytesRead, err := parsePropertyFromBytes(data[offset:])
 		if err != nil {
 			return nil, 0, err
 		}
+		if err := budget.add(prop); err != nil {
+			return nil, 0, err
+		}
 		props.Properties = append(props.Properties, *prop)
 		offset += bytesRead
 	}
@@ -459,6 +483,9 @@ type UTF8Pair struct {
 // Helper functions for reading/writing different data types
 
 func readByte(r io.Reader) (byte, error) {
+	if br, ok := bufferedReader(r); ok {
+		return readByteFrom(br)
+	}
 	var b [1]byte
 	if _, err := io.ReadFull(r, b[:]); err != nil {
 		if err == io.EOF {
@@ -477,6 +504,9 @@ func readByteFromBytes(data []byte) (byte, int, error) {
 }
 
 func readTwoByteInt(r io.Reader) (uint16, error) {
+	if br, ok := bufferedReader(r); ok {
+		return readTwoByteIntFrom(br)
+	}
 	var b [2]byte
 	if _, err := io.ReadFull(r, b[:]); err != nil {
 		if err == io.EOF {
@@ -515,6 +545,9 @@ func readFourByteIntFromBytes(data []byte) (uint32, int, error) {
 }
 
 func readUTF8String(r io.Reader) (string, error) {
+	if br, ok := bufferedReader(r); ok {
+		return readUTF8StringFrom(br)
+	}
 	length, err := readTwoByteInt(r)
 	if err != nil {
 		return "", err
@@ -524,7 +557,10 @@ func readUTF8String(r io.Reader) (string, error) {
 		return "", nil
 	}
 
-	buf := make([]byte, length)
+	// The bytes are copied into the string, a pooled buffer is enough to read them
+	pooled := bufpool.Get(int(length))
+	defer pooled.Release()
+	buf := pooled.B[:length]
 	if _, err := io.ReadFull(r, buf); err != nil {
 		return "", ErrUnexpectedEOF
 	}
@@ -748,7 +784,9 @@ func writeUTF8PairToBytes(buf []byte, value UTF8Pair) (int, error) {
 func writeBinaryData(w io.Writer, value []byte) error {
 	length := uint16(len(value))
 	// Write length (2 bytes) + data
-	buf := make([]byte, 2+length)
+	pooled := bufpool.Get(2 + int(length))
+	defer pooled.Release()
+	buf := pooled.B[:2+int(length)]
 	bytesWritten, err := writeBinaryDataToBytes(buf, value)
 	if err != nil {
 		return err
@@ -0,0 +1,49 @@
+package encoding
+
+import "io"
+
+// ParsePublishPacketFiltered parses an MQTT 5.0 PUBLISH packet once keep accepted its topic name, so a message
+// nobody receives costs a topic read instead of a property and payload parse
+// keep runs before the properties are read and reports false for a packet to skip: the rest of its body is then
+// discarded and the returned packet holds the fixed header, topic name and packet ID only, enough to acknowledge it.
+// A packet with an empty topic name uses a Topic Alias, which is a property, and a retained message is stored
+// whether or not anybody subscribed, so both are always parsed in full
+func ParsePublishPacketFiltered(r io.Reader, fh *FixedHeader, spool *PayloadSpool, keep func(topicName string) bool) (*PublishPacket, bool, error) {
+	pkt, err := parsePublishHeader(r, fh)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if keep == nil || pkt.TopicName == "" || fh.Retain || keep(pkt.TopicName) {
+		pkt, err = parsePublishRest(r, pkt, spool)
+		return pkt, err == nil, err
+	}
+
+	rest := int64(fh.RemainingLength) - 2 - int64(len(pkt.TopicName))
+	if fh.QoS > QoS0 {
+		rest -= 2
+	}
+	if rest < 0 {
+		return nil, false, ErrMalformedPacket
+	}
+	if n, err := io.CopyN(io.Discard, r, rest); err != nil {
+		if err == io.EOF && n < rest {
+			return nil, false, ErrUnexpectedEOF
+		}
+		return nil, false, err
+	}
+	return pkt, false, nil
+}
+
+// NoMatchingSubscribersAck returns the acknowledgement of a PUBLISH dropped for lacking subscribers, a PUBACK for
+// QoS 1 and a PUBREC for QoS 2 with reason code No matching subscribers, nil for QoS 0 which is not acknowledged
+func (p *PublishPacket) NoMatchingSubscribersAck() Packet {
+	switch p.FixedHeader.QoS {
+	case QoS1:
+		return &PubackPacket{PacketID: p.PacketID, ReasonCode: ReasonNoMatchingSubscribers}
+	case QoS2:
+		return &PubrecPacket{PacketID: p.PacketID, ReasonCode: ReasonNoMatchingSubscribers}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,103 @@
+package encoding
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePublishPacketFiltered(t *testing.T) {
+	var buf bytes.Buffer
+	withProps := &PublishPacket{
+		FixedHeader: FixedHeader{Type: PUBLISH, QoS: QoS2},
+		TopicName:   "sensors/void",
+		PacketID:    9,
+		Properties:  Properties{Properties: []Property{{ID: PropContentType, Value: "text/plain"}}},
+		Payload:     []byte("ignored"),
+	}
+	require.NoError(t, withProps.Encode(&buf))
+	buf.Write(encodeTestPublish(t, []byte("kept")))
+
+	var seen []string
+	keep := func(topicName string) bool {
+		seen = append(seen, topicName)
+		return topicName != "sensors/void"
+	}
+
+	r := bytes.NewReader(buf.Bytes())
+	fh, err := ParseFixedHeader(r)
+	require.NoError(t, err)
+	pkt, kept, err := ParsePublishPacketFiltered(r, fh, nil, keep)
+	require.NoError(t, err)
+	assert.False(t, kept)
+	assert.Equal(t, "sensors/void", pkt.TopicName)
+	assert.Equal(t, uint16(9), pkt.PacketID)
+	assert.Empty(t, pkt.Properties.Properties)
+	assert.Empty(t, pkt.Payload)
+	assert.Equal(t, &PubrecPacket{PacketID: 9, ReasonCode: ReasonNoMatchingSubscribers}, pkt.NoMatchingSubscribersAck())
+
+	// The skipped body is consumed, the next packet parses from the right offset
+	fh, err = ParseFixedHeader(r)
+	require.NoError(t, err)
+	pkt, kept, err = ParsePublishPacketFiltered(r, fh, nil, keep)
+	require.NoError(t, err)
+	assert.True(t, kept)
+	assert.Equal(t, []byte("kept"), pkt.Payload)
+	assert.Equal(t, &PubackPacket{PacketID: 7, ReasonCode: ReasonNoMatchingSubscribers}, pkt.NoMatchingSubscribersAck())
+	assert.Equal(t, []string{"sensors/void", "firmware/v2"}, seen)
+	assert.Zero(t, r.Len())
+}
+
+func TestParsePublishPacketFiltered_TopicAlias(t *testing.T) {
+	var buf bytes.Buffer
+	aliased := &PublishPacket{
+		FixedHeader: FixedHeader{Type: PUBLISH, QoS: QoS0},
+		Properties:  Properties{Properties: []Property{{ID: PropTopicAlias, Value: uint16(3)}}},
+		Payload:     []byte("x"),
+	}
+	require.NoError(t, aliased.Encode(&buf))
+
+	r := bytes.NewReader(buf.Bytes())
+	fh, err := ParseFixedHeader(r)
+	require.NoError(t, err)
+	pkt, kept, err := ParsePublishPacketFiltered(r, fh, nil, func(string) bool { return false })
+	require.NoError(t, err)
+	assert.True(t, kept)
+	assert.Len(t, pkt.Properties.Properties, 1)
+	assert.Nil(t, pkt.NoMatchingSubscribersAck())
+}
+
+func TestParsePublishPacketFiltered_Retain(t *testing.T) {
+	var buf bytes.Buffer
+	retained := &PublishPacket{
+		FixedHeader: FixedHeader{Type: PUBLISH, QoS: QoS1, Retain: true},
+		TopicName:   "status/dev-1",
+		PacketID:    4,
+		Properties:  Properties{Properties: []Property{{ID: PropContentType, Value: "text/plain"}}},
+		Payload:     []byte("online"),
+	}
+	require.NoError(t, retained.Encode(&buf))
+
+	r := bytes.NewReader(buf.Bytes())
+	fh, err := ParseFixedHeader(r)
+	require.NoError(t, err)
+	called := false
+	pkt, kept, err := ParsePublishPacketFiltered(r, fh, nil, func(string) bool { called = true; return false })
+	require.NoError(t, err)
+	assert.True(t, kept)
+	assert.False(t, called)
+	assert.Equal(t, []byte("online"), pkt.Payload)
+	assert.Len(t, pkt.Properties.Properties, 1)
+	assert.Zero(t, r.Len())
+}
+
+func TestParsePublishPacketFiltered_Truncated(t *testing.T) {
+	data := encodeTestPublish(t, bytes.Repeat([]byte{1}, 64))
+	r := bytes.NewReader(data[:len(data)-10])
+	fh, err := ParseFixedHeader(r)
+	require.NoError(t, err)
+	_, _, err = ParsePublishPacketFiltered(r, fh, nil, func(string) bool { return false })
+	assert.ErrorIs(t, err, ErrUnexpectedEOF)
+}
@@ -0,0 +1,131 @@
+package encoding
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// Limits of the small PUBLISH fast path, sized for typical telemetry messages
+const (
+	SmallPublishMaxTopic         = 64
+	SmallPublishMaxPayload       = 256
+	SmallPublishMaxProperties    = 2
+	SmallPublishMaxPropertyBytes = 64
+)
+
+// smallPublishMaxSize bounds an encoded small PUBLISH: fixed header byte, 2 byte remaining length,
+// topic, packet ID, 1 byte property length, properties and payload
+const smallPublishMaxSize = 1 + 2 + 2 + SmallPublishMaxTopic + 2 + 1 + SmallPublishMaxPropertyBytes + SmallPublishMaxPayload
+
+// ErrNotSmallPublish indicates a PUBLISH packet exceeds the limits of the small fast path
+var ErrNotSmallPublish = errors.New("packet exceeds small PUBLISH limits")
+
+var smallPublishPool = sync.Pool{
+	New: func() any {
+		return new([smallPublishMaxSize]byte)
+	},
+}
+
+// IsSmall reports whether the packet is within the topic, payload and property count limits of the fast path
+func (p *PublishPacket) IsSmall() bool {
+	return p.Spool == nil &&
+		len(p.TopicName) <= SmallPublishMaxTopic &&
+		len(p.Payload) <= SmallPublishMaxPayload &&
+		len(p.Properties.Properties) <= SmallPublishMaxProperties
+}
+
+// AppendPublishSmall appends the MQTT 5.0 encoding of a small PUBLISH packet to dst
+// Properties are written straight into place instead of being sized up front,
+// it returns ErrNotSmallPublish when the packet does not fit the fast path
+func AppendPublishSmall(dst []byte, p *PublishPacket) ([]byte, error) {
+	if !p.IsSmall() {
+		return dst, ErrNotSmallPublish
+	}
+	if p.FixedHeader.QoS > QoS2 {
+		return dst, ErrInvalidQoS
+	}
+
+	var buf [smallPublishMaxSize]byte
+	start, end, err := encodePublishSmall(buf[:], p)
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, buf[start:end]...), nil
+}
+
+// EncodePublishSmall writes a PUBLISH packet with a single Write from a pooled fixed size buffer,
+// packets outside the small limits are encoded with Encode
+func EncodePublishSmall(w io.Writer, p *PublishPacket) error {
+	if !p.IsSmall() || p.FixedHeader.QoS > QoS2 {
+		return p.Encode(w)
+	}
+
+	buf := smallPublishPool.Get().(*[smallPublishMaxSize]byte)
+	defer smallPublishPool.Put(buf)
+
+	start, end, err := encodePublishSmall(buf[:], p)
+	if errors.Is(err, ErrNotSmallPublish) {
+		return p.Encode(w)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(buf[start:end])
+	return err
+}
+
+// encodePublishSmall lays out the variable header and payload from offset 3,
+// then writes the fixed header right-aligned in front of it so nothing has to be moved
+// It returns the bounds of the packet within buf
+func encodePublishSmall(buf []byte, p *PublishPacket) (int, int, error) {
+	const body = 3
+	offset := body
+
+	n, err := writeUTF8StringToBytes(buf[offset:], p.TopicName)
+	if err != nil {
+		return 0, 0, err
+	}
+	offset += n
+
+	if p.FixedHeader.QoS > QoS0 {
+		buf[offset] = byte(p.PacketID >> 8)
+		buf[offset+1] = byte(p.PacketID)
+		offset += 2
+	}
+
+	// The property length always fits in one byte, reserve it and fill it in afterwards
+	propsLenAt := offset
+	offset++
+	props := buf[offset : offset+SmallPublishMaxPropertyBytes]
+	propsLen := 0
+	for i := range p.Properties.Properties {
+		n, err := encodePropertyToBytes(props[propsLen:], &p.Properties.Properties[i])
+		if errors.Is(err, ErrBufferTooSmall) {
+			return 0, 0, ErrNotSmallPublish
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		propsLen += n
+	}
+	buf[propsLenAt] = byte(propsLen)
+	offset += propsLen
+
+	offset += copy(buf[offset:], p.Payload)
+
+	remaining := offset - body
+	start := body - 1
+	if remaining >= 128 {
+		start--
+		buf[start] = byte(remaining&0x7F) | 0x80
+		buf[start+1] = byte(remaining >> 7)
+	} else {
+		buf[start] = byte(remaining)
+	}
+	start--
+	buf[start] = byte(PUBLISH)<<4 | p.FixedHeader.BuildPublishFlags()
+
+	return start, offset, nil
+}
@@ -0,0 +1,75 @@
+package encoding
+
+import (
+	"io"
+	"testing"
+)
+
+func newSmallTelemetryPublish() *PublishPacket {
+	return &PublishPacket{
+		FixedHeader: FixedHeader{QoS: QoS1},
+		TopicName:   "factory/line-3/sensor-17/temperature",
+		PacketID:    42,
+		Payload:     []byte(`{"ts":1712345678,"value":21.57,"unit":"C"}`),
+		Properties: Properties{Properties: []Property{
+			{ID: PropPayloadFormatIndicator, Value: byte(1)},
+			{ID: PropContentType, Value: "application/json"},
+		}},
+	}
+}
+
+func BenchmarkEncodePublishSmall_Generic(b *testing.B) {
+	packet := newSmallTelemetryPublish()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_ = packet.Encode(io.Discard)
+	}
+}
+
+func BenchmarkEncodePublishSmall_FastPath(b *testing.B) {
+	packet := newSmallTelemetryPublish()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_ = EncodePublishSmall(io.Discard, packet)
+	}
+}
+
+func BenchmarkEncodePublishSmall_Append(b *testing.B) {
+	packet := newSmallTelemetryPublish()
+	buf := make([]byte, 0, smallPublishMaxSize)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		buf, _ = AppendPublishSmall(buf[:0], packet)
+	}
+}
+
+func BenchmarkEncodePublishSmall_QoS0NoProperties(b *testing.B) {
+	packet := &PublishPacket{
+		FixedHeader: FixedHeader{QoS: QoS0},
+		TopicName:   "test/topic",
+		Payload:     []byte("hello world"),
+	}
+
+	b.Run("generic", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = packet.Encode(io.Discard)
+		}
+	})
+
+	b.Run("fast", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = EncodePublishSmall(io.Discard, packet)
+		}
+	})
+}
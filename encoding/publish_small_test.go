@@ -0,0 +1,145 @@
+package encoding
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodePublishSmall(t *testing.T) {
+	tests := []struct {
+		name      string
+		packet    *PublishPacket
+		wantSmall bool
+	}{
+		{
+			name: "qos0 no properties",
+			packet: &PublishPacket{
+				FixedHeader: FixedHeader{QoS: QoS0},
+				TopicName:   "sensors/1/temp",
+				Payload:     []byte("21.5"),
+			},
+			wantSmall: true,
+		},
+		{
+			name: "qos1 retain dup with properties",
+			packet: &PublishPacket{
+				FixedHeader: FixedHeader{QoS: QoS1, Retain: true, DUP: true},
+				TopicName:   "sensors/1/temp",
+				PacketID:    513,
+				Payload:     []byte(`{"t":21.5}`),
+				Properties: Properties{Properties: []Property{
+					{ID: PropPayloadFormatIndicator, Value: byte(1)},
+					{ID: PropContentType, Value: "application/json"},
+				}},
+			},
+			wantSmall: true,
+		},
+		{
+			name: "limits with two byte remaining length",
+			packet: &PublishPacket{
+				FixedHeader: FixedHeader{QoS: QoS2},
+				TopicName:   strings.Repeat("t", SmallPublishMaxTopic),
+				PacketID:    65535,
+				Payload:     bytes.Repeat([]byte{0xAB}, SmallPublishMaxPayload),
+				Properties: Properties{Properties: []Property{
+					{ID: PropMessageExpiryInterval, Value: uint32(60)},
+					{ID: PropResponseTopic, Value: strings.Repeat("r", 40)},
+				}},
+			},
+			wantSmall: true,
+		},
+		{
+			name: "empty payload",
+			packet: &PublishPacket{
+				FixedHeader: FixedHeader{QoS: QoS0},
+				TopicName:   "a",
+			},
+			wantSmall: true,
+		},
+		{
+			name: "payload too large",
+			packet: &PublishPacket{
+				FixedHeader: FixedHeader{QoS: QoS0},
+				TopicName:   "a",
+				Payload:     make([]byte, SmallPublishMaxPayload+1),
+			},
+		},
+		{
+			name: "topic too long",
+			packet: &PublishPacket{
+				FixedHeader: FixedHeader{QoS: QoS0},
+				TopicName:   strings.Repeat("t", SmallPublishMaxTopic+1),
+			},
+		},
+		{
+			name: "too many properties",
+			packet: &PublishPacket{
+				FixedHeader: FixedHeader{QoS: QoS0},
+				TopicName:   "a",
+				Properties: Properties{Properties: []Property{
+					{ID: PropPayloadFormatIndicator, Value: byte(1)},
+					{ID: PropMessageExpiryInterval, Value: uint32(60)},
+					{ID: PropContentType, Value: "text/plain"},
+				}},
+			},
+		},
+		{
+			name: "property bytes too large",
+			packet: &PublishPacket{
+				FixedHeader: FixedHeader{QoS: QoS0},
+				TopicName:   "a",
+				Properties: Properties{Properties: []Property{
+					{ID: PropContentType, Value: strings.Repeat("c", SmallPublishMaxPropertyBytes)},
+				}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var want bytes.Buffer
+			require.NoError(t, tt.packet.Encode(&want))
+
+			var got bytes.Buffer
+			require.NoError(t, EncodePublishSmall(&got, tt.packet))
+			assert.Equal(t, want.Bytes(), got.Bytes())
+
+			appended, err := AppendPublishSmall([]byte{0xFF}, tt.packet)
+			if tt.wantSmall {
+				require.NoError(t, err)
+				assert.Equal(t, append([]byte{0xFF}, want.Bytes()...), appended)
+			} else {
+				assert.ErrorIs(t, err, ErrNotSmallPublish)
+				assert.Equal(t, []byte{0xFF}, appended)
+			}
+		})
+	}
+}
+
+func TestEncodePublishSmall_RoundTrip(t *testing.T) {
+	packet := &PublishPacket{
+		FixedHeader: FixedHeader{QoS: QoS1},
+		TopicName:   "devices/42/state",
+		PacketID:    7,
+		Payload:     bytes.Repeat([]byte("x"), 200),
+		Properties: Properties{Properties: []Property{
+			{ID: PropUserProperty, Value: UTF8Pair{Key: "k", Value: "v"}},
+		}},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, EncodePublishSmall(&buf, packet))
+
+	fh, err := ParseFixedHeader(&buf)
+	require.NoError(t, err)
+	pub, err := ParsePublishPacket(&buf, fh)
+	require.NoError(t, err)
+	assert.Equal(t, packet.TopicName, pub.TopicName)
+	assert.Equal(t, packet.PacketID, pub.PacketID)
+	assert.Equal(t, packet.Payload, pub.Payload)
+	require.Len(t, pub.Properties.Properties, 1)
+}
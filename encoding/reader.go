@@ -0,0 +1,157 @@
+package encoding
+
+import (
+	"bufio"
+	"errors"
+	"io"
+
+	"github.com/axmq/ax/pkg/bufpool"
+)
+
+// The From variants below read from a *bufio.Reader using Peek and Discard on its buffer,
+// avoiding the many small Read calls the io.Reader versions make through the interface
+// The io.Reader versions switch to them automatically when handed a *bufio.Reader
+
+// DecodeVariableByteIntegerFrom decodes an MQTT Variable Byte Integer from a buffered reader
+func DecodeVariableByteIntegerFrom(br *bufio.Reader) (uint32, error) {
+	// Only peek at bytes that are already buffered, a short integer at the end of a
+	// packet must not block waiting for bytes that belong to the next one
+	if br.Buffered() >= MaxVariableByteIntegerBytes {
+		data, _ := br.Peek(MaxVariableByteIntegerBytes)
+		value, n, err := DecodeVariableByteIntegerFromBytes(data)
+		if err != nil {
+			return 0, err
+		}
+		_, _ = br.Discard(n)
+		return value, nil
+	}
+
+	var value uint32
+	var multiplier uint32 = 1
+	for i := 0; i < MaxVariableByteIntegerBytes; i++ {
+		encodedByte, err := br.ReadByte()
+		if err != nil {
+			return 0, unexpectedEOF(err)
+		}
+
+		value += uint32(encodedByte&0x7F) * multiplier
+		if (encodedByte & 0x80) == 0 {
+			return value, nil
+		}
+
+		if multiplier > maxMultiplierBeforeOverflow {
+			return 0, ErrMalformedVariableByteInteger
+		}
+		multiplier *= 128
+	}
+
+	return 0, ErrMalformedVariableByteInteger
+}
+
+// ParsePropertiesFrom parses MQTT 5.0 properties from a buffered reader
+// Property blocks that fit in the reader's buffer are parsed in place without copying them out first
+func ParsePropertiesFrom(br *bufio.Reader) (*Properties, error) {
+	return parsePropertiesFrom(br, &defaultPropertyLimits)
+}
+
+func parsePropertiesFrom(br *bufio.Reader, limits *PropertyLimits) (*Properties, error) {
+	propLength, err := DecodeVariableByteIntegerFrom(br)
+	if err != nil {
+		return nil, err
+	}
+
+	if propLength == 0 {
+		return &Properties{Properties: make([]Property, 0, 4)}, nil
+	}
+	if int(propLength) > br.Size() {
+		return parsePropertyList(br, propLength, limits)
+	}
+
+	data, err := br.Peek(int(propLength))
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	props := &Properties{
+		Length:     propLength,
+		Properties: make([]Property, 0, 4),
+	}
+	budget := newUserPropertyBudget(limits)
+	for offset := 0; offset < len(data); {
+		prop, n, err := parsePropertyFromBytes(data[offset:])
+		if err != nil {
+			return nil, err
+		}
+		if err := budget.add(prop); err != nil {
+			return nil, err
+		}
+		props.Properties = append(props.Properties, *prop)
+		offset += n
+	}
+
+	_, _ = br.Discard(int(propLength))
+	return props, nil
+}
+
+func readByteFrom(br *bufio.Reader) (byte, error) {
+	b, err := br.ReadByte()
+	if err != nil {
+		return 0, unexpectedEOF(err)
+	}
+	return b, nil
+}
+
+func readTwoByteIntFrom(br *bufio.Reader) (uint16, error) {
+	data, err := br.Peek(2)
+	if err != nil {
+		return 0, unexpectedEOF(err)
+	}
+	value := uint16(data[0])<<8 | uint16(data[1])
+	_, _ = br.Discard(2)
+	return value, nil
+}
+
+func readUTF8StringFrom(br *bufio.Reader) (string, error) {
+	length, err := readTwoByteIntFrom(br)
+	if err != nil {
+		return "", err
+	}
+
+	if length == 0 {
+		return "", nil
+	}
+
+	// Strings longer than the buffer cannot be peeked, read them the slow way
+	if int(length) > br.Size() {
+		pooled := bufpool.Get(int(length))
+		defer pooled.Release()
+		buf := pooled.B[:length]
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return "", ErrUnexpectedEOF
+		}
+		if err := ValidateUTF8String(buf); err != nil {
+			return "", err
+		}
+		return string(buf), nil
+	}
+
+	data, err := br.Peek(int(length))
+	if err != nil {
+		return "", ErrUnexpectedEOF
+	}
+	if err := ValidateUTF8String(data); err != nil {
+		return "", err
+	}
+
+	str := string(data)
+	_, _ = br.Discard(int(length))
+	return str, nil
+}
+
+// unexpectedEOF maps the end of input to ErrUnexpectedEOF
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrUnexpectedEOF
+	}
+	return err
+}
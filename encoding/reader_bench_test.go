@@ -0,0 +1,101 @@
+package encoding
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+)
+
+// repeatReader serves the same bytes forever, standing in for a connection carrying a stream of packets
+type repeatReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		c := copy(p[n:], r.data[r.pos:])
+		n += c
+		r.pos = (r.pos + c) % len(r.data)
+	}
+	return n, nil
+}
+
+func benchmarkPublishStream(b *testing.B) []byte {
+	packet := &PublishPacket{
+		FixedHeader: FixedHeader{QoS: QoS1},
+		TopicName:   "factory/line-3/sensor-17/temperature",
+		PacketID:    42,
+		Payload:     []byte(`{"ts":1712345678,"value":21.57}`),
+		Properties: Properties{Properties: []Property{
+			{ID: PropPayloadFormatIndicator, Value: byte(1)},
+			{ID: PropContentType, Value: "application/json"},
+		}},
+	}
+	var buf bytes.Buffer
+	if err := packet.Encode(&buf); err != nil {
+		b.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// unbuffered hides the *bufio.Reader so the byte-at-a-time io.Reader path is measured
+type unbuffered struct {
+	r io.Reader
+}
+
+func (u unbuffered) Read(p []byte) (int, error) {
+	return u.r.Read(p)
+}
+
+func BenchmarkParsePublishStream(b *testing.B) {
+	data := benchmarkPublishStream(b)
+
+	run := func(b *testing.B, r io.Reader) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			fh, err := ParseFixedHeader(r)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := ParsePublishPacket(r, fh); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	b.Run("io.Reader", func(b *testing.B) {
+		run(b, unbuffered{bufio.NewReader(&repeatReader{data: data})})
+	})
+
+	b.Run("bufio.Reader", func(b *testing.B) {
+		run(b, bufio.NewReader(&repeatReader{data: data}))
+	})
+}
+
+func BenchmarkDecodeVariableByteIntegerFrom(b *testing.B) {
+	data := []byte{0xFF, 0xFF, 0x7F}
+
+	b.Run("io.Reader", func(b *testing.B) {
+		r := unbuffered{bufio.NewReader(&repeatReader{data: data})}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := DecodeVariableByteInteger(r); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("bufio.Reader", func(b *testing.B) {
+		br := bufio.NewReader(&repeatReader{data: data})
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := DecodeVariableByteIntegerFrom(br); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
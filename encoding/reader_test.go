@@ -0,0 +1,183 @@
+package encoding
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeVariableByteIntegerFrom(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []byte
+		want    uint32
+		wantErr error
+	}{
+		{name: "1 byte", input: []byte{0x7F, 0xAA, 0xAA, 0xAA}, want: 127},
+		{name: "2 byte", input: []byte{0x80, 0x01, 0xAA, 0xAA}, want: 128},
+		{name: "4 byte", input: []byte{0xFF, 0xFF, 0xFF, 0x7F}, want: MaxVariableByteInteger},
+		{name: "short input", input: []byte{0x80, 0x01}, want: 128},
+		{name: "malformed", input: []byte{0xFF, 0xFF, 0xFF, 0xFF, 0x01}, wantErr: ErrMalformedVariableByteInteger},
+		{name: "truncated", input: []byte{0x80}, wantErr: ErrUnexpectedEOF},
+		{name: "empty", input: []byte{}, wantErr: ErrUnexpectedEOF},
+	}
+
+	for _, tt := range tests {
+		readers := map[string]*bufio.Reader{
+			"buffered":   bufio.NewReader(bytes.NewReader(tt.input)),
+			"one byte":   bufio.NewReader(iotest.OneByteReader(bytes.NewReader(tt.input))),
+			"via reader": nil,
+		}
+		for name, br := range readers {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				var got uint32
+				var err error
+				if br == nil {
+					got, err = DecodeVariableByteInteger(bufio.NewReader(bytes.NewReader(tt.input)))
+				} else {
+					got, err = DecodeVariableByteIntegerFrom(br)
+				}
+				if tt.wantErr != nil {
+					assert.ErrorIs(t, err, tt.wantErr)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			})
+		}
+	}
+}
+
+func TestDecodeVariableByteIntegerFrom_DoesNotWaitForMoreInput(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	go func() {
+		_, _ = pw.Write([]byte{0x05})
+	}()
+
+	done := make(chan uint32, 1)
+	go func() {
+		v, err := DecodeVariableByteIntegerFrom(bufio.NewReader(pr))
+		if err == nil {
+			done <- v
+		}
+	}()
+
+	select {
+	case v := <-done:
+		assert.Equal(t, uint32(5), v)
+	case <-time.After(time.Second):
+		t.Fatal("decoding blocked waiting for bytes past the integer")
+	}
+}
+
+func TestParsePropertiesFrom(t *testing.T) {
+	props := &Properties{Properties: []Property{
+		{ID: PropPayloadFormatIndicator, Value: byte(1)},
+		{ID: PropMessageExpiryInterval, Value: uint32(3600)},
+		{ID: PropContentType, Value: "application/json"},
+		{ID: PropCorrelationData, Value: []byte{1, 2, 3}},
+		{ID: PropUserProperty, Value: UTF8Pair{Key: "k", Value: "v"}},
+	}}
+	var encoded bytes.Buffer
+	require.NoError(t, props.EncodeProperties(&encoded))
+
+	want, _, err := ParsePropertiesFromBytes(encoded.Bytes())
+	require.NoError(t, err)
+
+	t.Run("fits in buffer", func(t *testing.T) {
+		br := bufio.NewReader(io.MultiReader(bytes.NewReader(encoded.Bytes()), strings.NewReader("rest")))
+		got, err := ParsePropertiesFrom(br)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+
+		rest, err := io.ReadAll(br)
+		require.NoError(t, err)
+		assert.Equal(t, "rest", string(rest))
+	})
+
+	t.Run("larger than buffer", func(t *testing.T) {
+		got, err := ParsePropertiesFrom(bufio.NewReaderSize(bytes.NewReader(encoded.Bytes()), 16))
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("correlation data is not aliased to the buffer", func(t *testing.T) {
+		br := bufio.NewReader(bytes.NewReader(encoded.Bytes()))
+		got, err := ParseProperties(br)
+		require.NoError(t, err)
+		_, _ = br.Read(make([]byte, 64))
+		assert.Equal(t, []byte{1, 2, 3}, got.Properties[3].Value)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		_, err := ParsePropertiesFrom(bufio.NewReader(bytes.NewReader(encoded.Bytes()[:encoded.Len()-2])))
+		assert.ErrorIs(t, err, ErrUnexpectedEOF)
+	})
+}
+
+func TestReadUTF8StringFrom(t *testing.T) {
+	long := strings.Repeat("x", 100)
+	tests := []struct {
+		name    string
+		input   []byte
+		size    int
+		want    string
+		wantErr error
+	}{
+		{name: "short", input: []byte{0, 3, 'a', 'b', 'c'}, size: 16, want: "abc"},
+		{name: "empty", input: []byte{0, 0}, size: 16, want: ""},
+		{name: "longer than buffer", input: append([]byte{0, 100}, long...), size: 16, want: long},
+		{name: "invalid utf8", input: []byte{0, 2, 0xC3, 0x28}, size: 16, wantErr: ErrInvalidUTF8},
+		{name: "truncated", input: []byte{0, 5, 'a'}, size: 16, wantErr: ErrUnexpectedEOF},
+		{name: "truncated length", input: []byte{0}, size: 16, wantErr: ErrUnexpectedEOF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readUTF8String(bufio.NewReaderSize(bytes.NewReader(tt.input), tt.size))
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParsePublishPacket_BufferedReader(t *testing.T) {
+	packet := &PublishPacket{
+		FixedHeader: FixedHeader{QoS: QoS1},
+		TopicName:   "devices/42/state",
+		PacketID:    9,
+		Payload:     []byte("online"),
+		Properties: Properties{Properties: []Property{
+			{ID: PropContentType, Value: "text/plain"},
+		}},
+	}
+
+	var stream bytes.Buffer
+	require.NoError(t, packet.Encode(&stream))
+	require.NoError(t, packet.Encode(&stream))
+
+	br := bufio.NewReader(iotest.HalfReader(&stream))
+	for i := 0; i < 2; i++ {
+		fh, err := ParseFixedHeader(br)
+		require.NoError(t, err)
+		got, err := ParsePublishPacket(br, fh)
+		require.NoError(t, err)
+		assert.Equal(t, packet.TopicName, got.TopicName)
+		assert.Equal(t, packet.PacketID, got.PacketID)
+		assert.Equal(t, packet.Payload, got.Payload)
+		assert.Equal(t, "text/plain", got.Properties.Properties[0].Value)
+	}
+}
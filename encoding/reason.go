@@ -0,0 +1,146 @@
+package encoding
+
+import (
+	"errors"
+
+	axerrors "github.com/axmq/ax/pkg/errors"
+)
+
+// reasonPackets records for every reason code the packet types allowed to carry it, one bit per packet type (MQTT 5.0 table 2-6)
+var reasonPackets = func() [256]uint16 {
+	var t [256]uint16
+	allow := func(rc ReasonCode, types ...PacketType) {
+		for _, pt := range types {
+			t[rc] |= 1 << pt
+		}
+	}
+
+	allow(ReasonSuccess, CONNACK, PUBACK, PUBREC, PUBREL, PUBCOMP, SUBACK, UNSUBACK, DISCONNECT, AUTH)
+	allow(ReasonGrantedQoS1, SUBACK)
+	allow(ReasonGrantedQoS2, SUBACK)
+	allow(ReasonDisconnectWithWillMessage, DISCONNECT)
+	allow(ReasonNoMatchingSubscribers, PUBACK, PUBREC)
+	allow(ReasonNoSubscriptionExisted, UNSUBACK)
+	allow(ReasonContinueAuthentication, AUTH)
+	allow(ReasonReAuthenticate, AUTH)
+
+	allow(ReasonUnspecifiedError, CONNACK, PUBACK, PUBREC, SUBACK, UNSUBACK, DISCONNECT)
+	allow(ReasonMalformedPacket, CONNACK, DISCONNECT)
+	allow(ReasonProtocolError, CONNACK, DISCONNECT)
+	allow(ReasonImplementationSpecificError, CONNACK, PUBACK, PUBREC, SUBACK, UNSUBACK, DISCONNECT)
+	allow(ReasonUnsupportedProtocolVersion, CONNACK)
+	allow(ReasonClientIdentifierNotValid, CONNACK)
+	allow(ReasonBadUsernameOrPassword, CONNACK)
+	allow(ReasonNotAuthorized, CONNACK, PUBACK, PUBREC, SUBACK, UNSUBACK, DISCONNECT)
+	allow(ReasonServerUnavailable, CONNACK)
+	allow(ReasonServerBusy, CONNACK, DISCONNECT)
+	allow(ReasonBanned, CONNACK)
+	allow(ReasonServerShuttingDown, DISCONNECT)
+	allow(ReasonBadAuthenticationMethod, CONNACK, DISCONNECT)
+	allow(ReasonKeepAliveTimeout, DISCONNECT)
+	allow(ReasonSessionTakenOver, DISCONNECT)
+	allow(ReasonTopicFilterInvalid, SUBACK, UNSUBACK, DISCONNECT)
+	allow(ReasonTopicNameInvalid, CONNACK, PUBACK, PUBREC, DISCONNECT)
+	allow(ReasonPacketIdentifierInUse, PUBACK, PUBREC, SUBACK, UNSUBACK)
+	allow(ReasonPacketIdentifierNotFound, PUBREL, PUBCOMP)
+	allow(ReasonReceiveMaximumExceeded, DISCONNECT)
+	allow(ReasonTopicAliasInvalid, DISCONNECT)
+	allow(ReasonPacketTooLarge, CONNACK, DISCONNECT)
+	allow(ReasonMessageRateTooHigh, DISCONNECT)
+	allow(ReasonQuotaExceeded, CONNACK, PUBACK, PUBREC, SUBACK, DISCONNECT)
+	allow(ReasonAdministrativeAction, DISCONNECT)
+	allow(ReasonPayloadFormatInvalid, CONNACK, PUBACK, PUBREC, DISCONNECT)
+	allow(ReasonRetainNotSupported, CONNACK, DISCONNECT)
+	allow(ReasonQoSNotSupported, CONNACK, DISCONNECT)
+	allow(ReasonUseAnotherServer, CONNACK, DISCONNECT)
+	allow(ReasonServerMoved, CONNACK, DISCONNECT)
+	allow(ReasonSharedSubscriptionsNotSupported, SUBACK, DISCONNECT)
+	allow(ReasonConnectionRateExceeded, CONNACK, DISCONNECT)
+	allow(ReasonMaximumConnectTime, DISCONNECT)
+	allow(ReasonSubscriptionIdentifiersNotSupported, SUBACK, DISCONNECT)
+	allow(ReasonWildcardSubscriptionsNotSupported, SUBACK, DISCONNECT)
+	return t
+}()
+
+// IsError reports whether the reason code signals a failure, which MQTT 5.0 encodes as 0x80 and above
+func (rc ReasonCode) IsError() bool {
+	return rc >= 0x80
+}
+
+// IsValidFor reports whether the reason code may be sent in a packet of the given type
+func (rc ReasonCode) IsValidFor(packetType PacketType) bool {
+	return packetType <= AUTH && reasonPackets[rc]&(1<<packetType) != 0
+}
+
+// ErrorReason maps an error to the reason code reported for it on the wire
+type ErrorReason struct {
+	Err  error
+	Code ReasonCode
+}
+
+// ErrorReasons is an error to reason code table, entries are checked in order with errors.Is
+type ErrorReasons []ErrorReason
+
+// FromError returns the reason code to send for err
+// A PacketError carries its own code, then the table is consulted and remaining errors are mapped by the
+// package level FromError
+func (t ErrorReasons) FromError(err error) ReasonCode {
+	if err == nil {
+		return ReasonSuccess
+	}
+
+	var pktErr *PacketError
+	if errors.As(err, &pktErr) {
+		return pktErr.ReasonCode
+	}
+	for _, m := range t {
+		if errors.Is(err, m.Err) {
+			return m.Code
+		}
+	}
+	return FromError(err)
+}
+
+// FromError returns the reason code to send for err
+// A nil error is ReasonSuccess, a PacketError carries its own code, ErrNotAuthorized and ErrQuotaExceeded
+// come next and the packet errors of this package are mapped as GetReasonCode does
+// Remaining errors fall back to the reason code of their kind, see ReasonForKind. Errors of other packages
+// are mapped with an ErrorReasons table
+func FromError(err error) ReasonCode {
+	if err == nil {
+		return ReasonSuccess
+	}
+
+	var pktErr *PacketError
+	if errors.As(err, &pktErr) {
+		return pktErr.ReasonCode
+	}
+
+	switch {
+	case errors.Is(err, ErrNotAuthorized):
+		return ReasonNotAuthorized
+	case errors.Is(err, ErrQuotaExceeded):
+		return ReasonQuotaExceeded
+	}
+
+	if code := GetReasonCode(err); code != ReasonUnspecifiedError {
+		return code
+	}
+	return ReasonForKind(axerrors.KindOf(err))
+}
+
+// ReasonForKind returns the reason code reported for errors of a kind without a more specific code
+func ReasonForKind(kind axerrors.Kind) ReasonCode {
+	switch kind {
+	case axerrors.KindProtocol:
+		return ReasonProtocolError
+	case axerrors.KindAuth:
+		return ReasonNotAuthorized
+	case axerrors.KindQuota:
+		return ReasonQuotaExceeded
+	case axerrors.KindStorage:
+		return ReasonImplementationSpecificError
+	default:
+		return ReasonUnspecifiedError
+	}
+}
@@ -0,0 +1,94 @@
+package encoding
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	axerrors "github.com/axmq/ax/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReasonCode_IsError(t *testing.T) {
+	assert.False(t, ReasonSuccess.IsError())
+	assert.False(t, ReasonGrantedQoS2.IsError())
+	assert.False(t, ReasonReAuthenticate.IsError())
+	assert.True(t, ReasonUnspecifiedError.IsError())
+	assert.True(t, ReasonWildcardSubscriptionsNotSupported.IsError())
+}
+
+func TestReasonCode_IsValidFor(t *testing.T) {
+	tests := []struct {
+		code       ReasonCode
+		packetType PacketType
+		want       bool
+	}{
+		{ReasonSuccess, CONNACK, true},
+		{ReasonSuccess, PUBREL, true},
+		{ReasonSuccess, PUBLISH, false},
+		{ReasonGrantedQoS1, SUBACK, true},
+		{ReasonGrantedQoS1, PUBACK, false},
+		{ReasonNoMatchingSubscribers, PUBACK, true},
+		{ReasonNoMatchingSubscribers, PUBCOMP, false},
+		{ReasonNoSubscriptionExisted, UNSUBACK, true},
+		{ReasonContinueAuthentication, AUTH, true},
+		{ReasonContinueAuthentication, CONNACK, false},
+		{ReasonNotAuthorized, PUBACK, true},
+		{ReasonNotAuthorized, PUBREL, false},
+		{ReasonBanned, CONNACK, true},
+		{ReasonBanned, DISCONNECT, false},
+		{ReasonServerShuttingDown, DISCONNECT, true},
+		{ReasonServerShuttingDown, CONNACK, false},
+		{ReasonPacketIdentifierNotFound, PUBCOMP, true},
+		{ReasonPacketIdentifierNotFound, PUBACK, false},
+		{ReasonQuotaExceeded, SUBACK, true},
+		{ReasonQuotaExceeded, UNSUBACK, false},
+		{ReasonMessageRateTooHigh, DISCONNECT, true},
+		{ReasonCode(0xFF), DISCONNECT, false},
+		{ReasonSuccess, PacketType(16), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%s", tt.code, tt.packetType), func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.code.IsValidFor(tt.packetType))
+			if tt.packetType != PUBLISH && tt.packetType <= AUTH {
+				assert.Equal(t, tt.want, ValidateReasonCodeForPacket(tt.packetType, tt.code) == nil)
+			}
+		})
+	}
+}
+
+func TestFromError(t *testing.T) {
+	errCustom := errors.New("custom")
+	table := ErrorReasons{
+		{Err: errCustom, Code: ReasonServerUnavailable},
+		{Err: errCustom, Code: ReasonServerBusy},
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want ReasonCode
+	}{
+		{name: "nil", err: nil, want: ReasonSuccess},
+		{name: "packet error", err: NewProtocolError(ErrMalformedPacket, "x"), want: ReasonProtocolError},
+		{name: "not authorized", err: fmt.Errorf("acl: %w", ErrNotAuthorized), want: ReasonNotAuthorized},
+		{name: "quota", err: ErrQuotaExceeded, want: ReasonQuotaExceeded},
+		{name: "table, first entry wins", err: fmt.Errorf("wrapped: %w", errCustom), want: ReasonServerUnavailable},
+		{name: "codec error", err: ErrInvalidTopicFilter, want: ReasonTopicFilterInvalid},
+		{name: "unknown", err: errors.New("boom"), want: ReasonUnspecifiedError},
+		{name: "protocol kind", err: ErrInvalidUTF8, want: ReasonProtocolError},
+		{name: "storage kind", err: fmt.Errorf("save: %w", axerrors.Wrap(axerrors.KindStorage, errors.New("disk full"))), want: ReasonImplementationSpecificError},
+		{name: "internal kind", err: axerrors.New(axerrors.KindInternal, "bug"), want: ReasonUnspecifiedError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, table.FromError(tt.err))
+			if tt.err != nil && !errors.Is(tt.err, errCustom) {
+				assert.Equal(t, tt.want, FromError(tt.err))
+			}
+		})
+	}
+	assert.Equal(t, ReasonUnspecifiedError, FromError(errCustom))
+}
@@ -0,0 +1,101 @@
+package encoding
+
+// ResponseLimits holds the client CONNECT settings that restrict the properties of acknowledgements sent to it
+type ResponseLimits struct {
+	// MaximumPacketSize is the largest packet the client accepts, zero means no limit
+	MaximumPacketSize uint32
+	// RequestProblemInfo is false when the client asked not to receive Reason Strings and User Properties on acks
+	RequestProblemInfo bool
+}
+
+// DefaultResponseLimits returns the limits of a client that sent neither property
+func DefaultResponseLimits() ResponseLimits {
+	return ResponseLimits{RequestProblemInfo: true}
+}
+
+// ResponseLimitsFromConnect reads the limits from the properties of a CONNECT packet
+func ResponseLimitsFromConnect(props *Properties) ResponseLimits {
+	limits := DefaultResponseLimits()
+	if props == nil {
+		return limits
+	}
+	if prop := props.GetProperty(PropMaximumPacketSize); prop != nil {
+		limits.MaximumPacketSize, _ = prop.Value.(uint32)
+	}
+	if prop := props.GetProperty(PropRequestProblemInformation); prop != nil {
+		if v, ok := prop.Value.(byte); ok {
+			limits.RequestProblemInfo = v != 0
+		}
+	}
+	return limits
+}
+
+// ackProperties returns the property block of the acknowledgements whose problem information may be trimmed
+func ackProperties(p Packet) *Properties {
+	switch ack := p.(type) {
+	case *PubackPacket:
+		return &ack.Properties
+	case *PubrecPacket:
+		return &ack.Properties
+	case *PubrelPacket:
+		return &ack.Properties
+	case *PubcompPacket:
+		return &ack.Properties
+	case *SubackPacket:
+		return &ack.Properties
+	case *UnsubackPacket:
+		return &ack.Properties
+	default:
+		return nil
+	}
+}
+
+// FitResponse removes the Reason String and User Properties of an acknowledgement that the client must not receive
+// They are all removed when the client disabled problem information, otherwise User Properties are dropped from the
+// last one and then the Reason String until the packet fits the client Maximum Packet Size. ErrResponseTooLarge is
+// returned when the packet exceeds the limit without them, the packet must not be sent then
+func FitResponse(p Packet, limits ResponseLimits) error {
+	props := ackProperties(p)
+	if props == nil || len(props.Properties) == 0 {
+		return checkResponseSize(p, limits)
+	}
+
+	// Work on a copy so a property block shared with other packets is never modified
+	kept := make([]Property, 0, len(props.Properties))
+	for _, prop := range props.Properties {
+		if !limits.RequestProblemInfo && isProblemInfo(prop.ID) {
+			continue
+		}
+		kept = append(kept, prop)
+	}
+	props.Properties = kept
+
+	for limits.MaximumPacketSize > 0 && uint32(p.Size()) > limits.MaximumPacketSize {
+		if !props.removeLast(PropUserProperty) && !props.removeLast(PropReasonString) {
+			return ErrResponseTooLarge
+		}
+	}
+	return nil
+}
+
+func checkResponseSize(p Packet, limits ResponseLimits) error {
+	if limits.MaximumPacketSize > 0 && uint32(p.Size()) > limits.MaximumPacketSize {
+		return ErrResponseTooLarge
+	}
+	return nil
+}
+
+func isProblemInfo(id PropertyID) bool {
+	return id == PropReasonString || id == PropUserProperty
+}
+
+// removeLast removes the last property with the given ID and reports whether there was one
+func (p *Properties) removeLast(id PropertyID) bool {
+	for i := len(p.Properties) - 1; i >= 0; i-- {
+		if p.Properties[i].ID == id {
+			p.Properties = append(p.Properties[:i], p.Properties[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package encoding
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func problemProperties(t *testing.T) Properties {
+	props, err := NewPropertyBuilder().
+		WithReasonString("subscription refused").
+		WithUserProperty("a", "1").
+		WithUserProperty("b", "2").
+		Build()
+	require.NoError(t, err)
+	return *props
+}
+
+func TestResponseLimitsFromConnect(t *testing.T) {
+	assert.Equal(t, DefaultResponseLimits(), ResponseLimitsFromConnect(nil))
+
+	props, err := NewPropertyBuilder().WithMaximumPacketSize(128).WithRequestProblemInfo(0).Build()
+	require.NoError(t, err)
+	assert.Equal(t, ResponseLimits{MaximumPacketSize: 128}, ResponseLimitsFromConnect(props))
+}
+
+func TestFitResponseRequestProblemInfo(t *testing.T) {
+	shared := problemProperties(t)
+	packet := &SubackPacket{PacketID: 1, ReasonCodes: []ReasonCode{ReasonNotAuthorized}, Properties: shared}
+
+	require.NoError(t, FitResponse(packet, ResponseLimits{}))
+	assert.Empty(t, packet.Properties.Properties)
+	assert.Len(t, shared.Properties, 3)
+}
+
+func TestFitResponseMaximumPacketSize(t *testing.T) {
+	full := &UnsubackPacket{PacketID: 1, ReasonCodes: []ReasonCode{ReasonSuccess}, Properties: problemProperties(t)}
+	fullSize := full.Size()
+
+	// Room for everything but the last user property
+	packet := &UnsubackPacket{PacketID: 1, ReasonCodes: []ReasonCode{ReasonSuccess}, Properties: problemProperties(t)}
+	require.NoError(t, FitResponse(packet, ResponseLimits{MaximumPacketSize: uint32(fullSize - 1), RequestProblemInfo: true}))
+	require.Len(t, packet.Properties.Properties, 2)
+	assert.Equal(t, "a", packet.Properties.GetProperty(PropUserProperty).Value.(UTF8Pair).Key)
+
+	packet = &UnsubackPacket{PacketID: 1, ReasonCodes: []ReasonCode{ReasonSuccess}, Properties: problemProperties(t)}
+	require.NoError(t, FitResponse(packet, ResponseLimits{MaximumPacketSize: 6, RequestProblemInfo: true}))
+	assert.Empty(t, packet.Properties.Properties)
+	assert.Equal(t, 6, packet.Size())
+
+	packet = &UnsubackPacket{PacketID: 1, ReasonCodes: []ReasonCode{ReasonSuccess}, Properties: problemProperties(t)}
+	assert.ErrorIs(t, FitResponse(packet, ResponseLimits{MaximumPacketSize: 5, RequestProblemInfo: true}), ErrResponseTooLarge)
+}
+
+func TestFitResponseKeepsOtherProperties(t *testing.T) {
+	puback := &PubackPacket{PacketID: 1, ReasonCode: ReasonNotAuthorized, Properties: problemProperties(t)}
+	require.NoError(t, FitResponse(puback, DefaultResponseLimits()))
+	assert.Len(t, puback.Properties.Properties, 3)
+
+	publish := &PublishPacket{TopicName: "a/b", Properties: problemProperties(t)}
+	require.NoError(t, FitResponse(publish, ResponseLimits{}))
+	assert.Len(t, publish.Properties.Properties, 3)
+}
@@ -0,0 +1,161 @@
+package encoding
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+)
+
+// PayloadSink holds a PUBLISH payload outside memory
+// It is filled once with ReadFrom, read back any number of times with Open and released with Close
+type PayloadSink interface {
+	io.ReaderFrom
+
+	// Size returns the number of payload bytes stored
+	Size() int64
+
+	// Open returns a reader over the stored payload, readers are independent of each other
+	Open() (io.ReadCloser, error)
+
+	// Close releases the storage, open readers may fail afterwards
+	Close() error
+}
+
+// PayloadSpool spools PUBLISH payloads larger than Threshold instead of reading them into a []byte,
+// so a few firmware-size publishes cannot exhaust memory
+type PayloadSpool struct {
+	// Threshold is the payload size in bytes above which payloads are spooled
+	Threshold int64
+	// Dir holds the temporary files of the default sink, empty uses os.TempDir
+	Dir string
+	// NewSink creates the sink for a payload of size bytes, nil spools to temporary files in Dir
+	NewSink func(size int64) (PayloadSink, error)
+}
+
+// NewFileSpool returns a spool writing payloads above threshold to temporary files in dir
+func NewFileSpool(dir string, threshold int64) *PayloadSpool {
+	return &PayloadSpool{Threshold: threshold, Dir: dir}
+}
+
+func (s *PayloadSpool) sink(size int64) (PayloadSink, error) {
+	if s.NewSink != nil {
+		return s.NewSink(size)
+	}
+	f, err := os.CreateTemp(s.Dir, "ax-payload-*")
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{file: f}, nil
+}
+
+// spool copies size payload bytes from r into a new sink
+func (s *PayloadSpool) spool(r io.Reader, size int64) (PayloadSink, error) {
+	sink, err := s.sink(size)
+	if err != nil {
+		return nil, err
+	}
+	n, err := sink.ReadFrom(io.LimitReader(r, size))
+	if err == nil && n < size {
+		err = ErrUnexpectedEOF
+	}
+	if err != nil {
+		_ = sink.Close()
+		return nil, err
+	}
+	return sink, nil
+}
+
+// fileSink stores a payload in a temporary file that is removed on Close
+type fileSink struct {
+	file *os.File
+	size int64
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func (s *fileSink) ReadFrom(r io.Reader) (int64, error) {
+	n, err := io.Copy(s.file, r)
+	s.size += n
+	return n, err
+}
+
+func (s *fileSink) Size() int64 {
+	return s.size
+}
+
+func (s *fileSink) Open() (io.ReadCloser, error) {
+	return io.NopCloser(io.NewSectionReader(s.file, 0, s.size)), nil
+}
+
+func (s *fileSink) Close() error {
+	s.closeOnce.Do(func() {
+		s.closeErr = s.file.Close()
+		if err := os.Remove(s.file.Name()); err != nil && s.closeErr == nil {
+			s.closeErr = err
+		}
+	})
+	return s.closeErr
+}
+
+// ParsePublishPacketSpooled parses an MQTT 5.0 PUBLISH packet, spooling payloads larger than the spool
+// threshold into PublishPacket.Spool instead of Payload. A nil spool reads every payload into memory
+func ParsePublishPacketSpooled(r io.Reader, fh *FixedHeader, spool *PayloadSpool) (*PublishPacket, error) {
+	return parsePublishPacket(r, fh, spool)
+}
+
+// ParsePacketBodySpooled is ParsePacketBody for MQTT 5.0 with large PUBLISH payloads spooled, see
+// ParsePublishPacketSpooled
+func ParsePacketBodySpooled(r io.Reader, fh *FixedHeader, spool *PayloadSpool) (Packet, error) {
+	if fh.Type == PUBLISH {
+		return parsePublishPacket(r, fh, spool)
+	}
+	return ParsePacketBody(r, fh, ProtocolVersion50)
+}
+
+// PayloadSize returns the payload length whether it is held in memory or spooled
+func (p *PublishPacket) PayloadSize() int64 {
+	if p.Spool != nil {
+		return p.Spool.Size()
+	}
+	return int64(len(p.Payload))
+}
+
+// OpenPayload returns a reader over the payload whether it is held in memory or spooled
+func (p *PublishPacket) OpenPayload() (io.ReadCloser, error) {
+	if p.Spool != nil {
+		return p.Spool.Open()
+	}
+	return io.NopCloser(bytes.NewReader(p.Payload)), nil
+}
+
+// ReleasePayload releases the storage of a spooled payload, call it once the last delivery was written
+func (p *PublishPacket) ReleasePayload() error {
+	if p.Spool == nil {
+		return nil
+	}
+	return p.Spool.Close()
+}
+
+// writePayload streams the payload to w
+func (p *PublishPacket) writePayload(w io.Writer) error {
+	if p.Spool == nil {
+		if len(p.Payload) == 0 {
+			return nil
+		}
+		_, err := w.Write(p.Payload)
+		return err
+	}
+
+	rc, err := p.Spool.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	n, err := io.Copy(w, rc)
+	if err == nil && n != p.Spool.Size() {
+		err = ErrUnexpectedEOF
+	}
+	return err
+}
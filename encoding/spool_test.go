@@ -0,0 +1,137 @@
+package encoding
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encodeTestPublish(t *testing.T, payload []byte) []byte {
+	pkt := &PublishPacket{
+		FixedHeader: FixedHeader{Type: PUBLISH, QoS: QoS1},
+		TopicName:   "firmware/v2",
+		PacketID:    7,
+		Payload:     payload,
+	}
+	var buf bytes.Buffer
+	require.NoError(t, pkt.Encode(&buf))
+	return buf.Bytes()
+}
+
+func parseSpooled(t *testing.T, data []byte, spool *PayloadSpool) (*PublishPacket, error) {
+	r := bytes.NewReader(data)
+	fh, err := ParseFixedHeader(r)
+	require.NoError(t, err)
+	pkt, err := ParsePacketBodySpooled(r, fh, spool)
+	if err != nil {
+		return nil, err
+	}
+	return pkt.(*PublishPacket), nil
+}
+
+func TestParsePublishPacketSpooled_File(t *testing.T) {
+	dir := t.TempDir()
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	data := encodeTestPublish(t, payload)
+
+	pkt, err := parseSpooled(t, data, NewFileSpool(dir, 4096))
+	require.NoError(t, err)
+	require.NotNil(t, pkt.Spool)
+	assert.Empty(t, pkt.Payload)
+	assert.Equal(t, int64(len(payload)), pkt.PayloadSize())
+	assert.Equal(t, "firmware/v2", pkt.TopicName)
+	assert.False(t, pkt.IsSmall())
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	// Delivery streams the payload back out, more than once
+	for i := 0; i < 2; i++ {
+		var out bytes.Buffer
+		require.NoError(t, pkt.Encode(&out))
+		assert.Equal(t, data, out.Bytes())
+	}
+	assert.Equal(t, len(data), pkt.Size())
+
+	buf := make([]byte, len(data))
+	n, err := pkt.EncodeTo(buf)
+	require.NoError(t, err)
+	assert.Equal(t, data, buf[:n])
+	_, err = pkt.EncodeTo(make([]byte, len(data)-1))
+	assert.ErrorIs(t, err, ErrBufferTooSmall)
+
+	rc, err := pkt.OpenPayload()
+	require.NoError(t, err)
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, payload, got)
+
+	require.NoError(t, pkt.ReleasePayload())
+	require.NoError(t, pkt.ReleasePayload())
+	files, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestParsePublishPacketSpooled_BelowThreshold(t *testing.T) {
+	data := encodeTestPublish(t, []byte("small"))
+
+	pkt, err := parseSpooled(t, data, NewFileSpool(t.TempDir(), 1024))
+	require.NoError(t, err)
+	assert.Nil(t, pkt.Spool)
+	assert.Equal(t, []byte("small"), pkt.Payload)
+	assert.NoError(t, pkt.ReleasePayload())
+}
+
+type memorySink struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (s *memorySink) Size() int64 {
+	return int64(s.Len())
+}
+
+func (s *memorySink) Open() (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(s.Bytes())), nil
+}
+
+func (s *memorySink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestParsePublishPacketSpooled_CustomSink(t *testing.T) {
+	payload := bytes.Repeat([]byte{0xAB}, 2048)
+	data := encodeTestPublish(t, payload)
+
+	var sink *memorySink
+	spool := &PayloadSpool{Threshold: 1024, NewSink: func(size int64) (PayloadSink, error) {
+		assert.Equal(t, int64(len(payload)), size)
+		sink = &memorySink{}
+		return sink, nil
+	}}
+
+	pkt, err := parseSpooled(t, data, spool)
+	require.NoError(t, err)
+	assert.Same(t, sink, pkt.Spool)
+	assert.Equal(t, payload, sink.Bytes())
+}
+
+func TestParsePublishPacketSpooled_Truncated(t *testing.T) {
+	dir := t.TempDir()
+	data := encodeTestPublish(t, bytes.Repeat([]byte{1}, 8192))
+
+	_, err := parseSpooled(t, data[:len(data)-100], NewFileSpool(dir, 1024))
+	assert.ErrorIs(t, err, ErrUnexpectedEOF)
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files, "partial spool file must be removed")
+}
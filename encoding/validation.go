@@ -32,6 +32,20 @@ func ValidateTopicName(topic string) error {
 	return nil
 }
 
+// ValidateRetainTopicName validates a topic name before it is written to the retained store
+// In addition to ValidateTopicName it rejects names using the shared subscription prefix
+func ValidateRetainTopicName(topic string) error {
+	if err := ValidateTopicName(topic); err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(topic, "$share/") {
+		return ErrSharedPublishTopicName
+	}
+
+	return nil
+}
+
 // ValidateTopicFilter validates an MQTT topic filter (used in SUBSCRIBE/UNSUBSCRIBE)
 func ValidateTopicFilter(filter string) error {
 	if filter == "" {
@@ -166,26 +180,13 @@ func ValidateRemainingLength(length uint32) error {
 
 // ValidateReasonCodeForPacket validates that a reason code is appropriate for a packet type
 func ValidateReasonCodeForPacket(packetType PacketType, reasonCode ReasonCode) error {
-	// Define valid reason codes for each packet type
-	// This is a simplified validation - full spec has more detailed rules
 	switch packetType {
-	case CONNACK:
-		// CONNACK can use various success and error codes
-		return nil
-	case PUBACK, PUBREC, PUBREL, PUBCOMP:
-		// QoS acknowledgment packets
-		return nil
-	case SUBACK:
-		// SUBACK can use QoS grant codes and error codes
-		return nil
-	case UNSUBACK:
-		// UNSUBACK uses specific reason codes
-		return nil
-	case DISCONNECT, AUTH:
-		// DISCONNECT and AUTH have their own reason codes
-		return nil
+	case CONNACK, PUBACK, PUBREC, PUBREL, PUBCOMP, SUBACK, UNSUBACK, DISCONNECT, AUTH:
+		if !reasonCode.IsValidFor(packetType) {
+			return ErrInvalidReasonCode
+		}
 	default:
-		// Other packets don't typically have reason codes
+		// Other packets don't carry reason codes
 		if reasonCode != 0 {
 			return ErrInvalidReasonCode
 		}
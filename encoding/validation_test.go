@@ -116,6 +116,34 @@ func TestValidateTopicName(t *testing.T) {
 	}
 }
 
+func TestValidateRetainTopicName(t *testing.T) {
+	tests := []struct {
+		name        string
+		topic       string
+		expectedErr error
+	}{
+		{name: "Valid topic", topic: "home/temperature"},
+		{name: "Valid system topic", topic: "$SYS/broker/uptime"},
+		{name: "Share-like level inside topic", topic: "a/$share/b"},
+		{name: "Empty topic", topic: "", expectedErr: ErrInvalidTopicName},
+		{name: "Single-level wildcard", topic: "home/+/temperature", expectedErr: ErrInvalidPublishTopicName},
+		{name: "Multi-level wildcard", topic: "home/#", expectedErr: ErrInvalidPublishTopicName},
+		{name: "Shared subscription prefix", topic: "$share/group/home", expectedErr: ErrSharedPublishTopicName},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateRetainTopicName(tt.topic)
+			if tt.expectedErr == nil {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, tt.expectedErr)
+			assert.Equal(t, ReasonTopicNameInvalid, GetReasonCode(err))
+		})
+	}
+}
+
 func TestValidateTopicFilter(t *testing.T) {
 	tests := []struct {
 		name        string
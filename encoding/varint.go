@@ -109,6 +109,10 @@ func EncodeVariableByteIntegerTo(buf []byte, offset int, value uint32) (int, err
 // - Each byte encodes 7 bits of data
 // - Bit 7 is the continuation bit (1 = more bytes follow, 0 = last byte)
 func DecodeVariableByteInteger(r io.Reader) (uint32, error) {
+	if br, ok := bufferedReader(r); ok {
+		return DecodeVariableByteIntegerFrom(br)
+	}
+
 	var value uint32
 	var multiplier uint32 = 1
 	var buf [1]byte // Stack-allocated for zero heap allocation
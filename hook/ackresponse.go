@@ -0,0 +1,171 @@
+package hook
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/axmq/ax/encoding"
+)
+
+// AckResponse describes a PUBACK, PUBREC, SUBACK or UNSUBACK about to be sent
+// PUBACK and PUBREC carry a single reason code, SUBACK and UNSUBACK one per topic filter of the request
+type AckResponse struct {
+	PacketType     encoding.PacketType
+	PacketID       uint16
+	ReasonCodes    []encoding.ReasonCode
+	ReasonString   string
+	UserProperties []encoding.UTF8Pair
+}
+
+// AddUserProperty appends a user property to the response
+func (a *AckResponse) AddUserProperty(key, value string) {
+	a.UserProperties = append(a.UserProperties, encoding.UTF8Pair{Key: key, Value: value})
+}
+
+// Failed reports whether any reason code of the response is an error
+func (a *AckResponse) Failed() bool {
+	for _, code := range a.ReasonCodes {
+		if code.IsError() {
+			return true
+		}
+	}
+	return false
+}
+
+// Packet builds the MQTT 5 acknowledgement and trims its properties to what the client accepts
+// encoding.ErrResponseTooLarge means the acknowledgement exceeds the client Maximum Packet Size and must not be sent
+func (a *AckResponse) Packet(limits encoding.ResponseLimits) (encoding.Packet, error) {
+	var props encoding.Properties
+	if a.ReasonString != "" {
+		props.Properties = append(props.Properties, encoding.Property{ID: encoding.PropReasonString, Value: a.ReasonString})
+	}
+	for _, pair := range a.UserProperties {
+		props.Properties = append(props.Properties, encoding.Property{ID: encoding.PropUserProperty, Value: pair})
+	}
+
+	var packet encoding.Packet
+	switch a.PacketType {
+	case encoding.PUBACK:
+		packet = &encoding.PubackPacket{PacketID: a.PacketID, ReasonCode: a.reasonCode(), Properties: props}
+	case encoding.PUBREC:
+		packet = &encoding.PubrecPacket{PacketID: a.PacketID, ReasonCode: a.reasonCode(), Properties: props}
+	case encoding.SUBACK:
+		packet = &encoding.SubackPacket{PacketID: a.PacketID, ReasonCodes: a.ReasonCodes, Properties: props}
+	case encoding.UNSUBACK:
+		packet = &encoding.UnsubackPacket{PacketID: a.PacketID, ReasonCodes: a.ReasonCodes, Properties: props}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAckType, a.PacketType)
+	}
+
+	if err := encoding.FitResponse(packet, limits); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+// reasonCode returns the single reason code of a PUBACK or PUBREC
+func (a *AckResponse) reasonCode() encoding.ReasonCode {
+	if len(a.ReasonCodes) > 0 {
+		return a.ReasonCodes[0]
+	}
+	return encoding.ReasonSuccess
+}
+
+// AckReasonHook attaches a human-readable Reason String to failed acknowledgements
+// The text of the first failing reason code with a configured reason is used, a reason set by an earlier hook is kept
+type AckReasonHook struct {
+	*Base
+
+	mu      sync.RWMutex
+	reasons map[encoding.ReasonCode]string
+}
+
+// NewAckReasonHook creates a hook sending the given reason strings
+func NewAckReasonHook(reasons map[encoding.ReasonCode]string) *AckReasonHook {
+	h := &AckReasonHook{Base: &Base{id: "ack-reasons"}}
+	h.SetReasons(reasons)
+	return h
+}
+
+// ID returns the hook identifier
+func (h *AckReasonHook) ID() string {
+	return h.id
+}
+
+// Provides indicates this hook handles acknowledgements
+func (h *AckReasonHook) Provides(event Event) bool {
+	return event == OnAckResponse
+}
+
+// SetReasons replaces the reason strings
+func (h *AckReasonHook) SetReasons(reasons map[encoding.ReasonCode]string) {
+	copied := make(map[encoding.ReasonCode]string, len(reasons))
+	for code, reason := range reasons {
+		copied[code] = reason
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.reasons = copied
+}
+
+// OnAckResponse sets the Reason String of a failed acknowledgement
+func (h *AckReasonHook) OnAckResponse(_ *Client, ack *AckResponse) error {
+	if ack == nil || ack.ReasonString != "" {
+		return nil
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, code := range ack.ReasonCodes {
+		if !code.IsError() {
+			continue
+		}
+		if reason, ok := h.reasons[code]; ok {
+			ack.ReasonString = reason
+			return nil
+		}
+	}
+	return nil
+}
+
+// RejectPublish returns an error for OnPublish hooks that rejects the message with code,
+// the client receives code in its PUBACK or PUBREC and reason as Reason String when it is not empty
+func RejectPublish(code encoding.ReasonCode, reason string) error {
+	return &encoding.PacketError{Err: ErrPublishRejected, ReasonCode: code, Message: reason}
+}
+
+// PublishReasonCode returns the PUBACK or PUBREC reason code for the result of the OnPublish hooks
+// Errors are mapped with reasons, so hooks may return RejectPublish errors or any error of the table, a nil
+// table maps only the codec errors. Codes a PUBACK cannot carry are reported as ReasonUnspecifiedError
+func PublishReasonCode(reasons encoding.ErrorReasons, err error) encoding.ReasonCode {
+	if err == nil {
+		return encoding.ReasonSuccess
+	}
+	code := reasons.FromError(err)
+	if !code.IsError() || !code.IsValidFor(encoding.PUBACK) {
+		return encoding.ReasonUnspecifiedError
+	}
+	return code
+}
+
+// PublishAck builds the PUBACK, or the PUBREC for QoS 2, answering a publish the OnPublish hooks returned err for
+// Only the reason of a RejectPublish error is sent as Reason String, other error texts stay on the server
+func PublishAck(reasons encoding.ErrorReasons, qos byte, packetID uint16, err error) *AckResponse {
+	ack := &AckResponse{
+		PacketType:  encoding.PUBACK,
+		PacketID:    packetID,
+		ReasonCodes: []encoding.ReasonCode{PublishReasonCode(reasons, err)},
+	}
+	if qos == 2 {
+		ack.PacketType = encoding.PUBREC
+	}
+
+	var pktErr *encoding.PacketError
+	if errors.As(err, &pktErr) && errors.Is(pktErr.Err, ErrPublishRejected) {
+		ack.ReasonString = pktErr.Message
+	}
+	return ack
+}
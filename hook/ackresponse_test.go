@@ -0,0 +1,174 @@
+package hook
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAckResponsePacket(t *testing.T) {
+	ack := &AckResponse{
+		PacketType:   encoding.SUBACK,
+		PacketID:     7,
+		ReasonCodes:  []encoding.ReasonCode{encoding.ReasonGrantedQoS1, encoding.ReasonNotAuthorized},
+		ReasonString: "tenant quota reached",
+	}
+	ack.AddUserProperty("policy", "tenant-a")
+	assert.True(t, ack.Failed())
+
+	packet, err := ack.Packet(encoding.DefaultResponseLimits())
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, packet.Encode(&buf))
+	parsed, err := encoding.ParsePacket(&buf, encoding.ProtocolVersion50)
+	require.NoError(t, err)
+
+	suback := parsed.(*encoding.SubackPacket)
+	assert.Equal(t, uint16(7), suback.PacketID)
+	assert.Equal(t, ack.ReasonCodes, suback.ReasonCodes)
+	assert.Equal(t, "tenant quota reached", suback.Properties.GetProperty(encoding.PropReasonString).Value)
+	assert.Len(t, suback.Properties.GetProperties(encoding.PropUserProperty), 1)
+}
+
+func TestAckResponsePacketRespectsClientLimits(t *testing.T) {
+	ack := &AckResponse{
+		PacketType:   encoding.PUBACK,
+		PacketID:     1,
+		ReasonCodes:  []encoding.ReasonCode{encoding.ReasonNotAuthorized},
+		ReasonString: "denied",
+	}
+
+	client := &Client{Properties: Properties{encoding.PropRequestProblemInformation.String(): byte(0)}}
+	packet, err := ack.Packet(client.ResponseLimits())
+	require.NoError(t, err)
+	puback := packet.(*encoding.PubackPacket)
+	assert.Empty(t, puback.Properties.Properties)
+	assert.Equal(t, encoding.ReasonNotAuthorized, puback.ReasonCode)
+
+	client = &Client{Properties: Properties{encoding.PropMaximumPacketSize.String(): uint32(2)}}
+	_, err = ack.Packet(client.ResponseLimits())
+	assert.ErrorIs(t, err, encoding.ErrResponseTooLarge)
+
+	_, err = (&AckResponse{PacketType: encoding.PUBREL}).Packet(encoding.DefaultResponseLimits())
+	assert.ErrorIs(t, err, ErrUnsupportedAckType)
+}
+
+func TestAckReasonHook(t *testing.T) {
+	h := NewAckReasonHook(map[encoding.ReasonCode]string{
+		encoding.ReasonNotAuthorized: "not allowed for this tenant",
+	})
+	assert.True(t, h.Provides(OnAckResponse))
+	assert.False(t, h.Provides(OnPublish))
+
+	ack := &AckResponse{ReasonCodes: []encoding.ReasonCode{encoding.ReasonGrantedQoS0, encoding.ReasonNotAuthorized}}
+	require.NoError(t, h.OnAckResponse(nil, ack))
+	assert.Equal(t, "not allowed for this tenant", ack.ReasonString)
+
+	ack = &AckResponse{ReasonCodes: []encoding.ReasonCode{encoding.ReasonNotAuthorized}, ReasonString: "custom"}
+	require.NoError(t, h.OnAckResponse(nil, ack))
+	assert.Equal(t, "custom", ack.ReasonString)
+
+	ack = &AckResponse{ReasonCodes: []encoding.ReasonCode{encoding.ReasonSuccess}}
+	require.NoError(t, h.OnAckResponse(nil, ack))
+	assert.Empty(t, ack.ReasonString)
+}
+
+func TestAckReasonHookFromRegistry(t *testing.T) {
+	r := NewRegistry()
+	require.NoError(t, RegisterBuiltins(r))
+
+	h, err := r.Create("ack-reasons", []byte(`{"reasons":{"135":"denied by policy"}}`))
+	require.NoError(t, err)
+
+	ack := &AckResponse{ReasonCodes: []encoding.ReasonCode{encoding.ReasonNotAuthorized}}
+	require.NoError(t, h.OnAckResponse(nil, ack))
+	assert.Equal(t, "denied by policy", ack.ReasonString)
+}
+
+// testReasons maps the publish errors of this package like the broker table does
+var testReasons = encoding.ErrorReasons{
+	{Err: ErrPublishNotAuthorized, Code: encoding.ReasonNotAuthorized},
+	{Err: ErrPublishQuotaExceeded, Code: encoding.ReasonQuotaExceeded},
+	{Err: ErrTopicNameInvalid, Code: encoding.ReasonTopicNameInvalid},
+	{Err: ErrPayloadFormatInvalid, Code: encoding.ReasonPayloadFormatInvalid},
+	{Err: ErrRateLimitExceeded, Code: encoding.ReasonQuotaExceeded},
+}
+
+func TestPublishReasonCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want encoding.ReasonCode
+	}{
+		{"accepted", nil, encoding.ReasonSuccess},
+		{"not authorized", ErrPublishNotAuthorized, encoding.ReasonNotAuthorized},
+		{"quota exceeded", fmt.Errorf("tenant a: %w", ErrPublishQuotaExceeded), encoding.ReasonQuotaExceeded},
+		{"topic name invalid", ErrTopicNameInvalid, encoding.ReasonTopicNameInvalid},
+		{"payload format invalid", ErrPayloadFormatInvalid, encoding.ReasonPayloadFormatInvalid},
+		{"rate limit", ErrRateLimitExceeded, encoding.ReasonQuotaExceeded},
+		{"rejected", RejectPublish(encoding.ReasonImplementationSpecificError, "schema v2 required"), encoding.ReasonImplementationSpecificError},
+		{"not allowed in puback", RejectPublish(encoding.ReasonServerBusy, ""), encoding.ReasonUnspecifiedError},
+		{"success code", RejectPublish(encoding.ReasonSuccess, ""), encoding.ReasonUnspecifiedError},
+		{"protocol kind", encoding.ErrMalformedPacket, encoding.ReasonUnspecifiedError},
+		{"plain error", errors.New("boom"), encoding.ReasonUnspecifiedError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, PublishReasonCode(testReasons, tt.err))
+		})
+	}
+
+	assert.Equal(t, encoding.ReasonUnspecifiedError, PublishReasonCode(nil, ErrPublishNotAuthorized), "without a table")
+}
+
+func TestPublishAck(t *testing.T) {
+	m := NewManager()
+	require.NoError(t, m.Add(&publishRejecter{Base: &Base{id: "schema"}}))
+
+	err := m.OnPublish(&Client{ID: "c1"}, &PublishPacket{Topic: "orders/eu", QoS: 1})
+	ack := PublishAck(testReasons, 1, 9, err)
+	assert.Equal(t, encoding.PUBACK, ack.PacketType)
+	assert.Equal(t, []encoding.ReasonCode{encoding.ReasonPayloadFormatInvalid}, ack.ReasonCodes)
+	assert.Equal(t, "payload must be JSON", ack.ReasonString)
+
+	packet, err := ack.Packet(encoding.DefaultResponseLimits())
+	require.NoError(t, err)
+	assert.Equal(t, encoding.ReasonPayloadFormatInvalid, packet.(*encoding.PubackPacket).ReasonCode)
+
+	err = m.OnPublish(&Client{ID: "c1"}, &PublishPacket{Topic: "secret", QoS: 2})
+	ack = PublishAck(testReasons, 2, 10, err)
+	assert.Equal(t, encoding.PUBREC, ack.PacketType)
+	assert.Equal(t, []encoding.ReasonCode{encoding.ReasonNotAuthorized}, ack.ReasonCodes)
+	assert.Empty(t, ack.ReasonString, "error texts other than rejection reasons are not sent")
+
+	packet, err = ack.Packet(encoding.DefaultResponseLimits())
+	require.NoError(t, err)
+	assert.Equal(t, encoding.ReasonNotAuthorized, packet.(*encoding.PubrecPacket).ReasonCode)
+
+	ack = PublishAck(testReasons, 1, 11, m.OnPublish(&Client{ID: "c1"}, &PublishPacket{Topic: "sensors/temp"}))
+	assert.False(t, ack.Failed())
+}
+
+type publishRejecter struct {
+	*Base
+}
+
+func (h *publishRejecter) Provides(event Event) bool {
+	return event == OnPublish
+}
+
+func (h *publishRejecter) OnPublish(_ *Client, packet *PublishPacket) error {
+	switch packet.Topic {
+	case "orders/eu":
+		return RejectPublish(encoding.ReasonPayloadFormatInvalid, "payload must be JSON")
+	case "secret":
+		return fmt.Errorf("acl for %s: %w", packet.Topic, ErrPublishNotAuthorized)
+	}
+	return nil
+}
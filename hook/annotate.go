@@ -0,0 +1,266 @@
+package hook
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/topic"
+)
+
+// User property keys of the broker annotations
+const (
+	AnnotationIngestTime = "ax-ingest-time" // unix milliseconds at which the broker received the message
+	AnnotationOrigin     = "ax-origin"      // client ID of the publisher
+	AnnotationNode       = "ax-node"        // broker node that received the message
+
+	// AnnotationPrefix starts every user property reserved for the broker
+	AnnotationPrefix = "ax-"
+)
+
+// AnnotationField selects the annotations added to a message
+type AnnotationField byte
+
+const (
+	AnnotateIngestTime AnnotationField = 1 << iota
+	AnnotateOrigin
+	AnnotateNode
+
+	AnnotateAll = AnnotateIngestTime | AnnotateOrigin | AnnotateNode
+)
+
+var annotationFieldNames = map[string]AnnotationField{
+	"ingest_time": AnnotateIngestTime,
+	"origin":      AnnotateOrigin,
+	"node":        AnnotateNode,
+}
+
+// AnnotationPolicy opts publishes to topics matching Filter into the selected annotations
+type AnnotationPolicy struct {
+	Filter string
+	Fields AnnotationField
+}
+
+// UnmarshalJSON reads a policy of the form {"filter":"orders/#","fields":["ingest_time","origin"]},
+// omitted fields select every annotation
+func (p *AnnotationPolicy) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Filter string   `json:"filter"`
+		Fields []string `json:"fields"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	p.Filter = raw.Filter
+	p.Fields = 0
+	for _, name := range raw.Fields {
+		field, ok := annotationFieldNames[name]
+		if !ok {
+			return fmt.Errorf("unknown annotation field %q", name)
+		}
+		p.Fields |= field
+	}
+	if p.Fields == 0 {
+		p.Fields = AnnotateAll
+	}
+	return nil
+}
+
+// AnnotationHook stamps delivered messages with broker-added user properties carrying their provenance
+// Only topics matching a policy are annotated so other traffic is not inflated, the first matching policy wins
+// Reserved ax- user properties are stripped from client publishes so provenance cannot be forged, only the
+// annotations of trusted clients, e.g. bridges relaying messages of another broker, are kept
+type AnnotationHook struct {
+	*Base
+	nodeID string
+	now    func() time.Time
+
+	mu       sync.RWMutex
+	policies []AnnotationPolicy
+	trusted  map[string]bool
+}
+
+// NewAnnotationHook creates an annotation hook for the broker node nodeID, every policy filter must be valid
+func NewAnnotationHook(nodeID string, policies ...AnnotationPolicy) (*AnnotationHook, error) {
+	h := &AnnotationHook{Base: &Base{id: "annotations"}, nodeID: nodeID, now: time.Now}
+	if err := h.SetPolicies(policies); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// ID returns the hook identifier
+func (h *AnnotationHook) ID() string {
+	return h.id
+}
+
+// Provides indicates this hook records ingest details on publish and annotates deliveries
+func (h *AnnotationHook) Provides(event Event) bool {
+	return event == OnPublish || event == OnPublishDeliver
+}
+
+// SetPolicies replaces the policies
+func (h *AnnotationHook) SetPolicies(policies []AnnotationPolicy) error {
+	for _, p := range policies {
+		if err := topic.ValidateTopicFilter(p.Filter); err != nil {
+			return fmt.Errorf("invalid annotation policy filter %q: %w", p.Filter, err)
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.policies = append([]AnnotationPolicy(nil), policies...)
+	return nil
+}
+
+// SetTrustedClients replaces the client IDs whose ax- user properties are kept, such as bridge clients
+func (h *AnnotationHook) SetTrustedClients(clientIDs []string) {
+	trusted := make(map[string]bool, len(clientIDs))
+	for _, id := range clientIDs {
+		trusted[id] = true
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.trusted = trusted
+}
+
+func (h *AnnotationHook) isTrusted(client *Client) bool {
+	if client == nil {
+		return false
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.trusted[client.ID]
+}
+
+// Fields returns the annotations selected for topicName, zero when no policy matches
+func (h *AnnotationHook) Fields(topicName string) AnnotationField {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, p := range h.policies {
+		if topic.MatchFilter(p.Filter, topicName) {
+			return p.Fields
+		}
+	}
+	return 0
+}
+
+// OnPublish strips reserved user properties sent by untrusted clients and records when and from whom the
+// message arrived unless an earlier stage already did
+func (h *AnnotationHook) OnPublish(client *Client, packet *PublishPacket) error {
+	if packet == nil {
+		return nil
+	}
+	if !h.isTrusted(client) {
+		packet.Properties = withoutReservedProperties(packet.Properties)
+	}
+	if packet.Created.IsZero() {
+		packet.Created = h.now()
+	}
+	if packet.Origin == "" {
+		packet.Origin = client.GetID()
+	}
+	return nil
+}
+
+// OnPublishDeliver returns a copy of the packet carrying the annotations of its topic
+func (h *AnnotationHook) OnPublishDeliver(_ *Client, packet *PublishPacket) *PublishPacket {
+	if packet == nil {
+		return packet
+	}
+	fields := h.Fields(packet.Topic)
+	if fields == 0 {
+		return packet
+	}
+
+	var pairs []encoding.UTF8Pair
+	add := func(field AnnotationField, key, value string) {
+		if fields&field != 0 && value != "" && len(packet.Properties.UserProperty(key)) == 0 {
+			pairs = append(pairs, encoding.UTF8Pair{Key: key, Value: value})
+		}
+	}
+	if !packet.Created.IsZero() {
+		add(AnnotateIngestTime, AnnotationIngestTime, strconv.FormatInt(packet.Created.UnixMilli(), 10))
+	}
+	add(AnnotateOrigin, AnnotationOrigin, packet.Origin)
+	add(AnnotateNode, AnnotationNode, h.nodeID)
+	if len(pairs) == 0 {
+		return packet
+	}
+
+	annotated := *packet
+	annotated.Properties = withUserProperties(packet.Properties, pairs)
+	return &annotated
+}
+
+// withoutReservedProperties returns props without ax- user properties, props itself when it has none
+func withoutReservedProperties(props Properties) Properties {
+	key := encoding.PropUserProperty.String()
+	var kept any
+	switch existing := props[key].(type) {
+	case []encoding.UTF8Pair:
+		if !slices.ContainsFunc(existing, func(pair encoding.UTF8Pair) bool { return isReserved(pair.Key) }) {
+			return props
+		}
+		kept = slices.DeleteFunc(slices.Clone(existing), func(pair encoding.UTF8Pair) bool { return isReserved(pair.Key) })
+	case map[string]string:
+		filtered := make(map[string]string, len(existing))
+		for k, v := range existing {
+			if !isReserved(k) {
+				filtered[k] = v
+			}
+		}
+		if len(filtered) == len(existing) {
+			return props
+		}
+		kept = filtered
+	default:
+		return props
+	}
+
+	result := make(Properties, len(props))
+	for k, v := range props {
+		result[k] = v
+	}
+	result[key] = kept
+	return result
+}
+
+func isReserved(key string) bool {
+	return strings.HasPrefix(key, AnnotationPrefix)
+}
+
+// withUserProperties returns a copy of props with pairs appended to its user properties, props is not modified
+func withUserProperties(props Properties, pairs []encoding.UTF8Pair) Properties {
+	key := encoding.PropUserProperty.String()
+	result := make(Properties, len(props)+1)
+	for k, v := range props {
+		result[k] = v
+	}
+
+	switch existing := props[key].(type) {
+	case []encoding.UTF8Pair:
+		merged := make([]encoding.UTF8Pair, 0, len(existing)+len(pairs))
+		result[key] = append(append(merged, existing...), pairs...)
+	case map[string]string:
+		merged := make(map[string]string, len(existing)+len(pairs))
+		for k, v := range existing {
+			merged[k] = v
+		}
+		for _, pair := range pairs {
+			merged[pair.Key] = pair.Value
+		}
+		result[key] = merged
+	default:
+		result[key] = pairs
+	}
+	return result
+}
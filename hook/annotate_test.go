@@ -0,0 +1,107 @@
+package hook
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnnotationHook_OnPublishDeliver(t *testing.T) {
+	h, err := NewAnnotationHook("node-1",
+		AnnotationPolicy{Filter: "orders/+/audit", Fields: AnnotateOrigin},
+		AnnotationPolicy{Filter: "orders/#", Fields: AnnotateAll},
+	)
+	require.NoError(t, err)
+	assert.True(t, h.Provides(OnPublish))
+	assert.True(t, h.Provides(OnPublishDeliver))
+	assert.False(t, h.Provides(OnPublished))
+
+	ingest := time.UnixMilli(1700000000123)
+	h.now = func() time.Time { return ingest }
+
+	packet := &PublishPacket{Topic: "orders/eu", Properties: Properties{"ContentType": "json"}}
+	require.NoError(t, h.OnPublish(&Client{ID: "shop-1"}, packet))
+	assert.Equal(t, ingest, packet.Created)
+	assert.Equal(t, "shop-1", packet.Origin)
+
+	delivered := h.OnPublishDeliver(&Client{ID: "sub"}, packet)
+	require.NotSame(t, packet, delivered)
+	assert.Equal(t, []string{"1700000000123"}, delivered.Properties.UserProperty(AnnotationIngestTime))
+	assert.Equal(t, []string{"shop-1"}, delivered.Properties.UserProperty(AnnotationOrigin))
+	assert.Equal(t, []string{"node-1"}, delivered.Properties.UserProperty(AnnotationNode))
+	assert.Equal(t, "json", delivered.Properties["ContentType"])
+	assert.Empty(t, packet.Properties.UserProperty(AnnotationOrigin), "shared packet must not be modified")
+
+	audit := &PublishPacket{Topic: "orders/eu/audit", Origin: "shop-2", Created: ingest}
+	delivered = h.OnPublishDeliver(nil, audit)
+	assert.Equal(t, []string{"shop-2"}, delivered.Properties.UserProperty(AnnotationOrigin))
+	assert.Empty(t, delivered.Properties.UserProperty(AnnotationIngestTime))
+
+	other := &PublishPacket{Topic: "telemetry/1", Origin: "dev", Created: ingest}
+	assert.Same(t, other, h.OnPublishDeliver(nil, other))
+}
+
+func TestAnnotationHook_KeepsExistingAnnotations(t *testing.T) {
+	h, err := NewAnnotationHook("node-2", AnnotationPolicy{Filter: "#", Fields: AnnotateNode | AnnotateOrigin})
+	require.NoError(t, err)
+
+	packet := &PublishPacket{
+		Topic:  "bridged/a",
+		Origin: "bridge",
+		Properties: Properties{
+			encoding.PropUserProperty.String(): []encoding.UTF8Pair{{Key: AnnotationNode, Value: "node-1"}, {Key: "k", Value: "v"}},
+		},
+	}
+	delivered := h.OnPublishDeliver(nil, packet)
+	assert.Equal(t, []string{"node-1"}, delivered.Properties.UserProperty(AnnotationNode))
+	assert.Equal(t, []string{"bridge"}, delivered.Properties.UserProperty(AnnotationOrigin))
+	assert.Equal(t, []string{"v"}, delivered.Properties.UserProperty("k"))
+	assert.Len(t, packet.Properties[encoding.PropUserProperty.String()], 2)
+}
+
+func TestAnnotationHook_StripsForgedAnnotations(t *testing.T) {
+	h, err := NewAnnotationHook("node-2", AnnotationPolicy{Filter: "#", Fields: AnnotateAll})
+	require.NoError(t, err)
+	h.SetTrustedClients([]string{"bridge"})
+
+	forged := func() *PublishPacket {
+		return &PublishPacket{Topic: "a", Properties: Properties{
+			encoding.PropUserProperty.String(): []encoding.UTF8Pair{
+				{Key: AnnotationOrigin, Value: "admin"}, {Key: AnnotationNode, Value: "node-9"}, {Key: "k", Value: "v"},
+			},
+		}}
+	}
+
+	packet := forged()
+	original := packet.Properties
+	require.NoError(t, h.OnPublish(&Client{ID: "mallory"}, packet))
+	assert.Empty(t, packet.Properties.UserProperty(AnnotationNode))
+	assert.Equal(t, []string{"v"}, packet.Properties.UserProperty("k"))
+	assert.Equal(t, []string{"node-9"}, original.UserProperty(AnnotationNode), "original properties must not be modified")
+	delivered := h.OnPublishDeliver(nil, packet)
+	assert.Equal(t, []string{"mallory"}, delivered.Properties.UserProperty(AnnotationOrigin))
+	assert.Equal(t, []string{"node-2"}, delivered.Properties.UserProperty(AnnotationNode))
+
+	packet = forged()
+	require.NoError(t, h.OnPublish(&Client{ID: "bridge"}, packet))
+	delivered = h.OnPublishDeliver(nil, packet)
+	assert.Equal(t, []string{"admin"}, delivered.Properties.UserProperty(AnnotationOrigin))
+	assert.Equal(t, []string{"node-9"}, delivered.Properties.UserProperty(AnnotationNode))
+}
+
+func TestAnnotationPolicy_UnmarshalJSON(t *testing.T) {
+	var policies []AnnotationPolicy
+	require.NoError(t, json.Unmarshal([]byte(`[{"filter":"a/#","fields":["ingest_time","node"]},{"filter":"b/#"}]`), &policies))
+	assert.Equal(t, []AnnotationPolicy{
+		{Filter: "a/#", Fields: AnnotateIngestTime | AnnotateNode},
+		{Filter: "b/#", Fields: AnnotateAll},
+	}, policies)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"filter":"a/#","fields":["size"]}`), &AnnotationPolicy{}))
+	_, err := NewAnnotationHook("n", AnnotationPolicy{Filter: "a/#/b"})
+	assert.Error(t, err)
+}
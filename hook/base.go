@@ -1,6 +1,8 @@
 package hook
 
 import (
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/axmq/ax/encoding"
@@ -9,7 +11,9 @@ import (
 // Base provides a default no-op implementation of the Hook interface
 // Users can embed this in their custom hooks and override only the methods they need
 type Base struct {
-	id string
+	id       string
+	filterMu sync.Mutex
+	filters  atomic.Pointer[map[Event]*EventFilter]
 }
 
 // NewHookBase creates a new base hook with the given ID
@@ -27,6 +31,43 @@ func (h *Base) Provides(event Event) bool {
 	return false
 }
 
+// SetFilter scopes the given event to traffic matching the filter
+// A nil filter removes any existing restriction, a malformed one is rejected with ErrInvalidEventFilter
+func (h *Base) SetFilter(event Event, filter *EventFilter) error {
+	if filter != nil {
+		if err := filter.Validate(); err != nil {
+			return err
+		}
+	}
+
+	h.filterMu.Lock()
+	defer h.filterMu.Unlock()
+
+	// Copy-on-write so Filter stays lock-free on the dispatch path
+	filters := make(map[Event]*EventFilter)
+	if old := h.filters.Load(); old != nil {
+		for k, v := range *old {
+			filters[k] = v
+		}
+	}
+	if filter == nil {
+		delete(filters, event)
+	} else {
+		filters[event] = filter
+	}
+	h.filters.Store(&filters)
+	return nil
+}
+
+// Filter returns the filter for the given event
+func (h *Base) Filter(event Event) *EventFilter {
+	filters := h.filters.Load()
+	if filters == nil {
+		return nil
+	}
+	return (*filters)[event]
+}
+
 // Init initializes the hook with the given config
 func (h *Base) Init(config any) error {
 	return nil
@@ -83,7 +124,7 @@ func (h *Base) OnSessionEstablished(client *Client, packet *ConnectPacket) error
 }
 
 // OnDisconnect is called when a client disconnects
-func (h *Base) OnDisconnect(client *Client, err error, expire bool) error {
+func (h *Base) OnDisconnect(client *Client, info *DisconnectInfo) error {
 	return nil
 }
 
@@ -226,3 +267,28 @@ func (h *Base) StoredRetainedMessages() ([]*RetainedMessage, error) {
 func (h *Base) StoredSysInfo() (*SysInfo, error) {
 	return nil, nil
 }
+
+// OnSlowConsumer is called when a slow consumer is detected
+func (h *Base) OnSlowConsumer(client *Client, info *SlowConsumerInfo) error {
+	return nil
+}
+
+// OnSessionTakeover is called when a client ID of an existing session connects again
+func (h *Base) OnSessionTakeover(client *Client, decision *TakeoverDecision) error {
+	return nil
+}
+
+// OnClientRoamed is called when a client took over its session from a different listener or transport
+func (h *Base) OnClientRoamed(client *Client, info *RoamingInfo) error {
+	return nil
+}
+
+// OnPublishDeliver is called before a message is sent to a subscriber
+func (h *Base) OnPublishDeliver(client *Client, packet *PublishPacket) *PublishPacket {
+	return packet
+}
+
+// OnAckResponse is called before an acknowledgement is sent to a client
+func (h *Base) OnAckResponse(client *Client, ack *AckResponse) error {
+	return nil
+}
@@ -175,10 +175,10 @@ func TestHookBaseOnDisconnect(t *testing.T) {
 	h := &Base{id: "test"}
 	client := &Client{ID: "client1"}
 
-	err := h.OnDisconnect(client, nil, false)
+	err := h.OnDisconnect(client, nil)
 	assert.NoError(t, err)
 
-	err = h.OnDisconnect(client, assert.AnError, true)
+	err = h.OnDisconnect(client, &DisconnectInfo{Err: assert.AnError, Expire: true})
 	assert.NoError(t, err)
 }
 
@@ -491,7 +491,7 @@ func TestHookBaseNilInputs(t *testing.T) {
 	err := h.OnConnect(nil, nil)
 	assert.NoError(t, err)
 
-	err = h.OnDisconnect(nil, nil, false)
+	err = h.OnDisconnect(nil, nil)
 	assert.NoError(t, err)
 
 	err = h.OnPublish(nil, nil)
@@ -544,7 +544,7 @@ func TestHookBaseAllMethodsNoOp(t *testing.T) {
 	err = h.OnSessionEstablished(client, packet)
 	assert.NoError(t, err)
 
-	err = h.OnDisconnect(client, nil, false)
+	err = h.OnDisconnect(client, nil)
 	assert.NoError(t, err)
 
 	_ = h.OnAuthPacket(client, nil)
@@ -0,0 +1,196 @@
+package hook
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BreakerState represents the state of a hook circuit breaker
+type BreakerState int32
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+// String returns the string representation of the breaker state
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half_open"
+	default:
+		return "unknown"
+	}
+}
+
+// BreakerConfig configures the per-hook circuit breaker
+type BreakerConfig struct {
+	// FailureThreshold is the number of failures within Window that trips the breaker
+	// A value of 0 disables tripping, panics are still recovered
+	FailureThreshold int
+	// Window is the period over which failures are counted
+	Window time.Duration
+	// Cooldown is how long a tripped hook stays disabled before a trial call is allowed
+	Cooldown time.Duration
+	// CountErrors counts returned errors as failures in addition to panics
+	CountErrors bool
+	// Timeout is how long a call may take, a slower call counts as a failure recorded as ErrHookTimeout
+	// whatever CountErrors says, but still returns its own result. Hooks are not preempted, the timeout keeps
+	// a stalling hook from slowing every later call down by tripping its breaker. Zero disables it
+	Timeout time.Duration
+}
+
+// DefaultBreakerConfig returns the default circuit breaker configuration
+func DefaultBreakerConfig() BreakerConfig {
+	return BreakerConfig{
+		FailureThreshold: 5,
+		Window:           time.Minute,
+		Cooldown:         30 * time.Second,
+	}
+}
+
+// HookHealth is a snapshot of a hook's failure statistics
+type HookHealth struct {
+	ID            string
+	State         BreakerState
+	Panics        uint64
+	Errors        uint64
+	Trips         uint64
+	LastFailure   error
+	LastFailureAt time.Time
+}
+
+type breaker struct {
+	state atomic.Int32
+
+	mu            sync.Mutex
+	failures      int
+	windowStart   time.Time
+	openedAt      time.Time
+	trialInFlight bool
+	panics        uint64
+	errors        uint64
+	trips         uint64
+	lastFailure   error
+	lastFailureAt time.Time
+}
+
+// allow reports whether the hook may be invoked
+func (b *breaker) allow(config BreakerConfig, now time.Time) bool {
+	if BreakerState(b.state.Load()) == BreakerClosed {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch BreakerState(b.state.Load()) {
+	case BreakerOpen:
+		if now.Sub(b.openedAt) < config.Cooldown {
+			return false
+		}
+		b.state.Store(int32(BreakerHalfOpen))
+		b.trialInFlight = true
+		return true
+	case BreakerHalfOpen:
+		if b.trialInFlight {
+			return false
+		}
+		b.trialInFlight = true
+		return true
+	default:
+		return true
+	}
+}
+
+// success records a successful invocation
+func (b *breaker) success() {
+	if BreakerState(b.state.Load()) == BreakerClosed {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if BreakerState(b.state.Load()) == BreakerHalfOpen {
+		b.state.Store(int32(BreakerClosed))
+		b.failures = 0
+		b.trialInFlight = false
+	}
+}
+
+// release ends a trial call that neither succeeded nor failed, e.g. a hook rejecting a packet while
+// errors are not counted, so the next call is the trial. The breaker stays half open
+func (b *breaker) release() {
+	if BreakerState(b.state.Load()) == BreakerClosed {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if BreakerState(b.state.Load()) == BreakerHalfOpen {
+		b.trialInFlight = false
+	}
+}
+
+// failure records a failed invocation and reports whether the breaker tripped
+func (b *breaker) failure(config BreakerConfig, err error, panicked bool, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if panicked {
+		b.panics++
+	} else {
+		b.errors++
+	}
+	b.lastFailure = err
+	b.lastFailureAt = now
+
+	if BreakerState(b.state.Load()) == BreakerHalfOpen {
+		b.open(now)
+		return true
+	}
+
+	if config.FailureThreshold <= 0 {
+		return false
+	}
+
+	if now.Sub(b.windowStart) > config.Window {
+		b.windowStart = now
+		b.failures = 0
+	}
+	b.failures++
+
+	if b.failures >= config.FailureThreshold {
+		b.open(now)
+		return true
+	}
+	return false
+}
+
+func (b *breaker) open(now time.Time) {
+	b.state.Store(int32(BreakerOpen))
+	b.openedAt = now
+	b.failures = 0
+	b.trialInFlight = false
+	b.trips++
+}
+
+func (b *breaker) health(id string) HookHealth {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return HookHealth{
+		ID:            id,
+		State:         BreakerState(b.state.Load()),
+		Panics:        b.panics,
+		Errors:        b.errors,
+		Trips:         b.trips,
+		LastFailure:   b.lastFailure,
+		LastFailureAt: b.lastFailureAt,
+	}
+}
@@ -0,0 +1,252 @@
+package hook
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type panicHook struct {
+	*Base
+	mu        sync.Mutex
+	panicking bool
+	err       error
+	delay     time.Duration
+	calls     int
+}
+
+func newPanicHook(id string) *panicHook {
+	return &panicHook{Base: &Base{id: id}, panicking: true}
+}
+
+func (h *panicHook) Provides(event Event) bool {
+	return event == OnPublish || event == OnConnectAuthenticate || event == StoredClients
+}
+
+func (h *panicHook) setPanicking(p bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.panicking = p
+}
+
+func (h *panicHook) call() {
+	h.mu.Lock()
+	h.calls++
+	p, delay := h.panicking, h.delay
+	h.mu.Unlock()
+	time.Sleep(delay)
+	if p {
+		panic("boom")
+	}
+}
+
+func (h *panicHook) OnPublish(_ *Client, _ *PublishPacket) error {
+	h.call()
+	return h.err
+}
+
+func (h *panicHook) OnConnectAuthenticate(_ *Client, _ *ConnectPacket) bool {
+	h.call()
+	return true
+}
+
+func (h *panicHook) StoredClients() ([]*Client, error) {
+	h.call()
+	return []*Client{{ID: "c1"}}, nil
+}
+
+type recordingLogger struct {
+	mu     sync.Mutex
+	errors []string
+	warns  []string
+}
+
+func (l *recordingLogger) Info(string, ...interface{})  {}
+func (l *recordingLogger) Debug(string, ...interface{}) {}
+
+func (l *recordingLogger) Warn(msg string, _ ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.warns = append(l.warns, msg)
+}
+
+func (l *recordingLogger) Error(msg string, _ ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errors = append(l.errors, msg)
+}
+
+func TestBreakerStateString(t *testing.T) {
+	assert.Equal(t, "closed", BreakerClosed.String())
+	assert.Equal(t, "open", BreakerOpen.String())
+	assert.Equal(t, "half_open", BreakerHalfOpen.String())
+	assert.Equal(t, "unknown", BreakerState(99).String())
+}
+
+func TestManagerRecoversPanic(t *testing.T) {
+	m := NewManager()
+	h := newPanicHook("panicky")
+	require.NoError(t, m.Add(h))
+
+	err := m.OnPublish(&Client{ID: "c1"}, &PublishPacket{Topic: "a"})
+	assert.ErrorIs(t, err, ErrHookPanicked)
+
+	assert.False(t, m.OnConnectAuthenticate(&Client{ID: "c1"}, &ConnectPacket{}))
+
+	_, err = m.StoredClients()
+	assert.ErrorIs(t, err, ErrHookPanicked)
+
+	health, ok := m.Health("panicky")
+	require.True(t, ok)
+	assert.Equal(t, uint64(3), health.Panics)
+	assert.ErrorIs(t, health.LastFailure, ErrHookPanicked)
+	assert.False(t, health.LastFailureAt.IsZero())
+
+	_, ok = m.Health("missing")
+	assert.False(t, ok)
+}
+
+func TestManagerCircuitBreaker(t *testing.T) {
+	log := &recordingLogger{}
+	m := NewManagerWithConfig(ManagerConfig{
+		Breaker: BreakerConfig{FailureThreshold: 2, Window: time.Minute, Cooldown: 50 * time.Millisecond},
+		Logger:  log,
+	})
+	h := newPanicHook("panicky")
+	require.NoError(t, m.Add(h))
+
+	client := &Client{ID: "c1"}
+	packet := &PublishPacket{Topic: "a"}
+
+	assert.Error(t, m.OnPublish(client, packet))
+	assert.Error(t, m.OnPublish(client, packet))
+
+	health, _ := m.Health("panicky")
+	assert.Equal(t, BreakerOpen, health.State)
+	assert.Equal(t, uint64(1), health.Trips)
+	assert.Len(t, log.errors, 2)
+	assert.Len(t, log.warns, 1)
+
+	// Open breaker skips publish hooks and denies auth
+	assert.NoError(t, m.OnPublish(client, packet))
+	assert.False(t, m.OnConnectAuthenticate(client, &ConnectPacket{}))
+	assert.Equal(t, 2, h.calls)
+
+	// Failed trial call reopens the breaker
+	time.Sleep(60 * time.Millisecond)
+	assert.Error(t, m.OnPublish(client, packet))
+	health, _ = m.Health("panicky")
+	assert.Equal(t, BreakerOpen, health.State)
+	assert.Equal(t, uint64(2), health.Trips)
+
+	// Successful trial call closes it
+	h.setPanicking(false)
+	time.Sleep(60 * time.Millisecond)
+	assert.NoError(t, m.OnPublish(client, packet))
+	health, _ = m.Health("panicky")
+	assert.Equal(t, BreakerClosed, health.State)
+	assert.True(t, m.OnConnectAuthenticate(client, &ConnectPacket{}))
+}
+
+func TestManagerCircuitBreakerCountErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		countErrors bool
+		state       BreakerState
+	}{
+		{name: "errors ignored", countErrors: false, state: BreakerClosed},
+		{name: "errors counted", countErrors: true, state: BreakerOpen},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManagerWithConfig(ManagerConfig{
+				Breaker: BreakerConfig{FailureThreshold: 2, Window: time.Minute, Cooldown: time.Minute, CountErrors: tt.countErrors},
+			})
+			h := newPanicHook("failing")
+			h.panicking = false
+			h.err = errors.New("rejected")
+			require.NoError(t, m.Add(h))
+
+			for i := 0; i < 3; i++ {
+				_ = m.OnPublish(&Client{ID: "c1"}, &PublishPacket{Topic: "a"})
+			}
+
+			health, _ := m.Health("failing")
+			assert.Equal(t, tt.state, health.State)
+			assert.Equal(t, uint64(0), health.Panics)
+		})
+	}
+}
+
+func TestManagerCircuitBreakerDisabled(t *testing.T) {
+	m := NewManagerWithConfig(ManagerConfig{Breaker: BreakerConfig{}})
+	h := newPanicHook("panicky")
+	require.NoError(t, m.Add(h))
+
+	for i := 0; i < 10; i++ {
+		assert.ErrorIs(t, m.OnPublish(&Client{ID: "c1"}, &PublishPacket{Topic: "a"}), ErrHookPanicked)
+	}
+	assert.Equal(t, 10, h.calls)
+
+	all := m.HealthAll()
+	require.Len(t, all, 1)
+	assert.Equal(t, "panicky", all[0].ID)
+	assert.Equal(t, BreakerClosed, all[0].State)
+	assert.Equal(t, uint64(10), all[0].Panics)
+}
+
+func TestManagerCircuitBreakerHalfOpenError(t *testing.T) {
+	m := NewManagerWithConfig(ManagerConfig{
+		Breaker: BreakerConfig{FailureThreshold: 1, Window: time.Minute, Cooldown: 20 * time.Millisecond},
+	})
+	h := newPanicHook("flaky")
+	require.NoError(t, m.Add(h))
+
+	client := &Client{ID: "c1"}
+	packet := &PublishPacket{Topic: "a"}
+	assert.ErrorIs(t, m.OnPublish(client, packet), ErrHookPanicked)
+
+	// An error returned by the trial call neither closes nor reopens the breaker
+	h.setPanicking(false)
+	h.err = errors.New("rejected")
+	time.Sleep(30 * time.Millisecond)
+	assert.Error(t, m.OnPublish(client, packet))
+	health, _ := m.Health("flaky")
+	assert.Equal(t, BreakerHalfOpen, health.State)
+
+	// The next call is the trial
+	h.err = nil
+	assert.NoError(t, m.OnPublish(client, packet))
+	health, _ = m.Health("flaky")
+	assert.Equal(t, BreakerClosed, health.State)
+	assert.Equal(t, 3, h.calls)
+}
+
+func TestManagerCircuitBreakerTimeout(t *testing.T) {
+	m := NewManagerWithConfig(ManagerConfig{
+		Breaker: BreakerConfig{FailureThreshold: 2, Window: time.Minute, Cooldown: time.Minute, Timeout: 5 * time.Millisecond},
+	})
+	h := newPanicHook("slow")
+	h.panicking = false
+	h.delay = 10 * time.Millisecond
+	require.NoError(t, m.Add(h))
+
+	// Slow calls keep their own result, an allow stays an allow
+	client := &Client{ID: "c1"}
+	assert.True(t, m.OnConnectAuthenticate(client, &ConnectPacket{}))
+	assert.NoError(t, m.OnPublish(client, &PublishPacket{Topic: "a"}))
+
+	health, _ := m.Health("slow")
+	assert.Equal(t, BreakerOpen, health.State)
+	assert.Equal(t, uint64(2), health.Errors)
+	assert.ErrorIs(t, health.LastFailure, ErrHookTimeout)
+
+	// The tripped hook is skipped instead of stalling the caller
+	assert.NoError(t, m.OnPublish(client, &PublishPacket{Topic: "a"}))
+	assert.Equal(t, 2, h.calls)
+}
@@ -0,0 +1,250 @@
+package hook
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ClientPoolConfig configures a pool of internal clients
+type ClientPoolConfig struct {
+	// ClientIDPrefix names the clients of the pool, e.g. ingest-1, ingest-2
+	ClientIDPrefix string
+	// Username and Metadata are given to every client so ACL and tenant hooks can tell the service apart
+	Username string
+	Metadata map[string]string
+	// Size bounds the clients borrowed at once, Borrow waits when all are in use
+	Size int
+	// MaxFailures retires a client after that many consecutive failed publishes, zero never retires one
+	MaxFailures int
+	// HealthCheck is run on idle clients when they are borrowed, a failing client is replaced by a new one
+	HealthCheck func(*InternalClient) error
+}
+
+// DefaultClientPoolConfig returns the default internal client pool configuration
+func DefaultClientPoolConfig() ClientPoolConfig {
+	return ClientPoolConfig{
+		ClientIDPrefix: "internal",
+		Size:           16,
+		MaxFailures:    3,
+	}
+}
+
+// ClientPoolStats holds the counters of a client pool
+type ClientPoolStats struct {
+	InUse     int
+	Idle      int
+	Borrowed  uint64
+	Waited    uint64
+	Published uint64
+	Failed    uint64
+	Replaced  uint64
+}
+
+// InternalClient publishes through a publish pipeline on behalf of a backend service running in the broker
+// process. It has a client identity for hooks and ACLs but no session state, subscriptions or connection
+type InternalClient struct {
+	client   *Client
+	pool     *ClientPool
+	failures int
+	borrowed bool
+}
+
+// ID returns the client ID
+func (c *InternalClient) ID() string {
+	return c.client.ID
+}
+
+// Client returns the identity hooks see for the publishes of this client
+func (c *InternalClient) Client() *Client {
+	return c.client
+}
+
+// Healthy reports whether the client has fewer consecutive failures than the pool tolerates
+func (c *InternalClient) Healthy() bool {
+	return c.pool.config.MaxFailures <= 0 || c.failures < c.pool.config.MaxFailures
+}
+
+// Publish runs packet through the pipeline, a message dropped by a stage fails with ErrPublishDropped
+// A borrowed client must only be used by one goroutine at a time
+func (c *InternalClient) Publish(ctx context.Context, packet *PublishPacket) error {
+	if packet.Created.IsZero() {
+		packet.Created = time.Now()
+	}
+	pc := NewPublishContext(ctx, c.client, packet)
+	err := c.pool.pipeline.Process(pc)
+	if err == nil {
+		if reason, dropped := pc.Dropped(); dropped {
+			err = fmt.Errorf("%w: %s", ErrPublishDropped, reason)
+		}
+	}
+
+	if err != nil {
+		c.failures++
+		c.pool.failed.Add(1)
+		return err
+	}
+	c.failures = 0
+	c.pool.published.Add(1)
+	return nil
+}
+
+// ClientPool lends internal clients to backend services that publish at high rates through the broker
+// pipeline, so they share a bounded set of client identities instead of each creating a session
+type ClientPool struct {
+	config   ClientPoolConfig
+	pipeline *PublishPipeline
+	slots    chan struct{}
+	done     chan struct{}
+
+	mu     sync.Mutex
+	idle   []*InternalClient
+	next   int
+	closed bool
+
+	borrowed  atomic.Uint64
+	waited    atomic.Uint64
+	published atomic.Uint64
+	failed    atomic.Uint64
+	replaced  atomic.Uint64
+}
+
+// NewClientPool creates a pool of clients publishing through pipeline
+func NewClientPool(pipeline *PublishPipeline, config ClientPoolConfig) *ClientPool {
+	defaults := DefaultClientPoolConfig()
+	if config.ClientIDPrefix == "" {
+		config.ClientIDPrefix = defaults.ClientIDPrefix
+	}
+	if config.Size <= 0 {
+		config.Size = defaults.Size
+	}
+	return &ClientPool{
+		config:   config,
+		pipeline: pipeline,
+		slots:    make(chan struct{}, config.Size),
+		done:     make(chan struct{}),
+	}
+}
+
+// Borrow takes an idle client or creates one, waiting while Size clients are in use until ctx is done
+// Every borrowed client must be given back with Return
+func (p *ClientPool) Borrow(ctx context.Context) (*InternalClient, error) {
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		p.waited.Add(1)
+		select {
+		case p.slots <- struct{}{}:
+		case <-p.done:
+			return nil, ErrClientPoolClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		<-p.slots
+		return nil, ErrClientPoolClosed
+	}
+	var c *InternalClient
+	if n := len(p.idle); n > 0 {
+		c = p.idle[n-1]
+		p.idle = p.idle[:n-1]
+	}
+	p.mu.Unlock()
+
+	if c != nil && p.config.HealthCheck != nil && p.config.HealthCheck(c) != nil {
+		p.replaced.Add(1)
+		c = nil
+	}
+	if c == nil {
+		c = p.newClient()
+	}
+	c.borrowed = true
+	p.borrowed.Add(1)
+	return c, nil
+}
+
+func (p *ClientPool) newClient() *InternalClient {
+	p.mu.Lock()
+	p.next++
+	id := fmt.Sprintf("%s-%d", p.config.ClientIDPrefix, p.next)
+	p.mu.Unlock()
+
+	return &InternalClient{
+		client: &Client{
+			ID:          id,
+			Username:    p.config.Username,
+			CleanStart:  true,
+			ConnectedAt: time.Now(),
+			State:       ClientStateConnected,
+			Metadata:    maps.Clone(p.config.Metadata),
+		},
+		pool: p,
+	}
+}
+
+// Return gives a borrowed client back, a client that failed MaxFailures times in a row is retired
+func (p *ClientPool) Return(c *InternalClient) error {
+	if c == nil || c.pool != p || !c.borrowed {
+		return ErrClientNotBorrowed
+	}
+	c.borrowed = false
+
+	p.mu.Lock()
+	switch {
+	case p.closed:
+	case !c.Healthy():
+		p.replaced.Add(1)
+	default:
+		p.idle = append(p.idle, c)
+	}
+	p.mu.Unlock()
+
+	<-p.slots
+	return nil
+}
+
+// Publish borrows a client, publishes packet and returns the client
+func (p *ClientPool) Publish(ctx context.Context, packet *PublishPacket) error {
+	c, err := p.Borrow(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = p.Return(c) }()
+	return c.Publish(ctx, packet)
+}
+
+// Close fails waiting and later borrows, clients already borrowed can still publish and be returned
+func (p *ClientPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed = true
+	p.idle = nil
+	close(p.done)
+}
+
+// Stats returns the pool counters
+func (p *ClientPool) Stats() ClientPoolStats {
+	p.mu.Lock()
+	idle := len(p.idle)
+	p.mu.Unlock()
+
+	return ClientPoolStats{
+		InUse:     len(p.slots),
+		Idle:      idle,
+		Borrowed:  p.borrowed.Load(),
+		Waited:    p.waited.Load(),
+		Published: p.published.Load(),
+		Failed:    p.failed.Load(),
+		Replaced:  p.replaced.Load(),
+	}
+}
@@ -0,0 +1,168 @@
+package hook
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newPoolPipeline(t *testing.T, fn func(*PublishContext) error) *PublishPipeline {
+	t.Helper()
+	p, err := NewPublishPipeline(NewPublishStage("route", PhaseRoute, fn))
+	require.NoError(t, err)
+	return p
+}
+
+func TestClientPoolPublish(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		clients []string
+	)
+	pipeline := newPoolPipeline(t, func(pc *PublishContext) error {
+		mu.Lock()
+		defer mu.Unlock()
+		clients = append(clients, pc.Client.ID)
+		assert.Equal(t, "svc", pc.Client.Username)
+		assert.Equal(t, "acme", pc.Client.Metadata["tenant"])
+		assert.False(t, pc.Packet.Created.IsZero())
+		return nil
+	})
+	pool := NewClientPool(pipeline, ClientPoolConfig{
+		ClientIDPrefix: "ingest",
+		Username:       "svc",
+		Metadata:       map[string]string{"tenant": "acme"},
+		Size:           2,
+	})
+	defer pool.Close()
+
+	for range 3 {
+		require.NoError(t, pool.Publish(context.Background(), &PublishPacket{Topic: "a"}))
+	}
+	assert.Equal(t, []string{"ingest-1", "ingest-1", "ingest-1"}, clients, "idle clients are reused")
+	assert.Equal(t, ClientPoolStats{Idle: 1, Borrowed: 3, Published: 3}, pool.Stats())
+}
+
+func TestClientPoolBorrowWaitsWhenExhausted(t *testing.T) {
+	pool := NewClientPool(newPoolPipeline(t, func(*PublishContext) error { return nil }), ClientPoolConfig{Size: 1})
+	defer pool.Close()
+
+	c, err := pool.Borrow(context.Background())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = pool.Borrow(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	got := make(chan *InternalClient)
+	go func() {
+		next, err := pool.Borrow(context.Background())
+		assert.NoError(t, err)
+		got <- next
+	}()
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, pool.Return(c))
+	next := <-got
+	assert.Equal(t, c.ID(), next.ID())
+	require.NoError(t, pool.Return(next))
+
+	assert.ErrorIs(t, pool.Return(next), ErrClientNotBorrowed)
+	assert.Equal(t, uint64(2), pool.Stats().Waited)
+	assert.Zero(t, pool.Stats().InUse)
+}
+
+func TestClientPoolRetiresFailingClients(t *testing.T) {
+	var fail atomic.Bool
+	fail.Store(true)
+	pipeline := newPoolPipeline(t, func(pc *PublishContext) error {
+		if fail.Load() {
+			return errors.New("route unavailable")
+		}
+		return nil
+	})
+	pool := NewClientPool(pipeline, ClientPoolConfig{Size: 1, MaxFailures: 2})
+	defer pool.Close()
+
+	c, err := pool.Borrow(context.Background())
+	require.NoError(t, err)
+	assert.Error(t, c.Publish(context.Background(), &PublishPacket{Topic: "a"}))
+	assert.True(t, c.Healthy())
+	assert.Error(t, c.Publish(context.Background(), &PublishPacket{Topic: "a"}))
+	assert.False(t, c.Healthy())
+	require.NoError(t, pool.Return(c))
+
+	fail.Store(false)
+	next, err := pool.Borrow(context.Background())
+	require.NoError(t, err)
+	assert.NotEqual(t, c.ID(), next.ID())
+	require.NoError(t, next.Publish(context.Background(), &PublishPacket{Topic: "a"}))
+	require.NoError(t, pool.Return(next))
+
+	stats := pool.Stats()
+	assert.Equal(t, uint64(2), stats.Failed)
+	assert.Equal(t, uint64(1), stats.Replaced)
+	assert.Equal(t, uint64(1), stats.Published)
+}
+
+func TestClientPoolHealthCheck(t *testing.T) {
+	pool := NewClientPool(newPoolPipeline(t, func(*PublishContext) error { return nil }), ClientPoolConfig{
+		Size: 1,
+		HealthCheck: func(c *InternalClient) error {
+			if c.ID() == "internal-1" {
+				return errors.New("stale")
+			}
+			return nil
+		},
+	})
+	defer pool.Close()
+
+	c, err := pool.Borrow(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, pool.Return(c))
+
+	c, err = pool.Borrow(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "internal-2", c.ID())
+	assert.Equal(t, uint64(1), pool.Stats().Replaced)
+}
+
+func TestClientPoolDroppedPublish(t *testing.T) {
+	pipeline := newPoolPipeline(t, func(pc *PublishContext) error {
+		pc.Drop(DropReasonACLDenied)
+		return nil
+	})
+	pool := NewClientPool(pipeline, ClientPoolConfig{})
+	defer pool.Close()
+
+	err := pool.Publish(context.Background(), &PublishPacket{Topic: "a"})
+	assert.ErrorIs(t, err, ErrPublishDropped)
+	assert.Contains(t, err.Error(), DropReasonACLDenied.String())
+}
+
+func TestClientPoolClose(t *testing.T) {
+	pool := NewClientPool(newPoolPipeline(t, func(*PublishContext) error { return nil }), ClientPoolConfig{Size: 1})
+
+	c, err := pool.Borrow(context.Background())
+	require.NoError(t, err)
+
+	waiting := make(chan error)
+	go func() {
+		_, err := pool.Borrow(context.Background())
+		waiting <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	pool.Close()
+	assert.ErrorIs(t, <-waiting, ErrClientPoolClosed)
+
+	require.NoError(t, c.Publish(context.Background(), &PublishPacket{Topic: "a"}))
+	require.NoError(t, pool.Return(c))
+	_, err = pool.Borrow(context.Background())
+	assert.ErrorIs(t, err, ErrClientPoolClosed)
+	assert.Zero(t, pool.Stats().Idle)
+}
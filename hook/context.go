@@ -0,0 +1,168 @@
+package hook
+
+import (
+	"context"
+)
+
+// PublishContextHook is implemented by hooks that want a context on OnPublish
+// The context ends when the publish is abandoned or the manager shuts down, so slow work can stop early
+type PublishContextHook interface {
+	OnPublishContext(ctx context.Context, client *Client, packet *PublishPacket) error
+}
+
+// PublishedContextHook is implemented by hooks that want a context on OnPublished
+type PublishedContextHook interface {
+	OnPublishedContext(ctx context.Context, client *Client, packet *PublishPacket) error
+}
+
+// Context returns the manager context, cancelled with ErrManagerShutdown as its cause by Shutdown
+func (m *Manager) Context() context.Context {
+	return m.ctx
+}
+
+// Shutdown cancels the context of every context-aware hook call and waits for the running ones to return
+// Calls started afterwards fail straight away, it returns ctx.Err() if the calls do not finish in time
+// Hooks may call back into the manager while it waits, such calls fail instead of blocking
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.cancel()
+
+	m.callsMu.Lock()
+	if m.calls == 0 {
+		m.callsMu.Unlock()
+		return nil
+	}
+	if m.idle == nil {
+		m.idle = make(chan struct{})
+	}
+	idle := m.idle
+	m.callsMu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// beginCall registers a running context-aware call, endCall must follow when it returns
+func (m *Manager) beginCall() {
+	m.callsMu.Lock()
+	m.calls++
+	m.callsMu.Unlock()
+}
+
+// endCall unregisters a call and wakes Shutdown once none is left
+func (m *Manager) endCall() {
+	m.callsMu.Lock()
+	m.calls--
+	if m.calls == 0 && m.idle != nil {
+		close(m.idle)
+		m.idle = nil
+	}
+	m.callsMu.Unlock()
+}
+
+// callContext derives the context for a hook call from ctx and the manager lifetime
+func (m *Manager) callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	if m.ctx.Err() != nil {
+		cancel(ErrManagerShutdown)
+		return ctx, func() {}
+	}
+	stop := context.AfterFunc(m.ctx, func() {
+		cancel(ErrManagerShutdown)
+	})
+	return ctx, func() {
+		stop()
+		cancel(nil)
+	}
+}
+
+// OnPublishContext invokes all OnPublish hooks like OnPublish, handing ctx to hooks implementing PublishContextHook
+// It stops and returns the cancellation cause once ctx is done or the manager shuts down
+func (m *Manager) OnPublishContext(ctx context.Context, client *Client, packet *PublishPacket) error {
+	m.beginCall()
+	defer m.endCall()
+
+	ctx, cancel := m.callContext(ctx)
+	defer cancel()
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+
+	entries := *m.entriesPtr.Load()
+
+	for _, hook := range entries {
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		if provides(hook.Hook, OnPublish, client.GetID(), packet.GetTopic()) {
+			if _, err := m.invoke(hook, OnPublish, func() error {
+				if ch, ok := hook.Hook.(PublishContextHook); ok {
+					return ch.OnPublishContext(ctx, client, packet)
+				}
+				return hook.OnPublish(client, packet)
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// OnSelectSubscribersContext invokes all OnSelectSubscribers hooks, stopping once ctx is done or the manager shuts down
+func (m *Manager) OnSelectSubscribersContext(ctx context.Context, subscribers *Subscribers, topic string) error {
+	m.beginCall()
+	defer m.endCall()
+
+	ctx, cancel := m.callContext(ctx)
+	defer cancel()
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+
+	entries := *m.entriesPtr.Load()
+
+	for _, hook := range entries {
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		if provides(hook.Hook, OnSelectSubscribers, "", topic) {
+			_, _ = m.invoke(hook, OnSelectSubscribers, func() error {
+				return hook.OnSelectSubscribers(subscribers, topic)
+			})
+		}
+	}
+	return nil
+}
+
+// OnPublishedContext invokes all OnPublished hooks, handing ctx to hooks implementing PublishedContextHook
+// Hooks not yet called when ctx is done or the manager shuts down are skipped
+func (m *Manager) OnPublishedContext(ctx context.Context, client *Client, packet *PublishPacket) error {
+	m.beginCall()
+	defer m.endCall()
+
+	ctx, cancel := m.callContext(ctx)
+	defer cancel()
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+
+	entries := *m.entriesPtr.Load()
+
+	for _, hook := range entries {
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		if provides(hook.Hook, OnPublished, client.GetID(), packet.GetTopic()) {
+			_, _ = m.invoke(hook, OnPublished, func() error {
+				if ch, ok := hook.Hook.(PublishedContextHook); ok {
+					return ch.OnPublishedContext(ctx, client, packet)
+				}
+				return hook.OnPublished(client, packet)
+			})
+		}
+	}
+	return nil
+}
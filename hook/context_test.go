@@ -0,0 +1,200 @@
+package hook
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ctxPublishHook blocks in OnPublishContext until its context ends
+type ctxPublishHook struct {
+	*Base
+	started chan struct{}
+	cause   atomic.Value
+}
+
+func (h *ctxPublishHook) Provides(event Event) bool {
+	return event == OnPublish
+}
+
+func (h *ctxPublishHook) OnPublishContext(ctx context.Context, _ *Client, _ *PublishPacket) error {
+	close(h.started)
+	<-ctx.Done()
+	h.cause.Store(context.Cause(ctx))
+	return context.Cause(ctx)
+}
+
+// plainPublishHook only implements the context-free callbacks
+type plainPublishHook struct {
+	*Base
+	published atomic.Int32
+}
+
+func (h *plainPublishHook) Provides(event Event) bool {
+	return event == OnPublish
+}
+
+func (h *plainPublishHook) OnPublish(_ *Client, _ *PublishPacket) error {
+	h.published.Add(1)
+	return nil
+}
+
+func TestManagerOnPublishContext(t *testing.T) {
+	m := NewManager()
+	plain := &plainPublishHook{Base: NewHookBase("plain")}
+	require.NoError(t, m.Add(plain))
+
+	client := &Client{ID: "c1"}
+	packet := &PublishPacket{Topic: "a/b"}
+
+	require.NoError(t, m.OnPublishContext(context.Background(), client, packet))
+	assert.Equal(t, int32(1), plain.published.Load())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, m.OnPublishContext(ctx, client, packet), context.Canceled)
+	assert.Equal(t, int32(1), plain.published.Load())
+}
+
+type traceKey struct{}
+
+// tracingHook records the trace ID it sees in OnPublishedContext
+type tracingHook struct {
+	*Base
+	traceID atomic.Value
+}
+
+func (h *tracingHook) Provides(event Event) bool {
+	return event == OnPublished
+}
+
+func (h *tracingHook) OnPublishedContext(ctx context.Context, _ *Client, _ *PublishPacket) error {
+	h.traceID.Store(ctx.Value(traceKey{}))
+	return nil
+}
+
+func TestManagerOnPublishedContext_PropagatesValues(t *testing.T) {
+	m := NewManager()
+	h := &tracingHook{Base: NewHookBase("trace")}
+	require.NoError(t, m.Add(h))
+
+	ctx := context.WithValue(context.Background(), traceKey{}, "trace-1")
+	require.NoError(t, m.OnPublishedContext(ctx, &Client{ID: "c1"}, &PublishPacket{Topic: "a/b"}))
+	assert.Equal(t, "trace-1", h.traceID.Load())
+}
+
+func TestManagerOnPublishContext_Deadline(t *testing.T) {
+	m := NewManager()
+	h := &ctxPublishHook{Base: NewHookBase("ctx"), started: make(chan struct{})}
+	require.NoError(t, m.Add(h))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := m.OnPublishContext(ctx, &Client{ID: "c1"}, &PublishPacket{Topic: "a/b"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestManagerShutdown(t *testing.T) {
+	m := NewManager()
+	h := &ctxPublishHook{Base: NewHookBase("ctx"), started: make(chan struct{})}
+	require.NoError(t, m.Add(h))
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- m.OnPublishContext(context.Background(), &Client{ID: "c1"}, &PublishPacket{Topic: "a/b"})
+	}()
+	<-h.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, m.Shutdown(ctx))
+
+	assert.ErrorIs(t, <-errCh, ErrManagerShutdown)
+	assert.ErrorIs(t, h.cause.Load().(error), ErrManagerShutdown)
+	assert.ErrorIs(t, context.Cause(m.Context()), ErrManagerShutdown)
+
+	err := m.OnPublishedContext(context.Background(), &Client{ID: "c1"}, &PublishPacket{Topic: "a/b"})
+	assert.ErrorIs(t, err, ErrManagerShutdown)
+	assert.ErrorIs(t, m.OnSelectSubscribersContext(context.Background(), &Subscribers{}, "a/b"), ErrManagerShutdown)
+}
+
+// stuckHook ignores cancellation
+type stuckHook struct {
+	*Base
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h *stuckHook) Provides(event Event) bool {
+	return event == OnPublish
+}
+
+func (h *stuckHook) OnPublish(_ *Client, _ *PublishPacket) error {
+	close(h.started)
+	<-h.release
+	return nil
+}
+
+func TestManagerShutdown_Timeout(t *testing.T) {
+	m := NewManager()
+	h := &stuckHook{Base: NewHookBase("stuck"), started: make(chan struct{}), release: make(chan struct{})}
+	require.NoError(t, m.Add(h))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- m.OnPublishContext(context.Background(), &Client{ID: "c1"}, &PublishPacket{Topic: "a/b"})
+	}()
+	<-h.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := m.Shutdown(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	close(h.release)
+	assert.NoError(t, <-done)
+}
+
+// reentrantHook publishes again from OnPublishContext once its context ends, as a hook answering a request does
+type reentrantHook struct {
+	*Base
+	m       *Manager
+	started chan struct{}
+	nested  chan error
+}
+
+func (h *reentrantHook) Provides(event Event) bool {
+	return event == OnPublish
+}
+
+func (h *reentrantHook) OnPublishContext(ctx context.Context, client *Client, _ *PublishPacket) error {
+	close(h.started)
+	<-ctx.Done()
+	h.nested <- h.m.OnPublishedContext(context.Background(), client, &PublishPacket{Topic: "reply"})
+	return nil
+}
+
+func TestManagerShutdown_Reentrant(t *testing.T) {
+	m := NewManager()
+	h := &reentrantHook{Base: NewHookBase("reentrant"), m: m, started: make(chan struct{}), nested: make(chan error, 1)}
+	require.NoError(t, m.Add(h))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- m.OnPublishContext(context.Background(), &Client{ID: "c1"}, &PublishPacket{Topic: "a/b"})
+	}()
+	<-h.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, m.Shutdown(ctx))
+	assert.ErrorIs(t, <-h.nested, ErrManagerShutdown)
+	assert.NoError(t, <-done)
+	require.NoError(t, m.Shutdown(ctx))
+}
@@ -0,0 +1,201 @@
+package hook
+
+import (
+	"bytes"
+	"strings"
+	"sync/atomic"
+
+	"github.com/axmq/ax/encoding"
+)
+
+// AnnotationModified is the user property listing how a delivered message differs from the message as published
+const AnnotationModified = "ax-modified"
+
+// DeliveryModification flags how a delivered message differs from the message as published
+type DeliveryModification uint8
+
+const (
+	ModifiedQoSDowngrade DeliveryModification = 1 << iota
+	ModifiedRetainCleared
+	ModifiedPropertiesDropped
+	ModifiedPayloadTruncated
+	ModifiedPayloadChanged
+	ModifiedTopicChanged
+
+	ModifiedAll = ModifiedQoSDowngrade | ModifiedRetainCleared | ModifiedPropertiesDropped |
+		ModifiedPayloadTruncated | ModifiedPayloadChanged | ModifiedTopicChanged
+)
+
+// modificationReasons names the modifications in bit order, the names are the annotation values and stats keys
+var modificationReasons = [...]string{
+	"qos_downgrade",
+	"retain_cleared",
+	"properties_dropped",
+	"payload_truncated",
+	"payload_changed",
+	"topic_changed",
+}
+
+// Reasons returns the names of the modifications in m
+func (m DeliveryModification) Reasons() []string {
+	var reasons []string
+	for i, name := range modificationReasons {
+		if m&(1<<i) != 0 {
+			reasons = append(reasons, name)
+		}
+	}
+	return reasons
+}
+
+// String returns the comma separated names of the modifications in m
+func (m DeliveryModification) String() string {
+	return strings.Join(m.Reasons(), ",")
+}
+
+// DiffDelivery compares a delivered copy with the message as published
+// Properties added on delivery, e.g. by the AnnotationHook, are not a modification
+func DiffDelivery(original, delivered *PublishPacket) DeliveryModification {
+	if original == nil || delivered == nil {
+		return 0
+	}
+
+	var m DeliveryModification
+	if delivered.QoS < original.QoS {
+		m |= ModifiedQoSDowngrade
+	}
+	if original.Retain && !delivered.Retain {
+		m |= ModifiedRetainCleared
+	}
+	if propertiesDropped(original.Properties, delivered.Properties) {
+		m |= ModifiedPropertiesDropped
+	}
+	if !bytes.Equal(original.Payload, delivered.Payload) {
+		if bytes.HasPrefix(original.Payload, delivered.Payload) {
+			m |= ModifiedPayloadTruncated
+		} else {
+			m |= ModifiedPayloadChanged
+		}
+	}
+	if delivered.Topic != original.Topic {
+		m |= ModifiedTopicChanged
+	}
+	return m
+}
+
+// propertiesDropped reports whether a property or user property pair of original is missing from delivered
+func propertiesDropped(original, delivered Properties) bool {
+	userKey := encoding.PropUserProperty.String()
+	for key := range original {
+		if key == userKey {
+			continue
+		}
+		if _, ok := delivered[key]; !ok {
+			return true
+		}
+	}
+
+	remaining := make(map[encoding.UTF8Pair]int)
+	for _, pair := range userPairs(delivered) {
+		remaining[pair]++
+	}
+	for _, pair := range userPairs(original) {
+		if remaining[pair] == 0 {
+			return true
+		}
+		remaining[pair]--
+	}
+	return false
+}
+
+// userPairs returns the user properties of props as pairs
+func userPairs(props Properties) []encoding.UTF8Pair {
+	switch p := props[encoding.PropUserProperty.String()].(type) {
+	case []encoding.UTF8Pair:
+		return p
+	case map[string]string:
+		pairs := make([]encoding.UTF8Pair, 0, len(p))
+		for k, v := range p {
+			pairs = append(pairs, encoding.UTF8Pair{Key: k, Value: v})
+		}
+		return pairs
+	}
+	return nil
+}
+
+// DeliveryDiagnosticsConfig configures DeliveryDiagnostics
+type DeliveryDiagnosticsConfig struct {
+	// Annotate adds the AnnotationModified user property to modified deliveries of MQTT 5 subscribers
+	Annotate bool
+	// Report selects the modifications counted and annotated, ModifiedAll when zero
+	Report DeliveryModification
+}
+
+// DeliveryDiagnosticsStats holds the counters of DeliveryDiagnostics
+type DeliveryDiagnosticsStats struct {
+	Inspected uint64
+	Modified  uint64
+	// Reasons counts modified deliveries per modification name
+	Reasons map[string]uint64
+}
+
+// DeliveryDiagnostics detects deliveries silently differing from the message as published, so integrators can
+// tell a broker modification from a publisher bug when troubleshooting
+// The delivery path calls Inspect with each copy once every OnPublishDeliver hook ran
+type DeliveryDiagnostics struct {
+	config    DeliveryDiagnosticsConfig
+	inspected atomic.Uint64
+	modified  atomic.Uint64
+	reasons   [len(modificationReasons)]atomic.Uint64
+}
+
+// NewDeliveryDiagnostics creates delivery diagnostics
+func NewDeliveryDiagnostics(config DeliveryDiagnosticsConfig) *DeliveryDiagnostics {
+	if config.Report == 0 {
+		config.Report = ModifiedAll
+	}
+	return &DeliveryDiagnostics{config: config}
+}
+
+// Inspect counts the modifications of a copy delivered to client and returns it, annotated when configured
+// Properties never reach MQTT 3 subscribers, so their deliveries count as dropping them and are not annotated
+func (d *DeliveryDiagnostics) Inspect(client *Client, original, delivered *PublishPacket) *PublishPacket {
+	d.inspected.Add(1)
+	m := DiffDelivery(original, delivered)
+	legacy := client != nil && client.ProtocolVersion != 0 && client.ProtocolVersion < byte(encoding.ProtocolVersion50)
+	if legacy && original != nil && len(original.Properties) > 0 {
+		m |= ModifiedPropertiesDropped
+	}
+	m &= d.config.Report
+	if m == 0 {
+		return delivered
+	}
+
+	d.modified.Add(1)
+	for i := range d.reasons {
+		if m&(1<<i) != 0 {
+			d.reasons[i].Add(1)
+		}
+	}
+	if !d.config.Annotate || legacy {
+		return delivered
+	}
+
+	annotated := *delivered
+	annotated.Properties = withUserProperties(delivered.Properties, []encoding.UTF8Pair{
+		{Key: AnnotationModified, Value: m.String()},
+	})
+	return &annotated
+}
+
+// Stats returns the counters of the diagnostics
+func (d *DeliveryDiagnostics) Stats() DeliveryDiagnosticsStats {
+	stats := DeliveryDiagnosticsStats{
+		Inspected: d.inspected.Load(),
+		Modified:  d.modified.Load(),
+		Reasons:   make(map[string]uint64, len(modificationReasons)),
+	}
+	for i, name := range modificationReasons {
+		stats.Reasons[name] = d.reasons[i].Load()
+	}
+	return stats
+}
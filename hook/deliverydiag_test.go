@@ -0,0 +1,89 @@
+package hook
+
+import (
+	"testing"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffDelivery(t *testing.T) {
+	userKey := encoding.PropUserProperty.String()
+	original := &PublishPacket{
+		Topic:   "orders/1",
+		Payload: []byte("payload"),
+		QoS:     2,
+		Retain:  true,
+		Properties: Properties{
+			"ContentType": "text/plain",
+			userKey:       []encoding.UTF8Pair{{Key: "a", Value: "1"}, {Key: "a", Value: "2"}},
+		},
+	}
+	same := *original
+	assert.Zero(t, DiffDelivery(original, &same))
+	assert.Zero(t, DiffDelivery(nil, &same))
+
+	// Added annotations are not a modification
+	annotated := same
+	annotated.Properties = withUserProperties(original.Properties, []encoding.UTF8Pair{{Key: AnnotationOrigin, Value: "c1"}})
+	assert.Zero(t, DiffDelivery(original, &annotated))
+
+	tests := []struct {
+		name   string
+		modify func(p *PublishPacket)
+		want   DeliveryModification
+	}{
+		{"qos", func(p *PublishPacket) { p.QoS = 1 }, ModifiedQoSDowngrade},
+		{"retain", func(p *PublishPacket) { p.Retain = false }, ModifiedRetainCleared},
+		{"property", func(p *PublishPacket) { p.Properties = Properties{userKey: original.Properties[userKey]} }, ModifiedPropertiesDropped},
+		{"user property", func(p *PublishPacket) {
+			p.Properties = Properties{"ContentType": "text/plain", userKey: map[string]string{"a": "1"}}
+		}, ModifiedPropertiesDropped},
+		{"truncated", func(p *PublishPacket) { p.Payload = []byte("pay") }, ModifiedPayloadTruncated},
+		{"changed", func(p *PublishPacket) { p.Payload = []byte("redacted") }, ModifiedPayloadChanged},
+		{"topic", func(p *PublishPacket) { p.Topic = "c1/orders/1" }, ModifiedTopicChanged},
+		{"several", func(p *PublishPacket) { p.QoS, p.Retain = 0, false }, ModifiedQoSDowngrade | ModifiedRetainCleared},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delivered := *original
+			tt.modify(&delivered)
+			assert.Equal(t, tt.want, DiffDelivery(original, &delivered))
+		})
+	}
+
+	assert.Equal(t, "qos_downgrade,payload_truncated", (ModifiedQoSDowngrade | ModifiedPayloadTruncated).String())
+	assert.Empty(t, DeliveryModification(0).String())
+}
+
+func TestDeliveryDiagnostics(t *testing.T) {
+	d := NewDeliveryDiagnostics(DeliveryDiagnosticsConfig{Annotate: true, Report: ModifiedAll &^ ModifiedRetainCleared})
+	v5 := &Client{ID: "c5", ProtocolVersion: byte(encoding.ProtocolVersion50)}
+	v3 := &Client{ID: "c3", ProtocolVersion: byte(encoding.ProtocolVersion311)}
+	original := &PublishPacket{Topic: "a", QoS: 2, Retain: true, Properties: Properties{"ContentType": "text/plain"}}
+
+	unchanged := *original
+	assert.Same(t, &unchanged, d.Inspect(v5, original, &unchanged))
+
+	// Unreported modifications are neither counted nor annotated
+	cleared := *original
+	cleared.Retain = false
+	assert.Same(t, &cleared, d.Inspect(v5, original, &cleared))
+
+	downgraded := *original
+	downgraded.QoS = 1
+	out := d.Inspect(v5, original, &downgraded)
+	assert.Equal(t, []string{"qos_downgrade"}, out.Properties.UserProperty(AnnotationModified))
+	assert.Empty(t, downgraded.Properties.UserProperty(AnnotationModified))
+
+	// MQTT 3 subscribers lose every property and cannot carry the annotation
+	out = d.Inspect(v3, original, &downgraded)
+	assert.Same(t, &downgraded, out)
+
+	stats := d.Stats()
+	assert.Equal(t, uint64(4), stats.Inspected)
+	assert.Equal(t, uint64(2), stats.Modified)
+	assert.Equal(t, uint64(2), stats.Reasons["qos_downgrade"])
+	assert.Equal(t, uint64(1), stats.Reasons["properties_dropped"])
+	assert.Equal(t, uint64(0), stats.Reasons["retain_cleared"])
+}
@@ -1,6 +1,8 @@
 package hook
 
-import "errors"
+import (
+	"errors"
+)
 
 var (
 	ErrHookNotFound            = errors.New("hook not found")
@@ -10,5 +12,37 @@ var (
 	ErrClientRateLimitExceeded = errors.New("client rate limit exceeded")
 	ErrGlobalRateLimitExceeded = errors.New("global rate limit exceeded")
 	ErrTopicRateLimitExceeded  = errors.New("topic rate limit exceeded")
+	ErrTenantRateLimitExceeded = errors.New("tenant rate limit exceeded")
+	ErrRateLimitUnavailable    = errors.New("rate limit backend unavailable")
+	ErrInvalidRateLimitConfig  = errors.New("invalid rate limit config")
 	ErrRatelimitClientNil      = errors.New("ratelimit hook: client is nil")
+	ErrHookPanicked            = errors.New("hook panicked")
+	ErrHookTimeout             = errors.New("hook timed out")
+	ErrFactoryNotFound         = errors.New("hook factory not found")
+	ErrFactoryAlreadyExists    = errors.New("hook factory already exists")
+	ErrEmptyFactoryName        = errors.New("hook factory name cannot be empty")
+	ErrManagerShutdown         = errors.New("hook manager shut down")
+	ErrInvalidPropertyFilter   = errors.New("invalid property filter")
+	ErrInvalidSubscriptionTTL  = errors.New("invalid subscription ttl")
+	ErrEmptyStageName          = errors.New("publish stage name cannot be empty")
+	ErrStageAlreadyExists      = errors.New("publish stage already exists")
+	ErrUnsupportedAckType      = errors.New("unsupported acknowledgement packet type")
+	ErrInvalidMirrorPolicy     = errors.New("invalid mirror policy")
+	ErrFingerprintChanged      = errors.New("connection fingerprint changed")
+	ErrInvalidRedactFilter     = errors.New("invalid redaction filter")
+	ErrPublishRejected         = errors.New("publish rejected")
+	ErrPublishNotAuthorized    = errors.New("publish not authorized")
+	ErrPublishQuotaExceeded    = errors.New("publish quota exceeded")
+	ErrTopicNameInvalid        = errors.New("topic name invalid")
+	ErrPayloadFormatInvalid    = errors.New("payload format invalid")
+	ErrPublishDropped          = errors.New("publish dropped")
+	ErrClientPoolClosed        = errors.New("client pool closed")
+	ErrClientNotBorrowed       = errors.New("client not borrowed from this pool")
+	ErrInvalidRetainedQuery    = errors.New("invalid retained message query")
+	ErrInvalidNotifyFilter     = errors.New("invalid subscription notify filter")
+	ErrInvalidLastValue        = errors.New("invalid last value subscription option")
+	ErrInvalidGuestNamespace   = errors.New("invalid guest namespace filter")
+	ErrInvalidEventFilter      = errors.New("invalid event filter")
+	ErrGuestQuotaExceeded      = errors.New("guest quota exceeded")
+	ErrGuestSessionExpired     = errors.New("guest session expired")
 )
@@ -0,0 +1,427 @@
+package hook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	mrand "math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/axmq/ax/encoding"
+)
+
+// FanoutTraceOrigin marks the messages a TopicTraceExporter publishes, they are never sampled themselves
+const FanoutTraceOrigin = "fanout-trace"
+
+// DeliveryOutcome is how the delivery of one copy of a traced message ended
+type DeliveryOutcome string
+
+const (
+	DeliveryPending    DeliveryOutcome = "pending"
+	DeliveryFlushed    DeliveryOutcome = "flushed"
+	DeliveryAcked      DeliveryOutcome = "acked"
+	DeliveryFailed     DeliveryOutcome = "failed"
+	DeliveryAckTimeout DeliveryOutcome = "ack_timeout"
+)
+
+// DeliveryRecord is the delivery of a traced message to one subscriber
+// Enqueued is when the copy was handed to the client queue, Flushed when it was written to the client and Acked
+// when the QoS flow completed, QoS 0 copies are never acknowledged
+type DeliveryRecord struct {
+	ClientID               string          `json:"client_id"`
+	TopicFilter            string          `json:"topic_filter,omitempty"`
+	SubscriptionIdentifier uint32          `json:"subscription_identifier,omitempty"`
+	QoS                    byte            `json:"qos"`
+	PacketID               uint16          `json:"packet_id,omitempty"`
+	Enqueued               time.Time       `json:"enqueued"`
+	Flushed                time.Time       `json:"flushed,omitzero"`
+	Acked                  time.Time       `json:"acked,omitzero"`
+	Outcome                DeliveryOutcome `json:"outcome"`
+
+	trace *FanoutTrace
+}
+
+// FanoutTrace is the delivery map of one sampled message
+type FanoutTrace struct {
+	ID          string    `json:"id"`
+	Topic       string    `json:"topic"`
+	Publisher   string    `json:"publisher"`
+	QoS         byte      `json:"qos"`
+	Retain      bool      `json:"retain"`
+	PayloadSize int       `json:"payload_size"`
+	Received    time.Time `json:"received"`
+	// Routed is when the subscriptions were matched, Matched counts them before per-client deduplication
+	Routed     time.Time         `json:"routed,omitzero"`
+	Matched    int               `json:"matched"`
+	Deliveries []*DeliveryRecord `json:"deliveries"`
+	// Finished is when every delivery settled or the acknowledgement timeout ended the trace
+	Finished time.Time `json:"finished,omitzero"`
+
+	fannedOut bool
+	pending   int
+}
+
+// TraceExporter receives finished traces, e.g. to forward them to a tracing backend
+// Exports run on the goroutine of FanoutTracer.Run, never on the delivery path
+type TraceExporter interface {
+	ExportFanout(ctx context.Context, trace *FanoutTrace) error
+}
+
+// TraceExporterFunc adapts a function to TraceExporter
+type TraceExporterFunc func(ctx context.Context, trace *FanoutTrace) error
+
+// ExportFanout calls f
+func (f TraceExporterFunc) ExportFanout(ctx context.Context, trace *FanoutTrace) error {
+	return f(ctx, trace)
+}
+
+// NewTopicTraceExporter publishes every trace as JSON to topicName through publish, e.g. scheduler.Publisher,
+// so analysis tools can subscribe to the delivery maps
+func NewTopicTraceExporter(topicName string, publish func(ctx context.Context, packet *PublishPacket) error) TraceExporter {
+	return TraceExporterFunc(func(ctx context.Context, trace *FanoutTrace) error {
+		payload, err := json.Marshal(trace)
+		if err != nil {
+			return err
+		}
+		return publish(ctx, &PublishPacket{
+			Topic:   topicName,
+			Payload: payload,
+			Properties: Properties{
+				encoding.PropContentType.String():            "application/json",
+				encoding.PropPayloadFormatIndicator.String(): byte(1),
+			},
+			ProtocolVersion: byte(encoding.ProtocolVersion50),
+			Created:         time.Now(),
+			Origin:          FanoutTraceOrigin,
+		})
+	})
+}
+
+// FanoutTraceConfig configures a FanoutTracer
+type FanoutTraceConfig struct {
+	// SampleRate is the share of messages traced, from 0 to 1
+	SampleRate float64
+	// Sample overrides SampleRate when set, e.g. to trace every message of a topic under investigation
+	Sample func(pc *PublishContext) bool
+	// Exporters receive the finished traces in order
+	Exporters []TraceExporter
+	// AckTimeout ends traces still missing acknowledgements, 30s when zero
+	AckTimeout time.Duration
+	// MaxOpen bounds the traces waiting for acknowledgements, sampling pauses at the limit. 1000 when zero
+	MaxOpen int
+	// ExportQueue is the number of finished traces buffered for Run, more are dropped. 256 when zero
+	ExportQueue int
+	// OnError receives export errors
+	OnError func(err error)
+}
+
+// FanoutTraceStats holds the counters of a FanoutTracer
+type FanoutTraceStats struct {
+	Sampled  uint64
+	Skipped  uint64
+	Exported uint64
+	Dropped  uint64
+	TimedOut uint64
+	Open     int
+}
+
+// ackKey identifies an outstanding QoS flow
+type ackKey struct {
+	clientID string
+	packetID uint16
+}
+
+// FanoutTracer records the full delivery map of sampled messages: the subscriptions matched and when each copy
+// was enqueued, flushed and acknowledged. The traces show where dispatch time goes per subscriber, so
+// dispatch and QoS parameters can be tuned from data instead of guesses
+// The fan-out calls Start, Routed, Enqueued, Flushed, Acked and Finish in that order, all of them accept the nil
+// trace of a message that is not sampled. Run exports the finished traces and must be running
+type FanoutTracer struct {
+	config FanoutTraceConfig
+
+	mu      sync.Mutex
+	open    map[*FanoutTrace]struct{}
+	pending map[ackKey]*DeliveryRecord
+
+	exports  chan *FanoutTrace
+	sampled  atomic.Uint64
+	skipped  atomic.Uint64
+	exported atomic.Uint64
+	dropped  atomic.Uint64
+	timedOut atomic.Uint64
+	now      func() time.Time
+}
+
+// NewFanoutTracer creates a fan-out tracer
+func NewFanoutTracer(config FanoutTraceConfig) *FanoutTracer {
+	if config.AckTimeout <= 0 {
+		config.AckTimeout = 30 * time.Second
+	}
+	if config.MaxOpen <= 0 {
+		config.MaxOpen = 1000
+	}
+	if config.ExportQueue <= 0 {
+		config.ExportQueue = 256
+	}
+	return &FanoutTracer{
+		config:  config,
+		open:    make(map[*FanoutTrace]struct{}),
+		pending: make(map[ackKey]*DeliveryRecord),
+		exports: make(chan *FanoutTrace, config.ExportQueue),
+		now:     time.Now,
+	}
+}
+
+// Start samples the message carried by pc and returns its trace, nil when it is not traced
+func (t *FanoutTracer) Start(pc *PublishContext) *FanoutTrace {
+	if t == nil || pc.Packet.Origin == FanoutTraceOrigin {
+		return nil
+	}
+	if t.config.Sample != nil {
+		if !t.config.Sample(pc) {
+			return nil
+		}
+	} else if t.config.SampleRate <= 0 || mrand.Float64() >= t.config.SampleRate {
+		return nil
+	}
+
+	t.mu.Lock()
+	full := len(t.open) >= t.config.MaxOpen
+	t.mu.Unlock()
+	if full {
+		t.skipped.Add(1)
+		return nil
+	}
+	t.sampled.Add(1)
+
+	packet := pc.Packet
+	received := packet.Created
+	if received.IsZero() {
+		received = t.now()
+	}
+	return &FanoutTrace{
+		ID:          newTraceID(),
+		Topic:       packet.Topic,
+		Publisher:   pc.Client.GetID(),
+		QoS:         packet.QoS,
+		Retain:      packet.Retain,
+		PayloadSize: len(packet.Payload),
+		Received:    received,
+	}
+}
+
+// newTraceID returns a random 16 byte ID in hex, the format of W3C and OpenTelemetry trace IDs
+func newTraceID() string {
+	var id [16]byte
+	_, _ = rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+// Routed records that matched subscriptions were found for the message of tr
+func (t *FanoutTracer) Routed(tr *FanoutTrace, matched int) {
+	if tr == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tr.Routed = t.now()
+	tr.Matched = matched
+}
+
+// Enqueued records a copy handed to the queue of a subscriber, packetID is that of the outbound QoS flow
+func (t *FanoutTracer) Enqueued(tr *FanoutTrace, sub *Subscription, qos byte, packetID uint16) *DeliveryRecord {
+	if tr == nil {
+		return nil
+	}
+	rec := &DeliveryRecord{
+		ClientID:               sub.ClientID,
+		TopicFilter:            sub.TopicFilter,
+		SubscriptionIdentifier: sub.SubscriptionIdentifier,
+		QoS:                    qos,
+		PacketID:               packetID,
+		Outcome:                DeliveryPending,
+		trace:                  tr,
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rec.Enqueued = t.now()
+	tr.Deliveries = append(tr.Deliveries, rec)
+	tr.pending++
+	if qos > 0 && packetID != 0 {
+		t.pending[ackKey{sub.ClientID, packetID}] = rec
+	}
+	return rec
+}
+
+// Flushed records the copy of rec written to the subscriber, or failed with err
+// QoS 0 copies settle here, the others with their acknowledgement
+func (t *FanoutTracer) Flushed(rec *DeliveryRecord, err error) {
+	if rec == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if rec.Outcome != DeliveryPending {
+		return
+	}
+	if err != nil {
+		rec.Outcome = DeliveryFailed
+		t.settleLocked(rec)
+		return
+	}
+	rec.Flushed = t.now()
+	rec.Outcome = DeliveryFlushed
+	if rec.QoS == 0 {
+		t.settleLocked(rec)
+	}
+}
+
+// Acked records the completed QoS flow of rec
+func (t *FanoutTracer) Acked(rec *DeliveryRecord) {
+	if rec == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ackLocked(rec)
+}
+
+// AckedPacket records the completed QoS flow of packetID towards clientID, for acknowledgements arriving on
+// another goroutine than the fan-out. Flows of messages that are not traced are ignored
+func (t *FanoutTracer) AckedPacket(clientID string, packetID uint16) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if rec, ok := t.pending[ackKey{clientID, packetID}]; ok {
+		t.ackLocked(rec)
+	}
+}
+
+func (t *FanoutTracer) ackLocked(rec *DeliveryRecord) {
+	if rec.QoS == 0 || (rec.Outcome != DeliveryPending && rec.Outcome != DeliveryFlushed) {
+		return
+	}
+	rec.Acked = t.now()
+	rec.Outcome = DeliveryAcked
+	t.settleLocked(rec)
+}
+
+// settleLocked ends the delivery of rec and finishes its trace when it was the last one
+func (t *FanoutTracer) settleLocked(rec *DeliveryRecord) {
+	if rec.PacketID != 0 {
+		key := ackKey{rec.ClientID, rec.PacketID}
+		if t.pending[key] == rec {
+			delete(t.pending, key)
+		}
+	}
+	tr := rec.trace
+	tr.pending--
+	if tr.fannedOut && tr.pending == 0 {
+		t.finishLocked(tr)
+	}
+}
+
+// Finish records that every copy of the message of tr was enqueued, the trace is exported once all deliveries
+// settled or the acknowledgement timeout passed
+func (t *FanoutTracer) Finish(tr *FanoutTrace) {
+	if tr == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tr.fannedOut = true
+	if tr.pending == 0 {
+		t.finishLocked(tr)
+		return
+	}
+	t.open[tr] = struct{}{}
+}
+
+func (t *FanoutTracer) finishLocked(tr *FanoutTrace) {
+	delete(t.open, tr)
+	tr.Finished = t.now()
+	select {
+	case t.exports <- tr:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// Run exports finished traces and ends those waiting too long for acknowledgements until ctx is done
+func (t *FanoutTracer) Run(ctx context.Context) {
+	ticker := time.NewTicker(max(t.config.AckTimeout/4, 10*time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case tr := <-t.exports:
+			t.export(ctx, tr)
+		case <-ticker.C:
+			t.expire()
+		}
+	}
+}
+
+func (t *FanoutTracer) export(ctx context.Context, tr *FanoutTrace) {
+	// Exporters read the trace while late acknowledgements may still arrive, hand them a settled copy
+	t.mu.Lock()
+	snapshot := *tr
+	snapshot.Deliveries = make([]*DeliveryRecord, len(tr.Deliveries))
+	for i, rec := range tr.Deliveries {
+		copied := *rec
+		snapshot.Deliveries[i] = &copied
+	}
+	t.mu.Unlock()
+
+	for _, exporter := range t.config.Exporters {
+		if err := exporter.ExportFanout(ctx, &snapshot); err != nil && t.config.OnError != nil {
+			t.config.OnError(err)
+		}
+	}
+	t.exported.Add(1)
+}
+
+// expire ends the open traces whose acknowledgement timeout passed, missing acknowledgements are marked
+func (t *FanoutTracer) expire() {
+	now := t.now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for tr := range t.open {
+		if now.Sub(tr.Received) < t.config.AckTimeout {
+			continue
+		}
+		for _, rec := range tr.Deliveries {
+			if rec.Outcome == DeliveryPending || rec.Outcome == DeliveryFlushed {
+				rec.Outcome = DeliveryAckTimeout
+				if key := (ackKey{rec.ClientID, rec.PacketID}); t.pending[key] == rec {
+					delete(t.pending, key)
+				}
+			}
+		}
+		tr.pending = 0
+		t.timedOut.Add(1)
+		t.finishLocked(tr)
+	}
+}
+
+// Stats returns the counters of the tracer
+func (t *FanoutTracer) Stats() FanoutTraceStats {
+	t.mu.Lock()
+	open := len(t.open)
+	t.mu.Unlock()
+	return FanoutTraceStats{
+		Sampled:  t.sampled.Load(),
+		Skipped:  t.skipped.Load(),
+		Exported: t.exported.Load(),
+		Dropped:  t.dropped.Load(),
+		TimedOut: t.timedOut.Load(),
+		Open:     open,
+	}
+}
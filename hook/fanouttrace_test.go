@@ -0,0 +1,182 @@
+package hook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tracedContext(topicName string, qos byte) *PublishContext {
+	return NewPublishContext(context.Background(), &Client{ID: "pub"}, &PublishPacket{Topic: topicName, QoS: qos, Payload: []byte("hello")})
+}
+
+// settled returns the next trace handed to Run
+func settled(t *testing.T, tracer *FanoutTracer) *FanoutTrace {
+	select {
+	case tr := <-tracer.exports:
+		return tr
+	default:
+		t.Fatal("no finished trace")
+		return nil
+	}
+}
+
+func TestFanoutTracerSampling(t *testing.T) {
+	var nilTracer *FanoutTracer
+	assert.Nil(t, nilTracer.Start(tracedContext("a", 0)))
+	nilTracer.Finish(nil)
+	nilTracer.AckedPacket("c1", 1)
+
+	assert.Nil(t, NewFanoutTracer(FanoutTraceConfig{}).Start(tracedContext("a", 0)))
+
+	tracer := NewFanoutTracer(FanoutTraceConfig{SampleRate: 1})
+	tr := tracer.Start(tracedContext("a/b", 1))
+	require.NotNil(t, tr)
+	assert.Len(t, tr.ID, 32)
+	assert.Equal(t, "a/b", tr.Topic)
+	assert.Equal(t, "pub", tr.Publisher)
+	assert.Equal(t, 5, tr.PayloadSize)
+
+	pc := tracedContext("traces", 0)
+	pc.Packet.Origin = FanoutTraceOrigin
+	assert.Nil(t, tracer.Start(pc), "exported traces are not traced")
+
+	tracer = NewFanoutTracer(FanoutTraceConfig{Sample: func(pc *PublishContext) bool { return pc.Packet.Topic == "debug" }})
+	assert.Nil(t, tracer.Start(tracedContext("other", 0)))
+	assert.NotNil(t, tracer.Start(tracedContext("debug", 0)))
+	assert.Equal(t, uint64(1), tracer.Stats().Sampled)
+}
+
+func TestFanoutTracerDeliveryMap(t *testing.T) {
+	now := time.Unix(100, 0)
+	tracer := NewFanoutTracer(FanoutTraceConfig{SampleRate: 1})
+	tracer.now = func() time.Time { return now }
+	tick := func() { now = now.Add(time.Millisecond) }
+
+	tr := tracer.Start(tracedContext("a/b", 1))
+	require.NotNil(t, tr)
+	tracer.Routed(tr, 3)
+	tick()
+	fast := tracer.Enqueued(tr, &Subscription{ClientID: "c1", TopicFilter: "a/+"}, 1, 7)
+	slow := tracer.Enqueued(tr, &Subscription{ClientID: "c2", SubscriptionIdentifier: 4}, 0, 0)
+	gone := tracer.Enqueued(tr, &Subscription{ClientID: "c3"}, 1, 8)
+	tick()
+	tracer.Flushed(fast, nil)
+	tracer.Flushed(slow, nil)
+	tracer.Flushed(gone, errors.New("closed"))
+	tracer.Finish(tr)
+	assert.Equal(t, 1, tracer.Stats().Open, "waiting for the acknowledgement of c1")
+
+	// A stale acknowledgement for another flow changes nothing
+	tracer.AckedPacket("c2", 7)
+	tick()
+	tracer.AckedPacket("c1", 7)
+	tracer.Acked(slow)
+
+	got := settled(t, tracer)
+	assert.Same(t, tr, got)
+	assert.Equal(t, 3, got.Matched)
+	assert.Equal(t, time.Unix(100, 0), got.Routed)
+	assert.Equal(t, now, got.Finished)
+	assert.Equal(t, DeliveryAcked, fast.Outcome)
+	assert.Equal(t, time.Unix(100, 0).Add(2*time.Millisecond), fast.Flushed)
+	assert.Equal(t, now, fast.Acked)
+	assert.Equal(t, DeliveryFlushed, slow.Outcome)
+	assert.True(t, slow.Acked.IsZero(), "QoS 0 copies are never acknowledged")
+	assert.Equal(t, DeliveryFailed, gone.Outcome)
+	assert.Zero(t, tracer.Stats().Open)
+	assert.Empty(t, tracer.pending)
+}
+
+func TestFanoutTracerAckTimeout(t *testing.T) {
+	now := time.Unix(100, 0)
+	tracer := NewFanoutTracer(FanoutTraceConfig{SampleRate: 1, AckTimeout: time.Second})
+	tracer.now = func() time.Time { return now }
+
+	tr := tracer.Start(tracedContext("a", 2))
+	rec := tracer.Enqueued(tr, &Subscription{ClientID: "c1"}, 2, 1)
+	tracer.Flushed(rec, nil)
+	tracer.Finish(tr)
+
+	tracer.expire()
+	assert.Equal(t, 1, tracer.Stats().Open)
+
+	now = now.Add(2 * time.Second)
+	tracer.expire()
+	assert.Same(t, tr, settled(t, tracer))
+	assert.Equal(t, DeliveryAckTimeout, rec.Outcome)
+	assert.Equal(t, uint64(1), tracer.Stats().TimedOut)
+
+	// A late acknowledgement is ignored
+	tracer.AckedPacket("c1", 1)
+	assert.True(t, rec.Acked.IsZero())
+}
+
+func TestFanoutTracerLimits(t *testing.T) {
+	tracer := NewFanoutTracer(FanoutTraceConfig{SampleRate: 1, MaxOpen: 1, ExportQueue: 1})
+
+	open := tracer.Start(tracedContext("a", 1))
+	tracer.Enqueued(open, &Subscription{ClientID: "c1"}, 1, 1)
+	tracer.Finish(open)
+	assert.Nil(t, tracer.Start(tracedContext("a", 1)), "sampling pauses while too many traces are open")
+	tracer.AckedPacket("c1", 1)
+
+	tracer.Finish(tracer.Start(tracedContext("b", 0)))
+	stats := tracer.Stats()
+	assert.Equal(t, uint64(1), stats.Skipped)
+	assert.Equal(t, uint64(1), stats.Dropped, "the export queue holds one trace")
+}
+
+func TestFanoutTracerRun(t *testing.T) {
+	var published []*PublishPacket
+	exported := make(chan *FanoutTrace, 1)
+	tracer := NewFanoutTracer(FanoutTraceConfig{
+		SampleRate: 1,
+		Exporters: []TraceExporter{
+			NewTopicTraceExporter("$ax/traces", func(_ context.Context, packet *PublishPacket) error {
+				published = append(published, packet)
+				return nil
+			}),
+			TraceExporterFunc(func(_ context.Context, tr *FanoutTrace) error {
+				exported <- tr
+				return errors.New("backend down")
+			}),
+		},
+		OnError: func(err error) { assert.EqualError(t, err, "backend down") },
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tracer.Run(ctx)
+
+	tr := tracer.Start(tracedContext("a/b", 0))
+	tracer.Flushed(tracer.Enqueued(tr, &Subscription{ClientID: "c1"}, 0, 0), nil)
+	tracer.Finish(tr)
+
+	select {
+	case got := <-exported:
+		assert.Equal(t, tr.ID, got.ID)
+		assert.NotSame(t, tr, got, "exporters get a copy")
+	case <-time.After(time.Second):
+		t.Fatal("trace not exported")
+	}
+	require.Len(t, published, 1)
+	assert.Equal(t, "$ax/traces", published[0].Topic)
+	assert.Equal(t, FanoutTraceOrigin, published[0].Origin)
+	assert.Equal(t, "application/json", published[0].Properties[encoding.PropContentType.String()])
+
+	var decoded map[string]any
+	require.NoError(t, json.Unmarshal(published[0].Payload, &decoded))
+	assert.Equal(t, tr.ID, decoded["id"])
+	deliveries := decoded["deliveries"].([]any)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, "flushed", deliveries[0].(map[string]any)["outcome"])
+	assert.NotContains(t, deliveries[0], "acked")
+	require.Eventually(t, func() bool { return tracer.Stats().Exported == 1 }, time.Second, time.Millisecond)
+}
@@ -0,0 +1,90 @@
+package hook
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/axmq/ax/topic"
+)
+
+// EventFilter restricts hook invocation to matching traffic
+// Topic filters use MQTT wildcard syntax, client ID patterns use path.Match glob syntax
+// Empty lists match everything; criteria that don't apply to an event are ignored
+type EventFilter struct {
+	TopicFilters []string
+	ClientIDs    []string
+	Normalize    topic.NormalizeOptions
+}
+
+// FilteredHook is implemented by hooks that scope events to matching traffic
+type FilteredHook interface {
+	// Filter returns the filter for the given event, or nil to receive all traffic
+	Filter(event Event) *EventFilter
+}
+
+// Validate returns ErrInvalidEventFilter for a malformed topic filter or client ID pattern
+func (f *EventFilter) Validate() error {
+	for _, filter := range f.TopicFilters {
+		if err := topic.ValidateTopicFilter(filter); err != nil {
+			return fmt.Errorf("%w: topic filter %q: %w", ErrInvalidEventFilter, filter, err)
+		}
+	}
+	for _, pattern := range f.ClientIDs {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%w: client id pattern %q: %w", ErrInvalidEventFilter, pattern, err)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the client ID and topic pass the filter
+// An empty topic or client ID means the event carries none and is not checked. Subscription events carry a
+// topic filter, which passes when it overlaps one of the filters: a hook scoped to telemetry/# sees a
+// subscription to # or +/temp. Matching fails closed, a malformed client ID pattern matches no client
+func (f *EventFilter) Matches(clientID, topicName string) bool {
+	if f == nil {
+		return true
+	}
+
+	if clientID != "" && len(f.ClientIDs) > 0 {
+		matched := false
+		for _, pattern := range f.ClientIDs {
+			if ok, _ := path.Match(pattern, clientID); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if topicName != "" && len(f.TopicFilters) > 0 {
+		isFilter := topic.IsSharedSubscription(topicName) || strings.ContainsAny(topicName, "+#")
+		for _, filter := range f.TopicFilters {
+			if isFilter && topic.FiltersOverlap(topic.Normalize(filter, f.Normalize), topic.Normalize(topicName, f.Normalize)) {
+				return true
+			}
+			if !isFilter && topic.MatchNormalized(filter, topicName, f.Normalize) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// provides checks whether a hook provides an event and wants to see the given traffic
+func provides(hook Hook, event Event, clientID, topicName string) bool {
+	if !hook.Provides(event) {
+		return false
+	}
+
+	fh, ok := hook.(FilteredHook)
+	if !ok {
+		return true
+	}
+	return fh.Filter(event).Matches(clientID, topicName)
+}
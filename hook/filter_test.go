@@ -0,0 +1,117 @@
+package hook
+
+import (
+	"testing"
+
+	"github.com/axmq/ax/topic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type countingPublishHook struct {
+	*Base
+	calls int
+}
+
+func (h *countingPublishHook) Provides(event Event) bool {
+	return event == OnPublish || event == OnConnect
+}
+
+func (h *countingPublishHook) OnPublish(_ *Client, _ *PublishPacket) error {
+	h.calls++
+	return nil
+}
+
+func (h *countingPublishHook) OnConnect(_ *Client, _ *ConnectPacket) error {
+	h.calls++
+	return nil
+}
+
+func TestEventFilterMatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   *EventFilter
+		clientID string
+		topic    string
+		expected bool
+	}{
+		{name: "nil filter", filter: nil, clientID: "c1", topic: "a/b", expected: true},
+		{name: "empty filter", filter: &EventFilter{}, clientID: "c1", topic: "a/b", expected: true},
+		{name: "topic match", filter: &EventFilter{TopicFilters: []string{"telemetry/#"}}, topic: "telemetry/d1/temp", expected: true},
+		{name: "topic mismatch", filter: &EventFilter{TopicFilters: []string{"telemetry/#"}}, topic: "heartbeat/d1", expected: false},
+		{name: "any topic filter", filter: &EventFilter{TopicFilters: []string{"a/+", "telemetry/#"}}, topic: "a/b", expected: true},
+		{name: "client glob match", filter: &EventFilter{ClientIDs: []string{"sensor-*"}}, clientID: "sensor-42", expected: true},
+		{name: "client glob mismatch", filter: &EventFilter{ClientIDs: []string{"sensor-*"}}, clientID: "gateway-1", expected: false},
+		{
+			name:     "both must match",
+			filter:   &EventFilter{TopicFilters: []string{"telemetry/#"}, ClientIDs: []string{"sensor-*"}},
+			clientID: "gateway-1",
+			topic:    "telemetry/x",
+			expected: false,
+		},
+		{name: "no topic on event", filter: &EventFilter{TopicFilters: []string{"telemetry/#"}}, clientID: "c1", expected: true},
+		{name: "no client on event", filter: &EventFilter{ClientIDs: []string{"sensor-*"}}, topic: "a", expected: true},
+		{name: "subscription overlaps", filter: &EventFilter{TopicFilters: []string{"telemetry/#"}}, topic: "#", expected: true},
+		{name: "subscription wildcard level", filter: &EventFilter{TopicFilters: []string{"telemetry/#"}}, topic: "+/d1/temp", expected: true},
+		{name: "shared subscription overlaps", filter: &EventFilter{TopicFilters: []string{"telemetry/#"}}, topic: "$share/g/telemetry/+", expected: true},
+		{name: "subscription disjoint", filter: &EventFilter{TopicFilters: []string{"telemetry/#"}}, topic: "heartbeat/+", expected: false},
+		{name: "bad client pattern fails closed", filter: &EventFilter{ClientIDs: []string{"sensor-["}}, clientID: "gateway-1", expected: false},
+		{
+			name:     "normalized topic match",
+			filter:   &EventFilter{TopicFilters: []string{"a/b/"}, Normalize: topic.NormalizeOptions{TrimTrailingSlash: true}},
+			topic:    "a/b",
+			expected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.filter.Matches(tt.clientID, tt.topic))
+		})
+	}
+}
+
+func TestBaseFilterRejectsMalformed(t *testing.T) {
+	base := NewHookBase("test")
+	assert.ErrorIs(t, base.SetFilter(OnConnect, &EventFilter{ClientIDs: []string{"sensor-["}}), ErrInvalidEventFilter)
+	assert.ErrorIs(t, base.SetFilter(OnPublish, &EventFilter{TopicFilters: []string{"a/#/b"}}), ErrInvalidEventFilter)
+	assert.Nil(t, base.Filter(OnConnect))
+	assert.Nil(t, base.Filter(OnPublish))
+}
+
+func TestBaseFilter(t *testing.T) {
+	base := NewHookBase("test")
+	assert.Nil(t, base.Filter(OnPublish))
+
+	filter := &EventFilter{TopicFilters: []string{"a/#"}}
+	require.NoError(t, base.SetFilter(OnPublish, filter))
+	assert.Same(t, filter, base.Filter(OnPublish))
+	assert.Nil(t, base.Filter(OnSubscribe))
+
+	require.NoError(t, base.SetFilter(OnPublish, nil))
+	assert.Nil(t, base.Filter(OnPublish))
+}
+
+func TestManagerFilteredDispatch(t *testing.T) {
+	manager := NewManager()
+	hook := &countingPublishHook{Base: NewHookBase("counting")}
+	require.NoError(t, hook.SetFilter(OnPublish, &EventFilter{TopicFilters: []string{"telemetry/#"}}))
+	require.NoError(t, hook.SetFilter(OnConnect, &EventFilter{ClientIDs: []string{"sensor-*"}}))
+	require.NoError(t, manager.Add(hook))
+
+	client := &Client{ID: "sensor-1"}
+	require.NoError(t, manager.OnPublish(client, &PublishPacket{Topic: "heartbeat/d1"}))
+	assert.Equal(t, 0, hook.calls)
+
+	require.NoError(t, manager.OnPublish(client, &PublishPacket{Topic: "telemetry/d1"}))
+	assert.Equal(t, 1, hook.calls)
+
+	require.NoError(t, manager.OnConnect(&Client{ID: "gateway-1"}, &ConnectPacket{}))
+	assert.Equal(t, 1, hook.calls)
+
+	require.NoError(t, manager.OnConnect(client, &ConnectPacket{}))
+	assert.Equal(t, 2, hook.calls)
+
+	require.NoError(t, manager.OnPublish(nil, nil))
+	assert.Equal(t, 3, hook.calls)
+}
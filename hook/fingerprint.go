@@ -0,0 +1,302 @@
+package hook
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/axmq/ax/encoding"
+)
+
+// Parts of a fingerprint reported by Fingerprint.Changes
+const (
+	FingerprintTLS      = "tls"
+	FingerprintMQTT     = "mqtt"
+	FingerprintClientID = "client_id"
+)
+
+// Fingerprint summarizes how a client connects, a sudden change for the same identity can mean stolen credentials
+type Fingerprint struct {
+	// TLS is the hash of the TLS ClientHello, see network.TLSFingerprint, empty for plain connections
+	TLS string
+	// MQTT is the hash of the protocol version, the CONNECT flags and the CONNECT properties present
+	MQTT string
+	// ClientIDEntropy is the Shannon entropy of the client ID in bits per character
+	ClientIDEntropy float64
+}
+
+// NewFingerprint builds the fingerprint of a CONNECT received over a connection with the given TLS fingerprint
+func NewFingerprint(tlsFingerprint string, packet *ConnectPacket) Fingerprint {
+	if packet == nil {
+		return Fingerprint{TLS: tlsFingerprint}
+	}
+	return Fingerprint{
+		TLS:             tlsFingerprint,
+		MQTT:            MQTTFingerprint(packet),
+		ClientIDEntropy: ClientIDEntropy(packet.ClientID),
+	}
+}
+
+// IsZero reports whether no fingerprint was collected
+func (f Fingerprint) IsZero() bool {
+	return f == Fingerprint{}
+}
+
+// Changes lists the parts of f that differ from prev, the client ID entropy counts as changed when it moves
+// by more than entropyDelta bits per character
+func (f Fingerprint) Changes(prev Fingerprint, entropyDelta float64) []string {
+	var changes []string
+	if f.TLS != prev.TLS {
+		changes = append(changes, FingerprintTLS)
+	}
+	if f.MQTT != prev.MQTT {
+		changes = append(changes, FingerprintMQTT)
+	}
+	if math.Abs(f.ClientIDEntropy-prev.ClientIDEntropy) > entropyDelta {
+		changes = append(changes, FingerprintClientID)
+	}
+	return changes
+}
+
+// MQTTFingerprint hashes which optional parts a client puts in its CONNECT, values that differ per connection
+// such as the client ID, credentials or property values are left out, user properties count by key
+func MQTTFingerprint(packet *ConnectPacket) string {
+	var b strings.Builder
+	b.WriteString(packet.ProtocolName)
+	b.WriteByte(';')
+	b.WriteString(strconv.Itoa(int(packet.ProtocolVersion)))
+	b.WriteByte(';')
+	b.WriteString(strconv.Itoa(int(packet.KeepAlive)))
+	b.WriteByte(';')
+	writeFlag(&b, packet.CleanStart)
+	writeFlag(&b, packet.Username != "")
+	writeFlag(&b, len(packet.Password) > 0)
+	writeFlag(&b, packet.Will != nil)
+	if packet.Will != nil {
+		b.WriteString(strconv.Itoa(int(packet.Will.QoS)))
+		writeFlag(&b, packet.Will.Retain)
+	}
+	b.WriteByte(';')
+
+	names := make([]string, 0, len(packet.Properties))
+	for name := range packet.Properties {
+		if name == encoding.PropUserProperty.String() {
+			continue
+		}
+		names = append(names, name)
+	}
+	for _, key := range userPropertyKeys(packet.Properties) {
+		names = append(names, "user:"+key)
+	}
+	slices.Sort(names)
+	names = slices.Compact(names)
+	b.WriteString(strings.Join(names, ","))
+
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:16])
+}
+
+func writeFlag(b *strings.Builder, set bool) {
+	if set {
+		b.WriteByte('1')
+	} else {
+		b.WriteByte('0')
+	}
+}
+
+func userPropertyKeys(props Properties) []string {
+	var keys []string
+	switch pairs := props[encoding.PropUserProperty.String()].(type) {
+	case []encoding.UTF8Pair:
+		for _, pair := range pairs {
+			keys = append(keys, pair.Key)
+		}
+	case map[string]string:
+		for key := range pairs {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// ClientIDEntropy returns the Shannon entropy of a client ID in bits per character
+// Generated IDs such as UUIDs score high, IDs derived from a serial number or a name score low
+func ClientIDEntropy(clientID string) float64 {
+	if clientID == "" {
+		return 0
+	}
+	// Counts are summed in order of first appearance so the same ID always yields the same float
+	index := make(map[rune]int)
+	var counts []int
+	total := 0
+	for _, r := range clientID {
+		i, ok := index[r]
+		if !ok {
+			i = len(counts)
+			index[r] = i
+			counts = append(counts, 0)
+		}
+		counts[i]++
+		total++
+	}
+
+	entropy := 0.0
+	for _, n := range counts {
+		p := float64(n) / float64(total)
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// FingerprintChange reports an identity connecting with a different fingerprint than before
+type FingerprintChange struct {
+	// Key is the username, or the client ID of clients without one
+	Key      string
+	Client   *Client
+	Previous Fingerprint
+	Current  Fingerprint
+	Changes  []string
+}
+
+// FingerprintConfig configures the fingerprint hook
+type FingerprintConfig struct {
+	// Reject refuses connections whose fingerprint changed, otherwise changes are only reported
+	Reject bool
+	// EntropyDelta is the client ID entropy change in bits per character that counts as a change
+	EntropyDelta float64
+	// MaxEntries bounds the number of identities remembered
+	MaxEntries int
+	// OnChange is called for every change, it must not block
+	OnChange func(FingerprintChange)
+}
+
+func DefaultFingerprintConfig() FingerprintConfig {
+	return FingerprintConfig{
+		EntropyDelta: 1,
+		MaxEntries:   100000,
+	}
+}
+
+// FingerprintStats holds the counters of a fingerprint hook
+type FingerprintStats struct {
+	Known    int
+	Changes  uint64
+	Rejected uint64
+}
+
+// FingerprintHook remembers the fingerprint each identity connects with and flags identities whose
+// fingerprint suddenly changes, e.g. credentials of a device reused from a laptop
+// The fingerprint is taken from Client.Fingerprint, or built from the CONNECT packet when it is not set
+type FingerprintHook struct {
+	*Base
+	config FingerprintConfig
+
+	mu    sync.Mutex
+	known map[string]Fingerprint
+
+	changes  atomic.Uint64
+	rejected atomic.Uint64
+}
+
+// NewFingerprintHook creates a fingerprint hook
+func NewFingerprintHook(config FingerprintConfig) *FingerprintHook {
+	return &FingerprintHook{
+		Base:   &Base{id: "fingerprint"},
+		config: config,
+		known:  make(map[string]Fingerprint),
+	}
+}
+
+// ID returns the hook identifier
+func (h *FingerprintHook) ID() string {
+	return h.id
+}
+
+// Provides indicates this hook inspects connects
+func (h *FingerprintHook) Provides(event Event) bool {
+	return event == OnConnect
+}
+
+// OnConnect compares the fingerprint of the client with the one its identity last connected with
+// A rejected fingerprint is not learned, the identity keeps its previous one
+func (h *FingerprintHook) OnConnect(client *Client, packet *ConnectPacket) error {
+	if client == nil {
+		return nil
+	}
+	if client.Fingerprint.IsZero() {
+		client.Fingerprint = NewFingerprint("", packet)
+	}
+	current := client.Fingerprint
+
+	key := client.Username
+	if key == "" {
+		key = client.ID
+	}
+
+	h.mu.Lock()
+	previous, ok := h.known[key]
+	var changes []string
+	if ok {
+		changes = current.Changes(previous, h.config.EntropyDelta)
+	}
+	reject := len(changes) > 0 && h.config.Reject
+	if !reject {
+		h.rememberLocked(key, current)
+	}
+	h.mu.Unlock()
+
+	if len(changes) == 0 {
+		return nil
+	}
+	h.changes.Add(1)
+	if h.config.OnChange != nil {
+		h.config.OnChange(FingerprintChange{Key: key, Client: client, Previous: previous, Current: current, Changes: changes})
+	}
+	if reject {
+		h.rejected.Add(1)
+		return ErrFingerprintChanged
+	}
+	return nil
+}
+
+func (h *FingerprintHook) rememberLocked(key string, fingerprint Fingerprint) {
+	if _, exists := h.known[key]; !exists && h.config.MaxEntries > 0 && len(h.known) >= h.config.MaxEntries {
+		for k := range h.known {
+			delete(h.known, k)
+			break
+		}
+	}
+	h.known[key] = fingerprint
+}
+
+// Known returns the fingerprint remembered for an identity
+func (h *FingerprintHook) Known(key string) (Fingerprint, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	f, ok := h.known[key]
+	return f, ok
+}
+
+// Forget drops the fingerprint of an identity, e.g. after a device was replaced, so the next one is learned
+func (h *FingerprintHook) Forget(key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.known, key)
+}
+
+// Stats returns the hook counters
+func (h *FingerprintHook) Stats() FingerprintStats {
+	h.mu.Lock()
+	known := len(h.known)
+	h.mu.Unlock()
+	return FingerprintStats{
+		Known:    known,
+		Changes:  h.changes.Load(),
+		Rejected: h.rejected.Load(),
+	}
+}
@@ -0,0 +1,147 @@
+package hook
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/axmq/ax/encoding"
+)
+
+func deviceConnect() *ConnectPacket {
+	return &ConnectPacket{
+		ProtocolName:    "MQTT",
+		ProtocolVersion: 5,
+		CleanStart:      true,
+		KeepAlive:       60,
+		ClientID:        "sensor-0042",
+		Username:        "sensor",
+		Password:        []byte("secret"),
+		Properties: Properties{
+			encoding.PropSessionExpiryInterval.String(): uint32(3600),
+			encoding.PropUserProperty.String():          []encoding.UTF8Pair{{Key: "fw", Value: "1.2"}},
+		},
+	}
+}
+
+func TestMQTTFingerprint(t *testing.T) {
+	base := MQTTFingerprint(deviceConnect())
+	assert.Len(t, base, 32)
+
+	// Per connection values do not change the fingerprint
+	same := deviceConnect()
+	same.ClientID = "sensor-0043"
+	same.Password = []byte("other")
+	same.Properties[encoding.PropSessionExpiryInterval.String()] = uint32(60)
+	same.Properties[encoding.PropUserProperty.String()] = []encoding.UTF8Pair{{Key: "fw", Value: "1.3"}}
+	assert.Equal(t, base, MQTTFingerprint(same))
+
+	tests := []struct {
+		name   string
+		modify func(*ConnectPacket)
+	}{
+		{"protocol version", func(p *ConnectPacket) { p.ProtocolVersion = 4 }},
+		{"keep alive", func(p *ConnectPacket) { p.KeepAlive = 30 }},
+		{"clean start", func(p *ConnectPacket) { p.CleanStart = false }},
+		{"will", func(p *ConnectPacket) { p.Will = &WillMessage{Topic: "t", QoS: 1} }},
+		{"property added", func(p *ConnectPacket) { p.Properties[encoding.PropReceiveMaximum.String()] = uint16(10) }},
+		{"user property key", func(p *ConnectPacket) {
+			p.Properties[encoding.PropUserProperty.String()] = []encoding.UTF8Pair{{Key: "tool", Value: "mqttx"}}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := deviceConnect()
+			tt.modify(p)
+			assert.NotEqual(t, base, MQTTFingerprint(p))
+		})
+	}
+}
+
+func TestClientIDEntropy(t *testing.T) {
+	assert.Zero(t, ClientIDEntropy(""))
+	assert.Zero(t, ClientIDEntropy("aaaa"))
+	assert.InDelta(t, 1.0, ClientIDEntropy("abab"), 1e-9)
+	assert.InDelta(t, 2.0, ClientIDEntropy("abcd"), 1e-9)
+	assert.Greater(t, ClientIDEntropy("3f2b8c1e-9d4a-4e7b-a6f0-51c2d8e9b703"), ClientIDEntropy("sensor-0042"))
+}
+
+func TestFingerprintChanges(t *testing.T) {
+	prev := Fingerprint{TLS: "a", MQTT: "m", ClientIDEntropy: 3}
+	assert.Empty(t, prev.Changes(prev, 1))
+	assert.Empty(t, Fingerprint{TLS: "a", MQTT: "m", ClientIDEntropy: 3.5}.Changes(prev, 1))
+
+	current := Fingerprint{TLS: "b", MQTT: "n", ClientIDEntropy: 4.5}
+	assert.Equal(t, []string{FingerprintTLS, FingerprintMQTT, FingerprintClientID}, current.Changes(prev, 1))
+	assert.True(t, Fingerprint{}.IsZero())
+	assert.False(t, prev.IsZero())
+}
+
+func TestFingerprintHook(t *testing.T) {
+	var reported []FingerprintChange
+	config := DefaultFingerprintConfig()
+	config.OnChange = func(change FingerprintChange) { reported = append(reported, change) }
+	h := NewFingerprintHook(config)
+
+	assert.Equal(t, "fingerprint", h.ID())
+	assert.True(t, h.Provides(OnConnect))
+	assert.False(t, h.Provides(OnPublish))
+
+	// Without a collected fingerprint it is built from the CONNECT packet
+	client := &Client{ID: "sensor-0042", Username: "sensor"}
+	require.NoError(t, h.OnConnect(client, deviceConnect()))
+	assert.Equal(t, NewFingerprint("", deviceConnect()), client.Fingerprint)
+	known, ok := h.Known("sensor")
+	require.True(t, ok)
+	assert.Equal(t, client.Fingerprint, known)
+
+	require.NoError(t, h.OnConnect(&Client{ID: "sensor-0042", Username: "sensor"}, deviceConnect()))
+	assert.Empty(t, reported)
+
+	// The same credentials from a different TLS stack are reported and learned
+	stolen := &Client{ID: "sensor-0042", Username: "sensor", Fingerprint: NewFingerprint("laptop", deviceConnect())}
+	require.NoError(t, h.OnConnect(stolen, deviceConnect()))
+	require.Len(t, reported, 1)
+	assert.Equal(t, "sensor", reported[0].Key)
+	assert.Equal(t, []string{FingerprintTLS}, reported[0].Changes)
+	assert.Same(t, stolen, reported[0].Client)
+	known, _ = h.Known("sensor")
+	assert.Equal(t, "laptop", known.TLS)
+
+	assert.Equal(t, FingerprintStats{Known: 1, Changes: 1}, h.Stats())
+
+	h.Forget("sensor")
+	_, ok = h.Known("sensor")
+	assert.False(t, ok)
+}
+
+func TestFingerprintHookReject(t *testing.T) {
+	config := DefaultFingerprintConfig()
+	config.Reject = true
+	h := NewFingerprintHook(config)
+
+	// Clients without a username are tracked by client ID
+	device := NewFingerprint("device", deviceConnect())
+	require.NoError(t, h.OnConnect(&Client{ID: "c1", Fingerprint: device}, nil))
+
+	err := h.OnConnect(&Client{ID: "c1", Fingerprint: NewFingerprint("laptop", deviceConnect())}, nil)
+	assert.ErrorIs(t, err, ErrFingerprintChanged)
+
+	// The rejected fingerprint is not learned
+	known, _ := h.Known("c1")
+	assert.Equal(t, device, known)
+	require.NoError(t, h.OnConnect(&Client{ID: "c1", Fingerprint: device}, nil))
+	assert.Equal(t, FingerprintStats{Known: 1, Changes: 1, Rejected: 1}, h.Stats())
+	assert.NoError(t, h.OnConnect(nil, nil))
+}
+
+func TestFingerprintHookMaxEntries(t *testing.T) {
+	h := NewFingerprintHook(FingerprintConfig{EntropyDelta: 1, MaxEntries: 2})
+	for _, id := range []string{"a", "b", "c"} {
+		require.NoError(t, h.OnConnect(&Client{ID: id}, deviceConnect()))
+	}
+	assert.Equal(t, 2, h.Stats().Known)
+	_, ok := h.Known("c")
+	assert.True(t, ok)
+}
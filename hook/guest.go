@@ -0,0 +1,402 @@
+package hook
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/session"
+	"github.com/axmq/ax/store"
+	"github.com/axmq/ax/topic"
+)
+
+const (
+	// GuestMetadataKey is the client metadata key set to "true" on guest sessions
+	GuestMetadataKey = "guest"
+
+	// DefaultGuestNamespace is the topic namespace of guests unless GuestConfig.Namespace is set
+	DefaultGuestNamespace = "provision/{clientid}/#"
+)
+
+// SessionLookup finds the stored session of a client, it is implemented by session.Manager
+type SessionLookup interface {
+	GetSession(ctx context.Context, clientID string) (*session.Session, error)
+}
+
+// GuestConfig restricts the sessions of guests
+type GuestConfig struct {
+	// Namespace lists the filters guests may publish and subscribe within, {clientid} is replaced by the client ID
+	Namespace []string
+	// MaxDuration ends guest sessions after this long, they are denied everything once it passed
+	MaxDuration time.Duration
+	// SessionExpiry overrides the session expiry interval requested by guests
+	SessionExpiry time.Duration
+	// MaxPublishes is the number of messages a guest may publish per session
+	MaxPublishes int
+	// MaxSubscriptions is the number of filters a guest may subscribe to at once
+	MaxSubscriptions int
+	// MaxPayloadSize is the largest payload a guest may publish, in bytes
+	MaxPayloadSize int
+	// AllowFailedCredentials admits clients whose credentials were refused as guests, only clients without
+	// credentials are admitted otherwise
+	AllowFailedCredentials bool
+	// ClientIDPrefix is the prefix guest client IDs must start with, choose one no registered client uses so a
+	// guest cannot connect with the client ID of a device and take over its session
+	ClientIDPrefix string
+	// Sessions refuses guests the client ID of a stored session that does not belong to a guest
+	Sessions SessionLookup
+	// OnExpired is called when a guest session reaches MaxDuration, e.g. to disconnect the client with
+	// ReasonMaximumConnectTime
+	OnExpired func(clientID string)
+	// Normalize canonicalizes topics and namespace filters before they are compared, set it to the options
+	// of the router so a guest cannot leave its namespace with a topic differing only in form
+	Normalize topic.NormalizeOptions
+}
+
+// DefaultGuestConfig returns a guest config suited to device provisioning
+func DefaultGuestConfig() GuestConfig {
+	return GuestConfig{
+		Namespace:        []string{DefaultGuestNamespace},
+		MaxDuration:      5 * time.Minute,
+		MaxPublishes:     10,
+		MaxSubscriptions: 4,
+		MaxPayloadSize:   4096,
+	}
+}
+
+// GuestStats holds the counters of a GuestHook
+type GuestStats struct {
+	Active   int
+	Admitted uint64
+	Denied   uint64
+	Expired  uint64
+}
+
+// guestSession tracks the quotas of a guest
+type guestSession struct {
+	client        *Client
+	deadline      time.Time
+	publishes     int
+	subscriptions map[string]struct{}
+	timer         *time.Timer
+}
+
+// GuestHook gives clients failing authentication a restricted guest session instead of refusing them
+// It wraps the authentication hook deciding for regular clients, since the manager refuses a client as soon as
+// one hook does. Guests are limited to a topic namespace and to quotas, publish and subscribe at QoS 0 only,
+// cannot retain messages or leave a will, and are denied everything after MaxDuration
+type GuestHook struct {
+	*Base
+	auth   Hook
+	config GuestConfig
+
+	mu       sync.Mutex
+	sessions map[string]*guestSession
+
+	admitted atomic.Uint64
+	denied   atomic.Uint64
+	expired  atomic.Uint64
+}
+
+// NewGuestHook creates a guest hook admitting clients refused by auth as guests
+// A nil auth admits clients with credentials as regular clients and clients without as guests
+func NewGuestHook(auth Hook, config GuestConfig) (*GuestHook, error) {
+	defaults := DefaultGuestConfig()
+	if len(config.Namespace) == 0 {
+		config.Namespace = defaults.Namespace
+	}
+	if config.MaxDuration <= 0 {
+		config.MaxDuration = defaults.MaxDuration
+	}
+	if config.MaxPublishes <= 0 {
+		config.MaxPublishes = defaults.MaxPublishes
+	}
+	if config.MaxSubscriptions <= 0 {
+		config.MaxSubscriptions = defaults.MaxSubscriptions
+	}
+	if config.MaxPayloadSize <= 0 {
+		config.MaxPayloadSize = defaults.MaxPayloadSize
+	}
+	for _, filter := range config.Namespace {
+		if err := topic.ValidateTopicFilter(strings.ReplaceAll(filter, "{clientid}", "id")); err != nil {
+			return nil, ErrInvalidGuestNamespace
+		}
+	}
+
+	return &GuestHook{
+		Base:     &Base{id: "guest"},
+		auth:     auth,
+		config:   config,
+		sessions: make(map[string]*guestSession),
+	}, nil
+}
+
+// ID returns the hook identifier
+func (h *GuestHook) ID() string {
+	return h.id
+}
+
+// Provides indicates this hook provides authentication, authorization and guest session handling
+func (h *GuestHook) Provides(event Event) bool {
+	switch event {
+	case OnConnectAuthenticate, OnACLCheck, OnConnect, OnDisconnect, OnSubscribe, OnUnsubscribed, OnPublish:
+		return true
+	}
+	return false
+}
+
+// Stop cancels the expiry timers of the guest sessions
+func (h *GuestHook) Stop() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, s := range h.sessions {
+		s.timer.Stop()
+		delete(h.sessions, id)
+	}
+	return nil
+}
+
+// IsGuest reports whether client was admitted as a guest
+func IsGuest(client *Client) bool {
+	return client != nil && client.Metadata[GuestMetadataKey] == "true"
+}
+
+// OnConnectAuthenticate admits clients accepted by the wrapped hook, and the others as guests when allowed
+func (h *GuestHook) OnConnectAuthenticate(client *Client, packet *ConnectPacket) bool {
+	if packet == nil {
+		return false
+	}
+
+	anonymous := packet.Username == "" && len(packet.Password) == 0
+	if h.auth == nil {
+		if !anonymous {
+			return true
+		}
+	} else if !h.auth.Provides(OnConnectAuthenticate) || h.auth.OnConnectAuthenticate(client, packet) {
+		return true
+	}
+
+	if !anonymous && !h.config.AllowFailedCredentials || !h.guestClientID(client.GetID()) {
+		h.denied.Add(1)
+		return false
+	}
+	client.SetMetadata(GuestMetadataKey, "true")
+	return true
+}
+
+// guestClientID reports whether a guest may connect with clientID, which must carry the guest prefix and
+// must not name the session of a registered client
+func (h *GuestHook) guestClientID(clientID string) bool {
+	if !strings.HasPrefix(clientID, h.config.ClientIDPrefix) {
+		return false
+	}
+	if h.config.Sessions == nil {
+		return true
+	}
+	existing, err := h.config.Sessions.GetSession(context.Background(), clientID)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return true
+	case err != nil:
+		return false
+	}
+	guest, _ := existing.GetMetadata(GuestMetadataKey)
+	return guest == "true"
+}
+
+// OnConnect starts the guest session, drops its will, forces a clean start and overrides its session expiry
+// interval, so a guest never resumes a stored session
+func (h *GuestHook) OnConnect(client *Client, packet *ConnectPacket) error {
+	if !IsGuest(client) {
+		return nil
+	}
+
+	client.Will = nil
+	client.CleanStart = true
+	if packet != nil {
+		packet.Will = nil
+		packet.CleanStart = true
+		if packet.Properties == nil {
+			packet.Properties = Properties{}
+		}
+		packet.Properties[encoding.PropSessionExpiryInterval.String()] = uint32(h.config.SessionExpiry / time.Second)
+	}
+
+	id := client.ID
+	s := &guestSession{
+		client:        client,
+		deadline:      time.Now().Add(h.config.MaxDuration),
+		subscriptions: make(map[string]struct{}),
+	}
+	s.timer = time.AfterFunc(h.config.MaxDuration, func() { h.expire(id, s) })
+
+	h.mu.Lock()
+	if old, ok := h.sessions[id]; ok {
+		old.timer.Stop()
+	}
+	h.sessions[id] = s
+	h.mu.Unlock()
+	h.admitted.Add(1)
+	return nil
+}
+
+// expire reports a guest session reaching its deadline
+func (h *GuestHook) expire(id string, s *guestSession) {
+	h.mu.Lock()
+	current := h.sessions[id] == s
+	h.mu.Unlock()
+	if !current {
+		return
+	}
+	h.expired.Add(1)
+	if h.config.OnExpired != nil {
+		h.config.OnExpired(id)
+	}
+}
+
+// OnDisconnect ends the guest session
+func (h *GuestHook) OnDisconnect(client *Client, _ *DisconnectInfo) error {
+	if !IsGuest(client) {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// The connection of a taken over session must not end the session of the new one
+	if s, ok := h.sessions[client.ID]; ok && s.client == client {
+		s.timer.Stop()
+		delete(h.sessions, client.ID)
+	}
+	return nil
+}
+
+// session returns the live session of a guest, nil once it expired
+func (h *GuestHook) session(client *Client) *guestSession {
+	s := h.sessions[client.ID]
+	if s == nil || !time.Now().Before(s.deadline) {
+		return nil
+	}
+	return s
+}
+
+// OnACLCheck keeps guests within their namespace until their session expires
+func (h *GuestHook) OnACLCheck(client *Client, topicName string, _ AccessType) bool {
+	if !IsGuest(client) {
+		return true
+	}
+
+	h.mu.Lock()
+	live := h.session(client) != nil
+	h.mu.Unlock()
+	return live && h.inNamespace(client.ID, topicName)
+}
+
+// inNamespace reports whether a topic or filter lies within a namespace filter of the guest
+// Client IDs that are not a single plain topic level would widen the namespace and match no {clientid} filter
+func (h *GuestHook) inNamespace(clientID, name string) bool {
+	plain := clientID != "" && !strings.ContainsAny(clientID, "/+#")
+	name = topic.Normalize(name, h.config.Normalize)
+	for _, filter := range h.config.Namespace {
+		if !plain && strings.Contains(filter, "{clientid}") {
+			continue
+		}
+		filter = topic.Normalize(strings.ReplaceAll(filter, "{clientid}", clientID), h.config.Normalize)
+		if filterCovers(filter, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterCovers reports whether every topic matched by name is matched by filter
+func filterCovers(filter, name string) bool {
+	f, n := strings.Split(filter, "/"), strings.Split(name, "/")
+	for i, level := range f {
+		if level == "#" {
+			return true
+		}
+		if i >= len(n) || n[i] == "#" {
+			return false
+		}
+		if level != "+" && level != n[i] {
+			return false
+		}
+	}
+	return len(f) == len(n)
+}
+
+// OnSubscribe downgrades guest subscriptions to QoS 0 and enforces the subscription quota
+func (h *GuestHook) OnSubscribe(client *Client, sub *Subscription) error {
+	if !IsGuest(client) || sub == nil {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.session(client)
+	if s == nil {
+		return ErrGuestSessionExpired
+	}
+	if _, ok := s.subscriptions[sub.TopicFilter]; !ok {
+		if len(s.subscriptions) >= h.config.MaxSubscriptions {
+			return ErrGuestQuotaExceeded
+		}
+		s.subscriptions[sub.TopicFilter] = struct{}{}
+	}
+	sub.QoS = 0
+	return nil
+}
+
+// OnUnsubscribed frees a subscription of the guest quota
+func (h *GuestHook) OnUnsubscribed(client *Client, topicFilter string) error {
+	if !IsGuest(client) {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.sessions[client.ID]; ok {
+		delete(s.subscriptions, topicFilter)
+	}
+	return nil
+}
+
+// OnPublish downgrades guest publishes to QoS 0 without retain and enforces the publish and payload quotas
+func (h *GuestHook) OnPublish(client *Client, packet *PublishPacket) error {
+	if !IsGuest(client) || packet == nil {
+		return nil
+	}
+	if len(packet.Payload) > h.config.MaxPayloadSize {
+		return ErrGuestQuotaExceeded
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.session(client)
+	if s == nil {
+		return ErrGuestSessionExpired
+	}
+	if s.publishes >= h.config.MaxPublishes {
+		return ErrGuestQuotaExceeded
+	}
+	s.publishes++
+	packet.QoS = 0
+	packet.Retain = false
+	return nil
+}
+
+// Stats returns the counters of the hook
+func (h *GuestHook) Stats() GuestStats {
+	h.mu.Lock()
+	active := len(h.sessions)
+	h.mu.Unlock()
+
+	return GuestStats{
+		Active:   active,
+		Admitted: h.admitted.Load(),
+		Denied:   h.denied.Load(),
+		Expired:  h.expired.Load(),
+	}
+}
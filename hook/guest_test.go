@@ -0,0 +1,200 @@
+package hook
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/session"
+	"github.com/axmq/ax/store"
+	"github.com/axmq/ax/topic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func connectGuest(t *testing.T, h *GuestHook, packet *ConnectPacket) *Client {
+	t.Helper()
+	client := &Client{ID: packet.ClientID}
+	require.True(t, h.OnConnectAuthenticate(client, packet))
+	require.NoError(t, h.OnConnect(client, packet))
+	return client
+}
+
+func TestGuestHookAuthenticate(t *testing.T) {
+	auth := NewBasicAuthHook()
+	auth.AddUser("alice", "secret")
+	h, err := NewGuestHook(auth, GuestConfig{})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = h.Stop() })
+
+	assert.Equal(t, "guest", h.ID())
+	assert.True(t, h.Provides(OnConnectAuthenticate))
+	assert.True(t, h.Provides(OnACLCheck))
+	assert.False(t, h.Provides(OnRetainMessage))
+
+	user := &Client{ID: "c1"}
+	assert.True(t, h.OnConnectAuthenticate(user, &ConnectPacket{Username: "alice", Password: []byte("secret")}))
+	assert.False(t, IsGuest(user))
+
+	assert.False(t, h.OnConnectAuthenticate(&Client{ID: "c2"}, &ConnectPacket{Username: "alice", Password: []byte("wrong")}))
+
+	guest := &Client{ID: "c3"}
+	assert.True(t, h.OnConnectAuthenticate(guest, &ConnectPacket{}))
+	assert.True(t, IsGuest(guest))
+
+	h.config.AllowFailedCredentials = true
+	failed := &Client{ID: "c4"}
+	assert.True(t, h.OnConnectAuthenticate(failed, &ConnectPacket{Username: "alice", Password: []byte("wrong")}))
+	assert.True(t, IsGuest(failed))
+	assert.Equal(t, uint64(1), h.Stats().Denied)
+
+	noAuth, err := NewGuestHook(nil, GuestConfig{})
+	require.NoError(t, err)
+	assert.True(t, noAuth.OnConnectAuthenticate(&Client{ID: "c5"}, &ConnectPacket{Username: "bob"}))
+
+	_, err = NewGuestHook(nil, GuestConfig{Namespace: []string{"a/#/b"}})
+	assert.ErrorIs(t, err, ErrInvalidGuestNamespace)
+}
+
+func TestGuestHookRestrictions(t *testing.T) {
+	h, err := NewGuestHook(nil, GuestConfig{
+		Namespace:        []string{DefaultGuestNamespace, "public/+/info"},
+		SessionExpiry:    30 * time.Second,
+		MaxPublishes:     2,
+		MaxSubscriptions: 1,
+		MaxPayloadSize:   8,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = h.Stop() })
+
+	packet := &ConnectPacket{ClientID: "dev1", Will: &WillMessage{Topic: "provision/dev1/will"}}
+	guest := connectGuest(t, h, packet)
+	assert.Nil(t, packet.Will)
+	assert.Equal(t, uint32(30), packet.Properties[encoding.PropSessionExpiryInterval.String()])
+
+	assert.True(t, h.OnACLCheck(guest, "provision/dev1/credentials", AccessTypeRead))
+	assert.True(t, h.OnACLCheck(guest, "provision/dev1/#", AccessTypeRead))
+	assert.True(t, h.OnACLCheck(guest, "public/eu/info", AccessTypeRead))
+	assert.False(t, h.OnACLCheck(guest, "provision/dev2/credentials", AccessTypeRead))
+	assert.False(t, h.OnACLCheck(guest, "provision/+/credentials", AccessTypeRead))
+	assert.False(t, h.OnACLCheck(guest, "public/#", AccessTypeRead))
+	assert.True(t, h.OnACLCheck(&Client{ID: "user"}, "anything", AccessTypeWrite))
+
+	// Wildcards in the client ID do not widen the namespace
+	wild := connectGuest(t, h, &ConnectPacket{ClientID: "#"})
+	assert.False(t, h.OnACLCheck(wild, "provision/other/credentials", AccessTypeRead))
+	assert.True(t, h.OnACLCheck(wild, "public/eu/info", AccessTypeRead))
+
+	sub := &Subscription{TopicFilter: "provision/dev1/#", QoS: 2}
+	require.NoError(t, h.OnSubscribe(guest, sub))
+	assert.Equal(t, byte(0), sub.QoS)
+	require.NoError(t, h.OnSubscribe(guest, &Subscription{TopicFilter: "provision/dev1/#", QoS: 1}))
+	assert.ErrorIs(t, h.OnSubscribe(guest, &Subscription{TopicFilter: "public/eu/info"}), ErrGuestQuotaExceeded)
+	require.NoError(t, h.OnUnsubscribed(guest, "provision/dev1/#"))
+	require.NoError(t, h.OnSubscribe(guest, &Subscription{TopicFilter: "public/eu/info"}))
+
+	publish := &PublishPacket{Topic: "provision/dev1/req", Payload: []byte("hi"), QoS: 1, Retain: true}
+	require.NoError(t, h.OnPublish(guest, publish))
+	assert.Equal(t, byte(0), publish.QoS)
+	assert.False(t, publish.Retain)
+	assert.ErrorIs(t, h.OnPublish(guest, &PublishPacket{Payload: []byte("too large!")}), ErrGuestQuotaExceeded)
+	require.NoError(t, h.OnPublish(guest, &PublishPacket{}))
+	assert.ErrorIs(t, h.OnPublish(guest, &PublishPacket{}), ErrGuestQuotaExceeded)
+
+	regular := &PublishPacket{QoS: 2, Retain: true, Payload: make([]byte, 64)}
+	require.NoError(t, h.OnPublish(&Client{ID: "user"}, regular))
+	assert.Equal(t, byte(2), regular.QoS)
+
+	stats := h.Stats()
+	assert.Equal(t, 2, stats.Active)
+	assert.Equal(t, uint64(2), stats.Admitted)
+
+	require.NoError(t, h.OnDisconnect(guest, &DisconnectInfo{}))
+	assert.Equal(t, 1, h.Stats().Active)
+}
+
+func TestGuestHookNormalize(t *testing.T) {
+	for _, normalize := range []bool{false, true} {
+		h, err := NewGuestHook(nil, GuestConfig{
+			Namespace: []string{"provision/{clientid}/req"},
+			Normalize: topic.NormalizeOptions{TrimTrailingSlash: normalize},
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = h.Stop() })
+
+		guest := connectGuest(t, h, &ConnectPacket{ClientID: "dev1"})
+		assert.Equal(t, normalize, h.OnACLCheck(guest, "provision/dev1/req/", AccessTypeWrite))
+		assert.False(t, h.OnACLCheck(guest, "provision/dev2/req/", AccessTypeWrite))
+	}
+}
+
+func TestGuestHookExpiry(t *testing.T) {
+	expired := make(chan string, 1)
+	h, err := NewGuestHook(nil, GuestConfig{
+		MaxDuration: 20 * time.Millisecond,
+		OnExpired:   func(clientID string) { expired <- clientID },
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = h.Stop() })
+
+	guest := connectGuest(t, h, &ConnectPacket{ClientID: "dev1"})
+	assert.True(t, h.OnACLCheck(guest, "provision/dev1/req", AccessTypeWrite))
+
+	select {
+	case id := <-expired:
+		assert.Equal(t, "dev1", id)
+	case <-time.After(5 * time.Second):
+		t.Fatal("guest session did not expire")
+	}
+	assert.False(t, h.OnACLCheck(guest, "provision/dev1/req", AccessTypeWrite))
+	assert.ErrorIs(t, h.OnPublish(guest, &PublishPacket{}), ErrGuestSessionExpired)
+	assert.ErrorIs(t, h.OnSubscribe(guest, &Subscription{TopicFilter: "provision/dev1/#"}), ErrGuestSessionExpired)
+	assert.Equal(t, uint64(1), h.Stats().Expired)
+}
+
+func TestGuestHookTakeover(t *testing.T) {
+	h, err := NewGuestHook(nil, GuestConfig{})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = h.Stop() })
+
+	old := connectGuest(t, h, &ConnectPacket{ClientID: "dev1"})
+	current := connectGuest(t, h, &ConnectPacket{ClientID: "dev1"})
+	require.NoError(t, h.OnDisconnect(old, &DisconnectInfo{}))
+	assert.True(t, h.OnACLCheck(current, "provision/dev1/req", AccessTypeWrite))
+	assert.Equal(t, 1, h.Stats().Active)
+}
+
+func TestGuestHookCannotTakeOverDeviceSession(t *testing.T) {
+	ctx := context.Background()
+	sessions := session.NewManager(session.ManagerConfig{Store: store.NewMemoryStore[*session.Session]()})
+	t.Cleanup(func() { _ = sessions.Close() })
+	_, _, err := sessions.CreateSession(ctx, "dev1", false, 3600, byte(encoding.ProtocolVersion50))
+	require.NoError(t, err)
+	guestSession, _, err := sessions.CreateSession(ctx, "guest-1", false, 3600, byte(encoding.ProtocolVersion50))
+	require.NoError(t, err)
+	guestSession.SetMetadata(GuestMetadataKey, "true")
+
+	h, err := NewGuestHook(nil, GuestConfig{Sessions: sessions})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = h.Stop() })
+
+	// the stored session of a device is not resumed nor taken over by a guest using its client ID
+	device := &Client{ID: "dev1"}
+	assert.False(t, h.OnConnectAuthenticate(device, &ConnectPacket{ClientID: "dev1"}))
+	assert.False(t, IsGuest(device))
+	assert.Equal(t, uint64(1), h.Stats().Denied)
+
+	// guests always start clean, also when reconnecting to a guest session
+	packet := &ConnectPacket{ClientID: "guest-1", CleanStart: false}
+	client := connectGuest(t, h, packet)
+	assert.True(t, packet.CleanStart)
+	assert.True(t, client.CleanStart)
+	connectGuest(t, h, &ConnectPacket{ClientID: "guest-2"})
+
+	prefixed, err := NewGuestHook(nil, GuestConfig{ClientIDPrefix: "guest-"})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = prefixed.Stop() })
+	assert.False(t, prefixed.OnConnectAuthenticate(&Client{ID: "dev1"}, &ConnectPacket{ClientID: "dev1"}))
+	assert.True(t, prefixed.OnConnectAuthenticate(&Client{ID: "guest-3"}, &ConnectPacket{ClientID: "guest-3"}))
+}
@@ -1,10 +1,12 @@
 package hook
 
 import (
+	"math"
 	"net"
 	"time"
 
 	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/session"
 )
 
 // Event represents hook event types
@@ -49,6 +51,11 @@ const (
 	StoredInflightMessages
 	StoredRetainedMessages
 	StoredSysInfo
+	OnSlowConsumer
+	OnSessionTakeover
+	OnClientRoamed
+	OnPublishDeliver
+	OnAckResponse
 )
 
 // String returns the string representation of the event
@@ -92,6 +99,11 @@ func (e Event) String() string {
 		"StoredInflightMessages",
 		"StoredRetainedMessages",
 		"StoredSysInfo",
+		"OnSlowConsumer",
+		"OnSessionTakeover",
+		"OnClientRoamed",
+		"OnPublishDeliver",
+		"OnAckResponse",
 	}
 	if e < Event(len(names)) {
 		return names[e]
@@ -141,8 +153,8 @@ type Hook interface {
 	// OnSessionEstablished is called after a session is established
 	OnSessionEstablished(client *Client, packet *ConnectPacket) error
 
-	// OnDisconnect is called when a client disconnects
-	OnDisconnect(client *Client, err error, expire bool) error
+	// OnDisconnect is called when a client disconnects, info describes who ended the connection and why
+	OnDisconnect(client *Client, info *DisconnectInfo) error
 
 	// OnAuthPacket is called when an AUTH packet is received (MQTT 5.0)
 	OnAuthPacket(client *Client, packet *AuthPacket) bool
@@ -174,7 +186,8 @@ type Hook interface {
 	// OnUnsubscribed is called after an unsubscription is completed
 	OnUnsubscribed(client *Client, topicFilter string) error
 
-	// OnPublish is called before publishing a message
+	// OnPublish is called before publishing a message, an error rejects it and is reported to the client
+	// with the reason code PublishAck derives from it, see RejectPublish
 	OnPublish(client *Client, packet *PublishPacket) error
 
 	// OnPublished is called after a message is published
@@ -227,6 +240,24 @@ type Hook interface {
 
 	// StoredSysInfo is called to store/load system info
 	StoredSysInfo() (*SysInfo, error)
+
+	// OnSlowConsumer is called when a client's outbound queue stays above the threshold
+	OnSlowConsumer(client *Client, info *SlowConsumerInfo) error
+
+	// OnSessionTakeover is called when a client connects with the ID of an existing session
+	// Hooks may change the decision to keep the existing connection or let the new one take over
+	OnSessionTakeover(client *Client, decision *TakeoverDecision) error
+
+	// OnClientRoamed is called after a client took over its session from a different listener or transport
+	OnClientRoamed(client *Client, info *RoamingInfo) error
+
+	// OnPublishDeliver is called for each copy of a message sent to a subscriber and returns the packet to send
+	// The packet is shared between subscribers, hooks that change it must return a modified copy
+	OnPublishDeliver(client *Client, packet *PublishPacket) *PublishPacket
+
+	// OnAckResponse is called before a PUBACK, PUBREC, SUBACK or UNSUBACK is sent to an MQTT 5 client
+	// Hooks may set the Reason String and add User Properties of the response
+	OnAckResponse(client *Client, ack *AckResponse) error
 }
 
 // Options holds the configuration options for the broker
@@ -284,6 +315,51 @@ type Client struct {
 	ConnectedAt     time.Time
 	DisconnectedAt  time.Time
 	State           ClientState
+	// Metadata holds arbitrary client attributes (e.g. tenant, firmware, region)
+	// typically set by auth hooks and persisted with the session
+	Metadata map[string]string
+	// Fingerprint describes how the client connected, for hooks detecting anomalies
+	Fingerprint Fingerprint
+	// Certificate is the fingerprint of the TLS client certificate, see network.Connection.CertificateFingerprint,
+	// empty without one
+	Certificate string
+}
+
+// GetID returns the client ID, or an empty string for a nil client
+func (c *Client) GetID() string {
+	if c == nil {
+		return ""
+	}
+	return c.ID
+}
+
+// SetMetadata sets a metadata value on the client
+func (c *Client) SetMetadata(key, value string) {
+	if c.Metadata == nil {
+		c.Metadata = make(map[string]string)
+	}
+	c.Metadata[key] = value
+}
+
+// ResponseLimits returns the restrictions the client placed on acknowledgement properties in its CONNECT
+func (c *Client) ResponseLimits() encoding.ResponseLimits {
+	limits := encoding.DefaultResponseLimits()
+	if c == nil {
+		return limits
+	}
+	if size, ok := c.Properties[encoding.PropMaximumPacketSize.String()].(uint32); ok {
+		limits.MaximumPacketSize = size
+	}
+	if request, ok := c.Properties[encoding.PropRequestProblemInformation.String()].(byte); ok {
+		limits.RequestProblemInfo = request != 0
+	}
+	return limits
+}
+
+// GetMetadata returns a metadata value from the client
+func (c *Client) GetMetadata(key string) (string, bool) {
+	value, ok := c.Metadata[key]
+	return value, ok
 }
 
 // ClientState represents the state of a client
@@ -332,6 +408,14 @@ type PublishPacket struct {
 	Origin          string
 }
 
+// GetTopic returns the topic, or an empty string for a nil packet
+func (p *PublishPacket) GetTopic() string {
+	if p == nil {
+		return ""
+	}
+	return p.Topic
+}
+
 // Subscription represents a client's subscription to a topic
 type Subscription struct {
 	ClientID               string
@@ -342,11 +426,23 @@ type Subscription struct {
 	RetainHandling         byte
 	SubscriptionIdentifier uint32
 	SubscribedAt           time.Time
+	Properties             Properties
+	TTL                    time.Duration // lease after which the subscription is removed if the client is inactive (0 = no lease)
+	LastValue              bool          // queue only the newest message per topic while the client is slow or offline
+}
+
+// GetTopicFilter returns the topic filter, or an empty string for a nil subscription
+func (s *Subscription) GetTopicFilter() string {
+	if s == nil {
+		return ""
+	}
+	return s.TopicFilter
 }
 
 // Subscribers holds a list of subscriptions for a topic
 type Subscribers struct {
 	Subscriptions []*Subscription
+	Packet        *PublishPacket // the publish being dispatched, when known
 }
 
 // Add adds a subscription to the list
@@ -385,6 +481,14 @@ type WillMessage struct {
 	WillDelayInterval uint32
 }
 
+// GetTopic returns the will topic, or an empty string for a nil will
+func (w *WillMessage) GetTopic() string {
+	if w == nil {
+		return ""
+	}
+	return w.Topic
+}
+
 // SessionState holds the state of a session
 type SessionState struct {
 	ClientID        string
@@ -417,11 +521,138 @@ type RetainedMessage struct {
 	QoS        byte
 	Properties Properties
 	Timestamp  time.Time
+	Node       string // cluster node that accepted the publish, breaks timestamp ties between replicas
+}
+
+// DisconnectInitiator tells which side ended a connection
+type DisconnectInitiator byte
+
+const (
+	DisconnectByClient  DisconnectInitiator = iota // the client sent DISCONNECT
+	DisconnectByServer                             // the broker closed the connection, e.g. keep alive timeout or takeover
+	DisconnectByNetwork                            // the connection dropped without a DISCONNECT
+)
+
+// String returns the string representation of the initiator
+func (d DisconnectInitiator) String() string {
+	switch d {
+	case DisconnectByClient:
+		return "client"
+	case DisconnectByServer:
+		return "server"
+	case DisconnectByNetwork:
+		return "network"
+	default:
+		return "unknown"
+	}
+}
+
+// DisconnectInfo describes how a connection ended and what it carried
+type DisconnectInfo struct {
+	Initiator DisconnectInitiator
+	// ReasonCode is the reason code of the DISCONNECT sent or received, or the code matching Err
+	ReasonCode encoding.ReasonCode
+	// Err is the error that ended the connection, nil for a normal disconnect
+	Err error
+	// Expire is set when the session is removed with the connection
+	Expire bool
+	// Duration is how long the connection lasted
+	Duration         time.Duration
+	BytesReceived    uint64
+	BytesSent        uint64
+	MessagesReceived uint64
+	MessagesSent     uint64
+}
+
+// Abnormal reports whether the connection ended without a normal DISCONNECT from the client
+// A client DISCONNECT with reason 0x04 (Disconnect with Will Message) is normal, WillPublished covers it
+func (i *DisconnectInfo) Abnormal() bool {
+	if i == nil {
+		return false
+	}
+	return i.Err != nil || i.Initiator != DisconnectByClient || i.ReasonCode.IsError()
+}
+
+// WillPublished reports whether the disconnect publishes the will message: after an abnormal disconnect
+// or when the client asked for it with reason 0x04
+func (i *DisconnectInfo) WillPublished() bool {
+	return i.Abnormal() || i != nil && i.ReasonCode == encoding.ReasonDisconnectWithWillMessage
+}
+
+// GetErr returns the error that ended the connection, or nil for a nil info
+func (i *DisconnectInfo) GetErr() error {
+	if i == nil {
+		return nil
+	}
+	return i.Err
+}
+
+// SlowConsumerInfo describes a client whose outbound queue is backing up
+type SlowConsumerInfo struct {
+	QueueDepth       int
+	OldestMessageAge time.Duration
+	Since            time.Time
+	Policy           string
+}
+
+// TakeoverDecision describes how a connection reusing an existing client ID is handled, OnSessionTakeover hooks
+// may change Reject and ReasonCode
+type TakeoverDecision = session.TakeoverDecision
+
+// RoamingInfo describes a client moving between listeners or transports, e.g. from LTE TCP to WiFi WebSocket
+type RoamingInfo struct {
+	PreviousListener   string
+	PreviousTransport  string
+	PreviousRemoteAddr string
+	Listener           string
+	Transport          string
+	RemoteAddr         string
+	InflightMessages   int // outbound QoS messages carried over to the new connection
 }
 
 // Properties is a map of key-value pairs for message properties
 type Properties map[string]any
 
+// UserProperty returns every value of the named user property, in the order received
+func (p Properties) UserProperty(key string) []string {
+	var values []string
+	switch props := p[encoding.PropUserProperty.String()].(type) {
+	case []encoding.UTF8Pair:
+		for _, pair := range props {
+			if pair.Key == key {
+				values = append(values, pair.Value)
+			}
+		}
+	case map[string]string:
+		if v, ok := props[key]; ok {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// Uint32 returns the named numeric property, e.g. the Message Expiry Interval. Stores decode the uint32 set by
+// the broker as uint64 (CBOR) or float64 (JSON), so every one of them is accepted when the value fits
+func (p Properties) Uint32(key string) (uint32, bool) {
+	switch v := p[key].(type) {
+	case uint32:
+		return v, true
+	case uint64:
+		if v <= math.MaxUint32 {
+			return uint32(v), true
+		}
+	case int64:
+		if v >= 0 && v <= math.MaxUint32 {
+			return uint32(v), true
+		}
+	case float64:
+		if v >= 0 && v <= math.MaxUint32 && v == math.Trunc(v) {
+			return uint32(v), true
+		}
+	}
+	return 0, false
+}
+
 // AccessType represents the type of access for ACL checks
 type AccessType byte
 
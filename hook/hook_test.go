@@ -1,10 +1,12 @@
 package hook
 
 import (
+	"errors"
 	"net"
 	"testing"
 	"time"
 
+	"github.com/axmq/ax/encoding"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -334,6 +336,34 @@ func TestDropReasonValues(t *testing.T) {
 	}
 }
 
+func TestDisconnectInitiatorString(t *testing.T) {
+	assert.Equal(t, "client", DisconnectByClient.String())
+	assert.Equal(t, "server", DisconnectByServer.String())
+	assert.Equal(t, "network", DisconnectByNetwork.String())
+	assert.Equal(t, "unknown", DisconnectInitiator(9).String())
+}
+
+func TestDisconnectInfoAbnormal(t *testing.T) {
+	var nilInfo *DisconnectInfo
+	assert.False(t, nilInfo.Abnormal())
+	assert.Nil(t, nilInfo.GetErr())
+
+	assert.False(t, (&DisconnectInfo{Initiator: DisconnectByClient}).Abnormal())
+	assert.False(t, (&DisconnectInfo{ReasonCode: encoding.ReasonDisconnectWithWillMessage}).Abnormal())
+	assert.True(t, (&DisconnectInfo{ReasonCode: encoding.ReasonDisconnectWithWillMessage}).WillPublished())
+	assert.False(t, (&DisconnectInfo{Initiator: DisconnectByClient}).WillPublished())
+	assert.True(t, (&DisconnectInfo{Initiator: DisconnectByNetwork}).WillPublished())
+	assert.False(t, nilInfo.WillPublished())
+	assert.True(t, (&DisconnectInfo{Initiator: DisconnectByClient, ReasonCode: encoding.ReasonPacketTooLarge}).Abnormal())
+	assert.True(t, (&DisconnectInfo{Initiator: DisconnectByServer, ReasonCode: encoding.ReasonSessionTakenOver}).Abnormal())
+	assert.True(t, (&DisconnectInfo{Initiator: DisconnectByNetwork}).Abnormal())
+
+	err := errors.New("reset")
+	info := &DisconnectInfo{Err: err}
+	assert.True(t, info.Abnormal())
+	assert.Equal(t, err, info.GetErr())
+}
+
 func TestEventValues(t *testing.T) {
 	events := []Event{
 		SetOptions,
@@ -468,3 +498,18 @@ func TestComplexScenario(t *testing.T) {
 	assert.Equal(t, "device/mqtt-client-123/status", client.Will.Topic)
 	assert.Equal(t, ClientStateConnected, client.State)
 }
+
+func TestClientMetadata(t *testing.T) {
+	client := &Client{ID: "client1"}
+
+	_, ok := client.GetMetadata("tenant")
+	assert.False(t, ok)
+
+	client.SetMetadata("tenant", "acme")
+	client.SetMetadata("firmware", "1.2.3")
+
+	value, ok := client.GetMetadata("tenant")
+	assert.True(t, ok)
+	assert.Equal(t, "acme", value)
+	assert.Len(t, client.Metadata, 2)
+}
@@ -0,0 +1,168 @@
+package hook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/store"
+)
+
+// inflightKeySep separates the client ID from the packet ID in store keys, MQTT strings cannot contain it
+// so the flows of one client never share a prefix with another client
+const inflightKeySep = "\x00"
+
+// InflightStoreConfig configures an InflightStoreHook
+type InflightStoreConfig struct {
+	GroupCommit store.GroupCommitConfig
+	// SyncPublish makes OnQosPublish wait for the commit holding a first send, so a message is never lost
+	// by a crash once it went out, at the cost of up to MaxLatency per publish. Acks and retries never wait
+	SyncPublish bool
+}
+
+// InflightStoreHook persists outbound QoS 1 and QoS 2 flows so a restarted broker can resume them
+// Updates go through a store.GroupCommitter, so acks and retries of all clients share one write per batch
+//
+// After a crash the store holds the flows as of the last commit. An ack lost with the last batch makes the
+// message be resent with DUP set, which QoS 1 allows and QoS 2 receivers dedupe by packet ID, a lost retry
+// only resets ResendCount. Without SyncPublish a message first sent in the last batch is not resent
+type InflightStoreHook struct {
+	*Base
+	store     store.Store[*InflightMessage]
+	committer *store.GroupCommitter[*InflightMessage]
+	config    InflightStoreConfig
+}
+
+// NewInflightStoreHook creates a hook persisting inflight messages in s, Stop commits the pending updates
+func NewInflightStoreHook(s store.Store[*InflightMessage], config InflightStoreConfig) *InflightStoreHook {
+	return &InflightStoreHook{
+		Base:      &Base{id: "inflight-store"},
+		store:     s,
+		committer: store.NewGroupCommitter(s, config.GroupCommit),
+		config:    config,
+	}
+}
+
+// ID returns the hook identifier
+func (h *InflightStoreHook) ID() string {
+	return h.id
+}
+
+// Provides indicates this hook tracks QoS flows and the sessions owning them
+func (h *InflightStoreHook) Provides(event Event) bool {
+	switch event {
+	case OnQosPublish, OnQosComplete, OnQosDropped, OnSessionEstablished, OnClientExpired, StoredInflightMessages:
+		return true
+	}
+	return false
+}
+
+func inflightKey(clientID string, packetID uint16) string {
+	return fmt.Sprintf("%s%s%04x", clientID, inflightKeySep, packetID)
+}
+
+// OnQosPublish records a sent or resent QoS message
+func (h *InflightStoreHook) OnQosPublish(client *Client, packet *PublishPacket, sent time.Time, resend int) error {
+	if packet.QoS == 0 {
+		return nil
+	}
+
+	err := h.committer.Save(inflightKey(client.ID, packet.PacketID), &InflightMessage{
+		PacketID:    packet.PacketID,
+		ClientID:    client.ID,
+		Topic:       packet.Topic,
+		Payload:     packet.Payload,
+		QoS:         packet.QoS,
+		Retain:      packet.Retain,
+		Duplicate:   resend > 0,
+		Properties:  packet.Properties,
+		Sent:        sent,
+		ResendCount: resend,
+	})
+	if err != nil || !h.config.SyncPublish || resend > 0 {
+		return err
+	}
+	return h.committer.Sync(context.Background())
+}
+
+// OnQosComplete forgets a flow finished by PUBACK or PUBCOMP, a PUBREC keeps it until the PUBCOMP
+func (h *InflightStoreHook) OnQosComplete(client *Client, packetID uint16, packetType encoding.PacketType) error {
+	if packetType != encoding.PUBACK && packetType != encoding.PUBCOMP {
+		return nil
+	}
+	return h.committer.Delete(inflightKey(client.ID, packetID))
+}
+
+// OnQosDropped forgets a flow the broker gave up on
+func (h *InflightStoreHook) OnQosDropped(client *Client, packetID uint16, _ DropReason) error {
+	return h.committer.Delete(inflightKey(client.ID, packetID))
+}
+
+// OnSessionEstablished forgets the flows of a previous session discarded by a clean start
+func (h *InflightStoreHook) OnSessionEstablished(client *Client, _ *ConnectPacket) error {
+	if !client.CleanStart {
+		return nil
+	}
+	return h.deleteClient(client.ID)
+}
+
+// OnClientExpired forgets the flows of an expired session
+func (h *InflightStoreHook) OnClientExpired(clientID string) error {
+	return h.deleteClient(clientID)
+}
+
+// deleteClient deletes the flows of the client through the write queue, so pending updates are discarded and
+// none queued before the delete brings a flow back
+func (h *InflightStoreHook) deleteClient(clientID string) error {
+	return h.committer.DeletePrefix(context.Background(), clientID+inflightKeySep)
+}
+
+// StoredInflightMessages commits the pending updates and returns every persisted flow in the order sent
+func (h *InflightStoreHook) StoredInflightMessages() ([]*InflightMessage, error) {
+	ctx := context.Background()
+	if err := h.committer.Flush(ctx); err != nil {
+		return nil, err
+	}
+
+	keys, err := h.store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]*InflightMessage, 0, len(keys))
+	for _, key := range keys {
+		if !strings.Contains(key, inflightKeySep) {
+			continue
+		}
+		msg, err := h.store.Load(ctx, key)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	slices.SortFunc(messages, func(a, b *InflightMessage) int {
+		return a.Sent.Compare(b.Sent)
+	})
+	return messages, nil
+}
+
+// Flush commits the pending updates now
+func (h *InflightStoreHook) Flush(ctx context.Context) error {
+	return h.committer.Flush(ctx)
+}
+
+// Stats returns the group commit counters
+func (h *InflightStoreHook) Stats() store.GroupCommitStats {
+	return h.committer.Stats()
+}
+
+// Stop commits the pending updates, the store is left open
+func (h *InflightStoreHook) Stop() error {
+	return h.committer.Close()
+}
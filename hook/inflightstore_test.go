@@ -0,0 +1,97 @@
+package hook
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInflightStoreHook_Provides(t *testing.T) {
+	h := NewInflightStoreHook(store.NewMemoryStore[*InflightMessage](), InflightStoreConfig{})
+	defer h.Stop()
+
+	assert.Equal(t, "inflight-store", h.ID())
+	assert.True(t, h.Provides(OnQosPublish))
+	assert.True(t, h.Provides(OnQosComplete))
+	assert.True(t, h.Provides(StoredInflightMessages))
+	assert.False(t, h.Provides(OnPublish))
+}
+
+func TestInflightStoreHook_Flows(t *testing.T) {
+	s := store.NewMemoryStore[*InflightMessage]()
+	h := NewInflightStoreHook(s, InflightStoreConfig{GroupCommit: store.GroupCommitConfig{MaxLatency: time.Hour}})
+	defer h.Stop()
+
+	c1 := &Client{ID: "c1"}
+	c2 := &Client{ID: "c1/x"}
+	sent := time.Now()
+	publish := func(client *Client, packetID uint16, qos byte, resend int) {
+		packet := &PublishPacket{PacketID: packetID, Topic: "t", Payload: []byte("p"), QoS: qos}
+		require.NoError(t, h.OnQosPublish(client, packet, sent.Add(time.Duration(packetID)), resend))
+	}
+
+	publish(c1, 1, 1, 0)
+	publish(c1, 2, 2, 0)
+	publish(c1, 3, 2, 0)
+	publish(c2, 4, 1, 0)
+	publish(c1, 5, 0, 0)
+	publish(c1, 2, 2, 1)
+	require.NoError(t, h.OnQosComplete(c1, 1, encoding.PUBACK))
+	require.NoError(t, h.OnQosComplete(c1, 2, encoding.PUBREC))
+	require.NoError(t, h.OnQosComplete(c1, 3, encoding.PUBCOMP))
+
+	// Every update above is still pending in a single batch
+	count, err := s.Count(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, count)
+
+	messages, err := h.StoredInflightMessages()
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, uint16(2), messages[0].PacketID)
+	assert.Equal(t, 1, messages[0].ResendCount)
+	assert.True(t, messages[0].Duplicate)
+	assert.Equal(t, "c1/x", messages[1].ClientID)
+	assert.Equal(t, uint64(1), h.Stats().Commits)
+
+	// Expiring c1 leaves the client whose ID it prefixes alone, and discards its pending updates
+	publish(c1, 6, 1, 0)
+	require.NoError(t, h.OnClientExpired("c1"))
+	messages, err = h.StoredInflightMessages()
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, uint16(4), messages[0].PacketID)
+
+	require.NoError(t, h.OnQosDropped(c2, 4, DropReasonExpired))
+	require.NoError(t, h.OnSessionEstablished(&Client{ID: "c3", CleanStart: true}, &ConnectPacket{}))
+	messages, err = h.StoredInflightMessages()
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+}
+
+func TestInflightStoreHook_SyncPublish(t *testing.T) {
+	s := store.NewMemoryStore[*InflightMessage]()
+	h := NewInflightStoreHook(s, InflightStoreConfig{
+		GroupCommit: store.GroupCommitConfig{MaxLatency: 5 * time.Millisecond},
+		SyncPublish: true,
+	})
+
+	// A first send is durable once OnQosPublish returns
+	packet := &PublishPacket{PacketID: 7, Topic: "t", QoS: 1}
+	require.NoError(t, h.OnQosPublish(&Client{ID: "c1"}, packet, time.Now(), 0))
+	exists, err := s.Exists(context.Background(), inflightKey("c1", 7))
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	// Stop commits the ack still pending
+	require.NoError(t, h.OnQosComplete(&Client{ID: "c1"}, 7, encoding.PUBACK))
+	require.NoError(t, h.Stop())
+	count, err := s.Count(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, count)
+}
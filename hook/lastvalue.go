@@ -0,0 +1,76 @@
+package hook
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/axmq/ax/topic"
+)
+
+// DefaultLastValueProperty is the SUBSCRIBE user property requesting a last value subscription, "true" or "false"
+const DefaultLastValueProperty = "last-value"
+
+// LastValueHook marks subscriptions as last value subscriptions, whose queued messages keep only the newest
+// message per topic, from a SUBSCRIBE user property or from broker filters. A subscription matches a broker
+// filter when its filter read as a topic name does, and the user property takes precedence over the filters
+type LastValueHook struct {
+	*Base
+	property string
+	filters  []string
+}
+
+// NewLastValueHook creates a last value hook applying to subscriptions matching filters
+func NewLastValueHook(filters ...string) (*LastValueHook, error) {
+	for _, filter := range filters {
+		if err := topic.ValidateTopicFilter(filter); err != nil {
+			return nil, fmt.Errorf("%w: filter %q: %v", ErrInvalidLastValue, filter, err)
+		}
+	}
+	return &LastValueHook{
+		Base:     &Base{id: "last-value"},
+		property: DefaultLastValueProperty,
+		filters:  append([]string(nil), filters...),
+	}, nil
+}
+
+// ID returns the hook identifier
+func (h *LastValueHook) ID() string {
+	return h.id
+}
+
+// Provides indicates this hook provides subscribe handling
+func (h *LastValueHook) Provides(event Event) bool {
+	return event == OnSubscribe
+}
+
+// OnSubscribe sets whether the subscription is a last value subscription
+func (h *LastValueHook) OnSubscribe(_ *Client, sub *Subscription) error {
+	if sub == nil {
+		return nil
+	}
+
+	values := sub.Properties.UserProperty(h.property)
+	if len(values) == 0 {
+		if h.matches(unsharedFilter(sub.TopicFilter)) {
+			sub.LastValue = true
+		}
+		return nil
+	}
+
+	lastValue, err := strconv.ParseBool(strings.TrimSpace(values[len(values)-1]))
+	if err != nil {
+		return fmt.Errorf("%w: %q", ErrInvalidLastValue, values[len(values)-1])
+	}
+	sub.LastValue = lastValue
+	return nil
+}
+
+func (h *LastValueHook) matches(filter string) bool {
+	for _, f := range h.filters {
+		if topic.MatchFilter(f, filter) {
+			return true
+		}
+	}
+	return false
+}
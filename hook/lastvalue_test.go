@@ -0,0 +1,44 @@
+package hook
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLastValueHook_OnSubscribe(t *testing.T) {
+	h, err := NewLastValueHook("dashboard/#")
+	require.NoError(t, err)
+	assert.True(t, h.Provides(OnSubscribe))
+	assert.False(t, h.Provides(OnPublish))
+
+	tests := []struct {
+		name    string
+		sub     *Subscription
+		want    bool
+		wantErr bool
+	}{
+		{name: "filter", sub: &Subscription{TopicFilter: "dashboard/+/temp"}, want: true},
+		{name: "shared filter", sub: &Subscription{TopicFilter: "$share/g/dashboard/cpu"}, want: true},
+		{name: "no filter", sub: &Subscription{TopicFilter: "logs/#"}},
+		{name: "property", sub: &Subscription{TopicFilter: "logs/#", Properties: userProperties("last-value", "true")}, want: true},
+		{name: "property overrides filter", sub: &Subscription{TopicFilter: "dashboard/x", Properties: userProperties("last-value", "false")}},
+		{name: "invalid", sub: &Subscription{TopicFilter: "logs/#", Properties: userProperties("last-value", "newest")}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := h.OnSubscribe(nil, tt.sub)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidLastValue)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, tt.sub.LastValue)
+		})
+	}
+
+	_, err = NewLastValueHook("a/#/b")
+	assert.ErrorIs(t, err, ErrInvalidLastValue)
+}
@@ -0,0 +1,132 @@
+package hook
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultLeaseProperty is the SUBSCRIBE user property requesting a subscription TTL,
+	// as a duration ("10m") or a number of seconds ("600")
+	DefaultLeaseProperty = "subscription-ttl"
+
+	// DefaultLeaseControlTopic is the topic expired subscriptions are announced on
+	DefaultLeaseControlTopic = "$SYS/subscriptions/expired/{clientid}"
+)
+
+// ControlPublisher publishes broker notifications on control topics
+type ControlPublisher interface {
+	PublishControl(topic string, payload []byte, retain bool) error
+}
+
+// LeaseExpiredMessage is the payload announcing an expired subscription
+type LeaseExpiredMessage struct {
+	ClientID    string `json:"clientid"`
+	TopicFilter string `json:"topic_filter"`
+	TTL         int64  `json:"ttl"`
+	Timestamp   int64  `json:"timestamp"`
+}
+
+// SubscriptionLeaseHook assigns TTLs to subscriptions, from a SUBSCRIBE user property or from
+// per-filter broker policies, and announces subscriptions the router expired on a control topic
+// Policies use the TTLPolicy matching of MessageTTLHook, the first matching filter wins
+type SubscriptionLeaseHook struct {
+	*Base
+	property  string
+	topic     string
+	maxTTL    time.Duration
+	policies  *MessageTTLHook
+	publisher ControlPublisher
+}
+
+// NewSubscriptionLeaseHook creates a subscription lease hook announcing expiries through publisher
+func NewSubscriptionLeaseHook(publisher ControlPublisher, policies ...TTLPolicy) (*SubscriptionLeaseHook, error) {
+	matcher, err := NewMessageTTLHook(policies...)
+	if err != nil {
+		return nil, err
+	}
+	return &SubscriptionLeaseHook{
+		Base:      &Base{id: "subscription-lease"},
+		property:  DefaultLeaseProperty,
+		topic:     DefaultLeaseControlTopic,
+		policies:  matcher,
+		publisher: publisher,
+	}, nil
+}
+
+// ID returns the hook identifier
+func (h *SubscriptionLeaseHook) ID() string {
+	return h.id
+}
+
+// Provides indicates this hook provides subscribe handling
+func (h *SubscriptionLeaseHook) Provides(event Event) bool {
+	return event == OnSubscribe
+}
+
+// SetMaxTTL caps TTLs requested by clients, zero leaves them uncapped
+func (h *SubscriptionLeaseHook) SetMaxTTL(ttl time.Duration) {
+	h.maxTTL = ttl
+}
+
+// SetControlTopic sets the announcement topic pattern, it may contain a {clientid} placeholder
+func (h *SubscriptionLeaseHook) SetControlTopic(pattern string) {
+	h.topic = pattern
+}
+
+// OnSubscribe sets the subscription TTL, a TTL requested by the client takes precedence over policies
+func (h *SubscriptionLeaseHook) OnSubscribe(_ *Client, sub *Subscription) error {
+	if sub == nil {
+		return nil
+	}
+
+	values := sub.Properties.UserProperty(h.property)
+	if len(values) == 0 {
+		sub.TTL, _ = h.policies.TTL(sub.TopicFilter)
+		return nil
+	}
+
+	ttl, err := parseLeaseTTL(values[len(values)-1])
+	if err != nil {
+		return err
+	}
+	if h.maxTTL > 0 && (ttl == 0 || ttl > h.maxTTL) {
+		ttl = h.maxTTL
+	}
+	sub.TTL = ttl
+	return nil
+}
+
+// parseLeaseTTL reads a duration or a number of seconds, zero requests no lease
+func parseLeaseTTL(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.ParseUint(value, 10, 32); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl < 0 {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidSubscriptionTTL, value)
+	}
+	return ttl, nil
+}
+
+// NotifyExpired announces that the router removed a subscription whose lease ran out
+func (h *SubscriptionLeaseHook) NotifyExpired(clientID, topicFilter string, ttl time.Duration) error {
+	if h.publisher == nil {
+		return nil
+	}
+
+	payload, err := json.Marshal(LeaseExpiredMessage{
+		ClientID:    clientID,
+		TopicFilter: topicFilter,
+		TTL:         int64(ttl / time.Second),
+		Timestamp:   time.Now().Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	return h.publisher.PublishControl(strings.ReplaceAll(h.topic, "{clientid}", clientID), payload, false)
+}
@@ -0,0 +1,74 @@
+package hook
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type controlRecorder struct {
+	topics   []string
+	payloads [][]byte
+}
+
+func (r *controlRecorder) PublishControl(topic string, payload []byte, _ bool) error {
+	r.topics = append(r.topics, topic)
+	r.payloads = append(r.payloads, payload)
+	return nil
+}
+
+func TestSubscriptionLeaseHook_OnSubscribe(t *testing.T) {
+	h, err := NewSubscriptionLeaseHook(nil, TTLPolicy{Filter: "ui/#", TTL: 10 * time.Minute})
+	require.NoError(t, err)
+	h.SetMaxTTL(time.Hour)
+	assert.True(t, h.Provides(OnSubscribe))
+
+	tests := []struct {
+		name    string
+		sub     *Subscription
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "policy", sub: &Subscription{TopicFilter: "ui/dashboard/+"}, want: 10 * time.Minute},
+		{name: "no policy", sub: &Subscription{TopicFilter: "other"}, want: 0},
+		{name: "seconds property", sub: &Subscription{TopicFilter: "ui/x", Properties: userProperties("subscription-ttl", "90")}, want: 90 * time.Second},
+		{name: "duration property", sub: &Subscription{TopicFilter: "other", Properties: userProperties("subscription-ttl", "5m")}, want: 5 * time.Minute},
+		{name: "capped", sub: &Subscription{TopicFilter: "other", Properties: userProperties("subscription-ttl", "24h")}, want: time.Hour},
+		{name: "invalid", sub: &Subscription{TopicFilter: "other", Properties: userProperties("subscription-ttl", "soon")}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := h.OnSubscribe(nil, tt.sub)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidSubscriptionTTL)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, tt.sub.TTL)
+		})
+	}
+}
+
+func TestSubscriptionLeaseHook_NotifyExpired(t *testing.T) {
+	recorder := &controlRecorder{}
+	h, err := NewSubscriptionLeaseHook(recorder)
+	require.NoError(t, err)
+
+	require.NoError(t, h.NotifyExpired("device-1", "ui/#", 10*time.Minute))
+	require.Len(t, recorder.topics, 1)
+	assert.Equal(t, "$SYS/subscriptions/expired/device-1", recorder.topics[0])
+
+	var msg LeaseExpiredMessage
+	require.NoError(t, json.Unmarshal(recorder.payloads[0], &msg))
+	assert.Equal(t, "device-1", msg.ClientID)
+	assert.Equal(t, "ui/#", msg.TopicFilter)
+	assert.Equal(t, int64(600), msg.TTL)
+
+	h.SetControlTopic("ops/leases")
+	require.NoError(t, h.NotifyExpired("device-2", "a", time.Second))
+	assert.Equal(t, "ops/leases", recorder.topics[1])
+}
@@ -1,27 +1,60 @@
 package hook
 
 import (
+	"context"
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/pkg/logger"
+	"github.com/axmq/ax/session"
 )
 
+// ManagerConfig configures the hooks manager
+type ManagerConfig struct {
+	Breaker BreakerConfig
+	Logger  logger.Logger
+}
+
 // Manager manages the registration and invocation of hooks
 type Manager struct {
-	mu       sync.Mutex
-	hooksPtr atomic.Pointer[[]Hook]
-	index    map[string]int
+	mu         sync.Mutex
+	entriesPtr atomic.Pointer[[]hookEntry]
+	index      map[string]int
+	config     ManagerConfig
+	ctx        context.Context // cancelled by Shutdown
+	cancel     context.CancelFunc
+	// calls counts the running context-aware invocations, Shutdown waits for idle to close once it drops to zero
+	// A counter rather than a lock lets a hook call back into the manager while Shutdown waits
+	callsMu sync.Mutex
+	calls   int
+	idle    chan struct{}
+}
+
+// hookEntry pairs a hook with its circuit breaker
+type hookEntry struct {
+	Hook
+	breaker *breaker
+}
+
+// NewManager creates a new hooks manager with the default breaker configuration
+func NewManager() *Manager {
+	return NewManagerWithConfig(ManagerConfig{Breaker: DefaultBreakerConfig()})
 }
 
-// NewManager creates a new hooks manager
-func NewManager() *Manager {
+// NewManagerWithConfig creates a new hooks manager with the given configuration
+func NewManagerWithConfig(config ManagerConfig) *Manager {
+	ctx, cancel := context.WithCancelCause(context.Background())
 	m := &Manager{
-		index: make(map[string]int),
+		index:  make(map[string]int),
+		config: config,
+		ctx:    ctx,
 	}
-	hooks := make([]Hook, 0)
-	m.hooksPtr.Store(&hooks)
+	m.cancel = func() { cancel(ErrManagerShutdown) }
+	entries := make([]hookEntry, 0)
+	m.entriesPtr.Store(&entries)
 	return m
 }
 
@@ -45,13 +78,13 @@ func (m *Manager) Add(hook Hook) error {
 	}
 
 	// Copy-on-write: create new slice with added hook
-	oldHooks := *m.hooksPtr.Load()
-	newHooks := make([]Hook, len(oldHooks)+1)
-	copy(newHooks, oldHooks)
-	newHooks[len(oldHooks)] = hook
+	oldEntries := *m.entriesPtr.Load()
+	newEntries := make([]hookEntry, len(oldEntries)+1)
+	copy(newEntries, oldEntries)
+	newEntries[len(oldEntries)] = hookEntry{Hook: hook, breaker: &breaker{}}
 
-	m.index[id] = len(oldHooks)
-	m.hooksPtr.Store(&newHooks)
+	m.index[id] = len(oldEntries)
+	m.entriesPtr.Store(&newEntries)
 
 	return nil
 }
@@ -68,19 +101,19 @@ func (m *Manager) Remove(id string) error {
 	}
 
 	// Copy-on-write: create new slice without removed hook
-	oldHooks := *m.hooksPtr.Load()
-	newHooks := make([]Hook, len(oldHooks)-1)
-	copy(newHooks[:idx], oldHooks[:idx])
-	copy(newHooks[idx:], oldHooks[idx+1:])
+	oldEntries := *m.entriesPtr.Load()
+	newEntries := make([]hookEntry, len(oldEntries)-1)
+	copy(newEntries[:idx], oldEntries[:idx])
+	copy(newEntries[idx:], oldEntries[idx+1:])
 
 	delete(m.index, id)
 
 	// Rebuild index for hooks after removed position
-	for i := idx; i < len(newHooks); i++ {
-		m.index[newHooks[i].ID()] = i
+	for i := idx; i < len(newEntries); i++ {
+		m.index[newEntries[i].ID()] = i
 	}
 
-	m.hooksPtr.Store(&newHooks)
+	m.entriesPtr.Store(&newEntries)
 
 	return nil
 }
@@ -95,22 +128,24 @@ func (m *Manager) Get(id string) (Hook, bool) {
 		return nil, false
 	}
 
-	hooks := *m.hooksPtr.Load()
-	return hooks[idx], true
+	entries := *m.entriesPtr.Load()
+	return entries[idx].Hook, true
 }
 
 // List returns a copy of all registered hooks
 func (m *Manager) List() []Hook {
-	hooks := *m.hooksPtr.Load()
-	result := make([]Hook, len(hooks))
-	copy(result, hooks)
+	entries := *m.entriesPtr.Load()
+	result := make([]Hook, len(entries))
+	for i, e := range entries {
+		result[i] = e.Hook
+	}
 	return result
 }
 
 // Count returns the number of registered hooks
 func (m *Manager) Count() int {
-	hooks := *m.hooksPtr.Load()
-	return len(hooks)
+	entries := *m.entriesPtr.Load()
+	return len(entries)
 }
 
 // Clear removes all hooks
@@ -118,23 +153,97 @@ func (m *Manager) Clear() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	oldHooks := *m.hooksPtr.Load()
-	for _, h := range oldHooks {
-		_ = h.Stop()
+	oldEntries := *m.entriesPtr.Load()
+	for _, e := range oldEntries {
+		_ = e.Stop()
 	}
 
-	newHooks := make([]Hook, 0)
-	m.hooksPtr.Store(&newHooks)
+	newEntries := make([]hookEntry, 0)
+	m.entriesPtr.Store(&newEntries)
 	m.index = make(map[string]int)
 }
 
+// Health returns the failure statistics of a hook
+func (m *Manager) Health(id string) (HookHealth, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	idx, exists := m.index[id]
+	if !exists {
+		return HookHealth{}, false
+	}
+
+	entries := *m.entriesPtr.Load()
+	return entries[idx].breaker.health(id), true
+}
+
+// HealthAll returns the failure statistics of all hooks
+func (m *Manager) HealthAll() []HookHealth {
+	entries := *m.entriesPtr.Load()
+	result := make([]HookHealth, len(entries))
+	for i, e := range entries {
+		result[i] = e.breaker.health(e.ID())
+	}
+	return result
+}
+
+// invoke calls fn with panic recovery, skipping the hook while its breaker is open
+// called is false if the hook was skipped
+func (m *Manager) invoke(e hookEntry, event Event, fn func() error) (called bool, err error) {
+	if !e.breaker.allow(m.config.Breaker, time.Now()) {
+		return false, nil
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %s %s: %v", ErrHookPanicked, e.ID(), event, r)
+			called = true
+			m.recordFailure(e, event, err, true)
+		}
+	}()
+
+	start := time.Now()
+	err = fn()
+	if timeout := m.config.Breaker.Timeout; timeout > 0 && time.Since(start) > timeout {
+		// The call already took effect, so its own result stands and only the breaker learns it was slow
+		slow := fmt.Errorf("%w: %s %s took longer than %s", ErrHookTimeout, e.ID(), event, timeout)
+		m.recordFailure(e, event, slow, false)
+		return true, err
+	}
+	switch {
+	case err != nil && m.config.Breaker.CountErrors:
+		m.recordFailure(e, event, err, false)
+	case err != nil:
+		// an uncounted error proves nothing about the health of a hook on trial
+		e.breaker.release()
+	default:
+		e.breaker.success()
+	}
+	return true, err
+}
+
+func (m *Manager) recordFailure(e hookEntry, event Event, err error, panicked bool) {
+	tripped := e.breaker.failure(m.config.Breaker, err, panicked, time.Now())
+	if m.config.Logger == nil {
+		return
+	}
+	if panicked {
+		m.config.Logger.Error("hook panicked", "hook", e.ID(), "event", event.String(), "error", err)
+	}
+	if tripped {
+		m.config.Logger.Warn("hook circuit breaker opened", "hook", e.ID(), "event", event.String(), "cooldown", m.config.Breaker.Cooldown)
+	}
+}
+
 // SetOptions invokes all SetOptions hooks
 func (m *Manager) SetOptions(opts *Options) error {
-	hooks := *m.hooksPtr.Load()
+	entries := *m.entriesPtr.Load()
 
-	for _, hook := range hooks {
+	for _, hook := range entries {
 		if hook.Provides(SetOptions) {
-			if err := hook.SetOptions(opts); err != nil {
+			if _, err := m.invoke(hook, SetOptions, func() error {
+				return hook.SetOptions(opts)
+			}); err != nil {
 				return err
 			}
 		}
@@ -144,44 +253,53 @@ func (m *Manager) SetOptions(opts *Options) error {
 
 // OnSysInfoTick invokes all OnSysInfoTick hooks
 func (m *Manager) OnSysInfoTick(info *SysInfo) {
-	hooks := *m.hooksPtr.Load()
+	entries := *m.entriesPtr.Load()
 
-	for _, hook := range hooks {
+	for _, hook := range entries {
 		if hook.Provides(OnSysInfoTick) {
-			_ = hook.OnSysInfoTick(info)
+			_, _ = m.invoke(hook, OnSysInfoTick, func() error {
+				return hook.OnSysInfoTick(info)
+			})
 		}
 	}
 }
 
 // OnStarted invokes all OnStarted hooks
 func (m *Manager) OnStarted() {
-	hooks := *m.hooksPtr.Load()
+	entries := *m.entriesPtr.Load()
 
-	for _, hook := range hooks {
+	for _, hook := range entries {
 		if hook.Provides(OnStarted) {
-			_ = hook.OnStarted()
+			_, _ = m.invoke(hook, OnStarted, hook.OnStarted)
 		}
 	}
 }
 
 // OnStopped invokes all OnStopped hooks
 func (m *Manager) OnStopped(err error) {
-	hooks := *m.hooksPtr.Load()
+	entries := *m.entriesPtr.Load()
 
-	for _, hook := range hooks {
+	for _, hook := range entries {
 		if hook.Provides(OnStopped) {
-			_ = hook.OnStopped(err)
+			_, _ = m.invoke(hook, OnStopped, func() error {
+				return hook.OnStopped(err)
+			})
 		}
 	}
 }
 
 // OnConnectAuthenticate invokes all OnConnectAuthenticate hooks
+// A hook that panics or whose breaker is open denies the connection
 func (m *Manager) OnConnectAuthenticate(client *Client, packet *ConnectPacket) bool {
-	hooks := *m.hooksPtr.Load()
-
-	for _, hook := range hooks {
-		if hook.Provides(OnConnectAuthenticate) {
-			if !hook.OnConnectAuthenticate(client, packet) {
+	entries := *m.entriesPtr.Load()
+
+	for _, hook := range entries {
+		if provides(hook.Hook, OnConnectAuthenticate, client.GetID(), "") {
+			allowed := false
+			if called, err := m.invoke(hook, OnConnectAuthenticate, func() error {
+				allowed = hook.OnConnectAuthenticate(client, packet)
+				return nil
+			}); err != nil || !called || !allowed {
 				return false
 			}
 		}
@@ -190,12 +308,17 @@ func (m *Manager) OnConnectAuthenticate(client *Client, packet *ConnectPacket) b
 }
 
 // OnACLCheck invokes all OnACLCheck hooks
+// A hook that panics or whose breaker is open denies access
 func (m *Manager) OnACLCheck(client *Client, topic string, access AccessType) bool {
-	hooks := *m.hooksPtr.Load()
-
-	for _, hook := range hooks {
-		if hook.Provides(OnACLCheck) {
-			if !hook.OnACLCheck(client, topic, access) {
+	entries := *m.entriesPtr.Load()
+
+	for _, hook := range entries {
+		if provides(hook.Hook, OnACLCheck, client.GetID(), topic) {
+			allowed := false
+			if called, err := m.invoke(hook, OnACLCheck, func() error {
+				allowed = hook.OnACLCheck(client, topic, access)
+				return nil
+			}); err != nil || !called || !allowed {
 				return false
 			}
 		}
@@ -205,11 +328,13 @@ func (m *Manager) OnACLCheck(client *Client, topic string, access AccessType) bo
 
 // OnConnect invokes all OnConnect hooks
 func (m *Manager) OnConnect(client *Client, packet *ConnectPacket) error {
-	hooks := *m.hooksPtr.Load()
+	entries := *m.entriesPtr.Load()
 
-	for _, hook := range hooks {
-		if hook.Provides(OnConnect) {
-			if err := hook.OnConnect(client, packet); err != nil {
+	for _, hook := range entries {
+		if provides(hook.Hook, OnConnect, client.GetID(), "") {
+			if _, err := m.invoke(hook, OnConnect, func() error {
+				return hook.OnConnect(client, packet)
+			}); err != nil {
 				return err
 			}
 		}
@@ -219,14 +344,17 @@ func (m *Manager) OnConnect(client *Client, packet *ConnectPacket) error {
 
 // OnSessionEstablish invokes all OnSessionEstablish hooks
 func (m *Manager) OnSessionEstablish(client *Client, packet *ConnectPacket) *SessionState {
-	hooks := *m.hooksPtr.Load()
+	entries := *m.entriesPtr.Load()
 
 	var state *SessionState
-	for _, hook := range hooks {
-		if hook.Provides(OnSessionEstablish) {
-			if s := hook.OnSessionEstablish(client, packet); s != nil {
-				state = s
-			}
+	for _, hook := range entries {
+		if provides(hook.Hook, OnSessionEstablish, client.GetID(), "") {
+			_, _ = m.invoke(hook, OnSessionEstablish, func() error {
+				if s := hook.OnSessionEstablish(client, packet); s != nil {
+					state = s
+				}
+				return nil
+			})
 		}
 	}
 	return state
@@ -234,11 +362,13 @@ func (m *Manager) OnSessionEstablish(client *Client, packet *ConnectPacket) *Ses
 
 // OnSessionEstablished invokes all OnSessionEstablished hooks
 func (m *Manager) OnSessionEstablished(client *Client, packet *ConnectPacket) error {
-	hooks := *m.hooksPtr.Load()
+	entries := *m.entriesPtr.Load()
 
-	for _, hook := range hooks {
-		if hook.Provides(OnSessionEstablished) {
-			if err := hook.OnSessionEstablished(client, packet); err != nil {
+	for _, hook := range entries {
+		if provides(hook.Hook, OnSessionEstablished, client.GetID(), "") {
+			if _, err := m.invoke(hook, OnSessionEstablished, func() error {
+				return hook.OnSessionEstablished(client, packet)
+			}); err != nil {
 				return err
 			}
 		}
@@ -246,24 +376,35 @@ func (m *Manager) OnSessionEstablished(client *Client, packet *ConnectPacket) er
 	return nil
 }
 
-// OnDisconnect invokes all OnDisconnect hooks
-func (m *Manager) OnDisconnect(client *Client, err error, expire bool) {
-	hooks := *m.hooksPtr.Load()
+// OnDisconnect invokes all OnDisconnect hooks, a nil info is passed to hooks as a normal client disconnect
+func (m *Manager) OnDisconnect(client *Client, info *DisconnectInfo) {
+	entries := *m.entriesPtr.Load()
+
+	if info == nil {
+		info = &DisconnectInfo{}
+	}
 
-	for _, hook := range hooks {
-		if hook.Provides(OnDisconnect) {
-			_ = hook.OnDisconnect(client, err, expire)
+	for _, hook := range entries {
+		if provides(hook.Hook, OnDisconnect, client.GetID(), "") {
+			_, _ = m.invoke(hook, OnDisconnect, func() error {
+				return hook.OnDisconnect(client, info)
+			})
 		}
 	}
 }
 
 // OnAuthPacket invokes all OnAuthPacket hooks
+// A hook that panics or whose breaker is open rejects the packet
 func (m *Manager) OnAuthPacket(client *Client, packet *AuthPacket) bool {
-	hooks := *m.hooksPtr.Load()
-
-	for _, hook := range hooks {
-		if hook.Provides(OnAuthPacket) {
-			if !hook.OnAuthPacket(client, packet) {
+	entries := *m.entriesPtr.Load()
+
+	for _, hook := range entries {
+		if provides(hook.Hook, OnAuthPacket, client.GetID(), "") {
+			allowed := false
+			if called, err := m.invoke(hook, OnAuthPacket, func() error {
+				allowed = hook.OnAuthPacket(client, packet)
+				return nil
+			}); err != nil || !called || !allowed {
 				return false
 			}
 		}
@@ -273,13 +414,18 @@ func (m *Manager) OnAuthPacket(client *Client, packet *AuthPacket) bool {
 
 // OnPacketRead invokes all OnPacketRead hooks
 func (m *Manager) OnPacketRead(client *Client, packet []byte) ([]byte, error) {
-	hooks := *m.hooksPtr.Load()
+	entries := *m.entriesPtr.Load()
 
-	var err error
 	result := packet
-	for _, hook := range hooks {
-		if hook.Provides(OnPacketRead) {
-			result, err = hook.OnPacketRead(client, result)
+	for _, hook := range entries {
+		if provides(hook.Hook, OnPacketRead, client.GetID(), "") {
+			_, err := m.invoke(hook, OnPacketRead, func() error {
+				modified, err := hook.OnPacketRead(client, result)
+				if err == nil {
+					result = modified
+				}
+				return err
+			})
 			if err != nil {
 				return nil, err
 			}
@@ -290,12 +436,15 @@ func (m *Manager) OnPacketRead(client *Client, packet []byte) ([]byte, error) {
 
 // OnPacketEncode invokes all OnPacketEncode hooks
 func (m *Manager) OnPacketEncode(client *Client, packet []byte) []byte {
-	hooks := *m.hooksPtr.Load()
+	entries := *m.entriesPtr.Load()
 
 	result := packet
-	for _, hook := range hooks {
-		if hook.Provides(OnPacketEncode) {
-			result = hook.OnPacketEncode(client, result)
+	for _, hook := range entries {
+		if provides(hook.Hook, OnPacketEncode, client.GetID(), "") {
+			_, _ = m.invoke(hook, OnPacketEncode, func() error {
+				result = hook.OnPacketEncode(client, result)
+				return nil
+			})
 		}
 	}
 	return result
@@ -303,33 +452,39 @@ func (m *Manager) OnPacketEncode(client *Client, packet []byte) []byte {
 
 // OnPacketSent invokes all OnPacketSent hooks
 func (m *Manager) OnPacketSent(client *Client, packet []byte, count int, err error) {
-	hooks := *m.hooksPtr.Load()
+	entries := *m.entriesPtr.Load()
 
-	for _, hook := range hooks {
-		if hook.Provides(OnPacketSent) {
-			_ = hook.OnPacketSent(client, packet, count, err)
+	for _, hook := range entries {
+		if provides(hook.Hook, OnPacketSent, client.GetID(), "") {
+			_, _ = m.invoke(hook, OnPacketSent, func() error {
+				return hook.OnPacketSent(client, packet, count, err)
+			})
 		}
 	}
 }
 
 // OnPacketProcessed invokes all OnPacketProcessed hooks
 func (m *Manager) OnPacketProcessed(client *Client, packetType encoding.PacketType, err error) {
-	hooks := *m.hooksPtr.Load()
+	entries := *m.entriesPtr.Load()
 
-	for _, hook := range hooks {
-		if hook.Provides(OnPacketProcessed) {
-			_ = hook.OnPacketProcessed(client, packetType, err)
+	for _, hook := range entries {
+		if provides(hook.Hook, OnPacketProcessed, client.GetID(), "") {
+			_, _ = m.invoke(hook, OnPacketProcessed, func() error {
+				return hook.OnPacketProcessed(client, packetType, err)
+			})
 		}
 	}
 }
 
 // OnSubscribe invokes all OnSubscribe hooks
 func (m *Manager) OnSubscribe(client *Client, sub *Subscription) error {
-	hooks := *m.hooksPtr.Load()
+	entries := *m.entriesPtr.Load()
 
-	for _, hook := range hooks {
-		if hook.Provides(OnSubscribe) {
-			if err := hook.OnSubscribe(client, sub); err != nil {
+	for _, hook := range entries {
+		if provides(hook.Hook, OnSubscribe, client.GetID(), sub.GetTopicFilter()) {
+			if _, err := m.invoke(hook, OnSubscribe, func() error {
+				return hook.OnSubscribe(client, sub)
+			}); err != nil {
 				return err
 			}
 		}
@@ -339,33 +494,39 @@ func (m *Manager) OnSubscribe(client *Client, sub *Subscription) error {
 
 // OnSubscribed invokes all OnSubscribed hooks
 func (m *Manager) OnSubscribed(client *Client, sub *Subscription) {
-	hooks := *m.hooksPtr.Load()
+	entries := *m.entriesPtr.Load()
 
-	for _, hook := range hooks {
-		if hook.Provides(OnSubscribed) {
-			_ = hook.OnSubscribed(client, sub)
+	for _, hook := range entries {
+		if provides(hook.Hook, OnSubscribed, client.GetID(), sub.GetTopicFilter()) {
+			_, _ = m.invoke(hook, OnSubscribed, func() error {
+				return hook.OnSubscribed(client, sub)
+			})
 		}
 	}
 }
 
 // OnSelectSubscribers invokes all OnSelectSubscribers hooks
 func (m *Manager) OnSelectSubscribers(subscribers *Subscribers, topic string) {
-	hooks := *m.hooksPtr.Load()
+	entries := *m.entriesPtr.Load()
 
-	for _, hook := range hooks {
-		if hook.Provides(OnSelectSubscribers) {
-			_ = hook.OnSelectSubscribers(subscribers, topic)
+	for _, hook := range entries {
+		if provides(hook.Hook, OnSelectSubscribers, "", topic) {
+			_, _ = m.invoke(hook, OnSelectSubscribers, func() error {
+				return hook.OnSelectSubscribers(subscribers, topic)
+			})
 		}
 	}
 }
 
 // OnUnsubscribe invokes all OnUnsubscribe hooks
 func (m *Manager) OnUnsubscribe(client *Client, topicFilter string) error {
-	hooks := *m.hooksPtr.Load()
+	entries := *m.entriesPtr.Load()
 
-	for _, hook := range hooks {
-		if hook.Provides(OnUnsubscribe) {
-			if err := hook.OnUnsubscribe(client, topicFilter); err != nil {
+	for _, hook := range entries {
+		if provides(hook.Hook, OnUnsubscribe, client.GetID(), topicFilter) {
+			if _, err := m.invoke(hook, OnUnsubscribe, func() error {
+				return hook.OnUnsubscribe(client, topicFilter)
+			}); err != nil {
 				return err
 			}
 		}
@@ -375,22 +536,26 @@ func (m *Manager) OnUnsubscribe(client *Client, topicFilter string) error {
 
 // OnUnsubscribed invokes all OnUnsubscribed hooks
 func (m *Manager) OnUnsubscribed(client *Client, topicFilter string) {
-	hooks := *m.hooksPtr.Load()
+	entries := *m.entriesPtr.Load()
 
-	for _, hook := range hooks {
-		if hook.Provides(OnUnsubscribed) {
-			_ = hook.OnUnsubscribed(client, topicFilter)
+	for _, hook := range entries {
+		if provides(hook.Hook, OnUnsubscribed, client.GetID(), topicFilter) {
+			_, _ = m.invoke(hook, OnUnsubscribed, func() error {
+				return hook.OnUnsubscribed(client, topicFilter)
+			})
 		}
 	}
 }
 
 // OnPublish invokes all OnPublish hooks
 func (m *Manager) OnPublish(client *Client, packet *PublishPacket) error {
-	hooks := *m.hooksPtr.Load()
+	entries := *m.entriesPtr.Load()
 
-	for _, hook := range hooks {
-		if hook.Provides(OnPublish) {
-			if err := hook.OnPublish(client, packet); err != nil {
+	for _, hook := range entries {
+		if provides(hook.Hook, OnPublish, client.GetID(), packet.GetTopic()) {
+			if _, err := m.invoke(hook, OnPublish, func() error {
+				return hook.OnPublish(client, packet)
+			}); err != nil {
 				return err
 			}
 		}
@@ -400,33 +565,44 @@ func (m *Manager) OnPublish(client *Client, packet *PublishPacket) error {
 
 // OnPublished invokes all OnPublished hooks
 func (m *Manager) OnPublished(client *Client, packet *PublishPacket) {
-	hooks := *m.hooksPtr.Load()
+	entries := *m.entriesPtr.Load()
 
-	for _, hook := range hooks {
-		if hook.Provides(OnPublished) {
-			_ = hook.OnPublished(client, packet)
+	for _, hook := range entries {
+		if provides(hook.Hook, OnPublished, client.GetID(), packet.GetTopic()) {
+			_, _ = m.invoke(hook, OnPublished, func() error {
+				return hook.OnPublished(client, packet)
+			})
 		}
 	}
 }
 
 // OnPublishDropped invokes all OnPublishDropped hooks
 func (m *Manager) OnPublishDropped(client *Client, packet *PublishPacket, reason DropReason) {
-	hooks := *m.hooksPtr.Load()
+	entries := *m.entriesPtr.Load()
 
-	for _, hook := range hooks {
-		if hook.Provides(OnPublishDropped) {
-			_ = hook.OnPublishDropped(client, packet, reason)
+	for _, hook := range entries {
+		if provides(hook.Hook, OnPublishDropped, client.GetID(), packet.GetTopic()) {
+			_, _ = m.invoke(hook, OnPublishDropped, func() error {
+				return hook.OnPublishDropped(client, packet, reason)
+			})
 		}
 	}
 }
 
 // OnRetainMessage invokes all OnRetainMessage hooks
+// The topic is validated first so invalid names never reach a retained store
 func (m *Manager) OnRetainMessage(client *Client, packet *PublishPacket) error {
-	hooks := *m.hooksPtr.Load()
+	if err := encoding.ValidateRetainTopicName(packet.GetTopic()); err != nil {
+		return err
+	}
+
+	entries := *m.entriesPtr.Load()
 
-	for _, hook := range hooks {
-		if hook.Provides(OnRetainMessage) {
-			if err := hook.OnRetainMessage(client, packet); err != nil {
+	for _, hook := range entries {
+		if provides(hook.Hook, OnRetainMessage, client.GetID(), packet.GetTopic()) {
+			if _, err := m.invoke(hook, OnRetainMessage, func() error {
+				return hook.OnRetainMessage(client, packet)
+			}); err != nil {
 				return err
 			}
 		}
@@ -436,69 +612,82 @@ func (m *Manager) OnRetainMessage(client *Client, packet *PublishPacket) error {
 
 // OnRetainPublished invokes all OnRetainPublished hooks
 func (m *Manager) OnRetainPublished(client *Client, packet *PublishPacket) {
-	hooks := *m.hooksPtr.Load()
+	entries := *m.entriesPtr.Load()
 
-	for _, hook := range hooks {
-		if hook.Provides(OnRetainPublished) {
-			_ = hook.OnRetainPublished(client, packet)
+	for _, hook := range entries {
+		if provides(hook.Hook, OnRetainPublished, client.GetID(), packet.GetTopic()) {
+			_, _ = m.invoke(hook, OnRetainPublished, func() error {
+				return hook.OnRetainPublished(client, packet)
+			})
 		}
 	}
 }
 
 // OnQosPublish invokes all OnQosPublish hooks
 func (m *Manager) OnQosPublish(client *Client, packet *PublishPacket, sent time.Time, resend int) {
-	hooks := *m.hooksPtr.Load()
+	entries := *m.entriesPtr.Load()
 
-	for _, hook := range hooks {
-		if hook.Provides(OnQosPublish) {
-			_ = hook.OnQosPublish(client, packet, sent, resend)
+	for _, hook := range entries {
+		if provides(hook.Hook, OnQosPublish, client.GetID(), packet.GetTopic()) {
+			_, _ = m.invoke(hook, OnQosPublish, func() error {
+				return hook.OnQosPublish(client, packet, sent, resend)
+			})
 		}
 	}
 }
 
 // OnQosComplete invokes all OnQosComplete hooks
 func (m *Manager) OnQosComplete(client *Client, packetID uint16, packetType encoding.PacketType) {
-	hooks := *m.hooksPtr.Load()
+	entries := *m.entriesPtr.Load()
 
-	for _, hook := range hooks {
-		if hook.Provides(OnQosComplete) {
-			_ = hook.OnQosComplete(client, packetID, packetType)
+	for _, hook := range entries {
+		if provides(hook.Hook, OnQosComplete, client.GetID(), "") {
+			_, _ = m.invoke(hook, OnQosComplete, func() error {
+				return hook.OnQosComplete(client, packetID, packetType)
+			})
 		}
 	}
 }
 
 // OnQosDropped invokes all OnQosDropped hooks
 func (m *Manager) OnQosDropped(client *Client, packetID uint16, reason DropReason) {
-	hooks := *m.hooksPtr.Load()
+	entries := *m.entriesPtr.Load()
 
-	for _, hook := range hooks {
-		if hook.Provides(OnQosDropped) {
-			_ = hook.OnQosDropped(client, packetID, reason)
+	for _, hook := range entries {
+		if provides(hook.Hook, OnQosDropped, client.GetID(), "") {
+			_, _ = m.invoke(hook, OnQosDropped, func() error {
+				return hook.OnQosDropped(client, packetID, reason)
+			})
 		}
 	}
 }
 
 // OnPacketIDExhausted invokes all OnPacketIDExhausted hooks
 func (m *Manager) OnPacketIDExhausted(client *Client, packetType encoding.PacketType) {
-	hooks := *m.hooksPtr.Load()
+	entries := *m.entriesPtr.Load()
 
-	for _, hook := range hooks {
-		if hook.Provides(OnPacketIDExhausted) {
-			_ = hook.OnPacketIDExhausted(client, packetType)
+	for _, hook := range entries {
+		if provides(hook.Hook, OnPacketIDExhausted, client.GetID(), "") {
+			_, _ = m.invoke(hook, OnPacketIDExhausted, func() error {
+				return hook.OnPacketIDExhausted(client, packetType)
+			})
 		}
 	}
 }
 
 // OnWill invokes all OnWill hooks
 func (m *Manager) OnWill(client *Client, will *WillMessage) *WillMessage {
-	hooks := *m.hooksPtr.Load()
+	entries := *m.entriesPtr.Load()
 
 	result := will
-	for _, hook := range hooks {
-		if hook.Provides(OnWill) {
-			if w := hook.OnWill(client, result); w != nil {
-				result = w
-			}
+	for _, hook := range entries {
+		if provides(hook.Hook, OnWill, client.GetID(), will.GetTopic()) {
+			_, _ = m.invoke(hook, OnWill, func() error {
+				if w := hook.OnWill(client, result); w != nil {
+					result = w
+				}
+				return nil
+			})
 		}
 	}
 	return result
@@ -506,44 +695,141 @@ func (m *Manager) OnWill(client *Client, will *WillMessage) *WillMessage {
 
 // OnWillSent invokes all OnWillSent hooks
 func (m *Manager) OnWillSent(client *Client, will *WillMessage) {
-	hooks := *m.hooksPtr.Load()
+	entries := *m.entriesPtr.Load()
 
-	for _, hook := range hooks {
-		if hook.Provides(OnWillSent) {
-			_ = hook.OnWillSent(client, will)
+	for _, hook := range entries {
+		if provides(hook.Hook, OnWillSent, client.GetID(), will.GetTopic()) {
+			_, _ = m.invoke(hook, OnWillSent, func() error {
+				return hook.OnWillSent(client, will)
+			})
 		}
 	}
 }
 
 // OnClientExpired invokes all OnClientExpired hooks
 func (m *Manager) OnClientExpired(clientID string) {
-	hooks := *m.hooksPtr.Load()
+	entries := *m.entriesPtr.Load()
 
-	for _, hook := range hooks {
-		if hook.Provides(OnClientExpired) {
-			_ = hook.OnClientExpired(clientID)
+	for _, hook := range entries {
+		if provides(hook.Hook, OnClientExpired, clientID, "") {
+			_, _ = m.invoke(hook, OnClientExpired, func() error {
+				return hook.OnClientExpired(clientID)
+			})
 		}
 	}
 }
 
 // OnRetainedExpired invokes all OnRetainedExpired hooks
 func (m *Manager) OnRetainedExpired(topic string) {
-	hooks := *m.hooksPtr.Load()
+	entries := *m.entriesPtr.Load()
+
+	for _, hook := range entries {
+		if provides(hook.Hook, OnRetainedExpired, "", topic) {
+			_, _ = m.invoke(hook, OnRetainedExpired, func() error {
+				return hook.OnRetainedExpired(topic)
+			})
+		}
+	}
+}
+
+// OnSlowConsumer invokes all OnSlowConsumer hooks
+func (m *Manager) OnSlowConsumer(client *Client, info *SlowConsumerInfo) {
+	entries := *m.entriesPtr.Load()
+
+	for _, hook := range entries {
+		if provides(hook.Hook, OnSlowConsumer, client.GetID(), "") {
+			_, _ = m.invoke(hook, OnSlowConsumer, func() error {
+				return hook.OnSlowConsumer(client, info)
+			})
+		}
+	}
+}
+
+// OnSessionTakeover invokes all OnSessionTakeover hooks, each one sees the decision left by the previous
+func (m *Manager) OnSessionTakeover(client *Client, decision *TakeoverDecision) {
+	entries := *m.entriesPtr.Load()
+
+	for _, hook := range entries {
+		if provides(hook.Hook, OnSessionTakeover, client.GetID(), "") {
+			_, _ = m.invoke(hook, OnSessionTakeover, func() error {
+				return hook.OnSessionTakeover(client, decision)
+			})
+		}
+	}
+}
+
+// OnClientRoamed invokes all OnClientRoamed hooks
+func (m *Manager) OnClientRoamed(client *Client, info *RoamingInfo) {
+	entries := *m.entriesPtr.Load()
+
+	for _, hook := range entries {
+		if provides(hook.Hook, OnClientRoamed, client.GetID(), "") {
+			_, _ = m.invoke(hook, OnClientRoamed, func() error {
+				return hook.OnClientRoamed(client, info)
+			})
+		}
+	}
+}
 
-	for _, hook := range hooks {
-		if hook.Provides(OnRetainedExpired) {
-			_ = hook.OnRetainedExpired(topic)
+// SessionRoamed runs the OnClientRoamed hooks for a session taken over from a different listener or transport,
+// set it as session.ManagerConfig.OnRoamed
+func (m *Manager) SessionRoamed(s *session.Session, decision *session.TakeoverDecision) {
+	client := &Client{ID: s.ClientID, Metadata: s.GetAllMetadata()}
+	m.OnClientRoamed(client, &RoamingInfo{
+		PreviousListener:   decision.Previous.Listener,
+		PreviousTransport:  decision.Previous.Transport,
+		PreviousRemoteAddr: decision.Previous.RemoteAddr,
+		Listener:           decision.Current.Listener,
+		Transport:          decision.Current.Transport,
+		RemoteAddr:         decision.Current.RemoteAddr,
+		InflightMessages:   len(s.GetAllPendingPublish()),
+	})
+}
+
+// OnPublishDeliver invokes all OnPublishDeliver hooks, each one receives the packet returned by the previous
+func (m *Manager) OnPublishDeliver(client *Client, packet *PublishPacket) *PublishPacket {
+	entries := *m.entriesPtr.Load()
+
+	result := packet
+	for _, hook := range entries {
+		if provides(hook.Hook, OnPublishDeliver, client.GetID(), result.GetTopic()) {
+			_, _ = m.invoke(hook, OnPublishDeliver, func() error {
+				if next := hook.OnPublishDeliver(client, result); next != nil {
+					result = next
+				}
+				return nil
+			})
+		}
+	}
+	return result
+}
+
+// OnAckResponse invokes all OnAckResponse hooks, a failing hook does not stop the acknowledgement
+func (m *Manager) OnAckResponse(client *Client, ack *AckResponse) {
+	entries := *m.entriesPtr.Load()
+
+	for _, hook := range entries {
+		if provides(hook.Hook, OnAckResponse, client.GetID(), "") {
+			_, _ = m.invoke(hook, OnAckResponse, func() error {
+				return hook.OnAckResponse(client, ack)
+			})
 		}
 	}
 }
 
 // StoredClients invokes all StoredClients hooks
 func (m *Manager) StoredClients() ([]*Client, error) {
-	hooks := *m.hooksPtr.Load()
+	entries := *m.entriesPtr.Load()
 
-	for _, hook := range hooks {
+	for _, hook := range entries {
 		if hook.Provides(StoredClients) {
-			return hook.StoredClients()
+			var result []*Client
+			if called, err := m.invoke(hook, StoredClients, func() (err error) {
+				result, err = hook.StoredClients()
+				return err
+			}); called {
+				return result, err
+			}
 		}
 	}
 	return nil, nil
@@ -551,11 +837,17 @@ func (m *Manager) StoredClients() ([]*Client, error) {
 
 // StoredSubscriptions invokes all StoredSubscriptions hooks
 func (m *Manager) StoredSubscriptions() ([]*Subscription, error) {
-	hooks := *m.hooksPtr.Load()
+	entries := *m.entriesPtr.Load()
 
-	for _, hook := range hooks {
+	for _, hook := range entries {
 		if hook.Provides(StoredSubscriptions) {
-			return hook.StoredSubscriptions()
+			var result []*Subscription
+			if called, err := m.invoke(hook, StoredSubscriptions, func() (err error) {
+				result, err = hook.StoredSubscriptions()
+				return err
+			}); called {
+				return result, err
+			}
 		}
 	}
 	return nil, nil
@@ -563,11 +855,17 @@ func (m *Manager) StoredSubscriptions() ([]*Subscription, error) {
 
 // StoredInflightMessages invokes all StoredInflightMessages hooks
 func (m *Manager) StoredInflightMessages() ([]*InflightMessage, error) {
-	hooks := *m.hooksPtr.Load()
+	entries := *m.entriesPtr.Load()
 
-	for _, hook := range hooks {
+	for _, hook := range entries {
 		if hook.Provides(StoredInflightMessages) {
-			return hook.StoredInflightMessages()
+			var result []*InflightMessage
+			if called, err := m.invoke(hook, StoredInflightMessages, func() (err error) {
+				result, err = hook.StoredInflightMessages()
+				return err
+			}); called {
+				return result, err
+			}
 		}
 	}
 	return nil, nil
@@ -575,11 +873,17 @@ func (m *Manager) StoredInflightMessages() ([]*InflightMessage, error) {
 
 // StoredRetainedMessages invokes all StoredRetainedMessages hooks
 func (m *Manager) StoredRetainedMessages() ([]*RetainedMessage, error) {
-	hooks := *m.hooksPtr.Load()
+	entries := *m.entriesPtr.Load()
 
-	for _, hook := range hooks {
+	for _, hook := range entries {
 		if hook.Provides(StoredRetainedMessages) {
-			return hook.StoredRetainedMessages()
+			var result []*RetainedMessage
+			if called, err := m.invoke(hook, StoredRetainedMessages, func() (err error) {
+				result, err = hook.StoredRetainedMessages()
+				return err
+			}); called {
+				return result, err
+			}
 		}
 	}
 	return nil, nil
@@ -587,11 +891,17 @@ func (m *Manager) StoredRetainedMessages() ([]*RetainedMessage, error) {
 
 // StoredSysInfo invokes all StoredSysInfo hooks
 func (m *Manager) StoredSysInfo() (*SysInfo, error) {
-	hooks := *m.hooksPtr.Load()
+	entries := *m.entriesPtr.Load()
 
-	for _, hook := range hooks {
+	for _, hook := range entries {
 		if hook.Provides(StoredSysInfo) {
-			return hook.StoredSysInfo()
+			var result *SysInfo
+			if called, err := m.invoke(hook, StoredSysInfo, func() (err error) {
+				result, err = hook.StoredSysInfo()
+				return err
+			}); called {
+				return result, err
+			}
 		}
 	}
 	return nil, nil
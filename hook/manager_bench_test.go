@@ -188,7 +188,7 @@ func BenchmarkManagerOnDisconnect(b *testing.B) {
 	b.ReportAllocs()
 
 	for i := 0; i < b.N; i++ {
-		m.OnDisconnect(client, nil, false)
+		m.OnDisconnect(client, &DisconnectInfo{Initiator: DisconnectByClient})
 	}
 }
 
@@ -351,7 +351,7 @@ func BenchmarkManagerMixedOperations(b *testing.B) {
 		_ = m.OnConnect(client, connectPacket)
 		_ = m.OnPublish(client, publishPacket)
 		_ = m.OnSubscribe(client, sub)
-		m.OnDisconnect(client, nil, false)
+		m.OnDisconnect(client, &DisconnectInfo{Initiator: DisconnectByClient})
 	}
 }
 
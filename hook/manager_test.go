@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/session"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -106,7 +107,7 @@ func (h *testHook) OnSessionEstablished(client *Client, packet *ConnectPacket) e
 	return nil
 }
 
-func (h *testHook) OnDisconnect(client *Client, err error, expire bool) error {
+func (h *testHook) OnDisconnect(client *Client, info *DisconnectInfo) error {
 	h.incrementCall("OnDisconnect")
 	return nil
 }
@@ -551,10 +552,46 @@ func TestManagerOnDisconnect(t *testing.T) {
 
 	client := &Client{ID: "client1"}
 
-	m.OnDisconnect(client, nil, false)
+	m.OnDisconnect(client, nil)
 	assert.Equal(t, 1, h.getCallCount("OnDisconnect"))
 }
 
+type disconnectRecorder struct {
+	*Base
+	infos []*DisconnectInfo
+}
+
+func (h *disconnectRecorder) Provides(event Event) bool {
+	return event == OnDisconnect
+}
+
+func (h *disconnectRecorder) OnDisconnect(_ *Client, info *DisconnectInfo) error {
+	h.infos = append(h.infos, info)
+	return nil
+}
+
+func TestManagerOnDisconnectInfo(t *testing.T) {
+	m := NewManager()
+	h := &disconnectRecorder{Base: &Base{id: "recorder"}}
+	require.NoError(t, m.Add(h))
+
+	info := &DisconnectInfo{
+		Initiator:        DisconnectByServer,
+		ReasonCode:       encoding.ReasonKeepAliveTimeout,
+		Duration:         time.Minute,
+		BytesReceived:    512,
+		MessagesReceived: 4,
+	}
+	m.OnDisconnect(&Client{ID: "c1"}, info)
+	m.OnDisconnect(&Client{ID: "c1"}, nil)
+
+	require.Len(t, h.infos, 2)
+	assert.Same(t, info, h.infos[0])
+	require.NotNil(t, h.infos[1], "hooks never see a nil info")
+	assert.Equal(t, DisconnectByClient, h.infos[1].Initiator)
+	assert.False(t, h.infos[1].Abnormal())
+}
+
 func TestManagerOnPacketRead(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -787,7 +824,7 @@ func TestManagerMultipleEventTypes(t *testing.T) {
 	err = m.OnSubscribe(client, sub)
 	assert.NoError(t, err)
 
-	m.OnDisconnect(client, nil, false)
+	m.OnDisconnect(client, nil)
 
 	assert.Equal(t, 1, h.getCallCount("OnConnect"))
 	assert.Equal(t, 1, h.getCallCount("OnPublish"))
@@ -892,7 +929,7 @@ func TestManagerEmptyHookList(t *testing.T) {
 	err := m.OnConnect(client, packet)
 	assert.NoError(t, err)
 
-	m.OnDisconnect(client, nil, false)
+	m.OnDisconnect(client, nil)
 }
 
 func TestManagerSetOptions(t *testing.T) {
@@ -942,6 +979,29 @@ func TestManagerRetainHooks(t *testing.T) {
 	assert.Equal(t, 1, h.getCallCount("OnRetainPublished"))
 }
 
+func TestManagerRetainMessageInvalidTopic(t *testing.T) {
+	m := NewManager()
+	h := newTestHook("retain", OnRetainMessage)
+	require.NoError(t, m.Add(h))
+
+	tests := []struct {
+		topic string
+		err   error
+	}{
+		{topic: "a/+/b", err: encoding.ErrInvalidPublishTopicName},
+		{topic: "a/#", err: encoding.ErrInvalidPublishTopicName},
+		{topic: "$share/g/a", err: encoding.ErrSharedPublishTopicName},
+		{topic: "", err: encoding.ErrInvalidTopicName},
+	}
+
+	for _, tt := range tests {
+		err := m.OnRetainMessage(&Client{ID: "client1"}, &PublishPacket{Topic: tt.topic, Retain: true})
+		assert.ErrorIs(t, err, tt.err)
+		assert.Equal(t, encoding.ReasonTopicNameInvalid, encoding.GetReasonCode(err))
+	}
+	assert.Equal(t, 0, h.getCallCount("OnRetainMessage"))
+}
+
 func TestManagerWillHooks(t *testing.T) {
 	m := NewManager()
 	h := newTestHook("will", OnWill, OnWillSent)
@@ -1237,3 +1297,174 @@ func TestManagerWithRealNetAddr(t *testing.T) {
 	err := m.OnConnect(client, packet)
 	assert.NoError(t, err)
 }
+
+type slowConsumerHook struct {
+	*Base
+	info *SlowConsumerInfo
+}
+
+func (h *slowConsumerHook) Provides(event Event) bool {
+	return event == OnSlowConsumer
+}
+
+func (h *slowConsumerHook) OnSlowConsumer(_ *Client, info *SlowConsumerInfo) error {
+	h.info = info
+	return nil
+}
+
+func TestManagerOnSlowConsumer(t *testing.T) {
+	m := NewManager()
+	h := &slowConsumerHook{Base: NewHookBase("slow")}
+	require.NoError(t, m.Add(h))
+
+	info := &SlowConsumerInfo{QueueDepth: 100, OldestMessageAge: time.Second, Policy: "disconnect"}
+	m.OnSlowConsumer(&Client{ID: "c1"}, info)
+	assert.Same(t, info, h.info)
+	assert.Equal(t, "OnSlowConsumer", OnSlowConsumer.String())
+
+	base := NewHookBase("base")
+	assert.NoError(t, base.OnSlowConsumer(nil, info))
+}
+
+type takeoverHook struct {
+	*Base
+	reject bool
+}
+
+func (h *takeoverHook) Provides(event Event) bool {
+	return event == OnSessionTakeover
+}
+
+func (h *takeoverHook) OnSessionTakeover(_ *Client, decision *TakeoverDecision) error {
+	decision.Reject = h.reject
+	decision.ReasonCode = encoding.ReasonNotAuthorized
+	return nil
+}
+
+func TestManagerOnSessionTakeover(t *testing.T) {
+	m := NewManager()
+	require.NoError(t, m.Add(&takeoverHook{Base: NewHookBase("takeover"), reject: true}))
+
+	decision := &TakeoverDecision{Policy: session.TakeoverPolicyReplace, Active: true}
+	m.OnSessionTakeover(&Client{ID: "c1"}, decision)
+	assert.True(t, decision.Reject)
+	assert.Equal(t, encoding.ReasonNotAuthorized, decision.ReasonCode)
+	assert.Equal(t, "OnSessionTakeover", OnSessionTakeover.String())
+
+	base := NewHookBase("base")
+	assert.NoError(t, base.OnSessionTakeover(nil, decision))
+}
+
+type roamingHook struct {
+	*Base
+	seen *RoamingInfo
+}
+
+func (h *roamingHook) Provides(event Event) bool {
+	return event == OnClientRoamed
+}
+
+func (h *roamingHook) OnClientRoamed(_ *Client, info *RoamingInfo) error {
+	h.seen = info
+	return nil
+}
+
+func TestManagerOnClientRoamed(t *testing.T) {
+	m := NewManager()
+	h := &roamingHook{Base: NewHookBase("roaming")}
+	require.NoError(t, m.Add(h))
+
+	info := &RoamingInfo{PreviousListener: "lte", PreviousTransport: "tcp", Listener: "wifi", Transport: "ws", InflightMessages: 2}
+	m.OnClientRoamed(&Client{ID: "c1"}, info)
+	assert.Same(t, info, h.seen)
+	assert.Equal(t, "OnClientRoamed", OnClientRoamed.String())
+
+	base := NewHookBase("base")
+	assert.NoError(t, base.OnClientRoamed(nil, info))
+}
+
+func TestManagerSessionRoamed(t *testing.T) {
+	m := NewManager()
+	h := &roamingHook{Base: NewHookBase("roaming")}
+	require.NoError(t, m.Add(h))
+
+	s := session.New("c1", false, 300, 5)
+	s.AddPendingPublish(&session.PendingMessage{PacketID: 1, Topic: "a", QoS: 1})
+	m.SessionRoamed(s, &session.TakeoverDecision{
+		ClientID: "c1",
+		Roaming:  true,
+		Previous: session.ConnectionInfo{Listener: "lte", Transport: "tcp", RemoteAddr: "10.0.0.1:4000"},
+		Current:  session.ConnectionInfo{Listener: "wifi", Transport: "ws", RemoteAddr: "192.0.2.1:5000"},
+	})
+	assert.Equal(t, &RoamingInfo{
+		PreviousListener:   "lte",
+		PreviousTransport:  "tcp",
+		PreviousRemoteAddr: "10.0.0.1:4000",
+		Listener:           "wifi",
+		Transport:          "ws",
+		RemoteAddr:         "192.0.2.1:5000",
+		InflightMessages:   1,
+	}, h.seen)
+}
+
+type deliverHook struct {
+	*Base
+	suffix string
+}
+
+func (h *deliverHook) Provides(event Event) bool {
+	return event == OnPublishDeliver
+}
+
+func (h *deliverHook) OnPublishDeliver(_ *Client, packet *PublishPacket) *PublishPacket {
+	if h.suffix == "" {
+		return nil
+	}
+	out := *packet
+	out.Topic += h.suffix
+	return &out
+}
+
+func TestManagerOnPublishDeliver(t *testing.T) {
+	m := NewManager()
+	require.NoError(t, m.Add(&deliverHook{Base: NewHookBase("first"), suffix: "/a"}))
+	require.NoError(t, m.Add(&deliverHook{Base: NewHookBase("nil")}))
+	require.NoError(t, m.Add(&deliverHook{Base: NewHookBase("second"), suffix: "/b"}))
+
+	packet := &PublishPacket{Topic: "t"}
+	result := m.OnPublishDeliver(&Client{ID: "c1"}, packet)
+	assert.Equal(t, "t/a/b", result.Topic)
+	assert.Equal(t, "t", packet.Topic)
+	assert.Equal(t, "OnPublishDeliver", OnPublishDeliver.String())
+
+	base := NewHookBase("base")
+	assert.Same(t, packet, base.OnPublishDeliver(nil, packet))
+}
+
+type ackHook struct {
+	*Base
+	key string
+}
+
+func (h *ackHook) Provides(event Event) bool {
+	return event == OnAckResponse
+}
+
+func (h *ackHook) OnAckResponse(_ *Client, ack *AckResponse) error {
+	ack.AddUserProperty(h.key, "1")
+	return errors.New("ignored")
+}
+
+func TestManagerOnAckResponse(t *testing.T) {
+	m := NewManager()
+	require.NoError(t, m.Add(&ackHook{Base: NewHookBase("first"), key: "a"}))
+	require.NoError(t, m.Add(&ackHook{Base: NewHookBase("second"), key: "b"}))
+
+	ack := &AckResponse{PacketType: encoding.PUBACK, PacketID: 1}
+	m.OnAckResponse(&Client{ID: "c1"}, ack)
+	assert.Equal(t, []encoding.UTF8Pair{{Key: "a", Value: "1"}, {Key: "b", Value: "1"}}, ack.UserProperties)
+	assert.Equal(t, "OnAckResponse", OnAckResponse.String())
+
+	base := NewHookBase("base")
+	assert.NoError(t, base.OnAckResponse(nil, ack))
+}
@@ -0,0 +1,164 @@
+package hook
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/topic"
+)
+
+// MirrorProperty is the user property marking mirrored copies, consumers can tell them from live traffic
+// and copies are never mirrored again
+const MirrorProperty = "ax-mirror"
+
+// MirrorPublisher publishes mirrored copies of messages, it must not block the publishing client
+type MirrorPublisher interface {
+	PublishMirror(client *Client, packet *PublishPacket) error
+}
+
+// MirrorPolicy mirrors a share of the publishes matching Filter to a shadow topic
+// A shadow consumer group subscribes to the target, e.g. $share/canary/$mirror/orders/#, so a new consumer
+// version sees live traffic while the primary subscribers keep receiving every message unchanged
+type MirrorPolicy struct {
+	Filter string `json:"filter"`
+	// Target is the topic copies are published to, {topic} is replaced by the original topic
+	Target string `json:"target"`
+	// Percent is the share of matching messages mirrored, from 0 to 100
+	Percent float64 `json:"percent"`
+}
+
+func (p *MirrorPolicy) validate() error {
+	if err := topic.ValidateTopicFilter(p.Filter); err != nil {
+		return fmt.Errorf("%w: filter %q: %v", ErrInvalidMirrorPolicy, p.Filter, err)
+	}
+	if p.Percent < 0 || p.Percent > 100 {
+		return fmt.Errorf("%w: percent %v out of range", ErrInvalidMirrorPolicy, p.Percent)
+	}
+	if err := topic.ValidateTopic(p.target("mirror")); err != nil {
+		return fmt.Errorf("%w: target %q: %v", ErrInvalidMirrorPolicy, p.Target, err)
+	}
+	return nil
+}
+
+func (p *MirrorPolicy) target(topicName string) string {
+	return strings.ReplaceAll(p.Target, "{topic}", topicName)
+}
+
+// mirrorRule is a policy with its sampling counter
+type mirrorRule struct {
+	policy MirrorPolicy
+	seen   atomic.Uint64
+}
+
+// sample reports whether the message is mirrored, it picks evenly spread messages so exactly Percent of them are
+func (r *mirrorRule) sample() bool {
+	n := float64(r.seen.Add(1))
+	return int64(n*r.policy.Percent/100) > int64((n-1)*r.policy.Percent/100)
+}
+
+// MirrorStats holds the counters of a mirror hook
+type MirrorStats struct {
+	Matched  uint64
+	Mirrored uint64
+	Failed   uint64
+}
+
+// MirrorHook copies a configurable share of the traffic matching a filter to a shadow topic
+// Primary delivery is never affected, the original packet is not modified and publish failures are only counted
+// Policies are checked in order and the first matching filter wins
+type MirrorHook struct {
+	*Base
+	publisher MirrorPublisher
+
+	mu    sync.RWMutex
+	rules []*mirrorRule
+
+	matched  atomic.Uint64
+	mirrored atomic.Uint64
+	failed   atomic.Uint64
+}
+
+// NewMirrorHook creates a mirror hook publishing copies through publisher
+func NewMirrorHook(publisher MirrorPublisher, policies ...MirrorPolicy) (*MirrorHook, error) {
+	h := &MirrorHook{Base: &Base{id: "mirror"}, publisher: publisher}
+	if err := h.SetPolicies(policies); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// ID returns the hook identifier
+func (h *MirrorHook) ID() string {
+	return h.id
+}
+
+// Provides indicates this hook inspects publishes
+func (h *MirrorHook) Provides(event Event) bool {
+	return event == OnPublish
+}
+
+// SetPolicies replaces the policies and restarts sampling
+func (h *MirrorHook) SetPolicies(policies []MirrorPolicy) error {
+	rules := make([]*mirrorRule, len(policies))
+	for i, p := range policies {
+		if err := p.validate(); err != nil {
+			return err
+		}
+		rules[i] = &mirrorRule{policy: p}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.rules = rules
+	return nil
+}
+
+func (h *MirrorHook) rule(topicName string) *mirrorRule {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, r := range h.rules {
+		if topic.MatchFilter(r.policy.Filter, topicName) {
+			return r
+		}
+	}
+	return nil
+}
+
+// OnPublish mirrors the message when its topic matches a policy and it is picked by sampling
+func (h *MirrorHook) OnPublish(client *Client, packet *PublishPacket) error {
+	if packet == nil || h.publisher == nil || len(packet.Properties.UserProperty(MirrorProperty)) > 0 {
+		return nil
+	}
+	r := h.rule(packet.Topic)
+	if r == nil {
+		return nil
+	}
+	h.matched.Add(1)
+	if !r.sample() {
+		return nil
+	}
+
+	mirrored := *packet
+	mirrored.Topic = r.policy.target(packet.Topic)
+	mirrored.Retain = false
+	mirrored.Properties = withUserProperties(packet.Properties, []encoding.UTF8Pair{{Key: MirrorProperty, Value: packet.Topic}})
+
+	if err := h.publisher.PublishMirror(client, &mirrored); err != nil {
+		h.failed.Add(1)
+		return nil
+	}
+	h.mirrored.Add(1)
+	return nil
+}
+
+// Stats returns the hook counters
+func (h *MirrorHook) Stats() MirrorStats {
+	return MirrorStats{
+		Matched:  h.matched.Load(),
+		Mirrored: h.mirrored.Load(),
+		Failed:   h.failed.Load(),
+	}
+}
@@ -0,0 +1,78 @@
+package hook
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mirrorRecorder struct {
+	packets []*PublishPacket
+	err     error
+}
+
+func (r *mirrorRecorder) PublishMirror(_ *Client, packet *PublishPacket) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.packets = append(r.packets, packet)
+	return nil
+}
+
+func TestMirrorHookMirrorsShareOfTraffic(t *testing.T) {
+	rec := &mirrorRecorder{}
+	h, err := NewMirrorHook(rec, MirrorPolicy{Filter: "orders/#", Target: "$mirror/{topic}", Percent: 25})
+	require.NoError(t, err)
+	assert.True(t, h.Provides(OnPublish))
+
+	for range 100 {
+		packet := &PublishPacket{Topic: "orders/eu", Payload: []byte("x"), Retain: true, Properties: Properties{}}
+		require.NoError(t, h.OnPublish(&Client{ID: "c1"}, packet))
+		assert.Equal(t, "orders/eu", packet.Topic)
+		assert.True(t, packet.Retain)
+		assert.Empty(t, packet.Properties.UserProperty(MirrorProperty))
+	}
+	require.NoError(t, h.OnPublish(&Client{ID: "c1"}, &PublishPacket{Topic: "inventory/eu"}))
+
+	require.Len(t, rec.packets, 25)
+	mirrored := rec.packets[0]
+	assert.Equal(t, "$mirror/orders/eu", mirrored.Topic)
+	assert.False(t, mirrored.Retain)
+	assert.Equal(t, []string{"orders/eu"}, mirrored.Properties.UserProperty(MirrorProperty))
+	assert.Equal(t, MirrorStats{Matched: 100, Mirrored: 25}, h.Stats())
+}
+
+func TestMirrorHookSkipsMirroredCopies(t *testing.T) {
+	rec := &mirrorRecorder{}
+	h, err := NewMirrorHook(rec, MirrorPolicy{Filter: "#", Target: "mirror/{topic}", Percent: 100})
+	require.NoError(t, err)
+
+	require.NoError(t, h.OnPublish(nil, &PublishPacket{Topic: "a"}))
+	require.Len(t, rec.packets, 1)
+	require.NoError(t, h.OnPublish(nil, rec.packets[0]))
+	assert.Len(t, rec.packets, 1)
+}
+
+func TestMirrorHookPublishFailureDoesNotAffectPrimary(t *testing.T) {
+	rec := &mirrorRecorder{err: errors.New("shadow unavailable")}
+	h, err := NewMirrorHook(rec, MirrorPolicy{Filter: "a/#", Target: "canary/a", Percent: 100})
+	require.NoError(t, err)
+
+	assert.NoError(t, h.OnPublish(nil, &PublishPacket{Topic: "a/b"}))
+	assert.Equal(t, MirrorStats{Matched: 1, Failed: 1}, h.Stats())
+}
+
+func TestMirrorHookInvalidPolicies(t *testing.T) {
+	tests := []MirrorPolicy{
+		{Filter: "a/#/b", Target: "m", Percent: 10},
+		{Filter: "a", Target: "m/+", Percent: 10},
+		{Filter: "a", Target: "", Percent: 10},
+		{Filter: "a", Target: "m", Percent: 150},
+	}
+	for _, p := range tests {
+		_, err := NewMirrorHook(&mirrorRecorder{}, p)
+		assert.ErrorIs(t, err, ErrInvalidMirrorPolicy, "%+v", p)
+	}
+}
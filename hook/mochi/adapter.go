@@ -0,0 +1,472 @@
+package mochi
+
+import (
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/hook"
+)
+
+// Adapter runs a mochi-mqtt hook as an ax hook
+type Adapter struct {
+	*hook.Base
+	inner Hook
+	log   *slog.Logger
+}
+
+// Wrap adapts a mochi-mqtt hook, log is passed to its SetOpts and defaults to slog.Default
+func Wrap(h Hook, log *slog.Logger) *Adapter {
+	if log == nil {
+		log = slog.Default()
+	}
+	// mochi-mqtt hands every hook its options when it is added, before the broker calls SetOptions
+	h.SetOpts(log, &HookOptions{Capabilities: &Capabilities{}})
+	return &Adapter{
+		Base:  hook.NewHookBase(h.ID()),
+		inner: h,
+		log:   log,
+	}
+}
+
+// Unwrap returns the wrapped mochi-mqtt hook
+func (a *Adapter) Unwrap() Hook {
+	return a.inner
+}
+
+// Provides reports the events the wrapped hook provides that have an ax counterpart
+func (a *Adapter) Provides(event hook.Event) bool {
+	switch event {
+	case hook.SetOptions:
+		return true
+	case hook.OnStarted, hook.OnStopped, hook.OnConnectAuthenticate, hook.OnACLCheck, hook.OnConnect,
+		hook.OnSessionEstablished, hook.OnDisconnect, hook.OnSubscribe, hook.OnSubscribed, hook.OnUnsubscribe,
+		hook.OnUnsubscribed, hook.OnPublish, hook.OnPublished, hook.OnPublishDropped, hook.OnRetainMessage,
+		hook.OnRetainPublished, hook.OnWill, hook.OnWillSent, hook.OnClientExpired, hook.OnRetainedExpired:
+		// The event values of both brokers match up to StoredSysInfo
+		return a.inner.Provides(byte(event))
+	}
+	return false
+}
+
+// Init initializes the wrapped hook
+func (a *Adapter) Init(config any) error {
+	return a.inner.Init(config)
+}
+
+// Stop stops the wrapped hook
+func (a *Adapter) Stop() error {
+	return a.inner.Stop()
+}
+
+// SetOptions passes the broker capabilities to the wrapped hook
+func (a *Adapter) SetOptions(opts *hook.Options) error {
+	a.inner.SetOpts(a.log, toHookOptions(opts))
+	return nil
+}
+
+// OnStarted notifies the wrapped hook
+func (a *Adapter) OnStarted() error {
+	a.inner.OnStarted()
+	return nil
+}
+
+// OnStopped notifies the wrapped hook
+func (a *Adapter) OnStopped(error) error {
+	a.inner.OnStopped()
+	return nil
+}
+
+// OnConnectAuthenticate asks the wrapped hook to authenticate a client
+func (a *Adapter) OnConnectAuthenticate(client *hook.Client, packet *hook.ConnectPacket) bool {
+	return a.inner.OnConnectAuthenticate(toClient(client), toConnectPacket(packet))
+}
+
+// OnACLCheck asks the wrapped hook, read and write access must both be granted for AccessTypeReadWrite
+func (a *Adapter) OnACLCheck(client *hook.Client, topic string, access hook.AccessType) bool {
+	cl := toClient(client)
+	switch access {
+	case hook.AccessTypeRead:
+		return a.inner.OnACLCheck(cl, topic, false)
+	case hook.AccessTypeWrite:
+		return a.inner.OnACLCheck(cl, topic, true)
+	}
+	return a.inner.OnACLCheck(cl, topic, false) && a.inner.OnACLCheck(cl, topic, true)
+}
+
+// OnConnect notifies the wrapped hook, its error rejects the connection
+func (a *Adapter) OnConnect(client *hook.Client, packet *hook.ConnectPacket) error {
+	return a.inner.OnConnect(toClient(client), toConnectPacket(packet))
+}
+
+// OnSessionEstablished notifies the wrapped hook
+func (a *Adapter) OnSessionEstablished(client *hook.Client, packet *hook.ConnectPacket) error {
+	a.inner.OnSessionEstablished(toClient(client), toConnectPacket(packet))
+	return nil
+}
+
+// OnDisconnect notifies the wrapped hook with the error and expiry of info
+func (a *Adapter) OnDisconnect(client *hook.Client, info *hook.DisconnectInfo) error {
+	expire := info != nil && info.Expire
+	a.inner.OnDisconnect(toClient(client), info.GetErr(), expire)
+	return nil
+}
+
+// OnSubscribe lets the wrapped hook change a subscription
+// Removing the filter or setting its Qos to a failure reason code rejects the subscription
+func (a *Adapter) OnSubscribe(client *hook.Client, sub *hook.Subscription) error {
+	pk := a.inner.OnSubscribe(toClient(client), toSubscribePacket(sub))
+	if len(pk.Filters) == 0 || pk.Filters[0].Qos >= byte(encoding.ReasonUnspecifiedError) {
+		return ErrSubscriptionRejected
+	}
+
+	f := pk.Filters[0]
+	sub.TopicFilter = f.Filter
+	sub.QoS = f.Qos
+	sub.NoLocal = f.NoLocal
+	sub.RetainAsPublished = f.RetainAsPublished
+	sub.RetainHandling = f.RetainHandling
+	sub.SubscriptionIdentifier = uint32(f.Identifier)
+	return nil
+}
+
+// OnSubscribed notifies the wrapped hook, the reason code passed is the granted QoS
+func (a *Adapter) OnSubscribed(client *hook.Client, sub *hook.Subscription) error {
+	a.inner.OnSubscribed(toClient(client), toSubscribePacket(sub), []byte{sub.QoS})
+	return nil
+}
+
+// OnUnsubscribe lets the wrapped hook refuse an unsubscription by removing the filter
+func (a *Adapter) OnUnsubscribe(client *hook.Client, topicFilter string) error {
+	pk := a.inner.OnUnsubscribe(toClient(client), toUnsubscribePacket(topicFilter))
+	if len(pk.Filters) == 0 {
+		return ErrSubscriptionRejected
+	}
+	return nil
+}
+
+// OnUnsubscribed notifies the wrapped hook
+func (a *Adapter) OnUnsubscribed(client *hook.Client, topicFilter string) error {
+	a.inner.OnUnsubscribed(toClient(client), toUnsubscribePacket(topicFilter))
+	return nil
+}
+
+// OnPublish lets the wrapped hook change or reject a publish, changes are copied into packet
+func (a *Adapter) OnPublish(client *hook.Client, packet *hook.PublishPacket) error {
+	pk, err := a.inner.OnPublish(toClient(client), toPublishPacket(packet))
+	if err != nil {
+		return err
+	}
+	applyPublishPacket(packet, pk)
+	return nil
+}
+
+// OnPublished notifies the wrapped hook
+func (a *Adapter) OnPublished(client *hook.Client, packet *hook.PublishPacket) error {
+	a.inner.OnPublished(toClient(client), toPublishPacket(packet))
+	return nil
+}
+
+// OnPublishDropped notifies the wrapped hook, mochi-mqtt hooks are not told why
+func (a *Adapter) OnPublishDropped(client *hook.Client, packet *hook.PublishPacket, _ hook.DropReason) error {
+	a.inner.OnPublishDropped(toClient(client), toPublishPacket(packet))
+	return nil
+}
+
+// OnRetainMessage notifies the wrapped hook, r is -1 when an empty payload clears the retained message and 1 otherwise
+func (a *Adapter) OnRetainMessage(client *hook.Client, packet *hook.PublishPacket) error {
+	r := int64(1)
+	if len(packet.Payload) == 0 {
+		r = -1
+	}
+	a.inner.OnRetainMessage(toClient(client), toPublishPacket(packet), r)
+	return nil
+}
+
+// OnRetainPublished notifies the wrapped hook
+func (a *Adapter) OnRetainPublished(client *hook.Client, packet *hook.PublishPacket) error {
+	a.inner.OnRetainPublished(toClient(client), toPublishPacket(packet))
+	return nil
+}
+
+// OnWill lets the wrapped hook change a will, an error keeps the will unchanged
+func (a *Adapter) OnWill(client *hook.Client, will *hook.WillMessage) *hook.WillMessage {
+	if will == nil {
+		return nil
+	}
+	w, err := a.inner.OnWill(toClient(client), toWill(will))
+	if err != nil {
+		return nil
+	}
+	return fromWill(w, will)
+}
+
+// OnWillSent notifies the wrapped hook with the will as a publish
+func (a *Adapter) OnWillSent(client *hook.Client, will *hook.WillMessage) error {
+	if will == nil {
+		return nil
+	}
+	a.inner.OnWillSent(toClient(client), Packet{
+		FixedHeader: FixedHeader{Type: Publish, Qos: will.QoS, Retain: will.Retain},
+		TopicName:   will.Topic,
+		Payload:     will.Payload,
+		Properties:  toProperties(will.Properties),
+		Created:     time.Now().Unix(),
+	})
+	return nil
+}
+
+// OnClientExpired notifies the wrapped hook with a client holding only the ID
+func (a *Adapter) OnClientExpired(clientID string) error {
+	a.inner.OnClientExpired(&Client{ID: clientID})
+	return nil
+}
+
+// OnRetainedExpired notifies the wrapped hook
+func (a *Adapter) OnRetainedExpired(topic string) error {
+	a.inner.OnRetainedExpired(topic)
+	return nil
+}
+
+func toHookOptions(opts *hook.Options) *HookOptions {
+	if opts == nil || opts.Capabilities == nil {
+		return &HookOptions{Capabilities: &Capabilities{}}
+	}
+	c := opts.Capabilities
+	return &HookOptions{Capabilities: &Capabilities{
+		MaximumSessionExpiryInterval: c.MaximumSessionExpiryInterval,
+		MaximumMessageExpiryInterval: int64(c.MaximumMessageExpiryInterval),
+		ReceiveMaximum:               c.ReceiveMaximum,
+		MaximumQos:                   c.MaximumQoS,
+		RetainAvailable:              flag(c.RetainAvailable),
+		MaximumPacketSize:            c.MaximumPacketSize,
+		TopicAliasMaximum:            c.MaximumTopicAlias,
+		WildcardSubAvailable:         flag(c.WildcardSubAvailable),
+		SubIDAvailable:               flag(c.SubIDAvailable),
+		SharedSubAvailable:           flag(c.SharedSubAvailable),
+	}}
+}
+
+func flag(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func toClient(c *hook.Client) *Client {
+	if c == nil {
+		return &Client{}
+	}
+	cl := &Client{
+		ID: c.ID,
+		Properties: ClientProperties{
+			Props:           toProperties(c.Properties),
+			Username:        []byte(c.Username),
+			ProtocolVersion: c.ProtocolVersion,
+			Clean:           c.CleanStart,
+		},
+	}
+	if c.RemoteAddr != nil {
+		cl.Net.Remote = c.RemoteAddr.String()
+	}
+	if c.Will != nil {
+		cl.Properties.Will = toWill(c.Will)
+	}
+	return cl
+}
+
+func toConnectPacket(p *hook.ConnectPacket) Packet {
+	if p == nil {
+		return Packet{FixedHeader: FixedHeader{Type: Connect}}
+	}
+	pk := Packet{
+		FixedHeader:     FixedHeader{Type: Connect},
+		Properties:      toProperties(p.Properties),
+		ProtocolVersion: p.ProtocolVersion,
+		Connect: ConnectParams{
+			ClientIdentifier: p.ClientID,
+			ProtocolName:     []byte(p.ProtocolName),
+			Username:         []byte(p.Username),
+			UsernameFlag:     p.Username != "",
+			Password:         p.Password,
+			PasswordFlag:     len(p.Password) > 0,
+			Keepalive:        p.KeepAlive,
+			Clean:            p.CleanStart,
+		},
+		Created: time.Now().Unix(),
+	}
+	if w := p.Will; w != nil {
+		pk.Connect.WillFlag = true
+		pk.Connect.WillTopic = w.Topic
+		pk.Connect.WillPayload = w.Payload
+		pk.Connect.WillQos = w.QoS
+		pk.Connect.WillRetain = w.Retain
+		pk.Connect.WillProperties = toProperties(w.Properties)
+		pk.Connect.WillProperties.WillDelayInterval = w.WillDelayInterval
+	}
+	return pk
+}
+
+func toSubscribePacket(sub *hook.Subscription) Packet {
+	return Packet{
+		FixedHeader: FixedHeader{Type: Subscribe, Qos: 1},
+		Properties:  toProperties(sub.Properties),
+		Filters: Subscriptions{{
+			Filter:            sub.TopicFilter,
+			Identifier:        int(sub.SubscriptionIdentifier),
+			RetainHandling:    sub.RetainHandling,
+			Qos:               sub.QoS,
+			RetainAsPublished: sub.RetainAsPublished,
+			NoLocal:           sub.NoLocal,
+		}},
+		Created: time.Now().Unix(),
+	}
+}
+
+func toUnsubscribePacket(topicFilter string) Packet {
+	return Packet{
+		FixedHeader: FixedHeader{Type: Unsubscribe, Qos: 1},
+		Filters:     Subscriptions{{Filter: topicFilter}},
+		Created:     time.Now().Unix(),
+	}
+}
+
+func toPublishPacket(p *hook.PublishPacket) Packet {
+	if p == nil {
+		return Packet{FixedHeader: FixedHeader{Type: Publish}}
+	}
+	pk := Packet{
+		FixedHeader:     FixedHeader{Type: Publish, Qos: p.QoS, Retain: p.Retain, Dup: p.Duplicate},
+		TopicName:       p.Topic,
+		Payload:         p.Payload,
+		PacketID:        p.PacketID,
+		Properties:      toProperties(p.Properties),
+		ProtocolVersion: p.ProtocolVersion,
+		Origin:          p.Origin,
+	}
+	if !p.Created.IsZero() {
+		pk.Created = p.Created.Unix()
+	}
+	if pk.Created > 0 && pk.Properties.MessageExpiryInterval > 0 {
+		pk.Expiry = pk.Created + int64(pk.Properties.MessageExpiryInterval)
+	}
+	return pk
+}
+
+// applyPublishPacket copies the fields a mochi-mqtt hook may change into packet
+func applyPublishPacket(packet *hook.PublishPacket, pk Packet) {
+	packet.Topic = pk.TopicName
+	packet.Payload = pk.Payload
+	packet.QoS = pk.FixedHeader.Qos
+	packet.Retain = pk.FixedHeader.Retain
+	packet.Properties = applyProperties(packet.Properties, pk.Properties)
+}
+
+func toWill(w *hook.WillMessage) Will {
+	props := toProperties(w.Properties)
+	will := Will{
+		Payload:           w.Payload,
+		User:              props.User,
+		TopicName:         w.Topic,
+		WillDelayInterval: w.WillDelayInterval,
+		Qos:               w.QoS,
+		Retain:            w.Retain,
+	}
+	if w.Topic != "" {
+		will.Flag = 1
+	}
+	return will
+}
+
+// fromWill builds the will a mochi-mqtt hook returned, properties it cannot see are kept from base
+func fromWill(w Will, base *hook.WillMessage) *hook.WillMessage {
+	props := toProperties(base.Properties)
+	props.User = w.User
+	return &hook.WillMessage{
+		Topic:             w.TopicName,
+		Payload:           w.Payload,
+		QoS:               w.Qos,
+		Retain:            w.Retain,
+		Properties:        applyProperties(clone(base.Properties), props),
+		WillDelayInterval: w.WillDelayInterval,
+	}
+}
+
+func clone(props hook.Properties) hook.Properties {
+	if props == nil {
+		return nil
+	}
+	c := make(hook.Properties, len(props))
+	for k, v := range props {
+		c[k] = v
+	}
+	return c
+}
+
+func toProperties(props hook.Properties) Properties {
+	var p Properties
+	if len(props) == 0 {
+		return p
+	}
+	if v, ok := props[encoding.PropPayloadFormatIndicator.String()].(byte); ok {
+		p.PayloadFormat = v
+		p.PayloadFormatFlag = true
+	}
+	p.MessageExpiryInterval, _ = props[encoding.PropMessageExpiryInterval.String()].(uint32)
+	p.SessionExpiryInterval, _ = props[encoding.PropSessionExpiryInterval.String()].(uint32)
+	p.ContentType, _ = props[encoding.PropContentType.String()].(string)
+	p.ResponseTopic, _ = props[encoding.PropResponseTopic.String()].(string)
+	p.ReasonString, _ = props[encoding.PropReasonString.String()].(string)
+	p.CorrelationData, _ = props[encoding.PropCorrelationData.String()].([]byte)
+
+	switch pairs := props[encoding.PropUserProperty.String()].(type) {
+	case []encoding.UTF8Pair:
+		for _, pair := range pairs {
+			p.User = append(p.User, UserProperty{Key: pair.Key, Val: pair.Value})
+		}
+	case map[string]string:
+		keys := make([]string, 0, len(pairs))
+		for key := range pairs {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			p.User = append(p.User, UserProperty{Key: key, Val: pairs[key]})
+		}
+	}
+	return p
+}
+
+// applyProperties writes the properties mochi-mqtt knows into props, others are left untouched
+func applyProperties(props hook.Properties, p Properties) hook.Properties {
+	if props == nil {
+		props = make(hook.Properties)
+	}
+	set := func(id encoding.PropertyID, value any, present bool) {
+		if present {
+			props[id.String()] = value
+		} else {
+			delete(props, id.String())
+		}
+	}
+	set(encoding.PropPayloadFormatIndicator, p.PayloadFormat, p.PayloadFormatFlag)
+	set(encoding.PropMessageExpiryInterval, p.MessageExpiryInterval, p.MessageExpiryInterval > 0)
+	set(encoding.PropSessionExpiryInterval, p.SessionExpiryInterval, p.SessionExpiryInterval > 0)
+	set(encoding.PropContentType, p.ContentType, p.ContentType != "")
+	set(encoding.PropResponseTopic, p.ResponseTopic, p.ResponseTopic != "")
+	set(encoding.PropReasonString, p.ReasonString, p.ReasonString != "")
+	set(encoding.PropCorrelationData, p.CorrelationData, len(p.CorrelationData) > 0)
+
+	pairs := make([]encoding.UTF8Pair, 0, len(p.User))
+	for _, u := range p.User {
+		pairs = append(pairs, encoding.UTF8Pair{Key: u.Key, Value: u.Val})
+	}
+	set(encoding.PropUserProperty, pairs, len(pairs) > 0)
+
+	if len(props) == 0 {
+		return nil
+	}
+	return props
+}
@@ -0,0 +1,221 @@
+package mochi
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/axmq/ax/hook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// legacyHook is written the way hooks for mochi-mqtt are
+type legacyHook struct {
+	HookBase
+	expired []string
+	retains []int64
+}
+
+func (h *legacyHook) ID() string {
+	return "legacy"
+}
+
+func (h *legacyHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		OnConnectAuthenticate,
+		OnACLCheck,
+		OnSubscribe,
+		OnUnsubscribe,
+		OnPublish,
+		OnRetainMessage,
+		OnWill,
+		OnClientExpired,
+	}, []byte{b})
+}
+
+func (h *legacyHook) OnConnectAuthenticate(cl *Client, pk Packet) bool {
+	return string(pk.Connect.Username) == "device" && string(pk.Connect.Password) == "secret"
+}
+
+func (h *legacyHook) OnACLCheck(cl *Client, topic string, write bool) bool {
+	if write {
+		return strings.HasPrefix(topic, "devices/"+cl.ID+"/")
+	}
+	return true
+}
+
+func (h *legacyHook) OnSubscribe(cl *Client, pk Packet) Packet {
+	for i := range pk.Filters {
+		if pk.Filters[i].Qos > 1 {
+			pk.Filters[i].Qos = 1
+		}
+	}
+	if pk.Filters[0].Filter == "#" {
+		pk.Filters = pk.Filters[:0]
+	}
+	return pk
+}
+
+func (h *legacyHook) OnUnsubscribe(cl *Client, pk Packet) Packet {
+	if pk.Filters[0].Filter == "$SYS/#" {
+		pk.Filters = nil
+	}
+	return pk
+}
+
+func (h *legacyHook) OnPublish(cl *Client, pk Packet) (Packet, error) {
+	if pk.TopicName == "blocked" {
+		return pk, ErrRejectPacket
+	}
+	pk.TopicName = "devices/" + cl.ID + "/" + pk.TopicName
+	pk.Properties.User = append(pk.Properties.User, UserProperty{Key: "via", Val: "legacy"})
+	pk.Properties.ContentType = "application/json"
+	return pk, nil
+}
+
+func (h *legacyHook) OnRetainMessage(cl *Client, pk Packet, r int64) {
+	h.retains = append(h.retains, r)
+}
+
+func (h *legacyHook) OnWill(cl *Client, will Will) (Will, error) {
+	if will.TopicName == "keep" {
+		return will, errors.New("keep will")
+	}
+	will.TopicName = "wills/" + cl.ID
+	return will, nil
+}
+
+func (h *legacyHook) OnClientExpired(cl *Client) {
+	h.expired = append(h.expired, cl.ID)
+}
+
+func TestAdapterProvides(t *testing.T) {
+	a := Wrap(&legacyHook{}, nil)
+	assert.Equal(t, "legacy", a.ID())
+	assert.True(t, a.Provides(hook.SetOptions))
+	assert.True(t, a.Provides(hook.OnPublish))
+	assert.True(t, a.Provides(hook.OnClientExpired))
+	assert.False(t, a.Provides(hook.OnPublished))
+	assert.False(t, a.Provides(hook.OnPacketRead))
+	assert.False(t, a.Provides(hook.OnPublishDeliver))
+	assert.Equal(t, byte(hook.StoredSysInfo), StoredSysInfo)
+}
+
+func TestAdapterAuthentication(t *testing.T) {
+	m := hook.NewManager()
+	require.NoError(t, m.Add(Wrap(&legacyHook{}, nil)))
+
+	client := &hook.Client{ID: "d1", RemoteAddr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1883}}
+	assert.True(t, m.OnConnectAuthenticate(client, &hook.ConnectPacket{Username: "device", Password: []byte("secret")}))
+	assert.False(t, m.OnConnectAuthenticate(client, &hook.ConnectPacket{Username: "device", Password: []byte("guess")}))
+
+	assert.True(t, m.OnACLCheck(client, "devices/d1/temp", hook.AccessTypeWrite))
+	assert.False(t, m.OnACLCheck(client, "devices/d2/temp", hook.AccessTypeWrite))
+	assert.True(t, m.OnACLCheck(client, "devices/d2/temp", hook.AccessTypeRead))
+	assert.False(t, m.OnACLCheck(client, "devices/d2/temp", hook.AccessTypeReadWrite))
+}
+
+func TestAdapterOnPublish(t *testing.T) {
+	a := Wrap(&legacyHook{}, nil)
+	client := &hook.Client{ID: "d1"}
+
+	packet := &hook.PublishPacket{
+		Topic:      "temp",
+		Payload:    []byte("21"),
+		QoS:        1,
+		Properties: hook.Properties{"ContentType": "text/plain", "TopicAlias": uint16(3)},
+	}
+	require.NoError(t, a.OnPublish(client, packet))
+	assert.Equal(t, "devices/d1/temp", packet.Topic)
+	assert.Equal(t, byte(1), packet.QoS)
+	assert.Equal(t, "application/json", packet.Properties["ContentType"])
+	assert.Equal(t, []string{"legacy"}, packet.Properties.UserProperty("via"))
+	assert.Equal(t, uint16(3), packet.Properties["TopicAlias"], "properties mochi-mqtt does not model are kept")
+
+	err := a.OnPublish(client, &hook.PublishPacket{Topic: "blocked"})
+	require.ErrorIs(t, err, ErrRejectPacket)
+}
+
+func TestAdapterOnSubscribe(t *testing.T) {
+	a := Wrap(&legacyHook{}, nil)
+	client := &hook.Client{ID: "d1"}
+
+	sub := &hook.Subscription{TopicFilter: "devices/+/temp", QoS: 2, SubscriptionIdentifier: 7}
+	require.NoError(t, a.OnSubscribe(client, sub))
+	assert.Equal(t, byte(1), sub.QoS)
+	assert.Equal(t, uint32(7), sub.SubscriptionIdentifier)
+
+	err := a.OnSubscribe(client, &hook.Subscription{TopicFilter: "#"})
+	require.ErrorIs(t, err, ErrSubscriptionRejected)
+
+	require.NoError(t, a.OnUnsubscribe(client, "devices/+/temp"))
+	require.ErrorIs(t, a.OnUnsubscribe(client, "$SYS/#"), ErrSubscriptionRejected)
+}
+
+func TestAdapterOnWill(t *testing.T) {
+	a := Wrap(&legacyHook{}, nil)
+	client := &hook.Client{ID: "d1"}
+
+	will := &hook.WillMessage{
+		Topic:      "status",
+		Payload:    []byte("offline"),
+		Properties: hook.Properties{"ContentType": "text/plain"},
+	}
+	got := a.OnWill(client, will)
+	require.NotNil(t, got)
+	assert.Equal(t, "wills/d1", got.Topic)
+	assert.Equal(t, []byte("offline"), got.Payload)
+	assert.Equal(t, "text/plain", got.Properties["ContentType"])
+	assert.Equal(t, "status", will.Topic)
+
+	assert.Nil(t, a.OnWill(client, &hook.WillMessage{Topic: "keep"}), "an error keeps the will")
+}
+
+func TestAdapterNotifications(t *testing.T) {
+	inner := &legacyHook{}
+	a := Wrap(inner, nil)
+
+	require.NoError(t, a.OnRetainMessage(&hook.Client{ID: "d1"}, &hook.PublishPacket{Topic: "t", Payload: []byte("x")}))
+	require.NoError(t, a.OnRetainMessage(&hook.Client{ID: "d1"}, &hook.PublishPacket{Topic: "t"}))
+	assert.Equal(t, []int64{1, -1}, inner.retains)
+
+	require.NoError(t, a.OnClientExpired("d1"))
+	assert.Equal(t, []string{"d1"}, inner.expired)
+}
+
+func TestAdapterSetOptions(t *testing.T) {
+	inner := &legacyHook{}
+	a := Wrap(inner, nil)
+	require.NotNil(t, inner.Log)
+	require.NotNil(t, inner.Opts)
+
+	require.NoError(t, a.SetOptions(&hook.Options{Capabilities: &hook.Capabilities{
+		MaximumQoS:      1,
+		RetainAvailable: true,
+		ReceiveMaximum:  100,
+	}}))
+	assert.Equal(t, byte(1), inner.Opts.Capabilities.MaximumQos)
+	assert.Equal(t, byte(1), inner.Opts.Capabilities.RetainAvailable)
+	assert.Equal(t, byte(0), inner.Opts.Capabilities.SharedSubAvailable)
+	assert.Equal(t, uint16(100), inner.Opts.Capabilities.ReceiveMaximum)
+	assert.Same(t, inner, a.Unwrap())
+}
+
+func TestToProperties(t *testing.T) {
+	p := toProperties(hook.Properties{
+		"PayloadFormatIndicator": byte(1),
+		"MessageExpiryInterval":  uint32(60),
+		"CorrelationData":        []byte("id"),
+		"UserProperty":           map[string]string{"b": "2", "a": "1"},
+	})
+	assert.True(t, p.PayloadFormatFlag)
+	assert.Equal(t, byte(1), p.PayloadFormat)
+	assert.Equal(t, uint32(60), p.MessageExpiryInterval)
+	assert.Equal(t, []byte("id"), p.CorrelationData)
+	assert.Equal(t, []UserProperty{{Key: "a", Val: "1"}, {Key: "b", Val: "2"}}, p.User)
+
+	assert.Nil(t, applyProperties(nil, Properties{}))
+}
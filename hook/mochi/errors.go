@@ -0,0 +1,11 @@
+package mochi
+
+import (
+	"errors"
+)
+
+var (
+	// ErrRejectPacket is what mochi-mqtt hooks return from OnPublish to drop a publish
+	ErrRejectPacket         = errors.New("packet rejected")
+	ErrSubscriptionRejected = errors.New("subscription rejected by mochi hook")
+)
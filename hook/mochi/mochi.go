@@ -0,0 +1,335 @@
+// Package mochi runs hooks written for mochi-mqtt on ax
+//
+// The package mirrors the parts of mochi-mqtt's Hook interface, Client and packets.Packet that hooks use, with
+// the same names, fields and event values. Porting a hook means replacing the mqtt and packets imports with this
+// package, e.g. mqtt.HookBase becomes mochi.HookBase and packets.Packet becomes mochi.Packet, and wrapping it
+// with Wrap before adding it to a hook.Manager. Hook methods ax has no counterpart for are never called
+package mochi
+
+import "log/slog"
+
+// Events a hook provides, the values match mochi-mqtt's
+const (
+	SetOptions byte = iota
+	OnSysInfoTick
+	OnStarted
+	OnStopped
+	OnConnectAuthenticate
+	OnACLCheck
+	OnConnect
+	OnSessionEstablish
+	OnSessionEstablished
+	OnDisconnect
+	OnAuthPacket
+	OnPacketRead
+	OnPacketEncode
+	OnPacketSent
+	OnPacketProcessed
+	OnSubscribe
+	OnSubscribed
+	OnSelectSubscribers
+	OnUnsubscribe
+	OnUnsubscribed
+	OnPublish
+	OnPublished
+	OnPublishDropped
+	OnRetainMessage
+	OnRetainPublished
+	OnQosPublish
+	OnQosComplete
+	OnQosDropped
+	OnPacketIDExhausted
+	OnWill
+	OnWillSent
+	OnClientExpired
+	OnRetainedExpired
+	StoredClients
+	StoredSubscriptions
+	StoredInflightMessages
+	StoredRetainedMessages
+	StoredSysInfo
+)
+
+// Packet types, the values match mochi-mqtt's packets package
+const (
+	Reserved byte = iota
+	Connect
+	Connack
+	Publish
+	Puback
+	Pubrec
+	Pubrel
+	Pubcomp
+	Subscribe
+	Suback
+	Unsubscribe
+	Unsuback
+	Pingreq
+	Pingresp
+	Disconnect
+	Auth
+)
+
+// Hook is the subset of mochi-mqtt's Hook interface ax calls
+type Hook interface {
+	ID() string
+	Provides(b byte) bool
+	Init(config any) error
+	Stop() error
+	SetOpts(l *slog.Logger, o *HookOptions)
+	OnStarted()
+	OnStopped()
+	OnConnectAuthenticate(cl *Client, pk Packet) bool
+	OnACLCheck(cl *Client, topic string, write bool) bool
+	OnConnect(cl *Client, pk Packet) error
+	OnSessionEstablished(cl *Client, pk Packet)
+	OnDisconnect(cl *Client, err error, expire bool)
+	OnSubscribe(cl *Client, pk Packet) Packet
+	OnSubscribed(cl *Client, pk Packet, reasonCodes []byte)
+	OnUnsubscribe(cl *Client, pk Packet) Packet
+	OnUnsubscribed(cl *Client, pk Packet)
+	OnPublish(cl *Client, pk Packet) (Packet, error)
+	OnPublished(cl *Client, pk Packet)
+	OnPublishDropped(cl *Client, pk Packet)
+	OnRetainMessage(cl *Client, pk Packet, r int64)
+	OnRetainPublished(cl *Client, pk Packet)
+	OnWill(cl *Client, will Will) (Will, error)
+	OnWillSent(cl *Client, pk Packet)
+	OnClientExpired(cl *Client)
+	OnRetainedExpired(filter string)
+}
+
+// HookOptions holds the broker capabilities passed to SetOpts
+type HookOptions struct {
+	Capabilities *Capabilities
+}
+
+// Capabilities mirrors the broker capabilities of mochi-mqtt a hook may read
+type Capabilities struct {
+	MaximumSessionExpiryInterval uint32
+	MaximumMessageExpiryInterval int64
+	ReceiveMaximum               uint16
+	MaximumQos                   byte
+	RetainAvailable              byte
+	MaximumPacketSize            uint32
+	TopicAliasMaximum            uint16
+	WildcardSubAvailable         byte
+	SubIDAvailable               byte
+	SharedSubAvailable           byte
+}
+
+// HookBase provides no-op implementations of every Hook method, hooks embed it as they embed mqtt.HookBase
+type HookBase struct {
+	Log  *slog.Logger
+	Opts *HookOptions
+}
+
+// ID returns the hook identifier
+func (h *HookBase) ID() string {
+	return "base"
+}
+
+// Provides reports no events, hooks override it
+func (h *HookBase) Provides(b byte) bool {
+	return false
+}
+
+// Init initializes the hook
+func (h *HookBase) Init(config any) error {
+	return nil
+}
+
+// Stop stops the hook
+func (h *HookBase) Stop() error {
+	return nil
+}
+
+// SetOpts keeps the logger and broker options
+func (h *HookBase) SetOpts(l *slog.Logger, opts *HookOptions) {
+	h.Log, h.Opts = l, opts
+}
+
+// OnStarted does nothing
+func (h *HookBase) OnStarted() {}
+
+// OnStopped does nothing
+func (h *HookBase) OnStopped() {}
+
+// OnConnectAuthenticate denies every client
+func (h *HookBase) OnConnectAuthenticate(cl *Client, pk Packet) bool {
+	return false
+}
+
+// OnACLCheck denies access
+func (h *HookBase) OnACLCheck(cl *Client, topic string, write bool) bool {
+	return false
+}
+
+// OnConnect does nothing
+func (h *HookBase) OnConnect(cl *Client, pk Packet) error {
+	return nil
+}
+
+// OnSessionEstablished does nothing
+func (h *HookBase) OnSessionEstablished(cl *Client, pk Packet) {}
+
+// OnDisconnect does nothing
+func (h *HookBase) OnDisconnect(cl *Client, err error, expire bool) {}
+
+// OnSubscribe returns the packet unchanged
+func (h *HookBase) OnSubscribe(cl *Client, pk Packet) Packet {
+	return pk
+}
+
+// OnSubscribed does nothing
+func (h *HookBase) OnSubscribed(cl *Client, pk Packet, reasonCodes []byte) {}
+
+// OnUnsubscribe returns the packet unchanged
+func (h *HookBase) OnUnsubscribe(cl *Client, pk Packet) Packet {
+	return pk
+}
+
+// OnUnsubscribed does nothing
+func (h *HookBase) OnUnsubscribed(cl *Client, pk Packet) {}
+
+// OnPublish returns the packet unchanged
+func (h *HookBase) OnPublish(cl *Client, pk Packet) (Packet, error) {
+	return pk, nil
+}
+
+// OnPublished does nothing
+func (h *HookBase) OnPublished(cl *Client, pk Packet) {}
+
+// OnPublishDropped does nothing
+func (h *HookBase) OnPublishDropped(cl *Client, pk Packet) {}
+
+// OnRetainMessage does nothing
+func (h *HookBase) OnRetainMessage(cl *Client, pk Packet, r int64) {}
+
+// OnRetainPublished does nothing
+func (h *HookBase) OnRetainPublished(cl *Client, pk Packet) {}
+
+// OnWill returns the will unchanged
+func (h *HookBase) OnWill(cl *Client, will Will) (Will, error) {
+	return will, nil
+}
+
+// OnWillSent does nothing
+func (h *HookBase) OnWillSent(cl *Client, pk Packet) {}
+
+// OnClientExpired does nothing
+func (h *HookBase) OnClientExpired(cl *Client) {}
+
+// OnRetainedExpired does nothing
+func (h *HookBase) OnRetainedExpired(filter string) {}
+
+// Client mirrors the fields of mochi-mqtt's Client that hooks read
+type Client struct {
+	ID         string
+	Net        ClientConnection
+	Properties ClientProperties
+}
+
+// ClientConnection describes the network connection of a client
+type ClientConnection struct {
+	Remote   string
+	Listener string
+	Inline   bool
+}
+
+// ClientProperties holds the CONNECT details of a client
+type ClientProperties struct {
+	Props           Properties
+	Will            Will
+	Username        []byte
+	ProtocolVersion byte
+	Clean           bool
+}
+
+// Will is the last will of a client
+type Will struct {
+	Payload           []byte
+	User              []UserProperty
+	TopicName         string
+	Flag              uint32
+	WillDelayInterval uint32
+	Qos               byte
+	Retain            bool
+}
+
+// FixedHeader is the fixed header of a packet
+type FixedHeader struct {
+	Remaining int
+	Type      byte
+	Qos       byte
+	Dup       bool
+	Retain    bool
+}
+
+// ConnectParams holds the variable header and payload of a CONNECT
+type ConnectParams struct {
+	WillProperties   Properties
+	Password         []byte
+	Username         []byte
+	ProtocolName     []byte
+	WillPayload      []byte
+	ClientIdentifier string
+	WillTopic        string
+	Keepalive        uint16
+	PasswordFlag     bool
+	UsernameFlag     bool
+	WillQos          byte
+	WillFlag         bool
+	WillRetain       bool
+	Clean            bool
+}
+
+// Subscription is a filter of a SUBSCRIBE or UNSUBSCRIBE
+type Subscription struct {
+	Filter            string
+	Identifier        int
+	RetainHandling    byte
+	Qos               byte
+	RetainAsPublished bool
+	NoLocal           bool
+}
+
+// Subscriptions is the filter list of a packet
+type Subscriptions []Subscription
+
+// UserProperty is an MQTT 5 user property
+type UserProperty struct {
+	Key string `json:"k"`
+	Val string `json:"v"`
+}
+
+// Properties mirrors the MQTT 5 properties of mochi-mqtt's packets package that relate to publishes and connects
+type Properties struct {
+	CorrelationData       []byte
+	User                  []UserProperty
+	ContentType           string
+	ResponseTopic         string
+	ReasonString          string
+	MessageExpiryInterval uint32
+	SessionExpiryInterval uint32
+	WillDelayInterval     uint32
+	PayloadFormat         byte
+	PayloadFormatFlag     bool
+}
+
+// Packet mirrors mochi-mqtt's packets.Packet
+type Packet struct {
+	Connect         ConnectParams
+	Properties      Properties
+	Payload         []byte
+	ReasonCodes     []byte
+	Filters         Subscriptions
+	TopicName       string
+	Origin          string
+	FixedHeader     FixedHeader
+	Created         int64
+	Expiry          int64
+	PacketID        uint16
+	ProtocolVersion byte
+	ReasonCode      byte
+}
@@ -0,0 +1,260 @@
+package hook
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// PublishPhase orders the stages of the inbound PUBLISH pipeline
+type PublishPhase byte
+
+const (
+	PhaseDecode PublishPhase = iota
+	PhaseValidate
+	PhaseAuthorize
+	PhaseTransform
+	PhasePersistRetain
+	PhaseRoute
+	PhaseEnqueue
+)
+
+// String returns the string representation of the phase
+func (p PublishPhase) String() string {
+	switch p {
+	case PhaseDecode:
+		return "decode"
+	case PhaseValidate:
+		return "validate"
+	case PhaseAuthorize:
+		return "authorize"
+	case PhaseTransform:
+		return "transform"
+	case PhasePersistRetain:
+		return "persist_retain"
+	case PhaseRoute:
+		return "route"
+	case PhaseEnqueue:
+		return "enqueue"
+	default:
+		return "unknown"
+	}
+}
+
+// PublishContext carries one inbound PUBLISH through the pipeline
+// Stages may replace Packet, e.g. to rewrite the topic for a tenant, and share state through Values
+type PublishContext struct {
+	Context context.Context
+	Client  *Client
+	Packet  *PublishPacket
+	Values  map[string]any
+
+	dropped    bool
+	dropReason DropReason
+}
+
+// NewPublishContext creates a context for a PUBLISH received from client
+func NewPublishContext(ctx context.Context, client *Client, packet *PublishPacket) *PublishContext {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &PublishContext{
+		Context: ctx,
+		Client:  client,
+		Packet:  packet,
+		Values:  make(map[string]any),
+	}
+}
+
+// Drop stops the pipeline without an error, the remaining stages are skipped
+func (pc *PublishContext) Drop(reason DropReason) {
+	pc.dropped = true
+	pc.dropReason = reason
+}
+
+// Dropped reports whether a stage dropped the message and why
+func (pc *PublishContext) Dropped() (DropReason, bool) {
+	return pc.dropReason, pc.dropped
+}
+
+// PublishStage is one step of the inbound PUBLISH pipeline
+type PublishStage interface {
+	// Name identifies the stage, it must be unique within a pipeline
+	Name() string
+
+	// Phase places the stage in the pipeline, stages of the same phase run in the order they were added
+	Phase() PublishPhase
+
+	// Process handles the message, an error rejects the PUBLISH
+	Process(pc *PublishContext) error
+}
+
+type publishStageFunc struct {
+	name  string
+	phase PublishPhase
+	fn    func(*PublishContext) error
+}
+
+func (s *publishStageFunc) Name() string {
+	return s.name
+}
+
+func (s *publishStageFunc) Phase() PublishPhase {
+	return s.phase
+}
+
+func (s *publishStageFunc) Process(pc *PublishContext) error {
+	return s.fn(pc)
+}
+
+// NewPublishStage creates a stage from a function
+func NewPublishStage(name string, phase PublishPhase, fn func(*PublishContext) error) PublishStage {
+	return &publishStageFunc{name: name, phase: phase, fn: fn}
+}
+
+// PublishPipeline runs the inbound PUBLISH path decode → validate → authorize → transform → persist-retain → route → enqueue
+// Features such as schema validation, tenancy rewrite and tracing plug in as stages of the matching phase
+type PublishPipeline struct {
+	mu        sync.Mutex
+	stagesPtr atomic.Pointer[[]PublishStage]
+}
+
+// NewPublishPipeline creates a pipeline with the given stages
+func NewPublishPipeline(stages ...PublishStage) (*PublishPipeline, error) {
+	p := &PublishPipeline{}
+	empty := make([]PublishStage, 0)
+	p.stagesPtr.Store(&empty)
+
+	for _, stage := range stages {
+		if err := p.Add(stage); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Add inserts a stage after the existing stages of its phase
+func (p *PublishPipeline) Add(stage PublishStage) error {
+	if stage == nil || stage.Name() == "" {
+		return ErrEmptyStageName
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	old := *p.stagesPtr.Load()
+	for _, s := range old {
+		if s.Name() == stage.Name() {
+			return fmt.Errorf("%w: %q", ErrStageAlreadyExists, stage.Name())
+		}
+	}
+
+	// Copy-on-write so Process never takes the lock
+	stages := make([]PublishStage, len(old)+1)
+	copy(stages, old)
+	stages[len(old)] = stage
+	sort.SliceStable(stages, func(i, j int) bool {
+		return stages[i].Phase() < stages[j].Phase()
+	})
+	p.stagesPtr.Store(&stages)
+	return nil
+}
+
+// Remove removes the named stage, it reports whether the stage was present
+func (p *PublishPipeline) Remove(name string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	old := *p.stagesPtr.Load()
+	for i, s := range old {
+		if s.Name() == name {
+			stages := make([]PublishStage, 0, len(old)-1)
+			stages = append(stages, old[:i]...)
+			stages = append(stages, old[i+1:]...)
+			p.stagesPtr.Store(&stages)
+			return true
+		}
+	}
+	return false
+}
+
+// Stages returns the stage names in execution order
+func (p *PublishPipeline) Stages() []string {
+	stages := *p.stagesPtr.Load()
+	names := make([]string, len(stages))
+	for i, s := range stages {
+		names[i] = s.Name()
+	}
+	return names
+}
+
+// Process runs pc through every stage until one fails or drops the message
+// The error names the failing stage and wraps its error, so reason codes registered for it still apply
+func (p *PublishPipeline) Process(pc *PublishContext) error {
+	for _, stage := range *p.stagesPtr.Load() {
+		if err := pc.Context.Err(); err != nil {
+			return err
+		}
+		if err := stage.Process(pc); err != nil {
+			return fmt.Errorf("%s: %w", stage.Name(), err)
+		}
+		if pc.dropped {
+			return nil
+		}
+	}
+	return nil
+}
+
+// ManagerFor returns the hook pipeline serving a client, e.g. TenantManagers.ForClient
+type ManagerFor func(client *Client) *Manager
+
+func fixedManager(m *Manager) ManagerFor {
+	return func(*Client) *Manager { return m }
+}
+
+// AuthorizeStage checks publish access with the OnACLCheck hooks of m and drops denied messages
+func AuthorizeStage(m *Manager) PublishStage {
+	return AuthorizeStageFor(fixedManager(m))
+}
+
+// AuthorizeStageFor checks publish access with the pipeline of the publishing client
+func AuthorizeStageFor(managerFor ManagerFor) PublishStage {
+	return NewPublishStage("acl", PhaseAuthorize, func(pc *PublishContext) error {
+		m := managerFor(pc.Client)
+		if !m.OnACLCheck(pc.Client, pc.Packet.Topic, AccessTypeWrite) {
+			pc.Drop(DropReasonACLDenied)
+			m.OnPublishDropped(pc.Client, pc.Packet, DropReasonACLDenied)
+		}
+		return nil
+	})
+}
+
+// HooksStage runs the OnPublish hooks of m, which may modify the packet
+func HooksStage(m *Manager) PublishStage {
+	return HooksStageFor(fixedManager(m))
+}
+
+// HooksStageFor runs the OnPublish hooks of the pipeline of the publishing client with the context of the
+// publish, so its cancellation and deadline reach hooks implementing PublishContextHook
+func HooksStageFor(managerFor ManagerFor) PublishStage {
+	return NewPublishStage("hooks", PhaseTransform, func(pc *PublishContext) error {
+		return managerFor(pc.Client).OnPublishContext(pc.Context, pc.Client, pc.Packet)
+	})
+}
+
+// RetainStage runs the OnRetainMessage hooks of m for retained messages
+func RetainStage(m *Manager) PublishStage {
+	return RetainStageFor(fixedManager(m))
+}
+
+// RetainStageFor runs the OnRetainMessage hooks of the pipeline of the publishing client for retained messages
+func RetainStageFor(managerFor ManagerFor) PublishStage {
+	return NewPublishStage("retain", PhasePersistRetain, func(pc *PublishContext) error {
+		if !pc.Packet.Retain {
+			return nil
+		}
+		return managerFor(pc.Client).OnRetainMessage(pc.Client, pc.Packet)
+	})
+}
@@ -0,0 +1,131 @@
+package hook
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func recordStage(name string, phase PublishPhase, order *[]string) PublishStage {
+	return NewPublishStage(name, phase, func(*PublishContext) error {
+		*order = append(*order, name)
+		return nil
+	})
+}
+
+func TestPublishPhaseString(t *testing.T) {
+	assert.Equal(t, "decode", PhaseDecode.String())
+	assert.Equal(t, "persist_retain", PhasePersistRetain.String())
+	assert.Equal(t, "enqueue", PhaseEnqueue.String())
+	assert.Equal(t, "unknown", PublishPhase(99).String())
+}
+
+func TestPublishPipelineOrder(t *testing.T) {
+	var order []string
+	p, err := NewPublishPipeline(
+		recordStage("route", PhaseRoute, &order),
+		recordStage("schema", PhaseValidate, &order),
+		recordStage("tenant", PhaseTransform, &order),
+	)
+	require.NoError(t, err)
+	require.NoError(t, p.Add(recordStage("trace", PhaseValidate, &order)))
+	require.NoError(t, p.Add(recordStage("decode", PhaseDecode, &order)))
+
+	assert.Equal(t, []string{"decode", "schema", "trace", "tenant", "route"}, p.Stages())
+
+	require.NoError(t, p.Process(NewPublishContext(context.Background(), &Client{ID: "c1"}, &PublishPacket{Topic: "a"})))
+	assert.Equal(t, p.Stages(), order)
+
+	assert.True(t, p.Remove("trace"))
+	assert.False(t, p.Remove("trace"))
+	assert.Equal(t, []string{"decode", "schema", "tenant", "route"}, p.Stages())
+}
+
+func TestPublishPipelineAddErrors(t *testing.T) {
+	var order []string
+	p, err := NewPublishPipeline(recordStage("a", PhaseRoute, &order))
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, p.Add(recordStage("a", PhaseEnqueue, &order)), ErrStageAlreadyExists)
+	assert.ErrorIs(t, p.Add(nil), ErrEmptyStageName)
+	assert.ErrorIs(t, p.Add(recordStage("", PhaseRoute, &order)), ErrEmptyStageName)
+
+	_, err = NewPublishPipeline(recordStage("a", PhaseRoute, &order), recordStage("a", PhaseRoute, &order))
+	assert.ErrorIs(t, err, ErrStageAlreadyExists)
+}
+
+func TestPublishPipelineStopsOnErrorAndDrop(t *testing.T) {
+	errInvalid := errors.New("invalid payload")
+	var order []string
+	p, err := NewPublishPipeline(
+		NewPublishStage("schema", PhaseValidate, func(pc *PublishContext) error {
+			if len(pc.Packet.Payload) == 0 {
+				return errInvalid
+			}
+			if string(pc.Packet.Payload) == "drop" {
+				pc.Drop(DropReasonQuotaExceeded)
+			}
+			return nil
+		}),
+		recordStage("route", PhaseRoute, &order),
+	)
+	require.NoError(t, err)
+
+	err = p.Process(NewPublishContext(nil, nil, &PublishPacket{Topic: "a"}))
+	assert.ErrorIs(t, err, errInvalid)
+	assert.Contains(t, err.Error(), "schema")
+
+	pc := NewPublishContext(nil, nil, &PublishPacket{Topic: "a", Payload: []byte("drop")})
+	require.NoError(t, p.Process(pc))
+	reason, dropped := pc.Dropped()
+	assert.True(t, dropped)
+	assert.Equal(t, DropReasonQuotaExceeded, reason)
+	assert.Empty(t, order)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, p.Process(NewPublishContext(ctx, nil, &PublishPacket{Topic: "a", Payload: []byte("x")})), context.Canceled)
+}
+
+func TestPublishPipelineHookStages(t *testing.T) {
+	m := NewManager()
+	h := newTestHook("h", OnACLCheck, OnPublish, OnRetainMessage, OnPublishDropped)
+	require.NoError(t, m.Add(h))
+
+	p, err := NewPublishPipeline(RetainStage(m), HooksStage(m), AuthorizeStage(m))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"acl", "hooks", "retain"}, p.Stages())
+
+	client := &Client{ID: "c1"}
+	require.NoError(t, p.Process(NewPublishContext(nil, client, &PublishPacket{Topic: "a", Retain: true})))
+	assert.Equal(t, 1, h.getCallCount("OnPublish"))
+	assert.Equal(t, 1, h.getCallCount("OnRetainMessage"))
+
+	h.aclResult = false
+	pc := NewPublishContext(nil, client, &PublishPacket{Topic: "a", Retain: true})
+	require.NoError(t, p.Process(pc))
+	reason, dropped := pc.Dropped()
+	assert.True(t, dropped)
+	assert.Equal(t, DropReasonACLDenied, reason)
+	assert.Equal(t, 1, h.getCallCount("OnPublish"))
+	assert.Equal(t, 1, h.getCallCount("OnPublishDropped"))
+}
+
+func TestPublishPipelineHooksStageContext(t *testing.T) {
+	m := NewManager()
+	h := &ctxPublishHook{Base: NewHookBase("ctx"), started: make(chan struct{})}
+	require.NoError(t, m.Add(h))
+
+	p, err := NewPublishPipeline(HooksStage(m))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = p.Process(NewPublishContext(ctx, &Client{ID: "c1"}, &PublishPacket{Topic: "a"}))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorIs(t, h.cause.Load().(error), context.DeadlineExceeded)
+}
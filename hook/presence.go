@@ -0,0 +1,101 @@
+package hook
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultPresenceTopicPattern is the default topic presence messages are published to
+	DefaultPresenceTopicPattern = "$presence/{clientid}"
+
+	PresenceOnline  = "online"
+	PresenceOffline = "offline"
+)
+
+// PresencePublisher defines the interface for publishing presence messages
+type PresencePublisher interface {
+	PublishPresence(topic string, payload []byte, retain bool) error
+}
+
+// PresenceMessage is the payload published on presence topics
+type PresenceMessage struct {
+	ClientID  string `json:"clientid"`
+	Status    string `json:"status"`
+	Timestamp int64  `json:"timestamp"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// PresenceHook publishes retained online/offline announcements on connect and disconnect
+type PresenceHook struct {
+	*Base
+	pattern   string
+	publisher PresencePublisher
+}
+
+// NewPresenceHook creates a presence hook publishing to the given topic pattern
+// The pattern may contain {clientid} and {username} placeholders
+func NewPresenceHook(publisher PresencePublisher, pattern string) *PresenceHook {
+	if pattern == "" {
+		pattern = DefaultPresenceTopicPattern
+	}
+	return &PresenceHook{
+		Base:      &Base{id: "presence"},
+		pattern:   pattern,
+		publisher: publisher,
+	}
+}
+
+// ID returns the hook identifier
+func (h *PresenceHook) ID() string {
+	return h.id
+}
+
+// Provides indicates this hook provides session establishment and disconnect handling
+func (h *PresenceHook) Provides(event Event) bool {
+	return event == OnSessionEstablished || event == OnDisconnect
+}
+
+// Topic returns the presence topic for a client
+func (h *PresenceHook) Topic(client *Client) string {
+	return strings.NewReplacer("{clientid}", client.ID, "{username}", client.Username).Replace(h.pattern)
+}
+
+// OnSessionEstablished publishes an online announcement
+func (h *PresenceHook) OnSessionEstablished(client *Client, _ *ConnectPacket) error {
+	if client == nil {
+		return nil
+	}
+	return h.publish(client, PresenceOnline, "")
+}
+
+// OnDisconnect publishes an offline announcement, unless the will message of the client was published
+// and already reports to the presence topic
+func (h *PresenceHook) OnDisconnect(client *Client, info *DisconnectInfo) error {
+	if client == nil {
+		return nil
+	}
+	if info.WillPublished() && client.Will != nil && client.Will.Topic == h.Topic(client) {
+		return nil
+	}
+
+	reason := ""
+	if err := info.GetErr(); err != nil {
+		reason = err.Error()
+	}
+	return h.publish(client, PresenceOffline, reason)
+}
+
+func (h *PresenceHook) publish(client *Client, status, reason string) error {
+	payload, err := json.Marshal(&PresenceMessage{
+		ClientID:  client.ID,
+		Status:    status,
+		Timestamp: time.Now().Unix(),
+		Reason:    reason,
+	})
+	if err != nil {
+		return err
+	}
+	return h.publisher.PublishPresence(h.Topic(client), payload, true)
+}
@@ -0,0 +1,88 @@
+package hook
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type presenceRecord struct {
+	topic   string
+	payload []byte
+	retain  bool
+}
+
+type mockPresencePublisher struct {
+	records []presenceRecord
+}
+
+func (p *mockPresencePublisher) PublishPresence(topic string, payload []byte, retain bool) error {
+	p.records = append(p.records, presenceRecord{topic: topic, payload: payload, retain: retain})
+	return nil
+}
+
+func TestPresenceHook(t *testing.T) {
+	hook := NewPresenceHook(&mockPresencePublisher{}, "")
+
+	assert.Equal(t, "presence", hook.ID())
+	assert.True(t, hook.Provides(OnSessionEstablished))
+	assert.True(t, hook.Provides(OnDisconnect))
+	assert.False(t, hook.Provides(OnPublish))
+	assert.Equal(t, "$presence/c1", hook.Topic(&Client{ID: "c1"}))
+}
+
+func TestPresenceHookTopicPattern(t *testing.T) {
+	hook := NewPresenceHook(&mockPresencePublisher{}, "tenants/{username}/devices/{clientid}/presence")
+	assert.Equal(t, "tenants/alice/devices/c1/presence", hook.Topic(&Client{ID: "c1", Username: "alice"}))
+}
+
+func TestPresenceHookOnlineOffline(t *testing.T) {
+	pub := &mockPresencePublisher{}
+	hook := NewPresenceHook(pub, "")
+	client := &Client{ID: "c1"}
+
+	require.NoError(t, hook.OnSessionEstablished(client, &ConnectPacket{}))
+	require.NoError(t, hook.OnDisconnect(client, &DisconnectInfo{}))
+	require.Len(t, pub.records, 2)
+
+	var online, offline PresenceMessage
+	require.NoError(t, json.Unmarshal(pub.records[0].payload, &online))
+	require.NoError(t, json.Unmarshal(pub.records[1].payload, &offline))
+
+	assert.Equal(t, "$presence/c1", pub.records[0].topic)
+	assert.True(t, pub.records[0].retain)
+	assert.Equal(t, PresenceOnline, online.Status)
+	assert.Equal(t, "c1", online.ClientID)
+	assert.Equal(t, PresenceOffline, offline.Status)
+	assert.Empty(t, offline.Reason)
+}
+
+func TestPresenceHookWillIntegration(t *testing.T) {
+	tests := []struct {
+		name      string
+		will      *WillMessage
+		info      *DisconnectInfo
+		published bool
+	}{
+		{name: "abnormal with presence will", will: &WillMessage{Topic: "$presence/c1"}, info: &DisconnectInfo{Initiator: DisconnectByNetwork, Err: errors.New("timeout")}, published: false},
+		{name: "abnormal with other will", will: &WillMessage{Topic: "other"}, info: &DisconnectInfo{Initiator: DisconnectByNetwork, Err: errors.New("timeout")}, published: true},
+		{name: "abnormal without will", info: &DisconnectInfo{Initiator: DisconnectByServer, ReasonCode: encoding.ReasonKeepAliveTimeout}, published: true},
+		{name: "normal with presence will", will: &WillMessage{Topic: "$presence/c1"}, info: &DisconnectInfo{}, published: true},
+		{name: "will requested with presence will", will: &WillMessage{Topic: "$presence/c1"}, info: &DisconnectInfo{ReasonCode: encoding.ReasonDisconnectWithWillMessage}, published: false},
+		{name: "nil info with presence will", will: &WillMessage{Topic: "$presence/c1"}, published: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pub := &mockPresencePublisher{}
+			hook := NewPresenceHook(pub, "")
+
+			require.NoError(t, hook.OnDisconnect(&Client{ID: "c1", Will: tt.will}, tt.info))
+			assert.Equal(t, tt.published, len(pub.records) == 1)
+		})
+	}
+}
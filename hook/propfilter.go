@@ -0,0 +1,412 @@
+package hook
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// DefaultFilterProperty is the SUBSCRIBE user property carrying a subscription filter expression
+const DefaultFilterProperty = "filter"
+
+// PropertyFilter is a parsed filter expression evaluated against PUBLISH user properties
+//
+// Expressions compare user properties with quoted values and combine comparisons with
+// and, or, not and parentheses, e.g. `priority = "high" and (site = "a" or site = "b")`
+// A comparison with = holds when any value of the property is equal, != holds when none is
+type PropertyFilter struct {
+	expr string
+	root filterNode
+}
+
+// ParsePropertyFilter parses a filter expression
+func ParsePropertyFilter(expr string) (*PropertyFilter, error) {
+	p := &filterParser{input: expr}
+	p.next()
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	if p.tok.kind != tokenEOF {
+		return nil, p.errorf("unexpected %q", p.tok.text)
+	}
+	return &PropertyFilter{expr: expr, root: root}, nil
+}
+
+// String returns the expression the filter was parsed from
+func (f *PropertyFilter) String() string {
+	return f.expr
+}
+
+// Match evaluates the filter against the user properties in props
+func (f *PropertyFilter) Match(props Properties) bool {
+	return f.root.eval(props)
+}
+
+type filterNode interface {
+	eval(props Properties) bool
+}
+
+type andNode struct{ left, right filterNode }
+type orNode struct{ left, right filterNode }
+type notNode struct{ operand filterNode }
+
+type compareNode struct {
+	key   string
+	value string
+	equal bool
+}
+
+func (n *andNode) eval(props Properties) bool { return n.left.eval(props) && n.right.eval(props) }
+func (n *orNode) eval(props Properties) bool  { return n.left.eval(props) || n.right.eval(props) }
+func (n *notNode) eval(props Properties) bool { return !n.operand.eval(props) }
+
+func (n *compareNode) eval(props Properties) bool {
+	for _, v := range props.UserProperty(n.key) {
+		if v == n.value {
+			return n.equal
+		}
+	}
+	return !n.equal
+}
+
+type tokenKind byte
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenEq
+	tokenNe
+	tokenLParen
+	tokenRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// filterParser is a recursive descent parser over a single token of lookahead
+type filterParser struct {
+	input string
+	pos   int
+	tok   token
+	err   error
+}
+
+func (p *filterParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%w: %s at offset %d", ErrInvalidPropertyFilter, fmt.Sprintf(format, args...), p.tok.pos)
+}
+
+// next advances to the next token, lexing errors are reported by the parse functions
+func (p *filterParser) next() {
+	for p.pos < len(p.input) && (p.input[p.pos] == ' ' || p.input[p.pos] == '\t') {
+		p.pos++
+	}
+
+	start := p.pos
+	if p.pos >= len(p.input) {
+		p.tok = token{kind: tokenEOF, pos: start}
+		return
+	}
+
+	switch c := p.input[p.pos]; {
+	case c == '(':
+		p.pos++
+		p.tok = token{kind: tokenLParen, text: "(", pos: start}
+	case c == ')':
+		p.pos++
+		p.tok = token{kind: tokenRParen, text: ")", pos: start}
+	case c == '=':
+		p.pos++
+		p.tok = token{kind: tokenEq, text: "=", pos: start}
+	case c == '!' && p.pos+1 < len(p.input) && p.input[p.pos+1] == '=':
+		p.pos += 2
+		p.tok = token{kind: tokenNe, text: "!=", pos: start}
+	case c == '"':
+		p.lexString(start)
+	case isIdentByte(c):
+		for p.pos < len(p.input) && isIdentByte(p.input[p.pos]) {
+			p.pos++
+		}
+		p.tok = token{kind: tokenIdent, text: p.input[start:p.pos], pos: start}
+	default:
+		p.pos++
+		p.tok = token{kind: tokenIdent, text: string(c), pos: start}
+		p.err = fmt.Errorf("%w: unexpected %q at offset %d", ErrInvalidPropertyFilter, c, start)
+	}
+}
+
+// lexString reads a double-quoted value, a backslash escapes the next byte
+func (p *filterParser) lexString(start int) {
+	var b strings.Builder
+	p.pos++
+	for p.pos < len(p.input) {
+		c := p.input[p.pos]
+		switch {
+		case c == '"':
+			p.pos++
+			p.tok = token{kind: tokenString, text: b.String(), pos: start}
+			return
+		case c == '\\' && p.pos+1 < len(p.input):
+			b.WriteByte(p.input[p.pos+1])
+			p.pos += 2
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+	p.tok = token{kind: tokenEOF, pos: start}
+	p.err = fmt.Errorf("%w: unterminated string at offset %d", ErrInvalidPropertyFilter, start)
+}
+
+func isIdentByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-' || c == '.'
+}
+
+func (p *filterParser) keyword(word string) bool {
+	return p.tok.kind == tokenIdent && strings.EqualFold(p.tok.text, word)
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterNode, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	if p.keyword("not") {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand: operand}, nil
+	}
+
+	if p.tok.kind == tokenLParen {
+		p.next()
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokenRParen {
+			return nil, p.errorf("expected )")
+		}
+		p.next()
+		return node, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (filterNode, error) {
+	if p.tok.kind != tokenIdent {
+		return nil, p.errorf("expected property name")
+	}
+	key := p.tok.text
+	p.next()
+
+	if p.err != nil {
+		return nil, p.err
+	}
+	if p.tok.kind != tokenEq && p.tok.kind != tokenNe {
+		return nil, p.errorf("expected = or != after %q", key)
+	}
+	equal := p.tok.kind == tokenEq
+	p.next()
+
+	if p.err != nil {
+		return nil, p.err
+	}
+	if p.tok.kind != tokenString {
+		return nil, p.errorf("expected quoted value for %q", key)
+	}
+	value := p.tok.text
+	p.next()
+
+	return &compareNode{key: key, value: value, equal: equal}, nil
+}
+
+// subscriptionKey identifies a subscription of a client
+type subscriptionKey struct {
+	clientID    string
+	topicFilter string
+}
+
+// PropertyFilterHook lets subscribers attach a filter expression to a subscription through a SUBSCRIBE
+// user property, publishes whose user properties do not match are not delivered to that subscription
+type PropertyFilterHook struct {
+	*Base
+	mu       sync.RWMutex
+	property string
+	filters  map[subscriptionKey]*PropertyFilter
+}
+
+// NewPropertyFilterHook creates a property filter hook reading expressions from the named
+// SUBSCRIBE user property, an empty name uses DefaultFilterProperty
+func NewPropertyFilterHook(property string) *PropertyFilterHook {
+	if property == "" {
+		property = DefaultFilterProperty
+	}
+	return &PropertyFilterHook{
+		Base:     &Base{id: "property-filter"},
+		property: property,
+		filters:  make(map[subscriptionKey]*PropertyFilter),
+	}
+}
+
+// ID returns the hook identifier
+func (h *PropertyFilterHook) ID() string {
+	return h.id
+}
+
+// Provides indicates this hook tracks subscriptions and selects subscribers
+func (h *PropertyFilterHook) Provides(event Event) bool {
+	switch event {
+	case OnSubscribe, OnSubscribed, OnUnsubscribed, OnClientExpired, OnSelectSubscribers:
+		return true
+	}
+	return false
+}
+
+// parseFilter parses the filter expression of sub, returning nil when it has none
+func (h *PropertyFilterHook) parseFilter(sub *Subscription) (*PropertyFilter, error) {
+	values := sub.Properties.UserProperty(h.property)
+	if len(values) == 0 {
+		return nil, nil
+	}
+	if len(values) > 1 {
+		return nil, fmt.Errorf("%w: more than one %q property", ErrInvalidPropertyFilter, h.property)
+	}
+	return ParsePropertyFilter(values[0])
+}
+
+// OnSubscribe rejects subscriptions with an invalid filter expression
+func (h *PropertyFilterHook) OnSubscribe(_ *Client, sub *Subscription) error {
+	if sub == nil {
+		return nil
+	}
+	_, err := h.parseFilter(sub)
+	return err
+}
+
+// OnSubscribed records the filter of a subscription, resubscribing without one removes it
+func (h *PropertyFilterHook) OnSubscribed(client *Client, sub *Subscription) error {
+	if sub == nil {
+		return nil
+	}
+	filter, err := h.parseFilter(sub)
+	if err != nil {
+		return err
+	}
+
+	key := subscriptionKey{clientID: subscriptionClientID(client, sub), topicFilter: sub.TopicFilter}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if filter == nil {
+		delete(h.filters, key)
+	} else {
+		h.filters[key] = filter
+	}
+	return nil
+}
+
+// OnUnsubscribed forgets the filter of the subscription
+func (h *PropertyFilterHook) OnUnsubscribed(client *Client, topicFilter string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.filters, subscriptionKey{clientID: client.GetID(), topicFilter: topicFilter})
+	return nil
+}
+
+// OnClientExpired forgets every filter of an expired session
+func (h *PropertyFilterHook) OnClientExpired(clientID string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for key := range h.filters {
+		if key.clientID == clientID {
+			delete(h.filters, key)
+		}
+	}
+	return nil
+}
+
+// OnSelectSubscribers drops subscriptions whose filter does not match the publish being dispatched
+func (h *PropertyFilterHook) OnSelectSubscribers(subscribers *Subscribers, _ string) error {
+	if subscribers == nil || subscribers.Packet == nil {
+		return nil
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if len(h.filters) == 0 {
+		return nil
+	}
+
+	props := subscribers.Packet.Properties
+	n := 0
+	for _, sub := range subscribers.Subscriptions {
+		filter, ok := h.filters[subscriptionKey{clientID: sub.ClientID, topicFilter: sub.TopicFilter}]
+		if ok && !filter.Match(props) {
+			continue
+		}
+		subscribers.Subscriptions[n] = sub
+		n++
+	}
+	for i := n; i < len(subscribers.Subscriptions); i++ {
+		subscribers.Subscriptions[i] = nil
+	}
+	subscribers.Subscriptions = subscribers.Subscriptions[:n]
+	return nil
+}
+
+// SubscriptionFilter returns the filter recorded for a subscription, or nil
+func (h *PropertyFilterHook) SubscriptionFilter(clientID, topicFilter string) *PropertyFilter {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.filters[subscriptionKey{clientID: clientID, topicFilter: topicFilter}]
+}
+
+func subscriptionClientID(client *Client, sub *Subscription) string {
+	if sub.ClientID != "" {
+		return sub.ClientID
+	}
+	return client.GetID()
+}
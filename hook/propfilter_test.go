@@ -0,0 +1,119 @@
+package hook
+
+import (
+	"testing"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func userProperties(pairs ...string) Properties {
+	props := make([]encoding.UTF8Pair, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		props = append(props, encoding.UTF8Pair{Key: pairs[i], Value: pairs[i+1]})
+	}
+	return Properties{"UserProperty": props}
+}
+
+func TestPropertyFilter_Match(t *testing.T) {
+	tests := []struct {
+		name  string
+		expr  string
+		props Properties
+		want  bool
+	}{
+		{name: "equal", expr: `priority = "high"`, props: userProperties("priority", "high"), want: true},
+		{name: "not equal value", expr: `priority = "high"`, props: userProperties("priority", "low"), want: false},
+		{name: "missing property", expr: `priority = "high"`, props: nil, want: false},
+		{name: "inequality", expr: `priority != "low"`, props: userProperties("priority", "high"), want: true},
+		{name: "inequality missing", expr: `priority != "low"`, props: nil, want: true},
+		{name: "repeated property", expr: `tag = "b"`, props: userProperties("tag", "a", "tag", "b"), want: true},
+		{name: "and", expr: `a = "1" and b = "2"`, props: userProperties("a", "1", "b", "3"), want: false},
+		{name: "or", expr: `a = "1" OR b = "2"`, props: userProperties("b", "2"), want: true},
+		{name: "and binds tighter", expr: `a = "1" or b = "2" and c = "3"`, props: userProperties("a", "1"), want: true},
+		{name: "parentheses", expr: `(a = "1" or b = "2") and c = "3"`, props: userProperties("a", "1"), want: false},
+		{name: "not", expr: `not (site = "a")`, props: userProperties("site", "b"), want: true},
+		{name: "escaped quote", expr: `name = "say \"hi\""`, props: userProperties("name", `say "hi"`), want: true},
+		{name: "map form", expr: `priority = "high"`, props: Properties{"UserProperty": map[string]string{"priority": "high"}}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParsePropertyFilter(tt.expr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expr, f.String())
+			assert.Equal(t, tt.want, f.Match(tt.props))
+		})
+	}
+}
+
+func TestParsePropertyFilter_Invalid(t *testing.T) {
+	for _, expr := range []string{
+		``,
+		`priority`,
+		`priority = high`,
+		`priority == "high"`,
+		`priority = "high`,
+		`(a = "1"`,
+		`a = "1" b = "2"`,
+		`a = "1" and`,
+		`a = "1" "x`,
+		`a > "1"`,
+	} {
+		t.Run(expr, func(t *testing.T) {
+			_, err := ParsePropertyFilter(expr)
+			assert.ErrorIs(t, err, ErrInvalidPropertyFilter)
+		})
+	}
+}
+
+func TestPropertyFilterHook(t *testing.T) {
+	h := NewPropertyFilterHook("")
+	assert.True(t, h.Provides(OnSelectSubscribers))
+	assert.True(t, h.Provides(OnSubscribe))
+	assert.False(t, h.Provides(OnPublish))
+
+	client := &Client{ID: "device-1"}
+	filtered := &Subscription{TopicFilter: "alerts/#", Properties: userProperties("filter", `priority = "high"`)}
+	require.NoError(t, h.OnSubscribe(client, filtered))
+	require.NoError(t, h.OnSubscribed(client, filtered))
+	require.NotNil(t, h.SubscriptionFilter("device-1", "alerts/#"))
+
+	invalid := &Subscription{TopicFilter: "x", Properties: userProperties("filter", `priority =`)}
+	assert.ErrorIs(t, h.OnSubscribe(client, invalid), ErrInvalidPropertyFilter)
+
+	dispatch := func(props Properties) []string {
+		subs := &Subscribers{
+			Subscriptions: []*Subscription{
+				{ClientID: "device-1", TopicFilter: "alerts/#"},
+				{ClientID: "device-2", TopicFilter: "alerts/#"},
+			},
+			Packet: &PublishPacket{Topic: "alerts/fire", Properties: props},
+		}
+		require.NoError(t, h.OnSelectSubscribers(subs, "alerts/fire"))
+		ids := make([]string, 0, len(subs.Subscriptions))
+		for _, sub := range subs.Subscriptions {
+			ids = append(ids, sub.ClientID)
+		}
+		return ids
+	}
+
+	assert.Equal(t, []string{"device-1", "device-2"}, dispatch(userProperties("priority", "high")))
+	assert.Equal(t, []string{"device-2"}, dispatch(userProperties("priority", "low")))
+
+	subs := &Subscribers{Subscriptions: []*Subscription{{ClientID: "device-1", TopicFilter: "alerts/#"}}}
+	require.NoError(t, h.OnSelectSubscribers(subs, "alerts/fire"))
+	assert.Len(t, subs.Subscriptions, 1)
+
+	require.NoError(t, h.OnSubscribed(client, &Subscription{TopicFilter: "alerts/#"}))
+	assert.Nil(t, h.SubscriptionFilter("device-1", "alerts/#"))
+
+	require.NoError(t, h.OnSubscribed(client, filtered))
+	require.NoError(t, h.OnUnsubscribed(client, "alerts/#"))
+	assert.Nil(t, h.SubscriptionFilter("device-1", "alerts/#"))
+
+	require.NoError(t, h.OnSubscribed(client, filtered))
+	require.NoError(t, h.OnClientExpired("device-1"))
+	assert.Nil(t, h.SubscriptionFilter("device-1", "alerts/#"))
+}
@@ -0,0 +1,95 @@
+//go:build integration
+
+package hook
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupRateLimitRedis(t *testing.T) *redis.Client {
+	t.Helper()
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		_ = client.Close()
+		t.Skipf("Redis not available at %s: %v", addr, err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func TestRedisTokenBucket(t *testing.T) {
+	client := setupRateLimitRedis(t)
+	ctx := context.Background()
+	prefix := "test:" + t.Name() + ":"
+	t.Cleanup(func() { client.Del(ctx, prefix+"k") })
+
+	bucket := NewRedisTokenBucket(client, prefix, 20, 3)
+	for range 3 {
+		ok, _, err := bucket.Allow(ctx, "k")
+		require.NoError(t, err)
+		assert.True(t, ok)
+	}
+
+	ok, wait, err := bucket.Allow(ctx, "k")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Greater(t, wait, time.Duration(0))
+	assert.LessOrEqual(t, wait, 50*time.Millisecond)
+
+	time.Sleep(60 * time.Millisecond)
+	ok, _, err = bucket.Allow(ctx, "k")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ttl, err := client.PTTL(ctx, prefix+"k").Result()
+	require.NoError(t, err)
+	assert.Greater(t, ttl, time.Duration(0))
+}
+
+func TestRedisRateLimitHookSharedAcrossNodes(t *testing.T) {
+	client := setupRateLimitRedis(t)
+	ctx := context.Background()
+	prefix := "test:" + t.Name() + ":"
+	t.Cleanup(func() {
+		client.Del(ctx, prefix+"client:c1", prefix+"client:c2", prefix+"tenant:acme")
+	})
+
+	config := RedisRateLimitConfig{
+		Client:      client,
+		Prefix:      prefix,
+		ClientRate:  0.001,
+		ClientBurst: 2,
+		TenantRate:  0.001,
+		TenantBurst: 3,
+	}
+	node1, err := NewRedisRateLimitHook(config)
+	require.NoError(t, err)
+	node2, err := NewRedisRateLimitHook(config)
+	require.NoError(t, err)
+
+	c1 := &Client{ID: "c1", Metadata: map[string]string{TenantMetadataKey: "acme"}}
+	c2 := &Client{ID: "c2", Metadata: map[string]string{TenantMetadataKey: "acme"}}
+	packet := &PublishPacket{Topic: "a"}
+
+	assert.NoError(t, node1.OnPublish(c1, packet))
+	assert.NoError(t, node2.OnPublish(c1, packet))
+	assert.ErrorIs(t, node1.OnPublish(c1, packet), ErrClientRateLimitExceeded)
+
+	assert.NoError(t, node2.OnPublish(c2, packet))
+	assert.ErrorIs(t, node1.OnPublish(c2, packet), ErrTenantRateLimitExceeded)
+
+	assert.Equal(t, RedisRateLimitStats{Allowed: 2, Limited: 2}, node1.Stats())
+	assert.Equal(t, RedisRateLimitStats{Allowed: 2}, node2.Stats())
+}
@@ -0,0 +1,83 @@
+package hook
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// unreachableRedis returns a client whose every command fails quickly
+func unreachableRedis(t *testing.T) *redis.Client {
+	t.Helper()
+	client := redis.NewClient(&redis.Options{
+		Addr:        "127.0.0.1:1",
+		DialTimeout: 50 * time.Millisecond,
+		MaxRetries:  -1,
+	})
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func TestNewRedisRateLimitHookValidation(t *testing.T) {
+	client := unreachableRedis(t)
+
+	tests := []struct {
+		name   string
+		config RedisRateLimitConfig
+	}{
+		{name: "nil client", config: RedisRateLimitConfig{ClientRate: 1, ClientBurst: 1}},
+		{name: "negative rate", config: RedisRateLimitConfig{Client: client, TenantRate: -1}},
+		{name: "client burst", config: RedisRateLimitConfig{Client: client, ClientRate: 10}},
+		{name: "tenant burst", config: RedisRateLimitConfig{Client: client, TenantRate: 10}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRedisRateLimitHook(tt.config)
+			assert.ErrorIs(t, err, ErrInvalidRateLimitConfig)
+		})
+	}
+}
+
+func TestRedisRateLimitHookUnavailable(t *testing.T) {
+	client := &Client{ID: "c1", Metadata: map[string]string{TenantMetadataKey: "acme"}}
+
+	t.Run("fail closed", func(t *testing.T) {
+		h, err := NewRedisRateLimitHook(RedisRateLimitConfig{Client: unreachableRedis(t), ClientRate: 10, ClientBurst: 10})
+		require.NoError(t, err)
+
+		err = h.OnPublish(client, &PublishPacket{Topic: "a"})
+		assert.ErrorIs(t, err, ErrRateLimitUnavailable)
+		assert.Equal(t, RedisRateLimitStats{Errors: 1}, h.Stats())
+	})
+
+	t.Run("fail open", func(t *testing.T) {
+		h, err := NewRedisRateLimitHook(RedisRateLimitConfig{
+			Client:      unreachableRedis(t),
+			ClientRate:  10,
+			ClientBurst: 10,
+			TenantRate:  10,
+			TenantBurst: 10,
+			FailOpen:    true,
+		})
+		require.NoError(t, err)
+
+		assert.NoError(t, h.OnPublish(client, &PublishPacket{Topic: "a"}))
+		assert.Equal(t, RedisRateLimitStats{Allowed: 1, Errors: 2}, h.Stats())
+	})
+}
+
+func TestRedisRateLimitHookDisabledLimits(t *testing.T) {
+	h, err := NewRedisRateLimitHook(RedisRateLimitConfig{Client: unreachableRedis(t)})
+	require.NoError(t, err)
+
+	assert.Equal(t, "redis-rate-limit", h.ID())
+	assert.True(t, h.Provides(OnPublish))
+	assert.False(t, h.Provides(OnConnect))
+	assert.NoError(t, h.OnPublish(&Client{ID: "c1"}, &PublishPacket{Topic: "a"}))
+	assert.True(t, errors.Is(h.OnPublish(nil, &PublishPacket{Topic: "a"}), ErrRatelimitClientNil))
+}
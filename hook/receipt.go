@@ -0,0 +1,151 @@
+package hook
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/axmq/ax/encoding"
+)
+
+// User properties of delivery receipts
+const (
+	// ReceiptRequestProperty asks the broker for a delivery receipt, any value but "false" or "0" does
+	ReceiptRequestProperty = "request-receipt"
+	// ReceiptDeliveredProperty and ReceiptFailedProperty carry the counts of a receipt
+	ReceiptDeliveredProperty = "delivered-count"
+	ReceiptFailedProperty    = "failed-count"
+)
+
+// ReceiptContentType is the content type of the JSON receipt payload
+const ReceiptContentType = "application/json"
+
+// deliveryTallyKey holds the DeliveryTally of a publish in PublishContext.Values
+const deliveryTallyKey = "delivery-tally"
+
+// DeliveryTally counts the outcome of fanning one message out to its subscribers
+type DeliveryTally struct {
+	Delivered int
+	Failed    int
+}
+
+// DeliveryTallyOf returns the tally of the publish carried by pc, the fan-out stage records every copy in it
+func DeliveryTallyOf(pc *PublishContext) *DeliveryTally {
+	if tally, ok := pc.Values[deliveryTallyKey].(*DeliveryTally); ok {
+		return tally
+	}
+	tally := &DeliveryTally{}
+	pc.Values[deliveryTallyKey] = tally
+	return tally
+}
+
+// ReceiptRequested reports whether the publisher of packet asked for a delivery receipt
+func ReceiptRequested(packet *PublishPacket) bool {
+	if packet == nil {
+		return false
+	}
+	for _, value := range packet.Properties.UserProperty(ReceiptRequestProperty) {
+		if value != "false" && value != "0" {
+			return true
+		}
+	}
+	return false
+}
+
+// DeliveryReceipt is the JSON payload of a receipt
+type DeliveryReceipt struct {
+	Topic     string `json:"topic"`
+	Delivered int    `json:"delivered"`
+	Failed    int    `json:"failed"`
+}
+
+// DeliveryReceiptsConfig configures DeliveryReceipts
+type DeliveryReceiptsConfig struct {
+	// Topic receives the receipts of publishes without a Response Topic, {clientid} is replaced by the
+	// publisher ID. Such publishes get no receipt when empty
+	Topic string
+}
+
+// DeliveryReceiptsStats holds the counters of DeliveryReceipts
+type DeliveryReceiptsStats struct {
+	Requested uint64
+	Sent      uint64
+	Failed    uint64
+}
+
+// DeliveryReceipts tells publishers how many subscribers a message reached, bridging the gap between the broker
+// acknowledging a publish and the subscribers receiving it
+// A publisher sets the ReceiptRequestProperty user property, once fan-out completes the broker publishes the
+// delivered and failed counts to the Response Topic of the message with its Correlation Data. The receipt is
+// published on behalf of the publisher, so its ACL decides which reply topics it may use
+type DeliveryReceipts struct {
+	config    DeliveryReceiptsConfig
+	requested atomic.Uint64
+	sent      atomic.Uint64
+	failed    atomic.Uint64
+}
+
+// NewDeliveryReceipts creates delivery receipts
+func NewDeliveryReceipts(config DeliveryReceiptsConfig) *DeliveryReceipts {
+	return &DeliveryReceipts{config: config}
+}
+
+// Receipt builds the receipt of the publish carried by pc from its tally, nil when no receipt was requested or
+// there is no topic to send it to
+func (r *DeliveryReceipts) Receipt(pc *PublishContext) *PublishPacket {
+	packet := pc.Packet
+	if !ReceiptRequested(packet) {
+		return nil
+	}
+	replyTopic, _ := packet.Properties[encoding.PropResponseTopic.String()].(string)
+	if replyTopic == "" && r.config.Topic != "" {
+		replyTopic = strings.ReplaceAll(r.config.Topic, "{clientid}", pc.Client.GetID())
+	}
+	if replyTopic == "" {
+		return nil
+	}
+	r.requested.Add(1)
+
+	tally := DeliveryTallyOf(pc)
+	payload, _ := json.Marshal(DeliveryReceipt{Topic: packet.Topic, Delivered: tally.Delivered, Failed: tally.Failed})
+	props := Properties{
+		encoding.PropContentType.String():            ReceiptContentType,
+		encoding.PropPayloadFormatIndicator.String(): byte(1),
+		encoding.PropUserProperty.String(): []encoding.UTF8Pair{
+			{Key: ReceiptDeliveredProperty, Value: strconv.Itoa(tally.Delivered)},
+			{Key: ReceiptFailedProperty, Value: strconv.Itoa(tally.Failed)},
+		},
+	}
+	if correlation, ok := packet.Properties[encoding.PropCorrelationData.String()].([]byte); ok {
+		props[encoding.PropCorrelationData.String()] = correlation
+	}
+
+	return &PublishPacket{
+		Topic:           replyTopic,
+		Payload:         payload,
+		QoS:             packet.QoS,
+		Properties:      props,
+		ProtocolVersion: byte(encoding.ProtocolVersion50),
+		Created:         packet.Created,
+		Origin:          "receipt/" + pc.Client.GetID(),
+	}
+}
+
+// Record counts the outcome of publishing a receipt, a nil error is a success
+func (r *DeliveryReceipts) Record(err error) {
+	if err != nil {
+		r.failed.Add(1)
+		return
+	}
+	r.sent.Add(1)
+}
+
+// Stats returns the counters of the receipts
+func (r *DeliveryReceipts) Stats() DeliveryReceiptsStats {
+	return DeliveryReceiptsStats{
+		Requested: r.requested.Load(),
+		Sent:      r.sent.Load(),
+		Failed:    r.failed.Load(),
+	}
+}
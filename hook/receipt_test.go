@@ -0,0 +1,62 @@
+package hook
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func requestReceipt(value string) Properties {
+	return Properties{encoding.PropUserProperty.String(): []encoding.UTF8Pair{{Key: ReceiptRequestProperty, Value: value}}}
+}
+
+func TestReceiptRequested(t *testing.T) {
+	assert.False(t, ReceiptRequested(nil))
+	assert.False(t, ReceiptRequested(&PublishPacket{}))
+	assert.True(t, ReceiptRequested(&PublishPacket{Properties: requestReceipt("true")}))
+	assert.True(t, ReceiptRequested(&PublishPacket{Properties: requestReceipt("")}))
+	assert.False(t, ReceiptRequested(&PublishPacket{Properties: requestReceipt("false")}))
+	assert.True(t, ReceiptRequested(&PublishPacket{Properties: Properties{
+		encoding.PropUserProperty.String(): map[string]string{ReceiptRequestProperty: "yes"},
+	}}))
+}
+
+func TestDeliveryReceipts(t *testing.T) {
+	client := &Client{ID: "c1"}
+	r := NewDeliveryReceipts(DeliveryReceiptsConfig{})
+
+	pc := NewPublishContext(context.Background(), client, &PublishPacket{Topic: "a/b", QoS: 2, Properties: requestReceipt("1")})
+	assert.Nil(t, r.Receipt(pc), "no reply topic")
+	assert.Nil(t, r.Receipt(NewPublishContext(context.Background(), client, &PublishPacket{Topic: "a/b"})))
+
+	pc.Packet.Properties[encoding.PropResponseTopic.String()] = "replies/c1"
+	tally := DeliveryTallyOf(pc)
+	tally.Delivered, tally.Failed = 3, 1
+	assert.Same(t, tally, DeliveryTallyOf(pc))
+
+	receipt := r.Receipt(pc)
+	require.NotNil(t, receipt)
+	assert.Equal(t, "replies/c1", receipt.Topic)
+	assert.Equal(t, byte(2), receipt.QoS)
+	assert.Equal(t, "receipt/c1", receipt.Origin)
+	assert.JSONEq(t, `{"topic": "a/b", "delivered": 3, "failed": 1}`, string(receipt.Payload))
+	assert.Equal(t, ReceiptContentType, receipt.Properties[encoding.PropContentType.String()])
+	assert.Equal(t, []string{"1"}, receipt.Properties.UserProperty(ReceiptFailedProperty))
+	assert.False(t, ReceiptRequested(receipt), "receipts do not request receipts")
+	_, ok := receipt.Properties[encoding.PropCorrelationData.String()]
+	assert.False(t, ok)
+
+	r = NewDeliveryReceipts(DeliveryReceiptsConfig{Topic: "receipts/{clientid}"})
+	receipt = r.Receipt(NewPublishContext(context.Background(), client, &PublishPacket{Topic: "a/b", Properties: requestReceipt("")}))
+	require.NotNil(t, receipt)
+	assert.Equal(t, "receipts/c1", receipt.Topic)
+	assert.JSONEq(t, `{"topic": "a/b", "delivered": 0, "failed": 0}`, string(receipt.Payload))
+
+	r.Record(nil)
+	r.Record(errors.New("denied"))
+	assert.Equal(t, DeliveryReceiptsStats{Requested: 1, Sent: 1, Failed: 1}, r.Stats())
+}
@@ -0,0 +1,193 @@
+package hook
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/axmq/ax/topic"
+)
+
+// DefaultRedactionMask replaces redacted payloads
+const DefaultRedactionMask = "[redacted]"
+
+// RedactConfig configures payload redaction
+type RedactConfig struct {
+	// Filters are the topic filters whose payloads are masked, e.g. patients/+/vitals
+	Filters []string `json:"filters"`
+	// Mask replaces redacted payloads, DefaultRedactionMask when empty
+	Mask string `json:"mask"`
+}
+
+// Redactor masks the payloads of messages on configured topics, use it wherever payloads are logged,
+// traced, audited or captured so PII on known topics never leaves the delivery path
+type Redactor struct {
+	filters []string
+	mask    []byte
+
+	redacted atomic.Uint64
+}
+
+// NewRedactor creates a redactor for the configured topic filters
+func NewRedactor(config RedactConfig) (*Redactor, error) {
+	for _, filter := range config.Filters {
+		if err := topic.ValidateTopicFilter(filter); err != nil {
+			return nil, fmt.Errorf("%w: %q: %v", ErrInvalidRedactFilter, filter, err)
+		}
+	}
+	if config.Mask == "" {
+		config.Mask = DefaultRedactionMask
+	}
+	return &Redactor{
+		filters: append([]string(nil), config.Filters...),
+		mask:    []byte(config.Mask),
+	}, nil
+}
+
+// Matches reports whether payloads on topicName are redacted, a nil redactor matches nothing
+func (r *Redactor) Matches(topicName string) bool {
+	if r == nil {
+		return false
+	}
+	for _, filter := range r.filters {
+		if topic.MatchFilter(filter, topicName) {
+			return true
+		}
+	}
+	return false
+}
+
+// Payload returns the mask when payloads on topicName are redacted and payload unchanged otherwise
+func (r *Redactor) Payload(topicName string, payload []byte) []byte {
+	if !r.Matches(topicName) {
+		return payload
+	}
+	r.redacted.Add(1)
+	return r.mask
+}
+
+// Packet returns a copy of packet with a masked payload when its topic is redacted and packet itself otherwise
+func (r *Redactor) Packet(packet *PublishPacket) *PublishPacket {
+	if packet == nil || !r.Matches(packet.Topic) {
+		return packet
+	}
+	cp := *packet
+	cp.Payload = r.Payload(packet.Topic, packet.Payload)
+	return &cp
+}
+
+// Will returns a copy of will with a masked payload when its topic is redacted and will itself otherwise
+func (r *Redactor) Will(will *WillMessage) *WillMessage {
+	if will == nil || !r.Matches(will.Topic) {
+		return will
+	}
+	cp := *will
+	cp.Payload = r.Payload(will.Topic, will.Payload)
+	return &cp
+}
+
+// Redacted returns the number of payloads masked so far
+func (r *Redactor) Redacted() uint64 {
+	return r.redacted.Load()
+}
+
+// RedactHook wraps an observing hook, such as an audit, journal or capture hook, so it only ever sees
+// masked payloads on redacted topics. Messages on redacted topics are passed as copies and changes the
+// wrapped hook makes to them are discarded, messages on other topics are passed unchanged
+// Raw packet events carry payloads that cannot be masked and are withheld from the wrapped hook
+type RedactHook struct {
+	Hook
+	redactor *Redactor
+}
+
+// NewRedactHook wraps inner so payloads on topics matched by redactor are masked before it sees them
+func NewRedactHook(inner Hook, redactor *Redactor) *RedactHook {
+	return &RedactHook{Hook: inner, redactor: redactor}
+}
+
+// Unwrap returns the wrapped hook
+func (h *RedactHook) Unwrap() Hook {
+	return h.Hook
+}
+
+// Redactor returns the redactor masking payloads for the wrapped hook
+func (h *RedactHook) Redactor() *Redactor {
+	return h.redactor
+}
+
+// Provides reports the events of the wrapped hook, except raw packet events
+func (h *RedactHook) Provides(event Event) bool {
+	switch event {
+	case OnPacketRead, OnPacketEncode, OnPacketSent:
+		return false
+	default:
+		return h.Hook.Provides(event)
+	}
+}
+
+// OnSelectSubscribers passes a masked copy of the dispatched packet, changes to the subscriber list are kept
+func (h *RedactHook) OnSelectSubscribers(subscribers *Subscribers, topicName string) error {
+	if subscribers == nil || subscribers.Packet == nil || !h.redactor.Matches(subscribers.Packet.Topic) {
+		return h.Hook.OnSelectSubscribers(subscribers, topicName)
+	}
+	cp := *subscribers
+	cp.Packet = h.redactor.Packet(subscribers.Packet)
+	err := h.Hook.OnSelectSubscribers(&cp, topicName)
+	subscribers.Subscriptions = cp.Subscriptions
+	return err
+}
+
+// OnPublish passes a masked copy of the packet on redacted topics
+func (h *RedactHook) OnPublish(client *Client, packet *PublishPacket) error {
+	return h.Hook.OnPublish(client, h.redactor.Packet(packet))
+}
+
+// OnPublished passes a masked copy of the packet on redacted topics
+func (h *RedactHook) OnPublished(client *Client, packet *PublishPacket) error {
+	return h.Hook.OnPublished(client, h.redactor.Packet(packet))
+}
+
+// OnPublishDropped passes a masked copy of the packet on redacted topics
+func (h *RedactHook) OnPublishDropped(client *Client, packet *PublishPacket, reason DropReason) error {
+	return h.Hook.OnPublishDropped(client, h.redactor.Packet(packet), reason)
+}
+
+// OnRetainMessage passes a masked copy of the packet on redacted topics
+func (h *RedactHook) OnRetainMessage(client *Client, packet *PublishPacket) error {
+	return h.Hook.OnRetainMessage(client, h.redactor.Packet(packet))
+}
+
+// OnRetainPublished passes a masked copy of the packet on redacted topics
+func (h *RedactHook) OnRetainPublished(client *Client, packet *PublishPacket) error {
+	return h.Hook.OnRetainPublished(client, h.redactor.Packet(packet))
+}
+
+// OnQosPublish passes a masked copy of the packet on redacted topics
+func (h *RedactHook) OnQosPublish(client *Client, packet *PublishPacket, sent time.Time, resend int) error {
+	return h.Hook.OnQosPublish(client, h.redactor.Packet(packet), sent, resend)
+}
+
+// OnPublishDeliver passes a masked copy of the packet on redacted topics and then delivers the original
+func (h *RedactHook) OnPublishDeliver(client *Client, packet *PublishPacket) *PublishPacket {
+	redacted := h.redactor.Packet(packet)
+	if redacted == packet {
+		return h.Hook.OnPublishDeliver(client, packet)
+	}
+	h.Hook.OnPublishDeliver(client, redacted)
+	return packet
+}
+
+// OnWill passes a masked copy of the will on redacted topics and then keeps the original
+func (h *RedactHook) OnWill(client *Client, will *WillMessage) *WillMessage {
+	redacted := h.redactor.Will(will)
+	if redacted == will {
+		return h.Hook.OnWill(client, will)
+	}
+	h.Hook.OnWill(client, redacted)
+	return will
+}
+
+// OnWillSent passes a masked copy of the will on redacted topics
+func (h *RedactHook) OnWillSent(client *Client, will *WillMessage) error {
+	return h.Hook.OnWillSent(client, h.redactor.Will(will))
+}
@@ -0,0 +1,125 @@
+package hook
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// captureHook records what an observing hook sees and tries to change it
+type captureHook struct {
+	*Base
+	published []*PublishPacket
+	wills     []*WillMessage
+	selected  [][]byte
+}
+
+func (h *captureHook) Provides(event Event) bool {
+	return true
+}
+
+func (h *captureHook) OnPublish(_ *Client, packet *PublishPacket) error {
+	packet.Payload = []byte("changed")
+	return nil
+}
+
+func (h *captureHook) OnPublished(_ *Client, packet *PublishPacket) error {
+	h.published = append(h.published, packet)
+	return nil
+}
+
+func (h *captureHook) OnSelectSubscribers(subscribers *Subscribers, _ string) error {
+	h.selected = append(h.selected, subscribers.Packet.Payload)
+	subscribers.Remove("c2")
+	return nil
+}
+
+func (h *captureHook) OnPublishDeliver(_ *Client, packet *PublishPacket) *PublishPacket {
+	return &PublishPacket{Topic: packet.Topic, Payload: []byte("changed")}
+}
+
+func (h *captureHook) OnWill(_ *Client, will *WillMessage) *WillMessage {
+	h.wills = append(h.wills, will)
+	return nil
+}
+
+func TestRedactor(t *testing.T) {
+	r, err := NewRedactor(RedactConfig{Filters: []string{"patients/+/vitals", "pii/#"}})
+	require.NoError(t, err)
+
+	assert.True(t, r.Matches("patients/42/vitals"))
+	assert.True(t, r.Matches("pii/email"))
+	assert.False(t, r.Matches("patients/42/room"))
+
+	assert.Equal(t, []byte(DefaultRedactionMask), r.Payload("pii/email", []byte("a@b.c")))
+	assert.Equal(t, []byte("21.5"), r.Payload("sensors/temp", []byte("21.5")))
+
+	packet := &PublishPacket{Topic: "pii/email", Payload: []byte("a@b.c"), QoS: 1}
+	redacted := r.Packet(packet)
+	assert.NotSame(t, packet, redacted)
+	assert.Equal(t, []byte(DefaultRedactionMask), redacted.Payload)
+	assert.Equal(t, byte(1), redacted.QoS)
+	assert.Equal(t, []byte("a@b.c"), packet.Payload)
+
+	plain := &PublishPacket{Topic: "sensors/temp"}
+	assert.Same(t, plain, r.Packet(plain))
+	assert.Nil(t, r.Packet(nil))
+	assert.Equal(t, []byte(DefaultRedactionMask), r.Will(&WillMessage{Topic: "pii/x", Payload: []byte("secret")}).Payload)
+	assert.Equal(t, uint64(3), r.Redacted())
+
+	var nilRedactor *Redactor
+	assert.False(t, nilRedactor.Matches("pii/email"))
+}
+
+func TestRedactorConfig(t *testing.T) {
+	_, err := NewRedactor(RedactConfig{Filters: []string{"pii/#/x"}})
+	assert.ErrorIs(t, err, ErrInvalidRedactFilter)
+
+	r, err := NewRedactor(RedactConfig{Filters: []string{"#"}, Mask: "***"})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("***"), r.Payload("any", []byte("x")))
+}
+
+func TestRedactHook(t *testing.T) {
+	r, err := NewRedactor(RedactConfig{Filters: []string{"pii/#"}})
+	require.NoError(t, err)
+	inner := &captureHook{Base: &Base{id: "capture"}}
+	h := NewRedactHook(inner, r)
+
+	assert.Equal(t, "capture", h.ID())
+	assert.Same(t, inner, h.Unwrap())
+	assert.True(t, h.Provides(OnPublished))
+	assert.False(t, h.Provides(OnPacketRead))
+	assert.False(t, h.Provides(OnPacketSent))
+
+	secret := &PublishPacket{Topic: "pii/email", Payload: []byte("a@b.c")}
+	plain := &PublishPacket{Topic: "sensors/temp", Payload: []byte("21.5")}
+
+	require.NoError(t, h.OnPublish(nil, secret))
+	assert.Equal(t, []byte("a@b.c"), secret.Payload, "changes to redacted copies are discarded")
+	require.NoError(t, h.OnPublish(nil, plain))
+	assert.Equal(t, []byte("changed"), plain.Payload)
+
+	require.NoError(t, h.OnPublished(nil, secret))
+	require.Len(t, inner.published, 1)
+	assert.Equal(t, []byte(DefaultRedactionMask), inner.published[0].Payload)
+
+	subs := &Subscribers{Packet: secret}
+	subs.Add(&Subscription{ClientID: "c1"})
+	subs.Add(&Subscription{ClientID: "c2"})
+	require.NoError(t, h.OnSelectSubscribers(subs, secret.Topic))
+	assert.Equal(t, [][]byte{[]byte(DefaultRedactionMask)}, inner.selected)
+	require.Len(t, subs.Subscriptions, 1)
+	assert.Equal(t, "c1", subs.Subscriptions[0].ClientID)
+	assert.Same(t, secret, subs.Packet)
+
+	assert.Same(t, secret, h.OnPublishDeliver(nil, secret))
+	assert.Equal(t, []byte("changed"), h.OnPublishDeliver(nil, plain).Payload)
+
+	will := &WillMessage{Topic: "pii/status", Payload: []byte("gone")}
+	assert.Same(t, will, h.OnWill(nil, will))
+	require.Len(t, inner.wills, 1)
+	assert.Equal(t, []byte(DefaultRedactionMask), inner.wills[0].Payload)
+	assert.Nil(t, h.OnWill(nil, &WillMessage{Topic: "status"}))
+}
@@ -0,0 +1,335 @@
+package hook
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/axmq/ax/encoding"
+)
+
+// Factory creates a hook from its raw JSON options
+type Factory func(options json.RawMessage) (Hook, error)
+
+// HookConfig describes a single hook in a pipeline configuration
+type HookConfig struct {
+	Name     string          `json:"name"`
+	Priority int             `json:"priority"`
+	Disabled bool            `json:"disabled"`
+	Options  json.RawMessage `json:"options"`
+}
+
+// PipelineConfig describes the hook chain to assemble
+type PipelineConfig struct {
+	Hooks []HookConfig `json:"hooks"`
+}
+
+// Registry holds hook factories by name
+type Registry struct {
+	mu        sync.RWMutex
+	factories map[string]Factory
+}
+
+// NewRegistry creates an empty hook registry
+func NewRegistry() *Registry {
+	return &Registry{
+		factories: make(map[string]Factory),
+	}
+}
+
+// Register registers a factory under the given name
+func (r *Registry) Register(name string, factory Factory) error {
+	if name == "" || factory == nil {
+		return ErrEmptyFactoryName
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.factories[name]; exists {
+		return ErrFactoryAlreadyExists
+	}
+	r.factories[name] = factory
+	return nil
+}
+
+// Names returns the sorted names of all registered factories
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.factories))
+	for name := range r.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Create builds and initializes a hook from the named factory
+func (r *Registry) Create(name string, options json.RawMessage) (Hook, error) {
+	r.mu.RLock()
+	factory, exists := r.factories[name]
+	r.mu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrFactoryNotFound, name)
+	}
+
+	hook, err := factory(options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create hook %s: %w", name, err)
+	}
+	if err := hook.Init(options); err != nil {
+		return nil, fmt.Errorf("failed to init hook %s: %w", name, err)
+	}
+	return hook, nil
+}
+
+// Assemble creates every enabled hook in the configuration and adds it to the manager
+// Hooks are added in ascending priority order, ties keep configuration order
+// On failure, hooks already added by this call are removed and stopped
+func (r *Registry) Assemble(m *Manager, config *PipelineConfig) error {
+	hooks := make([]HookConfig, 0, len(config.Hooks))
+	for _, hc := range config.Hooks {
+		if !hc.Disabled {
+			hooks = append(hooks, hc)
+		}
+	}
+	sort.SliceStable(hooks, func(i, j int) bool {
+		return hooks[i].Priority < hooks[j].Priority
+	})
+
+	added := make([]Hook, 0, len(hooks))
+	rollback := func() {
+		for _, h := range added {
+			_ = m.Remove(h.ID())
+			_ = h.Stop()
+		}
+	}
+
+	for _, hc := range hooks {
+		hook, err := r.Create(hc.Name, hc.Options)
+		if err != nil {
+			rollback()
+			return err
+		}
+		if err := m.Add(hook); err != nil {
+			_ = hook.Stop()
+			rollback()
+			return fmt.Errorf("failed to add hook %s: %w", hc.Name, err)
+		}
+		added = append(added, hook)
+	}
+
+	return nil
+}
+
+// ParsePipelineConfig parses a JSON pipeline configuration
+func ParsePipelineConfig(data []byte) (*PipelineConfig, error) {
+	var config PipelineConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse pipeline config: %w", err)
+	}
+	for i, hc := range config.Hooks {
+		if hc.Name == "" {
+			return nil, fmt.Errorf("%w: hook at index %d", ErrEmptyFactoryName, i)
+		}
+	}
+	return &config, nil
+}
+
+// LoadPipelineConfig reads a JSON pipeline configuration from a file
+func LoadPipelineConfig(path string) (*PipelineConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pipeline config: %w", err)
+	}
+	return ParsePipelineConfig(data)
+}
+
+// RegisterBuiltins registers factories for the hooks provided by this package
+func RegisterBuiltins(r *Registry) error {
+	builtins := map[string]Factory{
+		"basic-auth": func(options json.RawMessage) (Hook, error) {
+			var opts struct {
+				Users map[string]string `json:"users"`
+			}
+			if err := decodeOptions(options, &opts); err != nil {
+				return nil, err
+			}
+			h := NewBasicAuthHook()
+			h.LoadUsers(opts.Users)
+			return h, nil
+		},
+		"anonymous-auth": func(options json.RawMessage) (Hook, error) {
+			var opts struct {
+				Allow bool `json:"allow"`
+			}
+			if err := decodeOptions(options, &opts); err != nil {
+				return nil, err
+			}
+			return NewAnonymousAuthHook(opts.Allow), nil
+		},
+		"guest": func(options json.RawMessage) (Hook, error) {
+			var opts struct {
+				Users                  map[string]string `json:"users"`
+				Namespace              []string          `json:"namespace"`
+				MaxDuration            string            `json:"max_duration"`
+				SessionExpiry          string            `json:"session_expiry"`
+				MaxPublishes           int               `json:"max_publishes"`
+				MaxSubscriptions       int               `json:"max_subscriptions"`
+				MaxPayloadSize         int               `json:"max_payload_size"`
+				AllowFailedCredentials bool              `json:"allow_failed_credentials"`
+				ClientIDPrefix         string            `json:"client_id_prefix"`
+			}
+			if err := decodeOptions(options, &opts); err != nil {
+				return nil, err
+			}
+			config := GuestConfig{
+				Namespace:              opts.Namespace,
+				MaxPublishes:           opts.MaxPublishes,
+				MaxSubscriptions:       opts.MaxSubscriptions,
+				MaxPayloadSize:         opts.MaxPayloadSize,
+				AllowFailedCredentials: opts.AllowFailedCredentials,
+				ClientIDPrefix:         opts.ClientIDPrefix,
+			}
+			var err error
+			if opts.MaxDuration != "" {
+				if config.MaxDuration, err = parseWindow(opts.MaxDuration); err != nil {
+					return nil, err
+				}
+			}
+			if opts.SessionExpiry != "" {
+				if config.SessionExpiry, err = parseWindow(opts.SessionExpiry); err != nil {
+					return nil, err
+				}
+			}
+			// Users are checked by a basic-auth hook wrapped by the guest hook
+			var auth Hook
+			if opts.Users != nil {
+				basic := NewBasicAuthHook()
+				basic.LoadUsers(opts.Users)
+				auth = basic
+			}
+			return NewGuestHook(auth, config)
+		},
+		"rate-limit": func(options json.RawMessage) (Hook, error) {
+			var opts struct {
+				MaxRate int    `json:"max_rate"`
+				Window  string `json:"window"`
+			}
+			if err := decodeOptions(options, &opts); err != nil {
+				return nil, err
+			}
+			window, err := parseWindow(opts.Window)
+			if err != nil {
+				return nil, err
+			}
+			return NewRateLimitHook(opts.MaxRate, window), nil
+		},
+		"multi-level-rate-limit": func(options json.RawMessage) (Hook, error) {
+			var opts struct {
+				PerClient int    `json:"per_client"`
+				PerTopic  int    `json:"per_topic"`
+				Global    int    `json:"global"`
+				Window    string `json:"window"`
+			}
+			if err := decodeOptions(options, &opts); err != nil {
+				return nil, err
+			}
+			window, err := parseWindow(opts.Window)
+			if err != nil {
+				return nil, err
+			}
+			return NewMultiLevelRateLimitHook(opts.PerClient, opts.PerTopic, opts.Global, window), nil
+		},
+		"property-filter": func(options json.RawMessage) (Hook, error) {
+			var opts struct {
+				Property string `json:"property"`
+			}
+			if err := decodeOptions(options, &opts); err != nil {
+				return nil, err
+			}
+			return NewPropertyFilterHook(opts.Property), nil
+		},
+		"message-ttl": func(options json.RawMessage) (Hook, error) {
+			var opts struct {
+				Policies []TTLPolicy `json:"policies"`
+			}
+			if err := decodeOptions(options, &opts); err != nil {
+				return nil, err
+			}
+			return NewMessageTTLHook(opts.Policies...)
+		},
+		"annotations": func(options json.RawMessage) (Hook, error) {
+			var opts struct {
+				NodeID         string             `json:"node_id"`
+				Policies       []AnnotationPolicy `json:"policies"`
+				TrustedClients []string           `json:"trusted_clients"`
+			}
+			if err := decodeOptions(options, &opts); err != nil {
+				return nil, err
+			}
+			h, err := NewAnnotationHook(opts.NodeID, opts.Policies...)
+			if err != nil {
+				return nil, err
+			}
+			h.SetTrustedClients(opts.TrustedClients)
+			return h, nil
+		},
+		"fingerprint": func(options json.RawMessage) (Hook, error) {
+			config := DefaultFingerprintConfig()
+			opts := struct {
+				Reject       bool    `json:"reject"`
+				EntropyDelta float64 `json:"entropy_delta"`
+				MaxEntries   int     `json:"max_entries"`
+			}{EntropyDelta: config.EntropyDelta, MaxEntries: config.MaxEntries}
+			if err := decodeOptions(options, &opts); err != nil {
+				return nil, err
+			}
+			config.Reject, config.EntropyDelta, config.MaxEntries = opts.Reject, opts.EntropyDelta, opts.MaxEntries
+			return NewFingerprintHook(config), nil
+		},
+		"ack-reasons": func(options json.RawMessage) (Hook, error) {
+			var opts struct {
+				Reasons map[encoding.ReasonCode]string `json:"reasons"`
+			}
+			if err := decodeOptions(options, &opts); err != nil {
+				return nil, err
+			}
+			return NewAckReasonHook(opts.Reasons), nil
+		},
+	}
+
+	for name, factory := range builtins {
+		if err := r.Register(name, factory); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeOptions(options json.RawMessage, v any) error {
+	if len(options) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(options, v); err != nil {
+		return fmt.Errorf("invalid hook options: %w", err)
+	}
+	return nil
+}
+
+func parseWindow(window string) (time.Duration, error) {
+	if window == "" {
+		return time.Minute, nil
+	}
+	d, err := time.ParseDuration(window)
+	if err != nil {
+		return 0, fmt.Errorf("invalid hook options: %w", err)
+	}
+	return d, nil
+}
@@ -0,0 +1,168 @@
+package hook
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRegistry(t *testing.T) *Registry {
+	r := NewRegistry()
+	require.NoError(t, RegisterBuiltins(r))
+	return r
+}
+
+func TestRegistryRegister(t *testing.T) {
+	r := NewRegistry()
+	factory := func(json.RawMessage) (Hook, error) { return NewHookBase("x"), nil }
+
+	require.NoError(t, r.Register("x", factory))
+	assert.ErrorIs(t, r.Register("x", factory), ErrFactoryAlreadyExists)
+	assert.ErrorIs(t, r.Register("", factory), ErrEmptyFactoryName)
+	assert.ErrorIs(t, r.Register("y", nil), ErrEmptyFactoryName)
+	assert.Equal(t, []string{"x"}, r.Names())
+}
+
+func TestRegistryBuiltins(t *testing.T) {
+	r := newTestRegistry(t)
+	assert.Equal(t, []string{"ack-reasons", "annotations", "anonymous-auth", "basic-auth", "fingerprint", "guest", "message-ttl", "multi-level-rate-limit", "property-filter", "rate-limit"}, r.Names())
+
+	h, err := r.Create("basic-auth", json.RawMessage(`{"users":{"alice":"secret"}}`))
+	require.NoError(t, err)
+	assert.True(t, h.(*BasicAuthHook).HasUser("alice"))
+
+	h, err = r.Create("anonymous-auth", json.RawMessage(`{"allow":true}`))
+	require.NoError(t, err)
+	assert.True(t, h.(*AnonymousAuthHook).IsAnonymousAllowed())
+
+	h, err = r.Create("guest", json.RawMessage(`{"users":{"alice":"secret"},"max_duration":"1m","max_publishes":3}`))
+	require.NoError(t, err)
+	guest := h.(*GuestHook)
+	assert.Equal(t, time.Minute, guest.config.MaxDuration)
+	assert.Equal(t, 3, guest.config.MaxPublishes)
+	assert.True(t, guest.OnConnectAuthenticate(&Client{ID: "c1"}, &ConnectPacket{Username: "alice", Password: []byte("secret")}))
+	assert.False(t, guest.OnConnectAuthenticate(&Client{ID: "c2"}, &ConnectPacket{Username: "alice"}))
+
+	h, err = r.Create("rate-limit", json.RawMessage(`{"max_rate":10,"window":"30s"}`))
+	require.NoError(t, err)
+	defer h.Stop()
+	assert.Equal(t, 10, h.(*RateLimitHook).GetMaxRate())
+	assert.Equal(t, 30*time.Second, h.(*RateLimitHook).GetWindow())
+
+	h, err = r.Create("multi-level-rate-limit", nil)
+	require.NoError(t, err)
+	defer h.Stop()
+	assert.Equal(t, "multi-level-rate-limit", h.ID())
+
+	h, err = r.Create("fingerprint", json.RawMessage(`{"reject":true}`))
+	require.NoError(t, err)
+	assert.True(t, h.(*FingerprintHook).config.Reject)
+	assert.Equal(t, DefaultFingerprintConfig().MaxEntries, h.(*FingerprintHook).config.MaxEntries)
+}
+
+func TestRegistryCreateErrors(t *testing.T) {
+	r := newTestRegistry(t)
+	require.NoError(t, r.Register("broken", func(json.RawMessage) (Hook, error) {
+		return nil, errors.New("broken")
+	}))
+	require.NoError(t, r.Register("bad-init", func(json.RawMessage) (Hook, error) {
+		h := newTestHook("bad-init")
+		h.returnError = true
+		return h, nil
+	}))
+
+	tests := []struct {
+		name    string
+		factory string
+		options string
+		target  error
+	}{
+		{name: "unknown", factory: "missing", target: ErrFactoryNotFound},
+		{name: "invalid options", factory: "basic-auth", options: `{"users":1}`},
+		{name: "invalid window", factory: "rate-limit", options: `{"window":"soon"}`},
+		{name: "invalid ttl", factory: "message-ttl", options: `{"policies":[{"filter":"a/#","ttl":"soon"}]}`},
+		{name: "invalid ttl filter", factory: "message-ttl", options: `{"policies":[{"filter":"a/#/b","ttl":"1h"}]}`},
+		{name: "unknown annotation field", factory: "annotations", options: `{"policies":[{"filter":"a/#","fields":["size"]}]}`},
+		{name: "factory error", factory: "broken"},
+		{name: "init error", factory: "bad-init"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := r.Create(tt.factory, json.RawMessage(tt.options))
+			require.Error(t, err)
+			if tt.target != nil {
+				assert.ErrorIs(t, err, tt.target)
+			}
+		})
+	}
+}
+
+func TestRegistryAssemble(t *testing.T) {
+	r := newTestRegistry(t)
+	config, err := ParsePipelineConfig([]byte(`{
+		"hooks": [
+			{"name": "rate-limit", "priority": 20, "options": {"max_rate": 5}},
+			{"name": "basic-auth", "priority": 10, "options": {"users": {"bob": "pw"}}},
+			{"name": "anonymous-auth", "priority": 10},
+			{"name": "multi-level-rate-limit", "disabled": true}
+		]
+	}`))
+	require.NoError(t, err)
+
+	m := NewManager()
+	defer m.Clear()
+	require.NoError(t, r.Assemble(m, config))
+
+	ids := make([]string, 0)
+	for _, h := range m.List() {
+		ids = append(ids, h.ID())
+	}
+	assert.Equal(t, []string{"basic-auth", "anonymous-auth", "rate-limit"}, ids)
+}
+
+func TestRegistryAssembleRollback(t *testing.T) {
+	r := newTestRegistry(t)
+	m := NewManager()
+
+	config := &PipelineConfig{Hooks: []HookConfig{
+		{Name: "basic-auth"},
+		{Name: "missing"},
+	}}
+	assert.ErrorIs(t, r.Assemble(m, config), ErrFactoryNotFound)
+	assert.Equal(t, 0, m.Count())
+
+	config = &PipelineConfig{Hooks: []HookConfig{
+		{Name: "basic-auth"},
+		{Name: "basic-auth"},
+	}}
+	assert.ErrorIs(t, r.Assemble(m, config), ErrHookAlreadyExists)
+	assert.Equal(t, 0, m.Count())
+}
+
+func TestLoadPipelineConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hooks.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"hooks":[{"name":"basic-auth","priority":1}]}`), 0o600))
+
+	config, err := LoadPipelineConfig(path)
+	require.NoError(t, err)
+	require.Len(t, config.Hooks, 1)
+	assert.Equal(t, "basic-auth", config.Hooks[0].Name)
+	assert.Equal(t, 1, config.Hooks[0].Priority)
+
+	_, err = LoadPipelineConfig(filepath.Join(dir, "missing.json"))
+	assert.Error(t, err)
+
+	_, err = ParsePipelineConfig([]byte(`{"hooks":[{"priority":1}]}`))
+	assert.ErrorIs(t, err, ErrEmptyFactoryName)
+
+	_, err = ParsePipelineConfig([]byte(`not json`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,288 @@
+package hook
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"maps"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ResumptionCacheConfig configures the session resumption cache
+type ResumptionCacheConfig struct {
+	// TTL is how long an authentication decision is reused, keep it short so revocations apply quickly
+	TTL time.Duration
+	// NegativeTTL is how long a rejection is reused, zero does not cache rejections
+	NegativeTTL time.Duration
+	MaxEntries  int
+}
+
+// DefaultResumptionCacheConfig returns a configuration sized for reconnect storms after a network blip
+func DefaultResumptionCacheConfig() ResumptionCacheConfig {
+	return ResumptionCacheConfig{
+		TTL:         30 * time.Second,
+		NegativeTTL: 5 * time.Second,
+		MaxEntries:  100000,
+	}
+}
+
+type resumptionEntry struct {
+	credentials [sha256.Size]byte
+	username    string
+	allowed     bool
+	metadata    map[string]string
+	connack     []byte
+	expires     time.Time
+}
+
+// ResumptionCacheStats holds the counters of a resumption cache
+type ResumptionCacheStats struct {
+	Hits          uint64
+	Misses        uint64
+	Invalidations uint64
+	Entries       int
+}
+
+// ResumptionCache remembers authentication decisions and encoded CONNACK properties of recently seen
+// (client ID, credentials) pairs, so a mass reconnect skips redundant hook invocations and property
+// serialization. The credentials are the username, password, TLS client certificate and peer host, an entry
+// only matches the exact credentials it was stored with and a client presenting different ones invalidates it.
+// The client metadata set by the hooks, e.g. a guest flag or a tenant, is stored and set again on a hit
+type ResumptionCache struct {
+	config ResumptionCacheConfig
+
+	mu      sync.Mutex
+	entries map[string]*resumptionEntry
+	now     func() time.Time
+
+	hits          atomic.Uint64
+	misses        atomic.Uint64
+	invalidations atomic.Uint64
+}
+
+// NewResumptionCache creates a resumption cache
+func NewResumptionCache(config ResumptionCacheConfig) *ResumptionCache {
+	return &ResumptionCache{
+		config:  config,
+		entries: make(map[string]*resumptionEntry),
+		now:     time.Now,
+	}
+}
+
+// credentialHash digests the credentials of a CONNECT so the cache never holds the password itself
+// A decision based on a client certificate or the peer address is not reused for another certificate or peer
+func credentialHash(client *Client, packet *ConnectPacket) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write([]byte(packet.Username))
+	h.Write([]byte{0})
+	h.Write(packet.Password)
+	h.Write([]byte{0})
+	if client != nil {
+		h.Write([]byte(client.Certificate))
+		h.Write([]byte{0})
+		if client.RemoteAddr != nil {
+			h.Write([]byte(peerHost(client.RemoteAddr)))
+		}
+	}
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// peerHost returns the host of addr, the port changes with every connection
+func peerHost(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// lookup returns the live entry for the CONNECT, dropping it when the credentials changed
+// Only the authentication lookup counts towards hits and misses, so each CONNECT is counted once
+func (c *ResumptionCache) lookup(client *Client, packet *ConnectPacket, count bool) (*resumptionEntry, bool) {
+	sum := credentialHash(client, packet)
+	now := c.now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[packet.ClientID]
+	switch {
+	case !ok:
+	case !now.Before(entry.expires):
+		delete(c.entries, packet.ClientID)
+		ok = false
+	case subtle.ConstantTimeCompare(entry.credentials[:], sum[:]) != 1:
+		delete(c.entries, packet.ClientID)
+		c.invalidations.Add(1)
+		ok = false
+	}
+
+	if count {
+		if ok {
+			c.hits.Add(1)
+		} else {
+			c.misses.Add(1)
+		}
+	}
+	return entry, ok
+}
+
+// Authenticate returns the cached decision for the CONNECT or runs the OnConnectAuthenticate hooks of m
+// A cached decision sets the client metadata the hooks set when it was taken. Clients without an ID are
+// never cached since the broker assigns them a new one on every connect
+func (c *ResumptionCache) Authenticate(m *Manager, client *Client, packet *ConnectPacket) bool {
+	if packet == nil || packet.ClientID == "" {
+		return m.OnConnectAuthenticate(client, packet)
+	}
+	if entry, ok := c.lookup(client, packet, true); ok {
+		if client != nil {
+			for key, value := range entry.metadata {
+				client.SetMetadata(key, value)
+			}
+		}
+		return entry.allowed
+	}
+
+	var seeded map[string]string
+	if client != nil {
+		seeded = maps.Clone(client.Metadata)
+	}
+	allowed := m.OnConnectAuthenticate(client, packet)
+
+	ttl := c.config.TTL
+	if !allowed {
+		ttl = c.config.NegativeTTL
+	}
+	if ttl > 0 {
+		c.put(packet.ClientID, &resumptionEntry{
+			credentials: credentialHash(client, packet),
+			username:    packet.Username,
+			allowed:     allowed,
+			metadata:    changedMetadata(seeded, client),
+			expires:     c.now().Add(ttl),
+		})
+	}
+	return allowed
+}
+
+// changedMetadata returns the metadata entries of client that are missing from or differ in before
+func changedMetadata(before map[string]string, client *Client) map[string]string {
+	if client == nil {
+		return nil
+	}
+	var changed map[string]string
+	for key, value := range client.Metadata {
+		if old, ok := before[key]; ok && old == value {
+			continue
+		}
+		if changed == nil {
+			changed = make(map[string]string)
+		}
+		changed[key] = value
+	}
+	return changed
+}
+
+// ConnackProperties returns the encoded CONNACK properties cached for the CONNECT of client
+func (c *ResumptionCache) ConnackProperties(client *Client, packet *ConnectPacket) ([]byte, bool) {
+	if packet == nil || packet.ClientID == "" {
+		return nil, false
+	}
+	entry, ok := c.lookup(client, packet, false)
+	if !ok || !entry.allowed {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return entry.connack, entry.connack != nil
+}
+
+// SetConnackProperties caches the encoded CONNACK properties sent to an authenticated client
+// The block is reused until the authentication decision expires, the caller must not modify it
+func (c *ResumptionCache) SetConnackProperties(clientID string, properties []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[clientID]; ok && entry.allowed {
+		entry.connack = properties
+	}
+}
+
+func (c *ResumptionCache) put(clientID string, entry *resumptionEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.entries[clientID]; !exists && c.config.MaxEntries > 0 && len(c.entries) >= c.config.MaxEntries {
+		c.evictLocked()
+		if len(c.entries) >= c.config.MaxEntries {
+			return
+		}
+	}
+	c.entries[clientID] = entry
+}
+
+// evictLocked removes expired entries, or one arbitrary entry when none have expired
+func (c *ResumptionCache) evictLocked() {
+	now := c.now()
+	for clientID, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, clientID)
+		}
+	}
+	if len(c.entries) < c.config.MaxEntries {
+		return
+	}
+	for clientID := range c.entries {
+		delete(c.entries, clientID)
+		return
+	}
+}
+
+// Invalidate drops the cached decision of a client, e.g. after it was kicked or its ACL changed
+func (c *ResumptionCache) Invalidate(clientID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[clientID]; ok {
+		delete(c.entries, clientID)
+		c.invalidations.Add(1)
+	}
+}
+
+// InvalidateUser drops the cached decisions of every client that authenticated as username,
+// call it when the credentials of the user change
+func (c *ResumptionCache) InvalidateUser(username string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for clientID, entry := range c.entries {
+		if entry.username == username {
+			delete(c.entries, clientID)
+			c.invalidations.Add(1)
+		}
+	}
+}
+
+// Purge drops every cached decision
+func (c *ResumptionCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.invalidations.Add(uint64(len(c.entries)))
+	c.entries = make(map[string]*resumptionEntry)
+}
+
+// Stats returns the cache counters
+func (c *ResumptionCache) Stats() ResumptionCacheStats {
+	c.mu.Lock()
+	entries := len(c.entries)
+	c.mu.Unlock()
+
+	return ResumptionCacheStats{
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		Invalidations: c.invalidations.Load(),
+		Entries:       entries,
+	}
+}
@@ -0,0 +1,181 @@
+package hook
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newResumptionFixture(t *testing.T, config ResumptionCacheConfig) (*ResumptionCache, *Manager, *testHook, *time.Time) {
+	t.Helper()
+	m := NewManager()
+	h := newTestHook("auth", OnConnectAuthenticate)
+	require.NoError(t, m.Add(h))
+
+	now := time.Unix(1000, 0)
+	cache := NewResumptionCache(config)
+	cache.now = func() time.Time { return now }
+	return cache, m, h, &now
+}
+
+func TestDefaultResumptionCacheConfig(t *testing.T) {
+	config := DefaultResumptionCacheConfig()
+	assert.Equal(t, 30*time.Second, config.TTL)
+	assert.Equal(t, 5*time.Second, config.NegativeTTL)
+	assert.Equal(t, 100000, config.MaxEntries)
+}
+
+func TestResumptionCacheAuthenticate(t *testing.T) {
+	cache, m, h, now := newResumptionFixture(t, ResumptionCacheConfig{TTL: time.Minute})
+	packet := &ConnectPacket{ClientID: "c1", Username: "alice", Password: []byte("secret")}
+
+	assert.True(t, cache.Authenticate(m, &Client{ID: "c1"}, packet))
+	assert.True(t, cache.Authenticate(m, &Client{ID: "c1"}, packet))
+	assert.Equal(t, 1, h.getCallCount("OnConnectAuthenticate"))
+	assert.Equal(t, ResumptionCacheStats{Hits: 1, Misses: 1, Entries: 1}, cache.Stats())
+
+	*now = now.Add(2 * time.Minute)
+	assert.True(t, cache.Authenticate(m, &Client{ID: "c1"}, packet))
+	assert.Equal(t, 2, h.getCallCount("OnConnectAuthenticate"))
+}
+
+func TestResumptionCacheCredentialChange(t *testing.T) {
+	cache, m, h, _ := newResumptionFixture(t, ResumptionCacheConfig{TTL: time.Minute})
+	client := &Client{ID: "c1"}
+
+	assert.True(t, cache.Authenticate(m, client, &ConnectPacket{ClientID: "c1", Username: "alice", Password: []byte("old")}))
+
+	h.authResult = false
+	assert.False(t, cache.Authenticate(m, client, &ConnectPacket{ClientID: "c1", Username: "alice", Password: []byte("new")}))
+	assert.Equal(t, 2, h.getCallCount("OnConnectAuthenticate"))
+	assert.Equal(t, uint64(1), cache.Stats().Invalidations)
+
+	// Rejections are not cached without a negative TTL
+	assert.Equal(t, 0, cache.Stats().Entries)
+}
+
+func TestResumptionCacheNegativeTTL(t *testing.T) {
+	cache, m, h, _ := newResumptionFixture(t, ResumptionCacheConfig{TTL: time.Minute, NegativeTTL: time.Second})
+	h.authResult = false
+	packet := &ConnectPacket{ClientID: "c1", Username: "alice", Password: []byte("wrong")}
+
+	assert.False(t, cache.Authenticate(m, nil, packet))
+	assert.False(t, cache.Authenticate(m, nil, packet))
+	assert.Equal(t, 1, h.getCallCount("OnConnectAuthenticate"))
+
+	cache.SetConnackProperties("c1", []byte{0x21, 0x00, 0x0a})
+	_, ok := cache.ConnackProperties(nil, packet)
+	assert.False(t, ok)
+}
+
+func TestResumptionCacheConnackProperties(t *testing.T) {
+	cache, m, _, _ := newResumptionFixture(t, ResumptionCacheConfig{TTL: time.Minute})
+	packet := &ConnectPacket{ClientID: "c1", Username: "alice", Password: []byte("secret")}
+
+	_, ok := cache.ConnackProperties(nil, packet)
+	assert.False(t, ok)
+
+	require.True(t, cache.Authenticate(m, nil, packet))
+	_, ok = cache.ConnackProperties(nil, packet)
+	assert.False(t, ok)
+
+	cache.SetConnackProperties("c1", []byte{0x21, 0x00, 0x0a})
+	props, ok := cache.ConnackProperties(nil, packet)
+	require.True(t, ok)
+	assert.Equal(t, []byte{0x21, 0x00, 0x0a}, props)
+
+	_, ok = cache.ConnackProperties(nil, &ConnectPacket{ClientID: "c1", Username: "alice", Password: []byte("other")})
+	assert.False(t, ok)
+	assert.Equal(t, uint64(0), cache.Stats().Hits)
+}
+
+func TestResumptionCacheInvalidation(t *testing.T) {
+	cache, m, h, _ := newResumptionFixture(t, ResumptionCacheConfig{TTL: time.Minute})
+	for _, id := range []string{"c1", "c2", "c3"} {
+		username := "alice"
+		if id == "c3" {
+			username = "bob"
+		}
+		require.True(t, cache.Authenticate(m, nil, &ConnectPacket{ClientID: id, Username: username}))
+	}
+
+	cache.InvalidateUser("alice")
+	assert.Equal(t, 1, cache.Stats().Entries)
+	cache.Invalidate("c3")
+	cache.Invalidate("c3")
+	assert.Equal(t, uint64(3), cache.Stats().Invalidations)
+
+	require.True(t, cache.Authenticate(m, nil, &ConnectPacket{ClientID: "c1", Username: "alice"}))
+	cache.Purge()
+	assert.Equal(t, 0, cache.Stats().Entries)
+
+	// Clients without an ID always run the hooks
+	calls := h.getCallCount("OnConnectAuthenticate")
+	cache.Authenticate(m, nil, &ConnectPacket{})
+	cache.Authenticate(m, nil, &ConnectPacket{})
+	assert.Equal(t, calls+2, h.getCallCount("OnConnectAuthenticate"))
+}
+
+func TestResumptionCacheMaxEntries(t *testing.T) {
+	cache, m, _, now := newResumptionFixture(t, ResumptionCacheConfig{TTL: time.Minute, MaxEntries: 2})
+	require.True(t, cache.Authenticate(m, nil, &ConnectPacket{ClientID: "c1"}))
+	require.True(t, cache.Authenticate(m, nil, &ConnectPacket{ClientID: "c2"}))
+	*now = now.Add(30 * time.Second)
+	require.True(t, cache.Authenticate(m, nil, &ConnectPacket{ClientID: "c3"}))
+	assert.Equal(t, 2, cache.Stats().Entries)
+}
+
+// guestAuthHook admits every client as a guest
+type guestAuthHook struct {
+	*Base
+	calls int
+}
+
+func (h *guestAuthHook) Provides(event Event) bool {
+	return event == OnConnectAuthenticate
+}
+
+func (h *guestAuthHook) OnConnectAuthenticate(client *Client, _ *ConnectPacket) bool {
+	h.calls++
+	client.SetMetadata(GuestMetadataKey, "true")
+	return true
+}
+
+func TestResumptionCacheReplaysMetadata(t *testing.T) {
+	m := NewManager()
+	h := &guestAuthHook{Base: NewHookBase("guest")}
+	require.NoError(t, m.Add(h))
+	cache := NewResumptionCache(ResumptionCacheConfig{TTL: time.Minute})
+	packet := &ConnectPacket{ClientID: "c1"}
+
+	first := &Client{ID: "c1", Metadata: map[string]string{TenantMetadataKey: "acme"}}
+	require.True(t, cache.Authenticate(m, first, packet))
+	assert.True(t, IsGuest(first))
+
+	// Only the metadata set by the hooks is replayed, the tenant of the new connection stays
+	second := &Client{ID: "c1", Metadata: map[string]string{TenantMetadataKey: "other"}}
+	require.True(t, cache.Authenticate(m, second, packet))
+	assert.Equal(t, 1, h.calls)
+	assert.True(t, IsGuest(second))
+	assert.Equal(t, "other", second.Metadata[TenantMetadataKey])
+}
+
+func TestResumptionCacheCertificateAndPeer(t *testing.T) {
+	cache, m, h, _ := newResumptionFixture(t, ResumptionCacheConfig{TTL: time.Minute})
+	packet := &ConnectPacket{ClientID: "c1", Username: "device"}
+	peer := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 40000}
+
+	require.True(t, cache.Authenticate(m, &Client{ID: "c1", Certificate: "aa", RemoteAddr: peer}, packet))
+	// A new source port is the same peer
+	require.True(t, cache.Authenticate(m, &Client{ID: "c1", Certificate: "aa", RemoteAddr: &net.TCPAddr{IP: peer.IP, Port: 40001}}, packet))
+	assert.Equal(t, 1, h.getCallCount("OnConnectAuthenticate"))
+
+	require.True(t, cache.Authenticate(m, &Client{ID: "c1", Certificate: "bb", RemoteAddr: peer}, packet))
+	assert.Equal(t, 2, h.getCallCount("OnConnectAuthenticate"))
+	require.True(t, cache.Authenticate(m, &Client{ID: "c1", Certificate: "bb", RemoteAddr: &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 40000}}, packet))
+	assert.Equal(t, 3, h.getCallCount("OnConnectAuthenticate"))
+	assert.Equal(t, uint64(2), cache.Stats().Invalidations)
+}
@@ -0,0 +1,274 @@
+package hook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/axmq/ax/store"
+	"github.com/axmq/ax/topic"
+)
+
+// PredicateOp is a comparison operator used when querying the retained index
+type PredicateOp byte
+
+const (
+	OpEq PredicateOp = iota
+	OpNe
+	OpLt
+	OpLte
+	OpGt
+	OpGte
+	OpExists
+)
+
+// Predicate matches a single indexed field against a value
+type Predicate struct {
+	Field string
+	Op    PredicateOp
+	Value any
+}
+
+// RetainedIndexEntry holds the indexed fields extracted from a retained message
+type RetainedIndexEntry struct {
+	Topic     string         `json:"topic" cbor:"topic"`
+	Fields    map[string]any `json:"fields" cbor:"fields"`
+	UpdatedAt time.Time      `json:"updated_at" cbor:"updated_at"`
+}
+
+// RetainedIndexHook extracts selected JSON fields from retained messages into a
+// store-backed index that can be queried by field predicates
+type RetainedIndexHook struct {
+	*Base
+	mu        sync.RWMutex
+	fields    []string
+	normalize topic.NormalizeOptions
+	store     store.Store[*RetainedIndexEntry]
+}
+
+// NewRetainedIndexHook creates a retained index hook that extracts the given
+// dot-separated JSON field paths (e.g. "battery" or "status.battery")
+func NewRetainedIndexHook(s store.Store[*RetainedIndexEntry], fields ...string) *RetainedIndexHook {
+	return &RetainedIndexHook{
+		Base:   &Base{id: "retained-index"},
+		fields: append([]string(nil), fields...),
+		store:  s,
+	}
+}
+
+// ID returns the hook identifier
+func (h *RetainedIndexHook) ID() string {
+	return h.id
+}
+
+// Provides indicates this hook observes retained message changes
+func (h *RetainedIndexHook) Provides(event Event) bool {
+	return event == OnRetainMessage || event == OnRetainedExpired
+}
+
+// SetNormalizeOptions sets how topics are canonicalized before being used as index keys
+func (h *RetainedIndexHook) SetNormalizeOptions(opts topic.NormalizeOptions) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.normalize = opts
+}
+
+// Fields returns the indexed field paths
+func (h *RetainedIndexHook) Fields() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return append([]string(nil), h.fields...)
+}
+
+// OnRetainMessage indexes the payload of a retained message, removing the entry
+// when the retained message is cleared or its payload is not a JSON object
+func (h *RetainedIndexHook) OnRetainMessage(_ *Client, packet *PublishPacket) error {
+	if packet == nil {
+		return nil
+	}
+
+	ctx := context.Background()
+	key := h.key(packet.Topic)
+	if len(packet.Payload) == 0 {
+		return h.store.Delete(ctx, key)
+	}
+
+	var doc map[string]any
+	if err := json.Unmarshal(packet.Payload, &doc); err != nil {
+		return h.store.Delete(ctx, key)
+	}
+
+	h.mu.RLock()
+	fields := make(map[string]any, len(h.fields))
+	for _, path := range h.fields {
+		if v, ok := lookupField(doc, path); ok {
+			fields[path] = v
+		}
+	}
+	h.mu.RUnlock()
+
+	if len(fields) == 0 {
+		return h.store.Delete(ctx, key)
+	}
+
+	return h.store.Save(ctx, key, &RetainedIndexEntry{
+		Topic:     key,
+		Fields:    fields,
+		UpdatedAt: time.Now(),
+	})
+}
+
+// OnRetainedExpired removes the index entry of an expired retained message
+func (h *RetainedIndexHook) OnRetainedExpired(topicName string) error {
+	return h.store.Delete(context.Background(), h.key(topicName))
+}
+
+// Get returns the index entry for a topic
+func (h *RetainedIndexHook) Get(ctx context.Context, topicName string) (*RetainedIndexEntry, error) {
+	return h.store.Load(ctx, h.key(topicName))
+}
+
+// key returns the normalized store key for a topic
+func (h *RetainedIndexHook) key(topicName string) string {
+	h.mu.RLock()
+	opts := h.normalize
+	h.mu.RUnlock()
+	return topic.Normalize(topicName, opts)
+}
+
+// Query returns all index entries matching every predicate
+func (h *RetainedIndexHook) Query(ctx context.Context, predicates ...Predicate) ([]*RetainedIndexEntry, error) {
+	keys, err := h.store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*RetainedIndexEntry, 0)
+	for _, key := range keys {
+		entry, err := h.store.Load(ctx, key)
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		if entry.matches(predicates) {
+			result = append(result, entry)
+		}
+	}
+
+	return result, nil
+}
+
+func (e *RetainedIndexEntry) matches(predicates []Predicate) bool {
+	for _, p := range predicates {
+		v, ok := e.Fields[p.Field]
+		if p.Op == OpExists {
+			if !ok {
+				return false
+			}
+			continue
+		}
+		if !ok || !p.match(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p Predicate) match(v any) bool {
+	if a, ok := toFloat(v); ok {
+		if b, ok := toFloat(p.Value); ok {
+			return compareOrdered(a, b, p.Op)
+		}
+		return p.Op == OpNe
+	}
+	if a, ok := v.(string); ok {
+		if b, ok := p.Value.(string); ok {
+			return compareOrdered(a, b, p.Op)
+		}
+		return p.Op == OpNe
+	}
+	if a, ok := v.(bool); ok {
+		b, ok := p.Value.(bool)
+		switch p.Op {
+		case OpEq:
+			return ok && a == b
+		case OpNe:
+			return !ok || a != b
+		}
+	}
+	return false
+}
+
+func compareOrdered[T float64 | string](a, b T, op PredicateOp) bool {
+	switch op {
+	case OpEq:
+		return a == b
+	case OpNe:
+		return a != b
+	case OpLt:
+		return a < b
+	case OpLte:
+		return a <= b
+	case OpGt:
+		return a > b
+	case OpGte:
+		return a >= b
+	default:
+		return false
+	}
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
+
+func lookupField(doc map[string]any, path string) (any, bool) {
+	var current any = doc
+	for _, part := range strings.Split(path, ".") {
+		m, ok := current.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		current, ok = m[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	switch current.(type) {
+	case map[string]any, []any, nil:
+		return nil, false
+	}
+	return current, true
+}
@@ -0,0 +1,140 @@
+package hook
+
+import (
+	"context"
+	"testing"
+
+	"github.com/axmq/ax/store"
+	"github.com/axmq/ax/topic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRetainedIndexHook(fields ...string) *RetainedIndexHook {
+	return NewRetainedIndexHook(store.NewMemoryStore[*RetainedIndexEntry](), fields...)
+}
+
+func TestRetainedIndexHook(t *testing.T) {
+	hook := newTestRetainedIndexHook("battery", "status.online")
+
+	assert.Equal(t, "retained-index", hook.ID())
+	assert.True(t, hook.Provides(OnRetainMessage))
+	assert.True(t, hook.Provides(OnRetainedExpired))
+	assert.False(t, hook.Provides(OnPublish))
+	assert.Equal(t, []string{"battery", "status.online"}, hook.Fields())
+}
+
+func TestRetainedIndexHookIndexesFields(t *testing.T) {
+	hook := newTestRetainedIndexHook("battery", "status.online", "model")
+	ctx := context.Background()
+
+	err := hook.OnRetainMessage(nil, &PublishPacket{
+		Topic:   "devices/d1/state",
+		Payload: []byte(`{"battery":15,"status":{"online":true},"model":"x1","extra":1}`),
+	})
+	require.NoError(t, err)
+
+	entry, err := hook.Get(ctx, "devices/d1/state")
+	require.NoError(t, err)
+	assert.Equal(t, "devices/d1/state", entry.Topic)
+	assert.Equal(t, map[string]any{"battery": float64(15), "status.online": true, "model": "x1"}, entry.Fields)
+}
+
+func TestRetainedIndexHookRemovesEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+	}{
+		{name: "empty payload", payload: nil},
+		{name: "invalid json", payload: []byte("not json")},
+		{name: "no indexed fields", payload: []byte(`{"other":1}`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := newTestRetainedIndexHook("battery")
+			ctx := context.Background()
+
+			require.NoError(t, hook.OnRetainMessage(nil, &PublishPacket{Topic: "a", Payload: []byte(`{"battery":50}`)}))
+			require.NoError(t, hook.OnRetainMessage(nil, &PublishPacket{Topic: "a", Payload: tt.payload}))
+
+			_, err := hook.Get(ctx, "a")
+			assert.ErrorIs(t, err, store.ErrNotFound)
+		})
+	}
+}
+
+func TestRetainedIndexHookExpired(t *testing.T) {
+	hook := newTestRetainedIndexHook("battery")
+
+	require.NoError(t, hook.OnRetainMessage(nil, &PublishPacket{Topic: "a", Payload: []byte(`{"battery":50}`)}))
+	require.NoError(t, hook.OnRetainedExpired("a"))
+
+	_, err := hook.Get(context.Background(), "a")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
+
+func TestRetainedIndexHookNormalizesKeys(t *testing.T) {
+	hook := newTestRetainedIndexHook("battery")
+	hook.SetNormalizeOptions(topic.NormalizeOptions{TrimTrailingSlash: true})
+	ctx := context.Background()
+
+	require.NoError(t, hook.OnRetainMessage(nil, &PublishPacket{Topic: "devices/d1/", Payload: []byte(`{"battery":50}`)}))
+
+	entry, err := hook.Get(ctx, "devices/d1")
+	require.NoError(t, err)
+	assert.Equal(t, "devices/d1", entry.Topic)
+
+	require.NoError(t, hook.OnRetainedExpired("devices/d1/"))
+	_, err = hook.Get(ctx, "devices/d1")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
+
+func TestRetainedIndexHookQuery(t *testing.T) {
+	hook := newTestRetainedIndexHook("battery", "model", "online")
+	ctx := context.Background()
+
+	payloads := map[string]string{
+		"devices/d1": `{"battery":10,"model":"x1","online":true}`,
+		"devices/d2": `{"battery":55,"model":"x2","online":false}`,
+		"devices/d3": `{"battery":19.5,"model":"x1"}`,
+	}
+	for topic, payload := range payloads {
+		require.NoError(t, hook.OnRetainMessage(nil, &PublishPacket{Topic: topic, Payload: []byte(payload)}))
+	}
+
+	tests := []struct {
+		name       string
+		predicates []Predicate
+		expected   []string
+	}{
+		{name: "no predicates", expected: []string{"devices/d1", "devices/d2", "devices/d3"}},
+		{name: "lt", predicates: []Predicate{{Field: "battery", Op: OpLt, Value: 20}}, expected: []string{"devices/d1", "devices/d3"}},
+		{name: "gte", predicates: []Predicate{{Field: "battery", Op: OpGte, Value: 55}}, expected: []string{"devices/d2"}},
+		{name: "string eq", predicates: []Predicate{{Field: "model", Op: OpEq, Value: "x1"}}, expected: []string{"devices/d1", "devices/d3"}},
+		{name: "bool eq", predicates: []Predicate{{Field: "online", Op: OpEq, Value: false}}, expected: []string{"devices/d2"}},
+		{name: "exists", predicates: []Predicate{{Field: "online", Op: OpExists}}, expected: []string{"devices/d1", "devices/d2"}},
+		{
+			name: "combined",
+			predicates: []Predicate{
+				{Field: "battery", Op: OpLt, Value: 20},
+				{Field: "online", Op: OpEq, Value: true},
+			},
+			expected: []string{"devices/d1"},
+		},
+		{name: "type mismatch", predicates: []Predicate{{Field: "model", Op: OpLt, Value: 5}}, expected: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := hook.Query(ctx, tt.predicates...)
+			require.NoError(t, err)
+
+			topics := make([]string, 0, len(entries))
+			for _, e := range entries {
+				topics = append(topics, e.Topic)
+			}
+			assert.ElementsMatch(t, tt.expected, topics)
+		})
+	}
+}
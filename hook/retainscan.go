@@ -0,0 +1,76 @@
+package hook
+
+import (
+	"context"
+	"errors"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/store"
+)
+
+// InvalidRetainedEntry describes a retained store entry whose topic is not a valid PUBLISH topic
+type InvalidRetainedEntry struct {
+	Key    string
+	Topic  string
+	Reason encoding.ReasonCode
+	Err    error
+}
+
+// RetainedScanReport summarizes a scan of a retained message store
+type RetainedScanReport struct {
+	Scanned int
+	Invalid []InvalidRetainedEntry
+	Removed int
+}
+
+// ScanRetainedStore checks every retained message for a topic that could not have been published,
+// such as names containing wildcards or the $share/ prefix written before validation was enforced
+// When remove is true, invalid entries are deleted from the store
+func ScanRetainedStore(ctx context.Context, s store.Store[*RetainedMessage], remove bool) (*RetainedScanReport, error) {
+	keys, err := s.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RetainedScanReport{Invalid: make([]InvalidRetainedEntry, 0)}
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		msg, err := s.Load(ctx, key)
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				continue
+			}
+			return report, err
+		}
+		report.Scanned++
+
+		topicName := key
+		if msg != nil && msg.Topic != "" {
+			topicName = msg.Topic
+		}
+
+		verr := encoding.ValidateRetainTopicName(topicName)
+		if verr == nil {
+			continue
+		}
+
+		report.Invalid = append(report.Invalid, InvalidRetainedEntry{
+			Key:    key,
+			Topic:  topicName,
+			Reason: encoding.GetReasonCode(verr),
+			Err:    verr,
+		})
+
+		if remove {
+			if err := s.Delete(ctx, key); err != nil && !errors.Is(err, store.ErrNotFound) {
+				return report, err
+			}
+			report.Removed++
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,63 @@
+package hook
+
+import (
+	"context"
+	"testing"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanRetainedStore(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name        string
+		remove      bool
+		wantRemoved int
+		wantCount   int64
+	}{
+		{name: "report only", remove: false, wantRemoved: 0, wantCount: 4},
+		{name: "remove invalid", remove: true, wantRemoved: 2, wantCount: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := store.NewMemoryStore[*RetainedMessage]()
+			require.NoError(t, s.Save(ctx, "home/temp", &RetainedMessage{Topic: "home/temp"}))
+			require.NoError(t, s.Save(ctx, "home/+", &RetainedMessage{Topic: "home/+"}))
+			require.NoError(t, s.Save(ctx, "$share/g/home", &RetainedMessage{Topic: "$share/g/home"}))
+			require.NoError(t, s.Save(ctx, "home/humidity", &RetainedMessage{}))
+
+			report, err := ScanRetainedStore(ctx, s, tt.remove)
+			require.NoError(t, err)
+			assert.Equal(t, 4, report.Scanned)
+			assert.Equal(t, tt.wantRemoved, report.Removed)
+			require.Len(t, report.Invalid, 2)
+
+			invalid := map[string]InvalidRetainedEntry{}
+			for _, entry := range report.Invalid {
+				invalid[entry.Key] = entry
+				assert.Equal(t, encoding.ReasonTopicNameInvalid, entry.Reason)
+			}
+			assert.ErrorIs(t, invalid["home/+"].Err, encoding.ErrInvalidPublishTopicName)
+			assert.ErrorIs(t, invalid["$share/g/home"].Err, encoding.ErrSharedPublishTopicName)
+
+			count, err := s.Count(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantCount, count)
+		})
+	}
+}
+
+func TestScanRetainedStoreCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := store.NewMemoryStore[*RetainedMessage]()
+	require.NoError(t, s.Save(context.Background(), "a", &RetainedMessage{Topic: "a"}))
+	cancel()
+
+	_, err := ScanRetainedStore(ctx, s, false)
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,278 @@
+package hook
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/axmq/ax/admin"
+	"github.com/axmq/ax/store"
+	"github.com/axmq/ax/topic"
+)
+
+const (
+	DefaultRetainedQueryLimit = 100
+	MaxRetainedQueryLimit     = 1000
+
+	_retainedScanBatch = 512
+)
+
+// RetainedPage is a page of retained messages in topic order
+type RetainedPage struct {
+	Messages []*RetainedMessage `json:"messages"`
+	// NextCursor fetches the following page, it is empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+	// Total estimates the retained messages matching the filter, it counts topics without loading
+	// their messages so expired messages not purged yet are included
+	Total int64 `json:"total"`
+}
+
+// RetainedStore persists retained messages keyed by their topic name
+type RetainedStore struct {
+	store store.Store[*RetainedMessage]
+	guard *admin.Guard
+}
+
+// NewRetainedStore creates a retained message store on top of s
+func NewRetainedStore(s store.Store[*RetainedMessage]) *RetainedStore {
+	return &RetainedStore{store: s}
+}
+
+// SetGuard makes Query check the principal carried by its context for admin.PermissionMetricsRead
+func (r *RetainedStore) SetGuard(guard *admin.Guard) {
+	r.guard = guard
+}
+
+// Store returns the underlying store
+func (r *RetainedStore) Store() store.Store[*RetainedMessage] {
+	return r.store
+}
+
+// Save stores msg under its topic, a message with an empty payload clears the topic instead
+func (r *RetainedStore) Save(ctx context.Context, msg *RetainedMessage) error {
+	if len(msg.Payload) == 0 {
+		return r.Delete(ctx, msg.Topic)
+	}
+	return r.store.Save(ctx, msg.Topic, msg)
+}
+
+// Load returns the retained message of a topic, an expired message is deleted and reported as not found
+func (r *RetainedStore) Load(ctx context.Context, topicName string) (*RetainedMessage, error) {
+	msg, err := r.store.Load(ctx, topicName)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Expired(time.Now()) {
+		if err := r.Delete(ctx, topicName); err != nil {
+			return nil, err
+		}
+		return nil, store.ErrNotFound
+	}
+	return msg, nil
+}
+
+// Query returns up to limit retained messages matching filter in topic order, starting after cursor
+// Pass the NextCursor of a page to fetch the following one, pages stay stable while topics are added or
+// removed since the cursor is the last topic returned. Only topics under the literal prefix of filter
+// are scanned on stores implementing store.KeyScanner
+func (r *RetainedStore) Query(ctx context.Context, filter, cursor string, limit int) (*RetainedPage, error) {
+	if err := r.guard.Authorize(ctx, admin.PermissionMetricsRead); err != nil {
+		return nil, err
+	}
+	if err := topic.ValidateTopicFilter(filter); err != nil {
+		return nil, fmt.Errorf("%w: filter %q: %v", ErrInvalidRetainedQuery, filter, err)
+	}
+	after, err := decodeRetainedCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = DefaultRetainedQueryLimit
+	}
+	limit = min(limit, MaxRetainedQueryLimit)
+
+	prefix := filterPrefix(filter)
+	batch := _retainedScanBatch
+	if _, ok := r.store.(store.KeyScanner); !ok {
+		// Without a scanner every call lists all keys, so take them in one go
+		batch = 0
+	}
+
+	page := &RetainedPage{Messages: make([]*RetainedMessage, 0)}
+scan:
+	for {
+		keys, err := store.ScanKeys(ctx, r.store, prefix, after, batch)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			after = key
+			if !topic.MatchFilter(filter, key) {
+				continue
+			}
+			msg, err := r.Load(ctx, key)
+			if errors.Is(err, store.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			page.Messages = append(page.Messages, msg)
+			if len(page.Messages) == limit {
+				page.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(key))
+				break scan
+			}
+		}
+		if batch == 0 || len(keys) < batch {
+			break
+		}
+	}
+
+	if page.Total, err = r.countMatching(ctx, filter, prefix, batch); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// countMatching counts the topics matching filter without loading their messages
+func (r *RetainedStore) countMatching(ctx context.Context, filter, prefix string, batch int) (int64, error) {
+	if filter == "#" {
+		return r.store.Count(ctx)
+	}
+	if !strings.ContainsAny(filter, "+#") {
+		exists, err := r.store.Exists(ctx, filter)
+		if exists {
+			return 1, err
+		}
+		return 0, err
+	}
+
+	var total int64
+	after := ""
+	for {
+		keys, err := store.ScanKeys(ctx, r.store, prefix, after, batch)
+		if err != nil {
+			return 0, err
+		}
+		for _, key := range keys {
+			if topic.MatchFilter(filter, key) {
+				total++
+			}
+		}
+		if batch == 0 || len(keys) < batch {
+			return total, nil
+		}
+		after = keys[len(keys)-1]
+	}
+}
+
+// filterPrefix returns the literal leading part of a topic filter, every matching topic starts with it
+// A trailing /# is dropped with its separator since a/# also matches a
+func filterPrefix(filter string) string {
+	i := strings.IndexAny(filter, "+#")
+	if i < 0 {
+		return filter
+	}
+	if filter[i] == '#' && i > 0 {
+		return filter[:i-1]
+	}
+	return filter[:i]
+}
+
+func decodeRetainedCursor(cursor string) (string, error) {
+	if cursor == "" {
+		return "", nil
+	}
+	after, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(after) == 0 {
+		return "", fmt.Errorf("%w: malformed cursor", ErrInvalidRetainedQuery)
+	}
+	return string(after), nil
+}
+
+// PurgeExpired deletes every retained message past its Message Expiry Interval and returns their topics
+func (r *RetainedStore) PurgeExpired(ctx context.Context) ([]string, error) {
+	keys, err := r.store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var expired []string
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return expired, err
+		}
+
+		msg, err := r.store.Load(ctx, key)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return expired, err
+		}
+		if !msg.Expired(now) {
+			continue
+		}
+		if err := r.Delete(ctx, key); err != nil {
+			return expired, err
+		}
+		expired = append(expired, msg.Topic)
+	}
+	return expired, nil
+}
+
+// Delete clears the retained message of a topic, clearing a topic without one is not an error
+func (r *RetainedStore) Delete(ctx context.Context, topicName string) error {
+	if err := r.store.Delete(ctx, topicName); err != nil && !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// DeletePrefix clears every retained message whose topic starts with topicPrefix
+// The prefix is matched bytewise, so "tenant/a" also covers "tenant/abc/..."
+func (r *RetainedStore) DeletePrefix(ctx context.Context, topicPrefix string) error {
+	return r.store.DeletePrefix(ctx, topicPrefix)
+}
+
+// Clear deletes every retained message one topic at a time, DeletePrefix refuses to clear the whole store
+func (r *RetainedStore) Clear(ctx context.Context) error {
+	keys, err := r.store.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := r.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PurgeNamespace clears the retained message of root and of every topic below it,
+// e.g. purging "tenant/a" removes "tenant/a" and "tenant/a/#" but leaves "tenant/ab" alone
+func (r *RetainedStore) PurgeNamespace(ctx context.Context, root string) error {
+	if err := r.Delete(ctx, root); err != nil {
+		return err
+	}
+	return r.store.DeletePrefix(ctx, root+"/")
+}
+
+// ExpiresAt returns when the message expires, ok is false for messages without a Message Expiry Interval
+func (m *RetainedMessage) ExpiresAt() (deadline time.Time, ok bool) {
+	expiry, ok := m.Properties.Uint32(messageExpiryProperty)
+	if !ok || expiry == 0 {
+		return time.Time{}, false
+	}
+	return m.Timestamp.Add(time.Duration(expiry) * time.Second), true
+}
+
+// Expired reports whether the message is past its Message Expiry Interval at now
+func (m *RetainedMessage) Expired(now time.Time) bool {
+	deadline, ok := m.ExpiresAt()
+	return ok && !now.Before(deadline)
+}
@@ -0,0 +1,236 @@
+package hook
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/axmq/ax/admin"
+	"github.com/axmq/ax/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetainedStore(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		purge func(*RetainedStore) error
+		want  []string
+	}{
+		{
+			name:  "delete prefix",
+			purge: func(r *RetainedStore) error { return r.DeletePrefix(ctx, "tenant/a") },
+			want:  []string{"tenant/b/temp"},
+		},
+		{
+			name:  "purge namespace",
+			purge: func(r *RetainedStore) error { return r.PurgeNamespace(ctx, "tenant/a") },
+			want:  []string{"tenant/ab/temp", "tenant/b/temp"},
+		},
+		{
+			name:  "clear",
+			purge: func(r *RetainedStore) error { return r.Clear(ctx) },
+			want:  []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRetainedStore(store.NewMemoryStore[*RetainedMessage]())
+			for _, name := range []string{"tenant/a", "tenant/a/temp", "tenant/a/x/y", "tenant/ab/temp", "tenant/b/temp"} {
+				require.NoError(t, r.Save(ctx, &RetainedMessage{Topic: name, Payload: []byte("1")}))
+			}
+
+			require.NoError(t, tt.purge(r))
+
+			keys, err := r.Store().List(ctx)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, tt.want, keys)
+		})
+	}
+}
+
+func TestRetainedStore_SaveEmptyPayloadClears(t *testing.T) {
+	ctx := context.Background()
+	r := NewRetainedStore(store.NewMemoryStore[*RetainedMessage]())
+
+	require.NoError(t, r.Save(ctx, &RetainedMessage{Topic: "home/temp", Payload: []byte("21")}))
+	msg, err := r.Load(ctx, "home/temp")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("21"), msg.Payload)
+
+	require.NoError(t, r.Save(ctx, &RetainedMessage{Topic: "home/temp"}))
+	_, err = r.Load(ctx, "home/temp")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	assert.NoError(t, r.Delete(ctx, "home/temp"))
+}
+
+func TestRetainedStore_Expiry(t *testing.T) {
+	newStores := map[string]func(t *testing.T) store.Store[*RetainedMessage]{
+		"memory": func(*testing.T) store.Store[*RetainedMessage] {
+			return store.NewMemoryStore[*RetainedMessage]()
+		},
+		// Pebble decodes the expiry as uint64, it must still expire the messages after the round trip
+		"pebble": func(t *testing.T) store.Store[*RetainedMessage] {
+			s, err := store.NewPebbleStore[*RetainedMessage](store.PebbleStoreConfig{Path: t.TempDir()})
+			require.NoError(t, err)
+			t.Cleanup(func() { s.Close() })
+			return s
+		},
+	}
+	for name, newStore := range newStores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			r := NewRetainedStore(newStore(t))
+
+			old := time.Now().Add(-time.Hour)
+			require.NoError(t, r.Save(ctx, &RetainedMessage{Topic: "a", Payload: []byte("1"), Timestamp: old, Properties: Properties{"MessageExpiryInterval": uint32(60)}}))
+			require.NoError(t, r.Save(ctx, &RetainedMessage{Topic: "b", Payload: []byte("1"), Timestamp: old, Properties: Properties{"MessageExpiryInterval": uint32(7200)}}))
+			require.NoError(t, r.Save(ctx, &RetainedMessage{Topic: "c", Payload: []byte("1"), Timestamp: old}))
+			require.NoError(t, r.Save(ctx, &RetainedMessage{Topic: "d", Payload: []byte("1"), Timestamp: old, Properties: Properties{"MessageExpiryInterval": uint32(1)}}))
+
+			_, err := r.Load(ctx, "a")
+			assert.ErrorIs(t, err, store.ErrNotFound)
+			msg, err := r.Load(ctx, "b")
+			require.NoError(t, err)
+			deadline, ok := msg.ExpiresAt()
+			assert.True(t, ok)
+			assert.WithinDuration(t, old.Add(2*time.Hour), deadline, time.Second)
+
+			expired, err := r.PurgeExpired(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"d"}, expired)
+
+			keys, err := r.Store().List(ctx)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []string{"b", "c"}, keys)
+		})
+	}
+}
+
+func TestPropertiesUint32(t *testing.T) {
+	props := Properties{
+		"u32":      uint32(60),
+		"u64":      uint64(60),
+		"i64":      int64(60),
+		"json":     float64(60),
+		"fraction": 1.5,
+		"negative": int64(-1),
+		"large":    uint64(math.MaxUint32 + 1),
+		"string":   "60",
+	}
+	for _, key := range []string{"u32", "u64", "i64", "json"} {
+		v, ok := props.Uint32(key)
+		assert.True(t, ok, key)
+		assert.Equal(t, uint32(60), v, key)
+	}
+	for _, key := range []string{"fraction", "negative", "large", "string", "missing"} {
+		_, ok := props.Uint32(key)
+		assert.False(t, ok, key)
+	}
+}
+
+func retainedTopics(page *RetainedPage) []string {
+	topics := make([]string, len(page.Messages))
+	for i, msg := range page.Messages {
+		topics[i] = msg.Topic
+	}
+	return topics
+}
+
+func TestRetainedStoreQuery(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range map[string]store.Store[*RetainedMessage]{
+		"scanner":  store.NewMemoryStore[*RetainedMessage](),
+		"fallback": struct{ store.Store[*RetainedMessage] }{store.NewMemoryStore[*RetainedMessage]()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := NewRetainedStore(s)
+			for _, name := range []string{"devices", "devices/d3/state", "devices/d1/state", "devices/d2/state", "devices/d1/config", "devicesx/d9/state", "other/x"} {
+				require.NoError(t, r.Save(ctx, &RetainedMessage{Topic: name, Payload: []byte("1"), Timestamp: time.Now()}))
+			}
+
+			page, err := r.Query(ctx, "devices/+/state", "", 2)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"devices/d1/state", "devices/d2/state"}, retainedTopics(page))
+			assert.Equal(t, int64(3), page.Total)
+			require.NotEmpty(t, page.NextCursor)
+
+			// A topic added before the cursor does not shift the next page
+			require.NoError(t, r.Save(ctx, &RetainedMessage{Topic: "devices/d0/state", Payload: []byte("1")}))
+			page, err = r.Query(ctx, "devices/+/state", page.NextCursor, 2)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"devices/d3/state"}, retainedTopics(page))
+			assert.Empty(t, page.NextCursor)
+			assert.Equal(t, int64(4), page.Total)
+
+			page, err = r.Query(ctx, "devices/#", "", 0)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"devices", "devices/d0/state", "devices/d1/config", "devices/d1/state", "devices/d2/state", "devices/d3/state"}, retainedTopics(page))
+
+			page, err = r.Query(ctx, "#", "", 0)
+			require.NoError(t, err)
+			assert.Len(t, page.Messages, 8)
+			assert.Equal(t, int64(8), page.Total)
+
+			page, err = r.Query(ctx, "other/x", "", 0)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"other/x"}, retainedTopics(page))
+			assert.Equal(t, int64(1), page.Total)
+		})
+	}
+}
+
+func TestRetainedStoreQuerySkipsExpired(t *testing.T) {
+	ctx := context.Background()
+	r := NewRetainedStore(store.NewMemoryStore[*RetainedMessage]())
+	require.NoError(t, r.Save(ctx, &RetainedMessage{Topic: "a/1", Payload: []byte("1"), Timestamp: time.Now()}))
+	require.NoError(t, r.Save(ctx, &RetainedMessage{
+		Topic:      "a/2",
+		Payload:    []byte("1"),
+		Timestamp:  time.Now().Add(-time.Hour),
+		Properties: Properties{messageExpiryProperty: uint32(60)},
+	}))
+
+	page, err := r.Query(ctx, "a/+", "", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a/1"}, retainedTopics(page))
+}
+
+func TestRetainedStoreQueryInvalid(t *testing.T) {
+	r := NewRetainedStore(store.NewMemoryStore[*RetainedMessage]())
+	_, err := r.Query(context.Background(), "a/#/b", "", 10)
+	assert.ErrorIs(t, err, ErrInvalidRetainedQuery)
+	_, err = r.Query(context.Background(), "a/#", "!!", 10)
+	assert.ErrorIs(t, err, ErrInvalidRetainedQuery)
+}
+
+func TestRetainedStoreQueryGuard(t *testing.T) {
+	ctx := context.Background()
+	r := NewRetainedStore(store.NewMemoryStore[*RetainedMessage]())
+	require.NoError(t, r.Save(ctx, &RetainedMessage{Topic: "a/1", Payload: []byte("1"), Timestamp: time.Now()}))
+	guard, err := admin.NewGuard(admin.GuardConfig{Authenticator: admin.Chain()})
+	require.NoError(t, err)
+	r.SetGuard(guard)
+
+	_, err = r.Query(ctx, "#", "", 10)
+	assert.ErrorIs(t, err, admin.ErrNoCredentials)
+	_, err = r.Query(admin.WithPrincipal(ctx, &admin.Principal{Name: "anon"}), "#", "", 10)
+	assert.ErrorIs(t, err, admin.ErrForbidden)
+
+	page, err := r.Query(admin.WithPrincipal(ctx, &admin.Principal{Name: "grafana", Roles: []string{admin.RoleViewer}}), "#", "", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a/1"}, retainedTopics(page))
+}
+
+func TestFilterPrefix(t *testing.T) {
+	assert.Equal(t, "", filterPrefix("#"))
+	assert.Equal(t, "", filterPrefix("+/x"))
+	assert.Equal(t, "a/b", filterPrefix("a/b/#"))
+	assert.Equal(t, "a/", filterPrefix("a/+/c"))
+	assert.Equal(t, "a/b", filterPrefix("a/b"))
+}
@@ -0,0 +1,296 @@
+package hook
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/axmq/ax/store"
+)
+
+// DefaultTombstoneTTL is how long a cleared retained topic is remembered so anti-entropy does not resurrect it
+const DefaultTombstoneTTL = 24 * time.Hour
+
+// RetainedVersion identifies the revision of a retained topic held by a replica
+type RetainedVersion struct {
+	Timestamp time.Time
+	Node      string
+	Deleted   bool
+}
+
+// Newer reports whether v wins over other: the later timestamp wins and the greater node ID breaks ties,
+// so every replica picks the same message regardless of the order updates arrive in
+func (v RetainedVersion) Newer(other RetainedVersion) bool {
+	if !v.Timestamp.Equal(other.Timestamp) {
+		return v.Timestamp.After(other.Timestamp)
+	}
+	return v.Node > other.Node
+}
+
+// Version returns the revision of the message, an empty payload is a deletion
+func (m *RetainedMessage) Version() RetainedVersion {
+	return RetainedVersion{Timestamp: m.Timestamp, Node: m.Node, Deleted: len(m.Payload) == 0}
+}
+
+// RetainedPeer is a remote replica retained messages are pulled from during anti-entropy
+type RetainedPeer interface {
+	// Digest returns the version of every retained topic the peer holds, including recent deletions
+	Digest(ctx context.Context) (map[string]RetainedVersion, error)
+
+	// Messages returns the messages of the given topics, deletions as messages with an empty payload
+	Messages(ctx context.Context, topics []string) ([]*RetainedMessage, error)
+}
+
+// RetainedReplica is a node's copy of the cluster retained messages, it keeps only the latest message
+// per topic across nodes and converges with peers through periodic anti-entropy
+type RetainedReplica struct {
+	mu           sync.Mutex
+	store        *RetainedStore
+	tombstones   store.Store[*RetainedMessage]
+	node         string
+	tombstoneTTL time.Duration
+	now          func() time.Time
+
+	// OnSyncError, when set, receives errors of anti-entropy rounds
+	OnSyncError func(err error)
+}
+
+// NewRetainedReplica creates the replica of node on top of s, a zero tombstoneTTL uses DefaultTombstoneTTL
+// Cleared topics are remembered in tombstones, which must be as durable as s or a restarted node resurrects
+// them from its peers. A nil tombstones keeps them in memory
+func NewRetainedReplica(s *RetainedStore, tombstones store.Store[*RetainedMessage], node string, tombstoneTTL time.Duration) *RetainedReplica {
+	if tombstones == nil {
+		tombstones = store.NewMemoryStore[*RetainedMessage]()
+	}
+	if tombstoneTTL <= 0 {
+		tombstoneTTL = DefaultTombstoneTTL
+	}
+	return &RetainedReplica{
+		store:        s,
+		tombstones:   tombstones,
+		node:         node,
+		tombstoneTTL: tombstoneTTL,
+		now:          time.Now,
+	}
+}
+
+// Node returns the node ID of the replica
+func (r *RetainedReplica) Node() string {
+	return r.node
+}
+
+// Save stores a retained message published on this node, stamping it with the node ID and,
+// if unset, the current time, an empty payload clears the topic
+// The message is dropped when the replica already holds a newer revision of the topic, e.g. merged from
+// a node whose clock is ahead
+func (r *RetainedReplica) Save(ctx context.Context, msg *RetainedMessage) error {
+	stamped := *msg
+	stamped.Node = r.node
+	if stamped.Timestamp.IsZero() {
+		stamped.Timestamp = r.now()
+	}
+
+	_, err := r.Merge(ctx, &stamped)
+	return err
+}
+
+// Merge applies a message replicated from another node if it is newer than the local revision
+func (r *RetainedReplica) Merge(ctx context.Context, msg *RetainedMessage) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, ok, err := r.versionLocked(ctx, msg.Topic)
+	if err != nil {
+		return false, err
+	}
+	if ok && !msg.Version().Newer(current) {
+		return false, nil
+	}
+	return true, r.applyLocked(ctx, msg)
+}
+
+func (r *RetainedReplica) applyLocked(ctx context.Context, msg *RetainedMessage) error {
+	if len(msg.Payload) == 0 {
+		tombstone := &RetainedMessage{Topic: msg.Topic, Timestamp: msg.Timestamp, Node: msg.Node}
+		if err := r.tombstones.Save(ctx, msg.Topic, tombstone); err != nil {
+			return err
+		}
+		return r.store.Delete(ctx, msg.Topic)
+	}
+	if err := r.store.Save(ctx, msg); err != nil {
+		return err
+	}
+	if err := r.tombstones.Delete(ctx, msg.Topic); err != nil && !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// versionLocked returns the local revision of a topic, from the stored message or a tombstone
+func (r *RetainedReplica) versionLocked(ctx context.Context, topicName string) (RetainedVersion, bool, error) {
+	msg, err := r.store.Load(ctx, topicName)
+	switch {
+	case err == nil:
+		return msg.Version(), true, nil
+	case !errors.Is(err, store.ErrNotFound):
+		return RetainedVersion{}, false, err
+	}
+
+	tombstone, err := r.tombstones.Load(ctx, topicName)
+	switch {
+	case err == nil:
+		return tombstone.Version(), true, nil
+	case errors.Is(err, store.ErrNotFound):
+		return RetainedVersion{}, false, nil
+	default:
+		return RetainedVersion{}, false, err
+	}
+}
+
+// tombstonesLocked returns the tombstones younger than the tombstone TTL, older ones are deleted
+func (r *RetainedReplica) tombstonesLocked(ctx context.Context) (map[string]RetainedVersion, error) {
+	keys, err := r.tombstones.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := r.now().Add(-r.tombstoneTTL)
+	live := make(map[string]RetainedVersion, len(keys))
+	for _, key := range keys {
+		tombstone, err := r.tombstones.Load(ctx, key)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if tombstone.Timestamp.Before(cutoff) {
+			if err := r.tombstones.Delete(ctx, key); err != nil && !errors.Is(err, store.ErrNotFound) {
+				return nil, err
+			}
+			continue
+		}
+		live[key] = tombstone.Version()
+	}
+	return live, nil
+}
+
+// Digest returns the version of every retained topic and recent deletion held by the replica
+func (r *RetainedReplica) Digest(ctx context.Context) (map[string]RetainedVersion, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys, err := r.store.Store().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tombstones, err := r.tombstonesLocked(ctx)
+	if err != nil {
+		return nil, err
+	}
+	digest := make(map[string]RetainedVersion, len(keys)+len(tombstones))
+	for topicName, v := range tombstones {
+		digest[topicName] = v
+	}
+	for _, key := range keys {
+		msg, err := r.store.Load(ctx, key)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		digest[msg.Topic] = msg.Version()
+	}
+	return digest, nil
+}
+
+// Messages returns the messages of the given topics, deletions as messages with an empty payload
+func (r *RetainedReplica) Messages(ctx context.Context, topics []string) ([]*RetainedMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	msgs := make([]*RetainedMessage, 0, len(topics))
+	for _, topicName := range topics {
+		msg, err := r.store.Load(ctx, topicName)
+		if err == nil {
+			msgs = append(msgs, msg)
+			continue
+		}
+		if !errors.Is(err, store.ErrNotFound) {
+			return nil, err
+		}
+		tombstone, err := r.tombstones.Load(ctx, topicName)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, tombstone)
+	}
+	return msgs, nil
+}
+
+// SyncFrom pulls every topic where peer holds a newer revision and returns how many were applied
+func (r *RetainedReplica) SyncFrom(ctx context.Context, peer RetainedPeer) (int, error) {
+	remote, err := peer.Digest(ctx)
+	if err != nil {
+		return 0, err
+	}
+	local, err := r.Digest(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var stale []string
+	for topicName, v := range remote {
+		if current, ok := local[topicName]; !ok || v.Newer(current) {
+			stale = append(stale, topicName)
+		}
+	}
+	if len(stale) == 0 {
+		return 0, nil
+	}
+	sort.Strings(stale)
+
+	msgs, err := peer.Messages(ctx, stale)
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for _, msg := range msgs {
+		ok, err := r.Merge(ctx, msg)
+		if err != nil {
+			return applied, err
+		}
+		if ok {
+			applied++
+		}
+	}
+	return applied, nil
+}
+
+// RunAntiEntropy syncs from every peer each interval until ctx is done, peers is called every round
+// so cluster membership changes are picked up
+func (r *RetainedReplica) RunAntiEntropy(ctx context.Context, interval time.Duration, peers func() []RetainedPeer) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, peer := range peers() {
+				if _, err := r.SyncFrom(ctx, peer); err != nil && r.OnSyncError != nil {
+					r.OnSyncError(err)
+				}
+			}
+		}
+	}
+}
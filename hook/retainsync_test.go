@@ -0,0 +1,213 @@
+package hook
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/axmq/ax/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestReplica(node string) *RetainedReplica {
+	return NewRetainedReplica(NewRetainedStore(store.NewMemoryStore[*RetainedMessage]()), nil, node, 0)
+}
+
+func TestRetainedVersion_Newer(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name string
+		a, b RetainedVersion
+		want bool
+	}{
+		{name: "later timestamp", a: RetainedVersion{Timestamp: now.Add(time.Second), Node: "a"}, b: RetainedVersion{Timestamp: now, Node: "b"}, want: true},
+		{name: "earlier timestamp", a: RetainedVersion{Timestamp: now, Node: "b"}, b: RetainedVersion{Timestamp: now.Add(time.Second), Node: "a"}, want: false},
+		{name: "node tiebreak", a: RetainedVersion{Timestamp: now, Node: "b"}, b: RetainedVersion{Timestamp: now, Node: "a"}, want: true},
+		{name: "identical", a: RetainedVersion{Timestamp: now, Node: "a"}, b: RetainedVersion{Timestamp: now, Node: "a"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.a.Newer(tt.b))
+		})
+	}
+}
+
+func TestRetainedReplica_Merge(t *testing.T) {
+	ctx := context.Background()
+	r := newTestReplica("node-a")
+	now := time.Now()
+
+	require.NoError(t, r.Save(ctx, &RetainedMessage{Topic: "t", Payload: []byte("local"), Timestamp: now}))
+
+	applied, err := r.Merge(ctx, &RetainedMessage{Topic: "t", Payload: []byte("old"), Timestamp: now.Add(-time.Second), Node: "node-b"})
+	require.NoError(t, err)
+	assert.False(t, applied)
+
+	applied, err = r.Merge(ctx, &RetainedMessage{Topic: "t", Payload: []byte("tie"), Timestamp: now, Node: "node-b"})
+	require.NoError(t, err)
+	assert.True(t, applied)
+
+	msg, err := r.store.Load(ctx, "t")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("tie"), msg.Payload)
+	assert.Equal(t, "node-b", msg.Node)
+
+	applied, err = r.Merge(ctx, &RetainedMessage{Topic: "t", Timestamp: now.Add(time.Second), Node: "node-a"})
+	require.NoError(t, err)
+	assert.True(t, applied)
+	_, err = r.store.Load(ctx, "t")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	applied, err = r.Merge(ctx, &RetainedMessage{Topic: "t", Payload: []byte("stale"), Timestamp: now, Node: "node-c"})
+	require.NoError(t, err)
+	assert.False(t, applied, "a tombstone must keep older messages from resurrecting the topic")
+}
+
+func TestRetainedReplica_SaveKeepsNewer(t *testing.T) {
+	ctx := context.Background()
+	r := newTestReplica("node-a")
+	now := time.Now()
+
+	applied, err := r.Merge(ctx, &RetainedMessage{Topic: "t", Payload: []byte("remote"), Timestamp: now, Node: "node-b"})
+	require.NoError(t, err)
+	require.True(t, applied)
+
+	require.NoError(t, r.Save(ctx, &RetainedMessage{Topic: "t", Payload: []byte("older"), Timestamp: now.Add(-time.Second)}))
+	msg, err := r.store.Load(ctx, "t")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("remote"), msg.Payload)
+
+	require.NoError(t, r.Save(ctx, &RetainedMessage{Topic: "t", Payload: []byte("newer"), Timestamp: now.Add(time.Second)}))
+	msg, err = r.store.Load(ctx, "t")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("newer"), msg.Payload)
+	assert.Equal(t, "node-a", msg.Node)
+}
+
+func TestRetainedReplica_PersistedTombstones(t *testing.T) {
+	ctx := context.Background()
+	retained := NewRetainedStore(store.NewMemoryStore[*RetainedMessage]())
+	tombstones := store.NewMemoryStore[*RetainedMessage]()
+	now := time.Now()
+
+	r := NewRetainedReplica(retained, tombstones, "node-a", 0)
+	require.NoError(t, r.Save(ctx, &RetainedMessage{Topic: "t", Payload: []byte("1"), Timestamp: now}))
+	require.NoError(t, r.Save(ctx, &RetainedMessage{Topic: "t", Timestamp: now.Add(time.Second)}))
+
+	// A restarted node still knows the topic was cleared
+	restarted := NewRetainedReplica(retained, tombstones, "node-a", 0)
+	applied, err := restarted.Merge(ctx, &RetainedMessage{Topic: "t", Payload: []byte("stale"), Timestamp: now, Node: "node-b"})
+	require.NoError(t, err)
+	assert.False(t, applied)
+
+	digest, err := restarted.Digest(ctx)
+	require.NoError(t, err)
+	assert.True(t, digest["t"].Deleted)
+
+	// Republishing the topic drops its tombstone
+	require.NoError(t, restarted.Save(ctx, &RetainedMessage{Topic: "t", Payload: []byte("2"), Timestamp: now.Add(2 * time.Second)}))
+	exists, err := tombstones.Exists(ctx, "t")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestRetainedReplica_SyncConverges(t *testing.T) {
+	ctx := context.Background()
+	a := newTestReplica("node-a")
+	b := newTestReplica("node-b")
+	now := time.Now()
+
+	// Both sides took writes while partitioned
+	require.NoError(t, a.Save(ctx, &RetainedMessage{Topic: "shared", Payload: []byte("from a"), Timestamp: now}))
+	require.NoError(t, b.Save(ctx, &RetainedMessage{Topic: "shared", Payload: []byte("from b"), Timestamp: now.Add(time.Second)}))
+	require.NoError(t, a.Save(ctx, &RetainedMessage{Topic: "only-a", Payload: []byte("1"), Timestamp: now}))
+	require.NoError(t, b.Save(ctx, &RetainedMessage{Topic: "cleared", Payload: []byte("1"), Timestamp: now}))
+	require.NoError(t, a.Save(ctx, &RetainedMessage{Topic: "cleared", Timestamp: now.Add(time.Second)}))
+
+	n, err := a.SyncFrom(ctx, b)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = b.SyncFrom(ctx, a)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	digestA, err := a.Digest(ctx)
+	require.NoError(t, err)
+	digestB, err := b.Digest(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, digestA, digestB)
+	assert.True(t, digestA["cleared"].Deleted)
+
+	for _, r := range []*RetainedReplica{a, b} {
+		msg, err := r.store.Load(ctx, "shared")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("from b"), msg.Payload)
+		_, err = r.store.Load(ctx, "cleared")
+		assert.ErrorIs(t, err, store.ErrNotFound)
+	}
+
+	n, err = a.SyncFrom(ctx, b)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestRetainedReplica_TombstoneTTL(t *testing.T) {
+	ctx := context.Background()
+	r := NewRetainedReplica(NewRetainedStore(store.NewMemoryStore[*RetainedMessage]()), nil, "node-a", time.Minute)
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	require.NoError(t, r.Save(ctx, &RetainedMessage{Topic: "t"}))
+	digest, err := r.Digest(ctx)
+	require.NoError(t, err)
+	assert.Contains(t, digest, "t")
+
+	now = now.Add(2 * time.Minute)
+	digest, err = r.Digest(ctx)
+	require.NoError(t, err)
+	assert.NotContains(t, digest, "t")
+}
+
+type failingPeer struct{}
+
+func (failingPeer) Digest(context.Context) (map[string]RetainedVersion, error) {
+	return nil, errors.New("unreachable")
+}
+
+func (failingPeer) Messages(context.Context, []string) ([]*RetainedMessage, error) {
+	return nil, errors.New("unreachable")
+}
+
+func TestRetainedReplica_RunAntiEntropy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	a := newTestReplica("node-a")
+	b := newTestReplica("node-b")
+	require.NoError(t, b.Save(ctx, &RetainedMessage{Topic: "t", Payload: []byte("1")}))
+
+	errs := make(chan error, 16)
+	a.OnSyncError = func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		a.RunAntiEntropy(ctx, 5*time.Millisecond, func() []RetainedPeer { return []RetainedPeer{failingPeer{}, b} })
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool {
+		_, err := a.store.Load(ctx, "t")
+		return err == nil
+	}, time.Second, 5*time.Millisecond)
+	assert.Error(t, <-errs)
+
+	cancel()
+	<-done
+}
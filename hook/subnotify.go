@@ -0,0 +1,265 @@
+package hook
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/axmq/ax/topic"
+)
+
+const (
+	// DefaultSubscriptionNotifyTopic is the topic subscription changes are announced on
+	DefaultSubscriptionNotifyTopic = "$SYS/subscriptions/{event}"
+
+	SubscriptionActive   = "active"   // the first subscriber of a filter appeared
+	SubscriptionInactive = "inactive" // the last subscriber of a filter left
+	SubscriptionAdded    = "subscribed"
+	SubscriptionRemoved  = "unsubscribed"
+)
+
+// SubscriptionNotifyConfig configures the subscription change notifications
+type SubscriptionNotifyConfig struct {
+	// Filters selects the subscriptions to track, a subscription is tracked when its filter read as a
+	// topic name matches one of them, e.g. "sensors/#" tracks "sensors/+/temp"
+	Filters []string
+	// Topic is the announcement topic pattern, it may contain an {event} placeholder
+	Topic string
+	// EveryChange also announces subscriptions and unsubscriptions that leave the filter with subscribers
+	EveryChange bool
+}
+
+// SubscriptionNotifyMessage is the payload announcing a subscription change
+type SubscriptionNotifyMessage struct {
+	Event       string `json:"event"`
+	TopicFilter string `json:"topic_filter"`
+	ClientID    string `json:"clientid"`
+	Subscribers int    `json:"subscribers"`
+	Timestamp   int64  `json:"timestamp"`
+}
+
+// SubscriptionNotifyHook announces on control topics when tracked filters gain their first subscriber and
+// lose their last one, so producers can generate data only while someone is listening. Shared subscriptions
+// count towards the filter they share, and a client subscribing twice to a filter counts once
+type SubscriptionNotifyHook struct {
+	*Base
+	config    SubscriptionNotifyConfig
+	publisher ControlPublisher
+
+	mu          sync.Mutex
+	subscribers map[string]map[string]struct{} // filter -> subscribed clients
+	clients     map[string]map[string]struct{} // client -> tracked filters
+}
+
+// NewSubscriptionNotifyHook creates a hook announcing changes of the configured filters through publisher
+func NewSubscriptionNotifyHook(publisher ControlPublisher, config SubscriptionNotifyConfig) (*SubscriptionNotifyHook, error) {
+	for _, filter := range config.Filters {
+		if err := topic.ValidateTopicFilter(filter); err != nil {
+			return nil, fmt.Errorf("%w: %q: %v", ErrInvalidNotifyFilter, filter, err)
+		}
+	}
+	if config.Topic == "" {
+		config.Topic = DefaultSubscriptionNotifyTopic
+	}
+	config.Filters = append([]string(nil), config.Filters...)
+
+	return &SubscriptionNotifyHook{
+		Base:        &Base{id: "subscription-notify"},
+		config:      config,
+		publisher:   publisher,
+		subscribers: make(map[string]map[string]struct{}),
+		clients:     make(map[string]map[string]struct{}),
+	}, nil
+}
+
+// ID returns the hook identifier
+func (h *SubscriptionNotifyHook) ID() string {
+	return h.id
+}
+
+// Provides indicates this hook provides subscription, session and disconnect handling
+func (h *SubscriptionNotifyHook) Provides(event Event) bool {
+	switch event {
+	case OnSubscribed, OnUnsubscribed, OnSessionEstablished, OnDisconnect, OnClientExpired:
+		return true
+	default:
+		return false
+	}
+}
+
+// Subscribers returns how many clients subscribe to a tracked filter
+func (h *SubscriptionNotifyHook) Subscribers(filter string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscribers[unsharedFilter(filter)])
+}
+
+// Active returns the tracked filters with at least one subscriber
+func (h *SubscriptionNotifyHook) Active() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	filters := make([]string, 0, len(h.subscribers))
+	for filter := range h.subscribers {
+		filters = append(filters, filter)
+	}
+	return filters
+}
+
+// OnSubscribed counts the subscriber of a tracked filter
+func (h *SubscriptionNotifyHook) OnSubscribed(client *Client, sub *Subscription) error {
+	if client == nil || sub == nil {
+		return nil
+	}
+	filter := unsharedFilter(sub.TopicFilter)
+	if !h.tracks(filter) {
+		return nil
+	}
+
+	h.mu.Lock()
+	clients, ok := h.subscribers[filter]
+	if !ok {
+		clients = make(map[string]struct{})
+		h.subscribers[filter] = clients
+	}
+	if _, ok := clients[client.ID]; ok {
+		h.mu.Unlock()
+		return nil
+	}
+	clients[client.ID] = struct{}{}
+	if h.clients[client.ID] == nil {
+		h.clients[client.ID] = make(map[string]struct{})
+	}
+	h.clients[client.ID][filter] = struct{}{}
+	count := len(clients)
+	h.mu.Unlock()
+
+	if count == 1 {
+		return h.notify(SubscriptionActive, filter, client.ID, count)
+	}
+	if h.config.EveryChange {
+		return h.notify(SubscriptionAdded, filter, client.ID, count)
+	}
+	return nil
+}
+
+// OnUnsubscribed stops counting the subscriber of a tracked filter
+func (h *SubscriptionNotifyHook) OnUnsubscribed(client *Client, topicFilter string) error {
+	if client == nil {
+		return nil
+	}
+	h.mu.Lock()
+	count, removed := h.removeLocked(client.ID, unsharedFilter(topicFilter))
+	h.mu.Unlock()
+	if !removed {
+		return nil
+	}
+	return h.announceRemoval(unsharedFilter(topicFilter), client.ID, count)
+}
+
+// OnSessionEstablished forgets the subscriptions of a previous session discarded by a clean start
+func (h *SubscriptionNotifyHook) OnSessionEstablished(client *Client, _ *ConnectPacket) error {
+	if client == nil || !client.CleanStart {
+		return nil
+	}
+	return h.removeClient(client.ID)
+}
+
+// OnDisconnect forgets the subscriptions of a client whose session ends with the connection
+func (h *SubscriptionNotifyHook) OnDisconnect(client *Client, info *DisconnectInfo) error {
+	if client == nil || info == nil || !info.Expire {
+		return nil
+	}
+	return h.removeClient(client.ID)
+}
+
+// OnClientExpired forgets the subscriptions of an expired session
+func (h *SubscriptionNotifyHook) OnClientExpired(clientID string) error {
+	return h.removeClient(clientID)
+}
+
+func (h *SubscriptionNotifyHook) removeClient(clientID string) error {
+	h.mu.Lock()
+	filters := h.clients[clientID]
+	counts := make(map[string]int, len(filters))
+	for filter := range filters {
+		counts[filter], _ = h.removeLocked(clientID, filter)
+	}
+	h.mu.Unlock()
+
+	var firstErr error
+	for filter, count := range counts {
+		if err := h.announceRemoval(filter, clientID, count); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// removeLocked removes a subscriber and returns how many remain, removed is false for an untracked subscriber
+func (h *SubscriptionNotifyHook) removeLocked(clientID, filter string) (remaining int, removed bool) {
+	clients, ok := h.subscribers[filter]
+	if !ok {
+		return 0, false
+	}
+	if _, ok := clients[clientID]; !ok {
+		return len(clients), false
+	}
+
+	delete(clients, clientID)
+	if len(clients) == 0 {
+		delete(h.subscribers, filter)
+	}
+	delete(h.clients[clientID], filter)
+	if len(h.clients[clientID]) == 0 {
+		delete(h.clients, clientID)
+	}
+	return len(clients), true
+}
+
+func (h *SubscriptionNotifyHook) announceRemoval(filter, clientID string, remaining int) error {
+	if remaining == 0 {
+		return h.notify(SubscriptionInactive, filter, clientID, 0)
+	}
+	if h.config.EveryChange {
+		return h.notify(SubscriptionRemoved, filter, clientID, remaining)
+	}
+	return nil
+}
+
+func (h *SubscriptionNotifyHook) tracks(filter string) bool {
+	for _, watched := range h.config.Filters {
+		if topic.MatchFilter(watched, filter) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *SubscriptionNotifyHook) notify(event, filter, clientID string, subscribers int) error {
+	if h.publisher == nil {
+		return nil
+	}
+
+	payload, err := json.Marshal(SubscriptionNotifyMessage{
+		Event:       event,
+		TopicFilter: filter,
+		ClientID:    clientID,
+		Subscribers: subscribers,
+		Timestamp:   time.Now().Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	return h.publisher.PublishControl(strings.ReplaceAll(h.config.Topic, "{event}", event), payload, false)
+}
+
+// unsharedFilter returns the filter a shared subscription shares, other filters are returned as they are
+func unsharedFilter(filter string) string {
+	if _, shared, err := topic.ValidateSharedSubscription(filter); err == nil {
+		return shared
+	}
+	return filter
+}
@@ -0,0 +1,110 @@
+package hook
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func notifyEvents(t *testing.T, recorder *controlRecorder) []SubscriptionNotifyMessage {
+	t.Helper()
+	msgs := make([]SubscriptionNotifyMessage, 0, len(recorder.payloads))
+	for _, payload := range recorder.payloads {
+		var msg SubscriptionNotifyMessage
+		require.NoError(t, json.Unmarshal(payload, &msg))
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func TestSubscriptionNotifyHook_FirstAndLast(t *testing.T) {
+	recorder := &controlRecorder{}
+	h, err := NewSubscriptionNotifyHook(recorder, SubscriptionNotifyConfig{Filters: []string{"sensors/#"}})
+	require.NoError(t, err)
+	assert.True(t, h.Provides(OnSubscribed))
+	assert.True(t, h.Provides(OnClientExpired))
+	assert.False(t, h.Provides(OnPublish))
+
+	a, b := &Client{ID: "a"}, &Client{ID: "b"}
+	require.NoError(t, h.OnSubscribed(a, &Subscription{TopicFilter: "sensors/+/temp"}))
+	require.NoError(t, h.OnSubscribed(a, &Subscription{TopicFilter: "sensors/+/temp"}))
+	require.NoError(t, h.OnSubscribed(b, &Subscription{TopicFilter: "$share/g/sensors/+/temp"}))
+	require.NoError(t, h.OnSubscribed(a, &Subscription{TopicFilter: "other/topic"}))
+	assert.Equal(t, 2, h.Subscribers("sensors/+/temp"))
+	assert.Equal(t, []string{"sensors/+/temp"}, h.Active())
+
+	require.NoError(t, h.OnUnsubscribed(a, "sensors/+/temp"))
+	require.NoError(t, h.OnUnsubscribed(a, "sensors/+/temp"))
+	require.NoError(t, h.OnUnsubscribed(b, "$share/g/sensors/+/temp"))
+	assert.Zero(t, h.Subscribers("sensors/+/temp"))
+	assert.Empty(t, h.Active())
+
+	assert.Equal(t, []string{"$SYS/subscriptions/active", "$SYS/subscriptions/inactive"}, recorder.topics)
+	msgs := notifyEvents(t, recorder)
+	assert.Equal(t, SubscriptionActive, msgs[0].Event)
+	assert.Equal(t, "sensors/+/temp", msgs[0].TopicFilter)
+	assert.Equal(t, "a", msgs[0].ClientID)
+	assert.Equal(t, 1, msgs[0].Subscribers)
+	assert.Equal(t, SubscriptionInactive, msgs[1].Event)
+	assert.Equal(t, "b", msgs[1].ClientID)
+	assert.Zero(t, msgs[1].Subscribers)
+}
+
+func TestSubscriptionNotifyHook_EveryChange(t *testing.T) {
+	recorder := &controlRecorder{}
+	h, err := NewSubscriptionNotifyHook(recorder, SubscriptionNotifyConfig{
+		Filters:     []string{"video/+"},
+		Topic:       "ops/demand/{event}",
+		EveryChange: true,
+	})
+	require.NoError(t, err)
+
+	a, b := &Client{ID: "a"}, &Client{ID: "b"}
+	require.NoError(t, h.OnSubscribed(a, &Subscription{TopicFilter: "video/cam1"}))
+	require.NoError(t, h.OnSubscribed(b, &Subscription{TopicFilter: "video/cam1"}))
+	require.NoError(t, h.OnUnsubscribed(a, "video/cam1"))
+	require.NoError(t, h.OnUnsubscribed(b, "video/cam1"))
+
+	assert.Equal(t, []string{
+		"ops/demand/active",
+		"ops/demand/subscribed",
+		"ops/demand/unsubscribed",
+		"ops/demand/inactive",
+	}, recorder.topics)
+}
+
+func TestSubscriptionNotifyHook_SessionEnd(t *testing.T) {
+	recorder := &controlRecorder{}
+	h, err := NewSubscriptionNotifyHook(recorder, SubscriptionNotifyConfig{Filters: []string{"#"}})
+	require.NoError(t, err)
+
+	client := &Client{ID: "a"}
+	require.NoError(t, h.OnSubscribed(client, &Subscription{TopicFilter: "x"}))
+	require.NoError(t, h.OnSubscribed(client, &Subscription{TopicFilter: "y"}))
+
+	// A persistent session keeps its subscriptions while disconnected
+	require.NoError(t, h.OnDisconnect(client, &DisconnectInfo{}))
+	assert.Len(t, h.Active(), 2)
+
+	require.NoError(t, h.OnDisconnect(client, &DisconnectInfo{Expire: true}))
+	assert.Empty(t, h.Active())
+	assert.Len(t, recorder.topics, 4)
+
+	require.NoError(t, h.OnSubscribed(client, &Subscription{TopicFilter: "x"}))
+	require.NoError(t, h.OnSessionEstablished(&Client{ID: "a"}, nil))
+	assert.Equal(t, 1, h.Subscribers("x"))
+	require.NoError(t, h.OnSessionEstablished(&Client{ID: "a", CleanStart: true}, nil))
+	assert.Zero(t, h.Subscribers("x"))
+
+	require.NoError(t, h.OnSubscribed(client, &Subscription{TopicFilter: "x"}))
+	require.NoError(t, h.OnClientExpired("a"))
+	assert.Zero(t, h.Subscribers("x"))
+	assert.Len(t, recorder.topics, 8)
+}
+
+func TestNewSubscriptionNotifyHook_InvalidFilter(t *testing.T) {
+	_, err := NewSubscriptionNotifyHook(nil, SubscriptionNotifyConfig{Filters: []string{"a/#/b"}})
+	assert.ErrorIs(t, err, ErrInvalidNotifyFilter)
+}
@@ -0,0 +1,77 @@
+package hook
+
+import (
+	"sync"
+)
+
+// TenantMetadataKey is the client metadata key holding the tenant a connection was routed to, e.g. by TLS SNI
+const TenantMetadataKey = "tenant"
+
+// TenantManagers holds a separate hook pipeline per tenant so that each endpoint can use its own auth backends
+type TenantManagers struct {
+	mu       sync.RWMutex
+	fallback *Manager
+	managers map[string]*Manager
+}
+
+// NewTenantManagers creates a tenant pipeline set using fallback for unknown tenants
+func NewTenantManagers(fallback *Manager) *TenantManagers {
+	if fallback == nil {
+		fallback = NewManager()
+	}
+	return &TenantManagers{
+		fallback: fallback,
+		managers: make(map[string]*Manager),
+	}
+}
+
+// Set registers the pipeline for a tenant
+func (t *TenantManagers) Set(tenant string, m *Manager) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.managers[tenant] = m
+}
+
+// Remove removes the pipeline of a tenant and returns it
+func (t *TenantManagers) Remove(tenant string) (*Manager, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m, ok := t.managers[tenant]
+	delete(t.managers, tenant)
+	return m, ok
+}
+
+// Get returns the pipeline for a tenant, or the fallback pipeline
+func (t *TenantManagers) Get(tenant string) *Manager {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if m, ok := t.managers[tenant]; ok {
+		return m
+	}
+	return t.fallback
+}
+
+// ForClient returns the pipeline for the tenant stored in the client metadata
+func (t *TenantManagers) ForClient(client *Client) *Manager {
+	if client == nil || client.Metadata == nil {
+		return t.fallback
+	}
+	return t.Get(client.Metadata[TenantMetadataKey])
+}
+
+// Tenants returns the number of tenants with their own pipeline
+func (t *TenantManagers) Tenants() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.managers)
+}
+
+// Assemble builds a new pipeline for a tenant from the registry and registers it
+func (t *TenantManagers) Assemble(r *Registry, tenant string, config *PipelineConfig) (*Manager, error) {
+	m := NewManager()
+	if err := r.Assemble(m, config); err != nil {
+		return nil, err
+	}
+	t.Set(tenant, m)
+	return m, nil
+}
@@ -0,0 +1,68 @@
+package hook
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenantManagersGet(t *testing.T) {
+	fallback := NewManager()
+	tenants := NewTenantManagers(fallback)
+	customerA := NewManager()
+	tenants.Set("customer-a", customerA)
+
+	assert.Same(t, customerA, tenants.Get("customer-a"))
+	assert.Same(t, fallback, tenants.Get("customer-b"))
+	assert.Same(t, fallback, tenants.Get(""))
+	assert.Equal(t, 1, tenants.Tenants())
+
+	m, ok := tenants.Remove("customer-a")
+	assert.True(t, ok)
+	assert.Same(t, customerA, m)
+	assert.Same(t, fallback, tenants.Get("customer-a"))
+
+	_, ok = tenants.Remove("customer-a")
+	assert.False(t, ok)
+}
+
+func TestTenantManagersNilFallback(t *testing.T) {
+	tenants := NewTenantManagers(nil)
+	assert.NotNil(t, tenants.Get("any"))
+}
+
+func TestTenantManagersForClient(t *testing.T) {
+	fallback := NewManager()
+	tenants := NewTenantManagers(fallback)
+	customerA := NewManager()
+	tenants.Set("customer-a", customerA)
+
+	assert.Same(t, customerA, tenants.ForClient(&Client{Metadata: map[string]string{TenantMetadataKey: "customer-a"}}))
+	assert.Same(t, fallback, tenants.ForClient(&Client{Metadata: map[string]string{TenantMetadataKey: "other"}}))
+	assert.Same(t, fallback, tenants.ForClient(&Client{}))
+	assert.Same(t, fallback, tenants.ForClient(nil))
+}
+
+func TestTenantManagersAssemble(t *testing.T) {
+	r := newTestRegistry(t)
+	tenants := NewTenantManagers(nil)
+
+	configA, err := ParsePipelineConfig([]byte(`{"hooks": [{"name": "basic-auth", "options": {"users": {"alice": "a"}}}]}`))
+	require.NoError(t, err)
+	configB, err := ParsePipelineConfig([]byte(`{"hooks": [{"name": "basic-auth", "options": {"users": {"bob": "b"}}}]}`))
+	require.NoError(t, err)
+
+	_, err = tenants.Assemble(r, "customer-a", configA)
+	require.NoError(t, err)
+	_, err = tenants.Assemble(r, "customer-b", configB)
+	require.NoError(t, err)
+
+	alice := &ConnectPacket{Username: "alice", Password: []byte("a")}
+	assert.True(t, tenants.Get("customer-a").OnConnectAuthenticate(&Client{}, alice))
+	assert.False(t, tenants.Get("customer-b").OnConnectAuthenticate(&Client{}, alice))
+
+	_, err = tenants.Assemble(r, "customer-c", &PipelineConfig{Hooks: []HookConfig{{Name: "missing"}}})
+	assert.ErrorIs(t, err, ErrFactoryNotFound)
+	assert.Equal(t, 2, tenants.Tenants())
+}
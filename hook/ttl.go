@@ -0,0 +1,137 @@
+package hook
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/topic"
+)
+
+// TTLNever disables expiry for topics matching a policy, so broader policies after it do not apply
+const TTLNever = "never"
+
+// messageExpiryProperty is the hook property key of the MQTT 5.0 Message Expiry Interval
+var messageExpiryProperty = encoding.PropMessageExpiryInterval.String()
+
+// TTLPolicy sets the default message expiry for publishes to topics matching Filter
+// A zero TTL means messages on those topics never expire
+type TTLPolicy struct {
+	Filter string
+	TTL    time.Duration
+}
+
+// UnmarshalJSON reads a policy of the form {"filter":"telemetry/#","ttl":"1h"}, "never" is a zero TTL
+func (p *TTLPolicy) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Filter string `json:"filter"`
+		TTL    string `json:"ttl"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	p.Filter = raw.Filter
+	p.TTL = 0
+	if raw.TTL == "" || raw.TTL == TTLNever {
+		return nil
+	}
+	ttl, err := time.ParseDuration(raw.TTL)
+	if err != nil {
+		return err
+	}
+	p.TTL = ttl
+	return nil
+}
+
+// MessageTTLHook applies per-topic default message expiry to publishes that do not set one,
+// keeping retention policy in broker configuration instead of device firmware
+// Policies are checked in order and the first matching filter wins
+type MessageTTLHook struct {
+	*Base
+	mu       sync.RWMutex
+	policies []TTLPolicy
+}
+
+// NewMessageTTLHook creates a TTL hook, every policy filter must be a valid topic filter
+func NewMessageTTLHook(policies ...TTLPolicy) (*MessageTTLHook, error) {
+	h := &MessageTTLHook{Base: &Base{id: "message-ttl"}}
+	if err := h.SetPolicies(policies); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// ID returns the hook identifier
+func (h *MessageTTLHook) ID() string {
+	return h.id
+}
+
+// Provides indicates this hook provides publish handling
+func (h *MessageTTLHook) Provides(event Event) bool {
+	return event == OnPublish
+}
+
+// SetPolicies replaces the policies
+func (h *MessageTTLHook) SetPolicies(policies []TTLPolicy) error {
+	for _, p := range policies {
+		if err := topic.ValidateTopicFilter(p.Filter); err != nil {
+			return fmt.Errorf("invalid ttl policy filter %q: %w", p.Filter, err)
+		}
+		if p.TTL < 0 {
+			return fmt.Errorf("invalid ttl policy for %q: negative ttl", p.Filter)
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.policies = append([]TTLPolicy(nil), policies...)
+	return nil
+}
+
+// TTL returns the default expiry for topicName, ok is false when no policy matches
+func (h *MessageTTLHook) TTL(topicName string) (ttl time.Duration, ok bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, p := range h.policies {
+		if topic.MatchFilter(p.Filter, topicName) {
+			return p.TTL, true
+		}
+	}
+	return 0, false
+}
+
+// OnPublish sets the Message Expiry Interval of publishes that arrive without one,
+// the queue and retain stores then expire the message like any other
+func (h *MessageTTLHook) OnPublish(_ *Client, packet *PublishPacket) error {
+	if packet == nil {
+		return nil
+	}
+	if _, set := packet.Properties[messageExpiryProperty]; set {
+		return nil
+	}
+
+	ttl, ok := h.TTL(packet.Topic)
+	if !ok || ttl == 0 {
+		return nil
+	}
+
+	if packet.Properties == nil {
+		packet.Properties = make(Properties)
+	}
+	packet.Properties[messageExpiryProperty] = expirySeconds(ttl)
+	return nil
+}
+
+// expirySeconds converts ttl to whole seconds, rounding up so short TTLs do not become zero
+func expirySeconds(ttl time.Duration) uint32 {
+	seconds := (ttl + time.Second - 1) / time.Second
+	if seconds > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(seconds)
+}
@@ -0,0 +1,60 @@
+package hook
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageTTLHook_OnPublish(t *testing.T) {
+	h, err := NewMessageTTLHook(
+		TTLPolicy{Filter: "alarms/#"},
+		TTLPolicy{Filter: "telemetry/fast/+", TTL: 1500 * time.Millisecond},
+		TTLPolicy{Filter: "telemetry/#", TTL: time.Hour},
+	)
+	require.NoError(t, err)
+	assert.True(t, h.Provides(OnPublish))
+	assert.False(t, h.Provides(OnRetainMessage))
+
+	tests := []struct {
+		name       string
+		topic      string
+		properties Properties
+		want       any
+	}{
+		{name: "default applied", topic: "telemetry/room/1", want: uint32(3600)},
+		{name: "first match wins", topic: "telemetry/fast/1", want: uint32(2)},
+		{name: "never expires", topic: "alarms/fire", want: nil},
+		{name: "no policy", topic: "other/topic", want: nil},
+		{name: "publisher expiry kept", topic: "telemetry/room/1", properties: Properties{"MessageExpiryInterval": uint32(10)}, want: uint32(10)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packet := &PublishPacket{Topic: tt.topic, Properties: tt.properties}
+			require.NoError(t, h.OnPublish(nil, packet))
+			assert.Equal(t, tt.want, packet.Properties["MessageExpiryInterval"])
+		})
+	}
+
+	assert.NoError(t, h.OnPublish(nil, nil))
+}
+
+func TestMessageTTLHook_InvalidPolicy(t *testing.T) {
+	_, err := NewMessageTTLHook(TTLPolicy{Filter: "a/#/b", TTL: time.Hour})
+	assert.Error(t, err)
+
+	_, err = NewMessageTTLHook(TTLPolicy{Filter: "a/#", TTL: -time.Second})
+	assert.Error(t, err)
+}
+
+func TestTTLPolicy_UnmarshalJSON(t *testing.T) {
+	var policies []TTLPolicy
+	require.NoError(t, json.Unmarshal([]byte(`[{"filter":"telemetry/#","ttl":"1h"},{"filter":"alarms/#","ttl":"never"}]`), &policies))
+	assert.Equal(t, []TTLPolicy{{Filter: "telemetry/#", TTL: time.Hour}, {Filter: "alarms/#"}}, policies)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"filter":"a","ttl":"soon"}`), &TTLPolicy{}))
+}
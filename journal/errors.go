@@ -0,0 +1,11 @@
+package journal
+
+import "errors"
+
+var (
+	ErrJournalClosed     = errors.New("journal is closed")
+	ErrInvalidHeader     = errors.New("invalid journal header")
+	ErrCorruptRecord     = errors.New("corrupt journal record")
+	ErrUnknownRecordType = errors.New("unknown journal record type")
+	ErrFieldTooLarge     = errors.New("journal record field too large")
+)
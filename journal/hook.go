@@ -0,0 +1,82 @@
+package journal
+
+import (
+	"github.com/axmq/ax/hook"
+)
+
+// Hook records accepted publishes and session events into a journal
+type Hook struct {
+	*hook.Base
+	journal *Journal
+}
+
+// NewHook creates a hook that appends broker events to the journal
+func NewHook(journal *Journal) *Hook {
+	return &Hook{
+		Base:    hook.NewHookBase("journal"),
+		journal: journal,
+	}
+}
+
+// Provides indicates which events are journaled
+func (h *Hook) Provides(event hook.Event) bool {
+	switch event {
+	case hook.OnPublished, hook.OnSessionEstablished, hook.OnDisconnect, hook.OnSubscribed, hook.OnUnsubscribed:
+		return true
+	default:
+		return false
+	}
+}
+
+// OnPublished journals an accepted publish
+func (h *Hook) OnPublished(client *hook.Client, packet *hook.PublishPacket) error {
+	if packet == nil {
+		return nil
+	}
+	_, err := h.journal.Append(&Record{
+		Type:     RecordPublish,
+		ClientID: client.GetID(),
+		Topic:    packet.Topic,
+		Payload:  packet.Payload,
+		QoS:      packet.QoS,
+		Retain:   packet.Retain,
+	})
+	return err
+}
+
+// OnSessionEstablished journals a client connect
+func (h *Hook) OnSessionEstablished(client *hook.Client, _ *hook.ConnectPacket) error {
+	_, err := h.journal.Append(&Record{Type: RecordConnect, ClientID: client.GetID()})
+	return err
+}
+
+// OnDisconnect journals a client disconnect
+func (h *Hook) OnDisconnect(client *hook.Client, _ *hook.DisconnectInfo) error {
+	_, err := h.journal.Append(&Record{Type: RecordDisconnect, ClientID: client.GetID()})
+	return err
+}
+
+// OnSubscribed journals a completed subscription
+func (h *Hook) OnSubscribed(client *hook.Client, sub *hook.Subscription) error {
+	if sub == nil {
+		return nil
+	}
+	_, err := h.journal.Append(&Record{
+		Type:     RecordSubscribe,
+		ClientID: client.GetID(),
+		Topic:    sub.TopicFilter,
+		QoS:      sub.QoS,
+	})
+	return err
+}
+
+// OnUnsubscribed journals a completed unsubscription
+func (h *Hook) OnUnsubscribed(client *hook.Client, topicFilter string) error {
+	_, err := h.journal.Append(&Record{Type: RecordUnsubscribe, ClientID: client.GetID(), Topic: topicFilter})
+	return err
+}
+
+// Stop flushes and closes the journal
+func (h *Hook) Stop() error {
+	return h.journal.Close()
+}
@@ -0,0 +1,45 @@
+package journal
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/axmq/ax/hook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHook(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.journal")
+	h := NewHook(openTestJournal(t, path))
+
+	assert.Equal(t, "journal", h.ID())
+	assert.True(t, h.Provides(hook.OnPublished))
+	assert.True(t, h.Provides(hook.OnSessionEstablished))
+	assert.False(t, h.Provides(hook.OnPublish))
+
+	m := hook.NewManager()
+	require.NoError(t, m.Add(h))
+
+	client := &hook.Client{ID: "c1"}
+	m.OnSessionEstablished(client, &hook.ConnectPacket{})
+	m.OnSubscribed(client, &hook.Subscription{TopicFilter: "a/#", QoS: 1})
+	m.OnPublished(client, &hook.PublishPacket{Topic: "a/b", Payload: []byte("x"), QoS: 1, Retain: true})
+	m.OnUnsubscribed(client, "a/#")
+	m.OnDisconnect(client, &hook.DisconnectInfo{})
+	require.NoError(t, h.Stop())
+
+	got := readAll(t, path)
+	require.Len(t, got, 5)
+
+	types := make([]RecordType, 0, len(got))
+	for _, rec := range got {
+		assert.Equal(t, "c1", rec.ClientID)
+		types = append(types, rec.Type)
+	}
+	assert.Equal(t, []RecordType{RecordConnect, RecordSubscribe, RecordPublish, RecordUnsubscribe, RecordDisconnect}, types)
+	assert.Equal(t, "a/b", got[2].Topic)
+	assert.Equal(t, []byte("x"), got[2].Payload)
+	assert.True(t, got[2].Retain)
+	assert.Equal(t, "a/#", got[1].Topic)
+}
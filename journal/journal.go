@@ -0,0 +1,283 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Config configures a file-backed journal
+type Config struct {
+	Path string
+	// SyncOnWrite flushes and fsyncs after every record instead of relying on Flush
+	SyncOnWrite bool
+}
+
+// Journal is an append-only log of broker events with monotonically increasing sequence numbers
+type Journal struct {
+	mu     sync.Mutex
+	file   *os.File
+	w      *bufio.Writer
+	buf    []byte
+	seq    uint64
+	sync   bool
+	closed bool
+}
+
+// Open opens or creates the journal at config.Path
+// An existing journal is scanned to continue its sequence, and a torn trailing record left by a crash is truncated
+func Open(config Config) (*Journal, error) {
+	file, err := os.OpenFile(config.Path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+
+	j := &Journal{
+		file: file,
+		sync: config.SyncOnWrite,
+	}
+
+	if err := j.recover(); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	j.w = bufio.NewWriter(file)
+	return j, nil
+}
+
+// recover positions the journal at the end of its last valid record
+func (j *Journal) recover() error {
+	info, err := j.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat journal: %w", err)
+	}
+
+	if info.Size() == 0 {
+		if _, err := j.file.Write([]byte(magic)); err != nil {
+			return fmt.Errorf("failed to write journal header: %w", err)
+		}
+		return nil
+	}
+
+	r := NewReader(j.file)
+	for {
+		_, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, ErrCorruptRecord) {
+			if err := j.file.Truncate(r.Offset()); err != nil {
+				return fmt.Errorf("failed to truncate journal: %w", err)
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	// Records of unknown types written by a newer version still hold their sequence numbers
+	j.seq = r.Seq()
+
+	if _, err := j.file.Seek(r.Offset(), io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek journal: %w", err)
+	}
+	return nil
+}
+
+// Append assigns the next sequence number to the record and writes it
+// A zero Time is set to the current time
+func (j *Journal) Append(rec *Record) (uint64, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.closed {
+		return 0, ErrJournalClosed
+	}
+
+	if rec.Time.IsZero() {
+		rec.Time = time.Now()
+	}
+	rec.Seq = j.seq + 1
+
+	var err error
+	j.buf, err = appendRecord(j.buf[:0], rec)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := j.w.Write(j.buf); err != nil {
+		return 0, fmt.Errorf("failed to write journal record: %w", err)
+	}
+	j.seq = rec.Seq
+
+	if j.sync {
+		if err := j.flushLocked(); err != nil {
+			return 0, err
+		}
+	}
+	return rec.Seq, nil
+}
+
+// LastSeq returns the sequence number of the last appended record
+func (j *Journal) LastSeq() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.seq
+}
+
+// Flush writes buffered records to disk
+func (j *Journal) Flush() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.closed {
+		return ErrJournalClosed
+	}
+	return j.flushLocked()
+}
+
+func (j *Journal) flushLocked() error {
+	if err := j.w.Flush(); err != nil {
+		return fmt.Errorf("failed to flush journal: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync journal: %w", err)
+	}
+	return nil
+}
+
+// Close flushes and closes the journal
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.closed {
+		return nil
+	}
+	j.closed = true
+
+	flushErr := j.flushLocked()
+	if err := j.file.Close(); err != nil {
+		return err
+	}
+	return flushErr
+}
+
+// Reader reads records sequentially from a journal stream
+// Records of unknown types, e.g. written by a newer version, are skipped
+type Reader struct {
+	r       *bufio.Reader
+	size    int64
+	offset  int64
+	seq     uint64
+	skipped int
+	header  bool
+	frame   [frameHeaderSize]byte
+	body    []byte
+}
+
+// NewReader creates a reader for a journal stream starting at its header
+// Record sizes are checked against the length of files and in-memory readers before the record is read
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r), size: streamSize(r)}
+}
+
+// streamSize returns the number of bytes left in r, or -1 when it cannot be told
+func streamSize(r io.Reader) int64 {
+	switch s := r.(type) {
+	case interface{ Len() int }:
+		return int64(s.Len())
+	case *os.File:
+		info, err := s.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return -1
+		}
+		pos, err := s.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		return info.Size() - pos
+	}
+	return -1
+}
+
+// Next returns the next record, io.EOF at the end of the journal or ErrCorruptRecord for a torn or damaged record
+func (r *Reader) Next() (*Record, error) {
+	if !r.header {
+		var hdr [len(magic)]byte
+		if _, err := io.ReadFull(r.r, hdr[:]); err != nil || string(hdr[:]) != magic {
+			return nil, ErrInvalidHeader
+		}
+		r.header = true
+		r.offset = int64(len(magic))
+	}
+
+	for {
+		if _, err := io.ReadFull(r.r, r.frame[:]); err != nil {
+			if err == io.EOF {
+				return nil, io.EOF
+			}
+			return nil, ErrCorruptRecord
+		}
+
+		size := binary.BigEndian.Uint32(r.frame[0:4])
+		sum := binary.BigEndian.Uint32(r.frame[4:8])
+		if size < fixedBodySize || size > maxRecordSize {
+			return nil, ErrCorruptRecord
+		}
+		// A torn record must not allocate the size its damaged frame claims
+		if r.size >= 0 && r.offset+frameHeaderSize+int64(size) > r.size {
+			return nil, ErrCorruptRecord
+		}
+
+		if cap(r.body) < int(size) {
+			r.body = make([]byte, size)
+		}
+		body := r.body[:size]
+		if _, err := io.ReadFull(r.r, body); err != nil {
+			return nil, ErrCorruptRecord
+		}
+		if crc32.ChecksumIEEE(body) != sum {
+			return nil, ErrCorruptRecord
+		}
+
+		rec, err := decodeBody(body)
+		if errors.Is(err, ErrUnknownRecordType) {
+			r.offset += frameHeaderSize + int64(size)
+			r.seq = binary.BigEndian.Uint64(body[0:8])
+			r.skipped++
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if rec.Payload != nil {
+			rec.Payload = append([]byte(nil), rec.Payload...)
+		}
+
+		r.offset += frameHeaderSize + int64(size)
+		r.seq = rec.Seq
+		return rec, nil
+	}
+}
+
+// Offset returns the number of bytes consumed by the header and all records read so far
+func (r *Reader) Offset() int64 {
+	return r.offset
+}
+
+// Seq returns the sequence number of the last record read, including skipped records
+func (r *Reader) Seq() uint64 {
+	return r.seq
+}
+
+// Skipped returns the number of records of unknown types skipped so far
+func (r *Reader) Skipped() int {
+	return r.skipped
+}
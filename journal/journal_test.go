@@ -0,0 +1,208 @@
+package journal
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestJournal(t *testing.T, path string) *Journal {
+	j, err := Open(Config{Path: path})
+	require.NoError(t, err)
+	return j
+}
+
+func readAll(t *testing.T, path string) []*Record {
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	r := NewReader(file)
+	records := make([]*Record, 0)
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return records
+		}
+		require.NoError(t, err)
+		records = append(records, rec)
+	}
+}
+
+func TestRecordTypeString(t *testing.T) {
+	assert.Equal(t, "publish", RecordPublish.String())
+	assert.Equal(t, "connect", RecordConnect.String())
+	assert.Equal(t, "disconnect", RecordDisconnect.String())
+	assert.Equal(t, "subscribe", RecordSubscribe.String())
+	assert.Equal(t, "unsubscribe", RecordUnsubscribe.String())
+	assert.Equal(t, "unknown", RecordType(0).String())
+}
+
+func TestJournalAppendAndRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.journal")
+	j := openTestJournal(t, path)
+
+	now := time.Unix(1700000000, 42)
+	records := []*Record{
+		{Type: RecordConnect, ClientID: "c1", Time: now},
+		{Type: RecordSubscribe, ClientID: "c1", Topic: "a/#", QoS: 1},
+		{Type: RecordPublish, ClientID: "c1", Topic: "a/b", Payload: []byte("hello"), QoS: 2, Retain: true},
+		{Type: RecordUnsubscribe, ClientID: "c1", Topic: "a/#"},
+		{Type: RecordDisconnect, ClientID: "c1"},
+	}
+	for i, rec := range records {
+		seq, err := j.Append(rec)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(i+1), seq)
+	}
+	assert.Equal(t, uint64(5), j.LastSeq())
+	require.NoError(t, j.Close())
+
+	got := readAll(t, path)
+	require.Len(t, got, len(records))
+	for i, rec := range got {
+		assert.Equal(t, records[i].Seq, rec.Seq)
+		assert.Equal(t, records[i].Type, rec.Type)
+		assert.Equal(t, records[i].ClientID, rec.ClientID)
+		assert.Equal(t, records[i].Topic, rec.Topic)
+		assert.Equal(t, records[i].Payload, rec.Payload)
+		assert.Equal(t, records[i].QoS, rec.QoS)
+		assert.Equal(t, records[i].Retain, rec.Retain)
+		assert.True(t, records[i].Time.Equal(rec.Time))
+	}
+	assert.True(t, got[0].Time.Equal(now))
+
+	_, err := j.Append(&Record{Type: RecordConnect})
+	assert.ErrorIs(t, err, ErrJournalClosed)
+	assert.ErrorIs(t, j.Flush(), ErrJournalClosed)
+	assert.NoError(t, j.Close())
+}
+
+func TestJournalReopenContinuesSequence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.journal")
+	j := openTestJournal(t, path)
+	_, err := j.Append(&Record{Type: RecordConnect, ClientID: "c1"})
+	require.NoError(t, err)
+	_, err = j.Append(&Record{Type: RecordDisconnect, ClientID: "c1"})
+	require.NoError(t, err)
+	require.NoError(t, j.Close())
+
+	j = openTestJournal(t, path)
+	assert.Equal(t, uint64(2), j.LastSeq())
+	seq, err := j.Append(&Record{Type: RecordConnect, ClientID: "c2"})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), seq)
+	require.NoError(t, j.Close())
+
+	assert.Len(t, readAll(t, path), 3)
+}
+
+func TestJournalTruncatesTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.journal")
+	j, err := Open(Config{Path: path, SyncOnWrite: true})
+	require.NoError(t, err)
+	_, err = j.Append(&Record{Type: RecordPublish, ClientID: "c1", Topic: "a", Payload: []byte("one")})
+	require.NoError(t, err)
+	_, err = j.Append(&Record{Type: RecordPublish, ClientID: "c1", Topic: "a", Payload: []byte("two")})
+	require.NoError(t, err)
+	require.NoError(t, j.Close())
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path, info.Size()-3))
+
+	j = openTestJournal(t, path)
+	assert.Equal(t, uint64(1), j.LastSeq())
+	seq, err := j.Append(&Record{Type: RecordPublish, ClientID: "c1", Topic: "a", Payload: []byte("three")})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), seq)
+	require.NoError(t, j.Close())
+
+	got := readAll(t, path)
+	require.Len(t, got, 2)
+	assert.Equal(t, []byte("one"), got[0].Payload)
+	assert.Equal(t, []byte("three"), got[1].Payload)
+}
+
+func TestReaderErrors(t *testing.T) {
+	var buf []byte
+	buf = append(buf, magic...)
+	buf, err := appendRecord(buf, &Record{Seq: 1, Type: RecordConnect, ClientID: "c1", Time: time.Now()})
+	require.NoError(t, err)
+
+	corrupted := bytes.Clone(buf)
+	corrupted[len(corrupted)-1] ^= 0xFF
+
+	oversized := append([]byte(magic), 0x0F, 0xFF, 0xFF, 0xFF, 0, 0, 0, 0)
+
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{name: "missing header", data: []byte("nope"), err: ErrInvalidHeader},
+		{name: "empty", data: nil, err: ErrInvalidHeader},
+		{name: "checksum mismatch", data: corrupted, err: ErrCorruptRecord},
+		{name: "torn frame", data: buf[:len(magic)+3], err: ErrCorruptRecord},
+		{name: "torn body", data: buf[:len(buf)-1], err: ErrCorruptRecord},
+		{name: "size beyond stream", data: oversized, err: ErrCorruptRecord},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewReader(bytes.NewReader(tt.data)).Next()
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+func TestReaderSkipsUnknownRecordTypes(t *testing.T) {
+	buf, err := appendRecord([]byte(magic), &Record{Seq: 1, Type: RecordType(99), Time: time.Now()})
+	require.NoError(t, err)
+	buf, err = appendRecord(buf, &Record{Seq: 2, Type: RecordConnect, ClientID: "c1", Time: time.Now()})
+	require.NoError(t, err)
+	buf, err = appendRecord(buf, &Record{Seq: 3, Type: RecordType(99), Time: time.Now()})
+	require.NoError(t, err)
+
+	r := NewReader(bytes.NewReader(buf))
+	rec, err := r.Next()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), rec.Seq)
+	_, err = r.Next()
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 2, r.Skipped())
+	assert.Equal(t, uint64(3), r.Seq())
+
+	// The sequence continues after the skipped records
+	path := filepath.Join(t.TempDir(), "events.journal")
+	require.NoError(t, os.WriteFile(path, buf, 0o600))
+	j := openTestJournal(t, path)
+	seq, err := j.Append(&Record{Type: RecordPublish, ClientID: "c1", Topic: "a"})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), seq)
+	require.NoError(t, j.Close())
+	assert.Len(t, readAll(t, path), 2)
+}
+
+func TestOpenRejectsForeignFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "other")
+	require.NoError(t, os.WriteFile(path, []byte("not a journal"), 0o600))
+
+	_, err := Open(Config{Path: path})
+	assert.ErrorIs(t, err, ErrInvalidHeader)
+}
+
+func TestAppendFieldTooLarge(t *testing.T) {
+	j := openTestJournal(t, filepath.Join(t.TempDir(), "events.journal"))
+	defer j.Close()
+
+	_, err := j.Append(&Record{Type: RecordPublish, Topic: string(make([]byte, 70000))})
+	assert.ErrorIs(t, err, ErrFieldTooLarge)
+	assert.Equal(t, uint64(0), j.LastSeq())
+}
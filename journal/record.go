@@ -0,0 +1,158 @@
+package journal
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"math"
+	"time"
+)
+
+// RecordType identifies the broker event stored in a journal record
+type RecordType byte
+
+const (
+	RecordPublish RecordType = iota + 1
+	RecordConnect
+	RecordDisconnect
+	RecordSubscribe
+	RecordUnsubscribe
+)
+
+// String returns the string representation of the record type
+func (t RecordType) String() string {
+	switch t {
+	case RecordPublish:
+		return "publish"
+	case RecordConnect:
+		return "connect"
+	case RecordDisconnect:
+		return "disconnect"
+	case RecordSubscribe:
+		return "subscribe"
+	case RecordUnsubscribe:
+		return "unsubscribe"
+	default:
+		return "unknown"
+	}
+}
+
+func (t RecordType) valid() bool {
+	return t >= RecordPublish && t <= RecordUnsubscribe
+}
+
+// Record is a single journaled broker event
+// Topic holds the topic filter for subscribe and unsubscribe records
+type Record struct {
+	Seq      uint64
+	Time     time.Time
+	Type     RecordType
+	ClientID string
+	Topic    string
+	Payload  []byte
+	QoS      byte
+	Retain   bool
+}
+
+const (
+	// magic identifies a journal file and its format version
+	magic = "AXJ\x01"
+
+	// frameHeaderSize is the size of the length and checksum prefix of every record
+	frameHeaderSize = 8
+
+	// fixedBodySize is the size of the fixed-width record fields
+	fixedBodySize = 8 + 8 + 1 + 1 + 1 + 2 + 2 + 4
+
+	// maxRecordSize bounds a record body to the largest MQTT packet plus record overhead
+	maxRecordSize = fixedBodySize + 2*math.MaxUint16 + 268435455
+
+	flagRetain = 0x01
+)
+
+// appendRecord appends the framed binary encoding of a record to buf
+func appendRecord(buf []byte, rec *Record) ([]byte, error) {
+	if len(rec.ClientID) > math.MaxUint16 || len(rec.Topic) > math.MaxUint16 || len(rec.Payload) > maxRecordSize-fixedBodySize-2*math.MaxUint16 {
+		return buf, ErrFieldTooLarge
+	}
+
+	bodySize := fixedBodySize + len(rec.ClientID) + len(rec.Topic) + len(rec.Payload)
+	start := len(buf)
+	buf = append(buf, make([]byte, frameHeaderSize)...)
+
+	var flags byte
+	if rec.Retain {
+		flags |= flagRetain
+	}
+
+	buf = binary.BigEndian.AppendUint64(buf, rec.Seq)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(rec.Time.UnixNano()))
+	buf = append(buf, byte(rec.Type), rec.QoS, flags)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(rec.ClientID)))
+	buf = append(buf, rec.ClientID...)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(rec.Topic)))
+	buf = append(buf, rec.Topic...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(rec.Payload)))
+	buf = append(buf, rec.Payload...)
+
+	body := buf[start+frameHeaderSize:]
+	binary.BigEndian.PutUint32(buf[start:], uint32(bodySize))
+	binary.BigEndian.PutUint32(buf[start+4:], crc32.ChecksumIEEE(body))
+	return buf, nil
+}
+
+// decodeBody decodes a record body that has already passed its checksum
+func decodeBody(body []byte) (*Record, error) {
+	if len(body) < fixedBodySize {
+		return nil, ErrCorruptRecord
+	}
+
+	rec := &Record{
+		Seq:  binary.BigEndian.Uint64(body[0:8]),
+		Time: time.Unix(0, int64(binary.BigEndian.Uint64(body[8:16]))),
+		Type: RecordType(body[16]),
+		QoS:  body[17],
+	}
+	rec.Retain = body[18]&flagRetain != 0
+	if !rec.Type.valid() {
+		return nil, ErrUnknownRecordType
+	}
+
+	rest := body[19:]
+	var ok bool
+	var clientID, topic []byte
+	if clientID, rest, ok = readField(rest, 2); !ok {
+		return nil, ErrCorruptRecord
+	}
+	if topic, rest, ok = readField(rest, 2); !ok {
+		return nil, ErrCorruptRecord
+	}
+	if rec.Payload, rest, ok = readField(rest, 4); !ok || len(rest) != 0 {
+		return nil, ErrCorruptRecord
+	}
+
+	rec.ClientID = string(clientID)
+	rec.Topic = string(topic)
+	if len(rec.Payload) == 0 {
+		rec.Payload = nil
+	}
+	return rec, nil
+}
+
+// readField reads a length-prefixed field with a 2 or 4 byte length
+func readField(b []byte, lenSize int) (field, rest []byte, ok bool) {
+	if len(b) < lenSize {
+		return nil, nil, false
+	}
+
+	var n int
+	if lenSize == 2 {
+		n = int(binary.BigEndian.Uint16(b))
+	} else {
+		n = int(binary.BigEndian.Uint32(b))
+	}
+	b = b[lenSize:]
+	if n > len(b) {
+		return nil, nil, false
+	}
+	return b[:n:n], b[n:], true
+}
@@ -0,0 +1,90 @@
+package journal
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestJournal(t *testing.T, n int, gap time.Duration) string {
+	path := filepath.Join(t.TempDir(), "events.journal")
+	j := openTestJournal(t, path)
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		_, err := j.Append(&Record{
+			Type:     RecordPublish,
+			ClientID: "c1",
+			Topic:    "a/b",
+			Payload:  []byte{byte(i)},
+			Time:     start.Add(time.Duration(i) * gap),
+		})
+		require.NoError(t, err)
+	}
+	require.NoError(t, j.Close())
+	return path
+}
+
+func TestReplayFile(t *testing.T) {
+	path := writeTestJournal(t, 5, time.Second)
+
+	tests := []struct {
+		name string
+		opts ReplayOptions
+		want []uint64
+	}{
+		{name: "all", want: []uint64{1, 2, 3, 4, 5}},
+		{name: "from", opts: ReplayOptions{FromSeq: 3}, want: []uint64{3, 4, 5}},
+		{name: "to", opts: ReplayOptions{ToSeq: 2}, want: []uint64{1, 2}},
+		{name: "range", opts: ReplayOptions{FromSeq: 2, ToSeq: 4}, want: []uint64{2, 3, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seqs []uint64
+			n, err := ReplayFile(context.Background(), path, TargetFunc(func(_ context.Context, rec *Record) error {
+				seqs = append(seqs, rec.Seq)
+				return nil
+			}), tt.opts)
+			require.NoError(t, err)
+			assert.Equal(t, len(tt.want), n)
+			assert.Equal(t, tt.want, seqs)
+		})
+	}
+}
+
+func TestReplaySpeed(t *testing.T) {
+	path := writeTestJournal(t, 3, 100*time.Millisecond)
+
+	start := time.Now()
+	n, err := ReplayFile(context.Background(), path, TargetFunc(func(context.Context, *Record) error { return nil }), ReplayOptions{Speed: 4})
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = ReplayFile(ctx, path, TargetFunc(func(context.Context, *Record) error { return nil }), ReplayOptions{Speed: 0.1})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestReplayTargetError(t *testing.T) {
+	path := writeTestJournal(t, 3, 0)
+	boom := errors.New("boom")
+
+	n, err := ReplayFile(context.Background(), path, TargetFunc(func(_ context.Context, rec *Record) error {
+		if rec.Seq == 2 {
+			return boom
+		}
+		return nil
+	}), ReplayOptions{})
+	assert.ErrorIs(t, err, boom)
+	assert.Equal(t, 1, n)
+
+	_, err = ReplayFile(context.Background(), filepath.Join(t.TempDir(), "missing"), nil, ReplayOptions{})
+	assert.Error(t, err)
+}
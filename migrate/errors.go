@@ -0,0 +1,10 @@
+package migrate
+
+import "errors"
+
+var (
+	ErrNotMosquittoDB      = errors.New("not a mosquitto persistence file")
+	ErrUnsupportedDBFormat = errors.New("unsupported mosquitto persistence format")
+	ErrCorruptChunk        = errors.New("corrupt mosquitto persistence chunk")
+	ErrInvalidSnapshot     = errors.New("invalid snapshot")
+)
@@ -0,0 +1,339 @@
+package migrate
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/hook"
+	"github.com/axmq/ax/session"
+)
+
+// mosquittoMagic opens every Mosquitto persistence file
+const mosquittoMagic = "\x00\xB5\x00mosquitto db"
+
+// Chunk types of the Mosquitto persistence format
+const (
+	chunkConfig     = 1
+	chunkMsgStore   = 2
+	chunkClientMsg  = 3
+	chunkRetain     = 4
+	chunkSub        = 5
+	chunkClient     = 6
+	maxChunkPayload = 256 << 20
+)
+
+// Fixed part sizes of the chunks read, see persist.h in the Mosquitto sources
+const (
+	configSize    = 10
+	msgStoreSize  = 32
+	retainSize    = 8
+	subSize       = 12
+	clientSizeV5  = 16
+	clientSizeV6  = 24
+	dbIDSize      = 8
+	neverExpires  = ^uint32(0)
+	subOptionMask = 0x03
+)
+
+// hostOrder is the byte order of the fields Mosquitto writes without conversion (database IDs and times)
+// Files written on little-endian machines, which covers x86 and ARM deployments, are supported
+var hostOrder = binary.LittleEndian
+
+// MosquittoStats counts what was found in a Mosquitto persistence file
+type MosquittoStats struct {
+	Version         uint32
+	Messages        int
+	Retained        int
+	Sessions        int
+	Subscriptions   int
+	SkippedInflight int // queued and inflight client messages, which are not imported
+	SkippedExpired  int // retained messages already past their expiry
+}
+
+// OpenMosquittoDB reads the Mosquitto persistence file at path
+func OpenMosquittoDB(path string) (*Snapshot, *MosquittoStats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	return ReadMosquittoDB(bufio.NewReader(f))
+}
+
+// ReadMosquittoDB reads retained messages, persistent sessions and their subscriptions from
+// a Mosquitto persistence file (mosquitto.db) written by Mosquitto 1.6 (format 5) or 2.x (format 6)
+// Queued and inflight messages are skipped, clients receive them again through their subscriptions
+func ReadMosquittoDB(r io.Reader) (*Snapshot, *MosquittoStats, error) {
+	var header [len(mosquittoMagic) + 8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, nil, ErrNotMosquittoDB
+	}
+	if string(header[:len(mosquittoMagic)]) != mosquittoMagic {
+		return nil, nil, ErrNotMosquittoDB
+	}
+
+	// The CRC field after the magic is always written as zero and is not checked
+	version := binary.BigEndian.Uint32(header[len(mosquittoMagic)+4:])
+	if version != 5 && version != 6 {
+		return nil, nil, fmt.Errorf("%w: version %d", ErrUnsupportedDBFormat, version)
+	}
+
+	d := &mosquittoDecoder{
+		version:  version,
+		now:      time.Now(),
+		messages: make(map[uint64]*storedMessage),
+		sessions: make(map[string]*session.Session),
+		stats:    &MosquittoStats{Version: version},
+	}
+
+	var chunkHeader [8]byte
+	for {
+		if _, err := io.ReadFull(r, chunkHeader[:]); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, nil, ErrCorruptChunk
+		}
+
+		chunkType := binary.BigEndian.Uint32(chunkHeader[:4])
+		length := binary.BigEndian.Uint32(chunkHeader[4:])
+		if length > maxChunkPayload {
+			return nil, nil, fmt.Errorf("%w: chunk of %d bytes", ErrCorruptChunk, length)
+		}
+
+		data := make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, nil, ErrCorruptChunk
+		}
+		if err := d.chunk(chunkType, data); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return d.snapshot(), d.stats, nil
+}
+
+// storedMessage is a message of the Mosquitto message store, referenced by retain and client chunks
+type storedMessage struct {
+	topic      string
+	payload    []byte
+	qos        byte
+	expiresAt  time.Time
+	properties hook.Properties
+}
+
+// mosquittoDecoder collects the state spread across the chunks of a persistence file
+type mosquittoDecoder struct {
+	version  uint32
+	now      time.Time
+	messages map[uint64]*storedMessage
+	retained []uint64
+	sessions map[string]*session.Session
+	order    []string
+	stats    *MosquittoStats
+}
+
+func (d *mosquittoDecoder) chunk(chunkType uint32, data []byte) error {
+	switch chunkType {
+	case chunkConfig:
+		if len(data) < configSize {
+			return ErrCorruptChunk
+		}
+		if data[9] != dbIDSize {
+			return fmt.Errorf("%w: %d byte database IDs", ErrUnsupportedDBFormat, data[9])
+		}
+	case chunkMsgStore:
+		return d.msgStore(data)
+	case chunkRetain:
+		if len(data) < retainSize {
+			return ErrCorruptChunk
+		}
+		d.retained = append(d.retained, hostOrder.Uint64(data))
+	case chunkClient:
+		return d.client(data)
+	case chunkSub:
+		return d.sub(data)
+	case chunkClientMsg:
+		d.stats.SkippedInflight++
+	}
+	// Unknown chunk types are skipped, as Mosquitto itself does
+	return nil
+}
+
+// msgStore decodes a stored message: fixed fields, source client ID, source username, topic,
+// payload and, in whatever is left of the chunk, MQTT 5.0 properties
+func (d *mosquittoDecoder) msgStore(data []byte) error {
+	if len(data) < msgStoreSize {
+		return ErrCorruptChunk
+	}
+
+	id := hostOrder.Uint64(data[0:])
+	expiry := int64(hostOrder.Uint64(data[8:]))
+	payloadLen := int(binary.BigEndian.Uint32(data[16:]))
+	sourceIDLen := int(binary.BigEndian.Uint16(data[22:]))
+	usernameLen := int(binary.BigEndian.Uint16(data[24:]))
+	topicLen := int(binary.BigEndian.Uint16(data[26:]))
+	qos := data[30]
+
+	rest := data[msgStoreSize:]
+	if len(rest) < sourceIDLen+usernameLen+topicLen+payloadLen {
+		return ErrCorruptChunk
+	}
+	rest = rest[sourceIDLen+usernameLen:]
+	msg := &storedMessage{
+		topic:   string(rest[:topicLen]),
+		payload: append([]byte(nil), rest[topicLen:topicLen+payloadLen]...),
+		qos:     qos,
+	}
+	if expiry > 0 {
+		msg.expiresAt = time.Unix(expiry, 0)
+	}
+
+	if props := rest[topicLen+payloadLen:]; len(props) > 0 {
+		parsed, _, err := encoding.ParsePropertiesFromBytes(props)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrCorruptChunk, err)
+		}
+		msg.properties = propertiesMap(parsed)
+	}
+
+	d.messages[id] = msg
+	d.stats.Messages++
+	return nil
+}
+
+// client decodes a persistent client session
+func (d *mosquittoDecoder) client(data []byte) error {
+	size := clientSizeV5
+	if d.version >= 6 {
+		size = clientSizeV6
+	}
+	if len(data) < size {
+		return ErrCorruptChunk
+	}
+
+	expiryInterval := binary.BigEndian.Uint32(data[8:])
+	idLen := int(binary.BigEndian.Uint16(data[14:]))
+	if len(data) < size+idLen {
+		return ErrCorruptChunk
+	}
+	clientID := string(data[size : size+idLen])
+
+	// Mosquitto writes neverExpires for sessions of MQTT 3.1.1 clients without a configured expiry,
+	// which is the MQTT 5.0 value for a session that does not expire
+	sess := d.session(clientID)
+	sess.ExpiryInterval = expiryInterval
+	d.stats.Sessions++
+	return nil
+}
+
+// sub decodes a subscription of a persistent session
+func (d *mosquittoDecoder) sub(data []byte) error {
+	if len(data) < subSize {
+		return ErrCorruptChunk
+	}
+
+	identifier := binary.BigEndian.Uint32(data[0:])
+	idLen := int(binary.BigEndian.Uint16(data[4:]))
+	topicLen := int(binary.BigEndian.Uint16(data[6:]))
+	qos := data[8]
+	options := data[9]
+	if len(data) < subSize+idLen+topicLen {
+		return ErrCorruptChunk
+	}
+
+	clientID := string(data[subSize : subSize+idLen])
+	filter := string(data[subSize+idLen : subSize+idLen+topicLen])
+
+	// Options hold the MQTT 5.0 subscription option bits above the QoS
+	d.session(clientID).AddSubscription(&session.Subscription{
+		TopicFilter:            filter,
+		QoS:                    qos & subOptionMask,
+		NoLocal:                options&0x04 != 0,
+		RetainAsPublished:      options&0x08 != 0,
+		RetainHandling:         (options >> 4) & 0x03,
+		SubscriptionIdentifier: identifier,
+		SubscribedAt:           d.now,
+	})
+	d.stats.Subscriptions++
+	return nil
+}
+
+// session returns the session of clientID, creating it on first use
+// Subscription chunks may come before the client chunk they belong to
+func (d *mosquittoDecoder) session(clientID string) *session.Session {
+	sess, ok := d.sessions[clientID]
+	if !ok {
+		sess = session.New(clientID, false, neverExpires, 0)
+		d.sessions[clientID] = sess
+		d.order = append(d.order, clientID)
+	}
+	return sess
+}
+
+// snapshot resolves retained references against the message store
+func (d *mosquittoDecoder) snapshot() *Snapshot {
+	snap := &Snapshot{}
+
+	for _, id := range d.retained {
+		msg, ok := d.messages[id]
+		if !ok || len(msg.payload) == 0 {
+			continue
+		}
+
+		properties := msg.properties
+		if !msg.expiresAt.IsZero() {
+			remaining := msg.expiresAt.Sub(d.now)
+			if remaining <= 0 {
+				d.stats.SkippedExpired++
+				continue
+			}
+			if properties == nil {
+				properties = make(hook.Properties)
+			}
+			properties["MessageExpiryInterval"] = uint32((remaining + time.Second - 1) / time.Second)
+		}
+
+		snap.Retained = append(snap.Retained, &hook.RetainedMessage{
+			Topic:      msg.topic,
+			Payload:    msg.payload,
+			QoS:        msg.qos,
+			Properties: properties,
+			Timestamp:  d.now,
+		})
+	}
+	d.stats.Retained = len(snap.Retained)
+
+	for _, clientID := range d.order {
+		snap.Sessions = append(snap.Sessions, d.sessions[clientID])
+	}
+
+	return snap
+}
+
+// propertiesMap converts parsed properties to the map form used by hooks, keyed by property name
+func propertiesMap(props *encoding.Properties) hook.Properties {
+	if props == nil || len(props.Properties) == 0 {
+		return nil
+	}
+
+	m := make(hook.Properties, len(props.Properties))
+	for _, prop := range props.Properties {
+		name := prop.ID.String()
+		if prop.ID == encoding.PropUserProperty {
+			pairs, _ := m[name].([]encoding.UTF8Pair)
+			if pair, ok := prop.Value.(encoding.UTF8Pair); ok {
+				m[name] = append(pairs, pair)
+			}
+			continue
+		}
+		m[name] = prop.Value
+	}
+	return m
+}
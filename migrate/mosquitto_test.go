@@ -0,0 +1,183 @@
+package migrate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mosquittoDB builds a persistence file in the layout Mosquitto writes on little-endian hosts
+type mosquittoDB struct {
+	bytes.Buffer
+}
+
+func newMosquittoDB(version uint32) *mosquittoDB {
+	db := &mosquittoDB{}
+	db.WriteString(mosquittoMagic)
+	db.u32(0)
+	db.u32(version)
+	return db
+}
+
+func (db *mosquittoDB) u16(v uint16) { _ = binary.Write(&db.Buffer, binary.BigEndian, v) }
+func (db *mosquittoDB) u32(v uint32) { _ = binary.Write(&db.Buffer, binary.BigEndian, v) }
+
+func (db *mosquittoDB) chunk(chunkType uint32, data []byte) {
+	db.u32(chunkType)
+	db.u32(uint32(len(data)))
+	db.Write(data)
+}
+
+func (db *mosquittoDB) config() {
+	data := make([]byte, configSize)
+	hostOrder.PutUint64(data, 3)
+	data[9] = dbIDSize
+	db.chunk(chunkConfig, data)
+}
+
+func (db *mosquittoDB) msgStore(id uint64, expiry int64, topic string, payload []byte, qos byte, props []byte) {
+	data := make([]byte, msgStoreSize)
+	hostOrder.PutUint64(data[0:], id)
+	hostOrder.PutUint64(data[8:], uint64(expiry))
+	binary.BigEndian.PutUint32(data[16:], uint32(len(payload)))
+	binary.BigEndian.PutUint16(data[22:], uint16(len("publisher")))
+	binary.BigEndian.PutUint16(data[26:], uint16(len(topic)))
+	data[30] = qos
+	data[31] = 1
+	data = append(data, "publisher"...)
+	data = append(data, topic...)
+	data = append(data, payload...)
+	data = append(data, props...)
+	db.chunk(chunkMsgStore, data)
+}
+
+func (db *mosquittoDB) retain(id uint64) {
+	data := make([]byte, retainSize)
+	hostOrder.PutUint64(data, id)
+	db.chunk(chunkRetain, data)
+}
+
+func (db *mosquittoDB) client(version uint32, clientID string, expiryInterval uint32) {
+	size := clientSizeV5
+	if version >= 6 {
+		size = clientSizeV6
+	}
+	data := make([]byte, size)
+	binary.BigEndian.PutUint32(data[8:], expiryInterval)
+	binary.BigEndian.PutUint16(data[14:], uint16(len(clientID)))
+	db.chunk(chunkClient, append(data, clientID...))
+}
+
+func (db *mosquittoDB) sub(clientID, filter string, qos, options byte, identifier uint32) {
+	data := make([]byte, subSize)
+	binary.BigEndian.PutUint32(data[0:], identifier)
+	binary.BigEndian.PutUint16(data[4:], uint16(len(clientID)))
+	binary.BigEndian.PutUint16(data[6:], uint16(len(filter)))
+	data[8] = qos
+	data[9] = options
+	data = append(data, clientID...)
+	db.chunk(chunkSub, append(data, filter...))
+}
+
+func TestReadMosquittoDB(t *testing.T) {
+	props := &encoding.Properties{}
+	props.Properties = append(props.Properties,
+		encoding.Property{ID: encoding.PropContentType, Value: "text/plain"},
+		encoding.Property{ID: encoding.PropUserProperty, Value: encoding.UTF8Pair{Key: "a", Value: "1"}},
+		encoding.Property{ID: encoding.PropUserProperty, Value: encoding.UTF8Pair{Key: "b", Value: "2"}},
+	)
+	var encoded bytes.Buffer
+	require.NoError(t, props.EncodeProperties(&encoded))
+
+	for _, version := range []uint32{5, 6} {
+		db := newMosquittoDB(version)
+		db.config()
+		db.sub("device-1", "cmd/#", 1, 0x04|0x08|0x20, 7)
+		db.msgStore(1, 0, "sensors/1", []byte("21.5"), 1, encoded.Bytes())
+		db.msgStore(2, time.Now().Add(time.Hour).Unix(), "sensors/2", []byte("on"), 0, nil)
+		db.msgStore(3, time.Now().Add(-time.Hour).Unix(), "sensors/3", []byte("old"), 0, nil)
+		db.msgStore(4, 0, "sensors/4", []byte("queued"), 1, nil)
+		db.retain(1)
+		db.retain(2)
+		db.retain(3)
+		db.chunk(chunkClientMsg, make([]byte, 30))
+		db.chunk(99, []byte{1, 2, 3})
+		db.client(version, "device-1", 86400)
+		db.client(version, "device-2", neverExpires)
+
+		snap, stats, err := ReadMosquittoDB(&db.Buffer)
+		require.NoError(t, err)
+
+		assert.Equal(t, &MosquittoStats{
+			Version:         version,
+			Messages:        4,
+			Retained:        2,
+			Sessions:        2,
+			Subscriptions:   1,
+			SkippedInflight: 1,
+			SkippedExpired:  1,
+		}, stats)
+
+		require.Len(t, snap.Retained, 2)
+		assert.Equal(t, "sensors/1", snap.Retained[0].Topic)
+		assert.Equal(t, []byte("21.5"), snap.Retained[0].Payload)
+		assert.Equal(t, byte(1), snap.Retained[0].QoS)
+		assert.Equal(t, "text/plain", snap.Retained[0].Properties["ContentType"])
+		assert.Equal(t, []encoding.UTF8Pair{{Key: "a", Value: "1"}, {Key: "b", Value: "2"}}, snap.Retained[0].Properties["UserProperty"])
+
+		expiry, ok := snap.Retained[1].Properties["MessageExpiryInterval"].(uint32)
+		require.True(t, ok)
+		assert.InDelta(t, 3600, expiry, 2)
+
+		require.Len(t, snap.Sessions, 2)
+		assert.Equal(t, "device-1", snap.Sessions[0].ClientID)
+		assert.Equal(t, uint32(86400), snap.Sessions[0].ExpiryInterval)
+		assert.Equal(t, neverExpires, snap.Sessions[1].ExpiryInterval)
+
+		sub, ok := snap.Sessions[0].GetSubscription("cmd/#")
+		require.True(t, ok)
+		assert.Equal(t, byte(1), sub.QoS)
+		assert.True(t, sub.NoLocal)
+		assert.True(t, sub.RetainAsPublished)
+		assert.Equal(t, byte(2), sub.RetainHandling)
+		assert.Equal(t, uint32(7), sub.SubscriptionIdentifier)
+	}
+}
+
+func TestReadMosquittoDB_Invalid(t *testing.T) {
+	truncated := newMosquittoDB(6)
+	truncated.u32(chunkRetain)
+	truncated.u32(8)
+	truncated.Write([]byte{1, 2})
+
+	short := newMosquittoDB(6)
+	short.chunk(chunkClient, make([]byte, 4))
+
+	wideIDs := newMosquittoDB(6)
+	wideIDs.chunk(chunkConfig, make([]byte, configSize))
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{name: "empty", data: nil, wantErr: ErrNotMosquittoDB},
+		{name: "wrong magic", data: []byte("\x00\xB5\x00mosquitto xx\x00\x00\x00\x00\x00\x00\x00\x06"), wantErr: ErrNotMosquittoDB},
+		{name: "old version", data: newMosquittoDB(4).Bytes(), wantErr: ErrUnsupportedDBFormat},
+		{name: "truncated chunk", data: truncated.Bytes(), wantErr: ErrCorruptChunk},
+		{name: "short client chunk", data: short.Bytes(), wantErr: ErrCorruptChunk},
+		{name: "database ID size", data: wideIDs.Bytes(), wantErr: ErrUnsupportedDBFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := ReadMosquittoDB(bytes.NewReader(tt.data))
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
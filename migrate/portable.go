@@ -0,0 +1,250 @@
+package migrate
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/hook"
+	"github.com/axmq/ax/session"
+)
+
+// The portable format is JSON Lines: a header record followed by one record per retained message
+// and per session, for example
+//
+//	{"format":"ax-state","version":1}
+//	{"type":"retained","topic":"sensors/1","payload":"MjEuNQ==","qos":1,"timestamp":"2025-01-02T03:04:05Z","properties":{"message_expiry_interval":3600}}
+//	{"type":"session","client_id":"device-1","expiry_interval":86400,"protocol_version":5,"subscriptions":[{"topic_filter":"cmd/#","qos":1}]}
+//
+// Payloads and correlation data are base64, unknown record types are skipped by readers
+const (
+	portableFormat  = "ax-state"
+	portableVersion = 1
+
+	recordRetained = "retained"
+	recordSession  = "session"
+)
+
+type portableHeader struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+}
+
+type portableRecord struct {
+	Type string `json:"type"`
+
+	// Retained message fields
+	Topic      string              `json:"topic,omitempty"`
+	Payload    []byte              `json:"payload,omitempty"`
+	QoS        byte                `json:"qos,omitempty"`
+	Timestamp  time.Time           `json:"timestamp,omitzero"`
+	Properties *portableProperties `json:"properties,omitempty"`
+	Node       string              `json:"node,omitempty"`
+
+	// Session fields
+	ClientID        string                  `json:"client_id,omitempty"`
+	ExpiryInterval  uint32                  `json:"expiry_interval,omitempty"`
+	ProtocolVersion byte                    `json:"protocol_version,omitempty"`
+	CreatedAt       time.Time               `json:"created_at,omitzero"`
+	Metadata        map[string]string       `json:"metadata,omitempty"`
+	Subscriptions   []*portableSubscription `json:"subscriptions,omitempty"`
+}
+
+// portableProperties holds the PUBLISH properties kept with a retained message
+type portableProperties struct {
+	PayloadFormatIndicator *byte               `json:"payload_format_indicator,omitempty"`
+	MessageExpiryInterval  *uint32             `json:"message_expiry_interval,omitempty"`
+	ContentType            string              `json:"content_type,omitempty"`
+	ResponseTopic          string              `json:"response_topic,omitempty"`
+	CorrelationData        []byte              `json:"correlation_data,omitempty"`
+	UserProperties         []encoding.UTF8Pair `json:"user_properties,omitempty"`
+}
+
+type portableSubscription struct {
+	TopicFilter            string `json:"topic_filter"`
+	QoS                    byte   `json:"qos,omitempty"`
+	NoLocal                bool   `json:"no_local,omitempty"`
+	RetainAsPublished      bool   `json:"retain_as_published,omitempty"`
+	RetainHandling         byte   `json:"retain_handling,omitempty"`
+	SubscriptionIdentifier uint32 `json:"subscription_identifier,omitempty"`
+	LastValue              bool   `json:"last_value,omitempty"`
+}
+
+// WriteSnapshot writes snap to w in the portable format
+func WriteSnapshot(w io.Writer, snap *Snapshot) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+
+	if err := enc.Encode(portableHeader{Format: portableFormat, Version: portableVersion}); err != nil {
+		return err
+	}
+
+	for _, msg := range snap.Retained {
+		if err := enc.Encode(&portableRecord{
+			Type:       recordRetained,
+			Topic:      msg.Topic,
+			Payload:    msg.Payload,
+			QoS:        msg.QoS,
+			Timestamp:  msg.Timestamp.UTC(),
+			Properties: fromHookProperties(msg.Properties),
+			Node:       msg.Node,
+		}); err != nil {
+			return err
+		}
+	}
+
+	for _, sess := range snap.Sessions {
+		if err := enc.Encode(sessionRecord(sess)); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// ReadSnapshot reads a snapshot written by WriteSnapshot
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	dec := json.NewDecoder(r)
+
+	var header portableHeader
+	if err := dec.Decode(&header); err != nil || header.Format != portableFormat {
+		return nil, ErrInvalidSnapshot
+	}
+	if header.Version != portableVersion {
+		return nil, fmt.Errorf("%w: version %d", ErrInvalidSnapshot, header.Version)
+	}
+
+	snap := &Snapshot{}
+	for {
+		var rec portableRecord
+		if err := dec.Decode(&rec); err != nil {
+			if err == io.EOF {
+				return snap, nil
+			}
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+		}
+
+		switch rec.Type {
+		case recordRetained:
+			if rec.Topic == "" {
+				return nil, fmt.Errorf("%w: retained message without topic", ErrInvalidSnapshot)
+			}
+			snap.Retained = append(snap.Retained, &hook.RetainedMessage{
+				Topic:      rec.Topic,
+				Payload:    rec.Payload,
+				QoS:        rec.QoS,
+				Properties: rec.Properties.hookProperties(),
+				Timestamp:  rec.Timestamp,
+				Node:       rec.Node,
+			})
+		case recordSession:
+			if rec.ClientID == "" {
+				return nil, fmt.Errorf("%w: session without client ID", ErrInvalidSnapshot)
+			}
+			snap.Sessions = append(snap.Sessions, rec.session())
+		}
+	}
+}
+
+func sessionRecord(sess *session.Session) *portableRecord {
+	rec := &portableRecord{
+		Type:            recordSession,
+		ClientID:        sess.ClientID,
+		ExpiryInterval:  sess.ExpiryInterval,
+		ProtocolVersion: sess.ProtocolVersion,
+		CreatedAt:       sess.CreatedAt.UTC(),
+		Metadata:        sess.Metadata,
+	}
+	for _, sub := range sess.GetAllSubscriptions() {
+		rec.Subscriptions = append(rec.Subscriptions, &portableSubscription{
+			TopicFilter:            sub.TopicFilter,
+			QoS:                    sub.QoS,
+			NoLocal:                sub.NoLocal,
+			RetainAsPublished:      sub.RetainAsPublished,
+			RetainHandling:         sub.RetainHandling,
+			SubscriptionIdentifier: sub.SubscriptionIdentifier,
+			LastValue:              sub.LastValue,
+		})
+	}
+	sort.Slice(rec.Subscriptions, func(i, j int) bool {
+		return rec.Subscriptions[i].TopicFilter < rec.Subscriptions[j].TopicFilter
+	})
+	return rec
+}
+
+func (rec *portableRecord) session() *session.Session {
+	sess := session.New(rec.ClientID, false, rec.ExpiryInterval, rec.ProtocolVersion)
+	if !rec.CreatedAt.IsZero() {
+		sess.CreatedAt = rec.CreatedAt
+	}
+	if len(rec.Metadata) > 0 {
+		sess.Metadata = rec.Metadata
+	}
+	for _, sub := range rec.Subscriptions {
+		sess.AddSubscription(&session.Subscription{
+			TopicFilter:            sub.TopicFilter,
+			QoS:                    sub.QoS,
+			NoLocal:                sub.NoLocal,
+			RetainAsPublished:      sub.RetainAsPublished,
+			RetainHandling:         sub.RetainHandling,
+			SubscriptionIdentifier: sub.SubscriptionIdentifier,
+			LastValue:              sub.LastValue,
+			SubscribedAt:           sess.CreatedAt,
+		})
+	}
+	return sess
+}
+
+// fromHookProperties keeps the properties the portable format knows, keyed as in propertiesMap
+func fromHookProperties(props hook.Properties) *portableProperties {
+	if len(props) == 0 {
+		return nil
+	}
+
+	p := &portableProperties{}
+	if v, ok := props[encoding.PropPayloadFormatIndicator.String()].(byte); ok {
+		p.PayloadFormatIndicator = &v
+	}
+	if v, ok := props.Uint32(encoding.PropMessageExpiryInterval.String()); ok {
+		p.MessageExpiryInterval = &v
+	}
+	p.ContentType, _ = props[encoding.PropContentType.String()].(string)
+	p.ResponseTopic, _ = props[encoding.PropResponseTopic.String()].(string)
+	p.CorrelationData, _ = props[encoding.PropCorrelationData.String()].([]byte)
+	p.UserProperties, _ = props[encoding.PropUserProperty.String()].([]encoding.UTF8Pair)
+	return p
+}
+
+func (p *portableProperties) hookProperties() hook.Properties {
+	if p == nil {
+		return nil
+	}
+
+	props := make(hook.Properties)
+	if p.PayloadFormatIndicator != nil {
+		props[encoding.PropPayloadFormatIndicator.String()] = *p.PayloadFormatIndicator
+	}
+	if p.MessageExpiryInterval != nil {
+		props[encoding.PropMessageExpiryInterval.String()] = *p.MessageExpiryInterval
+	}
+	if p.ContentType != "" {
+		props[encoding.PropContentType.String()] = p.ContentType
+	}
+	if p.ResponseTopic != "" {
+		props[encoding.PropResponseTopic.String()] = p.ResponseTopic
+	}
+	if len(p.CorrelationData) > 0 {
+		props[encoding.PropCorrelationData.String()] = p.CorrelationData
+	}
+	if len(p.UserProperties) > 0 {
+		props[encoding.PropUserProperty.String()] = p.UserProperties
+	}
+	if len(props) == 0 {
+		return nil
+	}
+	return props
+}
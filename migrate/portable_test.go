@@ -0,0 +1,101 @@
+package migrate
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/hook"
+	"github.com/axmq/ax/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteSnapshot_RoundTrip(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	sess := session.New("device-1", false, 86400, 5)
+	sess.CreatedAt = now
+	sess.Metadata = map[string]string{"tenant": "acme"}
+	sess.AddSubscription(&session.Subscription{TopicFilter: "cmd/#", QoS: 1, NoLocal: true, RetainHandling: 2, SubscriptionIdentifier: 7})
+	sess.AddSubscription(&session.Subscription{TopicFilter: "alerts", QoS: 2, RetainAsPublished: true})
+
+	snap := &Snapshot{
+		Retained: []*hook.RetainedMessage{
+			{
+				Topic:   "sensors/1",
+				Payload: []byte{0x00, 0xFF, 'x'},
+				QoS:     1,
+				Properties: hook.Properties{
+					"PayloadFormatIndicator": byte(1),
+					"MessageExpiryInterval":  uint32(3600),
+					"ContentType":            "text/plain",
+					"ResponseTopic":          "reply",
+					"CorrelationData":        []byte{1, 2},
+					"UserProperty":           []encoding.UTF8Pair{{Key: "a", Value: "1"}},
+				},
+				Timestamp: now,
+			},
+			{Topic: "sensors/2", Payload: []byte("on"), Timestamp: now, Node: "node-b"},
+		},
+		Sessions: []*session.Session{sess},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteSnapshot(&buf, snap))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 4)
+	assert.JSONEq(t, `{"format":"ax-state","version":1}`, lines[0])
+
+	got, err := ReadSnapshot(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, snap.Retained, got.Retained)
+
+	require.Len(t, got.Sessions, 1)
+	restored := got.Sessions[0]
+	assert.Equal(t, "device-1", restored.ClientID)
+	assert.Equal(t, uint32(86400), restored.ExpiryInterval)
+	assert.Equal(t, byte(5), restored.ProtocolVersion)
+	assert.Equal(t, now, restored.CreatedAt)
+	assert.Equal(t, map[string]string{"tenant": "acme"}, restored.Metadata)
+
+	subs := restored.GetAllSubscriptions()
+	require.Len(t, subs, 2)
+	assert.True(t, subs["cmd/#"].NoLocal)
+	assert.Equal(t, byte(2), subs["cmd/#"].RetainHandling)
+	assert.Equal(t, uint32(7), subs["cmd/#"].SubscriptionIdentifier)
+	assert.True(t, subs["alerts"].RetainAsPublished)
+}
+
+func TestReadSnapshot_Invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "empty", input: ""},
+		{name: "wrong format", input: `{"format":"other","version":1}`},
+		{name: "future version", input: `{"format":"ax-state","version":2}`},
+		{name: "bad record", input: "{\"format\":\"ax-state\",\"version\":1}\n{\"type\":"},
+		{name: "retained without topic", input: "{\"format\":\"ax-state\",\"version\":1}\n{\"type\":\"retained\"}"},
+		{name: "session without client ID", input: "{\"format\":\"ax-state\",\"version\":1}\n{\"type\":\"session\"}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadSnapshot(strings.NewReader(tt.input))
+			assert.ErrorIs(t, err, ErrInvalidSnapshot)
+		})
+	}
+}
+
+func TestReadSnapshot_SkipsUnknownRecords(t *testing.T) {
+	input := "{\"format\":\"ax-state\",\"version\":1}\n{\"type\":\"bridge\",\"name\":\"x\"}\n{\"type\":\"retained\",\"topic\":\"a\",\"payload\":\"eA==\"}\n"
+
+	snap, err := ReadSnapshot(strings.NewReader(input))
+	require.NoError(t, err)
+	require.Len(t, snap.Retained, 1)
+	assert.Equal(t, []byte("x"), snap.Retained[0].Payload)
+}
@@ -0,0 +1,80 @@
+// Package migrate moves broker state into and out of ax: retained messages and persistent
+// sessions can be imported from a Mosquitto persistence file and exported to a portable format
+package migrate
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/axmq/ax/hook"
+	"github.com/axmq/ax/session"
+	"github.com/axmq/ax/store"
+)
+
+// Snapshot is the broker state carried between brokers
+type Snapshot struct {
+	Retained []*hook.RetainedMessage
+	Sessions []*session.Session
+}
+
+// Export collects the retained messages and stored sessions of an ax broker, either source may be nil
+// Entries are sorted by topic and client ID so exports of the same state are identical
+func Export(ctx context.Context, retained *hook.RetainedStore, sessions *session.Manager) (*Snapshot, error) {
+	snap := &Snapshot{}
+
+	if retained != nil {
+		keys, err := retained.Store().List(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			msg, err := retained.Load(ctx, key)
+			if errors.Is(err, store.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			snap.Retained = append(snap.Retained, msg)
+		}
+		sort.Slice(snap.Retained, func(i, j int) bool {
+			return snap.Retained[i].Topic < snap.Retained[j].Topic
+		})
+	}
+
+	if sessions != nil {
+		all, err := sessions.FindSessionsByMetadata(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		snap.Sessions = all
+		sort.Slice(snap.Sessions, func(i, j int) bool {
+			return snap.Sessions[i].ClientID < snap.Sessions[j].ClientID
+		})
+	}
+
+	return snap, nil
+}
+
+// Apply writes the snapshot into an ax broker, replacing retained messages and sessions with the same
+// topic or client ID, either target may be nil to skip that part
+func (s *Snapshot) Apply(ctx context.Context, retained *hook.RetainedStore, sessions *session.Manager) error {
+	if retained != nil {
+		for _, msg := range s.Retained {
+			if err := retained.Save(ctx, msg); err != nil {
+				return err
+			}
+		}
+	}
+
+	if sessions != nil {
+		for _, sess := range s.Sessions {
+			if err := sessions.RestoreSession(ctx, sess); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
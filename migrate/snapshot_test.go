@@ -0,0 +1,61 @@
+package migrate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/axmq/ax/hook"
+	"github.com/axmq/ax/session"
+	"github.com/axmq/ax/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot_ExportApply(t *testing.T) {
+	ctx := context.Background()
+
+	source := hook.NewRetainedStore(store.NewMemoryStore[*hook.RetainedMessage]())
+	sourceSessions := session.NewManager(session.ManagerConfig{Store: store.NewMemoryStore[*session.Session]()})
+	defer sourceSessions.Close()
+
+	require.NoError(t, source.Save(ctx, &hook.RetainedMessage{Topic: "b", Payload: []byte("2"), Timestamp: time.Now()}))
+	require.NoError(t, source.Save(ctx, &hook.RetainedMessage{Topic: "a", Payload: []byte("1"), Timestamp: time.Now()}))
+	sess, _, err := sourceSessions.CreateSession(ctx, "device-2", false, 300, 5)
+	require.NoError(t, err)
+	sess.AddSubscription(&session.Subscription{TopicFilter: "cmd/#", QoS: 1})
+	require.NoError(t, sourceSessions.DisconnectSession(ctx, "device-2", false))
+	_, _, err = sourceSessions.CreateSession(ctx, "device-1", false, 300, 5)
+	require.NoError(t, err)
+
+	snap, err := Export(ctx, source, sourceSessions)
+	require.NoError(t, err)
+	require.Len(t, snap.Retained, 2)
+	assert.Equal(t, "a", snap.Retained[0].Topic)
+	require.Len(t, snap.Sessions, 2)
+	assert.Equal(t, "device-1", snap.Sessions[0].ClientID)
+
+	target := hook.NewRetainedStore(store.NewMemoryStore[*hook.RetainedMessage]())
+	targetSessions := session.NewManager(session.ManagerConfig{Store: store.NewMemoryStore[*session.Session]()})
+	defer targetSessions.Close()
+
+	require.NoError(t, snap.Apply(ctx, target, targetSessions))
+
+	msg, err := target.Load(ctx, "b")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("2"), msg.Payload)
+
+	restored, err := targetSessions.GetSession(ctx, "device-2")
+	require.NoError(t, err)
+	assert.Equal(t, session.StateDisconnected, restored.State)
+	_, ok := restored.GetSubscription("cmd/#")
+	assert.True(t, ok)
+}
+
+func TestExport_NilSources(t *testing.T) {
+	snap, err := Export(context.Background(), nil, nil)
+	require.NoError(t, err)
+	assert.Empty(t, snap.Retained)
+	assert.Empty(t, snap.Sessions)
+	assert.NoError(t, snap.Apply(context.Background(), nil, nil))
+}
@@ -0,0 +1,67 @@
+package network
+
+import (
+	"crypto/tls"
+	"fmt"
+	"slices"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACMETLSALPNProto is the ALPN protocol of the ACME TLS-ALPN-01 challenge (RFC 8737)
+const ACMETLSALPNProto = "acme-tls/1"
+
+// CertificateManager issues certificates on demand, such as an ACME client for Let's Encrypt
+// golang.org/x/crypto/acme/autocert.Manager satisfies it and answers TLS-ALPN-01 challenges from
+// GetCertificate, so no HTTP listener on port 80 is needed
+type CertificateManager interface {
+	GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error)
+}
+
+// appendACMEProto offers the challenge protocol after the listener protocols so regular clients
+// never negotiate it by accident
+func appendACMEProto(protos []string) []string {
+	if slices.Contains(protos, ACMETLSALPNProto) {
+		return protos
+	}
+	return append(slices.Clip(protos), ACMETLSALPNProto)
+}
+
+// ACMEConfig configures certificates issued by an ACME CA such as Let's Encrypt
+type ACMEConfig struct {
+	// Domains lists the host names certificates are issued for, handshakes for other names are refused
+	Domains []string
+	// CacheDir persists the account key and the issued certificates so restarts do not hit CA rate limits
+	CacheDir string
+	// Email is the contact address of the ACME account, optional
+	Email string
+	// DirectoryURL is the directory of the CA, defaults to the Let's Encrypt production directory
+	DirectoryURL string
+	// RenewBefore renews certificates this long before they expire, defaults to 30 days
+	RenewBefore time.Duration
+}
+
+// NewACMEManager creates a CertificateManager issuing certificates for the configured domains through
+// TLS-ALPN-01 challenges, accepting the terms of service of the CA
+func NewACMEManager(config *ACMEConfig) (*autocert.Manager, error) {
+	if config == nil || len(config.Domains) == 0 {
+		return nil, fmt.Errorf("%w: ACME requires at least one domain", ErrInvalidTLSConfig)
+	}
+	if config.CacheDir == "" {
+		return nil, fmt.Errorf("%w: ACME requires a cache directory", ErrInvalidTLSConfig)
+	}
+
+	manager := &autocert.Manager{
+		Prompt:      autocert.AcceptTOS,
+		Cache:       autocert.DirCache(config.CacheDir),
+		HostPolicy:  autocert.HostWhitelist(config.Domains...),
+		Email:       config.Email,
+		RenewBefore: config.RenewBefore,
+	}
+	if config.DirectoryURL != "" {
+		manager.Client = &acme.Client{DirectoryURL: config.DirectoryURL}
+	}
+	return manager, nil
+}
@@ -0,0 +1,304 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/axmq/ax/admin"
+)
+
+// Connection metadata keys describing the client behind a connection
+// The listener sets MetadataListener and MetadataTenant on accept, the others are set once CONNECT is accepted
+const (
+	MetadataClientID = "client_id"
+	MetadataUsername = "username"
+	MetadataTenant   = "tenant"
+	MetadataListener = "listener"
+)
+
+// ClientInfo identifies the client behind a connection for selectors and bans
+type ClientInfo struct {
+	ClientID string
+	Username string
+	Tenant   string
+	Listener string
+}
+
+// ConnectionClientInfo reads the client identity from connection metadata
+func ConnectionClientInfo(conn *Connection) ClientInfo {
+	get := func(key string) string {
+		v, _ := conn.GetMetadata(key)
+		s, _ := v.(string)
+		return s
+	}
+	return ClientInfo{
+		ClientID: get(MetadataClientID),
+		Username: get(MetadataUsername),
+		Tenant:   get(MetadataTenant),
+		Listener: get(MetadataListener),
+	}
+}
+
+// ClientSelector matches clients by path.Match glob patterns, empty fields match any client
+type ClientSelector struct {
+	ClientID string
+	Username string
+	Tenant   string
+	Listener string
+}
+
+// IsEmpty reports whether the selector has no criteria and would match every client
+func (s ClientSelector) IsEmpty() bool {
+	return s == ClientSelector{}
+}
+
+// Validate checks that the selector has criteria and that every pattern is well formed
+func (s ClientSelector) Validate() error {
+	if s.IsEmpty() {
+		return ErrEmptySelector
+	}
+	for _, pattern := range []string{s.ClientID, s.Username, s.Tenant, s.Listener} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%w: %q", ErrInvalidSelector, pattern)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether info passes every criterion of the selector
+func (s ClientSelector) Matches(info ClientInfo) bool {
+	return globMatch(s.ClientID, info.ClientID) &&
+		globMatch(s.Username, info.Username) &&
+		globMatch(s.Tenant, info.Tenant) &&
+		globMatch(s.Listener, info.Listener)
+}
+
+func globMatch(pattern, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(pattern, value)
+	return ok
+}
+
+type ban struct {
+	selector ClientSelector
+	until    time.Time
+}
+
+// BanList rejects reconnects of clients matching banned selectors until the ban lapses
+type BanList struct {
+	mu   sync.RWMutex
+	bans []ban
+	now  func() time.Time
+}
+
+func NewBanList() *BanList {
+	return &BanList{now: time.Now}
+}
+
+// Ban bans clients matching selector for d
+func (b *BanList) Ban(selector ClientSelector, d time.Duration) error {
+	if err := selector.Validate(); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pruneLocked()
+	b.bans = append(b.bans, ban{selector: selector, until: b.now().Add(d)})
+	return nil
+}
+
+// Unban lifts every ban with exactly this selector
+func (b *BanList) Unban(selector ClientSelector) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := 0
+	for _, entry := range b.bans {
+		if entry.selector != selector {
+			b.bans[n] = entry
+			n++
+		}
+	}
+	b.bans = b.bans[:n]
+}
+
+// Check returns ErrClientBanned when info matches an active ban, call it while accepting CONNECT
+func (b *BanList) Check(info ClientInfo) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	now := b.now()
+	for _, entry := range b.bans {
+		if now.Before(entry.until) && entry.selector.Matches(info) {
+			return ErrClientBanned
+		}
+	}
+	return nil
+}
+
+// Len returns the number of active bans
+func (b *BanList) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pruneLocked()
+	return len(b.bans)
+}
+
+func (b *BanList) pruneLocked() {
+	now := b.now()
+	n := 0
+	for _, entry := range b.bans {
+		if now.Before(entry.until) {
+			b.bans[n] = entry
+			n++
+		}
+	}
+	b.bans = b.bans[:n]
+}
+
+// BulkDisconnectRequest describes an administrative disconnect of every matching client
+type BulkDisconnectRequest struct {
+	Selector     ClientSelector
+	ReasonCode   DisconnectReason // DisconnectAdministrativeAction when zero
+	ReasonString string
+	BanDuration  time.Duration // also ban the selector for this long when positive
+}
+
+// BulkDisconnectResult reports the clients an administrative disconnect closed
+type BulkDisconnectResult struct {
+	Disconnected []string // client IDs, sorted
+	Failed       map[string]error
+}
+
+// Admin runs administrative operations against the connections of a pool
+type Admin struct {
+	pool  *Pool
+	dm    *DisconnectManager
+	bans  *BanList
+	guard *admin.Guard
+}
+
+func NewAdmin(pool *Pool, dm *DisconnectManager, bans *BanList) *Admin {
+	if bans == nil {
+		bans = NewBanList()
+	}
+	return &Admin{pool: pool, dm: dm, bans: bans}
+}
+
+// SetGuard makes the operations check the permissions of the principal carried by their context
+func (a *Admin) SetGuard(guard *admin.Guard) {
+	a.guard = guard
+}
+
+// Ban bans clients matching selector for d, it needs admin.PermissionClientsKick
+func (a *Admin) Ban(ctx context.Context, selector ClientSelector, d time.Duration) error {
+	if err := a.guard.Authorize(ctx, admin.PermissionClientsKick); err != nil {
+		return err
+	}
+	return a.bans.Ban(selector, d)
+}
+
+// Unban lifts every ban with exactly this selector, it needs admin.PermissionClientsKick
+func (a *Admin) Unban(ctx context.Context, selector ClientSelector) error {
+	if err := a.guard.Authorize(ctx, admin.PermissionClientsKick); err != nil {
+		return err
+	}
+	a.bans.Unban(selector)
+	return nil
+}
+
+// CheckBan returns ErrClientBanned when info matches an active ban, call it while accepting CONNECT
+func (a *Admin) CheckBan(info ClientInfo) error {
+	return a.bans.Check(info)
+}
+
+// BandwidthStats returns the traffic counters of every connected client keyed by client ID
+// Connections that have not sent CONNECT yet are keyed by connection ID. It needs admin.PermissionMetricsRead
+func (a *Admin) BandwidthStats(ctx context.Context) (map[string]BandwidthStats, error) {
+	if err := a.guard.Authorize(ctx, admin.PermissionMetricsRead); err != nil {
+		return nil, err
+	}
+	stats := make(map[string]BandwidthStats)
+	a.pool.ForEach(func(conn *Connection) bool {
+		key := ConnectionClientInfo(conn).ClientID
+		if key == "" {
+			key = conn.ID()
+		}
+		stats[key] = conn.BandwidthStats()
+		return true
+	})
+	return stats, nil
+}
+
+// DisconnectMatching sends DISCONNECT with the requested reason to every client matching the selector
+// and closes the connections, the selector is banned first so clients cannot reconnect in between
+// It needs admin.PermissionClientsKick
+func (a *Admin) DisconnectMatching(ctx context.Context, req *BulkDisconnectRequest) (*BulkDisconnectResult, error) {
+	if err := a.guard.Authorize(ctx, admin.PermissionClientsKick); err != nil {
+		return nil, err
+	}
+	if err := req.Selector.Validate(); err != nil {
+		return nil, err
+	}
+
+	if req.BanDuration > 0 {
+		if err := a.bans.Ban(req.Selector, req.BanDuration); err != nil {
+			return nil, err
+		}
+	}
+
+	reason := req.ReasonCode
+	if reason == DisconnectNormalDisconnection {
+		reason = DisconnectAdministrativeAction
+	}
+
+	var targets []*Connection
+	a.pool.ForEach(func(conn *Connection) bool {
+		if req.Selector.Matches(ConnectionClientInfo(conn)) {
+			targets = append(targets, conn)
+		}
+		return true
+	})
+
+	result := &BulkDisconnectResult{
+		Disconnected: make([]string, 0, len(targets)),
+		Failed:       make(map[string]error),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, conn := range targets {
+		wg.Add(1)
+		go func(c *Connection) {
+			defer wg.Done()
+
+			clientID := ConnectionClientInfo(c).ClientID
+			err := a.dm.disconnect(ctx, c, &DisconnectPacket{ReasonCode: reason, ReasonString: req.ReasonString})
+			if err == nil {
+				err = a.pool.Remove(c.ID())
+				if err == ErrConnectionNotFound {
+					err = nil
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				result.Failed[clientID] = err
+				return
+			}
+			result.Disconnected = append(result.Disconnected, clientID)
+		}(conn)
+	}
+	wg.Wait()
+
+	sort.Strings(result.Disconnected)
+	return result, ctx.Err()
+}
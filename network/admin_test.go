@@ -0,0 +1,165 @@
+package network
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/axmq/ax/admin"
+)
+
+func TestClientSelector(t *testing.T) {
+	info := ClientInfo{ClientID: "sensor-fw1.2-0042", Username: "fleet", Tenant: "acme", Listener: "tls"}
+
+	tests := []struct {
+		name     string
+		selector ClientSelector
+		want     bool
+	}{
+		{name: "client ID glob", selector: ClientSelector{ClientID: "sensor-fw1.2-*"}, want: true},
+		{name: "client ID mismatch", selector: ClientSelector{ClientID: "sensor-fw1.3-*"}, want: false},
+		{name: "all criteria", selector: ClientSelector{ClientID: "sensor-*", Username: "fleet", Tenant: "acme", Listener: "tls"}, want: true},
+		{name: "one criterion fails", selector: ClientSelector{Username: "fleet", Tenant: "other"}, want: false},
+		{name: "listener", selector: ClientSelector{Listener: "ws"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.selector.Matches(info))
+		})
+	}
+
+	assert.ErrorIs(t, ClientSelector{}.Validate(), ErrEmptySelector)
+	assert.ErrorIs(t, ClientSelector{ClientID: "a["}.Validate(), ErrInvalidSelector)
+	assert.NoError(t, ClientSelector{Tenant: "acme"}.Validate())
+}
+
+func TestBanList(t *testing.T) {
+	now := time.Now()
+	bans := NewBanList()
+	bans.now = func() time.Time { return now }
+
+	assert.ErrorIs(t, bans.Ban(ClientSelector{}, time.Minute), ErrEmptySelector)
+
+	selector := ClientSelector{ClientID: "bad-*"}
+	require.NoError(t, bans.Ban(selector, time.Minute))
+	require.NoError(t, bans.Ban(ClientSelector{Username: "mallory"}, time.Hour))
+	assert.Equal(t, 2, bans.Len())
+
+	assert.ErrorIs(t, bans.Check(ClientInfo{ClientID: "bad-1"}), ErrClientBanned)
+	assert.ErrorIs(t, bans.Check(ClientInfo{ClientID: "x", Username: "mallory"}), ErrClientBanned)
+	assert.NoError(t, bans.Check(ClientInfo{ClientID: "good-1"}))
+
+	now = now.Add(2 * time.Minute)
+	assert.NoError(t, bans.Check(ClientInfo{ClientID: "bad-1"}))
+	assert.Equal(t, 1, bans.Len())
+
+	bans.Unban(ClientSelector{Username: "mallory"})
+	assert.NoError(t, bans.Check(ClientInfo{Username: "mallory"}))
+	assert.Equal(t, 0, bans.Len())
+}
+
+func TestAdminDisconnectMatching(t *testing.T) {
+	pool, err := NewPool(&PoolConfig{MaxConnections: 10})
+	require.NoError(t, err)
+	defer pool.Close()
+
+	clients := map[string]string{"fw12-a": "acme", "fw12-b": "acme", "fw12-c": "other", "fw13-a": "acme"}
+	for id, tenant := range clients {
+		server, client := net.Pipe()
+		t.Cleanup(func() { _ = client.Close() })
+		conn := NewConnection(server, "conn-"+id, nil)
+		conn.SetMetadata(MetadataClientID, id)
+		conn.SetMetadata(MetadataTenant, tenant)
+		require.NoError(t, pool.Add(conn))
+	}
+
+	dm := NewDisconnectManager(time.Second)
+	var mu sync.Mutex
+	sent := make(map[string]*DisconnectPacket)
+	dm.OnDisconnect(func(conn *Connection, packet *DisconnectPacket) error {
+		mu.Lock()
+		defer mu.Unlock()
+		sent[ConnectionClientInfo(conn).ClientID] = packet
+		return nil
+	})
+
+	admin := NewAdmin(pool, dm, nil)
+
+	_, err = admin.DisconnectMatching(context.Background(), &BulkDisconnectRequest{})
+	assert.ErrorIs(t, err, ErrEmptySelector)
+
+	selector := ClientSelector{ClientID: "fw12-*", Tenant: "acme"}
+	result, err := admin.DisconnectMatching(context.Background(), &BulkDisconnectRequest{
+		Selector:     selector,
+		ReasonString: "revoked firmware",
+		BanDuration:  time.Hour,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"fw12-a", "fw12-b"}, result.Disconnected)
+	assert.Empty(t, result.Failed)
+
+	require.Len(t, sent, 2)
+	assert.Equal(t, DisconnectAdministrativeAction, sent["fw12-a"].ReasonCode)
+	assert.Equal(t, "revoked firmware", sent["fw12-a"].ReasonString)
+
+	_, ok := pool.Get("conn-fw12-a")
+	assert.False(t, ok)
+	_, ok = pool.Get("conn-fw12-c")
+	assert.True(t, ok)
+
+	assert.ErrorIs(t, admin.CheckBan(ClientInfo{ClientID: "fw12-z", Tenant: "acme"}), ErrClientBanned)
+	assert.NoError(t, admin.CheckBan(ClientInfo{ClientID: "fw12-z", Tenant: "other"}))
+
+	result, err = admin.DisconnectMatching(context.Background(), &BulkDisconnectRequest{
+		Selector:   ClientSelector{ClientID: "fw13-*"},
+		ReasonCode: DisconnectNotAuthorized,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"fw13-a"}, result.Disconnected)
+	assert.Equal(t, DisconnectNotAuthorized, sent["fw13-a"].ReasonCode)
+	assert.Equal(t, 1, admin.bans.Len())
+}
+
+func TestAdminDisconnectMatchingGuard(t *testing.T) {
+	pool, err := NewPool(&PoolConfig{MaxConnections: 10})
+	require.NoError(t, err)
+	defer pool.Close()
+
+	guard, err := admin.NewGuard(admin.GuardConfig{Authenticator: admin.Chain()})
+	require.NoError(t, err)
+	a := NewAdmin(pool, NewDisconnectManager(time.Second), nil)
+	a.SetGuard(guard)
+	req := &BulkDisconnectRequest{Selector: ClientSelector{ClientID: "fw12-*"}, BanDuration: time.Hour}
+
+	_, err = a.DisconnectMatching(context.Background(), req)
+	assert.ErrorIs(t, err, admin.ErrNoCredentials)
+	viewer := admin.WithPrincipal(context.Background(), &admin.Principal{Name: "grafana", Roles: []string{admin.RoleViewer}})
+	_, err = a.DisconnectMatching(viewer, req)
+	assert.ErrorIs(t, err, admin.ErrForbidden)
+	assert.Zero(t, a.bans.Len(), "a rejected call bans nobody")
+
+	assert.ErrorIs(t, a.Ban(viewer, req.Selector, time.Hour), admin.ErrForbidden)
+	assert.Zero(t, a.bans.Len())
+	_, err = a.BandwidthStats(context.Background())
+	assert.ErrorIs(t, err, admin.ErrNoCredentials)
+	_, err = a.BandwidthStats(viewer)
+	assert.NoError(t, err)
+
+	operator := admin.WithPrincipal(context.Background(), &admin.Principal{Name: "ops", Roles: []string{admin.RoleOperator}})
+	_, err = a.DisconnectMatching(operator, req)
+	require.NoError(t, err)
+	assert.Equal(t, 1, a.bans.Len())
+
+	assert.ErrorIs(t, a.Unban(viewer, req.Selector), admin.ErrForbidden)
+	assert.Equal(t, 1, a.bans.Len())
+	require.NoError(t, a.Unban(operator, req.Selector))
+	assert.Zero(t, a.bans.Len())
+	require.NoError(t, a.Ban(operator, ClientSelector{Username: "mallory"}, time.Hour))
+	assert.ErrorIs(t, a.CheckBan(ClientInfo{ClientID: "x", Username: "mallory"}), ErrClientBanned)
+}
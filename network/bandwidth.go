@@ -0,0 +1,287 @@
+package network
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type BandwidthPolicy byte
+
+const (
+	BandwidthPolicyNone BandwidthPolicy = iota
+	BandwidthPolicyThrottle
+	BandwidthPolicyDisconnect
+)
+
+func (p BandwidthPolicy) String() string {
+	switch p {
+	case BandwidthPolicyNone:
+		return "none"
+	case BandwidthPolicyThrottle:
+		return "throttle"
+	case BandwidthPolicyDisconnect:
+		return "disconnect"
+	default:
+		return "unknown"
+	}
+}
+
+type BandwidthDirection byte
+
+const (
+	BandwidthIn BandwidthDirection = iota
+	BandwidthOut
+)
+
+func (d BandwidthDirection) String() string {
+	if d == BandwidthIn {
+		return "in"
+	}
+	return "out"
+}
+
+type BandwidthEvent struct {
+	ConnectionID string
+	Direction    BandwidthDirection
+	Bytes        uint64
+	Limit        uint64
+	Window       time.Duration
+	Policy       BandwidthPolicy
+}
+
+// BandwidthConfig caps the bytes a connection may transfer per sliding window, zero limits are unlimited
+type BandwidthConfig struct {
+	Window      time.Duration
+	Buckets     int
+	MaxBytesIn  uint64
+	MaxBytesOut uint64
+	Policy      BandwidthPolicy
+	OnExceeded  func(BandwidthEvent)
+}
+
+// Validate checks that every bucket of the window spans at least a nanosecond and that the policy is known
+func (c *BandwidthConfig) Validate() error {
+	if c.Window < 0 || c.Buckets < 0 {
+		return fmt.Errorf("%w: negative window or buckets", ErrInvalidBandwidthConfig)
+	}
+	if c.Window > 0 && c.Buckets > 0 && c.Window < time.Duration(c.Buckets) {
+		return fmt.Errorf("%w: window %s is shorter than %d buckets", ErrInvalidBandwidthConfig, c.Window, c.Buckets)
+	}
+	if c.Policy > BandwidthPolicyDisconnect {
+		return fmt.Errorf("%w: unknown policy %d", ErrInvalidBandwidthConfig, c.Policy)
+	}
+	return nil
+}
+
+func DefaultBandwidthConfig() *BandwidthConfig {
+	return &BandwidthConfig{
+		Window:  time.Second,
+		Buckets: 10,
+		Policy:  BandwidthPolicyNone,
+	}
+}
+
+type BandwidthStats struct {
+	BytesIn        uint64
+	BytesOut       uint64
+	WindowBytesIn  uint64
+	WindowBytesOut uint64
+	Exceeded       uint64
+	Throttled      time.Duration
+}
+
+// bandwidthTotals sums the traffic of the connections sharing it, closed connections included
+type bandwidthTotals struct {
+	in        atomic.Uint64
+	out       atomic.Uint64
+	exceeded  atomic.Uint64
+	throttled atomic.Int64
+}
+
+func (t *bandwidthTotals) stats() BandwidthStats {
+	return BandwidthStats{
+		BytesIn:   t.in.Load(),
+		BytesOut:  t.out.Load(),
+		Exceeded:  t.exceeded.Load(),
+		Throttled: time.Duration(t.throttled.Load()),
+	}
+}
+
+// slidingWindow counts bytes in fixed buckets covering the last window
+type slidingWindow struct {
+	bucketSize time.Duration
+	counts     []uint64
+	starts     []int64
+}
+
+func newSlidingWindow(window time.Duration, buckets int) *slidingWindow {
+	return &slidingWindow{
+		bucketSize: window / time.Duration(buckets),
+		counts:     make([]uint64, buckets),
+		starts:     make([]int64, buckets),
+	}
+}
+
+func (w *slidingWindow) window() int64 {
+	return int64(w.bucketSize) * int64(len(w.counts))
+}
+
+func (w *slidingWindow) add(n uint64, now time.Time) {
+	start := now.UnixNano() - now.UnixNano()%int64(w.bucketSize)
+	i := int(start/int64(w.bucketSize)) % len(w.counts)
+	if w.starts[i] != start {
+		w.starts[i] = start
+		w.counts[i] = 0
+	}
+	w.counts[i] += n
+}
+
+func (w *slidingWindow) sum(now time.Time) uint64 {
+	cutoff := now.UnixNano() - w.window()
+	var total uint64
+	for i, start := range w.starts {
+		if start > cutoff {
+			total += w.counts[i]
+		}
+	}
+	return total
+}
+
+// waitFor returns how long until the bytes in the window drop to limit or below
+func (w *slidingWindow) waitFor(limit uint64, now time.Time) time.Duration {
+	cutoff := now.UnixNano() - w.window()
+	total := w.sum(now)
+	for total > limit {
+		oldest := -1
+		for i, start := range w.starts {
+			if start > cutoff && (oldest < 0 || start < w.starts[oldest]) {
+				oldest = i
+			}
+		}
+		if oldest < 0 {
+			break
+		}
+		total -= w.counts[oldest]
+		cutoff = w.starts[oldest]
+	}
+	if wait := time.Duration(cutoff + w.window() - now.UnixNano()); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// BandwidthLimiter accounts and caps the traffic of a single connection
+type BandwidthLimiter struct {
+	config *BandwidthConfig
+
+	mu  sync.Mutex
+	in  *slidingWindow
+	out *slidingWindow
+
+	exceeded  atomic.Uint64
+	throttled atomic.Int64
+	totals    *bandwidthTotals
+	now       func() time.Time
+}
+
+// NewBandwidthLimiter creates a limiter, windows shorter than their buckets are cut into nanosecond buckets
+// Listeners reject such configurations with Validate
+func NewBandwidthLimiter(config *BandwidthConfig) *BandwidthLimiter {
+	if config == nil {
+		config = DefaultBandwidthConfig()
+	}
+
+	window := config.Window
+	if window <= 0 {
+		window = time.Second
+	}
+	buckets := config.Buckets
+	if buckets <= 0 {
+		buckets = 10
+	}
+	if time.Duration(buckets) > window {
+		buckets = int(window)
+	}
+
+	return &BandwidthLimiter{
+		config: config,
+		in:     newSlidingWindow(window, buckets),
+		out:    newSlidingWindow(window, buckets),
+		now:    time.Now,
+	}
+}
+
+// record accounts n bytes and returns how long the connection should be throttled
+// and whether it must be disconnected
+func (l *BandwidthLimiter) record(c *Connection, dir BandwidthDirection, n int) (time.Duration, bool) {
+	w, limit := l.in, l.config.MaxBytesIn
+	if dir == BandwidthOut {
+		w, limit = l.out, l.config.MaxBytesOut
+	}
+
+	if l.totals != nil {
+		if dir == BandwidthOut {
+			l.totals.out.Add(uint64(n))
+		} else {
+			l.totals.in.Add(uint64(n))
+		}
+	}
+
+	now := l.now()
+	l.mu.Lock()
+	w.add(uint64(n), now)
+	total := w.sum(now)
+	var wait time.Duration
+	if limit > 0 && total > limit && l.config.Policy == BandwidthPolicyThrottle {
+		wait = w.waitFor(limit, now)
+	}
+	l.mu.Unlock()
+
+	if limit == 0 || total <= limit {
+		return 0, false
+	}
+
+	l.exceeded.Add(1)
+	if l.totals != nil {
+		l.totals.exceeded.Add(1)
+	}
+	if l.config.OnExceeded != nil {
+		l.config.OnExceeded(BandwidthEvent{
+			ConnectionID: c.ID(),
+			Direction:    dir,
+			Bytes:        total,
+			Limit:        limit,
+			Window:       w.bucketSize * time.Duration(len(w.counts)),
+			Policy:       l.config.Policy,
+		})
+	}
+
+	switch l.config.Policy {
+	case BandwidthPolicyThrottle:
+		l.throttled.Add(int64(wait))
+		if l.totals != nil {
+			l.totals.throttled.Add(int64(wait))
+		}
+		return wait, false
+	case BandwidthPolicyDisconnect:
+		return 0, true
+	default:
+		return 0, false
+	}
+}
+
+// Stats returns the window counters of the limiter, lifetime totals are filled in by Connection.BandwidthStats
+func (l *BandwidthLimiter) Stats() BandwidthStats {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return BandwidthStats{
+		WindowBytesIn:  l.in.sum(now),
+		WindowBytesOut: l.out.sum(now),
+		Exceeded:       l.exceeded.Load(),
+		Throttled:      time.Duration(l.throttled.Load()),
+	}
+}
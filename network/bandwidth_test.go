@@ -0,0 +1,261 @@
+package network
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBandwidthPolicyString(t *testing.T) {
+	assert.Equal(t, "none", BandwidthPolicyNone.String())
+	assert.Equal(t, "throttle", BandwidthPolicyThrottle.String())
+	assert.Equal(t, "disconnect", BandwidthPolicyDisconnect.String())
+	assert.Equal(t, "unknown", BandwidthPolicy(99).String())
+	assert.Equal(t, "in", BandwidthIn.String())
+	assert.Equal(t, "out", BandwidthOut.String())
+}
+
+func TestDefaultBandwidthConfig(t *testing.T) {
+	config := DefaultBandwidthConfig()
+	assert.Equal(t, time.Second, config.Window)
+	assert.Equal(t, 10, config.Buckets)
+	assert.Equal(t, BandwidthPolicyNone, config.Policy)
+}
+
+func TestSlidingWindow(t *testing.T) {
+	w := newSlidingWindow(time.Second, 10)
+	start := time.Unix(1000, 0)
+
+	w.add(100, start)
+	w.add(50, start.Add(150*time.Millisecond))
+	w.add(25, start.Add(550*time.Millisecond))
+	assert.Equal(t, uint64(175), w.sum(start.Add(600*time.Millisecond)))
+
+	// The first bucket leaves the window after one second
+	assert.Equal(t, uint64(75), w.sum(start.Add(time.Second)))
+	assert.Equal(t, uint64(25), w.sum(start.Add(1100*time.Millisecond)))
+	assert.Equal(t, uint64(0), w.sum(start.Add(2*time.Second)))
+}
+
+func TestSlidingWindowWaitFor(t *testing.T) {
+	w := newSlidingWindow(time.Second, 10)
+	start := time.Unix(1000, 0)
+	now := start.Add(500 * time.Millisecond)
+
+	w.add(100, start)
+	w.add(100, start.Add(200*time.Millisecond))
+	w.add(100, now)
+
+	assert.Equal(t, time.Duration(0), w.waitFor(300, now))
+	assert.Equal(t, 500*time.Millisecond, w.waitFor(200, now))
+	assert.Equal(t, 700*time.Millisecond, w.waitFor(100, now))
+	assert.Equal(t, time.Second, w.waitFor(0, now))
+}
+
+func TestBandwidthLimiterThrottle(t *testing.T) {
+	var events []BandwidthEvent
+	l := NewBandwidthLimiter(&BandwidthConfig{
+		Window:     time.Second,
+		Buckets:    10,
+		MaxBytesIn: 100,
+		Policy:     BandwidthPolicyThrottle,
+		OnExceeded: func(e BandwidthEvent) { events = append(events, e) },
+	})
+	now := time.Unix(1000, 0)
+	l.now = func() time.Time { return now }
+
+	server, client := net.Pipe()
+	defer client.Close()
+	conn := NewConnection(server, "c1", nil)
+
+	wait, disconnect := l.record(conn, BandwidthIn, 80)
+	assert.Zero(t, wait)
+	assert.False(t, disconnect)
+
+	now = now.Add(300 * time.Millisecond)
+	wait, disconnect = l.record(conn, BandwidthIn, 40)
+	assert.Equal(t, 700*time.Millisecond, wait)
+	assert.False(t, disconnect)
+
+	// Outbound traffic is not capped
+	wait, _ = l.record(conn, BandwidthOut, 1000)
+	assert.Zero(t, wait)
+
+	require.Len(t, events, 1)
+	assert.Equal(t, BandwidthEvent{ConnectionID: "c1", Direction: BandwidthIn, Bytes: 120, Limit: 100, Window: time.Second, Policy: BandwidthPolicyThrottle}, events[0])
+
+	stats := l.Stats()
+	assert.Equal(t, uint64(120), stats.WindowBytesIn)
+	assert.Equal(t, uint64(1000), stats.WindowBytesOut)
+	assert.Equal(t, uint64(1), stats.Exceeded)
+	assert.Equal(t, 700*time.Millisecond, stats.Throttled)
+}
+
+func TestConnectionBandwidthDisconnect(t *testing.T) {
+	var exceeded atomic.Int32
+	server, client := net.Pipe()
+	defer client.Close()
+
+	conn := NewConnection(server, "c1", &ConnectionConfig{})
+	conn.SetBandwidthLimiter(NewBandwidthLimiter(&BandwidthConfig{
+		Window:      time.Minute,
+		MaxBytesOut: 10,
+		Policy:      BandwidthPolicyDisconnect,
+		OnExceeded:  func(BandwidthEvent) { exceeded.Add(1) },
+	}))
+
+	go func() { _, _ = io.Copy(io.Discard, client) }()
+
+	_, err := conn.Write(make([]byte, 10))
+	require.NoError(t, err)
+
+	_, err = conn.Write(make([]byte, 1))
+	assert.ErrorIs(t, err, ErrBandwidthExceeded)
+	assert.Equal(t, int32(1), exceeded.Load())
+	assert.Equal(t, StateClosed, conn.State())
+
+	stats := conn.BandwidthStats()
+	assert.Equal(t, uint64(11), stats.BytesOut)
+	assert.Equal(t, uint64(1), stats.Exceeded)
+}
+
+func TestConnectionBandwidthThrottle(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	conn := NewConnection(server, "c1", &ConnectionConfig{})
+	conn.SetBandwidthLimiter(NewBandwidthLimiter(&BandwidthConfig{
+		Window:     100 * time.Millisecond,
+		Buckets:    10,
+		MaxBytesIn: 10,
+		Policy:     BandwidthPolicyThrottle,
+	}))
+
+	go func() { _, _ = client.Write(make([]byte, 20)) }()
+
+	start := time.Now()
+	buf := make([]byte, 20)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, 20, n)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	assert.Positive(t, conn.BandwidthStats().Throttled)
+}
+
+func TestConnectionBandwidthThrottleInterruptedByClose(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	conn := NewConnection(server, "c1", &ConnectionConfig{})
+	conn.SetBandwidthLimiter(NewBandwidthLimiter(&BandwidthConfig{
+		Window:     time.Hour,
+		MaxBytesIn: 1,
+		Policy:     BandwidthPolicyThrottle,
+	}))
+
+	go func() { _, _ = client.Write(make([]byte, 2)) }()
+	time.AfterFunc(20*time.Millisecond, func() { conn.Close() })
+
+	_, err := conn.Read(make([]byte, 2))
+	assert.ErrorIs(t, err, ErrConnectionClosed)
+}
+
+func TestConnectionBandwidthStatsWithoutLimiter(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	conn := NewConnection(server, "c1", &ConnectionConfig{})
+	go func() { _, _ = io.Copy(io.Discard, client) }()
+
+	_, err := conn.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	stats := conn.BandwidthStats()
+	assert.Equal(t, uint64(5), stats.BytesOut)
+	assert.Zero(t, stats.WindowBytesOut)
+}
+
+func TestListenerBandwidthStats(t *testing.T) {
+	config := &ListenerConfig{
+		Address:      "127.0.0.1:0",
+		TCPKeepAlive: 10 * time.Second,
+		Bandwidth:    &BandwidthConfig{Window: time.Second, MaxBytesIn: 1 << 20, Policy: BandwidthPolicyThrottle},
+	}
+
+	listener, err := NewListener(config, nil)
+	require.NoError(t, err)
+
+	received := make(chan struct{})
+	done := make(chan struct{})
+	listener.OnConnection(func(conn *Connection) error {
+		buf := make([]byte, 5)
+		_, err := io.ReadFull(conn, buf)
+		close(received)
+		<-done
+		return err
+	})
+
+	require.NoError(t, listener.Start())
+	defer listener.Close()
+	defer close(done)
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, err = client.Write([]byte("hello"))
+	require.NoError(t, err)
+	<-received
+
+	stats := listener.BandwidthStats()
+	require.Len(t, stats, 1)
+	for _, s := range stats {
+		assert.Equal(t, uint64(5), s.BytesIn)
+		assert.Equal(t, uint64(5), s.WindowBytesIn)
+	}
+	assert.Equal(t, uint64(5), listener.Stats().Bandwidth.BytesIn)
+
+	admin := NewAdmin(listener.pool, nil, nil)
+	adminStats, err := admin.BandwidthStats(context.Background())
+	require.NoError(t, err)
+	require.Len(t, adminStats, 1)
+	for _, s := range adminStats {
+		assert.Equal(t, uint64(5), s.BytesIn)
+	}
+}
+
+func TestBandwidthConfigValidate(t *testing.T) {
+	assert.NoError(t, DefaultBandwidthConfig().Validate())
+	assert.NoError(t, (&BandwidthConfig{}).Validate())
+	assert.NoError(t, (&BandwidthConfig{Window: 10, Buckets: 10}).Validate())
+	assert.ErrorIs(t, (&BandwidthConfig{Window: 5, Buckets: 10}).Validate(), ErrInvalidBandwidthConfig)
+	assert.ErrorIs(t, (&BandwidthConfig{Window: -time.Second}).Validate(), ErrInvalidBandwidthConfig)
+	assert.ErrorIs(t, (&BandwidthConfig{Buckets: -1}).Validate(), ErrInvalidBandwidthConfig)
+	assert.ErrorIs(t, (&BandwidthConfig{Policy: 99}).Validate(), ErrInvalidBandwidthConfig)
+
+	_, err := NewListener(&ListenerConfig{
+		Address:   "127.0.0.1:0",
+		Bandwidth: &BandwidthConfig{Window: 5, Buckets: 10},
+	}, nil)
+	assert.ErrorIs(t, err, ErrInvalidBandwidthConfig)
+}
+
+func TestBandwidthLimiterShortWindow(t *testing.T) {
+	// A window shorter than its buckets must not divide by zero
+	l := NewBandwidthLimiter(&BandwidthConfig{Window: 5, Buckets: 10, MaxBytesIn: 10})
+	assert.Equal(t, time.Duration(1), l.in.bucketSize)
+	assert.Len(t, l.in.counts, 5)
+
+	server, client := net.Pipe()
+	defer client.Close()
+	conn := NewConnection(server, "c1", nil)
+	wait, disconnect := l.record(conn, BandwidthIn, 20)
+	assert.Zero(t, wait)
+	assert.False(t, disconnect)
+}
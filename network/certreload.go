@@ -0,0 +1,123 @@
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CertReloader serves a certificate and key pair that can be replaced while the listener runs
+// Handshakes pick up the new certificate immediately, established connections keep the one they negotiated
+type CertReloader struct {
+	mu       sync.Mutex
+	certFile string
+	keyFile  string
+	modTime  time.Time
+
+	cert atomic.Pointer[tls.Certificate]
+}
+
+// NewCertReloader loads the certificate and key pair, an error is returned when it cannot be loaded
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// SetFiles changes the files read by the next Reload
+func (r *CertReloader) SetFiles(certFile, keyFile string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.certFile, r.keyFile = certFile, keyFile
+	r.modTime = time.Time{}
+}
+
+// Reload reads the certificate and key pair again
+// On failure the previous certificate stays in use, so a half-written renewal never breaks the listener
+func (r *CertReloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reloadLocked()
+}
+
+func (r *CertReloader) reloadLocked() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+	r.cert.Store(&cert)
+	r.modTime = modTime
+	return nil
+}
+
+// latestModTime returns the newer modification time of the certificate and key files
+func (r *CertReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to load certificate: %w", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// reloadIfChanged reloads when either file changed since the last successful load
+func (r *CertReloader) reloadIfChanged() (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return false, err
+	}
+	if modTime.Equal(r.modTime) {
+		return false, nil
+	}
+	if err := r.reloadLocked(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Watch polls the files every interval and reloads them when they change, until ctx is done
+// onReload, when set, is called after every reload attempt triggered by a change
+func (r *CertReloader) Watch(ctx context.Context, interval time.Duration, onReload func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := r.reloadIfChanged()
+			if (changed || err != nil) && onReload != nil {
+				onReload(err)
+			}
+		}
+	}
+}
+
+// Certificate returns the certificate currently served
+func (r *CertReloader) Certificate() *tls.Certificate {
+	return r.cert.Load()
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
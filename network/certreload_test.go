@@ -0,0 +1,166 @@
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewCertReloaderMissingFile(t *testing.T) {
+	dir := t.TempDir()
+	_, err := NewCertReloader(filepath.Join(dir, "missing.crt"), filepath.Join(dir, "missing.key"))
+	assert.Error(t, err)
+}
+
+func TestCertReloaderReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestKeyPair(t, dir, "server")
+
+	r, err := NewCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+	first := r.Certificate()
+	require.NotNil(t, first)
+
+	writeTestKeyPair(t, dir, "server")
+	require.NoError(t, r.Reload())
+	second, err := r.GetCertificate(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	assert.NotEqual(t, first.Certificate[0], second.Certificate[0])
+}
+
+func TestCertReloaderReloadKeepsOldCertificateOnError(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestKeyPair(t, dir, "server")
+
+	r, err := NewCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+	before := r.Certificate()
+
+	require.NoError(t, os.WriteFile(certFile, []byte("not a certificate"), 0o644))
+	assert.Error(t, r.Reload())
+	assert.Same(t, before, r.Certificate())
+}
+
+func TestCertReloaderSetFiles(t *testing.T) {
+	dir := t.TempDir()
+	aCert, aKey := writeTestKeyPair(t, dir, "a")
+	bCert, bKey := writeTestKeyPair(t, dir, "b")
+
+	r, err := NewCertReloader(aCert, aKey)
+	require.NoError(t, err)
+	before := r.Certificate()
+
+	r.SetFiles(bCert, bKey)
+	require.NoError(t, r.Reload())
+	assert.NotEqual(t, before.Certificate[0], r.Certificate().Certificate[0])
+}
+
+func TestCertReloaderWatch(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestKeyPair(t, dir, "server")
+
+	r, err := NewCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+	before := r.Certificate()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloaded := make(chan error, 1)
+	go r.Watch(ctx, 10*time.Millisecond, func(err error) {
+		select {
+		case reloaded <- err:
+		default:
+		}
+	})
+
+	writeTestKeyPair(t, dir, "server")
+	future := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(certFile, future, future))
+
+	select {
+	case err := <-reloaded:
+		require.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("certificate change was not detected")
+	}
+	assert.NotEqual(t, before.Certificate[0], r.Certificate().Certificate[0])
+}
+
+func TestCertReloaderHandshakeAfterReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestKeyPair(t, dir, "server")
+
+	r, err := NewCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+	config, err := (&TLSConfig{CertReloader: r, MinVersion: tls.VersionTLS12}).Build()
+	require.NoError(t, err)
+	assert.Empty(t, config.Certificates)
+
+	writeTestKeyPair(t, dir, "server")
+	require.NoError(t, r.Reload())
+
+	cert, err := config.GetCertificate(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	assert.Same(t, r.Certificate(), cert)
+}
+
+type staticCertificateManager struct {
+	cert *tls.Certificate
+}
+
+func (m *staticCertificateManager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return m.cert, nil
+}
+
+func TestTLSConfigBuildWithACME(t *testing.T) {
+	acme := &staticCertificateManager{cert: &tls.Certificate{}}
+	config, err := (&TLSConfig{ACME: acme, NextProtos: []string{"mqtt"}}).Build()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"mqtt", ACMETLSALPNProto}, config.NextProtos)
+
+	cert, err := config.GetCertificate(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	assert.Same(t, acme.cert, cert)
+}
+
+func TestNewACMEManager(t *testing.T) {
+	_, err := NewACMEManager(&ACMEConfig{CacheDir: t.TempDir()})
+	assert.ErrorIs(t, err, ErrInvalidTLSConfig)
+	_, err = NewACMEManager(&ACMEConfig{Domains: []string{"mqtt.example.com"}})
+	assert.ErrorIs(t, err, ErrInvalidTLSConfig)
+
+	manager, err := NewACMEManager(&ACMEConfig{
+		Domains:      []string{"mqtt.example.com"},
+		CacheDir:     t.TempDir(),
+		DirectoryURL: "https://acme.invalid/directory",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "https://acme.invalid/directory", manager.Client.DirectoryURL)
+
+	// Names outside the domain list are refused before the CA is contacted
+	_, err = manager.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.example.com"})
+	assert.Error(t, err)
+}
+
+func TestTLSConfigBuildSNIFallsBackToReloader(t *testing.T) {
+	dir := t.TempDir()
+	aCert, aKey := writeTestKeyPair(t, dir, "a")
+	router, err := NewSNIRouter([]SNIRoute{{ServerName: "iot.customerA.com", CertFile: aCert, KeyFile: aKey}})
+	require.NoError(t, err)
+	certFile, keyFile := writeTestKeyPair(t, dir, "default")
+	r, err := NewCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+
+	config, err := (&TLSConfig{SNIRouter: router, CertReloader: r}).Build()
+	require.NoError(t, err)
+
+	cert, err := config.GetCertificate(&tls.ClientHelloInfo{ServerName: "unrouted.invalid"})
+	require.NoError(t, err)
+	assert.Same(t, r.Certificate(), cert)
+}
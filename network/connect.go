@@ -0,0 +1,90 @@
+package network
+
+import (
+	"context"
+	"errors"
+
+	"github.com/axmq/ax/encoding"
+)
+
+// CheckPacket enforces the CONNECT ordering rules for a packet read from the connection
+// The first packet must be CONNECT (ErrPacketBeforeConnect) and a client may send CONNECT only once
+// (ErrDuplicateConnect). Receiving the CONNECT stops the connect timeout
+func (c *Connection) CheckPacket(packetType encoding.PacketType) error {
+	if c.State() != StateConnected {
+		return ErrConnectionClosed
+	}
+
+	if packetType == encoding.CONNECT {
+		if !c.connectReceived.CompareAndSwap(false, true) {
+			return ErrDuplicateConnect
+		}
+		c.stopConnectTimer()
+		if c.connectExpired.Load() {
+			return ErrConnectTimeout
+		}
+		return nil
+	}
+
+	if !c.connectReceived.Load() {
+		return ErrPacketBeforeConnect
+	}
+	return nil
+}
+
+// AcceptConnect records the client ID and username presented in CONNECT as connection metadata, so selectors
+// of bulk disconnects match the connection. Call it once CheckPacket accepted the CONNECT and before CONNACK,
+// a client matching a ban of bans is rejected with ErrClientBanned and its identity is not recorded
+func (c *Connection) AcceptConnect(clientID, username string, bans *BanList) error {
+	info := ConnectionClientInfo(c)
+	info.ClientID = clientID
+	info.Username = username
+	if bans != nil {
+		if err := bans.Check(info); err != nil {
+			return err
+		}
+	}
+
+	c.SetMetadata(MetadataClientID, clientID)
+	if username != "" {
+		c.SetMetadata(MetadataUsername, username)
+	}
+	return nil
+}
+
+// ConnectReceived reports whether the client sent its CONNECT
+func (c *Connection) ConnectReceived() bool {
+	return c.connectReceived.Load()
+}
+
+// ConnectTimedOut reports whether the connection was closed for not sending CONNECT in time
+func (c *Connection) ConnectTimedOut() bool {
+	return c.connectExpired.Load()
+}
+
+func (c *Connection) expireConnect() {
+	if c.connectReceived.Load() {
+		return
+	}
+	c.connectExpired.Store(true)
+	_ = c.Close()
+}
+
+func (c *Connection) stopConnectTimer() {
+	if timer := c.connectTimer.Load(); timer != nil {
+		timer.Stop()
+	}
+}
+
+// RejectPacket closes conn after CheckPacket failed with err
+// A duplicate CONNECT is answered with DISCONNECT (Protocol Error) first. Before CONNECT was accepted
+// the server must not send DISCONNECT, so the connection is closed immediately
+func (dm *DisconnectManager) RejectPacket(ctx context.Context, conn *Connection, err error) error {
+	if errors.Is(err, ErrDuplicateConnect) {
+		return dm.disconnect(ctx, conn, &DisconnectPacket{
+			ReasonCode:   DisconnectProtocolError,
+			ReasonString: err.Error(),
+		})
+	}
+	return conn.Close()
+}
@@ -0,0 +1,124 @@
+package network
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectionCheckPacket(t *testing.T) {
+	conn, server, client := createTestConnection(t)
+	defer server.Close()
+	defer client.Close()
+
+	assert.ErrorIs(t, conn.CheckPacket(encoding.PUBLISH), ErrPacketBeforeConnect)
+	assert.False(t, conn.ConnectReceived())
+
+	require.NoError(t, conn.CheckPacket(encoding.CONNECT))
+	assert.True(t, conn.ConnectReceived())
+	assert.NoError(t, conn.CheckPacket(encoding.PUBLISH))
+	assert.NoError(t, conn.CheckPacket(encoding.PINGREQ))
+
+	for i := 0; i < 3; i++ {
+		assert.ErrorIs(t, conn.CheckPacket(encoding.CONNECT), ErrDuplicateConnect)
+	}
+
+	require.NoError(t, conn.Close())
+	assert.ErrorIs(t, conn.CheckPacket(encoding.PUBLISH), ErrConnectionClosed)
+}
+
+func TestConnectionAcceptConnect(t *testing.T) {
+	conn, server, client := createTestConnection(t)
+	defer server.Close()
+	defer client.Close()
+	conn.SetMetadata(MetadataTenant, "acme")
+
+	bans := NewBanList()
+	require.NoError(t, bans.Ban(ClientSelector{ClientID: "bad-*", Tenant: "acme"}, time.Minute))
+
+	assert.ErrorIs(t, conn.AcceptConnect("bad-1", "alice", bans), ErrClientBanned)
+	assert.Empty(t, ConnectionClientInfo(conn).ClientID)
+
+	require.NoError(t, conn.AcceptConnect("good-1", "alice", bans))
+	assert.Equal(t, ClientInfo{ClientID: "good-1", Username: "alice", Tenant: "acme"}, ConnectionClientInfo(conn))
+	assert.True(t, ClientSelector{ClientID: "good-*"}.Matches(ConnectionClientInfo(conn)))
+}
+
+func TestConnectionConnectTimeout(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	conn := NewConnection(server, "test-conn", &ConnectionConfig{ConnectTimeout: 20 * time.Millisecond})
+
+	select {
+	case <-conn.CloseChan():
+	case <-time.After(time.Second):
+		t.Fatal("connection was not closed after the connect timeout")
+	}
+	assert.True(t, conn.ConnectTimedOut())
+	assert.Equal(t, StateClosed, conn.State())
+}
+
+func TestConnectionConnectTimeoutStoppedByConnect(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	conn := NewConnection(server, "test-conn", &ConnectionConfig{ConnectTimeout: 20 * time.Millisecond})
+	defer conn.Close()
+	require.NoError(t, conn.CheckPacket(encoding.CONNECT))
+
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, conn.ConnectTimedOut())
+	assert.Equal(t, StateConnected, conn.State())
+}
+
+func TestDisconnectManagerRejectPacket(t *testing.T) {
+	t.Run("duplicate connect sends protocol error", func(t *testing.T) {
+		dm := NewDisconnectManager(100 * time.Millisecond)
+
+		var received *DisconnectPacket
+		dm.OnDisconnect(func(conn *Connection, packet *DisconnectPacket) error {
+			received = packet
+			return nil
+		})
+
+		conn, server, client := createTestConnection(t)
+		defer server.Close()
+		defer client.Close()
+
+		require.NoError(t, conn.CheckPacket(encoding.CONNECT))
+		err := conn.CheckPacket(encoding.CONNECT)
+		require.ErrorIs(t, err, ErrDuplicateConnect)
+
+		require.NoError(t, dm.RejectPacket(context.Background(), conn, err))
+		require.NotNil(t, received)
+		assert.Equal(t, DisconnectProtocolError, received.ReasonCode)
+		assert.Equal(t, StateClosed, conn.State())
+	})
+
+	t.Run("packet before connect closes without disconnect", func(t *testing.T) {
+		dm := NewDisconnectManager(100 * time.Millisecond)
+
+		called := false
+		dm.OnDisconnect(func(conn *Connection, packet *DisconnectPacket) error {
+			called = true
+			return nil
+		})
+
+		conn, server, client := createTestConnection(t)
+		defer server.Close()
+		defer client.Close()
+
+		err := conn.CheckPacket(encoding.SUBSCRIBE)
+		require.ErrorIs(t, err, ErrPacketBeforeConnect)
+
+		require.NoError(t, dm.RejectPacket(context.Background(), conn, err))
+		assert.False(t, called)
+		assert.Equal(t, StateClosed, conn.State())
+	})
+}
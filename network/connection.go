@@ -7,6 +7,8 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/axmq/ax/encoding"
 )
 
 type ConnectionState int32
@@ -38,6 +40,20 @@ type Connection struct {
 
 	bytesRead    atomic.Uint64
 	bytesWritten atomic.Uint64
+
+	writesPending atomic.Int32
+	writingSince  atomic.Int64 // unix nanoseconds since writes have been pending without a break
+
+	handshakeOnce    sync.Once
+	handshakeRelease func()
+
+	bandwidth atomic.Pointer[BandwidthLimiter]
+
+	connectReceived atomic.Bool
+	connectTimer    atomic.Pointer[time.Timer]
+	connectExpired  atomic.Bool
+
+	parser *encoding.Parser
 }
 
 type ConnectionConfig struct {
@@ -45,6 +61,10 @@ type ConnectionConfig struct {
 	ReadDeadline  time.Duration
 	WriteDeadline time.Duration
 	TLSConfig     *tls.Config
+	// ConnectTimeout closes connections that send no CONNECT within this time of being accepted, zero disables it
+	ConnectTimeout time.Duration
+	// Parser parses the packets of the connection, nil enforces the default property limits
+	Parser *encoding.Parser
 }
 
 func NewConnection(conn net.Conn, id string, cfg *ConnectionConfig) *Connection {
@@ -64,12 +84,20 @@ func NewConnection(conn net.Conn, id string, cfg *ConnectionConfig) *Connection
 		writeDeadline: cfg.WriteDeadline,
 		metadata:      make(map[string]interface{}),
 		closeCh:       make(chan struct{}),
+		parser:        cfg.Parser,
+	}
+	if c.parser == nil {
+		c.parser = encoding.NewParser(encoding.DefaultPropertyLimits())
 	}
 
 	c.state.Store(int32(StateConnected))
 	c.updateActivity()
 
-	if tlsConn, ok := conn.(*tls.Conn); ok {
+	if cfg.ConnectTimeout > 0 {
+		c.connectTimer.Store(time.AfterFunc(cfg.ConnectTimeout, c.expireConnect))
+	}
+
+	if tlsConn, ok := unwrapTLS(conn); ok {
 		c.tlsConn = tlsConn
 		c.isTLS = true
 	}
@@ -117,6 +145,9 @@ func (c *Connection) Read(b []byte) (int, error) {
 	if n > 0 {
 		c.bytesRead.Add(uint64(n))
 		c.updateActivity()
+		if berr := c.limitBandwidth(BandwidthIn, n); berr != nil {
+			return n, berr
+		}
 	}
 
 	return n, err
@@ -131,26 +162,104 @@ func (c *Connection) Write(b []byte) (int, error) {
 		_ = c.conn.SetWriteDeadline(time.Now().Add(c.writeDeadline))
 	}
 
+	if c.writesPending.Add(1) == 1 {
+		c.writingSince.Store(time.Now().UnixNano())
+	}
 	n, err := c.conn.Write(b)
+	if c.writesPending.Add(-1) == 0 {
+		c.writingSince.Store(0)
+	}
 	if n > 0 {
 		c.bytesWritten.Add(uint64(n))
 		c.updateActivity()
+		if berr := c.limitBandwidth(BandwidthOut, n); berr != nil {
+			return n, berr
+		}
 	}
 
 	return n, err
 }
 
+// Parser returns the parser enforcing the packet limits of the connection
+func (c *Connection) Parser() *encoding.Parser {
+	return c.parser
+}
+
+// WritePacket encodes p into a pooled buffer and writes it with a single Write
+func (c *Connection) WritePacket(p encoding.Packet) error {
+	buf, err := encoding.EncodePooled(p)
+	if err != nil {
+		return err
+	}
+	defer buf.Release()
+
+	_, err = c.Write(buf.Bytes())
+	return err
+}
+
 func (c *Connection) Close() error {
 	var err error
 	c.closeOnce.Do(func() {
+		c.stopConnectTimer()
 		c.state.Store(int32(StateClosing))
 		close(c.closeCh)
 		err = c.conn.Close()
 		c.state.Store(int32(StateClosed))
 	})
+	c.CompleteHandshake()
 	return err
 }
 
+// CompleteHandshake releases the accept pacing slot held by the connection once CONNECT has been processed
+func (c *Connection) CompleteHandshake() {
+	c.handshakeOnce.Do(func() {
+		if c.handshakeRelease != nil {
+			c.handshakeRelease()
+		}
+	})
+}
+
+// SetBandwidthLimiter caps the traffic of the connection, nil removes the cap
+func (c *Connection) SetBandwidthLimiter(l *BandwidthLimiter) {
+	c.bandwidth.Store(l)
+}
+
+func (c *Connection) BandwidthStats() BandwidthStats {
+	var stats BandwidthStats
+	if l := c.bandwidth.Load(); l != nil {
+		stats = l.Stats()
+	}
+	stats.BytesIn = c.bytesRead.Load()
+	stats.BytesOut = c.bytesWritten.Load()
+	return stats
+}
+
+// limitBandwidth accounts n transferred bytes and throttles or closes the connection when over its cap
+func (c *Connection) limitBandwidth(dir BandwidthDirection, n int) error {
+	l := c.bandwidth.Load()
+	if l == nil {
+		return nil
+	}
+
+	wait, disconnect := l.record(c, dir, n)
+	if disconnect {
+		c.Close()
+		return ErrBandwidthExceeded
+	}
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-c.closeCh:
+		return ErrConnectionClosed
+	}
+}
+
 func (c *Connection) CloseChan() <-chan struct{} {
 	return c.closeCh
 }
@@ -167,6 +276,16 @@ func (c *Connection) IdleDuration() time.Duration {
 	return time.Since(c.LastActivity())
 }
 
+// WriteBacklog returns the number of writes blocked on the connection and how long it has been writing
+// without a break, a peer that stopped reading keeps both growing
+func (c *Connection) WriteBacklog() (pending int, stalled time.Duration) {
+	pending = int(c.writesPending.Load())
+	if since := c.writingSince.Load(); pending > 0 && since > 0 {
+		stalled = time.Since(time.Unix(0, since))
+	}
+	return pending, stalled
+}
+
 func (c *Connection) BytesRead() uint64 {
 	return c.bytesRead.Load()
 }
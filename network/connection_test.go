@@ -1,10 +1,12 @@
 package network
 
 import (
+	"io"
 	"net"
 	"testing"
 	"time"
 
+	"github.com/axmq/ax/encoding"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -177,6 +179,23 @@ func TestConnectionWriteAfterClose(t *testing.T) {
 	assert.Equal(t, ErrConnectionClosed, err)
 }
 
+func TestConnectionWritePacket(t *testing.T) {
+	conn, _, client := createTestConnection(t)
+	defer conn.Close()
+	defer client.Close()
+
+	pkt := &encoding.PublishPacket311{FixedHeader: encoding.FixedHeader{Type: encoding.PUBLISH}, TopicName: "a/b", Payload: []byte("hi")}
+	done := make(chan error, 1)
+	go func() { done <- conn.WritePacket(pkt) }()
+
+	buf := make([]byte, pkt.Size())
+	_, err := io.ReadFull(client, buf)
+	require.NoError(t, err)
+	require.NoError(t, <-done)
+	assert.Equal(t, []byte{0x30, 7, 0, 3, 'a', '/', 'b', 'h', 'i'}, buf)
+	assert.Equal(t, uint64(len(buf)), conn.BytesWritten())
+}
+
 func TestConnectionBytesRead(t *testing.T) {
 	conn, _, client := createTestConnection(t)
 	defer conn.Close()
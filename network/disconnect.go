@@ -4,6 +4,8 @@ import (
 	"context"
 	"sync"
 	"time"
+
+	"github.com/axmq/ax/encoding"
 )
 
 type DisconnectReason byte
@@ -39,6 +41,7 @@ type DisconnectHandler func(*Connection, *DisconnectPacket) error
 type DisconnectManager struct {
 	mu              sync.RWMutex
 	handlers        []DisconnectHandler
+	reasons         encoding.ErrorReasons
 	gracefulTimeout time.Duration
 }
 
@@ -59,6 +62,32 @@ func (dm *DisconnectManager) OnDisconnect(handler DisconnectHandler) {
 	dm.mu.Unlock()
 }
 
+// SetErrorReasons sets the table DisconnectError maps errors with, without one only codec errors are mapped
+func (dm *DisconnectManager) SetErrorReasons(reasons encoding.ErrorReasons) {
+	dm.mu.Lock()
+	dm.reasons = reasons
+	dm.mu.Unlock()
+}
+
+// ErrorReason returns the DISCONNECT reason code for err, codes a DISCONNECT cannot carry are reported as
+// DisconnectUnspecifiedError
+func (dm *DisconnectManager) ErrorReason(err error) DisconnectReason {
+	dm.mu.RLock()
+	reasons := dm.reasons
+	dm.mu.RUnlock()
+
+	code := reasons.FromError(err)
+	if !code.IsError() || !code.IsValidFor(encoding.DISCONNECT) {
+		return DisconnectUnspecifiedError
+	}
+	return DisconnectReason(code)
+}
+
+// DisconnectError disconnects conn with the reason code err maps to
+func (dm *DisconnectManager) DisconnectError(ctx context.Context, conn *Connection, err error) error {
+	return dm.GracefulDisconnect(ctx, conn, dm.ErrorReason(err))
+}
+
 func (dm *DisconnectManager) HandleDisconnect(conn *Connection, packet *DisconnectPacket) error {
 	dm.mu.RLock()
 	handlers := make([]DisconnectHandler, len(dm.handlers))
@@ -75,10 +104,13 @@ func (dm *DisconnectManager) HandleDisconnect(conn *Connection, packet *Disconne
 }
 
 func (dm *DisconnectManager) GracefulDisconnect(ctx context.Context, conn *Connection, reason DisconnectReason) error {
-	packet := &DisconnectPacket{
+	return dm.disconnect(ctx, conn, &DisconnectPacket{
 		ReasonCode: reason,
-	}
+	})
+}
 
+// disconnect runs the disconnect handlers for packet and closes conn, closing it anyway after the graceful timeout
+func (dm *DisconnectManager) disconnect(ctx context.Context, conn *Connection, packet *DisconnectPacket) error {
 	timeoutCtx, cancel := context.WithTimeout(ctx, dm.gracefulTimeout)
 	defer cancel()
 
@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/axmq/ax/encoding"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -136,6 +137,36 @@ func TestDisconnectManagerGracefulDisconnect(t *testing.T) {
 	assert.Equal(t, StateClosed, conn.State())
 }
 
+func TestDisconnectManagerDisconnectError(t *testing.T) {
+	dm := NewDisconnectManager(100 * time.Millisecond)
+
+	assert.Equal(t, DisconnectUnspecifiedError, dm.ErrorReason(ErrKeepAliveTimeout), "no table")
+	assert.Equal(t, DisconnectMalformedPacket, dm.ErrorReason(encoding.ErrMalformedPacket))
+
+	dm.SetErrorReasons(encoding.ErrorReasons{
+		{Err: ErrKeepAliveTimeout, Code: encoding.ReasonKeepAliveTimeout},
+		{Err: ErrDuplicateConnect, Code: encoding.ReasonProtocolError},
+		{Err: ErrClientBanned, Code: encoding.ReasonBanned},
+	})
+	assert.Equal(t, DisconnectKeepAliveTimeout, dm.ErrorReason(fmt.Errorf("conn 1: %w", ErrKeepAliveTimeout)))
+	assert.Equal(t, DisconnectProtocolError, dm.ErrorReason(ErrDuplicateConnect))
+	assert.Equal(t, DisconnectUnspecifiedError, dm.ErrorReason(ErrClientBanned), "CONNACK only code")
+
+	var got DisconnectReason
+	dm.OnDisconnect(func(c *Connection, p *DisconnectPacket) error {
+		got = p.ReasonCode
+		return nil
+	})
+
+	server, client := net.Pipe()
+	defer client.Close()
+
+	conn := NewConnection(server, "test-conn", nil)
+	require.NoError(t, dm.DisconnectError(context.Background(), conn, ErrKeepAliveTimeout))
+	assert.Equal(t, DisconnectKeepAliveTimeout, got)
+	assert.Equal(t, StateClosed, conn.State())
+}
+
 func TestDisconnectManagerGracefulDisconnectTimeout(t *testing.T) {
 	dm := NewDisconnectManager(1 * time.Millisecond)
 
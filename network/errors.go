@@ -1,6 +1,8 @@
 package network
 
-import "errors"
+import (
+	"errors"
+)
 
 var (
 	ErrConnectionClosed        = errors.New("connection closed")
@@ -16,4 +18,24 @@ var (
 	ErrPoolClosed              = errors.New("pool closed")
 	ErrCertificateVerification = errors.New("certificate verification failed")
 	ErrGracefulShutdownTimeout = errors.New("graceful shutdown timeout")
+	ErrServerBusy              = errors.New("server busy")
+	ErrCertificateRevoked      = errors.New("certificate revoked")
+	ErrRevocationUnknown       = errors.New("certificate revocation status unknown")
+	ErrNoOCSPServer            = errors.New("certificate names no OCSP responder")
+	ErrInvalidOCSPResponse     = errors.New("invalid OCSP response")
+	ErrBandwidthExceeded       = errors.New("bandwidth limit exceeded")
+	ErrInvalidBandwidthConfig  = errors.New("invalid bandwidth configuration")
+	ErrEmptySelector           = errors.New("client selector has no criteria")
+	ErrInvalidSelector         = errors.New("invalid client selector pattern")
+	ErrClientBanned            = errors.New("client banned")
+	ErrConnectionRateExceeded  = errors.New("connection rate exceeded")
+	ErrAuthThrottled           = errors.New("too many failed authentications")
+	ErrInvalidProxyHeader      = errors.New("invalid PROXY protocol header")
+	ErrUntrustedProxy          = errors.New("PROXY protocol header from untrusted peer")
+	ErrDuplicateStage          = errors.New("duplicate middleware stage")
+	ErrStageNotFound           = errors.New("middleware stage not found")
+	ErrPacketBeforeConnect     = errors.New("packet received before CONNECT")
+	ErrDuplicateConnect        = errors.New("second CONNECT on established connection")
+	ErrConnectTimeout          = errors.New("no CONNECT received within connect timeout")
+	ErrAddressDenied           = errors.New("peer address not allowed")
 )
@@ -0,0 +1,117 @@
+package network
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TLSFingerprint hashes the parameters a client offers in its ClientHello, in the spirit of JA3
+// Go does not expose the raw extension list, so the hash covers the supported versions, cipher suites, curves,
+// point formats, signature schemes and ALPN protocols in the order offered, with GREASE values removed.
+// The same TLS stack yields the same fingerprint, a different library or device usually does not
+func TLSFingerprint(hello *tls.ClientHelloInfo) string {
+	if hello == nil {
+		return ""
+	}
+
+	var b strings.Builder
+	writeValues(&b, hello.SupportedVersions)
+	b.WriteByte(',')
+	writeValues(&b, hello.CipherSuites)
+	b.WriteByte(',')
+	writeValues(&b, hello.SupportedCurves)
+	b.WriteByte(',')
+	writeValues(&b, hello.SupportedPoints)
+	b.WriteByte(',')
+	writeValues(&b, hello.SignatureSchemes)
+	b.WriteByte(',')
+	b.WriteString(strings.Join(hello.SupportedProtos, "-"))
+
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:16])
+}
+
+func writeValues[T ~uint8 | ~uint16](b *strings.Builder, values []T) {
+	first := true
+	for _, v := range values {
+		if isGREASE(uint16(v)) {
+			continue
+		}
+		if !first {
+			b.WriteByte('-')
+		}
+		first = false
+		b.WriteString(strconv.Itoa(int(v)))
+	}
+}
+
+// isGREASE reports whether v is one of the reserved values clients send at random to keep servers tolerant, RFC 8701
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// fingerprintConn carries the TLS fingerprint of a connection through the middleware chain
+type fingerprintConn struct {
+	net.Conn
+	fingerprint string
+}
+
+// NetConn returns the wrapped connection
+func (c *fingerprintConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// TLSFingerprintMiddleware terminates TLS like TLSMiddleware and records the TLSFingerprint of the client,
+// Connection.TLSFingerprint returns it
+func TLSFingerprintMiddleware(config *tls.Config, timeout time.Duration) ConnMiddleware {
+	return func(ctx context.Context, conn net.Conn) (net.Conn, error) {
+		var fingerprint string
+		perConn := config.Clone()
+		perConn.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			fingerprint = TLSFingerprint(hello)
+			if config.GetConfigForClient != nil {
+				return config.GetConfigForClient(hello)
+			}
+			return nil, nil
+		}
+
+		tlsConn, err := TLSMiddleware(perConn, timeout)(ctx, conn)
+		if err != nil {
+			return nil, err
+		}
+		return &fingerprintConn{Conn: tlsConn, fingerprint: fingerprint}, nil
+	}
+}
+
+// TLSFingerprint returns the fingerprint recorded by TLSFingerprintMiddleware
+func (c *Connection) TLSFingerprint() (string, bool) {
+	conn := c.conn
+	for conn != nil {
+		if fc, ok := conn.(*fingerprintConn); ok {
+			return fc.fingerprint, true
+		}
+		wrapper, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			return "", false
+		}
+		conn = wrapper.NetConn()
+	}
+	return "", false
+}
+
+// CertificateFingerprint returns the hex encoded SHA-256 of the TLS client certificate, ok is false for
+// connections without one. It identifies the certificate in caches of authentication decisions
+func (c *Connection) CertificateFingerprint() (string, bool) {
+	state, ok := c.TLSConnectionState()
+	if !ok || len(state.PeerCertificates) == 0 {
+		return "", false
+	}
+	sum := sha256.Sum256(state.PeerCertificates[0].Raw)
+	return hex.EncodeToString(sum[:]), true
+}
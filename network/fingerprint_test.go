@@ -0,0 +1,96 @@
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSFingerprint(t *testing.T) {
+	hello := &tls.ClientHelloInfo{
+		CipherSuites:      []uint16{tls.TLS_AES_128_GCM_SHA256, tls.TLS_CHACHA20_POLY1305_SHA256},
+		SupportedVersions: []uint16{tls.VersionTLS13, tls.VersionTLS12},
+		SupportedCurves:   []tls.CurveID{tls.X25519, tls.CurveP256},
+		SupportedPoints:   []uint8{0},
+		SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+		SupportedProtos:   []string{"mqtt"},
+	}
+
+	fingerprint := TLSFingerprint(hello)
+	assert.Len(t, fingerprint, 32)
+	assert.Equal(t, fingerprint, TLSFingerprint(hello))
+	assert.Empty(t, TLSFingerprint(nil))
+
+	// GREASE values are random per connection and ignored
+	greased := *hello
+	greased.CipherSuites = append([]uint16{0x1a1a}, hello.CipherSuites...)
+	greased.SupportedCurves = append([]tls.CurveID{0xfafa}, hello.SupportedCurves...)
+	assert.Equal(t, fingerprint, TLSFingerprint(&greased))
+
+	reordered := *hello
+	reordered.CipherSuites = []uint16{tls.TLS_CHACHA20_POLY1305_SHA256, tls.TLS_AES_128_GCM_SHA256}
+	assert.NotEqual(t, fingerprint, TLSFingerprint(&reordered))
+
+	alpn := *hello
+	alpn.SupportedProtos = nil
+	assert.NotEqual(t, fingerprint, TLSFingerprint(&alpn))
+}
+
+func TestIsGREASE(t *testing.T) {
+	for _, v := range []uint16{0x0a0a, 0x1a1a, 0xfafa} {
+		assert.True(t, isGREASE(v), "%#x", v)
+	}
+	for _, v := range []uint16{0x0a1a, 0x1301, 0x001d, 0} {
+		assert.False(t, isGREASE(v), "%#x", v)
+	}
+}
+
+func handshakeFingerprint(t *testing.T, serverConfig *tls.Config, clientConfig *tls.Config) string {
+	t.Helper()
+	server, client := net.Pipe()
+	defer client.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		tlsClient := tls.Client(client, clientConfig)
+		done <- tlsClient.Handshake()
+	}()
+
+	conn, err := TLSFingerprintMiddleware(serverConfig, 5*time.Second)(context.Background(), server)
+	require.NoError(t, err)
+	require.NoError(t, <-done)
+
+	c := NewConnection(conn, "fp", nil)
+	defer c.Close()
+	assert.True(t, c.IsTLS())
+	fingerprint, ok := c.TLSFingerprint()
+	require.True(t, ok)
+	return fingerprint
+}
+
+func TestTLSFingerprintMiddleware(t *testing.T) {
+	certFile, keyFile := writeTestKeyPair(t, t.TempDir(), "server")
+	serverConfig, err := (&TLSConfig{CertFile: certFile, KeyFile: keyFile, MinVersion: tls.VersionTLS12}).Build()
+	require.NoError(t, err)
+
+	device := &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12, CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}}
+	laptop := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"mqtt"}}
+
+	first := handshakeFingerprint(t, serverConfig, device)
+	assert.NotEmpty(t, first)
+	assert.Equal(t, first, handshakeFingerprint(t, serverConfig, device))
+	assert.NotEqual(t, first, handshakeFingerprint(t, serverConfig, laptop))
+
+	// Connections that did not pass the middleware have no fingerprint
+	plain, _ := net.Pipe()
+	defer plain.Close()
+	_, ok := NewConnection(plain, "plain", nil).TLSFingerprint()
+	assert.False(t, ok)
+	_, ok = NewConnection(plain, "plain", nil).CertificateFingerprint()
+	assert.False(t, ok)
+}
@@ -3,15 +3,36 @@ package network
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
+	"net/netip"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/axmq/ax/encoding"
 )
 
+// Listener accepts connections for one transport and hands them to the broker
+// Transports share pre-processing through the ConnChain of their configuration
+type Listener interface {
+	Start() error
+	Close() error
+	Addr() net.Addr
+	OnConnection(handler ConnectionHandler)
+	Stats() ListenerStats
+}
+
 type ListenerConfig struct {
-	Address         string
+	// ID names the listener in the MetadataListener of its connections, empty leaves it unset
+	ID string
+	// Network is "tcp" or "unix", empty means "tcp"
+	Network string
+	Address string
+	// Addresses are bound next to Address, e.g. an IPv6 address or further interfaces, and share its limits
+	// With more than one address each IP literal binds its own family, so 0.0.0.0 and [::] may share a port
+	Addresses       []string
 	TLSConfig       *tls.Config
 	TCPKeepAlive    time.Duration
 	AcceptTimeout   time.Duration
@@ -19,6 +40,26 @@ type ListenerConfig struct {
 	ReadBufferSize  int
 	WriteBufferSize int
 	ReusePort       bool
+	// SlowConsumer watches the write backlog of every accepted connection, nil disables it
+	SlowConsumer *SlowConsumerConfig
+	AcceptPacing *AcceptPacingConfig
+	// Bandwidth caps the traffic of every accepted connection, nil disables it
+	Bandwidth *BandwidthConfig
+	// Liveness probes silent connections of clients whose keep alive cannot be relied on, nil disables it
+	Liveness *LivenessConfig
+	// ConnectTimeout caps the time between accept and CONNECT receipt, zero disables it
+	// Only set it when the connection handlers report packets through Connection.CheckPacket, connections are
+	// closed once it passes otherwise
+	ConnectTimeout time.Duration
+	// PropertyLimits bounds the User Properties of the packets of every accepted connection, nil uses
+	// encoding.DefaultPropertyLimits
+	PropertyLimits *encoding.PropertyLimits
+	// SNI sets the MetadataTenant of TLS connections to the tenant routed for the requested server name
+	// It should be the SNIRouter serving the certificates of TLSConfig
+	SNI *SNIRouter
+	// Chain pre-processes accepted connections before the handlers see them
+	// A chain with a TLS stage terminates TLS itself, leave TLSConfig nil then
+	Chain *ConnChain
 }
 
 func DefaultListenerConfig(address string) *ListenerConfig {
@@ -33,10 +74,17 @@ func DefaultListenerConfig(address string) *ListenerConfig {
 	}
 }
 
-type Listener struct {
-	config   *ListenerConfig
-	listener net.Listener
-	pool     *Pool
+// NetListener serves any net.Listener, TCP and unix sockets by address or adapters such as WebSocket or QUIC
+// through NewListenerFrom
+type NetListener struct {
+	config    *ListenerConfig
+	listeners []*boundListener
+	pool      *Pool
+	pacer     *HandshakePacer
+	liveness  *LivenessProber
+	slow      *SlowConsumerDetector
+	bandwidth bandwidthTotals
+	parser    *encoding.Parser
 
 	connSeq  atomic.Uint64
 	accepted atomic.Uint64
@@ -55,7 +103,16 @@ type Listener struct {
 
 type ConnectionHandler func(*Connection) error
 
-func NewListener(config *ListenerConfig, pool *Pool) (*Listener, error) {
+// boundListener is one bound address of a listener with its own share of the counters
+type boundListener struct {
+	listener net.Listener
+	accepted atomic.Uint64
+	rejected atomic.Uint64
+}
+
+var _ Listener = (*NetListener)(nil)
+
+func NewListener(config *ListenerConfig, pool *Pool) (*NetListener, error) {
 	if config == nil {
 		return nil, ErrInvalidAddress
 	}
@@ -67,41 +124,143 @@ func NewListener(config *ListenerConfig, pool *Pool) (*Listener, error) {
 			return nil, err
 		}
 	}
+	if config.Bandwidth != nil {
+		if err := config.Bandwidth.Validate(); err != nil {
+			return nil, err
+		}
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 
-	return &Listener{
+	l := &NetListener{
 		config:   config,
 		pool:     pool,
 		handlers: make([]ConnectionHandler, 0),
 		ctx:      ctx,
 		cancel:   cancel,
-	}, nil
+	}
+
+	if config.AcceptPacing != nil {
+		l.pacer = NewHandshakePacer(config.AcceptPacing)
+	}
+	if config.Liveness != nil {
+		l.liveness = NewLivenessProber(config.Liveness)
+	}
+	if config.SlowConsumer != nil {
+		l.slow = NewSlowConsumerDetector(config.SlowConsumer)
+	}
+	limits := encoding.DefaultPropertyLimits()
+	if config.PropertyLimits != nil {
+		limits = *config.PropertyLimits
+	}
+	l.parser = encoding.NewParser(limits)
+
+	return l, nil
 }
 
-func (l *Listener) Start() error {
+// NewListenerFrom serves connections accepted by ln, Start then skips binding config.Address
+func NewListenerFrom(ln net.Listener, config *ListenerConfig, pool *Pool) (*NetListener, error) {
+	if ln == nil {
+		return nil, ErrInvalidAddress
+	}
+	l, err := NewListener(config, pool)
+	if err != nil {
+		return nil, err
+	}
+	l.listeners = []*boundListener{{listener: ln}}
+	return l, nil
+}
+
+func (l *NetListener) Start() error {
 	if l.closed.Load() {
 		return ErrListenerClosed
 	}
 
-	var err error
-	if l.config.TLSConfig != nil {
-		l.listener, err = tls.Listen("tcp", l.config.Address, l.config.TLSConfig)
-	} else {
-		l.listener, err = net.Listen("tcp", l.config.Address)
+	if len(l.listeners) == 0 {
+		if err := l.bind(); err != nil {
+			return err
+		}
 	}
 
-	if err != nil {
-		return fmt.Errorf("failed to start listener: %w", err)
+	if l.liveness != nil {
+		l.liveness.Start()
+	}
+	if l.slow != nil {
+		l.slow.Start()
+	}
+
+	for _, b := range l.listeners {
+		l.wg.Add(1)
+		go l.acceptLoop(b)
+	}
+
+	return nil
+}
+
+// bind listens on every configured address, nothing stays bound when one of them fails
+func (l *NetListener) bind() error {
+	network := l.config.Network
+	if network == "" {
+		network = "tcp"
 	}
 
-	l.wg.Add(1)
-	go l.acceptLoop()
+	addresses := l.config.bindAddresses()
+	for _, address := range addresses {
+		family := network
+		if len(addresses) > 1 {
+			family = addressFamily(network, address)
+		}
 
+		var ln net.Listener
+		var err error
+		if l.config.TLSConfig != nil {
+			ln, err = tls.Listen(family, address, l.config.TLSConfig)
+		} else {
+			ln, err = net.Listen(family, address)
+		}
+		if err != nil {
+			for _, b := range l.listeners {
+				_ = b.listener.Close()
+			}
+			l.listeners = nil
+			return fmt.Errorf("failed to start listener on %s: %w", address, err)
+		}
+		l.listeners = append(l.listeners, &boundListener{listener: ln})
+	}
 	return nil
 }
 
-func (l *Listener) acceptLoop() {
+// bindAddresses returns Address followed by Addresses, a lone empty address binds an ephemeral port
+func (c *ListenerConfig) bindAddresses() []string {
+	addresses := make([]string, 0, 1+len(c.Addresses))
+	if c.Address != "" || len(c.Addresses) == 0 {
+		addresses = append(addresses, c.Address)
+	}
+	return append(addresses, c.Addresses...)
+}
+
+// addressFamily pins a TCP address to the family of its IP literal
+// An IPv6 socket is dual-stack by default and would take the port of an IPv4 address bound next to it,
+// tcp6 makes it IPv6 only. Host names and wildcards without an IP keep the network as configured
+func addressFamily(network, address string) string {
+	if network != "tcp" {
+		return network
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return network
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return network
+	}
+	if ip.Unmap().Is4() {
+		return "tcp4"
+	}
+	return "tcp6"
+}
+
+func (l *NetListener) acceptLoop(b *boundListener) {
 	defer l.wg.Done()
 
 	for {
@@ -112,12 +271,12 @@ func (l *Listener) acceptLoop() {
 		}
 
 		if l.config.AcceptTimeout > 0 {
-			if tcpListener, ok := l.listener.(*net.TCPListener); ok {
-				tcpListener.SetDeadline(time.Now().Add(l.config.AcceptTimeout))
+			if deadliner, ok := b.listener.(interface{ SetDeadline(time.Time) error }); ok {
+				deadliner.SetDeadline(time.Now().Add(l.config.AcceptTimeout))
 			}
 		}
 
-		netConn, err := l.listener.Accept()
+		netConn, err := b.listener.Accept()
 		if err != nil {
 			if l.closed.Load() {
 				return
@@ -132,20 +291,20 @@ func (l *Listener) acceptLoop() {
 
 		if l.config.MaxConnections > 0 && int(l.pool.total.Load()) >= l.config.MaxConnections {
 			_ = netConn.Close()
-			l.rejected.Add(1)
+			l.reject(b)
 			continue
 		}
 
 		l.wg.Add(1)
-		go l.handleConnection(netConn)
+		go l.handleConnection(b, netConn)
 	}
 }
 
-func (l *Listener) handleConnection(netConn net.Conn) {
+func (l *NetListener) handleConnection(b *boundListener, netConn net.Conn) {
 	defer l.wg.Done()
 
 	if tcpConn, ok := netConn.(*net.TCPConn); ok {
-		if l.config.TCPKeepAlive > 0 {
+		if !l.config.Liveness.applyTCPKeepAlive(tcpConn) && l.config.TCPKeepAlive > 0 {
 			tcpConn.SetKeepAlive(true)
 			tcpConn.SetKeepAlivePeriod(l.config.TCPKeepAlive)
 		}
@@ -159,27 +318,77 @@ func (l *Listener) handleConnection(netConn net.Conn) {
 		}
 	}
 
+	if l.config.Chain != nil {
+		var err error
+		netConn, err = l.config.Chain.Handle(l.ctx, netConn)
+		if err != nil {
+			l.reject(b)
+			return
+		}
+	}
+
 	connID := l.generateConnectionID()
 	conn := NewConnection(netConn, connID, &ConnectionConfig{
-		KeepAlive:     l.config.TCPKeepAlive,
-		ReadDeadline:  0,
-		WriteDeadline: 0,
-		TLSConfig:     l.config.TLSConfig,
+		KeepAlive:      l.config.TCPKeepAlive,
+		ReadDeadline:   0,
+		WriteDeadline:  0,
+		TLSConfig:      l.config.TLSConfig,
+		ConnectTimeout: l.config.ConnectTimeout,
+		Parser:         l.parser,
 	})
+	if l.config.Bandwidth != nil {
+		limiter := NewBandwidthLimiter(l.config.Bandwidth)
+		limiter.totals = &l.bandwidth
+		conn.SetBandwidthLimiter(limiter)
+	}
+
+	if l.pacer != nil {
+		release, err := l.pacer.Acquire(l.ctx)
+		if err != nil {
+			if l.config.AcceptPacing.OnReject != nil {
+				l.config.AcceptPacing.OnReject(conn)
+			}
+			conn.Close()
+			l.reject(b)
+			return
+		}
+		conn.handshakeRelease = release
+	}
 
 	if err := l.pool.Add(conn); err != nil {
 		conn.Close()
-		l.rejected.Add(1)
+		l.reject(b)
 		return
 	}
 
+	if l.config.ID != "" {
+		conn.SetMetadata(MetadataListener, l.config.ID)
+	}
+	if l.config.SNI != nil {
+		// completes the handshake, which the pacer admitted above
+		if tenant, ok := l.config.SNI.ConnectionTenant(conn); ok && tenant != "" {
+			conn.SetMetadata(MetadataTenant, tenant)
+		}
+	}
+
 	l.accepted.Add(1)
+	b.accepted.Add(1)
+	if l.liveness != nil {
+		l.liveness.Track(conn)
+	}
+	if l.slow != nil {
+		// closed connections are forgotten by the next check
+		l.slow.Track(conn.ID(), conn)
+	}
 
 	l.mu.RLock()
 	handlers := make([]ConnectionHandler, len(l.handlers))
 	copy(handlers, l.handlers)
 	l.mu.RUnlock()
 
+	// Handlers that return before CONNECT is processed must call CompleteHandshake themselves
+	defer conn.CompleteHandshake()
+
 	for _, handler := range handlers {
 		if err := handler(conn); err != nil {
 			l.pool.Remove(conn.ID())
@@ -188,18 +397,30 @@ func (l *Listener) handleConnection(netConn net.Conn) {
 	}
 }
 
-func (l *Listener) generateConnectionID() string {
+// reject counts a connection refused on b
+func (l *NetListener) reject(b *boundListener) {
+	l.rejected.Add(1)
+	b.rejected.Add(1)
+}
+
+func (l *NetListener) generateConnectionID() string {
 	seq := l.connSeq.Add(1)
 	return fmt.Sprintf("conn-%d-%d", time.Now().UnixNano(), seq)
 }
 
-func (l *Listener) OnConnection(handler ConnectionHandler) {
+// Parser returns the parser shared by the connections of the listener, changing its limits applies to
+// packets parsed afterwards
+func (l *NetListener) Parser() *encoding.Parser {
+	return l.parser
+}
+
+func (l *NetListener) OnConnection(handler ConnectionHandler) {
 	l.mu.Lock()
 	l.handlers = append(l.handlers, handler)
 	l.mu.Unlock()
 }
 
-func (l *Listener) Close() error {
+func (l *NetListener) Close() error {
 	if !l.closed.CompareAndSwap(false, true) {
 		return nil
 	}
@@ -208,33 +429,101 @@ func (l *Listener) Close() error {
 	l.closeOnce.Do(func() {
 		l.cancel()
 
-		if l.listener != nil {
-			err = l.listener.Close()
+		var errs []error
+		for _, b := range l.listeners {
+			errs = append(errs, b.listener.Close())
 		}
+		err = errors.Join(errs...)
 
 		l.wg.Wait()
+		if l.liveness != nil {
+			l.liveness.Stop()
+		}
+		if l.slow != nil {
+			l.slow.Stop()
+		}
 	})
 
 	return err
 }
 
-func (l *Listener) Addr() net.Addr {
-	if l.listener != nil {
-		return l.listener.Addr()
+// Addr returns the first bound address, see Addrs for a listener bound to several
+func (l *NetListener) Addr() net.Addr {
+	if len(l.listeners) > 0 {
+		return l.listeners[0].listener.Addr()
 	}
 	return nil
 }
 
-func (l *Listener) Stats() ListenerStats {
-	return ListenerStats{
+// Addrs returns every bound address in configuration order
+func (l *NetListener) Addrs() []net.Addr {
+	addrs := make([]net.Addr, len(l.listeners))
+	for i, b := range l.listeners {
+		addrs[i] = b.listener.Addr()
+	}
+	return addrs
+}
+
+func (l *NetListener) Stats() ListenerStats {
+	stats := ListenerStats{
 		Accepted: l.accepted.Load(),
 		Rejected: l.rejected.Load(),
 		Active:   uint64(l.pool.active.Load()),
 	}
+	if len(l.listeners) > 1 {
+		stats.Addresses = make([]AddressStats, len(l.listeners))
+		for i, b := range l.listeners {
+			stats.Addresses[i] = AddressStats{
+				Addr:     b.listener.Addr().String(),
+				Accepted: b.accepted.Load(),
+				Rejected: b.rejected.Load(),
+			}
+		}
+	}
+	if l.pacer != nil {
+		stats.QueuedHandshakes = l.pacer.Queued()
+		stats.InFlightHandshakes = l.pacer.InFlight()
+	}
+	if l.liveness != nil {
+		stats.Liveness = l.liveness.Stats()
+	}
+	if l.config.Bandwidth != nil {
+		stats.Bandwidth = l.bandwidth.stats()
+	}
+	return stats
+}
+
+// SlowConsumers returns the slow consumer detector of the listener, nil when SlowConsumer is not configured
+func (l *NetListener) SlowConsumers() *SlowConsumerDetector {
+	return l.slow
+}
+
+// BandwidthStats returns the traffic counters of every active connection keyed by connection ID
+func (l *NetListener) BandwidthStats() map[string]BandwidthStats {
+	stats := make(map[string]BandwidthStats)
+	l.pool.ForEach(func(conn *Connection) bool {
+		stats[conn.ID()] = conn.BandwidthStats()
+		return true
+	})
+	return stats
 }
 
 type ListenerStats struct {
+	Accepted           uint64
+	Rejected           uint64
+	Active             uint64
+	QueuedHandshakes   int64
+	InFlightHandshakes int
+	Liveness           LivenessStats
+	// Bandwidth sums the traffic of every connection accepted since start when Bandwidth is configured
+	Bandwidth BandwidthStats
+	// Addresses splits Accepted and Rejected by bound address when the listener has more than one
+	Addresses []AddressStats
+}
+
+// AddressStats holds the counters of one address of a listener
+type AddressStats struct {
+	Addr     string
 	Accepted uint64
 	Rejected uint64
-	Active   uint64
 }
@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/axmq/ax/encoding"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -18,6 +19,7 @@ func TestDefaultListenerConfig(t *testing.T) {
 	assert.Equal(t, "localhost:8080", config.Address)
 	assert.Equal(t, 30*time.Second, config.TCPKeepAlive)
 	assert.Equal(t, 5*time.Second, config.AcceptTimeout)
+	assert.Zero(t, config.ConnectTimeout, "no handler reports CONNECT unless configured to")
 	assert.Equal(t, 10000, config.MaxConnections)
 	assert.Equal(t, 4096, config.ReadBufferSize)
 	assert.Equal(t, 4096, config.WriteBufferSize)
@@ -103,6 +105,120 @@ func TestListenerAcceptConnection(t *testing.T) {
 	assert.Equal(t, uint64(1), stats.Accepted)
 }
 
+func TestListenerConnectTimeout(t *testing.T) {
+	config := DefaultListenerConfig("127.0.0.1:0")
+	config.ConnectTimeout = 50 * time.Millisecond
+	listener, err := NewListener(config, nil)
+	require.NoError(t, err)
+
+	conns := make(chan *Connection, 2)
+	listener.OnConnection(func(conn *Connection) error {
+		conns <- conn
+		return nil
+	})
+	require.NoError(t, listener.Start())
+	defer listener.Close()
+
+	accept := func() *Connection {
+		client, err := net.Dial("tcp", listener.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() { client.Close() })
+		select {
+		case conn := <-conns:
+			return conn
+		case <-time.After(2 * time.Second):
+			t.Fatal("connection not accepted")
+			return nil
+		}
+	}
+
+	connected := accept()
+	require.NoError(t, connected.CheckPacket(encoding.CONNECT))
+	silent := accept()
+
+	time.Sleep(150 * time.Millisecond)
+	assert.Equal(t, StateConnected, connected.State(), "a connection that sent CONNECT survives the timeout")
+	assert.False(t, connected.ConnectTimedOut())
+	assert.True(t, silent.ConnectTimedOut())
+	assert.NotEqual(t, StateConnected, silent.State())
+}
+
+func TestListenerPropertyLimits(t *testing.T) {
+	limits := encoding.PropertyLimits{MaxUserProperties: 4}
+	listener, err := NewListener(&ListenerConfig{Address: "127.0.0.1:0", PropertyLimits: &limits}, nil)
+	require.NoError(t, err)
+
+	parsers := make(chan *encoding.Parser, 1)
+	listener.OnConnection(func(conn *Connection) error {
+		parsers <- conn.Parser()
+		return nil
+	})
+	require.NoError(t, listener.Start())
+	defer listener.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	select {
+	case parser := <-parsers:
+		assert.Same(t, listener.Parser(), parser)
+		assert.Equal(t, limits, parser.Limits())
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection not accepted")
+	}
+
+	other, err := NewListener(DefaultListenerConfig("127.0.0.1:0"), nil)
+	require.NoError(t, err)
+	assert.Equal(t, encoding.DefaultPropertyLimits(), other.Parser().Limits())
+}
+
+func TestListenerSlowConsumer(t *testing.T) {
+	events := make(chan SlowConsumerEvent, 1)
+	config := &ListenerConfig{
+		Address:         "127.0.0.1:0",
+		WriteBufferSize: 4096,
+		SlowConsumer: &SlowConsumerConfig{
+			QueueThreshold:   1000,
+			LatencyThreshold: 20 * time.Millisecond,
+			Duration:         20 * time.Millisecond,
+			CheckInterval:    10 * time.Millisecond,
+			OnSlowConsumer: func(e SlowConsumerEvent) {
+				events <- e
+			},
+		},
+	}
+	listener, err := NewListener(config, nil)
+	require.NoError(t, err)
+
+	accepted := make(chan *Connection, 1)
+	listener.OnConnection(func(conn *Connection) error {
+		conn.SetMetadata(MetadataClientID, "stalled")
+		accepted <- conn
+		// The peer never reads, so the write blocks once the socket buffers are full
+		go conn.Write(make([]byte, 64<<20))
+		return nil
+	})
+	require.NoError(t, listener.Start())
+	defer listener.Close()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+
+	conn := <-accepted
+	defer conn.Close()
+	select {
+	case e := <-events:
+		assert.Equal(t, "stalled", e.ClientID)
+		assert.Equal(t, conn.ID(), e.ConnectionID)
+		assert.Greater(t, e.OldestMessageAge, 20*time.Millisecond)
+	case <-time.After(5 * time.Second):
+		t.Fatal("stalled connection was not detected")
+	}
+	assert.Equal(t, []string{conn.ID()}, listener.SlowConsumers().SlowConsumers())
+}
+
 func TestListenerMultipleConnections(t *testing.T) {
 	config := &ListenerConfig{
 		Address:        "127.0.0.1:0",
@@ -396,3 +512,62 @@ func TestListenerBufferSizes(t *testing.T) {
 		time.Sleep(50 * time.Millisecond)
 	}
 }
+
+func TestListenerMultipleAddresses(t *testing.T) {
+	probe, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skip("IPv6 loopback unavailable")
+	}
+	port := probe.Addr().(*net.TCPAddr).Port
+	require.NoError(t, probe.Close())
+
+	// Both families share one port, the IPv6 socket is bound IPv6 only
+	config := &ListenerConfig{
+		Address:        fmt.Sprintf("127.0.0.1:%d", port),
+		Addresses:      []string{fmt.Sprintf("[::1]:%d", port)},
+		MaxConnections: 10,
+	}
+	listener, err := NewListener(config, nil)
+	require.NoError(t, err)
+	listener.OnConnection(func(*Connection) error { return nil })
+	require.NoError(t, listener.Start())
+	defer listener.Close()
+
+	addrs := listener.Addrs()
+	require.Len(t, addrs, 2)
+	assert.Equal(t, addrs[0], listener.Addr())
+	for _, addr := range addrs {
+		conn, err := net.Dial("tcp", addr.String())
+		require.NoError(t, err)
+		defer conn.Close()
+	}
+
+	require.Eventually(t, func() bool { return listener.Stats().Accepted == 2 }, time.Second, 5*time.Millisecond)
+	stats := listener.Stats()
+	require.Len(t, stats.Addresses, 2)
+	for i, addr := range addrs {
+		assert.Equal(t, addr.String(), stats.Addresses[i].Addr)
+		assert.Equal(t, uint64(1), stats.Addresses[i].Accepted)
+	}
+}
+
+func TestListenerMultipleAddressesBindFailure(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer taken.Close()
+
+	listener, err := NewListener(&ListenerConfig{Address: "127.0.0.1:0", Addresses: []string{taken.Addr().String()}}, nil)
+	require.NoError(t, err)
+	assert.Error(t, listener.Start())
+	assert.Nil(t, listener.Addr())
+}
+
+func TestAddressFamily(t *testing.T) {
+	assert.Equal(t, "tcp4", addressFamily("tcp", "0.0.0.0:1883"))
+	assert.Equal(t, "tcp4", addressFamily("tcp", "[::ffff:127.0.0.1]:1883"))
+	assert.Equal(t, "tcp6", addressFamily("tcp", "[::]:1883"))
+	assert.Equal(t, "tcp6", addressFamily("tcp", "[fe80::1%eth0]:1883"))
+	assert.Equal(t, "tcp", addressFamily("tcp", ":1883"))
+	assert.Equal(t, "tcp", addressFamily("tcp", "localhost:1883"))
+	assert.Equal(t, "unix", addressFamily("unix", "/tmp/ax.sock"))
+}
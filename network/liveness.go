@@ -0,0 +1,273 @@
+package network
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/axmq/ax/encoding"
+)
+
+// MetadataProtocolVersion is the connection metadata key holding the encoding.ProtocolVersion of the client
+const MetadataProtocolVersion = "protocol_version"
+
+// DefaultLivenessEchoTopic is the topic EchoProbe publishes to, {clientid} is replaced by the client ID
+const DefaultLivenessEchoTopic = "$SYS/liveness/{clientid}"
+
+// LivenessConfig configures server-side liveness probes, for transports where the broker cannot rely on the
+// keep alive of clients, such as gateways multiplexing many devices over one connection
+type LivenessConfig struct {
+	// Interval is how long a connection may stay silent before it is probed
+	Interval time.Duration
+	// Timeout is how long a probe waits for inbound traffic before it counts as failed
+	Timeout time.Duration
+	// MaxFailures is the number of consecutive failed probes after which the connection is closed
+	MaxFailures int
+	// CheckInterval is how often connections are checked
+	CheckInterval time.Duration
+	// Probe sends a probe the client answers with any packet, see EchoProbe. Without it silent connections
+	// are closed after Timeout and MaxFailures as if every probe failed, leaving TCP keepalive to detect
+	// dead peers early
+	Probe func(conn *Connection) error
+
+	// TCPIdle, TCPInterval and TCPCount tune the TCP keepalive of accepted connections, setting any of them
+	// overrides ListenerConfig.TCPKeepAlive and zero values take the net.KeepAliveConfig defaults
+	TCPIdle     time.Duration
+	TCPInterval time.Duration
+	TCPCount    int
+
+	// OnProbeFailed is called for each probe left unanswered with the consecutive failures so far
+	OnProbeFailed func(conn *Connection, failures int)
+}
+
+func DefaultLivenessConfig() *LivenessConfig {
+	return &LivenessConfig{
+		Interval:      time.Minute,
+		Timeout:       10 * time.Second,
+		MaxFailures:   3,
+		CheckInterval: time.Second,
+	}
+}
+
+// LivenessStats holds the counters of a liveness prober
+type LivenessStats struct {
+	Tracked     int
+	Probes      uint64
+	ProbeErrors uint64 // probes that could not be sent
+	Failures    uint64 // probes left unanswered
+	Closed      uint64 // connections closed as dead
+}
+
+type livenessState struct {
+	conn      *Connection
+	bytesRead uint64
+	lastRead  time.Time
+	probedAt  time.Time
+	failures  int
+}
+
+// LivenessProber probes connections that stay silent and closes those that do not answer
+// A connection counts as alive whenever it reads bytes, so any packet of the client answers a probe
+type LivenessProber struct {
+	config *LivenessConfig
+
+	mu    sync.Mutex
+	conns map[string]*livenessState
+
+	probes      atomic.Uint64
+	probeErrors atomic.Uint64
+	failures    atomic.Uint64
+	closed      atomic.Uint64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewLivenessProber creates a liveness prober, zero settings take the defaults
+func NewLivenessProber(config *LivenessConfig) *LivenessProber {
+	defaults := DefaultLivenessConfig()
+	if config == nil {
+		config = defaults
+	}
+	cfg := *config
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaults.Interval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaults.Timeout
+	}
+	if cfg.MaxFailures <= 0 {
+		cfg.MaxFailures = defaults.MaxFailures
+	}
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = defaults.CheckInterval
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &LivenessProber{
+		config: &cfg,
+		conns:  make(map[string]*livenessState),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+func (p *LivenessProber) Start() {
+	p.wg.Add(1)
+	go p.checkLoop()
+}
+
+func (p *LivenessProber) Stop() {
+	p.cancel()
+	p.wg.Wait()
+}
+
+// Track starts probing conn once it stays silent
+func (p *LivenessProber) Track(conn *Connection) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.conns[conn.ID()] = &livenessState{conn: conn, bytesRead: conn.BytesRead(), lastRead: time.Now()}
+}
+
+func (p *LivenessProber) Untrack(connID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.conns, connID)
+}
+
+// Check probes silent connections and closes those that failed MaxFailures probes in a row,
+// it returns the closed connections
+func (p *LivenessProber) Check() []*Connection {
+	now := time.Now()
+	var (
+		probe  []*Connection
+		failed []*livenessState
+		dead   []*Connection
+	)
+
+	p.mu.Lock()
+	for id, state := range p.conns {
+		if state.conn.State() != StateConnected {
+			delete(p.conns, id)
+			continue
+		}
+		if read := state.conn.BytesRead(); read != state.bytesRead {
+			state.bytesRead = read
+			state.lastRead = now
+			state.probedAt = time.Time{}
+			state.failures = 0
+			continue
+		}
+
+		switch {
+		case !state.probedAt.IsZero() && now.Sub(state.probedAt) >= p.config.Timeout:
+			state.failures++
+			failed = append(failed, &livenessState{conn: state.conn, failures: state.failures})
+			if state.failures >= p.config.MaxFailures {
+				delete(p.conns, id)
+				dead = append(dead, state.conn)
+				continue
+			}
+		case state.probedAt.IsZero() && now.Sub(state.lastRead) >= p.config.Interval:
+		default:
+			continue
+		}
+		state.probedAt = now
+		probe = append(probe, state.conn)
+	}
+	p.mu.Unlock()
+
+	for _, f := range failed {
+		p.failures.Add(1)
+		if p.config.OnProbeFailed != nil {
+			p.config.OnProbeFailed(f.conn, f.failures)
+		}
+	}
+	for _, conn := range dead {
+		p.closed.Add(1)
+		conn.Close()
+	}
+	for _, conn := range probe {
+		p.probes.Add(1)
+		if p.config.Probe == nil {
+			continue
+		}
+		if err := p.config.Probe(conn); err != nil {
+			p.probeErrors.Add(1)
+		}
+	}
+	return dead
+}
+
+// Stats returns the tracked connections and probe counters
+func (p *LivenessProber) Stats() LivenessStats {
+	p.mu.Lock()
+	tracked := len(p.conns)
+	p.mu.Unlock()
+
+	return LivenessStats{
+		Tracked:     tracked,
+		Probes:      p.probes.Load(),
+		ProbeErrors: p.probeErrors.Load(),
+		Failures:    p.failures.Load(),
+		Closed:      p.closed.Load(),
+	}
+}
+
+func (p *LivenessProber) checkLoop() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.config.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.Check()
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+// applyTCPKeepAlive tunes the TCP keepalive of tcpConn, it reports false when no setting is configured
+func (c *LivenessConfig) applyTCPKeepAlive(tcpConn *net.TCPConn) bool {
+	if c == nil || c.TCPIdle <= 0 && c.TCPInterval <= 0 && c.TCPCount <= 0 {
+		return false
+	}
+	_ = tcpConn.SetKeepAliveConfig(net.KeepAliveConfig{
+		Enable:   true,
+		Idle:     c.TCPIdle,
+		Interval: c.TCPInterval,
+		Count:    c.TCPCount,
+	})
+	return true
+}
+
+// EchoProbe returns a probe publishing a QoS 0 message to topicPattern, in which {clientid} is replaced by the
+// client ID, carrying the probe time in unix nanoseconds. Clients that subscribe to the echo topic answer by
+// publishing the payload back, any topic will do. The message is encoded for the protocol version found in the
+// connection metadata, MQTT 5 when it is unknown
+func EchoProbe(topicPattern string) func(conn *Connection) error {
+	if topicPattern == "" {
+		topicPattern = DefaultLivenessEchoTopic
+	}
+	return func(conn *Connection) error {
+		topicName := strings.ReplaceAll(topicPattern, "{clientid}", ConnectionClientInfo(conn).ClientID)
+		payload := strconv.AppendInt(nil, time.Now().UnixNano(), 10)
+		header := encoding.FixedHeader{Type: encoding.PUBLISH, QoS: encoding.QoS0}
+
+		value, _ := conn.GetMetadata(MetadataProtocolVersion)
+		version, ok := value.(encoding.ProtocolVersion)
+
+		if !ok || version == encoding.ProtocolVersion50 {
+			return conn.WritePacket(&encoding.PublishPacket{FixedHeader: header, TopicName: topicName, Payload: payload})
+		}
+		return conn.WritePacket(&encoding.PublishPacket311{FixedHeader: header, TopicName: topicName, Payload: payload})
+	}
+}
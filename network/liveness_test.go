@@ -0,0 +1,173 @@
+package network
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLivenessProberDefaults(t *testing.T) {
+	p := NewLivenessProber(&LivenessConfig{Interval: time.Second})
+	assert.Equal(t, time.Second, p.config.Interval)
+	assert.Equal(t, 10*time.Second, p.config.Timeout)
+	assert.Equal(t, 3, p.config.MaxFailures)
+}
+
+func TestLivenessProber_ClosesSilentConnection(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	conn := NewConnection(server, "c1", nil)
+
+	var probes, failures atomic.Int32
+	p := NewLivenessProber(&LivenessConfig{
+		Interval:    10 * time.Millisecond,
+		Timeout:     10 * time.Millisecond,
+		MaxFailures: 2,
+		Probe: func(*Connection) error {
+			probes.Add(1)
+			return nil
+		},
+		OnProbeFailed: func(_ *Connection, n int) {
+			failures.Store(int32(n))
+		},
+	})
+	p.Track(conn)
+
+	assert.Empty(t, p.Check())
+	assert.Zero(t, probes.Load())
+
+	time.Sleep(15 * time.Millisecond)
+	assert.Empty(t, p.Check())
+	assert.Equal(t, int32(1), probes.Load())
+
+	time.Sleep(15 * time.Millisecond)
+	assert.Empty(t, p.Check())
+	assert.Equal(t, int32(1), failures.Load())
+	assert.Equal(t, int32(2), probes.Load())
+
+	time.Sleep(15 * time.Millisecond)
+	dead := p.Check()
+	require.Len(t, dead, 1)
+	assert.Equal(t, int32(2), failures.Load())
+	assert.Equal(t, StateClosed, conn.State())
+
+	stats := p.Stats()
+	assert.Zero(t, stats.Tracked)
+	assert.Equal(t, uint64(2), stats.Probes)
+	assert.Equal(t, uint64(2), stats.Failures)
+	assert.Equal(t, uint64(1), stats.Closed)
+}
+
+func TestLivenessProber_AnsweredProbe(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	conn := NewConnection(server, "c1", nil)
+	defer conn.Close()
+
+	p := NewLivenessProber(&LivenessConfig{
+		Interval:    10 * time.Millisecond,
+		Timeout:     10 * time.Millisecond,
+		MaxFailures: 1,
+	})
+	p.Track(conn)
+
+	time.Sleep(15 * time.Millisecond)
+	p.Check()
+	assert.Equal(t, uint64(1), p.Stats().Probes)
+
+	// Any inbound byte answers the probe
+	go func() { _, _ = client.Write([]byte{0xC0, 0x00}) }()
+	_, err := conn.Read(make([]byte, 2))
+	require.NoError(t, err)
+
+	time.Sleep(15 * time.Millisecond)
+	assert.Empty(t, p.Check())
+	assert.Equal(t, StateConnected, conn.State())
+	assert.Zero(t, p.Stats().Failures)
+	assert.Equal(t, 1, p.Stats().Tracked)
+
+	p.Untrack("c1")
+	assert.Zero(t, p.Stats().Tracked)
+}
+
+func TestEchoProbe(t *testing.T) {
+	tests := []struct {
+		name    string
+		version any
+	}{
+		{name: "mqtt5"},
+		{name: "mqtt311", version: encoding.ProtocolVersion311},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer client.Close()
+			conn := NewConnection(server, "c1", nil)
+			defer conn.Close()
+			conn.SetMetadata(MetadataClientID, "device-1")
+			if tt.version != nil {
+				conn.SetMetadata(MetadataProtocolVersion, tt.version)
+			}
+
+			errCh := make(chan error, 1)
+			go func() { errCh <- EchoProbe("")(conn) }()
+
+			br := bufio.NewReader(client)
+			fh, err := encoding.ParseFixedHeader(br)
+			require.NoError(t, err)
+			assert.Equal(t, encoding.PUBLISH, fh.Type)
+
+			body := make([]byte, fh.RemainingLength)
+			_, err = io.ReadFull(br, body)
+			require.NoError(t, err)
+			require.NoError(t, <-errCh)
+
+			topicName := "$SYS/liveness/device-1"
+			assert.Equal(t, topicName, string(body[2:2+len(topicName)]))
+			// MQTT 5 adds a property length byte between the topic and the payload
+			rest := body[2+len(topicName):]
+			if tt.version == nil {
+				assert.Equal(t, byte(0), rest[0])
+				rest = rest[1:]
+			}
+			assert.NotEmpty(t, rest)
+		})
+	}
+}
+
+func TestListenerLiveness(t *testing.T) {
+	listener, err := NewListener(&ListenerConfig{
+		Address: "127.0.0.1:0",
+		Liveness: &LivenessConfig{
+			Interval:      20 * time.Millisecond,
+			Timeout:       20 * time.Millisecond,
+			MaxFailures:   1,
+			CheckInterval: 5 * time.Millisecond,
+			TCPIdle:       time.Minute,
+		},
+	}, nil)
+	require.NoError(t, err)
+	require.NoError(t, listener.Start())
+	defer listener.Close()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+
+	// The silent client is closed once its probe goes unanswered
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(2*time.Second)))
+	_, err = client.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+
+	stats := listener.Stats().Liveness
+	assert.Equal(t, uint64(1), stats.Closed)
+	assert.Equal(t, uint64(1), stats.Failures)
+}
@@ -0,0 +1,306 @@
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Names of the standard connection middleware stages, in the order NewStandardConnChain runs them
+const (
+	StageRateLimit     = "rate-limit"
+	StageProxyProtocol = "proxy-protocol"
+	StageAddressACL    = "address-acl"
+	StageTLS           = "tls"
+	StageAuthThrottle  = "auth-throttle"
+)
+
+// ConnMiddleware pre-processes an accepted connection before the broker sees it
+// It returns the connection to pass on, possibly wrapped, or an error to reject it
+// Middleware must not close the connection on error, the chain does
+type ConnMiddleware func(ctx context.Context, conn net.Conn) (net.Conn, error)
+
+// ConnStage is a named middleware of a chain
+type ConnStage struct {
+	Name       string
+	Middleware ConnMiddleware
+}
+
+// ConnChain runs connection middleware in order so every transport shares the same pre-processing
+// Stages are named so custom net.Conn wrappers, e.g. for traffic shaping, can be inserted relative to the standard ones
+type ConnChain struct {
+	mu     sync.RWMutex
+	stages []ConnStage
+}
+
+func NewConnChain(stages ...ConnStage) *ConnChain {
+	return &ConnChain{stages: append([]ConnStage(nil), stages...)}
+}
+
+// StandardChainConfig selects the standard stages, nil fields leave their stage out
+type StandardChainConfig struct {
+	RateLimiter   *ConnRateLimiter
+	ProxyProtocol *ProxyProtocolConfig
+	AddressACL    *AddressACL
+	TLSConfig     *tls.Config
+	TLSTimeout    time.Duration
+	// TLSFingerprint records the TLS fingerprint of every client, see TLSFingerprintMiddleware
+	TLSFingerprint bool
+	AuthThrottle   *AuthThrottle
+}
+
+// NewStandardConnChain builds the chain rate limit → proxy protocol → address ACL → TLS → auth throttle
+// Rate limiting runs on the raw peer address so floods are dropped before any parsing, later stages see
+// the client address from the PROXY header, and TLS is terminated after it since proxies send the header in clear
+func NewStandardConnChain(config *StandardChainConfig) *ConnChain {
+	c := NewConnChain()
+	if config == nil {
+		return c
+	}
+
+	if config.RateLimiter != nil {
+		c.stages = append(c.stages, ConnStage{Name: StageRateLimit, Middleware: RateLimitMiddleware(config.RateLimiter)})
+	}
+	if config.ProxyProtocol != nil {
+		c.stages = append(c.stages, ConnStage{Name: StageProxyProtocol, Middleware: ProxyProtocolMiddleware(config.ProxyProtocol)})
+	}
+	if config.AddressACL != nil {
+		c.stages = append(c.stages, ConnStage{Name: StageAddressACL, Middleware: AddressACLMiddleware(config.AddressACL)})
+	}
+	if config.TLSConfig != nil {
+		middleware := TLSMiddleware(config.TLSConfig, config.TLSTimeout)
+		if config.TLSFingerprint {
+			middleware = TLSFingerprintMiddleware(config.TLSConfig, config.TLSTimeout)
+		}
+		c.stages = append(c.stages, ConnStage{Name: StageTLS, Middleware: middleware})
+	}
+	if config.AuthThrottle != nil {
+		c.stages = append(c.stages, ConnStage{Name: StageAuthThrottle, Middleware: AuthThrottleMiddleware(config.AuthThrottle)})
+	}
+	return c
+}
+
+// Use appends a stage to the end of the chain, right before the broker
+func (c *ConnChain) Use(stage ConnStage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.indexLocked(stage.Name) >= 0 {
+		return fmt.Errorf("%w: %q", ErrDuplicateStage, stage.Name)
+	}
+	c.stages = append(c.stages, stage)
+	return nil
+}
+
+// InsertBefore inserts a stage before the named stage
+func (c *ConnChain) InsertBefore(name string, stage ConnStage) error {
+	return c.insert(name, 0, stage)
+}
+
+// InsertAfter inserts a stage after the named stage
+func (c *ConnChain) InsertAfter(name string, stage ConnStage) error {
+	return c.insert(name, 1, stage)
+}
+
+func (c *ConnChain) insert(name string, offset int, stage ConnStage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.indexLocked(stage.Name) >= 0 {
+		return fmt.Errorf("%w: %q", ErrDuplicateStage, stage.Name)
+	}
+	i := c.indexLocked(name)
+	if i < 0 {
+		return fmt.Errorf("%w: %q", ErrStageNotFound, name)
+	}
+
+	i += offset
+	c.stages = append(c.stages, ConnStage{})
+	copy(c.stages[i+1:], c.stages[i:])
+	c.stages[i] = stage
+	return nil
+}
+
+// Remove removes the named stage, it reports whether the stage was present
+func (c *ConnChain) Remove(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	i := c.indexLocked(name)
+	if i < 0 {
+		return false
+	}
+	c.stages = append(c.stages[:i], c.stages[i+1:]...)
+	return true
+}
+
+// Stages returns the stage names in order
+func (c *ConnChain) Stages() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	names := make([]string, len(c.stages))
+	for i, stage := range c.stages {
+		names[i] = stage.Name
+	}
+	return names
+}
+
+func (c *ConnChain) indexLocked(name string) int {
+	for i, stage := range c.stages {
+		if stage.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Handle runs conn through every stage and returns the connection to hand to the broker
+// When a stage rejects the connection it is closed and the error names the stage
+func (c *ConnChain) Handle(ctx context.Context, conn net.Conn) (net.Conn, error) {
+	c.mu.RLock()
+	stages := make([]ConnStage, len(c.stages))
+	copy(stages, c.stages)
+	c.mu.RUnlock()
+
+	for _, stage := range stages {
+		next, err := stage.Middleware(ctx, conn)
+		if err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("%s: %w", stage.Name, err)
+		}
+		conn = next
+	}
+	return conn, nil
+}
+
+// TLSMiddleware terminates TLS, the handshake must complete within timeout when it is positive
+func TLSMiddleware(config *tls.Config, timeout time.Duration) ConnMiddleware {
+	return func(ctx context.Context, conn net.Conn) (net.Conn, error) {
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		tlsConn := tls.Server(conn, config)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+}
+
+// RateLimitMiddleware rejects connections from peers opening connections faster than the limiter allows
+func RateLimitMiddleware(limiter *ConnRateLimiter) ConnMiddleware {
+	return func(_ context.Context, conn net.Conn) (net.Conn, error) {
+		if !limiter.Allow(conn.RemoteAddr()) {
+			return nil, ErrConnectionRateExceeded
+		}
+		return conn, nil
+	}
+}
+
+// AuthThrottleMiddleware rejects connections from peers blocked after repeated authentication failures
+func AuthThrottleMiddleware(throttle *AuthThrottle) ConnMiddleware {
+	return func(_ context.Context, conn net.Conn) (net.Conn, error) {
+		if throttle.Blocked(conn.RemoteAddr()) {
+			return nil, ErrAuthThrottled
+		}
+		return conn, nil
+	}
+}
+
+// AddressACL admits peers by network address, a denied network wins over an allowed one
+// IPv4 peers of a dual-stack socket are matched as IPv4, so 10.0.0.0/8 covers ::ffff:10.0.0.1 as well
+type AddressACL struct {
+	// Allow lists the networks admitted, empty admits every peer not denied
+	Allow []netip.Prefix
+	Deny  []netip.Prefix
+}
+
+// Allowed reports whether the peer at addr is admitted, peers without an IP address only when Allow is empty
+func (a *AddressACL) Allowed(addr net.Addr) bool {
+	ip, ok := peerIP(addr)
+	if !ok {
+		return len(a.Allow) == 0
+	}
+	contains := func(prefix netip.Prefix) bool { return prefix.Contains(ip) }
+	if slices.ContainsFunc(a.Deny, contains) {
+		return false
+	}
+	return len(a.Allow) == 0 || slices.ContainsFunc(a.Allow, contains)
+}
+
+// AddressACLMiddleware rejects connections from peers the ACL does not admit
+func AddressACLMiddleware(acl *AddressACL) ConnMiddleware {
+	return func(_ context.Context, conn net.Conn) (net.Conn, error) {
+		if !acl.Allowed(conn.RemoteAddr()) {
+			return nil, ErrAddressDenied
+		}
+		return conn, nil
+	}
+}
+
+// unwrapTLS finds a TLS connection under middleware wrappers exposing NetConn, as tls.Conn itself does
+func unwrapTLS(conn net.Conn) (*tls.Conn, bool) {
+	for conn != nil {
+		if tlsConn, ok := conn.(*tls.Conn); ok {
+			return tlsConn, true
+		}
+		wrapper, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			return nil, false
+		}
+		conn = wrapper.NetConn()
+	}
+	return nil, false
+}
+
+// hostOf returns the host part of addr, used to key per-peer state
+func hostOf(addr net.Addr) string {
+	switch a := addr.(type) {
+	case nil:
+		return ""
+	case *net.TCPAddr:
+		return a.IP.String()
+	case *net.UDPAddr:
+		return a.IP.String()
+	}
+	s := addr.String()
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		return host
+	}
+	return s
+}
+
+// DefaultIPv6PeerPrefix is the IPv6 prefix length keying per-peer state, a single site usually owns a whole /64
+// and could otherwise dodge per-peer limits by cycling through its addresses
+const DefaultIPv6PeerPrefix = 64
+
+// peerIP returns the IP address of addr without zone, IPv4-mapped IPv6 addresses as IPv4
+func peerIP(addr net.Addr) (netip.Addr, bool) {
+	ip, err := netip.ParseAddr(hostOf(addr))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.Unmap().WithZone(""), true
+}
+
+// peerKey returns the key of per-peer state for addr, IPv6 peers are keyed by their network of ipv6Prefix bits
+func peerKey(addr net.Addr, ipv6Prefix int) string {
+	ip, ok := peerIP(addr)
+	if !ok {
+		return hostOf(addr)
+	}
+	if ip.Is6() && ipv6Prefix > 0 && ipv6Prefix < 128 {
+		prefix, _ := ip.Prefix(ipv6Prefix)
+		return prefix.String()
+	}
+	return ip.String()
+}
@@ -0,0 +1,142 @@
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type taggedConn struct {
+	net.Conn
+	tag string
+}
+
+func (c *taggedConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func tagStage(name string, order *[]string) ConnStage {
+	return ConnStage{Name: name, Middleware: func(_ context.Context, conn net.Conn) (net.Conn, error) {
+		*order = append(*order, name)
+		return &taggedConn{Conn: conn, tag: name}, nil
+	}}
+}
+
+func TestNewStandardConnChainOrder(t *testing.T) {
+	chain := NewStandardConnChain(&StandardChainConfig{
+		RateLimiter:   NewConnRateLimiter(1, 1),
+		ProxyProtocol: DefaultProxyProtocolConfig(),
+		AddressACL:    &AddressACL{},
+		TLSConfig:     &tls.Config{},
+		AuthThrottle:  NewAuthThrottle(nil),
+	})
+	assert.Equal(t, []string{StageRateLimit, StageProxyProtocol, StageAddressACL, StageTLS, StageAuthThrottle}, chain.Stages())
+
+	assert.Empty(t, NewStandardConnChain(nil).Stages())
+}
+
+func TestConnChainInsert(t *testing.T) {
+	var order []string
+	chain := NewConnChain(tagStage("a", &order), tagStage("c", &order))
+
+	require.NoError(t, chain.InsertAfter("a", tagStage("b", &order)))
+	require.NoError(t, chain.InsertBefore("a", tagStage("first", &order)))
+	require.NoError(t, chain.Use(tagStage("last", &order)))
+	assert.Equal(t, []string{"first", "a", "b", "c", "last"}, chain.Stages())
+
+	assert.ErrorIs(t, chain.Use(tagStage("b", &order)), ErrDuplicateStage)
+	assert.ErrorIs(t, chain.InsertAfter("missing", tagStage("x", &order)), ErrStageNotFound)
+
+	assert.True(t, chain.Remove("b"))
+	assert.False(t, chain.Remove("b"))
+	assert.Equal(t, []string{"first", "a", "c", "last"}, chain.Stages())
+}
+
+func TestConnChainHandle(t *testing.T) {
+	var order []string
+	chain := NewConnChain(tagStage("a", &order), tagStage("b", &order))
+
+	server, client := net.Pipe()
+	defer client.Close()
+
+	conn, err := chain.Handle(context.Background(), server)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, order)
+	assert.Equal(t, "b", conn.(*taggedConn).tag)
+	conn.Close()
+}
+
+func TestConnChainHandleRejects(t *testing.T) {
+	errReject := errors.New("rejected")
+	chain := NewConnChain(ConnStage{Name: "reject", Middleware: func(context.Context, net.Conn) (net.Conn, error) {
+		return nil, errReject
+	}})
+
+	server, client := net.Pipe()
+	defer client.Close()
+
+	_, err := chain.Handle(context.Background(), server)
+	assert.ErrorIs(t, err, errReject)
+	assert.Contains(t, err.Error(), "reject")
+
+	_, err = server.Write([]byte{0})
+	assert.Error(t, err)
+}
+
+func TestUnwrapTLS(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	_, ok := unwrapTLS(&taggedConn{Conn: server})
+	assert.False(t, ok)
+}
+
+func TestListenerChain(t *testing.T) {
+	chain := NewConnChain()
+	require.NoError(t, chain.Use(ConnStage{Name: "deny", Middleware: func(context.Context, net.Conn) (net.Conn, error) {
+		return nil, ErrConnectionRateExceeded
+	}}))
+
+	listener, err := NewListener(&ListenerConfig{Address: "127.0.0.1:0", Chain: chain}, nil)
+	require.NoError(t, err)
+	require.NoError(t, listener.Start())
+	defer listener.Close()
+
+	handled := make(chan struct{}, 1)
+	listener.OnConnection(func(*Connection) error {
+		handled <- struct{}{}
+		return nil
+	})
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.Eventually(t, func() bool { return listener.Stats().Rejected == 1 }, time.Second, 5*time.Millisecond)
+	select {
+	case <-handled:
+		t.Fatal("rejected connection reached the handlers")
+	default:
+	}
+}
+
+func TestListenerFrom(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	listener, err := NewListenerFrom(ln, &ListenerConfig{}, nil)
+	require.NoError(t, err)
+	require.NoError(t, listener.Start())
+	defer listener.Close()
+	assert.Equal(t, ln.Addr(), listener.Addr())
+
+	_, err = NewListenerFrom(nil, &ListenerConfig{}, nil)
+	assert.ErrorIs(t, err, ErrInvalidAddress)
+}
@@ -0,0 +1,316 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	// DefaultOCSPCacheSize bounds the responses cached by an OCSP client
+	DefaultOCSPCacheSize = 10000
+	// DefaultOCSPMaxCacheAge caps how long a response is cached, whatever its next update
+	DefaultOCSPMaxCacheAge = time.Hour
+
+	// maxOCSPResponseSize bounds the responses read from a responder
+	maxOCSPResponseSize = 1 << 20
+)
+
+// OCSPClientConfig configures an OCSP client
+type OCSPClientConfig struct {
+	// HTTPClient sends the requests, defaults to http.DefaultClient. The deadline of the request context
+	// set by RevocationConfig.OCSPTimeout bounds every request
+	HTTPClient *http.Client
+	// CacheSize bounds the cached responses, defaults to DefaultOCSPCacheSize
+	CacheSize int
+	// MaxCacheAge caps how long a response is cached, defaults to DefaultOCSPMaxCacheAge
+	// Responses are never cached past their next update
+	MaxCacheAge time.Duration
+}
+
+// OCSPClientStats holds OCSP client statistics
+type OCSPClientStats struct {
+	Requests  uint64
+	CacheHits uint64
+	Errors    uint64
+	Cached    int
+}
+
+type ocspCacheEntry struct {
+	status  RevocationStatus
+	expires time.Time
+}
+
+// OCSPClient is an OCSPChecker querying the responders named in the certificates
+// Responses are verified against the issuer and cached until their next update
+type OCSPClient struct {
+	config OCSPClientConfig
+
+	mu    sync.Mutex
+	cache map[string]ocspCacheEntry
+	now   func() time.Time
+
+	requests  atomic.Uint64
+	cacheHits atomic.Uint64
+	errors    atomic.Uint64
+}
+
+// NewOCSPClient creates an OCSP client, a nil config uses the defaults
+func NewOCSPClient(config *OCSPClientConfig) *OCSPClient {
+	c := &OCSPClient{
+		cache: make(map[string]ocspCacheEntry),
+		now:   time.Now,
+	}
+	if config != nil {
+		c.config = *config
+	}
+	if c.config.HTTPClient == nil {
+		c.config.HTTPClient = http.DefaultClient
+	}
+	if c.config.CacheSize <= 0 {
+		c.config.CacheSize = DefaultOCSPCacheSize
+	}
+	if c.config.MaxCacheAge <= 0 {
+		c.config.MaxCacheAge = DefaultOCSPMaxCacheAge
+	}
+	return c
+}
+
+// CheckOCSP returns the status of leaf from the cache or from the first responder of leaf that answers
+func (c *OCSPClient) CheckOCSP(ctx context.Context, leaf, issuer *x509.Certificate) (RevocationStatus, error) {
+	if len(leaf.OCSPServer) == 0 {
+		return RevocationUnknown, ErrNoOCSPServer
+	}
+
+	key := string(leaf.RawIssuer) + "\x00" + leaf.SerialNumber.String()
+	now := c.now()
+	c.mu.Lock()
+	entry, ok := c.cache[key]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		c.cacheHits.Add(1)
+		return entry.status, nil
+	}
+
+	request, err := ocsp.CreateRequest(leaf, issuer, &ocsp.RequestOptions{Hash: crypto.SHA1})
+	if err != nil {
+		c.errors.Add(1)
+		return RevocationUnknown, fmt.Errorf("failed to create OCSP request: %w", err)
+	}
+
+	var lastErr error
+	for _, server := range leaf.OCSPServer {
+		resp, err := c.query(ctx, server, request, leaf, issuer)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		status := RevocationUnknown
+		switch resp.Status {
+		case ocsp.Good:
+			status = RevocationGood
+		case ocsp.Revoked:
+			status = RevocationRevoked
+		}
+		if status != RevocationUnknown {
+			expires := now.Add(c.config.MaxCacheAge)
+			if !resp.NextUpdate.IsZero() && resp.NextUpdate.Before(expires) {
+				expires = resp.NextUpdate
+			}
+			c.put(key, ocspCacheEntry{status: status, expires: expires})
+		}
+		return status, nil
+	}
+
+	c.errors.Add(1)
+	return RevocationUnknown, lastErr
+}
+
+// query sends request to server and returns the verified response for leaf
+func (c *OCSPClient) query(ctx context.Context, server string, request []byte, leaf, issuer *x509.Certificate) (*ocsp.Response, error) {
+	c.requests.Add(1)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server, bytes.NewReader(request))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OCSP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	req.Header.Set("Accept", "application/ocsp-response")
+
+	httpResp, err := c.config.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query OCSP responder: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: responder returned %s", ErrInvalidOCSPResponse, httpResp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(httpResp.Body, maxOCSPResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OCSP response: %w", err)
+	}
+
+	resp, err := ocsp.ParseResponseForCert(body, leaf, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidOCSPResponse, err)
+	}
+	if !resp.NextUpdate.IsZero() && c.now().After(resp.NextUpdate) {
+		return nil, fmt.Errorf("%w: response expired at %s", ErrInvalidOCSPResponse, resp.NextUpdate)
+	}
+	return resp, nil
+}
+
+func (c *OCSPClient) put(key string, entry ocspCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.cache[key]; !exists && len(c.cache) >= c.config.CacheSize {
+		now := c.now()
+		for k, e := range c.cache {
+			if !now.Before(e.expires) {
+				delete(c.cache, k)
+			}
+		}
+		// Without expired entries an arbitrary one makes room
+		for k := range c.cache {
+			if len(c.cache) < c.config.CacheSize {
+				break
+			}
+			delete(c.cache, k)
+		}
+	}
+	c.cache[key] = entry
+}
+
+// Stats returns OCSP client statistics
+func (c *OCSPClient) Stats() OCSPClientStats {
+	c.mu.Lock()
+	cached := len(c.cache)
+	c.mu.Unlock()
+	return OCSPClientStats{
+		Requests:  c.requests.Load(),
+		CacheHits: c.cacheHits.Load(),
+		Errors:    c.errors.Load(),
+		Cached:    cached,
+	}
+}
+
+// OCSPStapler staples the DER encoded OCSP response in a file to a server certificate
+// The file is checked for changes at most every reload interval during handshakes, so a staple renewed by
+// an external job is picked up without a restart, and an expired staple is no longer sent
+type OCSPStapler struct {
+	cert     tls.Certificate
+	issuer   *x509.Certificate
+	path     string
+	interval time.Duration
+
+	mu         sync.RWMutex
+	stapled    *tls.Certificate
+	nextUpdate time.Time
+	modTime    time.Time
+
+	lastReload atomic.Int64
+	now        func() time.Time
+}
+
+// NewOCSPStapler loads the staple at path for cert, the issuer is taken from the chain of cert when present
+func NewOCSPStapler(cert tls.Certificate, path string, interval time.Duration) (*OCSPStapler, error) {
+	s := &OCSPStapler{
+		cert:     cert,
+		path:     path,
+		interval: interval,
+		now:      time.Now,
+	}
+	if len(cert.Certificate) > 1 {
+		issuer, err := x509.ParseCertificate(cert.Certificate[1])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse issuer certificate: %w", err)
+		}
+		s.issuer = issuer
+	}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reads the staple file, keeping the previous staple if it fails to load
+func (s *OCSPStapler) Reload() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to stat OCSP staple: %w", err)
+	}
+	der, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to read OCSP staple: %w", err)
+	}
+
+	resp, err := ocsp.ParseResponse(der, s.issuer)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidOCSPResponse, err)
+	}
+	if s.cert.Leaf != nil && resp.SerialNumber.Cmp(s.cert.Leaf.SerialNumber) != 0 {
+		return fmt.Errorf("%w: staple is for another certificate", ErrInvalidOCSPResponse)
+	}
+
+	stapled := s.cert
+	stapled.OCSPStaple = der
+
+	s.mu.Lock()
+	s.stapled = &stapled
+	s.nextUpdate = resp.NextUpdate
+	s.modTime = info.ModTime()
+	s.mu.Unlock()
+
+	s.lastReload.Store(s.now().UnixNano())
+	return nil
+}
+
+// maybeReload reloads the staple when the file changed and the reload interval has passed since the last check
+func (s *OCSPStapler) maybeReload() {
+	if s.interval <= 0 {
+		return
+	}
+
+	now := s.now().UnixNano()
+	last := s.lastReload.Load()
+	if now-last < int64(s.interval) || !s.lastReload.CompareAndSwap(last, now) {
+		return
+	}
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return
+	}
+	s.mu.RLock()
+	changed := !info.ModTime().Equal(s.modTime)
+	s.mu.RUnlock()
+	if changed {
+		_ = s.Reload()
+	}
+}
+
+// GetCertificate returns the certificate with the current staple and can be used as tls.Config.GetCertificate
+func (s *OCSPStapler) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.maybeReload()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.nextUpdate.IsZero() && !s.now().Before(s.nextUpdate) {
+		return &s.cert, nil
+	}
+	return s.stapled, nil
+}
@@ -0,0 +1,201 @@
+package network
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ocsp"
+)
+
+func (ca *testCA) ocspResponse(t *testing.T, serial *big.Int, status int, nextUpdate time.Time) []byte {
+	template := ocsp.Response{
+		Status:       status,
+		SerialNumber: serial,
+		ThisUpdate:   time.Now().Add(-time.Minute),
+		NextUpdate:   nextUpdate,
+	}
+	if status == ocsp.Revoked {
+		template.RevokedAt = time.Now().Add(-time.Minute)
+	}
+	der, err := ocsp.CreateResponse(ca.cert, ca.cert, template, ca.key)
+	require.NoError(t, err)
+	return der
+}
+
+func (ca *testCA) issueWithOCSP(t *testing.T, serial int64, servers ...string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		OCSPServer:   servers,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, key
+}
+
+// newTestResponder answers OCSP requests with the status of the serials in statuses, unknown serials fail
+func newTestResponder(t *testing.T, ca *testCA, statuses map[int64]int, nextUpdate time.Duration) (*httptest.Server, *atomic.Int64) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		req, err := ocsp.ParseRequest(body)
+		require.NoError(t, err)
+
+		status, ok := statuses[req.SerialNumber.Int64()]
+		if !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		_, _ = w.Write(ca.ocspResponse(t, req.SerialNumber, status, time.Now().Add(nextUpdate)))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestOCSPClientCheck(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	server, requests := newTestResponder(t, ca, map[int64]int{1: ocsp.Good, 2: ocsp.Revoked, 3: ocsp.Unknown}, time.Hour)
+	client := NewOCSPClient(nil)
+	ctx := context.Background()
+
+	good, _ := ca.issueWithOCSP(t, 1, server.URL)
+	status, err := client.CheckOCSP(ctx, good, ca.cert)
+	require.NoError(t, err)
+	assert.Equal(t, RevocationGood, status)
+
+	revoked, _ := ca.issueWithOCSP(t, 2, server.URL)
+	status, err = client.CheckOCSP(ctx, revoked, ca.cert)
+	require.NoError(t, err)
+	assert.Equal(t, RevocationRevoked, status)
+
+	unknown, _ := ca.issueWithOCSP(t, 3, server.URL)
+	status, err = client.CheckOCSP(ctx, unknown, ca.cert)
+	require.NoError(t, err)
+	assert.Equal(t, RevocationUnknown, status)
+
+	failing, _ := ca.issueWithOCSP(t, 4, server.URL)
+	_, err = client.CheckOCSP(ctx, failing, ca.cert)
+	assert.ErrorIs(t, err, ErrInvalidOCSPResponse)
+
+	none, _ := ca.issueWithOCSP(t, 5)
+	_, err = client.CheckOCSP(ctx, none, ca.cert)
+	assert.ErrorIs(t, err, ErrNoOCSPServer)
+
+	// Good and revoked answers are cached
+	assert.Equal(t, int64(4), requests.Load())
+	status, err = client.CheckOCSP(ctx, good, ca.cert)
+	require.NoError(t, err)
+	assert.Equal(t, RevocationGood, status)
+	assert.Equal(t, int64(4), requests.Load())
+
+	stats := client.Stats()
+	assert.Equal(t, uint64(4), stats.Requests)
+	assert.Equal(t, uint64(1), stats.CacheHits)
+	assert.Equal(t, uint64(1), stats.Errors)
+	assert.Equal(t, 2, stats.Cached)
+}
+
+func TestOCSPClientCacheExpiry(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	server, requests := newTestResponder(t, ca, map[int64]int{1: ocsp.Good, 2: ocsp.Good}, time.Hour)
+	client := NewOCSPClient(&OCSPClientConfig{CacheSize: 1, MaxCacheAge: time.Minute})
+	now := time.Now()
+	client.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	cert, _ := ca.issueWithOCSP(t, 1, server.URL)
+	_, err := client.CheckOCSP(ctx, cert, ca.cert)
+	require.NoError(t, err)
+	_, err = client.CheckOCSP(ctx, cert, ca.cert)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), requests.Load())
+
+	// MaxCacheAge caps a response valid for an hour
+	now = now.Add(2 * time.Minute)
+	_, err = client.CheckOCSP(ctx, cert, ca.cert)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), requests.Load())
+
+	other, _ := ca.issueWithOCSP(t, 2, server.URL)
+	_, err = client.CheckOCSP(ctx, other, ca.cert)
+	require.NoError(t, err)
+	assert.Equal(t, 1, client.Stats().Cached)
+}
+
+func TestOCSPClientRejectsForeignSignature(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	other := newTestCA(t, "other")
+	server, _ := newTestResponder(t, other, map[int64]int{1: ocsp.Revoked}, time.Hour)
+
+	cert, _ := ca.issueWithOCSP(t, 1, server.URL)
+	status, err := NewOCSPClient(nil).CheckOCSP(context.Background(), cert, ca.cert)
+	assert.ErrorIs(t, err, ErrInvalidOCSPResponse)
+	assert.Equal(t, RevocationUnknown, status)
+}
+
+func TestOCSPStapler(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	leaf, key := ca.issueWithOCSP(t, 7)
+	cert := tls.Certificate{Certificate: [][]byte{leaf.Raw, ca.cert.Raw}, PrivateKey: key, Leaf: leaf}
+
+	path := filepath.Join(t.TempDir(), "staple.der")
+	first := ca.ocspResponse(t, leaf.SerialNumber, ocsp.Good, time.Now().Add(time.Hour))
+	require.NoError(t, os.WriteFile(path, first, 0o644))
+
+	stapler, err := NewOCSPStapler(cert, path, time.Minute)
+	require.NoError(t, err)
+	now := time.Now()
+	stapler.now = func() time.Time { return now }
+
+	got, err := stapler.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, first, got.OCSPStaple)
+
+	// A renewed staple is picked up once the reload interval passed
+	second := ca.ocspResponse(t, leaf.SerialNumber, ocsp.Good, time.Now().Add(2*time.Hour))
+	require.NoError(t, os.WriteFile(path, second, 0o644))
+	require.NoError(t, os.Chtimes(path, now, now.Add(time.Second)))
+	got, _ = stapler.GetCertificate(nil)
+	assert.Equal(t, first, got.OCSPStaple)
+	now = now.Add(2 * time.Minute)
+	got, _ = stapler.GetCertificate(nil)
+	assert.Equal(t, second, got.OCSPStaple)
+
+	// An expired staple is no longer sent
+	now = now.Add(3 * time.Hour)
+	got, _ = stapler.GetCertificate(nil)
+	assert.Nil(t, got.OCSPStaple)
+
+	// Staples for another certificate or signed by another issuer are rejected
+	other, _ := ca.issueWithOCSP(t, 8)
+	require.NoError(t, os.WriteFile(path, ca.ocspResponse(t, other.SerialNumber, ocsp.Good, time.Time{}), 0o644))
+	assert.ErrorIs(t, stapler.Reload(), ErrInvalidOCSPResponse)
+	require.NoError(t, os.WriteFile(path, newTestCA(t, "x").ocspResponse(t, leaf.SerialNumber, ocsp.Good, time.Time{}), 0o644))
+	assert.ErrorIs(t, stapler.Reload(), ErrInvalidOCSPResponse)
+}
@@ -0,0 +1,114 @@
+package network
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+)
+
+type AcceptPacingConfig struct {
+	MaxInFlight int
+	MaxQueued   int
+	MaxWait     time.Duration
+	Jitter      time.Duration
+	OnReject    func(*Connection)
+}
+
+func DefaultAcceptPacingConfig() *AcceptPacingConfig {
+	return &AcceptPacingConfig{
+		MaxInFlight: 256,
+		MaxQueued:   10000,
+		MaxWait:     10 * time.Second,
+		Jitter:      50 * time.Millisecond,
+	}
+}
+
+// HandshakePacer limits the number of CONNECT handshakes in flight and queues the rest
+type HandshakePacer struct {
+	config *AcceptPacingConfig
+	slots  chan struct{}
+
+	queued   atomic.Int64
+	rejected atomic.Uint64
+}
+
+func NewHandshakePacer(config *AcceptPacingConfig) *HandshakePacer {
+	if config == nil {
+		config = DefaultAcceptPacingConfig()
+	}
+
+	maxInFlight := config.MaxInFlight
+	if maxInFlight <= 0 {
+		maxInFlight = 1
+	}
+
+	return &HandshakePacer{
+		config: config,
+		slots:  make(chan struct{}, maxInFlight),
+	}
+}
+
+// Acquire waits for a handshake slot and returns a function releasing it
+// Waiters are admitted after a random jitter so a reconnect storm is spread out instead of released at once
+func (p *HandshakePacer) Acquire(ctx context.Context) (func(), error) {
+	select {
+	case p.slots <- struct{}{}:
+		return p.releaser(), nil
+	default:
+	}
+
+	if p.config.MaxQueued > 0 && p.queued.Load() >= int64(p.config.MaxQueued) {
+		p.rejected.Add(1)
+		return nil, ErrServerBusy
+	}
+
+	p.queued.Add(1)
+	defer p.queued.Add(-1)
+
+	if p.config.MaxWait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.config.MaxWait)
+		defer cancel()
+	}
+
+	if p.config.Jitter > 0 {
+		timer := time.NewTimer(rand.N(p.config.Jitter))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			p.rejected.Add(1)
+			return nil, ErrServerBusy
+		}
+	}
+
+	select {
+	case p.slots <- struct{}{}:
+		return p.releaser(), nil
+	case <-ctx.Done():
+		p.rejected.Add(1)
+		return nil, ErrServerBusy
+	}
+}
+
+func (p *HandshakePacer) releaser() func() {
+	var released atomic.Bool
+	return func() {
+		if released.CompareAndSwap(false, true) {
+			<-p.slots
+		}
+	}
+}
+
+func (p *HandshakePacer) InFlight() int {
+	return len(p.slots)
+}
+
+func (p *HandshakePacer) Queued() int64 {
+	return p.queued.Load()
+}
+
+func (p *HandshakePacer) Rejected() uint64 {
+	return p.rejected.Load()
+}
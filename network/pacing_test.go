@@ -0,0 +1,175 @@
+package network
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultAcceptPacingConfig(t *testing.T) {
+	config := DefaultAcceptPacingConfig()
+	assert.Equal(t, 256, config.MaxInFlight)
+	assert.Equal(t, 10000, config.MaxQueued)
+	assert.Equal(t, 10*time.Second, config.MaxWait)
+	assert.Equal(t, 50*time.Millisecond, config.Jitter)
+}
+
+func TestHandshakePacerAcquireRelease(t *testing.T) {
+	pacer := NewHandshakePacer(&AcceptPacingConfig{MaxInFlight: 2, MaxWait: time.Second})
+
+	release1, err := pacer.Acquire(context.Background())
+	require.NoError(t, err)
+	release2, err := pacer.Acquire(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, pacer.InFlight())
+
+	release1()
+	release1()
+	assert.Equal(t, 1, pacer.InFlight())
+
+	release2()
+	assert.Equal(t, 0, pacer.InFlight())
+}
+
+func TestHandshakePacerQueuesUntilSlotFree(t *testing.T) {
+	pacer := NewHandshakePacer(&AcceptPacingConfig{MaxInFlight: 1, MaxWait: time.Second, Jitter: 5 * time.Millisecond})
+
+	release, err := pacer.Acquire(context.Background())
+	require.NoError(t, err)
+
+	done := make(chan error, 1)
+	go func() {
+		r, err := pacer.Acquire(context.Background())
+		if err == nil {
+			r()
+		}
+		done <- err
+	}()
+
+	require.Eventually(t, func() bool { return pacer.Queued() == 1 }, time.Second, time.Millisecond)
+	release()
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("queued handshake was not admitted")
+	}
+	assert.Equal(t, int64(0), pacer.Queued())
+	assert.Equal(t, uint64(0), pacer.Rejected())
+}
+
+func TestHandshakePacerMaxWait(t *testing.T) {
+	pacer := NewHandshakePacer(&AcceptPacingConfig{MaxInFlight: 1, MaxWait: 20 * time.Millisecond})
+
+	release, err := pacer.Acquire(context.Background())
+	require.NoError(t, err)
+	defer release()
+
+	start := time.Now()
+	_, err = pacer.Acquire(context.Background())
+	assert.ErrorIs(t, err, ErrServerBusy)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+	assert.Equal(t, uint64(1), pacer.Rejected())
+}
+
+func TestHandshakePacerMaxQueued(t *testing.T) {
+	pacer := NewHandshakePacer(&AcceptPacingConfig{MaxInFlight: 1, MaxQueued: 1, MaxWait: time.Second})
+
+	release, err := pacer.Acquire(context.Background())
+	require.NoError(t, err)
+
+	go func() {
+		if r, err := pacer.Acquire(context.Background()); err == nil {
+			r()
+		}
+	}()
+	require.Eventually(t, func() bool { return pacer.Queued() == 1 }, time.Second, time.Millisecond)
+
+	_, err = pacer.Acquire(context.Background())
+	assert.ErrorIs(t, err, ErrServerBusy)
+	assert.Equal(t, uint64(1), pacer.Rejected())
+
+	release()
+	require.Eventually(t, func() bool { return pacer.Queued() == 0 && pacer.InFlight() == 0 }, time.Second, time.Millisecond)
+}
+
+func TestHandshakePacerContextCanceled(t *testing.T) {
+	pacer := NewHandshakePacer(&AcceptPacingConfig{MaxInFlight: 1})
+
+	release, err := pacer.Acquire(context.Background())
+	require.NoError(t, err)
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = pacer.Acquire(ctx)
+	assert.ErrorIs(t, err, ErrServerBusy)
+}
+
+func TestListenerAcceptPacing(t *testing.T) {
+	var rejected atomic.Int32
+	config := &ListenerConfig{
+		Address:      "127.0.0.1:0",
+		TCPKeepAlive: 10 * time.Second,
+		AcceptPacing: &AcceptPacingConfig{
+			MaxInFlight: 1,
+			MaxWait:     50 * time.Millisecond,
+			OnReject: func(conn *Connection) {
+				rejected.Add(1)
+			},
+		},
+	}
+
+	listener, err := NewListener(config, nil)
+	require.NoError(t, err)
+
+	block := make(chan struct{})
+	listener.OnConnection(func(conn *Connection) error {
+		<-block
+		return nil
+	})
+
+	require.NoError(t, listener.Start())
+	defer listener.Close()
+	defer close(block)
+
+	addr := listener.Addr().String()
+	first, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer first.Close()
+
+	require.Eventually(t, func() bool { return listener.Stats().InFlightHandshakes == 1 }, time.Second, time.Millisecond)
+
+	second, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer second.Close()
+
+	require.Eventually(t, func() bool { return rejected.Load() == 1 }, time.Second, time.Millisecond)
+	stats := listener.Stats()
+	assert.Equal(t, uint64(1), stats.Rejected)
+	assert.Equal(t, uint64(1), stats.Accepted)
+	assert.Equal(t, int64(0), stats.QueuedHandshakes)
+}
+
+func TestConnectionCompleteHandshake(t *testing.T) {
+	pacer := NewHandshakePacer(&AcceptPacingConfig{MaxInFlight: 1})
+	release, err := pacer.Acquire(context.Background())
+	require.NoError(t, err)
+
+	server, client := net.Pipe()
+	defer client.Close()
+
+	conn := NewConnection(server, "test", nil)
+	conn.handshakeRelease = release
+	conn.CompleteHandshake()
+	assert.Equal(t, 0, pacer.InFlight())
+
+	conn.Close()
+	assert.Equal(t, 0, pacer.InFlight())
+}
@@ -0,0 +1,235 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// proxyV1Prefix starts every PROXY protocol version 1 header
+	proxyV1Prefix = []byte("PROXY ")
+	// proxyV2Signature starts every PROXY protocol version 2 header
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// maxProxyV1Header is the longest version 1 header including CRLF
+const maxProxyV1Header = 107
+
+type ProxyProtocolConfig struct {
+	// TrustedProxies lists the networks allowed to send a PROXY header, it must be set since empty trusts no
+	// peer. A header sent by any other peer is rejected with ErrUntrustedProxy instead of spoofing its address
+	TrustedProxies []netip.Prefix
+	// Required rejects trusted peers that do not send a header
+	Required bool
+	// HeaderTimeout bounds how long the header may take to arrive
+	HeaderTimeout time.Duration
+}
+
+func DefaultProxyProtocolConfig() *ProxyProtocolConfig {
+	return &ProxyProtocolConfig{
+		Required:      true,
+		HeaderTimeout: 5 * time.Second,
+	}
+}
+
+// proxyConn reports the client address carried in the PROXY header and replays bytes read past it
+type proxyConn struct {
+	net.Conn
+	reader *bufio.Reader
+	remote net.Addr
+	local  net.Addr
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// NetConn returns the wrapped connection
+func (c *proxyConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// ProxyProtocolMiddleware parses a PROXY protocol v1 or v2 header sent by a trusted load balancer
+// The returned connection reports the original client address so later stages and the broker see the real peer
+func ProxyProtocolMiddleware(config *ProxyProtocolConfig) ConnMiddleware {
+	if config == nil {
+		config = DefaultProxyProtocolConfig()
+	}
+	return func(_ context.Context, conn net.Conn) (net.Conn, error) {
+		if config.HeaderTimeout > 0 {
+			if err := conn.SetReadDeadline(time.Now().Add(config.HeaderTimeout)); err != nil {
+				return nil, err
+			}
+			defer conn.SetReadDeadline(time.Time{})
+		}
+
+		reader := bufio.NewReader(conn)
+		if !config.trusted(conn.RemoteAddr()) {
+			return rejectProxyHeader(conn, reader)
+		}
+
+		remote, local, err := readProxyHeader(reader, config.Required)
+		if err != nil {
+			return nil, err
+		}
+
+		pc := &proxyConn{Conn: conn, reader: reader, remote: conn.RemoteAddr(), local: conn.LocalAddr()}
+		if remote != nil {
+			pc.remote, pc.local = remote, local
+		}
+		return pc, nil
+	}
+}
+
+func (c *ProxyProtocolConfig) trusted(addr net.Addr) bool {
+	ip, ok := peerIP(addr)
+	if !ok {
+		return false
+	}
+	for _, prefix := range c.TrustedProxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// rejectProxyHeader passes on the connection of an untrusted peer, which must not start with a PROXY header
+// The first bytes are peeked, an MQTT client sends its CONNECT right away
+func rejectProxyHeader(conn net.Conn, r *bufio.Reader) (net.Conn, error) {
+	peek, err := r.Peek(len(proxyV1Prefix))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(peek, proxyV1Prefix) || bytes.HasPrefix(proxyV2Signature, peek) {
+		return nil, ErrUntrustedProxy
+	}
+	return &proxyConn{Conn: conn, reader: r, remote: conn.RemoteAddr(), local: conn.LocalAddr()}, nil
+}
+
+// readProxyHeader consumes the header and returns the addresses it carries
+// Nil addresses mean the header is absent or describes a local connection, the socket addresses apply then
+func readProxyHeader(r *bufio.Reader, required bool) (net.Addr, net.Addr, error) {
+	peek, err := r.Peek(len(proxyV2Signature))
+	switch {
+	case bytes.Equal(peek, proxyV2Signature):
+		return readProxyV2(r)
+	case bytes.HasPrefix(peek, proxyV1Prefix):
+		return readProxyV1(r)
+	case err != nil && len(peek) == 0:
+		return nil, nil, err
+	case required:
+		return nil, nil, fmt.Errorf("%w: header missing", ErrInvalidProxyHeader)
+	}
+	return nil, nil, nil
+}
+
+func readProxyV1(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	var line []byte
+	for len(line) < maxProxyV1Header {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, fmt.Errorf("%w: v1 header not terminated", ErrInvalidProxyHeader)
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("%w: malformed v1 header", ErrInvalidProxyHeader)
+	}
+
+	src, err := parseProxyV1Addr(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := parseProxyV1Addr(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+func parseProxyV1Addr(host, port string) (net.Addr, error) {
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidProxyHeader, err)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidProxyHeader, err)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(p))), nil
+}
+
+func readProxyV2(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	var header [16]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidProxyHeader, header[12]>>4)
+	}
+
+	body := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, nil, err
+	}
+
+	// LOCAL commands come from the proxy itself, e.g. health checks
+	if header[12]&0x0f == 0 {
+		return nil, nil, nil
+	}
+
+	var size int
+	switch header[13] >> 4 {
+	case 1:
+		size = 4
+	case 2:
+		size = 16
+	default:
+		// Unix and unspecified families carry no IP address
+		return nil, nil, nil
+	}
+	if len(body) < 2*size+4 {
+		return nil, nil, fmt.Errorf("%w: v2 address block too short", ErrInvalidProxyHeader)
+	}
+
+	srcIP, _ := netip.AddrFromSlice(body[:size])
+	dstIP, _ := netip.AddrFromSlice(body[size : 2*size])
+	srcPort := binary.BigEndian.Uint16(body[2*size:])
+	dstPort := binary.BigEndian.Uint16(body[2*size+2:])
+
+	if header[13]&0x0f == 2 {
+		return net.UDPAddrFromAddrPort(netip.AddrPortFrom(srcIP, srcPort)),
+			net.UDPAddrFromAddrPort(netip.AddrPortFrom(dstIP, dstPort)), nil
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(srcIP, srcPort)),
+		net.TCPAddrFromAddrPort(netip.AddrPortFrom(dstIP, dstPort)), nil
+}
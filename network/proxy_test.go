@@ -0,0 +1,135 @@
+package network
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// balancerAddr is the address of the load balancer sending the PROXY headers of the tests
+var balancerAddr = &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 51000}
+
+// peerConn reports a peer address for a pipe
+type peerConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *peerConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// handleProxy runs the middleware on a connection from peer, the load balancer is trusted unless config says otherwise
+func handleProxy(t *testing.T, config *ProxyProtocolConfig, data []byte) (net.Conn, error) {
+	t.Helper()
+	return handleProxyFrom(t, config, balancerAddr, data)
+}
+
+func handleProxyFrom(t *testing.T, config *ProxyProtocolConfig, peer net.Addr, data []byte) (net.Conn, error) {
+	t.Helper()
+	if config == nil {
+		config = DefaultProxyProtocolConfig()
+	}
+	if config.TrustedProxies == nil {
+		config.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	}
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+
+	go func() {
+		_, _ = client.Write(data)
+	}()
+	return ProxyProtocolMiddleware(config)(context.Background(), &peerConn{Conn: server, remote: peer})
+}
+
+func TestProxyProtocolV1(t *testing.T) {
+	conn, err := handleProxy(t, &ProxyProtocolConfig{Required: true},
+		[]byte("PROXY TCP4 192.0.2.1 198.51.100.1 40000 1883\r\nMQTT"))
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.1:40000", conn.RemoteAddr().String())
+	assert.Equal(t, "198.51.100.1:1883", conn.LocalAddr().String())
+
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "MQTT", string(buf))
+}
+
+func TestProxyProtocolV1Unknown(t *testing.T) {
+	conn, err := handleProxy(t, &ProxyProtocolConfig{Required: true}, []byte("PROXY UNKNOWN\r\nMQTT"))
+	require.NoError(t, err)
+	assert.Equal(t, balancerAddr.String(), conn.RemoteAddr().String())
+}
+
+func TestProxyProtocolV2(t *testing.T) {
+	header := append([]byte(nil), proxyV2Signature...)
+	header = append(header, 0x21, 0x11, 0, 12)
+	header = append(header, 192, 0, 2, 7, 198, 51, 100, 1)
+	header = binary.BigEndian.AppendUint16(header, 50000)
+	header = binary.BigEndian.AppendUint16(header, 8883)
+
+	conn, err := handleProxy(t, nil, append(header, "MQTT"...))
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.7:50000", conn.RemoteAddr().String())
+
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "MQTT", string(buf))
+}
+
+func TestProxyProtocolMissingHeader(t *testing.T) {
+	_, err := handleProxy(t, &ProxyProtocolConfig{Required: true}, []byte("\x10\x0e\x00\x04MQTT\x05\x02\x00\x3c\x00\x00"))
+	assert.ErrorIs(t, err, ErrInvalidProxyHeader)
+
+	conn, err := handleProxy(t, &ProxyProtocolConfig{}, []byte("\x10\x0e\x00\x04MQTT\x05\x02\x00\x3c\x00\x00"))
+	require.NoError(t, err)
+	buf := make([]byte, 2)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x10, 0x0e}, buf)
+}
+
+func TestProxyProtocolMalformed(t *testing.T) {
+	_, err := handleProxy(t, nil, []byte("PROXY TCP4 nonsense\r\n"))
+	assert.ErrorIs(t, err, ErrInvalidProxyHeader)
+}
+
+func TestProxyProtocolUntrustedPeer(t *testing.T) {
+	config := &ProxyProtocolConfig{
+		TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		Required:       true,
+	}
+	assert.True(t, config.trusted(&net.TCPAddr{IP: net.ParseIP("10.1.2.3")}))
+	assert.False(t, config.trusted(&net.TCPAddr{IP: net.ParseIP("192.0.2.1")}))
+	assert.False(t, (&ProxyProtocolConfig{}).trusted(&net.TCPAddr{IP: net.ParseIP("10.1.2.3")}), "no networks trust no peer")
+
+	client := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 40000}
+	connect := []byte("\x10\x0e\x00\x04MQTT\x05\x02\x00\x3c\x00\x00")
+	conn, err := handleProxyFrom(t, config, client, connect)
+	require.NoError(t, err)
+	assert.Equal(t, client.String(), conn.RemoteAddr().String())
+	buf := make([]byte, len(connect))
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, connect, buf)
+
+	_, err = handleProxyFrom(t, config, client, []byte("PROXY TCP4 10.9.9.9 198.51.100.1 40000 1883\r\nMQTT"))
+	assert.ErrorIs(t, err, ErrUntrustedProxy)
+	_, err = handleProxyFrom(t, config, client, append(append([]byte(nil), proxyV2Signature...), 0x21, 0x11, 0, 12))
+	assert.ErrorIs(t, err, ErrUntrustedProxy)
+
+	// Without trusted networks every header is rejected
+	_, err = handleProxyFrom(t, &ProxyProtocolConfig{TrustedProxies: []netip.Prefix{}}, balancerAddr,
+		[]byte("PROXY TCP4 192.0.2.1 198.51.100.1 40000 1883\r\nMQTT"))
+	assert.ErrorIs(t, err, ErrUntrustedProxy)
+}
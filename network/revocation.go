@@ -0,0 +1,299 @@
+package network
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type RevocationStatus int
+
+const (
+	RevocationGood RevocationStatus = iota
+	RevocationRevoked
+	RevocationUnknown
+)
+
+// OCSPChecker queries the revocation status of a certificate from an OCSP responder
+type OCSPChecker interface {
+	CheckOCSP(ctx context.Context, leaf, issuer *x509.Certificate) (RevocationStatus, error)
+}
+
+type OCSPCheckerFunc func(ctx context.Context, leaf, issuer *x509.Certificate) (RevocationStatus, error)
+
+func (f OCSPCheckerFunc) CheckOCSP(ctx context.Context, leaf, issuer *x509.Certificate) (RevocationStatus, error) {
+	return f(ctx, leaf, issuer)
+}
+
+type RevocationConfig struct {
+	// CRLFiles are PEM or DER encoded CRLs, reloaded when their modification time changes
+	CRLFiles       []string
+	ReloadInterval time.Duration
+	// OCSP queries the revocation status of client certificates, NewOCSPClient queries the responders
+	// named in the certificates
+	OCSP        OCSPChecker
+	OCSPTimeout time.Duration
+	// SoftFail accepts certificates whose status cannot be determined, e.g. when the OCSP responder is down
+	SoftFail bool
+	// OCSPStapleFile is a DER encoded OCSP response stapled to the server certificate, reloaded when it
+	// changes like the CRL files
+	OCSPStapleFile string
+}
+
+func DefaultRevocationConfig() *RevocationConfig {
+	return &RevocationConfig{
+		ReloadInterval: time.Minute,
+		OCSPTimeout:    5 * time.Second,
+		SoftFail:       true,
+	}
+}
+
+type crlEntry struct {
+	list       *x509.RevocationList
+	serials    map[string]struct{}
+	verifiedBy atomic.Pointer[x509.Certificate]
+}
+
+// RevocationChecker rejects client certificates revoked by a CRL or an OCSP responder
+type RevocationChecker struct {
+	config *RevocationConfig
+
+	mu       sync.RWMutex
+	crls     map[string][]*crlEntry
+	modTimes map[string]time.Time
+
+	lastReload atomic.Int64
+	now        func() time.Time
+
+	revoked    atomic.Uint64
+	softFailed atomic.Uint64
+}
+
+func NewRevocationChecker(config *RevocationConfig) (*RevocationChecker, error) {
+	if config == nil {
+		config = DefaultRevocationConfig()
+	}
+
+	rc := &RevocationChecker{
+		config:   config,
+		crls:     make(map[string][]*crlEntry),
+		modTimes: make(map[string]time.Time),
+		now:      time.Now,
+	}
+
+	if err := rc.Reload(); err != nil {
+		return nil, err
+	}
+	return rc, nil
+}
+
+// Reload reads all CRL files, keeping the previous lists if any file fails to load
+func (rc *RevocationChecker) Reload() error {
+	crls := make(map[string][]*crlEntry)
+	modTimes := make(map[string]time.Time, len(rc.config.CRLFiles))
+
+	for _, path := range rc.config.CRLFiles {
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("failed to stat CRL file: %w", err)
+		}
+
+		lists, err := loadCRLFile(path)
+		if err != nil {
+			return err
+		}
+
+		for _, list := range lists {
+			entry := &crlEntry{
+				list:    list,
+				serials: make(map[string]struct{}, len(list.RevokedCertificateEntries)),
+			}
+			for _, revoked := range list.RevokedCertificateEntries {
+				entry.serials[revoked.SerialNumber.String()] = struct{}{}
+			}
+			issuer := string(list.RawIssuer)
+			crls[issuer] = append(crls[issuer], entry)
+		}
+		modTimes[path] = info.ModTime()
+	}
+
+	rc.mu.Lock()
+	rc.crls = crls
+	rc.modTimes = modTimes
+	rc.mu.Unlock()
+
+	rc.lastReload.Store(rc.now().UnixNano())
+	return nil
+}
+
+func loadCRLFile(path string) ([]*x509.RevocationList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CRL file: %w", err)
+	}
+
+	lists := make([]*x509.RevocationList, 0, 1)
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "X509 CRL" {
+			continue
+		}
+		list, err := x509.ParseRevocationList(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CRL: %w", err)
+		}
+		lists = append(lists, list)
+	}
+
+	if len(lists) == 0 {
+		list, err := x509.ParseRevocationList(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CRL: %w", err)
+		}
+		lists = append(lists, list)
+	}
+	return lists, nil
+}
+
+// maybeReload reloads the CRL files when one changed and ReloadInterval has passed since the last check
+func (rc *RevocationChecker) maybeReload() {
+	if rc.config.ReloadInterval <= 0 || len(rc.config.CRLFiles) == 0 {
+		return
+	}
+
+	now := rc.now().UnixNano()
+	last := rc.lastReload.Load()
+	if now-last < int64(rc.config.ReloadInterval) || !rc.lastReload.CompareAndSwap(last, now) {
+		return
+	}
+
+	rc.mu.RLock()
+	changed := false
+	for _, path := range rc.config.CRLFiles {
+		info, err := os.Stat(path)
+		if err == nil && !info.ModTime().Equal(rc.modTimes[path]) {
+			changed = true
+			break
+		}
+	}
+	rc.mu.RUnlock()
+
+	if changed {
+		_ = rc.Reload()
+	}
+}
+
+// CheckCRL returns the CRL status of cert, verifying the CRL signature against issuer when given
+func (rc *RevocationChecker) CheckCRL(cert, issuer *x509.Certificate) RevocationStatus {
+	rc.maybeReload()
+
+	rc.mu.RLock()
+	entries := rc.crls[string(cert.RawIssuer)]
+	rc.mu.RUnlock()
+
+	if len(entries) == 0 {
+		return RevocationUnknown
+	}
+
+	status := RevocationUnknown
+	now := rc.now()
+	for _, entry := range entries {
+		if issuer != nil {
+			if verifiedBy := entry.verifiedBy.Load(); verifiedBy == nil || !verifiedBy.Equal(issuer) {
+				if entry.list.CheckSignatureFrom(issuer) != nil {
+					continue
+				}
+				entry.verifiedBy.Store(issuer)
+			}
+		}
+
+		if _, ok := entry.serials[cert.SerialNumber.String()]; ok {
+			return RevocationRevoked
+		}
+		if entry.list.NextUpdate.IsZero() || now.Before(entry.list.NextUpdate) {
+			status = RevocationGood
+		}
+	}
+	return status
+}
+
+// Check returns nil when cert is not revoked
+// A certificate is revoked if any source says so, good if any source vouches for it and unknown otherwise
+func (rc *RevocationChecker) Check(cert, issuer *x509.Certificate) error {
+	status := rc.CheckCRL(cert, issuer)
+
+	if status != RevocationRevoked && rc.config.OCSP != nil && issuer != nil {
+		timeout := rc.config.OCSPTimeout
+		if timeout <= 0 {
+			timeout = DefaultRevocationConfig().OCSPTimeout
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		ocspStatus, err := rc.config.OCSP.CheckOCSP(ctx, cert, issuer)
+		cancel()
+
+		if err == nil && ocspStatus != RevocationUnknown {
+			status = ocspStatus
+		}
+	}
+
+	switch status {
+	case RevocationRevoked:
+		rc.revoked.Add(1)
+		return ErrCertificateRevoked
+	case RevocationUnknown:
+		if len(rc.config.CRLFiles) == 0 && rc.config.OCSP == nil {
+			return nil
+		}
+		if rc.config.SoftFail {
+			rc.softFailed.Add(1)
+			return nil
+		}
+		return ErrRevocationUnknown
+	default:
+		return nil
+	}
+}
+
+// VerifyPeerCertificate checks the leaf of every verified chain and can be used as tls.Config.VerifyPeerCertificate
+// Without verified chains, e.g. with RequireAnyClientCert, the leaf is checked against the CRLs only
+func (rc *RevocationChecker) VerifyPeerCertificate(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	if len(verifiedChains) == 0 {
+		if len(rawCerts) == 0 {
+			return nil
+		}
+		leaf, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return fmt.Errorf("failed to parse certificate: %w", err)
+		}
+		return rc.Check(leaf, nil)
+	}
+
+	for _, chain := range verifiedChains {
+		var issuer *x509.Certificate
+		if len(chain) > 1 {
+			issuer = chain[1]
+		}
+		if err := rc.Check(chain[0], issuer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rc *RevocationChecker) Revoked() uint64 {
+	return rc.revoked.Load()
+}
+
+func (rc *RevocationChecker) SoftFailed() uint64 {
+	return rc.softFailed.Load()
+}
@@ -0,0 +1,273 @@
+package network
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ocsp"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) issue(t *testing.T, serial int64) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "device"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert
+}
+
+func (ca *testCA) crl(t *testing.T, number int64, nextUpdate time.Time, serials ...int64) []byte {
+	entries := make([]x509.RevocationListEntry, 0, len(serials))
+	for _, serial := range serials {
+		entries = append(entries, x509.RevocationListEntry{SerialNumber: big.NewInt(serial), RevocationTime: time.Now()})
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(number),
+		ThisUpdate:                time.Now().Add(-2 * time.Hour),
+		NextUpdate:                nextUpdate,
+		RevokedCertificateEntries: entries,
+	}, ca.cert, ca.key)
+	require.NoError(t, err)
+	return der
+}
+
+func writeCRL(t *testing.T, path string, der []byte, asPEM bool) {
+	data := der
+	if asPEM {
+		data = pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+	}
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+}
+
+func TestDefaultRevocationConfig(t *testing.T) {
+	config := DefaultRevocationConfig()
+	assert.Equal(t, time.Minute, config.ReloadInterval)
+	assert.Equal(t, 5*time.Second, config.OCSPTimeout)
+	assert.True(t, config.SoftFail)
+}
+
+func TestRevocationCheckerCRL(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	other := newTestCA(t, "other")
+
+	for _, asPEM := range []bool{true, false} {
+		path := filepath.Join(t.TempDir(), "ca.crl")
+		writeCRL(t, path, ca.crl(t, 1, time.Now().Add(time.Hour), 2), asPEM)
+
+		rc, err := NewRevocationChecker(&RevocationConfig{CRLFiles: []string{path}})
+		require.NoError(t, err)
+
+		assert.Equal(t, RevocationRevoked, rc.CheckCRL(ca.issue(t, 2), ca.cert))
+		assert.Equal(t, RevocationGood, rc.CheckCRL(ca.issue(t, 3), ca.cert))
+		assert.Equal(t, RevocationUnknown, rc.CheckCRL(other.issue(t, 2), other.cert))
+
+		// A CRL that does not verify against the presented issuer is ignored
+		assert.Equal(t, RevocationUnknown, rc.CheckCRL(ca.issue(t, 2), other.cert))
+	}
+}
+
+func TestRevocationCheckerStaleCRL(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	path := filepath.Join(t.TempDir(), "ca.crl")
+	writeCRL(t, path, ca.crl(t, 1, time.Now().Add(-time.Minute), 2), true)
+
+	rc, err := NewRevocationChecker(&RevocationConfig{CRLFiles: []string{path}})
+	require.NoError(t, err)
+
+	assert.Equal(t, RevocationRevoked, rc.CheckCRL(ca.issue(t, 2), ca.cert))
+	assert.Equal(t, RevocationUnknown, rc.CheckCRL(ca.issue(t, 3), ca.cert))
+}
+
+func TestRevocationCheckerSoftFail(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	other := newTestCA(t, "other")
+	path := filepath.Join(t.TempDir(), "ca.crl")
+	writeCRL(t, path, ca.crl(t, 1, time.Now().Add(time.Hour), 2), true)
+
+	soft, err := NewRevocationChecker(&RevocationConfig{CRLFiles: []string{path}, SoftFail: true})
+	require.NoError(t, err)
+	assert.NoError(t, soft.Check(other.issue(t, 5), other.cert))
+	assert.Equal(t, uint64(1), soft.SoftFailed())
+	assert.ErrorIs(t, soft.Check(ca.issue(t, 2), ca.cert), ErrCertificateRevoked)
+	assert.Equal(t, uint64(1), soft.Revoked())
+
+	hard, err := NewRevocationChecker(&RevocationConfig{CRLFiles: []string{path}})
+	require.NoError(t, err)
+	assert.ErrorIs(t, hard.Check(other.issue(t, 5), other.cert), ErrRevocationUnknown)
+	assert.NoError(t, hard.Check(ca.issue(t, 3), ca.cert))
+}
+
+func TestRevocationCheckerHotReload(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	path := filepath.Join(t.TempDir(), "ca.crl")
+	writeCRL(t, path, ca.crl(t, 1, time.Now().Add(time.Hour)), true)
+
+	rc, err := NewRevocationChecker(&RevocationConfig{CRLFiles: []string{path}, ReloadInterval: time.Minute})
+	require.NoError(t, err)
+	now := time.Now()
+	rc.now = func() time.Time { return now }
+
+	cert := ca.issue(t, 7)
+	assert.Equal(t, RevocationGood, rc.CheckCRL(cert, ca.cert))
+
+	writeCRL(t, path, ca.crl(t, 2, time.Now().Add(time.Hour), 7), true)
+	require.NoError(t, os.Chtimes(path, now.Add(time.Second), now.Add(time.Second)))
+
+	// Not reloaded before the interval has passed
+	assert.Equal(t, RevocationGood, rc.CheckCRL(cert, ca.cert))
+
+	now = now.Add(2 * time.Minute)
+	assert.Equal(t, RevocationRevoked, rc.CheckCRL(cert, ca.cert))
+
+	// A broken file keeps the last good lists
+	require.NoError(t, os.WriteFile(path, []byte("garbage"), 0o644))
+	require.NoError(t, os.Chtimes(path, now.Add(time.Hour), now.Add(time.Hour)))
+	now = now.Add(2 * time.Minute)
+	assert.Equal(t, RevocationRevoked, rc.CheckCRL(cert, ca.cert))
+}
+
+func TestNewRevocationCheckerErrors(t *testing.T) {
+	_, err := NewRevocationChecker(&RevocationConfig{CRLFiles: []string{"/nonexistent/ca.crl"}})
+	assert.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "bad.crl")
+	require.NoError(t, os.WriteFile(path, []byte("not a crl"), 0o644))
+	_, err = NewRevocationChecker(&RevocationConfig{CRLFiles: []string{path}})
+	assert.Error(t, err)
+}
+
+func TestRevocationCheckerOCSP(t *testing.T) {
+	ca := newTestCA(t, "ca")
+
+	tests := []struct {
+		name     string
+		status   RevocationStatus
+		err      error
+		softFail bool
+		expected error
+	}{
+		{"good", RevocationGood, nil, false, nil},
+		{"revoked", RevocationRevoked, nil, true, ErrCertificateRevoked},
+		{"responder down soft fail", RevocationUnknown, errors.New("timeout"), true, nil},
+		{"responder down hard fail", RevocationUnknown, errors.New("timeout"), false, ErrRevocationUnknown},
+		{"unknown hard fail", RevocationUnknown, nil, false, ErrRevocationUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, err := NewRevocationChecker(&RevocationConfig{
+				SoftFail: tt.softFail,
+				OCSP: OCSPCheckerFunc(func(ctx context.Context, leaf, issuer *x509.Certificate) (RevocationStatus, error) {
+					_, hasDeadline := ctx.Deadline()
+					assert.True(t, hasDeadline)
+					return tt.status, tt.err
+				}),
+			})
+			require.NoError(t, err)
+
+			err = rc.Check(ca.issue(t, 9), ca.cert)
+			if tt.expected == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRevocationCheckerVerifyPeerCertificate(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	path := filepath.Join(t.TempDir(), "ca.crl")
+	writeCRL(t, path, ca.crl(t, 1, time.Now().Add(time.Hour), 2), true)
+
+	rc, err := NewRevocationChecker(&RevocationConfig{CRLFiles: []string{path}})
+	require.NoError(t, err)
+
+	revoked := ca.issue(t, 2)
+	good := ca.issue(t, 3)
+
+	assert.ErrorIs(t, rc.VerifyPeerCertificate(nil, [][]*x509.Certificate{{revoked, ca.cert}}), ErrCertificateRevoked)
+	assert.NoError(t, rc.VerifyPeerCertificate(nil, [][]*x509.Certificate{{good, ca.cert}}))
+	assert.ErrorIs(t, rc.VerifyPeerCertificate([][]byte{revoked.Raw}, nil), ErrCertificateRevoked)
+	assert.NoError(t, rc.VerifyPeerCertificate(nil, nil))
+	assert.Error(t, rc.VerifyPeerCertificate([][]byte{[]byte("garbage")}, nil))
+}
+
+func TestTLSConfigBuildWithRevocation(t *testing.T) {
+	certPEM, keyPEM, err := generateTestCertificate()
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	stapleFile := filepath.Join(dir, "staple.der")
+	require.NoError(t, os.WriteFile(certFile, certPEM, 0o644))
+	require.NoError(t, os.WriteFile(keyFile, keyPEM, 0o644))
+	staple := newTestCA(t, "ca").ocspResponse(t, big.NewInt(1), ocsp.Good, time.Now().Add(time.Hour))
+	require.NoError(t, os.WriteFile(stapleFile, staple, 0o644))
+
+	tc := &TLSConfig{
+		CertFile:   certFile,
+		KeyFile:    keyFile,
+		Revocation: &RevocationConfig{OCSPStapleFile: stapleFile},
+	}
+	config, err := tc.Build()
+	require.NoError(t, err)
+	assert.NotNil(t, config.VerifyPeerCertificate)
+	cert, err := config.GetCertificate(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	assert.Equal(t, staple, cert.OCSPStaple)
+
+	tc.Revocation = &RevocationConfig{OCSPStapleFile: filepath.Join(dir, "missing.der")}
+	_, err = tc.Build()
+	assert.Error(t, err)
+}
@@ -0,0 +1,283 @@
+package network
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type SlowConsumerPolicy byte
+
+const (
+	SlowConsumerPolicyNone SlowConsumerPolicy = iota
+	SlowConsumerPolicyDisconnect
+	SlowConsumerPolicyDropQoS0
+	SlowConsumerPolicyQuarantine
+	SlowConsumerPolicyLastValue
+)
+
+func (p SlowConsumerPolicy) String() string {
+	switch p {
+	case SlowConsumerPolicyNone:
+		return "none"
+	case SlowConsumerPolicyDisconnect:
+		return "disconnect"
+	case SlowConsumerPolicyDropQoS0:
+		return "drop_qos0"
+	case SlowConsumerPolicyQuarantine:
+		return "quarantine"
+	case SlowConsumerPolicyLastValue:
+		return "last_value"
+	default:
+		return "unknown"
+	}
+}
+
+type SlowConsumerEvent struct {
+	// ClientID is the client of a connection tracked by a listener once CONNECT was accepted, its connection ID before
+	ClientID         string
+	ConnectionID     string
+	QueueDepth       int
+	OldestMessageAge time.Duration
+	Since            time.Time
+	Policy           SlowConsumerPolicy
+}
+
+type SlowConsumerConfig struct {
+	QueueThreshold int
+	// LatencyThreshold also marks a client above threshold while its oldest queued message or stalled write
+	// is older than this, zero only compares queue depths
+	LatencyThreshold time.Duration
+	Duration         time.Duration
+	CheckInterval    time.Duration
+	Policy           SlowConsumerPolicy
+	OnSlowConsumer   func(SlowConsumerEvent)
+	OnRecovered      func(clientID string)
+}
+
+func DefaultSlowConsumerConfig() *SlowConsumerConfig {
+	return &SlowConsumerConfig{
+		QueueThreshold: 1000,
+		Duration:       10 * time.Second,
+		CheckInterval:  time.Second,
+		Policy:         SlowConsumerPolicyNone,
+	}
+}
+
+type consumerState struct {
+	conn       *Connection
+	depth      int
+	oldestAge  time.Duration
+	writes     int           // writes pending on conn at the last check
+	writeAge   time.Duration // how long conn has been writing without a break
+	aboveSince time.Time
+	slow       bool
+}
+
+type SlowConsumerDetector struct {
+	config *SlowConsumerConfig
+
+	mu        sync.RWMutex
+	consumers map[string]*consumerState
+
+	detected atomic.Uint64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewSlowConsumerDetector(config *SlowConsumerConfig) *SlowConsumerDetector {
+	if config == nil {
+		config = DefaultSlowConsumerConfig()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &SlowConsumerDetector{
+		config:    config,
+		consumers: make(map[string]*consumerState),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+func (d *SlowConsumerDetector) Start() {
+	d.wg.Add(1)
+	go d.checkLoop()
+}
+
+func (d *SlowConsumerDetector) Stop() {
+	d.cancel()
+	d.wg.Wait()
+}
+
+func (d *SlowConsumerDetector) Track(clientID string, conn *Connection) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.consumers[clientID] = &consumerState{conn: conn}
+}
+
+func (d *SlowConsumerDetector) Untrack(clientID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.consumers, clientID)
+}
+
+// Observe records the current outbound queue depth and oldest message age of a client
+// Tracked connections are sampled on every check as well, the larger backlog counts
+func (d *SlowConsumerDetector) Observe(clientID string, depth int, oldestAge time.Duration) {
+	d.mu.Lock()
+	state, ok := d.consumers[clientID]
+	if !ok {
+		state = &consumerState{}
+		d.consumers[clientID] = state
+	}
+	state.depth = depth
+	state.oldestAge = oldestAge
+	recovered := d.updateLocked(state, time.Now())
+	d.mu.Unlock()
+
+	if recovered && d.config.OnRecovered != nil {
+		d.config.OnRecovered(clientID)
+	}
+}
+
+// updateLocked moves a client in or out of the above threshold state and reports whether it recovered
+func (d *SlowConsumerDetector) updateLocked(state *consumerState, now time.Time) bool {
+	depth, age := max(state.depth, state.writes), max(state.oldestAge, state.writeAge)
+	above := depth > d.config.QueueThreshold || (d.config.LatencyThreshold > 0 && age > d.config.LatencyThreshold)
+	if above {
+		if state.aboveSince.IsZero() {
+			state.aboveSince = now
+		}
+		return false
+	}
+	recovered := state.slow
+	state.aboveSince = time.Time{}
+	state.slow = false
+	return recovered
+}
+
+// Check samples the write backlog of tracked connections, forgetting closed ones, evaluates all clients and
+// returns the clients newly detected as slow
+func (d *SlowConsumerDetector) Check() []SlowConsumerEvent {
+	now := time.Now()
+	var (
+		events    []SlowConsumerEvent
+		conns     []*Connection
+		recovered []string
+	)
+
+	d.mu.Lock()
+	for key, state := range d.consumers {
+		if state.conn != nil {
+			if state.conn.State() == StateClosed {
+				delete(d.consumers, key)
+				continue
+			}
+			state.writes, state.writeAge = state.conn.WriteBacklog()
+			if d.updateLocked(state, now) {
+				recovered = append(recovered, key)
+			}
+		}
+		if state.slow || state.aboveSince.IsZero() || now.Sub(state.aboveSince) < d.config.Duration {
+			continue
+		}
+		state.slow = true
+		event := SlowConsumerEvent{
+			ClientID:         key,
+			QueueDepth:       max(state.depth, state.writes),
+			OldestMessageAge: max(state.oldestAge, state.writeAge),
+			Since:            state.aboveSince,
+			Policy:           d.config.Policy,
+		}
+		if state.conn != nil {
+			event.ConnectionID = state.conn.ID()
+			if clientID, ok := state.conn.GetMetadata(MetadataClientID); ok {
+				event.ClientID, _ = clientID.(string)
+			}
+		}
+		events = append(events, event)
+		conns = append(conns, state.conn)
+	}
+	d.mu.Unlock()
+
+	if d.config.OnRecovered != nil {
+		for _, key := range recovered {
+			d.config.OnRecovered(key)
+		}
+	}
+	for i, event := range events {
+		d.detected.Add(1)
+		if d.config.OnSlowConsumer != nil {
+			d.config.OnSlowConsumer(event)
+		}
+		if d.config.Policy == SlowConsumerPolicyDisconnect && conns[i] != nil {
+			conns[i].Close()
+		}
+	}
+
+	return events
+}
+
+func (d *SlowConsumerDetector) IsSlow(clientID string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	state, ok := d.consumers[clientID]
+	return ok && state.slow
+}
+
+// ShouldDropQoS0 reports whether QoS 0 messages to the client should be dropped
+func (d *SlowConsumerDetector) ShouldDropQoS0(clientID string) bool {
+	return d.config.Policy == SlowConsumerPolicyDropQoS0 && d.IsSlow(clientID)
+}
+
+// IsQuarantined reports whether delivery to the client should be suspended
+func (d *SlowConsumerDetector) IsQuarantined(clientID string) bool {
+	return d.config.Policy == SlowConsumerPolicyQuarantine && d.IsSlow(clientID)
+}
+
+// ShouldKeepLastValue reports whether messages queued for the client should keep only the newest message
+// per topic, as if every subscription of the client were a last value subscription
+func (d *SlowConsumerDetector) ShouldKeepLastValue(clientID string) bool {
+	return d.config.Policy == SlowConsumerPolicyLastValue && d.IsSlow(clientID)
+}
+
+func (d *SlowConsumerDetector) SlowConsumers() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	clientIDs := make([]string, 0)
+	for clientID, state := range d.consumers {
+		if state.slow {
+			clientIDs = append(clientIDs, clientID)
+		}
+	}
+	return clientIDs
+}
+
+func (d *SlowConsumerDetector) DetectedCount() uint64 {
+	return d.detected.Load()
+}
+
+func (d *SlowConsumerDetector) checkLoop() {
+	defer d.wg.Done()
+
+	interval := d.config.CheckInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			d.Check()
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package network
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultSlowConsumerConfig(t *testing.T) {
+	config := DefaultSlowConsumerConfig()
+	assert.Equal(t, 1000, config.QueueThreshold)
+	assert.Equal(t, 10*time.Second, config.Duration)
+	assert.Equal(t, time.Second, config.CheckInterval)
+	assert.Equal(t, SlowConsumerPolicyNone, config.Policy)
+}
+
+func TestSlowConsumerPolicyString(t *testing.T) {
+	assert.Equal(t, "none", SlowConsumerPolicyNone.String())
+	assert.Equal(t, "disconnect", SlowConsumerPolicyDisconnect.String())
+	assert.Equal(t, "drop_qos0", SlowConsumerPolicyDropQoS0.String())
+	assert.Equal(t, "quarantine", SlowConsumerPolicyQuarantine.String())
+	assert.Equal(t, "last_value", SlowConsumerPolicyLastValue.String())
+	assert.Equal(t, "unknown", SlowConsumerPolicy(99).String())
+}
+
+func TestSlowConsumerDetection(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		events    []SlowConsumerEvent
+		recovered []string
+	)
+	d := NewSlowConsumerDetector(&SlowConsumerConfig{
+		QueueThreshold: 10,
+		Duration:       30 * time.Millisecond,
+		OnSlowConsumer: func(e SlowConsumerEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, e)
+		},
+		OnRecovered: func(clientID string) {
+			mu.Lock()
+			defer mu.Unlock()
+			recovered = append(recovered, clientID)
+		},
+	})
+
+	d.Observe("c1", 20, time.Second)
+	d.Observe("c2", 5, 0)
+	assert.Empty(t, d.Check())
+
+	time.Sleep(40 * time.Millisecond)
+	d.Observe("c1", 25, 2*time.Second)
+	detected := d.Check()
+	require.Len(t, detected, 1)
+	assert.Equal(t, "c1", detected[0].ClientID)
+	assert.Equal(t, 25, detected[0].QueueDepth)
+	assert.Equal(t, 2*time.Second, detected[0].OldestMessageAge)
+	assert.True(t, d.IsSlow("c1"))
+	assert.False(t, d.IsSlow("c2"))
+	assert.Equal(t, []string{"c1"}, d.SlowConsumers())
+
+	// Reported once per episode
+	assert.Empty(t, d.Check())
+	assert.Equal(t, uint64(1), d.DetectedCount())
+
+	d.Observe("c1", 3, 0)
+	assert.False(t, d.IsSlow("c1"))
+
+	mu.Lock()
+	assert.Len(t, events, 1)
+	assert.Equal(t, []string{"c1"}, recovered)
+	mu.Unlock()
+}
+
+func TestSlowConsumerBriefSpikeIgnored(t *testing.T) {
+	d := NewSlowConsumerDetector(&SlowConsumerConfig{QueueThreshold: 10, Duration: 30 * time.Millisecond})
+
+	d.Observe("c1", 20, 0)
+	time.Sleep(20 * time.Millisecond)
+	d.Observe("c1", 0, 0)
+	d.Observe("c1", 20, 0)
+	time.Sleep(20 * time.Millisecond)
+
+	assert.Empty(t, d.Check())
+}
+
+func TestSlowConsumerPolicies(t *testing.T) {
+	tests := []struct {
+		policy      SlowConsumerPolicy
+		dropQoS0    bool
+		quarantined bool
+		lastValue   bool
+	}{
+		{policy: SlowConsumerPolicyNone},
+		{policy: SlowConsumerPolicyDropQoS0, dropQoS0: true},
+		{policy: SlowConsumerPolicyQuarantine, quarantined: true},
+		{policy: SlowConsumerPolicyLastValue, lastValue: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			d := NewSlowConsumerDetector(&SlowConsumerConfig{QueueThreshold: 1, Policy: tt.policy})
+			d.Observe("c1", 5, 0)
+			require.Len(t, d.Check(), 1)
+
+			assert.Equal(t, tt.dropQoS0, d.ShouldDropQoS0("c1"))
+			assert.Equal(t, tt.quarantined, d.IsQuarantined("c1"))
+			assert.Equal(t, tt.lastValue, d.ShouldKeepLastValue("c1"))
+			assert.False(t, d.ShouldDropQoS0("c2"))
+		})
+	}
+}
+
+func TestSlowConsumerDisconnectPolicy(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	conn := NewConnection(server, "c1", nil)
+	d := NewSlowConsumerDetector(&SlowConsumerConfig{
+		QueueThreshold: 1,
+		CheckInterval:  10 * time.Millisecond,
+		Policy:         SlowConsumerPolicyDisconnect,
+	})
+	d.Track("c1", conn)
+	d.Start()
+	defer d.Stop()
+
+	d.Observe("c1", 5, 0)
+
+	select {
+	case <-conn.CloseChan():
+	case <-time.After(time.Second):
+		t.Fatal("connection was not closed")
+	}
+
+	d.Untrack("c1")
+	assert.False(t, d.IsSlow("c1"))
+}
@@ -0,0 +1,121 @@
+package network
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// SNIRoute selects a certificate and tenant for a TLS server name
+// ServerName may be an exact host, a wildcard such as "*.example.com" matching one label, or "*" for the default route
+type SNIRoute struct {
+	ServerName string
+	CertFile   string
+	KeyFile    string
+	Tenant     string
+}
+
+type sniTarget struct {
+	cert   *tls.Certificate
+	tenant string
+}
+
+// SNIRouter serves per-hostname certificates and maps connections to tenants
+type SNIRouter struct {
+	exact    map[string]*sniTarget
+	wildcard map[string]*sniTarget
+	fallback *sniTarget
+}
+
+func NewSNIRouter(routes []SNIRoute) (*SNIRouter, error) {
+	r := &SNIRouter{
+		exact:    make(map[string]*sniTarget),
+		wildcard: make(map[string]*sniTarget),
+	}
+
+	for _, route := range routes {
+		cert, err := tls.LoadX509KeyPair(route.CertFile, route.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load certificate for %s: %w", route.ServerName, err)
+		}
+		if err := r.add(route.ServerName, &cert, route.Tenant); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (r *SNIRouter) add(serverName string, cert *tls.Certificate, tenant string) error {
+	target := &sniTarget{cert: cert, tenant: tenant}
+	name := strings.ToLower(strings.TrimSuffix(serverName, "."))
+
+	switch {
+	case name == "*":
+		if r.fallback != nil {
+			return fmt.Errorf("%w: duplicate default SNI route", ErrInvalidTLSConfig)
+		}
+		r.fallback = target
+	case strings.HasPrefix(name, "*."):
+		if _, exists := r.wildcard[name[2:]]; exists {
+			return fmt.Errorf("%w: duplicate SNI route %s", ErrInvalidTLSConfig, serverName)
+		}
+		r.wildcard[name[2:]] = target
+	case name == "" || strings.Contains(name, "*"):
+		return fmt.Errorf("%w: invalid SNI server name %q", ErrInvalidTLSConfig, serverName)
+	default:
+		if _, exists := r.exact[name]; exists {
+			return fmt.Errorf("%w: duplicate SNI route %s", ErrInvalidTLSConfig, serverName)
+		}
+		r.exact[name] = target
+	}
+	return nil
+}
+
+func (r *SNIRouter) lookup(serverName string) *sniTarget {
+	name := strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if target, ok := r.exact[name]; ok {
+		return target
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if target, ok := r.wildcard[name[i+1:]]; ok {
+			return target
+		}
+	}
+	return r.fallback
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+// Without a matching route the handshake fails unless a default route is configured
+func (r *SNIRouter) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	target := r.lookup(hello.ServerName)
+	if target == nil {
+		return nil, fmt.Errorf("%w: no certificate for server name %q", ErrInvalidTLSConfig, hello.ServerName)
+	}
+	return target.cert, nil
+}
+
+// Tenant returns the tenant routed for serverName
+func (r *SNIRouter) Tenant(serverName string) (string, bool) {
+	target := r.lookup(serverName)
+	if target == nil {
+		return "", false
+	}
+	return target.tenant, true
+}
+
+// ConnectionTenant returns the tenant for the server name a TLS client requested
+// The TLS handshake is completed first if it has not run yet
+func (r *SNIRouter) ConnectionTenant(conn *Connection) (string, bool) {
+	if conn.tlsConn == nil {
+		return "", false
+	}
+
+	state := conn.tlsConn.ConnectionState()
+	if !state.HandshakeComplete {
+		if err := conn.tlsConn.Handshake(); err != nil {
+			return "", false
+		}
+		state = conn.tlsConn.ConnectionState()
+	}
+	return r.Tenant(state.ServerName)
+}
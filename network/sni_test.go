@@ -0,0 +1,211 @@
+package network
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestKeyPair(t *testing.T, dir, name string) (string, string) {
+	certPEM, keyPEM, err := generateTestCertificate()
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certFile, certPEM, 0o644))
+	require.NoError(t, os.WriteFile(keyFile, keyPEM, 0o600))
+	return certFile, keyFile
+}
+
+func newTestSNIRouter(t *testing.T) *SNIRouter {
+	dir := t.TempDir()
+	aCert, aKey := writeTestKeyPair(t, dir, "a")
+	bCert, bKey := writeTestKeyPair(t, dir, "b")
+	dCert, dKey := writeTestKeyPair(t, dir, "default")
+
+	router, err := NewSNIRouter([]SNIRoute{
+		{ServerName: "iot.customerA.com", CertFile: aCert, KeyFile: aKey, Tenant: "customer-a"},
+		{ServerName: "*.customerB.com", CertFile: bCert, KeyFile: bKey, Tenant: "customer-b"},
+		{ServerName: "*", CertFile: dCert, KeyFile: dKey, Tenant: "default"},
+	})
+	require.NoError(t, err)
+	return router
+}
+
+func TestSNIRouterTenant(t *testing.T) {
+	router := newTestSNIRouter(t)
+
+	tests := []struct {
+		serverName string
+		tenant     string
+	}{
+		{"iot.customerA.com", "customer-a"},
+		{"IOT.CUSTOMERA.COM.", "customer-a"},
+		{"iot.customerB.com", "customer-b"},
+		{"mqtt.customerb.com", "customer-b"},
+		{"a.b.customerB.com", "default"},
+		{"customerB.com", "default"},
+		{"", "default"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.serverName, func(t *testing.T) {
+			tenant, ok := router.Tenant(tt.serverName)
+			assert.True(t, ok)
+			assert.Equal(t, tt.tenant, tenant)
+		})
+	}
+}
+
+func TestSNIRouterGetCertificate(t *testing.T) {
+	router := newTestSNIRouter(t)
+
+	a, err := router.GetCertificate(&tls.ClientHelloInfo{ServerName: "iot.customerA.com"})
+	require.NoError(t, err)
+	b, err := router.GetCertificate(&tls.ClientHelloInfo{ServerName: "x.customerB.com"})
+	require.NoError(t, err)
+	assert.NotSame(t, a, b)
+
+	dir := t.TempDir()
+	certFile, keyFile := writeTestKeyPair(t, dir, "a")
+	strict, err := NewSNIRouter([]SNIRoute{{ServerName: "iot.customerA.com", CertFile: certFile, KeyFile: keyFile}})
+	require.NoError(t, err)
+
+	_, err = strict.GetCertificate(&tls.ClientHelloInfo{ServerName: "unknown.com"})
+	assert.ErrorIs(t, err, ErrInvalidTLSConfig)
+	_, ok := strict.Tenant("unknown.com")
+	assert.False(t, ok)
+}
+
+func TestNewSNIRouterErrors(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestKeyPair(t, dir, "a")
+
+	tests := []struct {
+		name   string
+		routes []SNIRoute
+	}{
+		{"missing files", []SNIRoute{{ServerName: "a.com", CertFile: "/nonexistent", KeyFile: "/nonexistent"}}},
+		{"empty name", []SNIRoute{{CertFile: certFile, KeyFile: keyFile}}},
+		{"inner wildcard", []SNIRoute{{ServerName: "a.*.com", CertFile: certFile, KeyFile: keyFile}}},
+		{"duplicate exact", []SNIRoute{
+			{ServerName: "a.com", CertFile: certFile, KeyFile: keyFile},
+			{ServerName: "A.com", CertFile: certFile, KeyFile: keyFile},
+		}},
+		{"duplicate wildcard", []SNIRoute{
+			{ServerName: "*.a.com", CertFile: certFile, KeyFile: keyFile},
+			{ServerName: "*.a.com", CertFile: certFile, KeyFile: keyFile},
+		}},
+		{"duplicate default", []SNIRoute{
+			{ServerName: "*", CertFile: certFile, KeyFile: keyFile},
+			{ServerName: "*", CertFile: certFile, KeyFile: keyFile},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSNIRouter(tt.routes)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestTLSConfigBuildWithSNIRouter(t *testing.T) {
+	router := newTestSNIRouter(t)
+
+	config, err := (&TLSConfig{SNIRouter: router}).Build()
+	require.NoError(t, err)
+	assert.Empty(t, config.Certificates)
+	require.NotNil(t, config.GetCertificate)
+
+	cert, err := config.GetCertificate(&tls.ClientHelloInfo{ServerName: "iot.customerA.com"})
+	require.NoError(t, err)
+	assert.NotNil(t, cert)
+}
+
+func TestSNIRouterConnectionTenant(t *testing.T) {
+	router := newTestSNIRouter(t)
+	serverConfig, err := (&TLSConfig{SNIRouter: router, MinVersion: tls.VersionTLS12}).Build()
+	require.NoError(t, err)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	require.NoError(t, err)
+	defer ln.Close()
+
+	tenants := make(chan string, 1)
+	go func() {
+		netConn, err := ln.Accept()
+		if err != nil {
+			tenants <- ""
+			return
+		}
+		conn := NewConnection(netConn, "sni", nil)
+		defer conn.Close()
+		tenant, _ := router.ConnectionTenant(conn)
+		tenants <- tenant
+	}()
+
+	client, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+		ServerName:         "mqtt.customerB.com",
+		InsecureSkipVerify: true,
+		RootCAs:            x509.NewCertPool(),
+	})
+	require.NoError(t, err)
+	defer client.Close()
+
+	select {
+	case tenant := <-tenants:
+		assert.Equal(t, "customer-b", tenant)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for tenant")
+	}
+
+	plain, _ := net.Pipe()
+	defer plain.Close()
+	_, ok := router.ConnectionTenant(NewConnection(plain, "plain", nil))
+	assert.False(t, ok)
+}
+
+func TestListenerSetsTenantAndListener(t *testing.T) {
+	router := newTestSNIRouter(t)
+	serverConfig, err := (&TLSConfig{SNIRouter: router, MinVersion: tls.VersionTLS12}).Build()
+	require.NoError(t, err)
+
+	config := DefaultListenerConfig("127.0.0.1:0")
+	config.ID = "mqtts"
+	config.TLSConfig = serverConfig
+	config.SNI = router
+	config.ReusePort = false
+	listener, err := NewListener(config, nil)
+	require.NoError(t, err)
+
+	infos := make(chan ClientInfo, 1)
+	listener.OnConnection(func(conn *Connection) error {
+		infos <- ConnectionClientInfo(conn)
+		return nil
+	})
+	require.NoError(t, listener.Start())
+	defer listener.Close()
+
+	client, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+		ServerName:         "iot.customerA.com",
+		InsecureSkipVerify: true,
+	})
+	require.NoError(t, err)
+	defer client.Close()
+
+	select {
+	case info := <-infos:
+		assert.Equal(t, "customer-a", info.Tenant)
+		assert.Equal(t, "mqtts", info.Listener)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for connection")
+	}
+}
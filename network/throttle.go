@@ -0,0 +1,198 @@
+package network
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// minPruneSize is the number of tracked peers below which per-peer state is not pruned
+const minPruneSize = 1024
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// ConnRateLimiter limits how fast each peer address may open connections with a token bucket per address
+type ConnRateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	prefix  int
+	buckets map[string]*tokenBucket
+	pruneAt int
+	now     func() time.Time
+}
+
+// NewConnRateLimiter allows each peer rate connections per second with bursts of up to burst connections
+// IPv6 peers share the limit of their DefaultIPv6PeerPrefix network, see SetIPv6Prefix
+func NewConnRateLimiter(rate float64, burst int) *ConnRateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &ConnRateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		prefix:  DefaultIPv6PeerPrefix,
+		buckets: make(map[string]*tokenBucket),
+		pruneAt: minPruneSize,
+		now:     time.Now,
+	}
+}
+
+// SetIPv6Prefix sets the length of the IPv6 networks sharing a limit, 128 limits every address on its own
+func (r *ConnRateLimiter) SetIPv6Prefix(bits int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prefix = bits
+}
+
+// Allow takes a token for the peer at addr, it reports false when the peer has none left
+func (r *ConnRateLimiter) Allow(addr net.Addr) bool {
+	now := r.now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	host := peerKey(addr, r.prefix)
+
+	if len(r.buckets) >= r.pruneAt {
+		r.pruneLocked(now)
+	}
+
+	b, ok := r.buckets[host]
+	if !ok {
+		b = &tokenBucket{tokens: r.burst, last: now}
+		r.buckets[host] = b
+	}
+	r.refillLocked(b, now)
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (r *ConnRateLimiter) refillLocked(b *tokenBucket, now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(r.burst, b.tokens+elapsed.Seconds()*r.rate)
+		b.last = now
+	}
+}
+
+// pruneLocked forgets peers whose bucket has refilled, they are indistinguishable from new peers
+func (r *ConnRateLimiter) pruneLocked(now time.Time) {
+	for host, b := range r.buckets {
+		r.refillLocked(b, now)
+		if b.tokens >= r.burst {
+			delete(r.buckets, host)
+		}
+	}
+	r.pruneAt = max(minPruneSize, 2*len(r.buckets))
+}
+
+type AuthThrottleConfig struct {
+	// MaxFailures is the number of failed authentications within Window that blocks a peer
+	MaxFailures int
+	Window      time.Duration
+	// BlockDuration is how long a blocked peer is refused
+	BlockDuration time.Duration
+	// IPv6Prefix is the length of the IPv6 networks counted as one peer, zero means DefaultIPv6PeerPrefix
+	IPv6Prefix int
+}
+
+func DefaultAuthThrottleConfig() *AuthThrottleConfig {
+	return &AuthThrottleConfig{
+		MaxFailures:   5,
+		Window:        time.Minute,
+		BlockDuration: 5 * time.Minute,
+		IPv6Prefix:    DefaultIPv6PeerPrefix,
+	}
+}
+
+type authFailures struct {
+	count        int
+	windowStart  time.Time
+	blockedUntil time.Time
+}
+
+// AuthThrottle blocks peers after repeated authentication failures
+// The broker reports CONNECT outcomes with Failure and Success, AuthThrottleMiddleware refuses blocked peers
+type AuthThrottle struct {
+	mu      sync.Mutex
+	config  *AuthThrottleConfig
+	peers   map[string]*authFailures
+	pruneAt int
+	now     func() time.Time
+}
+
+func NewAuthThrottle(config *AuthThrottleConfig) *AuthThrottle {
+	if config == nil {
+		config = DefaultAuthThrottleConfig()
+	}
+	return &AuthThrottle{
+		config:  config,
+		peers:   make(map[string]*authFailures),
+		pruneAt: minPruneSize,
+		now:     time.Now,
+	}
+}
+
+// key returns the key of the peer at addr
+func (t *AuthThrottle) key(addr net.Addr) string {
+	prefix := t.config.IPv6Prefix
+	if prefix == 0 {
+		prefix = DefaultIPv6PeerPrefix
+	}
+	return peerKey(addr, prefix)
+}
+
+// Failure records a failed authentication of the peer at addr
+func (t *AuthThrottle) Failure(addr net.Addr) {
+	host := t.key(addr)
+	now := t.now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.peers) >= t.pruneAt {
+		t.pruneLocked(now)
+	}
+
+	f, ok := t.peers[host]
+	if !ok || now.Sub(f.windowStart) > t.config.Window {
+		f = &authFailures{windowStart: now}
+		t.peers[host] = f
+	}
+	f.count++
+	if t.config.MaxFailures > 0 && f.count >= t.config.MaxFailures {
+		f.blockedUntil = now.Add(t.config.BlockDuration)
+	}
+}
+
+// Success clears the failures of the peer at addr
+func (t *AuthThrottle) Success(addr net.Addr) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.peers, t.key(addr))
+}
+
+// Blocked reports whether the peer at addr is blocked
+func (t *AuthThrottle) Blocked(addr net.Addr) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	f, ok := t.peers[t.key(addr)]
+	return ok && t.now().Before(f.blockedUntil)
+}
+
+func (t *AuthThrottle) pruneLocked(now time.Time) {
+	for host, f := range t.peers {
+		if now.After(f.blockedUntil) && now.Sub(f.windowStart) > t.config.Window {
+			delete(t.peers, host)
+		}
+	}
+	t.pruneAt = max(minPruneSize, 2*len(t.peers))
+}
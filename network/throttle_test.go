@@ -0,0 +1,92 @@
+package network
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnRateLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	limiter := NewConnRateLimiter(1, 2)
+	limiter.now = func() time.Time { return now }
+
+	peer := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1000}
+	other := &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 1000}
+
+	assert.True(t, limiter.Allow(peer))
+	assert.True(t, limiter.Allow(&net.TCPAddr{IP: peer.IP, Port: 2000}))
+	assert.False(t, limiter.Allow(peer))
+	assert.True(t, limiter.Allow(other))
+
+	now = now.Add(time.Second)
+	assert.True(t, limiter.Allow(peer))
+	assert.False(t, limiter.Allow(peer))
+}
+
+func TestAuthThrottle(t *testing.T) {
+	now := time.Unix(0, 0)
+	throttle := NewAuthThrottle(&AuthThrottleConfig{MaxFailures: 2, Window: time.Minute, BlockDuration: time.Minute})
+	throttle.now = func() time.Time { return now }
+
+	peer := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1000}
+
+	throttle.Failure(peer)
+	assert.False(t, throttle.Blocked(peer))
+	throttle.Failure(peer)
+	assert.True(t, throttle.Blocked(peer))
+
+	now = now.Add(2 * time.Minute)
+	assert.False(t, throttle.Blocked(peer))
+
+	throttle.Failure(peer)
+	throttle.Success(peer)
+	throttle.Failure(peer)
+	assert.False(t, throttle.Blocked(peer))
+}
+
+func TestHostOf(t *testing.T) {
+	assert.Equal(t, "192.0.2.1", hostOf(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1}))
+	assert.Equal(t, "/tmp/ax.sock", hostOf(&net.UnixAddr{Name: "/tmp/ax.sock", Net: "unix"}))
+	assert.Equal(t, "", hostOf(nil))
+}
+
+func TestConnRateLimiterIPv6(t *testing.T) {
+	limiter := NewConnRateLimiter(0, 1)
+	limiter.now = func() time.Time { return time.Unix(0, 0) }
+
+	// Addresses of one /64 share a bucket, an IPv4 peer of a dual-stack socket keys as plain IPv4
+	assert.True(t, limiter.Allow(&net.TCPAddr{IP: net.ParseIP("2001:db8::1")}))
+	assert.False(t, limiter.Allow(&net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Zone: "eth0"}))
+	assert.True(t, limiter.Allow(&net.TCPAddr{IP: net.ParseIP("2001:db8:0:1::1")}))
+	assert.True(t, limiter.Allow(&net.TCPAddr{IP: net.ParseIP("::ffff:192.0.2.1")}))
+	assert.False(t, limiter.Allow(&net.TCPAddr{IP: net.ParseIP("192.0.2.1").To4()}))
+
+	limiter.SetIPv6Prefix(128)
+	assert.True(t, limiter.Allow(&net.TCPAddr{IP: net.ParseIP("2001:db8::3")}))
+	assert.True(t, limiter.Allow(&net.TCPAddr{IP: net.ParseIP("2001:db8::4")}))
+}
+
+func TestAuthThrottleIPv6(t *testing.T) {
+	throttle := NewAuthThrottle(&AuthThrottleConfig{MaxFailures: 1, Window: time.Minute, BlockDuration: time.Minute})
+	throttle.Failure(&net.TCPAddr{IP: net.ParseIP("2001:db8::1")})
+	assert.True(t, throttle.Blocked(&net.TCPAddr{IP: net.ParseIP("2001:db8::ffff")}))
+	assert.False(t, throttle.Blocked(&net.TCPAddr{IP: net.ParseIP("2001:db8:0:1::1")}))
+}
+
+func TestAddressACL(t *testing.T) {
+	acl := &AddressACL{
+		Allow: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8::/32")},
+		Deny:  []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")},
+	}
+	assert.True(t, acl.Allowed(&net.TCPAddr{IP: net.ParseIP("10.1.2.3")}))
+	assert.True(t, acl.Allowed(&net.TCPAddr{IP: net.ParseIP("::ffff:10.1.2.3")}))
+	assert.True(t, acl.Allowed(&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Zone: "eth0"}))
+	assert.False(t, acl.Allowed(&net.TCPAddr{IP: net.ParseIP("10.0.0.7")}))
+	assert.False(t, acl.Allowed(&net.TCPAddr{IP: net.ParseIP("192.0.2.1")}))
+	assert.False(t, acl.Allowed(&net.UnixAddr{Name: "/tmp/ax.sock", Net: "unix"}))
+	assert.True(t, (&AddressACL{}).Allowed(&net.UnixAddr{Name: "/tmp/ax.sock", Net: "unix"}))
+}
@@ -16,6 +16,15 @@ type TLSConfig struct {
 	MaxVersion         uint16
 	CipherSuites       []uint16
 	InsecureSkipVerify bool
+	Revocation         *RevocationConfig
+	SNIRouter          *SNIRouter
+	// CertReloader serves certificates reloaded on SIGHUP or file change instead of CertFile and KeyFile
+	CertReloader *CertReloader
+	// ACME issues certificates automatically and answers TLS-ALPN-01 challenges, it takes precedence
+	// over CertReloader and CertFile
+	ACME CertificateManager
+	// NextProtos lists the ALPN protocols offered, e.g. "http/1.1" on WebSocket listeners
+	NextProtos []string
 }
 
 func DefaultTLSConfig() *TLSConfig {
@@ -28,22 +37,58 @@ func DefaultTLSConfig() *TLSConfig {
 }
 
 func (tc *TLSConfig) Build() (*tls.Config, error) {
-	if tc.CertFile == "" || tc.KeyFile == "" {
+	if (tc.CertFile == "" || tc.KeyFile == "") && tc.SNIRouter == nil && tc.CertReloader == nil && tc.ACME == nil {
 		return nil, ErrInvalidTLSConfig
 	}
 
-	cert, err := tls.LoadX509KeyPair(tc.CertFile, tc.KeyFile)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load certificate: %w", err)
-	}
-
 	config := &tls.Config{
-		Certificates:       []tls.Certificate{cert},
 		ClientAuth:         tc.ClientAuth,
 		MinVersion:         tc.MinVersion,
 		MaxVersion:         tc.MaxVersion,
 		CipherSuites:       tc.CipherSuites,
 		InsecureSkipVerify: tc.InsecureSkipVerify,
+		NextProtos:         tc.NextProtos,
+	}
+
+	switch {
+	case tc.ACME != nil:
+		config.GetCertificate = tc.ACME.GetCertificate
+		config.NextProtos = appendACMEProto(config.NextProtos)
+	case tc.CertReloader != nil:
+		config.GetCertificate = tc.CertReloader.GetCertificate
+	case tc.CertFile != "" || tc.KeyFile != "":
+		cert, err := tls.LoadX509KeyPair(tc.CertFile, tc.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+
+		if tc.Revocation != nil && tc.Revocation.OCSPStapleFile != "" {
+			stapler, err := NewOCSPStapler(cert, tc.Revocation.OCSPStapleFile, tc.Revocation.ReloadInterval)
+			if err != nil {
+				return nil, err
+			}
+			config.GetCertificate = stapler.GetCertificate
+		}
+	}
+
+	if tc.SNIRouter != nil {
+		// The other certificate source is used when the client sends no server name or no route matches
+		router := tc.SNIRouter
+		fallback := config.GetCertificate
+		config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, err := router.GetCertificate(hello)
+			if err == nil {
+				return cert, nil
+			}
+			if fallback != nil {
+				return fallback(hello)
+			}
+			if len(config.Certificates) > 0 {
+				return nil, nil
+			}
+			return nil, err
+		}
 	}
 
 	if tc.CAFile != "" {
@@ -63,6 +108,14 @@ func (tc *TLSConfig) Build() (*tls.Config, error) {
 		}
 	}
 
+	if tc.Revocation != nil {
+		checker, err := NewRevocationChecker(tc.Revocation)
+		if err != nil {
+			return nil, err
+		}
+		config.VerifyPeerCertificate = checker.VerifyPeerCertificate
+	}
+
 	return config, nil
 }
 
@@ -0,0 +1,51 @@
+package ota
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// chunkHeaderSize is the offset and CRC-32 preceding the chunk data
+const chunkHeaderSize = 8 + 4
+
+// ChunkRequest asks for the chunk of an image starting at Offset
+// Version must match the manifest so a device never mixes chunks of two releases
+type ChunkRequest struct {
+	Version string `json:"version"`
+	Offset  int64  `json:"offset"`
+	Length  int    `json:"length,omitempty"`
+}
+
+// Chunk is a piece of an image delivered to a device
+type Chunk struct {
+	Offset int64
+	Data   []byte
+}
+
+// EncodeChunk encodes a chunk as its big-endian offset, the CRC-32 (IEEE) of the data and the data
+func EncodeChunk(c Chunk) []byte {
+	buf := make([]byte, chunkHeaderSize+len(c.Data))
+	binary.BigEndian.PutUint64(buf, uint64(c.Offset))
+	binary.BigEndian.PutUint32(buf[8:], crc32.ChecksumIEEE(c.Data))
+	copy(buf[chunkHeaderSize:], c.Data)
+	return buf
+}
+
+// DecodeChunk decodes a chunk and verifies its CRC-32, the data aliases payload
+func DecodeChunk(payload []byte) (Chunk, error) {
+	if len(payload) < chunkHeaderSize {
+		return Chunk{}, fmt.Errorf("%w: short chunk", ErrInvalidChunk)
+	}
+	c := Chunk{
+		Offset: int64(binary.BigEndian.Uint64(payload)),
+		Data:   payload[chunkHeaderSize:],
+	}
+	if c.Offset < 0 {
+		return Chunk{}, fmt.Errorf("%w: negative offset", ErrInvalidChunk)
+	}
+	if crc32.ChecksumIEEE(c.Data) != binary.BigEndian.Uint32(payload[8:]) {
+		return Chunk{}, fmt.Errorf("%w: crc mismatch at offset %d", ErrInvalidChunk, c.Offset)
+	}
+	return c, nil
+}
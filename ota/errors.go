@@ -0,0 +1,14 @@
+package ota
+
+import "errors"
+
+var (
+	ErrInvalidTopic     = errors.New("invalid ota topic")
+	ErrInvalidManifest  = errors.New("invalid ota manifest")
+	ErrInvalidRequest   = errors.New("invalid ota chunk request")
+	ErrInvalidChunk     = errors.New("invalid ota chunk")
+	ErrImageNotFound    = errors.New("ota image not found")
+	ErrVersionMismatch  = errors.New("ota image version mismatch")
+	ErrChecksumMismatch = errors.New("ota image checksum mismatch")
+	ErrEmptyClientID    = errors.New("ota client id cannot be empty")
+)
@@ -0,0 +1,39 @@
+package ota
+
+import (
+	"context"
+
+	"github.com/axmq/ax/hook"
+)
+
+// Hook wires the ota server into the broker publish path
+type Hook struct {
+	*hook.Base
+	server *Server
+}
+
+// NewHook creates a hook that forwards chunk requests to the server
+func NewHook(server *Server) *Hook {
+	return &Hook{
+		Base:   hook.NewHookBase("ota"),
+		server: server,
+	}
+}
+
+// Provides indicates this hook handles publishes
+func (h *Hook) Provides(event hook.Event) bool {
+	return event == hook.OnPublish
+}
+
+// OnPublish serves chunk requests, the requesting client is taken from the connection
+// so a device can never have chunks delivered to another client's topic
+func (h *Hook) OnPublish(client *hook.Client, packet *hook.PublishPacket) error {
+	if packet == nil || !IsOTATopic(packet.Topic) {
+		return nil
+	}
+	if _, err := ParseRequestTopic(packet.Topic); err != nil {
+		// Manifests and chunks published by the server pass through untouched
+		return nil
+	}
+	return h.server.HandleRequest(context.Background(), client.GetID(), packet.Topic, packet.Payload)
+}
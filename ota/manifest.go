@@ -0,0 +1,96 @@
+package ota
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// DefaultChunkSize keeps chunks well below common broker and device packet size limits
+const DefaultChunkSize = 32 * 1024
+
+// Manifest describes a firmware or file image offered for download
+type Manifest struct {
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	Size      int64  `json:"size"`
+	ChunkSize int    `json:"chunk_size"`
+	SHA256    string `json:"sha256"`
+}
+
+// Validate checks the manifest is usable for a transfer
+func (m *Manifest) Validate() error {
+	switch {
+	case m.Name == "" || !validTopicLevel(m.Name):
+		return fmt.Errorf("%w: invalid name %q", ErrInvalidManifest, m.Name)
+	case m.Version == "":
+		return fmt.Errorf("%w: empty version", ErrInvalidManifest)
+	case m.Size < 0:
+		return fmt.Errorf("%w: negative size", ErrInvalidManifest)
+	case m.ChunkSize <= 0:
+		return fmt.Errorf("%w: invalid chunk size %d", ErrInvalidManifest, m.ChunkSize)
+	}
+	if sum, err := hex.DecodeString(m.SHA256); err != nil || len(sum) != sha256.Size {
+		return fmt.Errorf("%w: invalid sha256", ErrInvalidManifest)
+	}
+	return nil
+}
+
+// Chunks returns the number of chunks of the image
+func (m *Manifest) Chunks() int64 {
+	return (m.Size + int64(m.ChunkSize) - 1) / int64(m.ChunkSize)
+}
+
+// Image is an image served to devices, its content is read on demand so large images are never held in memory
+type Image struct {
+	Manifest Manifest
+	content  io.ReaderAt
+}
+
+// NewImage creates an image of size bytes read from content and computes its checksum
+// A chunkSize of zero uses DefaultChunkSize
+func NewImage(name, version string, content io.ReaderAt, size int64, chunkSize int) (*Image, error) {
+	if chunkSize == 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(content, 0, size)); err != nil {
+		return nil, fmt.Errorf("failed to hash image: %w", err)
+	}
+
+	img := &Image{
+		Manifest: Manifest{
+			Name:      name,
+			Version:   version,
+			Size:      size,
+			ChunkSize: chunkSize,
+			SHA256:    hex.EncodeToString(h.Sum(nil)),
+		},
+		content: content,
+	}
+	if err := img.Manifest.Validate(); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// ReadChunk reads up to length bytes at offset, length is capped at the chunk size
+func (img *Image) ReadChunk(offset int64, length int) ([]byte, error) {
+	if offset < 0 || offset >= img.Manifest.Size || length < 0 {
+		return nil, fmt.Errorf("%w: offset %d out of range", ErrInvalidRequest, offset)
+	}
+	if length == 0 || length > img.Manifest.ChunkSize {
+		length = img.Manifest.ChunkSize
+	}
+	if remaining := img.Manifest.Size - offset; int64(length) > remaining {
+		length = int(remaining)
+	}
+
+	data := make([]byte, length)
+	if _, err := img.content.ReadAt(data, offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data, nil
+}
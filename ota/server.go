@@ -0,0 +1,120 @@
+// Package ota distributes firmware and other large files to devices over MQTT
+//
+// The server publishes the Manifest of each image retained to ManifestTopic. A device starts a Transfer from
+// the manifest, publishes chunk requests with offsets to RequestTopic and receives the chunks on its
+// own ChunkTopic, each carrying a CRC-32. Once every chunk arrived the whole image is checked against
+// the SHA-256 of the manifest. Saving Transfer.Progress lets a device resume an interrupted download
+package ota
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/axmq/ax/hook"
+)
+
+// Publisher defines the interface for publishing manifests and chunks
+type Publisher interface {
+	Publish(ctx context.Context, packet *hook.PublishPacket) error
+}
+
+// Server offers images to devices, it answers chunk requests published to RequestTopic
+// with chunks published to the ChunkTopic of the requesting client
+type Server struct {
+	mu        sync.RWMutex
+	images    map[string]*Image
+	publisher Publisher
+}
+
+// NewServer creates an ota server publishing through publisher
+func NewServer(publisher Publisher) *Server {
+	return &Server{
+		images:    make(map[string]*Image),
+		publisher: publisher,
+	}
+}
+
+// Add offers img, replacing the image of the same name, and publishes its manifest
+// Devices still downloading a replaced version get ErrVersionMismatch and restart from the new manifest
+func (s *Server) Add(ctx context.Context, img *Image) error {
+	if err := img.Manifest.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.images[img.Manifest.Name] = img
+	s.mu.Unlock()
+
+	return s.PublishManifest(ctx, img.Manifest.Name)
+}
+
+// Remove withdraws the named image and clears its retained manifest
+func (s *Server) Remove(ctx context.Context, name string) error {
+	s.mu.Lock()
+	delete(s.images, name)
+	s.mu.Unlock()
+
+	return s.publisher.Publish(ctx, &hook.PublishPacket{Topic: ManifestTopic(name), Retain: true})
+}
+
+// Manifest returns the manifest of the named image
+func (s *Server) Manifest(name string) (Manifest, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	img, ok := s.images[name]
+	if !ok {
+		return Manifest{}, ErrImageNotFound
+	}
+	return img.Manifest, nil
+}
+
+// PublishManifest publishes the manifest of the named image retained to its ManifestTopic, so devices
+// subscribing later still receive it
+func (s *Server) PublishManifest(ctx context.Context, name string) error {
+	manifest, err := s.Manifest(name)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	return s.publisher.Publish(ctx, &hook.PublishPacket{Topic: ManifestTopic(name), Payload: payload, Retain: true})
+}
+
+// HandleRequest serves a chunk request published by clientID to topic
+func (s *Server) HandleRequest(ctx context.Context, clientID, topic string, payload []byte) error {
+	if clientID == "" {
+		return ErrEmptyClientID
+	}
+	name, err := ParseRequestTopic(topic)
+	if err != nil {
+		return err
+	}
+
+	var req ChunkRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+
+	s.mu.RLock()
+	img, ok := s.images[name]
+	s.mu.RUnlock()
+	if !ok {
+		return ErrImageNotFound
+	}
+	if req.Version != img.Manifest.Version {
+		return fmt.Errorf("%w: requested %q, serving %q", ErrVersionMismatch, req.Version, img.Manifest.Version)
+	}
+
+	data, err := img.ReadChunk(req.Offset, req.Length)
+	if err != nil {
+		return err
+	}
+	return s.publisher.Publish(ctx, &hook.PublishPacket{
+		Topic:   ChunkTopic(name, clientID),
+		Payload: EncodeChunk(Chunk{Offset: req.Offset, Data: data}),
+	})
+}
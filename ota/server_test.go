@@ -0,0 +1,163 @@
+package ota
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/axmq/ax/hook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type published struct {
+	topic   string
+	payload []byte
+	retain  bool
+}
+
+type recordingPublisher struct {
+	mu       sync.Mutex
+	messages []published
+}
+
+func (p *recordingPublisher) Publish(_ context.Context, packet *hook.PublishPacket) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.messages = append(p.messages, published{topic: packet.Topic, payload: packet.Payload, retain: packet.Retain})
+	return nil
+}
+
+func (p *recordingPublisher) last() published {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.messages[len(p.messages)-1]
+}
+
+func newTestImage(t *testing.T, size, chunkSize int) (*Image, []byte) {
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(i * 7)
+	}
+	img, err := NewImage("sensor-fw", "1.2.0", bytes.NewReader(content), int64(size), chunkSize)
+	require.NoError(t, err)
+	return img, content
+}
+
+func TestServerAddPublishesManifest(t *testing.T) {
+	pub := &recordingPublisher{}
+	s := NewServer(pub)
+	img, _ := newTestImage(t, 1000, 256)
+
+	require.NoError(t, s.Add(context.Background(), img))
+	msg := pub.last()
+	assert.Equal(t, "$ota/sensor-fw/manifest", msg.topic)
+	assert.True(t, msg.retain, "devices subscribing later must receive the manifest")
+
+	var manifest Manifest
+	require.NoError(t, json.Unmarshal(msg.payload, &manifest))
+	assert.Equal(t, img.Manifest, manifest)
+	assert.Equal(t, int64(4), manifest.Chunks())
+}
+
+func TestServerHandleRequest(t *testing.T) {
+	pub := &recordingPublisher{}
+	s := NewServer(pub)
+	img, content := newTestImage(t, 1000, 256)
+	require.NoError(t, s.Add(context.Background(), img))
+	ctx := context.Background()
+
+	require.NoError(t, s.HandleRequest(ctx, "dev-1", RequestTopic("sensor-fw"), []byte(`{"version":"1.2.0","offset":768,"length":4096}`)))
+	msg := pub.last()
+	assert.Equal(t, "$ota/sensor-fw/chunk/dev-1", msg.topic)
+	assert.False(t, msg.retain)
+	chunk, err := DecodeChunk(msg.payload)
+	require.NoError(t, err)
+	assert.Equal(t, int64(768), chunk.Offset)
+	assert.Equal(t, content[768:], chunk.Data, "length is capped at the chunk size and image end")
+
+	tests := []struct {
+		name     string
+		clientID string
+		topic    string
+		payload  string
+		err      error
+	}{
+		{name: "no client", topic: RequestTopic("sensor-fw"), payload: `{"version":"1.2.0"}`, err: ErrEmptyClientID},
+		{name: "bad topic", clientID: "dev-1", topic: "$ota/sensor-fw/manifest", payload: `{}`, err: ErrInvalidTopic},
+		{name: "unknown image", clientID: "dev-1", topic: RequestTopic("other"), payload: `{"version":"1.2.0"}`, err: ErrImageNotFound},
+		{name: "old version", clientID: "dev-1", topic: RequestTopic("sensor-fw"), payload: `{"version":"1.1.0"}`, err: ErrVersionMismatch},
+		{name: "past end", clientID: "dev-1", topic: RequestTopic("sensor-fw"), payload: `{"version":"1.2.0","offset":1000}`, err: ErrInvalidRequest},
+		{name: "malformed", clientID: "dev-1", topic: RequestTopic("sensor-fw"), payload: `{`, err: ErrInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.HandleRequest(ctx, tt.clientID, tt.topic, []byte(tt.payload))
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+
+	require.NoError(t, s.Remove(ctx, "sensor-fw"))
+	_, err = s.Manifest("sensor-fw")
+	assert.ErrorIs(t, err, ErrImageNotFound)
+	msg = pub.last()
+	assert.Equal(t, "$ota/sensor-fw/manifest", msg.topic)
+	assert.True(t, msg.retain)
+	assert.Empty(t, msg.payload, "the retained manifest is cleared")
+}
+
+func TestHookOnPublish(t *testing.T) {
+	pub := &recordingPublisher{}
+	s := NewServer(pub)
+	img, _ := newTestImage(t, 100, 64)
+	require.NoError(t, s.Add(context.Background(), img))
+	h := NewHook(s)
+	assert.True(t, h.Provides(hook.OnPublish))
+
+	client := &hook.Client{ID: "dev-9"}
+	require.NoError(t, h.OnPublish(client, &hook.PublishPacket{Topic: "sensors/temp"}))
+	require.NoError(t, h.OnPublish(client, &hook.PublishPacket{Topic: ManifestTopic("sensor-fw"), Payload: []byte("{}")}))
+	require.NoError(t, h.OnPublish(client, &hook.PublishPacket{
+		Topic:   RequestTopic("sensor-fw"),
+		Payload: []byte(`{"version":"1.2.0","offset":0}`),
+	}))
+	assert.Equal(t, ChunkTopic("sensor-fw", "dev-9"), pub.last().topic)
+
+	err := h.OnPublish(client, &hook.PublishPacket{Topic: RequestTopic("sensor-fw"), Payload: []byte(`{"version":"0"}`)})
+	assert.ErrorIs(t, err, ErrVersionMismatch)
+}
+
+func TestManifestValidate(t *testing.T) {
+	img, _ := newTestImage(t, 10, 4)
+	valid := img.Manifest
+	require.NoError(t, valid.Validate())
+
+	for name, mutate := range map[string]func(m *Manifest){
+		"name with level": func(m *Manifest) { m.Name = "a/b" },
+		"wildcard name":   func(m *Manifest) { m.Name = "fw+" },
+		"no version":      func(m *Manifest) { m.Version = "" },
+		"chunk size":      func(m *Manifest) { m.ChunkSize = 0 },
+		"negative size":   func(m *Manifest) { m.Size = -1 },
+		"bad checksum":    func(m *Manifest) { m.SHA256 = "abc" },
+	} {
+		m := valid
+		mutate(&m)
+		assert.ErrorIs(t, m.Validate(), ErrInvalidManifest, name)
+	}
+}
+
+func TestChunkEncoding(t *testing.T) {
+	payload := EncodeChunk(Chunk{Offset: 4096, Data: []byte("firmware")})
+	chunk, err := DecodeChunk(payload)
+	require.NoError(t, err)
+	assert.Equal(t, Chunk{Offset: 4096, Data: []byte("firmware")}, chunk)
+
+	payload[len(payload)-1] ^= 0xFF
+	_, err = DecodeChunk(payload)
+	assert.ErrorIs(t, err, ErrInvalidChunk)
+
+	_, err = DecodeChunk([]byte{1, 2, 3})
+	assert.ErrorIs(t, err, ErrInvalidChunk)
+}
@@ -0,0 +1,45 @@
+package ota
+
+import "strings"
+
+const (
+	// TopicPrefix is the reserved topic namespace for firmware distribution
+	TopicPrefix = "$ota/"
+)
+
+// ManifestTopic returns the topic the manifest of an image is published to
+func ManifestTopic(image string) string {
+	return TopicPrefix + image + "/manifest"
+}
+
+// RequestTopic returns the topic devices publish chunk requests for an image to
+func RequestTopic(image string) string {
+	return TopicPrefix + image + "/request"
+}
+
+// ChunkTopic returns the topic the chunks requested by a client are published to
+func ChunkTopic(image, clientID string) string {
+	return TopicPrefix + image + "/chunk/" + clientID
+}
+
+// IsOTATopic checks if a topic belongs to the ota namespace
+func IsOTATopic(topic string) bool {
+	return strings.HasPrefix(topic, TopicPrefix)
+}
+
+// ParseRequestTopic extracts the image name from a chunk request topic
+func ParseRequestTopic(topic string) (string, error) {
+	if !IsOTATopic(topic) {
+		return "", ErrInvalidTopic
+	}
+	parts := strings.Split(topic[len(TopicPrefix):], "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] != "request" {
+		return "", ErrInvalidTopic
+	}
+	return parts[0], nil
+}
+
+// validTopicLevel reports whether s can be used as a single topic level
+func validTopicLevel(s string) bool {
+	return s != "" && !strings.ContainsAny(s, "/+#\x00")
+}
@@ -0,0 +1,124 @@
+package ota
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/axmq/ax/hook"
+)
+
+// Storage receives the downloaded image, it is read back to verify the checksum
+type Storage interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// Progress records how far a transfer got, persist it to resume after a reboot or disconnect
+type Progress struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Offset  int64  `json:"offset"`
+}
+
+// Transfer downloads an image chunk by chunk into storage on the device side
+// Chunks are written in order, duplicates of already written chunks (e.g. QoS 1 redeliveries) are ignored
+type Transfer struct {
+	manifest Manifest
+	storage  Storage
+	offset   int64
+}
+
+// NewTransfer starts downloading the image described by manifest
+// A progress saved for the same image and version resumes from its offset, any other progress is discarded
+func NewTransfer(manifest Manifest, storage Storage, progress *Progress) (*Transfer, error) {
+	if err := manifest.Validate(); err != nil {
+		return nil, err
+	}
+
+	t := &Transfer{manifest: manifest, storage: storage}
+	if progress != nil && progress.Name == manifest.Name && progress.Version == manifest.Version &&
+		progress.Offset > 0 && progress.Offset <= manifest.Size {
+		t.offset = progress.Offset
+	}
+	return t, nil
+}
+
+// Manifest returns the manifest of the image being downloaded
+func (t *Transfer) Manifest() Manifest {
+	return t.manifest
+}
+
+// Progress returns the state to persist for resuming the transfer
+func (t *Transfer) Progress() Progress {
+	return Progress{Name: t.manifest.Name, Version: t.manifest.Version, Offset: t.offset}
+}
+
+// Done reports whether every chunk was received
+func (t *Transfer) Done() bool {
+	return t.offset >= t.manifest.Size
+}
+
+// Next returns the request for the next missing chunk, ok is false once the transfer is done
+func (t *Transfer) Next() (req ChunkRequest, ok bool) {
+	if t.Done() {
+		return ChunkRequest{}, false
+	}
+	return ChunkRequest{Version: t.manifest.Version, Offset: t.offset, Length: t.manifest.ChunkSize}, true
+}
+
+// Request publishes the request for the next missing chunk to RequestTopic
+func (t *Transfer) Request(ctx context.Context, publisher Publisher) error {
+	req, ok := t.Next()
+	if !ok {
+		return nil
+	}
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	return publisher.Publish(ctx, &hook.PublishPacket{Topic: RequestTopic(t.manifest.Name), Payload: payload})
+}
+
+// HandleChunk writes a chunk received on ChunkTopic, it returns ErrInvalidChunk for a corrupt
+// chunk or one that skips ahead, the transfer then continues by requesting the same offset again
+func (t *Transfer) HandleChunk(payload []byte) error {
+	chunk, err := DecodeChunk(payload)
+	if err != nil {
+		return err
+	}
+	if chunk.Offset < t.offset {
+		return nil
+	}
+	if chunk.Offset > t.offset {
+		return fmt.Errorf("%w: expected offset %d, got %d", ErrInvalidChunk, t.offset, chunk.Offset)
+	}
+	if len(chunk.Data) == 0 || chunk.Offset+int64(len(chunk.Data)) > t.manifest.Size {
+		return fmt.Errorf("%w: bad length %d at offset %d", ErrInvalidChunk, len(chunk.Data), chunk.Offset)
+	}
+
+	if _, err := t.storage.WriteAt(chunk.Data, chunk.Offset); err != nil {
+		return fmt.Errorf("failed to write chunk: %w", err)
+	}
+	t.offset += int64(len(chunk.Data))
+	return nil
+}
+
+// Verify checks the SHA-256 of the stored image against the manifest, call it once the transfer is done
+func (t *Transfer) Verify() error {
+	if !t.Done() {
+		return fmt.Errorf("%w: transfer incomplete at offset %d", ErrChecksumMismatch, t.offset)
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(t.storage, 0, t.manifest.Size)); err != nil {
+		return fmt.Errorf("failed to read image: %w", err)
+	}
+	if hex.EncodeToString(h.Sum(nil)) != t.manifest.SHA256 {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
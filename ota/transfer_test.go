@@ -0,0 +1,134 @@
+package ota
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/axmq/ax/hook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryStorage struct {
+	data []byte
+}
+
+func (s *memoryStorage) WriteAt(p []byte, off int64) (int, error) {
+	if end := int(off) + len(p); end > len(s.data) {
+		s.data = append(s.data, make([]byte, end-len(s.data))...)
+	}
+	return copy(s.data[off:], p), nil
+}
+
+func (s *memoryStorage) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(s.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, s.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// loopback routes requests to the server and collects the chunks published back
+type loopback struct {
+	server   *Server
+	clientID string
+	chunks   [][]byte
+}
+
+func (l *loopback) Publish(ctx context.Context, packet *hook.PublishPacket) error {
+	if packet.Topic == ChunkTopic("sensor-fw", l.clientID) {
+		l.chunks = append(l.chunks, packet.Payload)
+		return nil
+	}
+	if _, err := ParseRequestTopic(packet.Topic); err == nil {
+		return l.server.HandleRequest(ctx, l.clientID, packet.Topic, packet.Payload)
+	}
+	return nil
+}
+
+func runTransfer(t *testing.T, transfer *Transfer, net *loopback, maxChunks int) {
+	ctx := context.Background()
+	for i := 0; i < maxChunks && !transfer.Done(); i++ {
+		require.NoError(t, transfer.Request(ctx, net))
+		for _, payload := range net.chunks {
+			require.NoError(t, transfer.HandleChunk(payload))
+		}
+		net.chunks = nil
+	}
+}
+
+func TestTransferEndToEnd(t *testing.T) {
+	net := &loopback{clientID: "dev-1"}
+	net.server = NewServer(net)
+	img, content := newTestImage(t, 10_000, 1024)
+	require.NoError(t, net.server.Add(context.Background(), img))
+
+	storage := &memoryStorage{}
+	transfer, err := NewTransfer(img.Manifest, storage, nil)
+	require.NoError(t, err)
+	assert.ErrorIs(t, transfer.Verify(), ErrChecksumMismatch)
+
+	runTransfer(t, transfer, net, 100)
+	require.True(t, transfer.Done())
+	require.NoError(t, transfer.Verify())
+	assert.Equal(t, content, storage.data)
+
+	_, ok := transfer.Next()
+	assert.False(t, ok)
+}
+
+func TestTransferResume(t *testing.T) {
+	net := &loopback{clientID: "dev-1"}
+	net.server = NewServer(net)
+	img, content := newTestImage(t, 5000, 1000)
+	require.NoError(t, net.server.Add(context.Background(), img))
+
+	storage := &memoryStorage{}
+	first, err := NewTransfer(img.Manifest, storage, nil)
+	require.NoError(t, err)
+	runTransfer(t, first, net, 2)
+	progress := first.Progress()
+	assert.Equal(t, Progress{Name: "sensor-fw", Version: "1.2.0", Offset: 2000}, progress)
+
+	// The device reboots and resumes with the saved progress
+	resumed, err := NewTransfer(img.Manifest, storage, &progress)
+	require.NoError(t, err)
+	req, ok := resumed.Next()
+	require.True(t, ok)
+	assert.Equal(t, int64(2000), req.Offset)
+
+	runTransfer(t, resumed, net, 10)
+	require.NoError(t, resumed.Verify())
+	assert.Equal(t, content, storage.data)
+
+	// Progress of another version is discarded
+	stale := Progress{Name: "sensor-fw", Version: "1.0.0", Offset: 3000}
+	fresh, err := NewTransfer(img.Manifest, &memoryStorage{}, &stale)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), fresh.Progress().Offset)
+}
+
+func TestTransferHandleChunk(t *testing.T) {
+	img, content := newTestImage(t, 300, 100)
+	storage := &memoryStorage{}
+	transfer, err := NewTransfer(img.Manifest, storage, nil)
+	require.NoError(t, err)
+
+	first := EncodeChunk(Chunk{Offset: 0, Data: content[:100]})
+	require.NoError(t, transfer.HandleChunk(first))
+	require.NoError(t, transfer.HandleChunk(first), "duplicate chunks are ignored")
+	assert.Equal(t, int64(100), transfer.Progress().Offset)
+
+	assert.ErrorIs(t, transfer.HandleChunk(EncodeChunk(Chunk{Offset: 200, Data: content[200:]})), ErrInvalidChunk)
+	assert.ErrorIs(t, transfer.HandleChunk(EncodeChunk(Chunk{Offset: 100, Data: make([]byte, 250)})), ErrInvalidChunk)
+	assert.ErrorIs(t, transfer.HandleChunk(EncodeChunk(Chunk{Offset: 100})), ErrInvalidChunk)
+
+	// A chunk with the right framing but wrong content fails the final checksum
+	require.NoError(t, transfer.HandleChunk(EncodeChunk(Chunk{Offset: 100, Data: make([]byte, 200)})))
+	assert.True(t, transfer.Done())
+	assert.ErrorIs(t, transfer.Verify(), ErrChecksumMismatch)
+}
@@ -0,0 +1,289 @@
+// Package bufpool provides byte buffers from size classes backed by sync.Pool
+//
+// Encoding packets and writing them to connections needs short lived buffers of very different sizes,
+// from a few bytes for an acknowledgement up to the maximum packet size. A request takes a buffer of the
+// smallest class that fits, so a small packet never pins a large buffer and a large one never grows a small
+// buffer step by step. Requests above the largest class are allocated directly and not retained.
+// The pool counts the requests of every class and keeps a histogram of the requested sizes, Stats reports
+// hit rates and how well each class fits its requests and SuggestClasses derives classes from the histogram
+package bufpool
+
+import (
+	"math/bits"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// Sizes of the default classes
+const (
+	Class256 = 256
+	Class4K  = 4 << 10
+	Class64K = 64 << 10
+	Class1M  = 1 << 20
+)
+
+// histogramBuckets covers requested sizes up to 2 GiB in powers of two
+const histogramBuckets = 32
+
+// DefaultClasses returns the classes of the default pool
+func DefaultClasses() []int {
+	return []int{Class256, Class4K, Class64K, Class1M}
+}
+
+// Buffer is a pooled byte buffer, B is empty with a capacity of at least the requested size
+// It implements io.Writer by appending to B, so packets can be encoded into it
+type Buffer struct {
+	B    []byte
+	pool *Pool
+}
+
+// Write appends p to the buffer
+func (b *Buffer) Write(p []byte) (int, error) {
+	b.B = append(b.B, p...)
+	return len(p), nil
+}
+
+// WriteByte appends c to the buffer
+func (b *Buffer) WriteByte(c byte) error {
+	b.B = append(b.B, c)
+	return nil
+}
+
+// Bytes returns the contents of the buffer, valid until Release
+func (b *Buffer) Bytes() []byte {
+	return b.B
+}
+
+// Len returns the number of bytes in the buffer
+func (b *Buffer) Len() int {
+	return len(b.B)
+}
+
+// Reset empties the buffer and keeps its capacity
+func (b *Buffer) Reset() {
+	b.B = b.B[:0]
+}
+
+// Release returns the buffer to its pool, neither the buffer nor its bytes may be used afterwards
+// Releasing a nil buffer does nothing
+func (b *Buffer) Release() {
+	if b != nil && b.pool != nil {
+		b.pool.Put(b)
+	}
+}
+
+// class is one size class
+type class struct {
+	size      int
+	pool      sync.Pool
+	gets      atomic.Uint64
+	hits      atomic.Uint64
+	puts      atomic.Uint64
+	requested atomic.Uint64
+}
+
+// Pool hands out buffers from size classes and is safe for concurrent use
+type Pool struct {
+	classes   []*class
+	oversize  atomic.Uint64
+	dropped   atomic.Uint64
+	histogram [histogramBuckets]atomic.Uint64
+}
+
+// New creates a pool with the given ascending class sizes, DefaultClasses when none are given
+func New(sizes ...int) (*Pool, error) {
+	if len(sizes) == 0 {
+		sizes = DefaultClasses()
+	}
+	for i, size := range sizes {
+		if size <= 0 || (i > 0 && size <= sizes[i-1]) {
+			return nil, ErrInvalidClasses
+		}
+	}
+
+	p := &Pool{classes: make([]*class, len(sizes))}
+	for i, size := range sizes {
+		p.classes[i] = &class{size: size}
+	}
+	return p, nil
+}
+
+var defaultPool, _ = New()
+
+// Default returns the process wide pool shared by the encoders and connection writers
+func Default() *Pool {
+	return defaultPool
+}
+
+// Get returns a buffer from the default pool
+func Get(size int) *Buffer {
+	return defaultPool.Get(size)
+}
+
+// Get returns an empty buffer with a capacity of at least size from the smallest class that fits
+// Sizes above the largest class are allocated directly
+func (p *Pool) Get(size int) *Buffer {
+	size = max(size, 0)
+	p.histogram[bucket(size)].Add(1)
+
+	c := p.classFor(size)
+	if c == nil {
+		p.oversize.Add(1)
+		return &Buffer{B: make([]byte, 0, size), pool: p}
+	}
+
+	c.gets.Add(1)
+	c.requested.Add(uint64(size))
+	if b, ok := c.pool.Get().(*Buffer); ok {
+		c.hits.Add(1)
+		return b
+	}
+	return &Buffer{B: make([]byte, 0, c.size), pool: p}
+}
+
+// Put returns b to the largest class its capacity covers
+// Buffers larger than the largest class, e.g. oversize requests, are left to the garbage collector
+func (p *Pool) Put(b *Buffer) {
+	if b == nil {
+		return
+	}
+	capacity := cap(b.B)
+	i := len(p.classes) - 1
+	for i >= 0 && p.classes[i].size > capacity {
+		i--
+	}
+	if i < 0 || capacity > p.classes[len(p.classes)-1].size {
+		p.dropped.Add(1)
+		return
+	}
+
+	c := p.classes[i]
+	b.B = b.B[:0]
+	b.pool = p
+	c.puts.Add(1)
+	c.pool.Put(b)
+}
+
+func (p *Pool) classFor(size int) *class {
+	for _, c := range p.classes {
+		if size <= c.size {
+			return c
+		}
+	}
+	return nil
+}
+
+// bucket returns the histogram bucket of size, bucket i counts sizes up to 1<<i
+func bucket(size int) int {
+	if size <= 1 {
+		return 0
+	}
+	return min(bits.Len(uint(size-1)), histogramBuckets-1)
+}
+
+// ClassStats holds the counters of one size class
+type ClassStats struct {
+	Size int
+	// Gets counts the requests served by the class, Hits those served by a pooled buffer
+	Gets uint64
+	Hits uint64
+	Puts uint64
+	// Requested sums the sizes asked for, compared with Gets*Size it shows how well the class fits
+	Requested uint64
+}
+
+// HitRate returns the share of requests served without allocating
+func (s ClassStats) HitRate() float64 {
+	if s.Gets == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Gets)
+}
+
+// Fill returns the average share of a buffer the requests asked for
+// A low fill means requests pay for much more memory than they use, a class in between would fit them better
+func (s ClassStats) Fill() float64 {
+	if s.Gets == 0 {
+		return 0
+	}
+	return float64(s.Requested) / (float64(s.Gets) * float64(s.Size))
+}
+
+// Stats holds the counters of a pool
+type Stats struct {
+	Classes []ClassStats
+	// Oversize counts requests above the largest class, Dropped the buffers Put did not retain
+	Oversize uint64
+	Dropped  uint64
+	// Histogram counts the requested sizes, entry i those above 1<<(i-1) up to 1<<i
+	Histogram []uint64
+}
+
+// Stats returns the counters of the pool
+func (p *Pool) Stats() Stats {
+	stats := Stats{
+		Classes:   make([]ClassStats, len(p.classes)),
+		Oversize:  p.oversize.Load(),
+		Dropped:   p.dropped.Load(),
+		Histogram: make([]uint64, histogramBuckets),
+	}
+	for i, c := range p.classes {
+		stats.Classes[i] = ClassStats{
+			Size:      c.size,
+			Gets:      c.gets.Load(),
+			Hits:      c.hits.Load(),
+			Puts:      c.puts.Load(),
+			Requested: c.requested.Load(),
+		}
+	}
+	for i := range p.histogram {
+		stats.Histogram[i] = p.histogram[i].Load()
+	}
+	return stats
+}
+
+// HitRate returns the share of all requests served without allocating, oversize requests included
+func (s Stats) HitRate() float64 {
+	var gets, hits uint64
+	for _, c := range s.Classes {
+		gets += c.Gets
+		hits += c.Hits
+	}
+	gets += s.Oversize
+	if gets == 0 {
+		return 0
+	}
+	return float64(hits) / float64(gets)
+}
+
+// SuggestClasses derives at most n class sizes from the histogram, for New or a config reload
+// The requests are split into n groups of about equal count and each class covers the largest size of its
+// group rounded up to a power of two, so every class serves a similar share of the load. The last class
+// covers the largest request seen. It returns nil before any request
+func (s Stats) SuggestClasses(n int) []int {
+	var total uint64
+	for _, count := range s.Histogram {
+		total += count
+	}
+	if n <= 0 || total == 0 {
+		return nil
+	}
+
+	var sizes []int
+	var seen uint64
+	next := 1
+	for i, count := range s.Histogram {
+		if count == 0 {
+			continue
+		}
+		seen += count
+		if seen*uint64(n) >= total*uint64(next) || seen == total {
+			sizes = append(sizes, 1<<i)
+			for next <= n && seen*uint64(n) >= total*uint64(next) {
+				next++
+			}
+		}
+	}
+	return slices.Compact(sizes)
+}
@@ -0,0 +1,138 @@
+package bufpool
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewInvalidClasses(t *testing.T) {
+	for _, sizes := range [][]int{{0}, {-1, 256}, {256, 256}, {4096, 256}} {
+		_, err := New(sizes...)
+		assert.ErrorIs(t, err, ErrInvalidClasses, "%v", sizes)
+	}
+}
+
+func TestPoolClasses(t *testing.T) {
+	p, err := New()
+	require.NoError(t, err)
+
+	tests := []struct {
+		size     int
+		capacity int
+	}{
+		{0, Class256},
+		{256, Class256},
+		{257, Class4K},
+		{5000, Class64K},
+		{Class1M, Class1M},
+		{Class1M + 1, Class1M + 1},
+	}
+	for _, tt := range tests {
+		b := p.Get(tt.size)
+		assert.Zero(t, b.Len())
+		assert.Equal(t, tt.capacity, cap(b.B), "size %d", tt.size)
+	}
+	stats := p.Stats()
+	assert.Equal(t, uint64(1), stats.Oversize)
+	assert.Equal(t, uint64(2), stats.Classes[0].Gets)
+	assert.Equal(t, uint64(256), stats.Classes[0].Requested)
+}
+
+func TestPoolReuse(t *testing.T) {
+	p, err := New(64, 1024)
+	require.NoError(t, err)
+
+	b := p.Get(10)
+	_, _ = b.Write([]byte("hello"))
+	require.NoError(t, b.WriteByte('!'))
+	assert.Equal(t, "hello!", string(b.Bytes()))
+	b.Release()
+
+	b = p.Get(60)
+	assert.Zero(t, b.Len(), "released buffers come back empty")
+	// A buffer grown past its class returns to the class its capacity covers
+	_, _ = b.Write(make([]byte, 2000))
+	b.Release()
+	p.Get(2000).Release()
+
+	// sync.Pool may drop any buffer, e.g. on GC or at random under the race detector, so hits are only bounded
+	stats := p.Stats()
+	class := stats.Classes[0]
+	assert.Equal(t, uint64(2), class.Gets)
+	assert.LessOrEqual(t, class.Hits, uint64(1))
+	assert.Equal(t, uint64(1), class.Puts)
+	assert.Equal(t, uint64(1), stats.Oversize)
+	assert.Equal(t, uint64(2), stats.Dropped)
+	assert.InDelta(t, float64(class.Hits)/2, class.HitRate(), 0.001)
+	assert.InDelta(t, 70.0/128, class.Fill(), 0.001)
+	assert.InDelta(t, float64(class.Hits)/3, stats.HitRate(), 0.001)
+
+	var nilBuffer *Buffer
+	nilBuffer.Release()
+}
+
+func TestStatsHistogram(t *testing.T) {
+	p, err := New()
+	require.NoError(t, err)
+	for _, size := range []int{0, 1, 2, 3, 4, 5, 100, 128, 129} {
+		p.Get(size)
+	}
+	h := p.Stats().Histogram
+	assert.Equal(t, []uint64{2, 1, 2, 1, 0, 0, 0, 2, 1}, h[:9])
+}
+
+func TestSuggestClasses(t *testing.T) {
+	assert.Nil(t, Stats{Histogram: make([]uint64, histogramBuckets)}.SuggestClasses(4))
+
+	p, err := New()
+	require.NoError(t, err)
+	for range 50 {
+		p.Get(100)
+	}
+	for range 30 {
+		p.Get(1500)
+	}
+	for range 20 {
+		p.Get(40000)
+	}
+	stats := p.Stats()
+	assert.Equal(t, []int{128, 2048, 65536}, stats.SuggestClasses(4))
+	assert.Equal(t, []int{128, 65536}, stats.SuggestClasses(2))
+	assert.Equal(t, []int{65536}, stats.SuggestClasses(1))
+
+	suggested, err := New(stats.SuggestClasses(4)...)
+	require.NoError(t, err)
+	assert.Equal(t, 2048, cap(suggested.Get(1500).B))
+}
+
+func TestPoolConcurrent(t *testing.T) {
+	p := Default()
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 1000 {
+				b := p.Get((i*1000 + j) % 70000)
+				_, _ = b.Write([]byte{byte(j)})
+				b.Release()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkPoolGet(b *testing.B) {
+	p, _ := New()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			buf := p.Get(512)
+			_, _ = buf.Write([]byte("payload"))
+			buf.Release()
+		}
+	})
+}
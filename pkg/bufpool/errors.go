@@ -0,0 +1,5 @@
+package bufpool
+
+import "errors"
+
+var ErrInvalidClasses = errors.New("buffer size classes must be positive and ascending")
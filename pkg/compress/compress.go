@@ -0,0 +1,253 @@
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/golang/snappy"
+)
+
+// Names of the codecs registered in the default registry
+const (
+	Gzip    = "gzip"
+	Deflate = "deflate"
+	Snappy  = "snappy"
+	Zstd    = "zstd"
+)
+
+// Codec compresses and decompresses byte slices
+// Encode and Decode append to dst and must be safe for concurrent use
+type Codec interface {
+	Name() string
+	Encode(dst, src []byte) ([]byte, error)
+	Decode(dst, src []byte) ([]byte, error)
+}
+
+// Options configures a codec when it is first used
+type Options struct {
+	// Level is the codec specific compression level, zero selects the codec default
+	Level int
+	// Dictionary is a preset dictionary shared by encoder and decoder
+	// Small JSON payloads with repeated field names compress much better with a dictionary of typical messages,
+	// deflate only matches against the dictionary at higher levels so combine it with Level 9
+	// Only deflate and zstd support dictionaries
+	Dictionary []byte
+}
+
+// Factory creates a codec from its options
+type Factory func(opts Options) (Codec, error)
+
+type entry struct {
+	factory Factory
+	opts    Options
+	once    sync.Once
+	codec   Codec
+	err     error
+}
+
+// Registry holds codec factories by name and creates each codec lazily on first use
+type Registry struct {
+	mu      sync.RWMutex
+	entries map[string]*entry
+}
+
+// NewRegistry creates an empty codec registry
+func NewRegistry() *Registry {
+	return &Registry{
+		entries: make(map[string]*entry),
+	}
+}
+
+// NewDefaultRegistry creates a registry with the built-in gzip, deflate, snappy and zstd codecs
+func NewDefaultRegistry() *Registry {
+	r := NewRegistry()
+	_ = r.Register(Gzip, NewGzip, Options{})
+	_ = r.Register(Deflate, NewDeflate, Options{})
+	_ = r.Register(Snappy, NewSnappy, Options{})
+	_ = r.Register(Zstd, NewZstd, Options{})
+	return r
+}
+
+// Register registers a codec factory and the options it is created with
+func (r *Registry) Register(name string, factory Factory, opts Options) error {
+	if name == "" || factory == nil {
+		return ErrEmptyCodecName
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.entries[name]; exists {
+		return ErrCodecAlreadyExists
+	}
+	r.entries[name] = &entry{factory: factory, opts: opts}
+	return nil
+}
+
+// Get returns the named codec, creating it on first use
+func (r *Registry) Get(name string) (Codec, error) {
+	r.mu.RLock()
+	e, exists := r.entries[name]
+	r.mu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrCodecNotFound, name)
+	}
+
+	e.once.Do(func() {
+		e.codec, e.err = e.factory(e.opts)
+	})
+	return e.codec, e.err
+}
+
+// Names returns the names of all registered codecs
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.entries))
+	for name := range r.entries {
+		names = append(names, name)
+	}
+	return names
+}
+
+var defaultRegistry = NewDefaultRegistry()
+
+// Default returns the process wide registry shared by all subsystems
+func Default() *Registry {
+	return defaultRegistry
+}
+
+// Register registers a codec in the default registry
+func Register(name string, factory Factory, opts Options) error {
+	return defaultRegistry.Register(name, factory, opts)
+}
+
+// Get returns a codec from the default registry
+func Get(name string) (Codec, error) {
+	return defaultRegistry.Get(name)
+}
+
+// flateCodec implements deflate and gzip with pooled writers
+type flateCodec struct {
+	name    string
+	level   int
+	dict    []byte
+	writers sync.Pool
+}
+
+// NewDeflate creates a raw deflate codec supporting a preset dictionary
+func NewDeflate(opts Options) (Codec, error) {
+	level := opts.Level
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	if _, err := flate.NewWriterDict(io.Discard, level, opts.Dictionary); err != nil {
+		return nil, err
+	}
+	return &flateCodec{name: Deflate, level: level, dict: opts.Dictionary}, nil
+}
+
+// NewGzip creates a gzip codec
+func NewGzip(opts Options) (Codec, error) {
+	if len(opts.Dictionary) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrDictionaryUnsupported, Gzip)
+	}
+	level := opts.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	if _, err := gzip.NewWriterLevel(io.Discard, level); err != nil {
+		return nil, err
+	}
+	return &flateCodec{name: Gzip, level: level}, nil
+}
+
+func (c *flateCodec) Name() string {
+	return c.name
+}
+
+type resettableWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+func (c *flateCodec) writer(buf *bytes.Buffer) resettableWriter {
+	if w, ok := c.writers.Get().(resettableWriter); ok {
+		w.Reset(buf)
+		return w
+	}
+	if c.name == Gzip {
+		w, _ := gzip.NewWriterLevel(buf, c.level)
+		return w
+	}
+	w, _ := flate.NewWriterDict(buf, c.level, c.dict)
+	return w
+}
+
+func (c *flateCodec) Encode(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	w := c.writer(buf)
+	defer c.writers.Put(w)
+
+	if _, err := w.Write(src); err != nil {
+		return dst, err
+	}
+	if err := w.Close(); err != nil {
+		return dst, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *flateCodec) Decode(dst, src []byte) ([]byte, error) {
+	var r io.ReadCloser
+	if c.name == Gzip {
+		gr, err := gzip.NewReader(bytes.NewReader(src))
+		if err != nil {
+			return dst, err
+		}
+		r = gr
+	} else {
+		r = flate.NewReaderDict(bytes.NewReader(src), c.dict)
+	}
+	defer r.Close()
+
+	buf := bytes.NewBuffer(dst)
+	if _, err := buf.ReadFrom(r); err != nil {
+		return dst, err
+	}
+	return buf.Bytes(), nil
+}
+
+type snappyCodec struct{}
+
+// NewSnappy creates a snappy block codec
+func NewSnappy(opts Options) (Codec, error) {
+	if len(opts.Dictionary) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrDictionaryUnsupported, Snappy)
+	}
+	return snappyCodec{}, nil
+}
+
+func (snappyCodec) Name() string {
+	return Snappy
+}
+
+func (snappyCodec) Encode(dst, src []byte) ([]byte, error) {
+	n := len(dst)
+	out := snappy.Encode(nil, src)
+	return append(dst[:n], out...), nil
+}
+
+func (snappyCodec) Decode(dst, src []byte) ([]byte, error) {
+	out, err := snappy.Decode(nil, src)
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, out...), nil
+}
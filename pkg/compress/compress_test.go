@@ -0,0 +1,216 @@
+package compress
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func samplePayload() []byte {
+	payload, _ := json.Marshal(map[string]any{
+		"device_id":   "sensor-0042",
+		"temperature": 21.5,
+		"humidity":    48,
+		"timestamp":   1700000000,
+	})
+	return payload
+}
+
+func TestDefaultRegistryNames(t *testing.T) {
+	names := NewDefaultRegistry().Names()
+	sort.Strings(names)
+	assert.Equal(t, []string{Deflate, Gzip, Snappy, Zstd}, names)
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	inputs := map[string][]byte{
+		"empty":   {},
+		"json":    samplePayload(),
+		"large":   bytes.Repeat([]byte("mqtt payload "), 10000),
+		"samples": []byte("a"),
+	}
+
+	for _, name := range []string{Gzip, Deflate, Snappy, Zstd} {
+		codec, err := Get(name)
+		require.NoError(t, err)
+		assert.Equal(t, name, codec.Name())
+
+		for inputName, input := range inputs {
+			t.Run(name+"/"+inputName, func(t *testing.T) {
+				encoded, err := codec.Encode(nil, input)
+				require.NoError(t, err)
+
+				decoded, err := codec.Decode(nil, encoded)
+				require.NoError(t, err)
+				assert.Equal(t, len(input), len(decoded))
+				assert.True(t, bytes.Equal(input, decoded))
+			})
+		}
+	}
+}
+
+func TestCodecAppendsToDst(t *testing.T) {
+	for _, name := range []string{Gzip, Deflate, Snappy, Zstd} {
+		t.Run(name, func(t *testing.T) {
+			codec, err := Get(name)
+			require.NoError(t, err)
+
+			encoded, err := codec.Encode([]byte("hdr"), samplePayload())
+			require.NoError(t, err)
+			assert.Equal(t, []byte("hdr"), encoded[:3])
+
+			decoded, err := codec.Decode([]byte("hdr"), encoded[3:])
+			require.NoError(t, err)
+			assert.Equal(t, append([]byte("hdr"), samplePayload()...), decoded)
+		})
+	}
+}
+
+func TestCodecDecodeCorrupt(t *testing.T) {
+	for _, name := range []string{Gzip, Deflate, Snappy, Zstd} {
+		t.Run(name, func(t *testing.T) {
+			codec, err := Get(name)
+			require.NoError(t, err)
+			_, err = codec.Decode(nil, []byte{0xff, 0xfe, 0xfd, 0xfc})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestDeflateDictionary(t *testing.T) {
+	dict := []byte(`{"device_id":"sensor-","temperature":,"humidity":,"timestamp":}`)
+	plain, err := NewDeflate(Options{Level: 9})
+	require.NoError(t, err)
+	withDict, err := NewDeflate(Options{Level: 9, Dictionary: dict})
+	require.NoError(t, err)
+
+	payload := samplePayload()
+	small, err := withDict.Encode(nil, payload)
+	require.NoError(t, err)
+	large, err := plain.Encode(nil, payload)
+	require.NoError(t, err)
+	assert.Less(t, len(small), len(large))
+
+	decoded, err := withDict.Decode(nil, small)
+	require.NoError(t, err)
+	assert.Equal(t, payload, decoded)
+
+	// A payload encoded with a dictionary cannot be decoded without it
+	decoded, err = plain.Decode(nil, small)
+	assert.False(t, err == nil && bytes.Equal(decoded, payload))
+}
+
+func TestZstdDictionary(t *testing.T) {
+	dict := bytes.Repeat([]byte(`{"device_id":"sensor-0000","temperature":20.0,"humidity":50,"timestamp":1700000000}`), 4)
+	plain, err := NewZstd(Options{})
+	require.NoError(t, err)
+	withDict, err := NewZstd(Options{Level: 19, Dictionary: dict})
+	require.NoError(t, err)
+
+	payload := samplePayload()
+	small, err := withDict.Encode(nil, payload)
+	require.NoError(t, err)
+	large, err := plain.Encode(nil, payload)
+	require.NoError(t, err)
+	assert.Less(t, len(small), len(large))
+
+	decoded, err := withDict.Decode(nil, small)
+	require.NoError(t, err)
+	assert.Equal(t, payload, decoded)
+
+	// Frames name their dictionary, decoders without it or with another one reject them
+	_, err = plain.Decode(nil, small)
+	assert.Error(t, err)
+	other, err := NewZstd(Options{Dictionary: []byte("other dictionary")})
+	require.NoError(t, err)
+	_, err = other.Decode(nil, small)
+	assert.Error(t, err)
+}
+
+func TestDictionaryUnsupported(t *testing.T) {
+	_, err := NewGzip(Options{Dictionary: []byte("x")})
+	assert.ErrorIs(t, err, ErrDictionaryUnsupported)
+	_, err = NewSnappy(Options{Dictionary: []byte("x")})
+	assert.ErrorIs(t, err, ErrDictionaryUnsupported)
+}
+
+func TestInvalidLevel(t *testing.T) {
+	_, err := NewDeflate(Options{Level: 42})
+	assert.Error(t, err)
+	_, err = NewGzip(Options{Level: 42})
+	assert.Error(t, err)
+	_, err = NewZstd(Options{Level: 42})
+	assert.ErrorIs(t, err, ErrInvalidLevel)
+}
+
+func TestRegistryRegister(t *testing.T) {
+	r := NewRegistry()
+	factory := func(Options) (Codec, error) { return snappyCodec{}, nil }
+
+	require.NoError(t, r.Register(Zstd, factory, Options{}))
+	assert.ErrorIs(t, r.Register(Zstd, factory, Options{}), ErrCodecAlreadyExists)
+	assert.ErrorIs(t, r.Register("", factory, Options{}), ErrEmptyCodecName)
+	assert.ErrorIs(t, r.Register("x", nil, Options{}), ErrEmptyCodecName)
+
+	_, err := r.Get("missing")
+	assert.ErrorIs(t, err, ErrCodecNotFound)
+}
+
+func TestRegistryLazyInit(t *testing.T) {
+	r := NewRegistry()
+	var calls int
+	var mu sync.Mutex
+	require.NoError(t, r.Register("lazy", func(opts Options) (Codec, error) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		return NewDeflate(opts)
+	}, Options{Level: 9}))
+	assert.Equal(t, 0, calls)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = r.Get("lazy")
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, calls)
+
+	codec, err := r.Get("lazy")
+	require.NoError(t, err)
+	assert.Equal(t, 9, codec.(*flateCodec).level)
+}
+
+func TestRegistryFactoryError(t *testing.T) {
+	r := NewRegistry()
+	require.NoError(t, r.Register(Gzip, NewGzip, Options{Dictionary: []byte("x")}))
+	_, err := r.Get(Gzip)
+	assert.ErrorIs(t, err, ErrDictionaryUnsupported)
+}
+
+func TestCodecConcurrentUse(t *testing.T) {
+	codec, err := Get(Deflate)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			encoded, err := codec.Encode(nil, samplePayload())
+			assert.NoError(t, err)
+			decoded, err := codec.Decode(nil, encoded)
+			assert.NoError(t, err)
+			assert.Equal(t, samplePayload(), decoded)
+		}()
+	}
+	wg.Wait()
+}
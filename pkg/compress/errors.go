@@ -0,0 +1,11 @@
+package compress
+
+import "errors"
+
+var (
+	ErrCodecNotFound         = errors.New("compression codec not found")
+	ErrCodecAlreadyExists    = errors.New("compression codec already exists")
+	ErrEmptyCodecName        = errors.New("compression codec name cannot be empty")
+	ErrDictionaryUnsupported = errors.New("compression codec does not support dictionaries")
+	ErrInvalidLevel          = errors.New("invalid compression level")
+)
@@ -0,0 +1,70 @@
+package compress
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdDictMagic starts dictionaries in the format produced by zstd --train
+var zstdDictMagic = []byte{0x37, 0xa4, 0x30, 0xec}
+
+// zstdCodec encodes whole frames, the encoder and decoder are safe for concurrent use
+type zstdCodec struct {
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+// NewZstd creates a zstd codec, Level is the zstd level from 1 to 22
+// The dictionary is either trained by zstd --train or raw content such as typical messages, raw dictionaries
+// are identified by their checksum so frames encoded with another dictionary fail to decode
+func NewZstd(opts Options) (Codec, error) {
+	if opts.Level < 0 || opts.Level > 22 {
+		return nil, fmt.Errorf("%w: %s level %d", ErrInvalidLevel, Zstd, opts.Level)
+	}
+
+	eopts := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+	dopts := []zstd.DOption{zstd.WithDecoderConcurrency(0)}
+	if opts.Level > 0 {
+		eopts = append(eopts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(opts.Level)))
+	}
+	switch {
+	case len(opts.Dictionary) == 0:
+	case bytes.HasPrefix(opts.Dictionary, zstdDictMagic):
+		eopts = append(eopts, zstd.WithEncoderDict(opts.Dictionary))
+		dopts = append(dopts, zstd.WithDecoderDicts(opts.Dictionary))
+	default:
+		id := max(crc32.ChecksumIEEE(opts.Dictionary), 1)
+		eopts = append(eopts, zstd.WithEncoderDictRaw(id, opts.Dictionary))
+		dopts = append(dopts, zstd.WithDecoderDictRaw(id, opts.Dictionary))
+	}
+
+	encoder, err := zstd.NewWriter(nil, eopts...)
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := zstd.NewReader(nil, dopts...)
+	if err != nil {
+		encoder.Close()
+		return nil, err
+	}
+	return &zstdCodec{encoder: encoder, decoder: decoder}, nil
+}
+
+func (c *zstdCodec) Name() string {
+	return Zstd
+}
+
+func (c *zstdCodec) Encode(dst, src []byte) ([]byte, error) {
+	return c.encoder.EncodeAll(src, dst), nil
+}
+
+func (c *zstdCodec) Decode(dst, src []byte) ([]byte, error) {
+	out, err := c.decoder.DecodeAll(src, dst)
+	if err != nil {
+		return dst, err
+	}
+	return out, nil
+}
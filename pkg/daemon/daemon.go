@@ -0,0 +1,161 @@
+// Package daemon runs a long-lived server under systemd, as a Windows service or in the foreground,
+// handling readiness notification, the watchdog, a pid file and signal driven reload and shutdown
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/axmq/ax/pkg/logger"
+)
+
+// Service is the server a daemon runs
+type Service interface {
+	// Start starts serving and returns once the service is ready to accept clients
+	Start(ctx context.Context) error
+
+	// Reload re-reads configuration and certificates, the service keeps running on error
+	Reload(ctx context.Context) error
+
+	// Stop shuts the service down, ctx bounds how long it may take
+	Stop(ctx context.Context) error
+}
+
+// Config configures how a service is run
+type Config struct {
+	// PIDFile is written while the service runs, empty disables it
+	PIDFile string
+	// StopTimeout bounds the graceful shutdown
+	StopTimeout time.Duration
+	Logger      logger.Logger
+}
+
+func DefaultConfig() Config {
+	return Config{StopTimeout: 30 * time.Second}
+}
+
+// Run starts svc and blocks until ctx is done or a stop signal arrives, reload signals (SIGHUP) call Reload
+// Readiness, reloads, shutdown and watchdog keep-alives are reported to systemd when it asked for them
+func Run(ctx context.Context, svc Service, config Config) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, append(append([]os.Signal(nil), stopSignals...), reloadSignals...)...)
+	defer signal.Stop(signals)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	reload := make(chan struct{}, 1)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-signals:
+				if isReloadSignal(sig) {
+					select {
+					case reload <- struct{}{}:
+					default:
+					}
+					continue
+				}
+				config.infof("received %s, shutting down", sig)
+				cancel()
+				return
+			}
+		}
+	}()
+
+	return run(ctx, svc, config, reload, nil)
+}
+
+func isReloadSignal(sig os.Signal) bool {
+	for _, s := range reloadSignals {
+		if sig == s {
+			return true
+		}
+	}
+	return false
+}
+
+// run drives svc until ctx is done, ready is called once the service started
+func run(ctx context.Context, svc Service, config Config, reload <-chan struct{}, ready func()) error {
+	if config.PIDFile != "" {
+		pidFile, err := CreatePIDFile(config.PIDFile)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if rerr := pidFile.Remove(); rerr != nil {
+				config.warnf("failed to remove pid file: %v", rerr)
+			}
+		}()
+	}
+
+	if err := svc.Start(ctx); err != nil {
+		return fmt.Errorf("failed to start: %w", err)
+	}
+	config.notify(NotifyReady + "\nMAINPID=" + fmt.Sprint(os.Getpid()))
+	if ready != nil {
+		ready()
+	}
+
+	var watchdog <-chan time.Time
+	if interval, ok := WatchdogInterval(); ok {
+		// Ping at half the timeout so a single late tick does not get the service killed
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		watchdog = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			config.notify(NotifyStopping)
+			return config.stop(svc)
+		case <-reload:
+			config.notify(NotifyReloading)
+			if err := svc.Reload(ctx); err != nil {
+				config.warnf("reload failed: %v", err)
+			}
+			config.notify(NotifyReady)
+		case <-watchdog:
+			config.notify(NotifyWatchdog)
+		}
+	}
+}
+
+func (c Config) stop(svc Service) error {
+	ctx := context.Background()
+	if c.StopTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.StopTimeout)
+		defer cancel()
+	}
+
+	if err := svc.Stop(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		return fmt.Errorf("failed to stop: %w", err)
+	}
+	return nil
+}
+
+func (c Config) notify(state string) {
+	if _, err := Notify(state); err != nil {
+		c.warnf("failed to notify service manager: %v", err)
+	}
+}
+
+func (c Config) infof(format string, args ...any) {
+	if c.Logger != nil {
+		c.Logger.Info(fmt.Sprintf(format, args...))
+	}
+}
+
+func (c Config) warnf(format string, args ...any) {
+	if c.Logger != nil {
+		c.Logger.Warn(fmt.Sprintf(format, args...))
+	}
+}
@@ -0,0 +1,208 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeService struct {
+	mu       sync.Mutex
+	started  int
+	reloaded int
+	stopped  int
+	startErr error
+}
+
+func (s *fakeService) Start(context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.started++
+	return s.startErr
+}
+
+func (s *fakeService) Reload(context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reloaded++
+	return nil
+}
+
+func (s *fakeService) Stop(context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped++
+	return nil
+}
+
+func (s *fakeService) counts() (int, int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.started, s.reloaded, s.stopped
+}
+
+// listenNotify points NOTIFY_SOCKET at a datagram socket and returns it
+func listenNotify(t *testing.T) *net.UnixConn {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("systemd notifications are not available on Windows")
+	}
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	return conn
+}
+
+func readNotify(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+	buf := make([]byte, 256)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	return string(buf[:n])
+}
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	sent, err := Notify(NotifyReady)
+	assert.NoError(t, err)
+	assert.False(t, sent)
+
+	conn := listenNotify(t)
+	sent, err = Notify(NotifyReady)
+	require.NoError(t, err)
+	assert.True(t, sent)
+	assert.Equal(t, NotifyReady, readNotify(t, conn))
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "")
+	_, ok := WatchdogInterval()
+	assert.False(t, ok)
+
+	t.Setenv("WATCHDOG_USEC", "2000000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	interval, ok := WatchdogInterval()
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Second, interval)
+
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	_, ok = WatchdogInterval()
+	assert.False(t, ok)
+}
+
+func TestPIDFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "axd.pid")
+
+	f, err := CreatePIDFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, path, f.Path())
+
+	pid, err := ReadPIDFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.Getpid(), pid)
+
+	require.NoError(t, f.Remove())
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, f.Remove())
+}
+
+func TestPIDFileStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "axd.pid")
+
+	// A pid far beyond pid_max never belongs to a live process
+	require.NoError(t, os.WriteFile(path, []byte("2147483647\n"), 0o644))
+	f, err := CreatePIDFile(path)
+	require.NoError(t, err)
+	defer f.Remove()
+
+	pid, err := ReadPIDFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.Getpid(), pid)
+}
+
+func TestPIDFileInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "axd.pid")
+	require.NoError(t, os.WriteFile(path, []byte("garbage"), 0o644))
+
+	_, err := CreatePIDFile(path)
+	assert.Error(t, err)
+}
+
+func TestRunLifecycle(t *testing.T) {
+	conn := listenNotify(t)
+	t.Setenv("WATCHDOG_USEC", "")
+	pidPath := filepath.Join(t.TempDir(), "axd.pid")
+
+	svc := &fakeService{}
+	ctx, cancel := context.WithCancel(context.Background())
+	reload := make(chan struct{})
+	ready := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- run(ctx, svc, Config{PIDFile: pidPath, StopTimeout: time.Second}, reload, func() { close(ready) })
+	}()
+
+	<-ready
+	assert.Contains(t, readNotify(t, conn), NotifyReady)
+	_, err := os.Stat(pidPath)
+	require.NoError(t, err)
+
+	reload <- struct{}{}
+	assert.Equal(t, NotifyReloading, readNotify(t, conn))
+	assert.Equal(t, NotifyReady, readNotify(t, conn))
+
+	cancel()
+	require.NoError(t, <-done)
+	assert.Equal(t, NotifyStopping, readNotify(t, conn))
+
+	started, reloaded, stopped := svc.counts()
+	assert.Equal(t, 1, started)
+	assert.Equal(t, 1, reloaded)
+	assert.Equal(t, 1, stopped)
+
+	_, err = os.Stat(pidPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRunWatchdog(t *testing.T) {
+	conn := listenNotify(t)
+	t.Setenv("WATCHDOG_USEC", "20000")
+	t.Setenv("WATCHDOG_PID", "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go run(ctx, &fakeService{}, Config{}, nil, nil)
+
+	assert.Contains(t, readNotify(t, conn), NotifyReady)
+	assert.Equal(t, NotifyWatchdog, readNotify(t, conn))
+}
+
+func TestRunStartError(t *testing.T) {
+	errStart := errors.New("bind failed")
+	svc := &fakeService{startErr: errStart}
+
+	err := run(context.Background(), svc, Config{}, nil, nil)
+	assert.ErrorIs(t, err, errStart)
+	_, _, stopped := svc.counts()
+	assert.Equal(t, 0, stopped)
+}
+
+func TestRunWindowsServiceFromConsole(t *testing.T) {
+	if runtime.GOOS != "windows" {
+		assert.ErrorIs(t, RunWindowsService("axd", &fakeService{}, Config{}), ErrNotService)
+	}
+}
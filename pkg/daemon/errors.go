@@ -0,0 +1,8 @@
+package daemon
+
+import "errors"
+
+var (
+	ErrAlreadyRunning = errors.New("daemon already running")
+	ErrNotService     = errors.New("not running as a Windows service")
+)
@@ -0,0 +1,87 @@
+package daemon
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// PIDFile records the process ID of a running daemon
+type PIDFile struct {
+	path string
+	pid  int
+}
+
+// CreatePIDFile writes the current process ID to path
+// A file left behind by a process that is no longer running is replaced, a live one yields ErrAlreadyRunning
+func CreatePIDFile(path string) (*PIDFile, error) {
+	if pid, err := ReadPIDFile(path); err == nil {
+		if pid != os.Getpid() && processAlive(pid) {
+			return nil, fmt.Errorf("%w: pid %d in %s", ErrAlreadyRunning, pid, path)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	f := &PIDFile{path: path, pid: os.Getpid()}
+
+	// Write to a temporary file and rename so readers never see a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pid file: %w", err)
+	}
+	if _, err := tmp.WriteString(strconv.Itoa(f.pid) + "\n"); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("failed to write pid file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("failed to write pid file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("failed to write pid file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("failed to write pid file: %w", err)
+	}
+	return f, nil
+}
+
+// ReadPIDFile returns the process ID stored in path
+func ReadPIDFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(string(bytes.TrimSpace(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid pid file %s", path)
+	}
+	return pid, nil
+}
+
+// Path returns the path of the pid file
+func (f *PIDFile) Path() string {
+	return f.path
+}
+
+// Remove deletes the pid file unless another process has replaced it since
+func (f *PIDFile) Remove() error {
+	pid, err := ReadPIDFile(f.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if pid != f.pid {
+		return nil
+	}
+	return os.Remove(f.path)
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+
+package daemon
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// reloadSignals ask a running daemon to reload its configuration and certificates
+var reloadSignals = []os.Signal{syscall.SIGHUP}
+
+// stopSignals ask a running daemon to shut down gracefully
+var stopSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,27 @@
+//go:build windows
+
+package daemon
+
+import (
+	"os"
+	"syscall"
+)
+
+// Windows has no reload signal, services are reloaded with the PARAMCHANGE control instead
+var reloadSignals []os.Signal
+
+var stopSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+const processQueryLimitedInformation = 0x1000
+
+func processAlive(pid int) bool {
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(h)
+
+	var code uint32
+	const stillActive = 259
+	return syscall.GetExitCodeProcess(h, &code) == nil && code == stillActive
+}
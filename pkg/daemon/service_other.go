@@ -0,0 +1,8 @@
+//go:build !windows
+
+package daemon
+
+// RunWindowsService returns ErrNotService on platforms other than Windows
+func RunWindowsService(name string, svc Service, config Config) error {
+	return ErrNotService
+}
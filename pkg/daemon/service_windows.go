@@ -0,0 +1,173 @@
+//go:build windows
+
+package daemon
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	serviceWin32OwnProcess = 0x10
+
+	serviceStopped      = 1
+	serviceStartPending = 2
+	serviceStopPending  = 3
+	serviceRunning      = 4
+
+	serviceAcceptStop        = 0x1
+	serviceAcceptShutdown    = 0x4
+	serviceAcceptParamChange = 0x8
+
+	serviceControlStop        = 1
+	serviceControlInterrogate = 4
+	serviceControlShutdown    = 5
+	serviceControlParamChange = 6
+
+	errorFailedServiceControllerConnect = syscall.Errno(1063)
+	errorCallNotImplemented             = 120
+)
+
+var (
+	advapi32                         = syscall.NewLazyDLL("advapi32.dll")
+	procStartServiceCtrlDispatcherW  = advapi32.NewProc("StartServiceCtrlDispatcherW")
+	procRegisterServiceCtrlHandlerEx = advapi32.NewProc("RegisterServiceCtrlHandlerExW")
+	procSetServiceStatus             = advapi32.NewProc("SetServiceStatus")
+)
+
+type serviceStatus struct {
+	ServiceType             uint32
+	CurrentState            uint32
+	ControlsAccepted        uint32
+	Win32ExitCode           uint32
+	ServiceSpecificExitCode uint32
+	CheckPoint              uint32
+	WaitHint                uint32
+}
+
+type serviceTableEntry struct {
+	name *uint16
+	proc uintptr
+}
+
+// windowsService holds the state shared with the callbacks the service control manager invokes
+type windowsService struct {
+	name   *uint16
+	svc    Service
+	config Config
+
+	mu     sync.Mutex
+	handle uintptr
+	status serviceStatus
+
+	cancel context.CancelFunc
+	reload chan struct{}
+	err    error
+}
+
+// only one service runs per process, the callbacks cannot carry Go pointers
+var current *windowsService
+
+// RunWindowsService runs svc under the Windows service control manager as the service called name
+// It returns ErrNotService when the process was started from a console, callers then fall back to Run
+// The STOP and SHUTDOWN controls stop the service and PARAMCHANGE reloads it
+func RunWindowsService(name string, svc Service, config Config) error {
+	namePtr, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+
+	current = &windowsService{
+		name:   namePtr,
+		svc:    svc,
+		config: config,
+		reload: make(chan struct{}, 1),
+		status: serviceStatus{ServiceType: serviceWin32OwnProcess},
+	}
+
+	table := []serviceTableEntry{
+		{name: namePtr, proc: syscall.NewCallback(serviceMain)},
+		{},
+	}
+	r, _, err := procStartServiceCtrlDispatcherW.Call(uintptr(unsafe.Pointer(&table[0])))
+	if r == 0 {
+		if errors.Is(err, errorFailedServiceControllerConnect) {
+			return ErrNotService
+		}
+		return err
+	}
+	return current.err
+}
+
+func serviceMain(_ uint32, _ **uint16) uintptr {
+	s := current
+	handle, _, err := procRegisterServiceCtrlHandlerEx.Call(uintptr(unsafe.Pointer(s.name)), syscall.NewCallback(serviceHandler), 0)
+	if handle == 0 {
+		s.err = err
+		return 0
+	}
+	s.handle = handle
+	s.setStatus(serviceStartPending, 0, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	s.cancel = cancel
+	s.mu.Unlock()
+
+	s.err = run(ctx, s.svc, s.config, s.reload, func() {
+		s.setStatus(serviceRunning, serviceAcceptStop|serviceAcceptShutdown|serviceAcceptParamChange, 0)
+	})
+
+	exitCode := uint32(0)
+	if s.err != nil {
+		exitCode = 1
+	}
+	s.setStatus(serviceStopped, 0, exitCode)
+	return 0
+}
+
+func serviceHandler(control, _ uint32, _, _ uintptr) uintptr {
+	s := current
+	switch control {
+	case serviceControlStop, serviceControlShutdown:
+		s.setStatus(serviceStopPending, 0, 0)
+		s.mu.Lock()
+		if s.cancel != nil {
+			s.cancel()
+		}
+		s.mu.Unlock()
+	case serviceControlParamChange:
+		select {
+		case s.reload <- struct{}{}:
+		default:
+		}
+	case serviceControlInterrogate:
+		s.mu.Lock()
+		status := s.status
+		s.mu.Unlock()
+		procSetServiceStatus.Call(s.handle, uintptr(unsafe.Pointer(&status)))
+	default:
+		return errorCallNotImplemented
+	}
+	return 0
+}
+
+func (s *windowsService) setStatus(state, accepts, exitCode uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status.CurrentState = state
+	s.status.ControlsAccepted = accepts
+	s.status.Win32ExitCode = exitCode
+	if state == serviceStartPending || state == serviceStopPending {
+		s.status.CheckPoint++
+		s.status.WaitHint = 30000
+	} else {
+		s.status.CheckPoint = 0
+		s.status.WaitHint = 0
+	}
+	procSetServiceStatus.Call(s.handle, uintptr(unsafe.Pointer(&s.status)))
+}
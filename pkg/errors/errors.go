@@ -0,0 +1,124 @@
+// Package errors defines the error categories shared across packages
+//
+// Packages declare their sentinel errors with New so each carries a stable Kind, callers match
+// a category with errors.Is against the kind sentinels (ErrProtocol, ErrAuth, ...) or read it
+// with KindOf, and map it to reason codes and metrics labels without knowing every sentinel
+package errors
+
+import (
+	stderrors "errors"
+	"fmt"
+)
+
+// Kind is the category of an error
+type Kind uint8
+
+const (
+	KindUnknown Kind = iota
+	KindProtocol
+	KindAuth
+	KindQuota
+	KindStorage
+	KindInternal
+)
+
+var kindLabels = [...]string{
+	KindUnknown:  "unknown",
+	KindProtocol: "protocol",
+	KindAuth:     "auth",
+	KindQuota:    "quota",
+	KindStorage:  "storage",
+	KindInternal: "internal",
+}
+
+// String returns the kind name, which is also its metrics label
+func (k Kind) String() string {
+	if int(k) < len(kindLabels) {
+		return kindLabels[k]
+	}
+	return fmt.Sprintf("Kind(%d)", uint8(k))
+}
+
+// Sentinels of each kind, every error of a kind matches its sentinel with errors.Is
+var (
+	ErrProtocol = &Error{kind: KindProtocol, text: "protocol error"}
+	ErrAuth     = &Error{kind: KindAuth, text: "authorization error"}
+	ErrQuota    = &Error{kind: KindQuota, text: "quota error"}
+	ErrStorage  = &Error{kind: KindStorage, text: "storage error"}
+	ErrInternal = &Error{kind: KindInternal, text: "internal error"}
+)
+
+var kindSentinels = [...]*Error{
+	KindProtocol: ErrProtocol,
+	KindAuth:     ErrAuth,
+	KindQuota:    ErrQuota,
+	KindStorage:  ErrStorage,
+	KindInternal: ErrInternal,
+}
+
+// Error is an error of a known kind, either a sentinel created with New or a foreign error tagged with Wrap
+type Error struct {
+	kind Kind
+	text string
+	err  error
+}
+
+// New returns a sentinel error of the given kind
+func New(kind Kind, text string) error {
+	return &Error{kind: kind, text: text}
+}
+
+// Wrap tags err with kind, the result matches err and the kind sentinel with errors.Is
+// A nil err stays nil
+func Wrap(kind Kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{kind: kind, err: err}
+}
+
+func (e *Error) Error() string {
+	if e.err != nil {
+		return e.err.Error()
+	}
+	return e.text
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// Is reports whether target is the sentinel of the error's kind
+func (e *Error) Is(target error) bool {
+	return int(e.kind) < len(kindSentinels) && kindSentinels[e.kind] != nil && target == kindSentinels[e.kind]
+}
+
+// Kind returns the category of the error
+func (e *Error) Kind() Kind {
+	return e.kind
+}
+
+// KindOf returns the kind of the first categorized error in err's chain, KindUnknown when there is none
+func KindOf(err error) Kind {
+	var e *Error
+	if stderrors.As(err, &e) {
+		return e.kind
+	}
+	return KindUnknown
+}
+
+// Label returns the metrics label of err's kind, "none" for a nil error
+func Label(err error) string {
+	if err == nil {
+		return "none"
+	}
+	return KindOf(err).String()
+}
+
+// Is, As, Unwrap and Join mirror the standard library so callers need a single errors import
+var (
+	Is     = stderrors.Is
+	As     = stderrors.As
+	Unwrap = stderrors.Unwrap
+	Join   = stderrors.Join
+)
@@ -0,0 +1,62 @@
+package errors
+
+import (
+	stderrors "errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew_MatchesKindSentinel(t *testing.T) {
+	errBadThing := New(KindProtocol, "bad thing")
+	wrapped := fmt.Errorf("decode: %w", errBadThing)
+
+	assert.True(t, Is(wrapped, errBadThing))
+	assert.True(t, Is(wrapped, ErrProtocol))
+	assert.False(t, Is(wrapped, ErrAuth))
+	assert.Equal(t, "bad thing", errBadThing.Error())
+	assert.False(t, Is(New(KindProtocol, "bad thing"), errBadThing), "sentinels are distinct by identity")
+}
+
+func TestWrap(t *testing.T) {
+	cause := stderrors.New("disk full")
+	err := Wrap(KindStorage, cause)
+
+	assert.True(t, Is(err, cause))
+	assert.True(t, Is(err, ErrStorage))
+	assert.Equal(t, "disk full", err.Error())
+	assert.Nil(t, Wrap(KindStorage, nil))
+}
+
+func TestKindOf(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want Kind
+	}{
+		{name: "nil", err: nil, want: KindUnknown},
+		{name: "plain", err: stderrors.New("boom"), want: KindUnknown},
+		{name: "sentinel", err: ErrQuota, want: KindQuota},
+		{name: "wrapped", err: fmt.Errorf("save: %w", New(KindStorage, "closed")), want: KindStorage},
+		{name: "outermost wins", err: Wrap(KindInternal, New(KindAuth, "denied")), want: KindInternal},
+		{name: "joined", err: Join(stderrors.New("a"), New(KindAuth, "denied")), want: KindAuth},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, KindOf(tt.err))
+		})
+	}
+}
+
+func TestLabel(t *testing.T) {
+	assert.Equal(t, "none", Label(nil))
+	assert.Equal(t, "unknown", Label(stderrors.New("boom")))
+	assert.Equal(t, "protocol", Label(ErrProtocol))
+	assert.Equal(t, "auth", Label(New(KindAuth, "denied")))
+	assert.Equal(t, "quota", KindQuota.String())
+	assert.Equal(t, "storage", KindStorage.String())
+	assert.Equal(t, "internal", KindInternal.String())
+	assert.Equal(t, "Kind(42)", Kind(42).String())
+}
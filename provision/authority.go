@@ -0,0 +1,159 @@
+package provision
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/axmq/ax/auth/credentials"
+)
+
+// Request is a provisioning request checked against its claim
+type Request struct {
+	ClientID string
+	Claim    *Claim
+	// CSR is the certificate signing request of the device, nil when it asked for password credentials
+	CSR *x509.CertificateRequest
+	// Metadata is sent by the device, e.g. its serial number or firmware version
+	Metadata map[string]string
+}
+
+// Identity holds the credentials issued to a device
+type Identity struct {
+	// Certificate is the PEM encoded client certificate
+	Certificate []byte
+	// CACertificate is the PEM encoded certificate of the issuer the device should trust
+	CACertificate []byte
+	Username      string
+	Password      string
+	// Serial identifies the identity in audit records, e.g. the certificate serial number
+	Serial    string
+	ExpiresAt time.Time
+}
+
+// Authority issues identities, it is the integration point of a certificate authority or credential backend
+// Provisioned reports whether a client ID already has an identity, claims are refused for those so nobody
+// holding the claim of a batch can impersonate a provisioned device and get credentials for it
+type Authority interface {
+	Issue(ctx context.Context, req *Request) (*Identity, error)
+	Provisioned(ctx context.Context, clientID string) (bool, error)
+}
+
+// CAAuthority signs the CSRs of devices with a local CA key
+// It remembers the client IDs it issued certificates to in memory, wrap it to consult a persistent device
+// inventory in Provisioned when identities must stay unique across restarts
+type CAAuthority struct {
+	cert     *x509.Certificate
+	key      crypto.Signer
+	validity time.Duration
+
+	mu     sync.Mutex
+	issued map[string]struct{}
+}
+
+// NewCAAuthority creates an authority issuing client certificates valid for validity, signed by cert and key
+func NewCAAuthority(cert *x509.Certificate, key crypto.Signer, validity time.Duration) (*CAAuthority, error) {
+	if cert == nil || key == nil || !cert.IsCA {
+		return nil, ErrInvalidAuthority
+	}
+	if validity <= 0 {
+		validity = 365 * 24 * time.Hour
+	}
+	return &CAAuthority{cert: cert, key: key, validity: validity, issued: make(map[string]struct{})}, nil
+}
+
+// Issue signs the CSR of the request with the client ID as common name, for TLS client authentication only
+func (a *CAAuthority) Issue(_ context.Context, req *Request) (*Identity, error) {
+	if req.CSR == nil {
+		return nil, ErrCSRRequired
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: req.ClientID},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(a.validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, a.cert, req.CSR.PublicKey, a.key)
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	a.issued[req.ClientID] = struct{}{}
+	a.mu.Unlock()
+
+	return &Identity{
+		Certificate:   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		CACertificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: a.cert.Raw}),
+		Serial:        serial.Text(16),
+		ExpiresAt:     template.NotAfter,
+	}, nil
+}
+
+// Provisioned reports whether a certificate was issued to clientID
+func (a *CAAuthority) Provisioned(_ context.Context, clientID string) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, ok := a.issued[clientID]
+	return ok, nil
+}
+
+// CredentialStore stores issued credentials and finds existing ones, credentials.MemoryStore implements it
+type CredentialStore interface {
+	Set(cred *credentials.Credential)
+	Lookup(ctx context.Context, username string) (*credentials.Credential, error)
+}
+
+// PasswordAuthority issues a random password per device and stores its hash for the regular auth hooks
+type PasswordAuthority struct {
+	store  CredentialStore
+	hasher credentials.Hasher
+}
+
+// NewPasswordAuthority creates an authority storing issued credentials in store
+func NewPasswordAuthority(store CredentialStore) *PasswordAuthority {
+	return &PasswordAuthority{store: store, hasher: credentials.DefaultPBKDF2Hasher()}
+}
+
+// Provisioned reports whether clientID has credentials in the store
+func (a *PasswordAuthority) Provisioned(ctx context.Context, clientID string) (bool, error) {
+	_, err := a.store.Lookup(ctx, clientID)
+	switch {
+	case errors.Is(err, credentials.ErrUserNotFound):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return true, nil
+}
+
+// Issue creates credentials with the client ID as username
+func (a *PasswordAuthority) Issue(_ context.Context, req *Request) (*Identity, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	password := base64.RawURLEncoding.EncodeToString(secret)
+	hash, err := a.hasher.Hash([]byte(password))
+	if err != nil {
+		return nil, err
+	}
+	a.store.Set(&credentials.Credential{Username: req.ClientID, PasswordHash: hash})
+
+	return &Identity{Username: req.ClientID, Password: password}, nil
+}
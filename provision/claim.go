@@ -0,0 +1,120 @@
+package provision
+
+import (
+	"context"
+	"crypto/sha256"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ClaimUsername is the CONNECT username of devices presenting a claim token as their password
+const ClaimUsername = "$claim"
+
+// Claim authorizes devices to provision themselves, it is typically baked into a production batch
+type Claim struct {
+	// ID names the claim in audit records, the token itself is never recorded
+	ID string
+	// Prefix restricts the client IDs the claim may provision, empty allows any
+	Prefix string
+	// ExpiresAt ends the validity of the claim, zero never expires
+	ExpiresAt time.Time
+	// MaxUses is the number of identities the claim may issue, zero is unlimited
+	MaxUses int
+	// Uses is the number of identities issued so far
+	Uses int
+	// Metadata is passed to the authority, e.g. a tenant or a device model
+	Metadata map[string]string
+}
+
+// Allows reports whether the claim may provision clientID
+func (c *Claim) Allows(clientID string) bool {
+	return strings.HasPrefix(clientID, c.Prefix)
+}
+
+// check reports why the claim cannot issue another identity at now
+func (c *Claim) check(now time.Time) error {
+	if !c.ExpiresAt.IsZero() && !now.Before(c.ExpiresAt) {
+		return ErrClaimExpired
+	}
+	if c.MaxUses > 0 && c.Uses >= c.MaxUses {
+		return ErrClaimExhausted
+	}
+	return nil
+}
+
+// ClaimStore validates claim tokens
+// Lookup checks a token when a device connects, Redeem counts a use when an identity is issued so a device
+// that drops before getting its identity does not use up the claim
+type ClaimStore interface {
+	Lookup(ctx context.Context, token string) (*Claim, error)
+	Redeem(ctx context.Context, token string) (*Claim, error)
+}
+
+// MemoryClaims is an in-memory ClaimStore, tokens are kept as SHA-256 digests
+type MemoryClaims struct {
+	mu     sync.Mutex
+	claims map[[sha256.Size]byte]*Claim
+}
+
+// NewMemoryClaims creates an empty in-memory claim store
+func NewMemoryClaims() *MemoryClaims {
+	return &MemoryClaims{
+		claims: make(map[[sha256.Size]byte]*Claim),
+	}
+}
+
+// Add registers a claim under token
+func (s *MemoryClaims) Add(token string, claim *Claim) error {
+	if token == "" {
+		return ErrInvalidClaim
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := sha256.Sum256([]byte(token))
+	if _, ok := s.claims[key]; ok {
+		return ErrClaimExists
+	}
+	c := *claim
+	s.claims[key] = &c
+	return nil
+}
+
+// Revoke removes the claim registered under token
+func (s *MemoryClaims) Revoke(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.claims, sha256.Sum256([]byte(token)))
+}
+
+// Lookup returns a copy of the claim registered under token if it can still issue an identity
+func (s *MemoryClaims) Lookup(_ context.Context, token string) (*Claim, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.claims[sha256.Sum256([]byte(token))]
+	if !ok {
+		return nil, ErrInvalidClaim
+	}
+	if err := c.check(time.Now()); err != nil {
+		return nil, err
+	}
+	claim := *c
+	return &claim, nil
+}
+
+// Redeem counts a use of the claim registered under token and returns a copy of it
+func (s *MemoryClaims) Redeem(_ context.Context, token string) (*Claim, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.claims[sha256.Sum256([]byte(token))]
+	if !ok {
+		return nil, ErrInvalidClaim
+	}
+	if err := c.check(time.Now()); err != nil {
+		return nil, err
+	}
+	c.Uses++
+	claim := *c
+	return &claim, nil
+}
@@ -0,0 +1,25 @@
+package provision
+
+import (
+	"errors"
+)
+
+var (
+	ErrInvalidTopic     = errors.New("invalid provisioning topic")
+	ErrInvalidClaim     = errors.New("invalid claim token")
+	ErrClaimExpired     = errors.New("claim token expired")
+	ErrClaimExhausted   = errors.New("claim token used up")
+	ErrClaimNotAllowed  = errors.New("claim token not valid for client id")
+	ErrClaimExists      = errors.New("claim token already exists")
+	ErrInvalidRequest   = errors.New("invalid provisioning request")
+	ErrInvalidCSR       = errors.New("invalid certificate signing request")
+	ErrCSRRequired      = errors.New("certificate signing request required")
+	ErrNotProvisioning  = errors.New("client is not provisioning")
+	ErrAuditFailed      = errors.New("provisioning audit failed")
+	ErrNoAuthority      = errors.New("provisioning authority cannot be nil")
+	ErrNoClaimStore     = errors.New("claim store cannot be nil")
+	ErrNoPublisher      = errors.New("provisioning publisher cannot be nil")
+	ErrEmptyClientID    = errors.New("provisioning client id cannot be empty")
+	ErrInvalidAuthority = errors.New("invalid certificate authority")
+	ErrProvisioned      = errors.New("client id is already provisioned")
+)
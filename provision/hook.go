@@ -0,0 +1,158 @@
+package provision
+
+import (
+	"context"
+	"sync"
+
+	"github.com/axmq/ax/hook"
+	"github.com/axmq/ax/topic"
+)
+
+// MetadataKey is the client metadata key holding the claim ID of a provisioning session
+const MetadataKey = "provision-claim"
+
+// session is a connection authenticated with a claim token
+type session struct {
+	client *hook.Client
+	token  string
+}
+
+// Hook admits devices presenting a claim token to a provisioning session and serves their requests
+// It wraps the authentication hook of regular clients, since the manager refuses a client as soon as one hook
+// does. Provisioning sessions may only publish to their RequestTopic and subscribe to their ResponseTopic, and
+// no other client may access the provisioning namespace
+type Hook struct {
+	*hook.Base
+	provisioner *Provisioner
+	auth        hook.Hook
+	normalize   topic.NormalizeOptions
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+// NewHook creates a hook serving provisioner, a nil auth admits every client not presenting a claim token
+func NewHook(provisioner *Provisioner, auth hook.Hook) *Hook {
+	return &Hook{
+		Base:        hook.NewHookBase("provision"),
+		provisioner: provisioner,
+		auth:        auth,
+		sessions:    make(map[string]*session),
+	}
+}
+
+// SetNormalize canonicalizes topics before they are checked, set it to the options of the router so a topic
+// differing only in form is treated as the topic it is routed to
+func (h *Hook) SetNormalize(opts topic.NormalizeOptions) {
+	h.normalize = opts
+}
+
+// Provides indicates this hook provides authentication, authorization, connect, publish and disconnect handling
+func (h *Hook) Provides(event hook.Event) bool {
+	switch event {
+	case hook.OnConnectAuthenticate, hook.OnACLCheck, hook.OnConnect, hook.OnPublish, hook.OnDisconnect:
+		return true
+	}
+	return false
+}
+
+// IsProvisioning reports whether client connected with a claim token
+func IsProvisioning(client *hook.Client) bool {
+	return client != nil && client.Metadata[MetadataKey] != ""
+}
+
+// OnConnectAuthenticate checks claim tokens and passes the other clients to the wrapped hook
+// Claims are refused for client IDs that already have an identity, so a claim cannot take over a device
+func (h *Hook) OnConnectAuthenticate(client *hook.Client, packet *hook.ConnectPacket) bool {
+	if packet == nil {
+		return false
+	}
+	if packet.Username != ClaimUsername {
+		return h.auth == nil || !h.auth.Provides(hook.OnConnectAuthenticate) || h.auth.OnConnectAuthenticate(client, packet)
+	}
+
+	// The client ID becomes a topic level of the provisioning topics
+	if !validTopicLevel(client.GetID()) {
+		return false
+	}
+	token := string(packet.Password)
+	claim, err := h.provisioner.Claims().Lookup(context.Background(), token)
+	if err != nil || !claim.Allows(client.ID) {
+		return false
+	}
+	if err := h.provisioner.CheckUnprovisioned(context.Background(), client.ID); err != nil {
+		return false
+	}
+
+	client.SetMetadata(MetadataKey, claim.ID)
+	h.mu.Lock()
+	h.sessions[client.ID] = &session{client: client, token: token}
+	h.mu.Unlock()
+	return true
+}
+
+// OnConnect forces a clean start for provisioning sessions, they never resume a stored session
+func (h *Hook) OnConnect(client *hook.Client, packet *hook.ConnectPacket) error {
+	if !IsProvisioning(client) {
+		return nil
+	}
+	client.CleanStart = true
+	if packet != nil {
+		packet.CleanStart = true
+	}
+	return nil
+}
+
+// OnACLCheck keeps provisioning sessions to their own topics and the other clients out of the namespace
+func (h *Hook) OnACLCheck(client *hook.Client, topicName string, access hook.AccessType) bool {
+	topicName = topic.Normalize(topicName, h.normalize)
+	if !IsProvisioning(client) {
+		return !IsProvisionTopic(topicName)
+	}
+	switch access {
+	case hook.AccessTypeWrite:
+		return topicName == RequestTopic(client.ID)
+	case hook.AccessTypeRead:
+		return topicName == ResponseTopic(client.ID)
+	}
+	return false
+}
+
+// OnPublish hands the requests of provisioning sessions to the provisioner
+func (h *Hook) OnPublish(client *hook.Client, packet *hook.PublishPacket) error {
+	if packet == nil || !IsProvisionTopic(packet.Topic) {
+		return nil
+	}
+	if !IsProvisioning(client) || packet.Topic != RequestTopic(client.ID) {
+		return ErrNotProvisioning
+	}
+
+	h.mu.Lock()
+	s, ok := h.sessions[client.ID]
+	h.mu.Unlock()
+	if !ok || s.client != client {
+		return ErrNotProvisioning
+	}
+	return h.provisioner.Handle(context.Background(), client.ID, s.token, packet.Payload)
+}
+
+// OnDisconnect forgets the claim token of a provisioning session
+func (h *Hook) OnDisconnect(client *hook.Client, _ *hook.DisconnectInfo) error {
+	if !IsProvisioning(client) {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// The connection of a taken over session must not forget the token of the new one
+	if s, ok := h.sessions[client.ID]; ok && s.client == client {
+		delete(h.sessions, client.ID)
+	}
+	return nil
+}
+
+// Sessions returns the number of connected provisioning sessions
+func (h *Hook) Sessions() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.sessions)
+}
@@ -0,0 +1,116 @@
+package provision
+
+import (
+	"testing"
+
+	"github.com/axmq/ax/auth/credentials"
+	"github.com/axmq/ax/hook"
+	"github.com/axmq/ax/topic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestHook(t *testing.T) (*Hook, *recordingPublisher, *credentials.MemoryStore) {
+	t.Helper()
+	store := credentials.NewMemoryStore()
+	claims := NewMemoryClaims()
+	require.NoError(t, claims.Add("token", &Claim{ID: "batch-1", Prefix: "dev-"}))
+	publisher := &recordingPublisher{}
+	p, err := NewProvisioner(Config{Claims: claims, Authority: NewPasswordAuthority(store), Publisher: publisher})
+	require.NoError(t, err)
+
+	auth := hook.NewBasicAuthHook()
+	auth.AddUser("alice", "secret")
+	return NewHook(p, auth), publisher, store
+}
+
+func TestHookProvisioningSession(t *testing.T) {
+	h, publisher, store := newTestHook(t)
+	assert.Equal(t, "provision", h.ID())
+	assert.True(t, h.Provides(hook.OnConnectAuthenticate))
+	assert.False(t, h.Provides(hook.OnSubscribe))
+
+	device := &hook.Client{ID: "dev-1"}
+	require.True(t, h.OnConnectAuthenticate(device, &hook.ConnectPacket{Username: ClaimUsername, Password: []byte("token")}))
+	assert.True(t, IsProvisioning(device))
+	assert.Equal(t, 1, h.Sessions())
+
+	assert.True(t, h.OnACLCheck(device, RequestTopic("dev-1"), hook.AccessTypeWrite))
+	assert.True(t, h.OnACLCheck(device, ResponseTopic("dev-1"), hook.AccessTypeRead))
+	assert.False(t, h.OnACLCheck(device, ResponseTopic("dev-1"), hook.AccessTypeWrite))
+	assert.False(t, h.OnACLCheck(device, ResponseTopic("dev-2"), hook.AccessTypeRead))
+	assert.False(t, h.OnACLCheck(device, "sensors/temp", hook.AccessTypeWrite))
+	assert.False(t, h.OnACLCheck(device, RequestTopic("dev-1")+"/", hook.AccessTypeWrite))
+	h.SetNormalize(topic.NormalizeOptions{TrimTrailingSlash: true})
+	assert.True(t, h.OnACLCheck(device, RequestTopic("dev-1")+"/", hook.AccessTypeWrite))
+	h.SetNormalize(topic.NormalizeOptions{})
+
+	assert.ErrorIs(t, h.OnPublish(device, &hook.PublishPacket{Topic: RequestTopic("dev-2")}), ErrNotProvisioning)
+	require.NoError(t, h.OnPublish(device, &hook.PublishPacket{Topic: RequestTopic("dev-1"), Payload: []byte("{}")}))
+	_, response := publisher.last(t)
+	require.NoError(t, store.Verify(t.Context(), "dev-1", []byte(response.Password)))
+
+	require.NoError(t, h.OnDisconnect(device, &hook.DisconnectInfo{}))
+	assert.Equal(t, 0, h.Sessions())
+	assert.ErrorIs(t, h.OnPublish(device, &hook.PublishPacket{Topic: RequestTopic("dev-1")}), ErrNotProvisioning)
+}
+
+func TestHookAuthenticate(t *testing.T) {
+	h, _, _ := newTestHook(t)
+	claim := func(id, token string) bool {
+		return h.OnConnectAuthenticate(&hook.Client{ID: id}, &hook.ConnectPacket{Username: ClaimUsername, Password: []byte(token)})
+	}
+	assert.False(t, claim("dev-1", "wrong"))
+	assert.False(t, claim("other", "token"))
+	assert.False(t, claim("dev-+", "token"))
+	assert.False(t, claim("", "token"))
+
+	user := &hook.Client{ID: "c1"}
+	assert.True(t, h.OnConnectAuthenticate(user, &hook.ConnectPacket{Username: "alice", Password: []byte("secret")}))
+	assert.False(t, IsProvisioning(user))
+	assert.False(t, h.OnConnectAuthenticate(&hook.Client{ID: "c2"}, &hook.ConnectPacket{Username: "alice"}))
+
+	// Regular clients stay out of the provisioning namespace
+	assert.False(t, h.OnACLCheck(user, ResponseTopic("dev-1"), hook.AccessTypeRead))
+	assert.False(t, h.OnACLCheck(user, "$provision/#", hook.AccessTypeRead))
+	assert.True(t, h.OnACLCheck(user, "sensors/temp", hook.AccessTypeWrite))
+	assert.ErrorIs(t, h.OnPublish(user, &hook.PublishPacket{Topic: RequestTopic("dev-1")}), ErrNotProvisioning)
+	require.NoError(t, h.OnPublish(user, &hook.PublishPacket{Topic: "sensors/temp"}))
+}
+
+func TestHookProvisionedClientID(t *testing.T) {
+	h, _, store := newTestHook(t)
+	packet := &hook.ConnectPacket{Username: ClaimUsername, Password: []byte("token")}
+	device := &hook.Client{ID: "dev-1"}
+	require.True(t, h.OnConnectAuthenticate(device, packet))
+	require.True(t, h.Provides(hook.OnConnect))
+	require.NoError(t, h.OnConnect(device, packet))
+	assert.True(t, device.CleanStart)
+	assert.True(t, packet.CleanStart)
+
+	user := &hook.Client{ID: "c1"}
+	require.NoError(t, h.OnConnect(user, &hook.ConnectPacket{}))
+	assert.False(t, user.CleanStart)
+
+	require.NoError(t, h.OnPublish(device, &hook.PublishPacket{Topic: RequestTopic("dev-1"), Payload: []byte("{}")}))
+	require.NoError(t, h.OnDisconnect(device, &hook.DisconnectInfo{}))
+	_, err := store.Lookup(t.Context(), "dev-1")
+	require.NoError(t, err)
+
+	// The claim no longer admits the client ID of the provisioned device
+	assert.False(t, h.OnConnectAuthenticate(&hook.Client{ID: "dev-1"}, &hook.ConnectPacket{Username: ClaimUsername, Password: []byte("token")}))
+	assert.True(t, h.OnConnectAuthenticate(&hook.Client{ID: "dev-2"}, &hook.ConnectPacket{Username: ClaimUsername, Password: []byte("token")}))
+}
+
+func TestHookTakeover(t *testing.T) {
+	h, _, _ := newTestHook(t)
+	packet := &hook.ConnectPacket{Username: ClaimUsername, Password: []byte("token")}
+	old, current := &hook.Client{ID: "dev-1"}, &hook.Client{ID: "dev-1"}
+	require.True(t, h.OnConnectAuthenticate(old, packet))
+	require.True(t, h.OnConnectAuthenticate(current, packet))
+
+	require.NoError(t, h.OnDisconnect(old, &hook.DisconnectInfo{}))
+	assert.Equal(t, 1, h.Sessions())
+	assert.ErrorIs(t, h.OnPublish(old, &hook.PublishPacket{Topic: RequestTopic("dev-1"), Payload: []byte("{}")}), ErrNotProvisioning)
+	require.NoError(t, h.OnPublish(current, &hook.PublishPacket{Topic: RequestTopic("dev-1"), Payload: []byte("{}")}))
+}
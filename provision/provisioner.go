@@ -0,0 +1,217 @@
+// Package provision bootstraps device identities over MQTT from claim tokens
+//
+// A device without credentials connects with ClaimUsername and a claim token as password. The Hook admits it
+// to a session restricted to its RequestTopic and ResponseTopic, where it publishes a Request, optionally
+// carrying a CSR, and receives the Identity issued by the Authority. Every issuance is audited before the
+// identity is published. The device then reconnects with its certificate or credentials and gets a regular,
+// re-authenticated session
+package provision
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"time"
+)
+
+// Publisher defines the interface for publishing provisioning responses
+type Publisher interface {
+	Publish(ctx context.Context, topic string, payload []byte) error
+}
+
+// AuditRecord describes an issuance attempt, successful or not
+type AuditRecord struct {
+	ClientID string
+	ClaimID  string
+	// Serial identifies the issued identity, empty when issuance failed
+	Serial    string
+	ExpiresAt time.Time
+	Time      time.Time
+	Err       error
+}
+
+// Auditor records issuance attempts
+type Auditor interface {
+	Audit(ctx context.Context, record AuditRecord) error
+}
+
+// AuditorFunc adapts a function to the Auditor interface
+type AuditorFunc func(ctx context.Context, record AuditRecord) error
+
+// Audit calls f(ctx, record)
+func (f AuditorFunc) Audit(ctx context.Context, record AuditRecord) error {
+	return f(ctx, record)
+}
+
+// Config configures a Provisioner
+type Config struct {
+	Claims    ClaimStore
+	Authority Authority
+	Publisher Publisher
+	// Auditor records every issuance attempt, an identity that could not be audited is not delivered
+	Auditor Auditor
+	// OnIssued is called once an identity was delivered, e.g. to disconnect the provisioning session so the
+	// device reconnects with its new identity
+	OnIssued func(clientID string, identity *Identity)
+}
+
+// RequestMessage is the payload a device publishes to its RequestTopic
+type RequestMessage struct {
+	// CSR is a PEM encoded certificate signing request with the client ID as common name
+	CSR      string            `json:"csr,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// ResponseMessage is the payload published to the ResponseTopic of a device
+type ResponseMessage struct {
+	Certificate   string    `json:"certificate,omitempty"`
+	CACertificate string    `json:"ca_certificate,omitempty"`
+	Username      string    `json:"username,omitempty"`
+	Password      string    `json:"password,omitempty"`
+	ExpiresAt     time.Time `json:"expires_at,omitzero"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// Provisioner issues identities to devices presenting a claim token
+type Provisioner struct {
+	config Config
+}
+
+// NewProvisioner creates a provisioner, Auditor and OnIssued are optional
+func NewProvisioner(config Config) (*Provisioner, error) {
+	switch {
+	case config.Claims == nil:
+		return nil, ErrNoClaimStore
+	case config.Authority == nil:
+		return nil, ErrNoAuthority
+	case config.Publisher == nil:
+		return nil, ErrNoPublisher
+	}
+	return &Provisioner{config: config}, nil
+}
+
+// Claims returns the claim store
+func (p *Provisioner) Claims() ClaimStore {
+	return p.config.Claims
+}
+
+// CheckUnprovisioned returns ErrProvisioned when the authority already issued an identity to clientID
+func (p *Provisioner) CheckUnprovisioned(ctx context.Context, clientID string) error {
+	provisioned, err := p.config.Authority.Provisioned(ctx, clientID)
+	if err != nil {
+		return err
+	}
+	if provisioned {
+		return ErrProvisioned
+	}
+	return nil
+}
+
+// Handle serves the request payload published by clientID with the claim token it connected with
+// The outcome is published to the ResponseTopic of the device, failures are also returned
+func (p *Provisioner) Handle(ctx context.Context, clientID, token string, payload []byte) error {
+	if clientID == "" {
+		return ErrEmptyClientID
+	}
+
+	identity, claim, err := p.issue(ctx, clientID, token, payload)
+	if claim != nil {
+		record := AuditRecord{ClientID: clientID, ClaimID: claim.ID, Time: time.Now(), Err: err}
+		if identity != nil {
+			record.Serial, record.ExpiresAt = identity.Serial, identity.ExpiresAt
+		}
+		if p.config.Auditor != nil {
+			if auditErr := p.config.Auditor.Audit(ctx, record); auditErr != nil && err == nil {
+				identity, err = nil, fmt.Errorf("%w: %v", ErrAuditFailed, auditErr)
+			}
+		}
+	}
+
+	response := ResponseMessage{}
+	if err != nil {
+		response.Error = err.Error()
+	} else {
+		response = ResponseMessage{
+			Certificate:   string(identity.Certificate),
+			CACertificate: string(identity.CACertificate),
+			Username:      identity.Username,
+			Password:      identity.Password,
+			ExpiresAt:     identity.ExpiresAt,
+		}
+	}
+	data, marshalErr := json.Marshal(response)
+	if marshalErr != nil {
+		return marshalErr
+	}
+	if pubErr := p.config.Publisher.Publish(ctx, ResponseTopic(clientID), data); pubErr != nil && err == nil {
+		err = pubErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if p.config.OnIssued != nil {
+		p.config.OnIssued(clientID, identity)
+	}
+	return nil
+}
+
+// issue checks the request and the claim, then has the authority issue the identity
+// The claim is returned once the token was redeemed, so failures after that point are audited
+func (p *Provisioner) issue(ctx context.Context, clientID, token string, payload []byte) (*Identity, *Claim, error) {
+	var msg RequestMessage
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+	req := &Request{ClientID: clientID, Metadata: msg.Metadata}
+	if msg.CSR != "" {
+		csr, err := parseCSR(msg.CSR, clientID)
+		if err != nil {
+			return nil, nil, err
+		}
+		req.CSR = csr
+	}
+
+	// Checking the claim before redeeming it keeps a mismatched client ID from using up the claim
+	claim, err := p.config.Claims.Lookup(ctx, token)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !claim.Allows(clientID) {
+		return nil, nil, ErrClaimNotAllowed
+	}
+	if err := p.CheckUnprovisioned(ctx, clientID); err != nil {
+		return nil, nil, err
+	}
+	if claim, err = p.config.Claims.Redeem(ctx, token); err != nil {
+		return nil, nil, err
+	}
+	req.Claim = claim
+
+	identity, err := p.config.Authority.Issue(ctx, req)
+	if err != nil {
+		return nil, claim, err
+	}
+	return identity, claim, nil
+}
+
+// parseCSR decodes a PEM encoded CSR and checks its signature and that it names clientID
+func parseCSR(data, clientID string) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, fmt.Errorf("%w: no PEM certificate request", ErrInvalidCSR)
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCSR, err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCSR, err)
+	}
+	if csr.Subject.CommonName != clientID {
+		return nil, fmt.Errorf("%w: common name %q does not match client id", ErrInvalidCSR, csr.Subject.CommonName)
+	}
+	return csr, nil
+}
@@ -0,0 +1,258 @@
+package provision
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/axmq/ax/auth/credentials"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type published struct {
+	topic   string
+	payload []byte
+}
+
+type recordingPublisher struct {
+	mu       sync.Mutex
+	messages []published
+}
+
+func (p *recordingPublisher) Publish(_ context.Context, topic string, payload []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.messages = append(p.messages, published{topic: topic, payload: payload})
+	return nil
+}
+
+func (p *recordingPublisher) last(t *testing.T) (string, ResponseMessage) {
+	t.Helper()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	require.NotEmpty(t, p.messages)
+	m := p.messages[len(p.messages)-1]
+	var response ResponseMessage
+	require.NoError(t, json.Unmarshal(m.payload, &response))
+	return m.topic, response
+}
+
+type recordingAuditor struct {
+	mu      sync.Mutex
+	records []AuditRecord
+	err     error
+}
+
+func (a *recordingAuditor) Audit(_ context.Context, record AuditRecord) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.records = append(a.records, record)
+	return a.err
+}
+
+func newTestCA(t *testing.T) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, key
+}
+
+func newTestCSR(t *testing.T, commonName string) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: commonName},
+	}, key)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+}
+
+func request(t *testing.T, msg RequestMessage) []byte {
+	t.Helper()
+	data, err := json.Marshal(msg)
+	require.NoError(t, err)
+	return data
+}
+
+func TestMemoryClaims(t *testing.T) {
+	ctx := context.Background()
+	claims := NewMemoryClaims()
+	require.NoError(t, claims.Add("token", &Claim{ID: "batch-1", Prefix: "dev-", MaxUses: 1}))
+	assert.ErrorIs(t, claims.Add("token", &Claim{}), ErrClaimExists)
+	assert.ErrorIs(t, claims.Add("", &Claim{}), ErrInvalidClaim)
+	require.NoError(t, claims.Add("old", &Claim{ID: "batch-0", ExpiresAt: time.Now().Add(-time.Minute)}))
+
+	claim, err := claims.Lookup(ctx, "token")
+	require.NoError(t, err)
+	assert.Equal(t, "batch-1", claim.ID)
+	assert.True(t, claim.Allows("dev-1"))
+	assert.False(t, claim.Allows("other"))
+
+	claim, err = claims.Redeem(ctx, "token")
+	require.NoError(t, err)
+	assert.Equal(t, 1, claim.Uses)
+	_, err = claims.Lookup(ctx, "token")
+	assert.ErrorIs(t, err, ErrClaimExhausted)
+	_, err = claims.Redeem(ctx, "token")
+	assert.ErrorIs(t, err, ErrClaimExhausted)
+
+	_, err = claims.Lookup(ctx, "old")
+	assert.ErrorIs(t, err, ErrClaimExpired)
+	_, err = claims.Lookup(ctx, "unknown")
+	assert.ErrorIs(t, err, ErrInvalidClaim)
+
+	claims.Revoke("old")
+	_, err = claims.Lookup(ctx, "old")
+	assert.ErrorIs(t, err, ErrInvalidClaim)
+}
+
+func TestProvisionerCertificate(t *testing.T) {
+	ctx := context.Background()
+	caCert, caKey := newTestCA(t)
+	authority, err := NewCAAuthority(caCert, caKey, time.Hour)
+	require.NoError(t, err)
+
+	claims := NewMemoryClaims()
+	require.NoError(t, claims.Add("token", &Claim{ID: "batch-1", Prefix: "dev-", MaxUses: 2}))
+	publisher := &recordingPublisher{}
+	auditor := &recordingAuditor{}
+	var issued []string
+	p, err := NewProvisioner(Config{
+		Claims:    claims,
+		Authority: authority,
+		Publisher: publisher,
+		Auditor:   auditor,
+		OnIssued:  func(clientID string, _ *Identity) { issued = append(issued, clientID) },
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, p.Handle(ctx, "dev-1", "token", request(t, RequestMessage{CSR: newTestCSR(t, "dev-1")})))
+	topic, response := publisher.last(t)
+	assert.Equal(t, ResponseTopic("dev-1"), topic)
+	assert.Empty(t, response.Error)
+	assert.Equal(t, []string{"dev-1"}, issued)
+
+	block, _ := pem.Decode([]byte(response.Certificate))
+	require.NotNil(t, block)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	assert.Equal(t, "dev-1", cert.Subject.CommonName)
+	assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, cert.ExtKeyUsage)
+	require.NoError(t, cert.CheckSignatureFrom(caCert))
+	assert.NotEmpty(t, response.CACertificate)
+
+	require.Len(t, auditor.records, 1)
+	record := auditor.records[0]
+	assert.Equal(t, "dev-1", record.ClientID)
+	assert.Equal(t, "batch-1", record.ClaimID)
+	assert.Equal(t, cert.SerialNumber.Text(16), record.Serial)
+	assert.NoError(t, record.Err)
+
+	// A provisioned device cannot be issued a second identity with the claim of its batch
+	provisioned, err := authority.Provisioned(ctx, "dev-1")
+	require.NoError(t, err)
+	assert.True(t, provisioned)
+	assert.ErrorIs(t, p.Handle(ctx, "dev-1", "token", request(t, RequestMessage{CSR: newTestCSR(t, "dev-1")})), ErrProvisioned)
+	require.Len(t, auditor.records, 1)
+
+	// A CSR naming another device is refused without using up the claim
+	err = p.Handle(ctx, "dev-2", "token", request(t, RequestMessage{CSR: newTestCSR(t, "dev-1")}))
+	assert.ErrorIs(t, err, ErrInvalidCSR)
+	_, response = publisher.last(t)
+	assert.NotEmpty(t, response.Error)
+	assert.Empty(t, response.Certificate)
+
+	assert.ErrorIs(t, p.Handle(ctx, "other", "token", request(t, RequestMessage{})), ErrClaimNotAllowed)
+	assert.ErrorIs(t, p.Handle(ctx, "dev-2", "wrong", request(t, RequestMessage{})), ErrInvalidClaim)
+	assert.ErrorIs(t, p.Handle(ctx, "dev-2", "token", []byte("{")), ErrInvalidRequest)
+
+	// The authority refusing a request without CSR still redeems and audits the claim
+	assert.ErrorIs(t, p.Handle(ctx, "dev-2", "token", request(t, RequestMessage{})), ErrCSRRequired)
+	require.Len(t, auditor.records, 2)
+	assert.ErrorIs(t, auditor.records[1].Err, ErrCSRRequired)
+	assert.ErrorIs(t, p.Handle(ctx, "dev-3", "token", request(t, RequestMessage{})), ErrClaimExhausted)
+	assert.Equal(t, []string{"dev-1"}, issued)
+}
+
+func TestProvisionerPassword(t *testing.T) {
+	ctx := context.Background()
+	store := credentials.NewMemoryStore()
+	claims := NewMemoryClaims()
+	require.NoError(t, claims.Add("token", &Claim{ID: "batch-1"}))
+	publisher := &recordingPublisher{}
+	auditor := &recordingAuditor{}
+	p, err := NewProvisioner(Config{
+		Claims:    claims,
+		Authority: NewPasswordAuthority(store),
+		Publisher: publisher,
+		Auditor:   auditor,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, p.Handle(ctx, "dev-1", "token", request(t, RequestMessage{})))
+	_, response := publisher.last(t)
+	assert.Equal(t, "dev-1", response.Username)
+	require.NotEmpty(t, response.Password)
+	require.NoError(t, store.Verify(ctx, "dev-1", []byte(response.Password)))
+	assert.ErrorIs(t, p.Handle(ctx, "dev-1", "token", request(t, RequestMessage{})), ErrProvisioned)
+	require.NoError(t, store.Verify(ctx, "dev-1", []byte(response.Password)))
+
+	// An identity that cannot be audited is not delivered
+	auditor.err = errors.New("audit log unavailable")
+	assert.ErrorIs(t, p.Handle(ctx, "dev-2", "token", request(t, RequestMessage{})), ErrAuditFailed)
+	_, response = publisher.last(t)
+	assert.Empty(t, response.Password)
+	assert.NotEmpty(t, response.Error)
+}
+
+func TestNewProvisioner(t *testing.T) {
+	_, err := NewProvisioner(Config{})
+	assert.ErrorIs(t, err, ErrNoClaimStore)
+	_, err = NewProvisioner(Config{Claims: NewMemoryClaims()})
+	assert.ErrorIs(t, err, ErrNoAuthority)
+	_, err = NewProvisioner(Config{Claims: NewMemoryClaims(), Authority: NewPasswordAuthority(credentials.NewMemoryStore())})
+	assert.ErrorIs(t, err, ErrNoPublisher)
+
+	caCert, caKey := newTestCA(t)
+	leaf := *caCert
+	leaf.IsCA = false
+	_, err = NewCAAuthority(&leaf, caKey, 0)
+	assert.ErrorIs(t, err, ErrInvalidAuthority)
+}
+
+func TestParseRequestTopic(t *testing.T) {
+	id, err := ParseRequestTopic(RequestTopic("dev-1"))
+	require.NoError(t, err)
+	assert.Equal(t, "dev-1", id)
+
+	for _, topic := range []string{"dev-1/request", ResponseTopic("dev-1"), "$provision//request", "$provision/a/b/request"} {
+		_, err := ParseRequestTopic(topic)
+		assert.ErrorIs(t, err, ErrInvalidTopic, topic)
+	}
+}
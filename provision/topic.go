@@ -0,0 +1,40 @@
+package provision
+
+import "strings"
+
+const (
+	// TopicPrefix is the reserved topic namespace of the provisioning exchange
+	TopicPrefix = "$provision/"
+)
+
+// RequestTopic returns the topic a device publishes its provisioning request to
+func RequestTopic(clientID string) string {
+	return TopicPrefix + clientID + "/request"
+}
+
+// ResponseTopic returns the topic the issued identity of a device is published to
+func ResponseTopic(clientID string) string {
+	return TopicPrefix + clientID + "/response"
+}
+
+// IsProvisionTopic checks if a topic belongs to the provisioning namespace
+func IsProvisionTopic(topic string) bool {
+	return strings.HasPrefix(topic, TopicPrefix)
+}
+
+// ParseRequestTopic extracts the client ID from a request topic
+func ParseRequestTopic(topic string) (string, error) {
+	if !IsProvisionTopic(topic) {
+		return "", ErrInvalidTopic
+	}
+	parts := strings.Split(topic[len(TopicPrefix):], "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] != "request" {
+		return "", ErrInvalidTopic
+	}
+	return parts[0], nil
+}
+
+// validTopicLevel reports whether s can be used as a single topic level
+func validTopicLevel(s string) bool {
+	return s != "" && !strings.ContainsAny(s, "/+#\x00")
+}
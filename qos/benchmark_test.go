@@ -492,3 +492,21 @@ func BenchmarkMessage_LargePayload(b *testing.B) {
 		_ = message.NewMessage(uint16(i), "test/topic", payload, encoding.QoS1, false, nil)
 	}
 }
+
+func BenchmarkHandler_HandleAcks(b *testing.B) {
+	h := NewHandler(nil)
+	defer h.Close()
+	h.SetPublishCallback(func(msg *message.Message) error { return nil })
+
+	ids := make([]uint16, 64)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		for j := range ids {
+			ids[j], _ = h.PublishQoS1("test/topic", nil, false, nil)
+		}
+		b.StartTimer()
+		_, _ = h.HandleAcks(ids, encoding.PUBACK)
+	}
+}
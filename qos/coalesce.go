@@ -0,0 +1,69 @@
+package qos
+
+import (
+	"errors"
+
+	"github.com/axmq/ax/encoding"
+)
+
+// DefaultAckBatchSize is the default number of acks coalesced before a flush
+const DefaultAckBatchSize = 64
+
+// AckCoalescer buffers consecutive PUBACK and PUBCOMP packets read from a connection
+// and hands them to the handler in batches
+// It is not safe for concurrent use and is meant to be owned by a single reader
+type AckCoalescer struct {
+	handler   *Session
+	ackType   encoding.PacketType
+	packetIDs []uint16
+	maxBatch  int
+}
+
+// NewAckCoalescer creates an ack coalescer flushing at most maxBatch acks at once
+func NewAckCoalescer(handler *Session, maxBatch int) *AckCoalescer {
+	if maxBatch <= 0 {
+		maxBatch = DefaultAckBatchSize
+	}
+	return &AckCoalescer{
+		handler:   handler,
+		packetIDs: make([]uint16, 0, maxBatch),
+		maxBatch:  maxBatch,
+	}
+}
+
+// Add buffers an ack, flushing first if the ack type changes and afterwards if the batch is full
+func (c *AckCoalescer) Add(ackType encoding.PacketType, packetID uint16) error {
+	if ackType != encoding.PUBACK && ackType != encoding.PUBCOMP {
+		return ErrInvalidAckType
+	}
+
+	var err error
+	if len(c.packetIDs) > 0 && c.ackType != ackType {
+		err = c.Flush()
+	}
+
+	c.ackType = ackType
+	c.packetIDs = append(c.packetIDs, packetID)
+
+	if len(c.packetIDs) >= c.maxBatch {
+		return errors.Join(err, c.Flush())
+	}
+	return err
+}
+
+// Flush hands all buffered acks to the handler
+// Readers should call Flush when no more packets are immediately available
+func (c *AckCoalescer) Flush() error {
+	if len(c.packetIDs) == 0 {
+		return nil
+	}
+
+	_, err := c.handler.HandleAcks(c.packetIDs, c.ackType)
+	c.packetIDs = c.packetIDs[:0]
+	return err
+}
+
+// Pending returns the number of buffered acks
+func (c *AckCoalescer) Pending() int {
+	return len(c.packetIDs)
+}
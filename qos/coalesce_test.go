@@ -0,0 +1,60 @@
+package qos
+
+import (
+	"testing"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/types/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAckCoalescer(t *testing.T) {
+	h := NewHandler(nil)
+	defer h.Close()
+
+	var acked []uint16
+	h.SetPublishCallback(func(msg *message.Message) error { return nil })
+	h.SetPubackCallback(func(packetID uint16) error {
+		acked = append(acked, packetID)
+		return nil
+	})
+
+	ids := make([]uint16, 0, 5)
+	for i := 0; i < 5; i++ {
+		packetID, err := h.PublishQoS1("test/topic", []byte("payload"), false, nil)
+		require.NoError(t, err)
+		ids = append(ids, packetID)
+	}
+	qos2, err := h.PublishQoS2("test/topic", []byte("payload"), false, nil)
+	require.NoError(t, err)
+	require.NoError(t, h.HandlePubrec(qos2))
+
+	c := NewAckCoalescer(h.Session, 3)
+
+	require.NoError(t, c.Add(encoding.PUBACK, ids[0]))
+	require.NoError(t, c.Add(encoding.PUBACK, ids[1]))
+	assert.Equal(t, 2, c.Pending())
+	assert.Empty(t, acked)
+
+	// Batch full
+	require.NoError(t, c.Add(encoding.PUBACK, ids[2]))
+	assert.Equal(t, 0, c.Pending())
+	assert.Equal(t, ids[:3], acked)
+
+	// Type change flushes the pending batch
+	require.NoError(t, c.Add(encoding.PUBACK, ids[3]))
+	require.NoError(t, c.Add(encoding.PUBCOMP, qos2))
+	assert.Equal(t, ids[:4], acked)
+	assert.Equal(t, 1, c.Pending())
+
+	require.NoError(t, c.Add(encoding.PUBACK, ids[4]))
+	require.NoError(t, c.Flush())
+	assert.Equal(t, ids, acked)
+	assert.Equal(t, 0, h.GetInflightCount())
+
+	assert.NoError(t, c.Flush())
+	assert.ErrorIs(t, c.Add(encoding.PUBREL, 1), ErrInvalidAckType)
+	assert.NoError(t, c.Add(encoding.PUBACK, 999))
+	assert.ErrorIs(t, c.Flush(), ErrPacketIDNotFound)
+}
@@ -1,11 +1,15 @@
 package qos
 
-import "errors"
+import (
+	axerrors "github.com/axmq/ax/pkg/errors"
+)
 
 var (
-	ErrInvalidQoS       = errors.New("invalid QoS level")
-	ErrPacketIDNotFound = errors.New("packet ID not found")
-	ErrMessageExpired   = errors.New("message has expired")
-	ErrQueueFull        = errors.New("message queue is full")
-	ErrHandlerClosed    = errors.New("handler is closed")
+	ErrInvalidQoS       = axerrors.New(axerrors.KindProtocol, "invalid QoS level")
+	ErrPacketIDNotFound = axerrors.New(axerrors.KindProtocol, "packet ID not found")
+	ErrMessageExpired   = axerrors.New(axerrors.KindInternal, "message has expired")
+	ErrQueueFull        = axerrors.New(axerrors.KindQuota, "message queue is full")
+	ErrHandlerClosed    = axerrors.New(axerrors.KindInternal, "handler is closed")
+	ErrInvalidAckType   = axerrors.New(axerrors.KindProtocol, "invalid acknowledgment packet type")
+	ErrUnexpectedAck    = axerrors.New(axerrors.KindProtocol, "acknowledgment does not match QoS flow state")
 )
@@ -1,11 +1,14 @@
 package qos
 
 import (
+	"context"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/session"
 	"github.com/axmq/ax/types/message"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -567,6 +570,7 @@ func TestHandler_PublishExpiredMessage(t *testing.T) {
 
 	time.Sleep(100 * time.Millisecond)
 	h.cleanup()
+	h.batches.Wait()
 
 	assert.Equal(t, 0, h.GetPendingQoS1Count())
 }
@@ -680,6 +684,33 @@ func TestHandler_MaxRetryCallback(t *testing.T) {
 	assert.True(t, called)
 }
 
+func TestHandler_Stats(t *testing.T) {
+	config := DefaultConfig()
+	config.RetryInterval = 50 * time.Millisecond
+	config.MaxRetries = 2
+	h := NewHandler(config)
+	defer h.Close()
+
+	var stats session.Stats
+	h.SetStats(&stats)
+	h.SetPublishCallback(func(msg *message.Message) error { return nil })
+
+	require.NoError(t, h.HandlePublish(message.NewMessage(1, "in", []byte("abc"), encoding.QoS1, false, nil)))
+	_, err := h.PublishQoS1("out", []byte("payload"), false, nil)
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return stats.Snapshot().Dropped == 1
+	}, time.Second, 10*time.Millisecond)
+
+	snap := stats.Snapshot()
+	assert.Equal(t, uint64(1), snap.MessagesReceived[1])
+	assert.Equal(t, uint64(3), snap.BytesReceived)
+	assert.Equal(t, uint64(1), snap.MessagesSent[1])
+	assert.Equal(t, uint64(7), snap.BytesSent)
+	assert.Equal(t, uint64(1), snap.Retries)
+}
+
 func TestHandler_ExpiredCallback(t *testing.T) {
 	config := DefaultConfig()
 	config.CleanupInterval = 50 * time.Millisecond
@@ -821,3 +852,602 @@ func TestHandler_ExponentialBackoff(t *testing.T) {
 		assert.LessOrEqual(t, interval, tt.wantMax)
 	}
 }
+
+func TestHandler_AdaptiveRetryInterval(t *testing.T) {
+	config := DefaultConfig()
+	config.RetryInterval = 5 * time.Second
+	config.RetryBackoff = 2.0
+	config.MaxRetryInterval = 10 * time.Second
+	config.AdaptiveRetry = true
+	config.RTTMultiplier = 3.0
+	config.MinRetryInterval = 500 * time.Millisecond
+
+	tests := []struct {
+		name       string
+		ackLatency time.Duration
+		attempt    int
+		want       time.Duration
+	}{
+		{name: "no sample", ackLatency: 0, attempt: 1, want: 5 * time.Second},
+		{name: "first retry", ackLatency: time.Second, attempt: 1, want: 3 * time.Second},
+		{name: "backoff", ackLatency: time.Second, attempt: 2, want: 6 * time.Second},
+		{name: "capped at max", ackLatency: time.Second, attempt: 3, want: 10 * time.Second},
+		{name: "clamped to min", ackLatency: time.Millisecond, attempt: 1, want: 500 * time.Millisecond},
+		{name: "clamped to max", ackLatency: time.Minute, attempt: 1, want: 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler(config)
+			defer h.Close()
+
+			h.ackLatency = tt.ackLatency
+			assert.Equal(t, tt.want, h.calculateRetryInterval(tt.attempt))
+		})
+	}
+}
+
+func TestHandler_AckLatencyEWMA(t *testing.T) {
+	config := DefaultConfig()
+	config.AdaptiveRetry = true
+	config.EWMAAlpha = 0.5
+	h := NewHandler(config)
+	defer h.Close()
+
+	h.SetPublishCallback(func(msg *message.Message) error { return nil })
+
+	assert.Equal(t, time.Duration(0), h.AckLatency())
+
+	packetID, err := h.PublishQoS1("test/topic", []byte("payload"), false, nil)
+	require.NoError(t, err)
+	h.mu.Lock()
+	h.qos1Messages[packetID].LastAttemptAt = time.Now().Add(-100 * time.Millisecond)
+	h.mu.Unlock()
+	require.NoError(t, h.HandlePuback(packetID))
+
+	first := h.AckLatency()
+	assert.GreaterOrEqual(t, first, 100*time.Millisecond)
+
+	packetID, err = h.PublishQoS2("test/topic", []byte("payload"), false, nil)
+	require.NoError(t, err)
+	h.mu.Lock()
+	h.qos2Messages[packetID].LastAttemptAt = time.Now().Add(-300 * time.Millisecond)
+	h.mu.Unlock()
+	require.NoError(t, h.HandlePubrec(packetID))
+
+	second := h.AckLatency()
+	assert.GreaterOrEqual(t, second, 200*time.Millisecond)
+	assert.Less(t, second, 300*time.Millisecond)
+
+	// Acks for retransmitted messages are not sampled
+	packetID, err = h.PublishQoS1("test/topic", []byte("payload"), false, nil)
+	require.NoError(t, err)
+	h.mu.Lock()
+	h.qos1Messages[packetID].MarkAttempt()
+	h.qos1Messages[packetID].LastAttemptAt = time.Now().Add(-time.Second)
+	h.mu.Unlock()
+	require.NoError(t, h.HandlePuback(packetID))
+	assert.Equal(t, second, h.AckLatency())
+}
+
+func TestHandler_AckLatencyDisabled(t *testing.T) {
+	h := NewHandler(nil)
+	defer h.Close()
+
+	packetID, err := h.PublishQoS1("test/topic", []byte("payload"), false, nil)
+	require.NoError(t, err)
+	require.NoError(t, h.HandlePuback(packetID))
+	assert.Equal(t, time.Duration(0), h.AckLatency())
+}
+
+func TestHandler_HandleAcks(t *testing.T) {
+	h := NewHandler(nil)
+	defer h.Close()
+
+	var mu sync.Mutex
+	var pubacks, pubcomps []uint16
+	h.SetPublishCallback(func(msg *message.Message) error { return nil })
+	h.SetPubackCallback(func(packetID uint16) error {
+		mu.Lock()
+		pubacks = append(pubacks, packetID)
+		mu.Unlock()
+		return nil
+	})
+	h.SetPubcompCallback(func(packetID uint16) error {
+		mu.Lock()
+		pubcomps = append(pubcomps, packetID)
+		mu.Unlock()
+		return nil
+	})
+
+	qos1 := make([]uint16, 0, 3)
+	for i := 0; i < 3; i++ {
+		packetID, err := h.PublishQoS1("test/topic", []byte("payload"), false, nil)
+		require.NoError(t, err)
+		qos1 = append(qos1, packetID)
+	}
+	qos2, err := h.PublishQoS2("test/topic", []byte("payload"), false, nil)
+	require.NoError(t, err)
+	require.NoError(t, h.HandlePubrec(qos2))
+	assert.Equal(t, 4, h.GetInflightCount())
+
+	n, err := h.HandleAcks(append(qos1, 999), encoding.PUBACK)
+	assert.ErrorIs(t, err, ErrPacketIDNotFound)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, qos1, pubacks)
+	assert.Equal(t, 1, h.GetInflightCount())
+
+	n, err = h.HandleAcks([]uint16{qos2}, encoding.PUBCOMP)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []uint16{qos2}, pubcomps)
+	assert.Equal(t, 0, h.GetInflightCount())
+
+	// A PUBCOMP for a QoS 1 message is a protocol error and leaves the message in flight
+	packetID, err := h.PublishQoS1("test/topic", []byte("payload"), false, nil)
+	require.NoError(t, err)
+	n, err = h.HandleAcks([]uint16{packetID}, encoding.PUBCOMP)
+	assert.ErrorIs(t, err, ErrUnexpectedAck)
+	assert.Zero(t, n)
+	assert.Equal(t, 1, h.GetInflightCount())
+	n, err = h.HandleAcks([]uint16{packetID}, encoding.PUBACK)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	_, err = h.HandleAcks([]uint16{1}, encoding.PUBREC)
+	assert.ErrorIs(t, err, ErrInvalidAckType)
+
+	require.NoError(t, h.Close())
+	_, err = h.HandleAcks([]uint16{1}, encoding.PUBACK)
+	assert.ErrorIs(t, err, ErrHandlerClosed)
+}
+
+func TestHandler_QoS2StateMachine(t *testing.T) {
+	type sent struct {
+		packet encoding.PacketType
+		id     uint16
+		reason encoding.ReasonCode
+	}
+
+	tests := []struct {
+		name      string
+		setup     func(t *testing.T, h *Handler) uint16
+		input     encoding.PacketType
+		wantErr   error
+		wantCode  encoding.ReasonCode
+		wantSent  []sent
+		wantState string
+	}{
+		{
+			name:      "outbound PUBREC moves to awaiting PUBCOMP",
+			setup:     publishQoS2,
+			input:     encoding.PUBREC,
+			wantSent:  []sent{{packet: encoding.PUBREC}, {packet: encoding.PUBREL}},
+			wantState: "pubrel",
+		},
+		{
+			name: "outbound duplicate PUBREC resends PUBREL",
+			setup: func(t *testing.T, h *Handler) uint16 {
+				id := publishQoS2(t, h)
+				require.NoError(t, h.HandlePubrec(id))
+				return id
+			},
+			input:     encoding.PUBREC,
+			wantSent:  []sent{{packet: encoding.PUBREL}},
+			wantState: "pubrel",
+		},
+		{
+			name: "outbound PUBCOMP completes flow",
+			setup: func(t *testing.T, h *Handler) uint16 {
+				id := publishQoS2(t, h)
+				require.NoError(t, h.HandlePubrec(id))
+				return id
+			},
+			input:     encoding.PUBCOMP,
+			wantState: "none",
+		},
+		{
+			name:      "outbound PUBCOMP before PUBREC is a protocol error",
+			setup:     publishQoS2,
+			input:     encoding.PUBCOMP,
+			wantErr:   ErrUnexpectedAck,
+			wantCode:  encoding.ReasonProtocolError,
+			wantState: "publish",
+		},
+		{
+			name:      "outbound PUBACK for QoS 2 message is a protocol error",
+			setup:     publishQoS2,
+			input:     encoding.PUBACK,
+			wantErr:   ErrUnexpectedAck,
+			wantCode:  encoding.ReasonProtocolError,
+			wantState: "publish",
+		},
+		{
+			name: "outbound PUBACK while awaiting PUBCOMP is a protocol error",
+			setup: func(t *testing.T, h *Handler) uint16 {
+				id := publishQoS2(t, h)
+				require.NoError(t, h.HandlePubrec(id))
+				return id
+			},
+			input:     encoding.PUBACK,
+			wantErr:   ErrUnexpectedAck,
+			wantCode:  encoding.ReasonProtocolError,
+			wantState: "pubrel",
+		},
+		{
+			name: "outbound PUBREC for QoS 1 message is a protocol error",
+			setup: func(t *testing.T, h *Handler) uint16 {
+				id, err := h.PublishQoS1("test/topic", []byte("payload"), false, nil)
+				require.NoError(t, err)
+				return id
+			},
+			input:     encoding.PUBREC,
+			wantErr:   ErrUnexpectedAck,
+			wantCode:  encoding.ReasonProtocolError,
+			wantState: "qos1",
+		},
+		{
+			name:      "outbound PUBREC for unknown ID",
+			setup:     func(t *testing.T, h *Handler) uint16 { return 42 },
+			input:     encoding.PUBREC,
+			wantErr:   ErrPacketIDNotFound,
+			wantCode:  encoding.ReasonUnspecifiedError,
+			wantState: "none",
+		},
+		{
+			name:      "outbound PUBCOMP for unknown ID",
+			setup:     func(t *testing.T, h *Handler) uint16 { return 42 },
+			input:     encoding.PUBCOMP,
+			wantErr:   ErrPacketIDNotFound,
+			wantCode:  encoding.ReasonUnspecifiedError,
+			wantState: "none",
+		},
+		{
+			name:      "inbound PUBLISH sends PUBREC",
+			setup:     func(t *testing.T, h *Handler) uint16 { return 7 },
+			input:     encoding.PUBLISH,
+			wantSent:  []sent{{packet: encoding.PUBREC, id: 7}},
+			wantState: "received",
+		},
+		{
+			name:      "inbound duplicate PUBLISH resends PUBREC",
+			setup:     receiveQoS2,
+			input:     encoding.PUBLISH,
+			wantSent:  []sent{{packet: encoding.PUBREC, id: 7}},
+			wantState: "received",
+		},
+		{
+			name:      "inbound PUBREL sends PUBCOMP",
+			setup:     receiveQoS2,
+			input:     encoding.PUBREL,
+			wantSent:  []sent{{packet: encoding.PUBREL, id: 7}, {packet: encoding.PUBCOMP, id: 7}},
+			wantState: "none",
+		},
+		{
+			name:      "inbound PUBREL for unknown ID sends PUBCOMP with not found",
+			setup:     func(t *testing.T, h *Handler) uint16 { return 7 },
+			input:     encoding.PUBREL,
+			wantSent:  []sent{{packet: encoding.PUBCOMP, id: 7, reason: encoding.ReasonPacketIdentifierNotFound}},
+			wantState: "none",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler(nil)
+			defer h.Close()
+			h.SetPublishCallback(func(msg *message.Message) error { return nil })
+
+			id := tt.setup(t, h)
+
+			var got []sent
+			record := func(packet encoding.PacketType) func(uint16) error {
+				return func(packetID uint16) error {
+					got = append(got, sent{packet: packet, id: packetID})
+					return nil
+				}
+			}
+			h.SetPubackCallback(record(encoding.PUBACK))
+			h.SetPubrecCallback(record(encoding.PUBREC))
+			h.SetPubrelCallback(record(encoding.PUBREL))
+			h.SetPubcompReasonCallback(func(packetID uint16, reasonCode encoding.ReasonCode) error {
+				got = append(got, sent{packet: encoding.PUBCOMP, id: packetID, reason: reasonCode})
+				return nil
+			})
+
+			var err error
+			switch tt.input {
+			case encoding.PUBLISH:
+				msg := message.NewMessage(id, "test/topic", []byte("payload"), encoding.QoS2, false, nil)
+				err = h.HandlePublish(msg)
+			case encoding.PUBACK:
+				err = h.HandlePuback(id)
+			case encoding.PUBREC:
+				err = h.HandlePubrec(id)
+			case encoding.PUBREL:
+				err = h.HandlePubrel(id)
+			case encoding.PUBCOMP:
+				err = h.HandlePubcomp(id)
+			}
+
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				assert.Equal(t, tt.wantCode, encoding.GetReasonCode(err))
+			} else {
+				require.NoError(t, err)
+			}
+
+			for i := range tt.wantSent {
+				if tt.wantSent[i].id == 0 {
+					tt.wantSent[i].id = id
+				}
+			}
+			assert.Equal(t, tt.wantSent, got)
+			assert.Equal(t, tt.wantState, qos2State(h, id))
+		})
+	}
+}
+
+func publishQoS2(t *testing.T, h *Handler) uint16 {
+	id, err := h.PublishQoS2("test/topic", []byte("payload"), false, nil)
+	require.NoError(t, err)
+	return id
+}
+
+func receiveQoS2(t *testing.T, h *Handler) uint16 {
+	msg := message.NewMessage(7, "test/topic", []byte("payload"), encoding.QoS2, false, nil)
+	require.NoError(t, h.HandlePublish(msg))
+	return 7
+}
+
+func qos2State(h *Handler, id uint16) string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if _, ok := h.qos1Messages[id]; ok {
+		return "qos1"
+	}
+	if _, ok := h.qos2Messages[id]; ok {
+		return "publish"
+	}
+	if _, ok := h.qos2Pubrel[id]; ok {
+		return "pubrel"
+	}
+	if _, ok := h.qos2Received[id]; ok {
+		return "received"
+	}
+	return "none"
+}
+
+func TestHandler_InflightTTL(t *testing.T) {
+	config := DefaultConfig()
+	config.InflightTTL = time.Minute
+	h := NewHandler(config)
+	defer h.Close()
+
+	var dropped []uint16
+	h.SetPublishCallback(func(msg *message.Message) error { return nil })
+	h.SetInflightExpiredCallback(func(packetID uint16) {
+		dropped = append(dropped, packetID)
+	})
+
+	old1, err := h.PublishQoS1("test/topic", []byte("payload"), false, nil)
+	require.NoError(t, err)
+	old2, err := h.PublishQoS2("test/topic", []byte("payload"), false, nil)
+	require.NoError(t, err)
+	oldPubrel, err := h.PublishQoS2("test/topic", []byte("payload"), false, nil)
+	require.NoError(t, err)
+	fresh, err := h.PublishQoS1("test/topic", []byte("payload"), false, nil)
+	require.NoError(t, err)
+
+	h.mu.Lock()
+	h.qos1Messages[old1].CreatedAt = time.Now().Add(-2 * time.Minute)
+	h.qos2Messages[old2].CreatedAt = time.Now().Add(-2 * time.Minute)
+	h.qos2Messages[oldPubrel].CreatedAt = time.Now().Add(-2 * time.Minute)
+	h.mu.Unlock()
+	require.NoError(t, h.HandlePubrec(oldPubrel))
+
+	h.cleanup()
+	h.batches.Wait()
+
+	assert.ElementsMatch(t, []uint16{old1, old2, oldPubrel}, dropped)
+	assert.Equal(t, 1, h.GetInflightCount())
+	assert.Equal(t, "qos1", qos2State(h, fresh))
+	assert.ErrorIs(t, h.HandlePuback(old1), ErrPacketIDNotFound)
+}
+
+func TestHandler_InflightExpiredCallbackReentrant(t *testing.T) {
+	config := DefaultConfig()
+	config.InflightTTL = time.Minute
+	h := NewHandler(config)
+	defer h.Close()
+
+	var inflight []int
+	h.SetPublishCallback(func(msg *message.Message) error { return nil })
+	h.SetInflightExpiredCallback(func(uint16) {
+		// Runs outside the session lock, so calling back into the session must not deadlock
+		inflight = append(inflight, h.GetInflightCount())
+	})
+
+	packetID, err := h.PublishQoS1("test/topic", []byte("payload"), false, nil)
+	require.NoError(t, err)
+	h.mu.Lock()
+	h.qos1Messages[packetID].CreatedAt = time.Now().Add(-2 * time.Minute)
+	h.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		h.retryMessages()
+		h.batches.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("inflight expired callback deadlocked")
+	}
+	assert.Equal(t, []int{0}, inflight)
+}
+
+func TestHandler_InflightTTLDisabled(t *testing.T) {
+	h := NewHandler(nil)
+	defer h.Close()
+
+	var dropped int
+	h.SetPublishCallback(func(msg *message.Message) error { return nil })
+	h.SetInflightExpiredCallback(func(uint16) { dropped++ })
+
+	packetID, err := h.PublishQoS1("test/topic", []byte("payload"), false, nil)
+	require.NoError(t, err)
+	h.mu.Lock()
+	h.qos1Messages[packetID].CreatedAt = time.Now().Add(-24 * time.Hour)
+	h.mu.Unlock()
+
+	h.cleanup()
+	h.batches.Wait()
+
+	assert.Equal(t, 0, dropped)
+	assert.Equal(t, 1, h.GetInflightCount())
+}
+
+func TestHandler_HandlePublishContext(t *testing.T) {
+	type ctxKey struct{}
+
+	t.Run("context reaches the callback", func(t *testing.T) {
+		h := NewHandler(DefaultConfig())
+		defer h.Close()
+
+		var got any
+		h.SetPublishCallback(func(msg *message.Message) error {
+			t.Fatal("plain callback must not be used when a context callback is set")
+			return nil
+		})
+		h.SetPublishContextCallback(func(ctx context.Context, msg *message.Message) error {
+			got = ctx.Value(ctxKey{})
+			return nil
+		})
+
+		ctx := context.WithValue(context.Background(), ctxKey{}, "trace")
+		msg := message.NewMessage(1, "test/topic", []byte("payload"), encoding.QoS1, false, nil)
+		require.NoError(t, h.HandlePublishContext(ctx, msg))
+		assert.Equal(t, "trace", got)
+	})
+
+	t.Run("plain callback still used", func(t *testing.T) {
+		h := NewHandler(DefaultConfig())
+		defer h.Close()
+
+		called := false
+		h.SetPublishCallback(func(msg *message.Message) error {
+			called = true
+			return nil
+		})
+
+		msg := message.NewMessage(0, "test/topic", []byte("payload"), encoding.QoS0, false, nil)
+		require.NoError(t, h.HandlePublishContext(context.Background(), msg))
+		assert.True(t, called)
+	})
+
+	t.Run("cancelled context", func(t *testing.T) {
+		h := NewHandler(DefaultConfig())
+		defer h.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		msg := message.NewMessage(0, "test/topic", []byte("payload"), encoding.QoS0, false, nil)
+		assert.ErrorIs(t, h.HandlePublishContext(ctx, msg), context.Canceled)
+	})
+
+	t.Run("message expiry bounds the context", func(t *testing.T) {
+		h := NewHandler(DefaultConfig())
+		defer h.Close()
+
+		var deadline time.Time
+		var cause error
+		h.SetPublishContextCallback(func(ctx context.Context, msg *message.Message) error {
+			deadline, _ = ctx.Deadline()
+			cause = context.Cause(ctx)
+			return nil
+		})
+
+		msg := message.NewMessage(0, "test/topic", []byte("payload"), encoding.QoS0, false,
+			map[string]interface{}{"MessageExpiryInterval": uint32(30)})
+		require.NoError(t, h.HandlePublishContext(context.Background(), msg))
+
+		want, ok := msg.Deadline()
+		require.True(t, ok)
+		assert.Equal(t, want, deadline)
+		assert.NoError(t, cause)
+	})
+
+	t.Run("close cancels delivery", func(t *testing.T) {
+		h := NewHandler(DefaultConfig())
+
+		started := make(chan struct{})
+		h.SetPublishContextCallback(func(ctx context.Context, msg *message.Message) error {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		})
+
+		errCh := make(chan error, 1)
+		go func() {
+			msg := message.NewMessage(0, "test/topic", []byte("payload"), encoding.QoS0, false, nil)
+			errCh <- h.HandlePublishContext(context.Background(), msg)
+		}()
+		<-started
+
+		require.NoError(t, h.Close())
+		select {
+		case err := <-errCh:
+			assert.ErrorIs(t, err, context.Canceled)
+		case <-time.After(time.Second):
+			t.Fatal("delivery was not cancelled by Close")
+		}
+	})
+}
+
+func TestHandler_RetryOffScheduler(t *testing.T) {
+	config := DefaultConfig()
+	config.RetryInterval = time.Millisecond
+	config.MaxRetries = 10
+	h := NewHandler(config)
+	defer h.Close()
+
+	release := make(chan struct{})
+	var sent atomic.Int32
+	h.SetPublishCallback(func(msg *message.Message) error {
+		if msg.AttemptCount > 1 {
+			sent.Add(1)
+			<-release
+		}
+		return nil
+	})
+
+	packetID, err := h.PublishQoS1("test/topic", []byte("payload"), false, nil)
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+
+	// A peer stuck on the retransmission neither holds the scheduler goroutine nor the session lock
+	done := make(chan struct{})
+	go func() {
+		h.retryMessages()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("retry blocked on the publish callback")
+	}
+	require.Eventually(t, func() bool { return sent.Load() == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, 1, h.GetInflightCount())
+
+	// Ticks are skipped until the stuck retransmission returns
+	time.Sleep(5 * time.Millisecond)
+	h.retryMessages()
+	assert.Equal(t, int32(1), sent.Load())
+
+	close(release)
+	h.batches.Wait()
+	require.NoError(t, h.HandlePuback(packetID))
+}
@@ -0,0 +1,134 @@
+package qos
+
+import (
+	"slices"
+	"time"
+
+	"github.com/axmq/ax/types/message"
+)
+
+// resendEntry is an unacknowledged outbound flow to replay on resume
+type resendEntry struct {
+	packetID uint16
+	sentAt   time.Time
+	msg      *message.Message // nil for flows waiting on PUBCOMP, which resend PUBREL
+}
+
+// resumeBatch holds the flows collected by resume and the callbacks to send them with once the lock is released
+type resumeBatch struct {
+	entries   []resendEntry
+	dropped   []*message.Message // messages whose expiry passed while detached
+	expired   []uint16           // packet IDs of the flows past the inflight TTL
+	onPublish func(*message.Message) error
+	onPubrel  func(uint16) error
+	onExpired func(*message.Message)
+}
+
+// Detach marks the client connection as gone, retries are held back until Resume
+// Inflight state is kept so a persistent session can pick its QoS flows up again
+func (s *Session) Detach() {
+	s.mu.Lock()
+	s.detached = true
+	s.mu.Unlock()
+}
+
+// IsDetached reports whether the session is waiting for the client to reconnect
+func (s *Session) IsDetached() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.detached
+}
+
+// Resume rebinds a resumed session to a new connection and retransmits
+// unacknowledged QoS 1 and QoS 2 PUBLISH packets with DUP set and pending PUBREL packets,
+// in the order they were originally sent
+// New publishes wait until the retransmission is done, so resent packets always go out first
+// It returns the number of packets resent, on a callback error the rest stay inflight for the retry loop
+func (s *Session) Resume() (int, error) {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	batch, err := s.resume()
+	if err != nil {
+		return 0, err
+	}
+	if batch.onExpired != nil {
+		for _, msg := range batch.dropped {
+			batch.onExpired(msg)
+		}
+	}
+	s.notifyInflightExpired(batch.expired)
+
+	// The flows are sent without the lock, so the callbacks may call back into the session
+	for i, e := range batch.entries {
+		if err := batch.send(e); err != nil {
+			return i, err
+		}
+	}
+	return len(batch.entries), nil
+}
+
+// resume collects the flows to retransmit in order under the lock, marking the PUBLISH packets as duplicates
+func (s *Session) resume() (*resumeBatch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrHandlerClosed
+	}
+	s.detached = false
+
+	dropped := s.cleanupExpiredMessages(s.qos1Messages, nil)
+	dropped = s.cleanupExpiredMessages(s.qos2Messages, dropped)
+	expired := s.expireInflight(time.Now())
+
+	entries := make([]resendEntry, 0, s.inflightCount)
+	for _, messages := range []map[uint16]*message.Message{s.qos1Messages, s.qos2Messages} {
+		for packetID, msg := range messages {
+			entries = append(entries, resendEntry{packetID: packetID, sentAt: msg.CreatedAt, msg: msg})
+		}
+	}
+	for packetID, sentAt := range s.qos2Pubrel {
+		entries = append(entries, resendEntry{packetID: packetID, sentAt: sentAt})
+	}
+
+	// Packet IDs are handed out in sequence, so the distance from the next ID
+	// orders flows created within the same clock tick
+	next := s.nextPacketID
+	slices.SortFunc(entries, func(a, b resendEntry) int {
+		if c := a.sentAt.Compare(b.sentAt); c != 0 {
+			return c
+		}
+		return int(a.packetID-next) - int(b.packetID-next)
+	})
+
+	for _, e := range entries {
+		if e.msg != nil {
+			e.msg.MarkAttempt()
+			e.msg.DUP = true
+			s.stats.RecordRetry()
+		}
+	}
+	return &resumeBatch{
+		entries:   entries,
+		dropped:   dropped,
+		expired:   expired,
+		onPublish: s.callbacks.onPublish,
+		onPubrel:  s.callbacks.onPubrel,
+		onExpired: s.callbacks.onExpired,
+	}, nil
+}
+
+// send retransmits a single flow
+func (b *resumeBatch) send(e resendEntry) error {
+	if e.msg == nil {
+		if b.onPubrel != nil {
+			return b.onPubrel(e.packetID)
+		}
+		return nil
+	}
+	if b.onPublish != nil {
+		return b.onPublish(e.msg)
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package qos
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/axmq/ax/types/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// resent records packets retransmitted by Resume, prefixed with their type
+type resent struct {
+	packets []string
+	dup     []bool
+}
+
+func newResumeHandler(t *testing.T) (*Handler, *resent) {
+	t.Helper()
+
+	config := DefaultConfig()
+	config.RetryInterval = time.Hour
+	h := NewHandler(config)
+	t.Cleanup(func() { _ = h.Close() })
+
+	r := &resent{}
+	h.SetPublishCallback(func(msg *message.Message) error {
+		r.packets = append(r.packets, "publish:"+msg.Topic)
+		r.dup = append(r.dup, msg.DUP)
+		return nil
+	})
+	return h, r
+}
+
+func TestHandler_Resume(t *testing.T) {
+	h, r := newResumeHandler(t)
+
+	id1, err := h.PublishQoS1("a", []byte("1"), false, nil)
+	require.NoError(t, err)
+	id2, err := h.PublishQoS2("b", []byte("2"), false, nil)
+	require.NoError(t, err)
+	_, err = h.PublishQoS1("c", []byte("3"), false, nil)
+	require.NoError(t, err)
+	require.NoError(t, h.HandlePuback(id1))
+
+	// b moves on to waiting for PUBCOMP
+	h.SetPubrelCallback(func(packetID uint16) error {
+		r.packets = append(r.packets, "pubrel")
+		return nil
+	})
+	require.NoError(t, h.HandlePubrec(id2))
+
+	h.Detach()
+	assert.True(t, h.IsDetached())
+	r.packets, r.dup = nil, nil
+
+	n, err := h.Resume()
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.False(t, h.IsDetached())
+	assert.Equal(t, []string{"pubrel", "publish:c"}, r.packets)
+	assert.Equal(t, []bool{true}, r.dup)
+	assert.Equal(t, 2, h.GetInflightCount())
+}
+
+func TestHandler_ResumeOrder(t *testing.T) {
+	h, r := newResumeHandler(t)
+
+	// Start just before the rollover so the later flows get lower packet IDs
+	h.mu.Lock()
+	h.nextPacketID = 65534
+	h.mu.Unlock()
+
+	topics := []string{"t1", "t2", "t3", "t4", "t5"}
+	for i, topic := range topics {
+		var err error
+		if i%2 == 0 {
+			_, err = h.PublishQoS1(topic, nil, false, nil)
+		} else {
+			_, err = h.PublishQoS2(topic, nil, false, nil)
+		}
+		require.NoError(t, err)
+	}
+
+	// Flows created within the same clock tick fall back to allocation order
+	h.mu.Lock()
+	at := time.Now()
+	for _, msg := range h.qos1Messages {
+		msg.CreatedAt = at
+	}
+	for _, msg := range h.qos2Messages {
+		msg.CreatedAt = at
+	}
+	h.mu.Unlock()
+
+	r.packets = nil
+	n, err := h.Resume()
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, []string{"publish:t1", "publish:t2", "publish:t3", "publish:t4", "publish:t5"}, r.packets)
+}
+
+func TestHandler_ResumeDropsExpired(t *testing.T) {
+	h, r := newResumeHandler(t)
+
+	var expired []string
+	h.SetExpiredCallback(func(msg *message.Message) {
+		expired = append(expired, msg.Topic)
+	})
+
+	_, err := h.PublishQoS1("old", nil, false, map[string]interface{}{"MessageExpiryInterval": uint32(1)})
+	require.NoError(t, err)
+	_, err = h.PublishQoS1("new", nil, false, nil)
+	require.NoError(t, err)
+
+	h.mu.Lock()
+	for _, msg := range h.qos1Messages {
+		if msg.Topic == "old" {
+			msg.CreatedAt = msg.CreatedAt.Add(-2 * time.Second)
+		}
+	}
+	h.mu.Unlock()
+
+	r.packets = nil
+	n, err := h.Resume()
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"publish:new"}, r.packets)
+	assert.Equal(t, []string{"old"}, expired)
+}
+
+func TestHandler_DetachHoldsRetries(t *testing.T) {
+	config := DefaultConfig()
+	config.RetryInterval = 10 * time.Millisecond
+	h := NewHandler(config)
+	defer h.Close()
+
+	_, err := h.PublishQoS1("a", nil, false, nil)
+	require.NoError(t, err)
+	h.Detach()
+
+	time.Sleep(50 * time.Millisecond)
+	h.mu.RLock()
+	for _, msg := range h.qos1Messages {
+		assert.Equal(t, 1, msg.AttemptCount)
+	}
+	h.mu.RUnlock()
+}
+
+func TestHandler_ResumeClosed(t *testing.T) {
+	h := NewHandler(nil)
+	require.NoError(t, h.Close())
+
+	_, err := h.Resume()
+	assert.ErrorIs(t, err, ErrHandlerClosed)
+}
+
+func TestHandler_ResumeBeforeNewPublishes(t *testing.T) {
+	h, _ := newResumeHandler(t)
+	for _, topic := range []string{"a", "b", "c"} {
+		_, err := h.PublishQoS1(topic, nil, false, nil)
+		require.NoError(t, err)
+	}
+	h.Detach()
+
+	var (
+		mu      sync.Mutex
+		packets []string
+		started sync.Once
+		done    = make(chan error, 1)
+	)
+	h.SetPublishCallback(func(msg *message.Message) error {
+		mu.Lock()
+		packets = append(packets, msg.Topic)
+		mu.Unlock()
+		// A publish racing the retransmission must not go out between the resent packets
+		started.Do(func() {
+			go func() {
+				_, err := h.PublishQoS1("new", nil, false, nil)
+				done <- err
+			}()
+			time.Sleep(20 * time.Millisecond)
+		})
+		return nil
+	})
+
+	n, err := h.Resume()
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	require.NoError(t, <-done)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"a", "b", "c", "new"}, packets)
+}
+
+func TestHandler_ResumeReentrant(t *testing.T) {
+	h, _ := newResumeHandler(t)
+
+	id, err := h.PublishQoS1("a", []byte("1"), false, nil)
+	require.NoError(t, err)
+	h.Detach()
+
+	// A client acknowledging straight from the send path calls back into the session
+	h.SetPublishCallback(func(msg *message.Message) error {
+		return h.HandlePuback(msg.PacketID)
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := h.Resume()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Resume deadlocked on a callback calling back into the session")
+	}
+	assert.Equal(t, 0, h.GetInflightCount())
+	assert.ErrorIs(t, h.HandlePuback(id), ErrPacketIDNotFound)
+}
@@ -0,0 +1,187 @@
+package qos
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultSchedulerTick is the timer resolution of a scheduler created with a zero tick
+const DefaultSchedulerTick = 100 * time.Millisecond
+
+// schedulerSlots is the number of slots in the timer wheel
+const schedulerSlots = 512
+
+// Scheduler drives the retry and cleanup timers of many sessions from a single goroutine
+// Timers live in a hashed timer wheel, so arming and stopping one is O(1) however many sessions share it
+type Scheduler struct {
+	tick time.Duration
+
+	mu     sync.Mutex
+	slots  [schedulerSlots]*Timer
+	pos    int
+	armed  int
+	due    []*Timer
+	closed bool
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// Timer is a periodic callback armed on a Scheduler
+type Timer struct {
+	scheduler  *Scheduler
+	fn         func()
+	interval   int // ticks between runs
+	rounds     int // full turns of the wheel left before it fires
+	slot       int // -1 while not in the wheel
+	prev, next *Timer
+	stopped    bool
+}
+
+// NewScheduler creates a scheduler firing timers with the given resolution and starts its goroutine
+func NewScheduler(tick time.Duration) *Scheduler {
+	if tick <= 0 {
+		tick = DefaultSchedulerTick
+	}
+
+	s := &Scheduler{
+		tick:   tick,
+		stopCh: make(chan struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.run()
+
+	return s
+}
+
+// Tick returns the timer resolution
+func (s *Scheduler) Tick() time.Duration {
+	return s.tick
+}
+
+// Every arms a timer calling fn every interval, rounded up to the scheduler tick
+// Callbacks run one at a time on the scheduler goroutine and must not block
+func (s *Scheduler) Every(interval time.Duration, fn func()) *Timer {
+	ticks := int((interval + s.tick - 1) / s.tick)
+	if ticks < 1 {
+		ticks = 1
+	}
+
+	t := &Timer{scheduler: s, fn: fn, interval: ticks, slot: -1}
+
+	s.mu.Lock()
+	s.insert(t)
+	s.mu.Unlock()
+
+	return t
+}
+
+// Stop disarms the timer, a run already in progress completes but the timer is not rearmed
+func (t *Timer) Stop() {
+	s := t.scheduler
+	s.mu.Lock()
+	t.stopped = true
+	s.remove(t)
+	s.mu.Unlock()
+}
+
+// Len returns the number of armed timers
+func (s *Scheduler) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.armed
+}
+
+// Close stops the scheduler goroutine, armed timers no longer fire
+func (s *Scheduler) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	close(s.stopCh)
+	s.wg.Wait()
+}
+
+// run advances the wheel once per tick
+func (s *Scheduler) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			s.advance()
+		}
+	}
+}
+
+// advance moves the wheel one slot forward and runs the timers that came due
+func (s *Scheduler) advance() {
+	s.mu.Lock()
+	s.pos = (s.pos + 1) % schedulerSlots
+	due := s.due[:0]
+	for t := s.slots[s.pos]; t != nil; {
+		next := t.next
+		if t.rounds > 0 {
+			t.rounds--
+		} else {
+			s.remove(t)
+			due = append(due, t)
+		}
+		t = next
+	}
+	s.due = due
+	s.mu.Unlock()
+
+	for _, t := range due {
+		t.fn()
+	}
+
+	s.mu.Lock()
+	for i, t := range due {
+		if !t.stopped && !s.closed {
+			s.insert(t)
+		}
+		due[i] = nil
+	}
+	s.mu.Unlock()
+}
+
+// insert links t into the slot it next fires in (must be called with lock held)
+func (s *Scheduler) insert(t *Timer) {
+	t.slot = (s.pos + t.interval) % schedulerSlots
+	t.rounds = (t.interval - 1) / schedulerSlots
+	t.prev = nil
+	t.next = s.slots[t.slot]
+	if t.next != nil {
+		t.next.prev = t
+	}
+	s.slots[t.slot] = t
+	s.armed++
+}
+
+// remove unlinks t from its slot (must be called with lock held)
+func (s *Scheduler) remove(t *Timer) {
+	if t.slot < 0 {
+		return
+	}
+	if t.prev != nil {
+		t.prev.next = t.next
+	} else {
+		s.slots[t.slot] = t.next
+	}
+	if t.next != nil {
+		t.next.prev = t.prev
+	}
+	t.prev, t.next = nil, nil
+	t.slot = -1
+	s.armed--
+}
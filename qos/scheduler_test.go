@@ -0,0 +1,129 @@
+package qos
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/axmq/ax/types/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduler_Every(t *testing.T) {
+	s := NewScheduler(time.Millisecond)
+	defer s.Close()
+
+	var fast, slow atomic.Int32
+	s.Every(time.Millisecond, func() { fast.Add(1) })
+	s.Every(20*time.Millisecond, func() { slow.Add(1) })
+	assert.Equal(t, 2, s.Len())
+
+	time.Sleep(60 * time.Millisecond)
+	assert.Greater(t, fast.Load(), slow.Load())
+	assert.Greater(t, slow.Load(), int32(0))
+}
+
+func TestScheduler_Stop(t *testing.T) {
+	s := NewScheduler(time.Millisecond)
+	defer s.Close()
+
+	var runs atomic.Int32
+	timer := s.Every(time.Millisecond, func() { runs.Add(1) })
+	time.Sleep(10 * time.Millisecond)
+
+	timer.Stop()
+	timer.Stop()
+	assert.Equal(t, 0, s.Len())
+
+	stopped := runs.Load()
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, stopped, runs.Load())
+}
+
+func TestScheduler_Wheel(t *testing.T) {
+	s := NewScheduler(time.Hour)
+	defer s.Close()
+
+	tests := []struct {
+		name  string
+		ticks int
+	}{
+		{name: "next tick", ticks: 1},
+		{name: "within one turn", ticks: 7},
+		{name: "full turn", ticks: schedulerSlots},
+		{name: "several turns", ticks: 2*schedulerSlots + 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var runs int
+			timer := s.Every(time.Duration(tt.ticks)*time.Hour, func() { runs++ })
+			defer timer.Stop()
+
+			for i := 1; i < tt.ticks; i++ {
+				s.advance()
+			}
+			assert.Equal(t, 0, runs)
+
+			s.advance()
+			assert.Equal(t, 1, runs)
+
+			// Rearmed for the next interval
+			for i := 0; i < tt.ticks; i++ {
+				s.advance()
+			}
+			assert.Equal(t, 2, runs)
+		})
+	}
+}
+
+func TestScheduler_StopDuringRun(t *testing.T) {
+	s := NewScheduler(time.Hour)
+	defer s.Close()
+
+	var timer *Timer
+	runs := 0
+	timer = s.Every(time.Hour, func() {
+		runs++
+		timer.Stop()
+	})
+
+	s.advance()
+	s.advance()
+	assert.Equal(t, 1, runs)
+	assert.Equal(t, 0, s.Len())
+}
+
+func TestScheduler_SharedSessions(t *testing.T) {
+	config := DefaultConfig()
+	config.RetryInterval = 5 * time.Millisecond
+	config.MaxRetries = 100
+
+	s := NewScheduler(time.Millisecond)
+	defer s.Close()
+
+	sessions := make([]*Session, 100)
+	var resent atomic.Int32
+	for i := range sessions {
+		sessions[i] = NewSession(config, s)
+		sessions[i].SetPublishCallback(func(*message.Message) error {
+			resent.Add(1)
+			return nil
+		})
+		_, err := sessions[i].PublishQoS1("test/topic", nil, false, nil)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2*len(sessions), s.Len())
+
+	time.Sleep(30 * time.Millisecond)
+	assert.Greater(t, int(resent.Load()), 2*len(sessions))
+
+	for _, session := range sessions {
+		require.NoError(t, session.Close())
+	}
+	assert.Equal(t, 0, s.Len())
+
+	_, err := sessions[0].PublishQoS1("test/topic", nil, false, nil)
+	assert.ErrorIs(t, err, ErrHandlerClosed)
+}
@@ -0,0 +1,1026 @@
+package qos
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/types/message"
+)
+
+// Config holds QoS handler configuration
+type Config struct {
+	MaxInflight       uint16
+	RetryInterval     time.Duration
+	MaxRetries        int
+	RetryBackoff      float64
+	MaxRetryInterval  time.Duration
+	CleanupInterval   time.Duration
+	AckTimeout        time.Duration
+	EnableDedup       bool
+	DedupWindowSize   int
+	DedupCleanupCount int
+
+	// InflightTTL abandons unacknowledged messages after this duration regardless of message expiry, zero disables it
+	InflightTTL time.Duration
+
+	// AdaptiveRetry schedules the first retry at RTTMultiplier times the observed ack latency
+	AdaptiveRetry    bool
+	RTTMultiplier    float64
+	MinRetryInterval time.Duration
+	EWMAAlpha        float64
+}
+
+// DefaultConfig returns default configuration
+func DefaultConfig() *Config {
+	return &Config{
+		MaxInflight:       65535,
+		RetryInterval:     5 * time.Second,
+		MaxRetries:        5,
+		RetryBackoff:      2.0,
+		MaxRetryInterval:  60 * time.Second,
+		CleanupInterval:   30 * time.Second,
+		AckTimeout:        30 * time.Second,
+		EnableDedup:       true,
+		DedupWindowSize:   1000,
+		DedupCleanupCount: 100,
+		RTTMultiplier:     3.0,
+		MinRetryInterval:  500 * time.Millisecond,
+		EWMAAlpha:         0.125,
+	}
+}
+
+// Session holds the QoS state of a single client session: inflight messages, packet IDs and callbacks
+// Retries and cleanup are driven by a Scheduler shared between sessions, so a session owns no goroutine
+type Session struct {
+	config *Config
+
+	// retrying is set while the retransmissions of a retry tick are sent
+	retrying atomic.Bool
+	// batches counts the retry and cleanup batches running off the scheduler goroutine
+	batches sync.WaitGroup
+	// sendMu is held by Resume while it retransmits without mu, new inbound and outbound publishes wait for it
+	sendMu sync.RWMutex
+
+	mu            sync.RWMutex
+	qos1Messages  map[uint16]*message.Message
+	qos2Messages  map[uint16]*message.Message
+	qos2Pubrel    map[uint16]time.Time
+	qos2Received  map[uint16]time.Time
+	dedupCache    *dedupCache
+	nextPacketID  uint16
+	inflightCount int
+	ackLatency    time.Duration
+	callbacks     *callbacks
+	stats         StatsRecorder
+	ctx           context.Context
+	cancel        context.CancelFunc
+	retryTimer    *Timer
+	cleanupTimer  *Timer
+	closed        bool
+	detached      bool
+}
+
+// StatsRecorder counts the traffic of a session, session.Stats implements it
+type StatsRecorder interface {
+	RecordSent(qos byte, size int)
+	RecordReceived(qos byte, size int)
+	RecordDrop()
+	RecordRetry()
+}
+
+// noStats discards the traffic of sessions without a recorder
+type noStats struct{}
+
+func (noStats) RecordSent(byte, int)     {}
+func (noStats) RecordReceived(byte, int) {}
+func (noStats) RecordDrop()              {}
+func (noStats) RecordRetry()             {}
+
+// callbacks holds event handlers
+type callbacks struct {
+	onPublish         func(msg *message.Message) error
+	onPublishContext  func(ctx context.Context, msg *message.Message) error
+	onPuback          func(packetID uint16) error
+	onPubrec          func(packetID uint16) error
+	onPubrel          func(packetID uint16) error
+	onPubcomp         func(packetID uint16) error
+	onPubcompReason   func(packetID uint16, reasonCode encoding.ReasonCode) error
+	onExpired         func(msg *message.Message)
+	onMaxRetry        func(msg *message.Message)
+	onInflightExpired func(packetID uint16)
+}
+
+// NewSession creates the QoS state of a session with its retry and cleanup timers armed on scheduler
+func NewSession(config *Config, scheduler *Scheduler) *Session {
+	if config == nil {
+		config = DefaultConfig()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &Session{
+		config:       config,
+		qos1Messages: make(map[uint16]*message.Message),
+		qos2Messages: make(map[uint16]*message.Message),
+		qos2Pubrel:   make(map[uint16]time.Time),
+		qos2Received: make(map[uint16]time.Time),
+		nextPacketID: 1,
+		callbacks:    &callbacks{},
+		stats:        noStats{},
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+
+	if config.EnableDedup {
+		s.dedupCache = newDedupCache(config.DedupWindowSize)
+	}
+
+	s.retryTimer = scheduler.Every(retryTick(config), s.retryMessages)
+	s.cleanupTimer = scheduler.Every(config.CleanupInterval, s.cleanup)
+
+	return s
+}
+
+// SetStats counts the messages received, sent, retried and dropped by the session with stats
+func (s *Session) SetStats(stats StatsRecorder) {
+	if stats == nil {
+		stats = noStats{}
+	}
+	s.mu.Lock()
+	s.stats = stats
+	s.mu.Unlock()
+}
+
+// SetPublishCallback sets the callback for publishing messages
+func (s *Session) SetPublishCallback(cb func(msg *message.Message) error) {
+	s.mu.Lock()
+	s.callbacks.onPublish = cb
+	s.mu.Unlock()
+}
+
+// SetPublishContextCallback sets a context-aware callback for publishing messages
+// When set, it is used instead of the publish callback and receives the context passed to HandlePublishContext
+func (s *Session) SetPublishContextCallback(cb func(ctx context.Context, msg *message.Message) error) {
+	s.mu.Lock()
+	s.callbacks.onPublishContext = cb
+	s.mu.Unlock()
+}
+
+// SetPubackCallback sets the callback for PUBACK
+func (s *Session) SetPubackCallback(cb func(packetID uint16) error) {
+	s.mu.Lock()
+	s.callbacks.onPuback = cb
+	s.mu.Unlock()
+}
+
+// SetPubrecCallback sets the callback for PUBREC
+func (s *Session) SetPubrecCallback(cb func(packetID uint16) error) {
+	s.mu.Lock()
+	s.callbacks.onPubrec = cb
+	s.mu.Unlock()
+}
+
+// SetPubrelCallback sets the callback for PUBREL
+func (s *Session) SetPubrelCallback(cb func(packetID uint16) error) {
+	s.mu.Lock()
+	s.callbacks.onPubrel = cb
+	s.mu.Unlock()
+}
+
+// SetPubcompCallback sets the callback for PUBCOMP
+func (s *Session) SetPubcompCallback(cb func(packetID uint16) error) {
+	s.mu.Lock()
+	s.callbacks.onPubcomp = cb
+	s.mu.Unlock()
+}
+
+// SetPubcompReasonCallback sets the callback for sending PUBCOMP with a reason code
+// When set, it is used instead of the PUBCOMP callback for outgoing PUBCOMP packets
+func (s *Session) SetPubcompReasonCallback(cb func(packetID uint16, reasonCode encoding.ReasonCode) error) {
+	s.mu.Lock()
+	s.callbacks.onPubcompReason = cb
+	s.mu.Unlock()
+}
+
+// SetExpiredCallback sets the callback for expired messages
+func (s *Session) SetExpiredCallback(cb func(msg *message.Message)) {
+	s.mu.Lock()
+	s.callbacks.onExpired = cb
+	s.mu.Unlock()
+}
+
+// SetMaxRetryCallback sets the callback for max retry reached
+func (s *Session) SetMaxRetryCallback(cb func(msg *message.Message)) {
+	s.mu.Lock()
+	s.callbacks.onMaxRetry = cb
+	s.mu.Unlock()
+}
+
+// SetInflightExpiredCallback sets the callback for messages abandoned after the inflight TTL
+// Brokers report these through the OnQosDropped hook with DropReasonExpired
+func (s *Session) SetInflightExpiredCallback(cb func(packetID uint16)) {
+	s.mu.Lock()
+	s.callbacks.onInflightExpired = cb
+	s.mu.Unlock()
+}
+
+// HandlePublish handles incoming PUBLISH packet based on QoS level
+func (s *Session) HandlePublish(msg *message.Message) error {
+	return s.HandlePublishContext(context.Background(), msg)
+}
+
+// HandlePublishContext handles an incoming PUBLISH packet like HandlePublish, passing ctx down to the publish callback
+// The context is also cancelled when the session is closed and, for messages with an expiry interval,
+// when the message expires, so delivery stops instead of outliving the session or the message
+func (s *Session) HandlePublishContext(ctx context.Context, msg *message.Message) error {
+	s.sendMu.RLock()
+	defer s.sendMu.RUnlock()
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrHandlerClosed
+	}
+	stats := s.stats
+	s.mu.Unlock()
+
+	stats.RecordReceived(byte(msg.QoS), len(msg.Payload))
+	if msg.IsExpired() {
+		stats.RecordDrop()
+		return ErrMessageExpired
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ctx, cancel := s.publishContext(ctx, msg)
+	defer cancel()
+
+	switch msg.QoS {
+	case encoding.QoS0:
+		return s.handleQoS0Publish(ctx, msg)
+	case encoding.QoS1:
+		return s.handleQoS1Publish(ctx, msg)
+	case encoding.QoS2:
+		return s.handleQoS2Publish(ctx, msg)
+	default:
+		return ErrInvalidQoS
+	}
+}
+
+// publishContext bounds ctx by the session lifetime and the message expiry
+func (s *Session) publishContext(ctx context.Context, msg *message.Message) (context.Context, context.CancelFunc) {
+	var cancel context.CancelFunc
+	if deadline, ok := msg.Deadline(); ok {
+		ctx, cancel = context.WithDeadlineCause(ctx, deadline, ErrMessageExpired)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+
+	stop := context.AfterFunc(s.ctx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// publish runs the publish callback, preferring the context-aware one
+func (s *Session) publish(ctx context.Context, msg *message.Message, cb func(*message.Message) error, ctxCb func(context.Context, *message.Message) error) error {
+	if ctxCb != nil {
+		return ctxCb(ctx, msg)
+	}
+	if cb != nil {
+		return cb(msg)
+	}
+	return nil
+}
+
+// handleQoS0Publish handles QoS 0 fire-and-forget delivery
+func (s *Session) handleQoS0Publish(ctx context.Context, msg *message.Message) error {
+	s.mu.RLock()
+	cb, ctxCb := s.callbacks.onPublish, s.callbacks.onPublishContext
+	s.mu.RUnlock()
+
+	return s.publish(ctx, msg, cb, ctxCb)
+}
+
+// handleQoS1Publish handles QoS 1 at-least-once delivery
+func (s *Session) handleQoS1Publish(ctx context.Context, msg *message.Message) error {
+	s.mu.Lock()
+
+	if s.config.EnableDedup && s.dedupCache.exists(msg.PacketID) {
+		s.mu.Unlock()
+		return s.sendPuback(msg.PacketID)
+	}
+
+	if s.config.EnableDedup {
+		s.dedupCache.add(msg.PacketID)
+	}
+
+	cb, ctxCb := s.callbacks.onPublish, s.callbacks.onPublishContext
+	s.mu.Unlock()
+
+	err := s.publish(ctx, msg, cb, ctxCb)
+
+	if err == nil {
+		return s.sendPuback(msg.PacketID)
+	}
+
+	return err
+}
+
+// handleQoS2Publish handles QoS 2 exactly-once delivery (step 1: receive PUBLISH)
+func (s *Session) handleQoS2Publish(ctx context.Context, msg *message.Message) error {
+	s.mu.Lock()
+
+	if _, exists := s.qos2Received[msg.PacketID]; exists {
+		s.mu.Unlock()
+		return s.sendPubrec(msg.PacketID)
+	}
+
+	if s.config.EnableDedup && s.dedupCache.exists(msg.PacketID) {
+		s.mu.Unlock()
+		return s.sendPubrec(msg.PacketID)
+	}
+
+	s.qos2Received[msg.PacketID] = time.Now()
+
+	if s.config.EnableDedup {
+		s.dedupCache.add(msg.PacketID)
+	}
+
+	cb, ctxCb := s.callbacks.onPublish, s.callbacks.onPublishContext
+	s.mu.Unlock()
+
+	err := s.publish(ctx, msg, cb, ctxCb)
+
+	if err == nil {
+		return s.sendPubrec(msg.PacketID)
+	}
+
+	return err
+}
+
+// HandlePuback handles incoming PUBACK packet (completes QoS 1 flow)
+func (s *Session) HandlePuback(packetID uint16) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrHandlerClosed
+	}
+
+	msg, exists := s.qos1Messages[packetID]
+	if !exists {
+		if s.isQoS2Outbound(packetID) {
+			return encoding.NewProtocolError(ErrUnexpectedAck, "PUBACK received for QoS 2 message")
+		}
+		return ErrPacketIDNotFound
+	}
+
+	delete(s.qos1Messages, packetID)
+	s.inflightCount--
+	s.observeAckLatency(msg)
+
+	if s.callbacks.onPuback != nil {
+		return s.callbacks.onPuback(msg.PacketID)
+	}
+
+	return nil
+}
+
+// HandlePubrec handles incoming PUBREC packet (QoS 2 step 2)
+func (s *Session) HandlePubrec(packetID uint16) error {
+	s.mu.Lock()
+
+	if s.closed {
+		s.mu.Unlock()
+		return ErrHandlerClosed
+	}
+
+	msg, exists := s.qos2Messages[packetID]
+	if !exists {
+		_, awaitingPubcomp := s.qos2Pubrel[packetID]
+		_, isQoS1 := s.qos1Messages[packetID]
+		s.mu.Unlock()
+
+		switch {
+		case awaitingPubcomp:
+			// Duplicate PUBREC, our PUBREL may have been lost
+			return s.sendPubrel(packetID)
+		case isQoS1:
+			return encoding.NewProtocolError(ErrUnexpectedAck, "PUBREC received for QoS 1 message")
+		default:
+			return ErrPacketIDNotFound
+		}
+	}
+
+	delete(s.qos2Messages, packetID)
+	s.qos2Pubrel[packetID] = msg.CreatedAt
+	s.observeAckLatency(msg)
+
+	cb := s.callbacks.onPubrec
+	s.mu.Unlock()
+
+	if cb != nil {
+		if err := cb(packetID); err != nil {
+			return err
+		}
+	}
+
+	return s.sendPubrel(msg.PacketID)
+}
+
+// HandlePubrel handles incoming PUBREL packet (QoS 2 step 3)
+func (s *Session) HandlePubrel(packetID uint16) error {
+	s.mu.Lock()
+
+	if s.closed {
+		s.mu.Unlock()
+		return ErrHandlerClosed
+	}
+
+	if _, exists := s.qos2Received[packetID]; !exists {
+		s.mu.Unlock()
+		return s.sendPubcompWithReason(packetID, encoding.ReasonPacketIdentifierNotFound)
+	}
+
+	delete(s.qos2Received, packetID)
+
+	cb := s.callbacks.onPubrel
+	s.mu.Unlock()
+
+	if cb != nil {
+		if err := cb(packetID); err != nil {
+			return err
+		}
+	}
+
+	return s.sendPubcomp(packetID)
+}
+
+// HandlePubcomp handles incoming PUBCOMP packet (completes QoS 2 flow)
+func (s *Session) HandlePubcomp(packetID uint16) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrHandlerClosed
+	}
+
+	if _, exists := s.qos2Pubrel[packetID]; !exists {
+		if _, exists := s.qos2Messages[packetID]; exists {
+			return encoding.NewProtocolError(ErrUnexpectedAck, "PUBCOMP received before PUBREC")
+		}
+		if _, exists := s.qos1Messages[packetID]; exists {
+			return encoding.NewProtocolError(ErrUnexpectedAck, "PUBCOMP received for QoS 1 message")
+		}
+		return ErrPacketIDNotFound
+	}
+
+	delete(s.qos2Pubrel, packetID)
+	s.inflightCount--
+
+	if s.callbacks.onPubcomp != nil {
+		return s.callbacks.onPubcomp(packetID)
+	}
+
+	return nil
+}
+
+// HandleAcks handles a burst of PUBACK or PUBCOMP packets under a single lock acquisition
+// It returns the number of acknowledged messages; unknown packet IDs are skipped and reported as ErrPacketIDNotFound
+func (s *Session) HandleAcks(packetIDs []uint16, ackType encoding.PacketType) (int, error) {
+	if ackType != encoding.PUBACK && ackType != encoding.PUBCOMP {
+		return 0, ErrInvalidAckType
+	}
+
+	s.mu.Lock()
+
+	if s.closed {
+		s.mu.Unlock()
+		return 0, ErrHandlerClosed
+	}
+
+	acked := make([]uint16, 0, len(packetIDs))
+	missing, unexpected := false, false
+	for _, packetID := range packetIDs {
+		if ackType == encoding.PUBACK {
+			msg, exists := s.qos1Messages[packetID]
+			if !exists {
+				if s.isQoS2Outbound(packetID) {
+					unexpected = true
+				}
+				missing = true
+				continue
+			}
+			delete(s.qos1Messages, packetID)
+			s.observeAckLatency(msg)
+		} else {
+			if _, exists := s.qos2Pubrel[packetID]; !exists {
+				_, beforePubrec := s.qos2Messages[packetID]
+				_, isQoS1 := s.qos1Messages[packetID]
+				if beforePubrec || isQoS1 {
+					unexpected = true
+				}
+				missing = true
+				continue
+			}
+			delete(s.qos2Pubrel, packetID)
+		}
+		acked = append(acked, packetID)
+	}
+	s.inflightCount -= len(acked)
+
+	cb := s.callbacks.onPuback
+	if ackType == encoding.PUBCOMP {
+		cb = s.callbacks.onPubcomp
+	}
+	s.mu.Unlock()
+
+	if cb != nil {
+		for _, packetID := range acked {
+			if err := cb(packetID); err != nil {
+				return len(acked), err
+			}
+		}
+	}
+
+	if unexpected {
+		return len(acked), encoding.NewProtocolError(ErrUnexpectedAck, "acknowledgment does not match QoS level")
+	}
+	if missing {
+		return len(acked), ErrPacketIDNotFound
+	}
+	return len(acked), nil
+}
+
+// PublishQoS1 publishes a message with QoS 1 (at-least-once)
+func (s *Session) PublishQoS1(topic string, payload []byte, retain bool, properties map[string]interface{}) (uint16, error) {
+	return s.publishWithQoS(topic, payload, retain, properties, encoding.QoS1)
+}
+
+// PublishQoS2 publishes a message with QoS 2 (exactly-once)
+func (s *Session) PublishQoS2(topic string, payload []byte, retain bool, properties map[string]interface{}) (uint16, error) {
+	return s.publishWithQoS(topic, payload, retain, properties, encoding.QoS2)
+}
+
+// publishWithQoS is a helper function that handles publishing for both QoS 1 and QoS 2
+func (s *Session) publishWithQoS(topic string, payload []byte, retain bool, properties map[string]interface{}, qos encoding.QoS) (uint16, error) {
+	// Wait for a Resume in progress, so the flows it retransmits go out before this one
+	s.sendMu.RLock()
+	defer s.sendMu.RUnlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, ErrHandlerClosed
+	}
+
+	if s.inflightCount >= int(s.config.MaxInflight) {
+		return 0, ErrQueueFull
+	}
+
+	packetID := s.allocatePacketID()
+	msg := message.NewMessage(packetID, topic, payload, qos, retain, properties)
+
+	if msg.IsExpired() {
+		return 0, ErrMessageExpired
+	}
+
+	// Store in appropriate map based on QoS level
+	if qos == encoding.QoS1 {
+		s.qos1Messages[packetID] = msg
+	} else {
+		s.qos2Messages[packetID] = msg
+	}
+	s.inflightCount++
+
+	msg.MarkAttempt()
+	if s.callbacks.onPublish != nil {
+		if err := s.callbacks.onPublish(msg); err != nil {
+			// Clean up on error
+			if qos == encoding.QoS1 {
+				delete(s.qos1Messages, packetID)
+			} else {
+				delete(s.qos2Messages, packetID)
+			}
+			s.inflightCount--
+			return 0, err
+		}
+	}
+	s.stats.RecordSent(byte(qos), len(payload))
+
+	return packetID, nil
+}
+
+// allocatePacketID allocates a new packet ID (must be called with lock held)
+func (s *Session) allocatePacketID() uint16 {
+	for {
+		packetID := s.nextPacketID
+		s.nextPacketID++
+		if s.nextPacketID == 0 {
+			s.nextPacketID = 1
+		}
+
+		if _, exists := s.qos1Messages[packetID]; !exists {
+			if _, exists := s.qos2Messages[packetID]; !exists {
+				if _, exists := s.qos2Pubrel[packetID]; !exists {
+					return packetID
+				}
+			}
+		}
+	}
+}
+
+// sendPuback sends a PUBACK packet
+func (s *Session) sendPuback(packetID uint16) error {
+	s.mu.RLock()
+	cb := s.callbacks.onPuback
+	s.mu.RUnlock()
+
+	if cb != nil {
+		return cb(packetID)
+	}
+	return nil
+}
+
+// sendPubrec sends a PUBREC packet
+func (s *Session) sendPubrec(packetID uint16) error {
+	s.mu.RLock()
+	cb := s.callbacks.onPubrec
+	s.mu.RUnlock()
+
+	if cb != nil {
+		return cb(packetID)
+	}
+	return nil
+}
+
+// sendPubrel sends a PUBREL packet
+func (s *Session) sendPubrel(packetID uint16) error {
+	s.mu.RLock()
+	cb := s.callbacks.onPubrel
+	s.mu.RUnlock()
+
+	if cb != nil {
+		return cb(packetID)
+	}
+	return nil
+}
+
+// sendPubcomp sends a PUBCOMP packet
+func (s *Session) sendPubcomp(packetID uint16) error {
+	return s.sendPubcompWithReason(packetID, encoding.ReasonSuccess)
+}
+
+// sendPubcompWithReason sends a PUBCOMP packet with the given reason code
+func (s *Session) sendPubcompWithReason(packetID uint16, reasonCode encoding.ReasonCode) error {
+	s.mu.RLock()
+	cb := s.callbacks.onPubcomp
+	reasonCb := s.callbacks.onPubcompReason
+	s.mu.RUnlock()
+
+	if reasonCb != nil {
+		return reasonCb(packetID, reasonCode)
+	}
+	if cb != nil {
+		return cb(packetID)
+	}
+	return nil
+}
+
+// isQoS2Outbound reports whether the packet ID belongs to an outgoing QoS 2 flow (must be called with lock held)
+func (s *Session) isQoS2Outbound(packetID uint16) bool {
+	if _, exists := s.qos2Messages[packetID]; exists {
+		return true
+	}
+	_, exists := s.qos2Pubrel[packetID]
+	return exists
+}
+
+// retryTick returns how often pending messages are checked for a retry
+func retryTick(config *Config) time.Duration {
+	interval := config.RetryInterval
+	if config.AdaptiveRetry && config.MinRetryInterval > 0 && config.MinRetryInterval < interval {
+		interval = config.MinRetryInterval
+	}
+	return interval
+}
+
+// retryBatch holds the work of a retry or cleanup tick, collected under the lock and run without it
+type retryBatch struct {
+	retries    []*message.Message // due for retransmission
+	expired    []*message.Message // dropped on their message expiry
+	exhausted  []*message.Message // dropped after MaxRetries attempts
+	inflight   []uint16           // packet IDs abandoned after the inflight TTL
+	onPublish  func(*message.Message) error
+	onExpired  func(*message.Message)
+	onMaxRetry func(*message.Message)
+}
+
+// newRetryBatch creates a batch with the current callbacks (must be called with lock held)
+func (s *Session) newRetryBatch() *retryBatch {
+	return &retryBatch{
+		onPublish:  s.callbacks.onPublish,
+		onExpired:  s.callbacks.onExpired,
+		onMaxRetry: s.callbacks.onMaxRetry,
+	}
+}
+
+func (b *retryBatch) empty() bool {
+	return len(b.retries) == 0 && len(b.expired) == 0 && len(b.exhausted) == 0 && len(b.inflight) == 0
+}
+
+// run calls the callbacks of the batch, it must not hold the lock so a slow peer only delays its own session
+func (s *Session) runRetryBatch(b *retryBatch) {
+	if b.onExpired != nil {
+		for _, msg := range b.expired {
+			b.onExpired(msg)
+		}
+	}
+	if b.onMaxRetry != nil {
+		for _, msg := range b.exhausted {
+			b.onMaxRetry(msg)
+		}
+	}
+	s.notifyInflightExpired(b.inflight)
+	if b.onPublish != nil {
+		for _, msg := range b.retries {
+			_ = b.onPublish(msg)
+		}
+	}
+}
+
+// retryMessages collects the pending messages due for a retry and retransmits them off the scheduler goroutine
+// A tick is skipped while the retransmissions of the previous one are still being sent
+func (s *Session) retryMessages() {
+	if !s.retrying.CompareAndSwap(false, true) {
+		return
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		s.retrying.Store(false)
+		return
+	}
+
+	now := time.Now()
+	batch := s.newRetryBatch()
+	batch.inflight = s.expireInflight(now)
+	s.retryMessagesInMap(s.qos1Messages, now, batch)
+	s.retryMessagesInMap(s.qos2Messages, now, batch)
+	s.mu.Unlock()
+
+	if batch.empty() {
+		s.retrying.Store(false)
+		return
+	}
+	s.batches.Add(1)
+	go func() {
+		defer s.batches.Done()
+		defer s.retrying.Store(false)
+		s.runRetryBatch(batch)
+	}()
+}
+
+// retryMessagesInMap collects the messages of a given map due for a retry into batch (must be called with lock held)
+func (s *Session) retryMessagesInMap(messages map[uint16]*message.Message, now time.Time, batch *retryBatch) {
+	for packetID, msg := range messages {
+		if msg.IsExpired() {
+			delete(messages, packetID)
+			s.inflightCount--
+			s.stats.RecordDrop()
+			batch.expired = append(batch.expired, msg)
+			continue
+		}
+
+		// Nothing can be retransmitted until the client reconnects
+		if s.detached {
+			continue
+		}
+
+		retryInterval := s.calculateRetryInterval(msg.AttemptCount)
+		if now.Sub(msg.LastAttemptAt) >= retryInterval {
+			if msg.AttemptCount >= s.config.MaxRetries {
+				delete(messages, packetID)
+				s.inflightCount--
+				s.stats.RecordDrop()
+				batch.exhausted = append(batch.exhausted, msg)
+				continue
+			}
+
+			msg.MarkAttempt()
+			s.stats.RecordRetry()
+			batch.retries = append(batch.retries, msg)
+		}
+	}
+}
+
+// observeAckLatency updates the ack latency estimate (must be called with lock held)
+// Retransmitted messages are ignored since their ack cannot be matched to an attempt
+func (s *Session) observeAckLatency(msg *message.Message) {
+	if !s.config.AdaptiveRetry || msg.AttemptCount != 1 {
+		return
+	}
+
+	sample := time.Since(msg.LastAttemptAt)
+	if s.ackLatency == 0 {
+		s.ackLatency = sample
+		return
+	}
+
+	alpha := s.config.EWMAAlpha
+	if alpha <= 0 || alpha > 1 {
+		alpha = 0.125
+	}
+	s.ackLatency = time.Duration((1-alpha)*float64(s.ackLatency) + alpha*float64(sample))
+}
+
+// baseRetryInterval returns the interval before the first retry (must be called with lock held)
+func (s *Session) baseRetryInterval() time.Duration {
+	if !s.config.AdaptiveRetry || s.ackLatency == 0 {
+		return s.config.RetryInterval
+	}
+
+	multiplier := s.config.RTTMultiplier
+	if multiplier <= 0 {
+		multiplier = 3.0
+	}
+
+	interval := time.Duration(float64(s.ackLatency) * multiplier)
+	if interval < s.config.MinRetryInterval {
+		interval = s.config.MinRetryInterval
+	}
+	if interval > s.config.MaxRetryInterval {
+		interval = s.config.MaxRetryInterval
+	}
+	return interval
+}
+
+// calculateRetryInterval calculates retry interval with exponential backoff
+func (s *Session) calculateRetryInterval(attemptCount int) time.Duration {
+	base := s.baseRetryInterval()
+	if attemptCount == 0 {
+		return base
+	}
+
+	backoffMultiplier := 1.0
+	for i := 0; i < attemptCount-1; i++ {
+		backoffMultiplier *= s.config.RetryBackoff
+	}
+
+	interval := time.Duration(float64(base) * backoffMultiplier)
+	if interval > s.config.MaxRetryInterval {
+		interval = s.config.MaxRetryInterval
+	}
+
+	return interval
+}
+
+// cleanup removes expired messages and old deduplication entries
+func (s *Session) cleanup() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+
+	now := time.Now()
+
+	batch := s.newRetryBatch()
+	batch.expired = s.cleanupExpiredMessages(s.qos1Messages, batch.expired)
+	batch.expired = s.cleanupExpiredMessages(s.qos2Messages, batch.expired)
+	batch.inflight = s.expireInflight(now)
+
+	for packetID, receivedAt := range s.qos2Received {
+		if len(s.qos2Received) > s.config.DedupCleanupCount {
+			if now.Sub(receivedAt) > s.config.AckTimeout {
+				delete(s.qos2Received, packetID)
+			}
+		}
+	}
+
+	if s.config.EnableDedup && s.dedupCache != nil {
+		s.dedupCache.cleanup()
+	}
+	s.mu.Unlock()
+
+	if !batch.empty() {
+		s.batches.Add(1)
+		go func() {
+			defer s.batches.Done()
+			s.runRetryBatch(batch)
+		}()
+	}
+}
+
+// cleanupExpiredMessages removes expired messages from a given map and appends them to dropped
+// (must be called with lock held), hand them to the expired callback once the lock is released
+func (s *Session) cleanupExpiredMessages(messages map[uint16]*message.Message, dropped []*message.Message) []*message.Message {
+	for packetID, msg := range messages {
+		if msg.IsExpired() {
+			delete(messages, packetID)
+			s.inflightCount--
+			s.stats.RecordDrop()
+			dropped = append(dropped, msg)
+		}
+	}
+	return dropped
+}
+
+// expireInflight abandons messages inflight for longer than the inflight TTL and returns their packet IDs
+// (must be called with lock held), hand them to notifyInflightExpired once the lock is released
+func (s *Session) expireInflight(now time.Time) []uint16 {
+	ttl := s.config.InflightTTL
+	if ttl <= 0 {
+		return nil
+	}
+
+	var expired []uint16
+	for _, messages := range []map[uint16]*message.Message{s.qos1Messages, s.qos2Messages} {
+		for packetID, msg := range messages {
+			if now.Sub(msg.CreatedAt) >= ttl {
+				delete(messages, packetID)
+				expired = append(expired, packetID)
+				s.stats.RecordDrop()
+			}
+		}
+	}
+	for packetID, createdAt := range s.qos2Pubrel {
+		if now.Sub(createdAt) >= ttl {
+			delete(s.qos2Pubrel, packetID)
+			expired = append(expired, packetID)
+		}
+	}
+
+	s.inflightCount -= len(expired)
+	return expired
+}
+
+// notifyInflightExpired runs the inflight expired callback for every packet ID, outside the session lock so
+// the callback may call back into the session
+func (s *Session) notifyInflightExpired(expired []uint16) {
+	if len(expired) == 0 {
+		return
+	}
+	s.mu.RLock()
+	cb := s.callbacks.onInflightExpired
+	s.mu.RUnlock()
+
+	if cb != nil {
+		for _, packetID := range expired {
+			cb(packetID)
+		}
+	}
+}
+
+// GetInflightCount returns the current inflight message count
+func (s *Session) GetInflightCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.inflightCount
+}
+
+// AckLatency returns the smoothed ack latency, or zero if no sample has been taken
+func (s *Session) AckLatency() time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ackLatency
+}
+
+// GetPendingQoS1Count returns the number of pending QoS 1 messages
+func (s *Session) GetPendingQoS1Count() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.qos1Messages)
+}
+
+// GetPendingQoS2Count returns the number of pending QoS 2 messages
+func (s *Session) GetPendingQoS2Count() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.qos2Messages)
+}
+
+// Close stops the session timers, tearing a session down does not depend on how many share the scheduler
+func (s *Session) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	s.retryTimer.Stop()
+	s.cleanupTimer.Stop()
+	s.cancel()
+
+	return nil
+}
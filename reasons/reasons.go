@@ -0,0 +1,91 @@
+// Package reasons maps the errors of the broker packages to the reason codes sent to clients
+package reasons
+
+import (
+	"github.com/axmq/ax/auth/credentials"
+	"github.com/axmq/ax/broker"
+	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/hook"
+	"github.com/axmq/ax/hook/mochi"
+	"github.com/axmq/ax/network"
+	"github.com/axmq/ax/provision"
+	"github.com/axmq/ax/qos"
+	"github.com/axmq/ax/session"
+)
+
+// table lists the errors reported with a specific reason code, the first matching entry wins
+var table = encoding.ErrorReasons{
+	// network
+	{Err: network.ErrServerBusy, Code: encoding.ReasonServerBusy},
+	{Err: network.ErrKeepAliveTimeout, Code: encoding.ReasonKeepAliveTimeout},
+	{Err: network.ErrBandwidthExceeded, Code: encoding.ReasonQuotaExceeded},
+	{Err: network.ErrCertificateRevoked, Code: encoding.ReasonNotAuthorized},
+	{Err: network.ErrCertificateVerification, Code: encoding.ReasonNotAuthorized},
+	{Err: network.ErrClientBanned, Code: encoding.ReasonBanned},
+	{Err: network.ErrAddressDenied, Code: encoding.ReasonNotAuthorized},
+	{Err: network.ErrConnectionRateExceeded, Code: encoding.ReasonConnectionRateExceeded},
+	{Err: network.ErrAuthThrottled, Code: encoding.ReasonConnectionRateExceeded},
+	{Err: network.ErrPacketBeforeConnect, Code: encoding.ReasonProtocolError},
+	{Err: network.ErrDuplicateConnect, Code: encoding.ReasonProtocolError},
+
+	// credentials
+	{Err: credentials.ErrUserNotFound, Code: encoding.ReasonBadUsernameOrPassword},
+	{Err: credentials.ErrInvalidCredentials, Code: encoding.ReasonBadUsernameOrPassword},
+
+	// hooks
+	{Err: hook.ErrRateLimitExceeded, Code: encoding.ReasonQuotaExceeded},
+	{Err: hook.ErrClientRateLimitExceeded, Code: encoding.ReasonQuotaExceeded},
+	{Err: hook.ErrGlobalRateLimitExceeded, Code: encoding.ReasonQuotaExceeded},
+	{Err: hook.ErrTopicRateLimitExceeded, Code: encoding.ReasonQuotaExceeded},
+	{Err: hook.ErrTenantRateLimitExceeded, Code: encoding.ReasonQuotaExceeded},
+	{Err: hook.ErrRateLimitUnavailable, Code: encoding.ReasonImplementationSpecificError},
+	{Err: hook.ErrFingerprintChanged, Code: encoding.ReasonNotAuthorized},
+	{Err: hook.ErrPublishRejected, Code: encoding.ReasonUnspecifiedError},
+	{Err: hook.ErrPublishNotAuthorized, Code: encoding.ReasonNotAuthorized},
+	{Err: hook.ErrPublishQuotaExceeded, Code: encoding.ReasonQuotaExceeded},
+	{Err: hook.ErrTopicNameInvalid, Code: encoding.ReasonTopicNameInvalid},
+	{Err: hook.ErrPayloadFormatInvalid, Code: encoding.ReasonPayloadFormatInvalid},
+	{Err: hook.ErrHookPanicked, Code: encoding.ReasonImplementationSpecificError},
+	{Err: hook.ErrHookTimeout, Code: encoding.ReasonImplementationSpecificError},
+	{Err: hook.ErrManagerShutdown, Code: encoding.ReasonServerShuttingDown},
+	{Err: hook.ErrInvalidPropertyFilter, Code: encoding.ReasonImplementationSpecificError},
+	{Err: hook.ErrInvalidSubscriptionTTL, Code: encoding.ReasonImplementationSpecificError},
+	{Err: hook.ErrInvalidLastValue, Code: encoding.ReasonImplementationSpecificError},
+	{Err: hook.ErrGuestQuotaExceeded, Code: encoding.ReasonQuotaExceeded},
+	{Err: hook.ErrGuestSessionExpired, Code: encoding.ReasonMaximumConnectTime},
+	{Err: mochi.ErrRejectPacket, Code: encoding.ReasonUnspecifiedError},
+	{Err: mochi.ErrSubscriptionRejected, Code: encoding.ReasonNotAuthorized},
+
+	// provisioning
+	{Err: provision.ErrInvalidClaim, Code: encoding.ReasonBadUsernameOrPassword},
+	{Err: provision.ErrClaimExpired, Code: encoding.ReasonBadUsernameOrPassword},
+	{Err: provision.ErrClaimExhausted, Code: encoding.ReasonQuotaExceeded},
+	{Err: provision.ErrClaimNotAllowed, Code: encoding.ReasonNotAuthorized},
+	{Err: provision.ErrInvalidRequest, Code: encoding.ReasonPayloadFormatInvalid},
+	{Err: provision.ErrInvalidCSR, Code: encoding.ReasonPayloadFormatInvalid},
+	{Err: provision.ErrCSRRequired, Code: encoding.ReasonPayloadFormatInvalid},
+	{Err: provision.ErrNotProvisioning, Code: encoding.ReasonNotAuthorized},
+	{Err: provision.ErrAuditFailed, Code: encoding.ReasonImplementationSpecificError},
+
+	// broker, sessions and QoS flows
+	{Err: broker.ErrNotAuthorized, Code: encoding.ReasonNotAuthorized},
+	{Err: broker.ErrBrokerClosed, Code: encoding.ReasonServerShuttingDown},
+	{Err: broker.ErrNoMatchingSubscribers, Code: encoding.ReasonNoMatchingSubscribers},
+	{Err: session.ErrTakeoverRejected, Code: encoding.ReasonClientIdentifierNotValid},
+	{Err: session.ErrSubscriptionNotFound, Code: encoding.ReasonNoSubscriptionExisted},
+	{Err: session.ErrManagedSubscription, Code: encoding.ReasonNoSubscriptionExisted},
+	{Err: session.ErrQueueFull, Code: encoding.ReasonQuotaExceeded},
+	{Err: qos.ErrInvalidQoS, Code: encoding.ReasonQoSNotSupported},
+	{Err: qos.ErrPacketIDNotFound, Code: encoding.ReasonPacketIdentifierNotFound},
+	{Err: qos.ErrQueueFull, Code: encoding.ReasonQuotaExceeded},
+}
+
+// Table returns a copy of the mapping, to be handed to the disconnect and acknowledgement paths
+func Table() encoding.ErrorReasons {
+	return append(encoding.ErrorReasons(nil), table...)
+}
+
+// FromError returns the reason code to send for err
+func FromError(err error) encoding.ReasonCode {
+	return table.FromError(err)
+}
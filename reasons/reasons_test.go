@@ -0,0 +1,50 @@
+package reasons
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/axmq/ax/broker"
+	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/hook"
+	"github.com/axmq/ax/hook/mochi"
+	"github.com/axmq/ax/network"
+	"github.com/axmq/ax/session"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want encoding.ReasonCode
+	}{
+		{"nil", nil, encoding.ReasonSuccess},
+		{"duplicate connect", network.ErrDuplicateConnect, encoding.ReasonProtocolError},
+		{"packet before connect", network.ErrPacketBeforeConnect, encoding.ReasonProtocolError},
+		{"keep alive", fmt.Errorf("conn 1: %w", network.ErrKeepAliveTimeout), encoding.ReasonKeepAliveTimeout},
+		{"no matching subscribers", broker.ErrNoMatchingSubscribers, encoding.ReasonNoMatchingSubscribers},
+		{"managed subscription", session.ErrManagedSubscription, encoding.ReasonNoSubscriptionExisted},
+		{"rate limit unavailable", hook.ErrRateLimitUnavailable, encoding.ReasonImplementationSpecificError},
+		{"fingerprint changed", hook.ErrFingerprintChanged, encoding.ReasonNotAuthorized},
+		{"mochi reject", mochi.ErrRejectPacket, encoding.ReasonUnspecifiedError},
+		{"mochi subscription", mochi.ErrSubscriptionRejected, encoding.ReasonNotAuthorized},
+		{"packet error", hook.RejectPublish(encoding.ReasonPayloadFormatInvalid, ""), encoding.ReasonPayloadFormatInvalid},
+		{"codec error", encoding.ErrMalformedPacket, encoding.ReasonMalformedPacket},
+		{"unknown", errors.New("boom"), encoding.ReasonUnspecifiedError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, FromError(tt.err))
+		})
+	}
+}
+
+func TestTable(t *testing.T) {
+	reasons := Table()
+	reasons[0].Code = encoding.ReasonSuccess
+	assert.NotEqual(t, encoding.ReasonSuccess, FromError(table[0].Err), "the table is copied")
+
+	assert.Equal(t, encoding.ReasonNotAuthorized, hook.PublishReasonCode(Table(), hook.ErrPublishNotAuthorized))
+}
@@ -0,0 +1,13 @@
+package replica
+
+import "errors"
+
+var (
+	ErrInvalidFrame     = errors.New("invalid sync frame")
+	ErrInvalidTopic     = errors.New("invalid sync topic")
+	ErrInvalidReplicaID = errors.New("invalid replica id")
+	ErrNoPublisher      = errors.New("sync publisher is required")
+	ErrNoRetainedStore  = errors.New("retained store is required")
+	ErrReadOnly         = errors.New("state is mirrored read-only from an upstream broker")
+	ErrResumeDenied     = errors.New("client is not allowed to resume this mirror")
+)
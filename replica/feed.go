@@ -0,0 +1,466 @@
+package replica
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/hook"
+	"github.com/axmq/ax/shadow"
+	"github.com/axmq/ax/store"
+)
+
+const (
+	// DefaultLogSize is how many changes a feed keeps for mirrors resuming after a gap
+	DefaultLogSize = 10000
+	// DefaultResumeInterval is the least time between two replays served to the same mirror
+	DefaultResumeInterval = time.Second
+
+	// FeedOrigin marks the packets published by a feed
+	FeedOrigin = "sync-feed"
+)
+
+var messageExpiryProperty = encoding.PropMessageExpiryInterval.String()
+
+// Publisher publishes the sync feed on the upstream broker
+type Publisher interface {
+	Publish(ctx context.Context, packet *hook.PublishPacket) error
+}
+
+// FeedConfig configures the upstream side of the sync feed
+type FeedConfig struct {
+	Publisher Publisher
+	// Retained persists the retained messages of the broker, the feed saves every change itself so the
+	// snapshot and the sequence of a change always agree
+	Retained *hook.RetainedStore
+	// Shadows is snapshotted for mirrors starting over, set its OnChange to ShadowChanged to feed its updates
+	Shadows *shadow.Manager
+	// Node stamps the retained messages saved through the hook
+	Node string
+	// LogSize bounds the changes kept for resuming mirrors, defaults to DefaultLogSize
+	LogSize int
+	// AuthorizeResume reports whether client may request the replay of the mirror replicaID, which can be a full
+	// snapshot of the retained messages and shadows. When nil a mirror is only served to the client whose ID is
+	// the replica ID
+	AuthorizeResume func(client *hook.Client, replicaID string) bool
+	// ResumeInterval is the least time between two replays served to a mirror through the hook, requests
+	// arriving sooner are coalesced into one. Defaults to DefaultResumeInterval
+	ResumeInterval time.Duration
+	// OnError receives the errors of live publishes and of replays served through the hook, mirrors detect the
+	// gap and resume on their own
+	OnError func(err error)
+}
+
+// FeedStats holds feed statistics
+type FeedStats struct {
+	Epoch     uint64
+	Seq       uint64
+	Logged    int
+	Replays   uint64
+	Snapshots uint64
+	// Coalesced counts the resume requests replaced by a later request of the same mirror
+	Coalesced uint64
+	// Denied counts the resume requests of unauthorized clients
+	Denied uint64
+	Errors uint64
+}
+
+// logEntry is a change kept for replay, suffix is the topic below the feed or replay prefix
+type logEntry struct {
+	suffix string
+	frame  Frame
+}
+
+// Feed numbers the retained and shadow changes of a broker and publishes them on the sync feed
+// Changes and replays are serialized, snapshots are taken without blocking changes and followed by the replay
+// of the changes made meanwhile, so a replay published in response to a resume request is followed on the
+// feed by exactly the changes it does not cover
+type Feed struct {
+	config FeedConfig
+	epoch  uint64
+
+	mu   sync.Mutex
+	seq  uint64
+	log  []logEntry
+	head int
+
+	resumeMu sync.Mutex
+	resuming map[string]*Checkpoint // replica ID -> request waiting for the replay in progress, nil when none
+
+	replays   atomic.Uint64
+	snapshots atomic.Uint64
+	coalesced atomic.Uint64
+	denied    atomic.Uint64
+	errors    atomic.Uint64
+}
+
+// NewFeed creates a feed with a new epoch
+func NewFeed(config FeedConfig) (*Feed, error) {
+	if config.Publisher == nil {
+		return nil, ErrNoPublisher
+	}
+	if config.Retained == nil {
+		return nil, ErrNoRetainedStore
+	}
+	if config.LogSize <= 0 {
+		config.LogSize = DefaultLogSize
+	}
+	if config.ResumeInterval <= 0 {
+		config.ResumeInterval = DefaultResumeInterval
+	}
+
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate feed epoch: %w", err)
+	}
+	return &Feed{
+		config:   config,
+		epoch:    binary.BigEndian.Uint64(b[:]) | 1,
+		log:      make([]logEntry, 0, min(config.LogSize, 1024)),
+		resuming: make(map[string]*Checkpoint),
+	}, nil
+}
+
+// Checkpoint returns the epoch and the sequence of the latest change
+func (f *Feed) Checkpoint() Checkpoint {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return Checkpoint{Epoch: f.epoch, Seq: f.seq}
+}
+
+// Retain saves a retained message and publishes the change, an empty payload clears the topic
+func (f *Feed) Retain(ctx context.Context, msg *hook.RetainedMessage) error {
+	if msg == nil {
+		return nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.config.Retained.Save(ctx, msg); err != nil {
+		return err
+	}
+	f.recordLocked(ctx, _retainedSuffix+msg.Topic, retainedFrame(msg))
+	return nil
+}
+
+// Expired clears an expired retained topic and publishes the deletion
+func (f *Feed) Expired(ctx context.Context, topicName string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.config.Retained.Delete(ctx, topicName); err != nil {
+		return err
+	}
+	f.recordLocked(ctx, _retainedSuffix+topicName, Frame{Timestamp: time.Now()})
+	return nil
+}
+
+// ShadowChanged publishes the change of a shadow document, doc is nil when it was deleted
+// Its signature matches shadow.ManagerConfig.OnChange
+func (f *Feed) ShadowChanged(clientID string, doc *shadow.Document) {
+	frame, err := shadowFrame(doc)
+	if err != nil {
+		f.fail(err)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.recordLocked(context.Background(), _shadowSuffix+clientID, frame)
+}
+
+// recordLocked numbers a change, keeps it for replay and publishes it on the live feed
+func (f *Feed) recordLocked(ctx context.Context, suffix string, frame Frame) {
+	f.seq++
+	frame.Epoch, frame.Seq = f.epoch, f.seq
+
+	entry := logEntry{suffix: suffix, frame: frame}
+	if len(f.log) < f.config.LogSize {
+		f.log = append(f.log, entry)
+	} else {
+		f.log[f.head] = entry
+		f.head = (f.head + 1) % len(f.log)
+	}
+
+	if err := f.publish(ctx, TopicPrefix+suffix, &frame); err != nil {
+		f.fail(err)
+	}
+}
+
+// Resume brings the mirror replicaID up to date from cp, on its replay topics
+// The changes after cp are replayed from the log when it still holds them, otherwise the mirror is told to
+// reset and receives a snapshot of every retained message and shadow followed by the changes made while the
+// snapshot was taken. Either way the replay ends with the checkpoint the mirror continues the live feed from
+func (f *Feed) Resume(ctx context.Context, replicaID string, cp Checkpoint) error {
+	if err := ValidateReplicaID(replicaID); err != nil {
+		return err
+	}
+	prefix := ReplayPrefix(replicaID)
+
+	snapshotted := false
+	for {
+		f.mu.Lock()
+		if f.coversLocked(cp) {
+			break
+		}
+		f.mu.Unlock()
+
+		var err error
+		if cp, err = f.snapshot(ctx, prefix); err != nil {
+			return err
+		}
+		f.snapshots.Add(1)
+		snapshotted = true
+	}
+	defer f.mu.Unlock()
+
+	skip := len(f.log) - int(f.seq-cp.Seq)
+	for i := skip; i < len(f.log); i++ {
+		entry := &f.log[(f.head+i)%len(f.log)]
+		if err := f.publish(ctx, prefix+entry.suffix, &entry.frame); err != nil {
+			return err
+		}
+	}
+	if !snapshotted {
+		f.replays.Add(1)
+	}
+
+	return f.publish(ctx, prefix+_endSuffix, &Frame{Epoch: f.epoch, Seq: f.seq})
+}
+
+// coversLocked reports whether the log holds every change after cp
+func (f *Feed) coversLocked(cp Checkpoint) bool {
+	return cp.Epoch == f.epoch && cp.Seq <= f.seq && f.seq-cp.Seq <= uint64(len(f.log))
+}
+
+// snapshot publishes a reset followed by the current state and returns the checkpoint it starts from
+// Changes made while it runs may or may not be included, replaying them from the checkpoint settles both
+func (f *Feed) snapshot(ctx context.Context, prefix string) (Checkpoint, error) {
+	cp := f.Checkpoint()
+	if err := f.publish(ctx, prefix+_resetSuffix, &Frame{Epoch: cp.Epoch, Seq: cp.Seq}); err != nil {
+		return cp, err
+	}
+
+	retained := f.config.Retained
+	topics, err := store.ScanKeys(ctx, retained.Store(), "", "", 0)
+	if err != nil {
+		return cp, err
+	}
+	for _, topicName := range topics {
+		msg, err := retained.Load(ctx, topicName)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return cp, err
+		}
+		frame := retainedFrame(msg)
+		frame.Epoch, frame.Seq = cp.Epoch, cp.Seq
+		if err := f.publish(ctx, prefix+_retainedSuffix+topicName, &frame); err != nil {
+			return cp, err
+		}
+	}
+
+	if f.config.Shadows == nil {
+		return cp, nil
+	}
+	docs, err := f.config.Shadows.List(ctx)
+	if err != nil {
+		return cp, err
+	}
+	for _, doc := range docs {
+		frame, err := shadowFrame(doc)
+		if err != nil {
+			return cp, err
+		}
+		frame.Epoch, frame.Seq = cp.Epoch, cp.Seq
+		if err := f.publish(ctx, prefix+_shadowSuffix+doc.ClientID, &frame); err != nil {
+			return cp, err
+		}
+	}
+	return cp, nil
+}
+
+// RequestResume serves a resume request of the mirror replicaID in the background. The replays of a mirror
+// are served one at a time and at most once per ResumeInterval, a request arriving meanwhile replaces the
+// one still waiting. Errors go to OnError
+func (f *Feed) RequestResume(replicaID string, cp Checkpoint) error {
+	if err := ValidateReplicaID(replicaID); err != nil {
+		return err
+	}
+
+	f.resumeMu.Lock()
+	defer f.resumeMu.Unlock()
+	waiting, busy := f.resuming[replicaID]
+	f.resuming[replicaID] = &cp
+	if waiting != nil {
+		f.coalesced.Add(1)
+	}
+	if !busy {
+		go f.serveResumes(replicaID)
+	}
+	return nil
+}
+
+// serveResumes replays the requests of a mirror until none is waiting after ResumeInterval
+func (f *Feed) serveResumes(replicaID string) {
+	for {
+		f.resumeMu.Lock()
+		cp := f.resuming[replicaID]
+		if cp == nil {
+			delete(f.resuming, replicaID)
+			f.resumeMu.Unlock()
+			return
+		}
+		f.resuming[replicaID] = nil
+		f.resumeMu.Unlock()
+
+		if err := f.Resume(context.Background(), replicaID, *cp); err != nil {
+			f.fail(fmt.Errorf("failed to resume %s: %w", replicaID, err))
+		}
+		time.Sleep(f.config.ResumeInterval)
+	}
+}
+
+// authorizeResume reports whether client may request the replay of replicaID
+func (f *Feed) authorizeResume(client *hook.Client, replicaID string) bool {
+	if f.config.AuthorizeResume != nil {
+		return f.config.AuthorizeResume(client, replicaID)
+	}
+	return client != nil && client.ID == replicaID
+}
+
+func (f *Feed) publish(ctx context.Context, topicName string, frame *Frame) error {
+	return f.config.Publisher.Publish(ctx, &hook.PublishPacket{
+		Topic:           topicName,
+		Payload:         AppendFrame(nil, frame),
+		QoS:             1,
+		ProtocolVersion: byte(encoding.ProtocolVersion50),
+		Created:         time.Now(),
+		Origin:          FeedOrigin,
+	})
+}
+
+func (f *Feed) fail(err error) {
+	f.errors.Add(1)
+	if f.config.OnError != nil {
+		f.config.OnError(err)
+	}
+}
+
+// Stats returns feed statistics
+func (f *Feed) Stats() FeedStats {
+	f.mu.Lock()
+	seq, logged := f.seq, len(f.log)
+	f.mu.Unlock()
+	return FeedStats{
+		Epoch:     f.epoch,
+		Seq:       seq,
+		Logged:    logged,
+		Replays:   f.replays.Load(),
+		Snapshots: f.snapshots.Load(),
+		Coalesced: f.coalesced.Load(),
+		Denied:    f.denied.Load(),
+		Errors:    f.errors.Load(),
+	}
+}
+
+// Hook returns a hook saving the retained messages of the broker through the feed and serving resume requests
+func (f *Feed) Hook() *FeedHook {
+	return &FeedHook{
+		Base: hook.NewHookBase("sync-feed"),
+		feed: f,
+	}
+}
+
+func retainedFrame(msg *hook.RetainedMessage) Frame {
+	expiry, _ := msg.Properties.Uint32(messageExpiryProperty)
+	return Frame{
+		Timestamp: msg.Timestamp,
+		QoS:       msg.QoS,
+		Expiry:    expiry,
+		Node:      msg.Node,
+		Body:      msg.Payload,
+	}
+}
+
+func shadowFrame(doc *shadow.Document) (Frame, error) {
+	if doc == nil {
+		return Frame{Timestamp: time.Now()}, nil
+	}
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return Frame{}, fmt.Errorf("failed to marshal shadow: %w", err)
+	}
+	return Frame{Timestamp: doc.UpdatedAt, Body: body}, nil
+}
+
+// FeedHook wires a feed into the broker, it replaces any other hook persisting retained messages
+type FeedHook struct {
+	*hook.Base
+	feed *Feed
+}
+
+// Provides indicates this hook handles retained changes and resume requests
+func (h *FeedHook) Provides(event hook.Event) bool {
+	switch event {
+	case hook.OnRetainMessage, hook.OnRetainedExpired, hook.OnPublish:
+		return true
+	default:
+		return false
+	}
+}
+
+// OnRetainMessage saves the retained message and publishes the change
+func (h *FeedHook) OnRetainMessage(_ *hook.Client, packet *hook.PublishPacket) error {
+	if packet == nil {
+		return nil
+	}
+	ts := packet.Created
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	return h.feed.Retain(context.Background(), &hook.RetainedMessage{
+		Topic:      packet.Topic,
+		Payload:    packet.Payload,
+		QoS:        packet.QoS,
+		Properties: packet.Properties,
+		Timestamp:  ts,
+		Node:       h.feed.config.Node,
+	})
+}
+
+// OnRetainedExpired publishes the deletion of an expired retained topic
+func (h *FeedHook) OnRetainedExpired(topicName string) error {
+	return h.feed.Expired(context.Background(), topicName)
+}
+
+// OnPublish serves the resume requests published by authorized mirrors on their resume topic, the replay is
+// published in the background and requests of other clients are rejected with ErrResumeDenied
+func (h *FeedHook) OnPublish(client *hook.Client, packet *hook.PublishPacket) error {
+	if packet == nil {
+		return nil
+	}
+	replicaID, ok := strings.CutPrefix(packet.Topic, _resumePrefix)
+	if !ok {
+		return nil
+	}
+	if !h.feed.authorizeResume(client, replicaID) {
+		h.feed.denied.Add(1)
+		return ErrResumeDenied
+	}
+	frame, err := ParseFrame(packet.Payload)
+	if err != nil {
+		return err
+	}
+	return h.feed.RequestResume(replicaID, Checkpoint{Epoch: frame.Epoch, Seq: frame.Seq})
+}
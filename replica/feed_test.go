@@ -0,0 +1,339 @@
+package replica
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/axmq/ax/hook"
+	"github.com/axmq/ax/shadow"
+	"github.com/axmq/ax/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recorder struct {
+	mu      sync.Mutex
+	packets []*hook.PublishPacket
+	err     error
+}
+
+func (r *recorder) Publish(_ context.Context, packet *hook.PublishPacket) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.packets = append(r.packets, packet)
+	return nil
+}
+
+// take returns and forgets the recorded packets
+func (r *recorder) take() []*hook.PublishPacket {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	packets := r.packets
+	r.packets = nil
+	return packets
+}
+
+func topics(packets []*hook.PublishPacket) []string {
+	names := make([]string, len(packets))
+	for i, p := range packets {
+		names[i] = p.Topic
+	}
+	return names
+}
+
+func frameOf(t *testing.T, packet *hook.PublishPacket) *Frame {
+	t.Helper()
+	f, err := ParseFrame(packet.Payload)
+	require.NoError(t, err)
+	return f
+}
+
+func newRetainedStore() *hook.RetainedStore {
+	return hook.NewRetainedStore(store.NewMemoryStore[*hook.RetainedMessage]())
+}
+
+func newTestFeed(t *testing.T, logSize int) (*Feed, *recorder, *hook.RetainedStore) {
+	t.Helper()
+	rec := &recorder{}
+	retained := newRetainedStore()
+	feed, err := NewFeed(FeedConfig{Publisher: rec, Retained: retained, LogSize: logSize})
+	require.NoError(t, err)
+	return feed, rec, retained
+}
+
+func TestNewFeed(t *testing.T) {
+	_, err := NewFeed(FeedConfig{Retained: newRetainedStore()})
+	assert.ErrorIs(t, err, ErrNoPublisher)
+	_, err = NewFeed(FeedConfig{Publisher: &recorder{}})
+	assert.ErrorIs(t, err, ErrNoRetainedStore)
+
+	a, _, _ := newTestFeed(t, 0)
+	b, _, _ := newTestFeed(t, 0)
+	assert.NotZero(t, a.Checkpoint().Epoch)
+	assert.NotEqual(t, a.Checkpoint().Epoch, b.Checkpoint().Epoch)
+	assert.Zero(t, a.Checkpoint().Seq)
+}
+
+func TestFeedLive(t *testing.T) {
+	feed, rec, retained := newTestFeed(t, 0)
+	ctx := context.Background()
+	ts := time.Now().Truncate(time.Second)
+
+	require.NoError(t, feed.Retain(ctx, &hook.RetainedMessage{
+		Topic:      "devices/1/state",
+		Payload:    []byte("on"),
+		QoS:        1,
+		Properties: hook.Properties{messageExpiryProperty: uint32(60)},
+		Timestamp:  ts,
+		Node:       "up",
+	}))
+	require.NoError(t, feed.Retain(ctx, &hook.RetainedMessage{Topic: "devices/2/state", Payload: []byte("off")}))
+	require.NoError(t, feed.Expired(ctx, "devices/2/state"))
+
+	packets := rec.take()
+	assert.Equal(t, []string{"$sync/retained/devices/1/state", "$sync/retained/devices/2/state", "$sync/retained/devices/2/state"}, topics(packets))
+	for i, p := range packets {
+		f := frameOf(t, p)
+		assert.Equal(t, feed.Checkpoint().Epoch, f.Epoch)
+		assert.Equal(t, uint64(i+1), f.Seq)
+		assert.Equal(t, byte(1), p.QoS)
+		assert.False(t, p.Retain)
+		assert.Equal(t, FeedOrigin, p.Origin)
+	}
+	first := frameOf(t, packets[0])
+	assert.Equal(t, []byte("on"), first.Body)
+	assert.Equal(t, uint32(60), first.Expiry)
+	assert.Equal(t, "up", first.Node)
+	assert.True(t, ts.Equal(first.Timestamp))
+	assert.Empty(t, frameOf(t, packets[2]).Body)
+
+	_, err := retained.Load(ctx, "devices/1/state")
+	require.NoError(t, err)
+	_, err = retained.Load(ctx, "devices/2/state")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+}
+
+func TestFeedPublishError(t *testing.T) {
+	var reported []error
+	rec := &recorder{err: errors.New("offline")}
+	feed, err := NewFeed(FeedConfig{
+		Publisher: rec,
+		Retained:  newRetainedStore(),
+		OnError:   func(err error) { reported = append(reported, err) },
+	})
+	require.NoError(t, err)
+
+	// the change is kept for replay even though the live publish failed
+	require.NoError(t, feed.Retain(context.Background(), &hook.RetainedMessage{Topic: "a", Payload: []byte("1")}))
+	assert.Len(t, reported, 1)
+	stats := feed.Stats()
+	assert.Equal(t, uint64(1), stats.Seq)
+	assert.Equal(t, 1, stats.Logged)
+	assert.Equal(t, uint64(1), stats.Errors)
+}
+
+func TestFeedResumeFromLog(t *testing.T) {
+	feed, rec, _ := newTestFeed(t, 0)
+	ctx := context.Background()
+
+	for _, topicName := range []string{"a", "b", "c"} {
+		require.NoError(t, feed.Retain(ctx, &hook.RetainedMessage{Topic: topicName, Payload: []byte(topicName)}))
+	}
+	rec.take()
+
+	cp := feed.Checkpoint()
+	require.NoError(t, feed.Resume(ctx, "edge", Checkpoint{Epoch: cp.Epoch, Seq: 1}))
+	packets := rec.take()
+	assert.Equal(t, []string{"$sync/replay/edge/retained/b", "$sync/replay/edge/retained/c", "$sync/replay/edge/end"}, topics(packets))
+	assert.Equal(t, uint64(2), frameOf(t, packets[0]).Seq)
+	assert.Equal(t, uint64(3), frameOf(t, packets[2]).Seq)
+
+	// an up to date mirror only gets the end marker
+	require.NoError(t, feed.Resume(ctx, "edge", cp))
+	assert.Equal(t, []string{"$sync/replay/edge/end"}, topics(rec.take()))
+
+	stats := feed.Stats()
+	assert.Equal(t, uint64(2), stats.Replays)
+	assert.Zero(t, stats.Snapshots)
+
+	assert.ErrorIs(t, feed.Resume(ctx, "edge/1", cp), ErrInvalidReplicaID)
+}
+
+func TestFeedResumeSnapshot(t *testing.T) {
+	rec := &recorder{}
+	shadows := shadow.NewManager(shadow.ManagerConfig{Store: store.NewMemoryStore[*shadow.Document]()})
+	feed, err := NewFeed(FeedConfig{Publisher: rec, Retained: newRetainedStore(), Shadows: shadows, LogSize: 2})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	for _, topicName := range []string{"a", "b", "c"} {
+		require.NoError(t, feed.Retain(ctx, &hook.RetainedMessage{Topic: topicName, Payload: []byte(topicName)}))
+	}
+	require.NoError(t, feed.Retain(ctx, &hook.RetainedMessage{Topic: "b"}))
+	_, err = shadows.Update(ctx, "dev1", &shadow.UpdateRequest{State: shadow.State{Desired: map[string]any{"on": true}}})
+	require.NoError(t, err)
+	rec.take()
+	cp := feed.Checkpoint()
+
+	for _, from := range []Checkpoint{
+		{Epoch: cp.Epoch, Seq: 1},     // no longer in the log
+		{Epoch: cp.Epoch + 1, Seq: 3}, // another epoch
+		{Epoch: cp.Epoch, Seq: 10},    // ahead of the feed
+	} {
+		require.NoError(t, feed.Resume(ctx, "edge", from))
+		packets := rec.take()
+		assert.Equal(t, []string{
+			"$sync/replay/edge/reset",
+			"$sync/replay/edge/retained/a",
+			"$sync/replay/edge/retained/c",
+			"$sync/replay/edge/shadow/dev1",
+			"$sync/replay/edge/end",
+		}, topics(packets))
+		for _, p := range packets {
+			assert.Equal(t, cp, Checkpoint{Epoch: frameOf(t, p).Epoch, Seq: frameOf(t, p).Seq})
+		}
+		var doc shadow.Document
+		require.NoError(t, json.Unmarshal(frameOf(t, packets[3]).Body, &doc))
+		assert.Equal(t, map[string]any{"on": true}, doc.Desired)
+	}
+	assert.Equal(t, uint64(3), feed.Stats().Snapshots)
+}
+
+func TestFeedHook(t *testing.T) {
+	feed, rec, retained := newTestFeed(t, 0)
+	h := feed.Hook()
+	assert.True(t, h.Provides(hook.OnRetainMessage))
+	assert.True(t, h.Provides(hook.OnRetainedExpired))
+	assert.True(t, h.Provides(hook.OnPublish))
+	assert.False(t, h.Provides(hook.OnConnect))
+
+	require.NoError(t, h.OnRetainMessage(nil, &hook.PublishPacket{Topic: "a", Payload: []byte("1"), Retain: true}))
+	msg, err := retained.Load(context.Background(), "a")
+	require.NoError(t, err)
+	assert.False(t, msg.Timestamp.IsZero())
+	require.NoError(t, h.OnRetainedExpired("a"))
+	assert.Len(t, rec.take(), 2)
+
+	require.NoError(t, h.OnPublish(nil, &hook.PublishPacket{Topic: "other", Payload: []byte("x")}))
+	assert.Empty(t, rec.take())
+	edge := &hook.Client{ID: "edge"}
+	assert.ErrorIs(t, h.OnPublish(edge, &hook.PublishPacket{Topic: ResumeTopic("edge"), Payload: []byte("x")}), ErrInvalidFrame)
+
+	// only the mirror itself may ask for its replay
+	resume := &hook.PublishPacket{Topic: ResumeTopic("edge"), Payload: AppendFrame(nil, &Frame{})}
+	assert.ErrorIs(t, h.OnPublish(nil, resume), ErrResumeDenied)
+	assert.ErrorIs(t, h.OnPublish(&hook.Client{ID: "dev1"}, resume), ErrResumeDenied)
+	assert.Equal(t, uint64(2), feed.Stats().Denied)
+	assert.Empty(t, rec.take())
+
+	require.NoError(t, h.OnPublish(edge, resume))
+	var packets []*hook.PublishPacket
+	require.Eventually(t, func() bool {
+		packets = append(packets, rec.take()...)
+		return len(packets) == 2
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"$sync/replay/edge/reset", "$sync/replay/edge/end"}, topics(packets))
+}
+
+func TestFeedHookCoalescesResumes(t *testing.T) {
+	rec := &recorder{}
+	feed, err := NewFeed(FeedConfig{
+		Publisher:       rec,
+		Retained:        newRetainedStore(),
+		ResumeInterval:  50 * time.Millisecond,
+		AuthorizeResume: func(client *hook.Client, _ string) bool { return client.Username == "mirror" },
+	})
+	require.NoError(t, err)
+	h := feed.Hook()
+	client := &hook.Client{ID: "edge-1", Username: "mirror"}
+	resume := &hook.PublishPacket{Topic: ResumeTopic("edge"), Payload: AppendFrame(nil, &Frame{})}
+
+	// the first request is served at once, the following ones wait for the interval and are served as one
+	require.NoError(t, h.OnPublish(client, resume))
+	require.Eventually(t, func() bool { return feed.Stats().Snapshots == 1 }, time.Second, time.Millisecond)
+	for range 4 {
+		require.NoError(t, h.OnPublish(client, resume))
+	}
+	assert.Equal(t, uint64(1), feed.Stats().Snapshots)
+	require.Eventually(t, func() bool { return feed.Stats().Snapshots == 2 }, time.Second, time.Millisecond)
+	time.Sleep(60 * time.Millisecond)
+	stats := feed.Stats()
+	assert.Equal(t, uint64(2), stats.Snapshots)
+	assert.Equal(t, uint64(3), stats.Coalesced)
+	assert.Len(t, rec.take(), 4)
+}
+
+// retainingPublisher saves a retained change while the first snapshot is published
+type retainingPublisher struct {
+	recorder
+	feed *Feed
+	once sync.Once
+}
+
+func (p *retainingPublisher) Publish(ctx context.Context, packet *hook.PublishPacket) error {
+	if strings.HasSuffix(packet.Topic, "/"+_resetSuffix) {
+		p.once.Do(func() {
+			_ = p.feed.Retain(ctx, &hook.RetainedMessage{Topic: "b", Payload: []byte("b")})
+		})
+	}
+	return p.recorder.Publish(ctx, packet)
+}
+
+func TestFeedSnapshotDoesNotBlockChanges(t *testing.T) {
+	pub := &retainingPublisher{}
+	feed, err := NewFeed(FeedConfig{Publisher: pub, Retained: newRetainedStore()})
+	require.NoError(t, err)
+	pub.feed = feed
+	ctx := context.Background()
+	require.NoError(t, feed.Retain(ctx, &hook.RetainedMessage{Topic: "a", Payload: []byte("a")}))
+	pub.take()
+
+	require.NoError(t, feed.Resume(ctx, "edge", Checkpoint{}))
+	var replay []*hook.PublishPacket
+	for _, p := range pub.take() {
+		if strings.HasPrefix(p.Topic, ReplayPrefix("edge")) {
+			replay = append(replay, p)
+		}
+	}
+	// the change made during the snapshot is replayed after it
+	assert.Equal(t, []string{
+		"$sync/replay/edge/reset",
+		"$sync/replay/edge/retained/a",
+		"$sync/replay/edge/retained/b",
+		"$sync/replay/edge/retained/b",
+		"$sync/replay/edge/end",
+	}, topics(replay))
+	assert.Equal(t, uint64(1), frameOf(t, replay[0]).Seq)
+	assert.Equal(t, uint64(2), frameOf(t, replay[3]).Seq)
+	assert.Equal(t, feed.Checkpoint().Seq, frameOf(t, replay[4]).Seq)
+}
+
+func TestFeedSnapshotKeepsExpiryFromPebble(t *testing.T) {
+	s, err := store.NewPebbleStore[*hook.RetainedMessage](store.PebbleStoreConfig{Path: t.TempDir()})
+	require.NoError(t, err)
+	defer s.Close()
+	rec := &recorder{}
+	feed, err := NewFeed(FeedConfig{Publisher: rec, Retained: hook.NewRetainedStore(s)})
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, feed.Retain(ctx, &hook.RetainedMessage{
+		Topic:      "a",
+		Payload:    []byte("1"),
+		Properties: hook.Properties{messageExpiryProperty: uint32(3600)},
+		Timestamp:  time.Now(),
+	}))
+	rec.take()
+
+	require.NoError(t, feed.Resume(ctx, "edge", Checkpoint{}))
+	packets := rec.take()
+	require.Equal(t, []string{"$sync/replay/edge/reset", "$sync/replay/edge/retained/a", "$sync/replay/edge/end"}, topics(packets))
+	assert.Equal(t, uint32(3600), frameOf(t, packets[1]).Expiry)
+}
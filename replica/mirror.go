@@ -0,0 +1,351 @@
+package replica
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/axmq/ax/hook"
+	"github.com/axmq/ax/shadow"
+	"github.com/axmq/ax/store"
+)
+
+const (
+	// DefaultResumeTimeout is how long a mirror waits for a replay before requesting it again
+	DefaultResumeTimeout = 10 * time.Second
+
+	_checkpointKeyPrefix = "replica:"
+)
+
+// MirrorConfig configures the downstream side of the sync feed
+type MirrorConfig struct {
+	// ReplicaID names the mirror upstream, it must be unique among the mirrors of a feed and a single topic level
+	ReplicaID string
+	// Retained receives the mirrored retained messages, a snapshot clears it first so it must be dedicated
+	Retained *hook.RetainedStore
+	// Shadows receives the mirrored shadow documents, shadows are not mirrored when nil
+	Shadows *shadow.Manager
+	// Checkpoints persists the position of the mirror after every change, so a restarted mirror resumes
+	// instead of copying everything again. It must be as durable as Retained and Shadows
+	Checkpoints store.Store[*Checkpoint]
+	// Publish sends resume requests to the upstream broker
+	Publish func(ctx context.Context, topic string, payload []byte) error
+	// ResumeTimeout is how long a replay may take before it is requested again, defaults to DefaultResumeTimeout
+	ResumeTimeout time.Duration
+	// OnError receives the errors of resume requests
+	OnError func(err error)
+}
+
+// MirrorStats holds mirror statistics
+type MirrorStats struct {
+	Checkpoint Checkpoint
+	Syncing    bool
+	Applied    uint64
+	Gaps       uint64
+	Resets     uint64
+	Requests   uint64
+	Ignored    uint64
+}
+
+// Mirror applies the sync feed of an upstream broker to local stores
+// It starts out syncing: live changes are ignored until the replay requested by Run ends, after which every
+// change must follow the previous one. A gap in the sequence or a new upstream epoch switches back to syncing
+type Mirror struct {
+	config MirrorConfig
+	replay string
+	resume chan struct{}
+
+	mu          sync.Mutex
+	cp          Checkpoint
+	syncing     bool
+	lastRequest time.Time
+
+	applied  atomic.Uint64
+	gaps     atomic.Uint64
+	resets   atomic.Uint64
+	requests atomic.Uint64
+	ignored  atomic.Uint64
+}
+
+// NewMirror creates a mirror, picking up the checkpoint persisted by a previous run
+func NewMirror(ctx context.Context, config MirrorConfig) (*Mirror, error) {
+	if err := ValidateReplicaID(config.ReplicaID); err != nil {
+		return nil, err
+	}
+	if config.Retained == nil {
+		return nil, ErrNoRetainedStore
+	}
+	if config.Publish == nil {
+		return nil, ErrNoPublisher
+	}
+	if config.ResumeTimeout <= 0 {
+		config.ResumeTimeout = DefaultResumeTimeout
+	}
+
+	m := &Mirror{
+		config:  config,
+		replay:  ReplayPrefix(config.ReplicaID),
+		resume:  make(chan struct{}, 1),
+		syncing: true,
+	}
+	if config.Checkpoints != nil {
+		cp, err := config.Checkpoints.Load(ctx, m.checkpointKey())
+		switch {
+		case err == nil:
+			m.cp = *cp
+		case !errors.Is(err, store.ErrNotFound):
+			return nil, fmt.Errorf("failed to load checkpoint: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// Filters returns the topic filters to subscribe to on the upstream broker
+func (m *Mirror) Filters() []string {
+	return []string{RetainedFeed + "#", ShadowFeed + "#", m.replay + "#"}
+}
+
+// Checkpoint returns the position of the mirror in the upstream feed
+func (m *Mirror) Checkpoint() Checkpoint {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.cp
+}
+
+// Handle applies a message received on one of the filters of the mirror
+// Messages of a feed must be handed over in the order they were received
+func (m *Mirror) Handle(ctx context.Context, topicName string, payload []byte) error {
+	frame, err := ParseFrame(payload)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if rest, ok := strings.CutPrefix(topicName, m.replay); ok {
+		return m.handleReplayLocked(ctx, rest, frame)
+	}
+	rest, ok := strings.CutPrefix(topicName, TopicPrefix)
+	if !ok {
+		return ErrInvalidTopic
+	}
+
+	switch {
+	case m.syncing:
+		// the replay in progress covers this change
+		m.ignored.Add(1)
+		return nil
+	case frame.Epoch == m.cp.Epoch && frame.Seq <= m.cp.Seq:
+		m.ignored.Add(1)
+		return nil
+	case frame.Epoch != m.cp.Epoch || frame.Seq != m.cp.Seq+1:
+		m.gaps.Add(1)
+		m.resyncLocked()
+		return nil
+	}
+
+	if err := m.applyLocked(ctx, rest, frame); err != nil {
+		m.resyncLocked()
+		return err
+	}
+	m.cp.Seq = frame.Seq
+	return m.saveCheckpointLocked(ctx)
+}
+
+func (m *Mirror) handleReplayLocked(ctx context.Context, rest string, frame *Frame) error {
+	if !m.syncing {
+		// left over from a request answered twice
+		m.ignored.Add(1)
+		return nil
+	}
+
+	switch rest {
+	case _resetSuffix:
+		m.resets.Add(1)
+		return m.clearLocked(ctx)
+	case _endSuffix:
+		m.cp = Checkpoint{Epoch: frame.Epoch, Seq: frame.Seq}
+		m.syncing = false
+		return m.saveCheckpointLocked(ctx)
+	default:
+		return m.applyLocked(ctx, rest, frame)
+	}
+}
+
+// applyLocked stores the retained message or shadow document carried by frame
+func (m *Mirror) applyLocked(ctx context.Context, rest string, frame *Frame) error {
+	if topicName, ok := strings.CutPrefix(rest, _retainedSuffix); ok {
+		msg := &hook.RetainedMessage{
+			Topic:     topicName,
+			Payload:   frame.Body,
+			QoS:       frame.QoS,
+			Timestamp: frame.Timestamp,
+			Node:      frame.Node,
+		}
+		if frame.Expiry > 0 {
+			msg.Properties = hook.Properties{messageExpiryProperty: frame.Expiry}
+		}
+		if err := m.config.Retained.Save(ctx, msg); err != nil {
+			return err
+		}
+		m.applied.Add(1)
+		return nil
+	}
+
+	clientID, ok := strings.CutPrefix(rest, _shadowSuffix)
+	if !ok || clientID == "" {
+		return ErrInvalidTopic
+	}
+	if m.config.Shadows == nil {
+		return nil
+	}
+	if len(frame.Body) == 0 {
+		if err := m.config.Shadows.Delete(ctx, clientID); err != nil && !errors.Is(err, shadow.ErrShadowNotFound) {
+			return err
+		}
+		m.applied.Add(1)
+		return nil
+	}
+	var doc shadow.Document
+	if err := json.Unmarshal(frame.Body, &doc); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidFrame, err)
+	}
+	doc.ClientID = clientID
+	if err := m.config.Shadows.Restore(ctx, &doc); err != nil {
+		return err
+	}
+	m.applied.Add(1)
+	return nil
+}
+
+// clearLocked drops the mirrored state before a snapshot
+func (m *Mirror) clearLocked(ctx context.Context) error {
+	if err := m.config.Retained.Clear(ctx); err != nil {
+		return err
+	}
+	if m.config.Shadows == nil {
+		return nil
+	}
+	docs, err := m.config.Shadows.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		if err := m.config.Shadows.Delete(ctx, doc.ClientID); err != nil && !errors.Is(err, shadow.ErrShadowNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Mirror) resyncLocked() {
+	m.syncing = true
+	select {
+	case m.resume <- struct{}{}:
+	default:
+	}
+}
+
+func (m *Mirror) saveCheckpointLocked(ctx context.Context) error {
+	if m.config.Checkpoints == nil {
+		return nil
+	}
+	cp := m.cp
+	return m.config.Checkpoints.Save(ctx, m.checkpointKey(), &cp)
+}
+
+func (m *Mirror) checkpointKey() string {
+	return _checkpointKeyPrefix + m.config.ReplicaID
+}
+
+// Run requests the initial replay and the replays after every gap until ctx is done
+// A request left unanswered for ResumeTimeout, e.g. sent while the upstream was unreachable, is repeated
+func (m *Mirror) Run(ctx context.Context) {
+	m.request(ctx)
+
+	ticker := time.NewTicker(max(m.config.ResumeTimeout/4, 10*time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.resume:
+			m.request(ctx)
+		case <-ticker.C:
+			m.mu.Lock()
+			due := m.syncing && time.Since(m.lastRequest) >= m.config.ResumeTimeout
+			m.mu.Unlock()
+			if due {
+				m.request(ctx)
+			}
+		}
+	}
+}
+
+// request publishes a resume request from the current checkpoint
+func (m *Mirror) request(ctx context.Context) {
+	m.mu.Lock()
+	cp := m.cp
+	m.lastRequest = time.Now()
+	m.mu.Unlock()
+
+	m.requests.Add(1)
+	payload := AppendFrame(nil, &Frame{Epoch: cp.Epoch, Seq: cp.Seq})
+	if err := m.config.Publish(ctx, ResumeTopic(m.config.ReplicaID), payload); err != nil && m.config.OnError != nil {
+		m.config.OnError(err)
+	}
+}
+
+// Stats returns mirror statistics
+func (m *Mirror) Stats() MirrorStats {
+	m.mu.Lock()
+	cp, syncing := m.cp, m.syncing
+	m.mu.Unlock()
+	return MirrorStats{
+		Checkpoint: cp,
+		Syncing:    syncing,
+		Applied:    m.applied.Load(),
+		Gaps:       m.gaps.Load(),
+		Resets:     m.resets.Load(),
+		Requests:   m.requests.Load(),
+		Ignored:    m.ignored.Load(),
+	}
+}
+
+// MirrorHook rejects local retained publishes and shadow updates and deletes on a mirror
+// Register it before the shadow hook so shadow requests are rejected before they are processed
+type MirrorHook struct {
+	*hook.Base
+}
+
+// NewMirrorHook creates a hook keeping the mirrored state read-only on the downstream broker
+func NewMirrorHook() *MirrorHook {
+	return &MirrorHook{Base: hook.NewHookBase("sync-mirror")}
+}
+
+// Provides indicates this hook guards retained messages and shadow requests
+func (h *MirrorHook) Provides(event hook.Event) bool {
+	return event == hook.OnRetainMessage || event == hook.OnPublish
+}
+
+// OnRetainMessage rejects retained publishes
+func (h *MirrorHook) OnRetainMessage(_ *hook.Client, _ *hook.PublishPacket) error {
+	return ErrReadOnly
+}
+
+// OnPublish rejects shadow updates and deletes, shadow gets are served from the mirror
+func (h *MirrorHook) OnPublish(_ *hook.Client, packet *hook.PublishPacket) error {
+	if packet == nil {
+		return nil
+	}
+	if _, op, err := shadow.ParseTopic(packet.Topic); err == nil && op != shadow.OperationGet {
+		return ErrReadOnly
+	}
+	return nil
+}
@@ -0,0 +1,372 @@
+package replica
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/axmq/ax/broker"
+	"github.com/axmq/ax/hook"
+	"github.com/axmq/ax/shadow"
+	"github.com/axmq/ax/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type resumeRequests struct {
+	mu       sync.Mutex
+	requests []Checkpoint
+}
+
+func (r *resumeRequests) publish(_ context.Context, topicName string, payload []byte) error {
+	if topicName != ResumeTopic("edge") {
+		return ErrInvalidTopic
+	}
+	f, err := ParseFrame(payload)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, Checkpoint{Epoch: f.Epoch, Seq: f.Seq})
+	return nil
+}
+
+func (r *resumeRequests) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.requests)
+}
+
+func newShadowManager(onChange func(string, *shadow.Document)) *shadow.Manager {
+	return shadow.NewManager(shadow.ManagerConfig{
+		Store:    store.NewMemoryStore[*shadow.Document](),
+		OnChange: onChange,
+	})
+}
+
+// deliver hands the recorded feed packets to the mirror
+func deliver(t *testing.T, m *Mirror, packets []*hook.PublishPacket) {
+	t.Helper()
+	for _, p := range packets {
+		require.NoError(t, m.Handle(context.Background(), p.Topic, p.Payload))
+	}
+}
+
+func TestNewMirror(t *testing.T) {
+	ctx := context.Background()
+	reqs := &resumeRequests{}
+
+	_, err := NewMirror(ctx, MirrorConfig{ReplicaID: "a/b", Retained: newRetainedStore(), Publish: reqs.publish})
+	assert.ErrorIs(t, err, ErrInvalidReplicaID)
+	_, err = NewMirror(ctx, MirrorConfig{ReplicaID: "edge", Publish: reqs.publish})
+	assert.ErrorIs(t, err, ErrNoRetainedStore)
+	_, err = NewMirror(ctx, MirrorConfig{ReplicaID: "edge", Retained: newRetainedStore()})
+	assert.ErrorIs(t, err, ErrNoPublisher)
+
+	m, err := NewMirror(ctx, MirrorConfig{ReplicaID: "edge", Retained: newRetainedStore(), Publish: reqs.publish})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"$sync/retained/#", "$sync/shadow/#", "$sync/replay/edge/#"}, m.Filters())
+	assert.True(t, m.Stats().Syncing)
+	assert.Zero(t, m.Checkpoint())
+}
+
+func TestMirrorSyncAndGap(t *testing.T) {
+	ctx := context.Background()
+	feed, rec, _ := newTestFeed(t, 0)
+	reqs := &resumeRequests{}
+	mirrored := newRetainedStore()
+	m, err := NewMirror(ctx, MirrorConfig{ReplicaID: "edge", Retained: mirrored, Publish: reqs.publish})
+	require.NoError(t, err)
+
+	require.NoError(t, feed.Retain(ctx, &hook.RetainedMessage{
+		Topic:      "a",
+		Payload:    []byte("1"),
+		Properties: hook.Properties{messageExpiryProperty: uint32(3600)},
+		Timestamp:  time.Now(),
+	}))
+	// live changes are ignored until the first replay ends
+	deliver(t, m, rec.take())
+	assert.Equal(t, uint64(1), m.Stats().Ignored)
+
+	require.NoError(t, feed.Resume(ctx, "edge", m.Checkpoint()))
+	deliver(t, m, rec.take())
+	assert.False(t, m.Stats().Syncing)
+	assert.Equal(t, uint64(1), m.Stats().Resets)
+	assert.Equal(t, feed.Checkpoint(), m.Checkpoint())
+	msg, err := mirrored.Load(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("1"), msg.Payload)
+	_, ok := msg.ExpiresAt()
+	assert.True(t, ok)
+
+	require.NoError(t, feed.Retain(ctx, &hook.RetainedMessage{Topic: "b", Payload: []byte("2")}))
+	deliver(t, m, rec.take())
+	assert.Equal(t, feed.Checkpoint(), m.Checkpoint())
+
+	// a redelivered change is skipped
+	require.NoError(t, feed.Resume(ctx, "other", Checkpoint{Epoch: feed.Checkpoint().Epoch, Seq: 1}))
+	replayed := rec.take()
+	require.NoError(t, m.Handle(ctx, RetainedFeed+"b", replayed[0].Payload))
+	assert.Equal(t, uint64(2), m.Stats().Ignored)
+
+	// losing a change switches back to syncing and asks for a replay
+	require.NoError(t, feed.Retain(ctx, &hook.RetainedMessage{Topic: "c", Payload: []byte("3")}))
+	rec.take()
+	require.NoError(t, feed.Retain(ctx, &hook.RetainedMessage{Topic: "a"}))
+	deliver(t, m, rec.take())
+	stats := m.Stats()
+	assert.True(t, stats.Syncing)
+	assert.Equal(t, uint64(1), stats.Gaps)
+	select {
+	case <-m.resume:
+	default:
+		t.Fatal("resume not requested")
+	}
+
+	require.NoError(t, feed.Resume(ctx, "edge", m.Checkpoint()))
+	deliver(t, m, rec.take())
+	// the missed changes are still in the log, so there is no reset
+	assert.False(t, m.Stats().Syncing)
+	assert.Equal(t, uint64(1), m.Stats().Resets)
+	assert.Equal(t, feed.Checkpoint(), m.Checkpoint())
+	_, err = mirrored.Load(ctx, "a")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	_, err = mirrored.Load(ctx, "c")
+	assert.NoError(t, err)
+
+	// a restarted upstream starts a new epoch, which takes a snapshot
+	restarted, rec2, _ := newTestFeed(t, 0)
+	require.NoError(t, restarted.Retain(ctx, &hook.RetainedMessage{Topic: "z", Payload: []byte("9")}))
+	deliver(t, m, rec2.take())
+	assert.Equal(t, uint64(2), m.Stats().Gaps)
+	require.NoError(t, restarted.Resume(ctx, "edge", m.Checkpoint()))
+	deliver(t, m, rec2.take())
+	assert.Equal(t, uint64(2), m.Stats().Resets)
+	assert.Equal(t, restarted.Checkpoint(), m.Checkpoint())
+	_, err = mirrored.Load(ctx, "c")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	_, err = mirrored.Load(ctx, "z")
+	assert.NoError(t, err)
+}
+
+func TestMirrorShadows(t *testing.T) {
+	ctx := context.Background()
+	rec := &recorder{}
+	var feed *Feed
+	upstream := newShadowManager(func(clientID string, doc *shadow.Document) { feed.ShadowChanged(clientID, doc) })
+	feed, err := NewFeed(FeedConfig{Publisher: rec, Retained: newRetainedStore(), Shadows: upstream})
+	require.NoError(t, err)
+
+	downstream := newShadowManager(nil)
+	m, err := NewMirror(ctx, MirrorConfig{
+		ReplicaID: "edge",
+		Retained:  newRetainedStore(),
+		Shadows:   downstream,
+		Publish:   (&resumeRequests{}).publish,
+	})
+	require.NoError(t, err)
+
+	_, err = downstream.Update(ctx, "stale", &shadow.UpdateRequest{State: shadow.State{Reported: map[string]any{"x": 1}}})
+	require.NoError(t, err)
+	_, err = upstream.Update(ctx, "dev1", &shadow.UpdateRequest{State: shadow.State{Desired: map[string]any{"on": true}}})
+	require.NoError(t, err)
+	rec.take()
+	require.NoError(t, feed.Resume(ctx, "edge", m.Checkpoint()))
+	deliver(t, m, rec.take())
+
+	_, err = downstream.Get(ctx, "stale")
+	assert.ErrorIs(t, err, shadow.ErrShadowNotFound)
+	doc, err := downstream.Get(ctx, "dev1")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), doc.Version)
+
+	_, err = upstream.Update(ctx, "dev1", &shadow.UpdateRequest{State: shadow.State{Reported: map[string]any{"on": true}}})
+	require.NoError(t, err)
+	deliver(t, m, rec.take())
+	doc, err = downstream.Get(ctx, "dev1")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), doc.Version)
+	assert.Equal(t, map[string]any{"on": true}, doc.Reported)
+
+	require.NoError(t, upstream.Delete(ctx, "dev1"))
+	deliver(t, m, rec.take())
+	_, err = downstream.Get(ctx, "dev1")
+	assert.ErrorIs(t, err, shadow.ErrShadowNotFound)
+	assert.Equal(t, feed.Checkpoint(), m.Checkpoint())
+}
+
+func TestMirrorCheckpoints(t *testing.T) {
+	ctx := context.Background()
+	feed, rec, _ := newTestFeed(t, 0)
+	checkpoints := store.NewMemoryStore[*Checkpoint]()
+	mirrored := newRetainedStore()
+	config := MirrorConfig{ReplicaID: "edge", Retained: mirrored, Checkpoints: checkpoints, Publish: (&resumeRequests{}).publish}
+
+	m, err := NewMirror(ctx, config)
+	require.NoError(t, err)
+	require.NoError(t, feed.Retain(ctx, &hook.RetainedMessage{Topic: "a", Payload: []byte("1")}))
+	require.NoError(t, feed.Resume(ctx, "edge", m.Checkpoint()))
+	require.NoError(t, feed.Retain(ctx, &hook.RetainedMessage{Topic: "b", Payload: []byte("2")}))
+	deliver(t, m, rec.take())
+	require.Equal(t, feed.Checkpoint(), m.Checkpoint())
+
+	// a restarted mirror resumes from its checkpoint and only gets the changes it missed
+	restarted, err := NewMirror(ctx, config)
+	require.NoError(t, err)
+	assert.Equal(t, m.Checkpoint(), restarted.Checkpoint())
+	require.NoError(t, feed.Retain(ctx, &hook.RetainedMessage{Topic: "c", Payload: []byte("3")}))
+	rec.take()
+	require.NoError(t, feed.Resume(ctx, "edge", restarted.Checkpoint()))
+	packets := rec.take()
+	assert.Equal(t, []string{"$sync/replay/edge/retained/c", "$sync/replay/edge/end"}, topics(packets))
+	deliver(t, restarted, packets)
+	assert.Zero(t, restarted.Stats().Resets)
+	assert.Equal(t, feed.Checkpoint(), restarted.Checkpoint())
+
+	failing := config
+	failing.Checkpoints = &failingStore{MemoryStore: store.NewMemoryStore[*Checkpoint]()}
+	_, err = NewMirror(ctx, failing)
+	assert.Error(t, err)
+}
+
+type failingStore struct {
+	*store.MemoryStore[*Checkpoint]
+}
+
+func (s *failingStore) Load(context.Context, string) (*Checkpoint, error) {
+	return nil, errors.New("disk failure")
+}
+
+func TestMirrorRun(t *testing.T) {
+	reqs := &resumeRequests{}
+	m, err := NewMirror(context.Background(), MirrorConfig{
+		ReplicaID:     "edge",
+		Retained:      newRetainedStore(),
+		Publish:       reqs.publish,
+		ResumeTimeout: 20 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.Run(ctx)
+		close(done)
+	}()
+
+	// an unanswered request is repeated
+	require.Eventually(t, func() bool { return reqs.count() >= 2 }, time.Second, 5*time.Millisecond)
+	cancel()
+	<-done
+	assert.GreaterOrEqual(t, m.Stats().Requests, uint64(2))
+}
+
+func TestMirrorHook(t *testing.T) {
+	h := NewMirrorHook()
+	assert.True(t, h.Provides(hook.OnRetainMessage))
+	assert.True(t, h.Provides(hook.OnPublish))
+	assert.False(t, h.Provides(hook.OnConnect))
+
+	assert.ErrorIs(t, h.OnRetainMessage(nil, &hook.PublishPacket{Topic: "a", Retain: true}), ErrReadOnly)
+	assert.ErrorIs(t, h.OnPublish(nil, &hook.PublishPacket{Topic: shadow.UpdateTopic("dev1")}), ErrReadOnly)
+	assert.ErrorIs(t, h.OnPublish(nil, &hook.PublishPacket{Topic: "$shadow/dev1/delete"}), ErrReadOnly)
+	assert.NoError(t, h.OnPublish(nil, &hook.PublishPacket{Topic: "$shadow/dev1/get"}))
+	assert.NoError(t, h.OnPublish(nil, &hook.PublishPacket{Topic: "devices/1"}))
+}
+
+type localPublisher struct {
+	client *broker.LocalClient
+}
+
+func (p localPublisher) Publish(ctx context.Context, packet *hook.PublishPacket) error {
+	return p.client.Publish(ctx, &broker.Message{Topic: packet.Topic, Payload: packet.Payload, QoS: packet.QoS})
+}
+
+func TestMirrorOverBroker(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	upstream, err := broker.New(broker.Config{})
+	require.NoError(t, err)
+	defer upstream.Close()
+
+	feedClient, err := upstream.Connect(broker.ConnectOptions{ClientID: "sync-feed"})
+	require.NoError(t, err)
+	var feed *Feed
+	shadows := newShadowManager(func(clientID string, doc *shadow.Document) { feed.ShadowChanged(clientID, doc) })
+	feed, err = NewFeed(FeedConfig{Publisher: localPublisher{feedClient}, Retained: newRetainedStore(), Shadows: shadows, Node: "up"})
+	require.NoError(t, err)
+	require.NoError(t, upstream.Hooks().Add(feed.Hook()))
+	require.NoError(t, upstream.Hooks().Add(shadow.NewHook(shadows)))
+
+	device, err := upstream.Connect(broker.ConnectOptions{ClientID: "dev1"})
+	require.NoError(t, err)
+	require.NoError(t, device.Publish(ctx, &broker.Message{Topic: "devices/1/state", Payload: []byte("on"), Retain: true}))
+	require.NoError(t, device.Publish(ctx, &broker.Message{
+		Topic:   shadow.UpdateTopic("dev1"),
+		Payload: []byte(`{"state":{"reported":{"temp":20}}}`),
+	}))
+
+	// the edge broker mirrors the upstream state and keeps it read-only
+	edge, err := broker.New(broker.Config{})
+	require.NoError(t, err)
+	defer edge.Close()
+	mirrored := newRetainedStore()
+	edgeShadows := newShadowManager(nil)
+	require.NoError(t, edge.Hooks().Add(NewMirrorHook()))
+	require.NoError(t, edge.Hooks().Add(shadow.NewHook(edgeShadows)))
+
+	var m *Mirror
+	link, err := upstream.Connect(broker.ConnectOptions{
+		ClientID: "edge",
+		OnMessage: func(msg *broker.Message) {
+			assert.NoError(t, m.Handle(ctx, msg.Topic, msg.Payload))
+		},
+	})
+	require.NoError(t, err)
+	m, err = NewMirror(ctx, MirrorConfig{
+		ReplicaID: "edge",
+		Retained:  mirrored,
+		Shadows:   edgeShadows,
+		Publish: func(ctx context.Context, topicName string, payload []byte) error {
+			return link.Publish(ctx, &broker.Message{Topic: topicName, Payload: payload, QoS: 1})
+		},
+	})
+	require.NoError(t, err)
+	for _, filter := range m.Filters() {
+		_, err := link.Subscribe(filter, 1)
+		require.NoError(t, err)
+	}
+	go m.Run(ctx)
+
+	require.Eventually(t, func() bool { return !m.Stats().Syncing }, time.Second, 5*time.Millisecond)
+	msg, err := mirrored.Load(ctx, "devices/1/state")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("on"), msg.Payload)
+	assert.Equal(t, "up", msg.Node)
+	doc, err := edgeShadows.Get(ctx, "dev1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"temp": float64(20)}, doc.Reported)
+
+	// live changes follow in order
+	require.NoError(t, device.Publish(ctx, &broker.Message{Topic: "devices/1/state", Payload: []byte("off"), Retain: true}))
+	require.NoError(t, device.Publish(ctx, &broker.Message{Topic: "devices/2/state", Payload: []byte("on"), Retain: true}))
+	require.NoError(t, device.Publish(ctx, &broker.Message{Topic: "$shadow/dev1/delete"}))
+	msg, err = mirrored.Load(ctx, "devices/1/state")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("off"), msg.Payload)
+	_, err = mirrored.Load(ctx, "devices/2/state")
+	require.NoError(t, err)
+	_, err = edgeShadows.Get(ctx, "dev1")
+	assert.ErrorIs(t, err, shadow.ErrShadowNotFound)
+	assert.Equal(t, feed.Checkpoint(), m.Checkpoint())
+	assert.Zero(t, m.Stats().Gaps)
+
+	local, err := edge.Connect(broker.ConnectOptions{ClientID: "local"})
+	require.NoError(t, err)
+	assert.ErrorIs(t, local.Publish(ctx, &broker.Message{Topic: "devices/1/state", Payload: []byte("x"), Retain: true}), ErrReadOnly)
+	assert.ErrorIs(t, local.Publish(ctx, &broker.Message{Topic: shadow.UpdateTopic("dev1"), Payload: []byte(`{}`)}), ErrReadOnly)
+}
@@ -0,0 +1,146 @@
+// Package replica keeps a read-only mirror of the retained messages and shadow documents of an upstream broker
+// over MQTT itself. The upstream Feed publishes every change on a compact sync feed numbered by sequence, a
+// downstream Mirror subscribes to it, applies the changes in order and resumes from its last sequence after a
+// gap or restart, falling back to a full snapshot when the upstream no longer holds the missing changes
+package replica
+
+import (
+	"encoding/binary"
+	"strings"
+	"time"
+)
+
+const (
+	// TopicPrefix is the reserved topic namespace of the sync feed
+	TopicPrefix = "$sync/"
+	// RetainedFeed prefixes the changes of retained topics, $sync/retained/<topic>
+	RetainedFeed = TopicPrefix + "retained/"
+	// ShadowFeed prefixes the changes of shadow documents, $sync/shadow/<clientID>
+	ShadowFeed = TopicPrefix + "shadow/"
+
+	_resumePrefix   = TopicPrefix + "resume/"
+	_replayPrefix   = TopicPrefix + "replay/"
+	_retainedSuffix = "retained/"
+	_shadowSuffix   = "shadow/"
+	_resetSuffix    = "reset"
+	_endSuffix      = "end"
+
+	_frameVersion = 1
+)
+
+// ResumeTopic returns the topic a mirror requests its replay on
+func ResumeTopic(replicaID string) string {
+	return _resumePrefix + replicaID
+}
+
+// ReplayPrefix returns the topic prefix the replay of a mirror is published under
+func ReplayPrefix(replicaID string) string {
+	return _replayPrefix + replicaID + "/"
+}
+
+// ValidateReplicaID checks that id can be used as a single topic level
+func ValidateReplicaID(id string) error {
+	if id == "" || strings.ContainsAny(id, "/+#") {
+		return ErrInvalidReplicaID
+	}
+	return nil
+}
+
+// Checkpoint is the position of a mirror in the feed of an upstream broker
+// Epoch changes every time the upstream feed starts, sequences of different epochs are not comparable
+type Checkpoint struct {
+	Epoch uint64 `json:"epoch"`
+	Seq   uint64 `json:"seq"`
+}
+
+// Frame is a record of the sync feed, an empty body deletes the topic or shadow it addresses
+type Frame struct {
+	Epoch     uint64
+	Seq       uint64
+	Timestamp time.Time
+	QoS       byte
+	// Expiry is the Message Expiry Interval of a retained message in seconds, counted from Timestamp
+	Expiry uint32
+	Node   string
+	Body   []byte
+}
+
+// AppendFrame appends the binary encoding of f to dst
+// The layout is a version byte, the QoS, uvarints for epoch and sequence, the timestamp in unix nanoseconds as
+// a varint, the expiry as a uvarint, the length prefixed node and the body up to the end of the payload
+func AppendFrame(dst []byte, f *Frame) []byte {
+	dst = append(dst, _frameVersion, f.QoS)
+	dst = binary.AppendUvarint(dst, f.Epoch)
+	dst = binary.AppendUvarint(dst, f.Seq)
+	var ts int64
+	if !f.Timestamp.IsZero() {
+		ts = f.Timestamp.UnixNano()
+	}
+	dst = binary.AppendVarint(dst, ts)
+	dst = binary.AppendUvarint(dst, uint64(f.Expiry))
+	dst = binary.AppendUvarint(dst, uint64(len(f.Node)))
+	dst = append(dst, f.Node...)
+	return append(dst, f.Body...)
+}
+
+// ParseFrame decodes a frame encoded by AppendFrame, the body aliases b
+func ParseFrame(b []byte) (*Frame, error) {
+	if len(b) < 2 || b[0] != _frameVersion || b[1] > 2 {
+		return nil, ErrInvalidFrame
+	}
+	r := frameReader{b: b[2:], ok: true}
+	f := &Frame{
+		QoS:   b[1],
+		Epoch: r.uvarint(),
+		Seq:   r.uvarint(),
+	}
+	if ts := r.varint(); ts != 0 {
+		f.Timestamp = time.Unix(0, ts)
+	}
+	expiry := r.uvarint()
+	node := r.bytes(r.uvarint())
+	if !r.ok || expiry > uint64(^uint32(0)) {
+		return nil, ErrInvalidFrame
+	}
+	f.Expiry = uint32(expiry)
+	f.Node = string(node)
+	if len(r.b) > 0 {
+		f.Body = r.b
+	}
+	return f, nil
+}
+
+// frameReader consumes the fields of a frame, ok turns false at the first truncated or malformed field
+type frameReader struct {
+	b  []byte
+	ok bool
+}
+
+func (r *frameReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.b)
+	return r.advance(v, n)
+}
+
+func (r *frameReader) varint() int64 {
+	v, n := binary.Varint(r.b)
+	return int64(r.advance(uint64(v), n))
+}
+
+func (r *frameReader) advance(v uint64, n int) uint64 {
+	if n <= 0 {
+		r.b, r.ok = nil, false
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *frameReader) bytes(n uint64) []byte {
+	if n > uint64(len(r.b)) {
+		r.b, r.ok = nil, false
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
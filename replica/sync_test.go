@@ -0,0 +1,51 @@
+package replica
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	frames := []*Frame{
+		{},
+		{Epoch: 1 << 62, Seq: 42, Timestamp: time.Unix(1700000000, 123), QoS: 2, Expiry: 60, Node: "node-a", Body: []byte(`{"on":true}`)},
+		{Epoch: 7, Seq: 1, Node: "n"},
+	}
+	for _, f := range frames {
+		parsed, err := ParseFrame(AppendFrame(nil, f))
+		require.NoError(t, err)
+		assert.Equal(t, f.Epoch, parsed.Epoch)
+		assert.Equal(t, f.Seq, parsed.Seq)
+		assert.True(t, f.Timestamp.Equal(parsed.Timestamp))
+		assert.Equal(t, f.QoS, parsed.QoS)
+		assert.Equal(t, f.Expiry, parsed.Expiry)
+		assert.Equal(t, f.Node, parsed.Node)
+		assert.Equal(t, f.Body, parsed.Body)
+	}
+}
+
+func TestParseFrameInvalid(t *testing.T) {
+	valid := AppendFrame(nil, &Frame{Epoch: 300, Seq: 300, Node: "node"})
+	for _, b := range [][]byte{
+		nil,
+		{2, 0},
+		{_frameVersion, 3},
+		valid[:4],
+		valid[:len(valid)-1],
+	} {
+		_, err := ParseFrame(b)
+		assert.ErrorIs(t, err, ErrInvalidFrame, "%v", b)
+	}
+}
+
+func TestValidateReplicaID(t *testing.T) {
+	assert.NoError(t, ValidateReplicaID("edge-1"))
+	for _, id := range []string{"", "edge/1", "edge+", "#"} {
+		assert.ErrorIs(t, ValidateReplicaID(id), ErrInvalidReplicaID)
+	}
+	assert.Equal(t, "$sync/resume/edge", ResumeTopic("edge"))
+	assert.Equal(t, "$sync/replay/edge/", ReplayPrefix("edge"))
+}
@@ -0,0 +1,11 @@
+package scheduler
+
+import "errors"
+
+var (
+	ErrInvalidCron = errors.New("invalid cron expression")
+	ErrInvalidJob  = errors.New("invalid scheduled job")
+	ErrJobExists   = errors.New("scheduled job already exists")
+	ErrJobNotFound = errors.New("scheduled job not found")
+	ErrNoPublisher = errors.New("scheduler has no publisher")
+)
@@ -0,0 +1,117 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/axmq/ax/hook"
+	"github.com/axmq/ax/topic"
+)
+
+// Placeholders replaced in the topic and payload of every publish
+const (
+	PlaceholderJob       = "{job}"       // job ID
+	PlaceholderSeq       = "{seq}"       // run number, starting at 1
+	PlaceholderTimestamp = "{timestamp}" // unix milliseconds of the run
+)
+
+// Job is a synthetic message published on an interval or a cron schedule, e.g. a heartbeat or a test probe
+type Job struct {
+	ID      string
+	Topic   string
+	Payload string
+	QoS     byte
+	Retain  bool
+	// Interval publishes every interval, measured from when the job was added
+	Interval time.Duration
+	// Cron publishes at the minutes matching the expression, see ParseCron
+	Cron string
+	// Disabled keeps the job registered without publishing
+	Disabled bool
+}
+
+// UnmarshalJSON reads a job of the form {"id":"hb","topic":"$SYS/heartbeat","payload":"{timestamp}","interval":"30s"}
+func (j *Job) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		ID       string `json:"id"`
+		Topic    string `json:"topic"`
+		Payload  string `json:"payload"`
+		QoS      byte   `json:"qos"`
+		Retain   bool   `json:"retain"`
+		Interval string `json:"interval"`
+		Cron     string `json:"cron"`
+		Disabled bool   `json:"disabled"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*j = Job{
+		ID:       raw.ID,
+		Topic:    raw.Topic,
+		Payload:  raw.Payload,
+		QoS:      raw.QoS,
+		Retain:   raw.Retain,
+		Cron:     raw.Cron,
+		Disabled: raw.Disabled,
+	}
+	if raw.Interval != "" {
+		interval, err := time.ParseDuration(raw.Interval)
+		if err != nil {
+			return err
+		}
+		j.Interval = interval
+	}
+	return nil
+}
+
+// Validate checks the job and returns its parsed cron schedule, nil for interval jobs
+func (j *Job) Validate() (*Schedule, error) {
+	if j.ID == "" {
+		return nil, fmt.Errorf("%w: id is empty", ErrInvalidJob)
+	}
+	if err := topic.ValidateTopic(j.render(j.Topic, 1, time.Time{})); err != nil {
+		return nil, fmt.Errorf("%w: %s: topic: %v", ErrInvalidJob, j.ID, err)
+	}
+	if j.QoS > 2 {
+		return nil, fmt.Errorf("%w: %s: qos %d", ErrInvalidJob, j.ID, j.QoS)
+	}
+
+	switch {
+	case j.Cron != "" && j.Interval != 0:
+		return nil, fmt.Errorf("%w: %s: set either interval or cron", ErrInvalidJob, j.ID)
+	case j.Cron != "":
+		return ParseCron(j.Cron)
+	case j.Interval > 0:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("%w: %s: interval or cron required", ErrInvalidJob, j.ID)
+	}
+}
+
+// render replaces the placeholders in s
+func (j *Job) render(s string, seq uint64, at time.Time) string {
+	if !strings.Contains(s, "{") {
+		return s
+	}
+	return strings.NewReplacer(
+		PlaceholderJob, j.ID,
+		PlaceholderSeq, strconv.FormatUint(seq, 10),
+		PlaceholderTimestamp, strconv.FormatInt(at.UnixMilli(), 10),
+	).Replace(s)
+}
+
+// packet builds the publish of run seq
+func (j *Job) packet(seq uint64, at time.Time) *hook.PublishPacket {
+	return &hook.PublishPacket{
+		Topic:   j.render(j.Topic, seq, at),
+		Payload: []byte(j.render(j.Payload, seq, at)),
+		QoS:     j.QoS,
+		Retain:  j.Retain,
+		Created: at,
+		Origin:  "scheduler/" + j.ID,
+	}
+}
@@ -0,0 +1,316 @@
+// Package scheduler publishes synthetic messages such as heartbeats and test probes on intervals or
+// cron schedules from inside the broker, replacing external cron jobs driving an MQTT client
+//
+// Jobs are managed at runtime through Add, Update, Remove, Pause, Resume and Trigger, which back the
+// admin API and check admin.PermissionConfigWrite once a guard is set, and Jobs reports when each job
+// last ran, when it runs next and whether publishing failed
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/axmq/ax/admin"
+	"github.com/axmq/ax/hook"
+)
+
+// Publisher injects a message into the broker as if a client had published it
+type Publisher interface {
+	Publish(ctx context.Context, packet *hook.PublishPacket) error
+}
+
+// Status reports the state of a scheduled job
+type Status struct {
+	Job       Job
+	Paused    bool
+	NextRun   time.Time
+	LastRun   time.Time
+	Runs      uint64
+	Failures  uint64
+	LastError string
+}
+
+type entry struct {
+	job      Job
+	schedule *Schedule
+	status   Status
+}
+
+// next returns the first run of the entry after t
+func (e *entry) next(t time.Time) time.Time {
+	if e.schedule != nil {
+		return e.schedule.Next(t)
+	}
+	return t.Add(e.job.Interval)
+}
+
+// Scheduler runs jobs until the context passed to Run is done
+type Scheduler struct {
+	publisher Publisher
+	guard     *admin.Guard
+	now       func() time.Time
+
+	mu   sync.Mutex
+	jobs map[string]*entry
+	wake chan struct{}
+}
+
+// New creates a scheduler publishing through publisher
+func New(publisher Publisher) *Scheduler {
+	return &Scheduler{
+		publisher: publisher,
+		now:       time.Now,
+		jobs:      make(map[string]*entry),
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// SetGuard makes the job management operations check the permissions of the principal carried by their context
+func (s *Scheduler) SetGuard(guard *admin.Guard) {
+	s.guard = guard
+}
+
+// Add registers a job, its first run is one interval or the next matching cron minute from now
+func (s *Scheduler) Add(ctx context.Context, job Job) error {
+	if err := s.guard.Authorize(ctx, admin.PermissionConfigWrite); err != nil {
+		return err
+	}
+	schedule, err := job.Validate()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if _, exists := s.jobs[job.ID]; exists {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrJobExists, job.ID)
+	}
+	e := &entry{job: job, schedule: schedule}
+	e.status.Paused = job.Disabled
+	e.status.NextRun = e.next(s.now())
+	s.jobs[job.ID] = e
+	s.mu.Unlock()
+
+	s.notify()
+	return nil
+}
+
+// Update replaces a job keeping its counters, the schedule restarts from now
+func (s *Scheduler) Update(ctx context.Context, job Job) error {
+	if err := s.guard.Authorize(ctx, admin.PermissionConfigWrite); err != nil {
+		return err
+	}
+	schedule, err := job.Validate()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	e, ok := s.jobs[job.ID]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrJobNotFound, job.ID)
+	}
+	e.job, e.schedule = job, schedule
+	e.status.Paused = job.Disabled
+	e.status.NextRun = e.next(s.now())
+	s.mu.Unlock()
+
+	s.notify()
+	return nil
+}
+
+// Remove unregisters a job
+func (s *Scheduler) Remove(ctx context.Context, id string) error {
+	if err := s.guard.Authorize(ctx, admin.PermissionConfigWrite); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.jobs[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	delete(s.jobs, id)
+	return nil
+}
+
+// Pause stops a job from publishing until Resume is called
+func (s *Scheduler) Pause(ctx context.Context, id string) error {
+	return s.setPaused(ctx, id, true)
+}
+
+// Resume lets a paused job publish again, its schedule restarts from now
+func (s *Scheduler) Resume(ctx context.Context, id string) error {
+	return s.setPaused(ctx, id, false)
+}
+
+func (s *Scheduler) setPaused(ctx context.Context, id string, paused bool) error {
+	if err := s.guard.Authorize(ctx, admin.PermissionConfigWrite); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	e, ok := s.jobs[id]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	if e.status.Paused && !paused {
+		e.status.NextRun = e.next(s.now())
+	}
+	e.status.Paused = paused
+	s.mu.Unlock()
+
+	s.notify()
+	return nil
+}
+
+// Trigger publishes a job immediately, paused jobs included, without changing its schedule
+func (s *Scheduler) Trigger(ctx context.Context, id string) error {
+	if err := s.guard.Authorize(ctx, admin.PermissionConfigWrite); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	e, ok := s.jobs[id]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	job := e.job
+	e.status.Runs++
+	seq := e.status.Runs
+	s.mu.Unlock()
+
+	return s.publish(ctx, id, &job, seq)
+}
+
+// Job returns the status of a job
+func (s *Scheduler) Job(id string) (Status, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.jobs[id]
+	if !ok {
+		return Status{}, false
+	}
+	status := e.status
+	status.Job = e.job
+	return status, true
+}
+
+// Jobs returns the status of every job ordered by ID
+func (s *Scheduler) Jobs() []Status {
+	s.mu.Lock()
+	statuses := make([]Status, 0, len(s.jobs))
+	for _, e := range s.jobs {
+		status := e.status
+		status.Job = e.job
+		statuses = append(statuses, status)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Job.ID < statuses[j].Job.ID
+	})
+	return statuses
+}
+
+// Run publishes due jobs until ctx is done
+func (s *Scheduler) Run(ctx context.Context) error {
+	if s.publisher == nil {
+		return ErrNoPublisher
+	}
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		s.runDue(ctx, s.now())
+
+		wait := time.Hour
+		if next, ok := s.nextRun(); ok {
+			wait = max(next.Sub(s.now()), 0)
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// due is a job run picked under the lock and published outside it
+type due struct {
+	id  string
+	job Job
+	seq uint64
+}
+
+// runDue publishes every job whose next run is not after now
+func (s *Scheduler) runDue(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	var runs []due
+	for id, e := range s.jobs {
+		if e.status.Paused || e.status.NextRun.IsZero() || e.status.NextRun.After(now) {
+			continue
+		}
+		e.status.Runs++
+		runs = append(runs, due{id: id, job: e.job, seq: e.status.Runs})
+		// Runs missed while the broker was busy are skipped rather than published in a burst
+		e.status.NextRun = e.next(now)
+	}
+	s.mu.Unlock()
+
+	for i := range runs {
+		_ = s.publish(ctx, runs[i].id, &runs[i].job, runs[i].seq)
+	}
+}
+
+func (s *Scheduler) publish(ctx context.Context, id string, job *Job, seq uint64) error {
+	if s.publisher == nil {
+		return ErrNoPublisher
+	}
+	at := s.now()
+	err := s.publisher.Publish(ctx, job.packet(seq, at))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.jobs[id]; ok {
+		e.status.LastRun = at
+		if err != nil {
+			e.status.Failures++
+			e.status.LastError = err.Error()
+		} else {
+			e.status.LastError = ""
+		}
+	}
+	return err
+}
+
+// nextRun returns the earliest next run of an active job
+func (s *Scheduler) nextRun() (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var next time.Time
+	for _, e := range s.jobs {
+		if e.status.Paused || e.status.NextRun.IsZero() {
+			continue
+		}
+		if next.IsZero() || e.status.NextRun.Before(next) {
+			next = e.status.NextRun
+		}
+	}
+	return next, !next.IsZero()
+}
+
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
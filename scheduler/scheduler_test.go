@@ -0,0 +1,206 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/axmq/ax/admin"
+	"github.com/axmq/ax/hook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingPublisher struct {
+	mu      sync.Mutex
+	packets []*hook.PublishPacket
+	err     error
+}
+
+func (p *recordingPublisher) Publish(_ context.Context, packet *hook.PublishPacket) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	p.packets = append(p.packets, packet)
+	return nil
+}
+
+func (p *recordingPublisher) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.packets)
+}
+
+func newTestScheduler(pub Publisher, now *time.Time) *Scheduler {
+	s := New(pub)
+	s.now = func() time.Time { return *now }
+	return s
+}
+
+func TestSchedulerRunsDueJobs(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	pub := &recordingPublisher{}
+	s := newTestScheduler(pub, &now)
+	ctx := context.Background()
+
+	require.NoError(t, s.Add(ctx, Job{ID: "hb", Topic: "$SYS/heartbeat/{job}", Payload: `{"seq":{seq},"ts":{timestamp}}`, QoS: 1, Interval: time.Minute}))
+	require.NoError(t, s.Add(ctx, Job{ID: "probe", Topic: "probe", Cron: "*/5 * * * *"}))
+
+	s.runDue(context.Background(), now)
+	assert.Zero(t, pub.count())
+
+	now = now.Add(time.Minute)
+	s.runDue(context.Background(), now)
+	require.Equal(t, 1, pub.count())
+	packet := pub.packets[0]
+	assert.Equal(t, "$SYS/heartbeat/hb", packet.Topic)
+	assert.Equal(t, `{"seq":1,"ts":1772366460000}`, string(packet.Payload))
+	assert.Equal(t, byte(1), packet.QoS)
+	assert.Equal(t, "scheduler/hb", packet.Origin)
+
+	now = now.Add(4 * time.Minute)
+	s.runDue(context.Background(), now)
+	assert.Equal(t, 3, pub.count())
+
+	status, ok := s.Job("hb")
+	require.True(t, ok)
+	assert.Equal(t, uint64(2), status.Runs)
+	assert.Equal(t, now, status.LastRun)
+	assert.Equal(t, now.Add(time.Minute), status.NextRun)
+	assert.Equal(t, "hb", status.Job.ID)
+}
+
+func TestSchedulerManageJobs(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	pub := &recordingPublisher{}
+	s := newTestScheduler(pub, &now)
+	ctx := context.Background()
+
+	job := Job{ID: "hb", Topic: "hb", Interval: time.Minute}
+	require.NoError(t, s.Add(ctx, job))
+	assert.ErrorIs(t, s.Add(ctx, job), ErrJobExists)
+
+	require.NoError(t, s.Pause(ctx, "hb"))
+	now = now.Add(time.Hour)
+	s.runDue(context.Background(), now)
+	assert.Zero(t, pub.count())
+
+	require.NoError(t, s.Trigger(context.Background(), "hb"))
+	assert.Equal(t, 1, pub.count())
+
+	require.NoError(t, s.Resume(ctx, "hb"))
+	status, _ := s.Job("hb")
+	assert.Equal(t, now.Add(time.Minute), status.NextRun)
+
+	job.Interval = time.Hour
+	require.NoError(t, s.Update(ctx, job))
+	status, _ = s.Job("hb")
+	assert.Equal(t, now.Add(time.Hour), status.NextRun)
+	assert.Equal(t, uint64(1), status.Runs)
+
+	require.NoError(t, s.Add(ctx, Job{ID: "a", Topic: "a", Cron: "@daily"}))
+	statuses := s.Jobs()
+	require.Len(t, statuses, 2)
+	assert.Equal(t, "a", statuses[0].Job.ID)
+
+	require.NoError(t, s.Remove(ctx, "hb"))
+	assert.ErrorIs(t, s.Remove(ctx, "hb"), ErrJobNotFound)
+	assert.ErrorIs(t, s.Pause(ctx, "hb"), ErrJobNotFound)
+	assert.ErrorIs(t, s.Update(ctx, job), ErrJobNotFound)
+	assert.ErrorIs(t, s.Trigger(context.Background(), "hb"), ErrJobNotFound)
+}
+
+func TestSchedulerGuard(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	pub := &recordingPublisher{}
+	s := newTestScheduler(pub, &now)
+	guard, err := admin.NewGuard(admin.GuardConfig{Authenticator: admin.Chain()})
+	require.NoError(t, err)
+	s.SetGuard(guard)
+	job := Job{ID: "hb", Topic: "hb", Interval: time.Minute}
+
+	assert.ErrorIs(t, s.Add(context.Background(), job), admin.ErrNoCredentials)
+	viewer := admin.WithPrincipal(context.Background(), &admin.Principal{Name: "grafana", Roles: []string{admin.RoleViewer}})
+	assert.ErrorIs(t, s.Add(viewer, job), admin.ErrForbidden)
+	assert.Empty(t, s.Jobs())
+
+	ctx := admin.WithPrincipal(context.Background(), &admin.Principal{Name: "ops", Roles: []string{admin.RoleAdmin}})
+	require.NoError(t, s.Add(ctx, job))
+	assert.ErrorIs(t, s.Pause(viewer, "hb"), admin.ErrForbidden)
+	assert.ErrorIs(t, s.Update(viewer, job), admin.ErrForbidden)
+	assert.ErrorIs(t, s.Trigger(viewer, "hb"), admin.ErrForbidden)
+	assert.ErrorIs(t, s.Remove(viewer, "hb"), admin.ErrForbidden)
+	assert.Zero(t, pub.count())
+	require.NoError(t, s.Trigger(ctx, "hb"))
+	require.NoError(t, s.Remove(ctx, "hb"))
+}
+
+func TestSchedulerRecordsFailures(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	pub := &recordingPublisher{err: errors.New("broker busy")}
+	s := newTestScheduler(pub, &now)
+	ctx := context.Background()
+
+	require.NoError(t, s.Add(ctx, Job{ID: "hb", Topic: "hb", Interval: time.Second}))
+	now = now.Add(time.Second)
+	s.runDue(context.Background(), now)
+
+	status, _ := s.Job("hb")
+	assert.Equal(t, uint64(1), status.Failures)
+	assert.Equal(t, "broker busy", status.LastError)
+}
+
+func TestJobValidate(t *testing.T) {
+	invalid := []Job{
+		{Topic: "a", Interval: time.Second},
+		{ID: "j", Topic: "a/+", Interval: time.Second},
+		{ID: "j", Topic: "a", QoS: 3, Interval: time.Second},
+		{ID: "j", Topic: "a"},
+		{ID: "j", Topic: "a", Interval: time.Second, Cron: "@daily"},
+	}
+	for _, job := range invalid {
+		_, err := job.Validate()
+		assert.ErrorIs(t, err, ErrInvalidJob, "%+v", job)
+	}
+
+	_, err := (&Job{ID: "j", Topic: "a", Cron: "bad"}).Validate()
+	assert.ErrorIs(t, err, ErrInvalidCron)
+}
+
+func TestJobUnmarshalJSON(t *testing.T) {
+	var jobs []Job
+	require.NoError(t, json.Unmarshal([]byte(`[
+		{"id":"hb","topic":"$SYS/hb","payload":"{timestamp}","qos":1,"interval":"30s"},
+		{"id":"probe","topic":"probe","cron":"*/5 * * * *","retain":true,"disabled":true}
+	]`), &jobs))
+	require.Len(t, jobs, 2)
+	assert.Equal(t, 30*time.Second, jobs[0].Interval)
+	assert.Equal(t, byte(1), jobs[0].QoS)
+	assert.Equal(t, "*/5 * * * *", jobs[1].Cron)
+	assert.True(t, jobs[1].Retain)
+	assert.True(t, jobs[1].Disabled)
+
+	var job Job
+	assert.Error(t, json.Unmarshal([]byte(`{"id":"x","interval":"soon"}`), &job))
+}
+
+func TestSchedulerRun(t *testing.T) {
+	pub := &recordingPublisher{}
+	s := New(pub)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+
+	require.NoError(t, s.Add(ctx, Job{ID: "hb", Topic: "hb", Interval: 10 * time.Millisecond}))
+	assert.Eventually(t, func() bool { return pub.count() >= 2 }, time.Second, 5*time.Millisecond)
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	assert.ErrorIs(t, New(nil).Run(context.Background()), ErrNoPublisher)
+}
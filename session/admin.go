@@ -0,0 +1,110 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/axmq/ax/admin"
+	"github.com/axmq/ax/store"
+)
+
+// Admin answers the session queries of the admin API, each needs admin.PermissionMetricsRead once a guard is set
+type Admin struct {
+	manager *Manager
+	guard   *admin.Guard
+}
+
+// NewAdmin creates the admin queries of manager
+func NewAdmin(manager *Manager) *Admin {
+	return &Admin{manager: manager}
+}
+
+// SetGuard makes the queries check the permissions of the principal carried by their context
+func (a *Admin) SetGuard(guard *admin.Guard) {
+	a.guard = guard
+}
+
+// FindByMetadata returns the sessions whose metadata contains every key/value pair of selector
+func (a *Admin) FindByMetadata(ctx context.Context, selector map[string]string) ([]*Session, error) {
+	if err := a.guard.Authorize(ctx, admin.PermissionMetricsRead); err != nil {
+		return nil, err
+	}
+	return a.manager.FindSessionsByMetadata(ctx, selector)
+}
+
+// Stats returns the traffic counters of a session
+func (a *Admin) Stats(ctx context.Context, clientID string) (StatsSnapshot, error) {
+	if err := a.guard.Authorize(ctx, admin.PermissionMetricsRead); err != nil {
+		return StatsSnapshot{}, err
+	}
+	return a.manager.GetSessionStats(ctx, clientID)
+}
+
+// AggregateStats returns the sum of the traffic counters of the active sessions
+func (a *Admin) AggregateStats(ctx context.Context) (StatsSnapshot, error) {
+	if err := a.guard.Authorize(ctx, admin.PermissionMetricsRead); err != nil {
+		return StatsSnapshot{}, err
+	}
+	return a.manager.AggregateStats(), nil
+}
+
+// SessionInfo describes a session found by Handler
+type SessionInfo struct {
+	ClientID string            `json:"client_id"`
+	Active   bool              `json:"active"`
+	Listener string            `json:"listener,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Handler serves the queries over HTTP, each request needs admin.PermissionMetricsRead once a guard is set
+//
+//	GET /?key=value   lists the sessions whose metadata holds every key/value pair of the query
+//	GET /stats        returns the traffic counters summed over the active sessions
+//	GET /{id}/stats   returns the traffic counters of one session, 404 Not Found for an unknown session
+func (a *Admin) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		selector := make(map[string]string)
+		for key, values := range r.URL.Query() {
+			selector[key] = values[0]
+		}
+		sessions, err := a.manager.FindSessionsByMetadata(r.Context(), selector)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		infos := make([]SessionInfo, len(sessions))
+		for i, session := range sessions {
+			infos[i] = SessionInfo{
+				ClientID: session.GetClientID(),
+				Active:   session.GetState() == StateActive,
+				Listener: session.GetConnection().Listener,
+				Metadata: session.GetAllMetadata(),
+			}
+		}
+		writeJSON(w, infos)
+	})
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, a.manager.AggregateStats())
+	})
+	mux.HandleFunc("GET /{id}/stats", func(w http.ResponseWriter, r *http.Request) {
+		stats, err := a.manager.GetSessionStats(r.Context(), r.PathValue("id"))
+		switch {
+		case errors.Is(err, store.ErrNotFound) || errors.Is(err, ErrSessionNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, stats)
+	})
+	return a.guard.Require(admin.PermissionMetricsRead, mux)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
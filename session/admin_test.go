@@ -0,0 +1,88 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/axmq/ax/admin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminGuard(t *testing.T) {
+	m, _ := newConsistencyFixture(t)
+	ctx := context.Background()
+	s, _, err := m.CreateSession(ctx, "device1", false, 3600, 5)
+	require.NoError(t, err)
+	s.SetMetadata("fleet", "north")
+
+	guard, err := admin.NewGuard(admin.GuardConfig{Authenticator: admin.Chain()})
+	require.NoError(t, err)
+	a := NewAdmin(m)
+	a.SetGuard(guard)
+
+	_, err = a.FindByMetadata(ctx, map[string]string{"fleet": "north"})
+	assert.ErrorIs(t, err, admin.ErrNoCredentials)
+	_, err = a.AggregateStats(admin.WithPrincipal(ctx, &admin.Principal{Name: "anon"}))
+	assert.ErrorIs(t, err, admin.ErrForbidden)
+
+	viewer := admin.WithPrincipal(ctx, &admin.Principal{Name: "grafana", Roles: []string{admin.RoleViewer}})
+	found, err := a.FindByMetadata(viewer, map[string]string{"fleet": "north"})
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, "device1", found[0].ClientID)
+	_, err = a.Stats(viewer, "device1")
+	assert.NoError(t, err)
+	_, err = a.AggregateStats(viewer)
+	assert.NoError(t, err)
+}
+
+func TestAdminHandler(t *testing.T) {
+	m, _ := newConsistencyFixture(t)
+	ctx := context.Background()
+	_, _, err := m.CreateSession(ctx, "device1", false, 3600, 5)
+	require.NoError(t, err)
+	require.NoError(t, m.SetSessionMetadata(ctx, "device1", map[string]string{"fleet": "north"}))
+	_, _, err = m.CreateSession(ctx, "device2", false, 3600, 5)
+	require.NoError(t, err)
+
+	guard, err := admin.NewGuard(admin.GuardConfig{Authenticator: admin.NewTokenAuthenticator(
+		admin.Token{Name: "grafana", Roles: []string{admin.RoleViewer}, Secret: "view"},
+	)})
+	require.NoError(t, err)
+	a := NewAdmin(m)
+	a.SetGuard(guard)
+	server := httptest.NewServer(a.Handler())
+	defer server.Close()
+
+	get := func(path, token string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, get("/?fleet=north", "").StatusCode)
+
+	resp := get("/?fleet=north", "view")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var sessions []SessionInfo
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&sessions))
+	require.Len(t, sessions, 1)
+	assert.Equal(t, "device1", sessions[0].ClientID)
+	assert.Equal(t, map[string]string{"fleet": "north"}, sessions[0].Metadata)
+
+	resp = get("/device1/stats", "view")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = get("/stats", "view")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, http.StatusNotFound, get("/unknown/stats", "view").StatusCode)
+}
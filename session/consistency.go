@@ -0,0 +1,395 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/axmq/ax/admin"
+	"github.com/axmq/ax/store"
+	"github.com/axmq/ax/topic"
+)
+
+// DriftKind classifies a discrepancy found by the consistency checker
+type DriftKind byte
+
+const (
+	// DriftMissingRoute is a session subscription the router does not deliver to
+	DriftMissingRoute DriftKind = iota + 1
+	// DriftOrphanRoute is a router subscription without a session subscription behind it
+	DriftOrphanRoute
+	// DriftRouteMismatch is a router subscription whose options differ from the session subscription
+	DriftRouteMismatch
+	// DriftStaleActive is a session held active although its client has no live connection
+	DriftStaleActive
+	// DriftGhostConnection is a live connection whose client has no active session
+	DriftGhostConnection
+
+	driftKinds = int(DriftGhostConnection) + 1
+)
+
+// String returns the string representation of the drift kind
+func (k DriftKind) String() string {
+	switch k {
+	case DriftMissingRoute:
+		return "missing_route"
+	case DriftOrphanRoute:
+		return "orphan_route"
+	case DriftRouteMismatch:
+		return "route_mismatch"
+	case DriftStaleActive:
+		return "stale_active"
+	case DriftGhostConnection:
+		return "ghost_connection"
+	default:
+		return "unknown"
+	}
+}
+
+// Drift is one discrepancy between the router, the session store and the live connections
+type Drift struct {
+	Kind     DriftKind
+	ClientID string
+	// Filter is the topic filter of subscription drifts
+	Filter   string
+	Repaired bool
+	Error    string
+}
+
+// ConsistencyReport is the result of one consistency check
+type ConsistencyReport struct {
+	Started time.Time
+	Checked int
+	Drifts  []Drift
+}
+
+// ConsistencyStats holds the cumulative counters of a consistency checker
+type ConsistencyStats struct {
+	Runs          uint64
+	Checked       uint64
+	Drifts        map[DriftKind]uint64
+	Repaired      uint64
+	RepairsFailed uint64
+	LastRun       time.Time
+}
+
+// RouterView is the part of the subscription router the checker inspects and repairs, *topic.Router implements it
+type RouterView interface {
+	Clients() []string
+	GetClientSubscriptions(clientID string) []*topic.Subscription
+	Subscribe(sub *topic.Subscription) error
+	Unsubscribe(clientID, filter string) bool
+}
+
+// ConnectionView reports which clients have a live network connection
+type ConnectionView interface {
+	IsConnected(clientID string) bool
+}
+
+// ConsistencyConfig configures the consistency checker
+type ConsistencyConfig struct {
+	Router RouterView
+	// Connections enables the connection state checks of the sampled clients when set
+	Connections ConnectionView
+	// Interval between checks run by Run
+	Interval time.Duration
+	// SampleSize is the number of clients checked per run, zero checks every client
+	SampleSize int
+	// Repair heals discrepancies, the session is treated as the source of truth for subscriptions
+	// and stale active sessions are disconnected without their will. Ghost connections are only reported
+	Repair bool
+	// Tiering, when set, marks cold sessions whose subscriptions are expected to have no routes
+	Tiering *Tiering
+}
+
+func DefaultConsistencyConfig() ConsistencyConfig {
+	return ConsistencyConfig{
+		Interval:   5 * time.Minute,
+		SampleSize: 1000,
+	}
+}
+
+// ConsistencyChecker samples clients and cross-checks router subscriptions, the subscriptions of the active
+// or persisted session and the live connection state, a safeguard against drift between the stores
+type ConsistencyChecker struct {
+	manager *Manager
+	config  ConsistencyConfig
+	guard   *admin.Guard
+
+	mu     sync.Mutex
+	drifts [driftKinds]uint64
+	last   time.Time
+
+	runs          atomic.Uint64
+	checked       atomic.Uint64
+	repaired      atomic.Uint64
+	repairsFailed atomic.Uint64
+}
+
+// NewConsistencyChecker creates a checker for the sessions of manager
+func NewConsistencyChecker(manager *Manager, config ConsistencyConfig) *ConsistencyChecker {
+	if config.Interval <= 0 {
+		config.Interval = DefaultConsistencyConfig().Interval
+	}
+	return &ConsistencyChecker{manager: manager, config: config}
+}
+
+// SetGuard makes Check verify the permissions of the principal carried by its context, admin.PermissionMetricsRead
+// and admin.PermissionConfigWrite as well when the checker repairs. Run is not checked
+func (c *ConsistencyChecker) SetGuard(guard *admin.Guard) {
+	c.guard = guard
+}
+
+// Run checks a sample of clients each interval until ctx is done, onReport receives reports with drift
+func (c *ConsistencyChecker) Run(ctx context.Context, onReport func(*ConsistencyReport)) {
+	ticker := time.NewTicker(c.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := c.check(ctx)
+			if err == nil && len(report.Drifts) > 0 && onReport != nil {
+				onReport(report)
+			}
+		}
+	}
+}
+
+// Check runs one consistency check over a sample of clients
+func (c *ConsistencyChecker) Check(ctx context.Context) (*ConsistencyReport, error) {
+	if err := c.guard.Authorize(ctx, admin.PermissionMetricsRead); err != nil {
+		return nil, err
+	}
+	if c.config.Repair {
+		if err := c.guard.Authorize(ctx, admin.PermissionConfigWrite); err != nil {
+			return nil, err
+		}
+	}
+	return c.check(ctx)
+}
+
+func (c *ConsistencyChecker) check(ctx context.Context) (*ConsistencyReport, error) {
+	report := &ConsistencyReport{Started: time.Now()}
+
+	clients, err := c.sample(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, clientID := range clients {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		drifts, err := c.checkClient(ctx, clientID)
+		if err != nil {
+			return nil, err
+		}
+		report.Checked++
+		report.Drifts = append(report.Drifts, drifts...)
+	}
+
+	c.record(report)
+	return report, nil
+}
+
+// sample returns the clients known to any of the stores, at most SampleSize picked at random
+func (c *ConsistencyChecker) sample(ctx context.Context) ([]string, error) {
+	seen := make(map[string]struct{})
+	for _, clientID := range c.manager.GetAllActiveSessions() {
+		seen[clientID] = struct{}{}
+	}
+
+	keys, err := c.manager.store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	prefix := sessionStoreKey("")
+	for _, key := range keys {
+		if clientID, ok := strings.CutPrefix(key, prefix); ok {
+			seen[clientID] = struct{}{}
+		}
+	}
+
+	if c.config.Router != nil {
+		for _, clientID := range c.config.Router.Clients() {
+			seen[clientID] = struct{}{}
+		}
+	}
+
+	clients := make([]string, 0, len(seen))
+	for clientID := range seen {
+		clients = append(clients, clientID)
+	}
+	if c.config.SampleSize > 0 && len(clients) > c.config.SampleSize {
+		rand.Shuffle(len(clients), func(i, j int) {
+			clients[i], clients[j] = clients[j], clients[i]
+		})
+		clients = clients[:c.config.SampleSize]
+	}
+	return clients, nil
+}
+
+// session returns the active session of a client or its persisted copy, nil when there is none
+func (c *ConsistencyChecker) session(ctx context.Context, clientID string) (*Session, bool, error) {
+	c.manager.mu.RLock()
+	active, ok := c.manager.activeSessions[clientID]
+	c.manager.mu.RUnlock()
+	if ok {
+		return active, true, nil
+	}
+
+	persisted, err := c.manager.store.Load(ctx, sessionStoreKey(clientID))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, false, nil
+	}
+	return persisted, false, err
+}
+
+func (c *ConsistencyChecker) checkClient(ctx context.Context, clientID string) ([]Drift, error) {
+	session, active, err := c.session(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	var drifts []Drift
+	if c.config.Router != nil {
+		drifts = c.checkRoutes(clientID, session)
+	}
+
+	if c.config.Connections != nil {
+		connected := c.config.Connections.IsConnected(clientID)
+		switch {
+		case active && !connected:
+			drift := Drift{Kind: DriftStaleActive, ClientID: clientID}
+			if c.config.Repair {
+				c.repair(&drift, c.manager.DisconnectSession(ctx, clientID, false))
+			}
+			drifts = append(drifts, drift)
+		case !active && connected:
+			drifts = append(drifts, Drift{Kind: DriftGhostConnection, ClientID: clientID})
+		}
+	}
+	return drifts, nil
+}
+
+// checkRoutes compares the router subscriptions of a client with those of its session
+func (c *ConsistencyChecker) checkRoutes(clientID string, session *Session) []Drift {
+	var expected map[string]*Subscription
+	if session != nil && (c.config.Tiering == nil || c.config.Tiering.Tier(clientID) != TierCold) {
+		expected = session.GetAllSubscriptions()
+	}
+
+	routed := make(map[string]*topic.Subscription)
+	for _, sub := range c.config.Router.GetClientSubscriptions(clientID) {
+		routed[sub.TopicFilter] = sub
+	}
+
+	var drifts []Drift
+	for filter, want := range expected {
+		got, ok := routed[filter]
+		switch {
+		case !ok:
+			drift := Drift{Kind: DriftMissingRoute, ClientID: clientID, Filter: filter}
+			if c.config.Repair {
+				c.repair(&drift, c.config.Router.Subscribe(routeFor(clientID, want, nil)))
+			}
+			drifts = append(drifts, drift)
+		case !sameOptions(want, got):
+			drift := Drift{Kind: DriftRouteMismatch, ClientID: clientID, Filter: filter}
+			if c.config.Repair {
+				c.config.Router.Unsubscribe(clientID, filter)
+				c.repair(&drift, c.config.Router.Subscribe(routeFor(clientID, want, got)))
+			}
+			drifts = append(drifts, drift)
+		}
+	}
+
+	for filter := range routed {
+		if _, ok := expected[filter]; ok {
+			continue
+		}
+		drift := Drift{Kind: DriftOrphanRoute, ClientID: clientID, Filter: filter}
+		if c.config.Repair {
+			c.config.Router.Unsubscribe(clientID, filter)
+			c.repair(&drift, nil)
+		}
+		drifts = append(drifts, drift)
+	}
+	return drifts
+}
+
+// routeFor builds the router subscription of a session subscription, keeping router-only settings of prev
+func routeFor(clientID string, sub *Subscription, prev *topic.Subscription) *topic.Subscription {
+	route := &topic.Subscription{}
+	if prev != nil {
+		*route = *prev
+	}
+	route.ClientID = clientID
+	route.TopicFilter = sub.TopicFilter
+	route.QoS = sub.QoS
+	route.NoLocal = sub.NoLocal
+	route.RetainAsPublished = sub.RetainAsPublished
+	route.RetainHandling = sub.RetainHandling
+	route.SubscriptionIdentifier = sub.SubscriptionIdentifier
+	route.LastValue = sub.LastValue
+	return route
+}
+
+func sameOptions(sub *Subscription, route *topic.Subscription) bool {
+	return sub.QoS == route.QoS &&
+		sub.NoLocal == route.NoLocal &&
+		sub.RetainAsPublished == route.RetainAsPublished &&
+		sub.RetainHandling == route.RetainHandling &&
+		sub.SubscriptionIdentifier == route.SubscriptionIdentifier &&
+		sub.LastValue == route.LastValue
+}
+
+func (c *ConsistencyChecker) repair(drift *Drift, err error) {
+	if err != nil {
+		drift.Error = err.Error()
+		c.repairsFailed.Add(1)
+		return
+	}
+	drift.Repaired = true
+	c.repaired.Add(1)
+}
+
+func (c *ConsistencyChecker) record(report *ConsistencyReport) {
+	c.runs.Add(1)
+	c.checked.Add(uint64(report.Checked))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, drift := range report.Drifts {
+		c.drifts[drift.Kind]++
+	}
+	c.last = report.Started
+}
+
+// Stats returns the cumulative counters of the checker
+func (c *ConsistencyChecker) Stats() ConsistencyStats {
+	stats := ConsistencyStats{
+		Runs:          c.runs.Load(),
+		Checked:       c.checked.Load(),
+		Drifts:        make(map[DriftKind]uint64),
+		Repaired:      c.repaired.Load(),
+		RepairsFailed: c.repairsFailed.Load(),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for kind, n := range c.drifts {
+		if n > 0 {
+			stats.Drifts[DriftKind(kind)] = n
+		}
+	}
+	stats.LastRun = c.last
+	return stats
+}
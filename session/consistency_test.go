@@ -0,0 +1,200 @@
+package session
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/axmq/ax/admin"
+	"github.com/axmq/ax/store"
+	"github.com/axmq/ax/topic"
+)
+
+type connectedSet map[string]bool
+
+func (c connectedSet) IsConnected(clientID string) bool {
+	return c[clientID]
+}
+
+func newConsistencyFixture(t *testing.T) (*Manager, *topic.Router) {
+	t.Helper()
+	m := NewManager(ManagerConfig{Store: store.NewMemoryStore[*Session]()})
+	t.Cleanup(func() { _ = m.Close() })
+	return m, topic.NewRouter()
+}
+
+func driftsByKind(report *ConsistencyReport) map[DriftKind][]string {
+	kinds := make(map[DriftKind][]string)
+	for _, d := range report.Drifts {
+		kinds[d.Kind] = append(kinds[d.Kind], d.ClientID+":"+d.Filter)
+	}
+	return kinds
+}
+
+func TestConsistencyCheckerDetectsDrift(t *testing.T) {
+	ctx := context.Background()
+	m, router := newConsistencyFixture(t)
+
+	s, _, err := m.CreateSession(ctx, "client1", false, 3600, 5)
+	require.NoError(t, err)
+	s.AddSubscription(&Subscription{TopicFilter: "a/b", QoS: 1})
+	s.AddSubscription(&Subscription{TopicFilter: "c/#", QoS: 2})
+	s.AddSubscription(&Subscription{TopicFilter: "d", QoS: 0})
+
+	require.NoError(t, router.Subscribe(&topic.Subscription{ClientID: "client1", TopicFilter: "a/b", QoS: 1}))
+	require.NoError(t, router.Subscribe(&topic.Subscription{ClientID: "client1", TopicFilter: "c/#", QoS: 0}))
+	require.NoError(t, router.Subscribe(&topic.Subscription{ClientID: "client1", TopicFilter: "e/+", QoS: 1}))
+	require.NoError(t, router.Subscribe(&topic.Subscription{ClientID: "gone", TopicFilter: "x", QoS: 1}))
+
+	checker := NewConsistencyChecker(m, ConsistencyConfig{
+		Router:      router,
+		Connections: connectedSet{"ghost": true},
+	})
+	report, err := checker.Check(ctx)
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, report.Checked)
+	kinds := driftsByKind(report)
+	assert.Equal(t, []string{"client1:d"}, kinds[DriftMissingRoute])
+	assert.Equal(t, []string{"client1:c/#"}, kinds[DriftRouteMismatch])
+	assert.ElementsMatch(t, []string{"client1:e/+", "gone:x"}, kinds[DriftOrphanRoute])
+	assert.Equal(t, []string{"client1:"}, kinds[DriftStaleActive])
+	assert.Empty(t, kinds[DriftGhostConnection])
+	for _, d := range report.Drifts {
+		assert.False(t, d.Repaired)
+	}
+
+	// Nothing is healed without Repair
+	assert.Len(t, router.GetClientSubscriptions("client1"), 3)
+	assert.Contains(t, m.GetAllActiveSessions(), "client1")
+}
+
+func TestConsistencyCheckerGhostConnection(t *testing.T) {
+	ctx := context.Background()
+	m, _ := newConsistencyFixture(t)
+
+	_, _, err := m.CreateSession(ctx, "client1", false, 3600, 5)
+	require.NoError(t, err)
+	require.NoError(t, m.DisconnectSession(ctx, "client1", false))
+
+	checker := NewConsistencyChecker(m, ConsistencyConfig{Connections: connectedSet{"client1": true}})
+	report, err := checker.Check(ctx)
+	require.NoError(t, err)
+
+	require.Len(t, report.Drifts, 1)
+	assert.Equal(t, DriftGhostConnection, report.Drifts[0].Kind)
+	assert.Equal(t, "client1", report.Drifts[0].ClientID)
+}
+
+func TestConsistencyCheckerRepair(t *testing.T) {
+	ctx := context.Background()
+	m, router := newConsistencyFixture(t)
+
+	s, _, err := m.CreateSession(ctx, "client1", false, 3600, 5)
+	require.NoError(t, err)
+	s.AddSubscription(&Subscription{TopicFilter: "c/#", QoS: 2, NoLocal: true})
+	s.AddSubscription(&Subscription{TopicFilter: "d", QoS: 1})
+
+	require.NoError(t, router.Subscribe(&topic.Subscription{ClientID: "client1", TopicFilter: "c/#", QoS: 0}))
+	require.NoError(t, router.Subscribe(&topic.Subscription{ClientID: "client1", TopicFilter: "e/+", QoS: 1}))
+
+	checker := NewConsistencyChecker(m, ConsistencyConfig{
+		Router:      router,
+		Connections: connectedSet{"client1": true},
+		Repair:      true,
+	})
+	report, err := checker.Check(ctx)
+	require.NoError(t, err)
+	require.Len(t, report.Drifts, 3)
+	for _, d := range report.Drifts {
+		assert.True(t, d.Repaired, d.Kind.String())
+	}
+
+	subs := router.GetClientSubscriptions("client1")
+	require.Len(t, subs, 2)
+	got := make(map[string]*topic.Subscription)
+	for _, sub := range subs {
+		got[sub.TopicFilter] = sub
+	}
+	assert.Equal(t, byte(2), got["c/#"].QoS)
+	assert.True(t, got["c/#"].NoLocal)
+	assert.Equal(t, byte(1), got["d"].QoS)
+
+	matched := router.Match("c/x")
+	require.Len(t, matched, 1)
+	assert.Equal(t, byte(2), matched[0].QoS)
+
+	report, err = checker.Check(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, report.Drifts)
+
+	stats := checker.Stats()
+	assert.Equal(t, uint64(2), stats.Runs)
+	assert.Equal(t, uint64(2), stats.Checked)
+	assert.Equal(t, uint64(3), stats.Repaired)
+	assert.Equal(t, map[DriftKind]uint64{DriftMissingRoute: 1, DriftOrphanRoute: 1, DriftRouteMismatch: 1}, stats.Drifts)
+	assert.False(t, stats.LastRun.IsZero())
+}
+
+func TestConsistencyCheckerRepairStaleActive(t *testing.T) {
+	ctx := context.Background()
+	m, _ := newConsistencyFixture(t)
+
+	_, _, err := m.CreateSession(ctx, "client1", false, 3600, 5)
+	require.NoError(t, err)
+
+	checker := NewConsistencyChecker(m, ConsistencyConfig{Connections: connectedSet{}, Repair: true})
+	report, err := checker.Check(ctx)
+	require.NoError(t, err)
+
+	require.Len(t, report.Drifts, 1)
+	assert.Equal(t, DriftStaleActive, report.Drifts[0].Kind)
+	assert.True(t, report.Drifts[0].Repaired)
+	assert.NotContains(t, m.GetAllActiveSessions(), "client1")
+
+	// The persisted copy is checked once the session is no longer active
+	report, err = checker.Check(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Checked)
+	assert.Empty(t, report.Drifts)
+}
+
+func TestConsistencyCheckerSampleSize(t *testing.T) {
+	ctx := context.Background()
+	m, router := newConsistencyFixture(t)
+
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		require.NoError(t, router.Subscribe(&topic.Subscription{ClientID: id, TopicFilter: "t", QoS: 0}))
+	}
+
+	checker := NewConsistencyChecker(m, ConsistencyConfig{Router: router, SampleSize: 2})
+	report, err := checker.Check(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Checked)
+	assert.Len(t, report.Drifts, 2)
+}
+
+func TestConsistencyCheckerGuard(t *testing.T) {
+	m, router := newConsistencyFixture(t)
+	ctx := context.Background()
+	guard, err := admin.NewGuard(admin.GuardConfig{Authenticator: admin.Chain()})
+	require.NoError(t, err)
+	viewer := admin.WithPrincipal(ctx, &admin.Principal{Name: "grafana", Roles: []string{admin.RoleViewer}})
+
+	checker := NewConsistencyChecker(m, ConsistencyConfig{Router: router})
+	checker.SetGuard(guard)
+	_, err = checker.Check(viewer)
+	assert.NoError(t, err)
+
+	// a repairing check changes the router, so reading metrics is not enough
+	checker = NewConsistencyChecker(m, ConsistencyConfig{Router: router, Repair: true})
+	checker.SetGuard(guard)
+	_, err = checker.Check(ctx)
+	assert.ErrorIs(t, err, admin.ErrNoCredentials)
+	_, err = checker.Check(viewer)
+	assert.ErrorIs(t, err, admin.ErrForbidden)
+	_, err = checker.Check(admin.WithPrincipal(ctx, &admin.Principal{Name: "root", Roles: []string{admin.RoleAdmin}}))
+	assert.NoError(t, err)
+}
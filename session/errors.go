@@ -1,8 +1,16 @@
 package session
 
-import "errors"
+import (
+	axerrors "github.com/axmq/ax/pkg/errors"
+)
 
 var (
-	ErrSessionNotFound      = errors.New("session not found")
-	ErrSessionAlreadyExists = errors.New("session already exists")
+	ErrSessionNotFound      = axerrors.New(axerrors.KindStorage, "session not found")
+	ErrSessionAlreadyExists = axerrors.New(axerrors.KindStorage, "session already exists")
+	ErrTakeoverRejected     = axerrors.New(axerrors.KindAuth, "session takeover rejected")
+	ErrSessionNotActive     = axerrors.New(axerrors.KindStorage, "session has no connected client")
+	ErrSubscriptionNotFound = axerrors.New(axerrors.KindProtocol, "subscription not found")
+	ErrSubscriptionConflict = axerrors.New(axerrors.KindProtocol, "client already subscribed to the filter")
+	ErrManagedSubscription  = axerrors.New(axerrors.KindAuth, "subscription is managed by the broker")
+	ErrQueueFull            = axerrors.New(axerrors.KindQuota, "message queue is full")
 )
@@ -0,0 +1,163 @@
+package session
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventType identifies a session lifecycle event
+type EventType byte
+
+const (
+	// EventCreated is published when a new session is created, including a clean start over an existing one
+	EventCreated EventType = iota + 1
+	// EventResumed is published when a client reconnects to its stored session
+	EventResumed
+	// EventTakeover is published when a new connection takes over the session of a client ID
+	EventTakeover
+	// EventDisconnected is published when the client of a session disconnects
+	EventDisconnected
+	// EventExpired is published when the expiry sweeper removes a session
+	EventExpired
+	// EventRoamed is published after EventTakeover when the client moved to a different listener or transport
+	EventRoamed
+)
+
+// String returns the string representation of the event type
+func (t EventType) String() string {
+	switch t {
+	case EventCreated:
+		return "created"
+	case EventResumed:
+		return "resumed"
+	case EventTakeover:
+		return "takeover"
+	case EventDisconnected:
+		return "disconnected"
+	case EventExpired:
+		return "expired"
+	case EventRoamed:
+		return "roamed"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes a session lifecycle change
+type Event struct {
+	Type     EventType
+	ClientID string
+	Session  *Session
+	Time     time.Time
+
+	// Takeover is the resolved decision for EventTakeover and EventRoamed
+	Takeover *TakeoverDecision
+}
+
+// EventHandler receives session events, it runs on the goroutine that caused the event and must not block
+type EventHandler func(Event)
+
+type eventSubscriber struct {
+	handler EventHandler
+	types   uint32 // bit set of EventType, 0 means every type
+}
+
+func (s *eventSubscriber) wants(t EventType) bool {
+	return s.types == 0 || s.types&(1<<t) != 0
+}
+
+// EventBus delivers session lifecycle events to internal subscribers such as presence, metrics and shadow
+// so they do not need direct calls from the session manager
+type EventBus struct {
+	mu          sync.RWMutex
+	nextID      uint64
+	subscribers map[uint64]*eventSubscriber
+
+	dropped atomic.Uint64
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[uint64]*eventSubscriber)}
+}
+
+// Subscribe calls handler for events of the given types, or of every type when none are given
+// The returned function removes the subscription
+func (b *EventBus) Subscribe(handler EventHandler, types ...EventType) func() {
+	sub := &eventSubscriber{handler: handler}
+	for _, t := range types {
+		sub.types |= 1 << t
+	}
+
+	b.mu.Lock()
+	b.nextID++
+	id := b.nextID
+	b.subscribers[id] = sub
+	b.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, id)
+			b.mu.Unlock()
+		})
+	}
+}
+
+// SubscribeChan delivers events on a channel with the given buffer for subscribers that process events
+// on their own goroutine. Events are dropped and counted when the channel is full, so a slow subscriber
+// never stalls the session manager. The returned function removes the subscription and closes the channel
+func (b *EventBus) SubscribeChan(buffer int, types ...EventType) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+
+	var closed bool
+	var mu sync.Mutex
+	unsubscribe := b.Subscribe(func(event Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case ch <- event:
+		default:
+			b.dropped.Add(1)
+		}
+	}, types...)
+
+	return ch, func() {
+		unsubscribe()
+		mu.Lock()
+		defer mu.Unlock()
+		if !closed {
+			closed = true
+			close(ch)
+		}
+	}
+}
+
+// Publish delivers event to every interested subscriber, Time is set when it is zero
+func (b *EventBus) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	b.mu.RLock()
+	subscribers := make([]*eventSubscriber, 0, len(b.subscribers))
+	for _, sub := range b.subscribers {
+		if sub.wants(event.Type) {
+			subscribers = append(subscribers, sub)
+		}
+	}
+	b.mu.RUnlock()
+
+	for _, sub := range subscribers {
+		sub.handler(event)
+	}
+}
+
+// Dropped returns the number of events dropped because a channel subscriber was full
+func (b *EventBus) Dropped() uint64 {
+	return b.dropped.Load()
+}
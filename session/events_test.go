@@ -0,0 +1,92 @@
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/axmq/ax/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventType_String(t *testing.T) {
+	assert.Equal(t, "created", EventCreated.String())
+	assert.Equal(t, "resumed", EventResumed.String())
+	assert.Equal(t, "takeover", EventTakeover.String())
+	assert.Equal(t, "disconnected", EventDisconnected.String())
+	assert.Equal(t, "expired", EventExpired.String())
+	assert.Equal(t, "roamed", EventRoamed.String())
+	assert.Equal(t, "unknown", EventType(0).String())
+}
+
+func TestEventBus_Subscribe(t *testing.T) {
+	bus := NewEventBus()
+
+	var all, expired []EventType
+	bus.Subscribe(func(e Event) { all = append(all, e.Type) })
+	unsubscribe := bus.Subscribe(func(e Event) { expired = append(expired, e.Type) }, EventExpired)
+
+	bus.Publish(Event{Type: EventCreated, ClientID: "c1"})
+	bus.Publish(Event{Type: EventExpired, ClientID: "c1"})
+	unsubscribe()
+	unsubscribe()
+	bus.Publish(Event{Type: EventExpired, ClientID: "c1"})
+
+	assert.Equal(t, []EventType{EventCreated, EventExpired, EventExpired}, all)
+	assert.Equal(t, []EventType{EventExpired}, expired)
+}
+
+func TestEventBus_SubscribeChan(t *testing.T) {
+	bus := NewEventBus()
+	ch, unsubscribe := bus.SubscribeChan(1, EventCreated)
+
+	bus.Publish(Event{Type: EventCreated, ClientID: "c1"})
+	bus.Publish(Event{Type: EventCreated, ClientID: "c2"})
+	bus.Publish(Event{Type: EventResumed, ClientID: "c3"})
+
+	event := <-ch
+	assert.Equal(t, "c1", event.ClientID)
+	assert.False(t, event.Time.IsZero())
+	assert.Equal(t, uint64(1), bus.Dropped())
+
+	unsubscribe()
+	unsubscribe()
+	_, ok := <-ch
+	assert.False(t, ok)
+	bus.Publish(Event{Type: EventCreated, ClientID: "c4"})
+}
+
+func TestManager_Events(t *testing.T) {
+	bus := NewEventBus()
+	m := NewManager(ManagerConfig{Store: store.NewMemoryStore[*Session](), Events: bus})
+	defer m.Close()
+	assert.Same(t, bus, m.Events())
+
+	var events []Event
+	bus.Subscribe(func(e Event) { events = append(events, e) })
+
+	ctx := context.Background()
+	_, _, err := m.CreateSession(ctx, "client1", false, 1, 5)
+	require.NoError(t, err)
+	require.NoError(t, m.TakeoverSession(ctx, "client1"))
+	require.NoError(t, m.DisconnectSession(ctx, "client1", false))
+	_, present, err := m.CreateSession(ctx, "client1", false, 1, 5)
+	require.NoError(t, err)
+	require.True(t, present)
+	require.NoError(t, m.DisconnectSession(ctx, "client1", false))
+
+	stored, err := m.store.Load(ctx, sessionStoreKey("client1"))
+	require.NoError(t, err)
+	stored.DisconnectedAt = time.Now().Add(-time.Hour)
+	require.NoError(t, m.store.Save(ctx, sessionStoreKey("client1"), stored))
+	require.NoError(t, m.indexExpiry(ctx, stored))
+	m.checkExpiredSessions()
+
+	types := make([]EventType, len(events))
+	for i, e := range events {
+		types[i] = e.Type
+		assert.Equal(t, "client1", e.ClientID)
+	}
+	assert.Equal(t, []EventType{EventCreated, EventTakeover, EventDisconnected, EventResumed, EventDisconnected, EventExpired}, types)
+}
@@ -0,0 +1,117 @@
+package session
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/axmq/ax/admin"
+	"github.com/axmq/ax/topic"
+)
+
+// SubscriptionRouter is the part of the subscription router managed subscriptions are installed in,
+// *topic.Router implements it
+type SubscriptionRouter interface {
+	Subscribe(sub *topic.Subscription) error
+	Unsubscribe(clientID, filter string) bool
+}
+
+// SubscriptionAdmin attaches and detaches broker-managed subscriptions to the sessions of connected clients,
+// for operational taps such as routing the diagnostics topics of a device to a support tool for a while
+type SubscriptionAdmin struct {
+	manager *Manager
+	router  SubscriptionRouter
+	guard   *admin.Guard
+}
+
+func NewSubscriptionAdmin(manager *Manager, router SubscriptionRouter) *SubscriptionAdmin {
+	return &SubscriptionAdmin{manager: manager, router: router}
+}
+
+// SetGuard makes the operations check the permissions of the principal carried by their context, Attach and
+// Detach need admin.PermissionConfigWrite and List admin.PermissionMetricsRead
+func (a *SubscriptionAdmin) SetGuard(guard *admin.Guard) {
+	a.guard = guard
+}
+
+// Attach subscribes a connected client to sub.TopicFilter on its behalf, the subscription is marked managed
+// so the client cannot remove it with UNSUBSCRIBE. Attaching a filter the client subscribed to itself
+// returns ErrSubscriptionConflict, attaching a managed filter again replaces its options
+func (a *SubscriptionAdmin) Attach(ctx context.Context, clientID string, sub *Subscription) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := a.guard.Authorize(ctx, admin.PermissionConfigWrite); err != nil {
+		return err
+	}
+	session, err := a.active(clientID)
+	if err != nil {
+		return err
+	}
+	if existing, ok := session.GetSubscription(sub.TopicFilter); ok && !existing.Managed {
+		return ErrSubscriptionConflict
+	}
+
+	managed := *sub
+	managed.Managed = true
+	managed.SubscribedAt = time.Now()
+
+	if err := a.router.Subscribe(routeFor(clientID, &managed, nil)); err != nil {
+		return err
+	}
+	session.AddSubscription(&managed)
+	return nil
+}
+
+// Detach removes a managed subscription from the session of a client and from the router,
+// subscriptions the client made itself are left alone and reported as ErrSubscriptionNotFound
+func (a *SubscriptionAdmin) Detach(ctx context.Context, clientID, topicFilter string) error {
+	if err := a.guard.Authorize(ctx, admin.PermissionConfigWrite); err != nil {
+		return err
+	}
+	session, err := a.manager.GetSession(ctx, clientID)
+	if err != nil {
+		return err
+	}
+	sub, ok := session.GetSubscription(topicFilter)
+	if !ok || !sub.Managed {
+		return ErrSubscriptionNotFound
+	}
+
+	session.RemoveSubscription(topicFilter)
+	a.router.Unsubscribe(clientID, topicFilter)
+	return nil
+}
+
+// List returns the managed subscriptions of a client sorted by topic filter
+func (a *SubscriptionAdmin) List(ctx context.Context, clientID string) ([]*Subscription, error) {
+	if err := a.guard.Authorize(ctx, admin.PermissionMetricsRead); err != nil {
+		return nil, err
+	}
+	session, err := a.manager.GetSession(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	var subs []*Subscription
+	for _, sub := range session.GetAllSubscriptions() {
+		if sub.Managed {
+			subs = append(subs, sub)
+		}
+	}
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].TopicFilter < subs[j].TopicFilter
+	})
+	return subs, nil
+}
+
+// active returns the session of a connected client
+func (a *SubscriptionAdmin) active(clientID string) (*Session, error) {
+	a.manager.mu.RLock()
+	defer a.manager.mu.RUnlock()
+	session, ok := a.manager.activeSessions[clientID]
+	if !ok {
+		return nil, ErrSessionNotActive
+	}
+	return session, nil
+}
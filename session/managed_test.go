@@ -0,0 +1,115 @@
+package session
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/axmq/ax/admin"
+	"github.com/axmq/ax/topic"
+)
+
+func TestSubscriptionAdminAttachDetach(t *testing.T) {
+	ctx := context.Background()
+	m, router := newConsistencyFixture(t)
+	admin := NewSubscriptionAdmin(m, router)
+
+	s, _, err := m.CreateSession(ctx, "device1", false, 3600, 5)
+	require.NoError(t, err)
+	s.AddSubscription(&Subscription{TopicFilter: "cmd/device1", QoS: 1})
+
+	require.NoError(t, admin.Attach(ctx, "device1", &Subscription{TopicFilter: "diag/device1/#", QoS: 1}))
+
+	sub, ok := s.GetSubscription("diag/device1/#")
+	require.True(t, ok)
+	assert.True(t, sub.Managed)
+	assert.False(t, sub.SubscribedAt.IsZero())
+	assert.Len(t, router.Match("diag/device1/cpu"), 1)
+
+	managed, err := admin.List(ctx, "device1")
+	require.NoError(t, err)
+	require.Len(t, managed, 1)
+	assert.Equal(t, "diag/device1/#", managed[0].TopicFilter)
+
+	err = s.Unsubscribe("diag/device1/#")
+	assert.ErrorIs(t, err, ErrManagedSubscription)
+	_, ok = s.GetSubscription("diag/device1/#")
+	assert.True(t, ok)
+
+	assert.ErrorIs(t, admin.Detach(ctx, "device1", "cmd/device1"), ErrSubscriptionNotFound)
+	require.NoError(t, admin.Detach(ctx, "device1", "diag/device1/#"))
+	_, ok = s.GetSubscription("diag/device1/#")
+	assert.False(t, ok)
+	assert.Empty(t, router.Match("diag/device1/cpu"))
+	assert.ErrorIs(t, admin.Detach(ctx, "device1", "diag/device1/#"), ErrSubscriptionNotFound)
+
+	require.NoError(t, s.Unsubscribe("cmd/device1"))
+	assert.ErrorIs(t, s.Unsubscribe("cmd/device1"), ErrSubscriptionNotFound)
+}
+
+func TestSubscriptionAdminAttachRejected(t *testing.T) {
+	ctx := context.Background()
+	m, router := newConsistencyFixture(t)
+	admin := NewSubscriptionAdmin(m, router)
+
+	err := admin.Attach(ctx, "offline", &Subscription{TopicFilter: "diag/#"})
+	assert.ErrorIs(t, err, ErrSessionNotActive)
+
+	s, _, err := m.CreateSession(ctx, "device1", false, 3600, 5)
+	require.NoError(t, err)
+	s.AddSubscription(&Subscription{TopicFilter: "diag/#", QoS: 0})
+
+	err = admin.Attach(ctx, "device1", &Subscription{TopicFilter: "diag/#", QoS: 1})
+	assert.ErrorIs(t, err, ErrSubscriptionConflict)
+	sub, _ := s.GetSubscription("diag/#")
+	assert.False(t, sub.Managed)
+
+	err = admin.Attach(ctx, "device1", &Subscription{TopicFilter: "diag/#/bad"})
+	assert.Error(t, err)
+	_, ok := s.GetSubscription("diag/#/bad")
+	assert.False(t, ok)
+}
+
+func TestSubscriptionAdminGuard(t *testing.T) {
+	m, router := newConsistencyFixture(t)
+	subs := NewSubscriptionAdmin(m, router)
+	guard, err := admin.NewGuard(admin.GuardConfig{Authenticator: admin.Chain()})
+	require.NoError(t, err)
+	subs.SetGuard(guard)
+
+	_, _, err = m.CreateSession(context.Background(), "device1", false, 3600, 5)
+	require.NoError(t, err)
+	sub := &Subscription{TopicFilter: "diag/device1/#"}
+
+	assert.ErrorIs(t, subs.Attach(context.Background(), "device1", sub), admin.ErrNoCredentials)
+	operator := admin.WithPrincipal(context.Background(), &admin.Principal{Name: "ops", Roles: []string{admin.RoleOperator}})
+	assert.ErrorIs(t, subs.Attach(operator, "device1", sub), admin.ErrForbidden)
+	assert.Empty(t, router.Match("diag/device1/cpu"))
+	managed, err := subs.List(operator, "device1")
+	require.NoError(t, err)
+	assert.Empty(t, managed)
+
+	owner := admin.WithPrincipal(context.Background(), &admin.Principal{Name: "root", Roles: []string{admin.RoleAdmin}})
+	require.NoError(t, subs.Attach(owner, "device1", sub))
+	assert.ErrorIs(t, subs.Detach(operator, "device1", "diag/device1/#"), admin.ErrForbidden)
+	require.NoError(t, subs.Detach(owner, "device1", "diag/device1/#"))
+}
+
+func TestSubscriptionAdminKeptByConsistencyChecker(t *testing.T) {
+	ctx := context.Background()
+	m, router := newConsistencyFixture(t)
+	admin := NewSubscriptionAdmin(m, router)
+
+	_, _, err := m.CreateSession(ctx, "device1", false, 3600, 5)
+	require.NoError(t, err)
+	require.NoError(t, admin.Attach(ctx, "device1", &Subscription{TopicFilter: "diag/device1/#", QoS: 1}))
+
+	checker := NewConsistencyChecker(m, ConsistencyConfig{Router: router})
+	report, err := checker.Check(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, report.Drifts)
+
+	var _ SubscriptionRouter = (*topic.Router)(nil)
+}
@@ -9,6 +9,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/axmq/ax/encoding"
 	"github.com/axmq/ax/store"
 )
 
@@ -18,12 +19,19 @@ const _sessionKeyPrefix = "session:%s"
 type Manager struct {
 	mu                sync.RWMutex
 	store             store.Store[*Session]
+	expiry            store.ExpiryIndex   // nil when the store has no expiry index
 	activeSessions    map[string]*Session // clientID -> session for quick access
 	expiryCheckTicker *time.Ticker
 	stopCh            chan struct{}
 	wg                sync.WaitGroup
 	willPublisher     WillPublisher
 	assignedIDPrefix  string
+	takeoverPolicy    TakeoverPolicy
+	takeoverReason    encoding.ReasonCode
+	onTakeover        func(*TakeoverDecision)
+	onRoamed          func(*Session, *TakeoverDecision)
+	roamingTakeover   bool
+	events            *EventBus
 }
 
 // WillPublisher defines the interface for publishing will messages
@@ -37,6 +45,22 @@ type ManagerConfig struct {
 	ExpiryCheckInterval time.Duration
 	WillPublisher       WillPublisher
 	AssignedIDPrefix    string
+
+	// TakeoverPolicy decides which connection wins when a client ID is already connected
+	TakeoverPolicy TakeoverPolicy
+	// TakeoverRejectReason is the CONNACK reason code for rejected connections,
+	// typically ReasonClientIdentifierNotValid (the default) or ReasonNotAuthorized
+	TakeoverRejectReason encoding.ReasonCode
+	// OnTakeover is called with every takeover decision and may override it
+	OnTakeover func(*TakeoverDecision)
+	// RoamingTakeover lets a client reconnecting on a different listener or transport take over its
+	// session even under TakeoverPolicyRejectNew, the old connection of a roaming device is usually dead
+	RoamingTakeover bool
+	// OnRoamed is called after a client took over its session from a different listener or transport,
+	// e.g. hook.Manager.SessionRoamed to run the OnClientRoamed hooks
+	OnRoamed func(*Session, *TakeoverDecision)
+	// Events receives session lifecycle events, a private bus is created when nil
+	Events *EventBus
 }
 
 // NewManager creates a new session manager
@@ -48,13 +72,26 @@ func NewManager(config ManagerConfig) *Manager {
 		config.AssignedIDPrefix = "auto-"
 	}
 
+	if config.Events == nil {
+		config.Events = NewEventBus()
+	}
+
+	expiry, _ := config.Store.(store.ExpiryIndex)
+
 	m := &Manager{
 		store:             config.Store,
+		expiry:            expiry,
 		activeSessions:    make(map[string]*Session),
 		expiryCheckTicker: time.NewTicker(config.ExpiryCheckInterval),
 		stopCh:            make(chan struct{}),
 		willPublisher:     config.WillPublisher,
 		assignedIDPrefix:  config.AssignedIDPrefix,
+		takeoverPolicy:    config.TakeoverPolicy,
+		takeoverReason:    rejectReason(config.TakeoverRejectReason),
+		onTakeover:        config.OnTakeover,
+		onRoamed:          config.OnRoamed,
+		roamingTakeover:   config.RoamingTakeover,
+		events:            config.Events,
 	}
 
 	m.wg.Add(1)
@@ -65,6 +102,62 @@ func NewManager(config ManagerConfig) *Manager {
 
 // CreateSession creates a new session or returns an existing one
 func (m *Manager) CreateSession(ctx context.Context, clientID string, cleanStart bool, expiryInterval uint32, protocolVersion byte) (*Session, bool, error) {
+	session, sessionPresent, err := m.createSession(ctx, clientID, cleanStart, expiryInterval, protocolVersion, ConnectionInfo{}, nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if sessionPresent {
+		m.publish(EventResumed, session)
+	} else {
+		m.publish(EventCreated, session)
+	}
+	return session, sessionPresent, nil
+}
+
+// ConnectRequest describes a client connecting to its session
+type ConnectRequest struct {
+	ClientID        string
+	CleanStart      bool
+	ExpiryInterval  uint32
+	ProtocolVersion byte
+	// Metadata holds the client metadata set while authenticating, it is merged into the session and persisted
+	Metadata map[string]string
+	// Connection is the listener and transport the client connected on, it is recorded on the session so the
+	// next takeover can tell whether the client roamed
+	Connection ConnectionInfo
+}
+
+// ConnectResult is the outcome of Connect
+type ConnectResult struct {
+	Session        *Session
+	SessionPresent bool
+	// Takeover is the decision taken for the existing session, nil when there was none
+	Takeover *TakeoverDecision
+}
+
+// Connect takes over the existing session of a connecting client, then creates or resumes its session
+// The client metadata is merged into the session on both paths, so it survives a clean start and is found by
+// FindSessionsByMetadata. A rejected takeover returns the decision with the error of TakeoverSessionFrom
+func (m *Manager) Connect(ctx context.Context, req ConnectRequest) (*ConnectResult, error) {
+	decision, err := m.takeover(ctx, req.ClientID, req.Connection, req.Metadata)
+	if err != nil {
+		return &ConnectResult{Takeover: decision}, err
+	}
+
+	session, sessionPresent, err := m.createSession(ctx, req.ClientID, req.CleanStart, req.ExpiryInterval, req.ProtocolVersion, req.Connection, req.Metadata)
+	if err != nil {
+		return &ConnectResult{Takeover: decision}, err
+	}
+	if sessionPresent {
+		m.publish(EventResumed, session)
+	} else {
+		m.publish(EventCreated, session)
+	}
+	return &ConnectResult{Session: session, SessionPresent: sessionPresent, Takeover: decision}, nil
+}
+
+func (m *Manager) createSession(ctx context.Context, clientID string, cleanStart bool, expiryInterval uint32, protocolVersion byte, conn ConnectionInfo, metadata map[string]string) (*Session, bool, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -91,17 +184,32 @@ func (m *Manager) CreateSession(ctx context.Context, clientID string, cleanStart
 			}
 			sessionPresent = true
 		}
+		if len(metadata) > 0 {
+			existingSession.MergeMetadata(metadata)
+		}
+		if !conn.IsZero() {
+			existingSession.SetConnection(conn)
+		}
 		m.activeSessions[clientID] = existingSession
 		if err := m.store.Save(ctx,
 			sessionStoreKey(existingSession.ClientID),
 			existingSession); err != nil {
 			return nil, false, err
 		}
+		if err := m.indexExpiry(ctx, existingSession); err != nil {
+			return nil, false, err
+		}
 		return existingSession, sessionPresent, nil
 	}
 
 	// Create new session
 	session := New(clientID, cleanStart, expiryInterval, protocolVersion)
+	if len(metadata) > 0 {
+		session.MergeMetadata(metadata)
+	}
+	if !conn.IsZero() {
+		session.SetConnection(conn)
+	}
 	session.SetActive()
 	m.activeSessions[clientID] = session
 
@@ -157,6 +265,8 @@ func (m *Manager) DisconnectSession(ctx context.Context, clientID string, sendWi
 	delete(m.activeSessions, clientID)
 	m.mu.Unlock()
 
+	m.publish(EventDisconnected, session)
+
 	// Clean session - remove immediately
 	cleanStart := session.GetCleanStart()
 	expiryInterval := session.GetExpiryInterval()
@@ -164,7 +274,19 @@ func (m *Manager) DisconnectSession(ctx context.Context, clientID string, sendWi
 		return m.store.Delete(ctx, sessionStoreKey(clientID))
 	}
 
-	return m.store.Save(ctx, sessionStoreKey(session.ClientID), session)
+	if err := m.store.Save(ctx, sessionStoreKey(session.ClientID), session); err != nil {
+		return err
+	}
+	return m.indexExpiry(ctx, session)
+}
+
+// indexExpiry records when the sweeper has to look at a session again, if the store keeps an expiry index
+func (m *Manager) indexExpiry(ctx context.Context, session *Session) error {
+	if m.expiry == nil {
+		return nil
+	}
+	deadline, _ := session.NextDeadline()
+	return m.expiry.SetExpiry(ctx, sessionStoreKey(session.ClientID), deadline)
 }
 
 // RemoveSession removes a session completely
@@ -177,19 +299,105 @@ func (m *Manager) RemoveSession(ctx context.Context, clientID string) error {
 }
 
 // TakeoverSession handles session takeover when a new connection uses an existing client ID
+// Under TakeoverPolicyRejectNew a session with a connected client is kept and a PacketError
+// wrapping ErrTakeoverRejected is returned, carrying the reason code to send in CONNACK
 func (m *Manager) TakeoverSession(ctx context.Context, clientID string) error {
+	_, err := m.TakeoverSessionFrom(ctx, clientID, ConnectionInfo{})
+	return err
+}
+
+// TakeoverSessionFrom handles a takeover like TakeoverSession for a connection arriving on conn
+// and records conn on the session. When the client roams to a different listener or transport the
+// inflight state is kept and outbound QoS messages are flagged DUP so they are resent on the new
+// connection, and EventRoamed is published. The returned decision is nil when there is no session
+func (m *Manager) TakeoverSessionFrom(ctx context.Context, clientID string, conn ConnectionInfo) (*TakeoverDecision, error) {
+	return m.takeover(ctx, clientID, conn, nil)
+}
+
+// takeover resolves a takeover and records conn and the metadata of the new client on the session
+func (m *Manager) takeover(ctx context.Context, clientID string, conn ConnectionInfo, metadata map[string]string) (*TakeoverDecision, error) {
 	session, err := m.GetSession(ctx, clientID)
 	if err != nil {
 		if errors.Is(err, store.ErrNotFound) {
-			return nil
+			return nil, nil
 		}
-		return err
+		return nil, err
+	}
+
+	session.mu.RLock()
+	active := session.State == StateActive
+	previous := session.Connection
+	session.mu.RUnlock()
+
+	decision := &TakeoverDecision{
+		ClientID:   clientID,
+		Policy:     m.takeoverPolicy,
+		Active:     active,
+		ReasonCode: m.takeoverReason,
+		Roaming:    conn.roamedFrom(previous),
+		Previous:   previous,
+		Current:    conn,
+	}
+	decision.Reject = m.takeoverPolicy == TakeoverPolicyRejectNew && active &&
+		!(decision.Roaming && m.roamingTakeover)
+	if m.onTakeover != nil {
+		m.onTakeover(decision)
+	}
+
+	if err := decision.RejectError(); err != nil {
+		return decision, err
 	}
 
 	// Clear will message on takeover
 	session.ClearWillMessage()
 
-	return nil
+	if len(metadata) > 0 {
+		session.MergeMetadata(metadata)
+	}
+	if !conn.IsZero() {
+		session.SetConnection(conn)
+		if decision.Roaming {
+			session.MarkPendingDUP()
+		}
+	}
+	if !conn.IsZero() || len(metadata) > 0 {
+		if err := m.store.Save(ctx, sessionStoreKey(clientID), session); err != nil {
+			return decision, err
+		}
+	}
+
+	m.events.Publish(Event{Type: EventTakeover, ClientID: clientID, Session: session, Takeover: decision})
+	if decision.Roaming {
+		m.events.Publish(Event{Type: EventRoamed, ClientID: clientID, Session: session, Takeover: decision})
+		if m.onRoamed != nil {
+			m.onRoamed(session, decision)
+		}
+	}
+	return decision, nil
+}
+
+// RestoreSession stores a session carried over from another broker as disconnected,
+// replacing any session with the same client ID
+func (m *Manager) RestoreSession(ctx context.Context, session *Session) error {
+	session.SetDisconnected()
+
+	m.mu.Lock()
+	delete(m.activeSessions, session.ClientID)
+	m.mu.Unlock()
+
+	if err := m.store.Save(ctx, sessionStoreKey(session.ClientID), session); err != nil {
+		return err
+	}
+	return m.indexExpiry(ctx, session)
+}
+
+// Events returns the bus session lifecycle events are published on
+func (m *Manager) Events() *EventBus {
+	return m.events
+}
+
+func (m *Manager) publish(t EventType, session *Session) {
+	m.events.Publish(Event{Type: t, ClientID: session.ClientID, Session: session})
 }
 
 // GenerateClientID generates a unique client ID for clients that don't provide one
@@ -217,6 +425,10 @@ func (m *Manager) GenerateClientID(ctx context.Context) (string, error) {
 func (m *Manager) expiryChecker() {
 	defer m.wg.Done()
 
+	if m.expiry != nil {
+		_ = m.rebuildExpiryIndex(context.Background())
+	}
+
 	for {
 		select {
 		case <-m.expiryCheckTicker.C:
@@ -227,11 +439,46 @@ func (m *Manager) expiryChecker() {
 	}
 }
 
+// rebuildExpiryIndex indexes every stored session, so sessions saved before the store had an index or
+// by an interrupted run are still swept
+func (m *Manager) rebuildExpiryIndex(ctx context.Context) error {
+	keys, err := m.store.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		select {
+		case <-m.stopCh:
+			return nil
+		default:
+		}
+
+		session, err := m.store.Load(ctx, key)
+		if err != nil {
+			continue
+		}
+		deadline, _ := session.NextDeadline()
+		if err := m.expiry.SetExpiry(ctx, key, deadline); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // checkExpiredSessions checks and removes expired sessions
+// With an expiry index only the sessions due now are loaded, otherwise every stored session is scanned
 func (m *Manager) checkExpiredSessions() {
 	ctx := context.Background()
 
-	keys, err := m.store.List(ctx)
+	var (
+		keys []string
+		err  error
+	)
+	if m.expiry != nil {
+		keys, err = m.expiry.ExpiringBefore(ctx, time.Now(), 0)
+	} else {
+		keys, err = m.store.List(ctx)
+	}
 	if err != nil {
 		return
 	}
@@ -239,6 +486,9 @@ func (m *Manager) checkExpiredSessions() {
 	for _, key := range keys {
 		session, err := m.store.Load(ctx, key)
 		if err != nil {
+			if errors.Is(err, store.ErrNotFound) && m.expiry != nil {
+				_ = m.expiry.SetExpiry(ctx, key, time.Time{})
+			}
 			continue
 		}
 
@@ -253,6 +503,7 @@ func (m *Manager) checkExpiredSessions() {
 			// Remove expired session
 			session.SetExpired()
 			_ = m.store.Delete(ctx, key)
+			m.publish(EventExpired, session)
 		} else if session.GetState() == StateDisconnected && session.WillMessage != nil {
 			// Check if delayed will should be published
 			if session.ShouldPublishWill() {
@@ -261,6 +512,7 @@ func (m *Manager) checkExpiredSessions() {
 				}
 				session.ClearWillMessage()
 				_ = m.store.Save(ctx, key, session)
+				_ = m.indexExpiry(ctx, session)
 			}
 		}
 	}
@@ -294,6 +546,74 @@ func (m *Manager) GetAllActiveSessions() []string {
 	return clientIDs
 }
 
+// SetSessionMetadata merges metadata into a session and persists it
+func (m *Manager) SetSessionMetadata(ctx context.Context, clientID string, metadata map[string]string) error {
+	session, err := m.GetSession(ctx, clientID)
+	if err != nil {
+		return err
+	}
+
+	session.MergeMetadata(metadata)
+	return m.store.Save(ctx, sessionStoreKey(clientID), session)
+}
+
+// FindSessionsByMetadata returns all sessions whose metadata contains every given key/value pair
+// A session that fails to load fails the query, rather than silently missing from the result
+func (m *Manager) FindSessionsByMetadata(ctx context.Context, selector map[string]string) ([]*Session, error) {
+	keys, err := m.store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	active := make(map[string]*Session, len(m.activeSessions))
+	for clientID, session := range m.activeSessions {
+		active[sessionStoreKey(clientID)] = session
+	}
+	m.mu.RUnlock()
+
+	sessions := make([]*Session, 0)
+	for _, key := range keys {
+		session, ok := active[key]
+		if !ok {
+			session, err = m.store.Load(ctx, key)
+			if errors.Is(err, store.ErrNotFound) {
+				// removed since the keys were listed
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		if session.matchesMetadata(selector) {
+			sessions = append(sessions, session)
+		}
+	}
+
+	return sessions, nil
+}
+
+// GetSessionStats returns a snapshot of a session's traffic counters
+func (m *Manager) GetSessionStats(ctx context.Context, clientID string) (StatsSnapshot, error) {
+	session, err := m.GetSession(ctx, clientID)
+	if err != nil {
+		return StatsSnapshot{}, err
+	}
+	return session.Stats().Snapshot(), nil
+}
+
+// AggregateStats returns the sum of traffic counters across active sessions
+func (m *Manager) AggregateStats() StatsSnapshot {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var total StatsSnapshot
+	for _, session := range m.activeSessions {
+		total.Add(session.Stats().Snapshot())
+	}
+	return total
+}
+
 func sessionStoreKey(clientID string) string {
 	return fmt.Sprintf(_sessionKeyPrefix, clientID)
 }
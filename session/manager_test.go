@@ -2,6 +2,7 @@ package session
 
 import (
 	"context"
+	"errors"
 	"strings"
 	"sync"
 	"testing"
@@ -604,3 +605,201 @@ func TestManager_Close(t *testing.T) {
 	err := manager.Close()
 	assert.NoError(t, err)
 }
+
+func TestManager_SessionMetadata(t *testing.T) {
+	ctx := context.Background()
+	manager := NewManager(ManagerConfig{Store: store.NewMemoryStore[*Session]()})
+	defer manager.Close()
+
+	err := manager.SetSessionMetadata(ctx, "missing", map[string]string{"tenant": "acme"})
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	_, _, err = manager.CreateSession(ctx, "client1", false, 300, 5)
+	require.NoError(t, err)
+	_, _, err = manager.CreateSession(ctx, "client2", false, 300, 5)
+	require.NoError(t, err)
+	_, _, err = manager.CreateSession(ctx, "client3", false, 300, 5)
+	require.NoError(t, err)
+
+	require.NoError(t, manager.SetSessionMetadata(ctx, "client1", map[string]string{"tenant": "acme", "region": "eu"}))
+	require.NoError(t, manager.SetSessionMetadata(ctx, "client2", map[string]string{"tenant": "acme", "region": "us"}))
+	require.NoError(t, manager.SetSessionMetadata(ctx, "client3", map[string]string{"tenant": "other"}))
+	require.NoError(t, manager.DisconnectSession(ctx, "client2", false))
+
+	tests := []struct {
+		name     string
+		selector map[string]string
+		expected []string
+	}{
+		{name: "single key", selector: map[string]string{"tenant": "acme"}, expected: []string{"client1", "client2"}},
+		{name: "multiple keys", selector: map[string]string{"tenant": "acme", "region": "us"}, expected: []string{"client2"}},
+		{name: "no match", selector: map[string]string{"tenant": "none"}, expected: []string{}},
+		{name: "empty selector", selector: nil, expected: []string{"client1", "client2", "client3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessions, err := manager.FindSessionsByMetadata(ctx, tt.selector)
+			require.NoError(t, err)
+
+			clientIDs := make([]string, 0, len(sessions))
+			for _, s := range sessions {
+				clientIDs = append(clientIDs, s.ClientID)
+			}
+			assert.ElementsMatch(t, tt.expected, clientIDs)
+		})
+	}
+}
+
+// loadFailStore fails to load one key, as a store whose backend is unavailable
+type loadFailStore struct {
+	store.Store[*Session]
+	key string
+	err error
+}
+
+func (s *loadFailStore) Load(ctx context.Context, key string) (*Session, error) {
+	if key == s.key {
+		return nil, s.err
+	}
+	return s.Store.Load(ctx, key)
+}
+
+func TestManager_FindSessionsByMetadataLoadError(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemoryStore[*Session]()
+	for _, clientID := range []string{"client1", "client2"} {
+		s := New(clientID, false, 300, 5)
+		s.SetMetadata("tenant", "acme")
+		require.NoError(t, st.Save(ctx, sessionStoreKey(clientID), s))
+	}
+
+	errBackend := errors.New("backend unavailable")
+	manager := NewManager(ManagerConfig{Store: &loadFailStore{Store: st, key: sessionStoreKey("client2"), err: errBackend}})
+	defer manager.Close()
+	_, err := manager.FindSessionsByMetadata(ctx, map[string]string{"tenant": "acme"})
+	assert.ErrorIs(t, err, errBackend)
+
+	// A session removed while the query runs is skipped
+	manager = NewManager(ManagerConfig{Store: &loadFailStore{Store: st, key: sessionStoreKey("client2"), err: store.ErrNotFound}})
+	defer manager.Close()
+	sessions, err := manager.FindSessionsByMetadata(ctx, map[string]string{"tenant": "acme"})
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, "client1", sessions[0].ClientID)
+}
+
+func TestManager_ConnectMetadata(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemoryStore[*Session]()
+	manager := NewManager(ManagerConfig{Store: st})
+	defer manager.Close()
+
+	result, err := manager.Connect(ctx, ConnectRequest{
+		ClientID:        "client1",
+		ExpiryInterval:  300,
+		ProtocolVersion: 5,
+		Metadata:        map[string]string{"tenant": "acme", "firmware": "1.0"},
+	})
+	require.NoError(t, err)
+	assert.False(t, result.SessionPresent)
+	assert.Nil(t, result.Takeover)
+	value, ok := result.Session.GetMetadata("tenant")
+	assert.True(t, ok)
+	assert.Equal(t, "acme", value)
+
+	result, err = manager.Connect(ctx, ConnectRequest{
+		ClientID:        "client1",
+		ExpiryInterval:  300,
+		ProtocolVersion: 5,
+		Metadata:        map[string]string{"firmware": "1.1"},
+	})
+	require.NoError(t, err)
+	assert.True(t, result.SessionPresent)
+	require.NotNil(t, result.Takeover)
+	assert.True(t, result.Takeover.Active)
+
+	stored, err := st.Load(ctx, sessionStoreKey("client1"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"tenant": "acme", "firmware": "1.1"}, stored.GetAllMetadata())
+
+	sessions, err := manager.FindSessionsByMetadata(ctx, map[string]string{"firmware": "1.1"})
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, "client1", sessions[0].ClientID)
+}
+
+func TestManager_ExpiryIndex(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemoryStore[*Session]()
+	manager := NewManager(ManagerConfig{
+		Store:               st,
+		ExpiryCheckInterval: time.Hour,
+	})
+	defer manager.Close()
+
+	_, _, err := manager.CreateSession(ctx, "client1", false, 60, 5)
+	require.NoError(t, err)
+	require.NoError(t, manager.DisconnectSession(ctx, "client1", true))
+
+	keys, err := st.ExpiringBefore(ctx, time.Now().Add(30*time.Second), 0)
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+
+	keys, err = st.ExpiringBefore(ctx, time.Now().Add(2*time.Minute), 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{sessionStoreKey("client1")}, keys)
+
+	_, _, err = manager.CreateSession(ctx, "client1", false, 60, 5)
+	require.NoError(t, err)
+
+	keys, err = st.ExpiringBefore(ctx, time.Now().Add(2*time.Minute), 0)
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestManager_RestoreSession(t *testing.T) {
+	ctx := context.Background()
+	manager := NewManager(ManagerConfig{Store: store.NewMemoryStore[*Session]()})
+	defer manager.Close()
+
+	_, _, err := manager.CreateSession(ctx, "client1", false, 300, 5)
+	require.NoError(t, err)
+
+	restored := New("client1", false, 3600, 4)
+	restored.AddSubscription(&Subscription{TopicFilter: "cmd/#", QoS: 1})
+	require.NoError(t, manager.RestoreSession(ctx, restored))
+
+	assert.Equal(t, 0, manager.GetActiveSessionCount())
+
+	loaded, err := manager.GetSession(ctx, "client1")
+	require.NoError(t, err)
+	assert.Equal(t, StateDisconnected, loaded.State)
+	assert.Equal(t, uint32(3600), loaded.ExpiryInterval)
+	assert.Contains(t, loaded.GetAllSubscriptions(), "cmd/#")
+}
+
+func TestManager_ExpiryIndexRebuild(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemoryStore[*Session]()
+
+	// Saved without going through a manager, so the index does not know it
+	session := New("client1", false, 30, 5)
+	session.SetDisconnected()
+	session.DisconnectedAt = session.DisconnectedAt.Add(-time.Minute)
+	require.NoError(t, st.Save(ctx, sessionStoreKey("client1"), session))
+	keys, err := st.ExpiringBefore(ctx, time.Now().Add(time.Hour), 0)
+	require.NoError(t, err)
+	require.Empty(t, keys)
+
+	manager := NewManager(ManagerConfig{
+		Store:               st,
+		ExpiryCheckInterval: 10 * time.Millisecond,
+	})
+	defer manager.Close()
+
+	assert.Eventually(t, func() bool {
+		exists, err := st.Exists(ctx, sessionStoreKey("client1"))
+		return err == nil && !exists
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,431 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/axmq/ax/store"
+)
+
+// OfflineQueueConfig configures an offline queue
+type OfflineQueueConfig struct {
+	// SegmentBytes seals the segment being appended to once its messages reach this size
+	SegmentBytes int64
+	// SegmentMessages seals the segment being appended to once it holds this many messages
+	SegmentMessages int
+	// MaxBytes bounds the queued bytes of a session, zero leaves them unbounded
+	MaxBytes int64
+	// MaxMessages bounds the queued messages of a session, zero leaves them unbounded
+	MaxMessages int
+}
+
+func DefaultOfflineQueueConfig() OfflineQueueConfig {
+	return OfflineQueueConfig{
+		SegmentBytes:    1 << 20,
+		SegmentMessages: 4096,
+		MaxBytes:        64 << 20,
+		MaxMessages:     1000000,
+	}
+}
+
+// OfflineRecord is a stored record of an offline queue, either a message or the index of a session
+type OfflineRecord struct {
+	Message *QueuedMessage
+	Index   *OfflineIndex
+}
+
+// OfflineIndex locates the queued messages of a session
+type OfflineIndex struct {
+	// Cursor is the sequence number of the next message to deliver
+	Cursor uint64
+	// Segments are the sealed segments, oldest first
+	Segments []OfflineSegment
+}
+
+// OfflineSegment describes a run of messages of a session stored under one key prefix
+type OfflineSegment struct {
+	ID       uint64 // sequence number of the first message
+	Last     uint64 // sequence number of the last message
+	Messages int
+	Bytes    int64
+}
+
+// OfflineQueueStats holds the counters of an offline queue
+type OfflineQueueStats struct {
+	Sessions        int
+	Appended        uint64
+	Delivered       uint64
+	Evicted         uint64 // messages dropped with their segment to respect the session limits
+	EvictedSegments uint64
+}
+
+// offlineSession is the in-memory state of the queue of a session
+type offlineSession struct {
+	mu     sync.Mutex
+	loaded bool
+	index  OfflineIndex
+	tail   OfflineSegment // segment being appended to, empty when Messages is zero
+	next   uint64         // sequence number of the next appended message
+}
+
+// pending returns the number of messages not delivered yet, sequence numbers of a session have no gaps
+func (s *offlineSession) pending() int {
+	first := s.tail.ID
+	switch {
+	case len(s.index.Segments) > 0:
+		first = s.index.Segments[0].ID
+	case s.tail.Messages == 0:
+		return 0
+	}
+	return int(s.next - max(first, s.index.Cursor))
+}
+
+func (s *offlineSession) messages() int {
+	n := s.tail.Messages
+	for _, seg := range s.index.Segments {
+		n += seg.Messages
+	}
+	return n
+}
+
+func (s *offlineSession) bytes() int64 {
+	n := s.tail.Bytes
+	for _, seg := range s.index.Segments {
+		n += seg.Bytes
+	}
+	return n
+}
+
+// OfflineQueue persists the messages of offline sessions as append-only segments in a store
+// Each message is written once under the key prefix of its segment. Messages are never deleted one by one:
+// delivered segments and the oldest segments of a session over its limits are removed with a single
+// DeletePrefix, a range delete on Pebble, which keeps write amplification flat for devices accumulating
+// millions of messages over days. The index of a session is only written when a segment is sealed, evicted
+// or consumed. Eviction drops whole segments, so a session may briefly hold up to a segment more than its
+// limits, and messages of a partially delivered segment stay on disk until the segment is consumed
+// Last value replacement is left to the in-memory Queue, queued messages are kept in order as appended
+type OfflineQueue struct {
+	store  store.Store[*OfflineRecord]
+	config OfflineQueueConfig
+
+	mu       sync.Mutex
+	sessions map[string]*offlineSession
+
+	appended        atomic.Uint64
+	delivered       atomic.Uint64
+	evicted         atomic.Uint64
+	evictedSegments atomic.Uint64
+}
+
+// NewOfflineQueue creates an offline queue persisting to s, e.g. a store of store.KeyspaceOfflineQueue
+// Sessions are recovered from s on first use
+func NewOfflineQueue(s store.Store[*OfflineRecord], config OfflineQueueConfig) *OfflineQueue {
+	defaults := DefaultOfflineQueueConfig()
+	if config.SegmentBytes <= 0 {
+		config.SegmentBytes = defaults.SegmentBytes
+	}
+	if config.SegmentMessages <= 0 {
+		config.SegmentMessages = defaults.SegmentMessages
+	}
+	return &OfflineQueue{
+		store:    s,
+		config:   config,
+		sessions: make(map[string]*offlineSession),
+	}
+}
+
+// Keys of a session start with the client ID and a NUL byte, which client IDs cannot contain
+func offlinePrefix(clientID string) string {
+	return clientID + "\x00"
+}
+
+func offlineIndexKey(clientID string) string {
+	return clientID + "\x00i"
+}
+
+func offlineSegmentPrefix(clientID string, id uint64) string {
+	return fmt.Sprintf("%s\x00s%016x\x00", clientID, id)
+}
+
+func offlineMessageKey(clientID string, segment, seq uint64) string {
+	return fmt.Sprintf("%s%016x", offlineSegmentPrefix(clientID, segment), seq)
+}
+
+// parseOfflineMessageKey returns the segment and sequence numbers of a message key of clientID
+func parseOfflineMessageKey(clientID, key string) (segment, seq uint64, err error) {
+	rest := key[len(offlinePrefix(clientID)):]
+	if len(rest) != 1+16+1+16 || rest[0] != 's' || rest[17] != 0 {
+		return 0, 0, fmt.Errorf("invalid offline queue key %q", key)
+	}
+	if segment, err = strconv.ParseUint(rest[1:17], 16, 64); err != nil {
+		return 0, 0, err
+	}
+	if seq, err = strconv.ParseUint(rest[18:], 16, 64); err != nil {
+		return 0, 0, err
+	}
+	return segment, seq, nil
+}
+
+func messageSize(msg *QueuedMessage) int64 {
+	return int64(len(msg.Topic) + len(msg.Payload))
+}
+
+// session returns the loaded state of the queue of clientID with its lock held
+func (q *OfflineQueue) session(ctx context.Context, clientID string) (*offlineSession, error) {
+	q.mu.Lock()
+	s, ok := q.sessions[clientID]
+	if !ok {
+		s = &offlineSession{}
+		q.sessions[clientID] = s
+	}
+	q.mu.Unlock()
+
+	s.mu.Lock()
+	if s.loaded {
+		return s, nil
+	}
+	if err := q.load(ctx, clientID, s); err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	s.loaded = true
+	return s, nil
+}
+
+// load recovers the queue of a session from its index and the keys appended after its last sealed segment
+func (q *OfflineQueue) load(ctx context.Context, clientID string, s *offlineSession) error {
+	s.index, s.tail, s.next = OfflineIndex{}, OfflineSegment{}, 0
+	record, err := q.store.Load(ctx, offlineIndexKey(clientID))
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+	case err != nil:
+		return err
+	case record != nil && record.Index != nil:
+		s.index = *record.Index
+	}
+
+	after := ""
+	if n := len(s.index.Segments); n > 0 {
+		last := s.index.Segments[n-1]
+		after = offlineSegmentPrefix(clientID, last.ID) + "\xff"
+		s.next = last.Last + 1
+	}
+	s.next = max(s.next, s.index.Cursor)
+
+	// Only the segment being appended to is not in the index, or several if a crash hit before it was sealed
+	keys, err := store.ScanKeys(ctx, q.store, offlinePrefix(clientID)+"s", after, 0)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		segment, seq, err := parseOfflineMessageKey(clientID, key)
+		if err != nil {
+			return err
+		}
+		record, err := q.store.Load(ctx, key)
+		if err != nil {
+			return err
+		}
+		if s.tail.Messages > 0 && segment != s.tail.ID {
+			s.index.Segments = append(s.index.Segments, s.tail)
+			s.tail = OfflineSegment{}
+		}
+		if s.tail.Messages == 0 {
+			s.tail.ID = segment
+		}
+		s.tail.Last = seq
+		s.tail.Messages++
+		if record != nil && record.Message != nil {
+			s.tail.Bytes += messageSize(record.Message)
+		}
+		s.next = max(s.next, seq+1)
+	}
+	return nil
+}
+
+// Append queues msg for clientID, evicting the oldest segments of the session when it is over its limits
+func (q *OfflineQueue) Append(ctx context.Context, clientID string, msg *QueuedMessage) error {
+	s, err := q.session(ctx, clientID)
+	if err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+
+	if s.tail.Messages == 0 {
+		s.tail = OfflineSegment{ID: s.next}
+	}
+	seq := s.next
+	if err := q.store.Save(ctx, offlineMessageKey(clientID, s.tail.ID, seq), &OfflineRecord{Message: msg}); err != nil {
+		return err
+	}
+	s.next++
+	s.tail.Last = seq
+	s.tail.Messages++
+	s.tail.Bytes += messageSize(msg)
+	q.appended.Add(1)
+
+	sealed := false
+	if s.tail.Bytes >= q.config.SegmentBytes || s.tail.Messages >= q.config.SegmentMessages {
+		s.index.Segments = append(s.index.Segments, s.tail)
+		s.tail = OfflineSegment{}
+		sealed = true
+	}
+	evicted, err := q.evict(ctx, clientID, s)
+	if err != nil {
+		return err
+	}
+	if sealed || evicted {
+		return q.saveIndex(ctx, clientID, s)
+	}
+	return nil
+}
+
+// evict drops the oldest segments while the session is over its limits, the segment being appended to is
+// sealed and dropped too when it alone exceeds them
+func (q *OfflineQueue) evict(ctx context.Context, clientID string, s *offlineSession) (bool, error) {
+	over := func() bool {
+		return (q.config.MaxBytes > 0 && s.bytes() > q.config.MaxBytes) ||
+			(q.config.MaxMessages > 0 && s.messages() > q.config.MaxMessages)
+	}
+
+	evicted := false
+	for over() {
+		if len(s.index.Segments) == 0 {
+			s.index.Segments = append(s.index.Segments, s.tail)
+			s.tail = OfflineSegment{}
+		}
+		seg := s.index.Segments[0]
+		// The index is written after the delete, a crash in between leaves it pointing at an empty segment
+		if err := q.store.DeletePrefix(ctx, offlineSegmentPrefix(clientID, seg.ID)); err != nil {
+			return evicted, err
+		}
+		s.index.Segments = s.index.Segments[1:]
+		s.index.Cursor = max(s.index.Cursor, seg.Last+1)
+		q.evicted.Add(uint64(seg.Messages))
+		q.evictedSegments.Add(1)
+		evicted = true
+	}
+	return evicted, nil
+}
+
+func (q *OfflineQueue) saveIndex(ctx context.Context, clientID string, s *offlineSession) error {
+	index := OfflineIndex{Cursor: s.index.Cursor, Segments: append([]OfflineSegment(nil), s.index.Segments...)}
+	return q.store.Save(ctx, offlineIndexKey(clientID), &OfflineRecord{Index: &index})
+}
+
+// Drain removes and returns up to n messages of clientID oldest first, n zero drains the whole queue
+// Fully delivered segments are deleted, the cursor of a partially delivered one is saved with the index
+func (q *OfflineQueue) Drain(ctx context.Context, clientID string, n int) ([]*QueuedMessage, error) {
+	s, err := q.session(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer s.mu.Unlock()
+
+	segments := s.index.Segments
+	if s.tail.Messages > 0 {
+		segments = append(segments[:len(segments):len(segments)], s.tail)
+	}
+
+	var msgs []*QueuedMessage
+	consumed := 0
+	for _, seg := range segments {
+		if n > 0 && len(msgs) >= n {
+			break
+		}
+		prefix := offlineSegmentPrefix(clientID, seg.ID)
+		after := ""
+		if s.index.Cursor > seg.ID {
+			after = offlineMessageKey(clientID, seg.ID, s.index.Cursor-1)
+		}
+		limit := 0
+		if n > 0 {
+			limit = n - len(msgs)
+		}
+		keys, err := store.ScanKeys(ctx, q.store, prefix, after, limit)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			record, err := q.store.Load(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+			if record != nil && record.Message != nil {
+				msgs = append(msgs, record.Message)
+			}
+			_, seq, err := parseOfflineMessageKey(clientID, key)
+			if err != nil {
+				return nil, err
+			}
+			s.index.Cursor = seq + 1
+		}
+		// A segment scanned to its end is done, even when a crash left it without messages
+		if limit == 0 || len(keys) < limit {
+			s.index.Cursor = max(s.index.Cursor, seg.Last+1)
+		}
+		if s.index.Cursor <= seg.Last {
+			break
+		}
+		if err := q.store.DeletePrefix(ctx, prefix); err != nil {
+			return nil, err
+		}
+		consumed++
+	}
+
+	if consumed > len(s.index.Segments) {
+		s.index.Segments = nil
+		s.tail = OfflineSegment{}
+	} else {
+		s.index.Segments = s.index.Segments[consumed:]
+	}
+	if len(msgs) == 0 && consumed == 0 {
+		return msgs, nil
+	}
+	q.delivered.Add(uint64(len(msgs)))
+	return msgs, q.saveIndex(ctx, clientID, s)
+}
+
+// Len returns the number of messages queued for clientID
+func (q *OfflineQueue) Len(ctx context.Context, clientID string) (int, error) {
+	s, err := q.session(ctx, clientID)
+	if err != nil {
+		return 0, err
+	}
+	defer s.mu.Unlock()
+	return s.pending(), nil
+}
+
+// Clear drops every message of clientID, e.g. when its session expires
+func (q *OfflineQueue) Clear(ctx context.Context, clientID string) error {
+	q.mu.Lock()
+	s, ok := q.sessions[clientID]
+	if !ok {
+		s = &offlineSession{}
+	}
+	delete(q.sessions, clientID)
+	q.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return q.store.DeletePrefix(ctx, offlinePrefix(clientID))
+}
+
+// Stats returns the counters of the queue
+func (q *OfflineQueue) Stats() OfflineQueueStats {
+	q.mu.Lock()
+	sessions := len(q.sessions)
+	q.mu.Unlock()
+
+	return OfflineQueueStats{
+		Sessions:        sessions,
+		Appended:        q.appended.Load(),
+		Delivered:       q.delivered.Load(),
+		Evicted:         q.evicted.Load(),
+		EvictedSegments: q.evictedSegments.Load(),
+	}
+}
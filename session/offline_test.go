@@ -0,0 +1,175 @@
+package session
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/axmq/ax/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// deleteCounter counts the single key and prefix deletes reaching a store
+type deleteCounter struct {
+	*store.MemoryStore[*OfflineRecord]
+	deletes       atomic.Int64
+	prefixDeletes atomic.Int64
+}
+
+func (c *deleteCounter) Delete(ctx context.Context, key string) error {
+	c.deletes.Add(1)
+	return c.MemoryStore.Delete(ctx, key)
+}
+
+func (c *deleteCounter) DeletePrefix(ctx context.Context, prefix string) error {
+	c.prefixDeletes.Add(1)
+	return c.MemoryStore.DeletePrefix(ctx, prefix)
+}
+
+func appendOffline(t *testing.T, q *OfflineQueue, clientID string, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		require.NoError(t, q.Append(context.Background(), clientID, &QueuedMessage{Topic: "a", Payload: []byte(strconv.Itoa(i))}))
+	}
+}
+
+func payloads(msgs []*QueuedMessage) []string {
+	out := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		out = append(out, string(msg.Payload))
+	}
+	return out
+}
+
+func TestOfflineQueue_Drain(t *testing.T) {
+	ctx := context.Background()
+	s := &deleteCounter{MemoryStore: store.NewMemoryStore[*OfflineRecord]()}
+	q := NewOfflineQueue(s, OfflineQueueConfig{SegmentMessages: 4})
+
+	appendOffline(t, q, "c1", 0, 10)
+	appendOffline(t, q, "c2", 0, 1)
+	n, err := q.Len(ctx, "c1")
+	require.NoError(t, err)
+	assert.Equal(t, 10, n)
+
+	msgs, err := q.Drain(ctx, "c1", 3)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0", "1", "2"}, payloads(msgs))
+	n, _ = q.Len(ctx, "c1")
+	assert.Equal(t, 7, n)
+	assert.Zero(t, s.prefixDeletes.Load())
+
+	msgs, err = q.Drain(ctx, "c1", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"3", "4"}, payloads(msgs))
+	assert.Equal(t, int64(1), s.prefixDeletes.Load())
+
+	msgs, err = q.Drain(ctx, "c1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"5", "6", "7", "8", "9"}, payloads(msgs))
+	n, _ = q.Len(ctx, "c1")
+	assert.Zero(t, n)
+
+	msgs, err = q.Drain(ctx, "c1", 0)
+	require.NoError(t, err)
+	assert.Empty(t, msgs)
+
+	// Messages are only ever removed a segment at a time
+	assert.Zero(t, s.deletes.Load())
+	assert.Equal(t, int64(3), s.prefixDeletes.Load())
+
+	appendOffline(t, q, "c1", 10, 12)
+	msgs, err = q.Drain(ctx, "c1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10", "11"}, payloads(msgs))
+
+	msgs, err = q.Drain(ctx, "c2", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0"}, payloads(msgs))
+
+	stats := q.Stats()
+	assert.Equal(t, 2, stats.Sessions)
+	assert.Equal(t, uint64(13), stats.Appended)
+	assert.Equal(t, uint64(13), stats.Delivered)
+}
+
+func TestOfflineQueue_Eviction(t *testing.T) {
+	ctx := context.Background()
+	s := &deleteCounter{MemoryStore: store.NewMemoryStore[*OfflineRecord]()}
+	q := NewOfflineQueue(s, OfflineQueueConfig{SegmentMessages: 4, MaxMessages: 8})
+
+	appendOffline(t, q, "c1", 0, 20)
+	n, err := q.Len(ctx, "c1")
+	require.NoError(t, err)
+	assert.Equal(t, 8, n)
+
+	msgs, err := q.Drain(ctx, "c1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"12", "13", "14", "15", "16", "17", "18", "19"}, payloads(msgs))
+	assert.Zero(t, s.deletes.Load())
+
+	stats := q.Stats()
+	assert.Equal(t, uint64(12), stats.Evicted)
+	assert.Equal(t, uint64(3), stats.EvictedSegments)
+
+	// The bytes limit evicts too, a segment alone over it is dropped as a whole
+	q = NewOfflineQueue(store.NewMemoryStore[*OfflineRecord](), OfflineQueueConfig{SegmentMessages: 4, MaxBytes: 10})
+	for i := range 4 {
+		require.NoError(t, q.Append(ctx, "c1", &QueuedMessage{Topic: "a", Payload: []byte{byte(i), 1, 2, 3}}))
+	}
+	n, _ = q.Len(ctx, "c1")
+	assert.Equal(t, 1, n)
+	assert.Equal(t, uint64(3), q.Stats().Evicted)
+}
+
+func TestOfflineQueue_Recovery(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemoryStore[*OfflineRecord]()
+	config := OfflineQueueConfig{SegmentMessages: 4}
+
+	appendOffline(t, NewOfflineQueue(s, config), "c1", 0, 10)
+
+	// A new queue recovers the sealed segments from the index and the open one from its keys
+	q := NewOfflineQueue(s, config)
+	n, err := q.Len(ctx, "c1")
+	require.NoError(t, err)
+	assert.Equal(t, 10, n)
+	msgs, err := q.Drain(ctx, "c1", 5)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0", "1", "2", "3", "4"}, payloads(msgs))
+
+	q = NewOfflineQueue(s, config)
+	n, err = q.Len(ctx, "c1")
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	appendOffline(t, q, "c1", 10, 12)
+
+	q = NewOfflineQueue(s, config)
+	msgs, err = q.Drain(ctx, "c1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"5", "6", "7", "8", "9", "10", "11"}, payloads(msgs))
+
+	appendOffline(t, q, "c1", 12, 13)
+	require.NoError(t, q.Clear(ctx, "c1"))
+	count, err := s.Count(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, count)
+	n, err = NewOfflineQueue(s, config).Len(ctx, "c1")
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func BenchmarkOfflineQueue_Append(b *testing.B) {
+	ctx := context.Background()
+	q := NewOfflineQueue(store.NewMemoryStore[*OfflineRecord](), OfflineQueueConfig{MaxMessages: 100000})
+	msg := &QueuedMessage{Topic: "devices/1/telemetry", Payload: make([]byte, 256)}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		if err := q.Append(ctx, "c1", msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package session
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// QueuedMessage is a message waiting for delivery to a slow or offline client
+type QueuedMessage struct {
+	Topic      string
+	Payload    []byte
+	QoS        byte
+	Retain     bool
+	Properties map[string]interface{}
+	Timestamp  time.Time
+	// LastValue replaces the queued last value message of the same topic instead of queueing behind it,
+	// set it for messages matched by a last value subscription
+	LastValue bool
+}
+
+// QueueConfig configures a message queue
+type QueueConfig struct {
+	// MaxLen bounds the queued messages, zero leaves the queue unbounded
+	MaxLen int
+	// DropOldest makes room for a message in a full queue by dropping the oldest one instead of rejecting it
+	DropOldest bool
+}
+
+func DefaultQueueConfig() QueueConfig {
+	return QueueConfig{
+		MaxLen: 1000,
+	}
+}
+
+// QueueStats holds the counters of a message queue
+type QueueStats struct {
+	Len      int
+	Enqueued uint64
+	Replaced uint64 // last value messages superseded by a newer message of their topic
+	Dropped  uint64
+}
+
+// Queue holds the messages of a client that are not delivered yet in arrival order. Last value messages
+// keep only the newest message per topic, so a dashboard falling behind catches up with current values
+// instead of working through a backlog. A replaced message keeps its place in the queue
+type Queue struct {
+	config QueueConfig
+
+	mu        sync.Mutex
+	messages  *list.List               // of *QueuedMessage, oldest first
+	lastValue map[string]*list.Element // topic -> queued last value message
+	enqueued  uint64
+	replaced  uint64
+	dropped   uint64
+}
+
+// NewQueue creates a message queue
+func NewQueue(config QueueConfig) *Queue {
+	if config.MaxLen < 0 {
+		config.MaxLen = 0
+	}
+	return &Queue{
+		config:    config,
+		messages:  list.New(),
+		lastValue: make(map[string]*list.Element),
+	}
+}
+
+// Enqueue queues msg, it returns ErrQueueFull when the queue is full and does not drop the oldest message
+func (q *Queue) Enqueue(msg *QueuedMessage) error {
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if msg.LastValue {
+		if elem, ok := q.lastValue[msg.Topic]; ok {
+			elem.Value = msg
+			q.enqueued++
+			q.replaced++
+			return nil
+		}
+	}
+
+	if q.config.MaxLen > 0 && q.messages.Len() >= q.config.MaxLen {
+		if !q.config.DropOldest {
+			q.dropped++
+			return ErrQueueFull
+		}
+		q.removeLocked(q.messages.Front())
+		q.dropped++
+	}
+
+	elem := q.messages.PushBack(msg)
+	if msg.LastValue {
+		q.lastValue[msg.Topic] = elem
+	}
+	q.enqueued++
+	return nil
+}
+
+// Dequeue removes and returns the oldest message, ok is false for an empty queue
+func (q *Queue) Dequeue() (msg *QueuedMessage, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	front := q.messages.Front()
+	if front == nil {
+		return nil, false
+	}
+	return q.removeLocked(front), true
+}
+
+// Drain removes and returns up to max messages oldest first, max zero drains the whole queue
+func (q *Queue) Drain(max int) []*QueuedMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	n := q.messages.Len()
+	if max > 0 && max < n {
+		n = max
+	}
+	msgs := make([]*QueuedMessage, 0, n)
+	for len(msgs) < n {
+		msgs = append(msgs, q.removeLocked(q.messages.Front()))
+	}
+	return msgs
+}
+
+// Len returns the number of queued messages
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.messages.Len()
+}
+
+// OldestAge returns how long the oldest message has been queued, zero for an empty queue
+// Report it with Len to the slow consumer detector
+func (q *Queue) OldestAge() time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	front := q.messages.Front()
+	if front == nil {
+		return 0
+	}
+	return time.Since(front.Value.(*QueuedMessage).Timestamp)
+}
+
+// Clear drops every queued message
+func (q *Queue) Clear() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.messages.Init()
+	clear(q.lastValue)
+}
+
+// Stats returns the queue length and counters
+func (q *Queue) Stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return QueueStats{
+		Len:      q.messages.Len(),
+		Enqueued: q.enqueued,
+		Replaced: q.replaced,
+		Dropped:  q.dropped,
+	}
+}
+
+func (q *Queue) removeLocked(elem *list.Element) *QueuedMessage {
+	msg := q.messages.Remove(elem).(*QueuedMessage)
+	if q.lastValue[msg.Topic] == elem {
+		delete(q.lastValue, msg.Topic)
+	}
+	return msg
+}
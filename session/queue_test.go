@@ -0,0 +1,88 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func queuedTopics(msgs []*QueuedMessage) []string {
+	topics := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		topics = append(topics, msg.Topic+"="+string(msg.Payload))
+	}
+	return topics
+}
+
+func TestQueue_FIFO(t *testing.T) {
+	q := NewQueue(QueueConfig{})
+	for _, payload := range []string{"1", "2", "3"} {
+		require.NoError(t, q.Enqueue(&QueuedMessage{Topic: "a", Payload: []byte(payload)}))
+	}
+	assert.Equal(t, 3, q.Len())
+
+	msg, ok := q.Dequeue()
+	require.True(t, ok)
+	assert.Equal(t, "1", string(msg.Payload))
+	assert.Equal(t, []string{"a=2", "a=3"}, queuedTopics(q.Drain(0)))
+
+	_, ok = q.Dequeue()
+	assert.False(t, ok)
+	assert.Zero(t, q.OldestAge())
+}
+
+func TestQueue_LastValue(t *testing.T) {
+	q := NewQueue(QueueConfig{MaxLen: 3})
+	require.NoError(t, q.Enqueue(&QueuedMessage{Topic: "temp", Payload: []byte("20"), LastValue: true}))
+	require.NoError(t, q.Enqueue(&QueuedMessage{Topic: "log", Payload: []byte("x")}))
+	require.NoError(t, q.Enqueue(&QueuedMessage{Topic: "temp", Payload: []byte("21"), LastValue: true}))
+	require.NoError(t, q.Enqueue(&QueuedMessage{Topic: "hum", Payload: []byte("40"), LastValue: true}))
+
+	// Replacing keeps the queue within MaxLen however many updates arrive
+	for i := 0; i < 10; i++ {
+		require.NoError(t, q.Enqueue(&QueuedMessage{Topic: "temp", Payload: []byte("22"), LastValue: true}))
+	}
+	assert.Equal(t, 3, q.Len())
+
+	stats := q.Stats()
+	assert.Equal(t, uint64(14), stats.Enqueued)
+	assert.Equal(t, uint64(11), stats.Replaced)
+	assert.Zero(t, stats.Dropped)
+
+	assert.Equal(t, []string{"temp=22", "log=x"}, queuedTopics(q.Drain(2)))
+
+	// A delivered last value message is no longer replaced
+	require.NoError(t, q.Enqueue(&QueuedMessage{Topic: "temp", Payload: []byte("23"), LastValue: true}))
+	assert.Equal(t, []string{"hum=40", "temp=23"}, queuedTopics(q.Drain(0)))
+}
+
+func TestQueue_Full(t *testing.T) {
+	q := NewQueue(QueueConfig{MaxLen: 2})
+	require.NoError(t, q.Enqueue(&QueuedMessage{Topic: "a", LastValue: true}))
+	require.NoError(t, q.Enqueue(&QueuedMessage{Topic: "b"}))
+	assert.ErrorIs(t, q.Enqueue(&QueuedMessage{Topic: "c"}), ErrQueueFull)
+	require.NoError(t, q.Enqueue(&QueuedMessage{Topic: "a", Payload: []byte("new"), LastValue: true}))
+
+	q = NewQueue(QueueConfig{MaxLen: 2, DropOldest: true})
+	require.NoError(t, q.Enqueue(&QueuedMessage{Topic: "a", LastValue: true}))
+	require.NoError(t, q.Enqueue(&QueuedMessage{Topic: "b"}))
+	require.NoError(t, q.Enqueue(&QueuedMessage{Topic: "c"}))
+	assert.Equal(t, uint64(1), q.Stats().Dropped)
+
+	// The dropped last value message no longer takes updates
+	require.NoError(t, q.Enqueue(&QueuedMessage{Topic: "a", LastValue: true}))
+	assert.Equal(t, []string{"c=", "a="}, queuedTopics(q.Drain(0)))
+}
+
+func TestQueue_OldestAge(t *testing.T) {
+	q := NewQueue(DefaultQueueConfig())
+	require.NoError(t, q.Enqueue(&QueuedMessage{Topic: "a", Timestamp: time.Now().Add(-time.Minute)}))
+	require.NoError(t, q.Enqueue(&QueuedMessage{Topic: "b"}))
+	assert.GreaterOrEqual(t, q.OldestAge(), time.Minute)
+
+	q.Clear()
+	assert.Zero(t, q.Len())
+	assert.Zero(t, q.OldestAge())
+}
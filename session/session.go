@@ -2,6 +2,7 @@ package session
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -57,6 +58,18 @@ type Session struct {
 
 	// Protocol version
 	ProtocolVersion byte
+
+	// Client metadata (e.g. tenant, firmware version), survives clean start
+	Metadata map[string]string
+
+	// Listener and transport of the latest connection, used to detect roaming clients
+	Connection ConnectionInfo
+
+	// Cold marks a session demoted by Tiering, its subscriptions are not routed until it is rehydrated
+	Cold bool
+
+	// Traffic counters, not persisted
+	stats atomic.Pointer[Stats]
 }
 
 // Subscription represents a topic subscription
@@ -68,6 +81,10 @@ type Subscription struct {
 	RetainHandling         byte
 	SubscriptionIdentifier uint32
 	SubscribedAt           time.Time
+	// Managed marks a subscription attached by the broker on behalf of the client, client UNSUBSCRIBE ignores it
+	Managed bool
+	// LastValue queues only the newest message per topic while the client is slow or offline
+	LastValue bool
 }
 
 // PendingMessage represents a message waiting for acknowledgment
@@ -99,6 +116,7 @@ func New(clientID string, cleanStart bool, expiryInterval uint32, protocolVersio
 		nextPacketID:    1,
 		ReceiveMaximum:  65535, // Default maximum
 		ProtocolVersion: protocolVersion,
+		Metadata:        make(map[string]string),
 	}
 }
 
@@ -108,6 +126,16 @@ func (s *Session) SetActive() {
 	defer s.mu.Unlock()
 	s.State = StateActive
 	s.LastAccessedAt = time.Now()
+	s.Stats().MarkConnected()
+}
+
+// Stats returns the session traffic counters
+func (s *Session) Stats() *Stats {
+	if stats := s.stats.Load(); stats != nil {
+		return stats
+	}
+	s.stats.CompareAndSwap(nil, &Stats{})
+	return s.stats.Load()
 }
 
 // SetDisconnected marks the session as disconnected
@@ -118,11 +146,12 @@ func (s *Session) SetDisconnected() {
 	s.DisconnectedAt = time.Now()
 }
 
-// SetExpired marks the session as expired
+// SetExpired marks the session as expired and resets its counters
 func (s *Session) SetExpired() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.State = StateExpired
+	s.Stats().Reset()
 }
 
 // IsExpired checks if the session has expired
@@ -141,6 +170,29 @@ func (s *Session) IsExpired() bool {
 	return s.State == StateExpired
 }
 
+// NextDeadline returns when a disconnected session next needs attention from the expiry sweeper,
+// the earlier of its delayed will and its expiry
+func (s *Session) NextDeadline() (time.Time, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.State != StateDisconnected {
+		return time.Time{}, false
+	}
+
+	var deadline time.Time
+	if s.ExpiryInterval > 0 {
+		deadline = s.DisconnectedAt.Add(time.Duration(s.ExpiryInterval) * time.Second)
+	}
+	if s.WillMessage != nil && s.WillDelayInterval > 0 {
+		willAt := s.DisconnectedAt.Add(time.Duration(s.WillDelayInterval) * time.Second)
+		if deadline.IsZero() || willAt.Before(deadline) {
+			deadline = willAt
+		}
+	}
+	return deadline, !deadline.IsZero()
+}
+
 // Touch updates the last accessed time
 func (s *Session) Touch() {
 	s.mu.Lock()
@@ -200,6 +252,22 @@ func (s *Session) RemoveSubscription(topicFilter string) {
 	delete(s.Subscriptions, topicFilter)
 }
 
+// Unsubscribe removes a subscription on behalf of the client, broker-managed subscriptions are kept
+// and reported as ErrManagedSubscription
+func (s *Session) Unsubscribe(topicFilter string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.Subscriptions[topicFilter]
+	if !ok {
+		return ErrSubscriptionNotFound
+	}
+	if sub.Managed {
+		return ErrManagedSubscription
+	}
+	delete(s.Subscriptions, topicFilter)
+	return nil
+}
+
 // GetSubscription returns a subscription by topic filter
 func (s *Session) GetSubscription(topicFilter string) (*Subscription, bool) {
 	s.mu.RLock()
@@ -282,6 +350,43 @@ func (s *Session) GetAllPendingPublish() map[uint16]*PendingMessage {
 	return msgs
 }
 
+// MarkPendingDUP flags every outbound QoS message for redelivery with the DUP flag set
+func (s *Session) MarkPendingDUP() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, msg := range s.PendingPublish {
+		msg.DUP = true
+	}
+}
+
+// SetConnection records the listener and transport of the current connection
+func (s *Session) SetConnection(conn ConnectionInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Connection = conn
+}
+
+// SetCold marks the session as demoted to the cold tier or rehydrated from it
+func (s *Session) SetCold(cold bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Cold = cold
+}
+
+// IsCold reports whether the session is in the cold tier
+func (s *Session) IsCold() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Cold
+}
+
+// GetConnection returns the listener and transport of the latest connection
+func (s *Session) GetConnection() ConnectionInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Connection
+}
+
 // AddPendingPubrel adds a pending PUBREL marker
 func (s *Session) AddPendingPubrel(packetID uint16) {
 	s.mu.Lock()
@@ -335,6 +440,7 @@ func (s *Session) Clear() {
 	s.PendingPubrel = make(map[uint16]struct{})
 	s.PendingPubcomp = make(map[uint16]struct{})
 	s.WillMessage = nil
+	s.Stats().Reset()
 }
 
 // GetState returns the current state
@@ -371,3 +477,62 @@ func (s *Session) UpdateExpiryInterval(interval uint32) {
 	defer s.mu.Unlock()
 	s.ExpiryInterval = interval
 }
+
+// SetMetadata sets a metadata value
+func (s *Session) SetMetadata(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Metadata == nil {
+		s.Metadata = make(map[string]string)
+	}
+	s.Metadata[key] = value
+}
+
+// MergeMetadata sets all given metadata values
+func (s *Session) MergeMetadata(metadata map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Metadata == nil {
+		s.Metadata = make(map[string]string, len(metadata))
+	}
+	for k, v := range metadata {
+		s.Metadata[k] = v
+	}
+}
+
+// GetMetadata returns a metadata value
+func (s *Session) GetMetadata(key string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.Metadata[key]
+	return value, ok
+}
+
+// DeleteMetadata removes a metadata value
+func (s *Session) DeleteMetadata(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.Metadata, key)
+}
+
+// GetAllMetadata returns a copy of all metadata
+func (s *Session) GetAllMetadata() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	metadata := make(map[string]string, len(s.Metadata))
+	for k, v := range s.Metadata {
+		metadata[k] = v
+	}
+	return metadata
+}
+
+func (s *Session) matchesMetadata(selector map[string]string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for k, v := range selector {
+		if actual, ok := s.Metadata[k]; !ok || actual != v {
+			return false
+		}
+	}
+	return true
+}
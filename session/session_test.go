@@ -423,3 +423,72 @@ func TestSession_ConcurrentAccess(t *testing.T) {
 		<-done
 	}
 }
+
+func TestSession_Metadata(t *testing.T) {
+	session := New("client1", true, 300, 5)
+	assert.NotNil(t, session.Metadata)
+
+	session.SetMetadata("tenant", "acme")
+	session.MergeMetadata(map[string]string{"firmware": "1.0", "region": "eu"})
+
+	value, ok := session.GetMetadata("tenant")
+	assert.True(t, ok)
+	assert.Equal(t, "acme", value)
+
+	all := session.GetAllMetadata()
+	assert.Equal(t, map[string]string{"tenant": "acme", "firmware": "1.0", "region": "eu"}, all)
+	all["tenant"] = "changed"
+	value, _ = session.GetMetadata("tenant")
+	assert.Equal(t, "acme", value)
+
+	session.DeleteMetadata("region")
+	_, ok = session.GetMetadata("region")
+	assert.False(t, ok)
+
+	session.Clear()
+	assert.Len(t, session.GetAllMetadata(), 2)
+
+	empty := &Session{}
+	empty.SetMetadata("tenant", "acme")
+	assert.Equal(t, "acme", empty.Metadata["tenant"])
+}
+
+func TestSession_NextDeadline(t *testing.T) {
+	disconnectedAt := time.Unix(1000, 0)
+
+	tests := []struct {
+		name      string
+		expiry    uint32
+		will      bool
+		willDelay uint32
+		want      time.Time
+		wantOK    bool
+	}{
+		{name: "no expiry", wantOK: false},
+		{name: "expiry only", expiry: 60, want: disconnectedAt.Add(60 * time.Second), wantOK: true},
+		{name: "will before expiry", expiry: 60, will: true, willDelay: 10, want: disconnectedAt.Add(10 * time.Second), wantOK: true},
+		{name: "expiry before will", expiry: 5, will: true, willDelay: 10, want: disconnectedAt.Add(5 * time.Second), wantOK: true},
+		{name: "delayed will without expiry", will: true, willDelay: 10, want: disconnectedAt.Add(10 * time.Second), wantOK: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New("client1", false, tt.expiry, 5)
+			if tt.will {
+				s.SetWillMessage(&WillMessage{Topic: "t"}, tt.willDelay)
+			}
+			s.State = StateDisconnected
+			s.DisconnectedAt = disconnectedAt
+
+			got, ok := s.NextDeadline()
+			assert.Equal(t, tt.wantOK, ok)
+			assert.True(t, tt.want.Equal(got))
+		})
+	}
+
+	t.Run("active session", func(t *testing.T) {
+		s := New("client1", false, 60, 5)
+		_, ok := s.NextDeadline()
+		assert.False(t, ok)
+	})
+}
@@ -0,0 +1,132 @@
+package session
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats holds per-session traffic counters
+// All methods are safe for concurrent use and lock-free
+type Stats struct {
+	messagesSent     [3]atomic.Uint64 // indexed by QoS
+	messagesReceived [3]atomic.Uint64 // indexed by QoS
+	bytesSent        atomic.Uint64
+	bytesReceived    atomic.Uint64
+	dropped          atomic.Uint64
+	retries          atomic.Uint64
+	lastActivity     atomic.Int64 // unix nanoseconds
+	connectedAt      atomic.Int64 // unix nanoseconds
+}
+
+// StatsSnapshot is a point-in-time copy of session counters
+type StatsSnapshot struct {
+	MessagesSent     [3]uint64
+	MessagesReceived [3]uint64
+	BytesSent        uint64
+	BytesReceived    uint64
+	Dropped          uint64
+	Retries          uint64
+	LastActivity     time.Time
+	ConnectedAt      time.Time
+}
+
+// RecordSent records an outbound message
+func (s *Stats) RecordSent(qos byte, size int) {
+	if qos < 3 {
+		s.messagesSent[qos].Add(1)
+	}
+	s.bytesSent.Add(uint64(size))
+	s.touch()
+}
+
+// RecordReceived records an inbound message
+func (s *Stats) RecordReceived(qos byte, size int) {
+	if qos < 3 {
+		s.messagesReceived[qos].Add(1)
+	}
+	s.bytesReceived.Add(uint64(size))
+	s.touch()
+}
+
+// RecordDrop records a dropped message
+func (s *Stats) RecordDrop() {
+	s.dropped.Add(1)
+}
+
+// RecordRetry records a redelivery attempt
+func (s *Stats) RecordRetry() {
+	s.retries.Add(1)
+}
+
+// MarkConnected records the connect time
+func (s *Stats) MarkConnected() {
+	now := time.Now().UnixNano()
+	s.connectedAt.Store(now)
+	s.lastActivity.Store(now)
+}
+
+// Reset clears all counters
+func (s *Stats) Reset() {
+	for i := range s.messagesSent {
+		s.messagesSent[i].Store(0)
+		s.messagesReceived[i].Store(0)
+	}
+	s.bytesSent.Store(0)
+	s.bytesReceived.Store(0)
+	s.dropped.Store(0)
+	s.retries.Store(0)
+	s.lastActivity.Store(0)
+	s.connectedAt.Store(0)
+}
+
+// Snapshot returns a copy of the current counters
+func (s *Stats) Snapshot() StatsSnapshot {
+	var snap StatsSnapshot
+	for i := range s.messagesSent {
+		snap.MessagesSent[i] = s.messagesSent[i].Load()
+		snap.MessagesReceived[i] = s.messagesReceived[i].Load()
+	}
+	snap.BytesSent = s.bytesSent.Load()
+	snap.BytesReceived = s.bytesReceived.Load()
+	snap.Dropped = s.dropped.Load()
+	snap.Retries = s.retries.Load()
+	snap.LastActivity = unixNanoTime(s.lastActivity.Load())
+	snap.ConnectedAt = unixNanoTime(s.connectedAt.Load())
+	return snap
+}
+
+func (s *Stats) touch() {
+	s.lastActivity.Store(time.Now().UnixNano())
+}
+
+// TotalSent returns the number of messages sent across all QoS levels
+func (s StatsSnapshot) TotalSent() uint64 {
+	return s.MessagesSent[0] + s.MessagesSent[1] + s.MessagesSent[2]
+}
+
+// TotalReceived returns the number of messages received across all QoS levels
+func (s StatsSnapshot) TotalReceived() uint64 {
+	return s.MessagesReceived[0] + s.MessagesReceived[1] + s.MessagesReceived[2]
+}
+
+// Add accumulates another snapshot into this one, keeping the latest activity time
+func (s *StatsSnapshot) Add(other StatsSnapshot) {
+	for i := range s.MessagesSent {
+		s.MessagesSent[i] += other.MessagesSent[i]
+		s.MessagesReceived[i] += other.MessagesReceived[i]
+	}
+	s.BytesSent += other.BytesSent
+	s.BytesReceived += other.BytesReceived
+	s.Dropped += other.Dropped
+	s.Retries += other.Retries
+	if other.LastActivity.After(s.LastActivity) {
+		s.LastActivity = other.LastActivity
+	}
+}
+
+func unixNanoTime(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
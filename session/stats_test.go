@@ -0,0 +1,112 @@
+package session
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/axmq/ax/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats_Record(t *testing.T) {
+	stats := &Stats{}
+
+	stats.RecordSent(0, 10)
+	stats.RecordSent(1, 20)
+	stats.RecordSent(1, 5)
+	stats.RecordReceived(2, 100)
+	stats.RecordReceived(3, 7) // invalid QoS only counts bytes
+	stats.RecordDrop()
+	stats.RecordRetry()
+	stats.RecordRetry()
+
+	snap := stats.Snapshot()
+	assert.Equal(t, [3]uint64{1, 2, 0}, snap.MessagesSent)
+	assert.Equal(t, [3]uint64{0, 0, 1}, snap.MessagesReceived)
+	assert.Equal(t, uint64(35), snap.BytesSent)
+	assert.Equal(t, uint64(107), snap.BytesReceived)
+	assert.Equal(t, uint64(1), snap.Dropped)
+	assert.Equal(t, uint64(2), snap.Retries)
+	assert.Equal(t, uint64(3), snap.TotalSent())
+	assert.Equal(t, uint64(1), snap.TotalReceived())
+	assert.False(t, snap.LastActivity.IsZero())
+	assert.True(t, snap.ConnectedAt.IsZero())
+
+	stats.MarkConnected()
+	assert.False(t, stats.Snapshot().ConnectedAt.IsZero())
+
+	stats.Reset()
+	assert.Equal(t, StatsSnapshot{}, stats.Snapshot())
+}
+
+func TestStats_ConcurrentRecord(t *testing.T) {
+	stats := &Stats{}
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				stats.RecordSent(1, 1)
+				stats.RecordReceived(0, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	snap := stats.Snapshot()
+	assert.Equal(t, uint64(1000), snap.MessagesSent[1])
+	assert.Equal(t, uint64(1000), snap.BytesReceived)
+}
+
+func TestSession_StatsLifecycle(t *testing.T) {
+	session := New("client1", false, 300, 5)
+	assert.Same(t, session.Stats(), session.Stats())
+
+	session.SetActive()
+	assert.False(t, session.Stats().Snapshot().ConnectedAt.IsZero())
+
+	session.Stats().RecordSent(1, 10)
+	session.Clear()
+	assert.Equal(t, uint64(0), session.Stats().Snapshot().TotalSent())
+
+	session.Stats().RecordSent(1, 10)
+	session.SetExpired()
+	assert.Equal(t, uint64(0), session.Stats().Snapshot().TotalSent())
+
+	empty := &Session{}
+	assert.NotNil(t, empty.Stats())
+}
+
+func TestManager_SessionStats(t *testing.T) {
+	ctx := context.Background()
+	manager := NewManager(ManagerConfig{Store: store.NewMemoryStore[*Session]()})
+	defer manager.Close()
+
+	_, err := manager.GetSessionStats(ctx, "missing")
+	assert.Error(t, err)
+
+	s1, _, err := manager.CreateSession(ctx, "client1", false, 300, 5)
+	require.NoError(t, err)
+	s2, _, err := manager.CreateSession(ctx, "client2", false, 300, 5)
+	require.NoError(t, err)
+
+	s1.Stats().RecordSent(1, 10)
+	s1.Stats().RecordReceived(0, 4)
+	s2.Stats().RecordSent(2, 20)
+	s2.Stats().RecordDrop()
+
+	snap, err := manager.GetSessionStats(ctx, "client1")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(10), snap.BytesSent)
+
+	total := manager.AggregateStats()
+	assert.Equal(t, [3]uint64{0, 1, 1}, total.MessagesSent)
+	assert.Equal(t, uint64(30), total.BytesSent)
+	assert.Equal(t, uint64(4), total.BytesReceived)
+	assert.Equal(t, uint64(1), total.Dropped)
+	assert.False(t, total.LastActivity.IsZero())
+}
@@ -0,0 +1,85 @@
+package session
+
+import (
+	"github.com/axmq/ax/encoding"
+)
+
+// TakeoverPolicy decides which connection wins when a client connects with the ID of an active session
+type TakeoverPolicy byte
+
+const (
+	// TakeoverPolicyReplace lets the new connection take over and disconnects the existing one
+	TakeoverPolicyReplace TakeoverPolicy = iota
+	// TakeoverPolicyRejectNew keeps the existing connection and rejects the new CONNECT,
+	// avoiding two devices with duplicated credentials kicking each other off in a loop
+	TakeoverPolicyRejectNew
+)
+
+// String returns the string representation of the policy
+func (p TakeoverPolicy) String() string {
+	switch p {
+	case TakeoverPolicyReplace:
+		return "replace"
+	case TakeoverPolicyRejectNew:
+		return "reject_new"
+	default:
+		return "unknown"
+	}
+}
+
+// ConnectionInfo identifies the listener and transport a client connection arrived on
+type ConnectionInfo struct {
+	Listener   string // listener ID, e.g. "tcp-1883"
+	Transport  string // transport kind, e.g. "tcp", "ws", "quic"
+	RemoteAddr string
+}
+
+// IsZero reports whether the connection is unknown
+func (c ConnectionInfo) IsZero() bool {
+	return c == ConnectionInfo{}
+}
+
+// roamedFrom reports whether c arrived on a different listener or transport than previous
+// Both connections must be known, a reconnect over the same listener from a new address is not roaming
+func (c ConnectionInfo) roamedFrom(previous ConnectionInfo) bool {
+	if c.IsZero() || previous.IsZero() {
+		return false
+	}
+	return c.Listener != previous.Listener || c.Transport != previous.Transport
+}
+
+// TakeoverDecision describes how a takeover is resolved
+// It is handed to the OnTakeover callback, which may change Reject and ReasonCode
+type TakeoverDecision struct {
+	ClientID   string
+	Policy     TakeoverPolicy
+	Active     bool // whether the existing session still has a connected client
+	Reject     bool
+	ReasonCode encoding.ReasonCode // CONNACK reason code sent when the new connection is rejected
+
+	// Roaming is set when the client reconnects on a different listener or transport than Previous
+	Roaming  bool
+	Previous ConnectionInfo
+	Current  ConnectionInfo
+}
+
+// RejectError returns the error rejecting the new connection, a PacketError wrapping ErrTakeoverRejected that
+// carries the CONNACK reason code, or nil when the takeover goes ahead
+func (d *TakeoverDecision) RejectError() error {
+	if !d.Reject {
+		return nil
+	}
+	return &encoding.PacketError{
+		Err:        ErrTakeoverRejected,
+		ReasonCode: rejectReason(d.ReasonCode),
+		Message:    d.ClientID,
+	}
+}
+
+// rejectReason returns code if it is an error reason code allowed in CONNACK, ReasonClientIdentifierNotValid otherwise
+func rejectReason(code encoding.ReasonCode) encoding.ReasonCode {
+	if code.IsError() && code.IsValidFor(encoding.CONNACK) {
+		return code
+	}
+	return encoding.ReasonClientIdentifierNotValid
+}
@@ -0,0 +1,238 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/axmq/ax/encoding"
+	"github.com/axmq/ax/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTakeoverPolicy_String(t *testing.T) {
+	assert.Equal(t, "replace", TakeoverPolicyReplace.String())
+	assert.Equal(t, "reject_new", TakeoverPolicyRejectNew.String())
+	assert.Equal(t, "unknown", TakeoverPolicy(99).String())
+}
+
+func TestManager_TakeoverPolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     ManagerConfig
+		active     bool
+		wantReject bool
+		wantReason encoding.ReasonCode
+	}{
+		{
+			name:   "replace active session",
+			config: ManagerConfig{TakeoverPolicy: TakeoverPolicyReplace},
+			active: true,
+		},
+		{
+			name:       "reject new on active session",
+			config:     ManagerConfig{TakeoverPolicy: TakeoverPolicyRejectNew},
+			active:     true,
+			wantReject: true,
+			wantReason: encoding.ReasonClientIdentifierNotValid,
+		},
+		{
+			name: "reject new with not authorized",
+			config: ManagerConfig{
+				TakeoverPolicy:       TakeoverPolicyRejectNew,
+				TakeoverRejectReason: encoding.ReasonNotAuthorized,
+			},
+			active:     true,
+			wantReject: true,
+			wantReason: encoding.ReasonNotAuthorized,
+		},
+		{
+			name: "invalid reject reason falls back",
+			config: ManagerConfig{
+				TakeoverPolicy:       TakeoverPolicyRejectNew,
+				TakeoverRejectReason: encoding.ReasonSessionTakenOver,
+			},
+			active:     true,
+			wantReject: true,
+			wantReason: encoding.ReasonClientIdentifierNotValid,
+		},
+		{
+			name:   "reject new on disconnected session",
+			config: ManagerConfig{TakeoverPolicy: TakeoverPolicyRejectNew},
+			active: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Store = store.NewMemoryStore[*Session]()
+			m := NewManager(tt.config)
+			defer m.Close()
+
+			ctx := context.Background()
+			s, _, err := m.CreateSession(ctx, "client1", false, 300, 5)
+			require.NoError(t, err)
+			s.SetWillMessage(&WillMessage{Topic: "client/status", Payload: []byte("offline")}, 0)
+			if tt.active {
+				s.SetActive()
+			} else {
+				s.SetDisconnected()
+			}
+
+			err = m.TakeoverSession(ctx, "client1")
+			if !tt.wantReject {
+				assert.NoError(t, err)
+				assert.Nil(t, s.GetWillMessage())
+				return
+			}
+
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, ErrTakeoverRejected))
+			assert.Equal(t, tt.wantReason, encoding.FromError(err))
+			assert.NotNil(t, s.GetWillMessage())
+		})
+	}
+}
+
+func TestManager_TakeoverCallback(t *testing.T) {
+	var seen TakeoverDecision
+	m := NewManager(ManagerConfig{
+		Store: store.NewMemoryStore[*Session](),
+		OnTakeover: func(d *TakeoverDecision) {
+			seen = *d
+			d.Reject = true
+			d.ReasonCode = encoding.ReasonNotAuthorized
+		},
+	})
+	defer m.Close()
+
+	ctx := context.Background()
+	s, _, err := m.CreateSession(ctx, "client1", false, 300, 5)
+	require.NoError(t, err)
+	s.SetActive()
+
+	err = m.TakeoverSession(ctx, "client1")
+	assert.True(t, errors.Is(err, ErrTakeoverRejected))
+	assert.Equal(t, encoding.ReasonNotAuthorized, encoding.FromError(err))
+	assert.Equal(t, TakeoverDecision{
+		ClientID:   "client1",
+		Policy:     TakeoverPolicyReplace,
+		Active:     true,
+		ReasonCode: encoding.ReasonClientIdentifierNotValid,
+	}, seen)
+
+	// A missing session never reaches the callback
+	seen = TakeoverDecision{}
+	assert.NoError(t, m.TakeoverSession(ctx, "missing"))
+	assert.Empty(t, seen.ClientID)
+}
+
+func TestConnectionInfo_Roaming(t *testing.T) {
+	tcp := ConnectionInfo{Listener: "lte", Transport: "tcp", RemoteAddr: "10.0.0.1:4000"}
+	ws := ConnectionInfo{Listener: "wifi", Transport: "ws", RemoteAddr: "192.168.1.5:5000"}
+
+	assert.True(t, ws.roamedFrom(tcp))
+	assert.False(t, tcp.roamedFrom(ConnectionInfo{}))
+	assert.False(t, ConnectionInfo{}.roamedFrom(tcp))
+	assert.False(t, ConnectionInfo{Listener: "lte", Transport: "tcp", RemoteAddr: "10.0.0.9:4000"}.roamedFrom(tcp))
+	assert.True(t, ConnectionInfo{}.IsZero())
+}
+
+func TestManager_TakeoverSessionFromRoaming(t *testing.T) {
+	bus := NewEventBus()
+	var events []Event
+	bus.Subscribe(func(e Event) { events = append(events, e) })
+
+	m := NewManager(ManagerConfig{
+		Store:           store.NewMemoryStore[*Session](),
+		TakeoverPolicy:  TakeoverPolicyRejectNew,
+		RoamingTakeover: true,
+		Events:          bus,
+	})
+	defer m.Close()
+
+	ctx := context.Background()
+	lte := ConnectionInfo{Listener: "lte", Transport: "tcp"}
+	wifi := ConnectionInfo{Listener: "wifi", Transport: "ws"}
+
+	s, _, err := m.CreateSession(ctx, "client1", false, 300, 5)
+	require.NoError(t, err)
+	s.SetConnection(lte)
+	s.AddPendingPublish(&PendingMessage{PacketID: 7, Topic: "a", QoS: 1})
+
+	// The same listener is still rejected under RejectNew
+	_, err = m.TakeoverSessionFrom(ctx, "client1", lte)
+	assert.True(t, errors.Is(err, ErrTakeoverRejected))
+
+	events = nil
+	decision, err := m.TakeoverSessionFrom(ctx, "client1", wifi)
+	require.NoError(t, err)
+	require.NotNil(t, decision)
+	assert.True(t, decision.Roaming)
+	assert.False(t, decision.Reject)
+	assert.Equal(t, lte, decision.Previous)
+	assert.Equal(t, wifi, decision.Current)
+
+	assert.Equal(t, wifi, s.GetConnection())
+	msg, ok := s.GetPendingPublish(7)
+	require.True(t, ok)
+	assert.True(t, msg.DUP)
+
+	require.Len(t, events, 2)
+	assert.Equal(t, EventTakeover, events[0].Type)
+	assert.Equal(t, EventRoamed, events[1].Type)
+	assert.Same(t, decision, events[1].Takeover)
+
+	decision, err = m.TakeoverSessionFrom(ctx, "missing", wifi)
+	assert.NoError(t, err)
+	assert.Nil(t, decision)
+}
+
+func TestManager_ConnectRoaming(t *testing.T) {
+	var roamed []*TakeoverDecision
+	m := NewManager(ManagerConfig{
+		Store:    store.NewMemoryStore[*Session](),
+		OnRoamed: func(_ *Session, decision *TakeoverDecision) { roamed = append(roamed, decision) },
+	})
+	defer m.Close()
+
+	ctx := context.Background()
+	lte := ConnectionInfo{Listener: "lte", Transport: "tcp"}
+	wifi := ConnectionInfo{Listener: "wifi", Transport: "ws"}
+
+	// The first connect records its listener, so the first roam is detected
+	result, err := m.Connect(ctx, ConnectRequest{ClientID: "client1", ExpiryInterval: 300, ProtocolVersion: 5, Connection: lte})
+	require.NoError(t, err)
+	assert.Equal(t, lte, result.Session.GetConnection())
+
+	result, err = m.Connect(ctx, ConnectRequest{ClientID: "client1", ExpiryInterval: 300, ProtocolVersion: 5, Connection: lte})
+	require.NoError(t, err)
+	assert.False(t, result.Takeover.Roaming)
+	assert.Empty(t, roamed)
+
+	result, err = m.Connect(ctx, ConnectRequest{ClientID: "client1", ExpiryInterval: 300, ProtocolVersion: 5, Connection: wifi})
+	require.NoError(t, err)
+	assert.True(t, result.Takeover.Roaming)
+	require.Len(t, roamed, 1)
+	assert.Same(t, result.Takeover, roamed[0])
+	assert.Equal(t, wifi, result.Session.GetConnection())
+}
+
+func TestManager_TakeoverSessionFromRoamingRejected(t *testing.T) {
+	m := NewManager(ManagerConfig{
+		Store:          store.NewMemoryStore[*Session](),
+		TakeoverPolicy: TakeoverPolicyRejectNew,
+	})
+	defer m.Close()
+
+	ctx := context.Background()
+	s, _, err := m.CreateSession(ctx, "client1", false, 300, 5)
+	require.NoError(t, err)
+	s.SetConnection(ConnectionInfo{Listener: "lte", Transport: "tcp"})
+
+	decision, err := m.TakeoverSessionFrom(ctx, "client1", ConnectionInfo{Listener: "wifi", Transport: "ws"})
+	assert.True(t, errors.Is(err, ErrTakeoverRejected))
+	assert.True(t, decision.Roaming)
+	assert.Equal(t, ConnectionInfo{Listener: "lte", Transport: "tcp"}, s.GetConnection())
+}
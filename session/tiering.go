@@ -0,0 +1,525 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/axmq/ax/store"
+	"github.com/axmq/ax/topic"
+)
+
+// Tier tells where the state of a session lives
+type Tier byte
+
+const (
+	TierNone Tier = iota // no session known
+	TierHot              // connected client, the session is held by the manager
+	TierWarm             // disconnected client, its subscriptions are still routed in memory
+	TierCold             // long idle client, its session and publish index live in the store only
+)
+
+// String returns the string representation of the tier
+func (t Tier) String() string {
+	switch t {
+	case TierHot:
+		return "hot"
+	case TierWarm:
+		return "warm"
+	case TierCold:
+		return "cold"
+	default:
+		return "none"
+	}
+}
+
+// TieringConfig configures session tiering
+type TieringConfig struct {
+	// Router holds the routes of hot and warm sessions, routes of cold sessions are removed from it
+	Router SubscriptionRouter
+	// Index stores the subscriptions of cold sessions by topic filter, an in-memory store is used when nil
+	Index store.Store[*ColdRoute]
+	// IdleAfter is how long a session stays disconnected before it is demoted to cold
+	IdleAfter time.Duration
+	// Interval between demotion sweeps run by Run
+	Interval time.Duration
+	// BatchSize bounds the sessions demoted per sweep, zero demotes every idle session
+	BatchSize int
+}
+
+func DefaultTieringConfig() TieringConfig {
+	return TieringConfig{
+		IdleAfter: time.Hour,
+		Interval:  time.Minute,
+		BatchSize: 10000,
+	}
+}
+
+// ColdRoute is an entry of the cold publish index, a subscription of a cold session
+type ColdRoute struct {
+	ClientID    string
+	TopicFilter string
+}
+
+const _coldRoutePrefix = "cold:"
+
+// TieringStats holds the session counts per tier and the counters of tier transitions
+type TieringStats struct {
+	Warm                int
+	Cold                int
+	Demoted             uint64
+	RehydratedOnConnect uint64
+	RehydratedOnPublish uint64
+	Failed              uint64
+}
+
+// Tiering demotes persistent sessions that stay disconnected for long to a store-only cold tier, keeping the
+// memory of brokers with millions of mostly idle devices bounded. A cold session is rehydrated when its client
+// reconnects or when a publish matches one of its subscriptions, call RehydrateMatching before routing publishes
+//
+// Cold subscriptions are indexed in the Index store by topic filter, a publish looks up its exact topic and
+// each of its multi-level wildcard prefixes. Filters with a single-level wildcard cannot be looked up that
+// way and stay in a small in-memory index, rebuilt by Load
+type Tiering struct {
+	manager     *Manager
+	config      TieringConfig
+	unsubscribe func()
+
+	mu       sync.Mutex
+	warm     map[string]time.Time // disconnected client -> disconnected at
+	wildcard *topic.Router        // cold subscriptions with single-level wildcards
+
+	cold                atomic.Int64
+	demoted             atomic.Uint64
+	rehydratedOnConnect atomic.Uint64
+	rehydratedOnPublish atomic.Uint64
+	failed              atomic.Uint64
+}
+
+// NewTiering creates session tiering for the sessions of manager, it follows the manager events until Close
+// Call Load once on startup so sessions disconnected before a restart are tiered too
+func NewTiering(manager *Manager, config TieringConfig) *Tiering {
+	defaults := DefaultTieringConfig()
+	if config.IdleAfter <= 0 {
+		config.IdleAfter = defaults.IdleAfter
+	}
+	if config.Interval <= 0 {
+		config.Interval = defaults.Interval
+	}
+	if config.Index == nil {
+		config.Index = store.NewMemoryStore[*ColdRoute]()
+	}
+
+	t := &Tiering{
+		manager:  manager,
+		config:   config,
+		warm:     make(map[string]time.Time),
+		wildcard: topic.NewRouter(),
+	}
+	t.unsubscribe = manager.Events().Subscribe(t.handle,
+		EventCreated, EventResumed, EventTakeover, EventDisconnected, EventExpired)
+	return t
+}
+
+// Close stops following the manager events
+func (t *Tiering) Close() {
+	t.unsubscribe()
+}
+
+// Load rebuilds the tier state from the session store: disconnected persistent sessions become warm, or cold
+// when they were demoted before, and the routes of cold sessions are removed from the router
+func (t *Tiering) Load(ctx context.Context) error {
+	keys, err := t.manager.store.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.warm = make(map[string]time.Time)
+	t.wildcard.Clear()
+	t.cold.Store(0)
+
+	prefix := sessionStoreKey("")
+	for _, key := range keys {
+		clientID, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if t.active(clientID) {
+			continue
+		}
+
+		session, err := t.manager.store.Load(ctx, key)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if session.GetState() != StateDisconnected || session.GetCleanStart() || session.GetExpiryInterval() == 0 {
+			continue
+		}
+
+		if !session.IsCold() {
+			t.warm[clientID] = session.DisconnectedAt
+			continue
+		}
+		for filter, sub := range session.GetAllSubscriptions() {
+			t.config.Router.Unsubscribe(clientID, filter)
+			if strings.Contains(filter, "+") {
+				_ = t.wildcard.Subscribe(&topic.Subscription{ClientID: clientID, TopicFilter: filter, QoS: sub.QoS})
+			}
+		}
+		t.cold.Add(1)
+	}
+	return nil
+}
+
+func (t *Tiering) active(clientID string) bool {
+	t.manager.mu.RLock()
+	defer t.manager.mu.RUnlock()
+	_, ok := t.manager.activeSessions[clientID]
+	return ok
+}
+
+func (t *Tiering) handle(event Event) {
+	switch event.Type {
+	case EventDisconnected:
+		if event.Session == nil || event.Session.GetCleanStart() || event.Session.GetExpiryInterval() == 0 {
+			return
+		}
+		t.mu.Lock()
+		t.warm[event.ClientID] = event.Time
+		t.mu.Unlock()
+	case EventResumed, EventTakeover:
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		delete(t.warm, event.ClientID)
+		if event.Session != nil && event.Session.IsCold() {
+			t.rehydrateLocked(context.Background(), event.ClientID, event.Session, &t.rehydratedOnConnect)
+		}
+	case EventCreated, EventExpired:
+		// A clean start or an expiry discards the subscriptions, the routes are not restored
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		delete(t.warm, event.ClientID)
+		if event.Session != nil && event.Session.IsCold() {
+			t.dropColdLocked(context.Background(), event.ClientID, event.Session, event.Type == EventCreated)
+		}
+	}
+}
+
+// Tier returns the tier of a client's session, a session in none of the in-memory tiers is looked up in the store
+func (t *Tiering) Tier(clientID string) Tier {
+	if t.active(clientID) {
+		return TierHot
+	}
+
+	t.mu.Lock()
+	_, warm := t.warm[clientID]
+	t.mu.Unlock()
+	if warm {
+		return TierWarm
+	}
+
+	session, err := t.manager.store.Load(context.Background(), sessionStoreKey(clientID))
+	if err == nil && session.IsCold() {
+		return TierCold
+	}
+	return TierNone
+}
+
+// Run demotes idle sessions each interval until ctx is done
+func (t *Tiering) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, _ = t.Demote(ctx)
+		}
+	}
+}
+
+// Demote moves the sessions disconnected for at least IdleAfter to the cold tier and returns how many it moved
+func (t *Tiering) Demote(ctx context.Context) (int, error) {
+	deadline := time.Now().Add(-t.config.IdleAfter)
+
+	t.mu.Lock()
+	var idle []string
+	for clientID, since := range t.warm {
+		if since.After(deadline) {
+			continue
+		}
+		idle = append(idle, clientID)
+		if t.config.BatchSize > 0 && len(idle) >= t.config.BatchSize {
+			break
+		}
+	}
+	t.mu.Unlock()
+
+	demoted := 0
+	for _, clientID := range idle {
+		if err := ctx.Err(); err != nil {
+			return demoted, err
+		}
+		ok, err := t.demote(ctx, clientID)
+		if err != nil {
+			t.failed.Add(1)
+			continue
+		}
+		if ok {
+			demoted++
+		}
+	}
+	return demoted, nil
+}
+
+func (t *Tiering) demote(ctx context.Context, clientID string) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// The client may have reconnected since the sweep started
+	if _, ok := t.warm[clientID]; !ok {
+		return false, nil
+	}
+
+	// Holding the manager lock keeps a connecting client from loading the session before it is marked cold
+	t.manager.mu.RLock()
+	defer t.manager.mu.RUnlock()
+	if _, active := t.manager.activeSessions[clientID]; active {
+		delete(t.warm, clientID)
+		return false, nil
+	}
+
+	session, err := t.manager.store.Load(ctx, sessionStoreKey(clientID))
+	if errors.Is(err, store.ErrNotFound) {
+		delete(t.warm, clientID)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	subs := session.GetAllSubscriptions()
+	for filter := range subs {
+		if err := t.config.Index.Save(ctx, coldRouteKey(clientID, filter), &ColdRoute{ClientID: clientID, TopicFilter: filter}); err != nil {
+			t.unindex(ctx, clientID, subs)
+			return false, err
+		}
+	}
+	session.SetCold(true)
+	if err := t.manager.store.Save(ctx, sessionStoreKey(clientID), session); err != nil {
+		session.SetCold(false)
+		t.unindex(ctx, clientID, subs)
+		return false, err
+	}
+
+	for filter, sub := range subs {
+		t.config.Router.Unsubscribe(clientID, filter)
+		if strings.Contains(filter, "+") {
+			_ = t.wildcard.Subscribe(&topic.Subscription{ClientID: clientID, TopicFilter: filter, QoS: sub.QoS})
+		}
+	}
+	delete(t.warm, clientID)
+	t.cold.Add(1)
+	t.demoted.Add(1)
+	return true, nil
+}
+
+// RehydrateMatching restores the cold sessions with a subscription matching topicName so the publish
+// reaches them, and returns their client IDs
+func (t *Tiering) RehydrateMatching(ctx context.Context, topicName string) ([]string, error) {
+	if t.cold.Load() <= 0 {
+		return nil, nil
+	}
+
+	var clients []string
+	seen := make(map[string]bool)
+	for _, filter := range coldLookupFilters(topicName) {
+		keys, err := store.ScanKeys(ctx, t.config.Index, coldRoutePrefix(filter), "", 0)
+		if err != nil {
+			return clients, err
+		}
+		for _, key := range keys {
+			clientID := coldRouteClient(key)
+			if seen[clientID] {
+				continue
+			}
+			seen[clientID] = true
+			ok, err := t.rehydrateStored(ctx, clientID)
+			if err != nil {
+				return clients, err
+			}
+			if !ok {
+				// The session was rehydrated, dropped or resubscribed since it was indexed
+				_ = t.config.Index.Delete(ctx, key)
+				continue
+			}
+			clients = append(clients, clientID)
+		}
+	}
+
+	t.mu.Lock()
+	matched := t.wildcard.Match(topicName)
+	t.mu.Unlock()
+	for _, sub := range matched {
+		if seen[sub.ClientID] {
+			continue
+		}
+		seen[sub.ClientID] = true
+		ok, err := t.rehydrateStored(ctx, sub.ClientID)
+		if err != nil {
+			return clients, err
+		}
+		if ok {
+			clients = append(clients, sub.ClientID)
+		}
+	}
+	return clients, nil
+}
+
+// rehydrateStored restores a cold session loaded from the store and reports whether it did, it does nothing
+// for sessions of other tiers
+func (t *Tiering) rehydrateStored(ctx context.Context, clientID string) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// A connecting client rehydrates its session itself, see handle
+	t.manager.mu.RLock()
+	defer t.manager.mu.RUnlock()
+	if _, active := t.manager.activeSessions[clientID]; active {
+		return false, nil
+	}
+
+	session, err := t.manager.store.Load(ctx, sessionStoreKey(clientID))
+	if errors.Is(err, store.ErrNotFound) {
+		t.wildcard.UnsubscribeAll(clientID)
+		return false, nil
+	}
+	if err != nil {
+		t.failed.Add(1)
+		return false, err
+	}
+	if !session.IsCold() {
+		return false, nil
+	}
+
+	if !t.rehydrateLocked(ctx, clientID, session, &t.rehydratedOnPublish) {
+		return false, nil
+	}
+	// A rehydrated session of a disconnected client is warm and may be demoted again
+	t.warm[clientID] = time.Now()
+	return true, nil
+}
+
+// rehydrateLocked routes the subscriptions of a cold session again, clears its cold mark and removes it
+// from the index
+func (t *Tiering) rehydrateLocked(ctx context.Context, clientID string, session *Session, counter *atomic.Uint64) bool {
+	session.SetCold(false)
+	if err := t.manager.store.Save(ctx, sessionStoreKey(clientID), session); err != nil {
+		session.SetCold(true)
+		t.failed.Add(1)
+		return false
+	}
+
+	subs := session.GetAllSubscriptions()
+	for _, sub := range subs {
+		if err := t.config.Router.Subscribe(routeFor(clientID, sub, nil)); err != nil {
+			t.failed.Add(1)
+		}
+	}
+	t.unindex(ctx, clientID, subs)
+	t.cold.Add(-1)
+	counter.Add(1)
+	return true
+}
+
+// dropColdLocked forgets a cold session whose subscriptions are discarded, index entries of a session cleared
+// by a clean start are no longer known and are removed when a publish finds them
+func (t *Tiering) dropColdLocked(ctx context.Context, clientID string, session *Session, save bool) {
+	t.unindex(ctx, clientID, session.GetAllSubscriptions())
+	t.wildcard.UnsubscribeAll(clientID)
+	session.SetCold(false)
+	if save {
+		if err := t.manager.store.Save(ctx, sessionStoreKey(clientID), session); err != nil {
+			t.failed.Add(1)
+		}
+	}
+	t.cold.Add(-1)
+}
+
+// unindex removes subscriptions of a client from the cold index
+func (t *Tiering) unindex(ctx context.Context, clientID string, subs map[string]*Subscription) {
+	for filter := range subs {
+		if err := t.config.Index.Delete(ctx, coldRouteKey(clientID, filter)); err != nil && !errors.Is(err, store.ErrNotFound) {
+			t.failed.Add(1)
+		}
+		t.wildcard.Unsubscribe(clientID, filter)
+	}
+}
+
+// Stats returns the tier sizes and transition counters
+func (t *Tiering) Stats() TieringStats {
+	t.mu.Lock()
+	warm := len(t.warm)
+	t.mu.Unlock()
+
+	return TieringStats{
+		Warm:                warm,
+		Cold:                int(t.cold.Load()),
+		Demoted:             t.demoted.Load(),
+		RehydratedOnConnect: t.rehydratedOnConnect.Load(),
+		RehydratedOnPublish: t.rehydratedOnPublish.Load(),
+		Failed:              t.failed.Load(),
+	}
+}
+
+// coldRoutePrefix returns the index key prefix of the cold subscriptions whose filter, without a shared
+// subscription prefix, is filter
+func coldRoutePrefix(filter string) string {
+	return _coldRoutePrefix + filter + "\x00"
+}
+
+// coldRouteKey returns the index key of a cold subscription, NUL cannot appear in topic filters
+func coldRouteKey(clientID, filter string) string {
+	match := filter
+	if topic.IsSharedSubscription(filter) {
+		if _, topicFilter, err := topic.ValidateSharedSubscription(filter); err == nil {
+			match = topicFilter
+		}
+	}
+	return coldRoutePrefix(match) + clientID + "\x00" + filter
+}
+
+// coldRouteClient returns the client ID of an index key
+func coldRouteClient(key string) string {
+	_, rest, _ := strings.Cut(key, "\x00")
+	clientID, _, _ := strings.Cut(rest, "\x00")
+	return clientID
+}
+
+// coldLookupFilters returns the filters without single-level wildcards that match topicName: the topic
+// itself and a multi-level wildcard after each of its levels
+func coldLookupFilters(topicName string) []string {
+	levels := strings.Split(topicName, "/")
+	filters := make([]string, 0, len(levels)+2)
+	filters = append(filters, topicName)
+	// Wildcards at the first level do not match topics starting with $
+	if !strings.HasPrefix(topicName, "$") {
+		filters = append(filters, "#")
+	}
+	for i := range levels {
+		filters = append(filters, strings.Join(levels[:i+1], "/")+"/#")
+	}
+	return filters
+}
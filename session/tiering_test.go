@@ -0,0 +1,215 @@
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/axmq/ax/store"
+	"github.com/axmq/ax/topic"
+)
+
+func newTieringFixture(t *testing.T) (*Manager, *topic.Router, *Tiering) {
+	t.Helper()
+	m, router := newConsistencyFixture(t)
+	tiering := NewTiering(m, TieringConfig{Router: router, IdleAfter: time.Millisecond})
+	t.Cleanup(tiering.Close)
+	return m, router, tiering
+}
+
+// connectDevice creates a persistent session subscribed to filter and routes it, then disconnects the client
+func connectDevice(t *testing.T, m *Manager, router *topic.Router, clientID, filter string) {
+	t.Helper()
+	ctx := context.Background()
+	s, _, err := m.CreateSession(ctx, clientID, false, 3600, 5)
+	require.NoError(t, err)
+	s.AddSubscription(&Subscription{TopicFilter: filter, QoS: 1})
+	require.NoError(t, router.Subscribe(&topic.Subscription{ClientID: clientID, TopicFilter: filter, QoS: 1}))
+	require.NoError(t, m.DisconnectSession(ctx, clientID, false))
+}
+
+func TestTierString(t *testing.T) {
+	assert.Equal(t, "hot", TierHot.String())
+	assert.Equal(t, "warm", TierWarm.String())
+	assert.Equal(t, "cold", TierCold.String())
+	assert.Equal(t, "none", TierNone.String())
+}
+
+func TestTieringDemoteAndRehydrateOnPublish(t *testing.T) {
+	ctx := context.Background()
+	m, router, tiering := newTieringFixture(t)
+
+	connectDevice(t, m, router, "dev1", "devices/dev1/cmd")
+	connectDevice(t, m, router, "dev2", "devices/dev2/cmd")
+	assert.Equal(t, TierWarm, tiering.Tier("dev1"))
+
+	time.Sleep(5 * time.Millisecond)
+	n, err := tiering.Demote(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, TierCold, tiering.Tier("dev1"))
+	assert.Empty(t, router.Match("devices/dev1/cmd"), "cold sessions are not routed")
+	assert.Equal(t, TieringStats{Cold: 2, Demoted: 2}, tiering.Stats())
+
+	clients, err := tiering.RehydrateMatching(ctx, "devices/dev1/cmd")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dev1"}, clients)
+	assert.Len(t, router.Match("devices/dev1/cmd"), 1)
+	assert.Equal(t, TierWarm, tiering.Tier("dev1"))
+	assert.Equal(t, TierCold, tiering.Tier("dev2"))
+
+	clients, err = tiering.RehydrateMatching(ctx, "devices/dev1/cmd")
+	require.NoError(t, err)
+	assert.Empty(t, clients)
+
+	stats := tiering.Stats()
+	assert.Equal(t, 1, stats.Warm)
+	assert.Equal(t, 1, stats.Cold)
+	assert.Equal(t, uint64(1), stats.RehydratedOnPublish)
+}
+
+func TestTieringRehydrateOnReconnect(t *testing.T) {
+	ctx := context.Background()
+	m, router, tiering := newTieringFixture(t)
+
+	connectDevice(t, m, router, "dev1", "devices/dev1/cmd")
+	time.Sleep(5 * time.Millisecond)
+	_, err := tiering.Demote(ctx)
+	require.NoError(t, err)
+	require.Equal(t, TierCold, tiering.Tier("dev1"))
+
+	_, present, err := m.CreateSession(ctx, "dev1", false, 3600, 5)
+	require.NoError(t, err)
+	assert.True(t, present)
+	assert.Equal(t, TierHot, tiering.Tier("dev1"))
+	assert.Len(t, router.Match("devices/dev1/cmd"), 1)
+	assert.Equal(t, uint64(1), tiering.Stats().RehydratedOnConnect)
+
+	_, err = tiering.RehydrateMatching(ctx, "devices/dev1/cmd")
+	require.NoError(t, err)
+	assert.Zero(t, tiering.Stats().RehydratedOnPublish)
+}
+
+func TestTieringCleanStartDropsColdSession(t *testing.T) {
+	ctx := context.Background()
+	m, router, tiering := newTieringFixture(t)
+
+	connectDevice(t, m, router, "dev1", "devices/dev1/cmd")
+	time.Sleep(5 * time.Millisecond)
+	_, err := tiering.Demote(ctx)
+	require.NoError(t, err)
+
+	_, _, err = m.CreateSession(ctx, "dev1", true, 0, 5)
+	require.NoError(t, err)
+	assert.Empty(t, router.Match("devices/dev1/cmd"))
+	clients, err := tiering.RehydrateMatching(ctx, "devices/dev1/cmd")
+	require.NoError(t, err)
+	assert.Empty(t, clients)
+	assert.Zero(t, tiering.Stats().Cold)
+}
+
+func TestTieringSkipsRecentAndCleanSessions(t *testing.T) {
+	ctx := context.Background()
+	m, router := newConsistencyFixture(t)
+	tiering := NewTiering(m, TieringConfig{Router: router, IdleAfter: time.Hour})
+	defer tiering.Close()
+
+	connectDevice(t, m, router, "dev1", "devices/dev1/cmd")
+	_, _, err := m.CreateSession(ctx, "clean", true, 0, 5)
+	require.NoError(t, err)
+	require.NoError(t, m.DisconnectSession(ctx, "clean", false))
+
+	n, err := tiering.Demote(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	assert.Equal(t, TierWarm, tiering.Tier("dev1"))
+	assert.Equal(t, TierNone, tiering.Tier("clean"))
+}
+
+func TestConsistencyCheckerIgnoresColdSessions(t *testing.T) {
+	ctx := context.Background()
+	m, router, tiering := newTieringFixture(t)
+
+	connectDevice(t, m, router, "dev1", "devices/dev1/cmd")
+	time.Sleep(5 * time.Millisecond)
+	_, err := tiering.Demote(ctx)
+	require.NoError(t, err)
+
+	checker := NewConsistencyChecker(m, ConsistencyConfig{Router: router, Tiering: tiering, Repair: true})
+	report, err := checker.Check(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, report.Drifts)
+	assert.Empty(t, router.Match("devices/dev1/cmd"))
+}
+
+func TestTieringKeepsColdSessionsInTheStore(t *testing.T) {
+	ctx := context.Background()
+	m, router := newConsistencyFixture(t)
+	index := store.NewMemoryStore[*ColdRoute]()
+	tiering := NewTiering(m, TieringConfig{Router: router, Index: index, IdleAfter: time.Millisecond})
+	defer tiering.Close()
+
+	connectDevice(t, m, router, "dev1", "devices/dev1/#")
+	connectDevice(t, m, router, "dev2", "devices/+/status")
+	time.Sleep(5 * time.Millisecond)
+	_, err := tiering.Demote(ctx)
+	require.NoError(t, err)
+
+	stored, err := m.store.Load(ctx, sessionStoreKey("dev1"))
+	require.NoError(t, err)
+	assert.True(t, stored.IsCold())
+	n, err := index.Count(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	clients, err := tiering.RehydrateMatching(ctx, "devices/dev1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dev1"}, clients, "a multi-level wildcard matches its parent level")
+
+	clients, err = tiering.RehydrateMatching(ctx, "devices/dev2/status")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dev2"}, clients)
+	assert.Len(t, router.Match("devices/dev2/status"), 1)
+
+	n, err = index.Count(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	assert.Zero(t, tiering.Stats().Cold)
+}
+
+func TestTieringLoadRebuildsTiersAfterRestart(t *testing.T) {
+	ctx := context.Background()
+	m, router := newConsistencyFixture(t)
+	index := store.NewMemoryStore[*ColdRoute]()
+	tiering := NewTiering(m, TieringConfig{Router: router, Index: index, IdleAfter: time.Millisecond})
+
+	connectDevice(t, m, router, "dev1", "devices/dev1/cmd")
+	time.Sleep(5 * time.Millisecond)
+	_, err := tiering.Demote(ctx)
+	require.NoError(t, err)
+	connectDevice(t, m, router, "dev2", "devices/dev2/cmd")
+	tiering.Close()
+
+	// A restarted broker restores every route, then tiering takes the cold ones out again
+	require.NoError(t, router.Subscribe(&topic.Subscription{ClientID: "dev1", TopicFilter: "devices/dev1/cmd", QoS: 1}))
+	restarted := NewTiering(m, TieringConfig{Router: router, Index: index, IdleAfter: time.Millisecond})
+	defer restarted.Close()
+	require.NoError(t, restarted.Load(ctx))
+
+	assert.Equal(t, TierCold, restarted.Tier("dev1"))
+	assert.Equal(t, TierWarm, restarted.Tier("dev2"))
+	assert.Empty(t, router.Match("devices/dev1/cmd"))
+	assert.Equal(t, TieringStats{Warm: 1, Cold: 1}, restarted.Stats())
+
+	time.Sleep(5 * time.Millisecond)
+	n, err := restarted.Demote(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n, "sessions disconnected before the restart are demoted")
+
+	clients, err := restarted.RehydrateMatching(ctx, "devices/dev1/cmd")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dev1"}, clients)
+}
@@ -0,0 +1,13 @@
+package shadow
+
+import "errors"
+
+var (
+	ErrShadowNotFound  = errors.New("shadow not found")
+	ErrVersionConflict = errors.New("shadow version conflict")
+	ErrInvalidDocument = errors.New("invalid shadow document")
+	ErrInvalidTopic    = errors.New("invalid shadow topic")
+	ErrEmptyClientID   = errors.New("shadow client id cannot be empty")
+	ErrNotAuthorized   = errors.New("not authorized for shadow")
+	ErrDeltaPublish    = errors.New("failed to publish shadow delta")
+)
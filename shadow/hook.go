@@ -0,0 +1,72 @@
+package shadow
+
+import (
+	"context"
+
+	"github.com/axmq/ax/hook"
+)
+
+// ACLChecker authorizes access to shadow topics, hook.Manager implements it
+type ACLChecker interface {
+	OnACLCheck(client *hook.Client, topic string, access hook.AccessType) bool
+}
+
+// Hook wires the shadow manager into the broker publish path
+type Hook struct {
+	*hook.Base
+	manager *Manager
+	acl     ACLChecker
+}
+
+// NewHook creates a hook that forwards shadow topic publishes to the manager
+// Without an ACL checker a client may only access its own shadow
+func NewHook(manager *Manager) *Hook {
+	return &Hook{
+		Base:    hook.NewHookBase("shadow"),
+		manager: manager,
+	}
+}
+
+// SetACL authorizes shadow requests with acl instead of restricting clients to their own shadow
+// Gets are checked for read access to the request topic, updates and deletes for write access
+func (h *Hook) SetACL(acl ACLChecker) {
+	h.acl = acl
+}
+
+// Provides indicates this hook handles publishes
+func (h *Hook) Provides(event hook.Event) bool {
+	return event == hook.OnPublish
+}
+
+// OnPublish processes publishes addressed to reserved shadow topics, unauthorized requests are rejected
+// before the shadow is touched
+func (h *Hook) OnPublish(client *hook.Client, packet *hook.PublishPacket) error {
+	if packet == nil || !IsShadowTopic(packet.Topic) {
+		return nil
+	}
+	clientID, op, err := ParseTopic(packet.Topic)
+	if err != nil {
+		return nil
+	}
+
+	ctx := context.Background()
+	if !h.allowed(client, clientID, op, packet.Topic) {
+		return h.manager.reject(ctx, clientID, op, ErrNotAuthorized, "")
+	}
+	_, err = h.manager.HandlePublish(ctx, packet.Topic, packet.Payload)
+	return err
+}
+
+func (h *Hook) allowed(client *hook.Client, clientID string, op Operation, topic string) bool {
+	if client == nil {
+		return false
+	}
+	if h.acl == nil {
+		return client.ID == clientID
+	}
+	access := hook.AccessTypeWrite
+	if op == OperationGet {
+		access = hook.AccessTypeRead
+	}
+	return h.acl.OnACLCheck(client, topic, access)
+}
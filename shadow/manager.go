@@ -0,0 +1,313 @@
+package shadow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/axmq/ax/store"
+)
+
+const (
+	// TopicPrefix is the reserved topic namespace for shadow operations
+	TopicPrefix = "$shadow/"
+
+	_shadowKeyPrefix = "shadow:%s"
+)
+
+// Operation identifies the shadow operation addressed by a topic
+type Operation string
+
+const (
+	OperationUpdate Operation = "update"
+	OperationGet    Operation = "get"
+	OperationDelete Operation = "delete"
+)
+
+// Publisher defines the interface for publishing shadow responses and deltas
+type Publisher interface {
+	Publish(ctx context.Context, topic string, payload []byte) error
+}
+
+// ManagerConfig configures the shadow manager
+type ManagerConfig struct {
+	Store     store.Store[*Document]
+	Publisher Publisher
+	// OnChange is called with a copy of every saved document, doc is nil when the shadow is deleted
+	// It runs while the manager is locked, so changes are reported in the order they were applied
+	OnChange func(clientID string, doc *Document)
+}
+
+// Manager maintains shadow documents and processes reserved shadow topics
+type Manager struct {
+	mu        sync.Mutex
+	store     store.Store[*Document]
+	publisher Publisher
+	onChange  func(clientID string, doc *Document)
+}
+
+// NewManager creates a new shadow manager
+func NewManager(config ManagerConfig) *Manager {
+	return &Manager{
+		store:     config.Store,
+		publisher: config.Publisher,
+		onChange:  config.OnChange,
+	}
+}
+
+// UpdateTopic returns the topic used to update a client's shadow
+func UpdateTopic(clientID string) string {
+	return TopicPrefix + clientID + "/update"
+}
+
+// DeltaTopic returns the topic deltas are published to
+func DeltaTopic(clientID string) string {
+	return TopicPrefix + clientID + "/update/delta"
+}
+
+// ParseTopic extracts the client ID and operation from a shadow request topic
+func ParseTopic(topic string) (string, Operation, error) {
+	if !strings.HasPrefix(topic, TopicPrefix) {
+		return "", "", ErrInvalidTopic
+	}
+
+	parts := strings.Split(topic[len(TopicPrefix):], "/")
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", ErrInvalidTopic
+	}
+
+	op := Operation(parts[1])
+	switch op {
+	case OperationUpdate, OperationGet, OperationDelete:
+		return parts[0], op, nil
+	default:
+		return "", "", ErrInvalidTopic
+	}
+}
+
+// IsShadowTopic checks if a topic belongs to the shadow namespace
+func IsShadowTopic(topic string) bool {
+	return strings.HasPrefix(topic, TopicPrefix)
+}
+
+// Get returns a copy of the shadow document for a client
+func (m *Manager) Get(ctx context.Context, clientID string) (*Document, error) {
+	doc, err := m.store.Load(ctx, shadowStoreKey(clientID))
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, ErrShadowNotFound
+		}
+		return nil, err
+	}
+	return doc.Clone(), nil
+}
+
+// Update applies a state update to a client's shadow and publishes a delta if
+// desired and reported state diverge
+func (m *Manager) Update(ctx context.Context, clientID string, req *UpdateRequest) (*Document, error) {
+	if clientID == "" {
+		return nil, ErrEmptyClientID
+	}
+	if req == nil || (req.State.Desired == nil && req.State.Reported == nil) {
+		return nil, ErrInvalidDocument
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	doc, err := m.store.Load(ctx, shadowStoreKey(clientID))
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		doc = NewDocument(clientID)
+	case err != nil:
+		return nil, err
+	default:
+		doc = doc.Clone()
+	}
+
+	if req.Version != 0 && req.Version != doc.Version {
+		return nil, ErrVersionConflict
+	}
+
+	doc.Apply(req.State)
+	if err := m.store.Save(ctx, shadowStoreKey(clientID), doc); err != nil {
+		return nil, fmt.Errorf("failed to save shadow: %w", err)
+	}
+
+	result := doc.Clone()
+	m.changed(clientID, result.Clone())
+	if d := result.Delta(); len(d) > 0 {
+		if err := m.publish(ctx, DeltaTopic(clientID), &DeltaMessage{
+			State:     d,
+			Version:   result.Version,
+			Timestamp: result.UpdatedAt.Unix(),
+		}); err != nil {
+			// the update is saved, only the delta notification is lost
+			return result, fmt.Errorf("%w: %v", ErrDeltaPublish, err)
+		}
+	}
+
+	return result, nil
+}
+
+// Delete removes a client's shadow
+func (m *Manager) Delete(ctx context.Context, clientID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	exists, err := m.store.Exists(ctx, shadowStoreKey(clientID))
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrShadowNotFound
+	}
+	if err := m.store.Delete(ctx, shadowStoreKey(clientID)); err != nil {
+		return err
+	}
+	m.changed(clientID, nil)
+	return nil
+}
+
+// List returns a copy of every shadow document in client ID order
+func (m *Manager) List(ctx context.Context) ([]*Document, error) {
+	prefix := shadowStoreKey("")
+	keys, err := store.ScanKeys(ctx, m.store, prefix, "", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := make([]*Document, 0, len(keys))
+	for _, key := range keys {
+		doc, err := m.store.Load(ctx, key)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc.Clone())
+	}
+	return docs, nil
+}
+
+// Restore replaces a client's shadow with doc as is, keeping its version and timestamps
+// It is meant for replicas copying documents from another manager, updates go through Update
+func (m *Manager) Restore(ctx context.Context, doc *Document) error {
+	if doc == nil {
+		return ErrInvalidDocument
+	}
+	if doc.ClientID == "" {
+		return ErrEmptyClientID
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	saved := doc.Clone()
+	if err := m.store.Save(ctx, shadowStoreKey(saved.ClientID), saved); err != nil {
+		return fmt.Errorf("failed to save shadow: %w", err)
+	}
+	m.changed(saved.ClientID, saved.Clone())
+	return nil
+}
+
+func (m *Manager) changed(clientID string, doc *Document) {
+	if m.onChange != nil {
+		m.onChange(clientID, doc)
+	}
+}
+
+// HandlePublish processes a publish on a reserved shadow topic, replying on the
+// corresponding accepted/rejected topics. It returns false if the topic is not
+// a shadow request topic
+func (m *Manager) HandlePublish(ctx context.Context, topic string, payload []byte) (bool, error) {
+	clientID, op, err := ParseTopic(topic)
+	if err != nil {
+		return false, nil
+	}
+
+	var (
+		response any
+		token    string
+	)
+
+	switch op {
+	case OperationUpdate:
+		var req UpdateRequest
+		if err = json.Unmarshal(payload, &req); err != nil {
+			err = ErrInvalidDocument
+			break
+		}
+		token = req.ClientToken
+		response, err = m.Update(ctx, clientID, &req)
+	case OperationGet:
+		response, err = m.Get(ctx, clientID)
+	case OperationDelete:
+		err = m.Delete(ctx, clientID)
+		response = map[string]any{"version": 0, "timestamp": time.Now().Unix()}
+	}
+
+	if err != nil && !errors.Is(err, ErrDeltaPublish) {
+		return true, m.reject(ctx, clientID, op, err, token)
+	}
+	if pubErr := m.publish(ctx, responseTopic(clientID, op, "accepted"), response); pubErr != nil {
+		return true, pubErr
+	}
+	return true, err
+}
+
+// reject publishes err on the rejected topic of the operation and returns it
+func (m *Manager) reject(ctx context.Context, clientID string, op Operation, err error, token string) error {
+	if pubErr := m.publish(ctx, responseTopic(clientID, op, "rejected"), &ErrorResponse{
+		Code:        errorCode(err),
+		Message:     err.Error(),
+		ClientToken: token,
+	}); pubErr != nil {
+		return pubErr
+	}
+	return err
+}
+
+// Close closes the underlying store
+func (m *Manager) Close() error {
+	return m.store.Close()
+}
+
+func (m *Manager) publish(ctx context.Context, topic string, v any) error {
+	if m.publisher == nil {
+		return nil
+	}
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal shadow message: %w", err)
+	}
+	return m.publisher.Publish(ctx, topic, payload)
+}
+
+func errorCode(err error) int {
+	switch {
+	case errors.Is(err, ErrShadowNotFound):
+		return 404
+	case errors.Is(err, ErrNotAuthorized):
+		return 403
+	case errors.Is(err, ErrVersionConflict):
+		return 409
+	case errors.Is(err, ErrInvalidDocument), errors.Is(err, ErrEmptyClientID):
+		return 400
+	default:
+		return 500
+	}
+}
+
+func responseTopic(clientID string, op Operation, result string) string {
+	return TopicPrefix + clientID + "/" + string(op) + "/" + result
+}
+
+func shadowStoreKey(clientID string) string {
+	return fmt.Sprintf(_shadowKeyPrefix, clientID)
+}
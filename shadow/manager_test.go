@@ -0,0 +1,353 @@
+package shadow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/axmq/ax/hook"
+	"github.com/axmq/ax/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type published struct {
+	topic   string
+	payload []byte
+}
+
+type mockPublisher struct {
+	mu       sync.Mutex
+	messages []published
+	// failTopic makes publishes to a topic fail
+	failTopic string
+}
+
+func (p *mockPublisher) Publish(_ context.Context, topic string, payload []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if topic == p.failTopic {
+		return errors.New("publish failed")
+	}
+	p.messages = append(p.messages, published{topic: topic, payload: payload})
+	return nil
+}
+
+func (p *mockPublisher) byTopic(topic string) []published {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var result []published
+	for _, m := range p.messages {
+		if m.topic == topic {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+func newTestManager() (*Manager, *mockPublisher) {
+	pub := &mockPublisher{}
+	return NewManager(ManagerConfig{
+		Store:     store.NewMemoryStore[*Document](),
+		Publisher: pub,
+	}), pub
+}
+
+func TestManagerUpdateNestedFromPebble(t *testing.T) {
+	s, err := store.NewPebbleStore[*Document](store.PebbleStoreConfig{Path: t.TempDir()})
+	require.NoError(t, err)
+	defer s.Close()
+	m := NewManager(ManagerConfig{Store: s, Publisher: &mockPublisher{}})
+	ctx := context.Background()
+
+	_, err = m.Update(ctx, "dev1", &UpdateRequest{State: State{
+		Desired:  map[string]any{"light": map[string]any{"on": true, "level": 80}},
+		Reported: map[string]any{"light": map[string]any{"on": true, "level": 80}},
+	}})
+	require.NoError(t, err)
+
+	// the nested objects come back from CBOR as map[any]any, a partial update keeps their siblings
+	doc, err := m.Update(ctx, "dev1", &UpdateRequest{State: State{Desired: map[string]any{"light": map[string]any{"level": 40}}}})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"on": true, "level": 40}, doc.Desired["light"])
+	assert.Equal(t, map[string]any{"light": map[string]any{"level": 40}}, doc.Delta())
+
+	doc, err = m.Get(ctx, "dev1")
+	require.NoError(t, err)
+	data, err := json.Marshal(doc)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"desired":{"light":{"level":40,"on":true}}`)
+}
+
+func TestParseTopic(t *testing.T) {
+	tests := []struct {
+		topic    string
+		clientID string
+		op       Operation
+		wantErr  bool
+	}{
+		{topic: "$shadow/dev1/update", clientID: "dev1", op: OperationUpdate},
+		{topic: "$shadow/dev1/get", clientID: "dev1", op: OperationGet},
+		{topic: "$shadow/dev1/delete", clientID: "dev1", op: OperationDelete},
+		{topic: "$shadow/dev1/update/delta", wantErr: true},
+		{topic: "$shadow//update", wantErr: true},
+		{topic: "$shadow/dev1/unknown", wantErr: true},
+		{topic: "devices/dev1/update", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.topic, func(t *testing.T) {
+			clientID, op, err := ParseTopic(tt.topic)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidTopic)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.clientID, clientID)
+			assert.Equal(t, tt.op, op)
+		})
+	}
+}
+
+func TestManagerUpdate(t *testing.T) {
+	m, pub := newTestManager()
+	ctx := context.Background()
+
+	doc, err := m.Update(ctx, "dev1", &UpdateRequest{State: State{Reported: map[string]any{"temp": 20}}})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), doc.Version)
+	assert.Empty(t, pub.byTopic(DeltaTopic("dev1")))
+
+	doc, err = m.Update(ctx, "dev1", &UpdateRequest{State: State{Desired: map[string]any{"temp": 25}}, Version: 1})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), doc.Version)
+
+	deltas := pub.byTopic(DeltaTopic("dev1"))
+	require.Len(t, deltas, 1)
+	var delta DeltaMessage
+	require.NoError(t, json.Unmarshal(deltas[0].payload, &delta))
+	assert.Equal(t, map[string]any{"temp": float64(25)}, delta.State)
+	assert.Equal(t, uint64(2), delta.Version)
+
+	_, err = m.Update(ctx, "dev1", &UpdateRequest{State: State{Reported: map[string]any{"temp": 25}}, Version: 1})
+	assert.ErrorIs(t, err, ErrVersionConflict)
+
+	stored, err := m.Get(ctx, "dev1")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), stored.Version)
+}
+
+func TestManagerUpdateInvalid(t *testing.T) {
+	m, _ := newTestManager()
+	ctx := context.Background()
+
+	_, err := m.Update(ctx, "", &UpdateRequest{State: State{Desired: map[string]any{"a": 1}}})
+	assert.ErrorIs(t, err, ErrEmptyClientID)
+
+	_, err = m.Update(ctx, "dev1", &UpdateRequest{})
+	assert.ErrorIs(t, err, ErrInvalidDocument)
+
+	_, err = m.Update(ctx, "dev1", nil)
+	assert.ErrorIs(t, err, ErrInvalidDocument)
+}
+
+func TestManagerGetReturnsCopy(t *testing.T) {
+	m, _ := newTestManager()
+	ctx := context.Background()
+
+	_, err := m.Get(ctx, "dev1")
+	assert.ErrorIs(t, err, ErrShadowNotFound)
+
+	_, err = m.Update(ctx, "dev1", &UpdateRequest{State: State{Desired: map[string]any{"a": 1}}})
+	require.NoError(t, err)
+
+	doc, err := m.Get(ctx, "dev1")
+	require.NoError(t, err)
+	doc.Desired["a"] = 2
+
+	doc, err = m.Get(ctx, "dev1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, doc.Desired["a"])
+}
+
+func TestManagerDelete(t *testing.T) {
+	m, _ := newTestManager()
+	ctx := context.Background()
+
+	assert.ErrorIs(t, m.Delete(ctx, "dev1"), ErrShadowNotFound)
+
+	_, err := m.Update(ctx, "dev1", &UpdateRequest{State: State{Desired: map[string]any{"a": 1}}})
+	require.NoError(t, err)
+	require.NoError(t, m.Delete(ctx, "dev1"))
+
+	_, err = m.Get(ctx, "dev1")
+	assert.ErrorIs(t, err, ErrShadowNotFound)
+}
+
+func TestManagerOnChange(t *testing.T) {
+	type change struct {
+		clientID string
+		doc      *Document
+	}
+	var changes []change
+	m := NewManager(ManagerConfig{
+		Store: store.NewMemoryStore[*Document](),
+		OnChange: func(clientID string, doc *Document) {
+			changes = append(changes, change{clientID, doc})
+		},
+	})
+	ctx := context.Background()
+
+	_, err := m.Update(ctx, "dev1", &UpdateRequest{State: State{Reported: map[string]any{"temp": 20}}})
+	require.NoError(t, err)
+	_, err = m.Update(ctx, "dev1", &UpdateRequest{Version: 5, State: State{Reported: map[string]any{"temp": 21}}})
+	require.ErrorIs(t, err, ErrVersionConflict)
+	require.NoError(t, m.Delete(ctx, "dev1"))
+
+	require.Len(t, changes, 2)
+	assert.Equal(t, "dev1", changes[0].clientID)
+	require.NotNil(t, changes[0].doc)
+	assert.Equal(t, uint64(1), changes[0].doc.Version)
+	assert.Equal(t, "dev1", changes[1].clientID)
+	assert.Nil(t, changes[1].doc)
+}
+
+func TestManagerListRestore(t *testing.T) {
+	m, _ := newTestManager()
+	ctx := context.Background()
+
+	for _, id := range []string{"dev2", "dev1"} {
+		_, err := m.Update(ctx, id, &UpdateRequest{State: State{Desired: map[string]any{"on": true}}})
+		require.NoError(t, err)
+	}
+	docs, err := m.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, docs, 2)
+	assert.Equal(t, "dev1", docs[0].ClientID)
+	assert.Equal(t, "dev2", docs[1].ClientID)
+
+	replica, _ := newTestManager()
+	docs[0].Version = 7
+	require.NoError(t, replica.Restore(ctx, docs[0]))
+	restored, err := replica.Get(ctx, "dev1")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(7), restored.Version)
+	assert.Equal(t, docs[0].Desired, restored.Desired)
+
+	assert.ErrorIs(t, replica.Restore(ctx, nil), ErrInvalidDocument)
+	assert.ErrorIs(t, replica.Restore(ctx, &Document{}), ErrEmptyClientID)
+}
+
+func TestManagerHandlePublish(t *testing.T) {
+	m, pub := newTestManager()
+	ctx := context.Background()
+
+	handled, err := m.HandlePublish(ctx, "devices/dev1", []byte("{}"))
+	assert.False(t, handled)
+	assert.NoError(t, err)
+
+	handled, err = m.HandlePublish(ctx, UpdateTopic("dev1"), []byte(`{"state":{"desired":{"on":true}},"clientToken":"t1"}`))
+	assert.True(t, handled)
+	require.NoError(t, err)
+	require.Len(t, pub.byTopic("$shadow/dev1/update/accepted"), 1)
+	require.Len(t, pub.byTopic(DeltaTopic("dev1")), 1)
+
+	handled, err = m.HandlePublish(ctx, UpdateTopic("dev1"), []byte(`{"state":{"reported":{"on":true}},"version":5,"clientToken":"t2"}`))
+	assert.True(t, handled)
+	assert.ErrorIs(t, err, ErrVersionConflict)
+
+	rejected := pub.byTopic("$shadow/dev1/update/rejected")
+	require.Len(t, rejected, 1)
+	var resp ErrorResponse
+	require.NoError(t, json.Unmarshal(rejected[0].payload, &resp))
+	assert.Equal(t, 409, resp.Code)
+	assert.Equal(t, "t2", resp.ClientToken)
+
+	_, err = m.HandlePublish(ctx, UpdateTopic("dev1"), []byte("not json"))
+	assert.ErrorIs(t, err, ErrInvalidDocument)
+
+	_, err = m.HandlePublish(ctx, "$shadow/dev1/get", nil)
+	require.NoError(t, err)
+	accepted := pub.byTopic("$shadow/dev1/get/accepted")
+	require.Len(t, accepted, 1)
+	var doc Document
+	require.NoError(t, json.Unmarshal(accepted[0].payload, &doc))
+	assert.Equal(t, "dev1", doc.ClientID)
+	assert.Equal(t, uint64(1), doc.Version)
+
+	_, err = m.HandlePublish(ctx, "$shadow/dev1/delete", nil)
+	require.NoError(t, err)
+	_, err = m.HandlePublish(ctx, "$shadow/dev1/get", nil)
+	assert.ErrorIs(t, err, ErrShadowNotFound)
+	assert.Len(t, pub.byTopic("$shadow/dev1/get/rejected"), 1)
+}
+
+func TestManagerHandlePublishDeltaFailure(t *testing.T) {
+	m, pub := newTestManager()
+	pub.failTopic = DeltaTopic("dev1")
+	ctx := context.Background()
+
+	// the update is saved, so it is accepted even though the delta could not be published
+	_, err := m.HandlePublish(ctx, UpdateTopic("dev1"), []byte(`{"state":{"desired":{"on":true}}}`))
+	assert.ErrorIs(t, err, ErrDeltaPublish)
+	assert.Len(t, pub.byTopic("$shadow/dev1/update/accepted"), 1)
+	assert.Empty(t, pub.byTopic("$shadow/dev1/update/rejected"))
+
+	doc, err := m.Get(ctx, "dev1")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), doc.Version)
+}
+
+type aclFunc func(client *hook.Client, topic string, access hook.AccessType) bool
+
+func (f aclFunc) OnACLCheck(client *hook.Client, topic string, access hook.AccessType) bool {
+	return f(client, topic, access)
+}
+
+func TestHookAuthorization(t *testing.T) {
+	m, pub := newTestManager()
+	h := NewHook(m)
+	update := &hook.PublishPacket{Topic: UpdateTopic("dev1"), Payload: []byte(`{"state":{"reported":{"on":true}}}`)}
+
+	// without an ACL a client only reaches its own shadow
+	assert.ErrorIs(t, h.OnPublish(&hook.Client{ID: "dev2"}, update), ErrNotAuthorized)
+	assert.ErrorIs(t, h.OnPublish(nil, update), ErrNotAuthorized)
+	rejected := pub.byTopic("$shadow/dev1/update/rejected")
+	require.Len(t, rejected, 2)
+	var resp ErrorResponse
+	require.NoError(t, json.Unmarshal(rejected[0].payload, &resp))
+	assert.Equal(t, 403, resp.Code)
+	_, err := m.Get(context.Background(), "dev1")
+	assert.ErrorIs(t, err, ErrShadowNotFound)
+
+	var checks []hook.AccessType
+	h.SetACL(aclFunc(func(client *hook.Client, topic string, access hook.AccessType) bool {
+		checks = append(checks, access)
+		return client.ID == "fleet-manager"
+	}))
+	require.NoError(t, h.OnPublish(&hook.Client{ID: "fleet-manager"}, update))
+	assert.ErrorIs(t, h.OnPublish(&hook.Client{ID: "dev1"}, update), ErrNotAuthorized)
+	require.NoError(t, h.OnPublish(&hook.Client{ID: "fleet-manager"}, &hook.PublishPacket{Topic: "$shadow/dev1/get"}))
+	assert.Equal(t, []hook.AccessType{hook.AccessTypeWrite, hook.AccessTypeWrite, hook.AccessTypeRead}, checks)
+	assert.Len(t, pub.byTopic("$shadow/dev1/update/accepted"), 1)
+}
+
+func TestHook(t *testing.T) {
+	m, pub := newTestManager()
+	h := NewHook(m)
+
+	assert.Equal(t, "shadow", h.ID())
+	assert.True(t, h.Provides(hook.OnPublish))
+	assert.False(t, h.Provides(hook.OnConnect))
+
+	require.NoError(t, h.OnPublish(&hook.Client{ID: "dev1"}, &hook.PublishPacket{Topic: "other/topic"}))
+	assert.Empty(t, pub.messages)
+
+	require.NoError(t, h.OnPublish(&hook.Client{ID: "dev1"}, &hook.PublishPacket{
+		Topic:   UpdateTopic("dev1"),
+		Payload: []byte(`{"state":{"reported":{"on":false}}}`),
+	}))
+	assert.Len(t, pub.byTopic("$shadow/dev1/update/accepted"), 1)
+}
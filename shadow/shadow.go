@@ -0,0 +1,179 @@
+package shadow
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Document is the versioned desired/reported state of a single device
+type Document struct {
+	ClientID  string         `json:"client_id" cbor:"client_id"`
+	Version   uint64         `json:"version" cbor:"version"`
+	Desired   map[string]any `json:"desired,omitempty" cbor:"desired,omitempty"`
+	Reported  map[string]any `json:"reported,omitempty" cbor:"reported,omitempty"`
+	UpdatedAt time.Time      `json:"updated_at" cbor:"updated_at"`
+}
+
+// State holds the desired and reported sections of an update request
+type State struct {
+	Desired  map[string]any `json:"desired,omitempty"`
+	Reported map[string]any `json:"reported,omitempty"`
+}
+
+// UpdateRequest is the payload published to the update topic
+// A zero Version skips the optimistic concurrency check
+type UpdateRequest struct {
+	State       State  `json:"state"`
+	Version     uint64 `json:"version,omitempty"`
+	ClientToken string `json:"clientToken,omitempty"`
+}
+
+// DeltaMessage is published when desired state differs from reported state
+type DeltaMessage struct {
+	State     map[string]any `json:"state"`
+	Version   uint64         `json:"version"`
+	Timestamp int64          `json:"timestamp"`
+}
+
+// ErrorResponse is published to the rejected topic
+type ErrorResponse struct {
+	Code        int    `json:"code"`
+	Message     string `json:"message"`
+	ClientToken string `json:"clientToken,omitempty"`
+}
+
+// NewDocument creates an empty shadow document for a client
+func NewDocument(clientID string) *Document {
+	return &Document{
+		ClientID: clientID,
+		Desired:  make(map[string]any),
+		Reported: make(map[string]any),
+	}
+}
+
+// Clone returns a deep copy of the document
+func (d *Document) Clone() *Document {
+	return &Document{
+		ClientID:  d.ClientID,
+		Version:   d.Version,
+		Desired:   cloneMap(d.Desired),
+		Reported:  cloneMap(d.Reported),
+		UpdatedAt: d.UpdatedAt,
+	}
+}
+
+// Apply merges the given state into the document and bumps its version
+// A nil value in the request removes the corresponding key
+func (d *Document) Apply(state State) {
+	if d.Desired == nil {
+		d.Desired = make(map[string]any)
+	}
+	if d.Reported == nil {
+		d.Reported = make(map[string]any)
+	}
+	merge(d.Desired, state.Desired)
+	merge(d.Reported, state.Reported)
+	d.Version++
+	d.UpdatedAt = time.Now()
+}
+
+// Delta returns the desired keys whose values differ from the reported state
+func (d *Document) Delta() map[string]any {
+	return delta(d.Desired, d.Reported)
+}
+
+func merge(dst, src map[string]any) {
+	for k, v := range src {
+		if v == nil {
+			delete(dst, k)
+			continue
+		}
+		if srcMap, ok := v.(map[string]any); ok {
+			dstMap, ok := dst[k].(map[string]any)
+			if !ok {
+				dstMap = make(map[string]any)
+			}
+			merge(dstMap, srcMap)
+			if len(dstMap) == 0 {
+				delete(dst, k)
+			} else {
+				dst[k] = dstMap
+			}
+			continue
+		}
+		dst[k] = cloneValue(v)
+	}
+}
+
+func delta(desired, reported map[string]any) map[string]any {
+	result := make(map[string]any)
+	for k, want := range desired {
+		have, ok := reported[k]
+		wantMap, wantIsMap := want.(map[string]any)
+		haveMap, haveIsMap := have.(map[string]any)
+		if ok && wantIsMap && haveIsMap {
+			if sub := delta(wantMap, haveMap); len(sub) > 0 {
+				result[k] = sub
+			}
+			continue
+		}
+		if !ok || !equalValues(want, have) {
+			result[k] = cloneValue(want)
+		}
+	}
+	return result
+}
+
+// equalValues compares values after normalizing them through JSON so that
+// numeric types decoded from different sources compare equal
+func equalValues(a, b any) bool {
+	return reflect.DeepEqual(normalize(a), normalize(b))
+}
+
+func normalize(v any) any {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out any
+	if err := json.Unmarshal(data, &out); err != nil {
+		return v
+	}
+	return out
+}
+
+func cloneMap(m map[string]any) map[string]any {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		out[k] = cloneValue(v)
+	}
+	return out
+}
+
+// cloneValue deep copies a value, nested objects decoded by CBOR stores as map[any]any become map[string]any so
+// documents loaded from any store merge, diff and encode to JSON alike
+func cloneValue(v any) any {
+	switch val := v.(type) {
+	case map[string]any:
+		return cloneMap(val)
+	case map[any]any:
+		out := make(map[string]any, len(val))
+		for k, item := range val {
+			out[fmt.Sprint(k)] = cloneValue(item)
+		}
+		return out
+	case []any:
+		out := make([]any, len(val))
+		for i, item := range val {
+			out[i] = cloneValue(item)
+		}
+		return out
+	default:
+		return v
+	}
+}
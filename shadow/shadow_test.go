@@ -0,0 +1,68 @@
+package shadow
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDocumentApply(t *testing.T) {
+	doc := NewDocument("dev1")
+
+	doc.Apply(State{
+		Desired:  map[string]any{"color": "red", "config": map[string]any{"rate": 5, "mode": "eco"}},
+		Reported: map[string]any{"color": "blue"},
+	})
+	assert.Equal(t, uint64(1), doc.Version)
+	assert.False(t, doc.UpdatedAt.IsZero())
+	assert.Equal(t, "red", doc.Desired["color"])
+	assert.Equal(t, "blue", doc.Reported["color"])
+
+	doc.Apply(State{Desired: map[string]any{"color": nil, "config": map[string]any{"mode": nil}}})
+	assert.Equal(t, uint64(2), doc.Version)
+	assert.NotContains(t, doc.Desired, "color")
+	assert.Equal(t, map[string]any{"rate": 5}, doc.Desired["config"])
+
+	doc.Apply(State{Desired: map[string]any{"config": map[string]any{"rate": nil}}})
+	assert.NotContains(t, doc.Desired, "config")
+}
+
+func TestDocumentDelta(t *testing.T) {
+	tests := []struct {
+		name     string
+		desired  map[string]any
+		reported map[string]any
+		expected map[string]any
+	}{
+		{name: "in sync", desired: map[string]any{"a": 1}, reported: map[string]any{"a": 1}, expected: map[string]any{}},
+		{name: "numeric types", desired: map[string]any{"a": 1}, reported: map[string]any{"a": float64(1)}, expected: map[string]any{}},
+		{name: "differs", desired: map[string]any{"a": 1, "b": "x"}, reported: map[string]any{"a": 2, "b": "x"}, expected: map[string]any{"a": 1}},
+		{name: "missing", desired: map[string]any{"a": 1}, reported: map[string]any{}, expected: map[string]any{"a": 1}},
+		{name: "reported only", desired: map[string]any{}, reported: map[string]any{"a": 1}, expected: map[string]any{}},
+		{
+			name:     "nested",
+			desired:  map[string]any{"cfg": map[string]any{"x": 1, "y": 2}},
+			reported: map[string]any{"cfg": map[string]any{"x": 1, "y": 3}},
+			expected: map[string]any{"cfg": map[string]any{"y": 2}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := &Document{Desired: tt.desired, Reported: tt.reported}
+			assert.Equal(t, tt.expected, doc.Delta())
+		})
+	}
+}
+
+func TestDocumentClone(t *testing.T) {
+	doc := NewDocument("dev1")
+	doc.Apply(State{Desired: map[string]any{"cfg": map[string]any{"x": 1}}})
+
+	clone := doc.Clone()
+	clone.Desired["cfg"].(map[string]any)["x"] = 2
+	clone.Version = 10
+
+	assert.Equal(t, 1, doc.Desired["cfg"].(map[string]any)["x"])
+	assert.Equal(t, uint64(1), doc.Version)
+}
@@ -0,0 +1,39 @@
+package store
+
+import (
+	"context"
+	"errors"
+)
+
+// Op is a single write of a batch, either saving Value under Key or deleting Key
+type Op[T any] struct {
+	Key    string
+	Value  T
+	Delete bool
+}
+
+// BatchWriter is implemented by stores that can apply several writes at once, atomically and with a single sync
+type BatchWriter[T any] interface {
+	// Apply applies ops in order, deleting a missing key is not an error
+	Apply(ctx context.Context, ops []Op[T]) error
+}
+
+// Apply writes ops to s in one batch, stores without BatchWriter get one call per op and may be left with a
+// prefix of the ops applied when one fails
+func Apply[T any](ctx context.Context, s Store[T], ops []Op[T]) error {
+	if writer, ok := s.(BatchWriter[T]); ok {
+		return writer.Apply(ctx, ops)
+	}
+	for _, op := range ops {
+		var err error
+		if op.Delete {
+			err = s.Delete(ctx, op.Key)
+		} else {
+			err = s.Save(ctx, op.Key, op.Value)
+		}
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,9 +1,17 @@
 package store
 
-import "errors"
+import axerrors "github.com/axmq/ax/pkg/errors"
 
 var (
-	ErrNotFound      = errors.New("key not found")
-	ErrAlreadyExists = errors.New("key already exists")
-	ErrStoreClosed   = errors.New("store is closed")
+	ErrNotFound      = axerrors.New(axerrors.KindStorage, "key not found")
+	ErrAlreadyExists = axerrors.New(axerrors.KindStorage, "key already exists")
+	ErrStoreClosed   = axerrors.New(axerrors.KindStorage, "store is closed")
+	ErrEmptyPrefix   = axerrors.New(axerrors.KindInternal, "key prefix cannot be empty")
+
+	ErrKeyspaceConflict = axerrors.New(axerrors.KindInternal, "keyspace id already used by another data type")
+
+	ErrInvalidMigration = axerrors.New(axerrors.KindInternal, "invalid migration")
+	ErrMigrationFailed  = axerrors.New(axerrors.KindStorage, "migration failed")
+	ErrMigrationDirty   = axerrors.New(axerrors.KindStorage, "migration interrupted, store needs repair")
+	ErrSchemaTooNew     = axerrors.New(axerrors.KindStorage, "store schema is newer than this broker")
 )
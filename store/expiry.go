@@ -0,0 +1,83 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// ExpiryIndex is implemented by stores that keep keys ordered by an expiry time,
+// so sweepers can fetch the keys due before a deadline without scanning every value
+type ExpiryIndex interface {
+	// SetExpiry indexes key under expiresAt, a zero time removes the key from the index
+	SetExpiry(ctx context.Context, key string, expiresAt time.Time) error
+
+	// ExpiringBefore returns up to limit keys expiring before t, earliest first
+	// A limit of zero or less returns all of them
+	ExpiringBefore(ctx context.Context, t time.Time, limit int) ([]string, error)
+}
+
+type expiryEntry struct {
+	at  int64
+	key string
+}
+
+func (e expiryEntry) less(o expiryEntry) bool {
+	return e.at < o.at || (e.at == o.at && e.key < o.key)
+}
+
+// expiryList is a sorted in-memory expiry index
+type expiryList struct {
+	entries []expiryEntry
+	byKey   map[string]int64
+}
+
+func newExpiryList() *expiryList {
+	return &expiryList{byKey: make(map[string]int64)}
+}
+
+func (l *expiryList) search(e expiryEntry) int {
+	return sort.Search(len(l.entries), func(i int) bool {
+		return !l.entries[i].less(e)
+	})
+}
+
+func (l *expiryList) remove(key string) {
+	at, ok := l.byKey[key]
+	if !ok {
+		return
+	}
+	delete(l.byKey, key)
+
+	i := l.search(expiryEntry{at: at, key: key})
+	if i < len(l.entries) && l.entries[i].key == key {
+		l.entries = append(l.entries[:i], l.entries[i+1:]...)
+	}
+}
+
+func (l *expiryList) set(key string, expiresAt time.Time) {
+	l.remove(key)
+	if expiresAt.IsZero() {
+		return
+	}
+
+	e := expiryEntry{at: expiresAt.UnixNano(), key: key}
+	i := l.search(e)
+	l.entries = append(l.entries, expiryEntry{})
+	copy(l.entries[i+1:], l.entries[i:])
+	l.entries[i] = e
+	l.byKey[key] = e.at
+}
+
+func (l *expiryList) before(t time.Time, limit int) []string {
+	end := l.search(expiryEntry{at: t.UnixNano()})
+	if limit > 0 && end > limit {
+		end = limit
+	}
+
+	keys := make([]string, end)
+	for i := range keys {
+		keys[i] = l.entries[i].key
+	}
+	return keys
+}
@@ -0,0 +1,327 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// GroupCommitConfig configures a GroupCommitter
+type GroupCommitConfig struct {
+	// MaxLatency is the longest a write waits in memory before its batch is committed
+	MaxLatency time.Duration
+	// MaxBatch commits early once this many keys are pending
+	MaxBatch int
+}
+
+// DefaultGroupCommitConfig returns the default group commit settings
+func DefaultGroupCommitConfig() GroupCommitConfig {
+	return GroupCommitConfig{
+		MaxLatency: 5 * time.Millisecond,
+		MaxBatch:   1024,
+	}
+}
+
+// GroupCommitStats holds the counters of a GroupCommitter
+type GroupCommitStats struct {
+	Pending   int
+	Writes    uint64 // Save and Delete calls
+	Coalesced uint64 // writes replacing a pending write of the same key
+	Commits   uint64
+	Failed    uint64
+}
+
+// commitBatch is the set of pending writes committed together, done is closed once err is set
+// Its prefix deletes are applied before its writes, which were all queued after them
+type commitBatch[T any] struct {
+	ops      map[string]int
+	order    []Op[T]
+	prefixes []string
+	done     chan struct{}
+	err      error
+}
+
+func newCommitBatch[T any]() *commitBatch[T] {
+	return &commitBatch[T]{ops: make(map[string]int), done: make(chan struct{})}
+}
+
+// GroupCommitter buffers writes to a store and commits them together every MaxLatency, turning a sync per
+// write into a sync per batch. Writes of the same key within a batch collapse into the last one
+//
+// Save and Delete return before the write is durable. A crash loses the writes of the batches not committed
+// yet, at most MaxLatency of them, and nothing else: each batch is applied atomically on stores implementing
+// BatchWriter, so after a restart the store holds the state as of some commit, never part of a batch.
+// Callers that must not lose a write call Sync, which waits for the commit holding it while still sharing
+// that commit with every other pending write
+//
+// A failed commit is retried with the next batch, unless a newer write of the same key replaced it, and the
+// error is reported to the callers waiting on that commit
+type GroupCommitter[T any] struct {
+	store  Store[T]
+	config GroupCommitConfig
+
+	mu       sync.Mutex
+	pending  *commitBatch[T]
+	inflight *commitBatch[T] // batch being committed, nil when idle
+	closed   bool
+
+	commitMu sync.Mutex // keeps batches committing in order
+	kick     chan struct{}
+	stopCh   chan struct{}
+	wg       sync.WaitGroup
+
+	writes    atomic.Uint64
+	coalesced atomic.Uint64
+	commits   atomic.Uint64
+	failed    atomic.Uint64
+}
+
+// NewGroupCommitter starts a group committer writing to s, s stays owned by the caller
+func NewGroupCommitter[T any](s Store[T], config GroupCommitConfig) *GroupCommitter[T] {
+	defaults := DefaultGroupCommitConfig()
+	if config.MaxLatency <= 0 {
+		config.MaxLatency = defaults.MaxLatency
+	}
+	if config.MaxBatch <= 0 {
+		config.MaxBatch = defaults.MaxBatch
+	}
+
+	c := &GroupCommitter[T]{
+		store:   s,
+		config:  config,
+		pending: newCommitBatch[T](),
+		kick:    make(chan struct{}, 1),
+		stopCh:  make(chan struct{}),
+	}
+	c.wg.Add(1)
+	go c.commitLoop()
+	return c
+}
+
+// Save queues saving value under key
+func (c *GroupCommitter[T]) Save(key string, value T) error {
+	return c.queue(Op[T]{Key: key, Value: value})
+}
+
+// Delete queues deleting key
+func (c *GroupCommitter[T]) Delete(key string) error {
+	return c.queue(Op[T]{Key: key, Delete: true})
+}
+
+func (c *GroupCommitter[T]) queue(op Op[T]) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrStoreClosed
+	}
+	c.writes.Add(1)
+	if c.pending.put(op) {
+		c.coalesced.Add(1)
+	}
+	full := len(c.pending.order) >= c.config.MaxBatch
+	c.mu.Unlock()
+
+	if full {
+		select {
+		case c.kick <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// put adds op to the batch and reports whether it replaced a write of the same key
+func (b *commitBatch[T]) put(op Op[T]) bool {
+	if i, ok := b.ops[op.Key]; ok {
+		b.order[i] = op
+		return true
+	}
+	b.ops[op.Key] = len(b.order)
+	b.order = append(b.order, op)
+	return false
+}
+
+// deletePrefix discards the pending writes of keys starting with prefix and queues deleting them from the store
+func (b *commitBatch[T]) deletePrefix(prefix string) {
+	order := b.order[:0]
+	clear(b.ops)
+	for _, op := range b.order {
+		if strings.HasPrefix(op.Key, prefix) {
+			continue
+		}
+		b.ops[op.Key] = len(order)
+		order = append(order, op)
+	}
+	clear(b.order[len(order):])
+	b.order = order
+	b.prefixes = append(b.prefixes, prefix)
+}
+
+// deletedByPrefix reports whether a prefix delete of the batch covers key
+func (b *commitBatch[T]) deletedByPrefix(key string) bool {
+	for _, prefix := range b.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *commitBatch[T]) empty() bool {
+	return len(b.order) == 0 && len(b.prefixes) == 0
+}
+
+// DeletePrefix queues deleting every key starting with prefix and waits for its commit
+// Pending writes of those keys are discarded, writes queued afterwards are committed after the delete, so a
+// write racing with the delete can never be undone by it nor bring a deleted key back
+func (c *GroupCommitter[T]) DeletePrefix(ctx context.Context, prefix string) error {
+	if prefix == "" {
+		return ErrEmptyPrefix
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrStoreClosed
+	}
+	c.writes.Add(1)
+	b := c.pending
+	b.deletePrefix(prefix)
+	c.mu.Unlock()
+
+	if err := c.Flush(ctx); err != nil {
+		return err
+	}
+	select {
+	case <-b.done:
+		return b.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Sync waits until every write queued so far is committed, without forcing an early commit
+func (c *GroupCommitter[T]) Sync(ctx context.Context) error {
+	c.mu.Lock()
+	b := c.pending
+	if b.empty() {
+		// Nothing new, only the batch being committed may hold earlier writes
+		b = c.inflight
+	}
+	c.mu.Unlock()
+
+	if b == nil {
+		return nil
+	}
+	select {
+	case <-b.done:
+		return b.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Flush commits the pending writes now and waits for them
+func (c *GroupCommitter[T]) Flush(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.commit(ctx)
+}
+
+// commit applies the pending batch, on failure its writes go back into the next batch
+func (c *GroupCommitter[T]) commit(ctx context.Context) error {
+	c.commitMu.Lock()
+	defer c.commitMu.Unlock()
+
+	c.mu.Lock()
+	b := c.pending
+	if b.empty() {
+		c.mu.Unlock()
+		return nil
+	}
+	c.pending = newCommitBatch[T]()
+	c.inflight = b
+	c.mu.Unlock()
+
+	var err error
+	for _, prefix := range b.prefixes {
+		if err = c.store.DeletePrefix(ctx, prefix); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = Apply(ctx, c.store, b.order)
+	}
+
+	c.mu.Lock()
+	c.inflight = nil
+	if err != nil {
+		for _, op := range b.order {
+			if _, replaced := c.pending.ops[op.Key]; !replaced && !c.pending.deletedByPrefix(op.Key) {
+				c.pending.put(op)
+			}
+		}
+		// The failed prefix deletes precede every write queued since, so they are applied first again
+		c.pending.prefixes = append(b.prefixes, c.pending.prefixes...)
+	}
+	c.mu.Unlock()
+
+	if err != nil {
+		c.failed.Add(1)
+	} else {
+		c.commits.Add(1)
+	}
+	b.err = err
+	close(b.done)
+	return err
+}
+
+func (c *GroupCommitter[T]) commitLoop() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.config.MaxLatency)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.kick:
+		case <-c.stopCh:
+			return
+		}
+		_ = c.commit(context.Background())
+	}
+}
+
+// Stats returns the counters of the committer
+func (c *GroupCommitter[T]) Stats() GroupCommitStats {
+	c.mu.Lock()
+	pending := len(c.pending.order)
+	c.mu.Unlock()
+
+	return GroupCommitStats{
+		Pending:   pending,
+		Writes:    c.writes.Load(),
+		Coalesced: c.coalesced.Load(),
+		Commits:   c.commits.Load(),
+		Failed:    c.failed.Load(),
+	}
+}
+
+// Close stops the commit loop and commits the remaining writes, the store is left open
+func (c *GroupCommitter[T]) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrStoreClosed
+	}
+	c.closed = true
+	c.mu.Unlock()
+
+	close(c.stopCh)
+	c.wg.Wait()
+	return c.commit(context.Background())
+}
@@ -0,0 +1,242 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// applyCounter counts the batches applied to a memory store and fails them while fail is set
+type applyCounter struct {
+	*MemoryStore[testData]
+	applies atomic.Int32
+	fail    atomic.Bool
+}
+
+func (a *applyCounter) Apply(ctx context.Context, ops []Op[testData]) error {
+	if a.fail.Load() {
+		return errors.New("disk full")
+	}
+	a.applies.Add(1)
+	return a.MemoryStore.Apply(ctx, ops)
+}
+
+func TestGroupCommitter_BatchesWrites(t *testing.T) {
+	ctx := context.Background()
+	s := &applyCounter{MemoryStore: NewMemoryStore[testData]()}
+	require.NoError(t, s.Save(ctx, "acked", testData{ID: "acked"}))
+
+	c := NewGroupCommitter[testData](s, GroupCommitConfig{MaxLatency: time.Hour})
+	require.NoError(t, c.Save("a", testData{ID: "a", Age: 1}))
+	require.NoError(t, c.Save("a", testData{ID: "a", Age: 2}))
+	require.NoError(t, c.Save("b", testData{ID: "b"}))
+	require.NoError(t, c.Delete("b"))
+	require.NoError(t, c.Delete("acked"))
+
+	// Nothing reaches the store before the commit
+	keys, err := s.List(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"acked"}, keys)
+	assert.Equal(t, 3, c.Stats().Pending)
+
+	require.NoError(t, c.Flush(ctx))
+	assert.Equal(t, int32(1), s.applies.Load())
+
+	v, err := s.Load(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, 2, v.Age)
+	count, err := s.Count(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	stats := c.Stats()
+	assert.Zero(t, stats.Pending)
+	assert.Equal(t, uint64(5), stats.Writes)
+	assert.Equal(t, uint64(2), stats.Coalesced)
+	assert.Equal(t, uint64(1), stats.Commits)
+
+	require.NoError(t, c.Close())
+	assert.ErrorIs(t, c.Save("c", testData{}), ErrStoreClosed)
+	assert.ErrorIs(t, c.Close(), ErrStoreClosed)
+}
+
+func TestGroupCommitter_CommitsWithinMaxLatency(t *testing.T) {
+	ctx := context.Background()
+	s := &applyCounter{MemoryStore: NewMemoryStore[testData]()}
+	c := NewGroupCommitter[testData](s, GroupCommitConfig{MaxLatency: 5 * time.Millisecond})
+	defer c.Close()
+
+	// Concurrent writers waiting on Sync share the periodic commits
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key := strconv.Itoa(i)
+			assert.NoError(t, c.Save(key, testData{ID: key}))
+			assert.NoError(t, c.Sync(ctx))
+			exists, err := s.Exists(ctx, key)
+			assert.NoError(t, err)
+			assert.True(t, exists)
+		}()
+	}
+	wg.Wait()
+
+	assert.Less(t, s.applies.Load(), int32(50))
+	assert.NoError(t, c.Sync(ctx))
+}
+
+func TestGroupCommitter_MaxBatch(t *testing.T) {
+	ctx := context.Background()
+	s := &applyCounter{MemoryStore: NewMemoryStore[testData]()}
+	c := NewGroupCommitter[testData](s, GroupCommitConfig{MaxLatency: time.Hour, MaxBatch: 2})
+	defer c.Close()
+
+	require.NoError(t, c.Save("a", testData{ID: "a"}))
+	require.NoError(t, c.Save("b", testData{ID: "b"}))
+
+	syncCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	require.NoError(t, c.Sync(syncCtx))
+	assert.Equal(t, int32(1), s.applies.Load())
+}
+
+func TestGroupCommitter_RetriesFailedCommit(t *testing.T) {
+	ctx := context.Background()
+	s := &applyCounter{MemoryStore: NewMemoryStore[testData]()}
+	c := NewGroupCommitter[testData](s, GroupCommitConfig{MaxLatency: time.Hour})
+	defer c.Close()
+
+	require.NoError(t, c.Save("a", testData{ID: "a", Age: 1}))
+	require.NoError(t, c.Save("b", testData{ID: "b", Age: 1}))
+	s.fail.Store(true)
+	assert.Error(t, c.Flush(ctx))
+	assert.Equal(t, uint64(1), c.Stats().Failed)
+	assert.Equal(t, 2, c.Stats().Pending)
+
+	// A newer write of a key wins over the failed one
+	require.NoError(t, c.Save("b", testData{ID: "b", Age: 2}))
+	s.fail.Store(false)
+	require.NoError(t, c.Flush(ctx))
+
+	a, err := s.Load(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, 1, a.Age)
+	b, err := s.Load(ctx, "b")
+	require.NoError(t, err)
+	assert.Equal(t, 2, b.Age)
+}
+
+func TestGroupCommitter_DeletePrefix(t *testing.T) {
+	ctx := context.Background()
+	s := &applyCounter{MemoryStore: NewMemoryStore[testData]()}
+	require.NoError(t, s.Save(ctx, "c1/1", testData{ID: "c1/1"}))
+	require.NoError(t, s.Save(ctx, "c2/1", testData{ID: "c2/1"}))
+	c := NewGroupCommitter[testData](s, GroupCommitConfig{MaxLatency: time.Hour})
+	defer c.Close()
+
+	assert.ErrorIs(t, c.DeletePrefix(ctx, ""), ErrEmptyPrefix)
+
+	// A pending write of the prefix is discarded instead of being committed after the delete
+	require.NoError(t, c.Save("c1/2", testData{ID: "c1/2"}))
+	require.NoError(t, c.DeletePrefix(ctx, "c1/"))
+	keys, err := s.List(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c2/1"}, keys)
+
+	// A failed delete is retried before the writes queued after it, which survive it
+	require.NoError(t, c.Save("c2/2", testData{ID: "c2/2"}))
+	s.fail.Store(true)
+	assert.Error(t, c.DeletePrefix(ctx, "c2/"))
+	require.NoError(t, c.Save("c2/3", testData{ID: "c2/3"}))
+	s.fail.Store(false)
+	require.NoError(t, c.Flush(ctx))
+	keys, err = s.List(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c2/3"}, keys)
+}
+
+func TestGroupCommitter_CrashRecovery(t *testing.T) {
+	ctx := context.Background()
+	path := t.TempDir()
+	s, err := NewPebbleStore[testData](PebbleStoreConfig{Path: path})
+	require.NoError(t, err)
+
+	c := NewGroupCommitter[testData](s, GroupCommitConfig{MaxLatency: time.Hour})
+	t.Cleanup(func() { _ = c.Close() })
+	require.NoError(t, c.Save("1", testData{ID: "1"}))
+	require.NoError(t, c.Save("2", testData{ID: "2"}))
+	require.NoError(t, c.Flush(ctx))
+
+	// The last batch is lost when the process dies before its commit
+	require.NoError(t, c.Delete("1"))
+	require.NoError(t, c.Save("3", testData{ID: "3"}))
+	require.NoError(t, s.Close())
+
+	reopened, err := NewPebbleStore[testData](PebbleStoreConfig{Path: path})
+	require.NoError(t, err)
+	defer reopened.Close()
+	keys, err := reopened.List(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"1", "2"}, keys)
+}
+
+func TestApply_Fallback(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore[testData]()
+	require.NoError(t, s.Save(ctx, "a", testData{ID: "a"}))
+
+	// Wrapping hides BatchWriter, so every op is written on its own
+	var plain Store[testData] = struct{ Store[testData] }{s}
+	require.NoError(t, Apply(ctx, plain, []Op[testData]{
+		{Key: "a", Delete: true},
+		{Key: "missing", Delete: true},
+		{Key: "b", Value: testData{ID: "b"}},
+	}))
+
+	keys, err := s.List(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b"}, keys)
+}
+
+func BenchmarkPebbleStore_SaveDelete(b *testing.B) {
+	s, err := NewPebbleStore[testData](PebbleStoreConfig{Path: b.TempDir()})
+	require.NoError(b, err)
+	defer s.Close()
+
+	ctx := context.Background()
+	var n atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			key := strconv.FormatInt(n.Add(1), 10)
+			_ = s.Save(ctx, key, testData{ID: key})
+			_ = s.Delete(ctx, key)
+		}
+	})
+}
+
+func BenchmarkGroupCommitter_SaveDelete(b *testing.B) {
+	s, err := NewPebbleStore[testData](PebbleStoreConfig{Path: b.TempDir()})
+	require.NoError(b, err)
+	defer s.Close()
+	c := NewGroupCommitter[testData](s, DefaultGroupCommitConfig())
+	defer c.Close()
+
+	var n atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			key := strconv.FormatInt(n.Add(1), 10)
+			_ = c.Save(key, testData{ID: key})
+			_ = c.Delete(key)
+		}
+	})
+}
@@ -2,20 +2,24 @@ package store
 
 import (
 	"context"
+	"strings"
 	"sync"
+	"time"
 )
 
 // MemoryStore is an in-memory implementation of the Store interface
 type MemoryStore[T any] struct {
 	mu     sync.RWMutex
 	data   map[string]T
+	expiry *expiryList
 	closed bool
 }
 
 // NewMemoryStore creates a new in-memory store
 func NewMemoryStore[T any]() *MemoryStore[T] {
 	return &MemoryStore[T]{
-		data: make(map[string]T),
+		data:   make(map[string]T),
+		expiry: newExpiryList(),
 	}
 }
 
@@ -72,6 +76,56 @@ func (m *MemoryStore[T]) Delete(ctx context.Context, key string) error {
 	}
 
 	delete(m.data, key)
+	m.expiry.remove(key)
+	return nil
+}
+
+// DeletePrefix removes every value whose key starts with prefix
+func (m *MemoryStore[T]) DeletePrefix(ctx context.Context, prefix string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if prefix == "" {
+		return ErrEmptyPrefix
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrStoreClosed
+	}
+
+	for key := range m.data {
+		if strings.HasPrefix(key, prefix) {
+			delete(m.data, key)
+			m.expiry.remove(key)
+		}
+	}
+	return nil
+}
+
+// Apply applies ops under a single lock, so readers see all of them or none
+func (m *MemoryStore[T]) Apply(ctx context.Context, ops []Op[T]) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrStoreClosed
+	}
+
+	for _, op := range ops {
+		if op.Delete {
+			delete(m.data, op.Key)
+			m.expiry.remove(op.Key)
+			continue
+		}
+		m.data[op.Key] = op.Value
+	}
 	return nil
 }
 
@@ -113,6 +167,28 @@ func (m *MemoryStore[T]) List(ctx context.Context) ([]string, error) {
 	return keys, nil
 }
 
+// ScanKeys returns up to limit keys under prefix following after in ascending order
+func (m *MemoryStore[T]) ScanKeys(ctx context.Context, prefix, after string, limit int) ([]string, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return nil, ErrStoreClosed
+	}
+
+	keys := make([]string, 0)
+	for key := range m.data {
+		if strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+	return scanSorted(keys, prefix, after, limit), nil
+}
+
 // Close closes the store
 func (m *MemoryStore[T]) Close() error {
 	m.mu.Lock()
@@ -124,9 +200,43 @@ func (m *MemoryStore[T]) Close() error {
 
 	m.closed = true
 	m.data = nil
+	m.expiry = newExpiryList()
 	return nil
 }
 
+// SetExpiry indexes a key by its expiry time
+func (m *MemoryStore[T]) SetExpiry(ctx context.Context, key string, expiresAt time.Time) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrStoreClosed
+	}
+
+	m.expiry.set(key, expiresAt)
+	return nil
+}
+
+// ExpiringBefore returns keys expiring before t, earliest first
+func (m *MemoryStore[T]) ExpiringBefore(ctx context.Context, t time.Time, limit int) ([]string, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return nil, ErrStoreClosed
+	}
+
+	return m.expiry.before(t, limit), nil
+}
+
 // Count returns the total number of items
 func (m *MemoryStore[T]) Count(ctx context.Context) (int64, error) {
 	if ctx.Err() != nil {
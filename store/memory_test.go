@@ -3,6 +3,7 @@ package store
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -487,3 +488,87 @@ func BenchmarkMemoryStore_Count(b *testing.B) {
 		store.Count(ctx)
 	}
 }
+
+func TestMemoryStore_ExpiryIndex(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore[testData]()
+	defer store.Close()
+
+	base := time.Unix(1000, 0)
+	require.NoError(t, store.SetExpiry(ctx, "c", base.Add(3*time.Second)))
+	require.NoError(t, store.SetExpiry(ctx, "a", base.Add(1*time.Second)))
+	require.NoError(t, store.SetExpiry(ctx, "b", base.Add(2*time.Second)))
+
+	keys, err := store.ExpiringBefore(ctx, base.Add(3*time.Second), 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, keys)
+
+	keys, err = store.ExpiringBefore(ctx, base.Add(time.Hour), 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, keys)
+
+	require.NoError(t, store.SetExpiry(ctx, "a", base.Add(4*time.Second)))
+	require.NoError(t, store.SetExpiry(ctx, "b", time.Time{}))
+
+	keys, err = store.ExpiringBefore(ctx, base.Add(time.Hour), 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c", "a"}, keys)
+
+	require.NoError(t, store.Save(ctx, "c", testData{ID: "3"}))
+	require.NoError(t, store.Delete(ctx, "c"))
+
+	keys, err = store.ExpiringBefore(ctx, base.Add(time.Hour), 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, keys)
+}
+
+func TestMemoryStore_DeletePrefix(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore[testData]()
+	defer store.Close()
+
+	for _, key := range []string{"tenant/a/1", "tenant/a/2", "tenant/ab", "tenant/b/1"} {
+		require.NoError(t, store.Save(ctx, key, testData{ID: key}))
+	}
+	require.NoError(t, store.SetExpiry(ctx, "tenant/a/1", time.Unix(1000, 0)))
+
+	require.NoError(t, store.DeletePrefix(ctx, "tenant/a/"))
+
+	keys, err := store.List(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"tenant/ab", "tenant/b/1"}, keys)
+
+	expiring, err := store.ExpiringBefore(ctx, time.Unix(2000, 0), 0)
+	require.NoError(t, err)
+	assert.Empty(t, expiring)
+
+	assert.ErrorIs(t, store.DeletePrefix(ctx, ""), ErrEmptyPrefix)
+
+	require.NoError(t, store.Close())
+	assert.ErrorIs(t, store.DeletePrefix(ctx, "tenant/"), ErrStoreClosed)
+}
+
+func TestMemoryStore_ScanKeys(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore[testData]()
+	for _, key := range []string{"b/2", "a/1", "b/1", "b/3", "c"} {
+		require.NoError(t, store.Save(ctx, key, testData{ID: key}))
+	}
+
+	keys, err := store.ScanKeys(ctx, "b/", "", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b/1", "b/2"}, keys)
+
+	keys, err = store.ScanKeys(ctx, "b/", "b/2", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b/3"}, keys)
+
+	keys, err = store.ScanKeys(ctx, "", "a/1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b/1", "b/2", "b/3", "c"}, keys)
+
+	// A store without KeyScanner is listed and sorted
+	keys, err = ScanKeys[testData](ctx, struct{ Store[testData] }{store}, "b/", "b/1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b/2", "b/3"}, keys)
+}
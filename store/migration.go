@@ -0,0 +1,246 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MigrateFunc changes stored data from the previous schema version to the version of its migration
+type MigrateFunc func(ctx context.Context) error
+
+// Migration is one versioned change of the stored data, such as a new key layout or index
+type Migration struct {
+	Version     uint32
+	Description string
+	Up          MigrateFunc
+}
+
+// MigrationRecord is the record of a migration kept in the store, Dirty is set while it runs
+type MigrationRecord struct {
+	Version     uint32
+	Description string
+	Dirty       bool
+	StartedAt   time.Time
+	AppliedAt   time.Time
+}
+
+// Migrator applies the migrations not yet recorded in a store in version order, run it on startup
+// before the stores are served. A migration that fails or is interrupted leaves a dirty record and
+// blocks further runs until it is resolved with Force, so a half-applied layout change is never built upon
+type Migrator struct {
+	records    Store[*MigrationRecord]
+	migrations []Migration
+	mu         sync.Mutex
+}
+
+// NewMigrator creates a migrator recording applied migrations in records
+// Versions must be positive and unique, they are applied in ascending order whatever order they are given in
+func NewMigrator(records Store[*MigrationRecord], migrations ...Migration) (*Migrator, error) {
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	for i, mig := range sorted {
+		if mig.Version == 0 || mig.Up == nil {
+			return nil, fmt.Errorf("%w: version %d", ErrInvalidMigration, mig.Version)
+		}
+		if i > 0 && sorted[i-1].Version == mig.Version {
+			return nil, fmt.Errorf("%w: duplicate version %d", ErrInvalidMigration, mig.Version)
+		}
+	}
+	return &Migrator{records: records, migrations: sorted}, nil
+}
+
+// Applied returns the migration records of the store in version order, including a dirty one
+func (m *Migrator) Applied(ctx context.Context) ([]*MigrationRecord, error) {
+	keys, err := m.records.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]*MigrationRecord, 0, len(keys))
+	for _, key := range keys {
+		if _, err := strconv.ParseUint(key, 10, 32); err != nil {
+			continue
+		}
+		record, err := m.records.Load(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Version < records[j].Version
+	})
+	return records, nil
+}
+
+// Version returns the highest applied version, zero for a store without migrations
+func (m *Migrator) Version(ctx context.Context) (uint32, error) {
+	records, err := m.Applied(ctx)
+	if err != nil || len(records) == 0 {
+		return 0, err
+	}
+	return records[len(records)-1].Version, nil
+}
+
+// Pending returns the migrations not applied yet in the order Migrate runs them
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	records, err := m.Applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return m.pending(records)
+}
+
+func (m *Migrator) pending(records []*MigrationRecord) ([]Migration, error) {
+	applied := make(map[uint32]bool, len(records))
+	for _, record := range records {
+		if record.Dirty {
+			return nil, fmt.Errorf("%w: version %d", ErrMigrationDirty, record.Version)
+		}
+		applied[record.Version] = true
+	}
+
+	var latest uint32
+	if len(m.migrations) > 0 {
+		latest = m.migrations[len(m.migrations)-1].Version
+	}
+	if len(records) > 0 && records[len(records)-1].Version > latest {
+		return nil, fmt.Errorf("%w: store at version %d, newest known is %d", ErrSchemaTooNew, records[len(records)-1].Version, latest)
+	}
+
+	var pending []Migration
+	for _, mig := range m.migrations {
+		if !applied[mig.Version] {
+			pending = append(pending, mig)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies the pending migrations in version order and returns the versions it applied
+// It stops at the first failure, which is returned wrapping ErrMigrationFailed and leaves the record dirty
+func (m *Migrator) Migrate(ctx context.Context) ([]uint32, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	records, err := m.Applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := m.pending(records)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make([]uint32, 0, len(pending))
+	for _, mig := range pending {
+		if err := ctx.Err(); err != nil {
+			return applied, err
+		}
+
+		record := &MigrationRecord{Version: mig.Version, Description: mig.Description, Dirty: true, StartedAt: time.Now()}
+		if err := m.records.Save(ctx, migrationKey(mig.Version), record); err != nil {
+			return applied, err
+		}
+		if err := mig.Up(ctx); err != nil {
+			return applied, fmt.Errorf("%w: version %d: %w", ErrMigrationFailed, mig.Version, err)
+		}
+
+		record.Dirty = false
+		record.AppliedAt = time.Now()
+		if err := m.records.Save(ctx, migrationKey(mig.Version), record); err != nil {
+			return applied, err
+		}
+		applied = append(applied, mig.Version)
+	}
+	return applied, nil
+}
+
+// Force marks a dirty migration as applied, or forgets it when applied is false so Migrate runs it again,
+// use it after repairing the data of an interrupted migration by hand
+func (m *Migrator) Force(ctx context.Context, version uint32, applied bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	record, err := m.records.Load(ctx, migrationKey(version))
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return m.records.Delete(ctx, migrationKey(version))
+	}
+	record.Dirty = false
+	record.AppliedAt = time.Now()
+	return m.records.Save(ctx, migrationKey(version), record)
+}
+
+// migrationKey zero-pads versions so the records list in version order
+func migrationKey(version uint32) string {
+	return fmt.Sprintf("%010d", version)
+}
+
+// MoveKeys is a migration helper that renames the keys of s, rename returns the new key and false for keys
+// that stay. Values are copied before the old key is deleted, so a rerun after an interruption completes
+// the move. Entries of an expiry index are not moved
+func MoveKeys[T any](ctx context.Context, s Store[T], rename func(key string) (string, bool)) (int, error) {
+	keys, err := s.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	for _, key := range keys {
+		newKey, ok := rename(key)
+		if !ok || newKey == key {
+			continue
+		}
+		value, err := s.Load(ctx, key)
+		if err != nil {
+			return moved, err
+		}
+		if err := s.Save(ctx, newKey, value); err != nil {
+			return moved, err
+		}
+		if err := s.Delete(ctx, key); err != nil {
+			return moved, err
+		}
+		moved++
+	}
+	return moved, nil
+}
+
+// CopyKeys is a migration helper that copies every value of from to to, such as from a store created by
+// NewPebbleStore to the keyspace store replacing it. Existing values of to are overwritten, so a rerun after
+// an interruption completes the copy. Entries of an expiry index are not copied
+func CopyKeys[T any](ctx context.Context, from, to Store[T]) (int, error) {
+	keys, err := from.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	copied := 0
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return copied, err
+		}
+		value, err := from.Load(ctx, key)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return copied, err
+		}
+		if err := to.Save(ctx, key, value); err != nil {
+			return copied, err
+		}
+		copied++
+	}
+	return copied, nil
+}
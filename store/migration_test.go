@@ -0,0 +1,169 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigratorAppliesInOrder(t *testing.T) {
+	ctx := context.Background()
+	records := NewMemoryStore[*MigrationRecord]()
+	var ran []uint32
+	step := func(v uint32) MigrateFunc {
+		return func(context.Context) error {
+			ran = append(ran, v)
+			return nil
+		}
+	}
+
+	m, err := NewMigrator(records,
+		Migration{Version: 3, Description: "index by tenant", Up: step(3)},
+		Migration{Version: 1, Description: "initial", Up: step(1)},
+		Migration{Version: 2, Description: "tenant prefixes", Up: step(2)},
+	)
+	require.NoError(t, err)
+
+	pending, err := m.Pending(ctx)
+	require.NoError(t, err)
+	assert.Len(t, pending, 3)
+
+	applied, err := m.Migrate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{1, 2, 3}, applied)
+	assert.Equal(t, []uint32{1, 2, 3}, ran)
+
+	version, err := m.Version(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(3), version)
+
+	list, err := m.Applied(ctx)
+	require.NoError(t, err)
+	require.Len(t, list, 3)
+	assert.Equal(t, "tenant prefixes", list[1].Description)
+	assert.False(t, list[1].Dirty)
+	assert.False(t, list[1].AppliedAt.IsZero())
+
+	// A restart applies nothing, a new release applies only its new migration
+	applied, err = m.Migrate(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, applied)
+
+	m, err = NewMigrator(records,
+		Migration{Version: 1, Up: step(1)},
+		Migration{Version: 2, Up: step(2)},
+		Migration{Version: 3, Up: step(3)},
+		Migration{Version: 4, Up: step(4)},
+	)
+	require.NoError(t, err)
+	applied, err = m.Migrate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{4}, applied)
+}
+
+func TestMigratorFailureLeavesDirtyRecord(t *testing.T) {
+	ctx := context.Background()
+	records := NewMemoryStore[*MigrationRecord]()
+	fail := true
+	m, err := NewMigrator(records,
+		Migration{Version: 1, Up: func(context.Context) error { return nil }},
+		Migration{Version: 2, Up: func(context.Context) error {
+			if fail {
+				return errors.New("disk full")
+			}
+			return nil
+		}},
+		Migration{Version: 3, Up: func(context.Context) error { return nil }},
+	)
+	require.NoError(t, err)
+
+	applied, err := m.Migrate(ctx)
+	assert.ErrorIs(t, err, ErrMigrationFailed)
+	assert.ErrorContains(t, err, "disk full")
+	assert.Equal(t, []uint32{1}, applied)
+
+	_, err = m.Migrate(ctx)
+	assert.ErrorIs(t, err, ErrMigrationDirty)
+
+	// Forgetting the dirty migration reruns it
+	fail = false
+	require.NoError(t, m.Force(ctx, 2, false))
+	applied, err = m.Migrate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{2, 3}, applied)
+}
+
+func TestMigratorForceApplied(t *testing.T) {
+	ctx := context.Background()
+	records := NewMemoryStore[*MigrationRecord]()
+	m, err := NewMigrator(records, Migration{Version: 1, Up: func(context.Context) error { return errors.New("boom") }})
+	require.NoError(t, err)
+
+	_, err = m.Migrate(ctx)
+	require.Error(t, err)
+	require.NoError(t, m.Force(ctx, 1, true))
+
+	pending, err := m.Pending(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+	assert.ErrorIs(t, m.Force(ctx, 7, true), ErrNotFound)
+}
+
+func TestMigratorRejectsNewerSchema(t *testing.T) {
+	ctx := context.Background()
+	records := NewMemoryStore[*MigrationRecord]()
+	require.NoError(t, records.Save(ctx, migrationKey(5), &MigrationRecord{Version: 5}))
+
+	m, err := NewMigrator(records, Migration{Version: 1, Up: func(context.Context) error { return nil }})
+	require.NoError(t, err)
+	_, err = m.Migrate(ctx)
+	assert.ErrorIs(t, err, ErrSchemaTooNew)
+}
+
+func TestNewMigratorValidation(t *testing.T) {
+	records := NewMemoryStore[*MigrationRecord]()
+	noop := func(context.Context) error { return nil }
+
+	_, err := NewMigrator(records, Migration{Version: 0, Up: noop})
+	assert.ErrorIs(t, err, ErrInvalidMigration)
+	_, err = NewMigrator(records, Migration{Version: 1})
+	assert.ErrorIs(t, err, ErrInvalidMigration)
+	_, err = NewMigrator(records, Migration{Version: 1, Up: noop}, Migration{Version: 1, Up: noop})
+	assert.ErrorIs(t, err, ErrInvalidMigration)
+}
+
+func TestMoveKeys(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore[testData]()
+	require.NoError(t, s.Save(ctx, "session:a", testData{ID: "a"}))
+	require.NoError(t, s.Save(ctx, "session:b", testData{ID: "b"}))
+	require.NoError(t, s.Save(ctx, "tenant:t1:session:c", testData{ID: "c"}))
+
+	toTenant := func(key string) (string, bool) {
+		if !strings.HasPrefix(key, "session:") {
+			return "", false
+		}
+		return "tenant:default:" + key, true
+	}
+	moved, err := MoveKeys[testData](ctx, s, toTenant)
+	require.NoError(t, err)
+	assert.Equal(t, 2, moved)
+
+	v, err := s.Load(ctx, "tenant:default:session:a")
+	require.NoError(t, err)
+	assert.Equal(t, "a", v.ID)
+	exists, err := s.Exists(ctx, "session:a")
+	require.NoError(t, err)
+	assert.False(t, exists)
+	exists, err = s.Exists(ctx, "tenant:t1:session:c")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	moved, err = MoveKeys[testData](ctx, s, toTenant)
+	require.NoError(t, err)
+	assert.Zero(t, moved)
+}
@@ -1,10 +1,16 @@
 package store
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"errors"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/axmq/ax/pkg/compress"
 	"github.com/cockroachdb/pebble"
 	"github.com/fxamacker/cbor/v2"
 )
@@ -15,6 +21,17 @@ type PebbleStore[T any] struct {
 	mu     sync.RWMutex
 	closed bool
 	prefix []byte
+	codec  compress.Codec
+
+	// Expiry index keys, expPrefix orders keys by a big-endian timestamp, refPrefix maps a key back to it
+	expPrefix []byte
+	refPrefix []byte
+
+	// Set for stores of a keyspace in a shared database, which outlives them
+	shared    *PebbleDB
+	keyspace  Keyspace
+	writeOpts *pebble.WriteOptions
+	deletes   atomic.Int64
 }
 
 // PebbleStoreConfig configures the Pebble store
@@ -22,6 +39,8 @@ type PebbleStoreConfig struct {
 	Path   string
 	Prefix string // Optional prefix for keys (useful when sharing a DB)
 	Opts   *pebble.Options
+	// Compression compresses stored values, it must stay the same for the lifetime of the data
+	Compression compress.Codec
 }
 
 // NewPebbleStore creates a new Pebble-based store
@@ -44,11 +63,138 @@ func NewPebbleStore[T any](config PebbleStoreConfig) (*PebbleStore[T], error) {
 	}
 
 	return &PebbleStore[T]{
-		db:     db,
-		prefix: prefix,
+		db:        db,
+		prefix:    slices.Clip(prefix),
+		codec:     config.Compression,
+		expPrefix: slices.Clip(append([]byte("\x00exp:"), prefix...)),
+		refPrefix: slices.Clip(append([]byte("\x00expref:"), prefix...)),
+		writeOpts: pebble.Sync,
 	}, nil
 }
 
+// Expiry index keys of stores created by NewPebbleStore live under expiryKeyspace, outside the data range
+// unless the prefix is empty
+var expiryKeyspace = []byte("\x00exp")
+
+func isExpiryKey(key []byte) bool {
+	return bytes.HasPrefix(key, expiryKeyspace)
+}
+
+func (p *PebbleStore[T]) expiryPrefix() []byte {
+	return append([]byte(nil), p.expPrefix...)
+}
+
+func (p *PebbleStore[T]) expiryKey(at uint64, key string) []byte {
+	k := p.expiryPrefix()
+	k = binary.BigEndian.AppendUint64(k, at)
+	return append(k, key...)
+}
+
+func (p *PebbleStore[T]) expiryRefKey(key string) []byte {
+	return append(append([]byte(nil), p.refPrefix...), key...)
+}
+
+// clearExpiry adds the deletion of the index entries of key to the batch
+func (p *PebbleStore[T]) clearExpiry(batch *pebble.Batch, key string) error {
+	refKey := p.expiryRefKey(key)
+	ref, closer, err := p.db.Get(refKey)
+	if errors.Is(err, pebble.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	at := binary.BigEndian.Uint64(ref)
+	closer.Close()
+
+	if err := batch.Delete(p.expiryKey(at, key), nil); err != nil {
+		return err
+	}
+	return batch.Delete(refKey, nil)
+}
+
+// SetExpiry indexes a key by its expiry time
+func (p *PebbleStore[T]) SetExpiry(ctx context.Context, key string, expiresAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	p.mu.RLock()
+	if p.closed {
+		p.mu.RUnlock()
+		return ErrStoreClosed
+	}
+	p.mu.RUnlock()
+
+	batch := p.db.NewBatch()
+	defer batch.Close()
+
+	if err := p.clearExpiry(batch, key); err != nil {
+		return err
+	}
+	if !expiresAt.IsZero() {
+		at := uint64(expiresAt.UnixNano())
+		if err := batch.Set(p.expiryKey(at, key), nil, nil); err != nil {
+			return err
+		}
+		if err := batch.Set(p.expiryRefKey(key), binary.BigEndian.AppendUint64(nil, at), nil); err != nil {
+			return err
+		}
+	}
+	return batch.Commit(p.writeOpts)
+}
+
+// ExpiringBefore returns keys expiring before t, earliest first
+func (p *PebbleStore[T]) ExpiringBefore(ctx context.Context, t time.Time, limit int) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	p.mu.RLock()
+	if p.closed {
+		p.mu.RUnlock()
+		return nil, ErrStoreClosed
+	}
+	p.mu.RUnlock()
+
+	prefix := p.expiryPrefix()
+	iter, err := p.db.NewIter(&pebble.IterOptions{
+		LowerBound: prefix,
+		UpperBound: binary.BigEndian.AppendUint64(p.expiryPrefix(), uint64(t.UnixNano())),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	keys := make([]string, 0)
+	for iter.First(); iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()[len(prefix)+8:]))
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+	}
+
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// prefixSuccessor returns the smallest key greater than every key starting with prefix,
+// nil when there is none because prefix is empty or only holds 0xff bytes
+func prefixSuccessor(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			end := make([]byte, i+1)
+			copy(end, prefix)
+			end[i]++
+			return end
+		}
+	}
+	return nil
+}
+
 // makeKey creates a key with the prefix
 func (p *PebbleStore[T]) makeKey(key string) []byte {
 	fullKey := make([]byte, len(p.prefix)+len(key))
@@ -74,9 +220,14 @@ func (p *PebbleStore[T]) Save(ctx context.Context, key string, value T) error {
 	if err != nil {
 		return err
 	}
+	if p.codec != nil {
+		if data, err = p.codec.Encode(nil, data); err != nil {
+			return err
+		}
+	}
 
 	fullKey := p.makeKey(key)
-	return p.db.Set(fullKey, data, pebble.Sync)
+	return p.db.Set(fullKey, data, p.writeOpts)
 }
 
 // Load retrieves a value by key
@@ -103,6 +254,12 @@ func (p *PebbleStore[T]) Load(ctx context.Context, key string) (T, error) {
 	}
 	defer closer.Close()
 
+	if p.codec != nil {
+		if data, err = p.codec.Decode(nil, data); err != nil {
+			return zero, err
+		}
+	}
+
 	var value T
 	if err := cbor.Unmarshal(data, &value); err != nil {
 		return zero, err
@@ -124,8 +281,126 @@ func (p *PebbleStore[T]) Delete(ctx context.Context, key string) error {
 	}
 	p.mu.RUnlock()
 
-	fullKey := p.makeKey(key)
-	return p.db.Delete(fullKey, pebble.Sync)
+	batch := p.db.NewBatch()
+	defer batch.Close()
+
+	if err := batch.Delete(p.makeKey(key), nil); err != nil {
+		return err
+	}
+	if err := p.clearExpiry(batch, key); err != nil {
+		return err
+	}
+	if err := batch.Commit(p.writeOpts); err != nil {
+		return err
+	}
+	p.deleted(1)
+	return nil
+}
+
+// Apply commits ops in one Pebble batch, so they land atomically with a single sync
+func (p *PebbleStore[T]) Apply(ctx context.Context, ops []Op[T]) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	p.mu.RLock()
+	if p.closed {
+		p.mu.RUnlock()
+		return ErrStoreClosed
+	}
+	p.mu.RUnlock()
+
+	batch := p.db.NewBatch()
+	defer batch.Close()
+
+	var deletes int64
+	for _, op := range ops {
+		if op.Delete {
+			if err := batch.Delete(p.makeKey(op.Key), nil); err != nil {
+				return err
+			}
+			if err := p.clearExpiry(batch, op.Key); err != nil {
+				return err
+			}
+			deletes++
+			continue
+		}
+
+		data, err := cbor.Marshal(op.Value)
+		if err != nil {
+			return err
+		}
+		if p.codec != nil {
+			if data, err = p.codec.Encode(nil, data); err != nil {
+				return err
+			}
+		}
+		if err := batch.Set(p.makeKey(op.Key), data, nil); err != nil {
+			return err
+		}
+	}
+
+	if err := batch.Commit(p.writeOpts); err != nil {
+		return err
+	}
+	p.deleted(deletes)
+	return nil
+}
+
+// DeletePrefix removes every value whose key starts with prefix with a single range delete
+// Only the expiry index entries under the prefix are iterated, the values themselves never are
+func (p *PebbleStore[T]) DeletePrefix(ctx context.Context, prefix string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if prefix == "" {
+		return ErrEmptyPrefix
+	}
+
+	p.mu.RLock()
+	if p.closed {
+		p.mu.RUnlock()
+		return ErrStoreClosed
+	}
+	p.mu.RUnlock()
+
+	batch := p.db.NewBatch()
+	defer batch.Close()
+
+	refPrefix := p.expiryRefKey(prefix)
+	iter, err := p.db.NewIter(&pebble.IterOptions{
+		LowerBound: refPrefix,
+		UpperBound: prefixSuccessor(refPrefix),
+	})
+	if err != nil {
+		return err
+	}
+	for iter.First(); iter.Valid(); iter.Next() {
+		key := string(iter.Key()[len(refPrefix)-len(prefix):])
+		at := binary.BigEndian.Uint64(iter.Value())
+		if err := batch.Delete(p.expiryKey(at, key), nil); err != nil {
+			iter.Close()
+			return err
+		}
+	}
+	if err := iter.Error(); err != nil {
+		iter.Close()
+		return err
+	}
+	iter.Close()
+
+	if err := batch.DeleteRange(refPrefix, prefixSuccessor(refPrefix), nil); err != nil {
+		return err
+	}
+	start := p.makeKey(prefix)
+	if err := batch.DeleteRange(start, prefixSuccessor(start), nil); err != nil {
+		return err
+	}
+	if err := batch.Commit(p.writeOpts); err != nil {
+		return err
+	}
+	p.deleted(1)
+	return nil
 }
 
 // Exists checks if a key exists
@@ -170,7 +445,7 @@ func (p *PebbleStore[T]) List(ctx context.Context) ([]string, error) {
 
 	iter, err := p.db.NewIter(&pebble.IterOptions{
 		LowerBound: p.prefix,
-		UpperBound: append(p.prefix, 0xff),
+		UpperBound: prefixSuccessor(p.prefix),
 	})
 	if err != nil {
 		return nil, err
@@ -179,6 +454,9 @@ func (p *PebbleStore[T]) List(ctx context.Context) ([]string, error) {
 
 	for iter.First(); iter.Valid(); iter.Next() {
 		key := iter.Key()
+		if isExpiryKey(key) {
+			continue
+		}
 		keyStr := string(key[len(p.prefix):])
 		keys = append(keys, keyStr)
 	}
@@ -190,6 +468,55 @@ func (p *PebbleStore[T]) List(ctx context.Context) ([]string, error) {
 	return keys, nil
 }
 
+// ScanKeys returns up to limit keys under prefix following after in ascending order
+func (p *PebbleStore[T]) ScanKeys(ctx context.Context, prefix, after string, limit int) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	p.mu.RLock()
+	if p.closed {
+		p.mu.RUnlock()
+		return nil, ErrStoreClosed
+	}
+	p.mu.RUnlock()
+
+	lower := p.makeKey(prefix)
+	iter, err := p.db.NewIter(&pebble.IterOptions{
+		LowerBound: lower,
+		UpperBound: prefixSuccessor(lower),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	keys := make([]string, 0)
+	valid := iter.First()
+	if after >= prefix {
+		valid = iter.SeekGE(p.makeKey(after))
+	}
+	for ; valid; valid = iter.Next() {
+		if limit > 0 && len(keys) == limit {
+			break
+		}
+		key := iter.Key()
+		if isExpiryKey(key) {
+			continue
+		}
+		keyStr := string(key[len(p.prefix):])
+		if keyStr == after {
+			continue
+		}
+		keys = append(keys, keyStr)
+	}
+
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
 // Close closes the store
 func (p *PebbleStore[T]) Close() error {
 	p.mu.Lock()
@@ -200,6 +527,9 @@ func (p *PebbleStore[T]) Close() error {
 	}
 
 	p.closed = true
+	if p.shared != nil {
+		return nil
+	}
 	return p.db.Close()
 }
 
@@ -220,7 +550,7 @@ func (p *PebbleStore[T]) Count(ctx context.Context) (int64, error) {
 
 	iter, err := p.db.NewIter(&pebble.IterOptions{
 		LowerBound: p.prefix,
-		UpperBound: append(p.prefix, 0xff),
+		UpperBound: prefixSuccessor(p.prefix),
 	})
 	if err != nil {
 		return 0, err
@@ -228,6 +558,9 @@ func (p *PebbleStore[T]) Count(ctx context.Context) (int64, error) {
 	defer iter.Close()
 
 	for iter.First(); iter.Valid(); iter.Next() {
+		if isExpiryKey(iter.Key()) {
+			continue
+		}
 		count++
 	}
 
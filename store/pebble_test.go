@@ -3,7 +3,9 @@ package store
 import (
 	"context"
 	"testing"
+	"time"
 
+	"github.com/axmq/ax/pkg/compress"
 	"github.com/cockroachdb/pebble"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -871,3 +873,167 @@ func BenchmarkPebbleStore_Count(b *testing.B) {
 		store.Count(ctx)
 	}
 }
+
+func TestPebbleStore_Compression(t *testing.T) {
+	for _, name := range []string{compress.Gzip, compress.Deflate, compress.Snappy} {
+		t.Run(name, func(t *testing.T) {
+			codec, err := compress.Get(name)
+			require.NoError(t, err)
+
+			store, err := NewPebbleStore[testData](PebbleStoreConfig{Path: t.TempDir(), Compression: codec})
+			require.NoError(t, err)
+			defer store.Close()
+
+			ctx := context.Background()
+			value := testData{ID: "1", Name: "compressed", Age: 42}
+			require.NoError(t, store.Save(ctx, "key1", value))
+
+			raw, closer, err := store.db.Get(store.makeKey("key1"))
+			require.NoError(t, err)
+			decoded, err := codec.Decode(nil, raw)
+			closer.Close()
+			require.NoError(t, err)
+			assert.NotEqual(t, raw, decoded)
+
+			loaded, err := store.Load(ctx, "key1")
+			require.NoError(t, err)
+			assert.Equal(t, value, loaded)
+		})
+	}
+}
+
+func TestPebbleStore_ExpiryIndex(t *testing.T) {
+	for _, prefix := range []string{"test:", ""} {
+		t.Run("prefix "+prefix, func(t *testing.T) {
+			ctx := context.Background()
+			store, err := NewPebbleStore[testData](PebbleStoreConfig{
+				Path:   t.TempDir(),
+				Prefix: prefix,
+			})
+			require.NoError(t, err)
+			defer store.Close()
+
+			base := time.Unix(1000, 0)
+			for _, key := range []string{"c", "a", "b"} {
+				require.NoError(t, store.Save(ctx, key, testData{ID: key}))
+			}
+			require.NoError(t, store.SetExpiry(ctx, "c", base.Add(3*time.Second)))
+			require.NoError(t, store.SetExpiry(ctx, "a", base.Add(1*time.Second)))
+			require.NoError(t, store.SetExpiry(ctx, "b", base.Add(2*time.Second)))
+
+			keys, err := store.ExpiringBefore(ctx, base.Add(3*time.Second), 0)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"a", "b"}, keys)
+
+			keys, err = store.ExpiringBefore(ctx, base.Add(time.Hour), 1)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"a"}, keys)
+
+			require.NoError(t, store.SetExpiry(ctx, "a", base.Add(4*time.Second)))
+			require.NoError(t, store.SetExpiry(ctx, "b", time.Time{}))
+			require.NoError(t, store.Delete(ctx, "c"))
+
+			keys, err = store.ExpiringBefore(ctx, base.Add(time.Hour), 0)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"a"}, keys)
+
+			listed, err := store.List(ctx)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []string{"a", "b"}, listed)
+
+			count, err := store.Count(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, int64(2), count)
+		})
+	}
+}
+
+func TestPebbleStore_DeletePrefix(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewPebbleStore[testData](PebbleStoreConfig{
+		Path:   t.TempDir(),
+		Prefix: "test:",
+	})
+	require.NoError(t, err)
+	defer store.Close()
+
+	for _, key := range []string{"tenant/a/1", "tenant/a/2", "tenant/ab", "tenant/b/1"} {
+		require.NoError(t, store.Save(ctx, key, testData{ID: key}))
+	}
+	require.NoError(t, store.SetExpiry(ctx, "tenant/a/1", time.Unix(1000, 0)))
+	require.NoError(t, store.SetExpiry(ctx, "tenant/b/1", time.Unix(1001, 0)))
+
+	require.NoError(t, store.DeletePrefix(ctx, "tenant/a/"))
+
+	keys, err := store.List(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"tenant/ab", "tenant/b/1"}, keys)
+
+	expiring, err := store.ExpiringBefore(ctx, time.Unix(2000, 0), 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tenant/b/1"}, expiring)
+
+	_, err = store.Load(ctx, "tenant/a/2")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.ErrorIs(t, store.DeletePrefix(ctx, ""), ErrEmptyPrefix)
+}
+
+func TestPebbleStore_DeletePrefixHighBytes(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewPebbleStore[testData](PebbleStoreConfig{
+		Path:   t.TempDir(),
+		Prefix: "test:",
+	})
+	require.NoError(t, err)
+	defer store.Close()
+
+	// Keys continuing the prefix with 0xff bytes sort after prefix+0xff
+	for _, key := range []string{"a\xff", "a\xff\xff", "a\xffz", "b", "a\xff\x00"} {
+		require.NoError(t, store.Save(ctx, key, testData{ID: key}))
+	}
+	require.NoError(t, store.DeletePrefix(ctx, "a"))
+
+	keys, err := store.List(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b"}, keys)
+}
+
+func TestPrefixSuccessor(t *testing.T) {
+	assert.Equal(t, []byte("b"), prefixSuccessor([]byte("a")))
+	assert.Equal(t, []byte("b"), prefixSuccessor([]byte("a\xff\xff")))
+	assert.Equal(t, []byte{0x01, 0x03}, prefixSuccessor([]byte{0x01, 0x02}))
+	assert.Nil(t, prefixSuccessor([]byte{0xff, 0xff}))
+	assert.Nil(t, prefixSuccessor(nil))
+}
+
+func TestPebbleStore_ScanKeys(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewPebbleStore[testData](PebbleStoreConfig{
+		Path:   t.TempDir(),
+		Prefix: "test:",
+	})
+	require.NoError(t, err)
+	defer store.Close()
+
+	for _, key := range []string{"b/2", "a/1", "b/1", "b/3", "c"} {
+		require.NoError(t, store.Save(ctx, key, testData{ID: key}))
+	}
+	require.NoError(t, store.SetExpiry(ctx, "b/1", time.Unix(1000, 0)))
+
+	keys, err := store.ScanKeys(ctx, "b/", "", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b/1", "b/2"}, keys)
+
+	keys, err = store.ScanKeys(ctx, "b/", "b/2", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b/3"}, keys)
+
+	keys, err = store.ScanKeys(ctx, "", "a/1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b/1", "b/2", "b/3", "c"}, keys)
+
+	keys, err = store.ScanKeys(ctx, "b/", "z", 0)
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
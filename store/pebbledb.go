@@ -0,0 +1,232 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/axmq/ax/pkg/compress"
+	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/bloom"
+)
+
+// Keys of a keyspace are laid out as keyspaceMarker, the keyspace ID and a kind byte followed by the key,
+// so each data type and its expiry index occupy one contiguous range of the database
+const (
+	keyspaceMarker = 0x01
+
+	keyKindData      = 'd'
+	keyKindExpiry    = 'e'
+	keyKindExpiryRef = 'r'
+)
+
+// Keyspace is the key range of one data type in a shared Pebble database with its write and compaction tuning
+type Keyspace struct {
+	// ID is the leading key byte of the keyspace, unique per database and never reused for another data type
+	ID   byte
+	Name string
+	// NoSync acknowledges writes before they reach the disk, for data that can be rebuilt after a crash
+	NoSync bool
+	// CompactAfterDeletes compacts the keyspace in the background after this many deletes, which clears the
+	// tombstones of churning data before they slow down scans, zero leaves compaction to Pebble
+	CompactAfterDeletes int
+}
+
+// Keyspaces of the broker data types
+var (
+	KeyspaceSessions      = Keyspace{ID: 0x01, Name: "sessions"}
+	KeyspaceSubscriptions = Keyspace{ID: 0x02, Name: "subscriptions"}
+	KeyspaceInflight      = Keyspace{ID: 0x03, Name: "inflight", CompactAfterDeletes: 100000}
+	KeyspaceRetained      = Keyspace{ID: 0x04, Name: "retained"}
+	KeyspaceOfflineQueue  = Keyspace{ID: 0x05, Name: "offline-queue", CompactAfterDeletes: 100000}
+)
+
+func (k Keyspace) key(kind byte) []byte {
+	return []byte{keyspaceMarker, k.ID, kind}
+}
+
+// bounds returns the range holding every key of the keyspace
+func (k Keyspace) bounds() (start, end []byte) {
+	if k.ID == 0xff {
+		return []byte{keyspaceMarker, k.ID}, []byte{keyspaceMarker + 1}
+	}
+	return []byte{keyspaceMarker, k.ID}, []byte{keyspaceMarker, k.ID + 1}
+}
+
+// PebbleDBConfig configures a shared Pebble database
+type PebbleDBConfig struct {
+	Path string
+	// Opts replaces the options of DefaultPebbleOptions
+	Opts *pebble.Options
+}
+
+// DefaultPebbleOptions returns options tuned for broker data: bloom filters on every level since most reads
+// are point lookups of sessions and messages, larger memtables to absorb bursts of inflight churn and an
+// earlier L0 compaction so deleted inflight and queued messages do not pile up in overlapping tables
+func DefaultPebbleOptions() *pebble.Options {
+	levels := make([]pebble.LevelOptions, 7)
+	for i := range levels {
+		levels[i] = pebble.LevelOptions{
+			BlockSize:    32 << 10,
+			FilterPolicy: bloom.FilterPolicy(10),
+			FilterType:   pebble.TableFilter,
+		}
+	}
+	return &pebble.Options{
+		Levels:                levels,
+		MemTableSize:          64 << 20,
+		L0CompactionThreshold: 2,
+		L0StopWritesThreshold: 1000,
+		LBaseMaxBytes:         64 << 20,
+		MaxConcurrentCompactions: func() int {
+			return max(1, runtime.GOMAXPROCS(0)/4)
+		},
+	}
+}
+
+// PebbleDB is a Pebble database shared by the stores of several data types, each in its own keyspace
+// Close the stores before the database
+type PebbleDB struct {
+	db *pebble.DB
+
+	mu         sync.Mutex
+	closed     bool
+	keyspaces  map[byte]string
+	compacting map[byte]bool
+	wg         sync.WaitGroup
+
+	compactions atomic.Uint64
+}
+
+// OpenPebbleDB opens or creates a shared Pebble database
+func OpenPebbleDB(config PebbleDBConfig) (*PebbleDB, error) {
+	opts := config.Opts
+	if opts == nil {
+		opts = DefaultPebbleOptions()
+	}
+
+	db, err := pebble.Open(config.Path, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &PebbleDB{
+		db:         db,
+		keyspaces:  make(map[byte]string),
+		compacting: make(map[byte]bool),
+	}, nil
+}
+
+// NewPebbleKeyspaceStore creates a store for the keyspace ks of db, stores of the same keyspace share its data
+func NewPebbleKeyspaceStore[T any](db *PebbleDB, ks Keyspace, codec compress.Codec) (*PebbleStore[T], error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return nil, ErrStoreClosed
+	}
+	if name, ok := db.keyspaces[ks.ID]; ok && name != ks.Name {
+		return nil, fmt.Errorf("%w: %#x is %q, not %q", ErrKeyspaceConflict, ks.ID, name, ks.Name)
+	}
+	db.keyspaces[ks.ID] = ks.Name
+
+	writeOpts := pebble.Sync
+	if ks.NoSync {
+		writeOpts = pebble.NoSync
+	}
+	return &PebbleStore[T]{
+		db:        db.db,
+		prefix:    ks.key(keyKindData),
+		codec:     codec,
+		expPrefix: ks.key(keyKindExpiry),
+		refPrefix: ks.key(keyKindExpiryRef),
+		shared:    db,
+		keyspace:  ks,
+		writeOpts: writeOpts,
+	}, nil
+}
+
+// Compact compacts the keyspace range, dropping its tombstones and overwritten values
+func (d *PebbleDB) Compact(ctx context.Context, ks Keyspace) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return ErrStoreClosed
+	}
+	d.wg.Add(1)
+	d.mu.Unlock()
+	defer d.wg.Done()
+
+	start, end := ks.bounds()
+	if err := d.db.Compact(start, end, true); err != nil {
+		return err
+	}
+	d.compactions.Add(1)
+	return nil
+}
+
+// compactAsync compacts the keyspace in the background unless a compaction of it is running
+func (d *PebbleDB) compactAsync(ks Keyspace) {
+	d.mu.Lock()
+	if d.closed || d.compacting[ks.ID] {
+		d.mu.Unlock()
+		return
+	}
+	d.compacting[ks.ID] = true
+	d.mu.Unlock()
+
+	go func() {
+		_ = d.Compact(context.Background(), ks)
+		d.mu.Lock()
+		delete(d.compacting, ks.ID)
+		d.mu.Unlock()
+	}()
+}
+
+// DiskUsage estimates the bytes the keyspace takes on disk
+func (d *PebbleDB) DiskUsage(ks Keyspace) (uint64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return 0, ErrStoreClosed
+	}
+
+	start, end := ks.bounds()
+	return d.db.EstimateDiskUsage(start, end)
+}
+
+// Compactions returns the number of keyspace compactions run
+func (d *PebbleDB) Compactions() uint64 {
+	return d.compactions.Load()
+}
+
+// Close waits for running compactions and closes the database
+func (d *PebbleDB) Close() error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return ErrStoreClosed
+	}
+	d.closed = true
+	d.mu.Unlock()
+
+	d.wg.Wait()
+	return d.db.Close()
+}
+
+// deleted counts deletes towards the compaction of the keyspace of a shared store
+func (p *PebbleStore[T]) deleted(n int64) {
+	if p.shared == nil || p.keyspace.CompactAfterDeletes <= 0 {
+		return
+	}
+	if p.deletes.Add(n) < int64(p.keyspace.CompactAfterDeletes) {
+		return
+	}
+	p.deletes.Store(0)
+	p.shared.compactAsync(p.keyspace)
+}
@@ -0,0 +1,143 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPebbleKeyspaceStore_Isolation(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenPebbleDB(PebbleDBConfig{Path: t.TempDir()})
+	require.NoError(t, err)
+
+	sessions, err := NewPebbleKeyspaceStore[testData](db, KeyspaceSessions, nil)
+	require.NoError(t, err)
+	retained, err := NewPebbleKeyspaceStore[testData](db, KeyspaceRetained, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, sessions.Save(ctx, "a", testData{ID: "session"}))
+	require.NoError(t, sessions.Save(ctx, "b", testData{ID: "session"}))
+	require.NoError(t, retained.Save(ctx, "a", testData{ID: "retained"}))
+	require.NoError(t, sessions.SetExpiry(ctx, "a", time.Now().Add(-time.Second)))
+	require.NoError(t, retained.SetExpiry(ctx, "a", time.Now().Add(-time.Second)))
+
+	v, err := sessions.Load(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "session", v.ID)
+	v, err = retained.Load(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "retained", v.ID)
+
+	keys, err := sessions.List(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, keys)
+	count, err := retained.Count(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	expiring, err := sessions.ExpiringBefore(ctx, time.Now(), 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, expiring)
+
+	// Deleting the keys of one keyspace leaves the others alone, the whole keyspace cannot be deleted at once
+	assert.ErrorIs(t, sessions.DeletePrefix(ctx, ""), ErrEmptyPrefix)
+	require.NoError(t, sessions.DeletePrefix(ctx, "a"))
+	require.NoError(t, sessions.DeletePrefix(ctx, "b"))
+	count, err = sessions.Count(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, count)
+	expiring, err = sessions.ExpiringBefore(ctx, time.Now(), 0)
+	require.NoError(t, err)
+	assert.Empty(t, expiring)
+	expiring, err = retained.ExpiringBefore(ctx, time.Now(), 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, expiring)
+
+	usage, err := db.DiskUsage(KeyspaceRetained)
+	require.NoError(t, err)
+	assert.NotZero(t, usage)
+
+	// Closing a keyspace store leaves the shared database open
+	require.NoError(t, sessions.Close())
+	_, err = retained.Load(ctx, "a")
+	require.NoError(t, err)
+	require.NoError(t, retained.Close())
+	require.NoError(t, db.Close())
+	assert.ErrorIs(t, db.Close(), ErrStoreClosed)
+
+	_, err = NewPebbleKeyspaceStore[testData](db, KeyspaceSessions, nil)
+	assert.ErrorIs(t, err, ErrStoreClosed)
+}
+
+func TestPebbleKeyspaceStore_Conflict(t *testing.T) {
+	db, err := OpenPebbleDB(PebbleDBConfig{Path: t.TempDir()})
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = NewPebbleKeyspaceStore[testData](db, KeyspaceInflight, nil)
+	require.NoError(t, err)
+	_, err = NewPebbleKeyspaceStore[testData](db, KeyspaceInflight, nil)
+	require.NoError(t, err)
+	_, err = NewPebbleKeyspaceStore[testData](db, Keyspace{ID: KeyspaceInflight.ID, Name: "other"}, nil)
+	assert.ErrorIs(t, err, ErrKeyspaceConflict)
+}
+
+func TestPebbleKeyspaceStore_CompactAfterDeletes(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenPebbleDB(PebbleDBConfig{Path: t.TempDir()})
+	require.NoError(t, err)
+
+	ks := Keyspace{ID: 0xff, Name: "queue", NoSync: true, CompactAfterDeletes: 3}
+	s, err := NewPebbleKeyspaceStore[testData](db, ks, nil)
+	require.NoError(t, err)
+
+	for _, key := range []string{"1", "2", "3"} {
+		require.NoError(t, s.Save(ctx, key, testData{ID: key}))
+		require.NoError(t, s.Delete(ctx, key))
+	}
+	assert.Eventually(t, func() bool {
+		return db.Compactions() == 1
+	}, time.Second, 5*time.Millisecond)
+
+	require.NoError(t, db.Compact(ctx, KeyspaceSessions))
+	assert.Equal(t, uint64(2), db.Compactions())
+	require.NoError(t, db.Close())
+}
+
+func TestKeyspaceBounds(t *testing.T) {
+	start, end := KeyspaceRetained.bounds()
+	assert.Equal(t, []byte{keyspaceMarker, 0x04}, start)
+	assert.Equal(t, []byte{keyspaceMarker, 0x05}, end)
+
+	start, end = Keyspace{ID: 0xff}.bounds()
+	assert.Equal(t, []byte{keyspaceMarker, 0xff}, start)
+	assert.Equal(t, []byte{keyspaceMarker + 1}, end)
+}
+
+func TestCopyKeys(t *testing.T) {
+	ctx := context.Background()
+	from := NewMemoryStore[testData]()
+	require.NoError(t, from.Save(ctx, "a", testData{ID: "a"}))
+	require.NoError(t, from.Save(ctx, "b", testData{ID: "b"}))
+
+	db, err := OpenPebbleDB(PebbleDBConfig{Path: t.TempDir()})
+	require.NoError(t, err)
+	defer db.Close()
+	to, err := NewPebbleKeyspaceStore[testData](db, KeyspaceSessions, nil)
+	require.NoError(t, err)
+
+	copied, err := CopyKeys[testData](ctx, from, to)
+	require.NoError(t, err)
+	assert.Equal(t, 2, copied)
+
+	keys, err := to.List(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, keys)
+	count, err := from.Count(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+}
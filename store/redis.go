@@ -5,9 +5,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/axmq/ax/pkg/compress"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -19,6 +21,8 @@ type RedisStore[T any] struct {
 	ttl    time.Duration // Optional TTL for keys
 	prefix string
 	index  string // Set key for indexing all keys
+	expiry string // Sorted set key ordering keys by expiry time
+	codec  compress.Codec
 }
 
 // RedisStoreConfig configures the Redis store
@@ -29,6 +33,8 @@ type RedisStoreConfig struct {
 	Prefix   string        // Optional prefix for keys (e.g., "session:", "message:")
 	TTL      time.Duration // Optional: TTL for keys (0 = no TTL)
 	Options  *redis.Options
+	// Compression compresses stored values, it must stay the same for the lifetime of the data
+	Compression compress.Codec
 }
 
 // NewRedisStore creates a new Redis-based store
@@ -63,6 +69,8 @@ func NewRedisStore[T any](config RedisStoreConfig) (*RedisStore[T], error) {
 		ttl:    config.TTL,
 		prefix: prefix,
 		index:  prefix + "index",
+		expiry: prefix + "expiry",
+		codec:  config.Compression,
 	}, nil
 }
 
@@ -88,6 +96,11 @@ func (r *RedisStore[T]) Save(ctx context.Context, key string, value T) error {
 	if err != nil {
 		return fmt.Errorf("failed to marshal value: %w", err)
 	}
+	if r.codec != nil {
+		if data, err = r.codec.Encode(nil, data); err != nil {
+			return fmt.Errorf("failed to compress value: %w", err)
+		}
+	}
 
 	fullKey := r.makeKey(key)
 
@@ -134,8 +147,15 @@ func (r *RedisStore[T]) Load(ctx context.Context, key string) (T, error) {
 		return zero, fmt.Errorf("failed to load value: %w", err)
 	}
 
+	raw := []byte(data)
+	if r.codec != nil {
+		if raw, err = r.codec.Decode(nil, raw); err != nil {
+			return zero, fmt.Errorf("failed to decompress value: %w", err)
+		}
+	}
+
 	var value T
-	if err := json.Unmarshal([]byte(data), &value); err != nil {
+	if err := json.Unmarshal(raw, &value); err != nil {
 		return zero, fmt.Errorf("failed to unmarshal value: %w", err)
 	}
 
@@ -160,6 +180,7 @@ func (r *RedisStore[T]) Delete(ctx context.Context, key string) error {
 	pipe := r.client.Pipeline()
 	pipe.Del(ctx, fullKey)
 	pipe.SRem(ctx, r.index, key)
+	pipe.ZRem(ctx, r.expiry, key)
 
 	_, err := pipe.Exec(ctx)
 	if err != nil {
@@ -169,6 +190,71 @@ func (r *RedisStore[T]) Delete(ctx context.Context, key string) error {
 	return nil
 }
 
+// DeletePrefix removes every value whose key starts with prefix
+// Keys are found with SCAN and removed in pipelined batches, so large key spaces are never loaded at once
+func (r *RedisStore[T]) DeletePrefix(ctx context.Context, prefix string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if prefix == "" {
+		return ErrEmptyPrefix
+	}
+
+	r.mu.RLock()
+	if r.closed {
+		r.mu.RUnlock()
+		return ErrStoreClosed
+	}
+	r.mu.RUnlock()
+
+	match := escapeGlob(r.makeKey(prefix)) + "*"
+	var cursor uint64
+	for {
+		fullKeys, next, err := r.client.Scan(ctx, cursor, match, redisScanCount).Result()
+		if err != nil {
+			return fmt.Errorf("failed to scan keys: %w", err)
+		}
+
+		pipe := r.client.Pipeline()
+		for _, fullKey := range fullKeys {
+			if fullKey == r.index || fullKey == r.expiry {
+				continue
+			}
+			key := strings.TrimPrefix(fullKey, r.prefix)
+			pipe.Del(ctx, fullKey)
+			pipe.SRem(ctx, r.index, key)
+			pipe.ZRem(ctx, r.expiry, key)
+		}
+		if pipe.Len() > 0 {
+			if _, err := pipe.Exec(ctx); err != nil {
+				return fmt.Errorf("failed to delete values: %w", err)
+			}
+		}
+
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// redisScanCount is the COUNT hint passed to SCAN by DeletePrefix
+const redisScanCount = 1000
+
+// escapeGlob escapes the Redis glob metacharacters in s so it matches literally
+func escapeGlob(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
 // Exists checks if a key exists
 func (r *RedisStore[T]) Exists(ctx context.Context, key string) (bool, error) {
 	if ctx.Err() != nil {
@@ -212,6 +298,59 @@ func (r *RedisStore[T]) List(ctx context.Context) ([]string, error) {
 	return keys, nil
 }
 
+// SetExpiry indexes a key by its expiry time in a sorted set scored by Unix milliseconds
+func (r *RedisStore[T]) SetExpiry(ctx context.Context, key string, expiresAt time.Time) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	r.mu.RLock()
+	if r.closed {
+		r.mu.RUnlock()
+		return ErrStoreClosed
+	}
+	r.mu.RUnlock()
+
+	var err error
+	if expiresAt.IsZero() {
+		err = r.client.ZRem(ctx, r.expiry, key).Err()
+	} else {
+		err = r.client.ZAdd(ctx, r.expiry, redis.Z{Score: float64(expiresAt.UnixMilli()), Member: key}).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set expiry: %w", err)
+	}
+	return nil
+}
+
+// ExpiringBefore returns keys expiring before t, earliest first
+func (r *RedisStore[T]) ExpiringBefore(ctx context.Context, t time.Time, limit int) ([]string, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	r.mu.RLock()
+	if r.closed {
+		r.mu.RUnlock()
+		return nil, ErrStoreClosed
+	}
+	r.mu.RUnlock()
+
+	opt := &redis.ZRangeBy{
+		Min: "-inf",
+		Max: fmt.Sprintf("(%d", t.UnixMilli()),
+	}
+	if limit > 0 {
+		opt.Count = int64(limit)
+	}
+
+	keys, err := r.client.ZRangeByScore(ctx, r.expiry, opt).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query expiry index: %w", err)
+	}
+	return keys, nil
+}
+
 // Close closes the store
 func (r *RedisStore[T]) Close() error {
 	r.mu.Lock()
@@ -0,0 +1,45 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// KeyScanner is implemented by stores that keep keys ordered, so callers can page through the keys
+// under a prefix without listing every key
+type KeyScanner interface {
+	// ScanKeys returns up to limit keys starting with prefix that sort after the key after, in ascending order
+	// A limit of zero or less returns all of them
+	ScanKeys(ctx context.Context, prefix, after string, limit int) ([]string, error)
+}
+
+// ScanKeys pages through the keys of s in ascending order, stores without KeyScanner are listed and sorted
+func ScanKeys[T any](ctx context.Context, s Store[T], prefix, after string, limit int) ([]string, error) {
+	if scanner, ok := s.(KeyScanner); ok {
+		return scanner.ScanKeys(ctx, prefix, after, limit)
+	}
+	keys, err := s.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return scanSorted(keys, prefix, after, limit), nil
+}
+
+// scanSorted sorts keys in place and returns the page of keys under prefix following after
+func scanSorted(keys []string, prefix, after string, limit int) []string {
+	sort.Strings(keys)
+	start := sort.SearchStrings(keys, prefix)
+	if after >= prefix {
+		start = sort.Search(len(keys), func(i int) bool { return keys[i] > after })
+	}
+
+	page := make([]string, 0)
+	for _, key := range keys[start:] {
+		if !strings.HasPrefix(key, prefix) || (limit > 0 && len(page) == limit) {
+			break
+		}
+		page = append(page, key)
+	}
+	return page
+}
@@ -16,6 +16,9 @@ type Store[T any] interface {
 	// Delete removes a value by key
 	Delete(ctx context.Context, key string) error
 
+	// DeletePrefix removes every value whose key starts with prefix, an empty prefix returns ErrEmptyPrefix
+	DeletePrefix(ctx context.Context, prefix string) error
+
 	// Close closes the store
 	Close() error
 }
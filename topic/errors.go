@@ -0,0 +1,7 @@
+package topic
+
+import "errors"
+
+var (
+	ErrInvalidSnapshot = errors.New("invalid router snapshot")
+)
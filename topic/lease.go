@@ -0,0 +1,108 @@
+package topic
+
+import (
+	"context"
+	"time"
+)
+
+// setLeaseLocked starts the lease of a subscription with a TTL, or clears it for one without
+func (r *Router) setLeaseLocked(sub *Subscription, filter string, now time.Time) {
+	if sub.TTL <= 0 {
+		if clientLeases, ok := r.leases[sub.ClientID]; ok {
+			delete(clientLeases, filter)
+			if len(clientLeases) == 0 {
+				delete(r.leases, sub.ClientID)
+			}
+		}
+		return
+	}
+
+	if r.leases[sub.ClientID] == nil {
+		r.leases[sub.ClientID] = make(map[string]time.Time)
+	}
+	r.leases[sub.ClientID][filter] = now.Add(sub.TTL)
+}
+
+// Renew extends the leases of every subscription of a client, call it on client activity
+// such as a publish, a PINGREQ or a delivery to keep subscriptions of live clients in place
+func (r *Router) Renew(clientID string) {
+	r.mu.RLock()
+	_, leased := r.leases[clientID]
+	r.mu.RUnlock()
+	if !leased {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for filter := range r.leases[clientID] {
+		if sub, ok := r.subscriptions[clientID][filter]; ok {
+			r.setLeaseLocked(sub, filter, now)
+		}
+	}
+}
+
+// LeaseDeadline returns when a subscription lease runs out, ok is false for subscriptions without a lease
+func (r *Router) LeaseDeadline(clientID, filter string) (deadline time.Time, ok bool) {
+	filter = Normalize(filter, r.normalize)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	deadline, ok = r.leases[clientID][filter]
+	return deadline, ok
+}
+
+// ExpireLeases removes every subscription whose lease ran out before now and returns them
+func (r *Router) ExpireLeases(now time.Time) []*Subscription {
+	type expiredLease struct {
+		clientID string
+		filter   string
+		sub      *Subscription
+	}
+
+	r.mu.RLock()
+	var expired []expiredLease
+	for clientID, clientLeases := range r.leases {
+		for filter, deadline := range clientLeases {
+			if now.Before(deadline) {
+				continue
+			}
+			expired = append(expired, expiredLease{clientID: clientID, filter: filter, sub: r.subscriptions[clientID][filter]})
+		}
+	}
+	r.mu.RUnlock()
+
+	removed := make([]*Subscription, 0, len(expired))
+	for _, e := range expired {
+		// A renewal between the scan and the removal keeps the subscription
+		if deadline, ok := r.LeaseDeadline(e.clientID, e.filter); !ok || now.Before(deadline) {
+			continue
+		}
+		if r.Unsubscribe(e.clientID, e.filter) && e.sub != nil {
+			removed = append(removed, e.sub)
+		}
+	}
+	return removed
+}
+
+// RunLeaseExpiry removes expired subscriptions each interval until ctx is done,
+// onExpired, when set, is called for every removed subscription
+func (r *Router) RunLeaseExpiry(ctx context.Context, interval time.Duration, onExpired func(*Subscription)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, sub := range r.ExpireLeases(now) {
+				if onExpired != nil {
+					onExpired(sub)
+				}
+			}
+		}
+	}
+}
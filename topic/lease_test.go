@@ -0,0 +1,81 @@
+package topic
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouterLeases(t *testing.T) {
+	router := NewRouter()
+
+	require.NoError(t, router.Subscribe(&Subscription{ClientID: "ephemeral", TopicFilter: "a/#", TTL: time.Minute}))
+	require.NoError(t, router.Subscribe(&Subscription{ClientID: "ephemeral", TopicFilter: "$share/g/b/+", TTL: time.Minute}))
+	require.NoError(t, router.Subscribe(&Subscription{ClientID: "durable", TopicFilter: "a/#"}))
+
+	deadline, ok := router.LeaseDeadline("ephemeral", "a/#")
+	require.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, time.Second)
+	_, ok = router.LeaseDeadline("durable", "a/#")
+	assert.False(t, ok)
+
+	assert.Empty(t, router.ExpireLeases(time.Now()))
+
+	expired := router.ExpireLeases(time.Now().Add(2 * time.Minute))
+	require.Len(t, expired, 2)
+	assert.Equal(t, 1, router.Count())
+	assert.Equal(t, []SubscriberInfo{{ClientID: "durable", TopicFilter: "a/#"}}, router.Match("a/x"))
+	assert.Empty(t, router.Match("b/x"))
+
+	_, ok = router.LeaseDeadline("ephemeral", "a/#")
+	assert.False(t, ok)
+}
+
+func TestRouterLeases_Renew(t *testing.T) {
+	router := NewRouter()
+	require.NoError(t, router.Subscribe(&Subscription{ClientID: "c", TopicFilter: "a", TTL: 50 * time.Millisecond}))
+
+	before, _ := router.LeaseDeadline("c", "a")
+	time.Sleep(10 * time.Millisecond)
+	router.Renew("c")
+	after, _ := router.LeaseDeadline("c", "a")
+	assert.True(t, after.After(before))
+
+	// Resubscribing without a TTL drops the lease
+	require.NoError(t, router.Subscribe(&Subscription{ClientID: "c", TopicFilter: "a"}))
+	_, ok := router.LeaseDeadline("c", "a")
+	assert.False(t, ok)
+	assert.Empty(t, router.ExpireLeases(time.Now().Add(time.Hour)))
+
+	require.NoError(t, router.Subscribe(&Subscription{ClientID: "c", TopicFilter: "b", TTL: time.Minute}))
+	router.UnsubscribeAll("c")
+	_, ok = router.LeaseDeadline("c", "b")
+	assert.False(t, ok)
+}
+
+func TestRouterRunLeaseExpiry(t *testing.T) {
+	router := NewRouter()
+	require.NoError(t, router.Subscribe(&Subscription{ClientID: "c", TopicFilter: "a", TTL: 10 * time.Millisecond}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	expired := make(chan *Subscription, 1)
+	done := make(chan struct{})
+	go func() {
+		router.RunLeaseExpiry(ctx, 5*time.Millisecond, func(sub *Subscription) { expired <- sub })
+		close(done)
+	}()
+
+	select {
+	case sub := <-expired:
+		assert.Equal(t, "a", sub.TopicFilter)
+	case <-time.After(time.Second):
+		t.Fatal("subscription lease did not expire")
+	}
+	assert.Zero(t, router.Count())
+
+	cancel()
+	<-done
+}
@@ -0,0 +1,75 @@
+package topic
+
+// MatchFilter reports whether a topic name matches a topic filter, honouring
+// the '+' and '#' wildcards. Filters starting with a wildcard do not match
+// topics beginning with '$'
+func MatchFilter(filter, topic string) bool {
+	if len(topic) > 0 && topic[0] == '$' && len(filter) > 0 && (filter[0] == '+' || filter[0] == '#') {
+		return false
+	}
+
+	filterLevels := NewLevels(filter)
+	topicLevels := NewLevels(topic)
+
+	for {
+		level, ok := filterLevels.Next()
+		if !ok {
+			return topicLevels.Done()
+		}
+		if level == "#" {
+			return true
+		}
+		topicLevel, ok := topicLevels.Next()
+		if !ok {
+			return false
+		}
+		if level != "+" && level != topicLevel {
+			return false
+		}
+	}
+}
+
+// FiltersOverlap reports whether some topic name matches both filters, e.g. "a/+" and "+/b" overlap on "a/b"
+// Shared subscription prefixes are ignored, and a filter starting with a wildcard does not overlap a '$' topic
+func FiltersOverlap(a, b string) bool {
+	a, b = sharedTopicFilter(a), sharedTopicFilter(b)
+	if a == "" || b == "" {
+		return a == b
+	}
+	if (a[0] == '$' && (b[0] == '+' || b[0] == '#')) || (b[0] == '$' && (a[0] == '+' || a[0] == '#')) {
+		return false
+	}
+
+	aLevels, bLevels := NewLevels(a), NewLevels(b)
+	for {
+		aLevel, aOK := aLevels.Next()
+		bLevel, bOK := bLevels.Next()
+		switch {
+		case !aOK && !bOK:
+			return true
+		case !aOK:
+			// "a/#" also matches its parent "a"
+			return bLevel == "#" && bLevels.Done()
+		case !bOK:
+			return aLevel == "#" && aLevels.Done()
+		case aLevel == "#" || bLevel == "#":
+			return true
+		case aLevel != "+" && bLevel != "+" && aLevel != bLevel:
+			return false
+		}
+	}
+}
+
+// sharedTopicFilter strips the $share/group/ prefix of a shared subscription
+func sharedTopicFilter(filter string) string {
+	if !IsSharedSubscription(filter) {
+		return filter
+	}
+	rest := filter[len("$share/"):]
+	for i := 0; i < len(rest); i++ {
+		if rest[i] == '/' {
+			return rest[i+1:]
+		}
+	}
+	return ""
+}
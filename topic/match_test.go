@@ -0,0 +1,65 @@
+package topic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchFilter(t *testing.T) {
+	tests := []struct {
+		filter   string
+		topic    string
+		expected bool
+	}{
+		{"a/b/c", "a/b/c", true},
+		{"a/b/c", "a/b", false},
+		{"a/b", "a/b/c", false},
+		{"a/+/c", "a/b/c", true},
+		{"a/+/c", "a/b/d", false},
+		{"a/+", "a/", true},
+		{"+/+", "/a", true},
+		{"a/#", "a", true},
+		{"a/#", "a/b/c", true},
+		{"#", "a/b", true},
+		{"#", "$SYS/info", false},
+		{"+/info", "$SYS/info", false},
+		{"$SYS/#", "$SYS/info", true},
+		{"telemetry/#", "heartbeat/d1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter+" "+tt.topic, func(t *testing.T) {
+			assert.Equal(t, tt.expected, MatchFilter(tt.filter, tt.topic))
+		})
+	}
+}
+
+func TestFiltersOverlap(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/c", false},
+		{"a/+", "+/b", true},
+		{"telemetry/#", "#", true},
+		{"telemetry/#", "telemetry", true},
+		{"telemetry/#", "+/d1", true},
+		{"telemetry/#", "heartbeat/+", false},
+		{"a/+", "a/b/c", false},
+		{"a/+/#", "a", false},
+		{"#", "$SYS/info", false},
+		{"+/info", "$SYS/#", false},
+		{"$SYS/#", "$SYS/info", true},
+		{"$share/g/telemetry/+", "telemetry/d1", true},
+		{"$share/g/telemetry/+", "heartbeat/#", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+" "+tt.b, func(t *testing.T) {
+			assert.Equal(t, tt.expected, FiltersOverlap(tt.a, tt.b))
+			assert.Equal(t, tt.expected, FiltersOverlap(tt.b, tt.a))
+		})
+	}
+}
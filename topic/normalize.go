@@ -0,0 +1,96 @@
+package topic
+
+import (
+	"golang.org/x/text/unicode/norm"
+)
+
+// NormalizeOptions controls how topic names and filters are canonicalized
+// The zero value leaves topics untouched and accepts everything the MQTT specification allows
+type NormalizeOptions struct {
+	// RejectEmptyLevels rejects topics with empty levels such as "a//b" or "/a"
+	RejectEmptyLevels bool
+	// TrimTrailingSlash removes a trailing '/' so that "a/b/" and "a/b" are the same topic
+	TrimTrailingSlash bool
+	// UnicodeNFC applies Unicode NFC normalization so visually identical topics compare equal
+	UnicodeNFC bool
+}
+
+// DefaultNormalizeOptions returns spec-compliant options that do not rewrite topics
+func DefaultNormalizeOptions() NormalizeOptions {
+	return NormalizeOptions{}
+}
+
+// Normalize returns the canonical form of a topic name or filter
+// Shared subscription prefixes are preserved and only the inner filter is rewritten
+func Normalize(topic string, opts NormalizeOptions) string {
+	if IsSharedSubscription(topic) {
+		rest := topic[len("$share/"):]
+		for i := 0; i < len(rest); i++ {
+			if rest[i] == '/' {
+				return topic[:len("$share/")+i+1] + Normalize(rest[i+1:], opts)
+			}
+		}
+		return topic
+	}
+
+	if opts.UnicodeNFC && !norm.NFC.IsNormalString(topic) {
+		topic = norm.NFC.String(topic)
+	}
+
+	if opts.TrimTrailingSlash && len(topic) > 1 && topic[len(topic)-1] == '/' {
+		topic = topic[:len(topic)-1]
+	}
+
+	return topic
+}
+
+// NormalizeTopic canonicalizes and validates a topic name
+func NormalizeTopic(topic string, opts NormalizeOptions) (string, error) {
+	topic = Normalize(topic, opts)
+	if err := ValidateTopic(topic); err != nil {
+		return "", err
+	}
+	if err := validateLevels(topic, opts); err != nil {
+		return "", err
+	}
+	return topic, nil
+}
+
+// NormalizeFilter canonicalizes and validates a topic filter, including shared subscriptions
+func NormalizeFilter(filter string, opts NormalizeOptions) (string, error) {
+	filter = Normalize(filter, opts)
+
+	topicFilter := filter
+	if IsSharedSubscription(filter) {
+		var err error
+		if _, topicFilter, err = ValidateSharedSubscription(filter); err != nil {
+			return "", err
+		}
+	} else if err := ValidateTopicFilter(filter); err != nil {
+		return "", err
+	}
+
+	if err := validateLevels(topicFilter, opts); err != nil {
+		return "", err
+	}
+	return filter, nil
+}
+
+// MatchNormalized reports whether a topic matches a filter after both are normalized
+func MatchNormalized(filter, topic string, opts NormalizeOptions) bool {
+	return MatchFilter(Normalize(filter, opts), Normalize(topic, opts))
+}
+
+// validateLevels applies the strict level checks enabled by the options
+func validateLevels(topic string, opts NormalizeOptions) error {
+	if !opts.RejectEmptyLevels {
+		return nil
+	}
+	levels := NewLevels(topic)
+	for level, ok := levels.Next(); ok; level, ok = levels.Next() {
+		if len(level) == 0 {
+			return &ValidationError{"topic cannot contain empty levels"}
+		}
+	}
+	return nil
+}
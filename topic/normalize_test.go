@@ -0,0 +1,101 @@
+package topic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalize(t *testing.T) {
+	strict := NormalizeOptions{TrimTrailingSlash: true, UnicodeNFC: true}
+
+	tests := []struct {
+		name  string
+		topic string
+		opts  NormalizeOptions
+		want  string
+	}{
+		{name: "default keeps trailing slash", topic: "a/b/", opts: DefaultNormalizeOptions(), want: "a/b/"},
+		{name: "default keeps decomposed unicode", topic: "cafe\u0301", opts: DefaultNormalizeOptions(), want: "cafe\u0301"},
+		{name: "trim trailing slash", topic: "a/b/", opts: strict, want: "a/b"},
+		{name: "single slash kept", topic: "/", opts: strict, want: "/"},
+		{name: "leading slash kept", topic: "/a/b", opts: strict, want: "/a/b"},
+		{name: "unicode nfc", topic: "cafe\u0301/x", opts: strict, want: "caf\u00e9/x"},
+		{name: "shared prefix preserved", topic: "$share/g/a/b/", opts: strict, want: "$share/g/a/b"},
+		{name: "shared without filter", topic: "$share/g", opts: strict, want: "$share/g"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Normalize(tt.topic, tt.opts))
+		})
+	}
+}
+
+func TestNormalizeTopic(t *testing.T) {
+	tests := []struct {
+		name    string
+		topic   string
+		opts    NormalizeOptions
+		want    string
+		wantErr bool
+	}{
+		{name: "valid", topic: "a/b", want: "a/b"},
+		{name: "empty levels allowed by default", topic: "a//b", want: "a//b"},
+		{name: "empty levels rejected", topic: "a//b", opts: NormalizeOptions{RejectEmptyLevels: true}, wantErr: true},
+		{name: "leading slash rejected", topic: "/a", opts: NormalizeOptions{RejectEmptyLevels: true}, wantErr: true},
+		{name: "trailing slash trimmed before check", topic: "a/b/", opts: NormalizeOptions{RejectEmptyLevels: true, TrimTrailingSlash: true}, want: "a/b"},
+		{name: "wildcard rejected", topic: "a/+", wantErr: true},
+		{name: "empty rejected", topic: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeTopic(tt.topic, tt.opts)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNormalizeFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  string
+		opts    NormalizeOptions
+		want    string
+		wantErr bool
+	}{
+		{name: "valid", filter: "a/+/#", want: "a/+/#"},
+		{name: "shared", filter: "$share/g/a/#", want: "$share/g/a/#"},
+		{name: "shared trimmed", filter: "$share/g/a/b/", opts: NormalizeOptions{TrimTrailingSlash: true}, want: "$share/g/a/b"},
+		{name: "shared empty level rejected", filter: "$share/g/a//b", opts: NormalizeOptions{RejectEmptyLevels: true}, wantErr: true},
+		{name: "invalid wildcard", filter: "a/b#", wantErr: true},
+		{name: "invalid shared", filter: "$share/g", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeFilter(tt.filter, tt.opts)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMatchNormalized(t *testing.T) {
+	opts := NormalizeOptions{TrimTrailingSlash: true, UnicodeNFC: true}
+
+	assert.True(t, MatchNormalized("a/b/", "a/b", opts))
+	assert.True(t, MatchNormalized("caf\u00e9/+", "cafe\u0301/x", opts))
+	assert.False(t, MatchNormalized("a/b/", "a/b", DefaultNormalizeOptions()))
+}
@@ -0,0 +1,110 @@
+package topic
+
+import (
+	"context"
+	"time"
+)
+
+// RebalanceAction is the change a rebalancing hint suggests for a shared subscription group
+type RebalanceAction byte
+
+const (
+	// RebalanceScaleUp suggests adding consumers because members lag behind
+	RebalanceScaleUp RebalanceAction = iota + 1
+	// RebalanceScaleDown suggests removing consumers because the group is mostly idle
+	RebalanceScaleDown
+	// RebalanceRedistribute suggests replacing or inspecting a member that lags far behind the others
+	RebalanceRedistribute
+)
+
+// String returns the string representation of the action
+func (a RebalanceAction) String() string {
+	switch a {
+	case RebalanceScaleUp:
+		return "scale_up"
+	case RebalanceScaleDown:
+		return "scale_down"
+	case RebalanceRedistribute:
+		return "redistribute"
+	default:
+		return "unknown"
+	}
+}
+
+// RebalanceHint is passed to the rebalancing callback for a group that needs attention
+type RebalanceHint struct {
+	Action RebalanceAction
+	Stats  SharedGroupStats
+}
+
+// RebalancePolicy decides which shared subscription groups get a rebalancing hint, a zero threshold disables its check
+type RebalancePolicy struct {
+	// ScaleUpPending hints a scale up once members average this many pending deliveries
+	ScaleUpPending float64
+	// ScaleDownPending hints a scale down while members average fewer pending deliveries
+	ScaleDownPending float64
+	// MinMembers keeps groups of this size or smaller from getting scale down hints
+	MinMembers int
+	// MaxSkew hints redistribution once the most lagging member holds this multiple of the mean pending deliveries
+	MaxSkew float64
+}
+
+// DefaultRebalancePolicy returns a policy that only reports lagging groups
+func DefaultRebalancePolicy() RebalancePolicy {
+	return RebalancePolicy{
+		ScaleUpPending: 1000,
+		MinMembers:     1,
+		MaxSkew:        4,
+	}
+}
+
+// Evaluate returns the action suggested for a group, ok is false when the group needs none
+// Lag takes precedence over skew, which takes precedence over idleness
+func (p RebalancePolicy) Evaluate(stats SharedGroupStats) (action RebalanceAction, ok bool) {
+	members := len(stats.Members)
+	if members == 0 {
+		return 0, false
+	}
+
+	mean := stats.MeanPending()
+	switch {
+	case p.ScaleUpPending > 0 && mean >= p.ScaleUpPending:
+		return RebalanceScaleUp, true
+	case p.MaxSkew > 0 && members > 1 && stats.PendingSkew >= p.MaxSkew:
+		return RebalanceRedistribute, true
+	case p.ScaleDownPending > 0 && mean < p.ScaleDownPending && members > max(p.MinMembers, 1):
+		return RebalanceScaleDown, true
+	}
+	return 0, false
+}
+
+// CheckRebalance evaluates every shared subscription group against the policy, calls onHint for each
+// group that needs attention and returns the number of hints
+func (r *Router) CheckRebalance(policy RebalancePolicy, onHint func(RebalanceHint)) int {
+	hints := 0
+	for _, stats := range r.SharedGroupStats() {
+		if action, ok := policy.Evaluate(stats); ok {
+			hints++
+			if onHint != nil {
+				onHint(RebalanceHint{Action: action, Stats: stats})
+			}
+		}
+	}
+	return hints
+}
+
+// RunRebalance checks the shared subscription groups each interval until ctx is done
+// It lets orchestration layers scale consumer groups based on the measured lag and skew
+func (r *Router) RunRebalance(ctx context.Context, interval time.Duration, policy RebalancePolicy, onHint func(RebalanceHint)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.CheckRebalance(policy, onHint)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package topic
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func groupStats(pending ...uint64) SharedGroupStats {
+	stats := SharedGroupStats{Group: "g", Filter: "t"}
+	var peak uint64
+	for _, p := range pending {
+		stats.Members = append(stats.Members, SharedMemberStats{Pending: p})
+		stats.Pending += p
+		peak = max(peak, p)
+	}
+	stats.PendingSkew = skew(peak, stats.Pending, len(pending))
+	return stats
+}
+
+func TestRebalancePolicyEvaluate(t *testing.T) {
+	policy := RebalancePolicy{ScaleUpPending: 100, ScaleDownPending: 1, MinMembers: 2, MaxSkew: 3}
+
+	tests := []struct {
+		name   string
+		stats  SharedGroupStats
+		action RebalanceAction
+		ok     bool
+	}{
+		{"empty group", groupStats(), 0, false},
+		{"lagging", groupStats(150, 120), RebalanceScaleUp, true},
+		{"skewed", groupStats(60, 0, 0, 0), RebalanceRedistribute, true},
+		{"idle", groupStats(0, 0, 0), RebalanceScaleDown, true},
+		{"idle at minimum", groupStats(0, 0), 0, false},
+		{"balanced", groupStats(10, 12, 9), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action, ok := policy.Evaluate(tt.stats)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.action, action)
+		})
+	}
+
+	_, ok := RebalancePolicy{}.Evaluate(groupStats(1000, 0))
+	assert.False(t, ok)
+	assert.Equal(t, "redistribute", RebalanceRedistribute.String())
+}
+
+func TestRouterCheckRebalance(t *testing.T) {
+	r := NewRouter()
+	subscribeShared(t, r, "$share/workers/jobs/#", "w1", "w2")
+	subscribeShared(t, r, "$share/idle/other/#", "i1")
+
+	for range 10 {
+		r.Match("jobs/a")
+	}
+
+	var hints []RebalanceHint
+	n := r.CheckRebalance(RebalancePolicy{ScaleUpPending: 5}, func(h RebalanceHint) {
+		hints = append(hints, h)
+	})
+	assert.Equal(t, 1, n)
+	require.Len(t, hints, 1)
+	assert.Equal(t, RebalanceScaleUp, hints[0].Action)
+	assert.Equal(t, "workers", hints[0].Stats.Group)
+}
+
+func TestRouterRunRebalance(t *testing.T) {
+	r := NewRouter()
+	subscribeShared(t, r, "$share/workers/jobs", "w1")
+	r.Match("jobs")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	hints := make(chan RebalanceHint, 8)
+	done := make(chan struct{})
+	go func() {
+		r.RunRebalance(ctx, 5*time.Millisecond, RebalancePolicy{ScaleUpPending: 1}, func(h RebalanceHint) {
+			select {
+			case hints <- h:
+			default:
+			}
+		})
+		close(done)
+	}()
+
+	select {
+	case h := <-hints:
+		assert.Equal(t, RebalanceScaleUp, h.Action)
+	case <-time.After(time.Second):
+		t.Fatal("no rebalance hint")
+	}
+	cancel()
+	<-done
+}
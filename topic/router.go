@@ -1,86 +1,122 @@
 package topic
 
-import "sync"
+import (
+	"sync"
+	"time"
+)
 
 // Router manages topic subscriptions and routes messages to subscribers
 type Router struct {
 	trie          *Trie
 	subscriptions map[string]map[string]*Subscription // clientID -> filter -> Subscription
+	leases        map[string]map[string]time.Time     // clientID -> filter -> lease deadline
+	normalize     NormalizeOptions
 	mu            sync.RWMutex
 }
 
 // NewRouter creates a new topic router
 func NewRouter() *Router {
+	return NewRouterWithOptions(DefaultNormalizeOptions())
+}
+
+// NewRouterWithOptions creates a new topic router that normalizes filters and topics with the given options
+func NewRouterWithOptions(opts NormalizeOptions) *Router {
 	return &Router{
 		trie:          NewTrie(),
 		subscriptions: make(map[string]map[string]*Subscription),
+		leases:        make(map[string]map[string]time.Time),
+		normalize:     opts,
 	}
 }
 
 // Subscribe adds a subscription to the router
 func (r *Router) Subscribe(sub *Subscription) error {
+	filter, err := NormalizeFilter(sub.TopicFilter, r.normalize)
+	if err != nil {
+		return err
+	}
+
 	// Check if this is a shared subscription
-	if IsSharedSubscription(sub.TopicFilter) {
-		groupName, topicFilter, err := ValidateSharedSubscription(sub.TopicFilter)
+	if IsSharedSubscription(filter) {
+		groupName, topicFilter, err := ValidateSharedSubscription(filter)
 		if err != nil {
 			return err
 		}
 
 		subInfo := SubscriberInfo{
 			ClientID:               sub.ClientID,
+			TopicFilter:            sub.TopicFilter,
 			QoS:                    sub.QoS,
 			NoLocal:                sub.NoLocal,
 			RetainAsPublished:      sub.RetainAsPublished,
 			RetainHandling:         sub.RetainHandling,
 			SubscriptionIdentifier: sub.SubscriptionIdentifier,
+			LastValue:              sub.LastValue,
 		}
 
 		if err := r.trie.SubscribeShared(groupName, topicFilter, subInfo); err != nil {
 			return err
 		}
 
-		// Store subscription metadata
-		r.mu.Lock()
-		if r.subscriptions[sub.ClientID] == nil {
-			r.subscriptions[sub.ClientID] = make(map[string]*Subscription)
-		}
-		r.subscriptions[sub.ClientID][sub.TopicFilter] = sub
-		r.mu.Unlock()
-
+		r.track(sub, filter)
 		return nil
 	}
 
 	// Regular subscription
-	if err := ValidateTopicFilter(sub.TopicFilter); err != nil {
-		return err
-	}
-
 	subInfo := SubscriberInfo{
 		ClientID:               sub.ClientID,
+		TopicFilter:            sub.TopicFilter,
 		QoS:                    sub.QoS,
 		NoLocal:                sub.NoLocal,
 		RetainAsPublished:      sub.RetainAsPublished,
 		RetainHandling:         sub.RetainHandling,
 		SubscriptionIdentifier: sub.SubscriptionIdentifier,
+		LastValue:              sub.LastValue,
 	}
 
-	if err := r.trie.Subscribe(sub.TopicFilter, subInfo); err != nil {
+	if err := r.trie.Subscribe(filter, subInfo); err != nil {
 		return err
 	}
 
-	// Store subscription metadata
+	r.track(sub, filter)
+	return nil
+}
+
+// track stores subscription metadata and starts or clears its lease
+func (r *Router) track(sub *Subscription, filter string) {
 	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	if r.subscriptions[sub.ClientID] == nil {
 		r.subscriptions[sub.ClientID] = make(map[string]*Subscription)
 	}
-	r.subscriptions[sub.ClientID][sub.TopicFilter] = sub
-	r.mu.Unlock()
+	r.subscriptions[sub.ClientID][filter] = sub
+	r.setLeaseLocked(sub, filter, time.Now())
+}
 
-	return nil
+// untrack removes subscription metadata and its lease
+func (r *Router) untrack(clientID, filter string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if clientSubs, ok := r.subscriptions[clientID]; ok {
+		delete(clientSubs, filter)
+		if len(clientSubs) == 0 {
+			delete(r.subscriptions, clientID)
+		}
+	}
+	if clientLeases, ok := r.leases[clientID]; ok {
+		delete(clientLeases, filter)
+		if len(clientLeases) == 0 {
+			delete(r.leases, clientID)
+		}
+	}
 }
 
 // Unsubscribe removes a subscription from the router
 func (r *Router) Unsubscribe(clientID, filter string) bool {
+	filter = Normalize(filter, r.normalize)
+
 	// Check if this is a shared subscription
 	if IsSharedSubscription(filter) {
 		groupName, topicFilter, err := ValidateSharedSubscription(filter)
@@ -89,67 +125,61 @@ func (r *Router) Unsubscribe(clientID, filter string) bool {
 		}
 
 		found := r.trie.UnsubscribeShared(groupName, topicFilter, clientID)
-
-		r.mu.Lock()
-		if clientSubs, ok := r.subscriptions[clientID]; ok {
-			delete(clientSubs, filter)
-			if len(clientSubs) == 0 {
-				delete(r.subscriptions, clientID)
-			}
-		}
-		r.mu.Unlock()
-
+		r.untrack(clientID, filter)
 		return found
 	}
 
 	// Regular unsubscribe
 	found := r.trie.Unsubscribe(filter, clientID)
-
-	r.mu.Lock()
-	if clientSubs, ok := r.subscriptions[clientID]; ok {
-		delete(clientSubs, filter)
-		if len(clientSubs) == 0 {
-			delete(r.subscriptions, clientID)
-		}
-	}
-	r.mu.Unlock()
-
+	r.untrack(clientID, filter)
 	return found
 }
 
 // UnsubscribeAll removes all subscriptions for a client
+// It visits only the client's own subscriptions rather than walking the whole trie
 func (r *Router) UnsubscribeAll(clientID string) int {
 	r.mu.Lock()
-	clientSubs, ok := r.subscriptions[clientID]
-	if !ok {
-		r.mu.Unlock()
-		return 0
-	}
-
-	filters := make([]string, 0, len(clientSubs))
-	for filter := range clientSubs {
-		filters = append(filters, filter)
-	}
+	delete(r.subscriptions, clientID)
+	delete(r.leases, clientID)
 	r.mu.Unlock()
 
-	count := 0
-	for _, filter := range filters {
-		if r.Unsubscribe(clientID, filter) {
-			count++
+	return r.trie.UnsubscribeClient(clientID)
+}
+
+// CountFilter returns the number of subscriptions on exactly the given filter
+// For a shared subscription filter only the members of that group are counted
+func (r *Router) CountFilter(filter string) int {
+	filter = Normalize(filter, r.normalize)
+
+	if IsSharedSubscription(filter) {
+		groupName, topicFilter, err := ValidateSharedSubscription(filter)
+		if err != nil {
+			return 0
 		}
+		return r.trie.CountSharedFilter(groupName, topicFilter)
 	}
+	return r.trie.CountFilter(filter)
+}
+
+// CountMatching returns the number of subscribers a message published to the topic would reach
+func (r *Router) CountMatching(topic string) int {
+	return r.trie.CountMatching(Normalize(topic, r.normalize))
+}
 
-	return count
+// HasSubscribers reports whether a message published to the topic would reach any subscription
+// It is the cheap pre-check for dropping unrouted publishes; No Local subscriptions of the publisher still count
+func (r *Router) HasSubscribers(topic string) bool {
+	return r.trie.HasMatch(Normalize(topic, r.normalize))
 }
 
 // Match finds all subscribers for a topic
 func (r *Router) Match(topic string) []SubscriberInfo {
-	return r.trie.Match(topic)
+	return r.trie.Match(Normalize(topic, r.normalize))
 }
 
 // MatchWithPublisher finds all subscribers for a topic, excluding the publisher if NoLocal is set
 func (r *Router) MatchWithPublisher(topic, publisherClientID string) []SubscriberInfo {
-	allSubs := r.trie.Match(topic)
+	allSubs := r.trie.Match(Normalize(topic, r.normalize))
 	if publisherClientID == "" {
 		return allSubs
 	}
@@ -168,6 +198,8 @@ func (r *Router) MatchWithPublisher(topic, publisherClientID string) []Subscribe
 
 // GetSubscription retrieves a specific subscription
 func (r *Router) GetSubscription(clientID, filter string) (*Subscription, bool) {
+	filter = Normalize(filter, r.normalize)
+
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
@@ -195,6 +227,18 @@ func (r *Router) GetClientSubscriptions(clientID string) []*Subscription {
 	return result
 }
 
+// Clients returns the IDs of the clients with subscriptions
+func (r *Router) Clients() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	clients := make([]string, 0, len(r.subscriptions))
+	for clientID := range r.subscriptions {
+		clients = append(clients, clientID)
+	}
+	return clients
+}
+
 // Count returns the total number of subscriptions
 func (r *Router) Count() int {
 	return r.trie.Count()
@@ -211,6 +255,7 @@ func (r *Router) CountClients() int {
 func (r *Router) Clear() {
 	r.mu.Lock()
 	r.subscriptions = make(map[string]map[string]*Subscription)
+	r.leases = make(map[string]map[string]time.Time)
 	r.mu.Unlock()
 	r.trie.Clear()
 }
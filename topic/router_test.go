@@ -444,6 +444,7 @@ func TestRouterCountClients(t *testing.T) {
 
 		router.Subscribe(&Subscription{ClientID: "client2", TopicFilter: "home/pressure", QoS: 1})
 		assert.Equal(t, 2, router.CountClients())
+		assert.ElementsMatch(t, []string{"client1", "client2"}, router.Clients())
 	})
 
 	t.Run("unsubscribe all removes client", func(t *testing.T) {
@@ -619,3 +620,45 @@ func BenchmarkRouterComplexMatching(b *testing.B) {
 		router.Match("home/room50/temperature")
 	}
 }
+
+func TestRouterNormalizeOptions(t *testing.T) {
+	r := NewRouterWithOptions(NormalizeOptions{TrimTrailingSlash: true, UnicodeNFC: true})
+
+	require.NoError(t, r.Subscribe(&Subscription{ClientID: "c1", TopicFilter: "sensors/cafe\u0301/"}))
+	require.NoError(t, r.Subscribe(&Subscription{ClientID: "c2", TopicFilter: "$share/g/sensors/+/"}))
+
+	assert.Len(t, r.Match("sensors/caf\u00e9"), 2)
+	assert.Len(t, r.Match("sensors/cafe\u0301/"), 2)
+
+	_, ok := r.GetSubscription("c1", "sensors/caf\u00e9")
+	assert.True(t, ok)
+
+	assert.True(t, r.Unsubscribe("c1", "sensors/caf\u00e9/"))
+	assert.True(t, r.Unsubscribe("c2", "$share/g/sensors/+"))
+	assert.Equal(t, 0, r.Count())
+
+	strict := NewRouterWithOptions(NormalizeOptions{RejectEmptyLevels: true})
+	assert.Error(t, strict.Subscribe(&Subscription{ClientID: "c1", TopicFilter: "a//b"}))
+}
+
+func TestRouterCountFilter(t *testing.T) {
+	router := NewRouter()
+	require.NoError(t, router.Subscribe(&Subscription{ClientID: "c1", TopicFilter: "home/+/temp"}))
+	require.NoError(t, router.Subscribe(&Subscription{ClientID: "c2", TopicFilter: "home/+/temp"}))
+	require.NoError(t, router.Subscribe(&Subscription{ClientID: "c3", TopicFilter: "$share/g/home/+/temp"}))
+	require.NoError(t, router.Subscribe(&Subscription{ClientID: "c4", TopicFilter: "home/#"}))
+
+	assert.Equal(t, 3, router.CountFilter("home/+/temp"))
+	assert.Equal(t, 1, router.CountFilter("$share/g/home/+/temp"))
+	assert.Equal(t, 0, router.CountFilter("$share/other/home/+/temp"))
+	assert.Equal(t, 0, router.CountFilter("$share/g"))
+
+	assert.Equal(t, 4, router.CountMatching("home/kitchen/temp"))
+	assert.Equal(t, 1, router.CountMatching("home/kitchen"))
+	assert.Equal(t, 0, router.CountMatching("office"))
+	assert.True(t, router.HasSubscribers("home/kitchen"))
+	assert.False(t, router.HasSubscribers("office"))
+
+	assert.Equal(t, 1, router.UnsubscribeAll("c4"))
+	assert.Equal(t, 3, router.CountMatching("home/kitchen/temp"))
+}
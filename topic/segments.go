@@ -0,0 +1,60 @@
+package topic
+
+// Levels iterates over the '/' separated levels of a topic name or filter without allocating
+// It is a small value type, copying it saves the position so recursive matchers can branch cheaply
+// An empty topic has no levels, a trailing '/' yields a final empty level as splitting would
+type Levels struct {
+	topic string
+	pos   int
+}
+
+// NewLevels returns an iterator positioned before the first level of topic
+func NewLevels(topic string) Levels {
+	if len(topic) == 0 {
+		return Levels{pos: 1}
+	}
+	return Levels{topic: topic}
+}
+
+// Next returns the next level, ok is false once every level was returned
+func (l *Levels) Next() (level string, ok bool) {
+	if l.pos > len(l.topic) {
+		return "", false
+	}
+	rest := l.topic[l.pos:]
+	for i := 0; i < len(rest); i++ {
+		if rest[i] == '/' {
+			l.pos += i + 1
+			return rest[:i], true
+		}
+	}
+	l.pos = len(l.topic) + 1
+	return rest, true
+}
+
+// Done reports whether every level was returned
+func (l *Levels) Done() bool {
+	return l.pos > len(l.topic)
+}
+
+// Remaining returns the unread part of the topic, starting with the next level
+func (l *Levels) Remaining() string {
+	if l.pos > len(l.topic) {
+		return ""
+	}
+	return l.topic[l.pos:]
+}
+
+// CountLevels returns the number of levels in topic
+func CountLevels(topic string) int {
+	if len(topic) == 0 {
+		return 0
+	}
+	n := 1
+	for i := 0; i < len(topic); i++ {
+		if topic[i] == '/' {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,134 @@
+package topic
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// naiveLevels is the strings.Split reference the iterator must agree with
+func naiveLevels(topic string) []string {
+	if topic == "" {
+		return []string{}
+	}
+	return strings.Split(topic, "/")
+}
+
+// naiveMatchFilter is the straightforward split based matcher MatchFilter replaced
+func naiveMatchFilter(filter, topic string) bool {
+	if len(topic) > 0 && topic[0] == '$' && len(filter) > 0 && (filter[0] == '+' || filter[0] == '#') {
+		return false
+	}
+	filterLevels := naiveLevels(filter)
+	topicLevels := naiveLevels(topic)
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
+
+func collectLevels(topic string) []string {
+	levels := NewLevels(topic)
+	result := []string{}
+	for level, ok := levels.Next(); ok; level, ok = levels.Next() {
+		result = append(result, level)
+	}
+	return result
+}
+
+func TestLevels(t *testing.T) {
+	tests := []struct {
+		topic string
+		want  []string
+	}{
+		{"", []string{}},
+		{"a", []string{"a"}},
+		{"a/b/c", []string{"a", "b", "c"}},
+		{"/", []string{"", ""}},
+		{"a/", []string{"a", ""}},
+		{"/a", []string{"", "a"}},
+		{"a//b", []string{"a", "", "b"}},
+		{"$SYS/broker/+/#", []string{"$SYS", "broker", "+", "#"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.topic, func(t *testing.T) {
+			assert.Equal(t, tt.want, collectLevels(tt.topic))
+			assert.Equal(t, len(tt.want), CountLevels(tt.topic))
+		})
+	}
+}
+
+func TestLevelsRemainingAndDone(t *testing.T) {
+	levels := NewLevels("a/b/c")
+	assert.False(t, levels.Done())
+	assert.Equal(t, "a/b/c", levels.Remaining())
+
+	level, ok := levels.Next()
+	require.True(t, ok)
+	assert.Equal(t, "a", level)
+	assert.Equal(t, "b/c", levels.Remaining())
+
+	branch := levels
+	levels.Next()
+	levels.Next()
+	assert.True(t, levels.Done())
+	assert.Empty(t, levels.Remaining())
+
+	level, ok = branch.Next()
+	require.True(t, ok)
+	assert.Equal(t, "b", level)
+
+	empty := NewLevels("")
+	assert.True(t, empty.Done())
+	_, ok = empty.Next()
+	assert.False(t, ok)
+}
+
+func TestMatchFilterDoesNotAllocate(t *testing.T) {
+	allocs := testing.AllocsPerRun(100, func() {
+		MatchFilter("sensors/+/temperature/#", "sensors/room-1/temperature/celsius")
+	})
+	assert.Zero(t, allocs)
+}
+
+func FuzzLevels(f *testing.F) {
+	for _, seed := range []string{"", "/", "a", "a/b", "a//b/", "/a/b", "$SYS/#", "+/+/+"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, topic string) {
+		assert.Equal(t, naiveLevels(topic), collectLevels(topic))
+		assert.Equal(t, len(naiveLevels(topic)), CountLevels(topic))
+	})
+}
+
+func FuzzMatchFilter(f *testing.F) {
+	seeds := [][2]string{
+		{"#", "a/b"},
+		{"a/#", "a"},
+		{"a/+", "a/b"},
+		{"a/+/c", "a//c"},
+		{"+", "$SYS"},
+		{"$SYS/#", "$SYS/load"},
+		{"a/b", "a/b/"},
+		{"", ""},
+	}
+	for _, seed := range seeds {
+		f.Add(seed[0], seed[1])
+	}
+
+	f.Fuzz(func(t *testing.T, filter, topic string) {
+		assert.Equal(t, naiveMatchFilter(filter, topic), MatchFilter(filter, topic))
+	})
+}
@@ -0,0 +1,189 @@
+package topic
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// sharedMemberStats counts the deliveries a shared group routed to one member
+type sharedMemberStats struct {
+	delivered    atomic.Uint64
+	acknowledged atomic.Uint64
+	lastDelivery atomic.Int64
+}
+
+func (s *sharedMemberStats) record() {
+	s.delivered.Add(1)
+	s.lastDelivery.Store(time.Now().UnixNano())
+}
+
+// acknowledge counts one completed delivery, acknowledgements never exceed deliveries
+func (s *sharedMemberStats) acknowledge() {
+	for {
+		acked := s.acknowledged.Load()
+		if acked >= s.delivered.Load() || s.acknowledged.CompareAndSwap(acked, acked+1) {
+			return
+		}
+	}
+}
+
+// SharedMemberStats holds the delivery counters of one member of a shared subscription group
+type SharedMemberStats struct {
+	ClientID     string
+	Delivered    uint64
+	Acknowledged uint64
+	// Pending is the number of deliveries not acknowledged yet, the lag indicator of the member
+	Pending      uint64
+	LastDelivery time.Time
+}
+
+// SharedGroupStats holds the delivery distribution of a shared subscription group
+type SharedGroupStats struct {
+	Group     string
+	Filter    string
+	Members   []SharedMemberStats
+	Delivered uint64
+	Pending   uint64
+	// DeliverySkew is the delivery count of the busiest member relative to the mean, 1 is an even split
+	DeliverySkew float64
+	// PendingSkew is the pending count of the most lagging member relative to the mean, 0 when nothing is pending
+	PendingSkew float64
+}
+
+// MeanPending returns the average number of pending deliveries per member
+func (s *SharedGroupStats) MeanPending() float64 {
+	if len(s.Members) == 0 {
+		return 0
+	}
+	return float64(s.Pending) / float64(len(s.Members))
+}
+
+// Acknowledge records that a member completed a delivery, e.g. on PUBACK or PUBCOMP
+// It reports whether the client is a member of the group
+func (g *SharedSubscriptionGroup) Acknowledge(clientID string) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	for i, sub := range g.subscribers {
+		if sub.ClientID == clientID {
+			g.members[i].acknowledge()
+			return true
+		}
+	}
+	return false
+}
+
+// Stats returns the delivery counters of the group, Filter is left empty
+func (g *SharedSubscriptionGroup) Stats() SharedGroupStats {
+	g.mu.RLock()
+	stats := SharedGroupStats{
+		Group:   g.groupName,
+		Members: make([]SharedMemberStats, len(g.subscribers)),
+	}
+	for i, sub := range g.subscribers {
+		m := g.members[i]
+		acked := m.acknowledged.Load()
+		delivered := m.delivered.Load()
+		member := SharedMemberStats{
+			ClientID:     sub.ClientID,
+			Delivered:    delivered,
+			Acknowledged: acked,
+			Pending:      delivered - min(acked, delivered),
+		}
+		if last := m.lastDelivery.Load(); last != 0 {
+			member.LastDelivery = time.Unix(0, last)
+		}
+		stats.Members[i] = member
+	}
+	g.mu.RUnlock()
+
+	var maxDelivered, maxPending uint64
+	for _, m := range stats.Members {
+		stats.Delivered += m.Delivered
+		stats.Pending += m.Pending
+		maxDelivered = max(maxDelivered, m.Delivered)
+		maxPending = max(maxPending, m.Pending)
+	}
+	stats.DeliverySkew = skew(maxDelivered, stats.Delivered, len(stats.Members))
+	stats.PendingSkew = skew(maxPending, stats.Pending, len(stats.Members))
+	return stats
+}
+
+// skew returns peak relative to the mean of total over n values
+func skew(peak, total uint64, n int) float64 {
+	if total == 0 || n == 0 {
+		return 0
+	}
+	return float64(peak) * float64(n) / float64(total)
+}
+
+// SharedGroupStats returns the statistics of every shared subscription group
+func (t *Trie) SharedGroupStats() []SharedGroupStats {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var stats []SharedGroupStats
+	t.sharedStatsRecursive(t.root, "", true, &stats)
+	return stats
+}
+
+func (t *Trie) sharedStatsRecursive(node *trieNode, filter string, root bool, stats *[]SharedGroupStats) {
+	node.mu.RLock()
+	defer node.mu.RUnlock()
+
+	if !root {
+		for _, group := range node.sharedGroups {
+			s := group.Stats()
+			s.Filter = filter
+			*stats = append(*stats, s)
+		}
+	}
+
+	for level, child := range node.children {
+		childFilter := level
+		if !root {
+			childFilter = filter + "/" + level
+		}
+		t.sharedStatsRecursive(child, childFilter, false, stats)
+	}
+}
+
+// AcknowledgeShared records a completed delivery for a member of a shared subscription group
+func (t *Trie) AcknowledgeShared(groupName, filter, clientID string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	node := t.findNode(filter)
+	if node == nil {
+		return false
+	}
+
+	node.mu.RLock()
+	defer node.mu.RUnlock()
+	if group, ok := node.sharedGroups[groupName]; ok {
+		return group.Acknowledge(clientID)
+	}
+	return false
+}
+
+// SharedGroupStats returns the statistics of every shared subscription group ordered by filter and group
+func (r *Router) SharedGroupStats() []SharedGroupStats {
+	stats := r.trie.SharedGroupStats()
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Filter != stats[j].Filter {
+			return stats[i].Filter < stats[j].Filter
+		}
+		return stats[i].Group < stats[j].Group
+	})
+	return stats
+}
+
+// AcknowledgeShared records that a client completed a delivery it received through the shared
+// subscription filter ($share/group/filter), so the pending counters reflect consumer lag
+func (r *Router) AcknowledgeShared(filter, clientID string) bool {
+	groupName, topicFilter, err := ValidateSharedSubscription(Normalize(filter, r.normalize))
+	if err != nil {
+		return false
+	}
+	return r.trie.AcknowledgeShared(groupName, topicFilter, clientID)
+}
@@ -0,0 +1,82 @@
+package topic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func subscribeShared(t *testing.T, r *Router, filter string, clientIDs ...string) {
+	for _, id := range clientIDs {
+		require.NoError(t, r.Subscribe(&Subscription{ClientID: id, TopicFilter: filter, QoS: 1}))
+	}
+}
+
+func TestRouterSharedGroupStats(t *testing.T) {
+	r := NewRouter()
+	subscribeShared(t, r, "$share/workers/jobs/+", "w1", "w2")
+	subscribeShared(t, r, "$share/audit/jobs/+", "a1")
+	require.NoError(t, r.Subscribe(&Subscription{ClientID: "plain", TopicFilter: "jobs/+"}))
+
+	for range 4 {
+		r.Match("jobs/build")
+	}
+	assert.True(t, r.AcknowledgeShared("$share/workers/jobs/+", "w1"))
+	assert.False(t, r.AcknowledgeShared("$share/workers/jobs/+", "unknown"))
+	assert.False(t, r.AcknowledgeShared("jobs/+", "w1"))
+
+	stats := r.SharedGroupStats()
+	require.Len(t, stats, 2)
+	assert.Equal(t, "audit", stats[0].Group)
+	assert.Equal(t, "jobs/+", stats[0].Filter)
+	assert.Equal(t, uint64(4), stats[0].Delivered)
+
+	workers := stats[1]
+	assert.Equal(t, "workers", workers.Group)
+	assert.Equal(t, "jobs/+", workers.Filter)
+	assert.Equal(t, uint64(4), workers.Delivered)
+	assert.Equal(t, uint64(3), workers.Pending)
+	assert.InDelta(t, 1.0, workers.DeliverySkew, 0.001)
+	require.Len(t, workers.Members, 2)
+
+	byID := map[string]SharedMemberStats{}
+	for _, m := range workers.Members {
+		byID[m.ClientID] = m
+	}
+	assert.Equal(t, SharedMemberStats{ClientID: "w1", Delivered: 2, Acknowledged: 1, Pending: 1, LastDelivery: byID["w1"].LastDelivery}, byID["w1"])
+	assert.False(t, byID["w1"].LastDelivery.IsZero())
+	assert.Equal(t, uint64(2), byID["w2"].Pending)
+	assert.InDelta(t, 4.0/3.0, workers.PendingSkew, 0.001)
+}
+
+func TestSharedGroupAcknowledgeNeverExceedsDeliveries(t *testing.T) {
+	g := NewSharedSubscriptionGroup("g")
+	g.AddSubscriber(SubscriberInfo{ClientID: "c1"})
+
+	_, ok := g.NextSubscriber()
+	require.True(t, ok)
+	assert.True(t, g.Acknowledge("c1"))
+	assert.True(t, g.Acknowledge("c1"))
+
+	stats := g.Stats()
+	require.Len(t, stats.Members, 1)
+	assert.Equal(t, uint64(1), stats.Members[0].Acknowledged)
+	assert.Zero(t, stats.Pending)
+	assert.Zero(t, stats.PendingSkew)
+}
+
+func TestSharedGroupStatsFollowMembership(t *testing.T) {
+	g := NewSharedSubscriptionGroup("g")
+	g.AddSubscriber(SubscriberInfo{ClientID: "c1"})
+	g.AddSubscriber(SubscriberInfo{ClientID: "c2"})
+	g.NextSubscriber()
+	g.NextSubscriber()
+	g.NextSubscriber()
+
+	require.True(t, g.RemoveSubscriber("c1"))
+	stats := g.Stats()
+	require.Len(t, stats.Members, 1)
+	assert.Equal(t, "c2", stats.Members[0].ClientID)
+	assert.Equal(t, uint64(1), stats.Members[0].Delivered)
+}
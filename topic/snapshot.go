@@ -0,0 +1,353 @@
+package topic
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Router snapshots start with this magic and a format version byte
+const (
+	snapshotMagic   = "AXRS"
+	snapshotVersion = 1
+)
+
+// Subscriber option bits in a snapshot, laid out like the SUBSCRIBE options byte
+const (
+	snapshotQoSMask           = 0x03
+	snapshotNoLocal           = 0x04
+	snapshotRetainAsPublished = 0x08
+	snapshotRetainShift       = 4
+	snapshotLastValue         = 0x40 // reserved in SUBSCRIBE options, free for broker options
+)
+
+// Snapshot serializes every subscription of the router, shared groups and lease TTLs included
+//
+// The trie is written depth first so Restore can rebuild it without parsing or validating filters:
+// a client ID table, then per node its subscribers, shared groups and children, with
+// lengths and numbers as uvarints and subscribers referring to clients by table index
+func (r *Router) Snapshot() []byte {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	r.trie.mu.RLock()
+	defer r.trie.mu.RUnlock()
+
+	clientIDs := make([]string, 0, len(r.trie.clients))
+	for clientID := range r.trie.clients {
+		clientIDs = append(clientIDs, clientID)
+	}
+	sort.Strings(clientIDs)
+
+	w := &snapshotWriter{
+		router:  r,
+		clients: make(map[string]uint64, len(clientIDs)),
+		buf:     append(make([]byte, 0, 64+32*len(clientIDs)), snapshotMagic...),
+	}
+	w.buf = append(w.buf, snapshotVersion)
+	w.uvarint(uint64(len(clientIDs)))
+	for i, clientID := range clientIDs {
+		w.clients[clientID] = uint64(i)
+		w.string(clientID)
+	}
+
+	w.node(r.trie.root, nil)
+	return w.buf
+}
+
+type snapshotWriter struct {
+	router  *Router
+	clients map[string]uint64
+	buf     []byte
+}
+
+func (w *snapshotWriter) uvarint(v uint64) {
+	w.buf = binary.AppendUvarint(w.buf, v)
+}
+
+func (w *snapshotWriter) string(s string) {
+	w.uvarint(uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *snapshotWriter) node(node *trieNode, levels []string) {
+	node.mu.RLock()
+	defer node.mu.RUnlock()
+
+	filter := strings.Join(levels, "/")
+	w.uvarint(uint64(len(node.subscribers)))
+	for _, sub := range node.subscribers {
+		w.subscriber(sub, filter)
+	}
+
+	groups := make([]string, 0, len(node.sharedGroups))
+	for name := range node.sharedGroups {
+		groups = append(groups, name)
+	}
+	sort.Strings(groups)
+	w.uvarint(uint64(len(groups)))
+	for _, name := range groups {
+		subs := node.sharedGroups[name].GetSubscribers()
+		w.string(name)
+		w.uvarint(uint64(len(subs)))
+		for _, sub := range subs {
+			w.subscriber(sub, "$share/"+name+"/"+filter)
+		}
+	}
+
+	children := make([]string, 0, len(node.children))
+	for level := range node.children {
+		children = append(children, level)
+	}
+	sort.Strings(children)
+	w.uvarint(uint64(len(children)))
+	for _, level := range children {
+		w.string(level)
+		w.node(node.children[level], append(levels, level))
+	}
+}
+
+func (w *snapshotWriter) subscriber(sub SubscriberInfo, filter string) {
+	flags := sub.QoS&snapshotQoSMask | sub.RetainHandling<<snapshotRetainShift
+	if sub.NoLocal {
+		flags |= snapshotNoLocal
+	}
+	if sub.RetainAsPublished {
+		flags |= snapshotRetainAsPublished
+	}
+	if sub.LastValue {
+		flags |= snapshotLastValue
+	}
+
+	var ttl time.Duration
+	if s, ok := w.router.subscriptions[sub.ClientID][filter]; ok {
+		ttl = s.TTL
+	}
+
+	w.uvarint(w.clients[sub.ClientID])
+	w.buf = append(w.buf, flags)
+	w.uvarint(uint64(sub.SubscriptionIdentifier))
+	w.uvarint(uint64(ttl / time.Millisecond))
+}
+
+// Restore replaces every subscription of the router with those of a snapshot taken by Snapshot
+// Leases restart from the time of the restore
+func (r *Router) Restore(data []byte) error {
+	if len(data) < len(snapshotMagic)+1 || string(data[:len(snapshotMagic)]) != snapshotMagic {
+		return ErrInvalidSnapshot
+	}
+	if version := data[len(snapshotMagic)]; version != snapshotVersion {
+		return fmt.Errorf("%w: version %d", ErrInvalidSnapshot, version)
+	}
+
+	rd := &snapshotReader{
+		data:          data[len(snapshotMagic)+1:],
+		now:           time.Now(),
+		refs:          make(map[string]map[nodeRef]struct{}),
+		subscriptions: make(map[string]map[string]*Subscription),
+		leases:        make(map[string]map[string]time.Time),
+	}
+
+	count, err := rd.count()
+	if err != nil {
+		return err
+	}
+	rd.clients = make([]string, count)
+	for i := range rd.clients {
+		if rd.clients[i], err = rd.string(); err != nil {
+			return err
+		}
+	}
+
+	root := newTrieNode()
+	if err := rd.node(root, nil); err != nil {
+		return err
+	}
+	if len(rd.data) != 0 {
+		return fmt.Errorf("%w: %d trailing bytes", ErrInvalidSnapshot, len(rd.data))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.trie.mu.Lock()
+	defer r.trie.mu.Unlock()
+
+	r.trie.root = root
+	r.trie.clients = rd.refs
+	r.subscriptions = rd.subscriptions
+	r.leases = rd.leases
+	return nil
+}
+
+type snapshotReader struct {
+	data          []byte
+	now           time.Time
+	clients       []string
+	refs          map[string]map[nodeRef]struct{}
+	subscriptions map[string]map[string]*Subscription
+	leases        map[string]map[string]time.Time
+}
+
+func (rd *snapshotReader) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(rd.data)
+	if n <= 0 {
+		return 0, ErrInvalidSnapshot
+	}
+	rd.data = rd.data[n:]
+	return v, nil
+}
+
+// count reads an element count, bounded by the remaining bytes since every element takes at least one
+func (rd *snapshotReader) count() (int, error) {
+	v, err := rd.uvarint()
+	if err != nil {
+		return 0, err
+	}
+	if v > uint64(len(rd.data)) {
+		return 0, ErrInvalidSnapshot
+	}
+	return int(v), nil
+}
+
+func (rd *snapshotReader) string() (string, error) {
+	n, err := rd.count()
+	if err != nil {
+		return "", err
+	}
+	s := string(rd.data[:n])
+	rd.data = rd.data[n:]
+	return s, nil
+}
+
+func (rd *snapshotReader) node(node *trieNode, levels []string) error {
+	filter := strings.Join(levels, "/")
+
+	count, err := rd.count()
+	if err != nil {
+		return err
+	}
+	node.subscribers = make([]SubscriberInfo, 0, count)
+	for range count {
+		sub, err := rd.subscriber(node, "", filter)
+		if err != nil {
+			return err
+		}
+		node.subscribers = append(node.subscribers, sub)
+	}
+
+	if count, err = rd.count(); err != nil {
+		return err
+	}
+	for range count {
+		name, err := rd.string()
+		if err != nil {
+			return err
+		}
+		members, err := rd.count()
+		if err != nil {
+			return err
+		}
+		group := NewSharedSubscriptionGroup(name)
+		group.subscribers = make([]SubscriberInfo, 0, members)
+		group.members = make([]*sharedMemberStats, 0, members)
+		for range members {
+			sub, err := rd.subscriber(node, name, filter)
+			if err != nil {
+				return err
+			}
+			group.subscribers = append(group.subscribers, sub)
+			group.members = append(group.members, &sharedMemberStats{})
+		}
+		node.sharedGroups[name] = group
+	}
+
+	if count, err = rd.count(); err != nil {
+		return err
+	}
+	for range count {
+		level, err := rd.string()
+		if err != nil {
+			return err
+		}
+		child := newTrieNode()
+		child.parent = node
+		child.level = level
+		node.children[level] = child
+		switch level {
+		case "+":
+			node.hasSingleLevel = true
+		case "#":
+			node.hasMultiLevel = true
+		}
+		if err := rd.node(child, append(levels, level)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subscriber reads a subscriber of node and records it in the reverse index and router metadata
+func (rd *snapshotReader) subscriber(node *trieNode, group, filter string) (SubscriberInfo, error) {
+	index, err := rd.uvarint()
+	if err != nil {
+		return SubscriberInfo{}, err
+	}
+	if index >= uint64(len(rd.clients)) || len(rd.data) == 0 {
+		return SubscriberInfo{}, ErrInvalidSnapshot
+	}
+	flags := rd.data[0]
+	rd.data = rd.data[1:]
+	identifier, err := rd.uvarint()
+	if err != nil {
+		return SubscriberInfo{}, err
+	}
+	ttl, err := rd.uvarint()
+	if err != nil {
+		return SubscriberInfo{}, err
+	}
+
+	key := filter
+	if group != "" {
+		key = "$share/" + group + "/" + filter
+	}
+	sub := SubscriberInfo{
+		ClientID:               rd.clients[index],
+		TopicFilter:            key,
+		QoS:                    flags & snapshotQoSMask,
+		NoLocal:                flags&snapshotNoLocal != 0,
+		RetainAsPublished:      flags&snapshotRetainAsPublished != 0,
+		RetainHandling:         flags >> snapshotRetainShift & 0x03,
+		SubscriptionIdentifier: uint32(identifier),
+		LastValue:              flags&snapshotLastValue != 0,
+	}
+
+	ref := nodeRef{node: node, group: group}
+	if rd.refs[sub.ClientID] == nil {
+		rd.refs[sub.ClientID] = make(map[nodeRef]struct{})
+	}
+	rd.refs[sub.ClientID][ref] = struct{}{}
+
+	if rd.subscriptions[sub.ClientID] == nil {
+		rd.subscriptions[sub.ClientID] = make(map[string]*Subscription)
+	}
+	rd.subscriptions[sub.ClientID][key] = &Subscription{
+		ClientID:               sub.ClientID,
+		TopicFilter:            key,
+		QoS:                    sub.QoS,
+		NoLocal:                sub.NoLocal,
+		RetainAsPublished:      sub.RetainAsPublished,
+		RetainHandling:         sub.RetainHandling,
+		SubscriptionIdentifier: sub.SubscriptionIdentifier,
+		SharedGroup:            group,
+		TTL:                    time.Duration(ttl) * time.Millisecond,
+		LastValue:              sub.LastValue,
+	}
+	if ttl > 0 {
+		if rd.leases[sub.ClientID] == nil {
+			rd.leases[sub.ClientID] = make(map[string]time.Time)
+		}
+		rd.leases[sub.ClientID][key] = rd.now.Add(time.Duration(ttl) * time.Millisecond)
+	}
+	return sub, nil
+}
@@ -0,0 +1,118 @@
+package topic
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sortedMatch(r *Router, topic string) []SubscriberInfo {
+	subs := r.Match(topic)
+	sort.Slice(subs, func(i, j int) bool {
+		if subs[i].ClientID != subs[j].ClientID {
+			return subs[i].ClientID < subs[j].ClientID
+		}
+		return subs[i].SubscriptionIdentifier < subs[j].SubscriptionIdentifier
+	})
+	return subs
+}
+
+func TestRouterSnapshotRestore(t *testing.T) {
+	source := NewRouter()
+	subs := []*Subscription{
+		{ClientID: "c1", TopicFilter: "home/+/temp", QoS: 1, SubscriptionIdentifier: 7},
+		{ClientID: "c1", TopicFilter: "alerts/#", QoS: 2, NoLocal: true, RetainAsPublished: true, RetainHandling: 2},
+		{ClientID: "c2", TopicFilter: "home/kitchen/temp", TTL: time.Minute, LastValue: true},
+		{ClientID: "c2", TopicFilter: "#"},
+		{ClientID: "c3", TopicFilter: "/leading/slash"},
+		{ClientID: "w1", TopicFilter: "$share/workers/jobs/+", QoS: 1},
+		{ClientID: "w2", TopicFilter: "$share/workers/jobs/+", QoS: 1},
+	}
+	for _, sub := range subs {
+		require.NoError(t, source.Subscribe(sub))
+	}
+
+	data := source.Snapshot()
+
+	restored := NewRouter()
+	require.NoError(t, restored.Subscribe(&Subscription{ClientID: "stale", TopicFilter: "old/topic"}))
+	require.NoError(t, restored.Restore(data))
+
+	assert.Equal(t, source.Count(), restored.Count())
+	assert.Equal(t, source.CountClients(), restored.CountClients())
+	for _, topic := range []string{"home/kitchen/temp", "alerts/fire", "/leading/slash", "old/topic", "jobs/1"} {
+		assert.Equal(t, sortedMatch(source, topic), sortedMatch(restored, topic), topic)
+	}
+	assert.Equal(t, 2, restored.CountFilter("$share/workers/jobs/+"))
+
+	sub, ok := restored.GetSubscription("c1", "alerts/#")
+	require.True(t, ok)
+	assert.True(t, sub.NoLocal)
+	assert.True(t, sub.RetainAsPublished)
+	assert.Equal(t, byte(2), sub.RetainHandling)
+
+	sub, ok = restored.GetSubscription("w1", "$share/workers/jobs/+")
+	require.True(t, ok)
+	assert.Equal(t, "workers", sub.SharedGroup)
+
+	sub, ok = restored.GetSubscription("c2", "home/kitchen/temp")
+	require.True(t, ok)
+	assert.True(t, sub.LastValue)
+
+	deadline, ok := restored.LeaseDeadline("c2", "home/kitchen/temp")
+	require.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, time.Second)
+
+	// The reverse index is rebuilt, so per-client removal works on the restored trie
+	assert.Equal(t, 2, restored.UnsubscribeAll("c1"))
+	assert.True(t, restored.Unsubscribe("w1", "$share/workers/jobs/+"))
+	assert.Equal(t, 1, restored.CountFilter("$share/workers/jobs/+"))
+
+	assert.Equal(t, data, source.Snapshot(), "snapshots of the same state are identical")
+}
+
+func TestRouterRestore_Invalid(t *testing.T) {
+	source := NewRouter()
+	require.NoError(t, source.Subscribe(&Subscription{ClientID: "c1", TopicFilter: "a/b"}))
+	data := source.Snapshot()
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: nil},
+		{name: "wrong magic", data: []byte("XXXX\x01")},
+		{name: "wrong version", data: append([]byte(snapshotMagic), 9)},
+		{name: "truncated", data: data[:len(data)-3]},
+		{name: "trailing bytes", data: append(append([]byte(nil), data...), 0)},
+		{name: "huge count", data: append([]byte(snapshotMagic), snapshotVersion, 0xFF, 0xFF, 0x03)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := NewRouter()
+			require.NoError(t, router.Subscribe(&Subscription{ClientID: "keep", TopicFilter: "x"}))
+			assert.ErrorIs(t, router.Restore(tt.data), ErrInvalidSnapshot)
+			assert.Equal(t, 1, router.Count(), "a failed restore leaves the router untouched")
+		})
+	}
+}
+
+func BenchmarkRouterRestore(b *testing.B) {
+	source := NewRouter()
+	for i := range 100000 {
+		_ = source.Subscribe(&Subscription{ClientID: fmt.Sprintf("client-%d", i), TopicFilter: fmt.Sprintf("site/%d/device/%d/+", i%100, i)})
+	}
+	data := source.Snapshot()
+
+	b.ResetTimer()
+	for range b.N {
+		if err := NewRouter().Restore(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package topic
 import (
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // Subscription represents an active subscription with all MQTT 5.0 features
@@ -14,17 +15,21 @@ type Subscription struct {
 	RetainAsPublished      bool
 	RetainHandling         byte
 	SubscriptionIdentifier uint32
-	SharedGroup            string // For shared subscriptions ($share/groupname/topic)
+	SharedGroup            string        // For shared subscriptions ($share/groupname/topic)
+	TTL                    time.Duration // Lease after which an inactive subscription is removed (0 = no lease)
+	LastValue              bool          // Queue only the newest message per topic for a slow subscriber
 }
 
 // SubscriberInfo contains subscriber metadata for routing
 type SubscriberInfo struct {
 	ClientID               string
+	TopicFilter            string // filter as subscribed, including the $share prefix of shared subscriptions
 	QoS                    byte
 	NoLocal                bool
 	RetainAsPublished      bool
 	RetainHandling         byte
 	SubscriptionIdentifier uint32
+	LastValue              bool
 }
 
 // TopicAlias manages topic alias mapping for MQTT 5.0
@@ -65,8 +70,9 @@ func (ta *TopicAlias) Clear() {
 type SharedSubscriptionGroup struct {
 	groupName   string
 	subscribers []SubscriberInfo
-	counter     atomic.Uint64 // Round-robin counter
-	mu          sync.RWMutex  // Protects subscribers slice
+	members     []*sharedMemberStats // Delivery counters, parallel to subscribers
+	counter     atomic.Uint64        // Round-robin counter
+	mu          sync.RWMutex         // Protects subscribers and members slices
 }
 
 // NewSharedSubscriptionGroup creates a new shared subscription group
@@ -82,6 +88,7 @@ func (g *SharedSubscriptionGroup) AddSubscriber(sub SubscriberInfo) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 	g.subscribers = append(g.subscribers, sub)
+	g.members = append(g.members, &sharedMemberStats{})
 }
 
 // RemoveSubscriber removes a subscriber from the group
@@ -91,6 +98,7 @@ func (g *SharedSubscriptionGroup) RemoveSubscriber(clientID string) bool {
 	for i, sub := range g.subscribers {
 		if sub.ClientID == clientID {
 			g.subscribers = append(g.subscribers[:i], g.subscribers[i+1:]...)
+			g.members = append(g.members[:i], g.members[i+1:]...)
 			return true
 		}
 	}
@@ -104,8 +112,9 @@ func (g *SharedSubscriptionGroup) NextSubscriber() (SubscriberInfo, bool) {
 	if len(g.subscribers) == 0 {
 		return SubscriberInfo{}, false
 	}
-	idx := g.counter.Add(1) - 1
-	return g.subscribers[idx%uint64(len(g.subscribers))], true
+	idx := (g.counter.Add(1) - 1) % uint64(len(g.subscribers))
+	g.members[idx].record()
+	return g.subscribers[idx], true
 }
 
 // Size returns the number of subscribers in the group
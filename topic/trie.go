@@ -4,6 +4,8 @@ import "sync"
 
 // trieNode represents a node in the topic trie
 type trieNode struct {
+	parent         *trieNode
+	level          string
 	children       map[string]*trieNode
 	subscribers    []SubscriberInfo
 	sharedGroups   map[string]*SharedSubscriptionGroup
@@ -21,16 +23,24 @@ func newTrieNode() *trieNode {
 	}
 }
 
+// nodeRef identifies a subscription slot on a trie node, group is empty for non-shared subscriptions
+type nodeRef struct {
+	node  *trieNode
+	group string
+}
+
 // Trie implements a trie-based topic filter matcher
 type Trie struct {
-	root *trieNode
-	mu   sync.RWMutex
+	root    *trieNode
+	clients map[string]map[nodeRef]struct{} // clientID -> subscribed nodes
+	mu      sync.RWMutex
 }
 
 // NewTrie creates a new topic trie
 func NewTrie() *Trie {
 	return &Trie{
-		root: newTrieNode(),
+		root:    newTrieNode(),
+		clients: make(map[string]map[nodeRef]struct{}),
 	}
 }
 
@@ -49,6 +59,8 @@ func (t *Trie) Subscribe(filter string, sub SubscriberInfo) error {
 	node.subscribers = append(node.subscribers, sub)
 	node.mu.Unlock()
 
+	t.addRef(sub.ClientID, nodeRef{node: node})
+
 	return nil
 }
 
@@ -70,19 +82,24 @@ func (t *Trie) SubscribeShared(groupName, filter string, sub SubscriberInfo) err
 	node.sharedGroups[groupName].AddSubscriber(sub)
 	node.mu.Unlock()
 
+	t.addRef(sub.ClientID, nodeRef{node: node, group: groupName})
+
 	return nil
 }
 
 // navigateToNode traverses the trie to find or create the node for a filter
 // Caller must hold t.mu lock
 func (t *Trie) navigateToNode(filter string) *trieNode {
-	levels := splitTopicLevels(filter)
 	node := t.root
 
-	for _, level := range levels {
+	levels := NewLevels(filter)
+	for level, ok := levels.Next(); ok; level, ok = levels.Next() {
 		node.mu.Lock()
 		if node.children[level] == nil {
-			node.children[level] = newTrieNode()
+			child := newTrieNode()
+			child.parent = node
+			child.level = level
+			node.children[level] = child
 		}
 		nextNode := node.children[level]
 
@@ -117,6 +134,9 @@ func (t *Trie) unsubscribeRecursive(node *trieNode, levels []string, clientID st
 		for i, sub := range node.subscribers {
 			if sub.ClientID == clientID {
 				node.subscribers = append(node.subscribers[:i], node.subscribers[i+1:]...)
+				if !hasSubscriber(node.subscribers, clientID) {
+					t.removeRef(clientID, nodeRef{node: node})
+				}
 				return true
 			}
 		}
@@ -167,6 +187,9 @@ func (t *Trie) unsubscribeSharedRecursive(node *trieNode, levels []string, group
 		if group.Size() == 0 {
 			delete(node.sharedGroups, groupName)
 		}
+		if removed {
+			t.removeRef(clientID, nodeRef{node: node, group: groupName})
+		}
 		return removed
 	}
 
@@ -190,6 +213,237 @@ func (t *Trie) unsubscribeSharedRecursive(node *trieNode, levels []string, group
 	return found
 }
 
+// UnsubscribeClient removes every subscription of a client using the reverse index
+// It only visits the nodes the client is subscribed to and returns the number of removed subscriptions
+func (t *Trie) UnsubscribeClient(clientID string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	refs := t.clients[clientID]
+	delete(t.clients, clientID)
+
+	count := 0
+	for ref := range refs {
+		node := ref.node
+		node.mu.Lock()
+		if ref.group == "" {
+			kept := node.subscribers[:0]
+			for _, sub := range node.subscribers {
+				if sub.ClientID == clientID {
+					count++
+					continue
+				}
+				kept = append(kept, sub)
+			}
+			clear(node.subscribers[len(kept):])
+			node.subscribers = kept
+		} else if group, ok := node.sharedGroups[ref.group]; ok {
+			for group.RemoveSubscriber(clientID) {
+				count++
+			}
+			if group.Size() == 0 {
+				delete(node.sharedGroups, ref.group)
+			}
+		}
+		node.mu.Unlock()
+
+		t.pruneUp(node)
+	}
+
+	return count
+}
+
+// CountFilter returns the number of subscriptions registered on exactly the given filter
+func (t *Trie) CountFilter(filter string) int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	node := t.findNode(filter)
+	if node == nil {
+		return 0
+	}
+
+	node.mu.RLock()
+	defer node.mu.RUnlock()
+	count := len(node.subscribers)
+	for _, group := range node.sharedGroups {
+		count += group.Size()
+	}
+	return count
+}
+
+// CountSharedFilter returns the number of members of a shared subscription group on the given filter
+func (t *Trie) CountSharedFilter(groupName, filter string) int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	node := t.findNode(filter)
+	if node == nil {
+		return 0
+	}
+
+	node.mu.RLock()
+	defer node.mu.RUnlock()
+	if group, ok := node.sharedGroups[groupName]; ok {
+		return group.Size()
+	}
+	return 0
+}
+
+// CountMatching returns the number of subscribers a message published to the topic would be delivered to
+// Each shared subscription group counts once; unlike Match it neither allocates nor advances shared group rotation
+func (t *Trie) CountMatching(topic string) int {
+	if err := ValidateTopic(topic); err != nil {
+		return 0
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.countMatchingRecursive(t.root, NewLevels(topic))
+}
+
+// countMatchingRecursive mirrors matchRecursive but only counts subscribers
+func (t *Trie) countMatchingRecursive(node *trieNode, levels Levels) int {
+	node.mu.RLock()
+	defer node.mu.RUnlock()
+
+	count := 0
+	if multiNode := node.children["#"]; multiNode != nil {
+		count += countNode(multiNode)
+	}
+
+	level, ok := levels.Next()
+	if !ok {
+		return count + countNodeLocked(node)
+	}
+
+	if exactNode := node.children[level]; exactNode != nil {
+		count += t.countMatchingRecursive(exactNode, levels)
+	}
+	if plusNode := node.children["+"]; plusNode != nil {
+		count += t.countMatchingRecursive(plusNode, levels)
+	}
+	return count
+}
+
+// HasMatch reports whether a message published to the topic would reach any subscriber
+// It stops at the first match and at the first level without a matching child, so a topic outside every
+// subscribed prefix is rejected after a lookup or two at the root and nothing is allocated
+func (t *Trie) HasMatch(topic string) bool {
+	if err := ValidateTopic(topic); err != nil {
+		return false
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.hasMatchRecursive(t.root, NewLevels(topic))
+}
+
+// hasMatchRecursive mirrors matchRecursive but returns on the first deliverable subscriber
+func (t *Trie) hasMatchRecursive(node *trieNode, levels Levels) bool {
+	node.mu.RLock()
+	defer node.mu.RUnlock()
+
+	if multiNode := node.children["#"]; multiNode != nil && countNode(multiNode) > 0 {
+		return true
+	}
+
+	level, ok := levels.Next()
+	if !ok {
+		return countNodeLocked(node) > 0
+	}
+
+	if exactNode := node.children[level]; exactNode != nil && t.hasMatchRecursive(exactNode, levels) {
+		return true
+	}
+	if plusNode := node.children["+"]; plusNode != nil && t.hasMatchRecursive(plusNode, levels) {
+		return true
+	}
+	return false
+}
+
+// countNode counts the deliverable subscribers of a node
+func countNode(node *trieNode) int {
+	node.mu.RLock()
+	defer node.mu.RUnlock()
+	return countNodeLocked(node)
+}
+
+// countNodeLocked counts the deliverable subscribers of a node, caller must hold node.mu
+func countNodeLocked(node *trieNode) int {
+	count := len(node.subscribers)
+	for _, group := range node.sharedGroups {
+		if group.Size() > 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// findNode returns the node for a filter without creating it, or nil if it does not exist
+// Caller must hold t.mu lock
+func (t *Trie) findNode(filter string) *trieNode {
+	node := t.root
+	levels := NewLevels(filter)
+	for level, ok := levels.Next(); ok; level, ok = levels.Next() {
+		node.mu.RLock()
+		child := node.children[level]
+		node.mu.RUnlock()
+		if child == nil {
+			return nil
+		}
+		node = child
+	}
+	return node
+}
+
+// addRef records that a client has a subscription on a node
+// Caller must hold t.mu lock
+func (t *Trie) addRef(clientID string, ref nodeRef) {
+	refs := t.clients[clientID]
+	if refs == nil {
+		refs = make(map[nodeRef]struct{})
+		t.clients[clientID] = refs
+	}
+	refs[ref] = struct{}{}
+}
+
+// removeRef forgets a client subscription on a node
+// Caller must hold t.mu lock
+func (t *Trie) removeRef(clientID string, ref nodeRef) {
+	refs := t.clients[clientID]
+	delete(refs, ref)
+	if len(refs) == 0 {
+		delete(t.clients, clientID)
+	}
+}
+
+// pruneUp removes empty nodes from the given node up towards the root
+// Caller must hold t.mu lock
+func (t *Trie) pruneUp(node *trieNode) {
+	for node != t.root && node.parent != nil && t.shouldPruneNode(node) {
+		parent := node.parent
+		parent.mu.Lock()
+		if parent.children[node.level] == node {
+			delete(parent.children, node.level)
+		}
+		parent.mu.Unlock()
+		node = parent
+	}
+}
+
+// hasSubscriber reports whether a client appears in a subscriber list
+func hasSubscriber(subscribers []SubscriberInfo, clientID string) bool {
+	for _, sub := range subscribers {
+		if sub.ClientID == clientID {
+			return true
+		}
+	}
+	return false
+}
+
 // Match finds all subscribers matching a topic
 func (t *Trie) Match(topic string) []SubscriberInfo {
 	if err := ValidateTopic(topic); err != nil {
@@ -199,14 +453,14 @@ func (t *Trie) Match(topic string) []SubscriberInfo {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
 
-	levels := splitTopicLevels(topic)
 	subscribers := make([]SubscriberInfo, 0, 16)
-	t.matchRecursive(t.root, levels, 0, &subscribers)
+	t.matchRecursive(t.root, NewLevels(topic), &subscribers)
 	return subscribers
 }
 
 // matchRecursive recursively matches subscribers
-func (t *Trie) matchRecursive(node *trieNode, levels []string, depth int, subscribers *[]SubscriberInfo) {
+// levels is passed by value so each branch continues from the same position
+func (t *Trie) matchRecursive(node *trieNode, levels Levels, subscribers *[]SubscriberInfo) {
 	node.mu.RLock()
 	defer node.mu.RUnlock()
 
@@ -223,7 +477,8 @@ func (t *Trie) matchRecursive(node *trieNode, levels []string, depth int, subscr
 	}
 
 	// If we've consumed all levels, add subscribers at this node
-	if depth == len(levels) {
+	level, ok := levels.Next()
+	if !ok {
 		*subscribers = append(*subscribers, node.subscribers...)
 		for _, group := range node.sharedGroups {
 			if sub, ok := group.NextSubscriber(); ok {
@@ -233,16 +488,14 @@ func (t *Trie) matchRecursive(node *trieNode, levels []string, depth int, subscr
 		return
 	}
 
-	level := levels[depth]
-
 	// Match exact level
 	if exactNode := node.children[level]; exactNode != nil {
-		t.matchRecursive(exactNode, levels, depth+1, subscribers)
+		t.matchRecursive(exactNode, levels, subscribers)
 	}
 
 	// Match single-level wildcard '+'
 	if plusNode := node.children["+"]; plusNode != nil {
-		t.matchRecursive(plusNode, levels, depth+1, subscribers)
+		t.matchRecursive(plusNode, levels, subscribers)
 	}
 }
 
@@ -259,6 +512,7 @@ func (t *Trie) Clear() {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	t.root = newTrieNode()
+	t.clients = make(map[string]map[nodeRef]struct{})
 }
 
 // Count returns the total number of subscriptions
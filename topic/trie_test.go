@@ -474,3 +474,111 @@ func BenchmarkTrieMatchShared(b *testing.B) {
 		trie.Match("home/temperature")
 	}
 }
+
+func TestTrieUnsubscribeClient(t *testing.T) {
+	trie := NewTrie()
+	require.NoError(t, trie.Subscribe("a/b/c", SubscriberInfo{ClientID: "c1"}))
+	require.NoError(t, trie.Subscribe("a/+", SubscriberInfo{ClientID: "c1"}))
+	require.NoError(t, trie.Subscribe("a/b/c", SubscriberInfo{ClientID: "c2"}))
+	require.NoError(t, trie.SubscribeShared("g", "x/#", SubscriberInfo{ClientID: "c1"}))
+
+	assert.Equal(t, 3, trie.UnsubscribeClient("c1"))
+	assert.Equal(t, 0, trie.UnsubscribeClient("c1"))
+	assert.Equal(t, 1, trie.Count())
+
+	subs := trie.Match("a/b/c")
+	require.Len(t, subs, 1)
+	assert.Equal(t, "c2", subs[0].ClientID)
+
+	// Empty branches are pruned
+	_, ok := trie.root.children["x"]
+	assert.False(t, ok)
+	_, ok = trie.root.children["a"].children["+"]
+	assert.False(t, ok)
+
+	require.NoError(t, trie.Subscribe("a/b/d", SubscriberInfo{ClientID: "c2"}))
+	assert.True(t, trie.Unsubscribe("a/b/c", "c2"))
+	assert.Equal(t, 1, trie.UnsubscribeClient("c2"))
+	assert.Empty(t, trie.root.children)
+	assert.Empty(t, trie.clients)
+}
+
+func TestTrieCountFilter(t *testing.T) {
+	trie := NewTrie()
+	require.NoError(t, trie.Subscribe("a/b", SubscriberInfo{ClientID: "c1"}))
+	require.NoError(t, trie.Subscribe("a/b", SubscriberInfo{ClientID: "c2"}))
+	require.NoError(t, trie.SubscribeShared("g", "a/b", SubscriberInfo{ClientID: "c3"}))
+	require.NoError(t, trie.SubscribeShared("g", "a/b", SubscriberInfo{ClientID: "c4"}))
+	require.NoError(t, trie.Subscribe("a/+", SubscriberInfo{ClientID: "c5"}))
+
+	assert.Equal(t, 4, trie.CountFilter("a/b"))
+	assert.Equal(t, 1, trie.CountFilter("a/+"))
+	assert.Equal(t, 0, trie.CountFilter("a"))
+	assert.Equal(t, 0, trie.CountFilter("missing/filter"))
+	assert.Equal(t, 2, trie.CountSharedFilter("g", "a/b"))
+	assert.Equal(t, 0, trie.CountSharedFilter("other", "a/b"))
+}
+
+func TestTrieCountMatching(t *testing.T) {
+	trie := NewTrie()
+	require.NoError(t, trie.Subscribe("a/b", SubscriberInfo{ClientID: "c1"}))
+	require.NoError(t, trie.Subscribe("a/+", SubscriberInfo{ClientID: "c2"}))
+	require.NoError(t, trie.Subscribe("#", SubscriberInfo{ClientID: "c3"}))
+	require.NoError(t, trie.SubscribeShared("g", "a/#", SubscriberInfo{ClientID: "c4"}))
+	require.NoError(t, trie.SubscribeShared("g", "a/#", SubscriberInfo{ClientID: "c5"}))
+
+	tests := []struct {
+		topic string
+		want  int
+	}{
+		{topic: "a/b", want: 4},
+		{topic: "a/c", want: 3},
+		{topic: "a", want: 2},
+		{topic: "z", want: 1},
+		{topic: "a/+", want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.topic, func(t *testing.T) {
+			assert.Equal(t, tt.want, trie.CountMatching(tt.topic))
+			if tt.want > 0 {
+				assert.Len(t, trie.Match(tt.topic), tt.want)
+			}
+		})
+	}
+}
+
+func TestTrieHasMatch(t *testing.T) {
+	trie := NewTrie()
+	assert.False(t, trie.HasMatch("a/b"))
+
+	require.NoError(t, trie.Subscribe("a/b/+", SubscriberInfo{ClientID: "c1"}))
+	require.NoError(t, trie.SubscribeShared("g", "x/#", SubscriberInfo{ClientID: "c2"}))
+
+	for _, topic := range []string{"a/b/c", "x", "x/y/z"} {
+		assert.True(t, trie.HasMatch(topic), topic)
+		assert.Positive(t, trie.CountMatching(topic), topic)
+	}
+	for _, topic := range []string{"a/b", "a/b/c/d", "a/c/d", "z", "a/+/c"} {
+		assert.False(t, trie.HasMatch(topic), topic)
+		assert.Zero(t, trie.CountMatching(topic), topic)
+	}
+
+	trie.UnsubscribeClient("c2")
+	assert.False(t, trie.HasMatch("x/y"))
+	require.NoError(t, trie.Subscribe("#", SubscriberInfo{ClientID: "c3"}))
+	assert.True(t, trie.HasMatch("x/y"))
+}
+
+func BenchmarkTrieHasMatchMiss(b *testing.B) {
+	trie := NewTrie()
+	for i := 0; i < 100; i++ {
+		filter := fmt.Sprintf("home/room%d/+", i)
+		trie.Subscribe(filter, SubscriberInfo{ClientID: fmt.Sprintf("client%d", i), QoS: 1})
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		trie.HasMatch("devices/sensor42/telemetry")
+	}
+}
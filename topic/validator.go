@@ -63,8 +63,8 @@ func ValidateTopicFilter(filter string) error {
 	}
 
 	// Validate wildcard usage
-	levels := splitTopicLevels(filter)
-	for _, level := range levels {
+	levels := NewLevels(filter)
+	for level, ok := levels.Next(); ok; level, ok = levels.Next() {
 		if len(level) == 0 {
 			continue // Empty level is valid (e.g., "a//b")
 		}
@@ -74,7 +74,7 @@ func ValidateTopicFilter(filter string) error {
 			if level != "#" {
 				return &ValidationError{"multi-level wildcard '#' must occupy entire level"}
 			}
-			if level != levels[len(levels)-1] {
+			if !levels.Done() {
 				return &ValidationError{"multi-level wildcard '#' must be last level"}
 			}
 		}
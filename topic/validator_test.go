@@ -169,6 +169,11 @@ func TestValidateTopicFilter(t *testing.T) {
 			filter:  "home/#/temperature",
 			wantErr: true,
 		},
+		{
+			name:    "filter with repeated multi-level wildcard",
+			filter:  "home/#/#",
+			wantErr: true,
+		},
 		{
 			name:    "filter with invalid multi-level wildcard with text",
 			filter:  "home/room#",
@@ -56,6 +56,14 @@ func (m *Message) IsExpired() bool {
 	return time.Since(m.CreatedAt) >= time.Duration(m.ExpiryInterval)*time.Second
 }
 
+// Deadline returns when the message expires, ok is false for messages without an expiry interval
+func (m *Message) Deadline() (deadline time.Time, ok bool) {
+	if !m.MessageExpirySet || m.ExpiryInterval == 0 {
+		return time.Time{}, false
+	}
+	return m.CreatedAt.Add(time.Duration(m.ExpiryInterval) * time.Second), true
+}
+
 // RemainingExpiry returns the remaining expiry time in seconds
 func (m *Message) RemainingExpiry() uint32 {
 	if !m.MessageExpirySet || m.ExpiryInterval == 0 {
@@ -317,3 +317,15 @@ func TestMessage_LargePayload(t *testing.T) {
 	assert.Equal(t, len(largePayload), len(cloned.Payload))
 	assert.Equal(t, msg.Payload, cloned.Payload)
 }
+
+func TestMessage_Deadline(t *testing.T) {
+	msg := NewMessage(1, "test/topic", []byte("payload"), encoding.QoS1, false, nil)
+	_, ok := msg.Deadline()
+	assert.False(t, ok)
+
+	msg = NewMessage(1, "test/topic", []byte("payload"), encoding.QoS1, false,
+		map[string]interface{}{"MessageExpiryInterval": uint32(10)})
+	deadline, ok := msg.Deadline()
+	assert.True(t, ok)
+	assert.Equal(t, msg.CreatedAt.Add(10*time.Second), deadline)
+}